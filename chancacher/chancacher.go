@@ -12,7 +12,6 @@
 package chancacher
 
 import (
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +21,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/crewjam/rfc5424"
 	"github.com/gofrs/flock"
 )
 
@@ -40,24 +40,31 @@ const MaxDepth = 1000000
 type ChanCacher struct {
 	In      chan interface{}
 	Out     chan interface{}
-	runDone bool
+	runDone flag
 	maxSize int
 
 	cachePath      string
 	cache          bool
 	cacheR         *fileCounter
 	cacheW         *fileCounter
-	cacheEnc       *gob.Encoder
-	cacheModified  bool
+	cacheEnc       *cacheEncoder
+	cacheModified  flag
 	cacheLock      sync.Mutex
-	cacheReading   bool
+	cacheReading   flag
 	cachePaused    chan bool
 	cacheDone      chan bool
 	cacheAck       chan bool
 	cacheIsDone    bool
-	cacheCommitted bool
+	cacheCommitted flag
 
 	fileLock *flock.Flock
+
+	codec     Codec //codec used when starting a new write file, nil is gob
+	policy    CodecPolicy
+	lgr       Logger
+	sampler   codecSampler
+	statsLock sync.Mutex
+	stats     CodecStats
 }
 
 // Create a new ChanCacher with maximum depth, and optional backing file.  If
@@ -98,6 +105,11 @@ func NewChanCacher(maxDepth int, cachePath string, maxSize int) (*ChanCacher, er
 		cacheDone:   make(chan bool),
 		cacheAck:    make(chan bool),
 		maxSize:     maxSize,
+		policy:      CodecPolicyRecommend,
+		sampler: codecSampler{
+			threshold: DefaultCodecThreshold,
+			samples:   map[string]*codecSample{},
+		},
 	}
 
 	// we start the cache unpaused, and because of go idioms, we have to
@@ -181,16 +193,17 @@ func NewChanCacher(maxDepth int, cachePath string, maxSize int) (*ChanCacher, er
 			return nil, err
 		}
 
-		c.cacheEnc = gob.NewEncoder(c.cacheW)
-
 		// if the write cache data data in it already (recover), then
-		// mark the cache as modified.
+		// mark the cache as modified and keep writing in its format.
 		fi, err = c.cacheW.Stat()
 		if err != nil {
 			return nil, err
 		}
 		if fi.Size() != 0 {
-			c.cacheModified = true
+			c.cacheModified.set(true)
+			if c.codec, err = fileCodec(w, fi.Size()); err != nil {
+				return nil, err
+			}
 		}
 
 		go c.cacheHandler()
@@ -226,12 +239,12 @@ func (c *ChanCacher) run() {
 		}
 	}
 
-	c.runDone = true
+	c.runDone.set(true)
 
 	if c.cache {
 		// closing c.In stops reading input, but we allow the cache to drain
 		// before closing c.Out.
-		for c.CacheHasData() && !c.cacheCommitted {
+		for c.CacheHasData() && !c.cacheCommitted.get() {
 			time.Sleep(100 * time.Millisecond)
 		}
 
@@ -253,28 +266,34 @@ func (c *ChanCacher) cacheHandler() {
 	// the main cache loop. We read from R, putting data into out directly
 	// until R is drained. Once R is drained, wait for W to have data and
 	// for run() to signal that we can swap buffers.
-	c.cacheReading = true
+	c.cacheReading.set(true)
 	for {
 		var err error
 
-		dec := gob.NewDecoder(c.cacheR)
-		var v interface{}
-		for {
-			err = dec.Decode(&v)
-			if err != nil {
-				break
-			}
-			if v == nil {
-				continue
-			}
+		var dec *cacheDecoder
+		if dec, err = newCacheDecoder(c.cacheR); err == nil {
+			for {
+				start := time.Now()
+				v, err := dec.Decode()
+				if err != nil {
+					break
+				}
+				c.statsLock.Lock()
+				c.stats.Decoded++
+				c.stats.DecodeTime += time.Since(start)
+				c.statsLock.Unlock()
+				if v == nil {
+					continue
+				}
 
-			c.Out <- v
+				c.Out <- v
+			}
 		}
 		if err != io.EOF {
 			// TODO: log
 		}
 
-		c.cacheReading = false
+		c.cacheReading.set(false)
 		c.cacheR.Seek(0, 0)
 		c.cacheR.Truncate(0)
 
@@ -288,7 +307,7 @@ func (c *ChanCacher) cacheHandler() {
 		}
 
 		// Wait for W to have data.
-		for !c.cacheModified {
+		for !c.cacheModified.get() {
 			select {
 			case <-c.cacheDone:
 				close(c.cacheAck)
@@ -301,9 +320,9 @@ func (c *ChanCacher) cacheHandler() {
 		c.cacheLock.Lock()
 		c.cacheR, c.cacheW = c.cacheW, c.cacheR
 		c.cacheR.Seek(0, 0)
-		c.cacheEnc = gob.NewEncoder(c.cacheW)
-		c.cacheModified = false
-		c.cacheReading = true
+		c.cacheEnc = nil
+		c.cacheModified.set(false)
+		c.cacheReading.set(true)
 		c.cacheLock.Unlock()
 	}
 }
@@ -318,16 +337,113 @@ func (c *ChanCacher) cacheValue(v interface{}) {
 
 	c.cacheLock.Lock()
 	defer c.cacheLock.Unlock()
-	err := c.cacheEnc.Encode(&v)
+	// new write files pick up the current codec, an existing file has to be
+	// finished in the format it was started with
+	if c.cacheEnc == nil || (c.cacheW.Count() == 0 && c.cacheEnc.codec != c.codec) {
+		var err error
+		if c.cacheEnc, err = newCacheEncoder(c.cacheW, c.codec); err != nil {
+			// TODO: log
+			return
+		}
+	}
+	start := time.Now()
+	before := c.cacheW.Count()
+	err := c.cacheEnc.Encode(v)
 	if err != nil {
 		// TODO: log
 	}
-	c.cacheModified = true
+	c.cacheModified.set(true)
+	n := c.cacheW.Count() - before
+
+	c.statsLock.Lock()
+	c.stats.Codec = c.cacheEnc.codecName()
+	c.stats.Encoded++
+	c.stats.EncodedBytes += uint64(n)
+	c.stats.EncodeTime += time.Since(start)
+	c.statsLock.Unlock()
+
+	if err == nil && c.policy != CodecPolicyGob && c.cacheEnc.codec == nil {
+		if cand, ratio := c.sampler.sample(v, n); cand != nil {
+			c.codecDecision(cand, ratio)
+		}
+	}
+}
+
+// codecDecision records and logs that a codec beat gob, the caller must hold the cache lock
+func (c *ChanCacher) codecDecision(cand Codec, ratio float64) {
+	c.statsLock.Lock()
+	c.stats.Recommended = cand.Name()
+	c.stats.Ratio = ratio
+	c.statsLock.Unlock()
+
+	action := `recommend`
+	if c.policy == CodecPolicyAuto {
+		c.codec = cand
+		action = `switch`
+	}
+	if c.lgr != nil {
+		c.lgr.Info("cache codec is smaller than gob",
+			rfc5424.SDParam{Name: "cache", Value: c.cachePath},
+			rfc5424.SDParam{Name: "codec", Value: cand.Name()},
+			rfc5424.SDParam{Name: "ratio", Value: formatRatio(ratio)},
+			rfc5424.SDParam{Name: "action", Value: action})
+	}
+}
+
+// SetCodecPolicy controls whether the cache samples for a better codec and what it does
+// when one is found.  Setting the policy to gob also returns new cache files to gob.
+func (c *ChanCacher) SetCodecPolicy(p CodecPolicy) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	c.policy = p
+	if p == CodecPolicyGob {
+		c.codec = nil
+	}
+}
+
+// SetCodecThreshold sets how many times larger gob must be than a codec before the
+// codec policy kicks in, values at or below 1 are ignored.
+func (c *ChanCacher) SetCodecThreshold(t float64) {
+	if t <= 1 {
+		return
+	}
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	c.sampler.threshold = t
+}
+
+// SetCodec forces new cache files to use the named registered codec, or gob.
+func (c *ChanCacher) SetCodec(name string) error {
+	var cd Codec
+	if name != GobCodec {
+		var ok bool
+		if cd, ok = getCodec(name); !ok {
+			return fmt.Errorf("%w %q", ErrUnknownCodec, name)
+		}
+	}
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	c.codec = cd
+	return nil
+}
+
+// SetLogger sets the logger used to report codec decisions.
+func (c *ChanCacher) SetLogger(lgr Logger) {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	c.lgr = lgr
+}
+
+// Stats returns encoding statistics for the disk cache.
+func (c *ChanCacher) Stats() CodecStats {
+	c.statsLock.Lock()
+	defer c.statsLock.Unlock()
+	return c.stats
 }
 
 // Return if the cache has outstanding data not written to the output channel.
 func (c *ChanCacher) CacheHasData() bool {
-	return c.cacheModified || c.cacheReading
+	return c.cacheModified.get() || c.cacheReading.get()
 }
 
 // Returns the number of elements on the internal buffer.
@@ -394,7 +510,7 @@ func (c *ChanCacher) Drain() {
 // scenarios.
 func (c *ChanCacher) Commit() {
 	if !c.cache {
-		c.cacheCommitted = true
+		c.cacheCommitted.set(true)
 		return
 	}
 
@@ -402,7 +518,7 @@ func (c *ChanCacher) Commit() {
 
 	// read from out and write back to the cache
 	readerStopped := false
-	for !c.runDone || len(c.Out) != 0 || !readerStopped {
+	for !c.runDone.get() || len(c.Out) != 0 || !readerStopped {
 		select {
 		case <-c.cacheAck:
 			readerStopped = true
@@ -418,7 +534,7 @@ func (c *ChanCacher) Commit() {
 	c.cacheR.Close()
 	c.cacheW.Close()
 
-	c.cacheCommitted = true
+	c.cacheCommitted.set(true)
 }
 
 func (c *ChanCacher) finishCache() {
@@ -434,10 +550,13 @@ func (c *ChanCacher) finishCache() {
 // Returns the number of bytes committed to disk. This does not include data in
 // the in-memory buffer.
 func (c *ChanCacher) Size() int {
+	//the read and write files are swapped under the cache lock
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
 	return c.cacheR.Count() + c.cacheW.Count()
 }
 
-// Merge two gob encoded files into a single file. Paths a and b are specified,
+// Merge two cache files into a single file. Paths a and b are specified,
 // with the resulting file in a.
 func merge(a, b string) error {
 	fa, err := os.Open(a)
@@ -459,42 +578,39 @@ func merge(a, b string) error {
 	defer t.Close()
 	defer os.Remove(t.Name())
 
-	enc := gob.NewEncoder(t)
-
-	adec := gob.NewDecoder(fa)
-	var v interface{}
-	for {
-		err = adec.Decode(&v)
-		if err != nil {
-			if err != io.EOF {
-				return err
-			}
-			break
-		}
-		if v == nil {
-			continue
-		}
-		err = enc.Encode(&v)
-		if err != nil {
-			return err
-		}
+	adec, err := newCacheDecoder(fa)
+	if err != nil {
+		return err
+	}
+	bdec, err := newCacheDecoder(fb)
+	if err != nil {
+		return err
+	}
+	// files recovered with a codec keep it, anything it can't handle falls back to gob
+	cd := adec.codec
+	if cd == nil {
+		cd = bdec.codec
+	}
+	enc, err := newCacheEncoder(t, cd)
+	if err != nil {
+		return err
 	}
 
-	bdec := gob.NewDecoder(fb)
-	for {
-		err = bdec.Decode(&v)
-		if err != nil {
-			if err != io.EOF {
+	for _, dec := range []*cacheDecoder{adec, bdec} {
+		for {
+			v, err := dec.Decode()
+			if err != nil {
+				if err != io.EOF {
+					return err
+				}
+				break
+			}
+			if v == nil {
+				continue
+			}
+			if err = enc.Encode(v); err != nil {
 				return err
 			}
-			break
-		}
-		if v == nil {
-			continue
-		}
-		err = enc.Encode(&v)
-		if err != nil {
-			return err
 		}
 	}
 
@@ -0,0 +1,198 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package chancacher
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	GobCodec = `gob`
+
+	maxCodecName = 255
+
+	//records in a codec stream are tagged with how they were encoded
+	tagGob   byte = 1
+	tagCodec byte = 2
+)
+
+var (
+	ErrUnknownCodec   = errors.New("Unknown cache codec")
+	ErrDuplicateCodec = errors.New("Cache codec is already registered")
+	ErrInvalidCodec   = errors.New("Invalid cache codec")
+	ErrCorruptCache   = errors.New("Corrupt cache record")
+
+	// Plain gob streams never start with a zero byte, so a leading zero marks a
+	// file written with a registered codec
+	codecMagic = []byte{0x00, 'g', 'c', 'c'}
+
+	codecLock sync.RWMutex
+	codecs    = map[string]Codec{}
+)
+
+// Codec is a compact encoding for the values put in a cache.  Codecs only need to handle the
+// types they know about, anything else is written with gob.  Codecs must be registered with
+// RegisterCodec before a cache written with them can be read back.
+type Codec interface {
+	Name() string
+	Supports(v interface{}) bool
+	Encode(w io.Writer, v interface{}) error
+	Decode(r *bufio.Reader) (interface{}, error)
+}
+
+// RegisterCodec makes a codec available to every ChanCacher
+func RegisterCodec(c Codec) error {
+	if c == nil || c.Name() == `` || c.Name() == GobCodec || len(c.Name()) > maxCodecName {
+		return ErrInvalidCodec
+	}
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	if _, ok := codecs[c.Name()]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicateCodec, c.Name())
+	}
+	codecs[c.Name()] = c
+	return nil
+}
+
+func getCodec(name string) (c Codec, ok bool) {
+	codecLock.RLock()
+	c, ok = codecs[name]
+	codecLock.RUnlock()
+	return
+}
+
+// codecsFor returns the registered codecs that can encode v
+func codecsFor(v interface{}) (r []Codec) {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	for _, c := range codecs {
+		if c.Supports(v) {
+			r = append(r, c)
+		}
+	}
+	return
+}
+
+// cacheEncoder writes either a plain gob stream, or if a codec is set a header naming the codec
+// followed by tagged records
+type cacheEncoder struct {
+	w     io.Writer
+	codec Codec
+	gob   *gob.Encoder
+	buf   bytes.Buffer
+}
+
+func newCacheEncoder(w io.Writer, codec Codec) (ce *cacheEncoder, err error) {
+	ce = &cacheEncoder{
+		w:     w,
+		codec: codec,
+		gob:   gob.NewEncoder(w),
+	}
+	if codec != nil {
+		hdr := append(append([]byte{}, codecMagic...), byte(len(codec.Name())))
+		if _, err = w.Write(append(hdr, codec.Name()...)); err != nil {
+			ce = nil
+		}
+	}
+	return
+}
+
+func (ce *cacheEncoder) codecName() string {
+	if ce.codec == nil {
+		return GobCodec
+	}
+	return ce.codec.Name()
+}
+
+func (ce *cacheEncoder) Encode(v interface{}) (err error) {
+	if ce.codec == nil {
+		return ce.gob.Encode(&v)
+	}
+	if ce.codec.Supports(v) {
+		//encode to a buffer first so a failure doesn't leave half a record in the file
+		ce.buf.Reset()
+		ce.buf.WriteByte(tagCodec)
+		if err = ce.codec.Encode(&ce.buf, v); err == nil {
+			_, err = ce.w.Write(ce.buf.Bytes())
+			return
+		}
+	}
+	if _, err = ce.w.Write([]byte{tagGob}); err == nil {
+		err = ce.gob.Encode(&v)
+	}
+	return
+}
+
+type cacheDecoder struct {
+	r     *bufio.Reader
+	codec Codec
+	gob   *gob.Decoder
+}
+
+// newCacheDecoder works out how a cache file was written, files from older versions are plain gob
+func newCacheDecoder(r io.Reader) (cd *cacheDecoder, err error) {
+	cd = &cacheDecoder{
+		r: bufio.NewReader(r),
+	}
+	//bufio.Reader is a ByteReader, so gob reads exactly one message at a time from it
+	cd.gob = gob.NewDecoder(cd.r)
+	if b, lerr := cd.r.Peek(len(codecMagic) + 1); lerr != nil || !bytes.Equal(b[:len(codecMagic)], codecMagic) {
+		return
+	}
+	hdr := make([]byte, len(codecMagic)+1)
+	if _, err = io.ReadFull(cd.r, hdr); err != nil {
+		return
+	}
+	name := make([]byte, hdr[len(codecMagic)])
+	if _, err = io.ReadFull(cd.r, name); err != nil {
+		return
+	}
+	var ok bool
+	if cd.codec, ok = getCodec(string(name)); !ok {
+		err = fmt.Errorf("%w %q", ErrUnknownCodec, name)
+	}
+	return
+}
+
+func (cd *cacheDecoder) Decode() (v interface{}, err error) {
+	if cd.codec == nil {
+		err = cd.gob.Decode(&v)
+		return
+	}
+	var tag byte
+	if tag, err = cd.r.ReadByte(); err != nil {
+		return
+	}
+	switch tag {
+	case tagCodec:
+		if v, err = cd.codec.Decode(cd.r); err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	case tagGob:
+		err = cd.gob.Decode(&v)
+	default:
+		err = ErrCorruptCache
+	}
+	return
+}
+
+// fileCodec returns the codec an existing cache file was written with, nil for plain gob
+func fileCodec(f io.ReaderAt, sz int64) (Codec, error) {
+	cd, err := newCacheDecoder(io.NewSectionReader(f, 0, sz))
+	if err != nil {
+		return nil, err
+	}
+	return cd.codec, nil
+}
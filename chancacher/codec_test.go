@@ -0,0 +1,266 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package chancacher
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/crewjam/rfc5424"
+)
+
+const testerCodecName = `tester`
+
+// testerCodec handles ChanCacheTester values, anything else goes to gob
+type testerCodec struct{}
+
+func (testerCodec) Name() string { return testerCodecName }
+
+func (testerCodec) Supports(v interface{}) bool {
+	_, ok := v.(*ChanCacheTester)
+	return ok
+}
+
+func (testerCodec) Encode(w io.Writer, v interface{}) error {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v.(*ChanCacheTester).V))
+	_, err := w.Write(b)
+	return err
+}
+
+func (testerCodec) Decode(r *bufio.Reader) (interface{}, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return &ChanCacheTester{V: int(binary.LittleEndian.Uint64(b))}, nil
+}
+
+var registerTester sync.Once
+
+func registerTesterCodec(t *testing.T) {
+	gob.Register(&ChanCacheTester{})
+	gob.Register(map[string]int{})
+	registerTester.Do(func() {
+		if err := RegisterCodec(testerCodec{}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+type testLogger struct {
+	sync.Mutex
+	msgs []string
+}
+
+func (tl *testLogger) Info(msg string, sds ...rfc5424.SDParam) error {
+	tl.Lock()
+	defer tl.Unlock()
+	for _, sd := range sds {
+		msg += ` ` + sd.Name + `=` + sd.Value
+	}
+	tl.msgs = append(tl.msgs, msg)
+	return nil
+}
+
+func TestRegisterCodec(t *testing.T) {
+	registerTesterCodec(t)
+	if err := RegisterCodec(testerCodec{}); !errors.Is(err, ErrDuplicateCodec) {
+		t.Fatalf("failed to catch duplicate codec: %v", err)
+	}
+	if err := RegisterCodec(nil); err != ErrInvalidCodec {
+		t.Fatalf("failed to catch nil codec: %v", err)
+	}
+	if _, err := ParseCodecPolicy(`foo`); err == nil {
+		t.Fatal("failed to catch bad policy")
+	}
+	if p, err := ParseCodecPolicy(``); err != nil || p != CodecPolicyRecommend {
+		t.Fatalf("bad default policy %v %v", p, err)
+	}
+}
+
+func writeCacheFile(t *testing.T, pth string, cd Codec, vals ...interface{}) {
+	f, err := os.Create(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	enc, err := newCacheEncoder(f, cd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vals {
+		if err = enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readCacheFile(t *testing.T, pth string) (vals []interface{}) {
+	f, err := os.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dec, err := newCacheDecoder(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		v, err := dec.Decode()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		vals = append(vals, v)
+	}
+}
+
+func TestCodecFile(t *testing.T) {
+	registerTesterCodec(t)
+	dir, err := ioutil.TempDir("", "chancachertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//a codec file with a value the codec can't handle mixed in, and a legacy gob file
+	a := filepath.Join(dir, "cache_a")
+	b := filepath.Join(dir, "cache_b")
+	writeCacheFile(t, a, testerCodec{}, &ChanCacheTester{V: 1}, map[string]int{"x": 2}, &ChanCacheTester{V: 3})
+	writeCacheFile(t, b, nil, &ChanCacheTester{V: 4})
+
+	vals := readCacheFile(t, a)
+	if len(vals) != 3 || vals[0].(*ChanCacheTester).V != 1 || vals[1].(map[string]int)["x"] != 2 || vals[2].(*ChanCacheTester).V != 3 {
+		t.Fatalf("bad values: %v", vals)
+	}
+
+	if err = merge(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if vals = readCacheFile(t, a); len(vals) != 4 || vals[3].(*ChanCacheTester).V != 4 {
+		t.Fatalf("bad merged values: %v", vals)
+	}
+	if cd, err := fileCodec(mustOpen(t, a), 1024); err != nil || cd == nil || cd.Name() != testerCodecName {
+		t.Fatalf("merged file lost its codec: %v %v", cd, err)
+	}
+}
+
+func mustOpen(t *testing.T, pth string) *os.File {
+	f, err := os.Open(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestCodecAuto(t *testing.T) {
+	registerTesterCodec(t)
+	dir, err := ioutil.TempDir("", "chancachertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewChanCacher(2, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lgr := &testLogger{}
+	c.SetLogger(lgr)
+	c.SetCodecPolicy(CodecPolicyAuto)
+
+	//the first batch is sampled with gob, the second is written to a fresh file with the codec
+	const count = 2 * codecSampleInterval * codecMinSamples
+	results := make(map[int]int)
+	for batch := 0; batch < 2; batch++ {
+		for i := batch * count; i < (batch+1)*count; i++ {
+			select {
+			case c.In <- &ChanCacheTester{V: i}:
+			case <-time.After(DEFAULT_TIMEOUT):
+				t.Fatal("channel should not block!")
+			}
+		}
+		for i := 0; i < count; i++ {
+			select {
+			case v := <-c.Out:
+				results[v.(*ChanCacheTester).V]++
+			case <-time.After(5 * DEFAULT_TIMEOUT):
+				t.Fatal("channel should not block!")
+			}
+		}
+	}
+	close(c.In)
+	for range c.Out {
+		t.Fatal("extra value")
+	}
+	for i := 0; i < 2*count; i++ {
+		if results[i] != 1 {
+			t.Fatalf("bad count for %d: %d", i, results[i])
+		}
+	}
+
+	st := c.Stats()
+	if st.Recommended != testerCodecName || st.Ratio < DefaultCodecThreshold {
+		t.Fatalf("bad recommendation: %+v", st)
+	} else if st.Codec != testerCodecName {
+		t.Fatalf("cache did not switch codecs: %+v", st)
+	} else if st.Encoded == 0 || st.Decoded == 0 || st.EncodedBytes == 0 {
+		t.Fatalf("bad stats: %+v", st)
+	}
+	lgr.Lock()
+	defer lgr.Unlock()
+	if len(lgr.msgs) != 1 {
+		t.Fatalf("bad log messages: %v", lgr.msgs)
+	}
+}
+
+func TestCodecRecommend(t *testing.T) {
+	registerTesterCodec(t)
+	dir, err := ioutil.TempDir("", "chancachertest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := NewChanCacher(2, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 2 * codecSampleInterval * codecMinSamples
+	for i := 0; i < count; i++ {
+		select {
+		case c.In <- &ChanCacheTester{V: i}:
+		case <-time.After(DEFAULT_TIMEOUT):
+			t.Fatal("channel should not block!")
+		}
+	}
+	close(c.In)
+	n := 0
+	for range c.Out {
+		n++
+	}
+	if n != count {
+		t.Fatalf("bad count %d", n)
+	}
+	if st := c.Stats(); st.Recommended != testerCodecName || st.Codec != GobCodec {
+		t.Fatalf("recommend policy should not switch codecs: %+v", st)
+	}
+}
@@ -0,0 +1,154 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package chancacher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crewjam/rfc5424"
+)
+
+// CodecPolicy controls what a ChanCacher does when a registered codec would be
+// significantly smaller than gob for the values being cached.
+type CodecPolicy int
+
+const (
+	CodecPolicyGob       CodecPolicy = iota // always use gob, no sampling
+	CodecPolicyRecommend                    // sample and log a recommendation
+	CodecPolicyAuto                         // sample and switch new cache files to the better codec
+
+	DefaultCodecThreshold = 1.5 //gob must be this many times larger than a codec before we act
+
+	codecSampleInterval = 16 //sample every Nth gob encoded value
+	codecMinSamples     = 64 //samples required before deciding
+)
+
+// Logger is the subset of the ingest logger used to report codec decisions
+type Logger interface {
+	Info(string, ...rfc5424.SDParam) error
+}
+
+// ParseCodecPolicy accepts gob, recommend, or auto; an empty string is recommend
+func ParseCodecPolicy(v string) (p CodecPolicy, err error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case `gob`:
+		p = CodecPolicyGob
+	case ``, `recommend`:
+		p = CodecPolicyRecommend
+	case `auto`:
+		p = CodecPolicyAuto
+	default:
+		err = fmt.Errorf("Invalid cache codec policy %q, must be gob, recommend, or auto", v)
+	}
+	return
+}
+
+func (p CodecPolicy) String() string {
+	switch p {
+	case CodecPolicyGob:
+		return `gob`
+	case CodecPolicyRecommend:
+		return `recommend`
+	case CodecPolicyAuto:
+		return `auto`
+	}
+	return `unknown`
+}
+
+// CodecStats describes how values have been encoded to and decoded from the disk cache
+type CodecStats struct {
+	Codec        string //codec used for the current write file
+	Encoded      uint64
+	EncodedBytes uint64
+	EncodeTime   time.Duration
+	Decoded      uint64
+	DecodeTime   time.Duration
+	Recommended  string  //codec recommended by sampling, if any
+	Ratio        float64 //gob size divided by the recommended codec size
+}
+
+// EncodeRate returns the encode throughput in bytes per second
+func (cs CodecStats) EncodeRate() float64 {
+	if cs.EncodeTime <= 0 {
+		return 0
+	}
+	return float64(cs.EncodedBytes) / cs.EncodeTime.Seconds()
+}
+
+type codecSample struct {
+	gobBytes   uint64
+	codecBytes uint64
+	samples    int
+}
+
+// codecSampler compares what gob actually wrote against what each supporting codec would have
+type codecSampler struct {
+	threshold float64
+	count     uint64
+	samples   map[string]*codecSample
+	decided   bool
+}
+
+type countWriter int
+
+func (cw *countWriter) Write(b []byte) (int, error) {
+	*cw += countWriter(len(b))
+	return len(b), nil
+}
+
+// sample is handed each value written with gob along with the number of bytes gob wrote,
+// once enough samples are in it returns the codec that beat gob by the threshold, if any
+func (cs *codecSampler) sample(v interface{}, gobBytes int) (c Codec, ratio float64) {
+	if cs.decided {
+		return
+	}
+	if cs.count++; cs.count%codecSampleInterval != 0 {
+		return
+	}
+	ready := false
+	for _, cand := range codecsFor(v) {
+		var cw countWriter
+		if err := cand.Encode(&cw, v); err != nil || cw == 0 {
+			continue
+		}
+		s, ok := cs.samples[cand.Name()]
+		if !ok {
+			s = &codecSample{}
+			cs.samples[cand.Name()] = s
+		}
+		s.gobBytes += uint64(gobBytes)
+		s.codecBytes += uint64(cw)
+		if s.samples++; s.samples >= codecMinSamples {
+			ready = true
+		}
+	}
+	if !ready {
+		return
+	}
+	cs.decided = true
+	for name, s := range cs.samples {
+		if s.samples < codecMinSamples {
+			continue
+		}
+		r := float64(s.gobBytes) / float64(s.codecBytes)
+		if r >= cs.threshold && r > ratio {
+			if cand, ok := getCodec(name); ok {
+				c, ratio = cand, r
+			}
+		}
+	}
+	return
+}
+
+func formatRatio(r float64) string {
+	return strconv.FormatFloat(r, 'f', 2, 64)
+}
@@ -1,10 +1,13 @@
 package chancacher
 
-import "os"
+import (
+	"os"
+	"sync/atomic"
+)
 
 type fileCounter struct {
 	*os.File
-	count int
+	count int64 // atomic, the size is read while the cache is being written
 }
 
 func NewFileCounter(f *os.File) (*fileCounter, error) {
@@ -14,18 +17,18 @@ func NewFileCounter(f *os.File) (*fileCounter, error) {
 	}
 	return &fileCounter{
 		File:  f,
-		count: int(fi.Size()),
+		count: fi.Size(),
 	}, nil
 }
 
 func (f *fileCounter) Write(b []byte) (n int, err error) {
-	f.count += len(b)
+	atomic.AddInt64(&f.count, int64(len(b)))
 	return f.File.Write(b)
 }
 
 func (f *fileCounter) Read(b []byte) (n int, err error) {
 	n, err = f.File.Read(b)
-	f.count -= n
+	atomic.AddInt64(&f.count, -int64(n))
 	return
 }
 
@@ -33,5 +36,20 @@ func (f *fileCounter) Count() int {
 	if f == nil || f.File == nil {
 		return 0
 	}
-	return f.count
+	return int(atomic.LoadInt64(&f.count))
+}
+
+// flag is a boolean shared between the cache goroutines
+type flag int32
+
+func (f *flag) set(v bool) {
+	var i int32
+	if v {
+		i = 1
+	}
+	atomic.StoreInt32((*int32)(f), i)
+}
+
+func (f *flag) get() bool {
+	return atomic.LoadInt32((*int32)(f)) == 1
 }
@@ -59,6 +59,9 @@ type WatchConfig struct {
 	FileFilter string
 	Hnd        handler
 	Recursive  bool
+	// CatchupRotated ingests compressed rotations of followed files that
+	// were written while the watcher was not running
+	CatchupRotated bool
 }
 
 func NewWatcher(stateFilePath string) (*WatchManager, error) {
@@ -202,7 +205,15 @@ func (wm *WatchManager) addNoLock(c WatchConfig) error {
 		wm.watched[c.BaseDir] = append(wm.watched[c.BaseDir], c)
 	}
 
-	if err := wm.fman.AddFilter(c.ConfigName, c.BaseDir, fltrs, c.Hnd, c.FollowerEngineConfig); err != nil {
+	fltr := filter{
+		FollowerEngineConfig: c.FollowerEngineConfig,
+		bname:                c.ConfigName,
+		loc:                  c.BaseDir,
+		mtchs:                fltrs,
+		lh:                   c.Hnd,
+		catchupRotated:       c.CatchupRotated,
+	}
+	if err := wm.fman.addFilter(fltr); err != nil {
 		return err
 	}
 	// Now add the subdirectories
@@ -271,6 +282,9 @@ func (wm *WatchManager) initExisting() error {
 	if err != nil {
 		return err
 	}
+	if _, err = wm.fman.CatchupRotated(toProcess, nil); err != nil {
+		return err
+	}
 	return wm.fman.LoadFileList(toProcess)
 }
 
@@ -303,6 +317,9 @@ func (wm *WatchManager) Catchup(qc chan os.Signal) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	if quit, err := wm.fman.CatchupRotated(toProcess, qc); err != nil || quit {
+		return quit, err
+	}
 
 	for _, wf := range toProcess {
 		if quit, err := wm.fman.CatchupFile(wf, qc); err != nil || quit {
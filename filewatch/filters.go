@@ -9,12 +9,12 @@
 package filewatch
 
 import (
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
 	"github.com/gravwell/gravwell/v3/ingest/log"
@@ -26,6 +26,8 @@ type filter struct {
 	loc   string //location we are watching
 	mtchs []string
 	lh    handler
+
+	catchupRotated bool //ingest rotations written while we were down
 }
 
 //a unique name that allows multiple IDs pointing at the same file
@@ -39,29 +41,37 @@ type FilterManager struct {
 	filters         []filter
 	followers       map[FileName]*follower
 	states          map[FileName]*int64
+	meta            map[FileName]fileMeta //file identity for states we are not actively following
+	rotatedOffsets  map[FileName]int64    //offsets into files that were replaced while we were down
 	stateFile       string
 	stateFout       *os.File
 	maxFilesWatched int
 	logger          ingest.IngestLogger
+	stateTime       time.Time //when the state file was last written before we started
+	rotatedDone     bool
 }
 
 func NewFilterManager(stateFile string) (*FilterManager, error) {
-	fout, states, err := initStateFile(stateFile)
-	if err != nil {
-		return nil, err
+	var stateTime time.Time
+	if fi, err := os.Stat(stateFile); err == nil && fi.Size() > 0 {
+		stateTime = fi.ModTime()
 	}
-	if err := cleanStates(states); err != nil {
-		fout.Close()
+	fout, states, meta, err := initStateFile(stateFile)
+	if err != nil {
 		return nil, err
 	}
+	rotated := reconcileStates(states, meta)
 
 	return &FilterManager{
-		mtx:       &sync.Mutex{},
-		stateFile: stateFile,
-		stateFout: fout,
-		states:    states,
-		followers: map[FileName]*follower{},
-		logger:    ingest.NoLogger(),
+		mtx:            &sync.Mutex{},
+		stateFile:      stateFile,
+		stateFout:      fout,
+		states:         states,
+		meta:           meta,
+		rotatedOffsets: rotated,
+		followers:      map[FileName]*follower{},
+		logger:         ingest.NoLogger(),
+		stateTime:      stateTime,
 	}, nil
 }
 
@@ -137,7 +147,8 @@ func (fm *FilterManager) Close() (err error) {
 	defer fm.mtx.Unlock()
 
 	//we have to actually close followers
-	for _, v := range fm.followers {
+	for k, v := range fm.followers {
+		fm.meta[k] = v.meta()
 		if lerr := v.Close(); lerr != nil {
 			err = appendErr(err, lerr)
 		}
@@ -197,23 +208,30 @@ func (fm *FilterManager) nolockDumpStates() error {
 	if err := fm.stateFout.Truncate(0); err != nil {
 		return err
 	}
-	if err := gob.NewEncoder(fm.stateFout).Encode(fm.states); err != nil {
+	for k, v := range fm.followers {
+		fm.meta[k] = v.meta()
+	}
+	if err := encodeStates(fm.stateFout, fm.states, fm.meta); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (f *FilterManager) AddFilter(bname, loc string, mtchs []string, lh handler, ecfg FollowerEngineConfig) error {
-	f.mtx.Lock()
-	defer f.mtx.Unlock()
-
-	fltr := filter{
+	return f.addFilter(filter{
 		FollowerEngineConfig: ecfg,
 		bname:                bname,
-		loc:                  filepath.Clean(loc),
+		loc:                  loc,
 		mtchs:                mtchs,
 		lh:                   lh,
-	}
+	})
+}
+
+func (f *FilterManager) addFilter(fltr filter) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fltr.loc = filepath.Clean(fltr.loc)
 	f.filters = append(f.filters, fltr)
 	return nil
 }
@@ -238,6 +256,9 @@ func (f *FilterManager) nolockRemoveFollower(fpath string, purgeState bool) (rem
 			delete(f.followers, stid)
 			if purgeState {
 				delete(f.states, stid)
+				delete(f.meta, stid)
+			} else {
+				f.meta[stid] = fl.meta()
 			}
 			if err = fl.Close(); err != nil {
 				return
@@ -341,13 +362,20 @@ func (f *FilterManager) RenameFollower(fpath string) error {
 				if err := flw.Close(); err != nil {
 					return err
 				}
+				lh, ok, err := v.handlerFor(p)
+				if err != nil {
+					return err
+				} else if !ok {
+					continue
+				}
 				fcfg := FollowerConfig{
 					BaseName:             v.bname,
 					FilePath:             p,
 					State:                st,
 					FilterID:             i,
-					Handler:              v.lh,
+					Handler:              lh,
 					FollowerEngineConfig: v.FollowerEngineConfig,
+					Followed:             v.followed,
 				}
 				if err := f.addFollower(fcfg); err != nil {
 					return err
@@ -445,6 +473,7 @@ func (f *FilterManager) addSeekInfo(bname, fpath string) *int64 {
 	}
 	si := new(int64)
 	f.states[stid] = si
+	delete(f.meta, stid)
 	return si
 }
 
@@ -475,6 +504,12 @@ func (f *FilterManager) launchFollowers(fpath string, deleteState bool) (ok bool
 		if v.loc != fdir || !f.matchFile(v.mtchs, fname) {
 			continue
 		}
+		lh, hok, err := v.handlerFor(fpath)
+		if err != nil {
+			return false, err
+		} else if !hok {
+			continue //the handler does not want this file
+		}
 		si = nil
 		if !deleteState {
 			//see if we have state information for this file
@@ -483,6 +518,10 @@ func (f *FilterManager) launchFollowers(fpath string, deleteState bool) (ok bool
 		//if not add it
 		if si == nil {
 			si = f.addSeekInfo(v.bname, fpath)
+			//a copy of a file we are following picks up where that follower is
+			if offset, ok := f.copyOffset(v.bname, fpath); ok {
+				*si = offset
+			}
 		}
 		fcfg := FollowerConfig{
 			FollowerEngineConfig: v.FollowerEngineConfig,
@@ -490,7 +529,8 @@ func (f *FilterManager) launchFollowers(fpath string, deleteState bool) (ok bool
 			FilePath:             fpath,
 			State:                si,
 			FilterID:             i,
-			Handler:              v.lh,
+			Handler:              lh,
+			Followed:             v.followed,
 		}
 		if err := f.addFollower(fcfg); err != nil {
 			return false, err
@@ -500,6 +540,31 @@ func (f *FilterManager) launchFollowers(fpath string, deleteState bool) (ok bool
 	return
 }
 
+// copyOffset checks if fpath is a copy of a file that a follower in the same directory is on,
+// which is what logrotate copytruncate leaves behind.  If it is, the copy is resumed from where
+// that follower was so that we don't ingest the data twice.
+// Caller MUST HOLD THE LOCK
+func (f *FilterManager) copyOffset(bname, fpath string) (offset int64, ok bool) {
+	print, err := readPrintFromName(fpath)
+	if err != nil || len(print) < printSize {
+		return
+	}
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		return
+	}
+	fdir := filepath.Dir(fpath)
+	for k, v := range f.followers {
+		if k.BaseName != bname || k.FilePath == fpath || filepath.Dir(k.FilePath) != fdir {
+			continue
+		}
+		if offset, ok = v.resumeOffset(print); ok && offset <= fi.Size() {
+			return
+		}
+	}
+	return 0, false
+}
+
 // checkState will grab a watched file and look it up in the state tracker
 // if the file is not known to the state tracker we assume it is new and return that it has work
 // if it IS in the state tracker, we check if it is larger than what we logged or smaller.
@@ -554,6 +619,7 @@ func (f *FilterManager) checkRename(fpath string, id FileId) (isRename bool, err
 			if f.filters[filterId].loc == fdir && f.matchFile(f.filters[filterId].mtchs, fname) {
 				//this is just a rename, update the fpath in the follower
 				delete(f.states, k)
+				delete(f.meta, k)
 				delete(f.followers, k)
 				k.FilePath = fpath
 				v.FilePath = fpath
@@ -570,6 +636,7 @@ func (f *FilterManager) checkRename(fpath string, id FileId) (isRename bool, err
 					return
 				}
 				delete(f.states, k)
+				delete(f.meta, k)
 				delete(f.followers, k)
 			}
 		}
@@ -577,6 +644,17 @@ func (f *FilterManager) checkRename(fpath string, id FileId) (isRename bool, err
 	return
 }
 
+// followed returns whether a file name is picked up by the filter, it is handed to followers
+// so they can check their neighbors without taking the manager lock
+func (fltr filter) followed(fname string) bool {
+	for _, m := range fltr.mtchs {
+		if ok, err := filepath.Match(m, fname); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *FilterManager) matchFile(mtchs []string, fname string) (matched bool) {
 	for _, m := range mtchs {
 		if ok, err := filepath.Match(m, fname); err == nil && ok {
@@ -624,6 +702,12 @@ func (f *FilterManager) CatchupFile(wf watchedFile, qc chan os.Signal) (bool, er
 		if v.loc != fdir || !f.matchFile(v.mtchs, fname) {
 			continue
 		}
+		lh, ok, err := v.handlerFor(wf.pth)
+		if err != nil {
+			return false, err
+		} else if !ok {
+			continue
+		}
 		if si, hasWork, err = f.checkState(wf); err != nil {
 			return false, err
 		} else if !hasWork {
@@ -636,7 +720,7 @@ func (f *FilterManager) CatchupFile(wf watchedFile, qc chan os.Signal) (bool, er
 			FilePath:             wf.pth,
 			State:                si,
 			FilterID:             i,
-			Handler:              v.lh,
+			Handler:              lh,
 		}
 		//this file needs to be caugh up
 		if quit, err := f.catchupFollower(fcfg, qc); err != nil || quit {
@@ -649,7 +733,8 @@ func (f *FilterManager) CatchupFile(wf watchedFile, qc chan os.Signal) (bool, er
 
 //catchup file is a linear operation to get outstanding files up to date
 func (f *FilterManager) catchupFollower(fcfg FollowerConfig, qc chan os.Signal) (bool, error) {
-	if fl, err := NewFollower(fcfg); err != nil {
+	fl, err := NewFollower(fcfg)
+	if err != nil {
 		return false, err
 	} else if quit, err := fl.Sync(qc); err != nil || quit {
 		fl.Close()
@@ -657,6 +742,9 @@ func (f *FilterManager) catchupFollower(fcfg FollowerConfig, qc chan os.Signal)
 	} else if err = fl.Close(); err != nil {
 		return false, err
 	}
+	if _, ok := f.states[fl.FileName]; ok {
+		f.meta[fl.FileName] = fl.meta()
+	}
 	f.logger.Info("file preprocessed at startup", log.KV("path", fcfg.FilePath))
 	return false, nil
 }
@@ -709,8 +797,8 @@ func ReadStateFile(p string) (states map[string]int64, err error) {
 		fin.Close()
 		return
 	} else if fi.Size() > 0 {
-		temp := map[FileName]*int64{}
-		if err = gob.NewDecoder(fin).Decode(&temp); err != nil {
+		var temp map[FileName]*int64
+		if temp, _, err = decodeStates(fin); err != nil {
 			err = fmt.Errorf("Failed to load existing states: %v", err)
 			fin.Close()
 			return
@@ -730,9 +818,10 @@ func ReadStateFile(p string) (states map[string]int64, err error) {
 	return
 }
 
-func initStateFile(p string) (fout *os.File, states map[FileName]*int64, err error) {
+func initStateFile(p string) (fout *os.File, states map[FileName]*int64, meta map[FileName]fileMeta, err error) {
 	var fi os.FileInfo
 	states = map[FileName]*int64{}
+	meta = map[FileName]fileMeta{}
 	//attempt to open state file
 	fi, err = os.Stat(p)
 	if err != nil {
@@ -766,35 +855,10 @@ func initStateFile(p string) (fout *os.File, states map[FileName]*int64, err err
 		return
 	}
 	if fi.Size() > 0 {
-		if err = gob.NewDecoder(fout).Decode(&states); err != nil {
+		if states, meta, err = decodeStates(fout); err != nil {
 			err = fmt.Errorf("Failed to load existing states: %v", err)
 			return
 		}
 	}
 	return
 }
-
-func cleanStates(states map[FileName]*int64) error {
-	for k, v := range states {
-		fi, err := os.Stat(k.FilePath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				//file is gone, delete it
-				delete(states, k)
-			} else {
-				// TODO: decide if we need to specifically check for other errors here
-				//return err
-			}
-		} else {
-			if v == nil {
-				v = new(int64)
-			}
-			//if file shrank, we have to assume this was a truncation, so remove the state
-			if fi.Size() < *v {
-				*v = 0 //reset the size
-			}
-		}
-		//all other cases are just fine, roll
-	}
-	return nil
-}
@@ -9,13 +9,17 @@
 package filewatch
 
 import (
+	"bytes"
 	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/gravwell/gravwell/v3/ingest/charset"
 )
 
 const (
@@ -38,8 +42,10 @@ type FileId struct {
 }
 
 type FollowerEngineConfig struct {
-	Engine     int
-	EngineArgs string
+	Engine       int
+	EngineArgs   string
+	ContinueArgs string          // continuation pattern for the multiline engine
+	Charset      charset.Charset // character encoding of the files
 }
 
 type FollowerConfig struct {
@@ -49,6 +55,7 @@ type FollowerConfig struct {
 	State    *int64
 	FilterID int
 	Handler  handler
+	Followed func(string) bool // reports whether a name in the same directory is followed by this filter
 }
 
 type follower struct {
@@ -65,6 +72,13 @@ type follower struct {
 	wg       *sync.WaitGroup
 	lh       handler
 	lastAct  time.Time
+	ecfg     FollowerEngineConfig
+	followed func(string) bool
+
+	pmtx     *sync.Mutex
+	print    []byte // first bytes of the file, used to spot truncate and rewrite
+	oldPrint []byte // print from before the last truncation
+	truncAt  int64  // offset we were at when the file was last truncated
 }
 
 func NewFollower(cfg FollowerConfig) (*follower, error) {
@@ -81,16 +95,20 @@ func NewFollower(cfg FollowerConfig) (*follower, error) {
 		return nil, err
 	}
 
+	print := readPrint(fin)
+
 	if _, err := fin.Seek(*cfg.State, 0); err != nil {
 		fin.Close()
 		return nil, err
 	}
 	rdrCfg := ReaderConfig{
-		Fin:        fin,
-		MaxLineLen: defaultMaxLine,
-		StartIndex: *cfg.State,
-		Engine:     cfg.Engine,
-		EngineArgs: cfg.EngineArgs,
+		Fin:          fin,
+		MaxLineLen:   defaultMaxLine,
+		StartIndex:   *cfg.State,
+		Engine:       cfg.Engine,
+		EngineArgs:   cfg.EngineArgs,
+		ContinueArgs: cfg.ContinueArgs,
+		Charset:      cfg.Charset,
 	}
 	lnr, err := NewReader(rdrCfg)
 	if err != nil {
@@ -118,7 +136,11 @@ func NewFollower(cfg FollowerConfig) (*follower, error) {
 			FilePath: cfg.FilePath,
 			BaseName: cfg.BaseName,
 		},
-		lastAct: time.Now(),
+		lastAct:  time.Now(),
+		ecfg:     cfg.FollowerEngineConfig,
+		followed: cfg.Followed,
+		pmtx:     &sync.Mutex{},
+		print:    print,
 	}, nil
 }
 
@@ -130,6 +152,126 @@ func (f *follower) FileId() FileId {
 	return f.id
 }
 
+func (f *follower) meta() fileMeta {
+	f.pmtx.Lock()
+	defer f.pmtx.Unlock()
+	return fileMeta{id: f.id, print: f.print}
+}
+
+// resumeOffset reports where a copy of this file starting with print should be picked up.
+// A copy of what we had before a truncation resumes where we were when the file was truncated.
+func (f *follower) resumeOffset(print []byte) (offset int64, ok bool) {
+	f.pmtx.Lock()
+	defer f.pmtx.Unlock()
+	if len(print) < printSize {
+		return // not enough to go on
+	}
+	if bytes.Equal(f.print, print) {
+		return *f.state, true
+	} else if bytes.Equal(f.oldPrint, print) {
+		return f.truncAt, true
+	}
+	return
+}
+
+// checkTruncate looks for a file that shrank or was rewritten underneath us.  The file is
+// truncated if it is smaller than our offset or its first bytes no longer match.
+// A truncated file is read from the start, if logrotate copied the file before truncating it
+// whatever we had not read yet is pulled from the copy.
+func (f *follower) checkTruncate() (truncated bool, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(f.FilePath); err != nil {
+		return
+	}
+	offset := *f.state
+	var cur []byte
+	if cur, err = readPrintFromName(f.FilePath); err != nil {
+		return
+	}
+	f.pmtx.Lock()
+	truncated = fi.Size() < offset || (offset > 0 && !printMatches(f.print, cur))
+	if !truncated {
+		if len(cur) > len(f.print) {
+			f.print = cur
+		}
+		f.pmtx.Unlock()
+		return
+	}
+	old := f.print
+	f.oldPrint, f.truncAt, f.print = old, offset, cur
+	f.pmtx.Unlock()
+
+	if err = f.recoverCopy(old, offset); err != nil {
+		return
+	}
+	*f.state = 0
+	err = f.lnr.SeekFile(0)
+	return
+}
+
+// recoverCopy finishes reading a copytruncate copy of the file from offset.
+// Copies we are already following are left to their own follower.
+func (f *follower) recoverCopy(print []byte, offset int64) error {
+	if len(print) == 0 || offset == 0 {
+		return nil
+	}
+	dir := filepath.Dir(f.FilePath)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var pth string
+	var mod time.Time
+	for _, fi := range fis {
+		p := filepath.Join(dir, fi.Name())
+		if !fi.Mode().IsRegular() || p == f.FilePath || fi.Size() < offset || fi.ModTime().Before(mod) {
+			continue
+		} else if f.followed != nil && f.followed(fi.Name()) {
+			continue
+		}
+		if id, err := getFileIdFromName(p); err != nil || id == f.id {
+			continue
+		}
+		if pr, err := readPrintFromName(p); err != nil || !bytes.HasPrefix(pr, print) {
+			continue
+		}
+		pth, mod = p, fi.ModTime()
+	}
+	if pth == `` {
+		return nil
+	}
+	fin, err := openDeletableFile(pth)
+	if err != nil {
+		return err
+	}
+	lnr, err := NewReader(ReaderConfig{
+		Fin:          fin,
+		MaxLineLen:   defaultMaxLine,
+		StartIndex:   offset,
+		Engine:       f.ecfg.Engine,
+		EngineArgs:   f.ecfg.EngineArgs,
+		ContinueArgs: f.ecfg.ContinueArgs,
+		Charset:      f.ecfg.Charset,
+	})
+	if err != nil {
+		fin.Close()
+		return err
+	}
+	defer lnr.Close()
+	for {
+		ln, ok, _, err := lnr.ReadEntry()
+		if err != nil {
+			return err
+		} else if !ok {
+			break
+		}
+		if err := f.lh.HandleLog(ln, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (f *follower) Sync(qc chan os.Signal) (bool, error) {
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
@@ -243,17 +385,11 @@ func (f *follower) processLines(writeEvent bool) error {
 			return err
 		}
 		if sawEOF && writeEvent {
-			// We got an EOF on the file after a write
-			fi, err := os.Stat(f.FilePath)
-			if err != nil {
+			// We got an EOF on the file after a write, make sure it wasn't truncated
+			if truncated, err := f.checkTruncate(); err != nil {
 				return err
-			}
-			if fi.Size() < *f.state {
-				// the file must have been truncated
-				*f.state = 0
-				if err = f.lnr.SeekFile(0); err != nil {
-					return err
-				}
+			} else if truncated && !ok {
+				continue //start reading the file over
 			}
 		}
 		if !ok {
@@ -20,6 +20,7 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
@@ -41,9 +42,12 @@ type logger interface {
 
 type LogHandler struct {
 	LogHandlerConfig
-	tg *timegrinder.TimeGrinder
-	w  logWriter
-	li *lineIgnorer
+	tg   *timegrinder.TimeGrinder
+	w    logWriter
+	li   *lineIgnorer
+	pm   *pathMeta
+	ch   *processors.Chunker
+	anns []processors.Annotation //path metadata for the file this handler is bound to
 }
 
 type LogHandlerConfig struct {
@@ -62,6 +66,13 @@ type LogHandlerConfig struct {
 	Debugger                debugOut
 	Ctx                     context.Context
 	TimeFormat              config.CustomTimeFormat
+	PathRegex               string                               // named captures are pulled from the path of each followed file
+	PathMetadata            []string                             // captures to attach to entries, all named captures if empty
+	PathMetadataMode        string                               // annotation mode for path captures: auto, json, prefix, or none
+	PathMetadataField       string                               // optional JSON field to nest the path captures under
+	TagResolver             func(string) (entry.EntryTag, error) // resolves tag names templated from path captures
+	TagReplacement          rune                                 // replaces characters that are not allowed in templated tags, '_' if zero
+	EntrySize               processors.ChunkConfig               // truncate, split, or reject oversized entries
 }
 
 type lineIgnorer struct {
@@ -163,12 +174,22 @@ func NewLogHandler(cfg LogHandlerConfig, w logWriter) (*LogHandler, error) {
 	if err != nil {
 		return nil, err
 	}
+	pm, err := newPathMeta(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := cfg.EntrySize.NewChunker()
+	if err != nil {
+		return nil, err
+	}
 
 	return &LogHandler{
 		LogHandlerConfig: cfg,
 		w:                w,
 		tg:               tg,
 		li:               li,
+		pm:               pm,
+		ch:               ch,
 	}, nil
 }
 
@@ -201,10 +222,27 @@ func (lh *LogHandler) HandleLog(b []byte, catchts time.Time) error {
 	if lh.Debugger != nil {
 		lh.Debugger("GOT %s %s\n", ts.Format(time.RFC3339), string(b))
 	}
-	return lh.w.ProcessContext(&entry.Entry{
+	ent := &entry.Entry{
 		SRC:  lh.Src,
 		TS:   entry.FromStandard(ts),
 		Tag:  lh.LogHandlerConfig.Tag,
 		Data: b,
-	}, lh.LogHandlerConfig.Ctx)
+	}
+	if len(lh.anns) > 0 {
+		if _, err = lh.pm.ann.Annotate(ent, lh.anns...); err != nil {
+			return err
+		}
+	}
+	ents, err := lh.ch.Chunk(ent)
+	if err != nil {
+		//a rejected entry must not stall the file
+		lh.Logger.Warn("dropping oversized entry", log.KV("tag", lh.TagName), log.KV("size", len(ent.Data)), log.KVErr(err))
+		return nil
+	}
+	for _, e := range ents {
+		if err = lh.w.ProcessContext(e, lh.LogHandlerConfig.Ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,155 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+)
+
+// JSONReader breaks a file into JSON objects by balancing braces, so pretty printed objects
+// spanning many lines come out as single entries.  Anything between objects that isn't
+// whitespace is handed back a line at a time.  Braces inside strings are ignored, the objects
+// are not otherwise validated.
+type JSONReader struct {
+	baseReader
+	brdr     *bufio.Reader
+	buf      []byte // everything read past idx
+	pos      int    // scan position in buf
+	depth    int
+	inStr    bool
+	esc      bool
+	lastRead time.Time
+	idle     time.Duration
+}
+
+func NewJSONReader(cfg ReaderConfig) (*JSONReader, error) {
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONReader{
+		baseReader: br,
+		brdr:       bufio.NewReader(cfg.Fin),
+		lastRead:   time.Now(),
+		idle:       idleFlushTimeout,
+	}, nil
+}
+
+func (jr *JSONReader) SeekFile(offset int64) error {
+	jr.reset(0)
+	err := jr.baseReader.SeekFile(offset)
+	jr.brdr.Reset(jr.f)
+	return err
+}
+
+func (jr *JSONReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	b := make([]byte, buffBlockSize)
+	for {
+		if ln, ok = jr.scan(); ok {
+			return
+		}
+		n, lerr := jr.brdr.Read(b)
+		if lerr != nil && lerr != io.EOF {
+			err = lerr
+			return
+		}
+		if n > 0 {
+			jr.lastRead = time.Now()
+			jr.buf = append(jr.buf, b[:n]...)
+			continue
+		}
+		if lerr == io.EOF {
+			wasEOF = true
+		}
+		break
+	}
+	//an object that never closes is sent as is once things go quiet
+	if len(bytes.TrimSpace(jr.buf)) > 0 && time.Since(jr.lastRead) > jr.idle {
+		if ln, ok = jr.consume(0, len(jr.buf)); ok {
+			ln = bytes.TrimSpace(ln)
+		}
+	}
+	return
+}
+
+// scan picks up where the last scan left off, returning an entry if one is complete
+func (jr *JSONReader) scan() (ln []byte, ok bool) {
+	for ; jr.pos < len(jr.buf); jr.pos++ {
+		c := jr.buf[jr.pos]
+		if jr.depth == 0 {
+			switch c {
+			case ' ', '\t', '\r', '\n':
+				continue
+			case '{':
+				//drop the whitespace in front of the object
+				jr.idx += int64(jr.pos)
+				jr.buf = jr.buf[jr.pos:]
+				jr.pos, jr.depth = 0, 1
+				continue
+			}
+			//not an object, pass the line along
+			nl := bytes.IndexByte(jr.buf[jr.pos:], '\n')
+			if nl < 0 {
+				return
+			}
+			ln = bytes.TrimSpace(jr.buf[jr.pos : jr.pos+nl])
+			return jr.consume(jr.pos, len(ln))
+		}
+		if jr.maxLine > 0 && jr.pos >= jr.maxLine {
+			//runaway object, send what we have rather than buffering forever
+			return jr.consume(0, jr.pos)
+		}
+		if jr.inStr {
+			if jr.esc {
+				jr.esc = false
+			} else if c == '\\' {
+				jr.esc = true
+			} else if c == '"' {
+				jr.inStr = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			jr.inStr = true
+		case '{', '[':
+			jr.depth++
+		case '}', ']':
+			if jr.depth--; jr.depth == 0 {
+				return jr.consume(0, jr.pos+1)
+			}
+		}
+	}
+	return
+}
+
+// consume hands back buf[off:off+n] and drops everything up to the end of the line it is on
+func (jr *JSONReader) consume(off, n int) (ln []byte, ok bool) {
+	end := off + n
+	if nl := bytes.IndexByte(jr.buf[end:], '\n'); nl >= 0 && len(bytes.TrimSpace(jr.buf[end:end+nl])) == 0 {
+		end += nl + 1
+	}
+	ln = append([]byte(nil), jr.buf[off:off+n]...)
+	ok = len(ln) > 0
+	jr.idx += int64(end)
+	jr.reset(end)
+	return
+}
+
+func (jr *JSONReader) reset(end int) {
+	if end >= len(jr.buf) {
+		jr.buf = jr.buf[:0]
+	} else {
+		jr.buf = append(jr.buf[:0], jr.buf[end:]...)
+	}
+	jr.pos, jr.depth, jr.inStr, jr.esc = 0, 0, false, false
+}
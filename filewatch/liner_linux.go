@@ -18,6 +18,7 @@ type LineReader struct {
 	baseReader
 	brdr     *bufio.Reader
 	currLine []byte
+	wide     *wideLiner // set for UTF-16 files
 }
 
 func NewLineReader(cfg ReaderConfig) (*LineReader, error) {
@@ -25,13 +26,23 @@ func NewLineReader(cfg ReaderConfig) (*LineReader, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &LineReader{
+	lr := &LineReader{
 		baseReader: br,
 		brdr:       bufio.NewReader(cfg.Fin),
-	}, nil
+	}
+	if cfg.Charset.Wide() {
+		lr.wide = newWideLiner(cfg.Fin, cfg.Charset, cfg.StartIndex)
+	}
+	return lr, nil
 }
 
 func (lr *LineReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	if lr.wide != nil {
+		var n int64
+		ln, n, ok, wasEOF, err = lr.wide.readEntry(lr.brdr, lr.idx)
+		lr.idx += n
+		return
+	}
 	for {
 		//ReadBytes garuntees that it returns err == nil ONLY when the results hit the delimiter
 		b, lerr := lr.brdr.ReadBytes(byte('\n'))
@@ -22,6 +22,7 @@ type LineReader struct {
 	currLine []byte
 	idx      int64
 	maxLine  int
+	wide     *wideLiner // set for UTF-16 files
 }
 
 func NewLineReader(cfg ReaderConfig) (*LineReader, error) {
@@ -35,11 +36,15 @@ func NewLineReader(cfg ReaderConfig) (*LineReader, error) {
 		return nil, errors.New("Invalid start index")
 	}
 	fpath := cfg.Fin.Name()
-	return &LineReader{
+	lr := &LineReader{
 		fpath:   fpath,
 		idx:     cfg.StartIndex,
 		maxLine: cfg.MaxLineLen,
-	}, nil
+	}
+	if cfg.Charset.Wide() {
+		lr.wide = newWideLiner(cfg.Fin, cfg.Charset, cfg.StartIndex)
+	}
+	return lr, nil
 }
 
 func (lr *LineReader) SeekFile(offset int64) error {
@@ -67,6 +72,12 @@ func (lr *LineReader) ReadEntry() (ln []byte, ok bool, sawEOF bool, err error) {
 	}
 	defer fin.Close()
 	brdr := bufio.NewReader(fin)
+	if lr.wide != nil {
+		var n int64
+		ln, n, ok, sawEOF, err = lr.wide.readEntry(brdr, lr.idx)
+		lr.idx += n
+		return
+	}
 	for {
 		//ReadBytes garuntees that it returns err == nil ONLY when the results hit the delimiter
 		b, lerr := brdr.ReadBytes(byte('\n'))
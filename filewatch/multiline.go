@@ -0,0 +1,139 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+)
+
+var (
+	ErrMissingMultilineStart = errors.New("Multiline engine requires a start pattern")
+)
+
+// MultilineReader groups lines into records.  A line matching the start pattern begins a new
+// record.  If a continuation pattern is set only lines matching it are appended to the current
+// record and anything else stands alone, otherwise every line that isn't a start is appended.
+// The end of a record isn't known until the next one starts, so a pending record is sent once the
+// file has been idle for a while.  The index only moves past complete records.
+type MultilineReader struct {
+	baseReader
+	start    *regexp.Regexp
+	cont     *regexp.Regexp
+	brdr     *bufio.Reader
+	partial  []byte // line that has not seen its newline yet
+	rec      []byte // record being assembled
+	recLen   int64  // bytes of the file consumed by rec
+	lastRead time.Time
+	idle     time.Duration
+}
+
+func NewMultilineReader(cfg ReaderConfig) (*MultilineReader, error) {
+	if cfg.EngineArgs == `` {
+		return nil, ErrMissingMultilineStart
+	}
+	start, err := regexp.Compile(cfg.EngineArgs)
+	if err != nil {
+		return nil, err
+	}
+	var cont *regexp.Regexp
+	if cfg.ContinueArgs != `` {
+		if cont, err = regexp.Compile(cfg.ContinueArgs); err != nil {
+			return nil, err
+		}
+	}
+	br, err := newBaseReader(cfg.Fin, cfg.MaxLineLen, cfg.StartIndex)
+	if err != nil {
+		return nil, err
+	}
+	return &MultilineReader{
+		baseReader: br,
+		start:      start,
+		cont:       cont,
+		brdr:       bufio.NewReader(cfg.Fin),
+		lastRead:   time.Now(),
+		idle:       idleFlushTimeout,
+	}, nil
+}
+
+func (mr *MultilineReader) SeekFile(offset int64) error {
+	mr.partial, mr.rec, mr.recLen = nil, nil, 0
+	err := mr.baseReader.SeekFile(offset)
+	mr.brdr.Reset(mr.f)
+	return err
+}
+
+func (mr *MultilineReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	for {
+		b, lerr := mr.brdr.ReadBytes('\n')
+		if lerr != nil && lerr != io.EOF {
+			err = lerr
+			return
+		}
+		if len(b) > 0 {
+			mr.lastRead = time.Now()
+		}
+		if lerr == io.EOF {
+			wasEOF = true
+			mr.partial = append(mr.partial, b...)
+			break
+		}
+		if len(mr.partial) > 0 {
+			b = append(mr.partial, b...)
+			mr.partial = nil
+		}
+		if ln, ok = mr.addLine(bytes.TrimRight(b, "\r\n"), int64(len(b))); ok {
+			return
+		}
+	}
+	//out of complete lines, if things have gone quiet send what we have
+	if mr.recLen > 0 && time.Since(mr.lastRead) > mr.idle {
+		ln, ok = mr.flush()
+	}
+	return
+}
+
+// addLine adds a line to the pending record, returning a record if the line completes one
+func (mr *MultilineReader) addLine(b []byte, raw int64) (ln []byte, ok bool) {
+	if mr.recLen == 0 {
+		if len(b) == 0 {
+			//blank lines between records are dropped
+			mr.idx += raw
+			return
+		}
+		mr.begin(b, raw)
+	} else if mr.start.Match(b) || (mr.cont != nil && !mr.cont.Match(b)) {
+		ln, ok = mr.flush()
+		mr.begin(b, raw)
+	} else {
+		mr.rec = append(mr.rec, '\n')
+		mr.rec = append(mr.rec, b...)
+		mr.recLen += raw
+	}
+	if !ok && mr.maxLine > 0 && len(mr.rec) >= mr.maxLine {
+		ln, ok = mr.flush()
+	}
+	return
+}
+
+func (mr *MultilineReader) begin(b []byte, raw int64) {
+	mr.rec = append(make([]byte, 0, len(b)), b...)
+	mr.recLen = raw
+}
+
+func (mr *MultilineReader) flush() (ln []byte, ok bool) {
+	ln, ok = mr.rec, true
+	mr.idx += mr.recLen
+	mr.rec, mr.recLen = nil, 0
+	return
+}
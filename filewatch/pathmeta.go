@@ -0,0 +1,192 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	// PathMetadataNone disables attaching path captures to entries
+	PathMetadataNone = `none`
+)
+
+var (
+	ErrPathRegexNoCaptures = errors.New("Path-Regex has no named capture groups")
+	ErrTagTemplateNoRegex  = errors.New("Tag-Name references path captures but no Path-Regex is set")
+	ErrMissingTagResolver  = errors.New("templated tag names require a tag resolver")
+
+	tagTemplateRx = regexp.MustCompile(`\$(\$|\{[^}]*\}|[[:word:]]+)`)
+)
+
+// pathHandler is implemented by handlers that attribute entries based on the file they came from.
+// The filter manager asks for a handler for each file it follows, if ok is false the file is skipped.
+type pathHandler interface {
+	ForPath(fpath string) (h handler, ok bool, err error)
+}
+
+// handlerFor returns the handler to use for a file matched by the filter
+func (fltr filter) handlerFor(fpath string) (handler, bool, error) {
+	if ph, ok := fltr.lh.(pathHandler); ok {
+		return ph.ForPath(fpath)
+	}
+	return fltr.lh, true, nil
+}
+
+// pathMeta extracts metadata from file paths using the named captures in a regular expression.
+type pathMeta struct {
+	rx        *regexp.Regexp
+	attach    []string //named captures attached to every entry
+	ann       processors.Annotator
+	tagTmpl   bool
+	tagPrefix string
+	tags      *ingest.TagSanitizer
+}
+
+func newPathMeta(cfg LogHandlerConfig) (pm *pathMeta, err error) {
+	templated := IsTagTemplate(cfg.TagName)
+	if cfg.PathRegex == `` {
+		if templated {
+			err = ErrTagTemplateNoRegex
+		}
+		return
+	}
+	if err = CheckPathRegex(cfg.PathRegex, cfg.TagName); err != nil {
+		return
+	}
+	pm = &pathMeta{
+		rx:      regexp.MustCompile(cfg.PathRegex),
+		tagTmpl: templated,
+	}
+	if templated {
+		if cfg.TagResolver == nil {
+			err = ErrMissingTagResolver
+			return
+		} else if pm.tags, err = ingest.NewTagSanitizer(cfg.TagReplacement); err != nil {
+			return
+		}
+	}
+	if strings.ToLower(strings.TrimSpace(cfg.PathMetadataMode)) == PathMetadataNone {
+		return
+	}
+	if pm.ann, err = processors.NewAnnotator(cfg.PathMetadataMode, cfg.PathMetadataField); err != nil {
+		return
+	}
+	if len(cfg.PathMetadata) > 0 {
+		for _, n := range cfg.PathMetadata {
+			if pm.rx.SubexpIndex(n) <= 0 {
+				err = fmt.Errorf("Path-Metadata %q is not a Path-Regex capture", n)
+				return
+			}
+		}
+		pm.attach = cfg.PathMetadata
+	} else {
+		for _, n := range pm.rx.SubexpNames() {
+			if n != `` {
+				pm.attach = append(pm.attach, n)
+			}
+		}
+	}
+	return
+}
+
+// IsTagTemplate reports whether a tag name is built from Path-Regex captures, e.g. k8s-${namespace}.
+// The $ character is not allowed in tag names, so any tag containing one is a template.
+func IsTagTemplate(tag string) bool {
+	return strings.Contains(tag, `$`)
+}
+
+// CheckPathRegex validates a Path-Regex and a tag name which may reference its named captures.
+// Tag templates use the same $name and ${name} syntax as regexp.Expand, and whatever is left
+// once the references are removed must be valid in a tag.
+func CheckPathRegex(rxs, tag string) error {
+	rx, err := regexp.Compile(rxs)
+	if err != nil {
+		return fmt.Errorf("Invalid Path-Regex: %w", err)
+	}
+	names := map[string]bool{}
+	for _, n := range rx.SubexpNames() {
+		if n != `` {
+			names[n] = true
+		}
+	}
+	if len(names) == 0 {
+		return ErrPathRegexNoCaptures
+	}
+	if !IsTagTemplate(tag) {
+		return nil
+	}
+	var bad error
+	static := tagTemplateRx.ReplaceAllStringFunc(tag, func(ref string) string {
+		n := strings.Trim(strings.TrimPrefix(ref, `$`), `{}`)
+		if n == `$` {
+			return `$`
+		} else if !names[n] && bad == nil {
+			bad = fmt.Errorf("Tag-Name %q references unknown Path-Regex capture %q", tag, n)
+		}
+		return ``
+	})
+	if bad != nil {
+		return bad
+	}
+	if strings.ContainsAny(static, ingest.FORBIDDEN_TAG_SET) {
+		return fmt.Errorf("Invalid characters in the Tag-Name %q", tag)
+	}
+	return nil
+}
+
+// ForPath builds a handler for a specific file.  When a Path-Regex is configured the
+// named captures are attached to every entry from the file and templated tag names are resolved.
+// Files that do not match the Path-Regex are not followed.
+func (lh *LogHandler) ForPath(fpath string) (handler, bool, error) {
+	if lh.pm == nil {
+		return lh, true, nil
+	}
+	m := lh.pm.rx.FindStringSubmatchIndex(fpath)
+	if m == nil {
+		lh.Logger.Warn("file does not match Path-Regex, ignoring it",
+			log.KV("path", fpath), log.KV("tag", lh.TagName))
+		return nil, false, nil
+	}
+	nlh := *lh
+	nlh.anns = nil
+	for _, n := range lh.pm.attach {
+		if idx := lh.pm.rx.SubexpIndex(n); idx > 0 && m[2*idx] >= 0 {
+			if v := fpath[m[2*idx]:m[2*idx+1]]; v != `` {
+				nlh.anns = append(nlh.anns, processors.Annotation{Name: n, Value: v})
+			}
+		}
+	}
+	if lh.pm.tagTmpl {
+		//path components routinely contain dots and other characters that are not allowed in tags
+		name, err := lh.pm.tags.Tag(string(lh.pm.rx.ExpandString(nil, lh.TagName, fpath, m)))
+		if err == nil {
+			err = ingest.CheckTag(name)
+		}
+		if err != nil {
+			lh.Logger.Warn("file path produced an invalid tag, ignoring it",
+				log.KV("path", fpath), log.KV("tag", name), log.KVErr(err))
+			return nil, false, nil
+		}
+		tag, err := lh.TagResolver(name)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to resolve tag %s for %s: %w", name, fpath, err)
+		}
+		nlh.TagName = name
+		nlh.LogHandlerConfig.Tag = tag
+	}
+	return &nlh, true, nil
+}
@@ -0,0 +1,110 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const k8sPathRegex = `^/var/log/containers/(?P<pod>[^_]+)_(?P<namespace>[^_]+)_(?P<container>.+)-[0-9a-f]{8}\.log$`
+
+type entryCollector struct {
+	ents []*entry.Entry
+}
+
+func (ec *entryCollector) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	ec.ents = append(ec.ents, ent)
+	return nil
+}
+
+func TestCheckPathRegex(t *testing.T) {
+	good := []string{`k8s`, `k8s-$namespace`, `k8s-${namespace}-${container}`, `${pod}`}
+	for _, tag := range good {
+		if err := CheckPathRegex(k8sPathRegex, tag); err != nil {
+			t.Fatalf("%s: %v", tag, err)
+		}
+	}
+	bad := []string{`k8s-$nope`, `k8s.${pod}`, `$$`}
+	for _, tag := range bad {
+		if err := CheckPathRegex(k8sPathRegex, tag); err == nil {
+			t.Fatalf("bad tag %q passed", tag)
+		}
+	}
+	if err := CheckPathRegex(`^/var/log/(.*)$`, `k8s`); err != ErrPathRegexNoCaptures {
+		t.Fatalf("missing captures not caught: %v", err)
+	}
+	if err := CheckPathRegex(`(?P<x>`, `k8s`); err == nil {
+		t.Fatal("bad regex passed")
+	}
+}
+
+func TestPathHandler(t *testing.T) {
+	var ec entryCollector
+	resolved := map[string]entry.EntryTag{}
+	cfg := LogHandlerConfig{
+		TagName:          `k8s-${namespace}`,
+		IgnoreTS:         true,
+		Logger:           log.NewDiscardLogger(),
+		PathRegex:        k8sPathRegex,
+		PathMetadata:     []string{`pod`, `container`},
+		PathMetadataMode: `prefix`,
+		TagResolver: func(name string) (entry.EntryTag, error) {
+			if _, ok := resolved[name]; !ok {
+				resolved[name] = entry.EntryTag(len(resolved) + 1)
+			}
+			return resolved[name], nil
+		},
+	}
+	lh, err := NewLogHandler(cfg, &ec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ok, err := lh.ForPath(`/var/log/containers/web-7f9_kube.system_nginx-0123abcd.log`)
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if h.Tag() != `k8s-kube_system` {
+		t.Fatalf("bad tag: %s", h.Tag())
+	} else if resolved[`k8s-kube_system`] != 1 {
+		t.Fatalf("tag not resolved: %v", resolved)
+	}
+	if err := h.HandleLog([]byte(`hello`), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(ec.ents) != 1 {
+		t.Fatalf("bad entry count: %d", len(ec.ents))
+	} else if string(ec.ents[0].Data) != `pod=web-7f9 container=nginx hello` {
+		t.Fatalf("bad annotation: %q", ec.ents[0].Data)
+	} else if ec.ents[0].Tag != 1 {
+		t.Fatalf("bad entry tag: %d", ec.ents[0].Tag)
+	}
+
+	//the original handler is untouched
+	if err := lh.HandleLog([]byte(`plain`), time.Now()); err != nil {
+		t.Fatal(err)
+	} else if string(ec.ents[1].Data) != `plain` {
+		t.Fatalf("base handler annotated data: %q", ec.ents[1].Data)
+	}
+
+	//paths that don't match are skipped
+	if _, ok, err = lh.ForPath(`/var/log/containers/garbage.log`); err != nil || ok {
+		t.Fatalf("non-matching path was accepted: %v %v", ok, err)
+	}
+
+	//templated tags need a regex
+	cfg.PathRegex = ``
+	if _, err = NewLogHandler(cfg, &ec); err != ErrTagTemplateNoRegex {
+		t.Fatalf("template without regex not caught: %v", err)
+	}
+}
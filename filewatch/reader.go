@@ -11,6 +11,9 @@ package filewatch
 import (
 	"errors"
 	"os"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/charset"
 )
 
 const (
@@ -19,8 +22,13 @@ const (
 )
 
 const (
-	LineEngine  int = 0
-	RegexEngine int = 1
+	LineEngine      int = 0
+	RegexEngine     int = 1
+	MultilineEngine int = 2
+	JSONEngine      int = 3
+
+	//how long a partial record may sit before it is sent as is
+	idleFlushTimeout = 5 * time.Second
 )
 
 type Reader interface {
@@ -31,17 +39,35 @@ type Reader interface {
 }
 
 type ReaderConfig struct {
-	Fin        *os.File
-	MaxLineLen int
-	StartIndex int64
-	Engine     int
-	EngineArgs string
+	Fin          *os.File
+	MaxLineLen   int
+	StartIndex   int64
+	Engine       int
+	EngineArgs   string
+	ContinueArgs string
+	Charset      charset.Charset // entries are transcoded from this charset to UTF-8
 }
 
 func NewReader(cfg ReaderConfig) (Reader, error) {
+	if cfg.Charset.Wide() && cfg.Engine != LineEngine {
+		return nil, ErrWideCharsetEngine
+	}
+	rdr, err := newEngineReader(cfg)
+	if err != nil || cfg.Charset.IsUTF8() || cfg.Charset.Wide() {
+		//the line engine transcodes UTF-16 itself
+		return rdr, err
+	}
+	return &decodingReader{Reader: rdr, cs: cfg.Charset}, nil
+}
+
+func newEngineReader(cfg ReaderConfig) (Reader, error) {
 	switch cfg.Engine {
 	case RegexEngine:
 		return NewRegexReader(cfg)
+	case MultilineEngine:
+		return NewMultilineReader(cfg)
+	case JSONEngine:
+		return NewJSONReader(cfg)
 	case LineEngine: //default/empty is line reader
 		return NewLineReader(cfg)
 	}
@@ -0,0 +1,233 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/gravwell/gravwell/v3/ingest/charset"
+)
+
+func newRecordFile(t *testing.T, data string) (f *os.File) {
+	p := filepath.Join(t.TempDir(), `records.log`)
+	var err error
+	if f, err = os.Create(p); err != nil {
+		t.Fatal(err)
+	} else if _, err = f.WriteString(data); err != nil {
+		t.Fatal(err)
+	} else if _, err = f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// readRecords reads entries until the reader runs dry
+func readRecords(t *testing.T, rdr Reader) (ents []string) {
+	for {
+		ln, ok, _, err := rdr.ReadEntry()
+		if err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			return
+		}
+		ents = append(ents, string(ln))
+	}
+}
+
+func checkRecords(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("bad record count %d != %d: %q", len(got), len(want), got)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("bad record %d:\n%q\n%q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMultilineReader(t *testing.T) {
+	first := "2022-01-01 first\n\tat a\n\tat b\n"
+	second := "2022-01-02 second\r\n"
+	third := "2022-01-03 third\n\tat c\n"
+	f := newRecordFile(t, "\n"+first+second+third)
+	mr, err := NewMultilineReader(ReaderConfig{
+		Fin:        f,
+		MaxLineLen: 1024,
+		EngineArgs: `^\d{4}-\d{2}-\d{2} `,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	checkRecords(t, readRecords(t, mr), []string{"2022-01-01 first\n\tat a\n\tat b", "2022-01-02 second"})
+	//the last record is held until it goes idle, and the index doesn't move past it
+	if exp := int64(1 + len(first) + len(second)); mr.Index() != exp {
+		t.Fatalf("bad index %d != %d", mr.Index(), exp)
+	}
+	mr.idle = 0
+	checkRecords(t, readRecords(t, mr), []string{"2022-01-03 third\n\tat c"})
+	if exp := int64(1 + len(first) + len(second) + len(third)); mr.Index() != exp {
+		t.Fatalf("bad index %d != %d", mr.Index(), exp)
+	}
+
+	//with a continuation pattern, lines that are neither stand alone
+	f = newRecordFile(t, first+"stray line\n"+second)
+	if mr, err = NewMultilineReader(ReaderConfig{
+		Fin:          f,
+		MaxLineLen:   1024,
+		EngineArgs:   `^\d{4}-`,
+		ContinueArgs: `^\s`,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	mr.idle = 0
+	checkRecords(t, readRecords(t, mr), []string{"2022-01-01 first\n\tat a\n\tat b", "stray line", "2022-01-02 second"})
+
+	if _, err = NewMultilineReader(ReaderConfig{Fin: f}); err != ErrMissingMultilineStart {
+		t.Fatalf("bad error: %v", err)
+	}
+}
+
+func TestJSONReader(t *testing.T) {
+	obj1 := "{\n  \"a\": \"brace } in a string\",\n  \"b\": [1, {\"c\": \"\\\"}\"}]\n}"
+	obj2 := `{"d":{}}`
+	f := newRecordFile(t, "  "+obj1+"\nnot json\n"+obj2+" \n{\"e\":\n")
+	jr, err := NewJSONReader(ReaderConfig{
+		Fin:        f,
+		MaxLineLen: 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jr.Close()
+	checkRecords(t, readRecords(t, jr), []string{obj1, `not json`, obj2})
+
+	//the rest of the object shows up later
+	wtr, err := os.OpenFile(f.Name(), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wtr.Close()
+	if _, err = wtr.WriteString("1}\n"); err != nil {
+		t.Fatal(err)
+	}
+	checkRecords(t, readRecords(t, jr), []string{"{\"e\":\n1}"})
+	if fi, err := wtr.Stat(); err != nil {
+		t.Fatal(err)
+	} else if jr.Index() != fi.Size() {
+		t.Fatalf("bad index %d != %d", jr.Index(), fi.Size())
+	}
+
+	//a truncated object goes out once the file is idle
+	if _, err = wtr.WriteString(`{"f": [`); err != nil {
+		t.Fatal(err)
+	}
+	if ents := readRecords(t, jr); len(ents) != 0 {
+		t.Fatalf("got partial object early: %q", ents)
+	}
+	jr.idle = 0
+	checkRecords(t, readRecords(t, jr), []string{`{"f": [`})
+}
+
+func utf16Data(s string, bigEndian bool) (b []byte) {
+	for _, u := range utf16.Encode([]rune(s)) {
+		if bigEndian {
+			b = append(b, byte(u>>8), byte(u))
+		} else {
+			b = append(b, byte(u), byte(u>>8))
+		}
+	}
+	return
+}
+
+func TestWideLineReader(t *testing.T) {
+	cs, err := charset.Parse(`utf-16`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//U+010A and U+0A0A put newline bytes in code units that are not newlines
+	lines := []string{"2022-01-01 first Ċ", "2022-01-02 second ਊ", "2022-01-03 third 日本"}
+	for _, be := range []bool{false, true} {
+		bom := []byte{0xff, 0xfe}
+		if be {
+			bom = []byte{0xfe, 0xff}
+		}
+		data := append(bom, utf16Data(lines[0]+"\r\n\n"+lines[1]+"\n", be)...)
+		f := newRecordFile(t, string(data))
+		lr, err := NewReader(ReaderConfig{Fin: f, MaxLineLen: 1024, Charset: cs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkRecords(t, readRecords(t, lr), lines[:2])
+		if lr.Index() != int64(len(data)) {
+			t.Fatalf("bad index %d != %d", lr.Index(), len(data))
+		}
+
+		//the last line trickles in a byte at a time
+		wtr, err := os.OpenFile(f.Name(), os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := utf16Data(lines[2]+"\n", be)
+		for i := range last {
+			if _, err = wtr.Write(last[i : i+1]); err != nil {
+				t.Fatal(err)
+			}
+			got := readRecords(t, lr)
+			if i < len(last)-1 && len(got) != 0 {
+				t.Fatalf("got a record after %d bytes: %q", i+1, got)
+			} else if i == len(last)-1 {
+				checkRecords(t, got, lines[2:])
+			}
+		}
+		wtr.Close()
+		lr.Close()
+
+		//resuming part way in still picks up the byte order from the mark
+		f, err = os.Open(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lr, err = NewReader(ReaderConfig{Fin: f, MaxLineLen: 1024, StartIndex: int64(len(data)), Charset: cs}); err != nil {
+			t.Fatal(err)
+		}
+		checkRecords(t, readRecords(t, lr), lines[2:])
+		lr.Close()
+	}
+
+	if _, err = NewReader(ReaderConfig{Fin: newRecordFile(t, ``), Engine: JSONEngine, Charset: cs}); err != ErrWideCharsetEngine {
+		t.Fatalf("bad error: %v", err)
+	}
+}
+
+func TestDecodingReader(t *testing.T) {
+	cs, err := charset.Parse(`latin-1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newRecordFile(t, "2022-01-01 caf\xe9\n\tat \xa9\n2022-01-02 na\xefve\n")
+	rdr, err := NewReader(ReaderConfig{
+		Fin:        f,
+		MaxLineLen: 1024,
+		Engine:     MultilineEngine,
+		EngineArgs: `^\d{4}-`,
+		Charset:    cs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rdr.Close()
+	rdr.(*decodingReader).Reader.(*MultilineReader).idle = 0
+	checkRecords(t, readRecords(t, rdr), []string{"2022-01-01 café\n\tat ©", "2022-01-02 naïve"})
+}
@@ -0,0 +1,226 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type decompressor func(io.Reader) (io.ReadCloser, error)
+
+var rotatedExts = map[string]decompressor{
+	`.gz`: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	`.bz2`: func(r io.Reader) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	},
+	`.xz`: func(r io.Reader) (io.ReadCloser, error) {
+		rdr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(rdr), nil
+	},
+	`.zst`: func(r io.Reader) (io.ReadCloser, error) {
+		rdr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return rdr.IOReadCloser(), nil
+	},
+}
+
+type rotatedFile struct {
+	pth     string
+	modTime time.Time
+	dec     decompressor //nil for rotations that have not been compressed yet
+}
+
+// rotatedSiblings finds rotations of fpath that were modified after since, oldest first.
+// Rotations are named after the live file, app.log.1.gz, app.log-20220301.gz, etc.
+// Uncompressed rotations (app.log.1) are only picked up if they are not matched by
+// the follower, which is common when logrotate is using delaycompress.
+func rotatedSiblings(fpath string, since time.Time, followed func(string) bool) (rfs []rotatedFile, err error) {
+	base := filepath.Base(fpath)
+	var fis []os.FileInfo
+	if fis, err = ioutil.ReadDir(filepath.Dir(fpath)); err != nil {
+		return
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || !fi.ModTime().After(since) || len(name) <= len(base) {
+			continue
+		} else if !strings.HasPrefix(name, base) || (name[len(base)] != '.' && name[len(base)] != '-') {
+			continue
+		}
+		rf := rotatedFile{
+			pth:     filepath.Join(filepath.Dir(fpath), name),
+			modTime: fi.ModTime(),
+		}
+		if dec, ok := rotatedExts[filepath.Ext(name)]; ok {
+			rf.dec = dec
+		} else if !isDigits(name[len(base)+1:]) || followed(name) {
+			continue
+		}
+		rfs = append(rfs, rf)
+	}
+	sort.SliceStable(rfs, func(i, j int) bool {
+		return rfs[i].modTime.Before(rfs[j].modTime)
+	})
+	return
+}
+
+func isDigits(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// CatchupRotated ingests rotations of followed files that were written after the state file
+// was last saved, for followers that asked for it.  The oldest such rotation is the file that
+// was being followed, so it is resumed from the saved offset, the rest are ingested in full
+// and the live file is then followed from the beginning.
+// States that carry file IDs are only caught up when the file was replaced or truncated,
+// older states are assumed to have been rotated if a newer rotation exists.
+// Rotations are only caught up once, the first time this is called.
+func (f *FilterManager) CatchupRotated(wfs []watchedFile, qc chan os.Signal) (bool, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.rotatedDone {
+		return false, nil
+	}
+	f.rotatedDone = true
+	if f.stateTime.IsZero() {
+		return false, nil //no saved states, nothing could have been missed
+	}
+	for _, wf := range wfs {
+		fname := filepath.Base(wf.pth)
+		fdir := filepath.Dir(wf.pth)
+		for i, v := range f.filters {
+			if !v.catchupRotated || v.loc != fdir || !f.matchFile(v.mtchs, fname) {
+				continue
+			}
+			si := f.seekInfo(v.bname, wf.pth)
+			stid := FileName{BaseName: v.bname, FilePath: wf.pth}
+			offset, replaced := f.rotatedOffsets[stid]
+			if !replaced {
+				if _, ok := f.meta[stid]; ok || si == nil {
+					continue //still the same file we were following, it was not rotated
+				}
+				offset = *si
+			}
+			rfs, err := rotatedSiblings(wf.pth, f.stateTime, func(n string) bool {
+				return f.matchFile(v.mtchs, n)
+			})
+			if err != nil {
+				return false, err
+			} else if len(rfs) == 0 {
+				continue
+			}
+			//rotations are attributed to the live file
+			lh, ok, err := v.handlerFor(wf.pth)
+			if err != nil {
+				return false, err
+			} else if !ok {
+				continue
+			}
+			for _, rf := range rfs {
+				fcfg := FollowerConfig{
+					FollowerEngineConfig: v.FollowerEngineConfig,
+					BaseName:             v.bname,
+					FilterID:             i,
+					Handler:              lh,
+				}
+				if quit, err := f.catchupRotation(fcfg, rf, offset, qc); err != nil || quit {
+					return quit, err
+				}
+				offset = 0
+			}
+			//the live file is a new file since the state was saved
+			if si != nil {
+				*si = 0
+			}
+		}
+	}
+	return false, nil
+}
+
+// catchupRotation expands a rotated file next to the state file and consumes it from offset.
+// If the expanded file is shorter than the offset it can't be the file we were following,
+// so the whole thing is ingested.
+func (f *FilterManager) catchupRotation(fcfg FollowerConfig, rf rotatedFile, offset int64, qc chan os.Signal) (bool, error) {
+	fcfg.FilePath = rf.pth
+	if rf.dec != nil {
+		tmp, err := f.expandRotation(rf)
+		if err != nil {
+			return false, err
+		}
+		defer os.Remove(tmp)
+		fcfg.FilePath = tmp
+	}
+	fi, err := os.Stat(fcfg.FilePath)
+	if err != nil {
+		return false, err
+	}
+	if offset > fi.Size() {
+		offset = 0
+	}
+	fcfg.State = &offset
+	f.logger.Info("ingesting rotated file",
+		log.KV("path", rf.pth),
+		log.KV("follower", fcfg.BaseName),
+		log.KV("offset", offset))
+	return f.catchupFollower(fcfg, qc)
+}
+
+func (f *FilterManager) expandRotation(rf rotatedFile) (tmp string, err error) {
+	var fin, fout *os.File
+	if fin, err = os.Open(rf.pth); err != nil {
+		return
+	}
+	defer fin.Close()
+	var rdr io.ReadCloser
+	if rdr, err = rf.dec(fin); err != nil {
+		return
+	}
+	defer rdr.Close()
+	if fout, err = ioutil.TempFile(filepath.Dir(f.stateFile), `rotated`); err != nil {
+		return
+	}
+	tmp = fout.Name()
+	if _, err = io.Copy(fout, rdr); err != nil {
+		fout.Close()
+	} else {
+		err = fout.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		tmp = ``
+	}
+	return
+}
@@ -0,0 +1,119 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type orderedLH struct {
+	testTagger
+	sync.Mutex
+	lines []string
+}
+
+func (h *orderedLH) HandleLog(b []byte, ts time.Time) error {
+	h.Lock()
+	h.lines = append(h.lines, string(b))
+	h.Unlock()
+	return nil
+}
+
+func writeGzip(t *testing.T, pth, data string, mod time.Time) {
+	fout, err := os.Create(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(fout)
+	if _, err = gz.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	} else if err = gz.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = fout.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = os.Chtimes(pth, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCatchupRotated(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `rotated`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Now()
+	live := filepath.Join(dir, `app.log`)
+
+	//we had read the first line of the file when the state was last saved
+	stateFile := filepath.Join(dir, `state`)
+	offset := int64(len("line1\n"))
+	fout, err := os.Create(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := map[FileName]*int64{
+		{BaseName: bName, FilePath: live}: &offset,
+	}
+	if err = gob.NewEncoder(fout).Encode(states); err != nil {
+		t.Fatal(err)
+	} else if err = fout.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = os.Chtimes(stateFile, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	//an old rotation we already have, the file we were following, a later rotation, and the live file
+	writeGzip(t, live+`.3.gz`, "old\n", now.Add(-2*time.Hour))
+	writeGzip(t, live+`.2.gz`, "line1\nline2\n", now.Add(-30*time.Minute))
+	if err = ioutil.WriteFile(live+`.1`, []byte("line3\n"), 0640); err != nil {
+		t.Fatal(err)
+	} else if err = os.Chtimes(live+`.1`, now.Add(-10*time.Minute), now.Add(-10*time.Minute)); err != nil {
+		t.Fatal(err)
+	} else if err = ioutil.WriteFile(live, []byte("line4\nline5\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWatcher(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lh := &orderedLH{}
+	if err = w.Add(WatchConfig{
+		ConfigName:     bName,
+		BaseDir:        dir,
+		FileFilter:     `app.log`,
+		Hnd:            lh,
+		CatchupRotated: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if quit, err := w.Catchup(nil); err != nil || quit {
+		t.Fatal(quit, err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(lh.lines, ","); got != `line2,line3,line4,line5` {
+		t.Fatalf("bad lines: %s", got)
+	}
+	//expanded rotations are cleaned up
+	if matches, _ := filepath.Glob(filepath.Join(dir, `rotated*`)); len(matches) != 0 {
+		t.Fatalf("temporary files left behind: %v", matches)
+	}
+}
@@ -0,0 +1,208 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateVersion = 2
+
+	// printSize is how much of the start of a file is remembered, it lets us tell a file
+	// that was truncated and rewritten from one that just grew, and spot copytruncate copies
+	printSize = 64
+)
+
+// stateRecord is what is saved for each followed file.  Files are identified by device and inode
+// (volume and file index on Windows) so renames and replacements are caught across restarts.
+type stateRecord struct {
+	Offset int64
+	Id     FileId
+	Print  []byte
+}
+
+type stateFile struct {
+	Version int
+	States  map[FileName]stateRecord
+}
+
+// fileMeta is the identity of a followed file, it is kept alongside the offset
+type fileMeta struct {
+	id    FileId
+	print []byte
+}
+
+// decodeStates loads a state file, older state files only held offsets and have no metadata
+func decodeStates(rdr io.Reader) (states map[FileName]*int64, meta map[FileName]fileMeta, err error) {
+	var b []byte
+	if b, err = ioutil.ReadAll(rdr); err != nil {
+		return
+	}
+	states = map[FileName]*int64{}
+	meta = map[FileName]fileMeta{}
+	var sf stateFile
+	if err = gob.NewDecoder(bytes.NewReader(b)).Decode(&sf); err == nil && sf.Version > 0 {
+		for k, v := range sf.States {
+			offset := v.Offset
+			states[k] = &offset
+			meta[k] = fileMeta{id: v.Id, print: v.Print}
+		}
+		return
+	}
+	err = gob.NewDecoder(bytes.NewReader(b)).Decode(&states)
+	return
+}
+
+func encodeStates(wtr io.Writer, states map[FileName]*int64, meta map[FileName]fileMeta) error {
+	sf := stateFile{
+		Version: stateVersion,
+		States:  make(map[FileName]stateRecord, len(states)),
+	}
+	for k, v := range states {
+		var sr stateRecord
+		if v != nil {
+			sr.Offset = *v
+		}
+		if m, ok := meta[k]; ok {
+			sr.Id = m.id
+			sr.Print = m.print
+		}
+		sf.States[k] = sr
+	}
+	return gob.NewEncoder(wtr).Encode(sf)
+}
+
+// readPrint grabs the start of a file
+func readPrint(f io.ReaderAt) []byte {
+	b := make([]byte, printSize)
+	n, _ := f.ReadAt(b, 0)
+	return b[:n]
+}
+
+func readPrintFromName(p string) (b []byte, err error) {
+	var fin *os.File
+	if fin, err = openDeletableFile(p); err != nil {
+		return
+	}
+	b = readPrint(fin)
+	err = fin.Close()
+	return
+}
+
+// printMatches reports whether a file starting with cur could be the file that was fingerprinted
+// as old.  A file that has not grown to the full print size only needs to match what it has.
+func printMatches(old, cur []byte) bool {
+	if len(cur) < len(old) {
+		return bytes.HasPrefix(old, cur)
+	}
+	return bytes.HasPrefix(cur, old)
+}
+
+// dirIds maps file IDs to names for a directory, it is built lazily and only once per directory
+type dirIds map[string]map[FileId]string
+
+func (di dirIds) find(dir string, id FileId) (p string, ok bool) {
+	ids, ok := di[dir]
+	if !ok {
+		ids = map[FileId]string{}
+		if fis, err := ioutil.ReadDir(dir); err == nil {
+			for _, fi := range fis {
+				if !fi.Mode().IsRegular() {
+					continue
+				}
+				pth := filepath.Join(dir, fi.Name())
+				if lid, err := getFileIdFromName(pth); err == nil {
+					ids[lid] = pth
+				}
+			}
+		}
+		di[dir] = ids
+	}
+	p, ok = ids[id]
+	return
+}
+
+// reconcileStates checks saved states against what is on disk now:
+//
+//   - a file that is gone or was replaced has its state moved to wherever the file
+//     was renamed to, if it can be found in the same directory
+//   - a replaced file starts from the beginning, the offset into the file it replaced is
+//     returned in rotated so rotated file catchup can pick up where we left off
+//   - a file that shrank, or whose first bytes changed, was truncated and starts over, the old
+//     offset is also returned in rotated as the data may live on in a copytruncate copy
+//
+// States saved by older versions have no file IDs and are only checked for truncation.
+func reconcileStates(states map[FileName]*int64, meta map[FileName]fileMeta) (rotated map[FileName]int64) {
+	rotated = map[FileName]int64{}
+	di := dirIds{}
+	moved := map[FileName]bool{}
+	for k, v := range states {
+		if moved[k] {
+			continue
+		}
+		if v == nil {
+			v = new(int64)
+			states[k] = v
+		}
+		m, hasMeta := meta[k]
+		fi, err := os.Stat(k.FilePath)
+		if err != nil && !os.IsNotExist(err) {
+			continue
+		}
+		var id FileId
+		if err == nil {
+			if id, err = getFileIdFromName(k.FilePath); err != nil {
+				continue
+			}
+		}
+		if !hasMeta {
+			if fi == nil {
+				delete(states, k)
+			} else if fi.Size() < *v {
+				*v = 0 //if file shrank, we have to assume this was a truncation, so remove the state
+			}
+			continue
+		}
+
+		if fi == nil || id != m.id {
+			//the file we were following is no longer at this path, see if it was renamed
+			if p, ok := di.find(filepath.Dir(k.FilePath), m.id); ok && p != k.FilePath {
+				nk := FileName{BaseName: k.BaseName, FilePath: p}
+				if _, exists := states[nk]; !exists {
+					offset := *v
+					states[nk] = &offset
+					meta[nk] = m
+					moved[nk] = true
+				}
+			}
+			rotated[k] = *v
+			delete(meta, k)
+			if fi == nil {
+				delete(states, k)
+			} else {
+				*v = 0
+			}
+			continue
+		}
+		if fi.Size() < *v {
+			rotated[k] = *v
+			*v = 0
+		} else if pr, err := readPrintFromName(k.FilePath); err == nil && !printMatches(m.print, pr) {
+			rotated[k] = *v
+			*v = 0
+		}
+	}
+	return
+}
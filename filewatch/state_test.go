@@ -0,0 +1,193 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func numberedLines(pfx string, start, cnt int) (s string) {
+	for i := start; i < start+cnt; i++ {
+		s += fmt.Sprintf("%s line number %04d with some padding to fill it out\n", pfx, i)
+	}
+	return
+}
+
+func writeStateFixture(t *testing.T, pth, data string) (stid FileName, m fileMeta) {
+	if err := ioutil.WriteFile(pth, []byte(data), 0640); err != nil {
+		t.Fatal(err)
+	}
+	id, err := getFileIdFromName(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stid = FileName{BaseName: baseName, FilePath: pth}
+	m = fileMeta{id: id, print: []byte(data)[:printSize]}
+	return
+}
+
+func TestStateEncoding(t *testing.T) {
+	a, b := int64(10), int64(20)
+	states := map[FileName]*int64{
+		FileName{BaseName: baseName, FilePath: `/tmp/a`}: &a,
+		FileName{BaseName: baseName, FilePath: `/tmp/b`}: &b,
+	}
+	meta := map[FileName]fileMeta{
+		FileName{BaseName: baseName, FilePath: `/tmp/a`}: fileMeta{id: FileId{Major: 1, Minor: 2}, print: []byte(`hello`)},
+	}
+	bb := bytes.NewBuffer(nil)
+	if err := encodeStates(bb, states, meta); err != nil {
+		t.Fatal(err)
+	}
+	nstates, nmeta, err := decodeStates(bb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nstates) != 2 || *nstates[FileName{BaseName: baseName, FilePath: `/tmp/a`}] != 10 || *nstates[FileName{BaseName: baseName, FilePath: `/tmp/b`}] != 20 {
+		t.Fatalf("bad states: %v", nstates)
+	}
+	m := nmeta[FileName{BaseName: baseName, FilePath: `/tmp/a`}]
+	if m.id != (FileId{Major: 1, Minor: 2}) || string(m.print) != `hello` {
+		t.Fatalf("bad meta: %+v", m)
+	}
+}
+
+func TestReconcileStates(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `reconcile`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	//a file that was renamed and replaced
+	rotated := filepath.Join(dir, `rotated.log`)
+	rstid, rmeta := writeStateFixture(t, rotated, numberedLines(`old`, 0, 10))
+	if err := os.Rename(rotated, rotated+`.1`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(rotated, []byte(numberedLines(`new`, 0, 10)), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	//a file that was truncated and rewritten with more data than we had read
+	trunc := filepath.Join(dir, `trunc.log`)
+	tstid, tmeta := writeStateFixture(t, trunc, numberedLines(`old`, 0, 2))
+	if err := ioutil.WriteFile(trunc, []byte(numberedLines(`new`, 0, 10)), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	//a file that just grew
+	grew := filepath.Join(dir, `grew.log`)
+	gstid, gmeta := writeStateFixture(t, grew, numberedLines(`old`, 0, 10))
+
+	//a file that is gone entirely
+	gone := filepath.Join(dir, `gone.log`)
+	dstid, dmeta := writeStateFixture(t, gone, numberedLines(`old`, 0, 10))
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+
+	offsets := []int64{300, 100, 200, 50}
+	states := map[FileName]*int64{
+		rstid: &offsets[0],
+		tstid: &offsets[1],
+		gstid: &offsets[2],
+		dstid: &offsets[3],
+	}
+	meta := map[FileName]fileMeta{
+		rstid: rmeta,
+		tstid: tmeta,
+		gstid: gmeta,
+		dstid: dmeta,
+	}
+	rot := reconcileStates(states, meta)
+
+	if v, ok := states[rstid]; !ok || *v != 0 {
+		t.Fatalf("replaced file did not start over: %v", states)
+	} else if v, ok := states[FileName{BaseName: baseName, FilePath: rotated + `.1`}]; !ok || *v != 300 {
+		t.Fatalf("renamed file did not keep its state: %v", states)
+	} else if rot[rstid] != 300 {
+		t.Fatalf("replaced file offset not handed to rotation catchup: %v", rot)
+	}
+	if v, ok := states[tstid]; !ok || *v != 0 {
+		t.Fatalf("truncated file did not start over")
+	} else if rot[tstid] != 100 {
+		t.Fatalf("truncated file offset not handed to rotation catchup: %v", rot)
+	}
+	if v, ok := states[gstid]; !ok || *v != 200 {
+		t.Fatalf("grown file lost its state")
+	} else if _, ok := rot[gstid]; ok {
+		t.Fatalf("grown file treated as rotated")
+	}
+	if _, ok := states[dstid]; ok {
+		t.Fatalf("missing file kept its state")
+	}
+}
+
+func TestFollowerCopyTruncate(t *testing.T) {
+	dir, err := ioutil.TempDir(tempPath, `copytruncate`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	live := filepath.Join(dir, `app.log`)
+	read := numberedLines(`old`, 0, 5)
+	missed := numberedLines(`old`, 5, 5)
+	if err := ioutil.WriteFile(live, []byte(read+missed), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	var lh orderedLH
+	state := int64(len(read))
+	fl, err := NewFollower(FollowerConfig{
+		BaseName: baseName,
+		FilePath: live,
+		State:    &state,
+		Handler:  &lh,
+		Followed: func(n string) bool { return n == `app.log` },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fl.Close()
+
+	//copy the file aside and truncate it, the way logrotate copytruncate does
+	if err := ioutil.WriteFile(live+`.1`, []byte(read+missed), 0640); err != nil {
+		t.Fatal(err)
+	}
+	fresh := numberedLines(`new`, 0, 3)
+	if err := ioutil.WriteFile(live, []byte(fresh), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := fl.processLines(true); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Split(strings.TrimSuffix(missed+fresh, "\n"), "\n")
+	if len(lh.lines) != len(want) {
+		t.Fatalf("bad line count: %d != %d\n%v", len(lh.lines), len(want), lh.lines)
+	}
+	for i := range want {
+		if lh.lines[i] != want[i] {
+			t.Fatalf("line %d mismatch: %q != %q", i, lh.lines[i], want[i])
+		}
+	}
+	if state != int64(len(fresh)) {
+		t.Fatalf("bad state after truncate: %d", state)
+	}
+	//the copy picks up where we were when the file was truncated
+	if offset, ok := fl.resumeOffset([]byte(read)[:printSize]); !ok || offset != int64(len(read)) {
+		t.Fatalf("bad resume offset for copy: %d %v", offset, ok)
+	}
+}
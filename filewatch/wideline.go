@@ -0,0 +1,123 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package filewatch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/gravwell/gravwell/v3/ingest/charset"
+)
+
+var (
+	ErrWideCharsetEngine = errors.New("UTF-16 files can only be read with the line engine")
+)
+
+// wideLiner splits lines of a UTF-16 file and transcodes them to UTF-8.  A newline byte is
+// only a newline when it is part of an aligned newline code unit, so lines are found by
+// looking at whole code units rather than by splitting on a single byte.
+// The byte order is taken from the byte order mark at the start of the file if there is one,
+// the mark itself is skipped.
+type wideLiner struct {
+	cs      charset.Charset
+	checked bool   // the byte order has been settled
+	part    []byte // encoded line waiting on the rest of its bytes
+}
+
+// newWideLiner builds a line splitter for f, if we are resuming part way into the file
+// the byte order mark is read up front since the reader will never see it
+func newWideLiner(f *os.File, cs charset.Charset, startIdx int64) *wideLiner {
+	wl := &wideLiner{cs: cs}
+	if startIdx > 0 {
+		mark := make([]byte, 2)
+		n, _ := f.ReadAt(mark, 0)
+		wl.cs, _ = cs.DetectBOM(mark[:n])
+		wl.checked = true
+	}
+	return wl
+}
+
+// readEntry reads the next line from brdr which is positioned at idx, n is the number of
+// bytes consumed from brdr.  Partial lines are held until the rest of the line shows up.
+func (wl *wideLiner) readEntry(brdr *bufio.Reader, idx int64) (ln []byte, n int64, ok bool, wasEOF bool, err error) {
+	if !wl.checked {
+		if idx == 0 {
+			var mark []byte
+			if mark, err = brdr.Peek(2); err != nil {
+				//wait for enough of the file to check for a mark
+				if err == io.EOF {
+					err = nil
+					wasEOF = true
+				}
+				return
+			}
+			var skip int
+			wl.cs, skip = wl.cs.DetectBOM(mark)
+			brdr.Discard(skip)
+			n += int64(skip)
+		} else {
+			wl.cs, _ = wl.cs.DetectBOM(nil)
+		}
+		wl.checked = true
+	}
+	nl := wl.cs.Newline()
+	for {
+		if len(wl.part)%2 == 0 && bytes.HasSuffix(wl.part, nl) {
+			b := wl.part
+			wl.part = nil
+			if ln, err = wl.cs.Decode(b); err != nil {
+				return
+			}
+			if ln = bytes.TrimRight(ln, "\r\n"); len(ln) > 0 {
+				ok = true
+				return
+			}
+			//just an empty line, try again
+			continue
+		}
+
+		var b []byte
+		var lerr error
+		if len(wl.part)%2 == 1 && wl.part[len(wl.part)-1] == '\n' {
+			//the newline byte is the first of a code unit, we need the second to know
+			var c byte
+			if c, lerr = brdr.ReadByte(); lerr == nil {
+				b = []byte{c}
+			}
+		} else {
+			b, lerr = brdr.ReadSlice('\n')
+		}
+		n += int64(len(b))
+		wl.part = append(wl.part, b...)
+		if lerr == io.EOF {
+			wasEOF = true
+			return
+		} else if lerr != nil && lerr != bufio.ErrBufferFull {
+			err = lerr
+			return
+		}
+	}
+}
+
+// decodingReader transcodes the entries of a file in a single byte or ASCII compatible charset,
+// newlines in these charsets are always a lone newline byte so the engines can split them as is
+type decodingReader struct {
+	Reader
+	cs charset.Charset
+}
+
+func (dr *decodingReader) ReadEntry() (ln []byte, ok bool, wasEOF bool, err error) {
+	if ln, ok, wasEOF, err = dr.Reader.ReadEntry(); err == nil && ok {
+		ln, err = dr.cs.Decode(ln)
+	}
+	return
+}
@@ -64,6 +64,7 @@ type GeneratorConfig struct {
 	SRC         net.IP
 	Logger      *log.Logger
 	LogLevel    log.Level
+	Tracer      TracerConfig
 }
 
 func GetGeneratorConfig(defaultTag string) (gc GeneratorConfig, err error) {
@@ -90,7 +91,15 @@ func GetGeneratorConfig(defaultTag string) (gc GeneratorConfig, err error) {
 		return
 	}
 
+	if gc.Tracer, err = getTracerConfig(); err != nil {
+		return
+	}
+
 	if *rawConn != `` {
+		if gc.Tracer.Enabled() {
+			err = errors.New("tracers are not supported with raw connections")
+			return
+		}
 		if _, _, err = net.SplitHostPort(*rawConn); err != nil {
 			err = fmt.Errorf("invalid raw connection string %q - %v", *rawConn, err)
 			return
@@ -202,9 +211,14 @@ func NewIngestMuxer(name, guid string, gc GeneratorConfig, to time.Duration) (co
 		to = time.Second
 	}
 
+	tags := []string{gc.Tag}
+	if gc.Tracer.Enabled() && gc.Tracer.Tag != gc.Tag {
+		tags = append(tags, gc.Tracer.Tag)
+	}
+
 	umc := ingest.UniformMuxerConfig{
 		Destinations:  gc.ConnSet,
-		Tags:          []string{gc.Tag},
+		Tags:          tags,
 		Auth:          gc.Auth,
 		Tenant:        gc.Tenant,
 		IngesterName:  name,
@@ -297,6 +311,12 @@ func OneShot(conn GeneratorConn, tag entry.EntryTag, src net.IP, cfg GeneratorCo
 		su.Start()
 		defer su.Stop()
 	}
+	var stopTracer func()
+	if stopTracer, err = startTracer(conn, src, cfg); err != nil {
+		err = fmt.Errorf("Failed to start tracer: %w", err)
+		return
+	}
+	defer stopTracer()
 	sp := cfg.Duration / time.Duration(cfg.Count)
 	var ts time.Time
 	if ts = cfg.Start; ts.IsZero() {
@@ -320,6 +340,12 @@ func OneShot(conn GeneratorConn, tag entry.EntryTag, src net.IP, cfg GeneratorCo
 }
 
 func Stream(conn GeneratorConn, tag entry.EntryTag, src net.IP, cfg GeneratorConfig, dg DataGen) (totalCount, totalBytes uint64, err error) {
+	var stopTracer func()
+	if stopTracer, err = startTracer(conn, src, cfg); err != nil {
+		err = fmt.Errorf("Failed to start tracer: %w", err)
+		return
+	}
+	defer stopTracer()
 	var stop bool
 	r := make(chan error, 1)
 	go func(ret chan error, stp *bool) {
@@ -0,0 +1,367 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+package base
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gravwell/gravwell/v3/client"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultTracerInterval = 10 * time.Second
+	defaultTracerTimeout  = time.Minute
+	minTracerInterval     = time.Second
+	tracerSearchSlack     = time.Minute
+)
+
+var (
+	tracerTag      = flag.String("tracer-tag", "", "Emit latency tracer entries to this tag")
+	tracerInterval = flag.String("tracer-interval", defaultTracerInterval.String(), "Interval between tracer entries")
+	tracerTimeout  = flag.String("tracer-timeout", defaultTracerTimeout.String(), "Tracers not visible in search after this long are counted as lost")
+	tracerServer   = flag.String("tracer-server", "", "Webserver address used to measure tracer latency, blank disables measurement")
+	tracerUser     = flag.String("tracer-user", "", "Username for the tracer webserver")
+	tracerPass     = flag.String("tracer-pass", "", "Password for the tracer webserver")
+	tracerNoHttps  = flag.Bool("tracer-no-https", false, "Use cleartext HTTP to talk to the tracer webserver")
+	tracerInsecure = flag.Bool("tracer-insecure", false, "Do not validate the tracer webserver certificate")
+)
+
+// TracerConfig controls the emission and measurement of tracer entries.  Tracers are small JSON
+// entries carrying the time they were sent, if a webserver is configured the tracer repeatedly
+// searches for its own tracers and reports how long they took to become visible.
+type TracerConfig struct {
+	Tag      string
+	Interval time.Duration
+	Timeout  time.Duration
+	Server   string
+	User     string
+	Pass     string
+	Https    bool
+	Insecure bool
+}
+
+func (tc TracerConfig) Enabled() bool {
+	return tc.Tag != ``
+}
+
+func (tc TracerConfig) measuring() bool {
+	return tc.Server != ``
+}
+
+func getTracerConfig() (tc TracerConfig, err error) {
+	if tc.Tag = *tracerTag; tc.Tag == `` {
+		return
+	} else if err = ingest.CheckTag(tc.Tag); err != nil {
+		return
+	}
+	if tc.Interval, err = time.ParseDuration(*tracerInterval); err != nil {
+		err = fmt.Errorf("invalid tracer-interval %s %w", *tracerInterval, err)
+		return
+	} else if tc.Interval < minTracerInterval {
+		err = fmt.Errorf("tracer-interval must be at least %v", minTracerInterval)
+		return
+	}
+	if tc.Timeout, err = time.ParseDuration(*tracerTimeout); err != nil {
+		err = fmt.Errorf("invalid tracer-timeout %s %w", *tracerTimeout, err)
+		return
+	} else if tc.Timeout < tc.Interval {
+		err = errors.New("tracer-timeout must be at least the tracer-interval")
+		return
+	}
+	if tc.Server = *tracerServer; tc.Server != `` {
+		if tc.User = *tracerUser; tc.User == `` {
+			err = errors.New("tracer-user is required to measure tracer latency")
+			return
+		}
+		tc.Pass = *tracerPass
+		tc.Https = !*tracerNoHttps
+		tc.Insecure = *tracerInsecure
+	}
+	return
+}
+
+// TracerRecord is the body of a tracer entry
+type TracerRecord struct {
+	Tracer   string
+	Ingester string
+	Seq      uint64
+	Sent     time.Time
+}
+
+// TracerStats is a running summary of tracer latency
+type TracerStats struct {
+	Sent    uint64
+	Seen    uint64
+	Lost    uint64
+	Pending int
+	Last    time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	Mean    time.Duration
+	total   time.Duration
+}
+
+func (ts *TracerStats) add(lat time.Duration) {
+	if ts.Seen == 0 || lat < ts.Min {
+		ts.Min = lat
+	}
+	if lat > ts.Max {
+		ts.Max = lat
+	}
+	ts.Seen++
+	ts.Last = lat
+	ts.total += lat
+	ts.Mean = ts.total / time.Duration(ts.Seen)
+}
+
+func (ts TracerStats) String() string {
+	if ts.Seen == 0 {
+		return fmt.Sprintf("tracers sent %d, seen 0, lost %d, pending %d", ts.Sent, ts.Lost, ts.Pending)
+	}
+	return fmt.Sprintf("tracers sent %d, seen %d, lost %d, pending %d; latency last %v min %v mean %v max %v",
+		ts.Sent, ts.Seen, ts.Lost, ts.Pending, ts.Last, ts.Min, ts.Mean, ts.Max)
+}
+
+// Tracer periodically writes tracer entries and optionally measures how long they take to be searchable
+type Tracer struct {
+	sync.Mutex
+	cfg     TracerConfig
+	conn    GeneratorConn
+	tag     entry.EntryTag
+	src     net.IP
+	id      string
+	name    string
+	seq     uint64
+	pending map[uint64]time.Time
+	stats   TracerStats
+	cli     *client.Client
+	done    chan bool
+	wg      sync.WaitGroup
+}
+
+// NewTracer negotiates the tracer tag and, if measurement is enabled, logs into the webserver
+func NewTracer(conn GeneratorConn, src net.IP, tc TracerConfig) (t *Tracer, err error) {
+	if conn == nil || !tc.Enabled() {
+		err = errors.New("invalid parameters")
+		return
+	}
+	t = &Tracer{
+		cfg:     tc,
+		conn:    conn,
+		src:     src,
+		id:      uuid.New().String(),
+		name:    filepath.Base(os.Args[0]),
+		pending: map[uint64]time.Time{},
+		done:    make(chan bool),
+	}
+	if t.tag, err = conn.NegotiateTag(tc.Tag); err != nil {
+		err = fmt.Errorf("failed to negotiate tracer tag %s: %w", tc.Tag, err)
+		return
+	}
+	if tc.measuring() {
+		if t.cli, err = client.NewClient(tc.Server, !tc.Insecure, tc.Https, nil); err != nil {
+			return
+		} else if err = t.cli.Login(tc.User, tc.Pass); err != nil {
+			t.cli.Close()
+			err = fmt.Errorf("tracer webserver login failed: %w", err)
+			return
+		}
+	}
+	return
+}
+
+// Start sends the first tracer and begins the emission and measurement routines
+func (t *Tracer) Start() error {
+	if err := t.emit(); err != nil {
+		return err
+	}
+	t.wg.Add(1)
+	go t.routine()
+	return nil
+}
+
+// Close stops emitting tracers, if we are measuring it waits for outstanding tracers to show up or time out
+func (t *Tracer) Close() (err error) {
+	close(t.done)
+	t.wg.Wait()
+	if t.cli == nil {
+		return
+	}
+	deadline := time.Now().Add(t.cfg.Timeout)
+	for t.outstanding() > 0 && time.Now().Before(deadline) {
+		if err = t.measure(); err != nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	t.expire(true)
+	t.cli.Logout()
+	t.cli.Close()
+	return
+}
+
+// Stats returns the current latency summary
+func (t *Tracer) Stats() (ts TracerStats) {
+	t.Lock()
+	ts = t.stats
+	ts.Pending = len(t.pending)
+	t.Unlock()
+	return
+}
+
+func (t *Tracer) outstanding() (n int) {
+	t.Lock()
+	n = len(t.pending)
+	t.Unlock()
+	return
+}
+
+func (t *Tracer) routine() {
+	defer t.wg.Done()
+	tkr := time.NewTicker(t.cfg.Interval)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-tkr.C:
+			if err := t.emit(); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to send tracer: %v\n", err)
+			}
+			if t.cli != nil {
+				if err := t.measure(); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to measure tracers: %v\n", err)
+				}
+				t.expire(false)
+				fmt.Fprintln(os.Stderr, t.Stats())
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *Tracer) emit() (err error) {
+	t.Lock()
+	defer t.Unlock()
+	tr := TracerRecord{
+		Tracer:   t.id,
+		Ingester: t.name,
+		Seq:      t.seq,
+		Sent:     time.Now(),
+	}
+	var b []byte
+	if b, err = json.Marshal(tr); err != nil {
+		return
+	}
+	ent := &entry.Entry{
+		TS:   entry.FromStandard(tr.Sent),
+		Tag:  t.tag,
+		SRC:  t.src,
+		Data: b,
+	}
+	if err = t.conn.WriteEntry(ent); err != nil {
+		return
+	}
+	t.seq++
+	t.stats.Sent++
+	if t.cli != nil {
+		t.pending[tr.Seq] = tr.Sent
+	}
+	return
+}
+
+// measure searches for our outstanding tracers, latency is the time between sending the tracer and
+// seeing it in search so the resolution is bounded by how often we look
+func (t *Tracer) measure() (err error) {
+	t.Lock()
+	var oldest time.Time
+	for _, sent := range t.pending {
+		if oldest.IsZero() || sent.Before(oldest) {
+			oldest = sent
+		}
+	}
+	t.Unlock()
+	if oldest.IsZero() {
+		return
+	}
+	query := fmt.Sprintf("tag=%s grep %s | text", t.cfg.Tag, t.id)
+	var s client.Search
+	if s, err = t.cli.StartSearch(query, oldest.Add(-tracerSearchSlack), time.Now().Add(tracerSearchSlack), true); err != nil {
+		return
+	}
+	defer t.cli.DeleteSearch(s.ID)
+	defer t.cli.DetachSearch(s)
+	if err = t.cli.WaitForSearch(s); err != nil {
+		return
+	}
+	seen := time.Now()
+	var cnt uint64
+	if cnt, _, err = t.cli.GetAvailableEntryCount(s); err != nil || cnt == 0 {
+		return
+	}
+	ents, err := t.cli.GetEntries(s, 0, cnt)
+	if err != nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	for _, ent := range ents {
+		var tr TracerRecord
+		if json.Unmarshal(ent.Data, &tr) != nil || tr.Tracer != t.id {
+			continue
+		}
+		if sent, ok := t.pending[tr.Seq]; ok {
+			delete(t.pending, tr.Seq)
+			t.stats.add(seen.Sub(sent))
+		}
+	}
+	return
+}
+
+// expire counts tracers that have been outstanding longer than the timeout as lost
+func (t *Tracer) expire(all bool) {
+	t.Lock()
+	defer t.Unlock()
+	for seq, sent := range t.pending {
+		if all || time.Since(sent) > t.cfg.Timeout {
+			delete(t.pending, seq)
+			t.stats.Lost++
+		}
+	}
+}
+
+// startTracer fires up a tracer if the config asks for one, the returned function stops it and prints a summary
+func startTracer(conn GeneratorConn, src net.IP, cfg GeneratorConfig) (stop func(), err error) {
+	stop = func() {}
+	if !cfg.Tracer.Enabled() {
+		return
+	}
+	var t *Tracer
+	if t, err = NewTracer(conn, src, cfg.Tracer); err != nil {
+		return
+	} else if err = t.Start(); err != nil {
+		return
+	}
+	stop = func() {
+		if err := t.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to measure tracers: %v\n", err)
+		}
+		fmt.Fprintln(os.Stderr, t.Stats())
+	}
+	return
+}
@@ -0,0 +1,216 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+)
+
+const (
+	maxEntrySize = 8 * 1024 * 1024 // keep the shared payload buffer reasonable
+	payloadSlack = 64 * 1024       // extra payload bytes so entries do not all start at the same offset
+	normalSpread = 6               // normal distributions are clamped to this many deviations above the mean
+
+	payloadChars = `abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 =,:-`
+)
+
+var (
+	ErrInvalidSizeDist = errors.New("invalid entry size distribution")
+	ErrInvalidTagMix   = errors.New("invalid tag mix")
+)
+
+type sizeDistType int
+
+const (
+	sizeFixed sizeDistType = iota
+	sizeUniform
+	sizeNormal
+)
+
+// sizeDist describes how large generated entries are
+type sizeDist struct {
+	typ sizeDistType
+	a   int // fixed size, uniform minimum, or normal mean
+	b   int // uniform maximum or normal standard deviation
+}
+
+// parseSizeDist parses an entry size distribution, the forms are:
+//
+//	N or fixed:N           every entry is N bytes
+//	uniform:MIN-MAX        entry sizes are uniformly distributed between MIN and MAX bytes
+//	normal:MEAN,STDDEV     entry sizes are normally distributed, clamped between 1 and MEAN+6*STDDEV
+func parseSizeDist(v string) (sd sizeDist, err error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	typ, args := `fixed`, v
+	if idx := strings.IndexByte(v, ':'); idx >= 0 {
+		typ, args = v[:idx], v[idx+1:]
+	}
+	switch typ {
+	case `fixed`:
+		sd.typ = sizeFixed
+		if sd.a, err = parseSize(args); err == nil {
+			sd.b = sd.a
+		}
+	case `uniform`:
+		sd.typ = sizeUniform
+		err = parseSizePair(args, `-`, &sd.a, &sd.b)
+		if err == nil && sd.a > sd.b {
+			err = fmt.Errorf("%w: minimum %d is larger than maximum %d", ErrInvalidSizeDist, sd.a, sd.b)
+		}
+	case `normal`:
+		sd.typ = sizeNormal
+		err = parseSizePair(args, `,`, &sd.a, &sd.b)
+	default:
+		err = fmt.Errorf("%w: unknown distribution %q", ErrInvalidSizeDist, typ)
+	}
+	return
+}
+
+func parseSizePair(v, sep string, a, b *int) (err error) {
+	flds := strings.Split(v, sep)
+	if len(flds) != 2 {
+		return fmt.Errorf("%w: %q is not two values separated by %q", ErrInvalidSizeDist, v, sep)
+	}
+	if *a, err = parseSize(flds[0]); err == nil {
+		*b, err = parseSize(flds[1])
+	}
+	return
+}
+
+func parseSize(v string) (n int, err error) {
+	if n, err = strconv.Atoi(strings.TrimSpace(v)); err != nil {
+		err = fmt.Errorf("%w: bad size %q", ErrInvalidSizeDist, v)
+	} else if n <= 0 || n > maxEntrySize {
+		err = fmt.Errorf("%w: size %d is not between 1 and %d", ErrInvalidSizeDist, n, maxEntrySize)
+	}
+	return
+}
+
+// max returns the largest size the distribution can produce
+func (sd sizeDist) max() int {
+	switch sd.typ {
+	case sizeUniform:
+		return sd.b
+	case sizeNormal:
+		if n := sd.a + normalSpread*sd.b; n < maxEntrySize {
+			return n
+		}
+		return maxEntrySize
+	}
+	return sd.a
+}
+
+func (sd sizeDist) size(r *rand.Rand) (n int) {
+	switch sd.typ {
+	case sizeUniform:
+		n = sd.a + r.Intn(sd.b-sd.a+1)
+	case sizeNormal:
+		n = int(math.Round(r.NormFloat64()*float64(sd.b))) + sd.a
+	default:
+		n = sd.a
+	}
+	if n < 1 {
+		n = 1
+	} else if mx := sd.max(); n > mx {
+		n = mx
+	}
+	return
+}
+
+func (sd sizeDist) String() string {
+	switch sd.typ {
+	case sizeUniform:
+		return fmt.Sprintf("uniform %d-%d bytes", sd.a, sd.b)
+	case sizeNormal:
+		return fmt.Sprintf("normal mean %d stddev %d bytes", sd.a, sd.b)
+	}
+	return fmt.Sprintf("fixed %d bytes", sd.a)
+}
+
+type weightedTag struct {
+	name   string
+	weight int
+}
+
+// tagMix picks tags for generated entries according to their weights
+type tagMix struct {
+	tags  []weightedTag
+	total int
+}
+
+// parseTagMix parses a comma separated list of tag:weight pairs, a missing weight is 1
+func parseTagMix(v string) (tm tagMix, err error) {
+	seen := map[string]bool{}
+	for _, fld := range strings.Split(v, ",") {
+		if fld = strings.TrimSpace(fld); fld == `` {
+			continue
+		}
+		wt := weightedTag{name: fld, weight: 1}
+		if idx := strings.LastIndexByte(fld, ':'); idx >= 0 {
+			wt.name = strings.TrimSpace(fld[:idx])
+			if wt.weight, err = strconv.Atoi(strings.TrimSpace(fld[idx+1:])); err != nil || wt.weight <= 0 {
+				err = fmt.Errorf("%w: bad weight in %q", ErrInvalidTagMix, fld)
+				return
+			}
+		}
+		if err = ingest.CheckTag(wt.name); err != nil {
+			err = fmt.Errorf("%w: %q %v", ErrInvalidTagMix, wt.name, err)
+			return
+		} else if seen[wt.name] {
+			err = fmt.Errorf("%w: duplicate tag %q", ErrInvalidTagMix, wt.name)
+			return
+		}
+		seen[wt.name] = true
+		tm.tags = append(tm.tags, wt)
+		tm.total += wt.weight
+	}
+	if len(tm.tags) == 0 {
+		err = fmt.Errorf("%w: no tags", ErrInvalidTagMix)
+	}
+	return
+}
+
+func (tm tagMix) names() (r []string) {
+	for _, wt := range tm.tags {
+		r = append(r, wt.name)
+	}
+	return
+}
+
+// pick returns the index of a tag chosen by weight
+func (tm tagMix) pick(r *rand.Rand) int {
+	if len(tm.tags) == 1 {
+		return 0
+	}
+	v := r.Intn(tm.total)
+	for i, wt := range tm.tags {
+		if v < wt.weight {
+			return i
+		}
+		v -= wt.weight
+	}
+	return len(tm.tags) - 1
+}
+
+// newPayload builds a buffer of printable characters that generated entries are sliced out of,
+// entries never modify their data so they can all share it
+func newPayload(sd sizeDist, r *rand.Rand) []byte {
+	b := make([]byte, sd.max()+payloadSlack)
+	for i := range b {
+		b[i] = payloadChars[r.Intn(len(payloadChars))]
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSizeDist(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	good := []struct {
+		v        string
+		min, max int
+	}{
+		{`512`, 512, 512},
+		{`fixed:64`, 64, 64},
+		{`uniform:10-20`, 10, 20},
+		{`Uniform: 5 - 5`, 5, 5},
+		{`normal:100,10`, 1, 160},
+	}
+	for _, c := range good {
+		sd, err := parseSizeDist(c.v)
+		if err != nil {
+			t.Fatalf("%s: %v", c.v, err)
+		} else if sd.max() != c.max {
+			t.Fatalf("%s: bad max %d != %d", c.v, sd.max(), c.max)
+		}
+		for i := 0; i < 1000; i++ {
+			if n := sd.size(r); n < c.min || n > c.max {
+				t.Fatalf("%s: size %d out of range", c.v, n)
+			}
+		}
+	}
+	for _, v := range []string{``, `0`, `-5`, `fixed:`, `uniform:20-10`, `uniform:10`, `normal:100`, `lognormal:1,2`, `99999999999`} {
+		if _, err := parseSizeDist(v); !errors.Is(err, ErrInvalidSizeDist) {
+			t.Fatalf("%q: expected invalid distribution, got %v", v, err)
+		}
+	}
+}
+
+func TestTagMix(t *testing.T) {
+	tm, err := parseTagMix(`foo:3, bar ,baz:6`)
+	if err != nil {
+		t.Fatal(err)
+	} else if names := tm.names(); len(names) != 3 || names[0] != `foo` || names[1] != `bar` || names[2] != `baz` {
+		t.Fatalf("bad names %v", names)
+	} else if tm.total != 10 {
+		t.Fatalf("bad total weight %d", tm.total)
+	}
+	r := rand.New(rand.NewSource(1))
+	counts := make([]int, 3)
+	for i := 0; i < 100000; i++ {
+		counts[tm.pick(r)]++
+	}
+	//within a few percent of 30/10/60
+	for i, exp := range []int{30000, 10000, 60000} {
+		if d := counts[i] - exp; d > 2000 || d < -2000 {
+			t.Fatalf("bad tag spread %v", counts)
+		}
+	}
+	for _, v := range []string{``, `,`, `foo:0`, `foo:x`, `foo,foo`, `bad tag`, `foo:1:2`} {
+		if _, err := parseTagMix(v); !errors.Is(err, ErrInvalidTagMix) {
+			t.Fatalf("%q: expected invalid tag mix, got %v", v, err)
+		}
+	}
+}
+
+func TestLatencyRecorder(t *testing.T) {
+	lr := newLatencyRecorder(1)
+	if lr.String() != `no samples` {
+		t.Fatalf("bad empty recorder %q", lr.String())
+	}
+	for i := 100; i > 0; i-- {
+		lr.add(time.Duration(i) * time.Millisecond)
+	}
+	o := newLatencyRecorder(2)
+	o.add(time.Second)
+	lr.merge(o)
+	if lr.count != 101 || lr.min != time.Millisecond || lr.max != time.Second {
+		t.Fatalf("bad summary %d %v %v", lr.count, lr.min, lr.max)
+	}
+	p := lr.percentiles([]float64{0, 50, 99, 100})
+	exp := []time.Duration{time.Millisecond, 51 * time.Millisecond, 100 * time.Millisecond, time.Second}
+	for i := range exp {
+		if p[i] != exp[i] {
+			t.Fatalf("bad percentiles %v != %v", p, exp)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/generators/base"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultTag         = `loadgen`
+	defaultSyncTimeout = 10 * time.Second
+	minPaceSleep       = time.Millisecond
+)
+
+var (
+	entrySize   = flag.String("entry-size", "normal:512,128", "Entry size distribution: N, fixed:N, uniform:MIN-MAX, or normal:MEAN,STDDEV")
+	tagMixFlag  = flag.String("tag-mix", "", "Comma separated tag:weight list to spread entries across, blank uses -tag-name")
+	tsSkew      = flag.Duration("ts-skew", 0, "Randomly offset entry timestamps by up to this much in either direction")
+	rate        = flag.Int("rate", 0, "Target entries per second across all writers, 0 is unlimited")
+	runTime     = flag.Duration("run-time", 30*time.Second, "How long to generate load, -entry-count stops the run early if set")
+	batchSize   = flag.Int("batch-size", 1000, "Entries each writer sends between waiting for the indexers to confirm them")
+	writers     = flag.Int("writers", 1, "Number of concurrent writers")
+	syncTimeout = flag.Duration("sync-timeout", defaultSyncTimeout, "How long to wait for the indexers to confirm a batch")
+)
+
+type loadConfig struct {
+	sizes       sizeDist
+	tags        tagMix
+	skew        time.Duration
+	rate        int
+	runTime     time.Duration
+	limit       uint64 // total entries to send, zero is unlimited
+	batch       int
+	writers     int
+	syncTimeout time.Duration
+}
+
+func getLoadConfig(gc base.GeneratorConfig) (lc loadConfig, err error) {
+	if lc.sizes, err = parseSizeDist(*entrySize); err != nil {
+		return
+	}
+	tm := *tagMixFlag
+	if tm == `` {
+		tm = gc.Tag
+	}
+	if lc.tags, err = parseTagMix(tm); err != nil {
+		return
+	}
+	if lc.skew = *tsSkew; lc.skew < 0 {
+		err = errors.New("ts-skew must not be negative")
+		return
+	}
+	if lc.rate = *rate; lc.rate < 0 {
+		err = errors.New("rate must not be negative")
+		return
+	}
+	if lc.runTime = *runTime; lc.runTime <= 0 {
+		err = errors.New("run-time must be positive")
+		return
+	}
+	if lc.batch = *batchSize; lc.batch <= 0 {
+		err = errors.New("batch-size must be positive")
+		return
+	}
+	if lc.writers = *writers; lc.writers <= 0 {
+		err = errors.New("writers must be positive")
+		return
+	}
+	if lc.syncTimeout = *syncTimeout; lc.syncTimeout <= 0 {
+		err = errors.New("sync-timeout must be positive")
+		return
+	}
+	//the entry count always has a default, only honor it if it was asked for
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == `entry-count` {
+			lc.limit = gc.Count
+		}
+	})
+	return
+}
+
+// loadRun is the shared state of a load generation run
+type loadRun struct {
+	lc      loadConfig
+	conn    base.GeneratorConn
+	src     net.IP
+	tags    []entry.EntryTag
+	payload []byte
+	issued  uint64 // entries handed out to writers, used to enforce the entry limit
+	stop    int32
+}
+
+func (lr *loadRun) stopped() bool {
+	return atomic.LoadInt32(&lr.stop) != 0
+}
+
+func (lr *loadRun) halt() {
+	atomic.StoreInt32(&lr.stop, 1)
+}
+
+// next reserves an entry for a writer, returning false when the entry limit has been reached
+func (lr *loadRun) next() bool {
+	if lr.lc.limit == 0 {
+		return true
+	}
+	return atomic.AddUint64(&lr.issued, 1) <= lr.lc.limit
+}
+
+// writerStats are the results of a single writer
+type writerStats struct {
+	count   uint64
+	bytes   uint64
+	tags    []uint64
+	write   *latencyRecorder // time blocked handing each entry to the muxer
+	confirm *latencyRecorder // time from the last write in a batch until the indexers confirmed it
+	err     error
+}
+
+func newWriterStats(ntags int, seed int64) *writerStats {
+	return &writerStats{
+		tags:    make([]uint64, ntags),
+		write:   newLatencyRecorder(seed),
+		confirm: newLatencyRecorder(seed + 1),
+	}
+}
+
+func (ws *writerStats) merge(o *writerStats) {
+	ws.count += o.count
+	ws.bytes += o.bytes
+	for i, v := range o.tags {
+		ws.tags[i] += v
+	}
+	ws.write.merge(o.write)
+	ws.confirm.merge(o.confirm)
+	if ws.err == nil {
+		ws.err = o.err
+	}
+}
+
+func (lr *loadRun) writer(id int, ws *writerStats) {
+	r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(id)))
+	var interval time.Duration
+	if lr.lc.rate > 0 {
+		interval = time.Second * time.Duration(lr.lc.writers) / time.Duration(lr.lc.rate)
+	}
+	start := time.Now()
+	deadline := start.Add(lr.lc.runTime)
+	var sent uint64
+	for {
+		var n int
+		var last time.Time
+		for ; n < lr.lc.batch && !lr.stopped() && time.Now().Before(deadline) && lr.next(); n++ {
+			if interval > 0 {
+				if d := time.Until(start.Add(time.Duration(sent) * interval)); d > minPaceSleep {
+					time.Sleep(d)
+				}
+			}
+			ent := lr.entry(r, ws)
+			ts := time.Now()
+			if ws.err = lr.conn.WriteEntry(ent); ws.err != nil {
+				lr.halt()
+				return
+			}
+			last = time.Now()
+			ws.write.add(last.Sub(ts))
+			ws.count++
+			ws.bytes += uint64(len(ent.Data))
+			sent++
+		}
+		if n == 0 {
+			return
+		}
+		if ws.err = lr.conn.Sync(lr.lc.syncTimeout); ws.err != nil {
+			lr.halt()
+			return
+		}
+		ws.confirm.add(time.Since(last))
+	}
+}
+
+// entry builds the next entry, data is a slice of the shared payload at a random offset
+func (lr *loadRun) entry(r *rand.Rand, ws *writerStats) *entry.Entry {
+	sz := lr.lc.sizes.size(r)
+	off := r.Intn(len(lr.payload) - sz + 1)
+	idx := lr.lc.tags.pick(r)
+	ws.tags[idx]++
+	ts := time.Now()
+	if lr.lc.skew > 0 {
+		ts = ts.Add(time.Duration(r.Int63n(int64(2*lr.lc.skew)+1)) - lr.lc.skew)
+	}
+	return &entry.Entry{
+		TS:   entry.FromStandard(ts),
+		Tag:  lr.tags[idx],
+		SRC:  lr.src,
+		Data: lr.payload[off : off+sz],
+	}
+}
+
+// run fires up the writers and waits for them to finish or for an interrupt
+func (lr *loadRun) run() (ws *writerStats) {
+	ws = newWriterStats(len(lr.tags), time.Now().UnixNano())
+	results := make([]*writerStats, lr.lc.writers)
+	var wg sync.WaitGroup
+	for i := range results {
+		results[i] = newWriterStats(len(lr.tags), time.Now().UnixNano()+int64(i))
+		wg.Add(1)
+		go func(id int, s *writerStats) {
+			defer wg.Done()
+			lr.writer(id, s)
+		}(i, results[i])
+	}
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt)
+	defer signal.Stop(c)
+	select {
+	case <-c:
+		lr.halt()
+		<-done
+	case <-done:
+	}
+	for _, s := range results {
+		ws.merge(s)
+	}
+	return
+}
+
+func main() {
+	gc, err := base.GetGeneratorConfig(defaultTag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lc, err := getLoadConfig(gc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	igst, src, err := base.NewIngestMuxer(`loadgenerator`, ``, gc, time.Second)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lr := &loadRun{
+		lc:      lc,
+		conn:    igst,
+		src:     src,
+		payload: newPayload(lc.sizes, rand.New(rand.NewSource(time.Now().UnixNano()))),
+	}
+	for _, name := range lc.tags.names() {
+		tag, err := igst.NegotiateTag(name)
+		if err != nil {
+			log.Fatalf("Failed to negotiate tag %s: %v", name, err)
+		}
+		lr.tags = append(lr.tags, tag)
+	}
+
+	start := time.Now()
+	ws := lr.run()
+	durr := time.Since(start)
+	if err = igst.Close(); err != nil {
+		log.Fatal("Failed to close ingest muxer ", err)
+	}
+
+	fmt.Printf("Completed in %v (%s)\n", durr, ingest.HumanSize(ws.bytes))
+	fmt.Printf("Total Count: %s\n", ingest.HumanCount(ws.count))
+	fmt.Printf("Entry Rate: %s\n", ingest.HumanEntryRate(ws.count, durr))
+	fmt.Printf("Ingest Rate: %s\n", ingest.HumanRate(ws.bytes, durr))
+	if ws.count > 0 {
+		fmt.Printf("Entry Size: %s, mean %d bytes\n", lc.sizes, ws.bytes/ws.count)
+	}
+	for i, wt := range lc.tags.tags {
+		fmt.Printf("Tag %s: %s\n", wt.name, ingest.HumanCount(ws.tags[i]))
+	}
+	fmt.Printf("Write Latency: %v\n", ws.write)
+	fmt.Printf("Confirm Latency: %v\n", ws.confirm)
+	if ws.err != nil {
+		log.Fatal("Failed to generate load ", ws.err)
+	}
+}
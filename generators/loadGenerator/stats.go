@@ -0,0 +1,118 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	maxLatencySamples = 1000000 // samples kept per recorder, past this we keep a uniform random subset
+)
+
+var reportPercentiles = []float64{50, 90, 99, 99.9}
+
+// latencyRecorder collects latency samples and reports percentiles.  Long runs can produce far
+// more samples than we want to hold, so once full it keeps a uniform reservoir sample while
+// tracking the exact count, minimum, maximum, and mean.
+type latencyRecorder struct {
+	samples []time.Duration
+	count   uint64
+	total   time.Duration
+	min     time.Duration
+	max     time.Duration
+	r       *rand.Rand
+}
+
+func newLatencyRecorder(seed int64) *latencyRecorder {
+	return &latencyRecorder{
+		r: rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (lr *latencyRecorder) add(d time.Duration) {
+	if lr.count == 0 || d < lr.min {
+		lr.min = d
+	}
+	if d > lr.max {
+		lr.max = d
+	}
+	lr.count++
+	lr.total += d
+	if len(lr.samples) < maxLatencySamples {
+		lr.samples = append(lr.samples, d)
+	} else if idx := lr.r.Int63n(int64(lr.count)); idx < maxLatencySamples {
+		lr.samples[idx] = d
+	}
+}
+
+// merge folds another recorder into this one, the combined sample set is trimmed back down if needed
+func (lr *latencyRecorder) merge(o *latencyRecorder) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	if lr.count == 0 || o.min < lr.min {
+		lr.min = o.min
+	}
+	if o.max > lr.max {
+		lr.max = o.max
+	}
+	lr.count += o.count
+	lr.total += o.total
+	lr.samples = append(lr.samples, o.samples...)
+	if len(lr.samples) > maxLatencySamples {
+		lr.r.Shuffle(len(lr.samples), func(i, j int) {
+			lr.samples[i], lr.samples[j] = lr.samples[j], lr.samples[i]
+		})
+		lr.samples = lr.samples[:maxLatencySamples]
+	}
+}
+
+func (lr *latencyRecorder) mean() time.Duration {
+	if lr.count == 0 {
+		return 0
+	}
+	return lr.total / time.Duration(lr.count)
+}
+
+// percentiles returns the requested percentiles using the nearest rank method
+func (lr *latencyRecorder) percentiles(pcts []float64) (r []time.Duration) {
+	r = make([]time.Duration, len(pcts))
+	if len(lr.samples) == 0 {
+		return
+	}
+	sort.Slice(lr.samples, func(i, j int) bool { return lr.samples[i] < lr.samples[j] })
+	for i, p := range pcts {
+		idx := int(p/100*float64(len(lr.samples))+0.5) - 1
+		if idx < 0 {
+			idx = 0
+		} else if idx >= len(lr.samples) {
+			idx = len(lr.samples) - 1
+		}
+		r[i] = lr.samples[idx]
+	}
+	return
+}
+
+func (lr *latencyRecorder) String() string {
+	if lr.count == 0 {
+		return `no samples`
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "count %d min %v mean %v", lr.count, lr.min, lr.mean())
+	for i, v := range lr.percentiles(reportPercentiles) {
+		fmt.Fprintf(&sb, " p%g %v", reportPercentiles[i], v)
+	}
+	fmt.Fprintf(&sb, " max %v", lr.max)
+	return sb.String()
+}
@@ -55,13 +55,16 @@ require (
 	github.com/tealeg/xlsx v1.0.5
 	github.com/turnage/graw v0.0.0-20191104042329-405cc3092119
 	github.com/turnage/redditproto v0.0.0-20151223012412-afedf1b6eddb // indirect
-	github.com/xdg-go/scram v1.1.1 // indirect
+	github.com/ulikunitz/xz v0.5.10
+	github.com/xdg-go/scram v1.1.1
 	golang.org/x/crypto v0.0.0-20201124201722-c8d3bf9c5392 // indirect
 	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4
 	golang.org/x/sys v0.0.0-20220318055525-2edf467146b5
+	golang.org/x/text v0.3.7
 	golang.org/x/time v0.0.0-20200416051211-89c76fbcd5d1
-	google.golang.org/protobuf v1.22.0 // indirect
-	gopkg.in/jcmturner/gokrb5.v7 v7.3.0 // indirect
+	google.golang.org/grpc v1.28.0
+	google.golang.org/protobuf v1.22.0
+	gopkg.in/jcmturner/gokrb5.v7 v7.3.0
 	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
 
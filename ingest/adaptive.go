@@ -0,0 +1,157 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultAdaptiveMinFrac   = 0.1                    // floor as a fraction of the configured rate
+	adaptiveInterval         = time.Second            // minimum time between rate changes
+	adaptiveIncrease         = 0.05                   // additive increase per interval, as a fraction of the configured rate
+	adaptiveThrottleDecrease = 0.5                    // multiplicative decrease when an indexer asks us to back off
+	adaptiveRTTDecrease      = 0.8                    // multiplicative decrease when acks slow down
+	adaptiveRTTSlack         = 100 * time.Millisecond // added to twice the baseline RTT when no Max-RTT is set
+	rttSmoothing             = 0.25                   // weight of each new RTT sample
+)
+
+var (
+	ErrAdaptiveRateNoLimit = errors.New("Adaptive rate limiting requires a rate limit")
+	ErrAdaptiveRateMin     = errors.New("Adaptive rate floor is above the rate limit")
+)
+
+// adaptiveRate scales a rate limiter between a floor and the configured rate based on feedback
+// from indexers.  Throttle requests and ack round trip times above a threshold cut the rate,
+// quiet intervals ramp it back up.  Every connection in a muxer reports to the same controller
+// because they share the uplink.
+type adaptiveRate struct {
+	sync.Mutex
+	lm      *rate.Limiter
+	base    rate.Limit // the configured rate
+	minFrac float64
+	maxRTT  time.Duration // zero means derive the threshold from the baseline
+	frac    float64
+	srtt    time.Duration // smoothed ack round trip time
+	minRTT  time.Duration // lowest ack round trip time seen
+	last    time.Time     // last rate change
+	now     func() time.Time
+}
+
+func newAdaptiveRate(p *parent, bps int64, ar config.AdaptiveRateConfig) (*adaptiveRate, error) {
+	if !ar.Enabled {
+		return nil, nil
+	} else if p == nil || bps <= 0 {
+		return nil, ErrAdaptiveRateNoLimit
+	}
+	a := &adaptiveRate{
+		lm:      p.lm,
+		base:    p.lm.Limit(),
+		minFrac: defaultAdaptiveMinFrac,
+		maxRTT:  ar.MaxRTT,
+		frac:    1,
+		now:     time.Now,
+	}
+	if ar.MinBps > 0 {
+		if ar.MinBps > bps {
+			return nil, ErrAdaptiveRateMin
+		}
+		a.minFrac = float64(ar.MinBps) / float64(bps)
+	}
+	return a, nil
+}
+
+// throttled is called when an indexer asks the ingester to back off
+func (a *adaptiveRate) throttled() {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	a.decrease(adaptiveThrottleDecrease)
+	a.Unlock()
+}
+
+// rtt is called with the time it took an indexer to confirm an entry
+func (a *adaptiveRate) rtt(d time.Duration) {
+	if a == nil || d <= 0 {
+		return
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.srtt == 0 {
+		a.srtt = d
+	} else {
+		a.srtt += time.Duration(rttSmoothing * float64(d-a.srtt))
+	}
+	if a.minRTT == 0 || d < a.minRTT {
+		a.minRTT = d
+	}
+	if a.srtt > a.threshold() {
+		a.decrease(adaptiveRTTDecrease)
+	} else {
+		a.increase()
+	}
+}
+
+func (a *adaptiveRate) threshold() time.Duration {
+	if a.maxRTT > 0 {
+		return a.maxRTT
+	}
+	return 2*a.minRTT + adaptiveRTTSlack
+}
+
+// decrease cuts the rate, only once per interval so a burst of signals doesn't collapse it.
+// Caller must hold the lock.
+func (a *adaptiveRate) decrease(mult float64) {
+	now := a.now()
+	if now.Sub(a.last) < adaptiveInterval {
+		return
+	}
+	a.set(a.frac*mult, now)
+}
+
+// increase ramps the rate back up after a quiet interval.  Caller must hold the lock.
+func (a *adaptiveRate) increase() {
+	now := a.now()
+	if a.frac >= 1 || now.Sub(a.last) < adaptiveInterval {
+		return
+	}
+	a.set(a.frac+adaptiveIncrease, now)
+}
+
+func (a *adaptiveRate) set(frac float64, now time.Time) {
+	if frac < a.minFrac {
+		frac = a.minFrac
+	} else if frac > 1 {
+		frac = 1
+	}
+	a.frac, a.last = frac, now
+	a.lm.SetLimitAt(now, a.base*rate.Limit(frac))
+}
+
+// fraction returns the current rate as a fraction of the configured rate
+func (a *adaptiveRate) fraction() float64 {
+	if a == nil {
+		return 1
+	}
+	a.Lock()
+	defer a.Unlock()
+	return a.frac
+}
+
+// AdaptiveRate returns the current rate limit as a fraction of the configured rate limit, it is
+// always 1 unless adaptive rate limiting is enabled.
+func (im *IngestMuxer) AdaptiveRate() float64 {
+	return im.adaptive.fraction()
+}
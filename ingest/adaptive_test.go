@@ -0,0 +1,89 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+)
+
+func TestAdaptiveRate(t *testing.T) {
+	const bps = 8 * 1024 * 1024
+	if a, err := newAdaptiveRate(nil, 0, config.AdaptiveRateConfig{}); err != nil || a != nil {
+		t.Fatalf("disabled controller built: %v %v", a, err)
+	} else if _, err = newAdaptiveRate(nil, 0, config.AdaptiveRateConfig{Enabled: true}); err != ErrAdaptiveRateNoLimit {
+		t.Fatalf("failed to catch missing limit: %v", err)
+	} else if _, err = newAdaptiveRate(newParent(bps, 0), bps, config.AdaptiveRateConfig{Enabled: true, MinBps: 2 * bps}); err != ErrAdaptiveRateMin {
+		t.Fatalf("failed to catch bad floor: %v", err)
+	}
+
+	p := newParent(bps, 0)
+	a, err := newAdaptiveRate(p, bps, config.AdaptiveRateConfig{Enabled: true, MinBps: bps / 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	a.now = func() time.Time { return now }
+	check := func(frac float64) {
+		t.Helper()
+		if math.Abs(a.fraction()-frac) > 0.001 {
+			t.Fatalf("bad fraction %f != %f", a.fraction(), frac)
+		} else if lm := p.lm.Limit(); math.Abs(float64(lm)-frac*bps) > 1 {
+			t.Fatalf("bad limit %f != %f", float64(lm), frac*bps)
+		}
+	}
+
+	//establish a baseline round trip time, nothing changes while we are at full rate
+	for i := 0; i < 10; i++ {
+		a.rtt(10 * time.Millisecond)
+	}
+	check(1)
+
+	//throttle requests halve the rate, but only once per interval
+	a.throttled()
+	a.throttled()
+	check(0.5)
+	now = now.Add(adaptiveInterval)
+	a.throttled()
+	check(0.25)
+	now = now.Add(adaptiveInterval)
+	a.throttled()
+	check(0.25) //floor
+
+	//good round trip times ramp back up one step per interval
+	now = now.Add(adaptiveInterval)
+	a.rtt(10 * time.Millisecond)
+	a.rtt(10 * time.Millisecond)
+	check(0.30)
+
+	//round trip times well over the baseline back off
+	for i := 0; i < 10; i++ {
+		a.rtt(time.Second)
+	}
+	check(0.30)
+	now = now.Add(adaptiveInterval)
+	a.rtt(time.Second)
+	check(0.25) //0.24 is clamped to the floor
+
+	//an explicit threshold overrides the baseline
+	a.maxRTT = 2 * time.Second
+	now = now.Add(adaptiveInterval)
+	a.rtt(time.Second)
+	check(0.30)
+
+	var nilA *adaptiveRate
+	nilA.throttled()
+	nilA.rtt(time.Second)
+	if nilA.fraction() != 1 {
+		t.Fatal("nil controller should report full rate")
+	}
+}
@@ -19,26 +19,31 @@ import (
 	"time"
 
 	"github.com/crewjam/rfc5424"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 )
 
 const (
 	//MAJOR API VERSIONS should always be compatible, there just may be additional features
 	API_VERSION_MAJOR uint32 = 0
-	API_VERSION_MINOR uint32 = 6
+	API_VERSION_MINOR uint32 = 7
 )
 
 const (
-	configurationBlockSize          uint32          = 1
+	configurationBlockSize          uint32          = 2
 	maxStreamConfigurationBlockSize uint32          = 1024 * 1024 //just a sanity check
 	maxIngestStateSize              uint32          = 1024 * 1024
+	maxTagHintSize                  uint32          = 1024 * 1024
 	CompressNone                    CompressionType = 0
 	CompressSnappy                  CompressionType = 0x10
+
+	streamFlagChecksum byte = 0x1 // entries carry a CRC32C trailer
 )
 
 var (
 	ErrInvalidBuffer            = errors.New("invalid buffer")
 	ErrInvalidIngestStateHeader = errors.New("Invalid ingest state header")
 	ErrInvalidConfigBlock       = errors.New("Invalid configuration block size")
+	ErrInvalidTagHintHeader     = errors.New("Invalid tag hint header")
 )
 
 type CompressionType uint8
@@ -67,6 +72,7 @@ type Logger interface {
 // StreamConfiguration is a structure that can be sent back and
 type StreamConfiguration struct {
 	Compression CompressionType
+	Checksum    bool // per-entry checksums, peers that predate checksums never echo this back
 }
 
 func (c StreamConfiguration) Write(wtr io.Writer) (err error) {
@@ -109,11 +115,14 @@ func (c *StreamConfiguration) Read(rdr io.Reader) (err error) {
 }
 
 func (c StreamConfiguration) encode(buff []byte) (err error) {
-	if len(buff) == 0 {
+	if len(buff) < int(configurationBlockSize) {
 		err = ErrInvalidBuffer
 		return
 	}
 	buff[0] = byte(c.Compression)
+	if c.Checksum {
+		buff[1] |= streamFlagChecksum
+	}
 	return
 }
 
@@ -123,6 +132,10 @@ func (c *StreamConfiguration) decode(buff []byte) (err error) {
 		return
 	}
 	c.Compression = CompressionType(buff[0])
+	//older peers only send the compression byte, unknown flags are ignored so newer peers can negotiate down
+	if len(buff) > 1 {
+		c.Checksum = buff[1]&streamFlagChecksum != 0
+	}
 
 	err = c.validate()
 	return
@@ -170,11 +183,25 @@ type IngesterState struct {
 	Tags          []string      // The tags registered with the ingester
 	CacheState    string
 	CacheSize     uint64
+	ChecksumErrs  uint64           `json:",omitempty"` // entries indexers reported as corrupted in transit
+	Latency       *IngestLatency   `json:",omitempty"` // indexer acknowledgment latency, only set when latency markers are enabled
+	Listeners     []ListenerStatus `json:",omitempty"` // accept health of the ingester's listeners, only set by ingesters that report it
 	Children      map[string]IngesterState
 	Configuration json.RawMessage `json:",omitempty"`
 	Metadata      json.RawMessage `json:",omitempty"`
 }
 
+// ListenerStatus is the accept health of a single network listener on an ingester
+type ListenerStatus struct {
+	Listener            string
+	Failing             bool
+	Fatal               bool   `json:",omitempty"` // the current failures are not a known temporary condition
+	ConsecutiveFailures uint64 `json:",omitempty"`
+	TotalFailures       uint64 `json:",omitempty"`
+	LastError           string `json:",omitempty"`
+	LastErrorTime       time.Time
+}
+
 func (s *IngesterState) Write(wtr io.Writer) (err error) {
 	// First, encode to JSON
 	var data []byte
@@ -231,6 +258,51 @@ func (s *IngesterState) Read(rdr io.Reader) (err error) {
 	return
 }
 
+// writeTagHints encodes a set of tag hints as a 32bit size and a JSON block
+func writeTagHints(wtr io.Writer, hints []config.TagHint) (err error) {
+	var data []byte
+	if data, err = json.Marshal(hints); err != nil {
+		return
+	} else if len(data) > int(maxTagHintSize) || len(data) == 0 {
+		return ErrInvalidTagHintHeader
+	}
+	buff := make([]byte, 4+len(data))
+	binary.LittleEndian.PutUint32(buff, uint32(len(data)))
+	copy(buff[4:], data)
+	var n int
+	if n, err = wtr.Write(buff); err == nil && n != len(buff) {
+		err = errors.New("Failed to write encoded tag hints")
+	}
+	return
+}
+
+// readTagHints decodes a set of tag hints, hints which fail validation are skipped
+func readTagHints(rdr io.Reader) (hints []config.TagHint, err error) {
+	var bsz uint32
+	if err = binary.Read(rdr, binary.LittleEndian, &bsz); err != nil {
+		return
+	} else if bsz > maxTagHintSize || bsz == 0 {
+		err = ErrInvalidTagHintHeader
+		return
+	}
+	buff := make([]byte, bsz)
+	if _, err = io.ReadFull(rdr, buff); err != nil {
+		return
+	}
+	var raw []config.TagHint
+	if err = json.Unmarshal(buff, &raw); err != nil {
+		//a bad hint block is not fatal, the stream is still in sync
+		err = nil
+		return
+	}
+	for _, th := range raw {
+		if CheckTag(th.Tag) == nil && th.Validate() == nil {
+			hints = append(hints, th)
+		}
+	}
+	return
+}
+
 // Copy creates a deep copy of the ingester state, this is important when handing the data type off to a gob encoder
 // if the server updates the ingester state when it is attempting to encode a state blob we could get a race
 // where the internal map is updated while we are attempting to encode it, this would cause fault
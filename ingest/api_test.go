@@ -12,7 +12,9 @@ import (
 	"bytes"
 	"encoding/binary"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestStreamConfigurationEncodeDecode(t *testing.T) {
@@ -82,6 +84,37 @@ func TestStreamConfiguration(t *testing.T) {
 	}
 }
 
+func TestStreamConfigurationChecksum(t *testing.T) {
+	bb := bytes.NewBuffer(make([]byte, 0, 64))
+	x := StreamConfiguration{
+		Compression: CompressSnappy,
+		Checksum:    true,
+	}
+	var y StreamConfiguration
+	if err := x.Write(bb); err != nil {
+		t.Fatal(err)
+	} else if err = y.Read(bb); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(x, y) {
+		t.Fatalf("ReadWrite failure: %+v != %+v\n", x, y)
+	}
+
+	//peers that predate checksums only send the compression byte
+	var z StreamConfiguration
+	if err := z.decode([]byte{byte(CompressSnappy)}); err != nil {
+		t.Fatal(err)
+	} else if z.Checksum {
+		t.Fatal("checksum enabled by a short configuration block")
+	}
+
+	//unknown flags are ignored
+	if err := z.decode([]byte{byte(CompressNone), 0xfe}); err != nil {
+		t.Fatal(err)
+	} else if z.Checksum {
+		t.Fatal("checksum enabled by an unknown flag")
+	}
+}
+
 func TestIngestState(t *testing.T) {
 	bb := bytes.NewBuffer(make([]byte, 0, 64))
 	x := IngesterState{
@@ -98,3 +131,38 @@ func TestIngestState(t *testing.T) {
 		t.Fatalf("ReadWrite failure: %+v != %+v\n", x, y)
 	}
 }
+
+func TestIngestStateListeners(t *testing.T) {
+	im := &IngestMuxer{mtx: &sync.RWMutex{}}
+	if err := im.SetMetadata(map[string]string{`foo`: `bar`}); err != nil {
+		t.Fatal(err)
+	}
+	st := []ListenerStatus{
+		{Listener: `syslog/tcp`},
+		{Listener: `json/bulk`, Failing: true, ConsecutiveFailures: 3, TotalFailures: 5, LastError: `too many open files`, LastErrorTime: time.Unix(1600000000, 0).UTC()},
+	}
+	im.SetListenerStatus(st)
+	st[0].Failing = true // the muxer must hold its own copy
+
+	x := im.stateSnapshot()
+	if string(x.Metadata) != `{"foo":"bar"}` {
+		t.Fatalf("listener status replaced the metadata: %s", x.Metadata)
+	} else if len(x.Listeners) != 2 || x.Listeners[0].Failing {
+		t.Fatalf("bad listener status: %+v", x.Listeners)
+	}
+
+	bb := bytes.NewBuffer(nil)
+	var y IngesterState
+	if err := x.Write(bb); err != nil {
+		t.Fatal(err)
+	} else if err = y.Read(bb); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(x.Listeners, y.Listeners) {
+		t.Fatalf("ReadWrite failure: %+v != %+v\n", x.Listeners, y.Listeners)
+	}
+
+	im.SetListenerStatus(nil)
+	if x = im.stateSnapshot(); x.Listeners != nil {
+		t.Fatalf("listener status not cleared: %+v", x.Listeners)
+	}
+}
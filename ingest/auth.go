@@ -26,8 +26,9 @@ import (
 const (
 	// The number of times to hash the shared secret
 	HASH_ITERATIONS uint16 = 16
-	// Auth protocol version number
-	VERSION uint16 = 0x7
+	// Auth protocol version number.  0x8 adds the TAG_HINT command, both ends must support it
+	// before hints are exchanged.
+	VERSION uint16 = 0x8
 	// Authenticated, but not ready for ingest
 	STATE_AUTHENTICATED uint32 = 0xBEEF42
 	// Not authenticated
@@ -40,6 +41,11 @@ const (
 	MaxTenantNameLength  uint16 = 512 //maximum length of a tenant name in bytes
 	SystemTenant         string = ``  // blank string, basically the root/system/infrastructure user
 
+	// Minimum auth version supporting Kerberos tickets in place of the shared secret.  This is an
+	// experimental extension, only the IngestServer in this package offers it today.
+	MinKerberosAuthVersion uint16 = 0x9
+	MaxKerberosTokenLength uint32 = 64 * 1024 //tickets carrying a large PAC can get big
+
 	// Max length for a state response message
 	maxStateResponseLen uint16 = 4096
 	// Maximum size of a message requesting tags from ingester
@@ -62,6 +68,8 @@ var (
 	ErrInvalidTenantName       = errors.New("auth tenant name is invalid")
 	ErrNilChallengeResponse    = errors.New("Got a nil challenge response")
 	ErrTenantAuthUnsupported   = errors.New("authentication endpoint does not support tenants")
+	ErrKerberosAuthUnsupported = errors.New("authentication endpoint does not support Kerberos")
+	ErrInvalidKerberosToken    = errors.New("auth Kerberos token is invalid")
 
 	prng        *rand.Rand
 	prngCounter int
@@ -73,6 +81,12 @@ var tenantAuthHeader = [32]byte{
 	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
 	0x74, 0x65, 0x6e, 0x61, 0x6e, 0x74, 0x30, 0x31}
 
+var kerberosAuthHeader = [32]byte{
+	0x67, 0x72, 0x61, 0x76, 0x77, 0x65, 0x6c, 0x6c,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0,
+	0x6b, 0x72, 0x62, 0x35, 0x61, 0x70, 0x30, 0x31}
+
 // AuthHash represents a hashed shared secret.
 type AuthHash [16]byte
 
@@ -90,11 +104,13 @@ type Challenge struct {
 }
 
 // ChallengeResponse is the resulting hash sent back as part of
-// the challenge/response process.
+// the challenge/response process.  Ingesters authenticating with Kerberos
+// send a Kerberos AP-REQ bound to the challenge instead of the hash.
 type ChallengeResponse struct {
 	Response [32]byte
 	Version  uint16
 	Tenant   string
+	Kerberos []byte // experimental, only sent to servers offering MinKerberosAuthVersion
 }
 
 // TagRequest is used to request tags for the ingester
@@ -260,7 +276,9 @@ func (cr *ChallengeResponse) Read(r io.Reader) error {
 	} else if n != len(cr.Response) {
 		return ErrShortRead
 	}
-	if buff != tenantAuthHeader {
+	if buff == kerberosAuthHeader {
+		return cr.readKerberosResponse(r)
+	} else if buff != tenantAuthHeader {
 		cr.Response = buff
 		return nil
 	}
@@ -299,9 +317,31 @@ func (cr *ChallengeResponse) readTenantResponse(r io.Reader) error {
 	return nil
 }
 
+func (cr *ChallengeResponse) readKerberosResponse(r io.Reader) error {
+	//version, tenant length, tenant, token length, token
+	if err := cr.readTenantResponse(r); err != nil {
+		return err
+	} else if cr.Version < MinKerberosAuthVersion {
+		return ErrInvalidAuthVersion
+	}
+	var length uint32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return err
+	} else if length == 0 || length > MaxKerberosTokenLength {
+		return ErrInvalidKerberosToken
+	}
+	cr.Kerberos = make([]byte, length)
+	if _, err := io.ReadFull(r, cr.Kerberos); err != nil {
+		return err
+	}
+	return nil
+}
+
 // Write the challenge response to the writer
 func (cr *ChallengeResponse) Write(w io.Writer) error {
-	if cr.Version < MinTenantAuthVersion || len(cr.Tenant) == 0 {
+	if len(cr.Kerberos) > 0 {
+		return cr.writeKerberosAuth(w)
+	} else if cr.Version < MinTenantAuthVersion || len(cr.Tenant) == 0 {
 		return cr.writeNonTenantAuth(w)
 	}
 	return cr.writeTenantAuth(w)
@@ -348,6 +388,42 @@ func (cr *ChallengeResponse) writeTenantAuth(w io.Writer) error {
 	return writeString(w, cr.Tenant)
 }
 
+func (cr *ChallengeResponse) writeKerberosAuth(w io.Writer) error {
+	if len(cr.Tenant) > int(MaxTenantNameLength) {
+		return ErrInvalidTenantName
+	} else if cr.Version < MinKerberosAuthVersion {
+		return ErrInvalidAuthVersion
+	} else if len(cr.Kerberos) > int(MaxKerberosTokenLength) {
+		return ErrInvalidKerberosToken
+	}
+
+	// write header
+	if n, err := w.Write(kerberosAuthHeader[:]); err != nil {
+		return err
+	} else if n != len(kerberosAuthHeader) {
+		return ErrShortWrite
+	}
+
+	// then version, tenant length, and tenant name
+	if err := binary.Write(w, binary.LittleEndian, cr.Version); err != nil {
+		return err
+	} else if err = binary.Write(w, binary.LittleEndian, uint16(len(cr.Tenant))); err != nil {
+		return err
+	} else if err = writeString(w, cr.Tenant); err != nil {
+		return err
+	}
+
+	// then the token length and token
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(cr.Kerberos))); err != nil {
+		return err
+	} else if n, err := w.Write(cr.Kerberos); err != nil {
+		return err
+	} else if n != len(cr.Kerberos) {
+		return ErrShortWrite
+	}
+	return nil
+}
+
 func writeString(w io.Writer, v string) error {
 	l := len(v)
 	if n, err := w.Write([]byte(v)); err != nil {
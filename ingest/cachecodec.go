@@ -0,0 +1,165 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+
+	"github.com/gravwell/gravwell/v3/chancacher"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	entryCacheCodecName = `entry`
+
+	cacheEntry    byte = 1
+	cacheBlock    byte = 2
+	cacheSeqEntry byte = 3 // entries carrying a sequence number
+	cacheSeqBlock byte = 4
+)
+
+var (
+	errUnsupportedCacheValue = errors.New("unsupported cache value")
+)
+
+func init() {
+	if err := chancacher.RegisterCodec(entryCacheCodec{}); err != nil {
+		panic(err)
+	}
+	//caches are written with gob until a codec is picked, the types have to be known before
+	//any cache is opened or values written to it are silently lost
+	gob.Register(&entry.Entry{})
+	gob.Register([]*entry.Entry{})
+}
+
+// entryCacheCodec writes entries to the disk cache in the same form they go over the wire,
+// which avoids the gob type and field overhead on every entry.  Sequence numbers are
+// written ahead of the entries that have them.
+type entryCacheCodec struct{}
+
+func (entryCacheCodec) Name() string {
+	return entryCacheCodecName
+}
+
+// Supports reports whether v is an entry or block of entries, entries without data
+// can't be represented on the wire and are left to gob
+func (entryCacheCodec) Supports(v interface{}) bool {
+	switch t := v.(type) {
+	case *entry.Entry:
+		return t != nil && len(t.Data) > 0
+	case []*entry.Entry:
+		for _, ent := range t {
+			if ent == nil || len(ent.Data) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (entryCacheCodec) Encode(w io.Writer, v interface{}) (err error) {
+	switch t := v.(type) {
+	case *entry.Entry:
+		if t.Seq == 0 {
+			_, err = w.Write([]byte{cacheEntry})
+		} else {
+			hdr := make([]byte, 9)
+			hdr[0] = cacheSeqEntry
+			binary.LittleEndian.PutUint64(hdr[1:], t.Seq)
+			_, err = w.Write(hdr)
+		}
+		if err == nil {
+			err = t.EncodeWriter(w)
+		}
+	case []*entry.Entry:
+		hdr := make([]byte, 5)
+		hdr[0] = cacheBlock
+		seqs := hasSeqs(t)
+		if seqs {
+			hdr[0] = cacheSeqBlock
+		}
+		binary.LittleEndian.PutUint32(hdr[1:], uint32(len(t)))
+		if _, err = w.Write(hdr); err != nil {
+			return
+		}
+		var seq [8]byte
+		for _, ent := range t {
+			if seqs {
+				binary.LittleEndian.PutUint64(seq[:], ent.Seq)
+				if _, err = w.Write(seq[:]); err != nil {
+					return
+				}
+			}
+			if err = ent.EncodeWriter(w); err != nil {
+				return
+			}
+		}
+	default:
+		err = errUnsupportedCacheValue
+	}
+	return
+}
+
+func (entryCacheCodec) Decode(r *bufio.Reader) (v interface{}, err error) {
+	var typ byte
+	if typ, err = r.ReadByte(); err != nil {
+		return
+	}
+	seqs := typ == cacheSeqEntry || typ == cacheSeqBlock
+	switch typ {
+	case cacheEntry, cacheSeqEntry:
+		ent := &entry.Entry{}
+		if seqs {
+			if err = binary.Read(r, binary.LittleEndian, &ent.Seq); err != nil {
+				return
+			}
+		}
+		if err = ent.DecodeReader(r); err == nil {
+			v = ent
+		}
+	case cacheBlock, cacheSeqBlock:
+		var cnt uint32
+		if err = binary.Read(r, binary.LittleEndian, &cnt); err != nil {
+			return
+		} else if cnt > entry.MaxSliceCount {
+			err = entry.ErrSliceLenTooLarge
+			return
+		}
+		ents := make([]*entry.Entry, cnt)
+		for i := range ents {
+			ents[i] = &entry.Entry{}
+			if seqs {
+				if err = binary.Read(r, binary.LittleEndian, &ents[i].Seq); err != nil {
+					return
+				}
+			}
+			if err = ents[i].DecodeReader(r); err != nil {
+				return
+			}
+		}
+		v = ents
+	default:
+		err = errUnsupportedCacheValue
+	}
+	return
+}
+
+func hasSeqs(ents []*entry.Entry) bool {
+	for _, ent := range ents {
+		if ent.Seq != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"net"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestEntryCacheCodec(t *testing.T) {
+	var cd entryCacheCodec
+	ent := &entry.Entry{
+		TS:   entry.Now(),
+		SRC:  net.ParseIP("192.168.1.1"),
+		Tag:  3,
+		Data: []byte("hello"),
+	}
+	blk := []*entry.Entry{ent, ent}
+	if cd.Supports(&entry.Entry{}) || cd.Supports([]*entry.Entry{ent, {}}) || cd.Supports(`foo`) {
+		t.Fatal("codec claims to support unencodable values")
+	} else if !cd.Supports(ent) || !cd.Supports(blk) {
+		t.Fatal("codec does not support entries")
+	}
+
+	var bb bytes.Buffer
+	if err := cd.Encode(&bb, ent); err != nil {
+		t.Fatal(err)
+	} else if err = cd.Encode(&bb, blk); err != nil {
+		t.Fatal(err)
+	}
+	sz := bb.Len()
+
+	br := bufio.NewReader(&bb)
+	if v, err := cd.Decode(br); err != nil {
+		t.Fatal(err)
+	} else if got := v.(*entry.Entry); got.Tag != ent.Tag || !got.SRC.Equal(ent.SRC) || got.TS != ent.TS || string(got.Data) != `hello` {
+		t.Fatalf("bad entry: %+v", got)
+	}
+	if v, err := cd.Decode(br); err != nil {
+		t.Fatal(err)
+	} else if got := v.([]*entry.Entry); len(got) != 2 || string(got[1].Data) != `hello` {
+		t.Fatalf("bad block: %+v", got)
+	}
+
+	//the whole point is to be smaller than gob
+	var gb bytes.Buffer
+	gob.Register(&entry.Entry{})
+	gob.Register([]*entry.Entry{})
+	enc := gob.NewEncoder(&gb)
+	for _, v := range []interface{}{ent, blk} {
+		if err := enc.Encode(&v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sz >= gb.Len() {
+		t.Fatalf("codec is larger than gob: %d >= %d", sz, gb.Len())
+	}
+}
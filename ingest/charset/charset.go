@@ -0,0 +1,161 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package charset transcodes data in other character encodings to UTF-8 so ingesters can
+// hand entries to timestamp extraction and the indexers as UTF-8 regardless of the source.
+//
+// Supported charsets are UTF-8, UTF-16 (little endian unless a byte order mark says otherwise),
+// UTF-16LE, UTF-16BE, Latin-1 (ISO 8859-1), and Shift-JIS.  The UTF-16 charsets honor a byte
+// order mark at the start of the data and drop it.
+package charset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+var (
+	ErrUnknownCharset = errors.New("unknown charset")
+
+	bomLE = []byte{0xff, 0xfe}
+	bomBE = []byte{0xfe, 0xff}
+)
+
+type kind int
+
+const (
+	kindUTF8 kind = iota
+	kindUTF16
+	kindLatin1
+	kindShiftJIS
+)
+
+// Charset is a character encoding, the zero value is UTF-8 which is passed through untouched
+type Charset struct {
+	kind      kind
+	bigEndian bool
+	fixed     bool // UTF-16 endianness was set by a byte order mark, later marks are not honored
+}
+
+// Parse looks up a charset by name, names are case insensitive and an empty name is UTF-8
+func Parse(name string) (c Charset, err error) {
+	switch strings.Replace(strings.ToLower(strings.TrimSpace(name)), `_`, `-`, -1) {
+	case ``, `utf-8`, `utf8`:
+	case `utf-16`, `utf16`, `utf-16le`, `utf16le`:
+		c.kind = kindUTF16
+	case `utf-16be`, `utf16be`:
+		c.kind = kindUTF16
+		c.bigEndian = true
+	case `latin-1`, `latin1`, `iso-8859-1`, `iso8859-1`:
+		c.kind = kindLatin1
+	case `shift-jis`, `shiftjis`, `sjis`:
+		c.kind = kindShiftJIS
+	default:
+		err = fmt.Errorf("%w %q", ErrUnknownCharset, name)
+	}
+	return
+}
+
+// IsUTF8 is true if the charset needs no transcoding
+func (c Charset) IsUTF8() bool {
+	return c.kind == kindUTF8
+}
+
+func (c Charset) String() string {
+	switch c.kind {
+	case kindUTF16:
+		if c.bigEndian {
+			return `utf-16be`
+		}
+		return `utf-16le`
+	case kindLatin1:
+		return `latin-1`
+	case kindShiftJIS:
+		return `shift-jis`
+	}
+	return `utf-8`
+}
+
+// Wide is true for charsets whose code units are two bytes, splitting their data on a single
+// newline byte is not safe
+func (c Charset) Wide() bool {
+	return c.kind == kindUTF16
+}
+
+// Newline returns the encoded form of a newline
+func (c Charset) Newline() []byte {
+	if c.kind != kindUTF16 {
+		return []byte{'\n'}
+	} else if c.bigEndian {
+		return []byte{0, '\n'}
+	}
+	return []byte{'\n', 0}
+}
+
+func (c Charset) encoding() encoding.Encoding {
+	switch c.kind {
+	case kindUTF16:
+		e, bom := unicode.LittleEndian, unicode.UseBOM
+		if c.bigEndian {
+			e = unicode.BigEndian
+		}
+		if c.fixed {
+			bom = unicode.IgnoreBOM
+		}
+		return unicode.UTF16(e, bom)
+	case kindLatin1:
+		return charmap.ISO8859_1
+	case kindShiftJIS:
+		return japanese.ShiftJIS
+	}
+	return encoding.Nop
+}
+
+// Reader returns a reader which transcodes a stream to UTF-8
+func (c Charset) Reader(r io.Reader) io.Reader {
+	if c.IsUTF8() {
+		return r
+	}
+	return transform.NewReader(r, c.encoding().NewDecoder())
+}
+
+// Decode transcodes a self contained chunk of data, such as a datagram or a line, to UTF-8.
+// Invalid sequences are replaced with the Unicode replacement character.
+func (c Charset) Decode(b []byte) ([]byte, error) {
+	if c.IsUTF8() || len(b) == 0 {
+		return b, nil
+	}
+	return c.encoding().NewDecoder().Bytes(b)
+}
+
+// DetectBOM is used by readers which decode data in pieces, such as lines of a file, and cannot
+// rely on the decoder seeing the start of the data.  If b, the start of the data, begins with a
+// UTF-16 byte order mark the returned charset uses the endianness it names, n is the length of
+// the mark.  The returned charset never honors marks itself, so pieces must not include the mark.
+func (c Charset) DetectBOM(b []byte) (r Charset, n int) {
+	r = c
+	if c.kind != kindUTF16 {
+		return
+	}
+	r.fixed = true
+	if bytes.HasPrefix(b, bomLE) {
+		r.bigEndian, n = false, len(bomLE)
+	} else if bytes.HasPrefix(b, bomBE) {
+		r.bigEndian, n = true, len(bomBE)
+	}
+	return
+}
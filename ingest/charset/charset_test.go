@@ -0,0 +1,111 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package charset
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+const testText = "2022-03-01T12:00:00Z café 日本"
+
+func utf16Bytes(s string, bigEndian bool) (b []byte) {
+	for _, r := range s {
+		if bigEndian {
+			b = append(b, byte(r>>8), byte(r))
+		} else {
+			b = append(b, byte(r), byte(r>>8))
+		}
+	}
+	return
+}
+
+func TestParse(t *testing.T) {
+	names := map[string]string{
+		``:           `utf-8`,
+		`UTF-8`:      `utf-8`,
+		`utf-16`:     `utf-16le`,
+		`UTF-16LE`:   `utf-16le`,
+		`utf_16be`:   `utf-16be`,
+		`ISO-8859-1`: `latin-1`,
+		`latin1`:     `latin-1`,
+		`Shift_JIS`:  `shift-jis`,
+		`sjis`:       `shift-jis`,
+	}
+	for n, want := range names {
+		if c, err := Parse(n); err != nil {
+			t.Fatalf("failed to parse %q: %v", n, err)
+		} else if c.String() != want {
+			t.Fatalf("%q parsed as %s, expected %s", n, c, want)
+		}
+	}
+	if _, err := Parse(`ebcdic`); !errors.Is(err, ErrUnknownCharset) {
+		t.Fatalf("bad error on unknown charset: %v", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		out  string
+	}{
+		{`utf-8`, []byte(testText), testText},
+		{`utf-16le`, utf16Bytes(testText, false), testText},
+		{`utf-16be`, utf16Bytes(testText, true), testText},
+		{`utf-16le`, append([]byte{0xfe, 0xff}, utf16Bytes(testText, true)...), testText}, //BOM overrides
+		{`utf-16be`, append([]byte{0xff, 0xfe}, utf16Bytes(testText, false)...), testText},
+		{`latin-1`, []byte("caf\xe9 \xa9"), "café ©"},
+		{`shift-jis`, []byte("\x93\xfa\x96\x7b ok"), "日本 ok"},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := c.Decode(tt.in); err != nil {
+			t.Fatalf("%s decode failed: %v", tt.name, err)
+		} else if string(out) != tt.out {
+			t.Fatalf("%s decoded %q, expected %q", tt.name, out, tt.out)
+		}
+		if out, err := ioutil.ReadAll(c.Reader(bytes.NewReader(tt.in))); err != nil {
+			t.Fatalf("%s read failed: %v", tt.name, err)
+		} else if string(out) != tt.out {
+			t.Fatalf("%s read %q, expected %q", tt.name, out, tt.out)
+		}
+	}
+}
+
+func TestDetectBOM(t *testing.T) {
+	c, _ := Parse(`utf-16`)
+	data := append([]byte{0xfe, 0xff}, utf16Bytes(testText, true)...)
+	fc, n := c.DetectBOM(data)
+	if n != 2 || fc.String() != `utf-16be` {
+		t.Fatalf("bad BOM detection %s %d", fc, n)
+	}
+	if out, err := fc.Decode(data[n:]); err != nil {
+		t.Fatal(err)
+	} else if string(out) != testText {
+		t.Fatalf("decoded %q", out)
+	}
+	//no mark keeps the configured endianness
+	if fc, n = c.DetectBOM(utf16Bytes(testText, false)); n != 0 || fc.String() != `utf-16le` {
+		t.Fatalf("bad detection without a BOM %s %d", fc, n)
+	}
+	//other charsets are left alone
+	l1, _ := Parse(`latin-1`)
+	if fc, n = l1.DetectBOM([]byte{0xff, 0xfe}); n != 0 || fc != l1 {
+		t.Fatalf("latin-1 detected a BOM")
+	}
+	if !bytes.Equal(c.Newline(), []byte{'\n', 0}) || !bytes.Equal(fc.Newline(), []byte{'\n'}) {
+		t.Fatal("bad newlines")
+	}
+}
@@ -0,0 +1,98 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	ErrInvalidAddress    = errors.New("not an IPv4 or IPv6 address")
+	ErrAddressHasPort    = errors.New("must be an address without a port")
+	ErrZoneRequiresIPv6  = errors.New("zones are only valid on IPv6 addresses")
+	ErrEmptyZone         = errors.New("empty zone after %")
+	ErrUnbracketedIPv6   = errors.New("IPv6 addresses with a port must be bracketed, e.g. [fe80::1%eth0]:601")
+	ErrMissingPort       = errors.New("missing port")
+	ErrIPv4OnIPv6Network = errors.New("IPv4 address used with an IPv6 only network")
+)
+
+// ParseIPZone parses an IPv4 or IPv6 address.  IPv6 addresses may be bracketed and may carry
+// a zone, such as fe80::1%eth0, the zone is returned separately.
+func ParseIPZone(v string) (ip net.IP, zone string, err error) {
+	v = strings.TrimSpace(v)
+	if strings.HasPrefix(v, `[`) && strings.HasSuffix(v, `]`) {
+		v = v[1 : len(v)-1]
+	}
+	addr := v
+	if idx := strings.LastIndex(v, `%`); idx >= 0 {
+		addr, zone = v[:idx], v[idx+1:]
+		if zone == `` {
+			err = fmt.Errorf("%q %w", v, ErrEmptyZone)
+			return
+		}
+	}
+	if ip = net.ParseIP(addr); ip == nil {
+		if h, _, lerr := net.SplitHostPort(v); lerr == nil && validIP(h) {
+			err = fmt.Errorf("%q %w", v, ErrAddressHasPort)
+		} else {
+			err = fmt.Errorf("%q is %w", v, ErrInvalidAddress)
+		}
+		return
+	} else if zone != `` && ip.To4() != nil {
+		ip = nil
+		err = fmt.Errorf("%q: %w", v, ErrZoneRequiresIPv6)
+	}
+	return
+}
+
+func validIP(v string) bool {
+	_, _, err := ParseIPZone(v)
+	return err == nil
+}
+
+// ParseSourceOverride parses a Source-Override value.  Entry sources cannot carry an IPv6 zone
+// so a zone is accepted but dropped, an empty value returns a nil IP.
+func ParseSourceOverride(v string) (ip net.IP, err error) {
+	if v = strings.TrimSpace(v); v == `` {
+		return
+	}
+	ip, _, err = ParseIPZone(v)
+	return
+}
+
+// SplitHostPortZone splits a host:port address, such as 10.0.0.1:601 or [fe80::1%eth0]:601,
+// and validates the port.  The host may be empty, a hostname, or an address.  The errors are
+// more descriptive than those of net.SplitHostPort, notably for unbracketed IPv6 addresses.
+func SplitHostPortZone(v string) (host, port string, err error) {
+	if host, port, err = net.SplitHostPort(v); err != nil {
+		if !strings.Contains(v, `[`) && strings.Count(v, `:`) > 1 {
+			err = fmt.Errorf("%q: %w", v, ErrUnbracketedIPv6)
+		} else if !strings.Contains(v, `:`) || strings.HasSuffix(v, `]`) {
+			err = fmt.Errorf("%q: %w", v, ErrMissingPort)
+		} else {
+			err = fmt.Errorf("%q: %v", v, err)
+		}
+		return
+	}
+	if port == `` {
+		err = fmt.Errorf("%q: %w", v, ErrMissingPort)
+		return
+	} else if _, err = net.LookupPort(`tcp`, port); err != nil {
+		err = fmt.Errorf("%q: invalid port %q", v, port)
+		return
+	}
+	//only check the host if it looks like an address, hostnames are left to the resolver
+	if strings.ContainsAny(host, `:%`) {
+		_, _, err = ParseIPZone(host)
+	}
+	return
+}
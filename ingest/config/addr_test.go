@@ -0,0 +1,113 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseIPZone(t *testing.T) {
+	for v, exp := range map[string][2]string{
+		`10.0.0.1`:          {`10.0.0.1`, ``},
+		` fe80::1 `:         {`fe80::1`, ``},
+		`[2001:db8::1]`:     {`2001:db8::1`, ``},
+		`fe80::1%eth0`:      {`fe80::1`, `eth0`},
+		`[fe80::1%25]`:      {`fe80::1`, `25`},
+		`fe80::1%enp0s31f6`: {`fe80::1`, `enp0s31f6`},
+	} {
+		ip, zone, err := ParseIPZone(v)
+		if err != nil {
+			t.Fatalf("%q: %v", v, err)
+		} else if ip.String() != exp[0] || zone != exp[1] {
+			t.Fatalf("bad parse of %q: %v %q", v, ip, zone)
+		}
+	}
+	for v, exp := range map[string]error{
+		`webserver`:          ErrInvalidAddress,
+		`10.0.0.1:601`:       ErrAddressHasPort,
+		`[fe80::1%eth0]:601`: ErrAddressHasPort,
+		`webserver:601`:      ErrInvalidAddress,
+		`10.0.0.1%eth0`:      ErrZoneRequiresIPv6,
+		`fe80::1%`:           ErrEmptyZone,
+	} {
+		if _, _, err := ParseIPZone(v); !errors.Is(err, exp) {
+			t.Fatalf("bad error for %q: %v", v, err)
+		}
+	}
+
+	if ip, err := ParseSourceOverride(`fe80::1%eth0`); err != nil || ip.String() != `fe80::1` {
+		t.Fatalf("bad source override: %v %v", ip, err)
+	} else if ip, err = ParseSourceOverride(``); err != nil || ip != nil {
+		t.Fatalf("bad empty source override: %v %v", ip, err)
+	} else if ip, err = ParseSource(`[fe80::2%eth0]`); err != nil || ip.String() != `fe80::2` {
+		t.Fatalf("bad source: %v %v", ip, err)
+	}
+	ic := IngestConfig{
+		Ingest_Secret:            `secret`,
+		Cleartext_Backend_Target: []string{`[fe80::1%lo]:4023`},
+		Log_File:                 filepath.Join(t.TempDir(), `ingest.log`),
+		Source_Override:          `10.0.0.1%eth0`,
+	}
+	if err := ic.Verify(); !errors.Is(err, ErrZoneRequiresIPv6) {
+		t.Fatalf("bad Source-Override not caught: %v", err)
+	}
+	ic.Source_Override = `fe80::1%eth0`
+	if err := ic.Verify(); err != nil {
+		t.Fatal(err)
+	} else if ip := ic.SourceOverride(); ip.String() != `fe80::1` {
+		t.Fatalf("bad Source-Override %v", ip)
+	}
+}
+
+func TestSplitHostPortZone(t *testing.T) {
+	for v, exp := range map[string][2]string{
+		`0.0.0.0:601`:        {`0.0.0.0`, `601`},
+		`:601`:               {``, `601`},
+		`[::]:601`:           {`::`, `601`},
+		`[fe80::1%eth0]:601`: {`fe80::1%eth0`, `601`},
+		`localhost:syslog`:   {`localhost`, `syslog`},
+	} {
+		host, port, err := SplitHostPortZone(v)
+		if err != nil {
+			t.Fatalf("%q: %v", v, err)
+		} else if host != exp[0] || port != exp[1] {
+			t.Fatalf("bad split of %q: %q %q", v, host, port)
+		}
+	}
+	for v, exp := range map[string]error{
+		`fe80::1:601`:        ErrUnbracketedIPv6,
+		`fe80::1%eth0:601`:   ErrUnbracketedIPv6,
+		`10.0.0.1`:           ErrMissingPort,
+		`[::1]`:              ErrMissingPort,
+		`10.0.0.1:`:          ErrMissingPort,
+		`[10.0.0.1%eth0]:80`: ErrZoneRequiresIPv6,
+	} {
+		if _, _, err := SplitHostPortZone(v); !errors.Is(err, exp) {
+			t.Fatalf("bad error for %q: %v", v, err)
+		}
+	}
+	if _, _, err := SplitHostPortZone(`10.0.0.1:99999`); err == nil {
+		t.Fatal("bad port not caught")
+	}
+
+	for v, exp := range map[string]string{
+		`10.0.0.1`:      `10.0.0.1:4023`,
+		`fe80::1%eth0`:  `[fe80::1%eth0]:4023`,
+		`[2001:db8::1]`: `[2001:db8::1]:4023`,
+		`[::1]:5555`:    `[::1]:5555`,
+		`indexer.local`: `indexer.local:4023`,
+		`10.0.0.1:5555`: `10.0.0.1:5555`,
+	} {
+		if r := AppendDefaultPort(v, 4023); r != exp {
+			t.Fatalf("bad default port on %q: %s", v, r)
+		}
+	}
+}
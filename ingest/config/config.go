@@ -51,6 +51,7 @@ import (
 
 	"github.com/google/go-write"
 	"github.com/google/uuid"
+	"github.com/gravwell/gravwell/v3/ingest/ha"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
@@ -71,6 +72,7 @@ const (
 	envCachePath         string = `GRAVWELL_CACHE_PATH`
 	envMaxCache          string = `GRAVWELL_CACHE_SIZE`
 	envDisableSelfIngest string = `GRAVWELL_DISABLE_SELF_INGEST`
+	envMemoryLimit       string = `GRAVWELL_MEMORY_LIMIT`
 
 	DefaultCleartextPort uint16 = 4023
 	DefaultTLSPort       uint16 = 4024
@@ -97,11 +99,13 @@ var (
 
 type IngestConfig struct {
 	IngestStreamConfig
+	ha.LeaseConfig
 	Ingester_Name              string   `json:",omitempty"`
 	Ingest_Secret              string   `json:"-"` // DO NOT send this when marshalling
 	Connection_Timeout         string   `json:",omitempty"`
 	Verify_Remote_Certificates bool     `json:"-"` //legacy, will be removed
 	Insecure_Skip_TLS_Verify   bool     `json:",omitempty"`
+	FIPS_Mode                  bool     `json:",omitempty"` //restrict TLS and hashing to FIPS approved algorithms
 	Cleartext_Backend_Target   []string `json:",omitempty"`
 	Encrypted_Backend_Target   []string `json:",omitempty"`
 	Pipe_Backend_Target        []string `json:",omitempty"`
@@ -117,10 +121,51 @@ type IngestConfig struct {
 	Max_Ingest_Cache           int      `json:",omitempty"`
 	Log_Source_Override        string   `json:",omitempty"` // override log messages only
 	Label                      string   `json:",omitempty"` //arbitrary label that can be attached to an ingester
+	Time_Format_Directory      string   `json:",omitempty"` //directory of hot-reloaded custom time format definitions
+	Preprocessor_Max_Latency   string   `json:",omitempty"` //per entry preprocessor budget, optional preprocessors are bypassed when it is exceeded
+	Disable_Resource_Limits    bool     `json:",omitempty"` //do not size caches and GOMAXPROCS from cgroup limits
+	Memory_Limit               string   `json:",omitempty"` //memory limit to size caches for, overrides the cgroup limit
+	Max_Procs                  int      `json:",omitempty"` //GOMAXPROCS override
+
+	// optional second group of indexers that selected tags are also, or only, written to
+	Secondary_Cleartext_Backend_Target []string `json:",omitempty"`
+	Secondary_Encrypted_Backend_Target []string `json:",omitempty"`
+	Secondary_Ingest_Secret            string   `json:"-"` // defaults to Ingest-Secret
+	Secondary_Ingest_Cache_Path        string   `json:",omitempty"`
+	Secondary_Max_Ingest_Cache         int      `json:",omitempty"`
+	Secondary_Tag                      []string `json:",omitempty"` // tags or patterns written to both groups
+	Secondary_Only_Tag                 []string `json:",omitempty"` // tags or patterns written only to the secondary group
+
+	// optional OpenTelemetry tracing of sampled entries
+	Trace_Endpoint     string   `json:",omitempty"` // OTLP/HTTP collector URL
+	Trace_Sample_Rate  string   `json:",omitempty"` // fraction of entries traced, 0.01 by default
+	Trace_Service_Name string   `json:",omitempty"` // service.name reported with spans
+	Trace_Header       []string `json:"-"`          // Name: value headers sent to the collector, may hold credentials
 }
 
 type IngestStreamConfig struct {
-	Enable_Compression bool `json:",omitempty"`
+	Enable_Compression      bool     `json:",omitempty"`
+	Enable_Checksums        bool     `json:",omitempty"` // checksum every entry on the wire
+	Tag_Hint                []string `json:",omitempty"` // per-tag storage hints, only sent to indexers that support them
+	Tag_Priority            []string `json:",omitempty"` // per-tag send priority, tag:high|normal|low
+	Tag_Rate_Limit          []string `json:",omitempty"` // per-tag bandwidth, tag:rate[:burst]
+	Destination_Rate_Limit  []string `json:",omitempty"` // per-indexer bandwidth, target,rate[,burst]
+	Adaptive_Rate_Limit     bool     `json:",omitempty"` // back Rate-Limit off when indexers push back, then ramp up again
+	Adaptive_Rate_Min       string   `json:",omitempty"` // floor for the adaptive rate, defaults to a tenth of Rate-Limit
+	Adaptive_Rate_Max_RTT   string   `json:",omitempty"` // ack round trip time treated as congestion
+	Cache_Codec             string   `json:",omitempty"` // disk cache codec policy, gob|recommend|auto
+	Cache_Dedup             bool     `json:",omitempty"` // skip cached entries indexers already acknowledged on replay
+	Latency_Marker_Interval string   `json:",omitempty"` // send a marker entry this often to measure indexer acknowledgment latency
+	Latency_Marker_Tag      string   `json:",omitempty"` // tag for latency markers, defaults to gravwell-latency
+	Destination_CA_File     []string `json:",omitempty"` // per-indexer CA bundle, target,path
+	Destination_SPKI_Pin    []string `json:",omitempty"` // per-indexer public key pins, target,pin[,pin...]
+	SPIFFE_Endpoint_Socket  string   `json:",omitempty"` // Workload API address, defaults to SPIFFE_ENDPOINT_SOCKET
+	SPIFFE_Indexer_ID       []string `json:",omitempty"` // indexer SPIFFE IDs or trust domains, enables SPIFFE mutual TLS
+	Experimental_Kerberos   bool     `json:",omitempty"` // allow Kerberos authentication, indexers must support it
+	Kerberos_Keytab         string   `json:",omitempty"` // keytab for Kerberos authentication in place of Ingest-Secret
+	Kerberos_Principal      string   `json:",omitempty"` // ingester principal, user@REALM
+	Kerberos_Config         string   `json:",omitempty"` // krb5.conf path, defaults to /etc/krb5.conf
+	Kerberos_Service        string   `json:",omitempty"` // indexer service principal name, defaults to gravwell
 }
 
 type TimeFormat struct {
@@ -172,6 +217,9 @@ func (ic *IngestConfig) loadDefaults() error {
 	if err := LoadEnvVar(&ic.Disable_Self_Ingest, envDisableSelfIngest, false); err != nil {
 		return err
 	}
+	if err := LoadEnvVar(&ic.Memory_Limit, envMemoryLimit, nil); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -190,8 +238,9 @@ func (ic *IngestConfig) Verify() error {
 	}
 
 	ic.Log_Level = strings.ToUpper(strings.TrimSpace(ic.Log_Level))
-	if ic.Max_Ingest_Cache == 0 && len(ic.Ingest_Cache_Path) != 0 {
-		ic.Max_Ingest_Cache = CACHE_SIZE_DEFAULT
+	//size unset caches and GOMAXPROCS to fit any container limits
+	if err := ic.applyResourceLimits(); err != nil {
+		return err
 	}
 	if to, err := ic.parseTimeout(); err != nil || to < 0 {
 		if err != nil {
@@ -199,13 +248,21 @@ func (ic *IngestConfig) Verify() error {
 		}
 		return ErrInvalidConnectionTimeout
 	}
-	if len(ic.Ingest_Secret) == 0 {
+	if _, err := ic.parseMaxLatency(); err != nil {
+		return err
+	}
+	if err := ic.verifyKerberos(); err != nil {
+		return err
+	} else if len(ic.Ingest_Secret) == 0 && !ic.KerberosEnabled() {
 		return ErrMissingIngestSecret
 	}
 	//ensure there is at least one target
 	if (len(ic.Cleartext_Backend_Target) + len(ic.Encrypted_Backend_Target) + len(ic.Pipe_Backend_Target)) == 0 {
 		return ErrNoConnections
 	}
+	if err := ic.verifyFIPS(); err != nil {
+		return err
+	}
 
 	//normalize the log level and check it
 	if err := ic.checkLogLevel(); err != nil {
@@ -229,15 +286,11 @@ func (ic *IngestConfig) Verify() error {
 		return errors.New("Log Location is not a directory")
 	}
 
-	if ic.Source_Override != `` {
-		if net.ParseIP(ic.Source_Override) == nil {
-			return errors.New("Failed to parse Source_Override")
-		}
+	if _, err := ParseSourceOverride(ic.Source_Override); err != nil {
+		return fmt.Errorf("Invalid Source-Override: %w", err)
 	}
-	if ic.Log_Source_Override != `` {
-		if net.ParseIP(ic.Log_Source_Override) == nil {
-			return errors.New("Failed to parse Log_Source_Override")
-		}
+	if _, err := ParseSourceOverride(ic.Log_Source_Override); err != nil {
+		return fmt.Errorf("Invalid Log-Source-Override: %w", err)
 	}
 
 	// cache checks and defaults
@@ -248,10 +301,65 @@ func (ic *IngestConfig) Verify() error {
 	default:
 		return errors.New("Cache-Mode must be [always,fail]")
 	}
-	if ic.Cache_Depth == 0 {
-		ic.Cache_Depth = CACHE_DEPTH_DEFAULT
+	// Cache_Depth and the cache size are defaulted by applyResourceLimits
+
+	if _, err := ic.TagHints(); err != nil {
+		return err
+	}
+	if _, err := ic.TagPriorities(); err != nil {
+		return err
+	}
+	if _, err := ic.TagRateLimits(); err != nil {
+		return err
+	}
+	if _, err := ic.DestinationRateLimits(); err != nil {
+		return err
+	}
+	if _, err := ic.DestinationTLS(); err != nil {
+		return err
+	}
+	if err := ic.verifySPIFFE(); err != nil {
+		return err
+	}
+	if _, err := ic.LatencyMarkers(); err != nil {
+		return err
+	}
+	if ar, err := ic.AdaptiveRate(); err != nil {
+		return err
+	} else if ar.Enabled {
+		if ic.Rate_Limit == `` {
+			return ErrAdaptiveRateNoLimit
+		} else if bps, err := ic.RateLimit(); err != nil {
+			return err
+		} else if ar.MinBps > bps {
+			return errors.New("Adaptive-Rate-Min must not be above Rate-Limit")
+		}
+	}
+	switch strings.ToLower(ic.Cache_Codec) {
+	case "", "gob", "recommend", "auto":
+	default:
+		return errors.New("Cache-Codec must be [gob,recommend,auto]")
+	}
+
+	if err := ic.verifySecondary(); err != nil {
+		return err
+	}
+
+	if _, err := ic.TraceConfig(); err != nil {
+		return err
+	}
+
+	if err := ic.LeaseConfig.Validate(); err != nil {
+		return err
+	}
+
+	if ic.Time_Format_Directory != `` {
+		if fi, err := os.Stat(ic.Time_Format_Directory); err != nil {
+			return fmt.Errorf("Invalid Time-Format-Directory: %v", err)
+		} else if !fi.IsDir() {
+			return errors.New("Time-Format-Directory is not a directory")
+		}
 	}
-	// there are no defaults for the cache_size.
 
 	return nil
 }
@@ -292,6 +400,18 @@ func (ic *IngestConfig) Timeout() time.Duration {
 }
 
 // Secret returns the value of the Ingest-Secret parameter, used to authenticate to the indexer.
+// SourceOverride returns the global Source-Override, nil if it is unset or invalid
+func (ic *IngestConfig) SourceOverride() net.IP {
+	ip, _ := ParseSourceOverride(ic.Source_Override)
+	return ip
+}
+
+// LogSourceOverride returns the Log-Source-Override, nil if it is unset or invalid
+func (ic *IngestConfig) LogSourceOverride() net.IP {
+	ip, _ := ParseSourceOverride(ic.Log_Source_Override)
+	return ip
+}
+
 func (ic *IngestConfig) Secret() string {
 	return ic.Ingest_Secret
 }
@@ -331,6 +451,24 @@ func (ic *IngestConfig) parseTimeout() (time.Duration, error) {
 	return time.ParseDuration(tos)
 }
 
+// PreprocessorMaxLatency returns the per entry latency budget for preprocessor sets, zero if unset.
+func (ic *IngestConfig) PreprocessorMaxLatency() time.Duration {
+	d, _ := ic.parseMaxLatency()
+	return d
+}
+
+func (ic *IngestConfig) parseMaxLatency() (d time.Duration, err error) {
+	s := strings.TrimSpace(ic.Preprocessor_Max_Latency)
+	if len(s) == 0 {
+		return
+	} else if d, err = time.ParseDuration(s); err != nil {
+		err = fmt.Errorf("Invalid Preprocessor-Max-Latency %q: %v", s, err)
+	} else if d < 0 {
+		err = fmt.Errorf("Invalid Preprocessor-Max-Latency %q: must not be negative", s)
+	}
+	return
+}
+
 // RateLimit returns the bandwidth limit, in bits per second, which
 // should be applied to the indexer connection.
 func (ic *IngestConfig) RateLimit() (bps int64, err error) {
@@ -428,6 +566,20 @@ func writeFull(w io.Writer, b []byte) error {
 	return nil
 }
 
+// TimeFormatDirectory loads the Time-Format-Directory and starts watching it for changes,
+// if no directory is configured the returned FormatDirectory is nil.  Reload errors are handed to errf.
+func (ic *IngestConfig) TimeFormatDirectory(errf func(error)) (fd *timegrinder.FormatDirectory, err error) {
+	if ic.Time_Format_Directory == `` {
+		return
+	}
+	if fd, err = timegrinder.NewFormatDirectory(ic.Time_Format_Directory); err != nil {
+		return
+	} else if err = fd.Start(timegrinder.DefaultFormatDirectoryPoll, errf); err != nil {
+		fd = nil
+	}
+	return
+}
+
 func (ctf CustomTimeFormat) Validate() (err error) {
 	if len(ctf) == 0 {
 		return
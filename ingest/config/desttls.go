@@ -0,0 +1,165 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	spkiPinPrefix = `sha256/`
+)
+
+var (
+	ErrInvalidDestinationCA  = errors.New("Destination CA must be of the form target,path")
+	ErrInvalidDestinationPin = errors.New("Destination SPKI pin must be of the form target,pin or target,pin,pin,...")
+	ErrInvalidSPKIPin        = errors.New("SPKI pin must be a base64 encoded SHA-256 hash")
+	ErrDuplicateDestination  = errors.New("Duplicate destination")
+	ErrEmptyCABundle         = errors.New("CA bundle does not contain any certificates")
+)
+
+// DestinationTLS holds the certificate verification settings for indexers matching Target.
+// CAFile is a PEM bundle of CAs trusted instead of the system roots.  Pins are SHA-256 hashes
+// of the public key (SubjectPublicKeyInfo) an indexer certificate must carry.
+type DestinationTLS struct {
+	Target string
+	CAFile string
+	Pins   [][]byte
+}
+
+// ParseDestinationCA parses a destination CA bundle specification:
+//
+//	10.0.0.1:4024,/opt/gravwell/etc/indexer-ca.pem
+func ParseDestinationCA(v string) (dt DestinationTLS, err error) {
+	bits := strings.Split(v, destRateSplit)
+	if len(bits) != 2 {
+		err = ErrInvalidDestinationCA
+		return
+	}
+	dt.Target = strings.TrimSpace(bits[0])
+	if dt.CAFile = strings.TrimSpace(bits[1]); dt.Target == `` || dt.CAFile == `` {
+		err = ErrInvalidDestinationCA
+	}
+	return
+}
+
+// ParseDestinationPins parses a destination SPKI pin set, the indexer's certificate must
+// match one of the pins which allows a new key to be pinned ahead of a rotation:
+//
+//	idx.example.com,sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=
+//	tls://10.0.0.1:4024,<current pin>,<next pin>
+func ParseDestinationPins(v string) (dt DestinationTLS, err error) {
+	bits := strings.Split(v, destRateSplit)
+	if len(bits) < 2 {
+		err = ErrInvalidDestinationPin
+		return
+	}
+	if dt.Target = strings.TrimSpace(bits[0]); dt.Target == `` {
+		err = ErrInvalidDestinationPin
+		return
+	}
+	for _, b := range bits[1:] {
+		var pin []byte
+		if pin, err = ParseSPKIPin(b); err != nil {
+			return
+		}
+		dt.Pins = append(dt.Pins, pin)
+	}
+	return
+}
+
+// ParseSPKIPin decodes a base64 SHA-256 public key pin, an optional sha256/ prefix is allowed
+// so pins can be copied from other tools.
+func ParseSPKIPin(v string) ([]byte, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), spkiPinPrefix)
+	pin, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(pin) != sha256.Size {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSPKIPin, v)
+	}
+	return pin, nil
+}
+
+// SPKIPin returns the pin for a certificate's public key in the form Destination-SPKI-Pin takes
+func SPKIPin(cert *x509.Certificate) string {
+	h := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return spkiPinPrefix + base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Matches reports whether the settings apply to a destination address, see DestinationRateLimit.Matches
+func (dt DestinationTLS) Matches(addr string) bool {
+	return matchDestination(dt.Target, addr)
+}
+
+// Verifies reports whether the settings verify an indexer's certificate on their own,
+// which means they can stand in for disabling verification.
+func (dt DestinationTLS) Verifies() bool {
+	return dt.CAFile != `` || len(dt.Pins) > 0
+}
+
+// RootCAs loads the CA bundle, it is nil if no bundle is set
+func (dt DestinationTLS) RootCAs() (*x509.CertPool, error) {
+	if dt.CAFile == `` {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(dt.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	p := x509.NewCertPool()
+	if !p.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyCABundle, dt.CAFile)
+	}
+	return p, nil
+}
+
+// DestinationTLS merges the Destination-CA-File and Destination-SPKI-Pin specifications into
+// per target settings, CA bundles are loaded to make sure they are usable.
+func (isc IngestStreamConfig) DestinationTLS() (dts []DestinationTLS, err error) {
+	idx := map[string]int{}
+	for _, v := range isc.Destination_CA_File {
+		var dt DestinationTLS
+		if dt, err = ParseDestinationCA(v); err != nil {
+			err = fmt.Errorf("Invalid Destination-CA-File %q: %w", v, err)
+			return
+		} else if _, ok := idx[dt.Target]; ok {
+			err = fmt.Errorf("%w %s in Destination-CA-File", ErrDuplicateDestination, dt.Target)
+			return
+		} else if _, err = dt.RootCAs(); err != nil {
+			err = fmt.Errorf("Invalid Destination-CA-File %q: %w", v, err)
+			return
+		}
+		idx[dt.Target] = len(dts)
+		dts = append(dts, dt)
+	}
+	pinned := map[string]bool{}
+	for _, v := range isc.Destination_SPKI_Pin {
+		var dt DestinationTLS
+		if dt, err = ParseDestinationPins(v); err != nil {
+			err = fmt.Errorf("Invalid Destination-SPKI-Pin %q: %w", v, err)
+			return
+		} else if pinned[dt.Target] {
+			err = fmt.Errorf("%w %s in Destination-SPKI-Pin", ErrDuplicateDestination, dt.Target)
+			return
+		}
+		pinned[dt.Target] = true
+		if i, ok := idx[dt.Target]; ok {
+			dts[i].Pins = dt.Pins
+		} else {
+			idx[dt.Target] = len(dts)
+			dts = append(dts, dt)
+		}
+	}
+	return
+}
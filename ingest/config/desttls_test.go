@@ -0,0 +1,90 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCA(t *testing.T, pth string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: `test ca`},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = os.WriteFile(pth, pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDestinationTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	ca := filepath.Join(dir, `ca.pem`)
+	empty := filepath.Join(dir, `empty.pem`)
+	writeTestCA(t, ca)
+	if err := os.WriteFile(empty, []byte(`nothing`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pin := `sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=`
+	isc := IngestStreamConfig{
+		Destination_CA_File:  []string{`10.0.0.1,` + ca, `tls://idx.example.com:4024, ` + ca},
+		Destination_SPKI_Pin: []string{`10.0.0.1, ` + pin + `,47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=`, `10.0.0.2,` + pin},
+	}
+	dts, err := isc.DestinationTLS()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(dts) != 3 {
+		t.Fatalf("bad destination count %d", len(dts))
+	} else if dts[0].CAFile != ca || len(dts[0].Pins) != 2 || dts[1].CAFile != ca || dts[2].CAFile != `` || len(dts[2].Pins) != 1 {
+		t.Fatalf("bad destinations %+v", dts)
+	}
+	if !dts[0].Matches(`tls://10.0.0.1:4024`) || dts[1].Matches(`tcp://idx.example.com:4024`) || !dts[2].Verifies() {
+		t.Fatal("bad destination matching")
+	}
+
+	bad := []IngestStreamConfig{
+		{Destination_CA_File: []string{`10.0.0.1`}},
+		{Destination_CA_File: []string{`10.0.0.1,` + ca, `10.0.0.1,` + ca}},
+		{Destination_CA_File: []string{`10.0.0.1,` + empty}},
+		{Destination_CA_File: []string{`10.0.0.1,/does/not/exist.pem`}},
+		{Destination_SPKI_Pin: []string{`10.0.0.1`}},
+		{Destination_SPKI_Pin: []string{`10.0.0.1,notbase64!`}},
+		{Destination_SPKI_Pin: []string{`10.0.0.1,c2hvcnQ=`}},
+		{Destination_SPKI_Pin: []string{`10.0.0.1,` + pin, `10.0.0.1,` + pin}},
+	}
+	for _, c := range bad {
+		if _, err := c.DestinationTLS(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+	if _, err = (IngestStreamConfig{Destination_CA_File: []string{`10.0.0.1,` + empty}}).DestinationTLS(); !errors.Is(err, ErrEmptyCABundle) {
+		t.Fatalf("bad error for an empty bundle: %v", err)
+	}
+}
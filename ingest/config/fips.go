@@ -0,0 +1,127 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	minFIPSRSABits = 2048
+)
+
+var (
+	ErrFIPSCleartextTarget = errors.New("FIPS mode does not allow cleartext backend targets")
+	ErrFIPSInsecureTLS     = errors.New("FIPS mode does not allow Insecure-Skip-TLS-Verify")
+	ErrFIPSCertificateKey  = errors.New("FIPS mode requires an RSA key of at least 2048 bits or an ECDSA P-256, P-384, or P-521 key")
+
+	fipsEnabled int32
+
+	// TLS 1.3 suites can't be restricted, so FIPS mode caps connections at TLS 1.2
+	fipsCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+)
+
+// EnableFIPS turns on FIPS mode for the whole process.  There is no way to turn it back off;
+// a process that has been asked to be compliant stays compliant.
+func EnableFIPS() {
+	atomic.StoreInt32(&fipsEnabled, 1)
+}
+
+// FIPSEnabled reports whether the process is restricted to FIPS approved algorithms, either
+// because a config enabled FIPS-Mode or because it was built with the fips build tag.
+func FIPSEnabled() bool {
+	return fipsBuild || atomic.LoadInt32(&fipsEnabled) == 1
+}
+
+// FIPSTLSConfig restricts a TLS config to FIPS approved versions, cipher suites, and curves
+// when FIPS mode is on, it returns an error if a certificate in the config has a key that is
+// not approved.  The config is left alone when FIPS mode is off.
+func FIPSTLSConfig(c *tls.Config) error {
+	if c == nil || !FIPSEnabled() {
+		return nil
+	}
+	for _, cert := range c.Certificates {
+		if err := CheckFIPSCertificate(cert); err != nil {
+			return err
+		}
+	}
+	c.MinVersion = tls.VersionTLS12
+	c.MaxVersion = tls.VersionTLS12
+	c.CipherSuites = append([]uint16(nil), fipsCipherSuites...)
+	c.CurvePreferences = append([]tls.CurveID(nil), fipsCurves...)
+	return nil
+}
+
+// CheckFIPSCertificate checks that a certificate's key is one FIPS mode allows
+func CheckFIPSCertificate(cert tls.Certificate) error {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return nil
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	switch k := leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() >= minFIPSRSABits {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+			return nil
+		}
+	}
+	return fmt.Errorf("%w, certificate for %q has a %v key", ErrFIPSCertificateKey, leaf.Subject.CommonName, leaf.PublicKeyAlgorithm)
+}
+
+// CheckFIPSHash returns an error if FIPS mode is on and the named hash is not approved
+func CheckFIPSHash(name string) error {
+	if !FIPSEnabled() {
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case `sha224`, `sha256`, `sha384`, `sha512`, `sha512/256`, `sha3-256`, `sha3-384`, `sha3-512`:
+		return nil
+	}
+	return fmt.Errorf("FIPS mode does not allow the %s hash", name)
+}
+
+// verifyFIPS enables FIPS mode if the config or build asks for it and rejects settings
+// that can't be compliant.  The ingest authentication handshake is only allowed inside a
+// verified TLS session.
+func (ic *IngestConfig) verifyFIPS() error {
+	if !ic.FIPS_Mode && !fipsBuild {
+		return nil
+	}
+	if len(ic.Cleartext_Backend_Target) > 0 || len(ic.Secondary_Cleartext_Backend_Target) > 0 {
+		return ErrFIPSCleartextTarget
+	} else if ic.Insecure_Skip_TLS_Verify {
+		return ErrFIPSInsecureTLS
+	}
+	EnableFIPS()
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build fips
+// +build fips
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+// built with the fips tag, FIPS mode is always on
+const fipsBuild = true
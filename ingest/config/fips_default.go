@@ -0,0 +1,15 @@
+//go:build !fips
+// +build !fips
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+// FIPS mode is only on when a config asks for it
+const fipsBuild = false
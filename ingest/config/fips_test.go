@@ -0,0 +1,103 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// withFIPS runs a test with FIPS mode on and turns it back off so other tests are not affected
+func withFIPS(t *testing.T, f func()) {
+	EnableFIPS()
+	defer atomic.StoreInt32(&fipsEnabled, 0)
+	f()
+}
+
+func testLeaf(key interface{}) tls.Certificate {
+	return tls.Certificate{Leaf: &x509.Certificate{PublicKey: key}}
+}
+
+func TestVerifyFIPS(t *testing.T) {
+	if fipsBuild {
+		t.Skip("FIPS mode can't be turned off in a fips build")
+	}
+	defer atomic.StoreInt32(&fipsEnabled, 0)
+	ic := IngestConfig{Cleartext_Backend_Target: []string{`127.0.0.1:4023`}}
+	if err := ic.verifyFIPS(); err != nil || FIPSEnabled() {
+		t.Fatalf("FIPS mode checked without being enabled: %v", err)
+	}
+	ic.FIPS_Mode = true
+	if err := ic.verifyFIPS(); !errors.Is(err, ErrFIPSCleartextTarget) {
+		t.Fatalf("cleartext target not caught: %v", err)
+	}
+	ic.Cleartext_Backend_Target = nil
+	ic.Encrypted_Backend_Target = []string{`127.0.0.1:4024`}
+	ic.Insecure_Skip_TLS_Verify = true
+	if err := ic.verifyFIPS(); !errors.Is(err, ErrFIPSInsecureTLS) {
+		t.Fatalf("insecure TLS not caught: %v", err)
+	} else if FIPSEnabled() {
+		t.Fatal("FIPS mode enabled by a bad config")
+	}
+	ic.Insecure_Skip_TLS_Verify = false
+	if err := ic.verifyFIPS(); err != nil {
+		t.Fatal(err)
+	} else if !FIPSEnabled() {
+		t.Fatal("FIPS mode not enabled")
+	}
+}
+
+func TestFIPSTLSConfig(t *testing.T) {
+	ec, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edpub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fipsBuild {
+		var c tls.Config
+		if err = FIPSTLSConfig(&c); err != nil || c.MaxVersion != 0 || c.CipherSuites != nil {
+			t.Fatalf("config changed outside of FIPS mode: %v %+v", err, &c)
+		}
+	}
+	withFIPS(t, func() {
+		c := tls.Config{Certificates: []tls.Certificate{testLeaf(&ec.PublicKey)}}
+		if err := FIPSTLSConfig(&c); err != nil {
+			t.Fatal(err)
+		} else if c.MinVersion != tls.VersionTLS12 || c.MaxVersion != tls.VersionTLS12 || len(c.CipherSuites) != len(fipsCipherSuites) || len(c.CurvePreferences) != len(fipsCurves) {
+			t.Fatalf("config not restricted: %+v", &c)
+		}
+		for _, key := range []interface{}{&small.PublicKey, edpub} {
+			c = tls.Config{Certificates: []tls.Certificate{testLeaf(key)}}
+			if err := FIPSTLSConfig(&c); !errors.Is(err, ErrFIPSCertificateKey) {
+				t.Fatalf("%T key not caught: %v", key, err)
+			}
+		}
+		if err := CheckFIPSHash(`SHA256`); err != nil {
+			t.Fatal(err)
+		} else if err = CheckFIPSHash(`sha1`); err == nil {
+			t.Fatal("sha1 allowed in FIPS mode")
+		}
+	})
+}
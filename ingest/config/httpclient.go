@@ -0,0 +1,140 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	HTTPProxyNone = `none`
+
+	DefaultHTTPRetryBackoff = time.Second
+	DefaultHTTPMaxBackoff   = 5 * time.Minute
+	defaultDNSPort          = `53`
+)
+
+var (
+	ErrInvalidHTTPProxy = errors.New("HTTP-Proxy must be a URL or \"none\"")
+	ErrInvalidRetries   = errors.New("HTTP-Retries may not be negative")
+	ErrInvalidDNSServer = errors.New("DNS-Server must be an IP or IP:port")
+)
+
+// HTTPClientConfig is embedded in the global config of ingesters that poll remote APIs so that
+// proxies, trusted CAs, timeouts, and retries are configured the same way everywhere:
+//
+//	HTTP-Proxy=http://proxy.example.com:3128
+//	HTTP-CA-Bundle=/opt/gravwell/etc/corporate-ca.pem
+//	HTTP-Timeout=30s
+//	HTTP-Retries=5
+//	DNS-Server=10.0.0.53
+//
+// An empty HTTP-Proxy uses the HTTPS_PROXY, HTTP_PROXY, and NO_PROXY environment variables.
+type HTTPClientConfig struct {
+	HTTP_Proxy                    string // proxy URL, "none" ignores the environment
+	HTTP_CA_Bundle                string // PEM file of CAs trusted in addition to the system pool
+	HTTP_Insecure_Skip_TLS_Verify bool
+	HTTP_Timeout                  string // per request timeout, zero disables
+	HTTP_Retries                  int    // retries on network errors, 429, and 5xx gateway responses
+	HTTP_Retry_Backoff            string // initial wait between retries, doubles on each attempt
+	HTTP_Max_Backoff              string // cap on retry waits, including server supplied Retry-After
+	DNS_Server                    string // resolver to use instead of the system resolver
+}
+
+// VerifyHTTPClient checks the HTTP client settings, it does not attempt to load the CA bundle
+func (hc HTTPClientConfig) VerifyHTTPClient() (err error) {
+	if _, err = hc.HTTPProxyURL(); err != nil {
+		return
+	} else if _, err = hc.HTTPTimeout(); err != nil {
+		return
+	} else if _, _, err = hc.HTTPBackoff(); err != nil {
+		return
+	} else if _, err = hc.HTTPDNSServer(); err != nil {
+		return
+	} else if hc.HTTP_Retries < 0 {
+		return ErrInvalidRetries
+	}
+	if hc.HTTP_CA_Bundle != `` {
+		if _, err = os.Stat(hc.HTTP_CA_Bundle); err != nil {
+			err = fmt.Errorf("Invalid HTTP-CA-Bundle: %w", err)
+		}
+	}
+	return
+}
+
+// HTTPProxyURL returns the configured proxy, nil if there is none (see UseEnvironmentProxy)
+func (hc HTTPClientConfig) HTTPProxyURL() (u *url.URL, err error) {
+	v := strings.TrimSpace(hc.HTTP_Proxy)
+	if v == `` || strings.EqualFold(v, HTTPProxyNone) {
+		return
+	}
+	if u, err = url.Parse(v); err != nil || u.Host == `` {
+		u, err = nil, ErrInvalidHTTPProxy
+	}
+	return
+}
+
+// UseEnvironmentProxy returns true if proxy settings should come from the environment
+func (hc HTTPClientConfig) UseEnvironmentProxy() bool {
+	return strings.TrimSpace(hc.HTTP_Proxy) == ``
+}
+
+// HTTPTimeout returns the per request timeout, zero means no timeout
+func (hc HTTPClientConfig) HTTPTimeout() (time.Duration, error) {
+	return parseOptionalDuration(`HTTP-Timeout`, hc.HTTP_Timeout, 0)
+}
+
+// HTTPBackoff returns the initial and maximum retry backoff
+func (hc HTTPClientConfig) HTTPBackoff() (initial, max time.Duration, err error) {
+	if initial, err = parseOptionalDuration(`HTTP-Retry-Backoff`, hc.HTTP_Retry_Backoff, DefaultHTTPRetryBackoff); err != nil {
+		return
+	} else if max, err = parseOptionalDuration(`HTTP-Max-Backoff`, hc.HTTP_Max_Backoff, DefaultHTTPMaxBackoff); err != nil {
+		return
+	} else if max < initial {
+		err = fmt.Errorf("HTTP-Max-Backoff %v is less than HTTP-Retry-Backoff %v", max, initial)
+	}
+	return
+}
+
+// HTTPDNSServer returns the resolver address as host:port, an empty string means use the system resolver
+func (hc HTTPClientConfig) HTTPDNSServer() (addr string, err error) {
+	v := strings.TrimSpace(hc.DNS_Server)
+	if v == `` {
+		return
+	}
+	host, port, lerr := net.SplitHostPort(v)
+	if lerr != nil {
+		host, port = strings.Trim(v, `[]`), defaultDNSPort
+	}
+	if net.ParseIP(host) == nil || port == `` {
+		err = ErrInvalidDNSServer
+		return
+	}
+	addr = net.JoinHostPort(host, port)
+	return
+}
+
+func parseOptionalDuration(name, v string, def time.Duration) (d time.Duration, err error) {
+	if v = strings.TrimSpace(v); v == `` {
+		d = def
+		return
+	}
+	if d, err = time.ParseDuration(v); err != nil {
+		err = fmt.Errorf("Invalid %s %q: %v", name, v, err)
+	} else if d < 0 {
+		err = fmt.Errorf("Invalid %s %q: may not be negative", name, v)
+	}
+	return
+}
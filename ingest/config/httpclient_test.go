@@ -0,0 +1,66 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHTTPClientConfig(t *testing.T) {
+	var hc HTTPClientConfig
+	if err := hc.VerifyHTTPClient(); err != nil {
+		t.Fatal(err)
+	} else if !hc.UseEnvironmentProxy() {
+		t.Fatal("empty proxy should use the environment")
+	} else if init, max, err := hc.HTTPBackoff(); err != nil || init != DefaultHTTPRetryBackoff || max != DefaultHTTPMaxBackoff {
+		t.Fatalf("bad default backoff %v %v %v", init, max, err)
+	}
+
+	hc = HTTPClientConfig{
+		HTTP_Proxy:         `http://proxy.example.com:3128`,
+		HTTP_Timeout:       `30s`,
+		HTTP_Retries:       3,
+		HTTP_Retry_Backoff: `2s`,
+		HTTP_Max_Backoff:   `1m`,
+		DNS_Server:         `10.0.0.53`,
+	}
+	if err := hc.VerifyHTTPClient(); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := hc.HTTPProxyURL(); err != nil || u.Host != `proxy.example.com:3128` {
+		t.Fatalf("bad proxy %v %v", u, err)
+	} else if to, err := hc.HTTPTimeout(); err != nil || to != 30*time.Second {
+		t.Fatalf("bad timeout %v %v", to, err)
+	} else if addr, err := hc.HTTPDNSServer(); err != nil || addr != `10.0.0.53:53` {
+		t.Fatalf("bad DNS server %v %v", addr, err)
+	}
+
+	hc = HTTPClientConfig{HTTP_Proxy: `none`, DNS_Server: `[fe80::1]:5353`}
+	if u, err := hc.HTTPProxyURL(); err != nil || u != nil || hc.UseEnvironmentProxy() {
+		t.Fatalf("bad proxy %v %v", u, err)
+	} else if addr, err := hc.HTTPDNSServer(); err != nil || addr != `[fe80::1]:5353` {
+		t.Fatalf("bad DNS server %v %v", addr, err)
+	}
+
+	bad := []HTTPClientConfig{
+		{HTTP_Proxy: `not a url`},
+		{HTTP_Timeout: `forever`},
+		{HTTP_Timeout: `-1s`},
+		{HTTP_Retries: -1},
+		{HTTP_Retry_Backoff: `1m`, HTTP_Max_Backoff: `1s`},
+		{DNS_Server: `dns.example.com`},
+		{HTTP_CA_Bundle: `/this/does/not/exist.pem`},
+	}
+	for i, hc := range bad {
+		if err := hc.VerifyHTTPClient(); err == nil {
+			t.Fatalf("bad config %d passed verification", i)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	krb5config "gopkg.in/jcmturner/gokrb5.v7/config"
+	"gopkg.in/jcmturner/gokrb5.v7/keytab"
+)
+
+const (
+	defaultKerberosConfig  = `/etc/krb5.conf`
+	defaultKerberosService = `gravwell`
+)
+
+var (
+	ErrMissingKerberosKeytab    = errors.New("Kerberos-Principal requires a Kerberos-Keytab")
+	ErrMissingKerberosPrincipal = errors.New("Kerberos-Keytab requires a Kerberos-Principal")
+	ErrInvalidKerberosPrincipal = errors.New("Kerberos principal must be of the form user@REALM")
+	ErrInvalidKerberosService   = errors.New("Kerberos-Service must be a service name such as gravwell")
+	ErrKerberosNotEnabled       = errors.New("Kerberos authentication is experimental and requires Experimental-Kerberos=true")
+)
+
+// KerberosEnabled reports whether ingesters authenticate to indexers with Kerberos tickets.
+// Kerberos authentication is an extension of the ingest protocol that indexers must implement,
+// so it is only used when explicitly enabled with Experimental-Kerberos.
+func (isc IngestStreamConfig) KerberosEnabled() bool {
+	return isc.Experimental_Kerberos && isc.Kerberos_Keytab != ``
+}
+
+// kerberosRequested reports whether any Kerberos settings are present
+func (isc IngestStreamConfig) kerberosRequested() bool {
+	return isc.Kerberos_Keytab != `` || isc.Kerberos_Principal != ``
+}
+
+// CheckKerberosEnabled returns an error if Kerberos settings are present without Experimental-Kerberos
+func (isc IngestStreamConfig) CheckKerberosEnabled() error {
+	if isc.kerberosRequested() && !isc.Experimental_Kerberos {
+		return ErrKerberosNotEnabled
+	}
+	return nil
+}
+
+// KerberosPrincipal splits the ingester principal into its user and realm
+func (isc IngestStreamConfig) KerberosPrincipal() (user, realm string, err error) {
+	return ParseKerberosPrincipal(isc.Kerberos_Principal)
+}
+
+// KerberosConfig returns the krb5.conf path
+func (isc IngestStreamConfig) KerberosConfig() string {
+	if isc.Kerberos_Config == `` {
+		return defaultKerberosConfig
+	}
+	return isc.Kerberos_Config
+}
+
+// KerberosService returns the service name of indexer principals, an indexer at
+// idx1.example.com is expected to hold the gravwell/idx1.example.com principal.
+func (isc IngestStreamConfig) KerberosService() string {
+	if isc.Kerberos_Service == `` {
+		return defaultKerberosService
+	}
+	return isc.Kerberos_Service
+}
+
+// ParseKerberosPrincipal splits a user@REALM principal, the user may carry instances
+// such as ingester/host.example.com@EXAMPLE.COM
+func ParseKerberosPrincipal(v string) (user, realm string, err error) {
+	v = strings.TrimSpace(v)
+	i := strings.LastIndex(v, `@`)
+	if i <= 0 || i == len(v)-1 {
+		err = fmt.Errorf("%w: %q", ErrInvalidKerberosPrincipal, v)
+		return
+	}
+	user, realm = v[:i], v[i+1:]
+	return
+}
+
+func (ic *IngestConfig) verifyKerberos() error {
+	if err := ic.CheckKerberosEnabled(); err != nil {
+		return err
+	} else if !ic.KerberosEnabled() {
+		if ic.Kerberos_Principal != `` {
+			return ErrMissingKerberosKeytab
+		}
+		return nil
+	} else if ic.Kerberos_Principal == `` {
+		return ErrMissingKerberosPrincipal
+	} else if _, _, err := ic.KerberosPrincipal(); err != nil {
+		return err
+	} else if svc := ic.KerberosService(); strings.ContainsAny(svc, `/@ `) {
+		return ErrInvalidKerberosService
+	}
+	if _, err := keytab.Load(ic.Kerberos_Keytab); err != nil {
+		return fmt.Errorf("Invalid Kerberos-Keytab %q: %w", ic.Kerberos_Keytab, err)
+	} else if _, err = LoadKerberosConfig(ic.KerberosConfig()); err != nil {
+		return fmt.Errorf("Invalid Kerberos-Config %q: %w", ic.KerberosConfig(), err)
+	}
+	return nil
+}
+
+// LoadKerberosConfig loads a krb5.conf, directives the Kerberos library does not support are
+// skipped rather than treated as errors because most site configs carry a few of them.
+func LoadKerberosConfig(pth string) (*krb5config.Config, error) {
+	c, err := krb5config.Load(pth)
+	if _, ok := err.(krb5config.UnsupportedDirective); ok && c != nil {
+		err = nil
+	}
+	return c, err
+}
@@ -0,0 +1,84 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/jcmturner/gokrb5.v7/test/testdata"
+)
+
+func TestKerberosConfig(t *testing.T) {
+	if u, r, err := ParseKerberosPrincipal(`ingester/host.example.com@EXAMPLE.COM`); err != nil || u != `ingester/host.example.com` || r != `EXAMPLE.COM` {
+		t.Fatalf("bad principal %q %q %v", u, r, err)
+	}
+	for _, v := range []string{``, `ingester`, `@EXAMPLE.COM`, `ingester@`} {
+		if _, _, err := ParseKerberosPrincipal(v); !errors.Is(err, ErrInvalidKerberosPrincipal) {
+			t.Fatalf("bad principal %q not caught: %v", v, err)
+		}
+	}
+
+	dir := t.TempDir()
+	kt, conf := filepath.Join(dir, `ingester.keytab`), filepath.Join(dir, `krb5.conf`)
+	b, _ := hex.DecodeString(testdata.HTTP_KEYTAB)
+	if err := os.WriteFile(kt, b, 0600); err != nil {
+		t.Fatal(err)
+	} else if err = os.WriteFile(conf, []byte(testdata.TEST_KRB5CONF), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var ic IngestConfig
+	if err := ic.verifyKerberos(); err != nil || ic.KerberosEnabled() {
+		t.Fatalf("Kerberos enabled without any settings: %v", err)
+	}
+	ic.Kerberos_Principal = `ingester@TEST.GOKRB5`
+	if err := ic.verifyKerberos(); err != ErrKerberosNotEnabled {
+		t.Fatalf("Kerberos settings accepted without Experimental-Kerberos: %v", err)
+	}
+	ic.Experimental_Kerberos = true
+	if err := ic.verifyKerberos(); err != ErrMissingKerberosKeytab {
+		t.Fatalf("missing keytab not caught: %v", err)
+	}
+	ic.Kerberos_Keytab = kt
+	ic.Kerberos_Config = conf
+	if err := ic.verifyKerberos(); err != nil {
+		t.Fatal(err)
+	} else if !ic.KerberosEnabled() {
+		t.Fatal("Kerberos not enabled")
+	} else if ic.KerberosService() != `gravwell` {
+		t.Fatalf("bad default service %s", ic.KerberosService())
+	}
+	ic.Kerberos_Service = `gravwell/idx`
+	if err := ic.verifyKerberos(); err != ErrInvalidKerberosService {
+		t.Fatalf("bad service not caught: %v", err)
+	}
+	ic.Kerberos_Service = ``
+	ic.Kerberos_Keytab = conf
+	if err := ic.verifyKerberos(); err == nil {
+		t.Fatal("bad keytab not caught")
+	}
+	ic.Kerberos_Keytab = kt
+	ic.Kerberos_Principal = ``
+	if err := ic.verifyKerberos(); err != ErrMissingKerberosPrincipal {
+		t.Fatalf("missing principal not caught: %v", err)
+	}
+
+	//a keytab alone does not turn Kerberos on
+	ic.Kerberos_Principal = `ingester@TEST.GOKRB5`
+	ic.Experimental_Kerberos = false
+	if ic.KerberosEnabled() {
+		t.Fatal("Kerberos enabled without Experimental-Kerberos")
+	} else if err := ic.verifyKerberos(); err != ErrKerberosNotEnabled {
+		t.Fatalf("Kerberos settings accepted without Experimental-Kerberos: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultLatencyMarkerTag  = `gravwell-latency`
+	MinLatencyMarkerInterval = time.Second
+)
+
+var (
+	ErrLatencyMarkerTagOnly = errors.New("Latency-Marker-Tag requires a Latency-Marker-Interval")
+)
+
+// LatencyMarkerConfig controls the marker entries a muxer periodically writes to measure how
+// long indexers take to acknowledge entries.  Markers are disabled when the interval is zero.
+type LatencyMarkerConfig struct {
+	Interval time.Duration
+	Tag      string
+}
+
+// Enabled reports whether latency markers should be sent
+func (lmc LatencyMarkerConfig) Enabled() bool {
+	return lmc.Interval > 0
+}
+
+// LatencyMarkers parses the Latency-Marker parameters, the tag defaults to DefaultLatencyMarkerTag
+func (isc IngestStreamConfig) LatencyMarkers() (lmc LatencyMarkerConfig, err error) {
+	if isc.Latency_Marker_Interval == `` {
+		if isc.Latency_Marker_Tag != `` {
+			err = ErrLatencyMarkerTagOnly
+		}
+		return
+	}
+	if lmc.Interval, err = time.ParseDuration(isc.Latency_Marker_Interval); err != nil {
+		err = fmt.Errorf("Invalid Latency-Marker-Interval %q: %v", isc.Latency_Marker_Interval, err)
+		return
+	} else if lmc.Interval < MinLatencyMarkerInterval {
+		err = fmt.Errorf("Invalid Latency-Marker-Interval %q: must be at least %v", isc.Latency_Marker_Interval, MinLatencyMarkerInterval)
+		return
+	}
+	if lmc.Tag = isc.Latency_Marker_Tag; lmc.Tag == `` {
+		lmc.Tag = DefaultLatencyMarkerTag
+	}
+	return
+}
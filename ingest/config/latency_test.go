@@ -0,0 +1,41 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyMarkerConfig(t *testing.T) {
+	if lmc, err := (IngestStreamConfig{}).LatencyMarkers(); err != nil || lmc.Enabled() {
+		t.Fatalf("latency markers enabled by default: %+v %v", lmc, err)
+	}
+	isc := IngestStreamConfig{Latency_Marker_Interval: `30s`}
+	if lmc, err := isc.LatencyMarkers(); err != nil {
+		t.Fatal(err)
+	} else if !lmc.Enabled() || lmc.Interval != 30*time.Second || lmc.Tag != DefaultLatencyMarkerTag {
+		t.Fatalf("bad latency marker config: %+v", lmc)
+	}
+	isc.Latency_Marker_Tag = `latency`
+	if lmc, err := isc.LatencyMarkers(); err != nil || lmc.Tag != `latency` {
+		t.Fatalf("bad latency marker tag: %+v %v", lmc, err)
+	}
+	bad := []IngestStreamConfig{
+		{Latency_Marker_Tag: `latency`},
+		{Latency_Marker_Interval: `often`},
+		{Latency_Marker_Interval: `100ms`},
+		{Latency_Marker_Interval: `-10s`},
+	}
+	for _, b := range bad {
+		if _, err := b.LatencyMarkers(); err == nil {
+			t.Fatalf("failed to catch bad latency marker config %+v", b)
+		}
+	}
+}
@@ -28,9 +28,9 @@ const (
 // Thus, AppendDefaultPort("10.0.0.1", 4023) will return "10.0.0.1:4023",
 // but AppendDefaultPort("10.0.0.1:5555", 4023) will return "10.0.0.1:5555".
 func AppendDefaultPort(bstr string, defPort uint16) string {
-	// first, try to parse as a plain IP
-	if ip := net.ParseIP(bstr); ip != nil {
-		return net.JoinHostPort(bstr, strconv.FormatUint(uint64(defPort), 10))
+	// first, try to parse as a plain IP, possibly bracketed or with an IPv6 zone
+	if _, _, err := ParseIPZone(bstr); err == nil {
+		return net.JoinHostPort(strings.Trim(strings.TrimSpace(bstr), `[]`), strconv.FormatUint(uint64(defPort), 10))
 	}
 	if _, _, err := net.SplitHostPort(bstr); err != nil {
 		if aerr, ok := err.(*net.AddrError); ok && aerr.Err == "missing port in address" {
@@ -95,14 +95,47 @@ func ParseRate(s string) (bps int64, err error) {
 	return
 }
 
+var (
+	sizeSuffix = []multSuff{
+		multSuff{mult: kb, suffix: `kb`},
+		multSuff{mult: mb, suffix: `mb`},
+		multSuff{mult: gb, suffix: `gb`},
+		multSuff{mult: kb, suffix: `k`},
+		multSuff{mult: mb, suffix: `m`},
+		multSuff{mult: gb, suffix: `g`},
+		multSuff{mult: 1, suffix: `b`},
+	}
+)
+
+// ParseDataSize parses a size in bytes, the string should consist of numbers optionally
+// followed by one of the following case insensitive suffixes: b, k, kb, m, mb, g, gb.
+// Suffixes are powers of 1024.
+func ParseDataSize(s string) (sz int64, err error) {
+	var r uint64
+	s = strings.ToLower(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, v := range sizeSuffix {
+		if strings.HasSuffix(s, v.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, v.suffix))
+			mult = v.mult
+			break
+		}
+	}
+	if r, err = strconv.ParseUint(s, 10, 63); err != nil {
+		return
+	}
+	sz = int64(r) * mult
+	return
+}
+
 // ParseSource returns a net.IP byte buffer
 // the returned buffer will always be a 32bit or 128bit buffer
 // but we accept encodings as IPv4, IPv6, integer, hex encoded hash
 // this function simply walks the available encodings until one works
 func ParseSource(v string) (b net.IP, err error) {
 	var i uint64
-	// try as an IP
-	if b = net.ParseIP(v); b != nil {
+	// try as an IP, IPv6 zones can't be carried in a source so they are dropped
+	if b, _, err = ParseIPZone(v); err == nil {
 		return
 	}
 	//try as a plain integer
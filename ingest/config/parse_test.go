@@ -47,3 +47,27 @@ func TestParseRate(t *testing.T) {
 		}
 	}
 }
+
+func TestParseDataSize(t *testing.T) {
+	tests := []rateVals{
+		{"1", 1},
+		{"1024b", 1024},
+		{"4k", 4 * 1024},
+		{"4KB", 4 * 1024},
+		{"512MB", 512 * 1024 * 1024},
+		{"512 mb", 512 * 1024 * 1024},
+		{"2G", 2 * 1024 * 1024 * 1024},
+	}
+	for i := range tests {
+		if sz, err := ParseDataSize(tests[i].Input); err != nil {
+			t.Fatalf("Failed to parse %v: %v", tests[i].Input, err)
+		} else if sz != tests[i].Bps {
+			t.Fatalf("%v incorrectly parsed to %v, expected %v", tests[i].Input, sz, tests[i].Bps)
+		}
+	}
+	for _, v := range []string{``, `MB`, `-1MB`, `1.5GB`, `12TB`} {
+		if _, err := ParseDataSize(v); err == nil {
+			t.Fatalf("parsed bad size %q", v)
+		}
+	}
+}
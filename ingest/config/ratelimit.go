@@ -0,0 +1,201 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	destRateSplit = `,`
+	schemeSplit   = `://`
+)
+
+var (
+	ErrInvalidTagRateLimit         = errors.New("Tag rate limit must be of the form tag:rate or tag:rate:burst")
+	ErrInvalidDestinationRateLimit = errors.New("Destination rate limit must be of the form target,rate or target,rate,burst")
+	ErrDuplicateRateLimit          = errors.New("Duplicate rate limit")
+	ErrAdaptiveRateNoLimit         = errors.New("Adaptive-Rate-Limit requires a Rate-Limit to adapt")
+)
+
+// RateLimit is a bandwidth limit in bits per second.  Burst is the number of bits that may be
+// sent at once, it defaults to one second of traffic.
+type RateLimit struct {
+	Bps   int64
+	Burst int64
+}
+
+// TagRateLimit limits the bandwidth used by all entries with a tag
+type TagRateLimit struct {
+	RateLimit
+	Tag string
+}
+
+// DestinationRateLimit limits the bandwidth used on a single indexer connection
+type DestinationRateLimit struct {
+	RateLimit
+	Target string
+}
+
+func parseRateLimit(rate, burst string) (rl RateLimit, err error) {
+	if rl.Bps, err = ParseRate(strings.TrimSpace(rate)); err != nil {
+		return
+	} else if rl.Bps <= 0 {
+		err = errors.New("rate must be positive")
+		return
+	}
+	if burst = strings.TrimSpace(burst); burst == `` {
+		rl.Burst = rl.Bps
+	} else if rl.Burst, err = ParseRate(burst); err == nil && rl.Burst <= 0 {
+		err = errors.New("burst must be positive")
+	}
+	return
+}
+
+// ParseTagRateLimit parses a tag rate limit specification of the form:
+//
+//	tag:10Mbit
+//	tag:10Mbit:50Mbit
+func ParseTagRateLimit(v string) (trl TagRateLimit, err error) {
+	bits := strings.Split(v, tagHintSplit)
+	if len(bits) != 2 && len(bits) != 3 {
+		err = ErrInvalidTagRateLimit
+		return
+	}
+	if trl.Tag = strings.TrimSpace(bits[0]); trl.Tag == `` || strings.ContainsAny(trl.Tag, " \t\r\n") {
+		err = ErrInvalidTagRateLimit
+		return
+	}
+	bits = append(bits, ``)
+	if trl.RateLimit, err = parseRateLimit(bits[1], bits[2]); err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalidTagRateLimit, err)
+	}
+	return
+}
+
+// ParseDestinationRateLimit parses a destination rate limit specification, commas are used
+// because addresses contain colons:
+//
+//	10.0.0.1:4023,100Mbit
+//	tls://idx.example.com,100Mbit,200Mbit
+func ParseDestinationRateLimit(v string) (drl DestinationRateLimit, err error) {
+	bits := strings.Split(v, destRateSplit)
+	if len(bits) != 2 && len(bits) != 3 {
+		err = ErrInvalidDestinationRateLimit
+		return
+	}
+	if drl.Target = strings.TrimSpace(bits[0]); drl.Target == `` {
+		err = ErrInvalidDestinationRateLimit
+		return
+	}
+	bits = append(bits, ``)
+	if drl.RateLimit, err = parseRateLimit(bits[1], bits[2]); err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalidDestinationRateLimit, err)
+	}
+	return
+}
+
+// Matches reports whether the limit applies to a destination address such as tcp://10.0.0.1:4023.
+// Targets may be the full address, the address without a scheme, or just the host, in which case
+// the limit applies to every connection to that host.
+func (drl DestinationRateLimit) Matches(addr string) bool {
+	return matchDestination(drl.Target, addr)
+}
+
+// matchDestination reports whether a destination target specification matches an address
+func matchDestination(tgt, addr string) bool {
+	if tgt == addr {
+		return true
+	}
+	if idx := strings.Index(tgt, schemeSplit); idx >= 0 {
+		//a scheme was given, it has to match
+		if !strings.HasPrefix(addr, tgt[:idx+len(schemeSplit)]) {
+			return false
+		}
+		tgt = tgt[idx+len(schemeSplit):]
+	}
+	if idx := strings.Index(addr, schemeSplit); idx >= 0 {
+		addr = addr[idx+len(schemeSplit):]
+	}
+	if tgt == addr {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && strings.Trim(tgt, `[]`) == host
+}
+
+// TagRateLimits parses and returns the set of Tag-Rate-Limit specifications.
+func (isc IngestStreamConfig) TagRateLimits() (trls []TagRateLimit, err error) {
+	seen := make(map[string]bool, len(isc.Tag_Rate_Limit))
+	for _, v := range isc.Tag_Rate_Limit {
+		var trl TagRateLimit
+		if trl, err = ParseTagRateLimit(v); err != nil {
+			err = fmt.Errorf("Invalid Tag-Rate-Limit %q: %w", v, err)
+			return
+		} else if seen[trl.Tag] {
+			err = fmt.Errorf("%w for tag %s", ErrDuplicateRateLimit, trl.Tag)
+			return
+		}
+		seen[trl.Tag] = true
+		trls = append(trls, trl)
+	}
+	return
+}
+
+// DestinationRateLimits parses and returns the set of Destination-Rate-Limit specifications.
+func (isc IngestStreamConfig) DestinationRateLimits() (drls []DestinationRateLimit, err error) {
+	seen := make(map[string]bool, len(isc.Destination_Rate_Limit))
+	for _, v := range isc.Destination_Rate_Limit {
+		var drl DestinationRateLimit
+		if drl, err = ParseDestinationRateLimit(v); err != nil {
+			err = fmt.Errorf("Invalid Destination-Rate-Limit %q: %w", v, err)
+			return
+		} else if seen[drl.Target] {
+			err = fmt.Errorf("%w for destination %s", ErrDuplicateRateLimit, drl.Target)
+			return
+		}
+		seen[drl.Target] = true
+		drls = append(drls, drl)
+	}
+	return
+}
+
+// AdaptiveRateConfig controls how the global Rate-Limit responds to indexer feedback.  MinBps and
+// MaxRTT are zero when not set, leaving the defaults up to the muxer.
+type AdaptiveRateConfig struct {
+	Enabled bool
+	MinBps  int64
+	MaxRTT  time.Duration
+}
+
+// AdaptiveRate parses the Adaptive-Rate parameters
+func (isc IngestStreamConfig) AdaptiveRate() (ar AdaptiveRateConfig, err error) {
+	ar.Enabled = isc.Adaptive_Rate_Limit
+	if isc.Adaptive_Rate_Min != `` {
+		if ar.MinBps, err = ParseRate(isc.Adaptive_Rate_Min); err != nil {
+			err = fmt.Errorf("Invalid Adaptive-Rate-Min %q: %v", isc.Adaptive_Rate_Min, err)
+			return
+		} else if ar.MinBps <= 0 {
+			err = fmt.Errorf("Invalid Adaptive-Rate-Min %q: must be positive", isc.Adaptive_Rate_Min)
+			return
+		}
+	}
+	if isc.Adaptive_Rate_Max_RTT != `` {
+		if ar.MaxRTT, err = time.ParseDuration(isc.Adaptive_Rate_Max_RTT); err != nil {
+			err = fmt.Errorf("Invalid Adaptive-Rate-Max-RTT %q: %v", isc.Adaptive_Rate_Max_RTT, err)
+		} else if ar.MaxRTT <= 0 {
+			err = fmt.Errorf("Invalid Adaptive-Rate-Max-RTT %q: must be positive", isc.Adaptive_Rate_Max_RTT)
+		}
+	}
+	return
+}
@@ -0,0 +1,121 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTagRateLimit(t *testing.T) {
+	good := map[string]TagRateLimit{
+		`backfill:1Mbit`:           TagRateLimit{Tag: `backfill`, RateLimit: RateLimit{Bps: 1024 * 1024, Burst: 1024 * 1024}},
+		` netflow : 2Mbit : 8Mbit`: TagRateLimit{Tag: `netflow`, RateLimit: RateLimit{Bps: 2 * 1024 * 1024, Burst: 8 * 1024 * 1024}},
+	}
+	for v, exp := range good {
+		if trl, err := ParseTagRateLimit(v); err != nil {
+			t.Fatalf("failed to parse %q: %v", v, err)
+		} else if trl != exp {
+			t.Fatalf("bad parse of %q: %+v != %+v", v, trl, exp)
+		}
+	}
+	bad := []string{
+		``,
+		`backfill`,
+		`:1Mbit`,
+		`backfill:`,
+		`backfill:fast`,
+		`backfill:0`,
+		`backfill:1Mbit:0`,
+		`backfill:1Mbit:2Mbit:3Mbit`,
+		`back fill:1Mbit`,
+	}
+	for _, v := range bad {
+		if _, err := ParseTagRateLimit(v); err == nil {
+			t.Fatalf("failed to catch bad rate limit %q", v)
+		}
+	}
+}
+
+func TestDestinationRateLimit(t *testing.T) {
+	drl, err := ParseDestinationRateLimit(`10.0.0.1:4023, 10Mbit, 20Mbit`)
+	if err != nil {
+		t.Fatal(err)
+	} else if drl.Target != `10.0.0.1:4023` || drl.Bps != 10*1024*1024 || drl.Burst != 20*1024*1024 {
+		t.Fatalf("bad parse: %+v", drl)
+	}
+	for _, v := range []string{``, `10.0.0.1:4023`, `,10Mbit`, `10.0.0.1,fast`, `10.0.0.1,1Mbit,2Mbit,3Mbit`} {
+		if _, err = ParseDestinationRateLimit(v); err == nil {
+			t.Fatalf("failed to catch bad rate limit %q", v)
+		}
+	}
+
+	tests := []struct {
+		target string
+		addr   string
+		match  bool
+	}{
+		{`tcp://10.0.0.1:4023`, `tcp://10.0.0.1:4023`, true},
+		{`10.0.0.1:4023`, `tcp://10.0.0.1:4023`, true},
+		{`10.0.0.1`, `tls://10.0.0.1:4024`, true},
+		{`10.0.0.1:4023`, `tls://10.0.0.1:4024`, false},
+		{`tls://10.0.0.1`, `tcp://10.0.0.1:4023`, false},
+		{`fe80::1`, `tcp://[fe80::1]:4023`, true},
+		{`[fe80::1]:4023`, `tcp://[fe80::1]:4023`, true},
+		{`10.0.0.2`, `tcp://10.0.0.1:4023`, false},
+		{`/opt/gravwell/comms/pipe`, `pipe:///opt/gravwell/comms/pipe`, true},
+	}
+	for _, tst := range tests {
+		drl.Target = tst.target
+		if drl.Matches(tst.addr) != tst.match {
+			t.Fatalf("%s matching %s should be %v", tst.target, tst.addr, tst.match)
+		}
+	}
+}
+
+func TestRateLimitsConfig(t *testing.T) {
+	var isc IngestStreamConfig
+	if trls, err := isc.TagRateLimits(); err != nil || len(trls) != 0 {
+		t.Fatalf("bad empty limits: %v %v", trls, err)
+	}
+	isc.Tag_Rate_Limit = []string{`a:1Mbit`, `b:2Mbit`}
+	isc.Destination_Rate_Limit = []string{`10.0.0.1,10Mbit`}
+	if trls, err := isc.TagRateLimits(); err != nil || len(trls) != 2 {
+		t.Fatalf("bad tag limits: %v %v", trls, err)
+	} else if drls, err := isc.DestinationRateLimits(); err != nil || len(drls) != 1 {
+		t.Fatalf("bad destination limits: %v %v", drls, err)
+	}
+	isc.Tag_Rate_Limit = append(isc.Tag_Rate_Limit, `a:5Mbit`)
+	isc.Destination_Rate_Limit = append(isc.Destination_Rate_Limit, `10.0.0.1,5Mbit`)
+	if _, err := isc.TagRateLimits(); err == nil {
+		t.Fatal("failed to catch duplicate tag limit")
+	} else if _, err = isc.DestinationRateLimits(); err == nil {
+		t.Fatal("failed to catch duplicate destination limit")
+	}
+}
+
+func TestAdaptiveRateConfig(t *testing.T) {
+	isc := IngestStreamConfig{Adaptive_Rate_Limit: true, Adaptive_Rate_Min: `2Mbit`, Adaptive_Rate_Max_RTT: `500ms`}
+	if ar, err := isc.AdaptiveRate(); err != nil {
+		t.Fatal(err)
+	} else if !ar.Enabled || ar.MinBps != 2*1024*1024 || ar.MaxRTT != 500*time.Millisecond {
+		t.Fatalf("bad adaptive config: %+v", ar)
+	}
+	bad := []IngestStreamConfig{
+		{Adaptive_Rate_Min: `fast`},
+		{Adaptive_Rate_Min: `0`},
+		{Adaptive_Rate_Max_RTT: `soon`},
+		{Adaptive_Rate_Max_RTT: `-1s`},
+	}
+	for _, b := range bad {
+		if _, err := b.AdaptiveRate(); err == nil {
+			t.Fatalf("failed to catch bad adaptive config %+v", b)
+		}
+	}
+}
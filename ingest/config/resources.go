@@ -0,0 +1,247 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	// cgroup v1 reports "unlimited" as a very large page aligned number
+	cgroupUnlimited int64 = 1 << 60
+
+	// cacheSlotSize is the memory we budget for each slot in the in-memory caches,
+	// most entries are much smaller but we have to leave room for large ones
+	cacheSlotSize = 64 * kb
+	cacheCount    = 4 // the muxer keeps an entry, block, header, and log cache
+	minCacheDepth = 16
+	minCacheSize  = 64 // MB
+)
+
+var (
+	ErrInvalidMemoryLimit = errors.New("Invalid Memory-Limit")
+	ErrInvalidMaxProcs    = errors.New("Invalid Max-Procs, must be >= 0")
+)
+
+// ResourceLimits are the memory and CPU limits the ingester is running under,
+// typically from a container's cgroup.  A zero value means no limit.
+type ResourceLimits struct {
+	Memory int64   // bytes
+	CPUs   float64 // fractional CPUs, a quota of 150ms every 100ms is 1.5
+}
+
+// DetectResourceLimits returns the cgroup limits for the current process,
+// platforms without cgroups report no limits.
+func DetectResourceLimits() ResourceLimits {
+	return platformResourceLimits()
+}
+
+// Limited reports whether any limit was found
+func (rl ResourceLimits) Limited() bool {
+	return rl.Memory > 0 || rl.CPUs > 0
+}
+
+// Procs is the GOMAXPROCS value that fits in the CPU limit, 0 if there is no limit
+func (rl ResourceLimits) Procs() int {
+	if rl.CPUs <= 0 {
+		return 0
+	}
+	return int(math.Ceil(rl.CPUs))
+}
+
+// CacheDepth sizes the in-memory cache channels so that all of them together,
+// full of large entries, fit in a quarter of the memory limit.
+// def is returned when there is no memory limit and is never exceeded.
+func (rl ResourceLimits) CacheDepth(def int) int {
+	if rl.Memory <= 0 {
+		return def
+	}
+	depth := rl.Memory / 4 / (cacheCount * cacheSlotSize)
+	if depth < minCacheDepth {
+		depth = minCacheDepth
+	}
+	if depth > int64(def) {
+		depth = int64(def)
+	}
+	return int(depth)
+}
+
+// CacheSize sizes the ingest cache in megabytes, capping it at half the memory limit.
+// A container's writable layer or tmpfs is often charged against its memory.
+// def is returned when there is no memory limit and is never exceeded.
+func (rl ResourceLimits) CacheSize(def int) int {
+	if rl.Memory <= 0 {
+		return def
+	}
+	sz := rl.Memory / 2 / mb
+	if sz < minCacheSize {
+		sz = minCacheSize
+	}
+	if sz > int64(def) {
+		sz = int64(def)
+	}
+	return int(sz)
+}
+
+// ResourceLimits returns the limits the ingester should size itself for.
+// Memory-Limit overrides the detected memory limit and detection can be turned off
+// entirely with Disable-Resource-Limits.
+func (ic *IngestConfig) ResourceLimits() (rl ResourceLimits, err error) {
+	if !ic.Disable_Resource_Limits {
+		rl = DetectResourceLimits()
+	}
+	if ic.Memory_Limit != `` {
+		if rl.Memory, err = ParseDataSize(ic.Memory_Limit); err != nil || rl.Memory <= 0 {
+			err = ErrInvalidMemoryLimit
+		}
+	}
+	return
+}
+
+// applyResourceLimits fills in unset cache settings based on the resource limits and sets
+// GOMAXPROCS.  Explicitly configured values are always left alone, as is a GOMAXPROCS
+// environment variable.
+func (ic *IngestConfig) applyResourceLimits() error {
+	if ic.Max_Procs < 0 {
+		return ErrInvalidMaxProcs
+	}
+	rl, err := ic.ResourceLimits()
+	if err != nil {
+		return err
+	}
+	if ic.Cache_Depth == 0 {
+		ic.Cache_Depth = rl.CacheDepth(CACHE_DEPTH_DEFAULT)
+	}
+	if ic.Max_Ingest_Cache == 0 && len(ic.Ingest_Cache_Path) != 0 {
+		ic.Max_Ingest_Cache = rl.CacheSize(CACHE_SIZE_DEFAULT)
+	}
+	if ic.Max_Procs > 0 {
+		runtime.GOMAXPROCS(ic.Max_Procs)
+	} else if procs := rl.Procs(); procs > 0 && os.Getenv(`GOMAXPROCS`) == `` && procs < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(procs)
+	}
+	return nil
+}
+
+// cgroupLimits reads the limits for the cgroups listed in cgroupFile out of the
+// cgroup filesystem mounted at root, both v1 and v2 (unified) hierarchies are handled.
+// Limits are inherited, so every level of the hierarchy is checked and the tightest wins.
+func cgroupLimits(root, cgroupFile string) (rl ResourceLimits) {
+	fin, err := os.Open(cgroupFile)
+	if err != nil {
+		return
+	}
+	defer fin.Close()
+	scn := bufio.NewScanner(fin)
+	for scn.Scan() {
+		//lines look like hierarchy-ID:controller-list:cgroup-path
+		flds := strings.SplitN(scn.Text(), ":", 3)
+		if len(flds) != 3 {
+			continue
+		}
+		if flds[0] == `0` && flds[1] == `` {
+			//unified hierarchy
+			walkCgroup(root, flds[2], func(dir string) {
+				rl.tightenMemory(readCgroupInt(filepath.Join(dir, `memory.max`)))
+				rl.tightenCPU(readCPUMax(filepath.Join(dir, `cpu.max`)))
+			})
+			continue
+		}
+		for _, ctrl := range strings.Split(flds[1], ",") {
+			switch ctrl {
+			case `memory`:
+				walkCgroup(filepath.Join(root, flds[1]), flds[2], func(dir string) {
+					rl.tightenMemory(readCgroupInt(filepath.Join(dir, `memory.limit_in_bytes`)))
+				})
+			case `cpu`:
+				walkCgroup(filepath.Join(root, flds[1]), flds[2], func(dir string) {
+					quota := readCgroupInt(filepath.Join(dir, `cpu.cfs_quota_us`))
+					period := readCgroupInt(filepath.Join(dir, `cpu.cfs_period_us`))
+					if quota > 0 && period > 0 {
+						rl.tightenCPU(float64(quota) / float64(period))
+					}
+				})
+			}
+		}
+	}
+	return
+}
+
+// walkCgroup calls fn for the cgroup directory and each of its parents up to the mount.
+// Inside a container the cgroup path is often not visible, so the mount itself is always checked.
+func walkCgroup(mnt, cg string, fn func(string)) {
+	for cg = path.Clean(`/` + cg); ; cg = path.Dir(cg) {
+		if dir := filepath.Join(mnt, filepath.FromSlash(cg)); isDir(dir) {
+			fn(dir)
+		}
+		if cg == `/` {
+			return
+		}
+	}
+}
+
+func (rl *ResourceLimits) tightenMemory(v int64) {
+	if v > 0 && v < cgroupUnlimited && (rl.Memory == 0 || v < rl.Memory) {
+		rl.Memory = v
+	}
+}
+
+func (rl *ResourceLimits) tightenCPU(v float64) {
+	if v > 0 && (rl.CPUs == 0 || v < rl.CPUs) {
+		rl.CPUs = v
+	}
+}
+
+// readCgroupInt reads a single integer value, "max" and errors come back as 0
+func readCgroupInt(p string) int64 {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// readCPUMax reads a cgroup v2 cpu.max file, which holds "$QUOTA $PERIOD"
+func readCPUMax(p string) float64 {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return 0
+	}
+	flds := strings.Fields(string(b))
+	if len(flds) != 2 {
+		return 0
+	}
+	quota, err := strconv.ParseInt(flds[0], 10, 64)
+	if err != nil {
+		return 0 //max
+	}
+	period, err := strconv.ParseInt(flds[1], 10, 64)
+	if err != nil || period <= 0 {
+		return 0
+	}
+	return float64(quota) / float64(period)
+}
+
+func isDir(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,129 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupFiles(t *testing.T, root string, files map[string]string) {
+	for k, v := range files {
+		p := filepath.Join(root, k)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		} else if err = ioutil.WriteFile(p, []byte(v), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCgroupV2Limits(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, root, map[string]string{
+		`self`:                            "0::/kubepods/pod1/ctr\n",
+		`fs/kubepods/memory.max`:          "max\n",
+		`fs/kubepods/cpu.max`:             "max 100000\n",
+		`fs/kubepods/pod1/memory.max`:     "1073741824\n",
+		`fs/kubepods/pod1/cpu.max`:        "400000 100000\n",
+		`fs/kubepods/pod1/ctr/memory.max`: "536870912\n",
+		`fs/kubepods/pod1/ctr/cpu.max`:    "150000 100000\n",
+	})
+	rl := cgroupLimits(filepath.Join(root, `fs`), filepath.Join(root, `self`))
+	if rl.Memory != 512*mb {
+		t.Fatalf("bad memory limit: %d", rl.Memory)
+	} else if rl.CPUs != 1.5 || rl.Procs() != 2 {
+		t.Fatalf("bad cpu limit: %v %d", rl.CPUs, rl.Procs())
+	}
+}
+
+func TestCgroupV1Limits(t *testing.T) {
+	root := t.TempDir()
+	//the container's own cgroup path is not visible, only the mount
+	writeCgroupFiles(t, root, map[string]string{
+		`self`:                             "12:memory:/docker/abc\n4:cpu,cpuacct:/docker/abc\n1:name=systemd:/docker/abc\n",
+		`fs/memory/memory.limit_in_bytes`:  "268435456\n",
+		`fs/cpu,cpuacct/cpu.cfs_quota_us`:  "200000\n",
+		`fs/cpu,cpuacct/cpu.cfs_period_us`: "100000\n",
+	})
+	rl := cgroupLimits(filepath.Join(root, `fs`), filepath.Join(root, `self`))
+	if rl.Memory != 256*mb {
+		t.Fatalf("bad memory limit: %d", rl.Memory)
+	} else if rl.Procs() != 2 {
+		t.Fatalf("bad cpu limit: %v", rl.CPUs)
+	}
+
+	//unlimited
+	writeCgroupFiles(t, root, map[string]string{
+		`fs/memory/memory.limit_in_bytes`: "9223372036854771712\n",
+		`fs/cpu,cpuacct/cpu.cfs_quota_us`: "-1\n",
+	})
+	if rl = cgroupLimits(filepath.Join(root, `fs`), filepath.Join(root, `self`)); rl.Limited() {
+		t.Fatalf("unlimited cgroup reported limits: %+v", rl)
+	}
+}
+
+func TestResourceSizing(t *testing.T) {
+	var rl ResourceLimits
+	if rl.CacheDepth(CACHE_DEPTH_DEFAULT) != CACHE_DEPTH_DEFAULT || rl.CacheSize(CACHE_SIZE_DEFAULT) != CACHE_SIZE_DEFAULT || rl.Procs() != 0 {
+		t.Fatal("unlimited sizing changed the defaults")
+	}
+	rl.Memory = 64 * mb
+	if d := rl.CacheDepth(CACHE_DEPTH_DEFAULT); d != 64 {
+		t.Fatalf("bad cache depth: %d", d)
+	} else if sz := rl.CacheSize(CACHE_SIZE_DEFAULT); sz != minCacheSize {
+		t.Fatalf("bad cache size: %d", sz)
+	}
+	rl.Memory = 8 * mb
+	if d := rl.CacheDepth(CACHE_DEPTH_DEFAULT); d != minCacheDepth {
+		t.Fatalf("bad cache depth: %d", d)
+	}
+	rl.Memory = 64 * gb
+	if d := rl.CacheDepth(CACHE_DEPTH_DEFAULT); d != CACHE_DEPTH_DEFAULT {
+		t.Fatalf("bad cache depth: %d", d)
+	} else if sz := rl.CacheSize(CACHE_SIZE_DEFAULT); sz != CACHE_SIZE_DEFAULT {
+		t.Fatalf("bad cache size: %d", sz)
+	}
+}
+
+func TestResourceOverrides(t *testing.T) {
+	ic := IngestConfig{
+		Disable_Resource_Limits: true,
+		Memory_Limit:            `128MB`,
+		Ingest_Cache_Path:       `/tmp/cache`,
+	}
+	if err := ic.applyResourceLimits(); err != nil {
+		t.Fatal(err)
+	} else if ic.Cache_Depth != 128 || ic.Max_Ingest_Cache != 64 {
+		t.Fatalf("bad sizing: %d %d", ic.Cache_Depth, ic.Max_Ingest_Cache)
+	}
+
+	//explicit values are left alone
+	ic = IngestConfig{
+		Memory_Limit:     `16MB`,
+		Cache_Depth:      1000,
+		Max_Ingest_Cache: 5000,
+	}
+	if err := ic.applyResourceLimits(); err != nil {
+		t.Fatal(err)
+	} else if ic.Cache_Depth != 1000 || ic.Max_Ingest_Cache != 5000 {
+		t.Fatalf("overrode explicit values: %d %d", ic.Cache_Depth, ic.Max_Ingest_Cache)
+	}
+
+	ic = IngestConfig{Memory_Limit: `lots`}
+	if err := ic.applyResourceLimits(); err != ErrInvalidMemoryLimit {
+		t.Fatalf("bad memory limit not caught: %v", err)
+	}
+	ic = IngestConfig{Max_Procs: -1}
+	if err := ic.applyResourceLimits(); err != ErrInvalidMaxProcs {
+		t.Fatalf("bad max procs not caught: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+var (
+	ErrSecondaryNoTargets       = errors.New("Secondary-Tag and Secondary-Only-Tag require a secondary backend target")
+	ErrSecondaryCachePath       = errors.New("Secondary-Ingest-Cache-Path must differ from Ingest-Cache-Path")
+	ErrDuplicateSecondaryTag    = errors.New("Tag is in both Secondary-Tag and Secondary-Only-Tag")
+	ErrInvalidSecondaryTagMatch = errors.New("Invalid secondary tag pattern")
+)
+
+// SecondaryEnabled returns true if a secondary group of indexers is configured.
+// Entries with tags matching Secondary-Tag are written to both the primary and secondary
+// indexers, entries matching Secondary-Only-Tag are written only to the secondary indexers.
+// If neither is set every entry is written to both.
+func (ic *IngestConfig) SecondaryEnabled() bool {
+	return len(ic.Secondary_Cleartext_Backend_Target)+len(ic.Secondary_Encrypted_Backend_Target) > 0
+}
+
+// SecondaryTargets returns the secondary indexer targets, prepended with the connection type.
+func (ic *IngestConfig) SecondaryTargets() ([]string, error) {
+	var conns []string
+	for _, v := range ic.Secondary_Cleartext_Backend_Target {
+		conns = append(conns, "tcp://"+AppendDefaultPort(v, DefaultCleartextPort))
+	}
+	for _, v := range ic.Secondary_Encrypted_Backend_Target {
+		conns = append(conns, "tls://"+AppendDefaultPort(v, DefaultTLSPort))
+	}
+	if len(conns) == 0 {
+		return nil, ErrNoConnections
+	}
+	return conns, nil
+}
+
+// SecondarySecret returns the secret used to authenticate to the secondary indexers,
+// Ingest-Secret is used if Secondary-Ingest-Secret is not set.
+func (ic *IngestConfig) SecondarySecret() string {
+	if ic.Secondary_Ingest_Secret != `` {
+		return ic.Secondary_Ingest_Secret
+	}
+	return ic.Ingest_Secret
+}
+
+// SecondaryCacheSize returns the secondary cache size in MB, defaulting to Max-Ingest-Cache.
+func (ic *IngestConfig) SecondaryCacheSize() int {
+	if ic.Secondary_Max_Ingest_Cache > 0 {
+		return ic.Secondary_Max_Ingest_Cache
+	}
+	return ic.Max_Ingest_Cache
+}
+
+func (ic *IngestConfig) verifySecondary() error {
+	if !ic.SecondaryEnabled() {
+		if len(ic.Secondary_Tag) > 0 || len(ic.Secondary_Only_Tag) > 0 {
+			return ErrSecondaryNoTargets
+		}
+		return nil
+	}
+	if ic.Secondary_Max_Ingest_Cache < 0 {
+		return errors.New("Secondary-Max-Ingest-Cache must not be negative")
+	}
+	if ic.Secondary_Ingest_Cache_Path != `` && ic.Ingest_Cache_Path != `` &&
+		filepath.Clean(ic.Secondary_Ingest_Cache_Path) == filepath.Clean(ic.Ingest_Cache_Path) {
+		return ErrSecondaryCachePath
+	}
+	seen := make(map[string]bool, len(ic.Secondary_Tag))
+	for _, v := range ic.Secondary_Tag {
+		if err := checkTagMatch(v); err != nil {
+			return err
+		}
+		seen[v] = true
+	}
+	for _, v := range ic.Secondary_Only_Tag {
+		if err := checkTagMatch(v); err != nil {
+			return err
+		} else if seen[v] {
+			return fmt.Errorf("%w: %s", ErrDuplicateSecondaryTag, v)
+		}
+	}
+	return nil
+}
+
+// checkTagMatch validates a tag name or glob pattern such as "firewall-*"
+func checkTagMatch(v string) error {
+	if v == `` || strings.ContainsAny(v, " \t\r\n") {
+		return fmt.Errorf("%w %q", ErrInvalidSecondaryTagMatch, v)
+	} else if _, err := glob.Compile(v); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidSecondaryTagMatch, v, err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifySecondary(t *testing.T) {
+	var ic IngestConfig
+	if err := ic.verifySecondary(); err != nil || ic.SecondaryEnabled() {
+		t.Fatalf("bad empty secondary: %v", err)
+	}
+	ic.Secondary_Tag = []string{`fw-*`}
+	if err := ic.verifySecondary(); err != ErrSecondaryNoTargets {
+		t.Fatalf("failed to catch missing targets: %v", err)
+	}
+
+	ic.Ingest_Secret = `foo`
+	ic.Max_Ingest_Cache = 100
+	ic.Secondary_Cleartext_Backend_Target = []string{`10.0.0.1`}
+	ic.Secondary_Encrypted_Backend_Target = []string{`10.0.0.2:4000`}
+	ic.Secondary_Only_Tag = []string{`bulk`}
+	if err := ic.verifySecondary(); err != nil {
+		t.Fatal(err)
+	}
+	if tgts, err := ic.SecondaryTargets(); err != nil {
+		t.Fatal(err)
+	} else if len(tgts) != 2 || tgts[0] != `tcp://10.0.0.1:4023` || tgts[1] != `tls://10.0.0.2:4000` {
+		t.Fatalf("bad secondary targets: %v", tgts)
+	}
+	if ic.SecondarySecret() != `foo` || ic.SecondaryCacheSize() != 100 {
+		t.Fatal("secondary defaults not inherited")
+	}
+	ic.Secondary_Ingest_Secret = `bar`
+	ic.Secondary_Max_Ingest_Cache = 10
+	if ic.SecondarySecret() != `bar` || ic.SecondaryCacheSize() != 10 {
+		t.Fatal("secondary overrides ignored")
+	}
+
+	ic.Ingest_Cache_Path = `/opt/gravwell/cache/`
+	ic.Secondary_Ingest_Cache_Path = `/opt/gravwell/cache`
+	if err := ic.verifySecondary(); err != ErrSecondaryCachePath {
+		t.Fatalf("failed to catch shared cache path: %v", err)
+	}
+	ic.Secondary_Ingest_Cache_Path = `/opt/gravwell/cache-dr`
+
+	ic.Secondary_Only_Tag = append(ic.Secondary_Only_Tag, `fw-*`)
+	if err := ic.verifySecondary(); !errors.Is(err, ErrDuplicateSecondaryTag) {
+		t.Fatalf("failed to catch duplicate tag: %v", err)
+	}
+	ic.Secondary_Only_Tag = []string{`fw-[`}
+	if err := ic.verifySecondary(); !errors.Is(err, ErrInvalidSecondaryTagMatch) {
+		t.Fatalf("failed to catch bad pattern: %v", err)
+	}
+}
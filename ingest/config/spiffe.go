@@ -0,0 +1,48 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+
+	"github.com/gravwell/gravwell/v3/ingest/spiffe"
+)
+
+var (
+	ErrSPIFFEMissingIndexerID = errors.New("SPIFFE-Endpoint-Socket requires at least one SPIFFE-Indexer-ID")
+	ErrSPIFFECleartextTarget  = errors.New("SPIFFE identities require encrypted backend targets, cleartext targets are not allowed")
+)
+
+// SPIFFEEnabled reports whether indexer connections use SPIFFE identities for mutual TLS
+func (isc IngestStreamConfig) SPIFFEEnabled() bool {
+	return len(isc.SPIFFE_Indexer_ID) > 0
+}
+
+// SPIFFEIndexers parses the SPIFFE IDs, or trust domains, indexers are allowed to present
+func (isc IngestStreamConfig) SPIFFEIndexers() (spiffe.Matcher, error) {
+	return spiffe.ParseMatcher(isc.SPIFFE_Indexer_ID)
+}
+
+func (ic *IngestConfig) verifySPIFFE() error {
+	if !ic.SPIFFEEnabled() {
+		if ic.SPIFFE_Endpoint_Socket != `` {
+			return ErrSPIFFEMissingIndexerID
+		}
+		return nil
+	}
+	if _, err := ic.SPIFFEIndexers(); err != nil {
+		return err
+	} else if _, _, err = spiffe.ParseEndpoint(ic.SPIFFE_Endpoint_Socket); err != nil {
+		return err
+	}
+	if len(ic.Cleartext_Backend_Target) > 0 || len(ic.Secondary_Cleartext_Backend_Target) > 0 {
+		return ErrSPIFFECleartextTarget
+	}
+	return nil
+}
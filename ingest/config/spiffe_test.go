@@ -0,0 +1,49 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/spiffe"
+)
+
+func TestSPIFFEConfig(t *testing.T) {
+	var ic IngestConfig
+	ic.Encrypted_Backend_Target = []string{`10.0.0.1:4024`}
+	if err := ic.verifySPIFFE(); err != nil || ic.SPIFFEEnabled() {
+		t.Fatalf("SPIFFE enabled without any settings: %v", err)
+	}
+	ic.SPIFFE_Endpoint_Socket = `unix:///run/spire/agent.sock`
+	if err := ic.verifySPIFFE(); err != ErrSPIFFEMissingIndexerID {
+		t.Fatalf("missing indexer IDs not caught: %v", err)
+	}
+	ic.SPIFFE_Indexer_ID = []string{`spiffe://example.org/gravwell/indexer`, `spiffe://dr.example.org`}
+	if err := ic.verifySPIFFE(); err != nil {
+		t.Fatal(err)
+	} else if m, err := ic.SPIFFEIndexers(); err != nil || len(m) != 2 {
+		t.Fatalf("bad indexer IDs %v %v", m, err)
+	}
+
+	ic.Cleartext_Backend_Target = []string{`10.0.0.2:4023`}
+	if err := ic.verifySPIFFE(); err != ErrSPIFFECleartextTarget {
+		t.Fatalf("cleartext target not caught: %v", err)
+	}
+	ic.Cleartext_Backend_Target = nil
+	ic.SPIFFE_Indexer_ID = []string{`example.org`}
+	if err := ic.verifySPIFFE(); !errors.Is(err, spiffe.ErrInvalidID) {
+		t.Fatalf("bad indexer ID not caught: %v", err)
+	}
+	ic.SPIFFE_Indexer_ID = []string{`spiffe://example.org`}
+	ic.SPIFFE_Endpoint_Socket = `/run/spire/agent.sock`
+	if err := ic.verifySPIFFE(); !errors.Is(err, spiffe.ErrInvalidEndpoint) {
+		t.Fatalf("bad endpoint not caught: %v", err)
+	}
+}
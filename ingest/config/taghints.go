@@ -0,0 +1,133 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	VolumeClassLow    = `low`
+	VolumeClassMedium = `medium`
+	VolumeClassHigh   = `high`
+
+	tagHintSplit     = `:`
+	tagHintItemSplit = `,`
+	tagHintKVSplit   = `=`
+	maxTagHintValue  = 256
+)
+
+var (
+	ErrInvalidTagHint        = errors.New("Tag hint must be of the form tag:key=value[,key=value]")
+	ErrInvalidVolumeClass    = errors.New("Tag hint volume must be low, medium, or high")
+	ErrEmptyTagHint          = errors.New("Tag hint does not specify a well, volume, or shard-key")
+	ErrInvalidTagHintValue   = errors.New("Tag hint values may only contain letters, numbers, '-', '_', and '.'")
+	ErrDuplicateTagHint      = errors.New("Duplicate tag hint")
+	ErrUnknownTagHintOptions = errors.New("Unknown tag hint option, must be well, volume, or shard-key")
+)
+
+// TagHint carries storage placement hints for a single tag.  Hints are advisory,
+// indexers may use them to route new tags to a well and/or shard without a manual
+// indexer side mapping for every tag.
+type TagHint struct {
+	Tag          string
+	Well         string `json:",omitempty"` // desired well
+	Volume_Class string `json:",omitempty"` // expected volume: low, medium, high
+	Shard_Key    string `json:",omitempty"` // name of the field to shard on
+}
+
+// ParseTagHint parses a tag hint specification of the form:
+//
+//	tag:well=name,volume=high,shard-key=field
+func ParseTagHint(v string) (th TagHint, err error) {
+	idx := strings.Index(v, tagHintSplit)
+	if idx <= 0 {
+		err = ErrInvalidTagHint
+		return
+	}
+	th.Tag = strings.TrimSpace(v[:idx])
+	for _, item := range strings.Split(v[idx+1:], tagHintItemSplit) {
+		if item = strings.TrimSpace(item); item == `` {
+			continue
+		}
+		bits := strings.SplitN(item, tagHintKVSplit, 2)
+		if len(bits) != 2 {
+			err = ErrInvalidTagHint
+			return
+		}
+		val := strings.TrimSpace(bits[1])
+		switch strings.ToLower(strings.TrimSpace(bits[0])) {
+		case `well`:
+			th.Well = val
+		case `volume`, `volume-class`, `volume_class`:
+			th.Volume_Class = strings.ToLower(val)
+		case `shard-key`, `shard_key`, `shard`:
+			th.Shard_Key = val
+		default:
+			err = fmt.Errorf("%w %q", ErrUnknownTagHintOptions, bits[0])
+			return
+		}
+	}
+	err = th.Validate()
+	return
+}
+
+// Validate checks that the hint specifies a tag and at least one valid hint.
+func (th TagHint) Validate() error {
+	if th.Tag == `` || strings.ContainsAny(th.Tag, " \t\r\n") {
+		return ErrInvalidTagHint
+	}
+	if th.Well == `` && th.Volume_Class == `` && th.Shard_Key == `` {
+		return ErrEmptyTagHint
+	}
+	switch th.Volume_Class {
+	case ``, VolumeClassLow, VolumeClassMedium, VolumeClassHigh:
+	default:
+		return ErrInvalidVolumeClass
+	}
+	if !validHintValue(th.Well) || !validHintValue(th.Shard_Key) {
+		return ErrInvalidTagHintValue
+	}
+	return nil
+}
+
+func validHintValue(v string) bool {
+	if len(v) > maxTagHintValue {
+		return false
+	}
+	for _, r := range v {
+		if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') && r != '-' && r != '_' && r != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// TagHints parses and returns the set of Tag-Hint specifications.
+func (isc IngestStreamConfig) TagHints() (hints []TagHint, err error) {
+	if len(isc.Tag_Hint) == 0 {
+		return
+	}
+	seen := make(map[string]bool, len(isc.Tag_Hint))
+	for _, v := range isc.Tag_Hint {
+		var th TagHint
+		if th, err = ParseTagHint(v); err != nil {
+			err = fmt.Errorf("Invalid Tag-Hint %q: %w", v, err)
+			return
+		} else if seen[th.Tag] {
+			err = fmt.Errorf("%w for %s", ErrDuplicateTagHint, th.Tag)
+			return
+		}
+		seen[th.Tag] = true
+		hints = append(hints, th)
+	}
+	return
+}
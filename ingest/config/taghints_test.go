@@ -0,0 +1,64 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestParseTagHint(t *testing.T) {
+	good := map[string]TagHint{
+		`syslog:well=netlogs`:                               TagHint{Tag: `syslog`, Well: `netlogs`},
+		` syslog : Well = netlogs, volume=HIGH `:            TagHint{Tag: `syslog`, Well: `netlogs`, Volume_Class: VolumeClassHigh},
+		`winlog:shard-key=Computer`:                         TagHint{Tag: `winlog`, Shard_Key: `Computer`},
+		`json:well=j,volume=low,shard-key=host.name,`:       TagHint{Tag: `json`, Well: `j`, Volume_Class: VolumeClassLow, Shard_Key: `host.name`},
+		`pcap:volume-class=medium`:                          TagHint{Tag: `pcap`, Volume_Class: VolumeClassMedium},
+		`zeekconn:well=zeek,shard_key=uid,volume_class=low`: TagHint{Tag: `zeekconn`, Well: `zeek`, Volume_Class: VolumeClassLow, Shard_Key: `uid`},
+	}
+	for v, exp := range good {
+		if th, err := ParseTagHint(v); err != nil {
+			t.Fatalf("failed to parse %q: %v", v, err)
+		} else if th != exp {
+			t.Fatalf("bad parse of %q: %+v != %+v", v, th, exp)
+		}
+	}
+	bad := []string{
+		``,
+		`syslog`,
+		`:well=foo`,
+		`syslog:`,
+		`syslog:well`,
+		`syslog:volume=huge`,
+		`syslog:well=foo bar`,
+		`syslog:color=blue`,
+		`sys log:well=foo`,
+	}
+	for _, v := range bad {
+		if _, err := ParseTagHint(v); err == nil {
+			t.Fatalf("failed to catch bad hint %q", v)
+		}
+	}
+}
+
+func TestTagHintsConfig(t *testing.T) {
+	var isc IngestStreamConfig
+	if hints, err := isc.TagHints(); err != nil || len(hints) != 0 {
+		t.Fatalf("bad empty hints: %v %v", hints, err)
+	}
+	isc.Tag_Hint = []string{`a:well=foo`, `b:volume=high`}
+	if hints, err := isc.TagHints(); err != nil {
+		t.Fatal(err)
+	} else if len(hints) != 2 || hints[0].Tag != `a` || hints[1].Volume_Class != VolumeClassHigh {
+		t.Fatalf("bad hints: %+v", hints)
+	}
+	isc.Tag_Hint = append(isc.Tag_Hint, `a:well=bar`)
+	if _, err := isc.TagHints(); err == nil {
+		t.Fatal("failed to catch duplicate hint")
+	}
+}
@@ -0,0 +1,82 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	PriorityHigh   = `high`
+	PriorityNormal = `normal`
+	PriorityLow    = `low`
+)
+
+var (
+	ErrInvalidTagPriority   = errors.New("Tag priority must be of the form tag:high, tag:normal, or tag:low")
+	ErrDuplicateTagPriority = errors.New("Duplicate tag priority")
+)
+
+// TagPriority assigns a priority class to every entry sent with a tag.  During congestion
+// the muxer sends high priority entries first and low priority entries last.
+type TagPriority struct {
+	Tag      string
+	Priority string
+}
+
+// ParseTagPriority parses a tag priority specification of the form:
+//
+//	tag:high
+func ParseTagPriority(v string) (tp TagPriority, err error) {
+	bits := strings.Split(v, tagHintSplit)
+	if len(bits) != 2 {
+		err = ErrInvalidTagPriority
+		return
+	}
+	tp.Tag = strings.TrimSpace(bits[0])
+	tp.Priority = strings.ToLower(strings.TrimSpace(bits[1]))
+	err = tp.Validate()
+	return
+}
+
+// Validate checks that the tag priority names a tag and a known priority class.
+func (tp TagPriority) Validate() error {
+	if tp.Tag == `` || strings.ContainsAny(tp.Tag, " \t\r\n") {
+		return ErrInvalidTagPriority
+	}
+	switch tp.Priority {
+	case PriorityHigh, PriorityNormal, PriorityLow:
+	default:
+		return ErrInvalidTagPriority
+	}
+	return nil
+}
+
+// TagPriorities parses and returns the set of Tag-Priority specifications.
+func (isc IngestStreamConfig) TagPriorities() (tps []TagPriority, err error) {
+	if len(isc.Tag_Priority) == 0 {
+		return
+	}
+	seen := make(map[string]bool, len(isc.Tag_Priority))
+	for _, v := range isc.Tag_Priority {
+		var tp TagPriority
+		if tp, err = ParseTagPriority(v); err != nil {
+			err = fmt.Errorf("Invalid Tag-Priority %q: %w", v, err)
+			return
+		} else if seen[tp.Tag] {
+			err = fmt.Errorf("%w for %s", ErrDuplicateTagPriority, tp.Tag)
+			return
+		}
+		seen[tp.Tag] = true
+		tps = append(tps, tp)
+	}
+	return
+}
@@ -0,0 +1,59 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"testing"
+)
+
+func TestParseTagPriority(t *testing.T) {
+	good := map[string]TagPriority{
+		`auth:high`:       TagPriority{Tag: `auth`, Priority: PriorityHigh},
+		` netflow : LOW `: TagPriority{Tag: `netflow`, Priority: PriorityLow},
+		`syslog:normal`:   TagPriority{Tag: `syslog`, Priority: PriorityNormal},
+	}
+	for v, exp := range good {
+		if tp, err := ParseTagPriority(v); err != nil {
+			t.Fatalf("failed to parse %q: %v", v, err)
+		} else if tp != exp {
+			t.Fatalf("bad parse of %q: %+v != %+v", v, tp, exp)
+		}
+	}
+	bad := []string{
+		``,
+		`auth`,
+		`:high`,
+		`auth:`,
+		`auth:urgent`,
+		`auth:high:low`,
+		`au th:high`,
+	}
+	for _, v := range bad {
+		if _, err := ParseTagPriority(v); err == nil {
+			t.Fatalf("failed to catch bad priority %q", v)
+		}
+	}
+}
+
+func TestTagPrioritiesConfig(t *testing.T) {
+	var isc IngestStreamConfig
+	if tps, err := isc.TagPriorities(); err != nil || len(tps) != 0 {
+		t.Fatalf("bad empty priorities: %v %v", tps, err)
+	}
+	isc.Tag_Priority = []string{`a:high`, `b:low`}
+	if tps, err := isc.TagPriorities(); err != nil {
+		t.Fatal(err)
+	} else if len(tps) != 2 || tps[0].Priority != PriorityHigh || tps[1].Tag != `b` {
+		t.Fatalf("bad priorities: %+v", tps)
+	}
+	isc.Tag_Priority = append(isc.Tag_Priority, `a:low`)
+	if _, err := isc.TagPriorities(); err == nil {
+		t.Fatal("failed to catch duplicate priority")
+	}
+}
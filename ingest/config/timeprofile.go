@@ -0,0 +1,76 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+var (
+	ErrUnknownTimeFormatProfile = errors.New("unknown time format profile")
+)
+
+// TimeFormatProfile is a named group of time formats, listeners that select a profile only try
+// the formats in it.  Formats may be built-in formats or custom formats:
+//
+//	[TimeFormatProfile "cisco"]
+//		Format=Syslog
+//		Format=RFC3339
+//		Format=ciscoasa #a TimeFormat definition
+type TimeFormatProfile struct {
+	Format []string
+}
+
+type TimeFormatProfiles map[string]*TimeFormatProfile
+
+// Validate checks that every profile names at least one format and that each format is either
+// built-in or defined in ctf.  When formats are also loaded from a format directory, dynamic
+// should be set and unknown names are allowed since the directory may provide them.
+func (tfp TimeFormatProfiles) Validate(ctf CustomTimeFormat, dynamic bool) error {
+	for k, v := range tfp {
+		if v == nil || len(v.Format) == 0 {
+			return fmt.Errorf("TimeFormatProfile %s has no formats", k)
+		}
+		for _, f := range v.Format {
+			if f = strings.TrimSpace(f); f == `` {
+				return fmt.Errorf("TimeFormatProfile %s has an empty format", k)
+			} else if dynamic || timegrinder.ValidateFormatOverride(f) == nil || ctf.has(f) {
+				continue
+			}
+			return fmt.Errorf("TimeFormatProfile %s format %q is not a built-in or custom time format", k, f)
+		}
+	}
+	return nil
+}
+
+// Formats returns the formats in the named profile, an empty name returns no formats
+func (tfp TimeFormatProfiles) Formats(name string) ([]string, error) {
+	if name = strings.TrimSpace(name); name == `` {
+		return nil, nil
+	}
+	for k, v := range tfp {
+		if strings.EqualFold(k, name) && v != nil {
+			return v.Format, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownTimeFormatProfile, name)
+}
+
+func (ctf CustomTimeFormat) has(name string) bool {
+	for k, v := range ctf {
+		if v != nil && strings.EqualFold(k, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTimeFormatProfiles(t *testing.T) {
+	ctf := CustomTimeFormat{
+		`ciscoasa`: &TimeFormat{Format: `Jan 02 2006 15:04:05`, Regex: `\w{3} \d{2} \d{4} \d{2}:\d{2}:\d{2}`},
+	}
+	tfp := TimeFormatProfiles{
+		`cisco`:  &TimeFormatProfile{Format: []string{`syslog`, `RFC3339`, `CiscoASA`}},
+		`apache`: &TimeFormatProfile{Format: []string{`Apache`, `ApacheNoTz`}},
+	}
+	if err := tfp.Validate(ctf, false); err != nil {
+		t.Fatal(err)
+	}
+	if f, err := tfp.Formats(`Cisco`); err != nil || len(f) != 3 {
+		t.Fatalf("bad profile lookup: %v %v", f, err)
+	} else if f, err = tfp.Formats(``); err != nil || f != nil {
+		t.Fatalf("empty profile name returned formats: %v %v", f, err)
+	} else if _, err = tfp.Formats(`windows`); !errors.Is(err, ErrUnknownTimeFormatProfile) {
+		t.Fatalf("bad error on unknown profile: %v", err)
+	}
+
+	tfp[`windows`] = &TimeFormatProfile{Format: []string{`winevent`}}
+	if err := tfp.Validate(ctf, false); err == nil {
+		t.Fatal("failed to catch unknown format")
+	} else if err = tfp.Validate(ctf, true); err != nil {
+		t.Fatalf("format directory formats rejected: %v", err)
+	}
+	tfp[`windows`] = &TimeFormatProfile{}
+	if err := tfp.Validate(ctf, true); err == nil {
+		t.Fatal("failed to catch empty profile")
+	}
+}
@@ -0,0 +1,68 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
+)
+
+var (
+	ErrTraceOptionsOnly = errors.New("Trace-Sample-Rate, Trace-Service-Name, and Trace-Header require a Trace-Endpoint")
+	ErrInvalidTraceHdr  = errors.New("Invalid Trace-Header, must be Name: value")
+)
+
+// TracingEnabled returns true if sampled entries should be traced
+func (ic *IngestConfig) TracingEnabled() bool {
+	return ic.Trace_Endpoint != ``
+}
+
+// TraceConfig parses the Trace parameters, tracing is disabled if the returned config is not Enabled.
+// Spans are exported to Trace-Endpoint using OTLP over HTTP.
+func (ic *IngestConfig) TraceConfig() (tc tracing.Config, err error) {
+	if !ic.TracingEnabled() {
+		if ic.Trace_Sample_Rate != `` || ic.Trace_Service_Name != `` || len(ic.Trace_Header) > 0 {
+			err = ErrTraceOptionsOnly
+		}
+		return
+	}
+	tc.Endpoint = strings.TrimSpace(ic.Trace_Endpoint)
+	tc.Service = ic.Trace_Service_Name
+	if ic.Trace_Sample_Rate != `` {
+		if tc.SampleRate, err = strconv.ParseFloat(strings.TrimSpace(ic.Trace_Sample_Rate), 64); err != nil {
+			err = fmt.Errorf("Invalid Trace-Sample-Rate %q: %v", ic.Trace_Sample_Rate, err)
+			return
+		} else if tc.SampleRate <= 0 {
+			err = tracing.ErrInvalidSampleRate
+			return
+		}
+	}
+	for _, h := range ic.Trace_Header {
+		idx := strings.Index(h, `:`)
+		if idx <= 0 {
+			err = fmt.Errorf("%w %q", ErrInvalidTraceHdr, h)
+			return
+		}
+		name, val := strings.TrimSpace(h[:idx]), strings.TrimSpace(h[idx+1:])
+		if name == `` {
+			err = fmt.Errorf("%w %q", ErrInvalidTraceHdr, h)
+			return
+		}
+		if tc.Headers == nil {
+			tc.Headers = map[string]string{}
+		}
+		tc.Headers[name] = val
+	}
+	err = tc.Validate()
+	return
+}
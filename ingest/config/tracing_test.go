@@ -0,0 +1,57 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package config
+
+import (
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
+)
+
+func TestTraceConfig(t *testing.T) {
+	if tc, err := (&IngestConfig{}).TraceConfig(); err != nil || tc.Enabled() {
+		t.Fatalf("tracing enabled by default: %+v %v", tc, err)
+	}
+	ic := IngestConfig{
+		Trace_Endpoint: `http://collector:4318`,
+	}
+	if tc, err := ic.TraceConfig(); err != nil {
+		t.Fatal(err)
+	} else if tc.Endpoint != `http://collector:4318/v1/traces` || tc.SampleRate != tracing.DefaultSampleRate || tc.Service != tracing.DefaultService {
+		t.Fatalf("bad trace config defaults: %+v", tc)
+	}
+	ic.Trace_Endpoint = `https://collector/otlp/v1/traces`
+	ic.Trace_Sample_Rate = `0.5`
+	ic.Trace_Service_Name = `relay`
+	ic.Trace_Header = []string{`Authorization: Bearer abc:123`, `X-Scope:tenant`}
+	if tc, err := ic.TraceConfig(); err != nil {
+		t.Fatal(err)
+	} else if tc.Endpoint != ic.Trace_Endpoint || tc.SampleRate != 0.5 || tc.Service != `relay` {
+		t.Fatalf("bad trace config: %+v", tc)
+	} else if len(tc.Headers) != 2 || tc.Headers[`Authorization`] != `Bearer abc:123` || tc.Headers[`X-Scope`] != `tenant` {
+		t.Fatalf("bad trace headers: %+v", tc.Headers)
+	}
+
+	bad := []IngestConfig{
+		{Trace_Sample_Rate: `0.1`},
+		{Trace_Header: []string{`X-Scope: tenant`}},
+		{Trace_Endpoint: `collector:4318`},
+		{Trace_Endpoint: `udp://collector:4318`},
+		{Trace_Endpoint: `http://collector`, Trace_Sample_Rate: `lots`},
+		{Trace_Endpoint: `http://collector`, Trace_Sample_Rate: `0`},
+		{Trace_Endpoint: `http://collector`, Trace_Sample_Rate: `1.5`},
+		{Trace_Endpoint: `http://collector`, Trace_Header: []string{`no value`}},
+		{Trace_Endpoint: `http://collector`, Trace_Header: []string{`: value`}},
+	}
+	for _, b := range bad {
+		if _, err := b.TraceConfig(); err == nil {
+			t.Fatalf("failed to catch bad trace config %+v", b)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/renameio"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	seqStateFile = `seqstate`
+	// sequence numbers are reserved in blocks and the reservation is saved before any
+	// number in it is handed out, so a crash can never reuse a number that is in the cache
+	seqReserveBlock uint64 = 1 << 16
+	seqSaveInterval        = time.Second
+)
+
+// seqRange is an inclusive range of sequence numbers
+type seqRange struct {
+	Lo uint64
+	Hi uint64
+}
+
+// seqState is the persisted form of a seqTracker
+type seqState struct {
+	Reserved uint64     // highest sequence number that may have been handed out
+	HWM      uint64     // every sequence number at or below the high-water mark is acknowledged
+	Acked    []seqRange // acknowledged ranges above the high-water mark, sorted and disjoint
+}
+
+// seqTracker hands out entry sequence numbers and records which of them the indexers have
+// confirmed.  The record is saved next to the cache so that when a crash leaves already
+// delivered entries in the cache they are skipped rather than sent again on replay.
+type seqTracker struct {
+	sync.Mutex
+	path  string
+	next  uint64
+	state seqState
+	dirty bool
+}
+
+// newSeqTracker loads the sequence state in a cache directory, the acknowledgements are only
+// kept when the cache has entries left to replay.
+func newSeqTracker(cachePath string, replay bool) (*seqTracker, error) {
+	st := &seqTracker{
+		path: filepath.Join(cachePath, seqStateFile),
+	}
+	if replay {
+		if err := st.load(); err != nil {
+			return nil, err
+		}
+	}
+	st.next = st.state.Reserved + 1
+	if !replay {
+		st.state = seqState{}
+	}
+	st.Lock()
+	defer st.Unlock()
+	if err := st.reserve(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+func (st *seqTracker) load() error {
+	b, err := os.ReadFile(st.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Failed to open sequence state: %w", err)
+	} else if len(b) == 0 {
+		return nil
+	}
+	if err = gob.NewDecoder(bytes.NewReader(b)).Decode(&st.state); err != nil {
+		return fmt.Errorf("Could not decode sequence state: %w", err)
+	}
+	return nil
+}
+
+// reserve extends the reservation and saves it, the caller must hold the lock
+func (st *seqTracker) reserve() error {
+	st.state.Reserved = st.next + seqReserveBlock - 1
+	return st.saveLocked()
+}
+
+// assign hands out the next sequence numbers to a set of entries
+func (st *seqTracker) assign(ents ...*entry.Entry) error {
+	if st == nil {
+		return nil
+	}
+	st.Lock()
+	defer st.Unlock()
+	for _, ent := range ents {
+		if st.next > st.state.Reserved {
+			if err := st.reserve(); err != nil {
+				return err
+			}
+		}
+		ent.Seq = st.next
+		st.next++
+	}
+	return nil
+}
+
+// ack records that an indexer confirmed an entry
+func (st *seqTracker) ack(ent *entry.Entry) {
+	if st == nil || ent == nil || ent.Seq == 0 {
+		return
+	}
+	st.Lock()
+	st.state.add(ent.Seq)
+	st.dirty = true
+	st.Unlock()
+}
+
+// delivered reports whether an entry was already confirmed by an indexer
+func (st *seqTracker) delivered(ent *entry.Entry) (r bool) {
+	if st == nil || ent.Seq == 0 {
+		return false
+	}
+	st.Lock()
+	r = st.state.acked(ent.Seq)
+	st.Unlock()
+	return
+}
+
+// filter drops delivered entries from a batch, the batch is only copied if something is dropped
+func (st *seqTracker) filter(b []*entry.Entry) []*entry.Entry {
+	if st == nil {
+		return b
+	}
+	for i, ent := range b {
+		if ent == nil || !st.delivered(ent) {
+			continue
+		}
+		nb := append(make([]*entry.Entry, 0, len(b)-1), b[:i]...)
+		for _, ent = range b[i+1:] {
+			if ent == nil || !st.delivered(ent) {
+				nb = append(nb, ent)
+			}
+		}
+		return nb
+	}
+	return b
+}
+
+// save writes the state out if anything was acknowledged since the last save
+func (st *seqTracker) save() error {
+	if st == nil {
+		return nil
+	}
+	st.Lock()
+	defer st.Unlock()
+	if !st.dirty {
+		return nil
+	}
+	return st.saveLocked()
+}
+
+func (st *seqTracker) saveLocked() error {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(&st.state); err != nil {
+		return err
+	} else if err = renameio.WriteFile(st.path, b.Bytes(), 0660); err != nil {
+		return err
+	}
+	st.dirty = false
+	return nil
+}
+
+// remove deletes the saved state, used once the cache is empty and nothing can be replayed
+func (st *seqTracker) remove() {
+	if st == nil {
+		return
+	}
+	st.Lock()
+	os.Remove(st.path)
+	st.Unlock()
+}
+
+func (s *seqState) acked(seq uint64) bool {
+	if seq <= s.HWM {
+		return true
+	}
+	i := sort.Search(len(s.Acked), func(i int) bool { return s.Acked[i].Hi >= seq })
+	return i < len(s.Acked) && s.Acked[i].Lo <= seq
+}
+
+func (s *seqState) add(seq uint64) {
+	if seq <= s.HWM {
+		return
+	} else if seq == s.HWM+1 {
+		//the common case, acks arrive in order
+		s.HWM = seq
+	} else {
+		//first range that ends at or after the number before seq, it is the only one seq can touch
+		i := sort.Search(len(s.Acked), func(i int) bool { return s.Acked[i].Hi+1 >= seq })
+		switch {
+		case i == len(s.Acked) || s.Acked[i].Lo > seq+1:
+			s.Acked = append(s.Acked, seqRange{})
+			copy(s.Acked[i+1:], s.Acked[i:])
+			s.Acked[i] = seqRange{Lo: seq, Hi: seq}
+		case s.Acked[i].Lo <= seq && seq <= s.Acked[i].Hi:
+			return
+		case s.Acked[i].Hi+1 == seq:
+			s.Acked[i].Hi = seq
+			if i+1 < len(s.Acked) && s.Acked[i+1].Lo == seq+1 {
+				s.Acked[i].Hi = s.Acked[i+1].Hi
+				s.Acked = append(s.Acked[:i+1], s.Acked[i+2:]...)
+			}
+		default:
+			s.Acked[i].Lo = seq
+		}
+	}
+	//fold ranges that now touch the high-water mark into it
+	for len(s.Acked) > 0 && s.Acked[0].Lo <= s.HWM+1 {
+		if s.Acked[0].Hi > s.HWM {
+			s.HWM = s.Acked[0].Hi
+		}
+		s.Acked = s.Acked[1:]
+	}
+}
+
+// seqSaveRoutine periodically saves which entries the indexers have confirmed
+func (im *IngestMuxer) seqSaveRoutine() {
+	tkr := time.NewTicker(seqSaveInterval)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-im.dieChan:
+			return
+		case <-tkr.C:
+			if err := im.seqs.save(); err != nil {
+				im.Error("failed to save cache sequence state", log.KV("path", im.cachePath), log.KVErr(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/chancacher"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestSeqState(t *testing.T) {
+	var s seqState
+	for _, seq := range []uint64{1, 2, 5, 9, 7, 6, 2, 10, 12} {
+		s.add(seq)
+	}
+	if s.HWM != 2 || !reflect.DeepEqual(s.Acked, []seqRange{{5, 7}, {9, 10}, {12, 12}}) {
+		t.Fatalf("bad state: %+v", s)
+	}
+	for seq, ok := range map[uint64]bool{1: true, 3: false, 5: true, 8: false, 10: true, 11: false, 12: true, 13: false} {
+		if s.acked(seq) != ok {
+			t.Fatalf("bad ack status for %d", seq)
+		}
+	}
+	//filling the gaps folds everything into the high-water mark
+	for _, seq := range []uint64{8, 4, 11, 3} {
+		s.add(seq)
+	}
+	if s.HWM != 12 || len(s.Acked) != 0 {
+		t.Fatalf("ranges not folded: %+v", s)
+	}
+}
+
+func TestSeqTracker(t *testing.T) {
+	dir := t.TempDir()
+	st, err := newSeqTracker(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := make([]*entry.Entry, 5)
+	for i := range ents {
+		ents[i] = &entry.Entry{}
+	}
+	if err = st.assign(ents...); err != nil {
+		t.Fatal(err)
+	} else if ents[0].Seq != 1 || ents[4].Seq != 5 {
+		t.Fatalf("bad sequence numbers %d %d", ents[0].Seq, ents[4].Seq)
+	}
+	st.ack(ents[0])
+	st.ack(ents[3])
+	if err = st.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	//a replay picks up the acknowledgements and never reuses a reserved number
+	if st, err = newSeqTracker(dir, true); err != nil {
+		t.Fatal(err)
+	}
+	var delivered []int
+	for i, ent := range ents {
+		if st.delivered(ent) {
+			delivered = append(delivered, i)
+		}
+	}
+	if !reflect.DeepEqual(delivered, []int{0, 3}) {
+		t.Fatalf("bad delivered entries %v", delivered)
+	} else if b := st.filter(ents); len(b) != 3 || b[0] != ents[1] || b[2] != ents[4] {
+		t.Fatalf("bad filtered batch %v", b)
+	}
+	ent := &entry.Entry{}
+	if err = st.assign(ent); err != nil {
+		t.Fatal(err)
+	} else if ent.Seq <= seqReserveBlock {
+		t.Fatalf("sequence number %d reused a reservation", ent.Seq)
+	}
+
+	//without anything to replay the old acknowledgements are dropped
+	if st, err = newSeqTracker(dir, false); err != nil {
+		t.Fatal(err)
+	} else if st.delivered(ents[0]) {
+		t.Fatal("stale acknowledgements kept")
+	}
+	st.remove()
+	if _, err = os.Stat(filepath.Join(dir, seqStateFile)); !os.IsNotExist(err) {
+		t.Fatalf("sequence state not removed: %v", err)
+	}
+}
+
+func TestEntryCacheCodecSeq(t *testing.T) {
+	var cd entryCacheCodec
+	ent := &entry.Entry{TS: entry.Now(), Tag: 1, Data: []byte("hello"), Seq: 99}
+	blk := []*entry.Entry{{TS: entry.Now(), Data: []byte("a"), Seq: 100}, {TS: entry.Now(), Data: []byte("b")}}
+	var bb bytes.Buffer
+	if err := cd.Encode(&bb, ent); err != nil {
+		t.Fatal(err)
+	} else if err = cd.Encode(&bb, blk); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(&bb)
+	if v, err := cd.Decode(br); err != nil {
+		t.Fatal(err)
+	} else if got := v.(*entry.Entry); got.Seq != 99 || string(got.Data) != `hello` {
+		t.Fatalf("bad entry: %+v", got)
+	}
+	if v, err := cd.Decode(br); err != nil {
+		t.Fatal(err)
+	} else if got := v.([]*entry.Entry); len(got) != 2 || got[0].Seq != 100 || got[1].Seq != 0 || string(got[1].Data) != `b` {
+		t.Fatalf("bad block: %+v", got)
+	}
+}
+
+func TestCacheReplayDedup(t *testing.T) {
+	dir := t.TempDir()
+	//leave behind the cache of a muxer that crashed after some of its entries were confirmed
+	st, err := newSeqTracker(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc, err := chancacher.NewChanCacher(16, filepath.Join(dir, "e"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		ent := &entry.Entry{TS: entry.Now(), Tag: 0, Data: []byte(fmt.Sprintf("old %d", i))}
+		if err = st.assign(ent); err != nil {
+			t.Fatal(err)
+		} else if i < 6 || i == 8 {
+			st.ack(ent)
+		}
+		cc.In <- ent
+	}
+	close(cc.In)
+	cc.Commit()
+	for range cc.Out {
+		//wait for the cacher to let go of its lock
+	}
+	if err = st.save(); err != nil {
+		t.Fatal(err)
+	} else if err = writeTagCache(map[string]entry.EntryTag{`foo`: 0}, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	col := &serverCollector{ents: map[string][]string{}}
+	srv, err := NewIngestServer(ServerConfig{Secret: `foo`, Handler: col.handle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lst)
+
+	im, err := NewUniformMuxer(UniformMuxerConfig{
+		IngestStreamConfig: config.IngestStreamConfig{Cache_Dedup: true},
+		Destinations:       []string{`tcp://` + lst.Addr().String()},
+		Tags:               []string{`foo`},
+		Auth:               `foo`,
+		IngesterName:       `deduptest`,
+		CachePath:          dir,
+		CacheSize:          1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = im.Start(); err != nil {
+		t.Fatal(err)
+	} else if err = im.WaitForHot(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	foo, _ := im.GetTag(`foo`)
+	if err = im.WriteEntry(&entry.Entry{TS: entry.Now(), Tag: foo, Data: []byte("new")}); err != nil {
+		t.Fatal(err)
+	} else if err = im.Sync(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500 && col.count(`foo`) < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = im.Close(); err != nil {
+		t.Fatal(err)
+	}
+	col.Lock()
+	got := append([]string(nil), col.ents[`foo`]...)
+	col.Unlock()
+	sort.Strings(got)
+	if exp := []string{`new`, `old 6`, `old 7`, `old 9`}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("bad replay %v != %v", got, exp)
+	}
+	if _, err = os.Stat(filepath.Join(dir, seqStateFile)); !os.IsNotExist(err) {
+		t.Fatalf("sequence state left behind with an empty cache: %v", err)
+	}
+}
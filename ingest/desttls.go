@@ -0,0 +1,114 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+)
+
+var (
+	ErrSPKIPinMismatch = errors.New("indexer certificate does not match any pinned public key")
+)
+
+// destinationTLS finds the certificate verification settings for each destination
+func destinationTLS(isc config.IngestStreamConfig, dests []Target) (map[string]config.DestinationTLS, error) {
+	dts, err := isc.DestinationTLS()
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]config.DestinationTLS, len(dts))
+	for _, d := range dests {
+		for _, dt := range dts {
+			if dt.Matches(d.Address) {
+				m[d.Address] = dt
+				break
+			}
+		}
+	}
+	return m, nil
+}
+
+// applyDestinationTLS sets up verification of an indexer certificate using a destination's
+// CA bundle and pins, both override Insecure-Skip-TLS-Verify.  Pins without a CA bundle replace
+// chain verification so indexers with self signed certificates can be verified, when both are
+// set the chain has to verify and contain a pinned key.
+func applyDestinationTLS(tcfg *tls.Config, dt config.DestinationTLS) (err error) {
+	if dt.CAFile != `` {
+		if tcfg.RootCAs, err = dt.RootCAs(); err != nil {
+			return
+		}
+		tcfg.InsecureSkipVerify = false
+	} else if len(dt.Pins) > 0 {
+		tcfg.InsecureSkipVerify = true
+	}
+	if len(dt.Pins) > 0 {
+		pins := dt.Pins
+		tcfg.VerifyPeerCertificate = func(raw [][]byte, chains [][]*x509.Certificate) error {
+			return checkSPKIPins(pins, raw, chains)
+		}
+	}
+	return
+}
+
+// tlsOptions carries the per destination TLS settings for an indexer connection
+type tlsOptions struct {
+	dest config.DestinationTLS
+	id   *workloadIdentity // optional, authenticates with SPIFFE SVIDs
+}
+
+// verifies reports whether the indexer certificate is verified regardless of Insecure-Skip-TLS-Verify
+func (to tlsOptions) verifies() bool {
+	return to.dest.Verifies() || to.id != nil
+}
+
+// apply sets up the destination settings, SPIFFE verification is layered on top of any pins
+func (to tlsOptions) apply(tcfg *tls.Config) error {
+	if err := applyDestinationTLS(tcfg, to.dest); err != nil {
+		return err
+	} else if to.id == nil {
+		return nil
+	}
+	src, err := to.id.source()
+	if err != nil {
+		return err
+	}
+	src.ClientConfig(tcfg, to.id.indexers)
+	return nil
+}
+
+// checkSPKIPins looks for a pinned key in the verified chains, without verified chains only
+// the leaf is considered because it is the only certificate the peer proved it holds the key for.
+func checkSPKIPins(pins [][]byte, raw [][]byte, chains [][]*x509.Certificate) error {
+	var certs []*x509.Certificate
+	for _, chain := range chains {
+		certs = append(certs, chain...)
+	}
+	if len(chains) == 0 && len(raw) > 0 {
+		leaf, err := x509.ParseCertificate(raw[0])
+		if err != nil {
+			return err
+		}
+		certs = append(certs, leaf)
+	}
+	for _, c := range certs {
+		h := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(h[:], pin) {
+				return nil
+			}
+		}
+	}
+	return ErrSPKIPinMismatch
+}
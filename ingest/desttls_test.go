@@ -0,0 +1,146 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/spiffe"
+)
+
+// selfSignedIndexer starts a TLS listener with a self signed certificate that completes handshakes
+func selfSignedIndexer(t *testing.T) (addr string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: `indexer`},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	} else if cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen(`tcp`, `127.0.0.1:0`, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+	return l.Addr().String(), cert
+}
+
+func TestDestinationTLS(t *testing.T) {
+	addr, cert := selfSignedIndexer(t)
+	other, _ := selfSignedIndexer(t)
+	caFile := filepath.Join(t.TempDir(), `ca.pem`)
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pin := config.SPKIPin(cert)
+	wrongPin := `sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=`
+	isc := config.IngestStreamConfig{
+		Destination_CA_File:  []string{addr + `,` + caFile},
+		Destination_SPKI_Pin: []string{addr + `,` + wrongPin + `,` + pin},
+	}
+	dts, err := destinationTLS(isc, []Target{{Address: `tls://` + addr}, {Address: `tls://` + other}})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(dts) != 1 || dts[`tls://`+addr].CAFile != caFile || len(dts[`tls://`+addr].Pins) != 2 {
+		t.Fatalf("bad destination settings %+v", dts)
+	}
+
+	//without any settings the self signed certificate is refused
+	if _, _, err = newTlsConn(addr, nil, true, tlsOptions{}); err == nil {
+		t.Fatal("self signed certificate accepted")
+	}
+	good, err := config.ParseDestinationPins(addr + `,` + pin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := config.ParseDestinationPins(addr + `,` + wrongPin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dt := range []config.DestinationTLS{
+		dts[`tls://`+addr],             //CA bundle and pins
+		{Target: addr, CAFile: caFile}, //CA bundle only
+		good,                           //pins replace chain verification
+	} {
+		conn, _, err := newTlsConn(addr, nil, true, tlsOptions{dest: dt})
+		if err != nil {
+			t.Fatalf("failed to verify with %+v: %v", dt, err)
+		}
+		conn.Close()
+	}
+	//the settings override skipping verification
+	if _, _, err = newTlsConn(addr, nil, false, tlsOptions{dest: bad}); !errors.Is(err, ErrSPKIPinMismatch) {
+		t.Fatalf("pin mismatch not caught: %v", err)
+	} else if _, _, err = newTlsConn(other, nil, false, tlsOptions{dest: config.DestinationTLS{CAFile: caFile}}); err == nil {
+		t.Fatal("certificate from the wrong CA accepted")
+	}
+}
+
+func TestWorkloadIdentityConfig(t *testing.T) {
+	isc := config.IngestStreamConfig{
+		SPIFFE_Endpoint_Socket: `unix:///run/spire/agent.sock`,
+	}
+	if wi, err := newWorkloadIdentity(isc, []Target{{Address: `tls://10.0.0.1:4024`}}, nil); err != nil || wi != nil {
+		t.Fatalf("identity enabled without indexer IDs: %v", err)
+	}
+	isc.SPIFFE_Indexer_ID = []string{`spiffe://example.org`}
+	wi, err := newWorkloadIdentity(isc, []Target{{Address: `tls://10.0.0.1:4024`}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if !(tlsOptions{id: wi}).verifies() {
+		t.Fatal("SPIFFE identities do not count as verification")
+	}
+	wi.close()
+	if _, err = wi.source(); err != spiffe.ErrSourceClosed {
+		t.Fatalf("closed identity handed out a source: %v", err)
+	}
+	if _, err = newWorkloadIdentity(isc, []Target{{Address: `tcp://10.0.0.1:4023`}}, nil); err != ErrSPIFFECleartext {
+		t.Fatalf("cleartext destination not caught: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var (
+	ErrTagNotAllowed     = errors.New("Tag does not match any negotiated tag pattern")
+	ErrInvalidTagPattern = errors.New("Invalid tag pattern")
+)
+
+// tagPattern is a wildcard tag pattern, such as "k8s-*", negotiated up front so that
+// tags matching it can be created on demand
+type tagPattern struct {
+	pattern string
+	g       glob.Glob
+}
+
+func compileTagPattern(v string) (tp tagPattern, err error) {
+	if v = strings.TrimSpace(v); v == `` {
+		err = fmt.Errorf("%w: empty pattern", ErrInvalidTagPattern)
+		return
+	} else if strings.ContainsAny(v, " \t\r\n") {
+		err = fmt.Errorf("%w %q", ErrInvalidTagPattern, v)
+		return
+	}
+	tp.pattern = v
+	if tp.g, err = glob.Compile(v); err != nil {
+		err = fmt.Errorf("%w %q: %v", ErrInvalidTagPattern, v, err)
+	}
+	return
+}
+
+func compileTagPatterns(pats []string) (r []tagPattern, err error) {
+	for _, v := range pats {
+		var tp tagPattern
+		if tp, err = compileTagPattern(v); err != nil {
+			return
+		}
+		r = append(r, tp)
+	}
+	return
+}
+
+// AddTagPattern negotiates a wildcard tag pattern, tags which match it can then be created
+// with GetOrCreateTag.  Listeners which derive tags from data, such as Kafka topics or container
+// labels, use patterns so that every possible tag does not need to be listed in the config.
+func (im *IngestMuxer) AddTagPattern(pattern string) error {
+	tp, err := compileTagPattern(pattern)
+	if err != nil {
+		return err
+	}
+	im.mtx.Lock()
+	defer im.mtx.Unlock()
+	for _, v := range im.tagPatterns {
+		if v.pattern == tp.pattern {
+			return nil
+		}
+	}
+	im.tagPatterns = append(im.tagPatterns, tp)
+	return nil
+}
+
+// TagPatterns returns the negotiated wildcard tag patterns
+func (im *IngestMuxer) TagPatterns() (r []string) {
+	im.mtx.RLock()
+	defer im.mtx.RUnlock()
+	for _, v := range im.tagPatterns {
+		r = append(r, v.pattern)
+	}
+	return
+}
+
+// GetOrCreateTag returns the intermediary tag id for a tag, negotiating it if it has not been
+// seen before.  Unlike NegotiateTag, new tags must match a negotiated tag pattern, otherwise
+// ErrTagNotAllowed is returned and the indexers never see the tag.
+func (im *IngestMuxer) GetOrCreateTag(name string) (tg entry.EntryTag, err error) {
+	if tg, err = im.GetTag(name); err != ErrTagNotFound {
+		return
+	} else if err = CheckTag(name); err != nil {
+		return
+	} else if !im.tagPatternMatch(name) {
+		err = fmt.Errorf("%w: %q", ErrTagNotAllowed, name)
+		return
+	}
+	return im.NegotiateTag(name)
+}
+
+func (im *IngestMuxer) tagPatternMatch(name string) bool {
+	im.mtx.RLock()
+	defer im.mtx.RUnlock()
+	for _, v := range im.tagPatterns {
+		if v.g.Match(name) {
+			return true
+		}
+	}
+	return false
+}
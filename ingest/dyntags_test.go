@@ -0,0 +1,66 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGetOrCreateTag(t *testing.T) {
+	if _, err := NewUniformMuxer(UniformMuxerConfig{
+		Destinations: []string{`tcp://127.0.0.1:4023`},
+		Tags:         []string{`foo`},
+		TagPatterns:  []string{`kafka-[`},
+		Auth:         `foo`,
+	}); !errors.Is(err, ErrInvalidTagPattern) {
+		t.Fatalf("bad pattern not caught: %v", err)
+	}
+	im, err := NewUniformMuxer(UniformMuxerConfig{
+		Destinations: []string{`tcp://127.0.0.1:4023`},
+		Tags:         []string{`foo`},
+		TagPatterns:  []string{`kafka-*`},
+		Auth:         `foo`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tg, err := im.GetOrCreateTag(`foo`); err != nil || tg != 0 {
+		t.Fatalf("bad existing tag: %v %v", tg, err)
+	}
+	tg, err := im.GetOrCreateTag(`kafka-orders`)
+	if err != nil {
+		t.Fatal(err)
+	} else if again, err := im.GetOrCreateTag(`kafka-orders`); err != nil || again != tg {
+		t.Fatalf("tag created twice: %v %v %v", tg, again, err)
+	}
+	for _, v := range []string{`k8s-web`, `kafka`} {
+		if _, err = im.GetOrCreateTag(v); !errors.Is(err, ErrTagNotAllowed) {
+			t.Fatalf("%s: bad error %v", v, err)
+		} else if _, err = im.GetTag(v); err != ErrTagNotFound {
+			t.Fatalf("%s: disallowed tag was created", v)
+		}
+	}
+	if _, err = im.GetOrCreateTag(`kafka-bad tag`); err != ErrForbiddenTag {
+		t.Fatalf("bad tag name not caught: %v", err)
+	}
+
+	//patterns can be negotiated after the fact
+	if err = im.AddTagPattern(`k8s-*`); err != nil {
+		t.Fatal(err)
+	} else if err = im.AddTagPattern(`k8s-*`); err != nil {
+		t.Fatal(err)
+	} else if err = im.AddTagPattern(` `); !errors.Is(err, ErrInvalidTagPattern) {
+		t.Fatalf("empty pattern not caught: %v", err)
+	} else if pats := im.TagPatterns(); len(pats) != 2 {
+		t.Fatalf("bad patterns %v", pats)
+	} else if _, err = im.GetOrCreateTag(`k8s-web`); err != nil {
+		t.Fatal(err)
+	}
+}
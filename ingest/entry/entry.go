@@ -44,6 +44,7 @@ type Entry struct {
 	SRC  net.IP
 	Tag  EntryTag
 	Data []byte
+	Seq  uint64 // muxer sequence number used to deduplicate cache replays, never sent on the wire
 }
 
 func (ent *Entry) Key() EntryKey {
@@ -311,5 +312,6 @@ func (ent *Entry) DeepCopy() (c Entry) {
 	c.SRC = append(net.IP(nil), ent.SRC...)
 	c.Tag = ent.Tag
 	c.Data = append([]byte(nil), ent.Data...)
+	c.Seq = ent.Seq
 	return
 }
@@ -96,7 +96,7 @@ func (ecb *entryConfBuffer) Size() int {
 	return ecb.capacity
 }
 
-//Free returns how many slots are available
+// Free returns how many slots are available
 func (ecb *entryConfBuffer) Free() int {
 	return ecb.capacity - ecb.count
 
@@ -104,19 +104,28 @@ func (ecb *entryConfBuffer) Free() int {
 
 // A confirmation removes the ID from our queue
 func (ecb *entryConfBuffer) Confirm(id entrySendID) error {
+	_, err := ecb.confirmEntry(id)
+	return err
+}
+
+// confirmEntry removes the ID from our queue and hands back the confirmed entry
+func (ecb *entryConfBuffer) confirmEntry(id entrySendID) (*entry.Entry, error) {
 	if ecb.count <= 0 {
-		return errEmptyConfBuff
+		return nil, errEmptyConfBuff
 	}
 	//check the head first as that is what SHOULD be hitting
 	ec := ecb.buff[ecb.head]
 	if ec == nil {
-		return errCorruptConfBuff
+		return nil, errCorruptConfBuff
 	}
 	if ec.EntryID != id {
-		return ecb.popUnalligned(id)
+		ent, _ := ecb.GetEntry(id)
+		if err := ecb.popUnalligned(id); err != nil {
+			return nil, err
+		}
+		return ent, nil
 	}
-	_, err := ecb.popHead()
-	return err
+	return ecb.popHead()
 }
 
 // typically used when we need to resend something
@@ -137,6 +146,19 @@ func (ecb *entryConfBuffer) GetEntry(id entrySendID) (*entry.Entry, error) {
 	return nil, errEntryNotFound
 }
 
+// take removes the entry associated with the ID and hands it back
+func (ecb *entryConfBuffer) take(id entrySendID) (*entry.Entry, error) {
+	for j := 0; j < ecb.count; j++ {
+		ec := ecb.buff[(ecb.head+j)%ecb.capacity]
+		if ec == nil {
+			return nil, errCorruptConfBuff
+		} else if ec.EntryID == id {
+			return ec.Ent, ecb.Confirm(id)
+		}
+	}
+	return nil, errEntryNotFound
+}
+
 func (ecb *entryConfBuffer) popHead() (*entry.Entry, error) {
 	var ent *entry.Entry
 	if ecb.buff[ecb.head] == nil {
@@ -14,13 +14,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/golang/snappy"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 )
 
@@ -59,24 +62,34 @@ type TagManager interface {
 	GetAndPopulate(string) (entry.EntryTag, error)
 }
 
+// TagHintManager is an optional interface a TagManager may implement
+// to receive the storage hints an ingester sends for its tags.
+type TagHintManager interface {
+	SetTagHints([]config.TagHint) error
+}
+
 type ackCommand struct {
 	cmd IngestCommand
 	val uint64 //this can be converted to any number of things, id, time.Duration, etc...
 }
 
 type EntryReader struct {
+	//csumErrs is accessed atomically, keep it 8 byte aligned
+	csumErrs   uint64
 	conn       net.Conn
 	flshr      flusher
 	bIO        *bufio.Reader
 	bAckWriter *bufio.Writer
 	errCount   uint32
 	mtx        *sync.Mutex
+	ackMtx     *sync.Mutex // guards bAckWriter, which the ack routine shares with stream configuration
 	wg         *sync.WaitGroup
 	ackChan    chan ackCommand
 	errState   error
 	hot        bool
 	started    bool
 	buff       []byte
+	checksum   bool // entries carry a checksum trailer, set during stream configuration
 	//entCache is used to allocate entries in blocks to relieve some pressure on the allocator and GC
 	entCache    []entry.Entry
 	entCacheIdx int
@@ -91,6 +104,7 @@ type EntryReader struct {
 	igAPIVersion   uint16
 	igStateMtx     *sync.Mutex
 	igState        IngesterState           // the most recent state message received
+	tagHints       []config.TagHint        // storage hints sent by the ingester
 	stateCallbacks []IngesterStateCallback // functions to be called when an IngesterState message is received
 }
 
@@ -111,6 +125,7 @@ func NewEntryReaderEx(cfg EntryReaderWriterConfig) (*EntryReader, error) {
 		bIO:        bufio.NewReaderSize(cfg.Conn, cfg.BufferSize),
 		bAckWriter: bufio.NewWriterSize(cfg.Conn, ackEncodeSize*cfg.OutstandingEntryCount),
 		mtx:        &sync.Mutex{},
+		ackMtx:     &sync.Mutex{},
 		wg:         &sync.WaitGroup{},
 		ackChan:    make(chan ackCommand, cfg.OutstandingEntryCount),
 		hot:        true,
@@ -145,6 +160,16 @@ func (er *EntryReader) GetIngesterState() (is IngesterState) {
 	return
 }
 
+// GetTagHints returns the most recent set of tag storage hints received from the ingester.
+func (er *EntryReader) GetTagHints() (hints []config.TagHint) {
+	if er != nil {
+		er.igStateMtx.Lock()
+		hints = append(hints, er.tagHints...)
+		er.igStateMtx.Unlock()
+	}
+	return
+}
+
 type IngesterStateCallback func(IngesterState)
 
 // AddIngesterStateCallback registers a callback function which will be called every
@@ -171,7 +196,10 @@ func (er *EntryReader) ConfigureStream() (err error) {
 		return
 	} else if err = req.validate(); err != nil {
 		return
-	} else if err = req.Write(er.bAckWriter); err != nil {
+	}
+	er.ackMtx.Lock()
+	defer er.ackMtx.Unlock()
+	if err = req.Write(er.bAckWriter); err != nil {
 		return
 	} else if err = er.bAckWriter.Flush(); err != nil {
 		return
@@ -180,12 +208,18 @@ func (er *EntryReader) ConfigureStream() (err error) {
 	}
 
 	//we are in good shape, configure the stream
+	er.checksum = req.Checksum
 	if req.Compression != CompressNone {
 		err = er.startCompression(req.Compression)
 	}
 	return
 }
 
+// ChecksumErrors returns the number of entries that were dropped because they failed checksum validation
+func (er *EntryReader) ChecksumErrors() uint64 {
+	return atomic.LoadUint64(&er.csumErrs)
+}
+
 // startCompression gets the entryReader/Writer ready to work with a compressed connection
 // caller MUST HOLD THE LOCK
 func (ew *EntryReader) startCompression(ct CompressionType) (err error) {
@@ -261,7 +295,7 @@ func (er *EntryReader) Read() (e *entry.Entry, err error) {
 	return e, err
 }
 
-//reset the read deadline on the underlying connection, caller must hold the lock
+// reset the read deadline on the underlying connection, caller must hold the lock
 func (er *EntryReader) resetTimeout() error {
 	if er.timeout <= 0 {
 		return er.conn.SetReadDeadline(nilTime)
@@ -291,12 +325,29 @@ func (er *EntryReader) read() (*entry.Entry, error) {
 	}
 	ent := &er.entCache[er.entCacheIdx]
 
-	if err = er.fillHeader(ent, &id, &sz); err != nil {
-		return nil, err
-	}
-	ent.Data = make([]byte, sz)
-	if _, err = io.ReadFull(er.bIO, ent.Data); err != nil {
-		return nil, err
+	for {
+		if err = er.fillHeader(ent, &id, &sz); err != nil {
+			return nil, err
+		}
+		ent.Data = make([]byte, sz)
+		if _, err = io.ReadFull(er.bIO, ent.Data); err != nil {
+			return nil, err
+		}
+		if !er.checksum {
+			break
+		}
+		var csum [checksumSize]byte
+		if _, err = io.ReadFull(er.bIO, csum[:]); err != nil {
+			return nil, err
+		}
+		if binary.LittleEndian.Uint32(csum[:]) == crc32.Update(crc32.Checksum(er.buff[:entry.ENTRY_HEADER_SIZE+8], crcTable), crcTable, ent.Data) {
+			break
+		}
+		//drop the entry and tell the writer so that it sends it again
+		atomic.AddUint64(&er.csumErrs, 1)
+		if err = er.throwChecksumError(id); err != nil {
+			return nil, err
+		}
 	}
 	if err = er.throwAck(id); err != nil {
 		return nil, err
@@ -414,6 +465,21 @@ headerLoop:
 				er.stateCallbacks[i](state)
 			}
 
+			continue
+		case TAG_HINT_MAGIC:
+			hints, err := readTagHints(er.bIO)
+			if err != nil {
+				return err
+			}
+			// hints are advisory, always confirm
+			er.ackChan <- ackCommand{cmd: CONFIRM_TAG_HINT_MAGIC}
+
+			er.igStateMtx.Lock()
+			er.tagHints = hints
+			er.igStateMtx.Unlock()
+			if thm, ok := er.tagMan.(TagHintManager); ok && len(hints) > 0 {
+				thm.SetTagHints(hints)
+			}
 			continue
 		default: //we should probably bail out if we get desynced
 			continue
@@ -650,11 +716,7 @@ func (er *EntryReader) ackRoutine() {
 				er.routineCleanFail(err)
 				return
 			}
-			if err = er.writeAll(keepalivebuff[:off]); err != nil {
-				er.routineCleanFail(err)
-				return
-			}
-			if err = er.bAckWriter.Flush(); err != nil {
+			if err = er.sendAcks(keepalivebuff[:off]); err != nil {
 				er.routineCleanFail(err)
 				return
 			}
@@ -672,10 +734,7 @@ func (er *EntryReader) fillAndSendAckBuffer(b []byte, v ackCommand, toch <-chan
 	if off, flush, err = v.encode(b); err != nil {
 		return
 	} else if flush {
-		if err = er.writeAll(b[:off]); err != nil {
-			return
-		}
-		err = er.bAckWriter.Flush()
+		err = er.sendAcks(b[:off])
 		return
 	}
 
@@ -690,10 +749,7 @@ feedLoop:
 			//check that we have room
 			if (v.size() + off) >= len(b) {
 				//ok, flush and keep rolling
-				if err = er.writeAll(b[:off]); err != nil {
-					return
-				}
-				if err = er.bAckWriter.Flush(); err != nil {
+				if err = er.sendAcks(b[:off]); err != nil {
 					return
 				}
 				off = 0
@@ -718,10 +774,7 @@ feedLoop:
 		}
 	}
 	if off > 0 {
-		if err = er.writeAll(b[:off]); err != nil {
-			return
-		}
-		if err = er.bAckWriter.Flush(); err == nil {
+		if err = er.sendAcks(b[:off]); err == nil {
 			//clear the timeout if we got a good flush
 			to = false
 		}
@@ -747,6 +800,16 @@ func (er *EntryReader) throwAck(id entrySendID) error {
 	return nil
 }
 
+// throwChecksumError tells the writer that an entry was corrupted in transit
+// throwChecksumError must be called with the mutex already locked by parent
+func (er *EntryReader) throwChecksumError(id entrySendID) error {
+	if !er.started {
+		return errAckRoutineClosed
+	}
+	er.ackChan <- ackCommand{cmd: CHECKSUM_ERROR_MAGIC, val: uint64(id)}
+	return nil
+}
+
 // sends a command down the channel indicating that we should flush
 func (er *EntryReader) forceAck() error {
 	if !er.started {
@@ -756,6 +819,16 @@ func (er *EntryReader) forceAck() error {
 	return nil
 }
 
+// sendAcks writes and flushes a buffer of encoded ack commands
+func (er *EntryReader) sendAcks(b []byte) (err error) {
+	er.ackMtx.Lock()
+	if err = er.writeAll(b); err == nil {
+		err = er.bAckWriter.Flush()
+	}
+	er.ackMtx.Unlock()
+	return
+}
+
 func (er *EntryReader) writeAll(b []byte) error {
 	var written int
 	for written < len(b) {
@@ -783,6 +856,8 @@ func (ac ackCommand) size() int {
 			return 0
 		}
 		return ackEncodeSize
+	case CHECKSUM_ERROR_MAGIC:
+		return ackEncodeSize
 	case PONG_MAGIC:
 		return 4
 	case ERROR_TAG_MAGIC:
@@ -799,6 +874,8 @@ func (ac ackCommand) size() int {
 		return 4
 	case CONFIRM_INGESTER_STATE_MAGIC:
 		return 4
+	case CONFIRM_TAG_HINT_MAGIC:
+		return 4
 	}
 	return 0
 }
@@ -815,6 +892,11 @@ func (ac ackCommand) encode(b []byte) (n int, flush bool, err error) {
 		binary.LittleEndian.PutUint32(b, uint32(ac.cmd))
 		binary.LittleEndian.PutUint64(b[4:], ac.val)
 		n += ackEncodeSize
+	case CHECKSUM_ERROR_MAGIC:
+		binary.LittleEndian.PutUint32(b, uint32(ac.cmd))
+		binary.LittleEndian.PutUint64(b[4:], ac.val)
+		n += ackEncodeSize
+		flush = true
 	case PONG_MAGIC:
 		binary.LittleEndian.PutUint32(b, uint32(ac.cmd))
 		n += pongEncodeSize
@@ -850,6 +932,10 @@ func (ac ackCommand) encode(b []byte) (n int, flush bool, err error) {
 		binary.LittleEndian.PutUint32(b, uint32(ac.cmd))
 		n += 4
 		flush = true
+	case CONFIRM_TAG_HINT_MAGIC:
+		binary.LittleEndian.PutUint32(b, uint32(ac.cmd))
+		n += 4
+		flush = true
 	default:
 		err = errUnknownCommand
 	}
@@ -869,7 +955,7 @@ func (ac *ackCommand) decode(rdr *bufio.Reader, blocking bool) (ok bool, err err
 	}
 	ac.cmd = IngestCommand(binary.LittleEndian.Uint32(cmd))
 	switch ac.cmd {
-	case THROTTLE_MAGIC:
+	case THROTTLE_MAGIC, CHECKSUM_ERROR_MAGIC:
 		fallthrough
 	case CONFIRM_ENTRY_MAGIC:
 		if _, err = io.ReadFull(rdr, val); err != nil {
@@ -901,6 +987,8 @@ func (ac *ackCommand) decode(rdr *bufio.Reader, blocking bool) (ok bool, err err
 		ok = true
 	case CONFIRM_INGESTER_STATE_MAGIC:
 		ok = true
+	case CONFIRM_TAG_HINT_MAGIC:
+		ok = true
 	default:
 		err = errUnknownCommand
 	}
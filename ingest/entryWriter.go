@@ -13,11 +13,14 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/snappy"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 )
 
@@ -40,9 +43,15 @@ const (
 	MINIMUM_INGEST_OK_VERSION       uint16        = 0x4 // minimum server version to ask
 	MINIMUM_DYN_CONFIG_VERSION      uint16        = 0x5 // minimum server version to send dynamic config block
 	MINIMUM_INGEST_STATE_VERSION    uint16        = 0x6 // minimum server version to send detailed ingester state messages
+	MINIMUM_TAG_HINT_VERSION        uint16        = 0x8 // minimum server version to send tag storage hints
 	maxThrottleDur                  time.Duration = 5 * time.Second
 
 	flushTimeout time.Duration = 10 * time.Second
+
+	// checksumSize is the CRC32C trailer on each entry when checksums are enabled.  The entry is the
+	// protocol's unit of framing, acknowledgement, and retransmission, so each entry carries its own
+	// checksum and a corrupted entry is resent on its own.
+	checksumSize int = 4
 )
 
 const (
@@ -51,6 +60,7 @@ const (
 	NEW_ENTRY_MAGIC              IngestCommand = 0xC7C95ACB
 	FORCE_ACK_MAGIC              IngestCommand = 0x1ADF7350
 	CONFIRM_ENTRY_MAGIC          IngestCommand = 0xF6E0307E
+	CHECKSUM_ERROR_MAGIC         IngestCommand = 0xF6E0307F
 	THROTTLE_MAGIC               IngestCommand = 0xBDEACC1E
 	PING_MAGIC                   IngestCommand = 0x88770001
 	PONG_MAGIC                   IngestCommand = 0x88770008
@@ -65,6 +75,8 @@ const (
 	CONFIRM_INGEST_OK_MAGIC      IngestCommand = 0x33445501
 	INGESTER_STATE_MAGIC         IngestCommand = 0x44556600
 	CONFIRM_INGESTER_STATE_MAGIC IngestCommand = 0x44556601
+	TAG_HINT_MAGIC               IngestCommand = 0x18675303 // protocol 0x8, indexers must implement it to advertise MINIMUM_TAG_HINT_VERSION
+	CONFIRM_TAG_HINT_MAGIC       IngestCommand = 0x18675304
 )
 
 type IngestCommand uint32
@@ -74,7 +86,14 @@ type flusher interface {
 	Flush() error
 }
 
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
 type EntryWriter struct {
+	//csumErrs is accessed atomically, keep it 8 byte aligned
+	csumErrs      uint64
+	csumCounter   *uint64 // where corruption reports are tallied, the muxer points this at its own counter
 	conn          conn
 	flshr         flusher
 	bIO           *bufio.Writer
@@ -87,6 +106,14 @@ type EntryWriter struct {
 	id            entrySendID
 	ackTimeout    time.Duration
 	serverVersion uint16
+	checksum      bool            // append a checksum to every entry, set during stream configuration
+	resend        []*entry.Entry  // entries the reader reported as corrupted
+	fb            *adaptiveRate   // optional, told about throttle requests and ack round trip times
+	rttID         entrySendID     // entry being timed, zero when none is
+	rttSent       time.Time       // when the timed entry was flushed to the wire
+	seqs          *seqTracker     // optional, told which entries the indexer confirmed
+	lm            *latencyMarkers // optional, told which entries the indexer confirmed
+	traces        *entryTraces    // optional, told which entries the indexer confirmed
 }
 
 func NewEntryWriter(conn net.Conn) (*EntryWriter, error) {
@@ -118,7 +145,7 @@ func NewEntryWriterEx(cfg EntryReaderWriterConfig) (*EntryWriter, error) {
 	}
 	utc := newUnthrottledConn(cfg.Conn)
 
-	return &EntryWriter{
+	ew := &EntryWriter{
 		conn:       utc,
 		bIO:        bufio.NewWriterSize(utc, cfg.BufferSize),
 		bAckReader: bufio.NewReaderSize(utc, cfg.OutstandingEntryCount*ACK_SIZE),
@@ -128,7 +155,49 @@ func NewEntryWriterEx(cfg EntryReaderWriterConfig) (*EntryWriter, error) {
 		buff:       make([]byte, READ_ENTRY_HEADER_SIZE),
 		id:         1,
 		ackTimeout: cfg.Timeout,
-	}, nil
+	}
+	ew.csumCounter = &ew.csumErrs
+	return ew, nil
+}
+
+// ChecksumErrors returns the number of entries the remote end reported as corrupted in transit
+func (ew *EntryWriter) ChecksumErrors() uint64 {
+	return atomic.LoadUint64(ew.csumCounter)
+}
+
+// setRateFeedback reports indexer feedback to an adaptive rate limiter
+func (ew *EntryWriter) setRateFeedback(a *adaptiveRate) {
+	ew.mtx.Lock()
+	ew.fb = a
+	ew.mtx.Unlock()
+}
+
+// setSeqTracker reports confirmed entries to the muxer's sequence tracker
+func (ew *EntryWriter) setSeqTracker(st *seqTracker) {
+	ew.mtx.Lock()
+	ew.seqs = st
+	ew.mtx.Unlock()
+}
+
+// setLatencyMarkers reports confirmed entries to the muxer's latency markers
+func (ew *EntryWriter) setLatencyMarkers(lm *latencyMarkers) {
+	ew.mtx.Lock()
+	ew.lm = lm
+	ew.mtx.Unlock()
+}
+
+// setEntryTraces reports confirmed entries to the muxer's entry traces
+func (ew *EntryWriter) setEntryTraces(et *entryTraces) {
+	ew.mtx.Lock()
+	ew.traces = et
+	ew.mtx.Unlock()
+}
+
+// setChecksumCounter redirects corruption reports to a shared counter
+func (ew *EntryWriter) setChecksumCounter(c *uint64) {
+	ew.mtx.Lock()
+	ew.csumCounter = c
+	ew.mtx.Unlock()
 }
 
 func (ew *EntryWriter) OverrideAckTimeout(t time.Duration) error {
@@ -143,8 +212,8 @@ func (ew *EntryWriter) OverrideAckTimeout(t time.Duration) error {
 
 type connWrapper func(conn) conn
 
-//wrapConn passes in a function that can wrap a reader/writer
-//when called we reset the write buffer, caller should make sure there isn't anything buffered
+// wrapConn passes in a function that can wrap a reader/writer
+// when called we reset the write buffer, caller should make sure there isn't anything buffered
 func (ew *EntryWriter) setConn(c conn) {
 	ew.mtx.Lock()
 	ew.conn = c
@@ -182,7 +251,7 @@ func (ew *EntryWriter) ForceAck() error {
 func (ew *EntryWriter) outstandingEntries() []*entry.Entry {
 	ew.mtx.Lock()
 	defer ew.mtx.Unlock()
-	return ew.ecb.outstandingEntries()
+	return append(ew.ecb.outstandingEntries(), ew.resend...)
 }
 
 func (ew *EntryWriter) throwAckSync() error {
@@ -357,6 +426,14 @@ func (ew *EntryWriter) writeEntry(ent *entry.Entry, flush bool) (bool, error) {
 	if err = ew.writeAll(ent.Data); err != nil {
 		return false, err
 	}
+	if ew.checksum {
+		//the checksum covers the header, ID, and data; everything but the magic
+		var csum [checksumSize]byte
+		binary.LittleEndian.PutUint32(csum[:], crc32.Update(crc32.Checksum(ew.buff[4:], crcTable), crcTable, ent.Data))
+		if err = ew.writeAll(csum[:]); err != nil {
+			return false, err
+		}
+	}
 	if flush {
 		flushed = flush
 		if err = ew.flush(); err != nil {
@@ -366,6 +443,12 @@ func (ew *EntryWriter) writeEntry(ent *entry.Entry, flush bool) (bool, error) {
 	if err = ew.ecb.Add(&entryConfirmation{ew.id, ent}); err != nil {
 		return false, err
 	}
+	if ew.fb != nil && ew.rttID == 0 {
+		ew.rttID = ew.id
+		if flush {
+			ew.rttSent = time.Now()
+		}
+	}
 	ew.id++
 	return flushed, nil
 }
@@ -398,8 +481,8 @@ func (ew *EntryWriter) writeAll(b []byte) error {
 	return nil
 }
 
-//flush attempts to push our buffer to the wire with a timeout
-//if the timeout expires we attempt to service acks and go back to attempting to flush
+// flush attempts to push our buffer to the wire with a timeout
+// if the timeout expires we attempt to service acks and go back to attempting to flush
 func (ew *EntryWriter) flush() (err error) {
 	//set the write timeout
 	if err = ew.conn.SetWriteTimeout(flushTimeout); err != nil {
@@ -415,10 +498,25 @@ func (ew *EntryWriter) flush() (err error) {
 			}
 		}
 		err = ew.conn.ClearWriteTimeout()
+		//the timed entry is on the wire, rate limiting waits don't count against the indexer
+		if err == nil && ew.rttID != 0 && ew.rttSent.IsZero() {
+			ew.rttSent = time.Now()
+		}
 	}
 	return
 }
 
+// confirmed finishes timing an entry once the indexer confirms it or anything sent after it
+func (ew *EntryWriter) confirmed(id entrySendID) {
+	if ew.rttID == 0 || id < ew.rttID {
+		return
+	}
+	if !ew.rttSent.IsZero() {
+		ew.fb.rtt(time.Since(ew.rttSent))
+	}
+	ew.rttID, ew.rttSent = 0, time.Time{}
+}
+
 // configureStream will
 func (ew *EntryWriter) ConfigureStream(c StreamConfiguration) (err error) {
 	var resp StreamConfiguration
@@ -451,6 +549,8 @@ func (ew *EntryWriter) ConfigureStream(c StreamConfiguration) (err error) {
 			return
 		}
 	}
+	//only checksum if we asked and the server agreed
+	ew.checksum = c.Checksum && resp.Checksum
 	return
 }
 
@@ -519,6 +619,73 @@ stateCmdLoop:
 	return
 }
 
+// SendTagHints sends the set of per-tag storage hints to the indexer.
+// Indexers which do not support hints are silently skipped.
+func (ew *EntryWriter) SendTagHints(hints []config.TagHint) (err error) {
+	if len(hints) == 0 {
+		return
+	}
+	ew.mtx.Lock()
+	defer ew.mtx.Unlock()
+
+	if ew.serverVersion < MINIMUM_TAG_HINT_VERSION {
+		//just return quietly, hints are advisory
+		return
+	}
+
+	// First attempt to sync
+	err = ew.forceAckNoLock()
+	if err != nil {
+		return
+	}
+
+	// write the header
+	if err = ew.writeAll(TAG_HINT_MAGIC.Buff()); err != nil {
+		return
+	}
+	// write the hints
+	if err = writeTagHints(ew.bIO, hints); err != nil {
+		return
+	}
+
+	if err = ew.flush(); err != nil {
+		return
+	}
+
+	// Read back an ackCommand
+	var ac ackCommand
+	var ok bool
+hintCmdLoop:
+	for {
+		if err = ew.conn.SetReadTimeout(2 * time.Second); err != nil {
+			break
+		}
+		if ok, err = ac.decode(ew.bAckReader, true); err != nil {
+			break
+		}
+		if !ok {
+			err = errors.New("couldn't figure out ackCommand")
+			break
+		}
+		switch ac.cmd {
+		case CONFIRM_TAG_HINT_MAGIC:
+			break hintCmdLoop
+		case PONG_MAGIC:
+			// unsolicited, can come whenever
+		default:
+			err = fmt.Errorf("Unexpected response to tag hint request: %#v", ac)
+			break hintCmdLoop
+		}
+	}
+	if err == nil {
+		err = ew.conn.ClearReadTimeout()
+	} else {
+		ew.conn.ClearReadTimeout()
+	}
+
+	return
+}
+
 // startCompression gets the entryReader/Writer ready to work with a compressed connection
 // caller MUST HOLD THE LOCK
 func (ew *EntryWriter) startCompression(ct CompressionType) (err error) {
@@ -837,7 +1004,46 @@ func (ew *EntryWriter) Ack() error {
 }
 
 // serviceAcks MUST be called with the parent holding the mutex
-func (ew *EntryWriter) serviceAcks(blocking bool) error {
+func (ew *EntryWriter) serviceAcks(blocking bool) (err error) {
+	if err = ew.serviceAckQueue(blocking); err == nil && len(ew.resend) > 0 {
+		err = ew.resendCorrupted()
+	}
+	return
+}
+
+// resendCorrupted writes entries that the reader reported as corrupted again under new IDs.
+// Each one freed a confirmation slot when it was reported so there is always room.
+// Caller MUST hold the lock.
+func (ew *EntryWriter) resendCorrupted() error {
+	for len(ew.resend) > 0 {
+		ent := ew.resend[0]
+		ew.resend[0] = nil
+		ew.resend = ew.resend[1:]
+		if _, err := ew.writeEntry(ent, false); err != nil {
+			//put it back so it isn't lost if the connection is recycled
+			ew.resend = append([]*entry.Entry{ent}, ew.resend...)
+			return err
+		}
+	}
+	ew.resend = nil
+	return nil
+}
+
+// checksumFailed pulls a corrupted entry out of the confirmation buffer so it can be resent.
+// Caller MUST hold the lock.
+func (ew *EntryWriter) checksumFailed(id entrySendID) error {
+	atomic.AddUint64(ew.csumCounter, 1)
+	ent, err := ew.ecb.take(id)
+	if err == errEntryNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	ew.resend = append(ew.resend, ent)
+	return nil
+}
+
+func (ew *EntryWriter) serviceAckQueue(blocking bool) error {
 	//only flush if we are blocking
 	if blocking && ew.bIO.Buffered() > 0 {
 		if err := ew.flush(); err != nil {
@@ -865,7 +1071,7 @@ func (ew *EntryWriter) serviceAcks(blocking bool) error {
 	return nil
 }
 
-//readAcks pulls out all of the acks in the ackBuffer and services them
+// readAcks pulls out all of the acks in the ackBuffer and services them
 func (ew *EntryWriter) readAcks(blocking bool) (err error) {
 	var ac ackCommand
 	var ok bool
@@ -893,17 +1099,28 @@ loop:
 			//check if the ID is the head, if not pop the head and resend
 			//TODO: if we get an ID we don't know about we just ignore it
 			//      is this the best course of action?
-			if err = ew.ecb.Confirm(entrySendID(ac.val)); err != nil {
+			var ent *entry.Entry
+			if ent, err = ew.ecb.confirmEntry(entrySendID(ac.val)); err != nil {
 				if err != errEntryNotFound {
 					break loop
 				}
 				err = nil
 			}
+			ew.seqs.ack(ent)
+			ew.lm.ack(ent)
+			ew.traces.ack(ent)
+			ew.confirmed(entrySendID(ac.val))
+			cnt++
+		case CHECKSUM_ERROR_MAGIC:
+			if err = ew.checksumFailed(entrySendID(ac.val)); err != nil {
+				break loop
+			}
 			cnt++
 		case THROTTLE_MAGIC:
 			if dur = time.Duration(ac.val); dur > maxThrottleDur || dur < 0 {
 				dur = maxThrottleDur
 			}
+			ew.fb.throttled()
 			if err = ew.throttle(dur); err != nil {
 				break loop
 			}
@@ -940,15 +1157,25 @@ func (ew *EntryWriter) readCommandsUntil(cmd IngestCommand) (err error) {
 			//check if the ID is the head, if not pop the head and resend
 			//TODO: if we get an ID we don't know about we just ignore it
 			//      is this the best course of action?
-			if err = ew.ecb.Confirm(entrySendID(ac.val)); err != nil {
+			var ent *entry.Entry
+			if ent, err = ew.ecb.confirmEntry(entrySendID(ac.val)); err != nil {
 				if err != errEntryNotFound {
 					return
 				}
 			}
+			ew.seqs.ack(ent)
+			ew.lm.ack(ent)
+			ew.traces.ack(ent)
+			ew.confirmed(entrySendID(ac.val))
+		case CHECKSUM_ERROR_MAGIC:
+			if err = ew.checksumFailed(entrySendID(ac.val)); err != nil {
+				return
+			}
 		case THROTTLE_MAGIC:
 			if dur = time.Duration(ac.val); dur > maxThrottleDur || dur < 0 {
 				dur = maxThrottleDur
 			}
+			ew.fb.throttled()
 			if err = ew.throttle(dur); err != nil {
 				return
 			}
@@ -996,6 +1223,8 @@ func (ic IngestCommand) String() string {
 		return `FORCE ACK`
 	case CONFIRM_ENTRY_MAGIC:
 		return `CONFIRM ENTRY`
+	case CHECKSUM_ERROR_MAGIC:
+		return `CHECKSUM ERROR`
 	case THROTTLE_MAGIC:
 		return `THROTTLE`
 	case INVALID_MAGIC:
@@ -1026,6 +1255,10 @@ func (ic IngestCommand) String() string {
 		return `INGESTER_STATE`
 	case CONFIRM_INGESTER_STATE_MAGIC:
 		return `INGESTER_STATE_CONFIRM`
+	case TAG_HINT_MAGIC:
+		return `TAG_HINT`
+	case CONFIRM_TAG_HINT_MAGIC:
+		return `TAG_HINT_CONFIRM`
 	}
 	return `UNKNOWN`
 }
@@ -22,6 +22,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 )
 
@@ -218,6 +219,158 @@ func TestCleanup(t *testing.T) {
 	}
 }
 
+type testHintTagMan struct {
+	hints []config.TagHint
+}
+
+func (t *testHintTagMan) GetAndPopulate(v string) (entry.EntryTag, error) {
+	return 1, nil
+}
+
+func (t *testHintTagMan) SetTagHints(hints []config.TagHint) error {
+	t.hints = hints
+	return nil
+}
+
+func TestTagHints(t *testing.T) {
+	if err := cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	hints := []config.TagHint{
+		config.TagHint{Tag: `syslog`, Well: `netlogs`, Volume_Class: config.VolumeClassHigh},
+		config.TagHint{Tag: `windows`, Shard_Key: `Computer`},
+		config.TagHint{Tag: `bad tag`, Well: `foo`}, //should be dropped by the reader
+	}
+	errChan := make(chan error)
+	lst, cli, srv, err := getConnections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tm testHintTagMan
+	etSrv, err := NewEntryReader(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etSrv.SetTagManager(&tm)
+	etSrv.Start()
+
+	etCli, err := NewEntryWriter(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go reader(etSrv, 1, 0xffffffff, errChan)
+
+	//old servers should be skipped quietly
+	if err = etCli.SendTagHints(hints); err != nil {
+		t.Fatal(err)
+	}
+	etCli.serverVersion = MINIMUM_TAG_HINT_VERSION
+	if err = etCli.SendTagHints(hints); err != nil {
+		t.Fatal(err)
+	}
+	if err = etCli.Write(makeEntry()); err != nil {
+		t.Fatal(err)
+	} else if err = etCli.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	got := etSrv.GetTagHints()
+	if len(got) != 2 || got[0] != hints[0] || got[1] != hints[1] {
+		t.Fatalf("bad tag hints: %+v", got)
+	} else if len(tm.hints) != 2 {
+		t.Fatalf("tag manager did not get hints: %+v", tm.hints)
+	}
+	if err = etSrv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = closeConnections(cli, srv); err != nil {
+		t.Fatal(err)
+	}
+	lst.Close()
+}
+
+// corruptConn flips a single bit at the given offset in the write stream
+type corruptConn struct {
+	conn
+	off     int64
+	written int64
+}
+
+func (cc *corruptConn) Write(b []byte) (int, error) {
+	if cc.off >= cc.written && cc.off < cc.written+int64(len(b)) {
+		b = append([]byte(nil), b...)
+		b[cc.off-cc.written] ^= 0x4
+	}
+	cc.written += int64(len(b))
+	return cc.conn.Write(b)
+}
+
+func TestChecksums(t *testing.T) {
+	if err := cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	const count = 100
+	errChan := make(chan error, 1)
+	lst, cli, srv, err := getConnections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	etSrv, err := NewEntryReader(srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etCli, err := NewEntryWriter(cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//negotiate checksums
+	etSrv.igAPIVersion = VERSION
+	etCli.serverVersion = VERSION
+	go func() {
+		errChan <- etSrv.ConfigureStream()
+	}()
+	if err = etCli.ConfigureStream(StreamConfiguration{Checksum: true}); err != nil {
+		t.Fatal(err)
+	} else if err = <-errChan; err != nil {
+		t.Fatal(err)
+	} else if !etCli.checksum || !etSrv.checksum {
+		t.Fatal("checksums were not negotiated")
+	}
+	etSrv.Start()
+
+	//corrupt the data portion of the third entry
+	dt := make([]byte, 256)
+	etCli.setConn(&corruptConn{conn: etCli.conn, off: int64(2*(READ_ENTRY_HEADER_SIZE+len(dt)+checksumSize) + READ_ENTRY_HEADER_SIZE + 100)})
+
+	go reader(etSrv, count, 0xffffffff, errChan)
+	for i := 0; i < count; i++ {
+		ent := makeEntryWithKey(int64(i))
+		ent.Data = dt
+		if err = etCli.Write(ent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = etCli.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	if n := etSrv.ChecksumErrors(); n != 1 {
+		t.Fatalf("reader saw %d checksum errors", n)
+	} else if n = etCli.ChecksumErrors(); n != 1 {
+		t.Fatalf("writer saw %d checksum errors", n)
+	}
+	if err = etSrv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = closeConnections(cli, srv); err != nil {
+		t.Fatal(err)
+	}
+	lst.Close()
+}
+
 func performThrottleCycles(t *testing.T, count int) (time.Duration, uint64) {
 	return performReaderCycles(t, count, 10)
 }
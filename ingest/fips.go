@@ -0,0 +1,36 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"fmt"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+)
+
+// checkFIPSTarget rejects destinations that can't be used in FIPS mode, the ingest
+// authentication handshake is only allowed to happen inside a verified TLS session.
+func checkFIPSTarget(addr string, verify bool) error {
+	if !config.FIPSEnabled() {
+		return nil
+	}
+	t, _, err := ConnectionType(addr)
+	if err != nil {
+		return err
+	}
+	switch t {
+	case "tcp":
+		return fmt.Errorf("%w: %s", config.ErrFIPSCleartextTarget, addr)
+	case "tls":
+		if !verify {
+			return fmt.Errorf("%w: %s", config.ErrFIPSInsecureTLS, addr)
+		}
+	}
+	return nil
+}
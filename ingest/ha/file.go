@@ -0,0 +1,116 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ha
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/renameio"
+)
+
+const (
+	maxSettle = time.Second
+)
+
+// fileRecord is the contents of a lease file
+type fileRecord struct {
+	ID      string
+	Expires time.Time
+}
+
+// FileLease is a lease stored in a file on storage shared by every instance.  Writes are atomic
+// renames so readers never see a partial record.  Expiration times are absolute so the instances
+// must have reasonably synchronized clocks.
+type FileLease struct {
+	path string
+	id   string
+	ttl  time.Duration
+}
+
+// NewFileLease creates a lease stored at path, the directory must already exist
+func NewFileLease(path, id string, ttl time.Duration) (fl *FileLease, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(filepath.Dir(path)); err != nil {
+		return
+	} else if !fi.IsDir() {
+		err = errors.New("HA-Lease-File directory is not a directory")
+		return
+	}
+	fl = &FileLease{
+		path: path,
+		id:   id,
+		ttl:  ttl,
+	}
+	return
+}
+
+func (fl *FileLease) read() (rec fileRecord, ok bool, err error) {
+	var b []byte
+	if b, err = ioutil.ReadFile(fl.path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	//a garbage lease file is treated as an expired lease
+	ok = json.Unmarshal(b, &rec) == nil
+	return
+}
+
+func (fl *FileLease) write(expires time.Time) error {
+	b, err := json.Marshal(fileRecord{ID: fl.id, Expires: expires})
+	if err != nil {
+		return err
+	}
+	return renameio.WriteFile(fl.path, b, 0640)
+}
+
+// Acquire takes the lease if it is free or expired and renews it if we already hold it
+func (fl *FileLease) Acquire() (held bool, err error) {
+	var rec fileRecord
+	var ok bool
+	if rec, ok, err = fl.read(); err != nil {
+		return
+	}
+	now := time.Now()
+	renewing := ok && rec.ID == fl.id
+	if ok && !renewing && now.Before(rec.Expires) {
+		return //someone else has it
+	}
+	if err = fl.write(now.Add(fl.ttl)); err != nil {
+		return
+	}
+	if !renewing {
+		//give anyone else that saw the same expired lease a chance to write, the last write wins
+		settle := fl.ttl / 10
+		if settle > maxSettle {
+			settle = maxSettle
+		}
+		time.Sleep(settle)
+	}
+	if rec, ok, err = fl.read(); err == nil {
+		held = ok && rec.ID == fl.id
+	}
+	return
+}
+
+// Release expires the lease if we hold it
+func (fl *FileLease) Release() (err error) {
+	var rec fileRecord
+	var ok bool
+	if rec, ok, err = fl.read(); err != nil || !ok || rec.ID != fl.id {
+		return
+	}
+	return fl.write(time.Time{})
+}
@@ -0,0 +1,259 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package ha implements a simple lease so that a pair of ingesters pointed at the same
+// source can run active/standby.  Only the instance holding the lease ingests, the standby
+// waits and takes over if the active instance stops renewing the lease.
+//
+// Two lease types are supported:
+//
+//	file - the lease is a small file on storage shared by both instances, clocks must be in sync
+//	peer - the instances talk directly to each other over TCP and agree on who is active
+package ha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ModeNone = ``
+	ModeFile = `file`
+	ModePeer = `peer`
+
+	DefaultLeaseTimeout = 15 * time.Second
+	minLeaseTimeout     = 3 * time.Second
+)
+
+var (
+	ErrInvalidMode         = errors.New("Invalid HA-Mode, must be file or peer")
+	ErrMissingLeaseFile    = errors.New("HA-Lease-File is required for file mode")
+	ErrMissingPeer         = errors.New("HA-Listen and HA-Peer are required for peer mode")
+	ErrInvalidLeaseTimeout = fmt.Errorf("HA-Lease-Timeout must be at least %v", minLeaseTimeout)
+	ErrMissingSecret       = errors.New("A shared secret is required for peer mode")
+	ErrManagerClosed       = errors.New("HA manager is closed")
+)
+
+// LeaseConfig is embedded in the global ingester configuration
+type LeaseConfig struct {
+	HA_Mode          string `json:",omitempty"` // file or peer, empty disables HA
+	HA_Lease_File    string `json:",omitempty"` // lease file on shared storage for file mode
+	HA_Lease_Timeout string `json:",omitempty"` // how long a lease is valid without renewal
+	HA_Listen        string `json:",omitempty"` // address to accept peer status requests on
+	HA_Peer          string `json:",omitempty"` // address of the other instance in peer mode
+	HA_Priority      int    `json:",omitempty"` // lower values are preferred when neither instance is active
+}
+
+// Lease is a single attempt at acquiring or renewing the right to be active
+type Lease interface {
+	// Acquire takes or renews the lease and reports whether we now hold it
+	Acquire() (bool, error)
+	// Release gives the lease up so the standby can take over immediately
+	Release() error
+}
+
+// Enabled returns true if an HA mode is configured
+func (lc LeaseConfig) Enabled() bool {
+	return strings.TrimSpace(lc.HA_Mode) != ModeNone
+}
+
+// Validate checks the HA configuration, an empty configuration is valid and disables HA
+func (lc LeaseConfig) Validate() (err error) {
+	if !lc.Enabled() {
+		return
+	}
+	if _, err = lc.timeout(); err != nil {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(lc.HA_Mode)) {
+	case ModeFile:
+		if strings.TrimSpace(lc.HA_Lease_File) == `` {
+			err = ErrMissingLeaseFile
+		}
+	case ModePeer:
+		if lc.HA_Listen == `` || lc.HA_Peer == `` {
+			err = ErrMissingPeer
+		} else if _, _, err = net.SplitHostPort(lc.HA_Listen); err != nil {
+			err = fmt.Errorf("Invalid HA-Listen %q: %w", lc.HA_Listen, err)
+		} else if _, _, err = net.SplitHostPort(lc.HA_Peer); err != nil {
+			err = fmt.Errorf("Invalid HA-Peer %q: %w", lc.HA_Peer, err)
+		}
+	default:
+		err = ErrInvalidMode
+	}
+	return
+}
+
+func (lc LeaseConfig) timeout() (to time.Duration, err error) {
+	if s := strings.TrimSpace(lc.HA_Lease_Timeout); s == `` {
+		to = DefaultLeaseTimeout
+	} else if to, err = time.ParseDuration(s); err != nil {
+		err = fmt.Errorf("Invalid HA-Lease-Timeout %q: %w", s, err)
+	} else if to < minLeaseTimeout {
+		err = ErrInvalidLeaseTimeout
+	}
+	return
+}
+
+// NodeID builds an identifier for this instance, the ingester UUID alone is not enough
+// because HA pairs are often deployed with copies of the same configuration file.
+func NodeID(uuid string) string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%s/%d", host, uuid, os.Getpid())
+}
+
+// Manager drives a lease, blocking until we are active and then renewing the lease
+// in the background.  If the lease is lost the Lost channel is closed and the ingester
+// should stop ingesting.
+type Manager struct {
+	mtx    sync.Mutex
+	lease  Lease
+	ttl    time.Duration
+	active bool
+	closed bool
+	lost   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewManager builds a manager from the configuration, if HA is not enabled the manager is nil.
+// The secret is used to authenticate peer messages.
+func NewManager(lc LeaseConfig, id, secret string) (m *Manager, err error) {
+	if !lc.Enabled() {
+		return
+	} else if err = lc.Validate(); err != nil {
+		return
+	}
+	var ttl time.Duration
+	var lease Lease
+	if ttl, err = lc.timeout(); err != nil {
+		return
+	}
+	switch strings.ToLower(strings.TrimSpace(lc.HA_Mode)) {
+	case ModeFile:
+		lease, err = NewFileLease(lc.HA_Lease_File, id, ttl)
+	case ModePeer:
+		lease, err = NewPeerLease(lc.HA_Listen, lc.HA_Peer, id, secret, lc.HA_Priority, ttl)
+	}
+	if err != nil {
+		return
+	}
+	m = newManager(lease, ttl)
+	return
+}
+
+func newManager(lease Lease, ttl time.Duration) *Manager {
+	return &Manager{
+		lease: lease,
+		ttl:   ttl,
+		lost:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+func (m *Manager) interval() time.Duration {
+	return m.ttl / 3
+}
+
+// WaitActive blocks until we hold the lease or the context is cancelled.  Once the lease is held
+// it is renewed in the background until the manager is closed.
+func (m *Manager) WaitActive(ctx context.Context) (err error) {
+	tckr := time.NewTicker(m.interval())
+	defer tckr.Stop()
+	for {
+		var held bool
+		if held, err = m.lease.Acquire(); err == nil && held {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.done:
+			return ErrManagerClosed
+		case <-tckr.C:
+		}
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.closed {
+		return ErrManagerClosed
+	}
+	m.active = true
+	m.wg.Add(1)
+	go m.renew()
+	return nil
+}
+
+// Active reports whether we currently hold the lease
+func (m *Manager) Active() bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.active
+}
+
+// Lost is closed when a held lease could not be renewed
+func (m *Manager) Lost() <-chan struct{} {
+	return m.lost
+}
+
+func (m *Manager) renew() {
+	defer m.wg.Done()
+	tckr := time.NewTicker(m.interval())
+	defer tckr.Stop()
+	lastRenew := time.Now()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-tckr.C:
+		}
+		held, err := m.lease.Acquire()
+		if err == nil && held {
+			lastRenew = time.Now()
+			continue
+		}
+		//someone else has it, or we can't tell and the other side may be about to take it
+		if (err == nil && !held) || time.Since(lastRenew) >= (2*m.ttl)/3 {
+			m.mtx.Lock()
+			m.active = false
+			m.mtx.Unlock()
+			close(m.lost)
+			return
+		}
+	}
+}
+
+// Close stops renewing the lease and releases it if we hold it
+func (m *Manager) Close() (err error) {
+	m.mtx.Lock()
+	if m.closed {
+		m.mtx.Unlock()
+		return ErrManagerClosed
+	}
+	m.closed = true
+	close(m.done)
+	wasActive := m.active
+	m.active = false
+	m.mtx.Unlock()
+	m.wg.Wait()
+	if wasActive {
+		err = m.lease.Release()
+	}
+	if cl, ok := m.lease.(interface{ Close() error }); ok {
+		if lerr := cl.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	return
+}
@@ -0,0 +1,156 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ha
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testTTL = 3 * time.Second
+
+func TestLeaseConfig(t *testing.T) {
+	if err := (LeaseConfig{}).Validate(); err != nil {
+		t.Fatal(err)
+	} else if m, err := NewManager(LeaseConfig{}, `a`, `b`); err != nil || m != nil {
+		t.Fatalf("disabled config built a manager: %v %v", m, err)
+	}
+	good := []LeaseConfig{
+		LeaseConfig{HA_Mode: `file`, HA_Lease_File: `/tmp/lease`},
+		LeaseConfig{HA_Mode: `Peer`, HA_Listen: `:7000`, HA_Peer: `10.0.0.1:7000`, HA_Lease_Timeout: `30s`},
+	}
+	for _, lc := range good {
+		if err := lc.Validate(); err != nil {
+			t.Fatalf("%+v: %v", lc, err)
+		}
+	}
+	bad := []LeaseConfig{
+		LeaseConfig{HA_Mode: `raft`},
+		LeaseConfig{HA_Mode: `file`},
+		LeaseConfig{HA_Mode: `file`, HA_Lease_File: `/tmp/lease`, HA_Lease_Timeout: `1s`},
+		LeaseConfig{HA_Mode: `file`, HA_Lease_File: `/tmp/lease`, HA_Lease_Timeout: `soon`},
+		LeaseConfig{HA_Mode: `peer`, HA_Listen: `:7000`},
+		LeaseConfig{HA_Mode: `peer`, HA_Listen: `7000`, HA_Peer: `10.0.0.1:7000`},
+	}
+	for _, lc := range bad {
+		if err := lc.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", lc)
+		}
+	}
+}
+
+func TestFileLease(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), `lease`)
+	a, err := NewFileLease(pth, `a`, testTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewFileLease(pth, `b`, testTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held, err := a.Acquire(); err != nil || !held {
+		t.Fatalf("failed to take free lease: %v %v", held, err)
+	} else if held, err = b.Acquire(); err != nil || held {
+		t.Fatalf("took a held lease: %v %v", held, err)
+	} else if held, err = a.Acquire(); err != nil || !held {
+		t.Fatalf("failed to renew: %v %v", held, err)
+	}
+	if err = a.Release(); err != nil {
+		t.Fatal(err)
+	} else if held, err := b.Acquire(); err != nil || !held {
+		t.Fatalf("failed to take released lease: %v %v", held, err)
+	} else if held, err = a.Acquire(); err != nil || held {
+		t.Fatalf("took a lease after release: %v %v", held, err)
+	}
+}
+
+func TestFileLeaseManager(t *testing.T) {
+	lc := LeaseConfig{
+		HA_Mode:          ModeFile,
+		HA_Lease_File:    filepath.Join(t.TempDir(), `lease`),
+		HA_Lease_Timeout: testTTL.String(),
+	}
+	a, err := NewManager(lc, `a`, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewManager(lc, `b`, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err = a.WaitActive(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if !a.Active() {
+		t.Fatal("not active after acquire")
+	}
+
+	//b must wait until a lets go
+	ctx, cf := context.WithTimeout(context.Background(), 2*time.Second)
+	if err = b.WaitActive(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("standby went active: %v", err)
+	}
+	cf()
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cf = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cf()
+	if err = b.WaitActive(ctx); err != nil {
+		t.Fatalf("standby failed to take over: %v", err)
+	}
+}
+
+func TestPeerLease(t *testing.T) {
+	//grab a pair of ports
+	a, err := NewPeerLease(`127.0.0.1:0`, `127.0.0.1:1`, `a`, `secret`, 1, testTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewPeerLease(`127.0.0.1:0`, a.Addr().String(), `b`, `secret`, 2, testTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	a.peer = b.Addr().String()
+
+	//b is less preferred so it waits, a takes it
+	if held, err := b.Acquire(); err != nil || held {
+		t.Fatalf("less preferred peer went active: %v %v", held, err)
+	} else if held, err = a.Acquire(); err != nil || !held {
+		t.Fatalf("preferred peer failed to go active: %v %v", held, err)
+	} else if held, err = b.Acquire(); err != nil || held {
+		t.Fatalf("standby went active while peer is active: %v %v", held, err)
+	}
+
+	//a goes away, b must wait a full lease period before taking over
+	a.Close()
+	if held, err := b.Acquire(); err != nil || held {
+		t.Fatalf("standby took over early: %v %v", held, err)
+	}
+	b.mtx.Lock()
+	b.lastSeen = time.Now().Add(-testTTL)
+	b.mtx.Unlock()
+	if held, err := b.Acquire(); err != nil || !held {
+		t.Fatalf("standby failed to take over: %v %v", held, err)
+	}
+
+	//a peer with the wrong secret is not trusted
+	c, err := NewPeerLease(`127.0.0.1:0`, b.Addr().String(), `c`, `wrong`, 0, testTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Acquire(); err != ErrBadPeerMAC {
+		t.Fatalf("bad secret not caught: %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ha
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	nonceSize     = 16
+	maxPeerMsg    = 4096
+	maxPeerWaitIO = 5 * time.Second
+)
+
+var (
+	ErrDuplicateNode = errors.New("HA peer has the same node ID")
+	ErrBadPeerMAC    = errors.New("HA peer response failed authentication")
+	ErrPeerMsgSize   = errors.New("HA peer message is too large")
+)
+
+type peerStatus struct {
+	ID       string
+	Priority int
+	Active   bool
+}
+
+type peerRequest struct {
+	Nonce string
+}
+
+type peerResponse struct {
+	Status peerStatus
+	MAC    string
+}
+
+// PeerLease coordinates directly with the other instance.  Each instance answers status requests
+// and polls the other, if both are up the active instance stays active and when neither is active
+// the instance with the lowest priority (then lowest ID) takes over.  If the peer cannot be
+// reached for a full lease period the standby takes over.
+//
+// Responses are authenticated with an HMAC over a requester supplied nonce, so no clock
+// synchronization is required.  Like any two node system a network partition between the
+// instances will result in both going active.
+type PeerLease struct {
+	mtx      sync.Mutex
+	id       string
+	secret   []byte
+	prio     int
+	peer     string
+	ttl      time.Duration
+	active   bool
+	started  time.Time
+	lastSeen time.Time
+	lst      net.Listener
+	wg       sync.WaitGroup
+}
+
+// NewPeerLease starts listening for status requests from the peer
+func NewPeerLease(listen, peer, id, secret string, prio int, ttl time.Duration) (pl *PeerLease, err error) {
+	if secret == `` {
+		err = ErrMissingSecret
+		return
+	}
+	var lst net.Listener
+	if lst, err = net.Listen("tcp", listen); err != nil {
+		return
+	}
+	pl = &PeerLease{
+		id:      id,
+		secret:  []byte(secret),
+		prio:    prio,
+		peer:    peer,
+		ttl:     ttl,
+		started: time.Now(),
+		lst:     lst,
+	}
+	pl.wg.Add(1)
+	go pl.serve()
+	return
+}
+
+// Addr returns the address we are listening for peer requests on
+func (pl *PeerLease) Addr() net.Addr {
+	return pl.lst.Addr()
+}
+
+func (pl *PeerLease) status() peerStatus {
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+	return peerStatus{
+		ID:       pl.id,
+		Priority: pl.prio,
+		Active:   pl.active,
+	}
+}
+
+func (pl *PeerLease) mac(nonce string, st peerStatus) (string, error) {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return ``, err
+	}
+	h := hmac.New(sha256.New, pl.secret)
+	h.Write([]byte(nonce))
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (pl *PeerLease) ioTimeout() time.Duration {
+	if to := pl.ttl / 3; to < maxPeerWaitIO {
+		return to
+	}
+	return maxPeerWaitIO
+}
+
+func (pl *PeerLease) serve() {
+	defer pl.wg.Done()
+	for {
+		conn, err := pl.lst.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		pl.wg.Add(1)
+		go pl.handle(conn)
+	}
+}
+
+func (pl *PeerLease) handle(conn net.Conn) {
+	defer pl.wg.Done()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(pl.ioTimeout()))
+	var req peerRequest
+	if err := readPeerMsg(bufio.NewReader(conn), &req); err != nil || req.Nonce == `` {
+		return
+	}
+	resp := peerResponse{
+		Status: pl.status(),
+	}
+	var err error
+	if resp.MAC, err = pl.mac(req.Nonce, resp.Status); err != nil {
+		return
+	}
+	writePeerMsg(conn, resp)
+}
+
+// query asks the peer for its current status
+func (pl *PeerLease) query() (st peerStatus, err error) {
+	var conn net.Conn
+	to := pl.ioTimeout()
+	if conn, err = net.DialTimeout("tcp", pl.peer, to); err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(to))
+	nb := make([]byte, nonceSize)
+	if _, err = rand.Read(nb); err != nil {
+		return
+	}
+	req := peerRequest{Nonce: hex.EncodeToString(nb)}
+	if err = writePeerMsg(conn, req); err != nil {
+		return
+	}
+	var resp peerResponse
+	if err = readPeerMsg(bufio.NewReader(conn), &resp); err != nil {
+		return
+	}
+	var mac string
+	if mac, err = pl.mac(req.Nonce, resp.Status); err != nil {
+		return
+	} else if !hmac.Equal([]byte(mac), []byte(resp.MAC)) {
+		err = ErrBadPeerMAC
+		return
+	}
+	st = resp.Status
+	return
+}
+
+// preferred returns true if we should win over the peer
+func (pl *PeerLease) preferred(st peerStatus) bool {
+	if pl.prio != st.Priority {
+		return pl.prio < st.Priority
+	}
+	return pl.id < st.ID
+}
+
+// Acquire checks in with the peer and decides whether we should be active
+func (pl *PeerLease) Acquire() (held bool, err error) {
+	st, qerr := pl.query()
+	pl.mtx.Lock()
+	defer pl.mtx.Unlock()
+	now := time.Now()
+	if qerr != nil {
+		if qerr == ErrBadPeerMAC {
+			//someone is answering, but it isn't our peer, don't take over because of it
+			err = qerr
+			held = pl.active
+			return
+		}
+		ref := pl.lastSeen
+		if ref.IsZero() {
+			ref = pl.started
+		}
+		if now.Sub(ref) >= pl.ttl {
+			pl.active = true
+		}
+		held = pl.active
+		return
+	}
+	pl.lastSeen = now
+	switch {
+	case st.ID == pl.id:
+		err = ErrDuplicateNode
+		pl.active = false
+	case st.Active && pl.active:
+		//both sides think they are active, likely after a partition healed
+		pl.active = pl.preferred(st)
+	case st.Active:
+		pl.active = false
+	case pl.active:
+		//we already have it
+	default:
+		pl.active = pl.preferred(st)
+	}
+	held = pl.active
+	return
+}
+
+// Release tells the peer we are no longer active
+func (pl *PeerLease) Release() error {
+	pl.mtx.Lock()
+	pl.active = false
+	pl.mtx.Unlock()
+	return nil
+}
+
+// Close stops answering peer requests
+func (pl *PeerLease) Close() (err error) {
+	err = pl.lst.Close()
+	pl.wg.Wait()
+	return
+}
+
+func writePeerMsg(conn net.Conn, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(b, '\n'))
+	return err
+}
+
+func readPeerMsg(rdr *bufio.Reader, v interface{}) error {
+	var b []byte
+	for {
+		ln, isPrefix, err := rdr.ReadLine()
+		if err != nil {
+			return err
+		}
+		if b = append(b, ln...); len(b) > maxPeerMsg {
+			return ErrPeerMsgSize
+		} else if !isPrefix {
+			break
+		}
+	}
+	return json.Unmarshal(b, v)
+}
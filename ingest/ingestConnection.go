@@ -15,6 +15,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 )
 
@@ -80,6 +81,13 @@ func (igst *IngestConnection) IdentifyIngester(name, version, id string) (err er
 	return
 }
 
+// SendTagHints sends per-tag storage hints to the indexer.
+func (igst *IngestConnection) SendTagHints(hints []config.TagHint) (err error) {
+	igst.mtx.Lock()
+	defer igst.mtx.Unlock()
+	return igst.ew.SendTagHints(hints)
+}
+
 // IngestOK asks the indexer if it is ok to start sending entries yet.
 func (igst *IngestConnection) IngestOK() (ok bool, err error) {
 	igst.mtx.Lock()
@@ -217,7 +225,7 @@ func (igst *IngestConnection) Source() (net.IP, error) {
 	return igst.src, nil
 }
 
-func authenticate(conn io.ReadWriter, tenant string, hash AuthHash, tags []string) (map[string]entry.EntryTag, uint16, error) {
+func authenticate(conn io.ReadWriter, tenant string, auth ingestAuth, tags []string) (map[string]entry.EntryTag, uint16, error) {
 	var tagReq TagRequest
 	var tagResp TagResponse
 	var state StateResponse
@@ -229,7 +237,7 @@ func authenticate(conn io.ReadWriter, tenant string, hash AuthHash, tags []strin
 	}
 
 	//generate response
-	resp, err := GenerateResponse(hash, chal)
+	resp, err := auth.response(chal)
 	if err != nil {
 		return nil, 0, err
 	} else if resp == nil {
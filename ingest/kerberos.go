@@ -0,0 +1,212 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"gopkg.in/jcmturner/gokrb5.v7/client"
+	"gopkg.in/jcmturner/gokrb5.v7/keytab"
+	"gopkg.in/jcmturner/gokrb5.v7/messages"
+	"gopkg.in/jcmturner/gokrb5.v7/service"
+	"gopkg.in/jcmturner/gokrb5.v7/types"
+)
+
+const (
+	// checksum type carrying the challenge binding in the AP-REQ authenticator,
+	// negative checksum types are reserved for local use
+	kerberosChallengeCksum int32 = -0x4757
+)
+
+var (
+	ErrKerberosChallengeMismatch = errors.New("Kerberos authenticator is not bound to the challenge")
+	ErrUnauthorizedPrincipal     = errors.New("Kerberos principal is not authorized")
+)
+
+// ingestAuth holds the credentials used to answer an indexer's challenge
+type ingestAuth struct {
+	hash AuthHash
+	krb  *kerberosAuth // optional, answers with a Kerberos ticket instead of the shared secret
+	host string        // indexer host the Kerberos ticket is requested for
+}
+
+// response answers a challenge with either the shared secret or a Kerberos ticket
+func (ia ingestAuth) response(ch Challenge) (*ChallengeResponse, error) {
+	if ia.krb == nil {
+		return GenerateResponse(ia.hash, ch)
+	} else if ch.Version < MinKerberosAuthVersion {
+		return nil, ErrKerberosAuthUnsupported
+	}
+	tok, err := ia.krb.token(ia.host, ch)
+	if err != nil {
+		return nil, err
+	}
+	return &ChallengeResponse{Version: ch.Version, Kerberos: tok}, nil
+}
+
+// kerberosClient is the part of the Kerberos client used to get indexer tickets
+type kerberosClient interface {
+	GetServiceTicket(spn string) (messages.Ticket, types.EncryptionKey, error)
+	Destroy()
+}
+
+// kerberosAuth requests service tickets for indexers using the keys in a keytab, the client
+// logs in on first use and renews its ticket granting ticket as needed.
+type kerberosAuth struct {
+	sync.Mutex
+	cl      kerberosClient
+	realm   string
+	cname   types.PrincipalName
+	service string
+}
+
+// newKerberosAuth returns nil if Kerberos authentication is not configured
+func newKerberosAuth(isc config.IngestStreamConfig) (*kerberosAuth, error) {
+	if err := isc.CheckKerberosEnabled(); err != nil {
+		return nil, err
+	} else if !isc.KerberosEnabled() {
+		return nil, nil
+	}
+	user, realm, err := isc.KerberosPrincipal()
+	if err != nil {
+		return nil, err
+	}
+	kt, err := keytab.Load(isc.Kerberos_Keytab)
+	if err != nil {
+		return nil, err
+	}
+	kc, err := config.LoadKerberosConfig(isc.KerberosConfig())
+	if err != nil {
+		return nil, err
+	}
+	cl := client.NewClientWithKeytab(user, realm, kt, kc, client.DisablePAFXFAST(true))
+	return &kerberosAuth{
+		cl:      cl,
+		realm:   cl.Credentials.Domain(),
+		cname:   cl.Credentials.CName(),
+		service: isc.KerberosService(),
+	}, nil
+}
+
+// token builds an AP-REQ for the indexer's service principal which is bound to the challenge
+func (ka *kerberosAuth) token(host string, ch Challenge) ([]byte, error) {
+	ka.Lock()
+	defer ka.Unlock()
+	tkt, key, err := ka.cl.GetServiceTicket(ka.service + `/` + host)
+	if err != nil {
+		return nil, err
+	}
+	return newKerberosToken(ka.realm, ka.cname, tkt, key, ch)
+}
+
+func (ka *kerberosAuth) close() {
+	if ka == nil {
+		return
+	}
+	ka.Lock()
+	ka.cl.Destroy()
+	ka.Unlock()
+}
+
+func newKerberosToken(realm string, cname types.PrincipalName, tkt messages.Ticket, key types.EncryptionKey, ch Challenge) ([]byte, error) {
+	auth, err := types.NewAuthenticator(realm, cname)
+	if err != nil {
+		return nil, err
+	}
+	auth.Cksum = types.Checksum{
+		CksumType: kerberosChallengeCksum,
+		Checksum:  challengeBinding(ch),
+	}
+	req, err := messages.NewAPReq(tkt, key, auth)
+	if err != nil {
+		return nil, err
+	}
+	return req.Marshal()
+}
+
+// challengeBinding hashes the challenge into the encrypted authenticator so a captured
+// AP-REQ can't be replayed against another challenge
+func challengeBinding(ch Challenge) []byte {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, ch)
+	return h.Sum(nil)
+}
+
+// kerberosHost returns the host name indexer service principals are expected to carry
+func kerberosHost(dst string) string {
+	if host, _, err := net.SplitHostPort(dst); err == nil {
+		return host
+	}
+	return `localhost` //named pipes
+}
+
+// kerberosVerifier checks AP-REQs from ingesters against the service keytab
+type kerberosVerifier struct {
+	kt      *keytab.Keytab
+	allowed map[string]bool
+}
+
+func newKerberosVerifier(kt string, principals []string) (*kerberosVerifier, error) {
+	kv := &kerberosVerifier{allowed: make(map[string]bool, len(principals))}
+	var err error
+	if kv.kt, err = keytab.Load(kt); err != nil {
+		return nil, err
+	}
+	for _, p := range principals {
+		user, realm, err := config.ParseKerberosPrincipal(p)
+		if err != nil {
+			return nil, err
+		}
+		kv.allowed[user+`@`+realm] = true
+	}
+	return kv, nil
+}
+
+// verify checks the ticket and its binding to the challenge, returning the client principal
+func (kv *kerberosVerifier) verify(tok []byte, ch Challenge, remote net.Addr) (principal string, err error) {
+	var req messages.APReq
+	if err = req.Unmarshal(tok); err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalidKerberosToken, err)
+		return
+	}
+	opts := []func(*service.Settings){service.DecodePAC(false)}
+	if remote != nil {
+		if ha, err := types.GetHostAddress(remote.String()); err == nil {
+			opts = append(opts, service.ClientAddress(ha))
+		}
+	}
+	ok, creds, err := service.VerifyAPREQ(req, service.NewSettings(kv.kt, opts...))
+	if err != nil {
+		return
+	} else if !ok {
+		err = ErrFailedAuth
+		return
+	}
+	//VerifyAPREQ works on a copy, decrypt the authenticator again to check the binding
+	if err = req.Ticket.DecryptEncPart(kv.kt, nil); err != nil {
+		return
+	} else if err = req.DecryptAuthenticator(req.Ticket.DecryptedEncPart.Key); err != nil {
+		return
+	} else if req.Authenticator.Cksum.CksumType != kerberosChallengeCksum || !hmac.Equal(req.Authenticator.Cksum.Checksum, challengeBinding(ch)) {
+		err = ErrKerberosChallengeMismatch
+		return
+	}
+	principal = creds.CName().PrincipalNameString() + `@` + creds.Domain()
+	if len(kv.allowed) > 0 && !kv.allowed[principal] {
+		err = fmt.Errorf("%w: %s", ErrUnauthorizedPrincipal, principal)
+	}
+	return
+}
@@ -0,0 +1,215 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"gopkg.in/jcmturner/gokrb5.v7/iana/nametype"
+	"gopkg.in/jcmturner/gokrb5.v7/keytab"
+	"gopkg.in/jcmturner/gokrb5.v7/messages"
+	"gopkg.in/jcmturner/gokrb5.v7/test/testdata"
+	"gopkg.in/jcmturner/gokrb5.v7/types"
+)
+
+const testRealm = `TEST.GOKRB5`
+
+// fakeKDC hands out tickets for the HTTP/host.test.gokrb5 service from the gokrb5 test keytab
+type fakeKDC struct {
+	kt    *keytab.Keytab
+	cname types.PrincipalName
+	spns  []string
+}
+
+func newFakeKDC(t *testing.T) (*fakeKDC, string) {
+	b, err := hex.DecodeString(testdata.HTTP_KEYTAB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdc := &fakeKDC{kt: keytab.New(), cname: types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, `ingester`)}
+	if err = kdc.kt.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	pth := filepath.Join(t.TempDir(), `indexer.keytab`)
+	if err = ioutil.WriteFile(pth, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return kdc, pth
+}
+
+func (k *fakeKDC) GetServiceTicket(spn string) (messages.Ticket, types.EncryptionKey, error) {
+	k.spns = append(k.spns, spn)
+	sname := types.NewPrincipalName(nametype.KRB_NT_PRINCIPAL, `HTTP/host.test.gokrb5`)
+	now := time.Now().UTC()
+	return messages.NewTicket(k.cname, testRealm, sname, testRealm, types.NewKrbFlags(), k.kt, 18, 1, now, now, now.Add(time.Hour), now.Add(time.Hour))
+}
+
+func (k *fakeKDC) Destroy() {}
+
+func (k *fakeKDC) auth() *kerberosAuth {
+	return &kerberosAuth{cl: k, realm: testRealm, cname: k.cname, service: `gravwell`}
+}
+
+func TestKerberosResponse(t *testing.T) {
+	kdc, ktPath := newFakeKDC(t)
+	ka := kdc.auth()
+	kv, err := newKerberosVerifier(ktPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chal, _ := NewChallenge(AuthHash{})
+	chal.Version = MinKerberosAuthVersion
+	ia := ingestAuth{krb: ka, host: kerberosHost(`idx.example.com:4023`)}
+	resp, err := ia.response(chal)
+	if err != nil {
+		t.Fatal(err)
+	} else if kdc.spns[0] != `gravwell/idx.example.com` {
+		t.Fatalf("bad service principal %s", kdc.spns[0])
+	}
+
+	//the response survives the wire
+	resp.Tenant = `foo`
+	var bb bytes.Buffer
+	var rresp ChallengeResponse
+	if err = resp.Write(&bb); err != nil {
+		t.Fatal(err)
+	} else if err = rresp.Read(&bb); err != nil {
+		t.Fatal(err)
+	} else if rresp.Tenant != `foo` || !bytes.Equal(rresp.Kerberos, resp.Kerberos) || rresp.Version != MinKerberosAuthVersion {
+		t.Fatalf("bad response %+v", rresp)
+	}
+	if p, err := kv.verify(rresp.Kerberos, chal, nil); err != nil {
+		t.Fatal(err)
+	} else if p != `ingester@`+testRealm {
+		t.Fatalf("bad principal %s", p)
+	}
+
+	//a ticket answering another challenge is rejected
+	other, _ := NewChallenge(AuthHash{})
+	other.Version = MinKerberosAuthVersion
+	if resp, err = ia.response(other); err != nil {
+		t.Fatal(err)
+	} else if _, err = kv.verify(resp.Kerberos, chal, nil); err != ErrKerberosChallengeMismatch {
+		t.Fatalf("challenge mismatch not caught: %v", err)
+	}
+
+	//only listed principals are allowed
+	if kv, err = newKerberosVerifier(ktPath, []string{`someone@` + testRealm}); err != nil {
+		t.Fatal(err)
+	} else if resp, err = ia.response(chal); err != nil {
+		t.Fatal(err)
+	} else if _, err = kv.verify(resp.Kerberos, chal, nil); !errors.Is(err, ErrUnauthorizedPrincipal) {
+		t.Fatalf("unlisted principal not caught: %v", err)
+	}
+
+	//old indexers can't take tickets
+	chal.Version = VERSION
+	if _, err = ia.response(chal); err != ErrKerberosAuthUnsupported {
+		t.Fatalf("old auth version not caught: %v", err)
+	}
+}
+
+func TestKerberosAuthGate(t *testing.T) {
+	isc := config.IngestStreamConfig{
+		Kerberos_Keytab:    `/nonexistent/ingester.keytab`,
+		Kerberos_Principal: `ingester@` + testRealm,
+	}
+	//Kerberos settings without the experimental flag are refused rather than ignored
+	if ka, err := newKerberosAuth(isc); err != config.ErrKerberosNotEnabled || ka != nil {
+		t.Fatalf("Kerberos enabled without Experimental-Kerberos: %v", err)
+	}
+	if ka, err := newKerberosAuth(config.IngestStreamConfig{}); err != nil || ka != nil {
+		t.Fatalf("Kerberos enabled without any settings: %v", err)
+	}
+}
+
+func TestKerberosIngestServer(t *testing.T) {
+	kdc, ktPath := newFakeKDC(t)
+	col := &serverCollector{ents: map[string][]string{}}
+	principals := make(chan string, 1)
+	srv, err := NewIngestServer(ServerConfig{
+		KerberosKeytab: ktPath,
+		Handler:        col.handle,
+		Connected: func(sc *ServerConn) error {
+			principals <- sc.Principal()
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lst)
+	defer srv.Close()
+
+	//without Kerberos there are no secrets to authenticate with
+	tgt := Target{Address: `tcp://` + lst.Addr().String()}
+	if _, err = initConnection(tgt, []string{`foo`}, ``, ``, false, tlsOptions{}, nil); err != ErrFailedAuth {
+		t.Fatalf("secret accepted by a Kerberos only server: %v", err)
+	}
+	igst, err := initConnection(tgt, []string{`foo`}, ``, ``, false, tlsOptions{}, kdc.auth())
+	if err != nil {
+		t.Fatal(err)
+	} else if err = igst.IdentifyIngester(`kerberos`, `1`, ``); err != nil {
+		t.Fatal(err)
+	} else if ok, err := igst.IngestOK(); err != nil || !ok {
+		t.Fatalf("ingest not ok: %v", err)
+	} else if err = igst.ew.ConfigureStream(StreamConfiguration{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-principals:
+		if p != `ingester@`+testRealm {
+			t.Fatalf("bad principal %s", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ingester never connected")
+	}
+	foo, _ := igst.GetTag(`foo`)
+	if err = igst.WriteEntry(&entry.Entry{TS: entry.Now(), Tag: foo, Data: []byte(`hello`)}); err != nil {
+		t.Fatal(err)
+	} else if err = igst.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500 && col.count(`foo`) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if col.count(`foo`) != 1 {
+		t.Fatal("entry not received")
+	}
+	igst.Close()
+
+	//a server without a keytab turns tickets away
+	secretOnly, err := NewIngestServer(ServerConfig{Secret: `foo`, Handler: col.handle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lst2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go secretOnly.Serve(lst2)
+	defer secretOnly.Close()
+	tgt.Address = `tcp://` + lst2.Addr().String()
+	if _, err = initConnection(tgt, []string{`foo`}, ``, ``, false, tlsOptions{}, kdc.auth()); err != ErrKerberosAuthUnsupported {
+		t.Fatalf("ticket sent to a server without Kerberos: %v", err)
+	}
+}
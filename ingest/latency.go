@@ -0,0 +1,224 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	latencyWindow        = 100             // percentiles cover this many of the most recent markers
+	minLatencyMarkerLoss = time.Minute     // markers unconfirmed this long are counted as lost
+	latencyMarkerLossMul = 10              // or this many intervals, whichever is longer
+	latencyMarkerWrite   = 5 * time.Second // longest we block trying to queue a marker
+)
+
+// IngestLatency summarizes how long indexers took to acknowledge latency markers.  Latency is
+// measured from when a marker is handed to the muxer until an indexer confirms it, so it covers
+// time spent in the muxer queues, on the wire, and in the indexer.
+type IngestLatency struct {
+	Sent      uint64        // markers written
+	Confirmed uint64        // markers acknowledged by an indexer
+	Lost      uint64        // markers that were never acknowledged, including markers spooled to the cache
+	Pending   int           // markers waiting to be acknowledged
+	Last      time.Duration // latency of the most recently acknowledged marker
+	P50       time.Duration // median over the most recent markers
+	P99       time.Duration // 99th percentile over the most recent markers
+	Max       time.Duration // largest latency over the most recent markers
+}
+
+// latencyMarkerRecord is the body of a latency marker entry
+type latencyMarkerRecord struct {
+	LatencyMarker uint64
+	Ingester      string
+	IngesterUUID  string
+	Sent          time.Time
+}
+
+// latencyMarkers periodically sends marker entries and times how long indexers take to confirm
+// them.  Every confirmation is reported to ack, markers are recognized by the entry pointer
+// so a marker that goes through the cache is never matched and eventually counts as lost.
+type latencyMarkers struct {
+	sync.Mutex
+	pending  int32 // number of outstanding markers, checked atomically on every ack
+	interval time.Duration
+	loss     time.Duration
+	tagName  string
+	tag      entry.EntryTag
+	seq      uint64
+	sent     map[*entry.Entry]time.Time
+	window   []time.Duration // ring of the most recent latencies
+	next     int
+	stats    IngestLatency
+}
+
+func newLatencyMarkers(lmc config.LatencyMarkerConfig) (*latencyMarkers, error) {
+	if !lmc.Enabled() {
+		return nil, nil
+	} else if err := CheckTag(lmc.Tag); err != nil {
+		return nil, err
+	}
+	lm := &latencyMarkers{
+		interval: lmc.Interval,
+		loss:     latencyMarkerLossMul * lmc.Interval,
+		tagName:  lmc.Tag,
+		sent:     map[*entry.Entry]time.Time{},
+		window:   make([]time.Duration, 0, latencyWindow),
+	}
+	if lm.loss < minLatencyMarkerLoss {
+		lm.loss = minLatencyMarkerLoss
+	}
+	return lm, nil
+}
+
+// marker builds the next marker entry and starts timing it
+func (lm *latencyMarkers) marker(name, uuid string, src net.IP) (ent *entry.Entry) {
+	lm.Lock()
+	defer lm.Unlock()
+	now := time.Now()
+	b, err := json.Marshal(latencyMarkerRecord{
+		LatencyMarker: lm.seq,
+		Ingester:      name,
+		IngesterUUID:  uuid,
+		Sent:          now.UTC(),
+	})
+	if err != nil {
+		return nil
+	}
+	ent = &entry.Entry{
+		TS:   entry.FromStandard(now),
+		Tag:  lm.tag,
+		SRC:  src,
+		Data: b,
+	}
+	lm.seq++
+	lm.sent[ent] = now
+	lm.stats.Sent++
+	atomic.AddInt32(&lm.pending, 1)
+	return
+}
+
+// unsent stops timing a marker that never made it into the muxer
+func (lm *latencyMarkers) unsent(ent *entry.Entry) {
+	lm.Lock()
+	if _, ok := lm.sent[ent]; ok {
+		delete(lm.sent, ent)
+		lm.stats.Sent--
+		atomic.AddInt32(&lm.pending, -1)
+	}
+	lm.Unlock()
+}
+
+// ack is called with every entry an indexer confirms
+func (lm *latencyMarkers) ack(ent *entry.Entry) {
+	if lm == nil || ent == nil || atomic.LoadInt32(&lm.pending) == 0 {
+		return
+	}
+	lm.Lock()
+	defer lm.Unlock()
+	sent, ok := lm.sent[ent]
+	if !ok {
+		return
+	}
+	delete(lm.sent, ent)
+	atomic.AddInt32(&lm.pending, -1)
+	lat := time.Since(sent)
+	if len(lm.window) < latencyWindow {
+		lm.window = append(lm.window, lat)
+	} else {
+		lm.window[lm.next] = lat
+		lm.next = (lm.next + 1) % latencyWindow
+	}
+	lm.stats.Confirmed++
+	lm.stats.Last = lat
+}
+
+// expire counts markers that have been outstanding too long as lost
+func (lm *latencyMarkers) expire() {
+	lm.Lock()
+	defer lm.Unlock()
+	for ent, sent := range lm.sent {
+		if time.Since(sent) > lm.loss {
+			delete(lm.sent, ent)
+			atomic.AddInt32(&lm.pending, -1)
+			lm.stats.Lost++
+		}
+	}
+}
+
+// snapshot returns the current latency summary
+func (lm *latencyMarkers) snapshot() (s IngestLatency) {
+	lm.Lock()
+	defer lm.Unlock()
+	s = lm.stats
+	s.Pending = len(lm.sent)
+	if len(lm.window) == 0 {
+		return
+	}
+	w := append([]time.Duration(nil), lm.window...)
+	sort.Slice(w, func(i, j int) bool { return w[i] < w[j] })
+	s.P50 = latencyPercentile(w, 50)
+	s.P99 = latencyPercentile(w, 99)
+	s.Max = w[len(w)-1]
+	return
+}
+
+// latencyPercentile uses the nearest rank method on a sorted set of latencies
+func latencyPercentile(sorted []time.Duration, p int) time.Duration {
+	idx := (p*len(sorted)+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// latencyRoutine sends a marker every interval while at least one indexer is connected.
+// Markers are not sent while we are offline so they do not pile up in the cache.
+func (im *IngestMuxer) latencyRoutine() {
+	tkr := time.NewTicker(im.latency.interval)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-im.dieChan:
+			return
+		case <-tkr.C:
+			im.latency.expire()
+			if atomic.LoadInt32(&im.connHot) == 0 {
+				continue
+			}
+			src, _ := im.SourceIP()
+			ent := im.latency.marker(im.name, im.uuid, src)
+			if ent == nil {
+				continue
+			}
+			if err := im.WriteEntryTimeout(ent, latencyMarkerWrite); err != nil {
+				im.latency.unsent(ent)
+				im.Warn("failed to send latency marker", log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid), log.KVErr(err))
+			}
+		}
+	}
+}
+
+// IngestLatency returns how long indexers have been taking to acknowledge latency markers,
+// ok is false if latency markers are not enabled.
+func (im *IngestMuxer) IngestLatency() (il IngestLatency, ok bool) {
+	if im.latency == nil {
+		return
+	}
+	return im.latency.snapshot(), true
+}
@@ -0,0 +1,126 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestLatencyMarkerStats(t *testing.T) {
+	lm, err := newLatencyMarkers(config.LatencyMarkerConfig{Interval: time.Second, Tag: `lat`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := lm.snapshot(); s.Sent != 0 || s.P50 != 0 {
+		t.Fatalf("bad empty stats %+v", s)
+	}
+	//acks for entries that are not markers are ignored
+	lm.ack(&entry.Entry{})
+	for i := 0; i < 2*latencyWindow; i++ {
+		ent := lm.marker(`test`, ``, nil)
+		if ent == nil {
+			t.Fatal("no marker")
+		}
+		//only the latest window of markers counts, make the old ones slow
+		lat := time.Duration(i-latencyWindow+1) * time.Millisecond
+		if i < latencyWindow {
+			lat = time.Hour
+		}
+		lm.sent[ent] = time.Now().Add(-lat)
+		lm.ack(ent)
+	}
+	gone := lm.marker(`test`, ``, nil)
+	lm.unsent(gone)
+	lm.marker(`test`, ``, nil)
+	s := lm.snapshot()
+	if s.Sent != 2*latencyWindow+1 || s.Confirmed != 2*latencyWindow || s.Pending != 1 || s.Lost != 0 {
+		t.Fatalf("bad counts %+v", s)
+	}
+	within := func(d, exp time.Duration) bool {
+		return d >= exp && d < exp+time.Second
+	}
+	if !within(s.P50, 50*time.Millisecond) || !within(s.P99, 99*time.Millisecond) || !within(s.Max, 100*time.Millisecond) || !within(s.Last, 100*time.Millisecond) {
+		t.Fatalf("bad latencies %+v", s)
+	}
+
+	//markers that are never confirmed are eventually lost
+	for ent := range lm.sent {
+		lm.sent[ent] = time.Now().Add(-2 * lm.loss)
+	}
+	lm.expire()
+	if s = lm.snapshot(); s.Pending != 0 || s.Lost != 1 {
+		t.Fatalf("bad counts after expire %+v", s)
+	}
+}
+
+func TestLatencyMarkers(t *testing.T) {
+	col := &serverCollector{ents: map[string][]string{}}
+	srv, err := NewIngestServer(ServerConfig{Secret: `foo`, Handler: col.handle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lst)
+
+	im, err := NewUniformMuxer(UniformMuxerConfig{
+		Destinations: []string{`tcp://` + lst.Addr().String()},
+		Tags:         []string{`foo`},
+		Auth:         `foo`,
+		IngesterName: `latencytest`,
+		IngestStreamConfig: config.IngestStreamConfig{
+			Latency_Marker_Interval: `1s`,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer im.Close()
+	if _, err = im.GetTag(config.DefaultLatencyMarkerTag); err != nil {
+		t.Fatalf("latency marker tag was not negotiated: %v", err)
+	} else if err = im.Start(); err != nil {
+		t.Fatal(err)
+	} else if err = im.WaitForHot(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var il IngestLatency
+	var ok bool
+	for i := 0; i < 500; i++ {
+		if il, ok = im.IngestLatency(); !ok {
+			t.Fatal("latency markers are not enabled")
+		} else if il.Confirmed > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if il.Confirmed == 0 || il.Sent < il.Confirmed || il.Last <= 0 || il.P50 <= 0 || il.P99 < il.P50 || il.Max < il.P99 {
+		t.Fatalf("bad latency stats %+v", il)
+	}
+	col.Lock()
+	defer col.Unlock()
+	markers := col.ents[config.DefaultLatencyMarkerTag]
+	if len(markers) == 0 {
+		t.Fatal("no markers were delivered")
+	}
+	var rec latencyMarkerRecord
+	if err = json.Unmarshal([]byte(markers[0]), &rec); err != nil {
+		t.Fatal(err)
+	} else if rec.Ingester != `latencytest` || rec.Sent.IsZero() {
+		t.Fatalf("bad marker %+v", rec)
+	}
+}
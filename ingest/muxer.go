@@ -30,8 +30,10 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
 
 	"github.com/google/renameio"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -84,7 +86,14 @@ type TargetError struct {
 }
 
 type IngestMuxer struct {
-	cfg StreamConfiguration //stream configuration
+	//checksumErrors is the total number of entries reported corrupted across all connections
+	//it is accessed atomically and must stay first in the struct for 32bit alignment
+	checksumErrors uint64
+	//mirrorStalled and mirrorDropped track copies the secondary group could not take, they are
+	//accessed atomically and follow checksumErrors to stay aligned
+	mirrorStalled int64               //unix nanoseconds the secondary group last failed to take a copy, zero if it is keeping up
+	mirrorDropped uint64              //copies dropped because the secondary group could not keep up
+	cfg           StreamConfiguration //stream configuration
 	//connHot, and connDead have atomic operations
 	//its important that these are aligned on 8 byte boundaries
 	//or it will panic on 32bit architectures
@@ -98,6 +107,8 @@ type IngestMuxer struct {
 	errDest           []TargetError
 	tags              []string
 	tagMap            map[string]entry.EntryTag
+	tagHints          []config.TagHint
+	tagPatterns       []tagPattern // wildcard patterns for tags created on demand
 	pubKey            string
 	privKey           string
 	verifyCert        bool
@@ -105,6 +116,10 @@ type IngestMuxer struct {
 	eChanOut          chan interface{}
 	bChan             chan interface{}
 	bChanOut          chan interface{}
+	hChan             chan interface{} // high priority entries and batches
+	hChanOut          chan interface{}
+	lChan             chan interface{} // low priority entries and batches
+	lChanOut          chan interface{}
 	eq                *emergencyQueue
 	dieChan           chan bool
 	upChan            chan bool
@@ -118,7 +133,22 @@ type IngestMuxer struct {
 	cachePath         string
 	cache             *chancacher.ChanCacher
 	bcache            *chancacher.ChanCacher
+	hcache            *chancacher.ChanCacher
+	lcache            *chancacher.ChanCacher
 	cacheAlways       bool
+	cacheFail         bool
+	tagPrio           map[string]Priority              // tag priorities by name
+	prioMap           atomic.Value                     // map[entry.EntryTag]Priority used on the write path
+	tagRates          map[string]*rate.Limiter         // tag rate limits by name
+	rateMap           atomic.Value                     // map[entry.EntryTag]*rate.Limiter used on the write path
+	destRates         map[string]*parent               // destination rate limits by address
+	destTLS           map[string]config.DestinationTLS // destination CA bundles and key pins by address
+	identity          *workloadIdentity                // optional SPIFFE identity for indexer connections
+	krb               *kerberosAuth                    // optional Kerberos credentials used in place of secrets
+	adaptive          *adaptiveRate                    // optional, scales the global rate limit on indexer feedback
+	seqs              *seqTracker                      // optional, skips already delivered entries on cache replay
+	latency           *latencyMarkers                  // optional, times indexer acknowledgment of marker entries
+	traces            *entryTraces                     // optional, adds muxer spans to traced entries
 	name              string
 	version           string
 	uuid              string
@@ -127,12 +157,16 @@ type IngestMuxer struct {
 	ingesterState     IngesterState
 	logbuff           *EntryBuffer // for holding logs until we can push them
 	start             time.Time    // when the muxer was started
+	secondary         *IngestMuxer // optional secondary destination group
+	routes            tagRoutes    // which tags go to the secondary group
+	routeMap          atomic.Value // map[entry.EntryTag]secondaryRoute used on the write path
 }
 
 type UniformMuxerConfig struct {
 	config.IngestStreamConfig
 	Destinations      []string
 	Tags              []string
+	TagPatterns       []string // wildcard patterns for tags that may be created with GetOrCreateTag
 	Tenant            string
 	Auth              string
 	PublicKey         string
@@ -150,12 +184,15 @@ type UniformMuxerConfig struct {
 	IngesterLabel     string
 	RateLimitBps      int64
 	LogSourceOverride net.IP
+	Tracer            *tracing.Tracer         // optional, entries written with a traced context get muxer spans
+	Secondary         *UniformSecondaryConfig // optional second group of indexers
 }
 
 type MuxerConfig struct {
 	config.IngestStreamConfig
 	Destinations      []Target
 	Tags              []string
+	TagPatterns       []string // wildcard patterns for tags that may be created with GetOrCreateTag
 	PublicKey         string
 	PrivateKey        string
 	VerifyCert        bool
@@ -171,6 +208,8 @@ type MuxerConfig struct {
 	IngesterLabel     string
 	RateLimitBps      int64
 	LogSourceOverride net.IP
+	Tracer            *tracing.Tracer  // optional, entries written with a traced context get muxer spans
+	Secondary         *SecondaryConfig // optional second group of indexers
 }
 
 func NewUniformMuxer(c UniformMuxerConfig) (*IngestMuxer, error) {
@@ -199,7 +238,7 @@ func NewUniformIngestMuxerExt(dests, tags []string, authString, pubKey, privKey,
 }
 
 func newUniformIngestMuxerEx(c UniformMuxerConfig) (*IngestMuxer, error) {
-	if len(c.Auth) == 0 {
+	if len(c.Auth) == 0 && !c.KerberosEnabled() {
 		return nil, ErrEmptyAuth
 	}
 	destinations := make([]Target, len(c.Destinations))
@@ -211,10 +250,15 @@ func newUniformIngestMuxerEx(c UniformMuxerConfig) (*IngestMuxer, error) {
 	if len(destinations) == 0 {
 		return nil, ErrNoTargets
 	}
+	secondary, err := c.Secondary.secondaryConfig(c.KerberosEnabled())
+	if err != nil {
+		return nil, err
+	}
 	cfg := MuxerConfig{
 		IngestStreamConfig: c.IngestStreamConfig,
 		Destinations:       destinations,
 		Tags:               c.Tags,
+		TagPatterns:        c.TagPatterns,
 		PublicKey:          c.PublicKey,
 		PrivateKey:         c.PrivateKey,
 		VerifyCert:         c.VerifyCert,
@@ -230,6 +274,8 @@ func newUniformIngestMuxerEx(c UniformMuxerConfig) (*IngestMuxer, error) {
 		RateLimitBps:       c.RateLimitBps,
 		Logger:             c.Logger,
 		LogSourceOverride:  c.LogSourceOverride,
+		Tracer:             c.Tracer,
+		Secondary:          secondary,
 	}
 	return newIngestMuxer(cfg)
 }
@@ -258,16 +304,74 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		}
 		localTags = append(localTags, c.Tags[i])
 	}
+	latencyCfg, err := c.IngestStreamConfig.LatencyMarkers()
+	if err != nil {
+		return nil, err
+	}
+	latency, err := newLatencyMarkers(latencyCfg)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid latency marker tag %q %v", latencyCfg.Tag, err)
+	} else if latency != nil {
+		var found bool
+		for _, v := range localTags {
+			found = found || v == latency.tagName
+		}
+		if !found {
+			localTags = append(localTags, latency.tagName)
+		}
+	}
+	tagPatterns, err := compileTagPatterns(c.TagPatterns)
+	if err != nil {
+		return nil, err
+	}
+	tagHints, err := c.IngestStreamConfig.TagHints()
+	if err != nil {
+		return nil, err
+	}
+	for _, th := range tagHints {
+		if err := CheckTag(th.Tag); err != nil {
+			return nil, fmt.Errorf("Invalid tag hint tag %q %v", th.Tag, err)
+		}
+	}
+	destTLS, err := destinationTLS(c.IngestStreamConfig, c.Destinations)
+	if err != nil {
+		return nil, err
+	}
 	if c.Logger == nil {
 		c.Logger = log.NewDiscardLogger()
 	}
+	identity, err := newWorkloadIdentity(c.IngestStreamConfig, c.Destinations, c.Logger)
+	if err != nil {
+		return nil, err
+	}
+	krb, err := newKerberosAuth(c.IngestStreamConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, dst := range c.Destinations {
+		if err := checkFIPSTarget(dst.Address, c.VerifyCert || destTLS[dst.Address].Verifies() || identity != nil); err != nil {
+			return nil, err
+		}
+	}
+
+	// build the secondary destination group first so bad secondary configs fail before we open our caches
+	var secondary *IngestMuxer
+	var routes tagRoutes
+	if c.Secondary != nil {
+		if secondary, routes, err = newSecondary(c); err != nil {
+			return nil, err
+		}
+	}
+
+	// check for leftovers before the caches start draining them
+	replay := c.CachePath != "" && cacheHasData(c.CachePath)
 
 	// connect up the chancacher
-	gob.Register(&entry.Entry{})
 	var cache *chancacher.ChanCacher
 	var bcache *chancacher.ChanCacher
+	var hcache *chancacher.ChanCacher
+	var lcache *chancacher.ChanCacher
 
-	var err error
 	if c.CachePath != "" {
 		cache, err = chancacher.NewChanCacher(c.CacheDepth, filepath.Join(c.CachePath, "e"), mb*c.CacheSize)
 		if err != nil {
@@ -277,6 +381,14 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		if err != nil {
 			return nil, err
 		}
+		hcache, err = chancacher.NewChanCacher(c.CacheDepth, filepath.Join(c.CachePath, "h"), mb*c.CacheSize)
+		if err != nil {
+			return nil, err
+		}
+		lcache, err = chancacher.NewChanCacher(c.CacheDepth, filepath.Join(c.CachePath, "l"), mb*c.CacheSize)
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		cache, err = chancacher.NewChanCacher(c.CacheDepth, "", 0)
 		if err != nil {
@@ -286,11 +398,51 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		if err != nil {
 			return nil, err
 		}
+		hcache, err = chancacher.NewChanCacher(c.CacheDepth, "", 0)
+		if err != nil {
+			return nil, err
+		}
+		lcache, err = chancacher.NewChanCacher(c.CacheDepth, "", 0)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if c.CacheMode == CacheModeFail {
 		cache.CacheStop()
 		bcache.CacheStop()
+		hcache.CacheStop()
+		lcache.CacheStop()
+	}
+
+	codecPolicy, err := chancacher.ParseCodecPolicy(c.Cache_Codec)
+	if err != nil {
+		return nil, err
+	}
+	for _, cc := range []*chancacher.ChanCacher{cache, bcache, hcache, lcache} {
+		cc.SetLogger(c.Logger)
+		cc.SetCodecPolicy(codecPolicy)
+	}
+
+	var seqs *seqTracker
+	if c.Cache_Dedup && c.CachePath != "" {
+		if seqs, err = newSeqTracker(c.CachePath, replay); err != nil {
+			return nil, err
+		}
+	}
+
+	tagPrios, err := c.IngestStreamConfig.TagPriorities()
+	if err != nil {
+		return nil, err
+	}
+	tagPrio := make(map[string]Priority, len(tagPrios))
+	for _, tp := range tagPrios {
+		if err := CheckTag(tp.Tag); err != nil {
+			return nil, fmt.Errorf("Invalid tag priority tag %q %v", tp.Tag, err)
+		}
+		if tagPrio[tp.Tag], err = ParsePriority(tp.Priority); err != nil {
+			return nil, err
+		}
 	}
 
 	// It's possible that the configuration, and therefore tag names and
@@ -300,6 +452,10 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 	// add our tags to them. If the old tag map doesn't exist, then it's
 	// anyone's guess where those entries might end up. Those are the
 	// breaks.
+	//
+	// The tag map is also kept across clean shutdowns so that every tag we
+	// have seen, including ones negotiated on the fly, resolves locally even
+	// if no indexer is reachable at startup.
 	var taglist []string
 	tagMap := make(map[string]entry.EntryTag)
 	if c.CachePath != "" {
@@ -334,11 +490,30 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 	if c.CachePath != "" {
 		writeTagCache(tagMap, c.CachePath)
 	}
+	if latency != nil {
+		latency.tag = tagMap[latency.tagName]
+	}
 
 	var p *parent
 	if c.RateLimitBps > 0 {
 		p = newParent(c.RateLimitBps, 0)
 	}
+	tagRateLimits, err := tagRates(c.IngestStreamConfig)
+	if err != nil {
+		return nil, err
+	}
+	destRates, err := destinationRates(c.IngestStreamConfig, c.Destinations)
+	if err != nil {
+		return nil, err
+	}
+	adaptiveCfg, err := c.IngestStreamConfig.AdaptiveRate()
+	if err != nil {
+		return nil, err
+	}
+	adaptive, err := newAdaptiveRate(p, c.RateLimitBps, adaptiveCfg)
+	if err != nil {
+		return nil, err
+	}
 
 	// Initialize the state
 	state := IngesterState{
@@ -360,11 +535,13 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		buff: make([]entry.Entry, 4096),
 	}
 
-	return &IngestMuxer{
+	im := &IngestMuxer{
 		cfg:               getStreamConfig(c.IngestStreamConfig),
 		dests:             c.Destinations,
 		tags:              taglist,
 		tagMap:            tagMap,
+		tagHints:          tagHints,
+		tagPatterns:       tagPatterns,
 		pubKey:            c.PublicKey,
 		privKey:           c.PrivateKey,
 		verifyCert:        c.VerifyCert,
@@ -378,15 +555,32 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		eChanOut:          cache.Out,
 		bChan:             bcache.In,
 		bChanOut:          bcache.Out,
+		hChan:             hcache.In,
+		hChanOut:          hcache.Out,
+		lChan:             lcache.In,
+		lChanOut:          lcache.Out,
 		eq:                newEmergencyQueue(),
 		dieChan:           make(chan bool, len(c.Destinations)),
 		upChan:            make(chan bool, 1),
 		errChan:           make(chan error, len(c.Destinations)),
 		cache:             cache,
 		bcache:            bcache,
+		hcache:            hcache,
+		lcache:            lcache,
 		cacheEnabled:      c.CachePath != "",
 		cachePath:         c.CachePath,
 		cacheAlways:       strings.ToLower(c.CacheMode) == CacheModeAlways,
+		cacheFail:         c.CacheMode == CacheModeFail,
+		tagPrio:           tagPrio,
+		tagRates:          tagRateLimits,
+		destRates:         destRates,
+		destTLS:           destTLS,
+		identity:          identity,
+		krb:               krb,
+		adaptive:          adaptive,
+		seqs:              seqs,
+		latency:           latency,
+		traces:            newEntryTraces(c.Tracer),
 		name:              c.IngesterName,
 		version:           c.IngesterVersion,
 		uuid:              c.IngesterUUID,
@@ -394,7 +588,27 @@ func newIngestMuxer(c MuxerConfig) (*IngestMuxer, error) {
 		logSourceOverride: c.LogSourceOverride,
 		ingesterState:     state,
 		logbuff:           logbuff,
-	}, nil
+	}
+	im.updatePriorities()
+	im.updateTagRates()
+	if secondary != nil {
+		im.secondary, im.routes = secondary, routes
+		if err = im.updateRoutes(); err != nil {
+			return nil, err
+		}
+	}
+	return im, nil
+}
+
+// cacheHasData reports whether any of the cache files under a cache path hold entries
+func cacheHasData(p string) bool {
+	files, _ := filepath.Glob(filepath.Join(p, "*", "cache_*"))
+	for _, f := range files {
+		if fi, err := os.Stat(f); err == nil && fi.Size() > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 func readTagCache(p string) (map[string]entry.EntryTag, error) {
@@ -433,9 +647,9 @@ func writeTagCache(t map[string]entry.EntryTag, p string) error {
 	return renameio.WriteFile(path, b.Bytes(), 0660)
 }
 
-//Start starts the connection process. This will return immediately, and does
-//not mean that connections are ready. Callers should call WaitForHot immediately after
-//to wait for the connections to be ready.
+// Start starts the connection process. This will return immediately, and does
+// not mean that connections are ready. Callers should call WaitForHot immediately after
+// to wait for the connections to be ready.
 func (im *IngestMuxer) Start() error {
 	im.mtx.Lock()
 	defer im.mtx.Unlock()
@@ -446,6 +660,11 @@ func (im *IngestMuxer) Start() error {
 	if im.cacheEnabled && im.cacheAlways {
 		im.cache.CacheStart()
 		im.bcache.CacheStart()
+		im.hcache.CacheStart()
+	}
+	//low priority entries always spill to the cache when the queue backs up
+	if im.cacheEnabled && !im.cacheFail {
+		im.lcache.CacheStart()
 	}
 
 	//fire up the ingest routines
@@ -460,7 +679,19 @@ func (im *IngestMuxer) Start() error {
 	im.state = running
 	// start the state report goroutine
 	go im.stateReportRoutine()
+	if im.seqs != nil {
+		go im.seqSaveRoutine()
+	}
+	if im.latency != nil {
+		go im.latencyRoutine()
+	}
+	if im.traces != nil {
+		go im.traceExpireRoutine()
+	}
 
+	if im.secondary != nil {
+		return im.secondary.Start()
+	}
 	return nil
 }
 
@@ -486,25 +717,37 @@ func (im *IngestMuxer) Close() error {
 
 	//wait for everyone to quit
 	im.wg.Wait()
+	im.traces.expire(true)
 
 	im.mtx.Lock()
 	defer im.mtx.Unlock()
 
 	close(im.eChan)
 	close(im.bChan)
+	close(im.hChan)
+	close(im.lChan)
 
 	// commit any outstanding data to disk, if the backing path is enabled.
 	im.cache.Commit()
 	im.bcache.Commit()
+	im.hcache.Commit()
+	im.lcache.Commit()
 
-	// If BOTH caches are empty, we can delete the stored tag map
-	if im.cacheEnabled && im.cache.Size() == 0 && im.bcache.Size() == 0 {
-		path := filepath.Join(im.cachePath, "tagcache")
-		os.Remove(path)
+	// If ALL caches are empty, we can drop the sequence state; the tag map is
+	// kept so tags resolve locally if no indexers are up on the next start
+	if im.cacheEnabled && im.cacheSize() == 0 {
+		im.seqs.remove()
+	} else if err := im.seqs.save(); err != nil {
+		im.Error("failed to save cache sequence state", log.KV("path", im.cachePath), log.KVErr(err))
 	}
 
 	//everyone is dead, clean up
 	close(im.upChan)
+	im.identity.close()
+	im.krb.close()
+	if im.secondary != nil {
+		return im.secondary.Close()
+	}
 	return nil
 }
 
@@ -512,9 +755,17 @@ func (im *IngestMuxer) stateReportRoutine() {
 	for im.state == running {
 		im.mtx.Lock()
 		// update the cache stats real quick
-		im.ingesterState.CacheSize = uint64(im.cache.Size())
+		im.ingesterState.CacheSize = uint64(im.cacheSize())
 		im.ingesterState.Uptime = time.Since(im.start)
 		im.ingesterState.Tags = im.tags
+		im.ingesterState.ChecksumErrs = im.ChecksumErrors()
+		if im.latency != nil {
+			il := im.latency.snapshot()
+			im.ingesterState.Latency = &il
+		}
+		if im.secondary != nil {
+			im.ingesterState.Children[secondaryStateKey] = im.secondary.stateSnapshot()
+		}
 		for _, v := range im.igst {
 			if v != nil {
 				// we don't fuss over the return value
@@ -534,7 +785,12 @@ func (im *IngestMuxer) SetRawConfiguration(obj interface{}) (err error) {
 	if msg, err = json.Marshal(obj); err != nil {
 		return
 	}
+	im.mtx.Lock()
 	im.ingesterState.Configuration = json.RawMessage(msg)
+	im.mtx.Unlock()
+	if im.secondary != nil {
+		err = im.secondary.SetRawConfiguration(obj)
+	}
 	return
 }
 
@@ -546,7 +802,35 @@ func (im *IngestMuxer) SetMetadata(obj interface{}) (err error) {
 	if msg, err = json.Marshal(obj); err != nil {
 		return
 	}
+	im.mtx.Lock()
 	im.ingesterState.Metadata = json.RawMessage(msg)
+	im.mtx.Unlock()
+	if im.secondary != nil {
+		err = im.secondary.SetMetadata(obj)
+	}
+	return
+}
+
+// SetListenerStatus publishes the accept health of the ingester's listeners in the ingester state,
+// the slice is copied so the caller may reuse it
+func (im *IngestMuxer) SetListenerStatus(st []ListenerStatus) {
+	var cp []ListenerStatus
+	if len(st) > 0 {
+		cp = append(make([]ListenerStatus, 0, len(st)), st...)
+	}
+	im.mtx.Lock()
+	im.ingesterState.Listeners = cp
+	im.mtx.Unlock()
+	if im.secondary != nil {
+		im.secondary.SetListenerStatus(st)
+	}
+}
+
+// stateSnapshot returns a copy of the current ingester state
+func (im *IngestMuxer) stateSnapshot() (s IngesterState) {
+	im.mtx.RLock()
+	s = im.ingesterState
+	im.mtx.RUnlock()
 	return
 }
 
@@ -618,6 +902,15 @@ func (im *IngestMuxer) NegotiateTag(name string) (tg entry.EntryTag, err error)
 	im.tagMap[name] = entry.EntryTag(tagNext + 1)
 
 	tg = im.tagMap[name]
+	if _, ok := im.tagPrio[name]; ok {
+		im.updatePriorities()
+	}
+	if _, ok := im.tagRates[name]; ok {
+		im.updateTagRates()
+	}
+	if err = im.updateRoutes(); err != nil {
+		return
+	}
 
 	// update the tag cache
 	if im.cachePath != "" {
@@ -657,7 +950,7 @@ func (im *IngestMuxer) SyncContext(ctx context.Context, to time.Duration) error
 	}
 	ts := time.Now()
 	im.mtx.Lock()
-	for len(im.eChanOut) > 0 || len(im.bChanOut) > 0 {
+	for len(im.eChanOut) > 0 || len(im.bChanOut) > 0 || len(im.hChanOut) > 0 || len(im.lChanOut) > 0 {
 		if err := ctx.Err(); err != nil {
 			im.mtx.Unlock()
 			return err
@@ -687,12 +980,18 @@ func (im *IngestMuxer) SyncContext(ctx context.Context, to time.Duration) error
 	if count == len(im.igst) {
 		return ErrAllConnsDown
 	}
+	if im.secondary != nil {
+		if err := im.secondary.SyncContext(ctx, to-time.Since(ts)); err != nil {
+			return fmt.Errorf("secondary destinations: %w", err)
+		}
+	}
 	return nil
 }
 
 // WaitForHot waits until at least one connection goes into the hot state
 // The timeout duration parameter is an optional timeout, if zero, it waits
-// indefinitely
+// indefinitely.  If the muxer has a cache and the timeout expires it starts
+// caching entries and returns nil, so ingesters can come up with all indexers down.
 func (im *IngestMuxer) WaitForHot(to time.Duration) error {
 	return im.WaitForHotContext(context.Background(), to)
 }
@@ -741,6 +1040,12 @@ mainLoop:
 				continue
 			}
 
+			//with a cache we can start offline, the tags resolve locally
+			//and entries spool to disk until an indexer comes up
+			if im.cacheEnabled {
+				im.startOffline()
+				return nil
+			}
 			return ErrConnectionTimeout
 		case err := <-im.errChan:
 			//lock the mutex and check if all our connections failed
@@ -756,6 +1061,23 @@ mainLoop:
 	return nil //someone came up
 }
 
+// startOffline fires up the caches when no indexers are reachable at startup,
+// they are stopped again when the first connection goes hot.
+func (im *IngestMuxer) startOffline() {
+	im.cache.CacheStart()
+	im.bcache.CacheStart()
+	im.hcache.CacheStart()
+	//a connection may have gone hot while we were starting the caches
+	if atomic.LoadInt32(&im.connHot) > 0 && !im.cacheAlways {
+		im.cache.CacheStop()
+		im.bcache.CacheStop()
+		im.hcache.CacheStop()
+		return
+	}
+	im.Warn("No indexers reachable, caching entries until a connection comes up",
+		log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid), log.KV("cachepath", im.cachePath))
+}
+
 // Hot returns how many connections are functioning
 func (im *IngestMuxer) Hot() (int, error) {
 	im.mtx.RLock()
@@ -766,7 +1088,7 @@ func (im *IngestMuxer) Hot() (int, error) {
 	return int(atomic.LoadInt32(&im.connHot)), nil
 }
 
-//goHot is a convenience function used by routines when they become active
+// goHot is a convenience function used by routines when they become active
 func (im *IngestMuxer) goHot() {
 	atomic.AddInt32(&im.connDead, -1)
 	//attempt a single on going hot, but don't block
@@ -775,6 +1097,7 @@ func (im *IngestMuxer) goHot() {
 		if !im.cacheAlways {
 			im.cache.CacheStop()
 			im.bcache.CacheStop()
+			im.hcache.CacheStop()
 		}
 	}
 	select {
@@ -783,13 +1106,14 @@ func (im *IngestMuxer) goHot() {
 	}
 }
 
-//goDead is a convenience function used by routines when they become dead
+// goDead is a convenience function used by routines when they become dead
 func (im *IngestMuxer) goDead() {
 	//decrement the hot counter
 	if atomic.AddInt32(&im.connHot, -1) == 0 {
 		if !im.cacheAlways {
 			im.cache.CacheStart()
 			im.bcache.CacheStart()
+			im.hcache.CacheStart()
 		}
 	}
 	atomic.AddInt32(&im.connDead, 1)
@@ -839,10 +1163,20 @@ func (im *IngestMuxer) WriteEntry(e *entry.Entry) error {
 	}
 	if im.state != running {
 		return ErrNotRunning
+	} else if err := im.waitTagRate(context.Background(), e); err != nil {
+		return err
 	}
-	im.eChan <- e
+	only, mirror := im.splitEntry(e)
+	if only != nil {
+		return im.secondary.WriteEntry(only)
+	}
+	if err := im.seqs.assign(e); err != nil {
+		return err
+	}
+	im.entryChan(im.tagPriority(e.Tag)) <- e
 	im.ingesterState.Entries++
 	im.ingesterState.Size += uint64(len(e.Data))
+	im.mirror(context.Background(), mirror, 0, false)
 	return nil
 }
 
@@ -858,14 +1192,26 @@ func (im *IngestMuxer) WriteEntryContext(ctx context.Context, e *entry.Entry) er
 	}
 	if im.state != running {
 		return ErrNotRunning
+	} else if err := im.waitTagRate(ctx, e); err != nil {
+		return err
+	}
+	only, mirror := im.splitEntry(e)
+	if only != nil {
+		return im.secondary.WriteEntryContext(ctx, only)
 	}
+	if err := im.seqs.assign(e); err != nil {
+		return err
+	}
+	im.traces.queued(ctx, e, 1)
 	select {
-	case im.eChan <- e:
+	case im.entryChan(im.tagPriority(e.Tag)) <- e:
 		im.ingesterState.Entries++
 		im.ingesterState.Size += uint64(len(e.Data))
 	case <-ctx.Done():
+		im.traces.dropped(e, ctx.Err())
 		return ctx.Err()
 	}
+	im.mirror(ctx, mirror, 0, false)
 	return nil
 }
 
@@ -881,12 +1227,22 @@ func (im *IngestMuxer) WriteEntryTimeout(e *entry.Entry, d time.Duration) (err e
 	}
 	if im.state != running {
 		return ErrNotRunning
+	} else if err = im.waitTagRateTimeout(e, d); err != nil {
+		return
+	}
+	only, mirror := im.splitEntry(e)
+	if only != nil {
+		return im.secondary.WriteEntryTimeout(only, d)
+	}
+	if err = im.seqs.assign(e); err != nil {
+		return
 	}
 	tmr := time.NewTimer(d)
 	select {
-	case im.eChan <- e:
+	case im.entryChan(im.tagPriority(e.Tag)) <- e:
 		im.ingesterState.Entries++
 		im.ingesterState.Size += uint64(len(e.Data))
+		im.mirror(context.Background(), mirror, 0, false)
 	case _ = <-tmr.C:
 		err = ErrWriteTimeout
 	}
@@ -897,64 +1253,41 @@ func (im *IngestMuxer) WriteEntryTimeout(e *entry.Entry, d time.Duration) (err e
 // available entry writer routine.  The entry writer routines will consume the
 // entire slice, so extremely large slices will go to a single indexer.
 func (im *IngestMuxer) WriteBatch(b []*entry.Entry) error {
-	if len(b) == 0 {
-		return nil
-	}
-	//scan the entries
-	for i := range b {
-		if b == nil {
-			return ErrInvalidEntry
-		} else if len(b[i].Data) > MAX_ENTRY_SIZE {
-			return ErrOversizedEntry
-		}
-	}
-	im.mtx.RLock()
-	runok := im.state == running
-	im.mtx.RUnlock()
-	if !runok {
-		return ErrNotRunning
-	}
-	im.bChan <- b
-	im.ingesterState.Entries += uint64(len(b))
-	for i := range b {
-		im.ingesterState.Size += uint64(len(b[i].Data))
-	}
-	return nil
+	return im.WriteBatchContext(context.Background(), b)
 }
 
 // WriteBatchContext puts a slice of entries into the queue to be sent out by the first
 // available entry writer routine.  The entry writer routines will consume the
 // entire slice, so extremely large slices will go to a single indexer.
-// if a cancellation context isn't needed, use WriteBatch
+// Batches containing tags with different priorities are split up by priority class.
 func (im *IngestMuxer) WriteBatchContext(ctx context.Context, b []*entry.Entry) error {
 	if len(b) == 0 {
 		return nil
+	} else if err := im.checkBatch(b); err != nil {
+		return err
+	} else if err = im.waitBatchRate(ctx, b); err != nil {
+		return err
 	}
-	//scan the entries
-	for i := range b {
-		if b == nil {
-			return ErrInvalidEntry
-		} else if len(b[i].Data) > MAX_ENTRY_SIZE {
-			return ErrOversizedEntry
-		}
+	var mb, sb []*entry.Entry
+	if im.secondary != nil {
+		mb, sb, b = im.secondaryBatch(b)
 	}
-
-	im.mtx.RLock()
-	runok := im.state == running
-	im.mtx.RUnlock()
-	if !runok {
-		return ErrNotRunning
+	if len(b) > 0 {
+		if err := im.seqs.assign(b...); err != nil {
+			return err
+		}
+		for _, pb := range im.prioritizeBatch(b) {
+			if err := im.writeBatch(ctx, pb.ents, pb.p); err != nil {
+				return err
+			}
+		}
 	}
-
-	select {
-	case im.bChan <- b:
-		im.ingesterState.Entries += uint64(len(b))
-		for i := range b {
-			im.ingesterState.Size += uint64(len(b[i].Data))
+	if len(sb) > 0 {
+		if err := im.secondary.WriteBatchContext(ctx, sb); err != nil {
+			return err
 		}
-	case <-ctx.Done():
-		return ctx.Err()
 	}
+	im.mirror(ctx, mb, 0, false)
 	return nil
 }
 
@@ -991,7 +1324,7 @@ func (im *IngestMuxer) WriteContext(ctx context.Context, tm entry.Timestamp, tag
 	return im.WriteEntryContext(ctx, e)
 }
 
-//connFailed will put the destination in a failed state and inform the muxer
+// connFailed will put the destination in a failed state and inform the muxer
 func (im *IngestMuxer) connFailed(dst string, err error) {
 	im.mtx.Lock()
 	defer im.mtx.Unlock()
@@ -1009,7 +1342,7 @@ type connSet struct {
 	src net.IP
 }
 
-//keep attempting to get a new connection set that we can actually write to
+// keep attempting to get a new connection set that we can actually write to
 func (im *IngestMuxer) getNewConnSet(csc chan connSet, connFailure chan bool, orig bool) (nc connSet, ok bool) {
 	if !orig {
 		//try to send, if we can't just roll on
@@ -1050,7 +1383,13 @@ func tickerInterval() time.Duration {
 
 func (im *IngestMuxer) shouldSched() bool {
 	//if pipelines are empty, schedule ourselves so that we can get a better distribution of entries
-	return len(im.igst) > 1 && im.cache.BufferSize() == 0 && im.bcache.BufferSize() == 0
+	return len(im.igst) > 1 && im.cache.BufferSize() == 0 && im.bcache.BufferSize() == 0 &&
+		im.hcache.BufferSize() == 0 && im.lcache.BufferSize() == 0
+}
+
+// cacheSize returns the total size of all the caches
+func (im *IngestMuxer) cacheSize() int {
+	return im.cache.Size() + im.bcache.Size() + im.hcache.Size() + im.lcache.Size()
 }
 
 func (im *IngestMuxer) writeRelayRoutine(csc chan connSet, connFailure chan bool) {
@@ -1059,7 +1398,6 @@ func (im *IngestMuxer) writeRelayRoutine(csc chan connSet, connFailure chan bool
 	defer close(connFailure)
 
 	//grab our first conn set
-	var tnc connSet
 	var nc connSet
 	var ok bool
 	var err error
@@ -1068,30 +1406,53 @@ func (im *IngestMuxer) writeRelayRoutine(csc chan connSet, connFailure chan bool
 		return
 	}
 
-	eC := im.eChanOut
-	bC := im.bChanOut
+	ri := relayInputs{
+		h: im.hChanOut,
+		e: im.eChanOut,
+		b: im.bChanOut,
+		l: im.lChanOut,
+	}
 
 inputLoop:
 	for {
-		select {
-		case _ = <-im.dieChan:
+		ev := ri.next(im.dieChan, csc, tmr.C)
+		switch ev.kind {
+		case relayDie:
 			nc.ig.Sync()
 			nc.ig.Close()
 			return
-		case ee, ok := <-eC:
-			if !ok {
-				eC = nil
-				if bC == nil {
-					return
+		case relayClosed:
+			if ri.closed() {
+				return
+			}
+			continue
+		case relayConn:
+			if !ev.ok {
+				nc.ig.Sync()
+				nc.ig.Close()
+				//attempt to sync with current ngst and then bail
+				break inputLoop
+			}
+			nc = ev.nc //just an update
+			continue
+		case relayTimer:
+			//periodically check the emergency queue and sync
+			if !im.eq.clear(nc.ig, nc.tt) || nc.ig.Sync() != nil {
+				if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
+					break inputLoop
 				}
-				continue
 			}
-			if ee == nil {
+			tmr.Reset(tickerInterval())
+			continue
+		}
+
+		switch v := ev.v.(type) {
+		case *entry.Entry:
+			e := v
+			if e == nil || im.seqs.delivered(e) {
 				continue
 			}
 
-			e := ee.(*entry.Entry)
-
 			ttag, ok = nc.tt.Translate(e.Tag)
 			if !ok {
 				// If the ingest muxer has no idea what this tag is, drop it and notify
@@ -1104,7 +1465,7 @@ inputLoop:
 					// We need to push this to the equeue and reconnect
 					// so we get the correct tag set.
 					// DO NOT reverse translate, muxer knows about the tag
-					im.recycleEntry(e)
+					im.recycleEntry(e, ev.p)
 					if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
 						break inputLoop
 					}
@@ -1118,12 +1479,13 @@ inputLoop:
 			}
 			if err = nc.ig.WriteEntry(e); err != nil {
 				e.Tag = nc.tt.Reverse(e.Tag)
-				im.recycleEntry(e)
+				im.recycleEntry(e, ev.p)
 				if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
 					break inputLoop
 				}
 				continue inputLoop
 			}
+			im.traces.sent(e, nc.dst)
 			//hack to get better distribution across connections in an muxer
 			if im.shouldSched() {
 				if !tmr.Stop() {
@@ -1137,19 +1499,11 @@ inputLoop:
 				tmr.Reset(tickerInterval())
 				runtime.Gosched()
 			}
-		case bb, ok := <-bC:
-			if !ok {
-				bC = nil
-				if eC == nil {
-					return
-				}
+		case []*entry.Entry:
+			b := im.seqs.filter(v)
+			if len(b) == 0 {
 				continue
 			}
-			if bb == nil {
-				continue
-			}
-
-			b := bb.([]*entry.Entry)
 			for i := range b {
 				if b[i] != nil {
 					ttag, ok = nc.tt.Translate(b[i].Tag)
@@ -1160,7 +1514,7 @@ inputLoop:
 							for j := 0; j < i; j++ {
 								b[j].Tag = nc.tt.Reverse(b[j].Tag)
 							}
-							im.recycleEntryBatch(b[:i]) //recycle and save what we can
+							im.recycleEntryBatch(b[:i], ev.p) //recycle and save what we can
 						} else {
 							im.Info("Got entry with new tag, need to renegotiate connection", log.KV("tag", name), log.KV("tagvalue", b[i].Tag), log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
 							// Could not translate! We need to push this to the equeue and reconnect
@@ -1170,7 +1524,7 @@ inputLoop:
 							for j := 0; j < i; j++ {
 								b[j].Tag = nc.tt.Reverse(b[j].Tag)
 							}
-							im.recycleEntryBatch(b)
+							im.recycleEntryBatch(b, ev.p)
 							if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
 								break inputLoop
 							}
@@ -1189,10 +1543,12 @@ inputLoop:
 				for i := n; i < len(b); i++ {
 					b[i].Tag = nc.tt.Reverse(b[i].Tag)
 				}
-				im.recycleEntryBatch(b[n:])
+				im.recycleEntryBatch(b[n:], ev.p)
 				if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
 					break inputLoop
 				}
+			} else {
+				im.traces.sent(b[len(b)-1], nc.dst)
 			}
 			//hack to get better distribution across connections in an muxer
 			if im.shouldSched() {
@@ -1207,27 +1563,11 @@ inputLoop:
 				tmr.Reset(tickerInterval())
 				runtime.Gosched()
 			}
-		case tnc, ok = <-csc: //in case we get an unexpected new connection
-			if !ok {
-				nc.ig.Sync()
-				nc.ig.Close()
-				//attempt to sync with current ngst and then bail
-				break inputLoop
-			}
-			nc = tnc //just an update
-		case <-tmr.C:
-			//periodically check the emergency queue and sync
-			if !im.eq.clear(nc.ig, nc.tt) || nc.ig.Sync() != nil {
-				if nc, ok = im.getNewConnSet(csc, connFailure, false); !ok {
-					break inputLoop
-				}
-			}
-			tmr.Reset(tickerInterval())
 		}
 	}
 }
 
-//the routine that manages
+// the routine that manages
 func (im *IngestMuxer) connRoutine(igIdx int) {
 	var src net.IP
 	defer im.wg.Done()
@@ -1244,7 +1584,7 @@ func (im *IngestMuxer) connRoutine(igIdx int) {
 	}
 
 	var igst *IngestConnection
-	var tt tagTrans
+	var tt *tagTrans
 	var err error
 	connErrNotif := make(chan bool, 1)
 	ncc := make(chan connSet, 1)
@@ -1282,7 +1622,10 @@ func (im *IngestMuxer) connRoutine(igIdx int) {
 						ents[i].Tag = tt.Reverse(ents[i].Tag)
 					}
 				}
-				im.recycleEntryBatch(ents)
+				//the priority an entry was written with is lost once it is on the wire, fall back to the tag priority
+				for _, pb := range im.prioritizeBatch(ents) {
+					im.recycleEntryBatch(pb.ents, pb.p)
+				}
 			}
 
 			//attempt to get the connection rolling again
@@ -1306,7 +1649,7 @@ func (im *IngestMuxer) connRoutine(igIdx int) {
 
 			im.mtx.Lock()
 			im.igst[igIdx] = igst
-			im.tagTranslators[igIdx] = &tt
+			im.tagTranslators[igIdx] = tt
 			im.mtx.Unlock()
 
 			im.goHot()
@@ -1314,13 +1657,13 @@ func (im *IngestMuxer) connRoutine(igIdx int) {
 				dst: dst.Address,
 				src: src,
 				ig:  igst,
-				tt:  &tt,
+				tt:  tt,
 			}
 		}
 	}
 }
 
-func (im *IngestMuxer) recycleEntryBatch(ents []*entry.Entry) {
+func (im *IngestMuxer) recycleEntryBatch(ents []*entry.Entry, p Priority) {
 	if len(ents) == 0 {
 		return
 	}
@@ -1336,12 +1679,12 @@ func (im *IngestMuxer) recycleEntryBatch(ents []*entry.Entry) {
 		if err := im.eq.push(nil, ents); err != nil {
 			//FIXME - throw a fit about this
 		}
-	case im.bChan <- ents:
+	case im.batchChan(p) <- ents:
 	}
 	return
 }
 
-func (im *IngestMuxer) recycleEntry(ent *entry.Entry) {
+func (im *IngestMuxer) recycleEntry(ent *entry.Entry, p Priority) {
 	if ent == nil {
 		return
 	}
@@ -1357,13 +1700,13 @@ func (im *IngestMuxer) recycleEntry(ent *entry.Entry) {
 		if err := im.eq.push(ent, nil); err != nil {
 			//FIXME - throw a fit about this
 		}
-	case im.eChan <- ent:
+	case im.entryChan(p) <- ent:
 	}
 	return
 }
 
-//fatal connection errors is looking for errors which are non-recoverable
-//Recoverable errors are related to timeouts, refused connections, and read errors
+// fatal connection errors is looking for errors which are non-recoverable
+// Recoverable errors are related to timeouts, refused connections, and read errors
 func isFatalConnError(err error) bool {
 	if err == nil {
 		return false
@@ -1377,6 +1720,8 @@ func isFatalConnError(err error) bool {
 		fallthrough
 	case ErrTenantAuthUnsupported:
 		fallthrough
+	case ErrKerberosAuthUnsupported:
+		fallthrough
 	case ErrForbiddenTag:
 		fallthrough
 	case ErrFailedParseLocalIP:
@@ -1387,13 +1732,18 @@ func isFatalConnError(err error) bool {
 	return false
 }
 
-func (im *IngestMuxer) getConnection(tgt Target) (ig *IngestConnection, tt tagTrans, err error) {
+// tlsOptions collects the TLS settings for connections to a destination
+func (im *IngestMuxer) tlsOptions(addr string) tlsOptions {
+	return tlsOptions{dest: im.destTLS[addr], id: im.identity}
+}
+
+func (im *IngestMuxer) getConnection(tgt Target) (ig *IngestConnection, tt *tagTrans, err error) {
 loop:
 	for {
 		//attempt a connection, timeouts are built in to the IngestConnection
 		im.Info("initializing connection", log.KV("indexer", tgt.Address), log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
 		im.mtx.RLock()
-		if ig, err = initConnection(tgt, im.tags, im.pubKey, im.privKey, im.verifyCert); err != nil {
+		if ig, err = initConnection(tgt, im.tags, im.pubKey, im.privKey, im.verifyCert, im.tlsOptions(tgt.Address), im.krb); err != nil {
 			im.mtx.RUnlock()
 			if isFatalConnError(err) {
 				im.Error("fatal connection error", log.KV("indexer", tgt.Address), log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid), log.KV("error", err))
@@ -1410,8 +1760,21 @@ loop:
 			continue
 		}
 		im.Info("connection established, completing negotiation and requesting approval to ingest", log.KV("indexer", tgt.Address), log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid))
-		if im.rateParent != nil {
-			ig.ew.setConn(im.rateParent.newThrottleConn(ig.ew.conn))
+		if tc := newParentsThrottleConn(ig.ew.conn, im.rateParent, im.destRates[tgt.Address]); tc != nil {
+			ig.ew.setConn(tc)
+		}
+		ig.ew.setChecksumCounter(&im.checksumErrors)
+		if im.adaptive != nil {
+			ig.ew.setRateFeedback(im.adaptive)
+		}
+		if im.seqs != nil {
+			ig.ew.setSeqTracker(im.seqs)
+		}
+		if im.latency != nil {
+			ig.ew.setLatencyMarkers(im.latency)
+		}
+		if im.traces != nil {
+			ig.ew.setEntryTraces(im.traces)
 		}
 
 		//no error, attempt to do a tag translation
@@ -1457,28 +1820,48 @@ loop:
 			continue
 		}
 
+		if err := ig.SendTagHints(im.tagHints); err != nil {
+			im.Warn("failed to send tag hints", log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid), log.KV("indexer", tgt.Address), log.KV("error", err))
+			ig.Close()
+			continue
+		}
+
 		im.Info("successfully connected with ingest OK", log.KV("ingester", im.name), log.KV("ingesteruuid", im.uuid), log.KV("indexer", tgt.Address))
 		break
 	}
 	return
 }
 
-func (im *IngestMuxer) newTagTrans(igst *IngestConnection) (tagTrans, error) {
-	tt := tagTrans(make([]entry.EntryTag, len(im.tagMap)))
-	if len(tt) == 0 {
+func (im *IngestMuxer) newTagTrans(igst *IngestConnection) (*tagTrans, error) {
+	tbl := make([]entry.EntryTag, len(im.tagMap))
+	if len(tbl) == 0 {
 		return nil, ErrTagMapInvalid
 	}
 	for k, v := range im.tagMap {
-		if int(v) > len(tt) {
+		if int(v) >= len(tbl) {
 			return nil, ErrTagMapInvalid
 		}
 		tg, ok := igst.GetTag(k)
 		if !ok {
 			return nil, ErrTagNotFound
 		}
-		tt[v] = tg
+		tbl[v] = tg
 	}
-	return tt, nil
+	return newTagTransTable(tbl), nil
+}
+
+// QueueDepth returns how many entries and batches are waiting for a connection and how many the
+// queues can hold.  A full queue means writers are blocking on the indexers.
+func (im *IngestMuxer) QueueDepth() (queued, capacity int) {
+	queued = len(im.eChanOut) + len(im.bChanOut) + len(im.hChanOut) + len(im.lChanOut)
+	capacity = cap(im.eChanOut) + cap(im.bChanOut) + cap(im.hChanOut) + cap(im.lChanOut)
+	return
+}
+
+// ChecksumErrors returns the number of entries that indexers reported as corrupted in transit.
+// Corrupted entries are resent, so a climbing count points at bad hardware in the path, not data loss.
+func (im *IngestMuxer) ChecksumErrors() uint64 {
+	return atomic.LoadUint64(&im.checksumErrors)
 }
 
 // SourceIP is a convenience function used to pull back a source value
@@ -1654,41 +2037,62 @@ func (eq *emergencyQueue) clear(igst *IngestConnection, tt *tagTrans) (ok bool)
 	return
 }
 
-type tagTrans []entry.EntryTag
+// tagTrans maps local tag values to the values negotiated on a connection.  Tags negotiated while
+// the connection is in use are registered from outside the relay routine, so the table is copied
+// and swapped rather than appended to in place.
+type tagTrans struct {
+	tbl atomic.Value // []entry.EntryTag
+}
+
+func newTagTransTable(tbl []entry.EntryTag) *tagTrans {
+	tt := &tagTrans{}
+	tt.tbl.Store(tbl)
+	return tt
+}
+
+func (tt *tagTrans) table() []entry.EntryTag {
+	return tt.tbl.Load().([]entry.EntryTag)
+}
 
 // Translate translates a local tag to a remote tag.  Senders should not use this function
-func (tt tagTrans) Translate(t entry.EntryTag) (entry.EntryTag, bool) {
+func (tt *tagTrans) Translate(t entry.EntryTag) (entry.EntryTag, bool) {
 	//check if this is the gravwell and if soo, pass it on through
 	if t == entry.GravwellTagId {
 		return t, true
 	}
+	tbl := tt.table()
 	//if this is a tag we have not negotiated, set it to the first one we have
 	//we are assuming that its an error, but we still want the entry
-	if int(t) >= len(tt) {
-		return tt[0], false
+	if int(t) >= len(tbl) {
+		return tbl[0], false
 	}
-	return tt[t], true
+	return tbl[t], true
 }
 
+// RegisterTag adds a newly negotiated tag, callers must hold the muxer lock
 func (tt *tagTrans) RegisterTag(local entry.EntryTag, remote entry.EntryTag) error {
-	if int(local) != len(*tt) {
+	tbl := tt.table()
+	if int(local) != len(tbl) {
 		// this means the local tag numbers got out of sync and something is bad
 		return errors.New("Cannot register tag, local tag out of sync with tag translator")
 	}
-	*tt = append(*tt, remote)
+	ntbl := make([]entry.EntryTag, len(tbl), len(tbl)+1)
+	copy(ntbl, tbl)
+	tt.tbl.Store(append(ntbl, remote))
 	return nil
 }
 
 // Reverse translates a remote tag back to a local tag
 // this is ONLY used when a connection dies while holding unconfirmed entries
 // this operation is stupid expensive, so... be gracious
-func (tt tagTrans) Reverse(t entry.EntryTag) entry.EntryTag {
+func (tt *tagTrans) Reverse(t entry.EntryTag) entry.EntryTag {
 	//check if this is gravwell and if soo, pass it on through
 	if t == entry.GravwellTagId {
 		return t
 	}
-	for i := range tt {
-		if tt[i] == t {
+	tbl := tt.table()
+	for i := range tbl {
+		if tbl[i] == t {
 			return entry.EntryTag(i)
 		}
 	}
@@ -1699,5 +2103,6 @@ func getStreamConfig(cfg config.IngestStreamConfig) (sc StreamConfiguration) {
 	if cfg.Enable_Compression {
 		sc.Compression = CompressSnappy
 	}
+	sc.Checksum = cfg.Enable_Checksums
 	return
 }
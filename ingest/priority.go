@@ -0,0 +1,386 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+// Priority is the send class of an entry.  When the muxer is backed up it sends high priority
+// entries first, then normal, then low.  Low priority entries are spilled to the cache (when
+// one is configured) rather than blocking writers.
+type Priority int
+
+const (
+	PriorityLow    Priority = -1
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1
+)
+
+var (
+	ErrInvalidPriority = errors.New("Invalid entry priority")
+)
+
+// ParsePriority converts a priority name (high, normal, low) to a Priority
+func ParsePriority(v string) (p Priority, err error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case config.PriorityHigh:
+		p = PriorityHigh
+	case config.PriorityNormal, ``:
+		p = PriorityNormal
+	case config.PriorityLow:
+		p = PriorityLow
+	default:
+		err = fmt.Errorf("%w %q", ErrInvalidPriority, v)
+	}
+	return
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return config.PriorityHigh
+	case PriorityNormal:
+		return config.PriorityNormal
+	case PriorityLow:
+		return config.PriorityLow
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// Valid returns true if the priority is one of the known classes
+func (p Priority) Valid() bool {
+	return p >= PriorityLow && p <= PriorityHigh
+}
+
+type relayEventKind int
+
+const (
+	relayItem relayEventKind = iota
+	relayClosed
+	relayDie
+	relayConn
+	relayTimer
+)
+
+// relayEvent is the next thing a relay routine has to deal with, either a queued
+// entry or batch, a closed queue, or one of its control channels
+type relayEvent struct {
+	kind relayEventKind
+	v    interface{} // *entry.Entry or []*entry.Entry
+	p    Priority    // the class of the queue v came from
+	nc   connSet
+	ok   bool
+}
+
+// relayInputs are the queues feeding a relay routine, queues are set to nil as they close
+type relayInputs struct {
+	h chan interface{} // high priority entries and batches
+	e chan interface{} // normal entries
+	b chan interface{} // normal batches
+	l chan interface{} // low priority entries and batches
+}
+
+func (ri *relayInputs) closed() bool {
+	return ri.h == nil && ri.e == nil && ri.b == nil && ri.l == nil
+}
+
+// next waits for a relay event.  Queued items are pulled in priority order: high priority
+// items are always taken first and low priority items only when nothing else is waiting.
+// The control channels are checked at every step so that a steady stream of high
+// priority entries can't starve them.
+func (ri *relayInputs) next(die chan bool, csc chan connSet, tc <-chan time.Time) (ev relayEvent) {
+	var v interface{}
+	var ok bool
+	select {
+	case <-die:
+		ev.kind = relayDie
+		return
+	case ev.nc, ev.ok = <-csc:
+		ev.kind = relayConn
+		return
+	case <-tc:
+		ev.kind = relayTimer
+		return
+	case v, ok = <-ri.h:
+		return ri.item(&ri.h, PriorityHigh, v, ok)
+	default:
+	}
+
+	select {
+	case <-die:
+		ev.kind = relayDie
+		return
+	case ev.nc, ev.ok = <-csc:
+		ev.kind = relayConn
+		return
+	case <-tc:
+		ev.kind = relayTimer
+		return
+	case v, ok = <-ri.h:
+		return ri.item(&ri.h, PriorityHigh, v, ok)
+	case v, ok = <-ri.e:
+		return ri.item(&ri.e, PriorityNormal, v, ok)
+	case v, ok = <-ri.b:
+		return ri.item(&ri.b, PriorityNormal, v, ok)
+	default:
+	}
+
+	select {
+	case <-die:
+		ev.kind = relayDie
+	case ev.nc, ev.ok = <-csc:
+		ev.kind = relayConn
+	case <-tc:
+		ev.kind = relayTimer
+	case v, ok = <-ri.h:
+		ev = ri.item(&ri.h, PriorityHigh, v, ok)
+	case v, ok = <-ri.e:
+		ev = ri.item(&ri.e, PriorityNormal, v, ok)
+	case v, ok = <-ri.b:
+		ev = ri.item(&ri.b, PriorityNormal, v, ok)
+	case v, ok = <-ri.l:
+		ev = ri.item(&ri.l, PriorityLow, v, ok)
+	}
+	return
+}
+
+func (ri *relayInputs) item(c *chan interface{}, p Priority, v interface{}, ok bool) relayEvent {
+	if !ok {
+		*c = nil
+		return relayEvent{kind: relayClosed}
+	}
+	return relayEvent{kind: relayItem, v: v, p: p}
+}
+
+// prioBatch is the set of entries from a batch that share a priority class
+type prioBatch struct {
+	p    Priority
+	ents []*entry.Entry
+}
+
+// SetTagPriority assigns a priority class to every entry written with the named tag through
+// WriteEntry, WriteBatch, and friends.  The tag does not need to be negotiated yet.
+func (im *IngestMuxer) SetTagPriority(name string, p Priority) error {
+	if err := CheckTag(name); err != nil {
+		return err
+	} else if !p.Valid() {
+		return ErrInvalidPriority
+	}
+	im.mtx.Lock()
+	if p == PriorityNormal {
+		delete(im.tagPrio, name)
+	} else {
+		im.tagPrio[name] = p
+	}
+	im.updatePriorities()
+	im.mtx.Unlock()
+	if im.secondary != nil {
+		return im.secondary.SetTagPriority(name, p)
+	}
+	return nil
+}
+
+// updatePriorities rebuilds the tag value to priority map used on the write path
+// the caller must hold the write lock
+func (im *IngestMuxer) updatePriorities() {
+	pm := make(map[entry.EntryTag]Priority, len(im.tagPrio))
+	for name, p := range im.tagPrio {
+		if tg, ok := im.tagMap[name]; ok {
+			pm[tg] = p
+		}
+	}
+	im.prioMap.Store(pm)
+}
+
+// tagPriority returns the priority class assigned to a tag, untagged entries are normal
+func (im *IngestMuxer) tagPriority(tg entry.EntryTag) Priority {
+	if pm, ok := im.prioMap.Load().(map[entry.EntryTag]Priority); ok && len(pm) > 0 {
+		return pm[tg]
+	}
+	return PriorityNormal
+}
+
+// prioritizeBatch splits a batch up by tag priority, highest class first.  Batches that
+// are all one class are handed back without a copy.
+func (im *IngestMuxer) prioritizeBatch(b []*entry.Entry) []prioBatch {
+	pm, _ := im.prioMap.Load().(map[entry.EntryTag]Priority)
+	if len(pm) == 0 {
+		return []prioBatch{{p: PriorityNormal, ents: b}}
+	}
+	var sets [3][]*entry.Entry
+	var classes int
+	for _, e := range b {
+		idx := PriorityHigh - PriorityNormal
+		if e != nil {
+			idx = PriorityHigh - pm[e.Tag]
+		}
+		if len(sets[idx]) == 0 {
+			classes++
+		}
+		sets[idx] = append(sets[idx], e)
+	}
+	ret := make([]prioBatch, 0, classes)
+	for i := range sets {
+		if len(sets[i]) > 0 {
+			if classes == 1 {
+				sets[i] = b
+			}
+			ret = append(ret, prioBatch{p: PriorityHigh - Priority(i), ents: sets[i]})
+		}
+	}
+	return ret
+}
+
+func (im *IngestMuxer) entryChan(p Priority) chan interface{} {
+	switch p {
+	case PriorityHigh:
+		return im.hChan
+	case PriorityLow:
+		return im.lChan
+	}
+	return im.eChan
+}
+
+func (im *IngestMuxer) batchChan(p Priority) chan interface{} {
+	switch p {
+	case PriorityHigh:
+		return im.hChan
+	case PriorityLow:
+		return im.lChan
+	}
+	return im.bChan
+}
+
+// WriteEntryPriority queues an entry with an explicit priority class, ignoring any tag priority.
+func (im *IngestMuxer) WriteEntryPriority(e *entry.Entry, p Priority) error {
+	if e == nil {
+		return nil
+	} else if len(e.Data) > MAX_ENTRY_SIZE {
+		return ErrOversizedEntry
+	} else if !p.Valid() {
+		return ErrInvalidPriority
+	}
+	if im.state != running {
+		return ErrNotRunning
+	}
+	only, mirror := im.splitEntry(e)
+	if only != nil {
+		return im.secondary.WriteEntryPriority(only, p)
+	}
+	im.entryChan(p) <- e
+	im.ingesterState.Entries++
+	im.ingesterState.Size += uint64(len(e.Data))
+	im.mirror(context.Background(), mirror, p, true)
+	return nil
+}
+
+// WriteEntryPriorityContext queues an entry with an explicit priority class, ignoring any
+// tag priority.  If a cancellation context isn't needed, use WriteEntryPriority.
+func (im *IngestMuxer) WriteEntryPriorityContext(ctx context.Context, e *entry.Entry, p Priority) error {
+	if e == nil {
+		return nil
+	} else if len(e.Data) > MAX_ENTRY_SIZE {
+		return ErrOversizedEntry
+	} else if !p.Valid() {
+		return ErrInvalidPriority
+	}
+	if im.state != running {
+		return ErrNotRunning
+	}
+	only, mirror := im.splitEntry(e)
+	if only != nil {
+		return im.secondary.WriteEntryPriorityContext(ctx, only, p)
+	}
+	select {
+	case im.entryChan(p) <- e:
+		im.ingesterState.Entries++
+		im.ingesterState.Size += uint64(len(e.Data))
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	im.mirror(ctx, mirror, p, true)
+	return nil
+}
+
+// WriteBatchPriority queues a batch with an explicit priority class for every entry,
+// ignoring any tag priority.
+func (im *IngestMuxer) WriteBatchPriority(b []*entry.Entry, p Priority) error {
+	return im.WriteBatchPriorityContext(context.Background(), b, p)
+}
+
+// WriteBatchPriorityContext queues a batch with an explicit priority class for every entry,
+// ignoring any tag priority.
+func (im *IngestMuxer) WriteBatchPriorityContext(ctx context.Context, b []*entry.Entry, p Priority) error {
+	if !p.Valid() {
+		return ErrInvalidPriority
+	} else if err := im.checkBatch(b); err != nil || len(b) == 0 {
+		return err
+	}
+	var mb, sb []*entry.Entry
+	if im.secondary != nil {
+		mb, sb, b = im.secondaryBatch(b)
+	}
+	if len(b) > 0 {
+		if err := im.writeBatch(ctx, b, p); err != nil {
+			return err
+		}
+	}
+	if len(sb) > 0 {
+		if err := im.secondary.WriteBatchPriorityContext(ctx, sb, p); err != nil {
+			return err
+		}
+	}
+	im.mirror(ctx, mb, p, true)
+	return nil
+}
+
+// checkBatch validates a batch before it is queued
+func (im *IngestMuxer) checkBatch(b []*entry.Entry) error {
+	for i := range b {
+		if b[i] == nil {
+			return ErrInvalidEntry
+		} else if len(b[i].Data) > MAX_ENTRY_SIZE {
+			return ErrOversizedEntry
+		}
+	}
+	im.mtx.RLock()
+	runok := im.state == running
+	im.mtx.RUnlock()
+	if !runok {
+		return ErrNotRunning
+	}
+	return nil
+}
+
+func (im *IngestMuxer) writeBatch(ctx context.Context, b []*entry.Entry, p Priority) error {
+	if len(b) > 0 {
+		im.traces.queued(ctx, b[len(b)-1], len(b))
+	}
+	select {
+	case im.batchChan(p) <- b:
+		im.ingesterState.Entries += uint64(len(b))
+		for i := range b {
+			im.ingesterState.Size += uint64(len(b[i].Data))
+		}
+	case <-ctx.Done():
+		if len(b) > 0 {
+			im.traces.dropped(b[len(b)-1], ctx.Err())
+		}
+		return ctx.Err()
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestParsePriority(t *testing.T) {
+	good := map[string]Priority{
+		`high`:   PriorityHigh,
+		` HIGH `: PriorityHigh,
+		`normal`: PriorityNormal,
+		``:       PriorityNormal,
+		`low`:    PriorityLow,
+	}
+	for v, exp := range good {
+		if p, err := ParsePriority(v); err != nil {
+			t.Fatalf("failed to parse %q: %v", v, err)
+		} else if p != exp {
+			t.Fatalf("bad parse of %q: %v != %v", v, p, exp)
+		}
+	}
+	if _, err := ParsePriority(`urgent`); err == nil {
+		t.Fatal("failed to catch bad priority")
+	}
+	if Priority(2).Valid() || Priority(-2).Valid() {
+		t.Fatal("invalid priorities reported valid")
+	}
+}
+
+func TestPrioritizeBatch(t *testing.T) {
+	im := &IngestMuxer{
+		mtx:     &sync.RWMutex{},
+		tagMap:  map[string]entry.EntryTag{`auth`: 1, `flows`: 2, `syslog`: 3},
+		tagPrio: map[string]Priority{},
+	}
+	im.updatePriorities()
+	b := []*entry.Entry{{Tag: 1}, {Tag: 2}, {Tag: 3}}
+	if sets := im.prioritizeBatch(b); len(sets) != 1 || sets[0].p != PriorityNormal || len(sets[0].ents) != 3 {
+		t.Fatalf("bad unprioritized batch: %+v", sets)
+	}
+
+	//priorities can be set before a tag is negotiated
+	if err := im.SetTagPriority(`auth`, PriorityHigh); err != nil {
+		t.Fatal(err)
+	} else if err = im.SetTagPriority(`flows`, PriorityLow); err != nil {
+		t.Fatal(err)
+	} else if err = im.SetTagPriority(`dns`, PriorityHigh); err != nil {
+		t.Fatal(err)
+	} else if err = im.SetTagPriority(`auth`, Priority(5)); err == nil {
+		t.Fatal("failed to catch bad priority")
+	}
+	if im.tagPriority(1) != PriorityHigh || im.tagPriority(2) != PriorityLow || im.tagPriority(3) != PriorityNormal {
+		t.Fatal("bad tag priorities")
+	}
+
+	b = append(b, &entry.Entry{Tag: 1})
+	sets := im.prioritizeBatch(b)
+	if len(sets) != 3 {
+		t.Fatalf("bad batch split: %+v", sets)
+	} else if sets[0].p != PriorityHigh || len(sets[0].ents) != 2 {
+		t.Fatalf("bad high set: %+v", sets[0])
+	} else if sets[1].p != PriorityNormal || len(sets[1].ents) != 1 || sets[1].ents[0].Tag != 3 {
+		t.Fatalf("bad normal set: %+v", sets[1])
+	} else if sets[2].p != PriorityLow || len(sets[2].ents) != 1 || sets[2].ents[0].Tag != 2 {
+		t.Fatalf("bad low set: %+v", sets[2])
+	}
+
+	//setting normal clears the priority
+	if err := im.SetTagPriority(`auth`, PriorityNormal); err != nil {
+		t.Fatal(err)
+	} else if im.tagPriority(1) != PriorityNormal {
+		t.Fatal("failed to clear priority")
+	}
+}
+
+func TestRelayInputsOrder(t *testing.T) {
+	ri := relayInputs{
+		h: make(chan interface{}, 4),
+		e: make(chan interface{}, 4),
+		b: make(chan interface{}, 4),
+		l: make(chan interface{}, 4),
+	}
+	die := make(chan bool)
+	csc := make(chan connSet)
+	tc := make(chan time.Time)
+
+	ri.l <- &entry.Entry{Tag: 4}
+	ri.b <- []*entry.Entry{{Tag: 3}}
+	ri.e <- &entry.Entry{Tag: 2}
+	ri.h <- &entry.Entry{Tag: 1}
+	ri.h <- []*entry.Entry{{Tag: 1}}
+
+	for i := 0; i < 2; i++ {
+		if ev := ri.next(die, csc, tc); ev.kind != relayItem || ev.p != PriorityHigh {
+			t.Fatalf("expected a high priority item, got %+v", ev)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if ev := ri.next(die, csc, tc); ev.kind != relayItem || ev.p != PriorityNormal {
+			t.Fatalf("expected a normal priority item, got %+v", ev)
+		}
+	}
+	if ev := ri.next(die, csc, tc); ev.kind != relayItem || ev.p != PriorityLow {
+		t.Fatalf("expected a low priority item, got %+v", ev)
+	}
+
+	//control events are not starved by queued entries
+	ri.h <- &entry.Entry{}
+	close(die)
+	var sawDie bool
+	for i := 0; i < 64 && !sawDie; i++ {
+		sawDie = ri.next(die, csc, tc).kind == relayDie
+		if len(ri.h) == 0 {
+			ri.h <- &entry.Entry{}
+		}
+	}
+	if !sawDie {
+		t.Fatal("die channel was starved")
+	}
+
+	close(ri.h)
+	close(ri.e)
+	close(ri.b)
+	close(ri.l)
+	for i := 0; i < 4; i++ {
+		if ev := ri.next(nil, csc, tc); ev.kind == relayItem {
+			i--
+		} else if ev.kind != relayClosed {
+			t.Fatalf("expected a closed event, got %+v", ev)
+		}
+	}
+	if !ri.closed() {
+		t.Fatal("inputs not closed")
+	}
+}
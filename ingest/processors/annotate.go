@@ -0,0 +1,142 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	AnnotateAuto   string = `auto`   // JSON objects get fields, everything else gets a prefix
+	AnnotateJSON   string = `json`   // only annotate JSON objects
+	AnnotatePrefix string = `prefix` // always prepend key=value pairs
+)
+
+var (
+	ErrInvalidAnnotationMode = errors.New("Invalid annotation mode, must be auto, json, or prefix")
+	ErrEmptyAnnotationName   = errors.New("Annotation name cannot be empty")
+)
+
+// Annotation is a single named value that is attached to an entry.
+type Annotation struct {
+	Name    string
+	Value   string
+	Literal bool // Value is a JSON number or boolean and is injected without quotes
+}
+
+// Annotator attaches derived values to entries.  Entries do not carry a side
+// channel for metadata, so annotations are written into the entry data.
+// JSON objects get the values injected as fields (optionally nested under Field),
+// other data gets a space delimited set of key=value pairs prepended.
+type Annotator struct {
+	Mode  string
+	Field string
+}
+
+// NewAnnotator validates the mode and returns an Annotator, an empty mode is auto.
+func NewAnnotator(mode, field string) (a Annotator, err error) {
+	if a.Mode, err = parseAnnotationMode(mode); err == nil {
+		a.Field = strings.TrimSpace(field)
+	}
+	return
+}
+
+func parseAnnotationMode(v string) (mode string, err error) {
+	switch mode = strings.TrimSpace(strings.ToLower(v)); mode {
+	case ``:
+		mode = AnnotateAuto
+	case AnnotateAuto:
+	case AnnotateJSON:
+	case AnnotatePrefix:
+	default:
+		err = ErrInvalidAnnotationMode
+	}
+	return
+}
+
+// Annotate attaches the set of annotations to the entry, returning true if the entry was modified.
+func (a Annotator) Annotate(ent *entry.Entry, anns ...Annotation) (ok bool, err error) {
+	if ent == nil || len(anns) == 0 {
+		return
+	}
+	for _, an := range anns {
+		if an.Name == `` {
+			err = ErrEmptyAnnotationName
+			return
+		}
+	}
+	switch a.Mode {
+	case AnnotatePrefix:
+		ent.Data = prefixAnnotations(ent.Data, anns)
+		ok = true
+	case AnnotateJSON:
+		if isJSONObject(ent.Data) {
+			ok, err = a.injectAnnotations(ent, anns)
+		}
+	default: //auto and empty
+		if isJSONObject(ent.Data) {
+			ok, err = a.injectAnnotations(ent, anns)
+		} else {
+			ent.Data = prefixAnnotations(ent.Data, anns)
+			ok = true
+		}
+	}
+	return
+}
+
+func (a Annotator) injectAnnotations(ent *entry.Entry, anns []Annotation) (ok bool, err error) {
+	data := ent.Data
+	for _, an := range anns {
+		var val []byte
+		if an.Literal {
+			val = []byte(an.Value)
+		} else if val, err = json.Marshal(an.Value); err != nil {
+			return
+		}
+		if a.Field != `` {
+			data, err = jsonparser.Set(data, val, a.Field, an.Name)
+		} else {
+			data, err = jsonparser.Set(data, val, an.Name)
+		}
+		if err != nil {
+			return
+		}
+	}
+	ent.Data = data
+	ok = true
+	return
+}
+
+func prefixAnnotations(data []byte, anns []Annotation) []byte {
+	bb := bytes.NewBuffer(make([]byte, 0, len(data)+32*len(anns)))
+	for _, an := range anns {
+		bb.WriteString(an.Name)
+		bb.WriteByte('=')
+		if strings.ContainsAny(an.Value, " \t\"=") || an.Value == `` {
+			bb.WriteString(strconv.Quote(an.Value))
+		} else {
+			bb.WriteString(an.Value)
+		}
+		bb.WriteByte(' ')
+	}
+	bb.Write(data)
+	return bb.Bytes()
+}
+
+func isJSONObject(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) >= 2 && data[0] == '{' && data[len(data)-1] == '}'
+}
@@ -0,0 +1,476 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	ArchiveProcessor = `archive`
+
+	ArchiveFormatNDJSON   = `ndjson`
+	ArchiveFormatNative   = `native`
+	ArchivePartitionHour  = `hour`
+	ArchivePartitionDay   = `day`
+	ArchiveCompressGzip   = `gzip`
+	ArchiveCompressNone   = `none`
+	ArchiveFilePrefix     = `archive-`
+	archiveGzipExt        = `.gz`
+	archiveFlushInterval  = time.Second
+	archiveHourLayout     = `20060102T15`
+	archiveDayLayout      = `20060102`
+	maxArchiveTagNameSize = 0xffff
+)
+
+var (
+	ErrMissingArchivePath     = errors.New("Archive-Path is required")
+	ErrInvalidArchiveFormat   = errors.New("Format must be ndjson or native")
+	ErrInvalidArchivePart     = errors.New("Partition must be hour or day")
+	ErrInvalidArchiveCompress = errors.New("Compression must be gzip or none")
+	ErrUnknownArchiveFile     = errors.New("Not an archive file")
+
+	archiveFiles    = map[string]*archiveFile{}
+	archiveFilesMtx sync.Mutex
+)
+
+// ArchiveConfig controls the archive preprocessor, which tees every entry into local time
+// partitioned files as it passes through, giving a cheap raw copy for compliance and replay.
+// Files are partitioned by the time entries are archived rather than their timestamps so each
+// partition is written once, files are named archive-<time>.<format>[.gz] under Archive-Path.
+//
+// The ndjson format holds one JSON object per entry with TS, SRC, Tag, and base64 encoded Data.
+// The native format holds each tag name, as a little endian uint16 length and the name, followed
+// by the entry in the ingest wire encoding.  Use OpenArchive to read either format back.
+type ArchiveConfig struct {
+	Archive_Path string // directory the archive files are written to
+	Format       string // ndjson or native, default is ndjson
+	Partition    string // hour or day, default is hour
+	Compression  string // gzip or none, default is gzip
+	Required     bool   // fail ingest when the archive cannot be written rather than passing entries on
+}
+
+func ArchiveLoadConfig(vc *config.VariableConfig) (c ArchiveConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func (c *ArchiveConfig) validate() error {
+	if c.Archive_Path = strings.TrimSpace(c.Archive_Path); c.Archive_Path == `` {
+		return ErrMissingArchivePath
+	}
+	c.Format = strings.ToLower(strings.TrimSpace(c.Format))
+	switch c.Format {
+	case ``:
+		c.Format = ArchiveFormatNDJSON
+	case ArchiveFormatNDJSON, ArchiveFormatNative:
+	default:
+		return ErrInvalidArchiveFormat
+	}
+	c.Partition = strings.ToLower(strings.TrimSpace(c.Partition))
+	switch c.Partition {
+	case ``:
+		c.Partition = ArchivePartitionHour
+	case ArchivePartitionHour, ArchivePartitionDay:
+	default:
+		return ErrInvalidArchivePart
+	}
+	c.Compression = strings.ToLower(strings.TrimSpace(c.Compression))
+	switch c.Compression {
+	case ``:
+		c.Compression = ArchiveCompressGzip
+	case ArchiveCompressGzip, ArchiveCompressNone:
+	default:
+		return ErrInvalidArchiveCompress
+	}
+	return nil
+}
+
+// Archive writes a copy of every entry to the archive and passes the entries on untouched.
+// Preprocessors sharing an Archive-Path and format share the underlying files.
+type Archive struct {
+	ArchiveConfig
+	af     *archiveFile
+	tt     *tagTrans
+	errors uint64
+}
+
+func NewArchive(cfg ArchiveConfig, tagger Tagger) (*Archive, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	} else if tagger == nil {
+		return nil, ErrNilTagger
+	}
+	af, err := openArchiveFile(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{
+		ArchiveConfig: cfg,
+		af:            af,
+		tt:            newTagTrans(tagger),
+	}, nil
+}
+
+func (a *Archive) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	if len(ents) == 0 {
+		return ents, nil
+	}
+	if err := a.af.write(ents, a.tt); err != nil {
+		atomic.AddUint64(&a.errors, 1)
+		if a.Required {
+			return nil, err
+		}
+	}
+	return ents, nil
+}
+
+// Errors returns how many batches could not be archived
+func (a *Archive) Errors() uint64 {
+	return atomic.LoadUint64(&a.errors)
+}
+
+func (a *Archive) Flush() []*entry.Entry {
+	a.af.flush()
+	return nil
+}
+
+func (a *Archive) Close() error {
+	return a.af.release()
+}
+
+// archiveFile is the current partition file for an archive path and format
+type archiveFile struct {
+	sync.Mutex
+	key   string
+	cfg   ArchiveConfig
+	refs  int
+	now   func() time.Time
+	name  string // current partition file
+	fout  *os.File
+	gz    *gzip.Writer
+	bw    *bufio.Writer
+	enc   *json.Encoder
+	hdr   []byte
+	dirty bool
+	done  chan struct{}
+}
+
+func openArchiveFile(cfg ArchiveConfig) (*archiveFile, error) {
+	dir, err := filepath.Abs(cfg.Archive_Path)
+	if err != nil {
+		return nil, err
+	} else if err = os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+	cfg.Archive_Path = dir
+	key := strings.Join([]string{dir, cfg.Format, cfg.Partition, cfg.Compression}, "\x00")
+	archiveFilesMtx.Lock()
+	defer archiveFilesMtx.Unlock()
+	if af, ok := archiveFiles[key]; ok {
+		af.Lock()
+		af.refs++
+		af.Unlock()
+		return af, nil
+	}
+	af := &archiveFile{
+		key:  key,
+		cfg:  cfg,
+		refs: 1,
+		now:  time.Now,
+		hdr:  make([]byte, entry.ENTRY_HEADER_SIZE),
+		done: make(chan struct{}),
+	}
+	archiveFiles[key] = af
+	go af.flushRoutine()
+	return af, nil
+}
+
+// ArchiveFileName returns the name of the partition file holding entries archived at t
+func ArchiveFileName(t time.Time, format, partition, compression string) string {
+	layout := archiveHourLayout
+	if partition == ArchivePartitionDay {
+		layout = archiveDayLayout
+	}
+	name := ArchiveFilePrefix + t.UTC().Format(layout) + `.` + format
+	if compression == ArchiveCompressGzip {
+		name += archiveGzipExt
+	}
+	return name
+}
+
+// rotateNoLock makes sure the current partition file is open, the lock must be held
+func (af *archiveFile) rotateNoLock() (err error) {
+	name := ArchiveFileName(af.now(), af.cfg.Format, af.cfg.Partition, af.cfg.Compression)
+	if af.fout != nil && name == af.name {
+		return
+	}
+	if err = af.closeNoLock(); err != nil {
+		return
+	}
+	// restarting within a partition appends, concatenated gzip streams read back as one
+	if af.fout, err = os.OpenFile(filepath.Join(af.cfg.Archive_Path, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640); err != nil {
+		return
+	}
+	af.name = name
+	var wtr io.Writer = af.fout
+	if af.cfg.Compression == ArchiveCompressGzip {
+		af.gz = gzip.NewWriter(af.fout)
+		wtr = af.gz
+	}
+	af.bw = bufio.NewWriter(wtr)
+	af.enc = json.NewEncoder(af.bw)
+	return
+}
+
+func (af *archiveFile) write(ents []*entry.Entry, tt *tagTrans) (err error) {
+	af.Lock()
+	defer af.Unlock()
+	if err = af.rotateNoLock(); err != nil {
+		return
+	}
+	af.dirty = true
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		name := tt.TagName(ent.Tag)
+		if af.cfg.Format == ArchiveFormatNDJSON {
+			err = af.enc.Encode(tagStringEntry{Entry: ent, Tag: name})
+		} else {
+			err = af.writeNative(ent, name)
+		}
+		if err != nil {
+			// drop the file so the next write starts a fresh stream rather than appending to a broken one
+			af.closeNoLock()
+			return
+		}
+	}
+	return
+}
+
+func (af *archiveFile) writeNative(ent *entry.Entry, name string) error {
+	if len(name) > maxArchiveTagNameSize {
+		name = name[:maxArchiveTagNameSize]
+	}
+	if err := ent.EncodeHeader(af.hdr); err != nil {
+		return err
+	}
+	var sz [2]byte
+	binary.LittleEndian.PutUint16(sz[:], uint16(len(name)))
+	af.bw.Write(sz[:])
+	af.bw.WriteString(name)
+	af.bw.Write(af.hdr)
+	_, err := af.bw.Write(ent.Data)
+	return err
+}
+
+func (af *archiveFile) flush() {
+	af.Lock()
+	af.flushNoLock()
+	af.Unlock()
+}
+
+func (af *archiveFile) flushNoLock() (err error) {
+	if af.fout == nil || !af.dirty {
+		return
+	}
+	af.dirty = false
+	if err = af.bw.Flush(); err == nil && af.gz != nil {
+		err = af.gz.Flush()
+	}
+	return
+}
+
+// closeNoLock finishes the current partition file, the lock must be held
+func (af *archiveFile) closeNoLock() (err error) {
+	if af.fout == nil {
+		return
+	}
+	af.dirty = true
+	err = af.flushNoLock()
+	if af.gz != nil {
+		if lerr := af.gz.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	if lerr := af.fout.Close(); lerr != nil && err == nil {
+		err = lerr
+	}
+	af.fout, af.gz, af.bw, af.enc, af.name = nil, nil, nil, nil, ``
+	return
+}
+
+// flushRoutine pushes buffered entries to disk so a quiet archive is never far behind
+func (af *archiveFile) flushRoutine() {
+	tckr := time.NewTicker(archiveFlushInterval)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-af.done:
+			return
+		case <-tckr.C:
+			af.flush()
+		}
+	}
+}
+
+// release drops a reference, the file is closed when the last preprocessor using it closes
+func (af *archiveFile) release() error {
+	archiveFilesMtx.Lock()
+	af.Lock()
+	af.refs--
+	last := af.refs <= 0
+	if last {
+		delete(archiveFiles, af.key)
+	}
+	af.Unlock()
+	archiveFilesMtx.Unlock()
+	if !last {
+		return nil
+	}
+	close(af.done)
+	af.Lock()
+	defer af.Unlock()
+	return af.closeNoLock()
+}
+
+// ArchiveReader reads entries back out of a file written by the archive preprocessor
+type ArchiveReader struct {
+	fin    *os.File
+	gz     *gzip.Reader
+	br     *bufio.Reader
+	dec    *json.Decoder
+	native bool
+}
+
+type archiveRecord struct {
+	TS   entry.Timestamp
+	SRC  net.IP
+	Tag  string
+	Data []byte
+}
+
+// OpenArchive opens an archive file, the format and compression are taken from the file name
+func OpenArchive(p string) (ar *ArchiveReader, err error) {
+	base := filepath.Base(p)
+	compressed := strings.HasSuffix(base, archiveGzipExt)
+	base = strings.TrimSuffix(base, archiveGzipExt)
+	format := strings.TrimPrefix(filepath.Ext(base), `.`)
+	if format != ArchiveFormatNDJSON && format != ArchiveFormatNative {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownArchiveFile, p)
+	}
+	var fin *os.File
+	if fin, err = os.Open(p); err != nil {
+		return nil, err
+	}
+	var rdr io.Reader = fin
+	var gz *gzip.Reader
+	if compressed {
+		if gz, err = gzip.NewReader(fin); err != nil {
+			fin.Close()
+			return nil, err
+		}
+		rdr = gz
+	}
+	if ar, err = NewArchiveReader(rdr, format); err != nil {
+		fin.Close()
+		return nil, err
+	}
+	ar.fin, ar.gz = fin, gz
+	return
+}
+
+// NewArchiveReader reads archive records in the given format from an uncompressed stream.
+// Gravwell JSON exports can be read as the ndjson format.
+func NewArchiveReader(rdr io.Reader, format string) (*ArchiveReader, error) {
+	ar := &ArchiveReader{
+		br: bufio.NewReader(rdr),
+	}
+	switch format {
+	case ArchiveFormatNDJSON:
+		ar.dec = json.NewDecoder(ar.br)
+	case ArchiveFormatNative:
+		ar.native = true
+	default:
+		return nil, ErrInvalidArchiveFormat
+	}
+	return ar, nil
+}
+
+// Next returns the next entry and its tag name, the entry tag is not set.
+// io.EOF is returned at the end of the archive.
+func (ar *ArchiveReader) Next() (ent *entry.Entry, tag string, err error) {
+	if !ar.native {
+		var rec archiveRecord
+		if err = ar.dec.Decode(&rec); err != nil {
+			return
+		}
+		ent = &entry.Entry{TS: rec.TS, SRC: rec.SRC, Data: rec.Data}
+		tag = rec.Tag
+		return
+	}
+	var sz [2]byte
+	if _, err = io.ReadFull(ar.br, sz[:]); err != nil {
+		return
+	}
+	name := make([]byte, binary.LittleEndian.Uint16(sz[:]))
+	if _, err = io.ReadFull(ar.br, name); err != nil {
+		err = unexpectedEOF(err)
+		return
+	}
+	ent = &entry.Entry{}
+	if err = ent.DecodeReader(ar.br); err != nil {
+		ent = nil
+		err = unexpectedEOF(err)
+		return
+	}
+	ent.Tag = 0
+	tag = string(name)
+	return
+}
+
+// unexpectedEOF reports a record cut off part way through as such rather than a clean end
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Close closes the file opened by OpenArchive, it is a no-op for readers from NewArchiveReader
+func (ar *ArchiveReader) Close() (err error) {
+	if ar.gz != nil {
+		err = ar.gz.Close()
+	}
+	if ar.fin != nil {
+		if lerr := ar.fin.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	return
+}
@@ -0,0 +1,172 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestArchiveConfig(t *testing.T) {
+	b := []byte(`
+	[preprocessor "arch"]
+		Type = archive
+		Archive-Path = "/tmp/archive"
+		Format = NATIVE
+		Partition = day
+	`)
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	vc, ok := tc.Preprocessor[`arch`]
+	if !ok {
+		t.Fatal("missing preprocessor")
+	}
+	cfg, err := ArchiveLoadConfig(vc)
+	if err != nil {
+		t.Fatal(err)
+	} else if cfg.Format != ArchiveFormatNative || cfg.Partition != ArchivePartitionDay || cfg.Compression != ArchiveCompressGzip {
+		t.Fatalf("bad config: %+v", cfg)
+	}
+
+	bad := []ArchiveConfig{
+		{},
+		{Archive_Path: `/tmp`, Format: `csv`},
+		{Archive_Path: `/tmp`, Partition: `minute`},
+		{Archive_Path: `/tmp`, Compression: `zstd`},
+	}
+	for _, c := range bad {
+		if err := c.validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, format := range []string{ArchiveFormatNDJSON, ArchiveFormatNative} {
+		for _, compression := range []string{ArchiveCompressGzip, ArchiveCompressNone} {
+			testArchiveRoundTrip(t, format, compression)
+		}
+	}
+}
+
+func testArchiveRoundTrip(t *testing.T, format, compression string) {
+	dir := t.TempDir()
+	tgr := &testTagger{}
+	foo, _ := tgr.NegotiateTag(`foo`)
+	bar, _ := tgr.NegotiateTag(`bar`)
+	cfg := ArchiveConfig{
+		Archive_Path: dir,
+		Format:       format,
+		Compression:  compression,
+	}
+	a, err := NewArchive(cfg, tgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a second preprocessor on the same path shares the file
+	a2, err := NewArchive(cfg, tgr)
+	if err != nil {
+		t.Fatal(err)
+	} else if a.af != a2.af {
+		t.Fatal("archive files not shared")
+	}
+	ts := time.Date(2022, 3, 4, 5, 6, 7, 8, time.UTC)
+	a.af.now = func() time.Time { return ts }
+
+	ents := []*entry.Entry{
+		{TS: entry.FromStandard(ts), SRC: net.ParseIP(`10.0.0.1`), Tag: foo, Data: []byte("first entry")},
+		{TS: entry.FromStandard(ts.Add(time.Second)), SRC: net.ParseIP(`fe80::1`), Tag: bar, Data: []byte{0, 1, 2, 0xff}},
+	}
+	if set, err := a.Process(ents[:1]); err != nil || len(set) != 1 || set[0] != ents[0] {
+		t.Fatalf("entries not passed through: %v %v", set, err)
+	}
+	if _, err = a2.Process(ents[1:]); err != nil {
+		t.Fatal(err)
+	}
+	//move into the next partition
+	ts = ts.Add(time.Hour)
+	if _, err = a.Process(ents[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = a2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	first := filepath.Join(dir, ArchiveFileName(ts.Add(-time.Hour), format, ArchivePartitionHour, compression))
+	second := filepath.Join(dir, ArchiveFileName(ts, format, ArchivePartitionHour, compression))
+	checkArchive(t, first, ents, []string{`foo`, `bar`})
+	checkArchive(t, second, ents[:1], []string{`foo`})
+}
+
+func checkArchive(t *testing.T, p string, ents []*entry.Entry, tags []string) {
+	t.Helper()
+	ar, err := OpenArchive(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ar.Close()
+	for i := range ents {
+		ent, tag, err := ar.Next()
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		} else if tag != tags[i] {
+			t.Fatalf("%s: bad tag %q != %q", p, tag, tags[i])
+		} else if ent.TS != ents[i].TS || !ent.SRC.Equal(ents[i].SRC) || string(ent.Data) != string(ents[i].Data) {
+			t.Fatalf("%s: bad entry %+v != %+v", p, ent, ents[i])
+		}
+	}
+	if _, _, err = ar.Next(); err != io.EOF {
+		t.Fatalf("%s: expected EOF: %v", p, err)
+	}
+}
+
+func TestArchiveAppend(t *testing.T) {
+	dir := t.TempDir()
+	tgr := &testTagger{}
+	tg, _ := tgr.NegotiateTag(`foo`)
+	ts := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	ent := &entry.Entry{TS: entry.FromStandard(ts), Tag: tg, Data: []byte("restarted")}
+	//a restart within a partition appends another gzip stream
+	for i := 0; i < 2; i++ {
+		a, err := NewArchive(ArchiveConfig{Archive_Path: dir, Partition: ArchivePartitionDay}, tgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.af.now = func() time.Time { return ts }
+		if _, err = a.Process([]*entry.Entry{ent}); err != nil {
+			t.Fatal(err)
+		} else if err = a.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkArchive(t, filepath.Join(dir, `archive-20220304.ndjson.gz`), []*entry.Entry{ent, ent}, []string{`foo`, `foo`})
+
+	if _, err := OpenArchive(filepath.Join(dir, `archive.txt`)); err == nil {
+		t.Fatal("failed to catch unknown archive file")
+	}
+	if fis, err := os.ReadDir(dir); err != nil || len(fis) != 1 {
+		t.Fatalf("unexpected archive files: %v %v", fis, err)
+	}
+}
@@ -0,0 +1,402 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	AttachProcessor = `attach`
+
+	attachSplit          = `,`
+	defaultAttachRefresh = 5 * time.Minute
+	minAttachRefresh     = time.Second
+	attachFetchTimeout   = 2 * time.Second
+	maxAttachValueSize   = 64 * 1024
+
+	attachSourceEnv      = `env`
+	attachSourceHostname = `hostname`
+	attachSourceEC2      = `ec2`
+	attachSourceGCP      = `gcp`
+	attachSourceFile     = `file`
+)
+
+var (
+	ErrMissingAttachments     = errors.New("At least one Attach value is required")
+	ErrInvalidAttachment      = errors.New("Attach must be of the form name,template")
+	ErrInvalidAttachTemplate  = errors.New("Attach template has an unterminated ${")
+	ErrUnknownAttachSource    = errors.New("Unknown Attach source, must be env, hostname, ec2, gcp, or file")
+	ErrMissingAttachKey       = errors.New("Attach source requires a key, e.g. ${env:NAME}")
+	ErrInvalidAttachRefresh   = fmt.Errorf("Refresh-Interval must be at least %v", minAttachRefresh)
+	ErrAttachMetadataResponse = errors.New("Instance metadata request failed")
+
+	// instance metadata endpoints, variables so tests can point them elsewhere
+	ec2MetadataURL = `http://169.254.169.254/latest`
+	gcpMetadataURL = `http://metadata.google.internal/computeMetadata/v1`
+)
+
+// AttachConfig attaches named values to every entry.  Values are templates which may pull
+// from the environment, the hostname, EC2 or GCP instance metadata, and files such as those
+// from the Kubernetes downward API:
+//
+//	Attach=datacenter,us-east
+//	Attach=host,${hostname}
+//	Attach=instance,${ec2:instance-id} in ${ec2:placement/availability-zone}
+//	Attach=zone,${gcp:instance/zone}
+//	Attach=pod,${env:POD_NAMESPACE}/${file:/etc/podinfo/name}
+//
+// Templated values are re-resolved every Refresh-Interval, a failed refresh keeps the
+// previous values.
+type AttachConfig struct {
+	Attach           []string // name,template pairs
+	Refresh_Interval string   // how often templated values are re-resolved, defaults to 5m
+	Annotation_Mode  string
+	Annotation_Field string
+}
+
+type attachPart struct {
+	lit string
+	src string // empty for literal text
+	key string
+}
+
+type attachTemplate struct {
+	name  string
+	parts []attachPart
+}
+
+type Attach struct {
+	AttachConfig
+	sync.RWMutex
+	ann     Annotator
+	tmpls   []attachTemplate
+	anns    []Annotation
+	refresh time.Duration
+	closed  bool
+	done    chan bool
+	wg      sync.WaitGroup
+}
+
+func AttachLoadConfig(vc *config.VariableConfig) (c AttachConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		_, _, err = c.validate()
+	}
+	return
+}
+
+func NewAttach(cfg AttachConfig) (*Attach, error) {
+	a := &Attach{}
+	if err := a.init(cfg); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *Attach) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(AttachConfig); ok {
+		err = a.init(cfg)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (c *AttachConfig) validate() (tmpls []attachTemplate, refresh time.Duration, err error) {
+	if len(c.Attach) == 0 {
+		err = ErrMissingAttachments
+		return
+	}
+	for _, v := range c.Attach {
+		var tmpl attachTemplate
+		if tmpl, err = parseAttachment(v); err != nil {
+			err = fmt.Errorf("Invalid Attach %q: %w", v, err)
+			return
+		}
+		tmpls = append(tmpls, tmpl)
+	}
+	refresh = defaultAttachRefresh
+	if c.Refresh_Interval = strings.TrimSpace(c.Refresh_Interval); c.Refresh_Interval != `` {
+		if refresh, err = time.ParseDuration(c.Refresh_Interval); err != nil {
+			return
+		} else if refresh < minAttachRefresh {
+			err = ErrInvalidAttachRefresh
+			return
+		}
+	}
+	_, err = parseAnnotationMode(c.Annotation_Mode)
+	return
+}
+
+func (a *Attach) init(cfg AttachConfig) (err error) {
+	var tmpls []attachTemplate
+	var refresh time.Duration
+	var ann Annotator
+	var anns []Annotation
+	if tmpls, refresh, err = cfg.validate(); err != nil {
+		return
+	} else if ann, err = NewAnnotator(cfg.Annotation_Mode, cfg.Annotation_Field); err != nil {
+		return
+	} else if anns, err = renderAttachments(tmpls); err != nil {
+		return
+	}
+	//stop any existing refresher before we swap things out
+	a.stopRefresher()
+
+	a.Lock()
+	defer a.Unlock()
+	a.AttachConfig = cfg
+	a.ann = ann
+	a.tmpls = tmpls
+	a.anns = anns
+	a.refresh = refresh
+	a.closed = false
+	if dynamicAttachments(tmpls) {
+		a.done = make(chan bool)
+		a.wg.Add(1)
+		go a.refresher(refresh, a.done)
+	}
+	return
+}
+
+func (a *Attach) Process(ents []*entry.Entry) (rset []*entry.Entry, err error) {
+	if len(ents) == 0 {
+		return
+	}
+	a.RLock()
+	defer a.RUnlock()
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		if _, err = a.ann.Annotate(ent, a.anns...); err != nil {
+			return
+		}
+		rset = append(rset, ent)
+	}
+	return
+}
+
+// Values returns the currently attached values by name
+func (a *Attach) Values() map[string]string {
+	a.RLock()
+	defer a.RUnlock()
+	r := make(map[string]string, len(a.anns))
+	for _, an := range a.anns {
+		r[an.Name] = an.Value
+	}
+	return r
+}
+
+// Refresh re-resolves the templated values, if any of them fail the existing values are retained.
+func (a *Attach) Refresh() (err error) {
+	a.RLock()
+	tmpls := a.tmpls
+	a.RUnlock()
+	var anns []Annotation
+	if anns, err = renderAttachments(tmpls); err != nil {
+		return
+	}
+	a.Lock()
+	a.anns = anns
+	a.Unlock()
+	return
+}
+
+func (a *Attach) refresher(interval time.Duration, done chan bool) {
+	defer a.wg.Done()
+	tckr := time.NewTicker(interval)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tckr.C:
+			//a failed refresh keeps the existing values
+			a.Refresh()
+		}
+	}
+}
+
+func (a *Attach) stopRefresher() {
+	a.Lock()
+	done := a.done
+	a.done = nil
+	a.Unlock()
+	if done != nil {
+		close(done)
+		a.wg.Wait()
+	}
+}
+
+func (a *Attach) Flush() []*entry.Entry {
+	return nil
+}
+
+func (a *Attach) Close() (err error) {
+	a.RLock()
+	closed := a.closed
+	a.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	a.stopRefresher()
+	a.Lock()
+	a.closed = true
+	a.Unlock()
+	return
+}
+
+func parseAttachment(v string) (tmpl attachTemplate, err error) {
+	idx := strings.Index(v, attachSplit)
+	if idx <= 0 {
+		err = ErrInvalidAttachment
+		return
+	}
+	if tmpl.name = strings.TrimSpace(v[:idx]); tmpl.name == `` {
+		err = ErrInvalidAttachment
+		return
+	}
+	tmpl.parts, err = parseAttachTemplate(strings.TrimSpace(v[idx+1:]))
+	return
+}
+
+// parseAttachTemplate splits a template into literal text and ${source:key} references
+func parseAttachTemplate(v string) (parts []attachPart, err error) {
+	for len(v) > 0 {
+		start := strings.Index(v, `${`)
+		if start < 0 {
+			parts = append(parts, attachPart{lit: v})
+			break
+		} else if start > 0 {
+			parts = append(parts, attachPart{lit: v[:start]})
+		}
+		end := strings.Index(v[start:], `}`)
+		if end < 0 {
+			err = ErrInvalidAttachTemplate
+			return
+		}
+		var p attachPart
+		ref := strings.TrimSpace(v[start+2 : start+end])
+		if idx := strings.Index(ref, `:`); idx >= 0 {
+			p.src, p.key = strings.ToLower(strings.TrimSpace(ref[:idx])), strings.TrimSpace(ref[idx+1:])
+		} else {
+			p.src = strings.ToLower(ref)
+		}
+		switch p.src {
+		case attachSourceHostname:
+		case attachSourceEnv, attachSourceEC2, attachSourceGCP, attachSourceFile:
+			if p.key == `` {
+				err = fmt.Errorf("%w: %s", ErrMissingAttachKey, p.src)
+				return
+			}
+		default:
+			err = fmt.Errorf("%w: %q", ErrUnknownAttachSource, p.src)
+			return
+		}
+		parts = append(parts, p)
+		v = v[start+end+1:]
+	}
+	return
+}
+
+func dynamicAttachments(tmpls []attachTemplate) bool {
+	for _, t := range tmpls {
+		for _, p := range t.parts {
+			if p.src != `` {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func renderAttachments(tmpls []attachTemplate) (anns []Annotation, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), attachFetchTimeout)
+	defer cancel()
+	var ec2Token string
+	for _, t := range tmpls {
+		var sb strings.Builder
+		for _, p := range t.parts {
+			var val string
+			switch p.src {
+			case ``:
+				val = p.lit
+			case attachSourceEnv:
+				val = os.Getenv(p.key)
+			case attachSourceHostname:
+				val, err = os.Hostname()
+			case attachSourceFile:
+				var bb []byte
+				if bb, err = ioutil.ReadFile(p.key); err == nil {
+					val = strings.TrimSpace(string(bb))
+				}
+			case attachSourceEC2:
+				if ec2Token == `` {
+					if ec2Token, err = ec2MetadataToken(ctx); err != nil {
+						break
+					}
+				}
+				val, err = fetchMetadata(ctx, http.MethodGet, ec2MetadataURL+`/meta-data/`+strings.TrimPrefix(p.key, `/`),
+					`X-aws-ec2-metadata-token`, ec2Token)
+			case attachSourceGCP:
+				val, err = fetchMetadata(ctx, http.MethodGet, gcpMetadataURL+`/`+strings.TrimPrefix(p.key, `/`),
+					`Metadata-Flavor`, `Google`)
+			}
+			if err != nil {
+				err = fmt.Errorf("Failed to resolve %s for %s: %w", p.src, t.name, err)
+				return
+			}
+			sb.WriteString(val)
+		}
+		anns = append(anns, Annotation{Name: t.name, Value: sb.String()})
+	}
+	return
+}
+
+// ec2MetadataToken gets an IMDSv2 session token
+func ec2MetadataToken(ctx context.Context) (string, error) {
+	return fetchMetadata(ctx, http.MethodPut, ec2MetadataURL+`/api/token`, `X-aws-ec2-metadata-token-ttl-seconds`, `300`)
+}
+
+func fetchMetadata(ctx context.Context, method, url, hdr, hdrVal string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return ``, err
+	}
+	req.Header.Set(hdr, hdrVal)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ``, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ``, fmt.Errorf("%w: %s %s", ErrAttachMetadataResponse, url, resp.Status)
+	}
+	bb, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAttachValueSize))
+	if err != nil {
+		return ``, err
+	}
+	return strings.TrimSpace(string(bb)), nil
+}
@@ -0,0 +1,165 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+// fakeMetadata serves EC2 (IMDSv2) and GCP style instance metadata
+func fakeMetadata(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc(`/ec2/api/token`, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get(`X-aws-ec2-metadata-token-ttl-seconds`) == `` {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`token`))
+	})
+	mux.HandleFunc(`/ec2/meta-data/instance-id`, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(`X-aws-ec2-metadata-token`) != `token` {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("i-0123456789\n"))
+	})
+	mux.HandleFunc(`/gcp/instance/zone`, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(`Metadata-Flavor`) != `Google` {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`projects/1/zones/us-central1-a`))
+	})
+	srv := httptest.NewServer(mux)
+	oldEC2, oldGCP := ec2MetadataURL, gcpMetadataURL
+	ec2MetadataURL, gcpMetadataURL = srv.URL+`/ec2`, srv.URL+`/gcp`
+	t.Cleanup(func() {
+		ec2MetadataURL, gcpMetadataURL = oldEC2, oldGCP
+		srv.Close()
+	})
+}
+
+func TestAttachConfig(t *testing.T) {
+	b := []byte(`
+	[preprocessor "meta"]
+		type = attach
+		Attach = "datacenter,us-east"
+		Attach = "host,${hostname}"
+		Refresh-Interval = 30s
+		Annotation-Mode = json
+		Annotation-Field = meta
+	`)
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	p, err := tc.Preprocessor.getProcessor(`meta`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	a, ok := p.(*Attach)
+	if !ok {
+		t.Fatalf("bad processor type: %T", p)
+	} else if a.refresh != 30*time.Second || len(a.tmpls) != 2 {
+		t.Fatalf("bad config: %+v", a.AttachConfig)
+	}
+	host, _ := os.Hostname()
+	ents, err := a.Process([]*entry.Entry{{Data: []byte(`{"x":1}`)}})
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := fmt.Sprintf(`{"x":1,"meta":{"datacenter":"us-east","host":%q}}`, host); string(ents[0].Data) != exp {
+		t.Fatalf("bad entry %s != %s", ents[0].Data, exp)
+	}
+
+	for _, v := range []AttachConfig{
+		{},
+		{Attach: []string{`nocomma`}},
+		{Attach: []string{`,value`}},
+		{Attach: []string{`x,${env:FOO`}},
+		{Attach: []string{`x,${env}`}},
+		{Attach: []string{`x,${registry:foo}`}},
+		{Attach: []string{`x,y`}, Refresh_Interval: `1ms`},
+		{Attach: []string{`x,y`}, Annotation_Mode: `xml`},
+	} {
+		if _, _, err := v.validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", v)
+		}
+	}
+}
+
+func TestAttachSources(t *testing.T) {
+	fakeMetadata(t)
+	pod := filepath.Join(t.TempDir(), `name`)
+	if err := os.WriteFile(pod, []byte("web-1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(`ATTACH_TEST_NS`, `prod`)
+	defer os.Unsetenv(`ATTACH_TEST_NS`)
+
+	a, err := NewAttach(AttachConfig{
+		Attach: []string{
+			`instance,${ec2:instance-id}`,
+			`zone,${gcp:/instance/zone}`,
+			`pod,${env:ATTACH_TEST_NS}/${file:` + pod + `}`,
+		},
+		Refresh_Interval: `1s`,
+		Annotation_Mode:  `prefix`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	ents, err := a.Process([]*entry.Entry{{Data: []byte(`hello`)}})
+	if err != nil {
+		t.Fatal(err)
+	} else if exp := `instance=i-0123456789 zone=projects/1/zones/us-central1-a pod=prod/web-1 hello`; string(ents[0].Data) != exp {
+		t.Fatalf("bad entry %q", ents[0].Data)
+	}
+
+	//values follow changes to their sources
+	if err = os.WriteFile(pod, []byte(`web-2`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300 && a.Values()[`pod`] != `prod/web-2`; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if v := a.Values()[`pod`]; v != `prod/web-2` {
+		t.Fatalf("value not refreshed: %s", v)
+	}
+
+	//a failed refresh keeps the last values
+	if err = os.Remove(pod); err != nil {
+		t.Fatal(err)
+	} else if err = a.Refresh(); err == nil {
+		t.Fatal("refresh of a missing file succeeded")
+	} else if v := a.Values()[`pod`]; v != `prod/web-2` {
+		t.Fatalf("lost the previous value: %s", v)
+	}
+
+	//metadata failures are caught up front
+	if _, err = NewAttach(AttachConfig{Attach: []string{`x,${gcp:instance/missing}`}}); !errors.Is(err, ErrAttachMetadataResponse) {
+		t.Fatalf("bad metadata error: %v", err)
+	}
+}
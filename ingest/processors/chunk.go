@@ -0,0 +1,135 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	OversizeTruncate = `truncate`
+	OversizeSplit    = `split`
+	OversizeReject   = `reject`
+
+	minMaxEntrySize = 256
+)
+
+var (
+	ErrEntryTooLarge         = errors.New("entry is larger than Max-Entry-Size")
+	ErrInvalidMaxEntrySize   = fmt.Errorf("Max-Entry-Size must be between %d bytes and %d bytes", minMaxEntrySize, ingest.MAX_ENTRY_SIZE)
+	ErrInvalidOversizePolicy = errors.New("Oversize-Policy must be truncate, split, or reject")
+	ErrPolicyWithoutMaxSize  = errors.New("Oversize-Policy requires Max-Entry-Size")
+)
+
+// ChunkConfig is embedded in listener configurations so every ingester handles oversized records
+// the same way.  Entries larger than Max-Entry-Size are truncated, split into pieces which each
+// end with a " [chunk 2/3]" continuation marker, or rejected.  Splitting is the default.
+// Pieces carry the timestamp, source, and tag of the original entry.
+type ChunkConfig struct {
+	Max_Entry_Size  string // entries larger than this are subject to the policy, such as 1MB
+	Oversize_Policy string // truncate, split, or reject
+}
+
+// Chunker applies the entry size policy.  A nil Chunker is valid and leaves entries alone.
+type Chunker struct {
+	max    int
+	policy string
+}
+
+// Validate checks the entry size configuration
+func (c ChunkConfig) Validate() (err error) {
+	_, err = c.NewChunker()
+	return
+}
+
+// NewChunker builds a chunker, if no Max-Entry-Size is set the returned chunker is nil
+func (c ChunkConfig) NewChunker() (ch *Chunker, err error) {
+	policy := strings.ToLower(strings.TrimSpace(c.Oversize_Policy))
+	if strings.TrimSpace(c.Max_Entry_Size) == `` {
+		if policy != `` {
+			err = ErrPolicyWithoutMaxSize
+		}
+		return
+	}
+	switch policy {
+	case ``:
+		policy = OversizeSplit
+	case OversizeTruncate, OversizeSplit, OversizeReject:
+	default:
+		err = fmt.Errorf("%w: %q", ErrInvalidOversizePolicy, c.Oversize_Policy)
+		return
+	}
+	var sz int64
+	if sz, err = config.ParseDataSize(c.Max_Entry_Size); err != nil || sz < int64(minMaxEntrySize) || sz > int64(ingest.MAX_ENTRY_SIZE) {
+		err = fmt.Errorf("%w, got %q", ErrInvalidMaxEntrySize, c.Max_Entry_Size)
+		return
+	}
+	ch = &Chunker{
+		max:    int(sz),
+		policy: policy,
+	}
+	return
+}
+
+// Chunk applies the policy to an entry and returns the entries to ingest in its place, entries
+// within the limit come back as is.  Under the reject policy oversized entries get ErrEntryTooLarge.
+func (ch *Chunker) Chunk(ent *entry.Entry) ([]*entry.Entry, error) {
+	if ch == nil || ent == nil || len(ent.Data) <= ch.max {
+		return []*entry.Entry{ent}, nil
+	}
+	switch ch.policy {
+	case OversizeReject:
+		return nil, ErrEntryTooLarge
+	case OversizeTruncate:
+		ent.Data = ent.Data[:runeCut(ent.Data, ch.max)]
+		return []*entry.Entry{ent}, nil
+	}
+	//reserve room for the widest marker, the piece count never has more digits than the size
+	budget := ch.max - len(chunkMarker(len(ent.Data), len(ent.Data)))
+	var pieces [][]byte
+	for b := ent.Data; len(b) > 0; {
+		n := len(b)
+		if n > budget {
+			n = runeCut(b, budget)
+		}
+		pieces = append(pieces, b[:n])
+		b = b[n:]
+	}
+	ents := make([]*entry.Entry, 0, len(pieces))
+	for i, p := range pieces {
+		m := chunkMarker(i+1, len(pieces))
+		e := *ent
+		e.Data = append(append(make([]byte, 0, len(p)+len(m)), p...), m...)
+		ents = append(ents, &e)
+	}
+	return ents, nil
+}
+
+func chunkMarker(i, n int) string {
+	return ` [chunk ` + strconv.Itoa(i) + `/` + strconv.Itoa(n) + `]`
+}
+
+// runeCut returns where to cut b so the first piece is at most max bytes and, if b is UTF-8,
+// does not end part way through a character
+func runeCut(b []byte, max int) int {
+	for i := max; i > 0 && i > max-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			return i
+		}
+	}
+	return max
+}
@@ -0,0 +1,98 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestChunkConfig(t *testing.T) {
+	if ch, err := (ChunkConfig{}).NewChunker(); err != nil || ch != nil {
+		t.Fatalf("empty config should produce a nil chunker: %v %v", ch, err)
+	}
+	if ch, err := (ChunkConfig{Max_Entry_Size: `1KB`}).NewChunker(); err != nil {
+		t.Fatal(err)
+	} else if ch.max != 1024 || ch.policy != OversizeSplit {
+		t.Fatalf("bad chunker %+v", ch)
+	}
+	bad := []struct {
+		c   ChunkConfig
+		err error
+	}{
+		{ChunkConfig{Oversize_Policy: `split`}, ErrPolicyWithoutMaxSize},
+		{ChunkConfig{Max_Entry_Size: `1KB`, Oversize_Policy: `fold`}, ErrInvalidOversizePolicy},
+		{ChunkConfig{Max_Entry_Size: `big`}, ErrInvalidMaxEntrySize},
+		{ChunkConfig{Max_Entry_Size: `16`}, ErrInvalidMaxEntrySize},
+		{ChunkConfig{Max_Entry_Size: `2GB`}, ErrInvalidMaxEntrySize},
+	}
+	for _, b := range bad {
+		if err := b.c.Validate(); !errors.Is(err, b.err) {
+			t.Fatalf("bad error on %+v: %v", b.c, err)
+		}
+	}
+}
+
+func TestChunker(t *testing.T) {
+	small := &entry.Entry{Tag: 1, Data: []byte(`small`)}
+	data := strings.Repeat(`日本語 text `, 100) // multi byte characters straddle the cuts
+	newEnt := func() *entry.Entry {
+		return &entry.Entry{TS: entry.Now(), Tag: 1, Data: []byte(data)}
+	}
+	for _, policy := range []string{OversizeTruncate, OversizeSplit, OversizeReject} {
+		ch, err := ChunkConfig{Max_Entry_Size: `256`, Oversize_Policy: policy}.NewChunker()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ents, err := ch.Chunk(small); err != nil || len(ents) != 1 || ents[0] != small {
+			t.Fatalf("%s changed a small entry: %v", policy, err)
+		}
+		ent := newEnt()
+		ents, err := ch.Chunk(ent)
+		switch policy {
+		case OversizeReject:
+			if err != ErrEntryTooLarge || len(ents) != 0 {
+				t.Fatalf("bad reject %d %v", len(ents), err)
+			}
+		case OversizeTruncate:
+			if err != nil || len(ents) != 1 {
+				t.Fatalf("bad truncate %d %v", len(ents), err)
+			} else if d := ents[0].Data; len(d) > 256 || !utf8.Valid(d) || !strings.HasPrefix(data, string(d)) {
+				t.Fatalf("bad truncated entry %q", d)
+			}
+		case OversizeSplit:
+			if err != nil || len(ents) < 2 {
+				t.Fatalf("bad split %d %v", len(ents), err)
+			}
+			var joined string
+			for i, e := range ents {
+				d := string(e.Data)
+				marker := chunkMarker(i+1, len(ents))
+				if len(d) > 256 || !utf8.ValidString(d) || !strings.HasSuffix(d, marker) {
+					t.Fatalf("bad piece %d %q", i, d)
+				} else if e.TS != ent.TS || e.Tag != ent.Tag {
+					t.Fatalf("piece %d lost the entry header", i)
+				}
+				joined += strings.TrimSuffix(d, marker)
+			}
+			if joined != data {
+				t.Fatal("pieces do not add up to the entry")
+			}
+		}
+	}
+
+	var ch *Chunker
+	if ents, err := ch.Chunk(small); err != nil || len(ents) != 1 || ents[0] != small {
+		t.Fatal("nil chunker changed an entry")
+	}
+}
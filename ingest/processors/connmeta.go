@@ -0,0 +1,232 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	MetadataListener   = `listener`
+	MetadataRemoteAddr = `remote-addr`
+	MetadataRemotePort = `remote-port`
+	MetadataTLSCN      = `tls-cn`
+	MetadataTenant     = `tenant`
+	MetadataHeader     = `header:` // prefix for HTTP headers, e.g. header:X-Request-ID
+	MetadataPathParam  = `path:`   // prefix for captured URL path parameters, e.g. path:app
+
+	metaListenerName   = `listener`
+	metaRemoteAddrName = `remote_addr`
+	metaRemotePortName = `remote_port`
+	metaTLSCNName      = `tls_cn`
+	metaTenantName     = `tenant`
+)
+
+var (
+	ErrInvalidMetadata       = errors.New("Unknown Attach-Metadata value, must be listener, remote-addr, remote-port, tls-cn, tenant, header:<name>, or path:<name>")
+	ErrMetadataHeaderMissing = errors.New("Attach-Metadata header item is missing a header name")
+	ErrMetadataParamMissing  = errors.New("Attach-Metadata path item is missing a parameter name")
+)
+
+// ConnMetadataConfig is embedded in listener configurations to attach connection metadata to every
+// entry without a dedicated preprocessor.  Values are attached with an Annotator, so JSON entries
+// gain fields and everything else is prefixed with key=value pairs.
+type ConnMetadataConfig struct {
+	Attach_Metadata []string // listener, remote-addr, remote-port, tls-cn, tenant, header:<name>, path:<name>
+	Metadata_Mode   string   // annotation mode: auto, json, or prefix
+	Metadata_Field  string   // optional JSON field to nest the metadata under
+}
+
+// ConnMetadata describes where a set of entries came from
+type ConnMetadata struct {
+	Listener   string
+	RemoteIP   net.IP
+	RemotePort int
+	TLSCN      string
+	Tenant     string // set by listeners that authenticate tenants
+	Header     http.Header
+	PathParams map[string]string // segments captured from a parameterized URL
+}
+
+// MetadataAttacher renders the configured connection metadata and attaches it to entries.
+// A nil MetadataAttacher is valid and attaches nothing.
+type MetadataAttacher struct {
+	ann        Annotator
+	listener   bool
+	remoteAddr bool
+	remotePort bool
+	tlsCN      bool
+	tenant     bool
+	headers    []string
+	params     []string
+}
+
+// Validate checks the metadata configuration
+func (c ConnMetadataConfig) Validate() (err error) {
+	_, err = c.NewMetadataAttacher()
+	return
+}
+
+// NewMetadataAttacher builds an attacher, if no metadata is requested the returned attacher is nil.
+func (c ConnMetadataConfig) NewMetadataAttacher() (ma *MetadataAttacher, err error) {
+	var ann Annotator
+	if ann, err = NewAnnotator(c.Metadata_Mode, c.Metadata_Field); err != nil || len(c.Attach_Metadata) == 0 {
+		return
+	}
+	ma = &MetadataAttacher{
+		ann: ann,
+	}
+	for _, v := range c.Attach_Metadata {
+		v = strings.TrimSpace(v)
+		if len(v) > len(MetadataHeader) && strings.EqualFold(v[:len(MetadataHeader)], MetadataHeader) {
+			ma.headers = append(ma.headers, strings.TrimSpace(v[len(MetadataHeader):]))
+			continue
+		} else if len(v) > len(MetadataPathParam) && strings.EqualFold(v[:len(MetadataPathParam)], MetadataPathParam) {
+			ma.params = append(ma.params, strings.TrimSpace(v[len(MetadataPathParam):]))
+			continue
+		}
+		switch strings.ToLower(v) {
+		case MetadataListener:
+			ma.listener = true
+		case MetadataRemoteAddr:
+			ma.remoteAddr = true
+		case MetadataRemotePort:
+			ma.remotePort = true
+		case MetadataTLSCN:
+			ma.tlsCN = true
+		case MetadataTenant:
+			ma.tenant = true
+		case MetadataHeader:
+			err = ErrMetadataHeaderMissing
+			return
+		case MetadataPathParam:
+			err = ErrMetadataParamMissing
+			return
+		default:
+			err = fmt.Errorf("%w: %q", ErrInvalidMetadata, v)
+			return
+		}
+	}
+	return
+}
+
+// Annotations renders the metadata into a set of annotations, this should be done once per
+// connection or request and the result handed to Attach for each entry.  Empty values are skipped.
+func (ma *MetadataAttacher) Annotations(md ConnMetadata) (anns []Annotation) {
+	if ma == nil {
+		return
+	}
+	if ma.listener && md.Listener != `` {
+		anns = append(anns, Annotation{Name: metaListenerName, Value: md.Listener})
+	}
+	if ma.remoteAddr && md.RemoteIP != nil {
+		anns = append(anns, Annotation{Name: metaRemoteAddrName, Value: md.RemoteIP.String()})
+	}
+	if ma.remotePort && md.RemotePort > 0 {
+		anns = append(anns, Annotation{Name: metaRemotePortName, Value: strconv.Itoa(md.RemotePort)})
+	}
+	if ma.tlsCN && md.TLSCN != `` {
+		anns = append(anns, Annotation{Name: metaTLSCNName, Value: md.TLSCN})
+	}
+	if ma.tenant && md.Tenant != `` {
+		anns = append(anns, Annotation{Name: metaTenantName, Value: md.Tenant})
+	}
+	for _, h := range ma.headers {
+		if v := md.Header.Get(h); v != `` {
+			anns = append(anns, Annotation{Name: h, Value: v})
+		}
+	}
+	for _, p := range ma.params {
+		if v := md.PathParams[p]; v != `` {
+			anns = append(anns, Annotation{Name: p, Value: v})
+		}
+	}
+	return
+}
+
+// Attach adds the rendered annotations to the entry
+func (ma *MetadataAttacher) Attach(ent *entry.Entry, anns []Annotation) (err error) {
+	if ma == nil || len(anns) == 0 {
+		return
+	}
+	_, err = ma.ann.Annotate(ent, anns...)
+	return
+}
+
+// ConnMetadataFromConn pulls the remote address, port, and client certificate common name from a connection.
+// TLS connections are handshaken if they have not been already.
+func ConnMetadataFromConn(listener string, c net.Conn) (md ConnMetadata) {
+	md.Listener = listener
+	if c == nil {
+		return
+	}
+	md.RemoteIP, md.RemotePort = splitAddr(c.RemoteAddr())
+	if tc, ok := c.(*tls.Conn); ok {
+		if err := tc.Handshake(); err == nil {
+			md.TLSCN = peerCN(tc.ConnectionState())
+		}
+	}
+	return
+}
+
+// ConnMetadataFromAddr is used for connectionless listeners where only the remote address is known.
+func ConnMetadataFromAddr(listener string, a net.Addr) (md ConnMetadata) {
+	md.Listener = listener
+	md.RemoteIP, md.RemotePort = splitAddr(a)
+	return
+}
+
+// ConnMetadataFromRequest pulls the remote address, port, client certificate common name, and headers from an HTTP request.
+func ConnMetadataFromRequest(listener string, r *http.Request) (md ConnMetadata) {
+	md.Listener = listener
+	if r == nil {
+		return
+	}
+	md.Header = r.Header
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		md.RemoteIP, _, _ = config.ParseIPZone(host)
+		md.RemotePort, _ = strconv.Atoi(port)
+	}
+	if r.TLS != nil {
+		md.TLSCN = peerCN(*r.TLS)
+	}
+	return
+}
+
+func splitAddr(a net.Addr) (ip net.IP, port int) {
+	switch v := a.(type) {
+	case *net.TCPAddr:
+		ip, port = v.IP, v.Port
+	case *net.UDPAddr:
+		ip, port = v.IP, v.Port
+	case nil:
+	default:
+		if host, p, err := net.SplitHostPort(a.String()); err == nil {
+			ip, _, _ = config.ParseIPZone(host)
+			port, _ = strconv.Atoi(p)
+		}
+	}
+	return
+}
+
+func peerCN(cs tls.ConnectionState) string {
+	if len(cs.PeerCertificates) > 0 && cs.PeerCertificates[0] != nil {
+		return cs.PeerCertificates[0].Subject.CommonName
+	}
+	return ``
+}
@@ -0,0 +1,116 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestConnMetadataConfig(t *testing.T) {
+	if ma, err := (ConnMetadataConfig{}).NewMetadataAttacher(); err != nil || ma != nil {
+		t.Fatalf("empty config should produce a nil attacher: %v %v", ma, err)
+	}
+	good := ConnMetadataConfig{
+		Attach_Metadata: []string{`listener`, `Remote-Addr`, `remote-port`, `tls-cn`, `tenant`, `header:X-Request-ID`},
+	}
+	if err := good.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []ConnMetadataConfig{
+		ConnMetadataConfig{Attach_Metadata: []string{`foobar`}},
+		ConnMetadataConfig{Attach_Metadata: []string{`header:`}},
+		ConnMetadataConfig{Attach_Metadata: []string{`path:`}},
+		ConnMetadataConfig{Attach_Metadata: []string{`listener`}, Metadata_Mode: `xml`},
+	}
+	for _, c := range bad {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+	if err := bad[0].Validate(); !errors.Is(err, ErrInvalidMetadata) {
+		t.Fatalf("bad error: %v", err)
+	} else if err = bad[1].Validate(); err != ErrMetadataHeaderMissing {
+		t.Fatalf("bad error: %v", err)
+	}
+}
+
+func TestConnMetadataAttach(t *testing.T) {
+	cfg := ConnMetadataConfig{
+		Attach_Metadata: []string{`listener`, `remote-addr`, `remote-port`},
+	}
+	ma, err := cfg.NewMetadataAttacher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := ConnMetadataFromAddr(`syslog`, &net.UDPAddr{IP: net.ParseIP(`10.0.0.1`), Port: 5514})
+	anns := ma.Annotations(md)
+	if len(anns) != 3 {
+		t.Fatalf("bad annotation count: %d", len(anns))
+	}
+
+	//JSON entries get fields
+	ent := &entry.Entry{Data: []byte(`{"foo":"bar"}`)}
+	if err = ma.Attach(ent, anns); err != nil {
+		t.Fatal(err)
+	} else if string(ent.Data) != `{"foo":"bar","listener":"syslog","remote_addr":"10.0.0.1","remote_port":"5514"}` {
+		t.Fatalf("bad JSON output: %s", ent.Data)
+	}
+
+	//everything else gets a prefix
+	ent = &entry.Entry{Data: []byte(`hello`)}
+	if err = ma.Attach(ent, anns); err != nil {
+		t.Fatal(err)
+	} else if string(ent.Data) != `listener=syslog remote_addr=10.0.0.1 remote_port=5514 hello` {
+		t.Fatalf("bad prefix output: %s", ent.Data)
+	}
+
+	//a nil attacher does nothing
+	var nilma *MetadataAttacher
+	ent = &entry.Entry{Data: []byte(`hello`)}
+	if anns := nilma.Annotations(md); anns != nil {
+		t.Fatalf("nil attacher produced annotations: %v", anns)
+	} else if err = nilma.Attach(ent, anns); err != nil || string(ent.Data) != `hello` {
+		t.Fatalf("nil attacher modified entry: %s %v", ent.Data, err)
+	}
+}
+
+func TestConnMetadataFromRequest(t *testing.T) {
+	cfg := ConnMetadataConfig{
+		Attach_Metadata: []string{`remote-addr`, `tls-cn`, `header:X-Request-ID`, `header:X-Missing`, `path:app`},
+		Metadata_Field:  `meta`,
+	}
+	ma, err := cfg.NewMetadataAttacher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(`POST`, `/test`, nil)
+	req.RemoteAddr = `192.168.1.1:4444`
+	req.Header.Set(`X-Request-ID`, `abc123`)
+	md := ConnMetadataFromRequest(`http`, req)
+	md.PathParams = map[string]string{`app`: `billing`}
+	if !md.RemoteIP.Equal(net.ParseIP(`192.168.1.1`)) || md.RemotePort != 4444 {
+		t.Fatalf("bad remote address: %v %d", md.RemoteIP, md.RemotePort)
+	}
+	//no TLS and a missing header means those are skipped
+	anns := ma.Annotations(md)
+	if len(anns) != 3 {
+		t.Fatalf("bad annotation count: %v", anns)
+	}
+	ent := &entry.Entry{Data: []byte(`{"foo":"bar"}`)}
+	if err = ma.Attach(ent, anns); err != nil {
+		t.Fatal(err)
+	} else if string(ent.Data) != `{"foo":"bar","meta":{"remote_addr":"192.168.1.1","X-Request-ID":"abc123","app":"billing"}}` {
+		t.Fatalf("bad output: %s", ent.Data)
+	}
+}
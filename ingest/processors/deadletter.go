@@ -0,0 +1,295 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	deadLetterQueueDepth = 4096
+	deadLetterBatchSize  = 256
+	deadLetterBatchWait  = time.Second
+	deadLetterTimeout    = 10 * time.Second
+)
+
+var (
+	ErrInvalidDeadLetterURL    = errors.New("Dead-Letter-URL must be an http or https URL")
+	ErrInvalidDeadLetterHeader = errors.New("Dead-Letter-Header must be <name>:<value>")
+	ErrDeadLetterTagConflict   = errors.New("Dead-Letter-Tag cannot be used with this preprocessor, use its own reject tag")
+)
+
+// DeadLetterConfig is accepted by the parsing preprocessors (jsonextract, regexextract, regexrouter,
+// and schema).  Entries a preprocessor cannot parse are copied to a webhook and/or a file as
+// newline delimited JSON so the data can be triaged and the producers fixed.
+type DeadLetterConfig struct {
+	Dead_Letter_URL    string   // entries are POSTed here in batches
+	Dead_Letter_Header []string // extra webhook headers, <name>:<value>
+	Dead_Letter_File   string   // entries are appended to this file
+	Dead_Letter_Tag    string   // pass failed entries on with this tag instead of dropping or passing them as is
+}
+
+// deadLetterProcessor is implemented by preprocessors that can hand off entries they fail to parse
+type deadLetterProcessor interface {
+	setDeadLetter(*deadLetter) error
+}
+
+func DeadLetterLoadConfig(vc *config.VariableConfig) (c DeadLetterConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		_, err = c.validate()
+	}
+	return
+}
+
+func (c DeadLetterConfig) enabled() bool {
+	return c.Dead_Letter_URL != `` || c.Dead_Letter_File != `` || c.Dead_Letter_Tag != ``
+}
+
+func (c DeadLetterConfig) validate() (hdrs http.Header, err error) {
+	if c.Dead_Letter_URL != `` {
+		var u *url.URL
+		if u, err = url.Parse(c.Dead_Letter_URL); err != nil || (u.Scheme != `http` && u.Scheme != `https`) || u.Host == `` {
+			err = ErrInvalidDeadLetterURL
+			return
+		}
+	}
+	hdrs = http.Header{}
+	for _, h := range c.Dead_Letter_Header {
+		bits := strings.SplitN(h, `:`, 2)
+		if len(bits) != 2 || strings.TrimSpace(bits[0]) == `` {
+			err = fmt.Errorf("%w: %q", ErrInvalidDeadLetterHeader, h)
+			return
+		}
+		hdrs.Add(strings.TrimSpace(bits[0]), strings.TrimSpace(bits[1]))
+	}
+	if c.Dead_Letter_Tag != `` {
+		err = ingest.CheckTag(c.Dead_Letter_Tag)
+	}
+	return
+}
+
+// deadLetterRecord is what is written to the webhook and file for each failed entry
+type deadLetterRecord struct {
+	TS           string `json:"ts"`
+	SRC          string `json:"src,omitempty"`
+	Tag          string `json:"tag,omitempty"`
+	Preprocessor string `json:"preprocessor"`
+	Reason       string `json:"reason"`
+	Data         string `json:"data,omitempty"`
+	DataBase64   []byte `json:"data_base64,omitempty"` //data that is not valid UTF-8
+}
+
+// deadLetter ships failed entries from a background routine, ingest is never held up
+// waiting on the webhook or file; if the queue fills up records are dropped and counted.
+type deadLetter struct {
+	proc    string
+	tgr     Tagger
+	retag   bool
+	tag     entry.EntryTag
+	url     string
+	hdrs    http.Header
+	cli     *http.Client
+	fout    *os.File
+	ch      chan []byte
+	wg      sync.WaitGroup
+	once    sync.Once
+	sent    uint64
+	dropped uint64
+}
+
+// newDeadLetter returns nil if the config does not ask for dead lettering
+func newDeadLetter(proc string, cfg DeadLetterConfig, tgr Tagger) (dl *deadLetter, err error) {
+	if !cfg.enabled() {
+		return
+	}
+	var hdrs http.Header
+	if hdrs, err = cfg.validate(); err != nil {
+		return
+	}
+	dl = &deadLetter{
+		proc: proc,
+		tgr:  tgr,
+		url:  cfg.Dead_Letter_URL,
+		hdrs: hdrs,
+		ch:   make(chan []byte, deadLetterQueueDepth),
+	}
+	if cfg.Dead_Letter_Tag != `` {
+		if tgr == nil {
+			return nil, ErrNilTagger
+		} else if dl.tag, err = tgr.NegotiateTag(cfg.Dead_Letter_Tag); err != nil {
+			return nil, fmt.Errorf("Failed to negotiate tag %s: %v", cfg.Dead_Letter_Tag, err)
+		}
+		dl.retag = true
+	}
+	if dl.url != `` {
+		dl.cli = &http.Client{Timeout: deadLetterTimeout}
+	}
+	if cfg.Dead_Letter_File != `` {
+		if dl.fout, err = os.OpenFile(cfg.Dead_Letter_File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640); err != nil {
+			return nil, err
+		}
+	}
+	if dl.url != `` || dl.fout != nil {
+		dl.wg.Add(1)
+		go dl.routine()
+	}
+	return
+}
+
+// attachDeadLetter sets up dead lettering on a freshly built preprocessor
+func attachDeadLetter(p deadLetterProcessor, proc string, vc *config.VariableConfig, tgr Tagger) (err error) {
+	var cfg DeadLetterConfig
+	var dl *deadLetter
+	if err = vc.MapTo(&cfg); err != nil {
+		return
+	} else if dl, err = newDeadLetter(proc, cfg, tgr); err != nil || dl == nil {
+		return
+	} else if err = p.setDeadLetter(dl); err != nil {
+		dl.Close()
+	}
+	return
+}
+
+// miss hands off an entry the preprocessor could not parse.  If a dead letter tag is set
+// the entry is retagged and should be passed on, otherwise pass is false and the
+// preprocessor does whatever it normally does with misses.
+func (dl *deadLetter) miss(ent *entry.Entry, reason string) (pass bool) {
+	if dl == nil || ent == nil {
+		return
+	}
+	if dl.url != `` || dl.fout != nil {
+		rec := deadLetterRecord{
+			TS:           ent.TS.StandardTime().Format(time.RFC3339Nano),
+			Preprocessor: dl.proc,
+			Reason:       reason,
+		}
+		if ent.SRC != nil {
+			rec.SRC = ent.SRC.String()
+		}
+		if dl.tgr != nil {
+			rec.Tag, _ = dl.tgr.LookupTag(ent.Tag)
+		}
+		if utf8.Valid(ent.Data) {
+			rec.Data = string(ent.Data)
+		} else {
+			rec.DataBase64 = ent.Data
+		}
+		if b, err := json.Marshal(rec); err == nil {
+			select {
+			case dl.ch <- append(b, '\n'):
+			default:
+				atomic.AddUint64(&dl.dropped, 1)
+			}
+		}
+	}
+	if dl.retag {
+		ent.Tag = dl.tag
+		pass = true
+	}
+	return
+}
+
+func (dl *deadLetter) routine() {
+	defer dl.wg.Done()
+	var bb bytes.Buffer
+	var cnt int
+	tmr := time.NewTimer(deadLetterBatchWait)
+	defer tmr.Stop()
+	for {
+		select {
+		case b, ok := <-dl.ch:
+			if !ok {
+				dl.flush(&bb, cnt)
+				return
+			}
+			bb.Write(b)
+			if cnt++; cnt >= deadLetterBatchSize {
+				dl.flush(&bb, cnt)
+				cnt = 0
+			}
+		case <-tmr.C:
+			dl.flush(&bb, cnt)
+			cnt = 0
+			tmr.Reset(deadLetterBatchWait)
+		}
+	}
+}
+
+func (dl *deadLetter) flush(bb *bytes.Buffer, cnt int) {
+	if cnt == 0 {
+		return
+	}
+	defer bb.Reset()
+	ok := true
+	if dl.fout != nil {
+		if _, err := dl.fout.Write(bb.Bytes()); err != nil {
+			ok = false
+		}
+	}
+	if dl.url != `` {
+		if err := dl.post(bb.Bytes()); err != nil {
+			ok = false
+		}
+	}
+	if ok {
+		atomic.AddUint64(&dl.sent, uint64(cnt))
+	} else {
+		atomic.AddUint64(&dl.dropped, uint64(cnt))
+	}
+}
+
+func (dl *deadLetter) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, dl.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range dl.hdrs {
+		req.Header[k] = v
+	}
+	req.Header.Set(`Content-Type`, `application/x-ndjson`)
+	resp, err := dl.cli.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("dead letter webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Close flushes anything queued and closes the file, it is safe to call on a nil deadLetter
+func (dl *deadLetter) Close() (err error) {
+	if dl == nil {
+		return
+	}
+	dl.once.Do(func() {
+		close(dl.ch)
+		dl.wg.Wait()
+		if dl.fout != nil {
+			err = dl.fout.Close()
+		}
+	})
+	return
+}
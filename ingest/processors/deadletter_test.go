@@ -0,0 +1,201 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func loadDeadLetterTest(t *testing.T, cfg string) ProcessorConfig {
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, []byte(cfg)); err != nil {
+		t.Fatal(err)
+	}
+	return tc.Preprocessor
+}
+
+func readDeadLetters(t *testing.T, b []byte) (recs []deadLetterRecord) {
+	scn := bufio.NewScanner(bytes.NewReader(b))
+	for scn.Scan() {
+		var rec deadLetterRecord
+		if err := json.Unmarshal(scn.Bytes(), &rec); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, rec)
+	}
+	return
+}
+
+func TestDeadLetter(t *testing.T) {
+	var mtx sync.Mutex
+	var posted []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(`X-Token`) != `secret` || r.Header.Get(`Content-Type`) != `application/x-ndjson` {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		mtx.Lock()
+		posted = append(posted, b...)
+		mtx.Unlock()
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir(os.TempDir(), `deadletter`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `dead.json`)
+
+	pc := loadDeadLetterTest(t, `
+	[preprocessor "je"]
+		type = jsonextract
+		Extractions = foo
+		Dead-Letter-URL = "`+srv.URL+`"
+		Dead-Letter-Header = "X-Token: secret"
+		Dead-Letter-File = "`+fpath+`"
+		Dead-Letter-Tag = unparsed
+	`)
+	var tt testTagger
+	tt.NegotiateTag(`default`)
+	p, err := pc.getProcessor(`je`, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []*entry.Entry{
+		&entry.Entry{Data: []byte(`{"foo":"bar"}`)},
+		&entry.Entry{Data: []byte(`not json`)},
+		&entry.Entry{Data: []byte{0xff, 0xfe}},
+	}
+	set, err := p.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 3 {
+		t.Fatalf("misses were not passed on: %d", len(set))
+	}
+	unparsed, _ := tt.NegotiateTag(`unparsed`)
+	if set[0].Tag == unparsed || set[1].Tag != unparsed || set[2].Tag != unparsed {
+		t.Fatalf("bad tags: %d %d %d", set[0].Tag, set[1].Tag, set[2].Tag)
+	}
+	if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	if !bytes.Equal(b, posted) {
+		t.Fatalf("webhook and file differ:\n%s\n%s", posted, b)
+	}
+	recs := readDeadLetters(t, b)
+	if len(recs) != 2 {
+		t.Fatalf("bad dead letter count: %d", len(recs))
+	} else if recs[0].Data != `not json` || recs[0].Tag != `default` || recs[0].Preprocessor != JsonExtractProcessor || recs[0].Reason == `` {
+		t.Fatalf("bad record: %+v", recs[0])
+	} else if recs[1].Data != `` || !bytes.Equal(recs[1].DataBase64, []byte{0xff, 0xfe}) {
+		t.Fatalf("bad binary record: %+v", recs[1])
+	}
+}
+
+func TestDeadLetterDrop(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), `deadletter`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fpath := filepath.Join(dir, `dead.json`)
+
+	//without a tag misses are handled the way the preprocessor normally would
+	pc := loadDeadLetterTest(t, `
+	[preprocessor "re"]
+		type = regexextract
+		Regex = "(?P<a>\\d+)"
+		Template = "${a}"
+		Dead-Letter-File = "`+fpath+`"
+	`)
+	var tt testTagger
+	p, err := pc.getProcessor(`re`, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := p.Process([]*entry.Entry{
+		&entry.Entry{Data: []byte(`abc 123`)},
+		&entry.Entry{Data: []byte(`no numbers`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 1 || string(set[0].Data) != `123` {
+		t.Fatalf("bad set: %v", set)
+	} else if err = p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recs := readDeadLetters(t, b); len(recs) != 1 || recs[0].Data != `no numbers` {
+		t.Fatalf("bad dead letters: %s", b)
+	}
+}
+
+func TestDeadLetterConfig(t *testing.T) {
+	bad := []string{
+		`type = jsonextract
+		Extractions = foo
+		Dead-Letter-URL = "ftp://example.com"`,
+		`type = regexrouter
+		Regex = "(?P<a>\\d+)"
+		Route-Extraction = a
+		Route = 1:one
+		Dead-Letter-Header = "nocolon"`,
+		`type = regexextract
+		Regex = "(?P<a>\\d+)"
+		Template = "${a}"
+		Dead-Letter-Tag = "bad tag"`,
+	}
+	for i, v := range bad {
+		pc := loadDeadLetterTest(t, "[preprocessor \"p\"]\n"+v)
+		if err := pc.CheckConfig(`p`); err == nil {
+			t.Fatalf("failed to catch bad config %d", i)
+		}
+	}
+
+	//schema has its own reject tag
+	pc := loadDeadLetterTest(t, `
+	[preprocessor "s"]
+		type = schema
+		Field = a:string
+		Drop-Rejects = true
+		Dead-Letter-Tag = rejects
+	`)
+	var tt testTagger
+	if _, err := pc.getProcessor(`s`, &tt); err != ErrDeadLetterTagConflict {
+		t.Fatalf("failed to catch tag conflict: %v", err)
+	}
+}
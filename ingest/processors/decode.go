@@ -0,0 +1,321 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/golang/snappy"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+)
+
+const (
+	DecodeProcessor string = `decode`
+
+	DecodeAuto   = `auto`
+	DecodeBase64 = `base64`
+	DecodeGzip   = `gzip`
+	DecodeZlib   = `zlib`
+	DecodeSnappy = `snappy`
+
+	defaultDecodeMaxLayers = 4
+	maxDecodeLayers        = 16
+	defaultDecodeMaxOutput = 32 * mb
+	defaultDecodeMaxRatio  = 100
+	minAutoBase64          = 8
+)
+
+var (
+	ErrDecodeTooLarge       = errors.New("Decoded payload exceeds size limits")
+	ErrUnknownEncoding      = errors.New("Unknown encoding, must be auto, base64, gzip, zlib, or snappy")
+	ErrInvalidDecodeLayers  = fmt.Errorf("Max-Layers must be between 1 and %d", maxDecodeLayers)
+	ErrInvalidDecodeLimits  = errors.New("Max-Output-Size and Max-Ratio may not be negative")
+	ErrDecodeAutoNotAlone   = errors.New("auto encoding may not be combined with other encodings")
+	ErrPayloadNotEncoded    = errors.New("Payload does not match the expected encoding")
+	snappyFrameMagic        = []byte("\xff\x06\x00\x00sNaPpY")
+	base64Encodings         = []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding}
+	base64WhitespaceRemover = strings.NewReplacer("\r", "", "\n", "", " ", "", "\t", "")
+)
+
+// DecodeConfig controls the payload decoder.  Encodings are unwrapped in the order given,
+// the auto encoding detects and unwraps up to Max_Layers layers.
+type DecodeConfig struct {
+	Encoding                []string // ordered set of encodings to unwrap, default is auto
+	Max_Layers              int      // maximum number of layers unwrapped in auto mode
+	Max_Output_Size         int      // maximum decoded size in bytes
+	Max_Ratio               int      // maximum expansion ratio for a single decompression layer
+	Passthrough_Undecodable bool     // pass entries that fail to decode unmodified rather than dropping them
+}
+
+type Decoder struct {
+	nocloser
+	DecodeConfig
+	auto bool
+	bb   *bytes.Buffer
+}
+
+func DecodeLoadConfig(vc *config.VariableConfig) (c DecodeConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func NewDecoder(cfg DecodeConfig) (*Decoder, error) {
+	d := &Decoder{
+		bb: bytes.NewBuffer(nil),
+	}
+	if err := d.init(cfg); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Decoder) Config(v interface{}) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(DecodeConfig); ok {
+		err = d.init(cfg)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (c *DecodeConfig) validate() (err error) {
+	if len(c.Encoding) == 0 {
+		c.Encoding = []string{DecodeAuto}
+	}
+	for i, v := range c.Encoding {
+		v = strings.ToLower(strings.TrimSpace(v))
+		switch v {
+		case DecodeAuto:
+			if len(c.Encoding) != 1 {
+				return ErrDecodeAutoNotAlone
+			}
+		case DecodeBase64, DecodeGzip, DecodeZlib, DecodeSnappy:
+		default:
+			return fmt.Errorf("%w: %q", ErrUnknownEncoding, v)
+		}
+		c.Encoding[i] = v
+	}
+	if c.Max_Layers == 0 {
+		c.Max_Layers = defaultDecodeMaxLayers
+	}
+	if c.Max_Output_Size == 0 {
+		c.Max_Output_Size = defaultDecodeMaxOutput
+	}
+	if c.Max_Ratio == 0 {
+		c.Max_Ratio = defaultDecodeMaxRatio
+	}
+	if c.Max_Layers < 1 || c.Max_Layers > maxDecodeLayers {
+		err = ErrInvalidDecodeLayers
+	} else if c.Max_Output_Size < 0 || c.Max_Ratio < 0 {
+		err = ErrInvalidDecodeLimits
+	}
+	return
+}
+
+func (d *Decoder) init(cfg DecodeConfig) (err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	d.DecodeConfig = cfg
+	d.auto = len(cfg.Encoding) == 1 && cfg.Encoding[0] == DecodeAuto
+	return
+}
+
+func (d *Decoder) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	if len(ents) == 0 {
+		return nil, nil
+	}
+	rset := ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		if b, err := d.decode(ent.Data); err == nil {
+			ent.Data = b
+		} else if !d.Passthrough_Undecodable {
+			continue
+		}
+		rset = append(rset, ent)
+	}
+	return rset, nil
+}
+
+// decode unwraps the payload, returning the original buffer if no layers were removed
+func (d *Decoder) decode(b []byte) (r []byte, err error) {
+	r = b
+	if !d.auto {
+		for _, enc := range d.Encoding {
+			if r, err = d.unwrap(enc, r); err != nil {
+				return
+			}
+		}
+		return
+	}
+	for i := 0; i < d.Max_Layers; i++ {
+		enc := detectEncoding(r)
+		if enc == `` {
+			break
+		}
+		if r, err = d.unwrap(enc, r); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (d *Decoder) unwrap(enc string, b []byte) (r []byte, err error) {
+	switch enc {
+	case DecodeBase64:
+		r, err = decodeBase64(b)
+	case DecodeGzip:
+		var rdr io.ReadCloser
+		if rdr, err = gzip.NewReader(bytes.NewReader(b)); err == nil {
+			r, err = d.inflate(rdr, len(b))
+		}
+	case DecodeZlib:
+		var rdr io.ReadCloser
+		if rdr, err = zlib.NewReader(bytes.NewReader(b)); err == nil {
+			r, err = d.inflate(rdr, len(b))
+		}
+	case DecodeSnappy:
+		if bytes.HasPrefix(b, snappyFrameMagic) {
+			r, err = d.inflate(io.NopCloser(snappy.NewReader(bytes.NewReader(b))), len(b))
+			return
+		}
+		//raw snappy blocks carry their decoded length, check it before allocating anything
+		var sz int
+		if sz, err = snappy.DecodedLen(b); err != nil {
+			return
+		} else if sz > d.limit(len(b)) {
+			err = ErrDecodeTooLarge
+			return
+		}
+		r, err = snappy.Decode(nil, b)
+	default:
+		err = ErrUnknownEncoding
+	}
+	return
+}
+
+// limit returns the maximum allowed output for an input of sz bytes
+func (d *Decoder) limit(sz int) (lim int) {
+	lim = d.Max_Output_Size
+	if ratio := sz * d.Max_Ratio; ratio > 0 && ratio < lim {
+		lim = ratio
+	}
+	return
+}
+
+// inflate reads the decompressor until EOF, bailing out as soon as the limit is exceeded
+func (d *Decoder) inflate(rdr io.ReadCloser, sz int) (r []byte, err error) {
+	lim := d.limit(sz)
+	d.bb.Reset()
+	var n int64
+	if n, err = io.Copy(d.bb, io.LimitReader(rdr, int64(lim)+1)); err != nil {
+		rdr.Close()
+		return
+	} else if n > int64(lim) {
+		rdr.Close()
+		err = ErrDecodeTooLarge
+		return
+	}
+	if err = rdr.Close(); err == nil {
+		r = append(nb, d.bb.Bytes()...)
+	}
+	if d.bb.Cap() > defaultBaseBuff {
+		d.bb = bytes.NewBuffer(nil)
+	}
+	return
+}
+
+func decodeBase64(b []byte) (r []byte, err error) {
+	s := base64WhitespaceRemover.Replace(string(b))
+	if len(s) == 0 {
+		err = ErrPayloadNotEncoded
+		return
+	}
+	for _, enc := range base64Encodings {
+		if r, err = enc.DecodeString(s); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// detectEncoding attempts to identify the outer layer of a payload, an empty string means
+// the payload does not look encoded.  Raw snappy blocks have no header and are never detected.
+func detectEncoding(b []byte) string {
+	if len(b) > 2 && binary.LittleEndian.Uint16(b) == gzipMagic {
+		return DecodeGzip
+	} else if isZlib(b) {
+		return DecodeZlib
+	} else if bytes.HasPrefix(b, snappyFrameMagic) {
+		return DecodeSnappy
+	} else if looksBase64(b) {
+		return DecodeBase64
+	}
+	return ``
+}
+
+func isZlib(b []byte) bool {
+	//CMF must specify deflate with a window of at most 32K and FCHECK must validate
+	return len(b) > 2 && b[0]&0x0f == 8 && b[0]>>4 <= 7 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// looksBase64 checks that the payload is entirely base64 and that it decodes to something
+// either encoded further or printable, short plain text words are frequently valid base64.
+func looksBase64(b []byte) bool {
+	if len(b) < minAutoBase64 {
+		return false
+	}
+	for _, c := range b {
+		if !isBase64Char(c) {
+			return false
+		}
+	}
+	r, err := decodeBase64(b)
+	if err != nil || len(r) == 0 {
+		return false
+	}
+	if len(r) > 2 && binary.LittleEndian.Uint16(r) == gzipMagic || isZlib(r) || bytes.HasPrefix(r, snappyFrameMagic) {
+		return true
+	}
+	return isPrintable(r)
+}
+
+func isBase64Char(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+		c == '+' || c == '/' || c == '-' || c == '_' || c == '=' || c == '\r' || c == '\n'
+}
+
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,225 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+)
+
+const testDecodePayload = `{"event": "webhook", "user": "bob", "action": "login"}`
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	bb := bytes.NewBuffer(nil)
+	w := gzip.NewWriter(bb)
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	} else if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func zlibBytes(t *testing.T, b []byte) []byte {
+	bb := bytes.NewBuffer(nil)
+	w := zlib.NewWriter(bb)
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	} else if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func snappyFramed(t *testing.T, b []byte) []byte {
+	bb := bytes.NewBuffer(nil)
+	w := snappy.NewBufferedWriter(bb)
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	} else if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func b64(b []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(b))
+}
+
+func TestDecodeConfig(t *testing.T) {
+	b := []byte(`
+	[preprocessor "dec"]
+		type = decode
+		Encoding = base64
+		Encoding = GZIP
+		Max-Output-Size = 1048576
+		Passthrough-Undecodable = true
+	`)
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tt testTagger
+	p, err := tc.Preprocessor.getProcessor(`dec`, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := p.(*Decoder)
+	if !ok {
+		t.Fatalf("bad processor type: %T", p)
+	} else if d.auto || len(d.Encoding) != 2 || d.Encoding[1] != DecodeGzip {
+		t.Fatalf("bad encodings: %v", d.Encoding)
+	} else if d.Max_Output_Size != mb || d.Max_Ratio != defaultDecodeMaxRatio || d.Max_Layers != defaultDecodeMaxLayers {
+		t.Fatalf("bad limits: %+v", d.DecodeConfig)
+	}
+
+	bad := []DecodeConfig{
+		DecodeConfig{Encoding: []string{`rot13`}},
+		DecodeConfig{Encoding: []string{`auto`, `gzip`}},
+		DecodeConfig{Max_Layers: maxDecodeLayers + 1},
+		DecodeConfig{Max_Output_Size: -1},
+		DecodeConfig{Max_Ratio: -1},
+	}
+	for i, c := range bad {
+		if _, err := NewDecoder(c); err == nil {
+			t.Fatalf("failed to catch bad config %d: %+v", i, c)
+		}
+	}
+}
+
+func TestDecodeExplicit(t *testing.T) {
+	payload := []byte(testDecodePayload)
+	tests := []struct {
+		enc  []string
+		data []byte
+	}{
+		{enc: []string{DecodeBase64}, data: b64(payload)},
+		{enc: []string{DecodeGzip}, data: gzipBytes(t, payload)},
+		{enc: []string{DecodeZlib}, data: zlibBytes(t, payload)},
+		{enc: []string{DecodeSnappy}, data: snappy.Encode(nil, payload)},
+		{enc: []string{DecodeSnappy}, data: snappyFramed(t, payload)},
+		{enc: []string{DecodeBase64, DecodeGzip}, data: b64(gzipBytes(t, payload))},
+		{enc: []string{DecodeBase64, DecodeZlib, DecodeBase64}, data: b64(zlibBytes(t, b64(payload)))},
+	}
+	for i, tst := range tests {
+		d, err := NewDecoder(DecodeConfig{Encoding: tst.enc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		set, err := d.Process(makeEntry(tst.data, 0))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(set) != 1 {
+			t.Fatalf("%d bad set count: %d", i, len(set))
+		} else if string(set[0].Data) != testDecodePayload {
+			t.Fatalf("%d bad decode: %q", i, set[0].Data)
+		}
+		//an unencoded payload should be dropped
+		if set, err = d.Process(makeEntry([]byte(`not encoded!`), 0)); err != nil {
+			t.Fatal(err)
+		} else if len(set) != 0 {
+			t.Fatalf("%d failed to drop undecodable entry", i)
+		}
+	}
+}
+
+func TestDecodeAuto(t *testing.T) {
+	d, err := NewDecoder(DecodeConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(testDecodePayload)
+	for i, data := range [][]byte{
+		payload,
+		b64(payload),
+		gzipBytes(t, payload),
+		zlibBytes(t, payload),
+		snappyFramed(t, payload),
+		b64(gzipBytes(t, payload)),
+		gzipBytes(t, b64(zlibBytes(t, payload))),
+	} {
+		set, err := d.Process(makeEntry(data, 0))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(set) != 1 || string(set[0].Data) != testDecodePayload {
+			t.Fatalf("%d bad decode: %q", i, set[0].Data)
+		}
+	}
+	//plain words that happen to be valid base64 should be left alone
+	for _, v := range []string{`password`, `HelloWorld`, `abcd`} {
+		if set, err := d.Process(makeEntry([]byte(v), 0)); err != nil {
+			t.Fatal(err)
+		} else if len(set) != 1 || string(set[0].Data) != v {
+			t.Fatalf("modified plain payload %q", v)
+		}
+	}
+
+	//layers beyond the limit are left in place
+	d.Max_Layers = 1
+	set, err := d.Process(makeEntry(b64(b64(payload)), 0))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 1 || !bytes.Equal(set[0].Data, b64(payload)) {
+		t.Fatalf("bad layer limited decode: %q", set[0].Data)
+	}
+}
+
+func TestDecodeBombs(t *testing.T) {
+	bomb := bytes.Repeat([]byte{0}, 4*mb)
+	d, err := NewDecoder(DecodeConfig{Encoding: []string{DecodeGzip}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the default ratio catches this
+	if set, err := d.Process(makeEntry(gzipBytes(t, bomb), 0)); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 0 {
+		t.Fatal("failed to catch gzip bomb")
+	}
+
+	//raise the ratio and lower the size limit
+	if d, err = NewDecoder(DecodeConfig{Max_Ratio: 1000000, Max_Output_Size: mb}); err != nil {
+		t.Fatal(err)
+	}
+	for i, data := range [][]byte{gzipBytes(t, bomb), zlibBytes(t, bomb), snappyFramed(t, bomb), b64(gzipBytes(t, bomb))} {
+		if set, err := d.Process(makeEntry(data, 0)); err != nil {
+			t.Fatal(err)
+		} else if len(set) != 0 {
+			t.Fatalf("%d failed to catch bomb", i)
+		}
+	}
+	if _, err = d.decode(zlibBytes(t, bomb)); err != ErrDecodeTooLarge {
+		t.Fatalf("bad error: %v", err)
+	}
+	d.Encoding = []string{DecodeSnappy}
+	d.auto = false
+	if _, err = d.decode(snappy.Encode(nil, bomb)); err != ErrDecodeTooLarge {
+		t.Fatalf("bad raw snappy error: %v", err)
+	}
+
+	//passthrough keeps the original
+	d.Passthrough_Undecodable = true
+	orig := snappy.Encode(nil, bomb)
+	if set, err := d.Process(makeEntry(orig, 0)); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 1 || !bytes.Equal(set[0].Data, orig) {
+		t.Fatal("failed to pass undecodable entry")
+	}
+}
@@ -375,6 +375,10 @@ func (nfc *ForwarderConfig) Validate() (err error) {
 	case protoUDP:
 		fallthrough
 	case protoTLS:
+		if nfc.Protocol == protoTLS && nfc.Insecure_Skip_TLS_Verify && config.FIPSEnabled() {
+			err = config.ErrFIPSInsecureTLS
+			return
+		}
 		var h string
 		if h, _, err = net.SplitHostPort(nfc.Target); err != nil {
 			return
@@ -423,6 +427,7 @@ func (nfc *Forwarder) newConnection(retry bool) (conn net.Conn, err error) {
 			cfg := tls.Config{
 				InsecureSkipVerify: nfc.Insecure_Skip_TLS_Verify,
 			}
+			config.FIPSTLSConfig(&cfg) //no certificates, so nothing to reject
 			conn, err = tls.DialWithDialer(&d, `tcp`, nfc.Target, &cfg)
 		}
 		if err == context.Canceled {
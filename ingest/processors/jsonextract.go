@@ -52,6 +52,7 @@ type JsonExtractor struct {
 	nocloser
 	JsonExtractConfig
 	bldr builder
+	dl   *deadLetter
 }
 
 func NewJsonExtractor(cfg JsonExtractConfig) (*JsonExtractor, error) {
@@ -102,15 +103,18 @@ func (je *JsonExtractor) Process(ents []*entry.Entry) (rset []*entry.Entry, err
 func (je *JsonExtractor) processItem(ent *entry.Entry) *entry.Entry {
 	if err := je.bldr.extract(ent.Data); err != nil {
 		je.bldr.reset()
-		if je.Passthrough_Misses {
+		if je.dl.miss(ent, err.Error()) || je.Passthrough_Misses {
 			return ent
 		}
 		return nil
 	}
 	data, cnt := je.bldr.render()
 	if je.Strict_Extraction && cnt != len(je.bldr.keynames) {
+		if je.dl.miss(ent, `missing extractions`) {
+			return ent
+		}
 		return nil //just dropping the entry
-	} else if cnt == 0 && je.Passthrough_Misses {
+	} else if cnt == 0 && (je.dl.miss(ent, `no extractions`) || je.Passthrough_Misses) {
 		return ent
 	} else if len(data) > 0 {
 		ent.Data = data
@@ -118,6 +122,15 @@ func (je *JsonExtractor) processItem(ent *entry.Entry) *entry.Entry {
 	return ent
 }
 
+func (je *JsonExtractor) setDeadLetter(dl *deadLetter) error {
+	je.dl = dl
+	return nil
+}
+
+func (je *JsonExtractor) Close() error {
+	return je.dl.Close()
+}
+
 func (jec JsonExtractConfig) getKeyData() (keys [][]string, keynames []string, err error) {
 	if len(jec.Extractions) == 0 {
 		err = ErrMissingExtractions
@@ -0,0 +1,722 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	jsonTypeNull    = `null`
+	jsonTypeBoolean = `boolean`
+	jsonTypeObject  = `object`
+	jsonTypeArray   = `array`
+	jsonTypeNumber  = `number`
+	jsonTypeInteger = `integer`
+	jsonTypeString  = `string`
+
+	maxExactExponent = 400 // numbers with larger exponents are compared as float64
+)
+
+var (
+	ErrInvalidJSONSchema    = errors.New("Invalid JSON Schema")
+	ErrUnsupportedSchemaRef = errors.New("Only local $ref values such as #/$defs/name are supported")
+	ErrJSONSchemaViolation  = errors.New("JSON Schema violation")
+)
+
+// JSONSchema validates JSON documents against a JSON Schema.  The validation keywords shared by
+// draft-07 and 2020-12 are supported: type, enum, const, the numeric, string, array, and object
+// bounds, properties, patternProperties, additionalProperties, required, items, prefixItems,
+// allOf, anyOf, oneOf, not, and local $ref into $defs or definitions.  Annotations such as
+// format, title, and description are ignored, as are unknown keywords.
+type JSONSchema struct {
+	root *schemaNode
+}
+
+type schemaNode struct {
+	always *bool // set for the boolean schemas true and false
+
+	types    []string
+	enum     []interface{}
+	cnst     interface{}
+	hasConst bool
+
+	minimum, maximum         *big.Rat
+	exclMinimum, exclMaximum *big.Rat
+	multipleOf               *big.Rat
+
+	minLength, maxLength int
+	pattern              *regexp.Regexp
+
+	items       *schemaNode
+	prefixItems []*schemaNode
+	minItems    int
+	maxItems    int
+	uniqueItems bool
+
+	properties    map[string]*schemaNode
+	patternProps  []patternSchema
+	additional    *schemaNode
+	required      []string
+	minProperties int
+	maxProperties int
+
+	allOf, anyOf, oneOf []*schemaNode
+	not                 *schemaNode
+	ref                 *schemaNode
+}
+
+type patternSchema struct {
+	re *regexp.Regexp
+	s  *schemaNode
+}
+
+type schemaCompiler struct {
+	doc  interface{}
+	refs map[string]*schemaNode
+}
+
+// LoadJSONSchema reads and compiles a JSON Schema document from a file
+func LoadJSONSchema(pth string) (*JSONSchema, error) {
+	b, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	return CompileJSONSchema(b)
+}
+
+// CompileJSONSchema compiles a JSON Schema document
+func CompileJSONSchema(b []byte) (js *JSONSchema, err error) {
+	var doc interface{}
+	if doc, err = decodeJSONNumbers(b); err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalidJSONSchema, err)
+		return
+	}
+	sc := schemaCompiler{
+		doc:  doc,
+		refs: map[string]*schemaNode{},
+	}
+	var root *schemaNode
+	if root, err = sc.compileRef(`#`); err != nil {
+		return
+	}
+	js = &JSONSchema{root: root}
+	return
+}
+
+// Validate checks a JSON document against the schema, the returned error wraps ErrJSONSchemaViolation
+// and names the location of the first violation as a JSON pointer.
+func (js *JSONSchema) Validate(data []byte) error {
+	v, err := decodeJSONNumbers(data)
+	if err != nil {
+		return fmt.Errorf("%w: not valid JSON: %v", ErrJSONSchemaViolation, err)
+	}
+	if err = js.root.validate(v, ``); err != nil {
+		return fmt.Errorf("%w: %v", ErrJSONSchemaViolation, err)
+	}
+	return nil
+}
+
+func decodeJSONNumbers(b []byte) (v interface{}, err error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err = dec.Decode(&v); err != nil {
+		return
+	} else if _, lerr := dec.Token(); lerr != io.EOF {
+		err = errors.New("trailing data after JSON value")
+	}
+	return
+}
+
+// compileRef compiles the schema at a local reference, nodes are cached by reference so recursive schemas work
+func (sc *schemaCompiler) compileRef(ref string) (n *schemaNode, err error) {
+	if n = sc.refs[ref]; n != nil {
+		return
+	}
+	if ref != `#` && !strings.HasPrefix(ref, `#/`) {
+		err = fmt.Errorf("%w: %q", ErrUnsupportedSchemaRef, ref)
+		return
+	}
+	v := sc.doc
+	if ref != `#` {
+		for _, tok := range strings.Split(ref[2:], `/`) {
+			if tok, err = url.PathUnescape(tok); err != nil {
+				err = fmt.Errorf("%w: bad $ref %q", ErrInvalidJSONSchema, ref)
+				return
+			}
+			tok = strings.Replace(strings.Replace(tok, `~1`, `/`, -1), `~0`, `~`, -1)
+			switch vv := v.(type) {
+			case map[string]interface{}:
+				v = vv[tok]
+			case []interface{}:
+				idx, lerr := strconv.Atoi(tok)
+				if lerr != nil || idx < 0 || idx >= len(vv) {
+					v = nil
+				} else {
+					v = vv[idx]
+				}
+			default:
+				v = nil
+			}
+			if v == nil {
+				err = fmt.Errorf("%w: $ref %q not found", ErrInvalidJSONSchema, ref)
+				return
+			}
+		}
+	}
+	n = &schemaNode{}
+	sc.refs[ref] = n
+	err = sc.compileInto(n, v, ref)
+	return
+}
+
+func (sc *schemaCompiler) compile(v interface{}, loc string) (n *schemaNode, err error) {
+	n = &schemaNode{}
+	err = sc.compileInto(n, v, loc)
+	return
+}
+
+func (sc *schemaCompiler) compileInto(n *schemaNode, v interface{}, loc string) (err error) {
+	n.minLength, n.maxLength = -1, -1
+	n.minItems, n.maxItems = -1, -1
+	n.minProperties, n.maxProperties = -1, -1
+	switch vv := v.(type) {
+	case bool:
+		n.always = &vv
+		return
+	case map[string]interface{}:
+		return sc.compileObject(n, vv, loc)
+	}
+	return fmt.Errorf("%w: %s is not an object or boolean", ErrInvalidJSONSchema, loc)
+}
+
+func (sc *schemaCompiler) compileObject(n *schemaNode, m map[string]interface{}, loc string) (err error) {
+	bad := func(kw string) error {
+		return fmt.Errorf("%w: bad %s at %s", ErrInvalidJSONSchema, kw, loc)
+	}
+	if v, ok := m[`$ref`]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return bad(`$ref`)
+		} else if n.ref, err = sc.compileRef(s); err != nil {
+			return
+		}
+	}
+	if v, ok := m[`type`]; ok {
+		switch vv := v.(type) {
+		case string:
+			n.types = []string{vv}
+		case []interface{}:
+			for _, t := range vv {
+				s, ok := t.(string)
+				if !ok {
+					return bad(`type`)
+				}
+				n.types = append(n.types, s)
+			}
+		default:
+			return bad(`type`)
+		}
+		for _, t := range n.types {
+			switch t {
+			case jsonTypeNull, jsonTypeBoolean, jsonTypeObject, jsonTypeArray, jsonTypeNumber, jsonTypeInteger, jsonTypeString:
+			default:
+				return fmt.Errorf("%w: unknown type %q at %s", ErrInvalidJSONSchema, t, loc)
+			}
+		}
+	}
+	if v, ok := m[`enum`]; ok {
+		if n.enum, ok = v.([]interface{}); !ok {
+			return bad(`enum`)
+		}
+	}
+	if v, ok := m[`const`]; ok {
+		n.cnst, n.hasConst = v, true
+	}
+
+	//numeric bounds
+	for kw, dst := range map[string]**big.Rat{
+		`minimum`:          &n.minimum,
+		`maximum`:          &n.maximum,
+		`exclusiveMinimum`: &n.exclMinimum,
+		`exclusiveMaximum`: &n.exclMaximum,
+		`multipleOf`:       &n.multipleOf,
+	} {
+		v, ok := m[kw]
+		if !ok {
+			continue
+		}
+		num, ok := v.(json.Number)
+		if !ok {
+			return bad(kw)
+		} else if *dst, ok = jsonRat(num); !ok {
+			return bad(kw)
+		}
+	}
+	if n.multipleOf != nil && n.multipleOf.Sign() <= 0 {
+		return bad(`multipleOf`)
+	}
+
+	//counts
+	for kw, dst := range map[string]*int{
+		`minLength`:     &n.minLength,
+		`maxLength`:     &n.maxLength,
+		`minItems`:      &n.minItems,
+		`maxItems`:      &n.maxItems,
+		`minProperties`: &n.minProperties,
+		`maxProperties`: &n.maxProperties,
+	} {
+		v, ok := m[kw]
+		if !ok {
+			continue
+		}
+		num, ok := v.(json.Number)
+		if !ok {
+			return bad(kw)
+		}
+		i, lerr := num.Int64()
+		if lerr != nil || i < 0 || i > math.MaxInt32 {
+			return bad(kw)
+		}
+		*dst = int(i)
+	}
+
+	if v, ok := m[`pattern`]; ok {
+		s, ok := v.(string)
+		if !ok {
+			return bad(`pattern`)
+		} else if n.pattern, err = regexp.Compile(s); err != nil {
+			return fmt.Errorf("%w: bad pattern at %s: %v", ErrInvalidJSONSchema, loc, err)
+		}
+	}
+	if v, ok := m[`uniqueItems`]; ok {
+		if n.uniqueItems, ok = v.(bool); !ok {
+			return bad(`uniqueItems`)
+		}
+	}
+
+	//arrays, draft-07 tuple style items are treated as prefixItems
+	if v, ok := m[`prefixItems`]; ok {
+		if n.prefixItems, err = sc.compileList(v, loc+`/prefixItems`); err != nil {
+			return
+		}
+	}
+	if v, ok := m[`items`]; ok {
+		if list, isList := v.([]interface{}); isList {
+			if n.prefixItems, err = sc.compileList(list, loc+`/items`); err != nil {
+				return
+			}
+			if av, ok := m[`additionalItems`]; ok {
+				if n.items, err = sc.compile(av, loc+`/additionalItems`); err != nil {
+					return
+				}
+			}
+		} else if n.items, err = sc.compile(v, loc+`/items`); err != nil {
+			return
+		}
+	}
+
+	//objects
+	if v, ok := m[`properties`]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return bad(`properties`)
+		}
+		n.properties = make(map[string]*schemaNode, len(props))
+		for k, pv := range props {
+			if n.properties[k], err = sc.compile(pv, loc+`/properties/`+k); err != nil {
+				return
+			}
+		}
+	}
+	if v, ok := m[`patternProperties`]; ok {
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return bad(`patternProperties`)
+		}
+		for k, pv := range props {
+			ps := patternSchema{}
+			if ps.re, err = regexp.Compile(k); err != nil {
+				return fmt.Errorf("%w: bad patternProperties at %s: %v", ErrInvalidJSONSchema, loc, err)
+			} else if ps.s, err = sc.compile(pv, loc+`/patternProperties/`+k); err != nil {
+				return
+			}
+			n.patternProps = append(n.patternProps, ps)
+		}
+	}
+	if v, ok := m[`additionalProperties`]; ok {
+		if n.additional, err = sc.compile(v, loc+`/additionalProperties`); err != nil {
+			return
+		}
+	}
+	if v, ok := m[`required`]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return bad(`required`)
+		}
+		for _, r := range list {
+			s, ok := r.(string)
+			if !ok {
+				return bad(`required`)
+			}
+			n.required = append(n.required, s)
+		}
+	}
+
+	//combinations
+	if v, ok := m[`allOf`]; ok {
+		if n.allOf, err = sc.compileList(v, loc+`/allOf`); err != nil {
+			return
+		}
+	}
+	if v, ok := m[`anyOf`]; ok {
+		if n.anyOf, err = sc.compileList(v, loc+`/anyOf`); err != nil {
+			return
+		}
+	}
+	if v, ok := m[`oneOf`]; ok {
+		if n.oneOf, err = sc.compileList(v, loc+`/oneOf`); err != nil {
+			return
+		}
+	}
+	if v, ok := m[`not`]; ok {
+		if n.not, err = sc.compile(v, loc+`/not`); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (sc *schemaCompiler) compileList(v interface{}, loc string) (r []*schemaNode, err error) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		err = fmt.Errorf("%w: %s must be a non-empty array", ErrInvalidJSONSchema, loc)
+		return
+	}
+	for i, sv := range list {
+		var n *schemaNode
+		if n, err = sc.compile(sv, loc+`/`+strconv.Itoa(i)); err != nil {
+			return
+		}
+		r = append(r, n)
+	}
+	return
+}
+
+func (n *schemaNode) validate(v interface{}, ptr string) (err error) {
+	fail := func(format string, args ...interface{}) error {
+		p := ptr
+		if p == `` {
+			p = `/`
+		}
+		return fmt.Errorf("%s: %s", p, fmt.Sprintf(format, args...))
+	}
+	if n.always != nil {
+		if !*n.always {
+			return fail("no value is allowed")
+		}
+		return nil
+	}
+	if n.ref != nil {
+		if err = n.ref.validate(v, ptr); err != nil {
+			return
+		}
+	}
+	if len(n.types) > 0 {
+		var match bool
+		for _, t := range n.types {
+			if jsonTypeMatch(t, v) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return fail("expected %s, got %s", strings.Join(n.types, ` or `), jsonTypeOf(v))
+		}
+	}
+	if n.enum != nil {
+		var match bool
+		for _, e := range n.enum {
+			if jsonEqual(e, v) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return fail("value is not one of the allowed values")
+		}
+	}
+	if n.hasConst && !jsonEqual(n.cnst, v) {
+		return fail("value does not match the required constant")
+	}
+
+	switch vv := v.(type) {
+	case json.Number:
+		if err = n.validateNumber(vv); err != nil {
+			return fail("%v", err)
+		}
+	case string:
+		l := utf8.RuneCountInString(vv)
+		if n.minLength >= 0 && l < n.minLength {
+			return fail("string is shorter than %d characters", n.minLength)
+		} else if n.maxLength >= 0 && l > n.maxLength {
+			return fail("string is longer than %d characters", n.maxLength)
+		} else if n.pattern != nil && !n.pattern.MatchString(vv) {
+			return fail("string does not match pattern %q", n.pattern.String())
+		}
+	case []interface{}:
+		if err = n.validateArray(vv, ptr); err != nil {
+			return
+		}
+	case map[string]interface{}:
+		if err = n.validateObject(vv, ptr); err != nil {
+			return
+		}
+	}
+
+	for _, s := range n.allOf {
+		if err = s.validate(v, ptr); err != nil {
+			return
+		}
+	}
+	if len(n.anyOf) > 0 {
+		var match bool
+		for _, s := range n.anyOf {
+			if s.validate(v, ptr) == nil {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return fail("value does not match any of the anyOf schemas")
+		}
+	}
+	if len(n.oneOf) > 0 {
+		var cnt int
+		for _, s := range n.oneOf {
+			if s.validate(v, ptr) == nil {
+				cnt++
+			}
+		}
+		if cnt != 1 {
+			return fail("value matches %d of the oneOf schemas, expected exactly 1", cnt)
+		}
+	}
+	if n.not != nil && n.not.validate(v, ptr) == nil {
+		return fail("value matches a schema it must not")
+	}
+	return nil
+}
+
+func (n *schemaNode) validateNumber(num json.Number) error {
+	r, ok := jsonRat(num)
+	if !ok {
+		return fmt.Errorf("%s is out of range", num)
+	}
+	if n.minimum != nil && r.Cmp(n.minimum) < 0 {
+		return fmt.Errorf("%s is less than the minimum of %s", num, n.minimum.RatString())
+	} else if n.maximum != nil && r.Cmp(n.maximum) > 0 {
+		return fmt.Errorf("%s is greater than the maximum of %s", num, n.maximum.RatString())
+	} else if n.exclMinimum != nil && r.Cmp(n.exclMinimum) <= 0 {
+		return fmt.Errorf("%s must be greater than %s", num, n.exclMinimum.RatString())
+	} else if n.exclMaximum != nil && r.Cmp(n.exclMaximum) >= 0 {
+		return fmt.Errorf("%s must be less than %s", num, n.exclMaximum.RatString())
+	} else if n.multipleOf != nil && !new(big.Rat).Quo(r, n.multipleOf).IsInt() {
+		return fmt.Errorf("%s is not a multiple of %s", num, n.multipleOf.RatString())
+	}
+	return nil
+}
+
+func (n *schemaNode) validateArray(a []interface{}, ptr string) (err error) {
+	if n.minItems >= 0 && len(a) < n.minItems {
+		return fmt.Errorf("%s: array has fewer than %d items", pointerOrRoot(ptr), n.minItems)
+	} else if n.maxItems >= 0 && len(a) > n.maxItems {
+		return fmt.Errorf("%s: array has more than %d items", pointerOrRoot(ptr), n.maxItems)
+	}
+	for i, item := range a {
+		ip := ptr + `/` + strconv.Itoa(i)
+		if i < len(n.prefixItems) {
+			err = n.prefixItems[i].validate(item, ip)
+		} else if n.items != nil {
+			err = n.items.validate(item, ip)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if n.uniqueItems {
+		for i := range a {
+			for j := i + 1; j < len(a); j++ {
+				if jsonEqual(a[i], a[j]) {
+					return fmt.Errorf("%s: items %d and %d are not unique", pointerOrRoot(ptr), i, j)
+				}
+			}
+		}
+	}
+	return
+}
+
+func (n *schemaNode) validateObject(m map[string]interface{}, ptr string) (err error) {
+	if n.minProperties >= 0 && len(m) < n.minProperties {
+		return fmt.Errorf("%s: object has fewer than %d properties", pointerOrRoot(ptr), n.minProperties)
+	} else if n.maxProperties >= 0 && len(m) > n.maxProperties {
+		return fmt.Errorf("%s: object has more than %d properties", pointerOrRoot(ptr), n.maxProperties)
+	}
+	for _, r := range n.required {
+		if _, ok := m[r]; !ok {
+			return fmt.Errorf("%s: missing required property %q", pointerOrRoot(ptr), r)
+		}
+	}
+	//walk the keys in order so the reported violation is stable
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		kp := ptr + `/` + strings.Replace(strings.Replace(k, `~`, `~0`, -1), `/`, `~1`, -1)
+		var matched bool
+		if s, ok := n.properties[k]; ok {
+			matched = true
+			if err = s.validate(m[k], kp); err != nil {
+				return
+			}
+		}
+		for _, ps := range n.patternProps {
+			if ps.re.MatchString(k) {
+				matched = true
+				if err = ps.s.validate(m[k], kp); err != nil {
+					return
+				}
+			}
+		}
+		if !matched && n.additional != nil {
+			if n.additional.always != nil && !*n.additional.always {
+				return fmt.Errorf("%s: property is not allowed", kp)
+			} else if err = n.additional.validate(m[k], kp); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func pointerOrRoot(ptr string) string {
+	if ptr == `` {
+		return `/`
+	}
+	return ptr
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return jsonTypeNull
+	case bool:
+		return jsonTypeBoolean
+	case json.Number:
+		if isJSONInteger(vv) {
+			return jsonTypeInteger
+		}
+		return jsonTypeNumber
+	case string:
+		return jsonTypeString
+	case []interface{}:
+		return jsonTypeArray
+	case map[string]interface{}:
+		return jsonTypeObject
+	}
+	return `unknown`
+}
+
+func jsonTypeMatch(t string, v interface{}) bool {
+	vt := jsonTypeOf(v)
+	return t == vt || (t == jsonTypeNumber && vt == jsonTypeInteger)
+}
+
+// isJSONInteger reports whether a number has no fractional part, 1.0 is an integer
+func isJSONInteger(num json.Number) bool {
+	r, ok := jsonRat(num)
+	return ok && r.IsInt()
+}
+
+// jsonRat converts a number exactly, huge exponents go through float64 so hostile input can't
+// force enormous allocations.  Numbers beyond the range of a float64 fail.
+func jsonRat(num json.Number) (r *big.Rat, ok bool) {
+	s := num.String()
+	if idx := strings.IndexAny(s, `eE`); idx >= 0 {
+		exp, err := strconv.Atoi(strings.TrimPrefix(s[idx+1:], `+`))
+		if err != nil || exp > maxExactExponent || exp < -maxExactExponent {
+			f, _ := strconv.ParseFloat(s, 64)
+			if math.IsInf(f, 0) {
+				return nil, false
+			}
+			return new(big.Rat).SetFloat64(f), true
+		}
+	}
+	return new(big.Rat).SetString(s)
+}
+
+// jsonEqual compares decoded JSON values, numbers are equal if they have the same value
+func jsonEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv
+	case string:
+		bv, ok := b.(string)
+		return ok && av == bv
+	case json.Number:
+		bv, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		ar, aok := jsonRat(av)
+		br, bok := jsonRat(bv)
+		return aok && bok && ar.Cmp(br) == 0
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if bvv, ok := bv[k]; !ok || !jsonEqual(v, bvv) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testJSONSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["host", "level"],
+	"additionalProperties": false,
+	"properties": {
+		"host": {"type": "string", "minLength": 1, "maxLength": 16, "pattern": "^[a-z0-9.-]+$"},
+		"level": {"enum": ["info", "warn", "error"]},
+		"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+		"ratio": {"type": "number", "exclusiveMinimum": 0, "exclusiveMaximum": 1},
+		"step": {"type": "number", "multipleOf": 0.5},
+		"version": {"const": 2},
+		"tags": {"type": "array", "items": {"type": "string"}, "minItems": 1, "maxItems": 3, "uniqueItems": true},
+		"pair": {"type": "array", "prefixItems": [{"type": "string"}, {"type": "integer"}]},
+		"owner": {"$ref": "#/$defs/person"},
+		"id": {"anyOf": [{"type": "integer"}, {"type": "string", "format": "uuid"}]},
+		"kind": {"oneOf": [{"type": "string"}, {"type": "string", "maxLength": 1}]},
+		"note": {"not": {"type": "null"}},
+		"labels": {"type": "object", "patternProperties": {"^x-": {"type": "string"}}, "additionalProperties": false, "maxProperties": 2}
+	},
+	"$defs": {
+		"person": {
+			"type": "object",
+			"required": ["name"],
+			"properties": {
+				"name": {"type": "string"},
+				"manager": {"$ref": "#/$defs/person"}
+			}
+		}
+	}
+}`
+
+func TestJSONSchema(t *testing.T) {
+	js, err := CompileJSONSchema([]byte(testJSONSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := []string{
+		`{"host":"web1","level":"info"}`,
+		`{"host":"web1","level":"warn","port":443,"ratio":0.25,"step":1.5,"version":2.0}`,
+		`{"host":"web1","level":"error","tags":["a","b"],"pair":["x",1,true]}`,
+		`{"host":"web1","level":"info","owner":{"name":"a","manager":{"name":"b"}}}`,
+		`{"host":"web1","level":"info","id":5,"kind":"long","note":"x","labels":{"x-a":"1"}}`,
+		`{"host":"web1","level":"info","port":1e3}`,
+	}
+	for _, v := range good {
+		if err := js.Validate([]byte(v)); err != nil {
+			t.Fatalf("%s: %v", v, err)
+		}
+	}
+	bad := map[string]string{
+		`{"level":"info"}`:                                                 `missing required property "host"`,
+		`{"host":"web1","level":"debug"}`:                                  `/level: value is not one of`,
+		`{"host":"WEB1","level":"info"}`:                                   `/host: string does not match`,
+		`{"host":"","level":"info"}`:                                       `/host: string is shorter`,
+		`{"host":"web1","level":"info","port":"443"}`:                      `/port: expected integer, got string`,
+		`{"host":"web1","level":"info","port":44.5}`:                       `/port: expected integer, got number`,
+		`{"host":"web1","level":"info","port":70000}`:                      `/port: 70000 is greater than the maximum`,
+		`{"host":"web1","level":"info","ratio":1}`:                         `/ratio: 1 must be less than 1`,
+		`{"host":"web1","level":"info","step":0.3}`:                        `/step: 0.3 is not a multiple`,
+		`{"host":"web1","level":"info","version":3}`:                       `/version: value does not match`,
+		`{"host":"web1","level":"info","tags":[]}`:                         `/tags: array has fewer than 1`,
+		`{"host":"web1","level":"info","tags":["a","a"]}`:                  `/tags: items 0 and 1 are not unique`,
+		`{"host":"web1","level":"info","tags":["a",1]}`:                    `/tags/1: expected string`,
+		`{"host":"web1","level":"info","pair":[1,1]}`:                      `/pair/0: expected string`,
+		`{"host":"web1","level":"info","owner":{"manager":{}}}`:            `/owner: missing required property "name"`,
+		`{"host":"web1","level":"info","owner":{"name":"a","manager":{}}}`: `/owner/manager: missing required`,
+		`{"host":"web1","level":"info","id":true}`:                         `/id: value does not match any`,
+		`{"host":"web1","level":"info","kind":"x"}`:                        `/kind: value matches 2 of the oneOf`,
+		`{"host":"web1","level":"info","note":null}`:                       `/note: value matches a schema it must not`,
+		`{"host":"web1","level":"info","labels":{"y":"1"}}`:                `/labels/y: property is not allowed`,
+		`{"host":"web1","level":"info","extra":1}`:                         `/extra: property is not allowed`,
+		`{"host":"web1","level":"info","ratio":1e999999}`:                  `/ratio: 1e999999 is out of range`,
+		`[1,2]`:                             `/: expected object, got array`,
+		`{"host":"web1"`:                    `not valid JSON`,
+		`{"host":"web1","level":"info"} {}`: `not valid JSON`,
+	}
+	for v, exp := range bad {
+		err := js.Validate([]byte(v))
+		if !errors.Is(err, ErrJSONSchemaViolation) {
+			t.Fatalf("%s: violation not caught: %v", v, err)
+		} else if !strings.Contains(err.Error(), exp) {
+			t.Fatalf("%s: bad error %q, expected %q", v, err, exp)
+		}
+	}
+
+	//draft-07 style tuples and definitions
+	if js, err = CompileJSONSchema([]byte(`{"definitions":{"n":{"type":"number"}},"items":[{"$ref":"#/definitions/n"}],"additionalItems":false}`)); err != nil {
+		t.Fatal(err)
+	} else if err = js.Validate([]byte(`[1]`)); err != nil {
+		t.Fatal(err)
+	} else if err = js.Validate([]byte(`[1,2]`)); err == nil {
+		t.Fatal("additional item not caught")
+	} else if err = js.Validate([]byte(`["a"]`)); err == nil {
+		t.Fatal("bad tuple item not caught")
+	}
+	if js, err = CompileJSONSchema([]byte(`true`)); err != nil || js.Validate([]byte(`"anything"`)) != nil {
+		t.Fatalf("true schema failed: %v", err)
+	}
+
+	for _, v := range []string{
+		``,
+		`[]`,
+		`{"type":"thing"}`,
+		`{"minLength":-1}`,
+		`{"pattern":"("}`,
+		`{"multipleOf":0}`,
+		`{"anyOf":[]}`,
+		`{"$ref":"#/$defs/missing"}`,
+		`{"$ref":"https://example.com/schema.json"}`,
+		`{"properties":{"a":1}}`,
+	} {
+		if _, err := CompileJSONSchema([]byte(v)); err == nil {
+			t.Fatalf("bad schema %q not caught", v)
+		}
+	}
+}
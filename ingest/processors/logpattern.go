@@ -0,0 +1,334 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	LogPatternProcessor = `logpattern`
+
+	defaultPatternDepth        = 4
+	defaultPatternSimilarity   = 0.4
+	defaultPatternMaxChildren  = 100
+	defaultPatternMaxClusters  = 4096
+	defaultPatternName         = `pattern`
+	patternTemplateSuffix      = `_template`
+	patternWildcard            = `<*>`
+	minPatternDepth            = 3
+	maxPatternDepth            = 16
+	maxPatternClustersAllowed  = 1024 * 1024
+	maxPatternChildrenAllowed  = 64 * 1024
+	patternTemplateLengthLimit = 4096
+	maxPatternRootLength       = 256 //longer lines share a single token count root
+)
+
+var (
+	ErrInvalidPatternDepth      = fmt.Errorf("Depth must be between %d and %d", minPatternDepth, maxPatternDepth)
+	ErrInvalidPatternSimilarity = errors.New("Similarity-Threshold must be greater than 0 and less than or equal to 1")
+	ErrInvalidPatternChildren   = fmt.Errorf("Max-Children must be between 1 and %d", maxPatternChildrenAllowed)
+	ErrInvalidPatternClusters   = fmt.Errorf("Max-Clusters must be between 1 and %d", maxPatternClustersAllowed)
+)
+
+// LogPatternConfig controls the streaming template miner.  The miner uses a fixed depth
+// token tree (the Drain algorithm) so no vectors or embeddings are required.
+type LogPatternConfig struct {
+	Depth                int     // depth of the parse tree, including the root and token count layers
+	Similarity_Threshold float64 // minimum ratio of matching tokens to join an existing pattern
+	Max_Children         int     // maximum number of children per tree node
+	Max_Clusters         int     // maximum number of patterns tracked
+	Pattern_Name         string  // name of the pattern ID annotation
+	Include_Template     bool    // also attach the current template for the pattern
+	Novel_Tag            string  // optional tag for entries which created a new pattern
+	Annotation_Mode      string
+	Annotation_Field     string
+}
+
+type LogPattern struct {
+	nocloser
+	LogPatternConfig
+	sync.Mutex
+	ann      Annotator
+	novel    bool
+	novelTag entry.EntryTag
+	roots    map[int]*patternNode
+	count    int
+}
+
+type patternNode struct {
+	children map[string]*patternNode
+	clusters []*patternCluster
+}
+
+type patternCluster struct {
+	id     string
+	tokens []string
+}
+
+func LogPatternLoadConfig(vc *config.VariableConfig) (c LogPatternConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func NewLogPattern(cfg LogPatternConfig, tagger Tagger) (*LogPattern, error) {
+	lp := &LogPattern{}
+	if err := lp.init(cfg, tagger); err != nil {
+		return nil, err
+	}
+	return lp, nil
+}
+
+func (lp *LogPattern) Config(v interface{}, tagger Tagger) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(LogPatternConfig); ok {
+		err = lp.init(cfg, tagger)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (c *LogPatternConfig) validate() (err error) {
+	if c.Depth == 0 {
+		c.Depth = defaultPatternDepth
+	}
+	if c.Similarity_Threshold == 0 {
+		c.Similarity_Threshold = defaultPatternSimilarity
+	}
+	if c.Max_Children == 0 {
+		c.Max_Children = defaultPatternMaxChildren
+	}
+	if c.Max_Clusters == 0 {
+		c.Max_Clusters = defaultPatternMaxClusters
+	}
+	if c.Pattern_Name = strings.TrimSpace(c.Pattern_Name); c.Pattern_Name == `` {
+		c.Pattern_Name = defaultPatternName
+	}
+	c.Novel_Tag = strings.TrimSpace(c.Novel_Tag)
+	if c.Depth < minPatternDepth || c.Depth > maxPatternDepth {
+		err = ErrInvalidPatternDepth
+	} else if c.Similarity_Threshold <= 0 || c.Similarity_Threshold > 1 {
+		err = ErrInvalidPatternSimilarity
+	} else if c.Max_Children < 1 || c.Max_Children > maxPatternChildrenAllowed {
+		err = ErrInvalidPatternChildren
+	} else if c.Max_Clusters < 1 || c.Max_Clusters > maxPatternClustersAllowed {
+		err = ErrInvalidPatternClusters
+	} else if _, err = parseAnnotationMode(c.Annotation_Mode); err != nil {
+		return
+	} else if c.Novel_Tag != `` {
+		err = ingest.CheckTag(c.Novel_Tag)
+	}
+	return
+}
+
+func (lp *LogPattern) init(cfg LogPatternConfig, tagger Tagger) (err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	var ann Annotator
+	if ann, err = NewAnnotator(cfg.Annotation_Mode, cfg.Annotation_Field); err != nil {
+		return
+	}
+	lp.Lock()
+	defer lp.Unlock()
+	if cfg.Novel_Tag != `` {
+		if tagger == nil {
+			err = errors.New("A tagger is required to route novel patterns")
+			return
+		} else if lp.novelTag, err = tagger.NegotiateTag(cfg.Novel_Tag); err != nil {
+			err = fmt.Errorf("Failed to negotiate tag %s: %v", cfg.Novel_Tag, err)
+			return
+		}
+		lp.novel = true
+	}
+	lp.LogPatternConfig = cfg
+	lp.ann = ann
+	lp.roots = map[int]*patternNode{}
+	lp.count = 0
+	return
+}
+
+func (lp *LogPattern) Process(ents []*entry.Entry) (rset []*entry.Entry, err error) {
+	if len(ents) == 0 {
+		return
+	}
+	lp.Lock()
+	defer lp.Unlock()
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		} else if err = lp.processItem(ent); err != nil {
+			return
+		}
+		rset = append(rset, ent)
+	}
+	return
+}
+
+func (lp *LogPattern) processItem(ent *entry.Entry) (err error) {
+	tokens := patternTokenize(ent.Data)
+	if len(tokens) == 0 {
+		return
+	}
+	c, novel := lp.match(tokens)
+	if c == nil {
+		//the cluster table is full and nothing was close enough, leave it be
+		return
+	}
+	anns := []Annotation{Annotation{Name: lp.Pattern_Name, Value: c.id}}
+	if lp.Include_Template {
+		anns = append(anns, Annotation{Name: lp.Pattern_Name + patternTemplateSuffix, Value: c.template()})
+	}
+	if _, err = lp.ann.Annotate(ent, anns...); err != nil {
+		return
+	}
+	if novel && lp.novel {
+		ent.Tag = lp.novelTag
+	}
+	return
+}
+
+// Patterns returns the number of patterns currently tracked
+func (lp *LogPattern) Patterns() (r int) {
+	lp.Lock()
+	r = lp.count
+	lp.Unlock()
+	return
+}
+
+// match walks the parse tree and either merges the tokens into an existing cluster
+// or creates a new one.  Once the cluster table is full only existing nodes are walked,
+// so lines that cannot land in a cluster do not grow the tree.  The caller must hold the lock.
+func (lp *LogPattern) match(tokens []string) (c *patternCluster, novel bool) {
+	full := lp.count >= lp.Max_Clusters
+	sz := len(tokens)
+	if sz > maxPatternRootLength {
+		sz = maxPatternRootLength
+	}
+	root, ok := lp.roots[sz]
+	if !ok {
+		if full {
+			return
+		}
+		root = &patternNode{children: map[string]*patternNode{}}
+		lp.roots[sz] = root
+	}
+	leaf := root
+	for i := 0; i < (lp.Depth-2) && i < len(tokens); i++ {
+		if leaf = leaf.child(tokens[i], lp.Max_Children, !full); leaf == nil {
+			return
+		}
+	}
+
+	var best float64
+	for _, cand := range leaf.clusters {
+		if sim := cand.similarity(tokens); sim > best {
+			best = sim
+			c = cand
+		}
+	}
+	if c != nil && best >= lp.Similarity_Threshold {
+		c.merge(tokens)
+		return
+	}
+	if full {
+		c = nil
+		return
+	}
+	c = newPatternCluster(tokens)
+	leaf.clusters = append(leaf.clusters, c)
+	lp.count++
+	novel = true
+	return
+}
+
+// child returns the node for the token, nil is returned if there isn't one and create is not set
+func (pn *patternNode) child(tok string, max int, create bool) (r *patternNode) {
+	var ok bool
+	if r, ok = pn.children[tok]; ok {
+		return
+	}
+	if tok != patternWildcard && len(pn.children) >= max {
+		//out of room, everything else goes down the wildcard path
+		tok = patternWildcard
+		if r, ok = pn.children[tok]; ok {
+			return
+		}
+	}
+	if !create {
+		return
+	}
+	r = &patternNode{children: map[string]*patternNode{}}
+	pn.children[tok] = r
+	return
+}
+
+func newPatternCluster(tokens []string) *patternCluster {
+	c := &patternCluster{
+		tokens: append([]string(nil), tokens...),
+	}
+	h := fnv.New64a()
+	h.Write([]byte(c.template()))
+	c.id = fmt.Sprintf("%016x", h.Sum64())
+	return c
+}
+
+// similarity returns the ratio of non-wildcard tokens which exactly match
+func (c *patternCluster) similarity(tokens []string) float64 {
+	if len(tokens) != len(c.tokens) || len(tokens) == 0 {
+		return 0
+	}
+	var hits int
+	for i := range c.tokens {
+		if c.tokens[i] == patternWildcard || c.tokens[i] == tokens[i] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(tokens))
+}
+
+func (c *patternCluster) merge(tokens []string) {
+	for i := range c.tokens {
+		if c.tokens[i] != tokens[i] {
+			c.tokens[i] = patternWildcard
+		}
+	}
+}
+
+func (c *patternCluster) template() (r string) {
+	if r = strings.Join(c.tokens, " "); len(r) > patternTemplateLengthLimit {
+		r = r[:patternTemplateLengthLimit]
+	}
+	return
+}
+
+// patternTokenize splits on whitespace and masks any token containing a digit
+// since those are almost always variables (addresses, counters, timestamps, etc.)
+func patternTokenize(data []byte) (tokens []string) {
+	tokens = strings.Fields(string(data))
+	for i, tok := range tokens {
+		if strings.ContainsAny(tok, "0123456789") {
+			tokens[i] = patternWildcard
+		}
+	}
+	return
+}
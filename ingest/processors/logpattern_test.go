@@ -0,0 +1,250 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestLogPatternConfig(t *testing.T) {
+	b := []byte(`
+	[preprocessor "lp"]
+		type = logpattern
+		Depth = 5
+		Similarity-Threshold = 0.5
+		Pattern-Name = pid
+		Novel-Tag = novel
+	`)
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tt testTagger
+	p, err := tc.Preprocessor.getProcessor(`lp`, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp, ok := p.(*LogPattern)
+	if !ok {
+		t.Fatalf("bad processor type: %T", p)
+	} else if lp.Depth != 5 || lp.Similarity_Threshold != 0.5 || lp.Pattern_Name != `pid` {
+		t.Fatalf("bad config: %+v", lp.LogPatternConfig)
+	} else if lp.Max_Children != defaultPatternMaxChildren || lp.Max_Clusters != defaultPatternMaxClusters {
+		t.Fatalf("defaults not applied: %+v", lp.LogPatternConfig)
+	}
+	if _, ok := tt.mp[`novel`]; !ok {
+		t.Fatal("novel tag not negotiated")
+	}
+}
+
+func TestLogPatternBadConfig(t *testing.T) {
+	bad := []LogPatternConfig{
+		LogPatternConfig{Depth: 1},
+		LogPatternConfig{Similarity_Threshold: 1.5},
+		LogPatternConfig{Max_Children: -1},
+		LogPatternConfig{Max_Clusters: -1},
+		LogPatternConfig{Novel_Tag: `foo bar`},
+		LogPatternConfig{Annotation_Mode: `xml`},
+	}
+	var tt testTagger
+	for i, c := range bad {
+		if _, err := NewLogPattern(c, &tt); err == nil {
+			t.Fatalf("failed to catch bad config %d: %+v", i, c)
+		}
+	}
+}
+
+func TestLogPatternClustering(t *testing.T) {
+	var tt testTagger
+	lp, err := NewLogPattern(LogPatternConfig{Annotation_Mode: AnnotatePrefix}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]int{}
+	for _, v := range []string{
+		`login accepted for bob from 10.0.0.1`,
+		`login accepted for alice from 10.0.0.2`,
+		`login accepted for carol from 192.168.1.1`,
+		`disk sda1 is at 99 percent`,
+		`disk sdb2 is at 12 percent`,
+	} {
+		ent := &entry.Entry{Data: []byte(v)}
+		set, err := lp.Process([]*entry.Entry{ent})
+		if err != nil {
+			t.Fatal(err)
+		} else if len(set) != 1 {
+			t.Fatalf("bad set count: %d", len(set))
+		}
+		if !bytes.HasPrefix(set[0].Data, []byte(`pattern=`)) || !bytes.HasSuffix(set[0].Data, []byte(v)) {
+			t.Fatalf("bad annotation: %q", set[0].Data)
+		}
+		ids[string(set[0].Data[:len(`pattern=`)+16])]++
+	}
+	if len(ids) != 2 || lp.Patterns() != 2 {
+		t.Fatalf("bad pattern counts: %d %d", len(ids), lp.Patterns())
+	}
+}
+
+func TestLogPatternNovelTag(t *testing.T) {
+	var tt testTagger
+	if _, err := tt.NegotiateTag(`default`); err != nil {
+		t.Fatal(err)
+	}
+	lp, err := NewLogPattern(LogPatternConfig{Novel_Tag: `novel`, Include_Template: true}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	novel := tt.mp[`novel`]
+	ents := []*entry.Entry{
+		&entry.Entry{Data: []byte(`{"msg": "connection closed by 10.0.0.1"}`)},
+		&entry.Entry{Data: []byte(`{"msg": "connection closed by 10.0.0.2"}`)},
+	}
+	//process one at a time so that the second matches the first
+	for i, ent := range ents {
+		if _, err := lp.Process([]*entry.Entry{ent}); err != nil {
+			t.Fatal(i, err)
+		}
+	}
+	if ents[0].Tag != novel || ents[1].Tag == novel {
+		t.Fatalf("bad novel tagging: %d %d", ents[0].Tag, ents[1].Tag)
+	}
+	a, err := jsonparser.GetString(ents[0].Data, `pattern`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := jsonparser.GetString(ents[1].Data, `pattern`)
+	if err != nil {
+		t.Fatal(err)
+	} else if a != b || len(a) != 16 {
+		t.Fatalf("bad pattern IDs: %q %q", a, b)
+	}
+	if tmpl, err := jsonparser.GetString(ents[1].Data, `pattern_template`); err != nil {
+		t.Fatal(err)
+	} else if tmpl != `{"msg": "connection closed by <*>` {
+		t.Fatalf("bad template: %q", tmpl)
+	}
+}
+
+func TestLogPatternMaxClusters(t *testing.T) {
+	lp, err := NewLogPattern(LogPatternConfig{Max_Clusters: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []*entry.Entry{
+		&entry.Entry{Data: []byte(`alpha beta gamma`)},
+		&entry.Entry{Data: []byte(`delta epsilon zeta`)},
+	}
+	if _, err = lp.Process(ents); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(ents[0].Data, []byte(`pattern=`)) {
+		t.Fatalf("first entry not annotated: %q", ents[0].Data)
+	} else if string(ents[1].Data) != `delta epsilon zeta` {
+		t.Fatalf("entry annotated beyond cluster limit: %q", ents[1].Data)
+	}
+}
+
+func TestLogPatternBoundedTree(t *testing.T) {
+	lp, err := NewLogPattern(LogPatternConfig{Max_Clusters: 8}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//every line has a distinct token count and distinct leading tokens
+	line := func(i int) []byte {
+		var bb bytes.Buffer
+		for j := 0; j <= i; j++ {
+			fmt.Fprintf(&bb, "%c%c ", 'a'+(i+j)%26, 'a'+(i/26+j)%26)
+		}
+		return bb.Bytes()
+	}
+	var i int
+	for ; lp.Patterns() < lp.Max_Clusters; i++ {
+		if _, err = lp.Process([]*entry.Entry{&entry.Entry{Data: line(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roots, nodes := len(lp.roots), patternTreeSize(lp.roots)
+	for end := i + 2*maxPatternRootLength; i < end; i++ {
+		if _, err = lp.Process([]*entry.Entry{&entry.Entry{Data: line(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(lp.roots) != roots {
+		t.Fatalf("roots grew after the cluster table filled: %d != %d", len(lp.roots), roots)
+	} else if n := patternTreeSize(lp.roots); n != nodes {
+		t.Fatalf("tree grew after the cluster table filled: %d != %d", n, nodes)
+	}
+
+	//long lines share a root while there is still room
+	if lp, err = NewLogPattern(LogPatternConfig{Max_Clusters: 4 * maxPatternRootLength}, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i = 0; i < 2*maxPatternRootLength; i++ {
+		if _, err = lp.Process([]*entry.Entry{&entry.Entry{Data: line(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(lp.roots) != maxPatternRootLength {
+		t.Fatalf("bad root count: %d", len(lp.roots))
+	}
+}
+
+func patternTreeSize(roots map[int]*patternNode) (r int) {
+	var walk func(*patternNode)
+	walk = func(pn *patternNode) {
+		r++
+		for _, c := range pn.children {
+			walk(c)
+		}
+	}
+	for _, pn := range roots {
+		walk(pn)
+	}
+	return
+}
+
+func TestAnnotator(t *testing.T) {
+	if _, err := NewAnnotator(`bad`, ``); err == nil {
+		t.Fatal("failed to catch bad mode")
+	}
+	a, err := NewAnnotator(``, `meta`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ent := &entry.Entry{Data: []byte(`{"foo":"bar"}`)}
+	if ok, err := a.Annotate(ent, Annotation{Name: `a`, Value: `b c`}); err != nil || !ok {
+		t.Fatal(ok, err)
+	} else if v, err := jsonparser.GetString(ent.Data, `meta`, `a`); err != nil || v != `b c` {
+		t.Fatalf("bad JSON annotation %q: %v", ent.Data, err)
+	}
+	ent = &entry.Entry{Data: []byte(`plain text`)}
+	if ok, err := a.Annotate(ent, Annotation{Name: `a`, Value: `b c`}, Annotation{Name: `x`, Value: `y`}); err != nil || !ok {
+		t.Fatal(ok, err)
+	} else if string(ent.Data) != `a="b c" x=y plain text` {
+		t.Fatalf("bad prefix annotation: %q", ent.Data)
+	}
+	a.Mode = AnnotateJSON
+	if ok, err := a.Annotate(ent, Annotation{Name: `a`, Value: `b`}); err != nil || ok {
+		t.Fatal("annotated non-JSON in json mode", ok, err)
+	}
+	if _, err := a.Annotate(ent, Annotation{}); err != ErrEmptyAnnotationName {
+		t.Fatal("failed to catch empty name", err)
+	}
+}
@@ -19,14 +19,20 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
 )
 
 const (
 	preProcSectName string = `preprocessor`
 	preProcTypeName string = `type`
+
+	// DefaultBypassWindow is how long optional processors are skipped once the latency budget is blown
+	DefaultBypassWindow = 30 * time.Second
 )
 
 var (
@@ -40,9 +46,19 @@ var (
 )
 
 type ProcessorSet struct {
+	bypassed uint64 // entries that skipped optional processors, accessed atomically
 	sync.Mutex
-	wtr entWriter
-	set []Processor
+	wtr      entWriter
+	set      []Processor
+	optional []bool
+	budget   time.Duration // per entry latency budget, zero disables bypassing
+	window   time.Duration
+	until    time.Time // optional processors are bypassed until this time
+	bypass   bool      // optional processors are being skipped for the current call
+	spent    time.Duration
+	done     chan struct{} // stops the expire routines
+	wg       sync.WaitGroup
+	expErr   error // write errors from the expire routines, handed back by Flush and Close
 }
 
 type ProcessorConfig map[string]*config.VariableConfig
@@ -54,6 +70,14 @@ type Processor interface {
 	Close() error //give the processor a chance to tidy up
 }
 
+// expiringProcessor is implemented by processors that hold entries until a deadline, such as the
+// rollup windows.  The ProcessorSet calls Expire every ExpireInterval so held entries are still
+// released when no new entries arrive.
+type expiringProcessor interface {
+	ExpireInterval() time.Duration
+	Expire() []*entry.Entry
+}
+
 func CheckProcessor(id string) error {
 	id = strings.TrimSpace(strings.ToLower(id))
 	switch id {
@@ -71,6 +95,14 @@ func CheckProcessor(id string) error {
 	case CiscoISEProcessor:
 	case SrcRouterProcessor:
 	case PluginProcessor:
+	case LogPatternProcessor:
+	case ThreatIntelProcessor:
+	case DecodeProcessor:
+	case RollupProcessor:
+	case SchemaProcessor:
+	case ArchiveProcessor:
+	case SignProcessor:
+	case AttachProcessor:
 	default:
 		return checkProcessorOS(id)
 	}
@@ -91,7 +123,8 @@ type entWriter interface {
 }
 
 type preprocessorBase struct {
-	Type string
+	Type     string
+	Optional bool // may be bypassed when the set is over its latency budget
 }
 
 func ProcessorLoadConfig(vc *config.VariableConfig) (cfg interface{}, err error) {
@@ -128,9 +161,31 @@ func ProcessorLoadConfig(vc *config.VariableConfig) (cfg interface{}, err error)
 		cfg, err = SrcRouteLoadConfig(vc)
 	case PluginProcessor:
 		cfg, err = PluginLoadConfig(vc)
+	case LogPatternProcessor:
+		cfg, err = LogPatternLoadConfig(vc)
+	case ThreatIntelProcessor:
+		cfg, err = ThreatIntelLoadConfig(vc)
+	case DecodeProcessor:
+		cfg, err = DecodeLoadConfig(vc)
+	case RollupProcessor:
+		cfg, err = RollupLoadConfig(vc)
+	case SchemaProcessor:
+		cfg, err = SchemaLoadConfig(vc)
+	case ArchiveProcessor:
+		cfg, err = ArchiveLoadConfig(vc)
+	case SignProcessor:
+		cfg, err = SignLoadConfig(vc)
+	case AttachProcessor:
+		cfg, err = AttachLoadConfig(vc)
 	default:
 		cfg, err = processorLoadConfigOS(vc)
 	}
+	if err == nil {
+		switch strings.TrimSpace(strings.ToLower(pb.Type)) {
+		case JsonExtractProcessor, RegexExtractProcessor, RegexRouterProcessor, SchemaProcessor:
+			_, err = DeadLetterLoadConfig(vc)
+		}
+	}
 	return
 }
 
@@ -261,9 +316,65 @@ func newProcessor(vc *config.VariableConfig, tgr Tagger) (p Processor, err error
 			p, err = NewPluginProcessor(cfg, tgr)
 		}
 		return
+	case LogPatternProcessor:
+		var cfg LogPatternConfig
+		if err = vc.MapTo(&cfg); err != nil {
+			return
+		}
+		p, err = NewLogPattern(cfg, tgr)
+	case ThreatIntelProcessor:
+		var cfg ThreatIntelConfig
+		if err = vc.MapTo(&cfg); err != nil {
+			return
+		}
+		p, err = NewThreatIntel(cfg, tgr)
+	case DecodeProcessor:
+		var cfg DecodeConfig
+		if err = vc.MapTo(&cfg); err != nil {
+			return
+		}
+		p, err = NewDecoder(cfg)
+	case RollupProcessor:
+		var cfg RollupConfig
+		if err = vc.MapTo(&cfg); err != nil {
+			return
+		}
+		p, err = NewRollup(cfg, tgr)
+	case SchemaProcessor:
+		var cfg SchemaConfig
+		if err = vc.MapTo(&cfg); err != nil {
+			return
+		}
+		p, err = NewSchema(cfg, tgr)
+	case ArchiveProcessor:
+		var cfg ArchiveConfig
+		if err = vc.MapTo(&cfg); err != nil {
+			return
+		}
+		p, err = NewArchive(cfg, tgr)
+	case SignProcessor:
+		var cfg SignConfig
+		if err = vc.MapTo(&cfg); err != nil {
+			return
+		}
+		p, err = NewSigner(cfg, tgr)
+	case AttachProcessor:
+		var cfg AttachConfig
+		if err = vc.MapTo(&cfg); err != nil {
+			return
+		}
+		p, err = NewAttach(cfg)
 	default:
 		p, err = newProcessorOS(vc, tgr)
 	}
+	if err == nil {
+		if dp, ok := p.(deadLetterProcessor); ok {
+			if err = attachDeadLetter(dp, id, vc, tgr); err != nil {
+				p.Close()
+				p = nil
+			}
+		}
+	}
 	return
 }
 
@@ -280,9 +391,106 @@ func (pr *ProcessorSet) Enabled() bool {
 }
 
 func (pr *ProcessorSet) AddProcessor(p Processor) {
+	pr.addProcessor(p, false)
+}
+
+// AddOptionalProcessor adds a processor that is skipped while the set is over its latency budget
+func (pr *ProcessorSet) AddOptionalProcessor(p Processor) {
+	pr.addProcessor(p, true)
+}
+
+func (pr *ProcessorSet) addProcessor(p Processor, optional bool) {
 	pr.Lock()
 	defer pr.Unlock()
 	pr.set = append(pr.set, p)
+	pr.optional = append(pr.optional, optional)
+	if ep, ok := p.(expiringProcessor); ok {
+		if d := ep.ExpireInterval(); d > 0 {
+			if pr.done == nil {
+				pr.done = make(chan struct{})
+			}
+			pr.wg.Add(1)
+			go pr.expireRoutine(len(pr.set)-1, ep, d)
+		}
+	}
+}
+
+// expireRoutine periodically pushes the entries a processor releases through the rest of the set
+func (pr *ProcessorSet) expireRoutine(i int, ep expiringProcessor, d time.Duration) {
+	defer pr.wg.Done()
+	tckr := time.NewTicker(d)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-pr.done:
+			return
+		case <-tckr.C:
+		}
+		pr.Lock()
+		if ents := ep.Expire(); len(ents) > 0 && pr.wtr != nil {
+			if err := pr.processItems(ents, i+1); err != nil {
+				pr.expErr = addError(err, pr.expErr)
+			}
+		}
+		pr.Unlock()
+	}
+}
+
+// SetLatencyBudget sets the maximum average time the processors may spend on each entry.
+// When a call goes over budget any optional processors are bypassed for the window so that a
+// slow processor, such as one waiting on an enrichment backend, cannot stall ingest.
+// A zero budget disables bypassing, a zero window uses DefaultBypassWindow.
+func (pr *ProcessorSet) SetLatencyBudget(budget, window time.Duration) {
+	if window <= 0 {
+		window = DefaultBypassWindow
+	}
+	pr.Lock()
+	pr.budget, pr.window = budget, window
+	pr.Unlock()
+}
+
+// BypassedEntries returns the number of entries that skipped optional processors
+func (pr *ProcessorSet) BypassedEntries() uint64 {
+	return atomic.LoadUint64(&pr.bypassed)
+}
+
+// Bypassing returns true if optional processors are currently being skipped
+func (pr *ProcessorSet) Bypassing() bool {
+	pr.Lock()
+	defer pr.Unlock()
+	return pr.budget > 0 && time.Now().Before(pr.until)
+}
+
+// startCall decides if optional processors are skipped for this call, the lock must be held
+func (pr *ProcessorSet) startCall(cnt int) {
+	pr.spent = 0
+	if pr.bypass = pr.budget > 0 && time.Now().Before(pr.until); pr.bypass {
+		for _, opt := range pr.optional {
+			if opt {
+				atomic.AddUint64(&pr.bypassed, uint64(cnt))
+				break
+			}
+		}
+	}
+}
+
+// endCall checks the time spent in the processors against the budget, the lock must be held
+func (pr *ProcessorSet) endCall(cnt int) {
+	if pr.budget > 0 && !pr.bypass && cnt > 0 && pr.spent/time.Duration(cnt) > pr.budget {
+		pr.until = time.Now().Add(pr.window)
+	}
+	pr.bypass = false
+}
+
+// run hands a single processor the entries, timing it when there is a latency budget
+func (pr *ProcessorSet) run(i int, ents []*entry.Entry) (set []*entry.Entry, err error) {
+	if pr.budget <= 0 {
+		return pr.set[i].Process(ents)
+	}
+	start := time.Now()
+	set, err = pr.set[i].Process(ents)
+	pr.spent += time.Since(start)
+	return
 }
 
 func (pr *ProcessorSet) Process(ent *entry.Entry) (err error) {
@@ -296,7 +504,9 @@ func (pr *ProcessorSet) Process(ent *entry.Entry) (err error) {
 		err = pr.wtr.WriteEntry(ent)
 	} else {
 		//we have processors, start recursing into them
+		pr.startCall(1)
 		err = pr.processItems([]*entry.Entry{ent}, 0)
+		pr.endCall(1)
 	}
 	pr.Unlock()
 	return
@@ -313,7 +523,9 @@ func (pr *ProcessorSet) ProcessBatch(ents []*entry.Entry) (err error) {
 		err = pr.wtr.WriteBatch(ents)
 	} else {
 		//we have processors, start recursing into them
+		pr.startCall(len(ents))
 		err = pr.processItems(ents, 0)
+		pr.endCall(len(ents))
 	}
 	pr.Unlock()
 	return
@@ -330,7 +542,12 @@ func (pr *ProcessorSet) ProcessContext(ent *entry.Entry, ctx context.Context) (e
 		err = pr.wtr.WriteEntryContext(ctx, ent)
 	} else {
 		//we have processors, start recursing into them
+		pr.startCall(1)
+		span := pr.traceSpan(ctx, 1)
 		err = pr.processItemsContext([]*entry.Entry{ent}, 0, ctx)
+		span.SetError(err)
+		span.End()
+		pr.endCall(1)
 	}
 	pr.Unlock()
 	return
@@ -347,19 +564,37 @@ func (pr *ProcessorSet) ProcessBatchContext(ents []*entry.Entry, ctx context.Con
 		err = pr.wtr.WriteBatchContext(ctx, ents)
 	} else {
 		//we have processors, start recursing into them
+		pr.startCall(len(ents))
+		span := pr.traceSpan(ctx, len(ents))
 		err = pr.processItemsContext(ents, 0, ctx)
+		span.SetError(err)
+		span.End()
+		pr.endCall(len(ents))
 	}
 	pr.Unlock()
 	return
 }
 
+// traceSpan starts a preprocess span if the caller is tracing, the span covers the preprocessors
+// and handing their output to the muxer.  The muxer's spans are siblings, not children.
+func (pr *ProcessorSet) traceSpan(ctx context.Context, cnt int) *tracing.Span {
+	parent := tracing.SpanFromContext(ctx)
+	if parent == nil {
+		return nil
+	}
+	return parent.Child(`preprocess`, tracing.KindInternal,
+		tracing.Int(`processors`, int64(len(pr.set))), tracing.Int(`entries`, int64(cnt)))
+}
+
 // processItem recurses into each processor generating entries and writing them out
 func (pr *ProcessorSet) processItems(ents []*entry.Entry, i int) error {
 	if i >= len(pr.set) {
 		//we are at the end of the line, just write the entry
 		return pr.wtr.WriteBatch(ents)
+	} else if pr.bypass && pr.optional[i] {
+		return pr.processItems(ents, i+1)
 	}
-	if set, err := pr.set[i].Process(ents); err != nil {
+	if set, err := pr.run(i, ents); err != nil {
 		return err
 	} else {
 		if err := pr.processItems(set, i+1); err != nil {
@@ -374,8 +609,10 @@ func (pr *ProcessorSet) processItemsContext(ents []*entry.Entry, i int, ctx cont
 	if i >= len(pr.set) {
 		//we are at the end of the line, just write the entry
 		return pr.wtr.WriteBatchContext(ctx, ents)
+	} else if pr.bypass && pr.optional[i] {
+		return pr.processItemsContext(ents, i+1, ctx)
 	}
-	if set, err := pr.set[i].Process(ents); err != nil {
+	if set, err := pr.run(i, ents); err != nil {
 		return err
 	} else {
 		if err := pr.processItemsContext(set, i+1, ctx); err != nil {
@@ -385,10 +622,34 @@ func (pr *ProcessorSet) processItemsContext(ents []*entry.Entry, i int, ctx cont
 	return nil
 }
 
+// Flush pushes anything held by the preprocessors, such as partial rollups, through the rest of
+// the set and out to the writer.  The preprocessors are not closed and the set may still be used.
+func (pr *ProcessorSet) Flush() (err error) {
+	pr.Lock()
+	defer pr.Unlock()
+	err, pr.expErr = pr.expErr, nil
+	for i, v := range pr.set {
+		if v != nil {
+			if ents := v.Flush(); len(ents) > 0 {
+				if lerr := pr.processItems(ents, i+1); lerr != nil {
+					err = addError(lerr, err)
+				}
+			}
+		}
+	}
+	return
+}
+
 // Close will close the underlying preprocessors within the set.
 // This function DOES NOT close the ingest muxer handle.
 // It is ONLY for shutting down preprocessors
 func (pr *ProcessorSet) Close() (err error) {
+	if pr.done != nil {
+		close(pr.done)
+		pr.wg.Wait()
+		pr.done = nil
+	}
+	err, pr.expErr = pr.expErr, nil
 	for i, v := range pr.set {
 		if v != nil {
 			if ents := v.Flush(); len(ents) > 0 {
@@ -430,7 +691,14 @@ func (pc ProcessorConfig) ProcessorSet(t tagWriter, names []string) (pr *Process
 			err = fmt.Errorf("%s %v", n, err)
 			return
 		}
-		pr.AddProcessor(p)
+		var pb preprocessorBase
+		if err = pc[n].MapTo(&pb); err != nil {
+			return
+		} else if pb.Optional {
+			pr.AddOptionalProcessor(p)
+		} else {
+			pr.AddProcessor(p)
+		}
 	}
 	return
 }
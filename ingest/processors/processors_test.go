@@ -17,6 +17,7 @@ import (
 	"net"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
@@ -158,6 +159,94 @@ func TestMultiProcessorSet(t *testing.T) {
 	return
 }
 
+type slowProcessor struct {
+	delay time.Duration
+	cnt   int
+}
+
+func (sp *slowProcessor) Close() error {
+	return nil
+}
+
+func (sp *slowProcessor) Flush() []*entry.Entry {
+	return nil
+}
+
+func (sp *slowProcessor) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	sp.cnt += len(ents)
+	time.Sleep(sp.delay)
+	return ents, nil
+}
+
+func TestLatencyBypass(t *testing.T) {
+	var tw testWriter
+	ps := NewProcessorSet(&tw)
+	required := &slowProcessor{}
+	optional := &slowProcessor{delay: 20 * time.Millisecond}
+	ps.AddProcessor(required)
+	ps.AddOptionalProcessor(optional)
+	ps.SetLatencyBudget(5*time.Millisecond, time.Hour)
+
+	//first entry goes through everything and blows the budget
+	if err := ps.Process(makeEntry([]byte("test"), 0)[0]); err != nil {
+		t.Fatal(err)
+	} else if optional.cnt != 1 || !ps.Bypassing() {
+		t.Fatalf("budget overrun was not caught: %d %v", optional.cnt, ps.Bypassing())
+	}
+
+	//now the optional processor is skipped but the required one is not
+	if err := ps.ProcessBatch(makeEntry([]byte("test"), 0)); err != nil {
+		t.Fatal(err)
+	} else if err = ps.ProcessContext(makeEntry([]byte("test"), 0)[0], context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if optional.cnt != 1 || required.cnt != 3 || len(tw.ents) != 3 {
+		t.Fatalf("bad counts: optional %d required %d written %d", optional.cnt, required.cnt, len(tw.ents))
+	} else if ps.BypassedEntries() != 2 {
+		t.Fatalf("bad bypass count: %d", ps.BypassedEntries())
+	}
+
+	//once the window passes the optional processor is tried again
+	ps.SetLatencyBudget(time.Second, time.Hour)
+	ps.Lock()
+	ps.until = time.Time{}
+	ps.Unlock()
+	if err := ps.Process(makeEntry([]byte("test"), 0)[0]); err != nil {
+		t.Fatal(err)
+	} else if optional.cnt != 2 || ps.Bypassing() {
+		t.Fatalf("optional processor was not restored: %d %v", optional.cnt, ps.Bypassing())
+	}
+}
+
+func TestOptionalProcessorConfig(t *testing.T) {
+	b := []byte(`
+	[preprocessor "a"]
+		type = gzip
+		Optional=true
+	[preprocessor "b"]
+		type = gzip
+	`)
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	tw := struct {
+		*testWriter
+		*testTagger
+	}{&testWriter{}, &testTagger{mp: map[string]entry.EntryTag{}}}
+	ps, err := tc.Preprocessor.ProcessorSet(tw, []string{`a`, `b`})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ps.optional) != 2 || !ps.optional[0] || ps.optional[1] {
+		t.Fatalf("bad optional flags: %v", ps.optional)
+	}
+}
+
 func gzipCompressVal(x string) (r []byte, err error) {
 	bwtr := bytes.NewBuffer(nil)
 	gzw := gzip.NewWriter(bwtr)
@@ -454,3 +543,111 @@ func makeEntry(v []byte, tag entry.EntryTag) []*entry.Entry {
 		},
 	}
 }
+
+// holdingProcessor keeps everything until it is flushed, like an aggregator
+type holdingProcessor struct {
+	held   []*entry.Entry
+	closed bool
+}
+
+func (hp *holdingProcessor) Close() error {
+	hp.closed = true
+	return nil
+}
+
+func (hp *holdingProcessor) Flush() (ents []*entry.Entry) {
+	ents, hp.held = hp.held, nil
+	return
+}
+
+func (hp *holdingProcessor) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	hp.held = append(hp.held, ents...)
+	return nil, nil
+}
+
+// heldWriter accepts the empty batches a holding processor passes down
+type heldWriter struct {
+	testWriter
+}
+
+func (hw *heldWriter) WriteBatch(ents []*entry.Entry) error {
+	if len(ents) == 0 {
+		return nil
+	}
+	return hw.testWriter.WriteBatch(ents)
+}
+
+func TestProcessorSetFlush(t *testing.T) {
+	var tw heldWriter
+	ps := NewProcessorSet(&tw)
+	hp := &holdingProcessor{}
+	after := &slowProcessor{}
+	ps.AddProcessor(hp)
+	ps.AddProcessor(after)
+	if err := ps.ProcessBatch(makeEntry([]byte("test"), 0)); err != nil {
+		t.Fatal(err)
+	} else if len(tw.ents) != 0 {
+		t.Fatal("entry was not held")
+	}
+	//flushed entries go through the rest of the set and the set stays usable
+	if err := ps.Flush(); err != nil {
+		t.Fatal(err)
+	} else if len(tw.ents) != 1 || after.cnt != 1 || hp.closed {
+		t.Fatalf("bad flush: %d %d %v", len(tw.ents), after.cnt, hp.closed)
+	} else if err = ps.ProcessBatch(makeEntry([]byte("test"), 0)); err != nil {
+		t.Fatal(err)
+	} else if err = ps.Close(); err != nil {
+		t.Fatal(err)
+	} else if len(tw.ents) != 2 || !hp.closed {
+		t.Fatalf("bad close: %d %v", len(tw.ents), hp.closed)
+	}
+}
+
+// expiringHolder releases what it holds whenever it is expired
+type expiringHolder struct {
+	holdingProcessor
+	expires int
+}
+
+func (eh *expiringHolder) ExpireInterval() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func (eh *expiringHolder) Expire() []*entry.Entry {
+	eh.expires++
+	return eh.Flush()
+}
+
+func TestProcessorSetExpire(t *testing.T) {
+	var tw heldWriter
+	ps := NewProcessorSet(&tw)
+	eh := &expiringHolder{}
+	after := &slowProcessor{}
+	ps.AddProcessor(eh)
+	ps.AddProcessor(after)
+	if err := ps.ProcessBatch(makeEntry([]byte("test"), 0)); err != nil {
+		t.Fatal(err)
+	}
+	//the held entry goes out with no more writes or flushes
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ps.Lock()
+		cnt, acnt := len(tw.ents), after.cnt
+		ps.Unlock()
+		if cnt == 1 && acnt == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("held entry was never expired: %d %d", cnt, acnt)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := ps.Close(); err != nil {
+		t.Fatal(err)
+	}
+	//the expire routine is stopped by Close
+	expires := eh.expires
+	time.Sleep(50 * time.Millisecond)
+	if eh.expires != expires || !eh.closed {
+		t.Fatalf("expire routine still running after close: %d != %d", eh.expires, expires)
+	}
+}
@@ -44,6 +44,7 @@ type RegexExtractor struct {
 	tmp *formatter
 	rx  *regexp.Regexp
 	cnt int
+	dl  *deadLetter
 }
 
 func NewRegexExtractor(cfg RegexExtractConfig) (*RegexExtractor, error) {
@@ -94,13 +95,22 @@ func (re *RegexExtractor) processEntry(ent *entry.Entry) (*entry.Entry, error) {
 	}
 	if mtchs := re.rx.FindSubmatch(ent.Data); len(mtchs) == re.cnt {
 		ent.Data = re.tmp.render(ent, mtchs)
-	} else if !re.Passthrough_Misses {
+	} else if !re.dl.miss(ent, `regular expression did not match`) && !re.Passthrough_Misses {
 		//NOT passing through misses, so set ent to nil, this is a DROP
 		ent = nil
 	}
 	return ent, nil
 }
 
+func (re *RegexExtractor) setDeadLetter(dl *deadLetter) error {
+	re.dl = dl
+	return nil
+}
+
+func (re *RegexExtractor) Close() error {
+	return re.dl.Close()
+}
+
 func (c RegexExtractConfig) validate() (rx *regexp.Regexp, tmp *formatter, err error) {
 	if c.Regex == `` {
 		err = errors.New("Missing regular expression")
@@ -52,6 +52,7 @@ type RegexRouter struct {
 	drops    map[string]struct{}
 	matchIdx int
 	rxp      *regexp.Regexp
+	dl       *deadLetter
 }
 
 func RegexRouteLoadConfig(vc *config.VariableConfig) (c RegexRouteConfig, err error) {
@@ -123,12 +124,21 @@ func (rr *RegexRouter) processItem(ent *entry.Entry) *entry.Entry {
 		} else if ok {
 			ent.Tag = tag
 		}
-	} else if rr.Drop_Misses {
+	} else if !rr.dl.miss(ent, `regular expression did not match`) && rr.Drop_Misses {
 		return nil
 	}
 	return ent
 }
 
+func (rr *RegexRouter) setDeadLetter(dl *deadLetter) error {
+	rr.dl = dl
+	return nil
+}
+
+func (rr *RegexRouter) Close() error {
+	return rr.dl.Close()
+}
+
 func (rr *RegexRouter) handleExtract(v []byte) (tag entry.EntryTag, drop, ok bool) {
 	//check if we have a tag
 	if tag, ok = rr.routes[string(v)]; !ok {
@@ -0,0 +1,476 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	RollupProcessor = `rollup`
+
+	RollupSum   = `sum`
+	RollupCount = `count`
+	RollupMin   = `min`
+	RollupMax   = `max`
+	RollupAvg   = `avg`
+
+	defaultRollupWindow     = time.Minute
+	defaultRollupMaxBuckets = 16384
+	maxRollupBucketsAllowed = 1024 * 1024
+	minRollupWindow         = time.Second
+	rollupFieldSep          = `.`
+	rollupKeySep            = "\x00"
+)
+
+var (
+	ErrMissingRollupFields    = errors.New("At least one Value-Field is required")
+	ErrInvalidRollupWindow    = fmt.Errorf("Window must be at least %v", minRollupWindow)
+	ErrInvalidRollupOperation = errors.New("Unknown operation, must be sum, count, min, max, or avg")
+	ErrInvalidRollupBuckets   = fmt.Errorf("Max-Buckets must be between 1 and %d", maxRollupBucketsAllowed)
+	ErrDuplicateRollupField   = errors.New("Duplicate rollup field")
+
+	defaultRollupOperations = []string{RollupCount, RollupSum, RollupMin, RollupMax, RollupAvg}
+)
+
+// RollupConfig controls the rollup preprocessor which buckets JSON entries into fixed
+// time windows and emits a single summary entry per window and group.  Buckets are emitted
+// once entry timestamps move a full window past their end, or once they have been open for
+// two windows of wall clock time.  When the rollup is part of a ProcessorSet the wall clock
+// check runs once per window, so buckets for sources that go quiet are still emitted.
+type RollupConfig struct {
+	Window             string   // size of each bucket, default is 1m
+	Value_Field        []string // numeric fields to aggregate, nested fields are dot separated
+	Group_By           []string // fields used to group buckets
+	Operation          []string // sum, count, min, max, avg; default is all of them
+	Output_Tag         string   // tag for rollup entries, default is the tag of the first entry in a bucket
+	Max_Buckets        int      // maximum number of open buckets, the oldest is emitted early to make room
+	Passthrough_Misses bool     // pass entries which cannot be rolled up unmodified rather than dropping them
+	span               time.Duration
+	ops                rollupOps
+}
+
+type rollupOps struct {
+	sum, count, min, max, avg bool
+}
+
+type Rollup struct {
+	RollupConfig
+	sync.Mutex
+	tagged    bool
+	tag       entry.EntryTag
+	values    [][]string
+	groups    [][]string
+	buckets   map[string]*rollupBucket
+	order     rollupOrder
+	seq       uint64
+	watermark time.Time
+}
+
+type rollupBucket struct {
+	key     string
+	idx     int    // position in the eviction order
+	seq     uint64 // creation order, breaks ties between buckets with the same start
+	start   time.Time
+	created time.Time
+	tag     entry.EntryTag
+	src     []byte
+	groups  [][]byte // JSON encoded group values
+	entries uint64
+	stats   []rollupStat
+}
+
+type rollupStat struct {
+	count uint64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func RollupLoadConfig(vc *config.VariableConfig) (c RollupConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func NewRollup(cfg RollupConfig, tagger Tagger) (*Rollup, error) {
+	r := &Rollup{}
+	if err := r.init(cfg, tagger); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Rollup) Config(v interface{}, tagger Tagger) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(RollupConfig); ok {
+		err = r.init(cfg, tagger)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (c *RollupConfig) validate() (err error) {
+	if c.span = defaultRollupWindow; strings.TrimSpace(c.Window) != `` {
+		if c.span, err = time.ParseDuration(strings.TrimSpace(c.Window)); err != nil {
+			return
+		}
+	}
+	if c.span < minRollupWindow {
+		return ErrInvalidRollupWindow
+	}
+	if c.Max_Buckets == 0 {
+		c.Max_Buckets = defaultRollupMaxBuckets
+	} else if c.Max_Buckets < 0 || c.Max_Buckets > maxRollupBucketsAllowed {
+		return ErrInvalidRollupBuckets
+	}
+	if len(c.Value_Field) == 0 {
+		return ErrMissingRollupFields
+	}
+	seen := map[string]bool{}
+	for _, set := range [][]string{c.Value_Field, c.Group_By} {
+		for i, v := range set {
+			if v = strings.TrimSpace(v); v == `` {
+				return ErrInvalidKeyname
+			} else if seen[v] {
+				return fmt.Errorf("%w %q", ErrDuplicateRollupField, v)
+			}
+			seen[v] = true
+			set[i] = v
+		}
+	}
+	ops := c.Operation
+	if len(ops) == 0 {
+		ops = defaultRollupOperations
+	}
+	c.ops = rollupOps{}
+	for _, v := range ops {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case RollupSum:
+			c.ops.sum = true
+		case RollupCount:
+			c.ops.count = true
+		case RollupMin:
+			c.ops.min = true
+		case RollupMax:
+			c.ops.max = true
+		case RollupAvg:
+			c.ops.avg = true
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidRollupOperation, v)
+		}
+	}
+	if c.Output_Tag = strings.TrimSpace(c.Output_Tag); c.Output_Tag != `` {
+		err = ingest.CheckTag(c.Output_Tag)
+	}
+	return
+}
+
+func (r *Rollup) init(cfg RollupConfig, tagger Tagger) (err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.tagged = false
+	if cfg.Output_Tag != `` {
+		if tagger == nil {
+			return ErrNilTagger
+		} else if r.tag, err = tagger.NegotiateTag(cfg.Output_Tag); err != nil {
+			return
+		}
+		r.tagged = true
+	}
+	r.RollupConfig = cfg
+	r.values = splitFieldPaths(cfg.Value_Field)
+	r.groups = splitFieldPaths(cfg.Group_By)
+	r.buckets = map[string]*rollupBucket{}
+	r.order = nil
+	return
+}
+
+func splitFieldPaths(set []string) (r [][]string) {
+	r = make([][]string, 0, len(set))
+	for _, v := range set {
+		r = append(r, strings.Split(v, rollupFieldSep))
+	}
+	return
+}
+
+func (r *Rollup) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	if len(ents) == 0 {
+		return nil, nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	rset := ents[:0]
+	var rolled []*entry.Entry
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		ok, evicted := r.add(ent)
+		if !ok && r.Passthrough_Misses {
+			rset = append(rset, ent)
+		}
+		if evicted != nil {
+			rolled = append(rolled, evicted)
+		}
+	}
+	//rset shares the backing array with ents, so rollups are only appended once all entries are consumed
+	rset = append(rset, rolled...)
+	rset = append(rset, r.flush(false)...)
+	return rset, nil
+}
+
+// add places the entry in a bucket, returning false if the entry could not be rolled up.
+// If a new bucket is needed and Max-Buckets are already open the oldest bucket is rendered and returned.
+func (r *Rollup) add(ent *entry.Entry) (ok bool, evicted *entry.Entry) {
+	vals := make([]float64, len(r.values))
+	valid := make([]bool, len(r.values))
+	var found bool
+	for i, pth := range r.values {
+		if v, err := jsonparser.GetFloat(ent.Data, pth...); err == nil && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			vals[i], valid[i], found = v, true, true
+		}
+	}
+	if !found {
+		return
+	}
+	ts := ent.TS.StandardTime()
+	start := ts.Truncate(r.span)
+	groups := make([][]byte, len(r.groups))
+	key := bytes.NewBuffer(nil)
+	key.WriteString(strconv.FormatInt(start.UnixNano(), 16))
+	key.WriteString(rollupKeySep)
+	key.WriteString(strconv.FormatUint(uint64(ent.Tag), 16))
+	for i, pth := range r.groups {
+		groups[i] = rollupGroupValue(ent.Data, pth)
+		key.WriteString(rollupKeySep)
+		key.Write(groups[i])
+	}
+	b, ok := r.buckets[key.String()]
+	if !ok {
+		if len(r.buckets) >= r.Max_Buckets {
+			evicted = r.evict()
+		}
+		r.seq++
+		b = &rollupBucket{
+			key:     key.String(),
+			seq:     r.seq,
+			start:   start,
+			created: time.Now(),
+			tag:     ent.Tag,
+			src:     append([]byte(nil), ent.SRC...),
+			groups:  groups,
+			stats:   make([]rollupStat, len(r.values)),
+		}
+		r.buckets[b.key] = b
+		heap.Push(&r.order, b)
+	}
+	b.entries++
+	for i := range vals {
+		if valid[i] {
+			b.stats[i].add(vals[i])
+		}
+	}
+	if ts.After(r.watermark) {
+		r.watermark = ts
+	}
+	ok = true
+	return
+}
+
+// evict removes the bucket with the earliest window, the oldest bucket wins a tie
+func (r *Rollup) evict() *entry.Entry {
+	b := heap.Pop(&r.order).(*rollupBucket)
+	delete(r.buckets, b.key)
+	return r.render(b)
+}
+
+// rollupGroupValue returns the JSON encoded value of a group field, missing fields are null
+func rollupGroupValue(data []byte, pth []string) []byte {
+	v, dt, _, err := jsonparser.Get(data, pth...)
+	if err != nil {
+		return []byte(`null`)
+	} else if dt == jsonparser.String {
+		if s, err := jsonparser.ParseString(v); err == nil {
+			v, _ = json.Marshal(s)
+			return v
+		}
+	}
+	return append([]byte(nil), v...)
+}
+
+func (s *rollupStat) add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+}
+
+// flush emits buckets that are complete, a bucket is complete once entry timestamps have moved a full
+// window past its end or it has been open for two windows of wall clock time.  Force flushes everything.
+// The caller must hold the lock.
+func (r *Rollup) flush(force bool) (ents []*entry.Entry) {
+	if len(r.buckets) == 0 {
+		return
+	}
+	cutoff := r.watermark.Add(-1 * r.span)
+	stale := time.Now().Add(-2 * r.span)
+	var keys []string
+	for k, b := range r.buckets {
+		if force || !b.start.Add(r.span).After(cutoff) || b.created.Before(stale) {
+			keys = append(keys, k)
+		}
+	}
+	//emit in a stable order so the output is deterministic
+	sort.Strings(keys)
+	for _, k := range keys {
+		b := r.buckets[k]
+		heap.Remove(&r.order, b.idx)
+		delete(r.buckets, k)
+		ents = append(ents, r.render(b))
+	}
+	return
+}
+
+// rollupOrder is a heap of open buckets ordered by window start and then creation
+type rollupOrder []*rollupBucket
+
+func (o rollupOrder) Len() int { return len(o) }
+
+func (o rollupOrder) Less(i, j int) bool {
+	if !o[i].start.Equal(o[j].start) {
+		return o[i].start.Before(o[j].start)
+	}
+	return o[i].seq < o[j].seq
+}
+
+func (o rollupOrder) Swap(i, j int) {
+	o[i], o[j] = o[j], o[i]
+	o[i].idx = i
+	o[j].idx = j
+}
+
+func (o *rollupOrder) Push(x interface{}) {
+	b := x.(*rollupBucket)
+	b.idx = len(*o)
+	*o = append(*o, b)
+}
+
+func (o *rollupOrder) Pop() interface{} {
+	old := *o
+	b := old[len(old)-1]
+	old[len(old)-1] = nil
+	*o = old[:len(old)-1]
+	return b
+}
+
+func (r *Rollup) render(b *rollupBucket) *entry.Entry {
+	bb := bytes.NewBuffer(nil)
+	fmt.Fprintf(bb, `{"window_start":%q,"window":%q,"count":%d`, b.start.UTC().Format(time.RFC3339Nano), r.span.String(), b.entries)
+	for i, name := range r.Group_By {
+		fmt.Fprintf(bb, `,%q:`, name)
+		bb.Write(b.groups[i])
+	}
+	for i, name := range r.Value_Field {
+		st := b.stats[i]
+		if r.ops.count {
+			fmt.Fprintf(bb, `,%q:%d`, name+`_count`, st.count)
+		}
+		if st.count == 0 {
+			continue
+		}
+		if r.ops.sum {
+			fmt.Fprintf(bb, `,%q:%s`, name+`_sum`, formatRollupFloat(st.sum))
+		}
+		if r.ops.min {
+			fmt.Fprintf(bb, `,%q:%s`, name+`_min`, formatRollupFloat(st.min))
+		}
+		if r.ops.max {
+			fmt.Fprintf(bb, `,%q:%s`, name+`_max`, formatRollupFloat(st.max))
+		}
+		if r.ops.avg {
+			fmt.Fprintf(bb, `,%q:%s`, name+`_avg`, formatRollupFloat(st.sum/float64(st.count)))
+		}
+	}
+	bb.WriteString(`}`)
+	ent := &entry.Entry{
+		TS:   entry.FromStandard(b.start),
+		SRC:  b.src,
+		Tag:  b.tag,
+		Data: bb.Bytes(),
+	}
+	if r.tagged {
+		ent.Tag = r.tag
+	}
+	return ent
+}
+
+func formatRollupFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Buckets returns the number of open buckets
+func (r *Rollup) Buckets() (n int) {
+	r.Lock()
+	n = len(r.buckets)
+	r.Unlock()
+	return
+}
+
+func (r *Rollup) Flush() (ents []*entry.Entry) {
+	r.Lock()
+	ents = r.flush(true)
+	r.Unlock()
+	return
+}
+
+// ExpireInterval is how often a ProcessorSet should call Expire
+func (r *Rollup) ExpireInterval() (d time.Duration) {
+	r.Lock()
+	d = r.span
+	r.Unlock()
+	return
+}
+
+// Expire emits the buckets that are complete, it lets buckets close on wall clock time when no entries arrive
+func (r *Rollup) Expire() (ents []*entry.Entry) {
+	r.Lock()
+	ents = r.flush(false)
+	r.Unlock()
+	return
+}
+
+func (r *Rollup) Close() error {
+	return nil
+}
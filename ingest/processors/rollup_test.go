@@ -0,0 +1,261 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var rollupBase = time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func rollupEntry(offset time.Duration, data string) *entry.Entry {
+	return &entry.Entry{
+		TS:   entry.FromStandard(rollupBase.Add(offset)),
+		Data: []byte(data),
+	}
+}
+
+func TestRollupConfig(t *testing.T) {
+	b := []byte(`
+	[preprocessor "ru"]
+		type = rollup
+		Window = 5m
+		Value-Field = bytes
+		Value-Field = stats.latency
+		Group-By = host
+		Operation = sum
+		Operation = MAX
+		Output-Tag = rollups
+	`)
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tt testTagger
+	p, err := tc.Preprocessor.getProcessor(`ru`, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := p.(*Rollup)
+	if !ok {
+		t.Fatalf("bad processor type: %T", p)
+	} else if r.span != 5*time.Minute || !r.tagged || r.Max_Buckets != defaultRollupMaxBuckets {
+		t.Fatalf("bad config: %+v", r.RollupConfig)
+	} else if (r.ops != rollupOps{sum: true, max: true}) {
+		t.Fatalf("bad operations: %+v", r.ops)
+	} else if len(r.values) != 2 || len(r.values[1]) != 2 || r.values[1][1] != `latency` {
+		t.Fatalf("bad value paths: %v", r.values)
+	}
+
+	bad := []RollupConfig{
+		RollupConfig{},
+		RollupConfig{Value_Field: []string{`a`}, Window: `10ms`},
+		RollupConfig{Value_Field: []string{`a`}, Window: `foo`},
+		RollupConfig{Value_Field: []string{`a`}, Operation: []string{`median`}},
+		RollupConfig{Value_Field: []string{`a`}, Group_By: []string{`a`}},
+		RollupConfig{Value_Field: []string{`a`, ` `}},
+		RollupConfig{Value_Field: []string{`a`}, Max_Buckets: -1},
+		RollupConfig{Value_Field: []string{`a`}, Output_Tag: `bad tag`},
+	}
+	for i, c := range bad {
+		if _, err := NewRollup(c, &tt); err == nil {
+			t.Fatalf("failed to catch bad config %d: %+v", i, c)
+		}
+	}
+}
+
+func TestRollupBuckets(t *testing.T) {
+	r, err := NewRollup(RollupConfig{
+		Value_Field: []string{`bytes`, `stats.latency`},
+		Group_By:    []string{`host`},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := []*entry.Entry{
+		rollupEntry(0, `{"host": "a", "bytes": 10, "stats": {"latency": 1.5}}`),
+		rollupEntry(time.Second, `{"host": "a", "bytes": 30, "stats": {"latency": 0.5}}`),
+		rollupEntry(2*time.Second, `{"host": "b", "bytes": 5}`),
+		rollupEntry(3*time.Second, `{"host": "a", "bytes": 20}`),
+		rollupEntry(4*time.Second, `not json`),
+	}
+	set, err := r.Process(ents)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 0 {
+		t.Fatalf("emitted entries before the window closed: %d", len(set))
+	} else if r.Buckets() != 2 {
+		t.Fatalf("bad bucket count: %d", r.Buckets())
+	}
+
+	//an entry two windows later closes the first window
+	if set, err = r.Process([]*entry.Entry{rollupEntry(2*time.Minute, `{"host": "a", "bytes": 1}`)}); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 2 {
+		t.Fatalf("bad rollup count: %d", len(set))
+	} else if r.Buckets() != 1 {
+		t.Fatalf("bad bucket count after flush: %d", r.Buckets())
+	}
+	for _, ent := range set {
+		if !ent.TS.StandardTime().Equal(rollupBase) {
+			t.Fatalf("bad rollup timestamp: %v", ent.TS)
+		}
+		host, err := jsonparser.GetString(ent.Data, `host`)
+		if err != nil {
+			t.Fatal(err, string(ent.Data))
+		}
+		exp := map[string]string{
+			`count`: `1`, `bytes_count`: `1`, `bytes_sum`: `5`, `bytes_min`: `5`, `bytes_max`: `5`, `bytes_avg`: `5`, `stats.latency_count`: `0`,
+		}
+		if host == `a` {
+			exp = map[string]string{
+				`count`: `3`, `bytes_count`: `3`, `bytes_sum`: `60`, `bytes_min`: `10`, `bytes_max`: `30`, `bytes_avg`: `20`,
+				`stats.latency_count`: `2`, `stats.latency_sum`: `2`, `stats.latency_min`: `0.5`, `stats.latency_max`: `1.5`, `stats.latency_avg`: `1`,
+			}
+		} else if _, _, _, err := jsonparser.Get(ent.Data, `stats.latency_sum`); err == nil {
+			t.Fatalf("empty stat was rendered: %s", ent.Data)
+		}
+		for k, v := range exp {
+			if val, _, _, err := jsonparser.Get(ent.Data, k); err != nil || string(val) != v {
+				t.Fatalf("bad %s for %s: %q != %q (%v) %s", k, host, val, v, err, ent.Data)
+			}
+		}
+	}
+
+	//flushing pushes out whatever is left
+	if set = r.Flush(); len(set) != 1 {
+		t.Fatalf("bad flush count: %d", len(set))
+	} else if r.Buckets() != 0 {
+		t.Fatal("buckets left after flush")
+	}
+}
+
+func TestRollupPassthroughAndLimits(t *testing.T) {
+	var tt testTagger
+	r, err := NewRollup(RollupConfig{
+		Value_Field:        []string{`v`},
+		Group_By:           []string{`id`},
+		Operation:          []string{`count`},
+		Output_Tag:         `rolled`,
+		Max_Buckets:        2,
+		Passthrough_Misses: true,
+	}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := r.Process([]*entry.Entry{rollupEntry(0, `{"v": "nope"}`), rollupEntry(0, `plain text`)})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 2 {
+		t.Fatalf("misses were not passed: %d", len(set))
+	}
+	var ents []*entry.Entry
+	for i := 0; i < 5; i++ {
+		ents = append(ents, rollupEntry(0, fmt.Sprintf(`{"id": %d, "v": 1}`, i)))
+	}
+	if set, err = r.Process(ents); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 3 {
+		t.Fatalf("bucket limit did not evict: %d", len(set))
+	}
+	//the oldest buckets go first
+	for i, ent := range set {
+		if id, err := jsonparser.GetInt(ent.Data, `id`); err != nil || id != int64(i) {
+			t.Fatalf("evicted the wrong bucket %d: %s", i, ent.Data)
+		}
+	}
+	set = append(set, r.Flush()...)
+	if len(set) != 5 {
+		t.Fatalf("bad final count: %d", len(set))
+	}
+	for _, ent := range set {
+		if ent.Tag != tt.mp[`rolled`] {
+			t.Fatal("rollup was not tagged")
+		} else if _, _, _, err := jsonparser.Get(ent.Data, `v_sum`); err == nil {
+			t.Fatalf("unrequested operation rendered: %s", ent.Data)
+		}
+	}
+}
+
+func TestRollupEvictOldest(t *testing.T) {
+	r, err := NewRollup(RollupConfig{
+		Value_Field: []string{`v`},
+		Group_By:    []string{`id`},
+		Operation:   []string{`count`},
+		Max_Buckets: 2,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a later window for id 1 is opened first, but the earlier window for id 2 is still the oldest
+	set, err := r.Process([]*entry.Entry{
+		rollupEntry(time.Minute, `{"id": 1, "v": 1}`),
+		rollupEntry(0, `{"id": 2, "v": 1}`),
+		rollupEntry(time.Minute+time.Second, `{"id": 1, "v": 1}`),
+		rollupEntry(time.Minute+2*time.Second, `{"id": 3, "v": 1}`),
+		rollupEntry(time.Minute+3*time.Second, `{"id": 1, "v": 1}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 1 {
+		t.Fatalf("bad eviction count: %d", len(set))
+	} else if id, _ := jsonparser.GetInt(set[0].Data, `id`); id != 2 {
+		t.Fatalf("evicted the wrong bucket: %s", set[0].Data)
+	}
+	//the open buckets were never split, so each group has a single row
+	exp := map[int64]int64{1: 3, 3: 1}
+	for _, ent := range r.Flush() {
+		id, _ := jsonparser.GetInt(ent.Data, `id`)
+		if cnt, _ := jsonparser.GetInt(ent.Data, `count`); cnt != exp[id] {
+			t.Fatalf("bad count for %d: %d != %d", id, cnt, exp[id])
+		}
+		delete(exp, id)
+	}
+	if len(exp) != 0 {
+		t.Fatalf("missing rollups: %v", exp)
+	}
+}
+
+func TestRollupExpire(t *testing.T) {
+	r, err := NewRollup(RollupConfig{Value_Field: []string{`v`}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if r.ExpireInterval() != defaultRollupWindow {
+		t.Fatalf("bad expire interval: %v", r.ExpireInterval())
+	}
+	if set, err := r.Process([]*entry.Entry{rollupEntry(0, `{"v": 1}`)}); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 0 {
+		t.Fatal("emitted an open bucket")
+	} else if set = r.Expire(); len(set) != 0 {
+		t.Fatal("expired a fresh bucket")
+	}
+	//age the bucket as if the source went quiet
+	r.Lock()
+	for _, b := range r.buckets {
+		b.created = b.created.Add(-3 * defaultRollupWindow)
+	}
+	r.Unlock()
+	if set := r.Expire(); len(set) != 1 {
+		t.Fatalf("stale bucket was not expired: %d", len(set))
+	} else if r.Buckets() != 0 {
+		t.Fatal("expired bucket is still open")
+	}
+}
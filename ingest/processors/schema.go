@@ -0,0 +1,408 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+const (
+	SchemaProcessor = `schema`
+
+	SchemaString = `string`
+	SchemaInt    = `int`
+	SchemaFloat  = `float`
+	SchemaBool   = `bool`
+	SchemaIP     = `ip`
+	SchemaTime   = `time`
+	SchemaAny    = `any`
+
+	schemaSpecSep          = `:`
+	schemaRequired         = `required`
+	schemaEnumPrefix       = `enum=`
+	schemaEnumSep          = `|`
+	schemaFieldSep         = `.`
+	defaultSchemaErrorName = `schema_error`
+)
+
+var (
+	ErrMissingSchemaFields  = errors.New("At least one Field is required")
+	ErrInvalidSchemaField   = errors.New("Invalid Field, must be <field>:<type>[:required][:enum=a|b|c]")
+	ErrInvalidSchemaType    = errors.New("Invalid field type, must be string, int, float, bool, ip, time, or any")
+	ErrDuplicateSchemaField = errors.New("Duplicate schema field")
+	ErrSchemaRejectAction   = errors.New("Exactly one of Reject-Tag or Drop-Rejects must be set")
+	ErrSchemaNotJSON        = errors.New("entry is not a JSON object")
+)
+
+// SchemaConfig declares the fields expected in JSON entries.  Entries that do not conform
+// are either dropped or retagged with the violation attached.
+type SchemaConfig struct {
+	Field            []string // <field>:<type>[:required][:enum=a|b|c], nested fields are dot separated
+	Coerce           bool     // convert values to the declared type where possible
+	Strict           bool     // reject entries with top level fields not in the schema
+	Reject_Tag       string   // tag for entries that violate the schema
+	Drop_Rejects     bool     // drop violating entries rather than retagging them
+	Error_Name       string   // name of the violation annotation, default is schema_error
+	Annotation_Mode  string
+	Annotation_Field string
+	specs            []schemaField
+}
+
+type schemaField struct {
+	name     string
+	path     []string
+	tp       string
+	required bool
+	enum     map[string]bool
+}
+
+type Schema struct {
+	nocloser
+	SchemaConfig
+	ann       Annotator
+	rejectTag entry.EntryTag
+	topLevel  map[string]bool
+	dl        *deadLetter
+}
+
+func SchemaLoadConfig(vc *config.VariableConfig) (c SchemaConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func NewSchema(cfg SchemaConfig, tagger Tagger) (*Schema, error) {
+	s := &Schema{}
+	if err := s.init(cfg, tagger); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Schema) Config(v interface{}, tagger Tagger) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(SchemaConfig); ok {
+		err = s.init(cfg, tagger)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (c *SchemaConfig) validate() (err error) {
+	if len(c.Field) == 0 {
+		return ErrMissingSchemaFields
+	}
+	c.specs = c.specs[:0]
+	seen := map[string]bool{}
+	for _, v := range c.Field {
+		var sf schemaField
+		if sf, err = parseSchemaField(v); err != nil {
+			return
+		} else if seen[sf.name] {
+			return fmt.Errorf("%w %q", ErrDuplicateSchemaField, sf.name)
+		}
+		seen[sf.name] = true
+		c.specs = append(c.specs, sf)
+	}
+	c.Reject_Tag = strings.TrimSpace(c.Reject_Tag)
+	if (c.Reject_Tag == ``) == !c.Drop_Rejects {
+		return ErrSchemaRejectAction
+	} else if c.Reject_Tag != `` {
+		if err = ingest.CheckTag(c.Reject_Tag); err != nil {
+			return
+		}
+	}
+	if c.Error_Name = strings.TrimSpace(c.Error_Name); c.Error_Name == `` {
+		c.Error_Name = defaultSchemaErrorName
+	}
+	_, err = parseAnnotationMode(c.Annotation_Mode)
+	return
+}
+
+func parseSchemaField(v string) (sf schemaField, err error) {
+	parts := strings.Split(strings.TrimSpace(v), schemaSpecSep)
+	if len(parts) < 2 {
+		err = fmt.Errorf("%w: %q", ErrInvalidSchemaField, v)
+		return
+	}
+	if sf.name = strings.TrimSpace(parts[0]); sf.name == `` {
+		err = fmt.Errorf("%w: %q", ErrInvalidSchemaField, v)
+		return
+	}
+	sf.path = strings.Split(sf.name, schemaFieldSep)
+	switch sf.tp = strings.ToLower(strings.TrimSpace(parts[1])); sf.tp {
+	case SchemaString, SchemaInt, SchemaFloat, SchemaBool, SchemaIP, SchemaTime, SchemaAny:
+	default:
+		err = fmt.Errorf("%w: %q", ErrInvalidSchemaType, parts[1])
+		return
+	}
+	for _, p := range parts[2:] {
+		p = strings.TrimSpace(p)
+		if strings.EqualFold(p, schemaRequired) {
+			sf.required = true
+		} else if strings.HasPrefix(strings.ToLower(p), schemaEnumPrefix) {
+			sf.enum = map[string]bool{}
+			for _, e := range strings.Split(p[len(schemaEnumPrefix):], schemaEnumSep) {
+				sf.enum[e] = true
+			}
+		} else {
+			err = fmt.Errorf("%w: unknown option %q", ErrInvalidSchemaField, p)
+			return
+		}
+	}
+	return
+}
+
+func (s *Schema) init(cfg SchemaConfig, tagger Tagger) (err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	if s.ann, err = NewAnnotator(cfg.Annotation_Mode, cfg.Annotation_Field); err != nil {
+		return
+	}
+	if cfg.Reject_Tag != `` {
+		if tagger == nil {
+			return ErrNilTagger
+		} else if s.rejectTag, err = tagger.NegotiateTag(cfg.Reject_Tag); err != nil {
+			return fmt.Errorf("Failed to negotiate tag %s: %v", cfg.Reject_Tag, err)
+		}
+	}
+	s.topLevel = make(map[string]bool, len(cfg.specs))
+	for _, sf := range cfg.specs {
+		s.topLevel[sf.path[0]] = true
+	}
+	s.SchemaConfig = cfg
+	return
+}
+
+func (s *Schema) Process(ents []*entry.Entry) (rset []*entry.Entry, err error) {
+	if len(ents) == 0 {
+		return
+	}
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		if verr := s.enforce(ent); verr != nil {
+			s.dl.miss(ent, verr.Error())
+			if s.Drop_Rejects {
+				continue
+			}
+			//failing to attach the error shouldn't stop the entry from being routed
+			s.ann.Annotate(ent, Annotation{Name: s.Error_Name, Value: verr.Error()})
+			ent.Tag = s.rejectTag
+		}
+		rset = append(rset, ent)
+	}
+	return
+}
+
+// setDeadLetter copies rejects to the dead letter webhook or file, schema has its own reject tag
+func (s *Schema) setDeadLetter(dl *deadLetter) error {
+	if dl.retag {
+		return ErrDeadLetterTagConflict
+	}
+	s.dl = dl
+	return nil
+}
+
+func (s *Schema) Close() error {
+	return s.dl.Close()
+}
+
+// enforce checks the entry against the schema, coerced values are only written back if the entire entry conforms
+func (s *Schema) enforce(ent *entry.Entry) (err error) {
+	if !isJSONObject(ent.Data) {
+		return ErrSchemaNotJSON
+	}
+	data := ent.Data
+	for _, sf := range s.specs {
+		val, dt, _, lerr := jsonparser.Get(data, sf.path...)
+		if lerr != nil && lerr != jsonparser.KeyPathNotFoundError {
+			return fmt.Errorf("field %s: %v", sf.name, lerr)
+		} else if dt == jsonparser.NotExist || dt == jsonparser.Null {
+			if sf.required {
+				return fmt.Errorf("field %s: required field is missing", sf.name)
+			}
+			continue
+		}
+		var nv []byte
+		if nv, err = sf.conform(val, dt, s.Coerce); err != nil {
+			return fmt.Errorf("field %s: %v", sf.name, err)
+		} else if nv != nil {
+			if data, err = jsonparser.Set(data, nv, sf.path...); err != nil {
+				return fmt.Errorf("field %s: %v", sf.name, err)
+			}
+		}
+	}
+	if s.Strict {
+		err = jsonparser.ObjectEach(data, func(key, _ []byte, _ jsonparser.ValueType, _ int) error {
+			if !s.topLevel[string(key)] {
+				return fmt.Errorf("field %s: not in schema", key)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	ent.Data = data
+	return
+}
+
+// conform checks a value against the field type, if the value had to be coerced the new JSON value is returned
+func (sf schemaField) conform(val []byte, dt jsonparser.ValueType, coerce bool) (nv []byte, err error) {
+	var canon string //textual value used for enumeration checks
+	switch sf.tp {
+	case SchemaAny:
+		return
+	case SchemaString:
+		if dt == jsonparser.String {
+			canon, err = jsonparser.ParseString(val)
+		} else if coerce && (dt == jsonparser.Number || dt == jsonparser.Boolean) {
+			canon = string(val)
+			nv, err = json.Marshal(canon)
+		} else {
+			err = typeMismatch(sf.tp, dt)
+		}
+	case SchemaInt:
+		var i int64
+		if i, err = schemaInt(val, dt, coerce); err == nil {
+			canon = strconv.FormatInt(i, 10)
+			if dt != jsonparser.Number || canon != string(val) {
+				nv = []byte(canon)
+			}
+		}
+	case SchemaFloat:
+		var f float64
+		if dt == jsonparser.Number {
+			canon = string(val)
+		} else if !coerce || dt != jsonparser.String {
+			err = typeMismatch(sf.tp, dt)
+		} else if f, err = strconv.ParseFloat(strings.TrimSpace(string(val)), 64); err == nil {
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				err = errors.New("value is not a finite number")
+			} else {
+				canon = strconv.FormatFloat(f, 'g', -1, 64)
+				nv = []byte(canon)
+			}
+		}
+	case SchemaBool:
+		var b bool
+		if dt == jsonparser.Boolean {
+			canon = string(val)
+		} else if !coerce {
+			err = typeMismatch(sf.tp, dt)
+		} else if dt == jsonparser.String || dt == jsonparser.Number {
+			if b, err = strconv.ParseBool(strings.TrimSpace(string(val))); err == nil {
+				canon = strconv.FormatBool(b)
+				nv = []byte(canon)
+			}
+		} else {
+			err = typeMismatch(sf.tp, dt)
+		}
+	case SchemaIP:
+		var s string
+		if dt != jsonparser.String {
+			err = typeMismatch(sf.tp, dt)
+		} else if s, err = jsonparser.ParseString(val); err == nil {
+			if ip := net.ParseIP(strings.TrimSpace(s)); ip == nil {
+				err = fmt.Errorf("%q is not an IP address", s)
+			} else if canon = ip.String(); coerce && canon != s {
+				nv, err = json.Marshal(canon)
+			}
+		}
+	case SchemaTime:
+		var t time.Time
+		if t, err = schemaTime(val, dt, coerce); err == nil {
+			canon = t.Format(time.RFC3339Nano)
+			if coerce && canon != string(val) {
+				nv, err = json.Marshal(canon)
+			}
+		}
+	}
+	if err == nil && sf.enum != nil && !sf.enum[canon] {
+		err = fmt.Errorf("%q is not an allowed value", canon)
+	}
+	return
+}
+
+func schemaInt(val []byte, dt jsonparser.ValueType, coerce bool) (i int64, err error) {
+	s := string(val)
+	if dt == jsonparser.String && coerce {
+		s = strings.TrimSpace(s)
+	} else if dt != jsonparser.Number {
+		err = typeMismatch(SchemaInt, dt)
+		return
+	}
+	if i, err = strconv.ParseInt(s, 10, 64); err == nil || !coerce {
+		return
+	}
+	//whole numbers written as floats, like 3.0, can be coerced
+	var f float64
+	if f, err = strconv.ParseFloat(s, 64); err != nil {
+		return
+	} else if f != math.Trunc(f) || math.IsInf(f, 0) || math.IsNaN(f) || math.Abs(f) > (1<<53) {
+		err = fmt.Errorf("%s is not an integer", s)
+		return
+	}
+	i = int64(f)
+	return
+}
+
+// schemaTime accepts RFC3339 strings, with coercion enabled unix timestamps and anything timegrinder can extract are converted
+func schemaTime(val []byte, dt jsonparser.ValueType, coerce bool) (t time.Time, err error) {
+	switch dt {
+	case jsonparser.String:
+		var s string
+		if s, err = jsonparser.ParseString(val); err != nil {
+			return
+		} else if t, err = time.Parse(time.RFC3339Nano, s); err == nil || !coerce {
+			return
+		}
+		var ok bool
+		if t, ok, err = timegrinder.Extract([]byte(s)); err == nil && !ok {
+			err = fmt.Errorf("%q is not a timestamp", s)
+		}
+	case jsonparser.Number:
+		if !coerce {
+			err = typeMismatch(SchemaTime, dt)
+			return
+		}
+		var f float64
+		if f, err = strconv.ParseFloat(string(val), 64); err == nil {
+			sec, frac := math.Modf(f)
+			t = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+		}
+	default:
+		err = typeMismatch(SchemaTime, dt)
+	}
+	return
+}
+
+func typeMismatch(want string, got jsonparser.ValueType) error {
+	return fmt.Errorf("expected %s, got %s", want, got)
+}
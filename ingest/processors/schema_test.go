@@ -0,0 +1,205 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestSchemaConfig(t *testing.T) {
+	b := []byte(`
+	[preprocessor "sch"]
+		type = schema
+		Field = host:string:required
+		Field = "stats.latency:float"
+		Field = "level:string:enum=info|warn|error"
+		Coerce = true
+		Reject-Tag = rejects
+	`)
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tt testTagger
+	p, err := tc.Preprocessor.getProcessor(`sch`, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := p.(*Schema)
+	if !ok {
+		t.Fatalf("bad processor type: %T", p)
+	} else if len(s.specs) != 3 || !s.specs[0].required || len(s.specs[1].path) != 2 || len(s.specs[2].enum) != 3 {
+		t.Fatalf("bad fields: %+v", s.specs)
+	} else if s.Error_Name != defaultSchemaErrorName {
+		t.Fatalf("bad error name: %q", s.Error_Name)
+	}
+
+	bad := []SchemaConfig{
+		SchemaConfig{},
+		SchemaConfig{Field: []string{`a:string`}},
+		SchemaConfig{Field: []string{`a:string`}, Reject_Tag: `r`, Drop_Rejects: true},
+		SchemaConfig{Field: []string{`a`}, Drop_Rejects: true},
+		SchemaConfig{Field: []string{`a:uuid`}, Drop_Rejects: true},
+		SchemaConfig{Field: []string{`a:int:optional`}, Drop_Rejects: true},
+		SchemaConfig{Field: []string{`a:int`, `a:string`}, Drop_Rejects: true},
+		SchemaConfig{Field: []string{`a:int`}, Reject_Tag: `bad tag`},
+		SchemaConfig{Field: []string{`a:int`}, Drop_Rejects: true, Annotation_Mode: `xml`},
+	}
+	for i, c := range bad {
+		if _, err := NewSchema(c, &tt); err == nil {
+			t.Fatalf("failed to catch bad config %d: %+v", i, c)
+		}
+	}
+}
+
+func TestSchemaEnforce(t *testing.T) {
+	var tt testTagger
+	cfg := SchemaConfig{
+		Field: []string{
+			`host:ip:required`,
+			`count:int`,
+			`ratio:float`,
+			`ok:bool`,
+			`ts:time`,
+			`meta.level:string:enum=info|warn`,
+			`extra:any`,
+		},
+		Reject_Tag: `rejects`,
+	}
+	s, err := NewSchema(cfg, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := []string{
+		`{"host":"10.0.0.1"}`,
+		`{"host":"::1","count":12,"ratio":0.5,"ok":false,"ts":"2022-06-01T12:00:00Z","meta":{"level":"warn"},"extra":[1,2]}`,
+		`{"host":"10.0.0.1","count":null}`,
+	}
+	for _, v := range good {
+		ents := makeEntry([]byte(v), 0)
+		if ents, err = s.Process(ents); err != nil {
+			t.Fatal(err)
+		} else if len(ents) != 1 || ents[0].Tag != 0 || string(ents[0].Data) != v {
+			t.Fatalf("conforming entry was modified: %s", v)
+		}
+	}
+	rejectTag, _ := tt.NegotiateTag(`rejects`)
+	bad := map[string]string{
+		`not json`:                                     `not a JSON object`,
+		`{"count":1}`:                                  `field host`,
+		`{"host":"bob"}`:                               `field host`,
+		`{"host":"10.0.0.1","count":"12"}`:             `field count`,
+		`{"host":"10.0.0.1","count":1.5}`:              `field count`,
+		`{"host":"10.0.0.1","ok":1}`:                   `field ok`,
+		`{"host":"10.0.0.1","ts":1654084800}`:          `field ts`,
+		`{"host":"10.0.0.1","meta":{"level":5}}`:       `field meta.level`,
+		`{"host":"10.0.0.1","meta":{"level":"debug"}}`: `field meta.level`,
+	}
+	for v, want := range bad {
+		ents := makeEntry([]byte(v), 0)
+		if ents, err = s.Process(ents); err != nil {
+			t.Fatal(err)
+		} else if len(ents) != 1 || ents[0].Tag != rejectTag {
+			t.Fatalf("violating entry was not rejected: %s", v)
+		}
+		if isJSONObject(ents[0].Data) {
+			if msg, err := jsonparser.GetString(ents[0].Data, defaultSchemaErrorName); err != nil || !strings.HasPrefix(msg, want) {
+				t.Fatalf("bad error annotation on %s: %q %v", v, msg, err)
+			}
+		}
+	}
+
+	//dropping rejects
+	cfg.Reject_Tag = ``
+	cfg.Drop_Rejects = true
+	if s, err = NewSchema(cfg, &tt); err != nil {
+		t.Fatal(err)
+	}
+	if ents, err := s.Process(makeEntry([]byte(`{"count":1}`), 0)); err != nil || len(ents) != 0 {
+		t.Fatalf("violating entry was not dropped: %d %v", len(ents), err)
+	}
+}
+
+func TestSchemaCoerce(t *testing.T) {
+	var tt testTagger
+	cfg := SchemaConfig{
+		Field: []string{
+			`host:ip`,
+			`count:int`,
+			`ratio:float`,
+			`ok:bool`,
+			`ts:time`,
+			`code:string:enum=200|404`,
+		},
+		Coerce:       true,
+		Strict:       true,
+		Drop_Rejects: true,
+	}
+	s, err := NewSchema(cfg, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := `{"host":"::ffff:10.0.0.1","count":"12","ratio":"0.25","ok":"true","ts":1654084800,"code":404}`
+	want := map[string]string{
+		`host`:  `10.0.0.1`,
+		`count`: `12`,
+		`ratio`: `0.25`,
+		`ok`:    `true`,
+		`ts`:    `2022-06-01T12:00:00Z`,
+		`code`:  `404`,
+	}
+	ents, err := s.Process(makeEntry([]byte(in), 0))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ents) != 1 {
+		t.Fatalf("coercible entry was dropped: %s", in)
+	}
+	for k, v := range want {
+		val, dt, _, err := jsonparser.Get(ents[0].Data, k)
+		if err != nil || string(val) != v {
+			t.Fatalf("bad coerced %s: %q != %q %v", k, val, v, err)
+		}
+		switch k {
+		case `count`, `ratio`:
+			if dt != jsonparser.Number {
+				t.Fatalf("%s was not coerced to a number: %v", k, dt)
+			}
+		case `ok`:
+			if dt != jsonparser.Boolean {
+				t.Fatalf("%s was not coerced to a boolean: %v", k, dt)
+			}
+		default:
+			if dt != jsonparser.String {
+				t.Fatalf("%s was not coerced to a string: %v", k, dt)
+			}
+		}
+	}
+
+	//unconvertable values and unknown fields still fail, and the entry is left alone
+	for _, v := range []string{`{"count":"twelve"}`, `{"ratio":"NaN"}`, `{"code":500}`, `{"count":1,"other":2}`} {
+		if ents, err = s.Process(makeEntry([]byte(v), 0)); err != nil || len(ents) != 0 {
+			t.Fatalf("bad entry %s was not dropped: %v", v, err)
+		}
+	}
+	var ent entry.Entry
+	ent.Data = []byte(`{"count":"3","ok":"maybe"}`)
+	if verr := s.enforce(&ent); verr == nil || string(ent.Data) != `{"count":"3","ok":"maybe"}` {
+		t.Fatalf("rejected entry was modified: %s %v", ent.Data, verr)
+	}
+}
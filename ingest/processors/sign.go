@@ -0,0 +1,429 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	SignProcessor = `sign`
+
+	SignHMAC    = `hmac-sha256`
+	SignEd25519 = `ed25519`
+
+	SignModeEntry = `entry`
+	SignModeBlock = `block`
+
+	defaultSignKeyID = `default`
+	// signatureMarker separates an entry from its signature trailer, the trailer is
+	// <marker><key id>:<base64 signature>
+	signatureMarker = ` gravsig:`
+)
+
+var (
+	ErrInvalidSignAlgorithm = errors.New("Algorithm must be hmac-sha256 or ed25519")
+	ErrInvalidSignMode      = errors.New("Mode must be entry or block")
+	ErrMissingSignKey       = errors.New("Key or Key-File is required")
+	ErrInvalidSignKey       = errors.New("Invalid signing key")
+	ErrInvalidSignKeyID     = errors.New("Key-ID may not contain spaces or colons")
+	ErrSignatureMissing     = errors.New("Entry is not signed")
+	ErrSignatureMismatch    = errors.New("Signature does not match")
+	ErrUnknownSignKey       = errors.New("Unknown signing key")
+	ErrManifestMismatch     = errors.New("Entries do not match the signed manifest")
+	ErrManifestChain        = errors.New("Manifest does not follow the previous manifest")
+
+	sigEncoding = base64.RawStdEncoding
+)
+
+// SignConfig controls the sign preprocessor which signs entries so that they can later be
+// shown to be unmodified since ingest.  In entry mode every entry gets a signature trailer
+// covering its timestamp and data.  In block mode entries pass through untouched and every
+// block is followed by a signed manifest entry listing the hash of each entry in the block,
+// manifests are chained so that a missing manifest is detected.
+type SignConfig struct {
+	Algorithm    string // hmac-sha256 or ed25519, default is hmac-sha256
+	Key          string `json:"-"` // HMAC secret or base64 encoded ed25519 private key
+	Key_File     string // file holding the key, ed25519 keys may be PEM encoded
+	Key_ID       string // names the key in signatures so verifiers can pick the right one
+	Mode         string // entry or block, default is entry
+	Manifest_Tag string // tag for block manifests, default is the tag of the first entry in a block
+	key          SignatureKey
+}
+
+// SignatureKey is a named signing or verification key.  HMAC keys are the shared secret,
+// ed25519 keys are either a private key for signing or a public key for verification.
+type SignatureKey struct {
+	ID        string
+	Algorithm string
+	Key       []byte
+}
+
+// SignManifest is the body of a block mode manifest entry
+type SignManifest struct {
+	Key       string
+	Algorithm string
+	Sequence  uint64
+	Previous  string          `json:",omitempty"` // signature of the previous manifest
+	Entries   []ManifestEntry // one per signed entry, in block order
+	Signature string          `json:",omitempty"`
+}
+
+// ManifestEntry identifies a single entry in a manifest
+type ManifestEntry struct {
+	TS   time.Time
+	Hash string // base64 SHA256 of the entry timestamp and data
+}
+
+func SignLoadConfig(vc *config.VariableConfig) (c SignConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		err = c.validate()
+	}
+	return
+}
+
+func (c *SignConfig) validate() (err error) {
+	if c.Algorithm = strings.ToLower(strings.TrimSpace(c.Algorithm)); c.Algorithm == `` {
+		c.Algorithm = SignHMAC
+	}
+	if c.Mode = strings.ToLower(strings.TrimSpace(c.Mode)); c.Mode == `` {
+		c.Mode = SignModeEntry
+	} else if c.Mode != SignModeEntry && c.Mode != SignModeBlock {
+		return ErrInvalidSignMode
+	}
+	if c.Key_ID = strings.TrimSpace(c.Key_ID); c.Key_ID == `` {
+		c.Key_ID = defaultSignKeyID
+	} else if strings.ContainsAny(c.Key_ID, " \t:") {
+		return ErrInvalidSignKeyID
+	}
+	if c.Manifest_Tag = strings.TrimSpace(c.Manifest_Tag); c.Manifest_Tag != `` {
+		if err = ingest.CheckTag(c.Manifest_Tag); err != nil {
+			return
+		}
+	}
+	raw := []byte(c.Key)
+	if c.Key_File != `` {
+		if raw, err = os.ReadFile(c.Key_File); err != nil {
+			return
+		}
+		raw = bytes.TrimSpace(raw)
+	}
+	if len(raw) == 0 {
+		return ErrMissingSignKey
+	}
+	c.key, err = parseSigningKey(c.Key_ID, c.Algorithm, raw)
+	return
+}
+
+func parseSigningKey(id, alg string, raw []byte) (k SignatureKey, err error) {
+	k = SignatureKey{ID: id, Algorithm: alg}
+	switch alg {
+	case SignHMAC:
+		k.Key = raw
+	case SignEd25519:
+		if blk, _ := pem.Decode(raw); blk != nil {
+			var pk interface{}
+			if pk, err = x509.ParsePKCS8PrivateKey(blk.Bytes); err != nil {
+				return
+			}
+			edk, ok := pk.(ed25519.PrivateKey)
+			if !ok {
+				err = ErrInvalidSignKey
+				return
+			}
+			k.Key = edk
+			return
+		}
+		var b []byte
+		if b, err = base64.StdEncoding.DecodeString(string(raw)); err != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidSignKey, err)
+			return
+		}
+		switch len(b) {
+		case ed25519.SeedSize:
+			k.Key = ed25519.NewKeyFromSeed(b)
+		case ed25519.PrivateKeySize:
+			k.Key = b
+		default:
+			err = ErrInvalidSignKey
+		}
+	default:
+		err = ErrInvalidSignAlgorithm
+	}
+	return
+}
+
+// VerifyKey returns the key needed to verify what this configuration signs
+func (c SignConfig) VerifyKey() SignatureKey {
+	k := c.key
+	if k.Algorithm == SignEd25519 {
+		k.Key = ed25519.PrivateKey(k.Key).Public().(ed25519.PublicKey)
+	}
+	return k
+}
+
+func (k SignatureKey) sign(msg []byte) []byte {
+	if k.Algorithm == SignEd25519 {
+		return ed25519.Sign(ed25519.PrivateKey(k.Key), msg)
+	}
+	h := hmac.New(sha256.New, k.Key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+func (k SignatureKey) verify(msg, sig []byte) bool {
+	switch k.Algorithm {
+	case SignEd25519:
+		if len(k.Key) == ed25519.PrivateKeySize {
+			k.Key = ed25519.PrivateKey(k.Key).Public().(ed25519.PublicKey)
+		}
+		return len(k.Key) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(k.Key), msg, sig)
+	case SignHMAC:
+		return hmac.Equal(k.sign(msg), sig)
+	}
+	return false
+}
+
+// signedContent is what an entry signature covers, the tag and source are left out
+// because they are assigned by the muxer and indexer after the entry is signed.
+func signedContent(ts entry.Timestamp, data []byte) []byte {
+	b := make([]byte, 16, 16+len(data))
+	binary.LittleEndian.PutUint64(b, uint64(ts.Sec))
+	binary.LittleEndian.PutUint64(b[8:], uint64(ts.Nsec))
+	return append(b, data...)
+}
+
+func entryHash(ent *entry.Entry) string {
+	sum := sha256.Sum256(signedContent(ent.TS, ent.Data))
+	return sigEncoding.EncodeToString(sum[:])
+}
+
+type Signer struct {
+	nocloser
+	SignConfig
+	sync.Mutex
+	tagged bool
+	tag    entry.EntryTag
+	seq    uint64
+	prev   string
+}
+
+func NewSigner(cfg SignConfig, tagger Tagger) (*Signer, error) {
+	s := &Signer{}
+	if err := s.init(cfg, tagger); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Signer) Config(v interface{}, tagger Tagger) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(SignConfig); ok {
+		err = s.init(cfg, tagger)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (s *Signer) init(cfg SignConfig, tagger Tagger) (err error) {
+	if err = cfg.validate(); err != nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.tagged = false
+	if cfg.Mode == SignModeBlock && cfg.Manifest_Tag != `` {
+		if tagger == nil {
+			return ErrNilTagger
+		} else if s.tag, err = tagger.NegotiateTag(cfg.Manifest_Tag); err != nil {
+			return
+		}
+		s.tagged = true
+	}
+	s.SignConfig = cfg
+	return
+}
+
+func (s *Signer) Process(ents []*entry.Entry) ([]*entry.Entry, error) {
+	if len(ents) == 0 {
+		return ents, nil
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.Mode == SignModeBlock {
+		return s.processBlock(ents)
+	}
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		sig := s.key.sign(signedContent(ent.TS, ent.Data))
+		trailer := signatureMarker + s.key.ID + `:` + sigEncoding.EncodeToString(sig)
+		//never write into the caller's backing array
+		data := make([]byte, 0, len(ent.Data)+len(trailer))
+		ent.Data = append(append(data, ent.Data...), trailer...)
+	}
+	return ents, nil
+}
+
+func (s *Signer) processBlock(ents []*entry.Entry) ([]*entry.Entry, error) {
+	m := SignManifest{
+		Key:       s.key.ID,
+		Algorithm: s.key.Algorithm,
+		Sequence:  s.seq,
+		Previous:  s.prev,
+	}
+	var first *entry.Entry
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		} else if first == nil {
+			first = ent
+		}
+		m.Entries = append(m.Entries, ManifestEntry{TS: ent.TS.StandardTime().UTC(), Hash: entryHash(ent)})
+	}
+	if first == nil {
+		return ents, nil
+	}
+	msg, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	m.Signature = sigEncoding.EncodeToString(s.key.sign(msg))
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	s.seq++
+	s.prev = m.Signature
+	me := &entry.Entry{
+		TS:   entry.Now(),
+		SRC:  first.SRC,
+		Tag:  first.Tag,
+		Data: body,
+	}
+	if s.tagged {
+		me.Tag = s.tag
+	}
+	return append(ents, me), nil
+}
+
+// SignatureVerifier checks entries and manifests produced by the sign preprocessor,
+// it is not safe for concurrent use.
+type SignatureVerifier struct {
+	keys map[string]SignatureKey
+	prev map[string]string // last verified manifest signature by key
+}
+
+func NewSignatureVerifier(keys ...SignatureKey) (*SignatureVerifier, error) {
+	sv := &SignatureVerifier{
+		keys: make(map[string]SignatureKey, len(keys)),
+		prev: map[string]string{},
+	}
+	for _, k := range keys {
+		if k.ID == `` || len(k.Key) == 0 {
+			return nil, ErrInvalidSignKey
+		} else if k.Algorithm != SignHMAC && k.Algorithm != SignEd25519 {
+			return nil, ErrInvalidSignAlgorithm
+		}
+		sv.keys[k.ID] = k
+	}
+	return sv, nil
+}
+
+// VerifyEntry checks the signature trailer on an entry, returning the key that signed it
+// and the entry data without the trailer.
+func (sv *SignatureVerifier) VerifyEntry(ent *entry.Entry) (id string, data []byte, err error) {
+	idx := bytes.LastIndex(ent.Data, []byte(signatureMarker))
+	if idx < 0 {
+		err = ErrSignatureMissing
+		return
+	}
+	data = ent.Data[:idx]
+	parts := strings.SplitN(string(ent.Data[idx+len(signatureMarker):]), `:`, 2)
+	if len(parts) != 2 {
+		err = ErrSignatureMissing
+		return
+	}
+	id = parts[0]
+	k, ok := sv.keys[id]
+	if !ok {
+		err = fmt.Errorf("%w %q", ErrUnknownSignKey, id)
+		return
+	}
+	raw, lerr := sigEncoding.DecodeString(parts[1])
+	if lerr != nil || !k.verify(signedContent(ent.TS, data), raw) {
+		err = ErrSignatureMismatch
+	}
+	return
+}
+
+// VerifyManifest checks a manifest entry's signature and that it covers exactly the given
+// entries in order.  Manifests from each key must be verified in sequence, the verifier
+// remembers the last one so a dropped or reordered manifest is reported.
+func (sv *SignatureVerifier) VerifyManifest(me *entry.Entry, ents []*entry.Entry) (m SignManifest, err error) {
+	if err = json.Unmarshal(me.Data, &m); err != nil {
+		return
+	}
+	k, ok := sv.keys[m.Key]
+	if !ok {
+		err = fmt.Errorf("%w %q", ErrUnknownSignKey, m.Key)
+		return
+	} else if k.Algorithm != m.Algorithm {
+		err = ErrSignatureMismatch
+		return
+	}
+	unsigned := m
+	unsigned.Signature = ``
+	msg, err := json.Marshal(unsigned)
+	if err != nil {
+		return
+	}
+	sig, err := sigEncoding.DecodeString(m.Signature)
+	if err != nil || !k.verify(msg, sig) {
+		err = ErrSignatureMismatch
+		return
+	}
+	if prev, ok := sv.prev[m.Key]; ok && prev != m.Previous {
+		err = ErrManifestChain
+		return
+	}
+	if len(ents) != len(m.Entries) {
+		err = ErrManifestMismatch
+		return
+	}
+	for i, ent := range ents {
+		if entryHash(ent) != m.Entries[i].Hash {
+			err = fmt.Errorf("%w at entry %d", ErrManifestMismatch, i)
+			return
+		}
+	}
+	sv.prev[m.Key] = m.Signature
+	return
+}
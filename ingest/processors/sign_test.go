@@ -0,0 +1,183 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func signEntries(datas ...string) (r []*entry.Entry) {
+	for _, d := range datas {
+		r = append(r, &entry.Entry{TS: entry.Now(), Tag: 1, Data: []byte(d)})
+	}
+	return
+}
+
+func TestSignConfig(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kf := filepath.Join(t.TempDir(), `sign.pem`)
+	if err = os.WriteFile(kf, pem.EncodeToMemory(&pem.Block{Type: `PRIVATE KEY`, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	b := []byte(`
+	[preprocessor "sig"]
+		type = sign
+		Algorithm = ed25519
+		Key-File = ` + kf + `
+		Key-ID = edge1
+		Mode = block
+		Manifest-Tag = signatures
+	`)
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err = config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tt testTagger
+	p, err := tc.Preprocessor.getProcessor(`sig`, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := p.(*Signer)
+	if !ok {
+		t.Fatalf("bad processor type: %T", p)
+	} else if !s.tagged || s.Mode != SignModeBlock || !priv.Equal(ed25519.PrivateKey(s.key.Key)) {
+		t.Fatalf("bad config: %+v", s.SignConfig)
+	}
+
+	bad := []SignConfig{
+		{},
+		{Key: `foo`, Algorithm: `rsa`},
+		{Key: `foo`, Mode: `stream`},
+		{Key: `foo`, Key_ID: `a:b`},
+		{Key: `foo`, Manifest_Tag: `bad tag`},
+		{Key: `notbase64!`, Algorithm: SignEd25519},
+		{Key: base64.StdEncoding.EncodeToString([]byte(`short`)), Algorithm: SignEd25519},
+	}
+	for _, c := range bad {
+		if err := c.validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", c)
+		}
+	}
+}
+
+func TestSignEntries(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []SignConfig{
+		{Key: `secret`},
+		{Key: base64.StdEncoding.EncodeToString(priv.Seed()), Algorithm: SignEd25519, Key_ID: `edge1`},
+	} {
+		s, err := NewSigner(c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ents, err := s.Process(signEntries(`hello`, `world`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sv, err := NewSignatureVerifier(s.VerifyKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, ent := range ents {
+			if id, data, err := sv.VerifyEntry(ent); err != nil {
+				t.Fatal(err)
+			} else if id != s.Key_ID || (string(data) != `hello` && string(data) != `world`) {
+				t.Fatalf("bad verification %s %q", id, data)
+			}
+		}
+		ents[0].Data[0] = 'j'
+		if _, _, err = sv.VerifyEntry(ents[0]); !errors.Is(err, ErrSignatureMismatch) {
+			t.Fatalf("tampered data not caught: %v", err)
+		}
+		ents[1].TS.Sec++
+		if _, _, err = sv.VerifyEntry(ents[1]); !errors.Is(err, ErrSignatureMismatch) {
+			t.Fatalf("tampered timestamp not caught: %v", err)
+		}
+		if _, _, err = sv.VerifyEntry(signEntries(`plain`)[0]); !errors.Is(err, ErrSignatureMissing) {
+			t.Fatalf("unsigned entry not caught: %v", err)
+		}
+	}
+
+	//a verifier without the signing key can't vouch for anything
+	s, _ := NewSigner(SignConfig{Key: `secret`, Key_ID: `other`}, nil)
+	sv, _ := NewSignatureVerifier(SignatureKey{ID: `default`, Algorithm: SignHMAC, Key: []byte(`secret`)})
+	ents, _ := s.Process(signEntries(`hello`))
+	if _, _, err = sv.VerifyEntry(ents[0]); !errors.Is(err, ErrUnknownSignKey) {
+		t.Fatalf("unknown key not caught: %v", err)
+	}
+}
+
+func TestSignBlocks(t *testing.T) {
+	var tt testTagger
+	s, err := NewSigner(SignConfig{Key: `secret`, Mode: SignModeBlock, Manifest_Tag: `signatures`}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sv, err := NewSignatureVerifier(s.VerifyKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks [][]*entry.Entry
+	var manifests []*entry.Entry
+	for i := 0; i < 3; i++ {
+		ents, err := s.Process(signEntries(`a`, `b`, `c`))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(ents) != 4 || string(ents[0].Data) != `a` || ents[3].Tag != s.tag {
+			t.Fatalf("bad block %d: %v", i, ents)
+		}
+		blocks = append(blocks, ents[:3])
+		manifests = append(manifests, ents[3])
+	}
+	if m, err := sv.VerifyManifest(manifests[0], blocks[0]); err != nil {
+		t.Fatal(err)
+	} else if m.Sequence != 0 || len(m.Entries) != 3 {
+		t.Fatalf("bad manifest %+v", m)
+	}
+	//skipping a manifest breaks the chain
+	if _, err = sv.VerifyManifest(manifests[2], blocks[2]); !errors.Is(err, ErrManifestChain) {
+		t.Fatalf("broken chain not caught: %v", err)
+	}
+	blocks[1][1].Data = []byte(`x`)
+	if _, err = sv.VerifyManifest(manifests[1], blocks[1]); !errors.Is(err, ErrManifestMismatch) {
+		t.Fatalf("tampered entry not caught: %v", err)
+	} else if _, err = sv.VerifyManifest(manifests[1], blocks[1][:2]); !errors.Is(err, ErrManifestMismatch) {
+		t.Fatalf("missing entry not caught: %v", err)
+	}
+	manifests[1].Data[len(manifests[1].Data)-3] ^= 1
+	if _, err = sv.VerifyManifest(manifests[1], blocks[1]); err == nil {
+		t.Fatal("tampered manifest not caught")
+	}
+}
@@ -0,0 +1,163 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+)
+
+const (
+	srcResolveTimeout  = 2 * time.Second
+	srcResolveTTL      = 5 * time.Minute
+	srcResolveNegTTL   = time.Minute
+	maxSrcResolveCache = 4096
+)
+
+var (
+	ErrInvalidSourceField = errors.New("Source-Field must be a dot separated path of JSON keys, e.g. host.ip")
+)
+
+// SourceFieldConfig is embedded in listener configurations to pull the entry source out of a
+// field in the payload rather than using the address of the sender.  Source-Field is a dot
+// separated path such as host.ip, if the field is missing or does not hold a usable address the
+// entry falls back to the transport source.  With Source-Field-Resolve set, hostnames (such as
+// a syslog hostname) are resolved via DNS and the results cached.
+type SourceFieldConfig struct {
+	Source_Field         string // dot separated path to the payload field holding the source, e.g. host.ip
+	Source_Field_Resolve bool   // resolve hostnames found in the source field
+}
+
+// SourceExtractor pulls entry sources out of JSON payloads.
+// A nil SourceExtractor is valid and always returns the fallback.
+type SourceExtractor struct {
+	path    []string
+	resolve bool
+	lookup  func(context.Context, string) ([]net.IPAddr, error)
+	mtx     sync.Mutex
+	cache   map[string]srcCacheEntry
+}
+
+type srcCacheEntry struct {
+	ip      net.IP // nil for failed lookups
+	expires time.Time
+}
+
+// Validate checks the source field configuration
+func (c SourceFieldConfig) Validate() (err error) {
+	_, err = c.NewSourceExtractor()
+	return
+}
+
+// NewSourceExtractor builds an extractor, if no source field is set the returned extractor is nil.
+func (c SourceFieldConfig) NewSourceExtractor() (se *SourceExtractor, err error) {
+	v := strings.TrimSpace(c.Source_Field)
+	if v == `` {
+		return
+	}
+	var path []string
+	for _, k := range strings.Split(v, `.`) {
+		if k = strings.TrimSpace(k); k == `` {
+			err = fmt.Errorf("%w: %q", ErrInvalidSourceField, c.Source_Field)
+			return
+		}
+		path = append(path, k)
+	}
+	se = &SourceExtractor{
+		path:    path,
+		resolve: c.Source_Field_Resolve,
+		lookup:  net.DefaultResolver.LookupIPAddr,
+	}
+	if se.resolve {
+		se.cache = map[string]srcCacheEntry{}
+	}
+	return
+}
+
+// Source returns the address held in the source field of a JSON payload, if the field is
+// missing, empty, or not a usable address the fallback is returned.
+func (se *SourceExtractor) Source(data []byte, fallback net.IP) net.IP {
+	if se == nil {
+		return fallback
+	}
+	v, err := jsonparser.GetString(data, se.path...)
+	if err != nil {
+		return fallback
+	}
+	if ip := se.parse(v); ip != nil {
+		return ip
+	}
+	return fallback
+}
+
+func (se *SourceExtractor) parse(v string) (ip net.IP) {
+	if v = strings.TrimSpace(v); v == `` {
+		return
+	}
+	//allow host:port and bracketed IPv6 addresses
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		v = host
+	} else {
+		v = strings.TrimSuffix(strings.TrimPrefix(v, `[`), `]`)
+	}
+	if ip = net.ParseIP(v); ip != nil {
+		if ip.IsUnspecified() {
+			ip = nil
+		}
+		return
+	} else if se.resolve {
+		ip = se.resolveHost(v)
+	}
+	return
+}
+
+func (se *SourceExtractor) resolveHost(host string) net.IP {
+	host = strings.ToLower(strings.TrimSuffix(host, `.`))
+	if host == `` {
+		return nil
+	}
+	now := time.Now()
+	se.mtx.Lock()
+	ce, ok := se.cache[host]
+	se.mtx.Unlock()
+	if ok && now.Before(ce.expires) {
+		return ce.ip
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), srcResolveTimeout)
+	defer cancel()
+	ce = srcCacheEntry{expires: now.Add(srcResolveNegTTL)}
+	if addrs, err := se.lookup(ctx, host); err == nil {
+		for _, a := range addrs {
+			//prefer IPv4 when a host has both
+			if ce.ip == nil || (ce.ip.To4() == nil && a.IP.To4() != nil) {
+				ce.ip = a.IP
+			}
+		}
+		if ce.ip != nil {
+			ce.expires = now.Add(srcResolveTTL)
+		}
+	}
+
+	se.mtx.Lock()
+	if len(se.cache) >= maxSrcResolveCache {
+		//drop everything rather than tracking usage, the cache refills quickly
+		se.cache = make(map[string]srcCacheEntry, len(se.cache))
+	}
+	se.cache[host] = ce
+	se.mtx.Unlock()
+	return ce.ip
+}
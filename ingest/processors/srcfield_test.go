@@ -0,0 +1,95 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSourceField(t *testing.T) {
+	var se *SourceExtractor
+	fallback := net.ParseIP(`10.0.0.1`)
+	if ip := se.Source([]byte(`{"host":{"ip":"1.2.3.4"}}`), fallback); !ip.Equal(fallback) {
+		t.Fatalf("nil extractor returned %v", ip)
+	}
+	if se, err := (SourceFieldConfig{}).NewSourceExtractor(); err != nil || se != nil {
+		t.Fatalf("empty config built an extractor: %v", err)
+	}
+	for _, v := range []string{`host.`, `.ip`, `host..ip`} {
+		if err := (SourceFieldConfig{Source_Field: v}).Validate(); !errors.Is(err, ErrInvalidSourceField) {
+			t.Fatalf("failed to catch bad field %q: %v", v, err)
+		}
+	}
+
+	se, err := SourceFieldConfig{Source_Field: `host.ip`}.NewSourceExtractor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for data, exp := range map[string]string{
+		`{"host":{"ip":"1.2.3.4"}}`:           `1.2.3.4`,
+		`{"host":{"ip":"1.2.3.4:514"}}`:       `1.2.3.4`,
+		`{"host":{"ip":"[fe80::1]:514"}}`:     `fe80::1`,
+		`{"host":{"ip":"fe80::2"}}`:           `fe80::2`,
+		`{"host":{"ip":"0.0.0.0"}}`:           `10.0.0.1`,
+		`{"host":{"ip":"webserver"}}`:         `10.0.0.1`,
+		`{"host":{"ip":""}}`:                  `10.0.0.1`,
+		`{"host":{"name":"1.2.3.4"}}`:         `10.0.0.1`,
+		`{"host":"1.2.3.4"}`:                  `10.0.0.1`,
+		`not json`:                            `10.0.0.1`,
+		`{"other":1,"host":{"ip":"5.6.7.8"}}`: `5.6.7.8`,
+	} {
+		if ip := se.Source([]byte(data), fallback); ip.String() != exp {
+			t.Fatalf("bad source for %s: %v != %s", data, ip, exp)
+		}
+	}
+}
+
+func TestSourceFieldResolve(t *testing.T) {
+	se, err := SourceFieldConfig{Source_Field: `hostname`, Source_Field_Resolve: true}.NewSourceExtractor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookups := map[string]int{}
+	se.lookup = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		lookups[host]++
+		switch host {
+		case `web1.example.com`:
+			return []net.IPAddr{{IP: net.ParseIP(`2001:db8::1`)}, {IP: net.ParseIP(`192.168.1.10`)}}, nil
+		case `web2.example.com`:
+			return []net.IPAddr{{IP: net.ParseIP(`2001:db8::2`)}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	fallback := net.ParseIP(`10.0.0.1`)
+	for i := 0; i < 3; i++ {
+		for data, exp := range map[string]string{
+			`{"hostname":"web1.example.com"}`:  `192.168.1.10`,
+			`{"hostname":"WEB1.example.com."}`: `192.168.1.10`,
+			`{"hostname":"web2.example.com"}`:  `2001:db8::2`,
+			`{"hostname":"missing"}`:           `10.0.0.1`,
+			`{"hostname":"172.16.0.1"}`:        `172.16.0.1`,
+		} {
+			if ip := se.Source([]byte(data), fallback); ip.String() != exp {
+				t.Fatalf("bad source for %s: %v != %s", data, ip, exp)
+			}
+		}
+	}
+	//hits and misses are both cached
+	for host, cnt := range lookups {
+		if cnt != 1 {
+			t.Fatalf("%s looked up %d times", host, cnt)
+		}
+	}
+	if len(lookups) != 3 {
+		t.Fatalf("bad lookups: %v", lookups)
+	}
+}
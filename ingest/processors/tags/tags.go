@@ -34,6 +34,13 @@ type TagResolver interface {
 	NegotiateTag(string) (entry.EntryTag, error)
 }
 
+// TagCreator is implemented by resolvers that accept wildcard tag patterns up front and
+// only create tags which match them, such as the ingest muxer
+type TagCreator interface {
+	AddTagPattern(string) error
+	GetOrCreateTag(string) (entry.EntryTag, error)
+}
+
 type TaggerConfig struct {
 	Tags []string
 }
@@ -71,6 +78,7 @@ type Tagger struct {
 	tagmap map[string]entry.EntryTag
 	globs  []glob.Glob
 	tr     TagResolver
+	tc     TagCreator
 	tm     TagMask
 }
 
@@ -88,6 +96,18 @@ func NewTagger(tc TaggerConfig, tr TagResolver) (*Tagger, error) {
 		return nil, err
 	}
 
+	//hand wildcards to resolvers that can create matching tags on demand
+	tcr, _ := tr.(TagCreator)
+	if tcr != nil {
+		for _, tn := range tc.Tags {
+			if bytes.ContainsAny([]byte(tn), wildCards) {
+				if err = tcr.AddTagPattern(tn); err != nil {
+					return nil, fmt.Errorf("Failed to negotiate tag pattern %s: %w", tn, err)
+				}
+			}
+		}
+	}
+
 	//swing through and negotiate our tags
 	for _, tn := range tgs {
 		if err := ingest.CheckTag(tn); err != nil {
@@ -104,6 +124,7 @@ func NewTagger(tc TaggerConfig, tr TagResolver) (*Tagger, error) {
 		tagmap:       tagMap,
 		globs:        globs,
 		tr:           tr,
+		tc:           tcr,
 		tm:           mask,
 	}, nil
 }
@@ -140,7 +161,18 @@ func (t *Tagger) Negotiate(tn string) (tg entry.EntryTag, err error) {
 	if tg, ok = t.tagmap[tn]; ok {
 		return
 	}
-	//tag hasn't been negotiated yet
+	//tag hasn't been negotiated yet, creators refuse tags outside our patterns
+	if t.tc != nil {
+		if ok, err = t.allowedTag(tn); err != nil {
+			return
+		} else if !ok {
+			err = ErrDisallowedTag
+			return
+		} else if tg, err = t.tc.GetOrCreateTag(tn); err == nil {
+			t.tm.Set(tg)
+		}
+		return
+	}
 	if tg, err = t.tr.NegotiateTag(tn); err == nil {
 		if ok, err = t.allowedTag(tn); err == nil && ok {
 			t.tm.Set(tg) //set the bitmask
@@ -134,3 +134,35 @@ func (ttn *testTagNeg) NegotiateTag(tn string) (tg entry.EntryTag, err error) {
 	}
 	return
 }
+
+func TestTaggerCreator(t *testing.T) {
+	im, err := ingest.NewUniformMuxer(ingest.UniformMuxerConfig{
+		Destinations: []string{`tcp://127.0.0.1:4023`},
+		Tags:         []string{`default`},
+		Auth:         `foo`,
+		IngesterName: `taggertest`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tgr, err := NewTagger(TaggerConfig{Tags: []string{`default`, `k8s-*`}}, im)
+	if err != nil {
+		t.Fatal(err)
+	} else if pats := im.TagPatterns(); len(pats) != 1 || pats[0] != `k8s-*` {
+		t.Fatalf("pattern not handed to the muxer: %v", pats)
+	}
+	tg, err := tgr.Negotiate(`k8s-web`)
+	if err != nil {
+		t.Fatal(err)
+	} else if !tgr.Allowed(tg) {
+		t.Fatal("created tag is not allowed")
+	} else if mtg, err := im.GetTag(`k8s-web`); err != nil || mtg != tg {
+		t.Fatalf("muxer did not create the tag: %v %v", mtg, err)
+	}
+	//disallowed tags never make it to the muxer
+	if _, err = tgr.Negotiate(`other`); err != ErrDisallowedTag {
+		t.Fatalf("bad error on disallowed tag: %v", err)
+	} else if _, err = im.GetTag(`other`); err != ingest.ErrTagNotFound {
+		t.Fatalf("disallowed tag was negotiated: %v", err)
+	}
+}
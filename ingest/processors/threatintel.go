@@ -0,0 +1,677 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asergeyev/nradix"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	ThreatIntelProcessor = `threatintel`
+
+	defaultThreatName = `threat`
+
+	threatFormatAuto = `auto`
+	threatFormatCSV  = `csv`
+	threatFormatSTIX = `stix`
+	threatFormatMISP = `misp`
+
+	indicatorIP     = `ip`
+	indicatorCIDR   = `cidr`
+	indicatorDomain = `domain`
+	indicatorHash   = `hash`
+	indicatorURL    = `url`
+	indicatorOther  = `value`
+
+	minThreatReload = time.Second
+
+	urlTrailing = `.:!?/&` //trimmed from URLs so a trailing slash or sentence punctuation still matches
+	urlExtra    = `/?=&%#~+@!$*`
+)
+
+var (
+	ErrMissingIndicatorFiles   = errors.New("At least one Indicator-File is required")
+	ErrUnknownIndicatorFormat  = errors.New("Unknown Indicator-Format, must be auto, csv, stix, or misp")
+	ErrInvalidReloadInterval   = fmt.Errorf("Reload-Interval must be at least %v", minThreatReload)
+	ErrNoIndicators            = errors.New("No indicators loaded")
+	ErrUnknownIndicatorPayload = errors.New("JSON indicator file is not a STIX bundle or MISP export")
+
+	stixValueRe = regexp.MustCompile(`=\s*'([^']+)'`)
+)
+
+// ThreatIntelConfig controls matching of entries against local indicator lists.
+// Indicator files may be CSV (indicator in the first column, an optional description in the second),
+// STIX 2 JSON bundles, or MISP JSON exports.  URL indicators match URLs in the data with the same
+// scheme, host, and path; a query string or fragment in the data is ignored if the whole URL does not match.
+type ThreatIntelConfig struct {
+	Indicator_File   []string
+	Indicator_Format string // auto, csv, stix, misp
+	Reload_Interval  string // optional period at which changed files are re-read
+	Match_Name       string // name used for the match annotations
+	Match_Tag        string // optional tag for entries that hit an indicator
+	Drop_Misses      bool   // drop entries that do not match any indicator
+	Annotation_Mode  string
+	Annotation_Field string
+}
+
+type indicator struct {
+	value  string
+	typ    string
+	source string
+}
+
+type indicatorSet struct {
+	vals  map[string]indicator
+	cidrs *nradix.Tree
+	cnt   int
+}
+
+type ThreatIntel struct {
+	ThreatIntelConfig
+	sync.RWMutex
+	ann    Annotator
+	routed bool
+	tag    entry.EntryTag
+	reload time.Duration
+	set    *indicatorSet
+	mtimes map[string]time.Time
+	closed bool
+	done   chan bool
+	wg     sync.WaitGroup
+}
+
+func ThreatIntelLoadConfig(vc *config.VariableConfig) (c ThreatIntelConfig, err error) {
+	if err = vc.MapTo(&c); err == nil {
+		_, err = c.validate()
+	}
+	return
+}
+
+func NewThreatIntel(cfg ThreatIntelConfig, tagger Tagger) (*ThreatIntel, error) {
+	ti := &ThreatIntel{}
+	if err := ti.init(cfg, tagger); err != nil {
+		return nil, err
+	}
+	return ti, nil
+}
+
+func (ti *ThreatIntel) Config(v interface{}, tagger Tagger) (err error) {
+	if v == nil {
+		err = ErrNilConfig
+	} else if cfg, ok := v.(ThreatIntelConfig); ok {
+		err = ti.init(cfg, tagger)
+	} else {
+		err = fmt.Errorf("Invalid configuration, unknown type type %T", v)
+	}
+	return
+}
+
+func (c *ThreatIntelConfig) validate() (reload time.Duration, err error) {
+	if len(c.Indicator_File) == 0 {
+		err = ErrMissingIndicatorFiles
+		return
+	}
+	for i := range c.Indicator_File {
+		if c.Indicator_File[i] = strings.TrimSpace(c.Indicator_File[i]); c.Indicator_File[i] == `` {
+			err = ErrMissingIndicatorFiles
+			return
+		}
+	}
+	switch c.Indicator_Format = strings.ToLower(strings.TrimSpace(c.Indicator_Format)); c.Indicator_Format {
+	case ``:
+		c.Indicator_Format = threatFormatAuto
+	case threatFormatAuto, threatFormatCSV, threatFormatSTIX, threatFormatMISP:
+	default:
+		err = ErrUnknownIndicatorFormat
+		return
+	}
+	if c.Reload_Interval = strings.TrimSpace(c.Reload_Interval); c.Reload_Interval != `` {
+		if reload, err = time.ParseDuration(c.Reload_Interval); err != nil {
+			return
+		} else if reload < minThreatReload {
+			err = ErrInvalidReloadInterval
+			return
+		}
+	}
+	if c.Match_Name = strings.TrimSpace(c.Match_Name); c.Match_Name == `` {
+		c.Match_Name = defaultThreatName
+	}
+	if c.Match_Tag = strings.TrimSpace(c.Match_Tag); c.Match_Tag != `` {
+		if err = ingest.CheckTag(c.Match_Tag); err != nil {
+			return
+		}
+	}
+	_, err = parseAnnotationMode(c.Annotation_Mode)
+	return
+}
+
+func (ti *ThreatIntel) init(cfg ThreatIntelConfig, tagger Tagger) (err error) {
+	var reload time.Duration
+	var ann Annotator
+	var set *indicatorSet
+	var mtimes map[string]time.Time
+	if reload, err = cfg.validate(); err != nil {
+		return
+	} else if ann, err = NewAnnotator(cfg.Annotation_Mode, cfg.Annotation_Field); err != nil {
+		return
+	} else if set, mtimes, err = loadIndicators(cfg.Indicator_File, cfg.Indicator_Format); err != nil {
+		return
+	}
+	//stop any existing reloader before we swap things out
+	ti.stopReloader()
+
+	ti.Lock()
+	defer ti.Unlock()
+	ti.routed = false
+	if cfg.Match_Tag != `` {
+		if tagger == nil {
+			err = errors.New("A tagger is required to route matches")
+			return
+		} else if ti.tag, err = tagger.NegotiateTag(cfg.Match_Tag); err != nil {
+			err = fmt.Errorf("Failed to negotiate tag %s: %v", cfg.Match_Tag, err)
+			return
+		}
+		ti.routed = true
+	}
+	ti.ThreatIntelConfig = cfg
+	ti.ann = ann
+	ti.reload = reload
+	ti.set = set
+	ti.mtimes = mtimes
+	ti.closed = false
+	if reload > 0 {
+		ti.done = make(chan bool)
+		ti.wg.Add(1)
+		go ti.reloader(reload, ti.done)
+	}
+	return
+}
+
+func (ti *ThreatIntel) Process(ents []*entry.Entry) (rset []*entry.Entry, err error) {
+	if len(ents) == 0 {
+		return
+	}
+	ti.RLock()
+	defer ti.RUnlock()
+	rset = ents[:0]
+	for _, ent := range ents {
+		if ent == nil {
+			continue
+		}
+		ind, ok := ti.set.match(ent.Data)
+		if !ok {
+			if !ti.Drop_Misses {
+				rset = append(rset, ent)
+			}
+			continue
+		}
+		if _, err = ti.ann.Annotate(ent,
+			Annotation{Name: ti.Match_Name, Value: ind.value},
+			Annotation{Name: ti.Match_Name + `_type`, Value: ind.typ},
+			Annotation{Name: ti.Match_Name + `_source`, Value: ind.source},
+		); err != nil {
+			return
+		}
+		if ti.routed {
+			ent.Tag = ti.tag
+		}
+		rset = append(rset, ent)
+	}
+	return
+}
+
+// Indicators returns the number of indicators currently loaded
+func (ti *ThreatIntel) Indicators() (r int) {
+	ti.RLock()
+	if ti.set != nil {
+		r = ti.set.cnt
+	}
+	ti.RUnlock()
+	return
+}
+
+// Reload re-reads any indicator files which have changed since they were last loaded.
+// If the files cannot be parsed the existing indicator set is retained.
+func (ti *ThreatIntel) Reload() (err error) {
+	ti.RLock()
+	files, format := ti.Indicator_File, ti.Indicator_Format
+	changed := indicatorsChanged(files, ti.mtimes)
+	ti.RUnlock()
+	if !changed {
+		return
+	}
+	var set *indicatorSet
+	var mtimes map[string]time.Time
+	if set, mtimes, err = loadIndicators(files, format); err != nil {
+		return
+	}
+	ti.Lock()
+	ti.set = set
+	ti.mtimes = mtimes
+	ti.Unlock()
+	return
+}
+
+func (ti *ThreatIntel) reloader(interval time.Duration, done chan bool) {
+	defer ti.wg.Done()
+	tckr := time.NewTicker(interval)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tckr.C:
+			//a failed reload keeps the existing indicators
+			ti.Reload()
+		}
+	}
+}
+
+func (ti *ThreatIntel) stopReloader() {
+	ti.Lock()
+	done := ti.done
+	ti.done = nil
+	ti.Unlock()
+	if done != nil {
+		close(done)
+		ti.wg.Wait()
+	}
+}
+
+func (ti *ThreatIntel) Flush() []*entry.Entry {
+	return nil
+}
+
+func (ti *ThreatIntel) Close() (err error) {
+	ti.RLock()
+	closed := ti.closed
+	ti.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	ti.stopReloader()
+	ti.Lock()
+	ti.closed = true
+	ti.Unlock()
+	return
+}
+
+func indicatorsChanged(files []string, mtimes map[string]time.Time) bool {
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return false //file is missing or moving, keep what we have
+		} else if mt, ok := mtimes[f]; !ok || !mt.Equal(fi.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadIndicators(files []string, format string) (set *indicatorSet, mtimes map[string]time.Time, err error) {
+	set = &indicatorSet{
+		vals: map[string]indicator{},
+	}
+	mtimes = make(map[string]time.Time, len(files))
+	for _, f := range files {
+		var fi os.FileInfo
+		var bb []byte
+		if fi, err = os.Stat(f); err != nil {
+			return
+		} else if bb, err = os.ReadFile(f); err != nil {
+			return
+		}
+		mtimes[f] = fi.ModTime()
+		src := filepath.Base(f)
+		fmtType := format
+		if fmtType == threatFormatAuto {
+			fmtType = detectIndicatorFormat(bb)
+		}
+		switch fmtType {
+		case threatFormatSTIX:
+			err = set.loadSTIX(bb, src)
+		case threatFormatMISP:
+			err = set.loadMISP(bb, src)
+		default:
+			err = set.loadCSV(bb, src)
+		}
+		if err != nil {
+			err = fmt.Errorf("Failed to load indicators from %s: %v", f, err)
+			return
+		}
+	}
+	if set.cnt == 0 {
+		err = ErrNoIndicators
+	}
+	return
+}
+
+func detectIndicatorFormat(bb []byte) string {
+	bb = bytes.TrimSpace(bb)
+	if len(bb) == 0 || bb[0] != '{' {
+		return threatFormatCSV
+	}
+	var probe struct {
+		Type    string          `json:"type"`
+		Objects json.RawMessage `json:"objects"`
+	}
+	if err := json.Unmarshal(bb, &probe); err == nil && (probe.Type == `bundle` || len(probe.Objects) > 0) {
+		return threatFormatSTIX
+	}
+	return threatFormatMISP
+}
+
+func (s *indicatorSet) add(val, src string) {
+	if val = strings.ToLower(strings.TrimSpace(val)); val == `` {
+		return
+	}
+	typ := classifyIndicator(val)
+	if typ == indicatorURL {
+		val = strings.TrimRight(val, urlTrailing)
+	}
+	if typ == indicatorCIDR {
+		if s.cidrs == nil {
+			s.cidrs = nradix.NewTree(32)
+		}
+		if err := s.cidrs.AddCIDR(val, indicator{value: val, typ: typ, source: src}); err != nil {
+			return
+		}
+	} else {
+		if typ == indicatorIP {
+			val = net.ParseIP(val).String() //normalize
+		}
+		s.vals[val] = indicator{value: val, typ: typ, source: src}
+	}
+	s.cnt++
+}
+
+func (s *indicatorSet) loadCSV(bb []byte, src string) (err error) {
+	rdr := csv.NewReader(bytes.NewReader(bb))
+	rdr.Comment = '#'
+	rdr.FieldsPerRecord = -1
+	rdr.TrimLeadingSpace = true
+	for {
+		var rec []string
+		if rec, err = rdr.Read(); err == io.EOF {
+			err = nil
+			break
+		} else if err != nil {
+			return
+		} else if len(rec) == 0 {
+			continue
+		}
+		desc := src
+		if len(rec) > 1 && strings.TrimSpace(rec[1]) != `` {
+			desc = strings.TrimSpace(rec[1])
+		}
+		s.add(rec[0], desc)
+	}
+	return
+}
+
+type stixBundle struct {
+	Type    string `json:"type"`
+	Objects []struct {
+		Type    string `json:"type"`
+		Name    string `json:"name"`
+		Pattern string `json:"pattern"`
+		Value   string `json:"value"`
+	} `json:"objects"`
+}
+
+func (s *indicatorSet) loadSTIX(bb []byte, src string) (err error) {
+	var b stixBundle
+	if err = json.Unmarshal(bb, &b); err != nil {
+		return
+	}
+	for _, obj := range b.Objects {
+		desc := src
+		if obj.Name != `` {
+			desc = obj.Name
+		}
+		switch obj.Type {
+		case `indicator`:
+			for _, m := range stixValueRe.FindAllStringSubmatch(obj.Pattern, -1) {
+				s.add(m[1], desc)
+			}
+		case `ipv4-addr`, `ipv6-addr`, `domain-name`, `url`:
+			s.add(obj.Value, desc)
+		}
+	}
+	return
+}
+
+type mispAttribute struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type mispEvent struct {
+	Info      string          `json:"info"`
+	Attribute []mispAttribute `json:"Attribute"`
+	Object    []struct {
+		Attribute []mispAttribute `json:"Attribute"`
+	} `json:"Object"`
+}
+
+type mispExport struct {
+	Event     *mispEvent      `json:"Event"`
+	Attribute []mispAttribute `json:"Attribute"`
+	Response  json.RawMessage `json:"response"`
+}
+
+func (s *indicatorSet) loadMISP(bb []byte, src string) (err error) {
+	var exp mispExport
+	if err = json.Unmarshal(bb, &exp); err != nil {
+		return
+	}
+	var found bool
+	if exp.Event != nil {
+		s.addMISPEvent(exp.Event, src)
+		found = true
+	}
+	if len(exp.Attribute) > 0 {
+		s.addMISPAttributes(exp.Attribute, src)
+		found = true
+	}
+	if len(exp.Response) > 0 {
+		//restSearch responses are either a list of events or an object of attributes
+		var evs []mispExport
+		var attrs mispExport
+		if err = json.Unmarshal(exp.Response, &evs); err == nil {
+			for _, ev := range evs {
+				if ev.Event != nil {
+					s.addMISPEvent(ev.Event, src)
+				}
+			}
+		} else if err = json.Unmarshal(exp.Response, &attrs); err == nil {
+			s.addMISPAttributes(attrs.Attribute, src)
+		} else {
+			return
+		}
+		found = true
+	}
+	if !found {
+		err = ErrUnknownIndicatorPayload
+	}
+	return
+}
+
+func (s *indicatorSet) addMISPEvent(ev *mispEvent, src string) {
+	if ev.Info != `` {
+		src = ev.Info
+	}
+	s.addMISPAttributes(ev.Attribute, src)
+	for _, obj := range ev.Object {
+		s.addMISPAttributes(obj.Attribute, src)
+	}
+}
+
+func (s *indicatorSet) addMISPAttributes(attrs []mispAttribute, src string) {
+	for _, a := range attrs {
+		//composite attributes like domain|ip and filename|md5 are pipe delimited
+		types := strings.Split(a.Type, `|`)
+		for i, v := range strings.Split(a.Value, `|`) {
+			if i < len(types) && (types[i] == `filename` || types[i] == `port`) {
+				continue //too generic to match on
+			}
+			s.add(v, src)
+		}
+	}
+}
+
+func classifyIndicator(v string) string {
+	if strings.Contains(v, `://`) {
+		return indicatorURL
+	} else if ip := net.ParseIP(v); ip != nil {
+		return indicatorIP
+	} else if _, _, err := net.ParseCIDR(v); err == nil {
+		return indicatorCIDR
+	} else if isHexString(v) && (len(v) == 32 || len(v) == 40 || len(v) == 64 || len(v) == 128) {
+		return indicatorHash
+	} else if strings.Contains(v, `.`) {
+		return indicatorDomain
+	}
+	return indicatorOther
+}
+
+func isHexString(v string) bool {
+	for _, r := range v {
+		if !(r >= '0' && r <= '9') && !(r >= 'a' && r <= 'f') {
+			return false
+		}
+	}
+	return len(v) > 0
+}
+
+// match walks the candidate tokens in the data and returns the first indicator hit
+func (s *indicatorSet) match(data []byte) (ind indicator, ok bool) {
+	if s == nil {
+		return
+	}
+	for _, tok := range indicatorTokens(data) {
+		if ind, ok = s.lookup(tok); ok {
+			return
+		}
+	}
+	return
+}
+
+func (s *indicatorSet) lookup(tok string) (ind indicator, ok bool) {
+	if ind, ok = s.vals[tok]; ok {
+		return
+	} else if strings.Contains(tok, `://`) {
+		//try again without the query string or fragment
+		if idx := strings.IndexAny(tok, `?#`); idx > 0 {
+			ind, ok = s.vals[strings.TrimRight(tok[:idx], urlTrailing)]
+		}
+		return
+	}
+	ip := net.ParseIP(tok)
+	if ip == nil {
+		//might be host:port
+		if h, _, err := net.SplitHostPort(tok); err == nil {
+			ip = net.ParseIP(h)
+		}
+	}
+	if ip != nil {
+		if ind, ok = s.vals[ip.String()]; ok {
+			return
+		} else if s.cidrs != nil {
+			if r, _ := s.cidrs.FindCIDR(ip.String()); r != nil {
+				ind, ok = r.(indicator)
+			}
+		}
+		return
+	}
+	//walk parent domains, so evil.com catches www.evil.com
+	for idx := strings.IndexByte(tok, '.'); idx >= 0; idx = strings.IndexByte(tok, '.') {
+		if tok = tok[idx+1:]; strings.IndexByte(tok, '.') < 0 {
+			break
+		} else if ind, ok = s.vals[tok]; ok {
+			return
+		}
+	}
+	return
+}
+
+func isIndicatorByte(c byte) bool {
+	return isAlphaNum(c) || c == '.' || c == ':' || c == '-' || c == '_'
+}
+
+func isURLByte(c byte) bool {
+	return isIndicatorByte(c) || strings.IndexByte(urlExtra, c) >= 0
+}
+
+// urlEnd returns the end of the URL starting at data[start], or start if there isn't one.
+// A URL is a scheme followed by :// and at least one more character.
+func urlEnd(data []byte, start int) (end int) {
+	end = start
+	i := start
+	for i < len(data) && (isAlphaNum(data[i]) || data[i] == '+' || data[i] == '.' || data[i] == '-') {
+		i++
+	}
+	if i == start || !bytes.HasPrefix(data[i:], []byte(`://`)) {
+		return
+	}
+	host := i + 3
+	for i = host; i < len(data) && isURLByte(data[i]); i++ {
+	}
+	if i > host {
+		end = i
+	}
+	return
+}
+
+func isAlphaNum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// indicatorTokens hands back the runs of characters that may make up an IP, domain, or hash.
+// URLs are also handed back whole ahead of their pieces, so they can match URL indicators.
+func indicatorTokens(data []byte) (r []string) {
+	start := -1
+	for i := 0; i <= len(data); i++ {
+		if i < len(data) && isIndicatorByte(data[i]) {
+			if start < 0 {
+				start = i
+				if end := urlEnd(data, i); end > i {
+					if tok := bytes.TrimRight(data[i:end], urlTrailing); bytes.Contains(tok, []byte(`://`)) {
+						r = append(r, strings.ToLower(string(tok)))
+					}
+				}
+			}
+			continue
+		}
+		if start >= 0 {
+			if tok := bytes.TrimRight(bytes.Trim(data[start:i], `.-_`), `:`); len(tok) > 0 {
+				r = append(r, strings.ToLower(string(tok)))
+			}
+			start = -1
+		}
+	}
+	return
+}
@@ -0,0 +1,283 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package processors
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	testIndicatorCSV = `# indicator,description
+1.2.3.4,scanner
+10.99.0.0/16,bad subnet
+evil.com,phishing
+d41d8cd98f00b204e9800998ecf8427e
+`
+	testIndicatorSTIX = `{"type": "bundle", "id": "bundle--1", "objects": [
+	{"type": "indicator", "name": "c2 server", "pattern": "[ipv4-addr:value = '5.6.7.8'] OR [domain-name:value = 'c2.example.org']"},
+	{"type": "domain-name", "value": "stixdomain.net"}
+]}`
+	testIndicatorMISP = `{"response": [{"Event": {"info": "misp event", "Attribute": [
+	{"type": "ip-dst|port", "value": "9.9.9.1|443"},
+	{"type": "filename|md5", "value": "bad.exe|0123456789abcdef0123456789abcdef"}
+]}}]}`
+)
+
+func writeIndicatorFiles(t *testing.T) (dir string, files []string) {
+	dir = t.TempDir()
+	for name, v := range map[string]string{`list.csv`: testIndicatorCSV, `bundle.json`: testIndicatorSTIX, `misp.json`: testIndicatorMISP} {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(v), 0640); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, p)
+	}
+	return
+}
+
+func TestThreatIntelConfig(t *testing.T) {
+	_, files := writeIndicatorFiles(t)
+	b := []byte(fmt.Sprintf(`
+	[preprocessor "ti"]
+		type = threatintel
+		Indicator-File = %q
+		Indicator-File = %q
+		Reload-Interval = 10m
+		Match-Tag = threats
+	`, files[0], files[1]))
+	tc := struct {
+		Global struct {
+			Foo string
+		}
+		Preprocessor ProcessorConfig
+	}{}
+	if err := config.LoadConfigBytes(&tc, b); err != nil {
+		t.Fatal(err)
+	}
+	var tt testTagger
+	p, err := tc.Preprocessor.getProcessor(`ti`, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti, ok := p.(*ThreatIntel)
+	if !ok {
+		t.Fatalf("bad processor type: %T", p)
+	} else if len(ti.Indicator_File) != 2 || ti.reload != 10*time.Minute || !ti.routed {
+		t.Fatalf("bad config: %+v", ti.ThreatIntelConfig)
+	}
+	if err = ti.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = ti.Close(); err != ErrClosed {
+		t.Fatal("failed to catch double close", err)
+	}
+}
+
+func TestThreatIntelBadConfig(t *testing.T) {
+	_, files := writeIndicatorFiles(t)
+	bad := []ThreatIntelConfig{
+		ThreatIntelConfig{},
+		ThreatIntelConfig{Indicator_File: []string{`/this/does/not/exist`}},
+		ThreatIntelConfig{Indicator_File: files, Indicator_Format: `xml`},
+		ThreatIntelConfig{Indicator_File: files, Reload_Interval: `1ms`},
+		ThreatIntelConfig{Indicator_File: files, Match_Tag: `bad tag`},
+		ThreatIntelConfig{Indicator_File: files, Annotation_Mode: `bad`},
+	}
+	var tt testTagger
+	for i, c := range bad {
+		if _, err := NewThreatIntel(c, &tt); err == nil {
+			t.Fatalf("failed to catch bad config %d: %+v", i, c)
+		}
+	}
+}
+
+func TestThreatIntelMatching(t *testing.T) {
+	_, files := writeIndicatorFiles(t)
+	var tt testTagger
+	if _, err := tt.NegotiateTag(`default`); err != nil {
+		t.Fatal(err)
+	}
+	ti, err := NewThreatIntel(ThreatIntelConfig{Indicator_File: files, Match_Tag: `threats`}, &tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ti.Close()
+	if cnt := ti.Indicators(); cnt != 9 {
+		t.Fatalf("bad indicator count: %d", cnt)
+	}
+	tag := tt.mp[`threats`]
+	tests := []struct {
+		data   string
+		val    string
+		typ    string
+		source string
+	}{
+		{data: `{"src": "1.2.3.4", "dst": "192.168.1.1"}`, val: `1.2.3.4`, typ: indicatorIP, source: `scanner`},
+		{data: `{"src": "10.99.1.1:5555"}`, val: `10.99.0.0/16`, typ: indicatorCIDR, source: `bad subnet`},
+		{data: `{"url": "https://www.EVIL.com/login"}`, val: `evil.com`, typ: indicatorDomain, source: `phishing`},
+		{data: `{"hash": "D41D8CD98F00B204E9800998ECF8427E"}`, val: `d41d8cd98f00b204e9800998ecf8427e`, typ: indicatorHash, source: `list.csv`},
+		{data: `{"query": "c2.example.org."}`, val: `c2.example.org`, typ: indicatorDomain, source: `c2 server`},
+		{data: `{"dst": "5.6.7.8"}`, val: `5.6.7.8`, typ: indicatorIP, source: `c2 server`},
+		{data: `{"dst": "9.9.9.1"}`, val: `9.9.9.1`, typ: indicatorIP, source: `misp event`},
+		{data: `{"md5": "0123456789abcdef0123456789abcdef"}`, val: `0123456789abcdef0123456789abcdef`, typ: indicatorHash, source: `misp event`},
+	}
+	for i, tst := range tests {
+		ents := makeEntry([]byte(tst.data), 0)
+		set, err := ti.Process(ents)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(set) != 1 {
+			t.Fatalf("%d bad set count: %d", i, len(set))
+		} else if set[0].Tag != tag {
+			t.Fatalf("%d entry not routed", i)
+		}
+		for k, v := range map[string]string{`threat`: tst.val, `threat_type`: tst.typ, `threat_source`: tst.source} {
+			if s, err := jsonparser.GetString(set[0].Data, k); err != nil || s != v {
+				t.Fatalf("%d bad %s: %q != %q (%v) %s", i, k, s, v, err, set[0].Data)
+			}
+		}
+	}
+
+	//check misses
+	for _, v := range []string{`{"src": "1.2.3.5"}`, `{"domain": "notevil.com"}`, `{"file": "bad.exe"}`, `{"port": 443}`} {
+		ents := makeEntry([]byte(v), 0)
+		if set, err := ti.Process(ents); err != nil {
+			t.Fatal(err)
+		} else if len(set) != 1 || string(set[0].Data) != v || set[0].Tag != 0 {
+			t.Fatalf("miss was modified: %q", set[0].Data)
+		}
+	}
+
+	ti.Drop_Misses = true
+	if set, err := ti.Process(makeEntry([]byte(`nothing to see here`), 0)); err != nil {
+		t.Fatal(err)
+	} else if len(set) != 0 {
+		t.Fatal("failed to drop miss")
+	}
+}
+
+func TestThreatIntelURLs(t *testing.T) {
+	dir := t.TempDir()
+	stix := filepath.Join(dir, `urls.json`)
+	misp := filepath.Join(dir, `misp.json`)
+	if err := os.WriteFile(stix, []byte(`{"type": "bundle", "id": "bundle--2", "objects": [
+	{"type": "url", "value": "http://Bad.example.com/payload.exe"},
+	{"type": "indicator", "name": "kit", "pattern": "[url:value = 'https://kit.example.net/gate.php?id=7']"}
+]}`), 0640); err != nil {
+		t.Fatal(err)
+	} else if err = os.WriteFile(misp, []byte(`{"Attribute": [{"type": "url", "value": "http://drop.example.org/a/"}]}`), 0640); err != nil {
+		t.Fatal(err)
+	}
+	ti, err := NewThreatIntel(ThreatIntelConfig{Indicator_File: []string{stix, misp}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ti.Close()
+	tests := []struct {
+		data   string
+		val    string
+		source string
+	}{
+		{data: `{"req": "GET http://bad.example.com/payload.exe 200"}`, val: `http://bad.example.com/payload.exe`, source: `urls.json`},
+		{data: `{"url": "http://bad.example.com/payload.exe?x=1#y"}`, val: `http://bad.example.com/payload.exe`, source: `urls.json`},
+		{data: `{"msg": "referer=https://kit.example.net/gate.php?id=7&"}`, val: `https://kit.example.net/gate.php?id=7`, source: `kit`},
+		{data: `{"msg": "see http://drop.example.org/a."}`, val: `http://drop.example.org/a`, source: `misp.json`},
+	}
+	for i, tst := range tests {
+		set, err := ti.Process(makeEntry([]byte(tst.data), 0))
+		if err != nil {
+			t.Fatal(err)
+		} else if len(set) != 1 {
+			t.Fatalf("%d bad set count: %d", i, len(set))
+		}
+		for k, v := range map[string]string{`threat`: tst.val, `threat_type`: indicatorURL, `threat_source`: tst.source} {
+			if s, err := jsonparser.GetString(set[0].Data, k); err != nil || s != v {
+				t.Fatalf("%d bad %s: %q != %q (%v) %s", i, k, s, v, err, set[0].Data)
+			}
+		}
+	}
+
+	//other paths on the same host and other query strings are not hits
+	for _, v := range []string{`http://bad.example.com/other.exe`, `https://kit.example.net/gate.php?id=8`, `bad.example.com`} {
+		if set, err := ti.Process(makeEntry([]byte(v), 0)); err != nil {
+			t.Fatal(err)
+		} else if len(set) != 1 || string(set[0].Data) != v {
+			t.Fatalf("miss was modified: %q", set[0].Data)
+		}
+	}
+}
+
+func TestThreatIntelReload(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, `list.csv`)
+	if err := os.WriteFile(p, []byte("1.1.1.1\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	ti, err := NewThreatIntel(ThreatIntelConfig{Indicator_File: []string{p}, Annotation_Mode: AnnotatePrefix}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ti.Close()
+	if err = os.WriteFile(p, []byte("1.1.1.1\n2.2.2.2\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	//make sure the mtime moves even on coarse filesystems
+	if err = os.Chtimes(p, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	} else if err = ti.Reload(); err != nil {
+		t.Fatal(err)
+	} else if ti.Indicators() != 2 {
+		t.Fatalf("reload failed: %d", ti.Indicators())
+	}
+	set, err := ti.Process(makeEntry([]byte(`blocked 2.2.2.2`), 0))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(set) != 1 || string(set[0].Data) != `threat=2.2.2.2 threat_type=ip threat_source=list.csv blocked 2.2.2.2` {
+		t.Fatalf("bad annotation: %q", set[0].Data)
+	}
+
+	//a broken file should keep the old indicators
+	if err = os.WriteFile(p, []byte(``), 0640); err != nil {
+		t.Fatal(err)
+	} else if err = os.Chtimes(p, time.Now(), time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	} else if err = ti.Reload(); err == nil {
+		t.Fatal("failed to catch empty indicator file")
+	} else if ti.Indicators() != 2 {
+		t.Fatal("lost indicators on failed reload")
+	}
+}
+
+func BenchmarkThreatIntel(b *testing.B) {
+	dir := b.TempDir()
+	p := filepath.Join(dir, `list.csv`)
+	if err := os.WriteFile(p, []byte(testIndicatorCSV), 0640); err != nil {
+		b.Fatal(err)
+	}
+	ti, err := NewThreatIntel(ThreatIntelConfig{Indicator_File: []string{p}}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ti.Close()
+	ent := &entry.Entry{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ent.Data = benchmarkVal
+		if _, err := ti.Process([]*entry.Entry{ent}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"golang.org/x/time/rate"
+)
+
+// tagRates builds a limiter for every Tag-Rate-Limit, keyed by tag name.  Writers of a limited
+// tag are held back before their entries are queued, so a bulk tag cannot fill the queues and
+// delay everything else.
+func tagRates(isc config.IngestStreamConfig) (map[string]*rate.Limiter, error) {
+	trls, err := isc.TagRateLimits()
+	if err != nil {
+		return nil, err
+	}
+	lms := make(map[string]*rate.Limiter, len(trls))
+	for _, trl := range trls {
+		if err := CheckTag(trl.Tag); err != nil {
+			return nil, fmt.Errorf("Invalid tag rate limit tag %q %v", trl.Tag, err)
+		}
+		lms[trl.Tag] = newRateLimiter(trl.RateLimit)
+	}
+	return lms, nil
+}
+
+// destinationRates builds a parent for every destination with a Destination-Rate-Limit, the first
+// matching limit wins.  Each destination gets its own limiter even if they share a limit.
+func destinationRates(isc config.IngestStreamConfig, dests []Target) (map[string]*parent, error) {
+	drls, err := isc.DestinationRateLimits()
+	if err != nil {
+		return nil, err
+	}
+	ps := make(map[string]*parent, len(drls))
+	for _, d := range dests {
+		for _, drl := range drls {
+			if drl.Matches(d.Address) {
+				ps[d.Address] = newRateParent(drl.Bps, drl.Burst)
+				break
+			}
+		}
+	}
+	return ps, nil
+}
+
+func newRateLimiter(rl config.RateLimit) *rate.Limiter {
+	burst := int(rl.Burst / 8)
+	if burst <= 0 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(rl.Bps/8), burst)
+}
+
+// updateTagRates rebuilds the tag value to limiter map used on the write path
+// the caller must hold the write lock
+func (im *IngestMuxer) updateTagRates() {
+	lm := make(map[entry.EntryTag]*rate.Limiter, len(im.tagRates))
+	for name, l := range im.tagRates {
+		if tg, ok := im.tagMap[name]; ok {
+			lm[tg] = l
+		}
+	}
+	im.rateMap.Store(lm)
+}
+
+// tagLimiter returns the limiter for a tag, or nil if it is not limited
+func (im *IngestMuxer) tagLimiter(tg entry.EntryTag) *rate.Limiter {
+	if lm, ok := im.rateMap.Load().(map[entry.EntryTag]*rate.Limiter); ok && len(lm) > 0 {
+		return lm[tg]
+	}
+	return nil
+}
+
+// waitTagRate blocks until the entry's tag is within its rate limit
+func (im *IngestMuxer) waitTagRate(ctx context.Context, e *entry.Entry) error {
+	if l := im.tagLimiter(e.Tag); l != nil {
+		return waitN(ctx, l, int(e.Size()))
+	}
+	return nil
+}
+
+// waitBatchRate blocks until every tag in the batch is within its rate limit
+func (im *IngestMuxer) waitBatchRate(ctx context.Context, b []*entry.Entry) error {
+	lm, ok := im.rateMap.Load().(map[entry.EntryTag]*rate.Limiter)
+	if !ok || len(lm) == 0 {
+		return nil
+	}
+	var sizes map[*rate.Limiter]int
+	for _, e := range b {
+		if e == nil {
+			continue
+		} else if l := lm[e.Tag]; l != nil {
+			if sizes == nil {
+				sizes = map[*rate.Limiter]int{}
+			}
+			sizes[l] += int(e.Size())
+		}
+	}
+	for l, sz := range sizes {
+		if err := waitN(ctx, l, sz); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitN waits for n bytes, taking no more than a burst at a time so large writes do not fail
+func waitN(ctx context.Context, l *rate.Limiter, n int) error {
+	for burst := l.Burst(); n > 0; n -= burst {
+		sz := n
+		if sz > burst {
+			sz = burst
+		}
+		if err := l.WaitN(ctx, sz); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitTagRateTimeout is waitTagRate for writers with a timeout, ErrWriteTimeout is returned if
+// the tag cannot be admitted in time
+func (im *IngestMuxer) waitTagRateTimeout(e *entry.Entry, d time.Duration) error {
+	l := im.tagLimiter(e.Tag)
+	if l == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if err := waitN(ctx, l, int(e.Size())); err != nil {
+		return ErrWriteTimeout
+	}
+	return nil
+}
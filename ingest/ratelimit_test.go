@@ -0,0 +1,101 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestTagRateLimits(t *testing.T) {
+	//64kbit is 8KB a second, with a 1KB burst
+	isc := config.IngestStreamConfig{Tag_Rate_Limit: []string{`backfill:64kbit:8kbit`, `later:1Mbit`}}
+	rates, err := tagRates(isc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	im := &IngestMuxer{
+		mtx:      &sync.RWMutex{},
+		tagMap:   map[string]entry.EntryTag{`backfill`: 1, `realtime`: 2},
+		tagRates: rates,
+	}
+	im.updateTagRates()
+	if im.tagLimiter(1) == nil || im.tagLimiter(2) != nil {
+		t.Fatal("bad tag limiters")
+	}
+
+	data := make([]byte, 1024-entry.ENTRY_HEADER_SIZE)
+	ts := time.Now()
+	for i := 0; i < 100; i++ {
+		if err = im.waitTagRate(context.Background(), &entry.Entry{Tag: 2, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if time.Since(ts) > 100*time.Millisecond {
+		t.Fatal("unlimited tag was slowed down")
+	}
+
+	ts = time.Now()
+	b := []*entry.Entry{{Tag: 1, Data: data}, {Tag: 2, Data: data}}
+	for i := 0; i < 3; i++ {
+		if err = im.waitBatchRate(context.Background(), b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//the first KB is the burst, the next 2KB take a quarter second
+	if d := time.Since(ts); d < 200*time.Millisecond {
+		t.Fatalf("limited tag was not slowed down: %v", d)
+	}
+
+	//writers with timeouts give up
+	if err = im.waitTagRateTimeout(&entry.Entry{Tag: 1, Data: make([]byte, 8192)}, 10*time.Millisecond); err != ErrWriteTimeout {
+		t.Fatalf("bad timeout error: %v", err)
+	}
+
+	//tags negotiated later pick up their limits
+	im.tagMap[`later`] = 3
+	im.updateTagRates()
+	if im.tagLimiter(3) == nil {
+		t.Fatal("negotiated tag missing its limit")
+	}
+
+	if _, err = tagRates(config.IngestStreamConfig{Tag_Rate_Limit: []string{`bad)tag:1Mbit`}}); err == nil {
+		t.Fatal("failed to catch invalid tag")
+	}
+}
+
+func TestDestinationRateLimits(t *testing.T) {
+	isc := config.IngestStreamConfig{Destination_Rate_Limit: []string{`10.0.0.1,8Mbit,16Mbit`}}
+	dests := []Target{{Address: `tcp://10.0.0.1:4023`}, {Address: `tls://10.0.0.1:4024`}, {Address: `tcp://10.0.0.2:4023`}}
+	ps, err := destinationRates(isc, dests)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ps) != 2 || ps[dests[0].Address] == ps[dests[1].Address] {
+		t.Fatalf("bad destination limits: %v", ps)
+	} else if p := ps[dests[0].Address]; p.burst != 2*1024*1024 {
+		t.Fatalf("bad burst: %d", p.burst)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	if tc := newParentsThrottleConn(c1, nil, nil); tc != nil {
+		t.Fatal("throttled a connection without limits")
+	}
+	global := newParent(1024*1024, 0)
+	if tc := newParentsThrottleConn(c1, global, ps[dests[0].Address]); tc == nil || len(tc.lms) != 2 || tc.burst != 1024*1024 {
+		t.Fatalf("bad throttled connection: %+v", tc)
+	}
+}
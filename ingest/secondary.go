@@ -0,0 +1,326 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+
+	"github.com/gobwas/glob"
+)
+
+const (
+	secondaryStateKey = `secondary`
+	// how long a write waits on the secondary group for copies of entries also sent to the primary
+	secondaryMirrorTimeout = 100 * time.Millisecond
+	// once the secondary group stalls, copies are dropped without waiting for this long
+	secondaryMirrorBackoff = 5 * time.Second
+)
+
+var (
+	ErrSecondaryCachePath = errors.New("Secondary destination group must use its own cache path")
+)
+
+// SecondaryConfig describes an independent group of indexers that selected tags are written
+// to in addition to, or instead of, the primary destinations.  A typical use is mirroring data
+// to a disaster recovery cluster.  The group keeps its own connections, health, and cache so
+// an outage on one group does not spill entries into the cache of the other.
+//
+// Tags and OnlyTags take tag names or glob patterns.  Tags matching OnlyTags are written only to
+// the secondary group, tags matching Tags are written to both.  If both are empty every tag is
+// written to both groups.
+//
+// Entries written to both groups go to the primary group first.  The copy only waits briefly on
+// the secondary group, if it cannot keep up copies are dropped and counted for a while rather
+// than holding up the primary group.  Give the secondary group a cache so an outage does not
+// lose copies.  Entries written only to the secondary group wait on it like any other write.
+type SecondaryConfig struct {
+	Destinations []Target
+	CachePath    string
+	CacheSize    int
+	Tags         []string
+	OnlyTags     []string
+}
+
+// UniformSecondaryConfig is a SecondaryConfig where every destination shares a secret and tenant
+type UniformSecondaryConfig struct {
+	Destinations []string
+	Tenant       string
+	Auth         string
+	CachePath    string
+	CacheSize    int
+	Tags         []string
+	OnlyTags     []string
+}
+
+// SecondaryFromConfig builds the secondary destination group described by an ingester's
+// global config section, it returns nil if no secondary targets are configured.
+func SecondaryFromConfig(ic *config.IngestConfig) (*UniformSecondaryConfig, error) {
+	if ic == nil || !ic.SecondaryEnabled() {
+		return nil, nil
+	}
+	conns, err := ic.SecondaryTargets()
+	if err != nil {
+		return nil, err
+	}
+	return &UniformSecondaryConfig{
+		Destinations: conns,
+		Auth:         ic.SecondarySecret(),
+		CachePath:    ic.Secondary_Ingest_Cache_Path,
+		CacheSize:    ic.SecondaryCacheSize(),
+		Tags:         ic.Secondary_Tag,
+		OnlyTags:     ic.Secondary_Only_Tag,
+	}, nil
+}
+
+// secondaryConfig expands the uniform config, the secret may only be empty if Kerberos is used
+func (usc *UniformSecondaryConfig) secondaryConfig(kerberos bool) (*SecondaryConfig, error) {
+	if usc == nil {
+		return nil, nil
+	} else if len(usc.Auth) == 0 && !kerberos {
+		return nil, ErrEmptyAuth
+	}
+	sc := &SecondaryConfig{
+		Destinations: make([]Target, len(usc.Destinations)),
+		CachePath:    usc.CachePath,
+		CacheSize:    usc.CacheSize,
+		Tags:         usc.Tags,
+		OnlyTags:     usc.OnlyTags,
+	}
+	for i := range usc.Destinations {
+		sc.Destinations[i] = Target{
+			Address: usc.Destinations[i],
+			Secret:  usc.Auth,
+			Tenant:  usc.Tenant,
+		}
+	}
+	return sc, nil
+}
+
+// tagRoutes decides which tags go to the secondary destination group
+type tagRoutes struct {
+	all  bool
+	dup  []glob.Glob
+	only []glob.Glob
+}
+
+func newTagRoutes(dup, only []string) (tr tagRoutes, err error) {
+	if tr.dup, err = compileTagMatches(dup); err != nil {
+		return
+	} else if tr.only, err = compileTagMatches(only); err != nil {
+		return
+	}
+	tr.all = len(dup) == 0 && len(only) == 0
+	return
+}
+
+func compileTagMatches(pats []string) (r []glob.Glob, err error) {
+	for _, p := range pats {
+		var g glob.Glob
+		if g, err = glob.Compile(p); err != nil {
+			err = fmt.Errorf("Invalid secondary tag pattern %q %v", p, err)
+			return
+		}
+		r = append(r, g)
+	}
+	return
+}
+
+// route returns whether the named tag is written to the secondary group and whether it is
+// written only to the secondary group.  Secondary only patterns win over duplicate patterns.
+func (tr tagRoutes) route(name string) (secondary, only bool) {
+	for _, g := range tr.only {
+		if g.Match(name) {
+			return true, true
+		}
+	}
+	if tr.all {
+		return true, false
+	}
+	for _, g := range tr.dup {
+		if g.Match(name) {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// secondaryRoute is the secondary group's tag value for a primary tag value
+type secondaryRoute struct {
+	tag  entry.EntryTag
+	only bool
+}
+
+// newSecondary builds the muxer for the secondary destination group, it shares everything
+// with the primary muxer except destinations, cache, and tag values.
+func newSecondary(c MuxerConfig) (*IngestMuxer, tagRoutes, error) {
+	sc := c.Secondary
+	routes, err := newTagRoutes(sc.Tags, sc.OnlyTags)
+	if err != nil {
+		return nil, routes, err
+	} else if len(sc.Destinations) == 0 {
+		return nil, routes, ErrNoTargets
+	} else if sc.CachePath != `` && c.CachePath != `` && filepath.Clean(sc.CachePath) == filepath.Clean(c.CachePath) {
+		return nil, routes, ErrSecondaryCachePath
+	}
+	c.Secondary = nil
+	c.Destinations = sc.Destinations
+	//tag rate limits are applied by the primary before entries are routed
+	c.Tag_Rate_Limit = nil
+	c.CachePath = sc.CachePath
+	c.CacheSize = sc.CacheSize
+	tags := c.Tags
+	c.Tags = nil
+	for _, name := range tags {
+		if ok, _ := routes.route(name); ok {
+			c.Tags = append(c.Tags, name)
+		}
+	}
+	sm, err := newIngestMuxer(c)
+	return sm, routes, err
+}
+
+// Secondary returns the muxer for the secondary destination group, or nil if there is none.
+// Its Hot, Dead, and Size methods report the health of the secondary indexers, which is tracked
+// independently of the primary indexers.  Tag values from the secondary muxer are not valid on
+// the primary muxer.
+func (im *IngestMuxer) Secondary() *IngestMuxer {
+	return im.secondary
+}
+
+// updateRoutes rebuilds the tag value to secondary tag map used on the write path,
+// negotiating any newly routed tags with the secondary group.
+// the caller must hold the write lock
+func (im *IngestMuxer) updateRoutes() error {
+	if im.secondary == nil {
+		return nil
+	}
+	rm := make(map[entry.EntryTag]secondaryRoute, len(im.tagMap))
+	for name, tg := range im.tagMap {
+		ok, only := im.routes.route(name)
+		if !ok {
+			continue
+		}
+		stg, err := im.secondary.NegotiateTag(name)
+		if err != nil {
+			return err
+		}
+		rm[tg] = secondaryRoute{tag: stg, only: only}
+	}
+	im.routeMap.Store(rm)
+	return nil
+}
+
+// secondaryEntry returns the copy of an entry bound for the secondary group, if any, and
+// whether the entry should still go to the primary group.  Entries are copied because the
+// muxer rewrites tags in place as it sends them.
+func (im *IngestMuxer) secondaryEntry(e *entry.Entry) (se *entry.Entry, primary bool) {
+	rm, _ := im.routeMap.Load().(map[entry.EntryTag]secondaryRoute)
+	r, ok := rm[e.Tag]
+	if !ok {
+		return nil, true
+	}
+	ne := *e
+	ne.Tag = r.tag
+	return &ne, !r.only
+}
+
+// splitEntry returns the copy of an entry when it is bound only for the secondary group,
+// otherwise the copies to mirror to the secondary group once the entry is with the primary
+func (im *IngestMuxer) splitEntry(e *entry.Entry) (only *entry.Entry, mirror []*entry.Entry) {
+	if im.secondary == nil {
+		return
+	}
+	if se, primary := im.secondaryEntry(e); se == nil {
+		return
+	} else if !primary {
+		return se, nil
+	} else {
+		return nil, []*entry.Entry{se}
+	}
+}
+
+// secondaryBatch splits a batch into the copies of entries going to both groups, the copies
+// bound only for the secondary group, and the entries bound for the primary group.  Batches
+// with nothing routed exclusively to the secondary group are handed back to the primary group
+// without a copy.
+func (im *IngestMuxer) secondaryBatch(b []*entry.Entry) (mb, sb, pb []*entry.Entry) {
+	rm, _ := im.routeMap.Load().(map[entry.EntryTag]secondaryRoute)
+	if len(rm) == 0 {
+		return nil, nil, b
+	}
+	var split bool
+	for i, e := range b {
+		r, ok := rm[e.Tag]
+		if ok {
+			ne := *e
+			ne.Tag = r.tag
+			if r.only {
+				sb = append(sb, &ne)
+			} else {
+				mb = append(mb, &ne)
+			}
+		}
+		if ok && r.only {
+			if !split {
+				split = true
+				pb = append(make([]*entry.Entry, 0, len(b)), b[:i]...)
+			}
+		} else if split {
+			pb = append(pb, e)
+		}
+	}
+	if !split {
+		pb = b
+	}
+	return
+}
+
+// mirror hands copies of entries written to the primary group to the secondary group.  A slow
+// or dead secondary group never holds up the primary, copies it cannot take in time are dropped.
+func (im *IngestMuxer) mirror(ctx context.Context, ents []*entry.Entry, p Priority, prio bool) {
+	if len(ents) == 0 || im.secondary == nil {
+		return
+	}
+	stalled := atomic.LoadInt64(&im.mirrorStalled)
+	if stalled != 0 && time.Since(time.Unix(0, stalled)) < secondaryMirrorBackoff {
+		atomic.AddUint64(&im.mirrorDropped, uint64(len(ents)))
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, secondaryMirrorTimeout)
+	defer cancel()
+	var err error
+	if prio {
+		err = im.secondary.WriteBatchPriorityContext(ctx, ents, p)
+	} else {
+		err = im.secondary.WriteBatchContext(ctx, ents)
+	}
+	if err != nil {
+		atomic.AddUint64(&im.mirrorDropped, uint64(len(ents)))
+		if atomic.SwapInt64(&im.mirrorStalled, time.Now().UnixNano()) == 0 {
+			im.Warn("secondary destinations are not keeping up, dropping copies", log.KVErr(err))
+		}
+	} else if stalled != 0 && atomic.CompareAndSwapInt64(&im.mirrorStalled, stalled, 0) {
+		im.Info("secondary destinations caught up", log.KV("dropped", atomic.LoadUint64(&im.mirrorDropped)))
+	}
+}
+
+// SecondaryDropped returns the number of entry copies the secondary destination group could
+// not take in time and were dropped
+func (im *IngestMuxer) SecondaryDropped() uint64 {
+	return atomic.LoadUint64(&im.mirrorDropped)
+}
@@ -0,0 +1,232 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestTagRoutes(t *testing.T) {
+	tr, err := newTagRoutes(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if ok, only := tr.route(`anything`); !ok || only {
+		t.Fatal("empty routes should mirror every tag")
+	}
+
+	if tr, err = newTagRoutes([]string{`fw-*`, `auth`}, []string{`fw-debug`, `bulk*`}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		secondary bool
+		only      bool
+	}{
+		{`fw-edge`, true, false},
+		{`auth`, true, false},
+		{`fw-debug`, true, true},
+		{`bulk-pcap`, true, true},
+		{`syslog`, false, false},
+		{`authlog`, false, false},
+	}
+	for _, tst := range tests {
+		if ok, only := tr.route(tst.name); ok != tst.secondary || only != tst.only {
+			t.Fatalf("bad route for %s: %v %v", tst.name, ok, only)
+		}
+	}
+	if _, err = newTagRoutes([]string{`fw-[`}, nil); err == nil {
+		t.Fatal("failed to catch bad pattern")
+	}
+}
+
+func TestSecondaryMuxer(t *testing.T) {
+	c := MuxerConfig{
+		Destinations: []Target{{Address: `tcp://127.0.0.1:4023`, Secret: `foo`}},
+		Tags:         []string{`syslog`, `fw-edge`, `bulk`},
+		Secondary: &SecondaryConfig{
+			Destinations: []Target{{Address: `tcp://127.0.0.2:4023`, Secret: `bar`}},
+			Tags:         []string{`fw-*`},
+			OnlyTags:     []string{`bulk`},
+		},
+	}
+	im, err := newIngestMuxer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := im.Secondary()
+	if sm == nil {
+		t.Fatal("missing secondary muxer")
+	} else if sm.Secondary() != nil {
+		t.Fatal("secondary muxer has a secondary")
+	} else if len(sm.dests) != 1 || sm.dests[0].Secret != `bar` {
+		t.Fatalf("bad secondary destinations: %+v", sm.dests)
+	}
+	tags := sm.KnownTags()
+	sort.Strings(tags)
+	if len(tags) != 2 || tags[0] != `bulk` || tags[1] != `fw-edge` {
+		t.Fatalf("bad secondary tags: %v", tags)
+	}
+
+	syslog, _ := im.GetTag(`syslog`)
+	fw, _ := im.GetTag(`fw-edge`)
+	bulk, _ := im.GetTag(`bulk`)
+	sfw, _ := sm.GetTag(`fw-edge`)
+	sbulk, _ := sm.GetTag(`bulk`)
+
+	e := &entry.Entry{Tag: fw, Data: []byte(`fw`)}
+	if se, primary := im.secondaryEntry(e); se == nil || !primary {
+		t.Fatal("duplicated tag not routed to both groups")
+	} else if se == e || se.Tag != sfw || e.Tag != fw || string(se.Data) != `fw` {
+		t.Fatalf("bad secondary copy: %+v", se)
+	}
+	if se, primary := im.secondaryEntry(&entry.Entry{Tag: bulk}); se == nil || primary || se.Tag != sbulk {
+		t.Fatal("secondary only tag routed to the primary group")
+	}
+	if se, primary := im.secondaryEntry(&entry.Entry{Tag: syslog}); se != nil || !primary {
+		t.Fatal("primary tag routed to the secondary group")
+	}
+
+	//batches with nothing exclusive to the secondary group go to the primary untouched
+	b := []*entry.Entry{{Tag: syslog}, {Tag: fw}, {Tag: syslog}}
+	if mb, sb, pb := im.secondaryBatch(b); len(mb) != 1 || mb[0].Tag != sfw || len(sb) != 0 || len(pb) != 3 || &pb[0] != &b[0] {
+		t.Fatalf("bad batch split: %v %v %v", mb, sb, pb)
+	}
+	b = []*entry.Entry{{Tag: syslog}, {Tag: bulk}, {Tag: fw}, {Tag: bulk}}
+	mb, sb, pb := im.secondaryBatch(b)
+	if len(mb) != 1 || mb[0].Tag != sfw {
+		t.Fatalf("bad mirrored batch: %v", mb)
+	} else if len(sb) != 2 || sb[0].Tag != sbulk || sb[1].Tag != sbulk {
+		t.Fatalf("bad secondary batch: %v", sb)
+	} else if len(pb) != 2 || pb[0] != b[0] || pb[1] != b[2] {
+		t.Fatalf("bad primary batch: %v", pb)
+	}
+	if only, mirror := im.splitEntry(e); only != nil || len(mirror) != 1 || mirror[0].Tag != sfw {
+		t.Fatalf("bad entry split: %v %v", only, mirror)
+	} else if only, mirror = im.splitEntry(&entry.Entry{Tag: bulk}); only == nil || len(mirror) != 0 {
+		t.Fatalf("bad secondary only split: %v %v", only, mirror)
+	}
+
+	//newly negotiated tags are routed
+	tg, err := im.NegotiateTag(`fw-core`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stg, err := sm.GetTag(`fw-core`)
+	if err != nil {
+		t.Fatal("negotiated tag missing from the secondary group")
+	}
+	if se, _ := im.secondaryEntry(&entry.Entry{Tag: tg}); se == nil || se.Tag != stg {
+		t.Fatal("negotiated tag not routed")
+	}
+	if _, err = im.NegotiateTag(`dns`); err != nil {
+		t.Fatal(err)
+	} else if _, err = sm.GetTag(`dns`); err == nil {
+		t.Fatal("unrouted tag negotiated with the secondary group")
+	}
+}
+
+func TestSecondaryConfig(t *testing.T) {
+	dir := t.TempDir()
+	c := UniformMuxerConfig{
+		Destinations: []string{`tcp://127.0.0.1:4023`},
+		Auth:         `foo`,
+		CachePath:    dir,
+		Secondary: &UniformSecondaryConfig{
+			Destinations: []string{`tcp://127.0.0.2:4023`},
+			CachePath:    dir,
+		},
+	}
+	if _, err := newUniformIngestMuxerEx(c); err != ErrEmptyAuth {
+		t.Fatalf("failed to catch missing secondary secret: %v", err)
+	}
+	c.Secondary.Auth = `bar`
+	if _, err := newUniformIngestMuxerEx(c); err != ErrSecondaryCachePath {
+		t.Fatalf("failed to catch shared cache path: %v", err)
+	}
+	c.Secondary.CachePath = ``
+	c.Secondary.Destinations = nil
+	if _, err := newUniformIngestMuxerEx(c); err != ErrNoTargets {
+		t.Fatalf("failed to catch missing secondary targets: %v", err)
+	}
+}
+
+func TestSecondaryStalled(t *testing.T) {
+	col := &serverCollector{ents: map[string][]string{}}
+	srv, err := NewIngestServer(ServerConfig{Secret: `foo`, Handler: col.handle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lst)
+	//nothing listens on the secondary address and it has no cache, so it stops taking entries
+	//once its queue is full
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.Addr().String()
+	dead.Close()
+
+	im, err := NewUniformMuxer(UniformMuxerConfig{
+		Destinations: []string{`tcp://` + lst.Addr().String()},
+		Tags:         []string{`foo`},
+		Auth:         `foo`,
+		IngesterName: `secondarytest`,
+		CacheDepth:   4,
+		Secondary: &UniformSecondaryConfig{
+			Destinations: []string{`tcp://` + deadAddr},
+			Auth:         `foo`,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = im.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer im.Close()
+	if err = im.WaitForHot(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	foo, _ := im.GetTag(`foo`)
+	const count = 64
+	start := time.Now()
+	for i := 0; i < count; i++ {
+		e := &entry.Entry{TS: entry.Now(), Tag: foo, Data: []byte(fmt.Sprintf("e%d", i))}
+		if i%2 == 0 {
+			err = im.WriteEntry(e)
+		} else {
+			err = im.WriteBatch([]*entry.Entry{e})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	//only the first copy to time out waits on the secondary, the rest are dropped in the backoff
+	if d := time.Since(start); d > secondaryMirrorBackoff {
+		t.Fatalf("stalled secondary held up primary writes for %v", d)
+	} else if dropped := im.SecondaryDropped(); dropped == 0 || dropped > count {
+		t.Fatalf("bad dropped count %d", dropped)
+	}
+	for i := 0; i < 500 && col.count(`foo`) < count; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := col.count(`foo`); n != count {
+		t.Fatalf("primary got %d of %d entries", n, count)
+	}
+}
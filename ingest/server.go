@@ -0,0 +1,588 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	defaultHandshakeTimeout = 30 * time.Second
+	acceptRetryDelay        = 100 * time.Millisecond
+)
+
+var (
+	ErrServerClosed      = errors.New("Ingest server closed")
+	ErrNoEntryHandler    = errors.New("Ingest server requires an entry handler")
+	ErrTagSpaceExhausted = errors.New("No tag IDs remain")
+	ErrIngesterNotHot    = errors.New("Ingester did not signal it is ready to ingest")
+	ErrEmptyTenantLabel  = errors.New("Tenant secrets require a tenant label")
+	ErrDuplicateSecret   = errors.New("Ingest secret is assigned more than once")
+)
+
+// EntryHandler is called with every entry read from a connected ingester.  Entries have already
+// been acknowledged when the handler sees them; returning an error drops the connection.
+// Handlers are called concurrently from each connection.
+type EntryHandler func(sc *ServerConn, ent *entry.Entry) error
+
+// TenantSecret is an additional ingest secret, ingesters that authenticate with it are assigned
+// the tenant label regardless of the tenant they claim.
+type TenantSecret struct {
+	Tenant string
+	Secret string
+}
+
+// ServerConfig configures an IngestServer, at least one of Secret, Tenants, or KerberosKeytab
+// is required.  Ingesters authenticating with Kerberos need a ticket for a service principal
+// in the keytab, they may claim any tenant.
+type ServerConfig struct {
+	Secret             string         // shared secret ingesters must authenticate with
+	Tenants            []TenantSecret // per tenant secrets
+	KerberosKeytab     string         // keytab holding the service principal keys, enables Kerberos authentication
+	KerberosPrincipals []string       // ingester principals allowed to authenticate, empty allows any
+	Handler            EntryHandler   // called with every entry received
+	Timeout            time.Duration  // drop ingesters that have been silent this long, defaults to 10 minutes
+	HandshakeTimeout   time.Duration  // time allowed for authentication and tag negotiation, defaults to 30 seconds
+	Logger             Logger         // optional
+
+	// Connected is called once an ingester is ready to send entries, returning an error drops
+	// the connection.  Disconnected is called when a connected ingester goes away.  Both are
+	// optional and let users keep per connection state.
+	Connected    func(sc *ServerConn) error
+	Disconnected func(sc *ServerConn)
+}
+
+// IngestServer implements the indexer side of the ingest protocol: the authentication challenge,
+// tag negotiation, stream configuration, and entry framing.  It lets user code receive entries
+// from ingesters and federators in order to build relays and fan-in collectors.
+//
+// Tag names are negotiated into a tag set shared by every connection on the server, so an entry's
+// tag can be resolved with LookupTag regardless of which ingester sent it.
+type IngestServer struct {
+	mtx    sync.Mutex
+	wg     sync.WaitGroup
+	cfg    ServerConfig
+	auths  []serverAuth
+	krb    *kerberosVerifier
+	tags   *serverTags
+	lgr    Logger
+	lsts   map[net.Listener]struct{}
+	conns  map[*ServerConn]struct{}
+	closed bool
+}
+
+type serverAuth struct {
+	hash   AuthHash
+	tenant string
+	fixed  bool // the tenant is set by the secret rather than the ingester
+}
+
+// ServerConn is a single authenticated ingester connection
+type ServerConn struct {
+	conn      net.Conn
+	er        *EntryReader
+	tags      *serverTags
+	src       net.IP
+	tenant    string
+	principal string
+}
+
+// NewIngestServer validates the configuration and creates an IngestServer, call Serve or
+// ServeConn to start receiving entries.
+func NewIngestServer(cfg ServerConfig) (*IngestServer, error) {
+	if cfg.Secret == `` && len(cfg.Tenants) == 0 && cfg.KerberosKeytab == `` {
+		return nil, ErrEmptyAuth
+	} else if cfg.Handler == nil {
+		return nil, ErrNoEntryHandler
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultReaderTimeout
+	}
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = defaultHandshakeTimeout
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewDiscardLogger()
+	}
+	auths, err := genServerAuths(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var krb *kerberosVerifier
+	if cfg.KerberosKeytab != `` {
+		if krb, err = newKerberosVerifier(cfg.KerberosKeytab, cfg.KerberosPrincipals); err != nil {
+			return nil, err
+		}
+	}
+	return &IngestServer{
+		cfg:   cfg,
+		auths: auths,
+		krb:   krb,
+		tags:  newServerTags(),
+		lgr:   cfg.Logger,
+		lsts:  map[net.Listener]struct{}{},
+		conns: map[*ServerConn]struct{}{},
+	}, nil
+}
+
+func genServerAuths(cfg ServerConfig) (auths []serverAuth, err error) {
+	secrets := make(map[string]bool, len(cfg.Tenants)+1)
+	if cfg.Secret != `` {
+		var a serverAuth
+		if a.hash, err = GenAuthHash(cfg.Secret); err != nil {
+			return
+		}
+		secrets[cfg.Secret] = true
+		auths = append(auths, a)
+	}
+	for _, ts := range cfg.Tenants {
+		if ts.Secret == `` {
+			return nil, ErrEmptyAuth
+		} else if ts.Tenant == `` {
+			return nil, ErrEmptyTenantLabel
+		} else if len(ts.Tenant) > int(MaxTenantNameLength) {
+			return nil, ErrInvalidTenantName
+		} else if secrets[ts.Secret] {
+			return nil, fmt.Errorf("%w: tenant %s", ErrDuplicateSecret, ts.Tenant)
+		}
+		a := serverAuth{tenant: ts.Tenant, fixed: true}
+		if a.hash, err = GenAuthHash(ts.Secret); err != nil {
+			return
+		}
+		secrets[ts.Secret] = true
+		auths = append(auths, a)
+	}
+	return
+}
+
+// Serve accepts ingester connections on the listener and serves each one in its own goroutine.
+// Serve blocks until the listener fails or the server is closed, in which case ErrServerClosed
+// is returned.  Wrap the listener with tls.NewListener to serve encrypted ingesters.
+func (s *IngestServer) Serve(l net.Listener) error {
+	if err := s.addListener(l); err != nil {
+		return err
+	}
+	defer s.removeListener(l)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			} else if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				s.lgr.Warn("temporary accept error", log.KV("address", l.Addr()), log.KVErr(err))
+				time.Sleep(acceptRetryDelay)
+				continue
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			if err := s.ServeConn(conn); err != nil {
+				s.lgr.Warn("ingester connection failed", log.KV("remote", conn.RemoteAddr()), log.KVErr(err))
+			}
+		}()
+	}
+}
+
+// ServeConn performs the ingest handshake on an established connection and hands every entry it
+// reads to the handler.  ServeConn blocks until the ingester disconnects, the handler returns an
+// error, or the server is closed.  A clean disconnect returns nil.
+func (s *IngestServer) ServeConn(conn net.Conn) (err error) {
+	sc := &ServerConn{
+		conn: conn,
+		tags: s.tags,
+		src:  addrIP(conn.RemoteAddr()),
+	}
+	if err = s.addConn(sc); err != nil {
+		conn.Close()
+		return
+	}
+	defer s.removeConn(sc)
+	defer conn.Close()
+
+	if err = s.handshake(sc); err != nil {
+		return
+	}
+	defer sc.er.Close()
+	name, version, uuid := sc.IngesterInfo()
+	if s.cfg.Connected != nil {
+		if err = s.cfg.Connected(sc); err != nil {
+			return
+		}
+	}
+	if s.cfg.Disconnected != nil {
+		defer s.cfg.Disconnected(sc)
+	}
+	s.lgr.Info("ingester connected", log.KV("remote", conn.RemoteAddr()), log.KV("ingester", name),
+		log.KV("version", version), log.KV("ingesteruuid", uuid), log.KV("tenant", sc.tenant))
+
+	var ent *entry.Entry
+	for {
+		if ent, err = sc.er.Read(); err != nil {
+			if err == io.EOF || s.isClosed() {
+				err = nil
+			}
+			break
+		}
+		if len(ent.SRC) == 0 {
+			ent.SRC = sc.src
+		}
+		if err = s.cfg.Handler(sc, ent); err != nil {
+			break
+		}
+	}
+	s.lgr.Info("ingester disconnected", log.KV("remote", conn.RemoteAddr()), log.KV("ingester", name), log.KV("ingesteruuid", uuid))
+	return
+}
+
+// handshake runs the authentication and tag negotiation then gets the entry stream configured.
+func (s *IngestServer) handshake(sc *ServerConn) (err error) {
+	if err = sc.conn.SetDeadline(time.Now().Add(s.cfg.HandshakeTimeout)); err != nil {
+		return
+	}
+	//the handshake messages are read straight off the connection so that nothing destined for
+	//the entry reader is buffered, some of them do not tolerate short reads
+	rdr := fullReader{r: sc.conn}
+
+	var chal Challenge
+	var resp ChallengeResponse
+	if chal, err = NewChallenge(AuthHash{}); err != nil {
+		return
+	}
+	if s.krb != nil {
+		//advertise Kerberos support
+		chal.Version = MinKerberosAuthVersion
+	}
+	if err = chal.Write(sc.conn); err != nil {
+		return
+	} else if err = resp.Read(rdr); err != nil {
+		return
+	}
+	var authed bool
+	if len(resp.Kerberos) > 0 {
+		authed = s.kerberosAuth(sc, chal, resp)
+	} else {
+		for _, a := range s.auths {
+			if VerifyResponse(a.hash, chal, resp) == nil {
+				if authed = true; a.fixed {
+					sc.tenant = a.tenant
+				} else {
+					sc.tenant = resp.Tenant
+				}
+				break
+			}
+		}
+	}
+	if !authed {
+		state := StateResponse{ID: STATE_NOT_AUTHENTICATED}
+		state.Write(sc.conn)
+		return ErrFailedAuth
+	}
+	state := StateResponse{ID: STATE_AUTHENTICATED}
+	if err = state.Write(sc.conn); err != nil {
+		return
+	}
+
+	var tagReq TagRequest
+	if err = tagReq.Read(rdr); err != nil {
+		return
+	}
+	tagResp := TagResponse{Tags: make(map[string]entry.EntryTag, len(tagReq.Tags))}
+	for _, name := range tagReq.Tags {
+		var tg entry.EntryTag
+		if tg, err = s.tags.GetAndPopulate(name); err != nil {
+			//an empty response tells the ingester negotiation failed
+			tagResp.Tags = map[string]entry.EntryTag{}
+			break
+		}
+		tagResp.Tags[name] = tg
+	}
+	tagResp.Count = uint32(len(tagResp.Tags))
+	if werr := tagResp.Write(sc.conn); werr != nil {
+		return werr
+	} else if err != nil {
+		return fmt.Errorf("%w: %v", ErrFailedTagNegotiation, err)
+	} else if tagResp.Count == 0 {
+		return ErrFailedTagNegotiation
+	}
+	if err = state.Read(rdr); err != nil {
+		return
+	} else if state.ID != STATE_HOT {
+		return ErrIngesterNotHot
+	} else if err = sc.conn.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	//the ingester is hot, everything else goes through the entry reader
+	sc.er, err = NewEntryReaderEx(EntryReaderWriterConfig{
+		Conn:                  sc.conn,
+		OutstandingEntryCount: MAX_UNCONFIRMED_COUNT,
+		BufferSize:            READ_BUFFER_SIZE,
+		Timeout:               s.cfg.Timeout,
+		TagMan:                s.tags,
+	})
+	if err != nil {
+		return
+	} else if err = sc.er.Start(); err != nil {
+		return
+	}
+	if err = sc.er.SetupConnection(); err == nil {
+		//ingesters that predate the ingest OK query go straight to sending entries
+		if sc.er.GetIngesterAPIVersion() >= MINIMUM_INGEST_OK_VERSION {
+			err = sc.er.IngestOK(true)
+		}
+		if err == nil {
+			err = sc.er.ConfigureStream()
+		}
+	}
+	if err != nil {
+		sc.er.Close()
+	}
+	return
+}
+
+// kerberosAuth checks a Kerberos challenge response, failures are logged because the
+// ingester is only told that it was rejected
+func (s *IngestServer) kerberosAuth(sc *ServerConn, chal Challenge, resp ChallengeResponse) bool {
+	if s.krb == nil {
+		s.lgr.Warn("ingester attempted Kerberos authentication, which is not enabled", log.KV("remote", sc.conn.RemoteAddr()))
+		return false
+	}
+	principal, err := s.krb.verify(resp.Kerberos, chal, sc.conn.RemoteAddr())
+	if err != nil {
+		s.lgr.Warn("Kerberos authentication failed", log.KV("remote", sc.conn.RemoteAddr()), log.KVErr(err))
+		return false
+	}
+	sc.principal, sc.tenant = principal, resp.Tenant
+	return true
+}
+
+// Close stops every listener passed to Serve, drops all connected ingesters, and waits for the
+// connections to finish.
+func (s *IngestServer) Close() error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return ErrServerClosed
+	}
+	s.closed = true
+	for l := range s.lsts {
+		l.Close()
+	}
+	for sc := range s.conns {
+		sc.conn.Close()
+	}
+	s.mtx.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// LookupTag resolves a tag ID on a received entry to its name
+func (s *IngestServer) LookupTag(tg entry.EntryTag) (string, bool) {
+	return s.tags.LookupTag(tg)
+}
+
+// GetTag returns the tag ID assigned to a name, tags are assigned when ingesters negotiate them
+func (s *IngestServer) GetTag(name string) (entry.EntryTag, bool) {
+	return s.tags.GetTag(name)
+}
+
+// KnownTags returns the names of every tag negotiated with the server, sorted
+func (s *IngestServer) KnownTags() []string {
+	return s.tags.KnownTags()
+}
+
+// Connections returns the number of connected ingesters, including those still negotiating
+func (s *IngestServer) Connections() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return len(s.conns)
+}
+
+func (s *IngestServer) isClosed() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.closed
+}
+
+func (s *IngestServer) addListener(l net.Listener) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	s.lsts[l] = struct{}{}
+	return nil
+}
+
+func (s *IngestServer) removeListener(l net.Listener) {
+	s.mtx.Lock()
+	delete(s.lsts, l)
+	s.mtx.Unlock()
+}
+
+func (s *IngestServer) addConn(sc *ServerConn) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	s.conns[sc] = struct{}{}
+	return nil
+}
+
+func (s *IngestServer) removeConn(sc *ServerConn) {
+	s.mtx.Lock()
+	delete(s.conns, sc)
+	s.mtx.Unlock()
+}
+
+// RemoteAddr returns the address of the ingester
+func (sc *ServerConn) RemoteAddr() net.Addr {
+	return sc.conn.RemoteAddr()
+}
+
+// Principal returns the Kerberos principal of an ingester that authenticated with Kerberos
+func (sc *ServerConn) Principal() string {
+	return sc.principal
+}
+
+// Tenant returns the tenant the ingester authenticated as.  Ingesters using a tenant secret get
+// its label, everyone else gets the tenant they asked for, which is usually the SystemTenant.
+func (sc *ServerConn) Tenant() string {
+	return sc.tenant
+}
+
+// Conn returns the underlying connection so that callers can inspect it, it must not be read or written
+func (sc *ServerConn) Conn() net.Conn {
+	return sc.conn
+}
+
+// IngesterInfo returns the name, version, and UUID the ingester identified itself with
+func (sc *ServerConn) IngesterInfo() (name, version, uuid string) {
+	if sc.er != nil {
+		name, version, uuid = sc.er.GetIngesterInfo()
+	}
+	return
+}
+
+// IngesterState returns the most recent state report sent by the ingester
+func (sc *ServerConn) IngesterState() IngesterState {
+	return sc.er.GetIngesterState()
+}
+
+// TagHints returns the storage hints sent by the ingester
+func (sc *ServerConn) TagHints() []config.TagHint {
+	return sc.er.GetTagHints()
+}
+
+// LookupTag resolves a tag ID on an entry from this connection to its name
+func (sc *ServerConn) LookupTag(tg entry.EntryTag) (string, bool) {
+	return sc.tags.LookupTag(tg)
+}
+
+// serverTags is the tag set shared by all of a server's connections, it is the TagManager
+// handed to each EntryReader so ingesters can negotiate new tags on the fly.
+type serverTags struct {
+	sync.RWMutex
+	ids   map[string]entry.EntryTag
+	names map[entry.EntryTag]string
+	next  entry.EntryTag
+}
+
+func newServerTags() *serverTags {
+	return &serverTags{
+		ids: map[string]entry.EntryTag{
+			entry.DefaultTagName:  entry.DefaultTagId,
+			entry.GravwellTagName: entry.GravwellTagId,
+		},
+		names: map[entry.EntryTag]string{
+			entry.DefaultTagId:  entry.DefaultTagName,
+			entry.GravwellTagId: entry.GravwellTagName,
+		},
+		next: entry.DefaultTagId + 1,
+	}
+}
+
+// GetAndPopulate implements the TagManager interface
+func (st *serverTags) GetAndPopulate(name string) (tg entry.EntryTag, err error) {
+	if err = CheckTag(name); err != nil {
+		return
+	}
+	st.Lock()
+	defer st.Unlock()
+	var ok bool
+	if tg, ok = st.ids[name]; ok {
+		return
+	} else if st.next == entry.GravwellTagId {
+		err = ErrTagSpaceExhausted
+		return
+	}
+	tg = st.next
+	st.next++
+	st.ids[name] = tg
+	st.names[tg] = name
+	return
+}
+
+func (st *serverTags) GetTag(name string) (tg entry.EntryTag, ok bool) {
+	st.RLock()
+	tg, ok = st.ids[name]
+	st.RUnlock()
+	return
+}
+
+func (st *serverTags) LookupTag(tg entry.EntryTag) (name string, ok bool) {
+	st.RLock()
+	name, ok = st.names[tg]
+	st.RUnlock()
+	return
+}
+
+func (st *serverTags) KnownTags() (r []string) {
+	st.RLock()
+	r = make([]string, 0, len(st.ids))
+	for k := range st.ids {
+		r = append(r, k)
+	}
+	st.RUnlock()
+	sort.Strings(r)
+	return
+}
+
+// fullReader turns short reads into complete ones for decoders that expect a single Read to
+// return a whole message.
+type fullReader struct {
+	r io.Reader
+}
+
+func (fr fullReader) Read(b []byte) (int, error) {
+	return io.ReadFull(fr.r, b)
+}
+
+func addrIP(a net.Addr) net.IP {
+	switch v := a.(type) {
+	case *net.TCPAddr:
+		return v.IP
+	case *net.UDPAddr:
+		return v.IP
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+type serverCollector struct {
+	sync.Mutex
+	ents  map[string][]string
+	names []string
+	conns int
+}
+
+func (c *serverCollector) handle(sc *ServerConn, ent *entry.Entry) error {
+	name, ok := sc.LookupTag(ent.Tag)
+	if !ok {
+		return fmt.Errorf("unknown tag %d", ent.Tag)
+	}
+	c.Lock()
+	c.ents[name] = append(c.ents[name], string(ent.Data))
+	igName, _, _ := sc.IngesterInfo()
+	c.names = append(c.names, igName)
+	c.Unlock()
+	return nil
+}
+
+func (c *serverCollector) count(tag string) int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.ents[tag])
+}
+
+func TestIngestServer(t *testing.T) {
+	if _, err := NewIngestServer(ServerConfig{Secret: `foo`}); err != ErrNoEntryHandler {
+		t.Fatalf("failed to catch missing handler: %v", err)
+	}
+	col := &serverCollector{ents: map[string][]string{}}
+	srv, err := NewIngestServer(ServerConfig{
+		Secret:  `foo`,
+		Handler: col.handle,
+		Connected: func(sc *ServerConn) error {
+			col.Lock()
+			col.conns++
+			col.Unlock()
+			return nil
+		},
+		Disconnected: func(sc *ServerConn) {
+			col.Lock()
+			col.conns--
+			col.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(lst) }()
+
+	//a bad secret is rejected during the handshake
+	tgt := Target{Address: `tcp://` + lst.Addr().String(), Secret: `bar`}
+	if _, err = initConnection(tgt, []string{`foo`}, ``, ``, false, tlsOptions{}, nil); err != ErrFailedAuth {
+		t.Fatalf("bad secret not rejected: %v", err)
+	}
+
+	im, err := NewUniformMuxer(UniformMuxerConfig{
+		Destinations: []string{`tcp://` + lst.Addr().String()},
+		Tags:         []string{`foo`, `bar`},
+		Auth:         `foo`,
+		IngesterName: `servertest`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = im.Start(); err != nil {
+		t.Fatal(err)
+	} else if err = im.WaitForHot(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	foo, _ := im.GetTag(`foo`)
+	bar, _ := im.GetTag(`bar`)
+	for i := 0; i < 100; i++ {
+		if err = im.WriteEntry(&entry.Entry{TS: entry.Now(), Tag: foo, Data: []byte(fmt.Sprintf("foo %d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = im.WriteEntry(&entry.Entry{TS: entry.Now(), Tag: bar, Data: []byte("bar")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = im.Sync(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	//entries are acked before they are handed off, give the handler a moment to catch up
+	for i := 0; i < 500 && col.count(`bar`) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if col.count(`foo`) != 100 || col.count(`bar`) != 1 {
+		t.Fatalf("missing entries: %d %d", col.count(`foo`), col.count(`bar`))
+	}
+	col.Lock()
+	if col.ents[`foo`][99] != `foo 99` || col.names[0] != `servertest` {
+		t.Fatalf("bad entries: %v %v", col.ents[`foo`][99], col.names[0])
+	}
+	col.Unlock()
+	if err = im.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//tags negotiated after the connection is hot land in the same tag set
+	tgt.Secret = `foo`
+	igst, err := initConnection(tgt, []string{`foo`}, ``, ``, false, tlsOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = igst.IdentifyIngester(`direct`, `1`, ``); err != nil {
+		t.Fatal(err)
+	} else if ok, err := igst.IngestOK(); err != nil || !ok {
+		t.Fatalf("ingest not ok: %v", err)
+	} else if err = igst.ew.ConfigureStream(StreamConfiguration{}); err != nil {
+		t.Fatal(err)
+	}
+	baz, err := igst.NegotiateTag(`baz`)
+	if err != nil {
+		t.Fatal(err)
+	} else if tg, ok := srv.GetTag(`baz`); !ok || tg != baz {
+		t.Fatalf("negotiated tag missing: %v", srv.KnownTags())
+	} else if err = igst.WriteEntrySync(&entry.Entry{TS: entry.Now(), Tag: baz, Data: []byte("baz")}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500 && col.count(`baz`) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if col.count(`baz`) != 1 {
+		t.Fatal("missing entry on negotiated tag")
+	}
+	igst.Close()
+
+	if err = srv.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = <-serveErr; err != ErrServerClosed {
+		t.Fatalf("bad serve exit: %v", err)
+	} else if n := srv.Connections(); n != 0 || col.conns != 0 {
+		t.Fatalf("%d connections left open, %d not disconnected", n, col.conns)
+	}
+}
+
+func TestServerTags(t *testing.T) {
+	st := newServerTags()
+	if tg, err := st.GetAndPopulate(entry.GravwellTagName); err != nil || tg != entry.GravwellTagId {
+		t.Fatalf("bad gravwell tag: %v %v", tg, err)
+	}
+	a, err := st.GetAndPopulate(`a`)
+	if err != nil || a != 1 {
+		t.Fatalf("bad tag: %v %v", a, err)
+	} else if again, _ := st.GetAndPopulate(`a`); again != a {
+		t.Fatal("tag renumbered")
+	} else if _, err = st.GetAndPopulate(`bad tag`); err == nil {
+		t.Fatal("failed to catch invalid tag")
+	}
+	st.next = entry.GravwellTagId
+	if _, err = st.GetAndPopulate(`b`); err != ErrTagSpaceExhausted {
+		t.Fatalf("failed to catch exhausted tags: %v", err)
+	}
+}
+
+func TestServerTenants(t *testing.T) {
+	bad := []ServerConfig{
+		{Tenants: []TenantSecret{{Tenant: `acme`}}},
+		{Tenants: []TenantSecret{{Secret: `acme`}}},
+		{Secret: `sys`, Tenants: []TenantSecret{{Tenant: `acme`, Secret: `sys`}}},
+	}
+	for _, cfg := range bad {
+		cfg.Handler = func(*ServerConn, *entry.Entry) error { return nil }
+		if _, err := NewIngestServer(cfg); err == nil {
+			t.Fatalf("failed to catch bad tenant config %+v", cfg)
+		}
+	}
+
+	tenants := make(chan string, 8)
+	srv, err := NewIngestServer(ServerConfig{
+		Secret:  `sys`,
+		Tenants: []TenantSecret{{Tenant: `acme`, Secret: `acmesecret`}, {Tenant: `beta`, Secret: `betasecret`}},
+		Handler: func(*ServerConn, *entry.Entry) error { return nil },
+		Connected: func(sc *ServerConn) error {
+			tenants <- sc.Tenant()
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lst)
+
+	tests := []struct {
+		secret  string
+		claimed string
+		tenant  string
+	}{
+		{`sys`, ``, ``},
+		{`sys`, `gamma`, `gamma`},      //the shared secret trusts the claimed tenant
+		{`betasecret`, ``, `beta`},     //tenant secrets assign their label
+		{`acmesecret`, `beta`, `acme`}, //and cannot be used to claim another tenant
+	}
+	for _, tst := range tests {
+		im, err := NewUniformMuxer(UniformMuxerConfig{
+			Destinations: []string{`tcp://` + lst.Addr().String()},
+			Tags:         []string{`foo`},
+			Auth:         tst.secret,
+			Tenant:       tst.claimed,
+			IngesterName: `tenanttest`,
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if err = im.Start(); err != nil {
+			t.Fatal(err)
+		} else if err = im.WaitForHot(5 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case tenant := <-tenants:
+			if tenant != tst.tenant {
+				t.Fatalf("secret %s claiming %q got tenant %q, expected %q", tst.secret, tst.claimed, tenant, tst.tenant)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("ingester never connected")
+		}
+		im.Close()
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 )
 
@@ -78,11 +79,11 @@ func InitializeConnection(dst, authString string, tags []string, pubKey, privKey
 		Address: dst,
 		Secret:  authString,
 	}
-	return initConnection(tgt, tags, pubKey, privKey, verifyRemoteKey)
+	return initConnection(tgt, tags, pubKey, privKey, verifyRemoteKey, tlsOptions{}, nil)
 }
 
-func initConnection(tgt Target, tags []string, pubKey, privKey string, verifyRemoteKey bool) (*IngestConnection, error) {
-	auth, err := GenAuthHash(tgt.Secret)
+func initConnection(tgt Target, tags []string, pubKey, privKey string, verifyRemoteKey bool, to tlsOptions, krb *kerberosAuth) (*IngestConnection, error) {
+	hash, err := GenAuthHash(tgt.Secret)
 	if err != nil {
 		return nil, err
 	}
@@ -90,6 +91,13 @@ func initConnection(tgt Target, tags []string, pubKey, privKey string, verifyRem
 	if err != nil {
 		return nil, err
 	}
+	auth := ingestAuth{hash: hash}
+	if krb != nil {
+		auth.krb, auth.host = krb, kerberosHost(dest)
+	}
+	if err = checkFIPSTarget(tgt.Address, verifyRemoteKey || to.verifies()); err != nil {
+		return nil, err
+	}
 	switch t {
 	//figure out which connection is specified
 	case "tls":
@@ -103,7 +111,7 @@ func initConnection(tgt Target, tags []string, pubKey, privKey string, verifyRem
 		} else if certs == nil {
 			return nil, ErrInvalidCerts
 		}
-		return newTLSConnection(dest, tgt.Tenant, auth, certs, verifyRemoteKey, tags)
+		return newTLSConnection(dest, tgt.Tenant, auth, certs, verifyRemoteKey, to, tags)
 	case "tcp":
 		return newTCPConnection(dest, tgt.Tenant, auth, tags)
 	case "pipe":
@@ -189,14 +197,14 @@ func checkTLSPublicKey(local, remote []byte) bool {
 //
 // Deprecated: Use the IngestMuxer instead.
 func NewTLSConnection(dst string, auth AuthHash, certs *TLSCerts, verify bool, tags []string) (*IngestConnection, error) {
-	return newTLSConnection(dst, SystemTenant, auth, certs, verify, tags)
+	return newTLSConnection(dst, SystemTenant, ingestAuth{hash: auth}, certs, verify, tlsOptions{}, tags)
 }
 
-func newTLSConnection(dst, tenant string, auth AuthHash, certs *TLSCerts, verify bool, tags []string) (*IngestConnection, error) {
+func newTLSConnection(dst, tenant string, auth ingestAuth, certs *TLSCerts, verify bool, to tlsOptions, tags []string) (*IngestConnection, error) {
 	if err := checkTags(tags); err != nil {
 		return nil, err
 	}
-	conn, src, err := newTlsConn(dst, certs, verify)
+	conn, src, err := newTlsConn(dst, certs, verify, to)
 	if err != nil {
 		return nil, err
 	}
@@ -205,18 +213,23 @@ func newTLSConnection(dst, tenant string, auth AuthHash, certs *TLSCerts, verify
 }
 
 //negotiate a TLS connection and check the public cert if requested
-func newTlsConn(dst string, certs *TLSCerts, verify bool) (net.Conn, net.IP, error) {
+func newTlsConn(dst string, certs *TLSCerts, verify bool, to tlsOptions) (net.Conn, net.IP, error) {
 	var src net.IP
 
-	config := tls.Config{
+	tcfg := tls.Config{
 		InsecureSkipVerify: !verify,
 	}
 	if certs != nil {
-		config.Certificates = []tls.Certificate{certs.Cert}
+		tcfg.Certificates = []tls.Certificate{certs.Cert}
+	}
+	if err := to.apply(&tcfg); err != nil {
+		return nil, src, err
+	} else if err = config.FIPSTLSConfig(&tcfg); err != nil {
+		return nil, src, err
 	}
 
 	dialer := &net.Dialer{Timeout: 5 * time.Second}
-	conn, err := tls.DialWithDialer(dialer, "tcp", dst, &config)
+	conn, err := tls.DialWithDialer(dialer, "tcp", dst, &tcfg)
 	if err != nil {
 		return nil, src, err
 	}
@@ -241,10 +254,10 @@ func newTlsConn(dst string, certs *TLSCerts, verify bool) (net.Conn, net.IP, err
 //
 // Deprecated: Use the IngestMuxer instead.
 func NewTCPConnection(dst string, auth AuthHash, tags []string) (*IngestConnection, error) {
-	return newTCPConnection(dst, SystemTenant, auth, tags)
+	return newTCPConnection(dst, SystemTenant, ingestAuth{hash: auth}, tags)
 }
 
-func newTCPConnection(dst, tenant string, auth AuthHash, tags []string) (*IngestConnection, error) {
+func newTCPConnection(dst, tenant string, auth ingestAuth, tags []string) (*IngestConnection, error) {
 	err := checkTags(tags)
 	if err != nil {
 		return nil, err
@@ -282,10 +295,10 @@ func newTcpConn(dst string) (net.Conn, net.IP, error) {
 //
 // Deprecated: Use the IngestMuxer instead.
 func NewPipeConnection(dst string, auth AuthHash, tags []string) (*IngestConnection, error) {
-	return newPipeConnection(dst, SystemTenant, auth, tags)
+	return newPipeConnection(dst, SystemTenant, ingestAuth{hash: auth}, tags)
 }
 
-func newPipeConnection(dst, tenant string, auth AuthHash, tags []string) (*IngestConnection, error) {
+func newPipeConnection(dst, tenant string, auth ingestAuth, tags []string) (*IngestConnection, error) {
 	err := checkTags(tags)
 	if err != nil {
 		return nil, err
@@ -306,7 +319,7 @@ func newPipeConn(dst string) (net.Conn, net.IP, error) {
 	return conn, localhostAddr, nil
 }
 
-func negotiateEntryWriter(conn net.Conn, tenant string, auth AuthHash, tags []string) (*EntryWriter, map[string]entry.EntryTag, error) {
+func negotiateEntryWriter(conn net.Conn, tenant string, auth ingestAuth, tags []string) (*EntryWriter, map[string]entry.EntryTag, error) {
 	tagIDs, serverVersion, err := authenticate(conn, tenant, auth, tags)
 	if err != nil {
 		conn.Close()
@@ -322,7 +335,7 @@ func negotiateEntryWriter(conn net.Conn, tenant string, auth AuthHash, tags []st
 }
 
 //completeIngestConnection performs the authentication and tag negotiation
-func completeIngestConnection(conn net.Conn, src net.IP, tenant string, auth AuthHash, tags []string) (*IngestConnection, error) {
+func completeIngestConnection(conn net.Conn, src net.IP, tenant string, auth ingestAuth, tags []string) (*IngestConnection, error) {
 	ew, tagIDs, err := negotiateEntryWriter(conn, tenant, auth, tags)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,40 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package simple_test
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/simple"
+)
+
+func Example() {
+	c, err := simple.Connect([]string{"10.0.0.1:4023", "tls://10.0.0.2"}, "IngestSecrets")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	tag, err := c.Tag("example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = c.Write(time.Now(), tag, []byte("hello from the simple client")); err != nil {
+		log.Fatal(err)
+	}
+
+	// wait up to a minute for the entry to reach an indexer
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err = c.Sync(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,269 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package simple is a small, stable client for sending data to Gravwell from other Go programs.
+//
+// It wraps the ingest muxer with sane defaults so that a program only needs to connect,
+// resolve the tags it wants, and write:
+//
+//	c, err := simple.Connect([]string{"10.0.0.1:4023"}, "IngestSecrets")
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	tag, err := c.Tag("myapp")
+//	if err != nil {
+//		return err
+//	}
+//	err = c.Write(time.Now(), tag, []byte("hello"))
+//
+// The exported API of this package is kept backwards compatible, programs which need finer
+// control should use the ingest.IngestMuxer directly.
+package simple
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	DefaultName           = `simple`
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultSyncTimeout    = 10 * time.Second
+)
+
+var (
+	ErrNoTargets      = errors.New("no ingest targets specified")
+	ErrNoSecret       = errors.New("no ingest secret specified")
+	ErrInvalidTarget  = errors.New("invalid ingest target")
+	ErrConnectTimeout = errors.New("timed out connecting to an indexer")
+	ErrClosed         = errors.New("client is closed")
+	ErrInvalidTag     = errors.New("invalid tag")
+	ErrUnknownTag     = errors.New("tag was not created by this client")
+	ErrEntryTooLarge  = errors.New("entry data exceeds the maximum entry size")
+)
+
+// Tag is a resolved tag, obtain one with Client.Tag
+type Tag struct {
+	name string
+	tag  entry.EntryTag
+	ok   bool
+}
+
+// Name returns the tag name
+func (t Tag) Name() string {
+	return t.name
+}
+
+// Config contains the optional settings for a client, the zero value is usable.
+type Config struct {
+	Name                  string        // ingester name reported to the indexers, defaults to DefaultName
+	Version               string        // ingester version reported to the indexers
+	UUID                  string        // ingester UUID, leave empty if the program does not persist one
+	Label                 string        // ingester label
+	Tenant                string        // tenant to ingest as, empty for the system tenant
+	InsecureSkipTLSVerify bool          // do not validate indexer certificates on tls:// targets
+	ConnectTimeout        time.Duration // time to wait for an indexer in Connect, defaults to DefaultConnectTimeout
+	CachePath             string        // directory for a local cache used while indexers are unreachable
+	CacheSize             int           // maximum cache size in MB
+	Source                net.IP        // source applied to entries, empty lets the indexer use the connection address
+	Logger                ingest.Logger // optional logger for connection events
+}
+
+// Client sends entries to one or more indexers, it is safe for concurrent use
+type Client struct {
+	mtx    sync.RWMutex
+	mux    *ingest.IngestMuxer
+	src    net.IP
+	closed bool
+}
+
+// Connect connects to the targets using the default configuration and waits for at least one
+// indexer to accept the connection.  Targets are host:port pairs for cleartext connections, or
+// URLs of the form tcp://host:port, tls://host:port, and pipe:///path/to/pipe.
+func Connect(targets []string, secret string) (*Client, error) {
+	return ConnectContext(context.Background(), targets, secret, Config{})
+}
+
+// ConnectContext is Connect with a context and configuration, the context bounds the wait for an indexer
+func ConnectContext(ctx context.Context, targets []string, secret string, cfg Config) (c *Client, err error) {
+	if len(targets) == 0 {
+		return nil, ErrNoTargets
+	} else if secret == `` {
+		return nil, ErrNoSecret
+	}
+	dests := make([]string, 0, len(targets))
+	for _, t := range targets {
+		var d string
+		if d, err = NormalizeTarget(t); err != nil {
+			return
+		}
+		dests = append(dests, d)
+	}
+	if cfg.Name == `` {
+		cfg.Name = DefaultName
+	}
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = DefaultConnectTimeout
+	}
+	mcfg := ingest.UniformMuxerConfig{
+		Destinations:    dests,
+		Auth:            secret,
+		Tenant:          cfg.Tenant,
+		VerifyCert:      !cfg.InsecureSkipTLSVerify,
+		IngesterName:    cfg.Name,
+		IngesterVersion: cfg.Version,
+		IngesterUUID:    cfg.UUID,
+		IngesterLabel:   cfg.Label,
+		CachePath:       cfg.CachePath,
+		CacheSize:       cfg.CacheSize,
+		Logger:          cfg.Logger,
+	}
+	if cfg.CachePath != `` {
+		mcfg.CacheMode = `fail`
+	}
+	var mux *ingest.IngestMuxer
+	if mux, err = ingest.NewUniformMuxer(mcfg); err != nil {
+		return nil, fmt.Errorf("failed to create ingest muxer: %w", err)
+	} else if err = mux.Start(); err != nil {
+		mux.Close()
+		return nil, fmt.Errorf("failed to start ingest muxer: %w", err)
+	}
+	if err = mux.WaitForHotContext(ctx, cfg.ConnectTimeout); err != nil {
+		mux.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("%w: %v", ErrConnectTimeout, err)
+	}
+	c = &Client{
+		mux: mux,
+		src: cfg.Source,
+	}
+	return
+}
+
+// NormalizeTarget converts a target into the URL form used by the ingest muxer, bare host:port
+// pairs are cleartext connections and a bare host uses the default cleartext port.
+func NormalizeTarget(t string) (string, error) {
+	if t = strings.TrimSpace(t); t == `` {
+		return ``, ErrInvalidTarget
+	}
+	if !strings.Contains(t, `://`) {
+		if strings.HasPrefix(t, `/`) {
+			t = `pipe://` + t
+		} else {
+			t = `tcp://` + t
+		}
+	}
+	tp, addr, err := ingest.ConnectionType(t)
+	if err != nil {
+		return ``, fmt.Errorf("%w %q: %v", ErrInvalidTarget, t, err)
+	} else if addr == `` {
+		return ``, fmt.Errorf("%w %q: missing address", ErrInvalidTarget, t)
+	}
+	if tp == `pipe` {
+		return tp + `://` + addr, nil
+	}
+	if _, _, err = net.SplitHostPort(addr); err != nil {
+		// no port, apply the default for the connection type
+		port := ingest.DEFAULT_CLEAR_PORT
+		if tp == `tls` {
+			port = ingest.DEFAULT_TLS_PORT
+		}
+		addr = net.JoinHostPort(strings.Trim(addr, `[]`), strconv.Itoa(port))
+	}
+	return tp + `://` + addr, nil
+}
+
+// Tag resolves a tag name, creating the tag on the indexers if needed
+func (c *Client) Tag(name string) (t Tag, err error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	if c.closed {
+		err = ErrClosed
+		return
+	}
+	if err = ingest.CheckTag(name); err != nil {
+		err = fmt.Errorf("%w %q: %v", ErrInvalidTag, name, err)
+		return
+	}
+	var tg entry.EntryTag
+	if tg, err = c.mux.NegotiateTag(name); err != nil {
+		err = fmt.Errorf("failed to create tag %q: %w", name, err)
+		return
+	}
+	t = Tag{name: name, tag: tg, ok: true}
+	return
+}
+
+// Write sends data with the given timestamp, it blocks while the indexers are unable to keep up
+func (c *Client) Write(ts time.Time, tag Tag, data []byte) error {
+	return c.WriteContext(context.Background(), ts, tag, data)
+}
+
+// WriteContext is Write with a context which can abandon a blocked write
+func (c *Client) WriteContext(ctx context.Context, ts time.Time, tag Tag, data []byte) error {
+	if !tag.ok {
+		return ErrUnknownTag
+	} else if len(data) > ingest.MAX_ENTRY_SIZE {
+		return ErrEntryTooLarge
+	}
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.mux.WriteEntryContext(ctx, &entry.Entry{
+		TS:   entry.FromStandard(ts),
+		SRC:  c.src,
+		Tag:  tag.tag,
+		Data: data,
+	})
+}
+
+// Sync waits until everything written has been handed to an indexer, or the context expires.
+// If the context has no deadline DefaultSyncTimeout is used.
+func (c *Client) Sync(ctx context.Context) error {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	if c.closed {
+		return ErrClosed
+	}
+	to := DefaultSyncTimeout
+	if dl, ok := ctx.Deadline(); ok {
+		to = time.Until(dl)
+	}
+	return c.mux.SyncContext(ctx, to)
+}
+
+// Close attempts to flush outstanding entries and disconnects from the indexers
+func (c *Client) Close() (err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	c.closed = true
+	if serr := c.mux.Sync(DefaultSyncTimeout); serr != nil && serr != ingest.ErrAllConnsDown {
+		err = serr
+	}
+	if cerr := c.mux.Close(); cerr != nil {
+		err = cerr
+	}
+	return
+}
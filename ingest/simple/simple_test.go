@@ -0,0 +1,79 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package simple
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTarget(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{`10.0.0.1:4023`, `tcp://10.0.0.1:4023`},
+		{`10.0.0.1`, `tcp://10.0.0.1:4023`},
+		{` indexer.example.com `, `tcp://indexer.example.com:4023`},
+		{`tls://indexer.example.com`, `tls://indexer.example.com:4024`},
+		{`TLS://indexer.example.com:9000`, `tls://indexer.example.com:9000`},
+		{`::1`, `tcp://[::1]:4023`},
+		{`[::1]`, `tcp://[::1]:4023`},
+		{`[::1]:5000`, `tcp://[::1]:5000`},
+		{`/opt/gravwell/comms/pipe`, `pipe:///opt/gravwell/comms/pipe`},
+		{`pipe:///opt/gravwell/comms/pipe`, `pipe:///opt/gravwell/comms/pipe`},
+	}
+	for _, tst := range tests {
+		out, err := NormalizeTarget(tst.in)
+		if err != nil {
+			t.Fatalf("%q: %v", tst.in, err)
+		} else if out != tst.out {
+			t.Fatalf("%q: got %q, expected %q", tst.in, out, tst.out)
+		}
+	}
+	for _, bad := range []string{``, `   `, `udp://10.0.0.1:4023`, `tcp://`} {
+		if _, err := NormalizeTarget(bad); !errors.Is(err, ErrInvalidTarget) {
+			t.Fatalf("%q: expected ErrInvalidTarget, got %v", bad, err)
+		}
+	}
+}
+
+func TestConnectErrors(t *testing.T) {
+	if _, err := Connect(nil, `secret`); err != ErrNoTargets {
+		t.Fatalf("expected ErrNoTargets, got %v", err)
+	}
+	if _, err := Connect([]string{`127.0.0.1`}, ``); err != ErrNoSecret {
+		t.Fatalf("expected ErrNoSecret, got %v", err)
+	}
+	if _, err := Connect([]string{`bogus://127.0.0.1`}, `secret`); !errors.Is(err, ErrInvalidTarget) {
+		t.Fatalf("expected ErrInvalidTarget, got %v", err)
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	// nothing is listening on the pipe so the connect must give up
+	tgt := t.TempDir() + `/nothere`
+	if _, err := ConnectContext(context.Background(), []string{tgt}, `secret`, Config{ConnectTimeout: 100 * time.Millisecond}); !errors.Is(err, ErrConnectTimeout) {
+		t.Fatalf("expected ErrConnectTimeout, got %v", err)
+	}
+	ctx, cf := context.WithCancel(context.Background())
+	cf()
+	if _, err := ConnectContext(ctx, []string{tgt}, `secret`, Config{}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestZeroTag(t *testing.T) {
+	var c Client
+	if err := c.Write(time.Now(), Tag{}, nil); err != ErrUnknownTag {
+		t.Fatalf("expected ErrUnknownTag, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package spiffe fetches X.509 SVIDs from a SPIFFE Workload API, such as a SPIRE agent, and
+// builds TLS configs which authenticate peers by their SPIFFE ID.
+package spiffe
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	// EndpointSocketEnv is the standard environment variable holding the Workload API address
+	EndpointSocketEnv = `SPIFFE_ENDPOINT_SOCKET`
+
+	idScheme = `spiffe`
+)
+
+var (
+	ErrInvalidID       = errors.New("SPIFFE IDs must be of the form spiffe://trust-domain/path")
+	ErrNoEndpoint      = errors.New("no SPIFFE Workload API endpoint configured")
+	ErrInvalidEndpoint = errors.New("SPIFFE Workload API endpoint must be a unix:// socket or tcp://ip:port address")
+	ErrMissingPeerID   = errors.New("peer certificate does not have a SPIFFE ID")
+	ErrUnauthorizedID  = errors.New("peer SPIFFE ID is not authorized")
+)
+
+// ID is a SPIFFE ID, an empty path refers to a whole trust domain
+type ID struct {
+	TrustDomain string
+	Path        string
+}
+
+// ParseID parses a SPIFFE ID such as spiffe://example.org/gravwell/indexer
+func ParseID(v string) (id ID, err error) {
+	u, err := url.Parse(strings.TrimSpace(v))
+	if err != nil || u.Scheme != idScheme || u.Host == `` || u.User != nil || u.Port() != `` || u.RawQuery != `` || u.Fragment != `` {
+		err = fmt.Errorf("%w: %q", ErrInvalidID, v)
+		return
+	}
+	id.TrustDomain = strings.ToLower(u.Host)
+	id.Path = strings.TrimSuffix(u.Path, `/`)
+	return
+}
+
+func (id ID) String() string {
+	return idScheme + `://` + id.TrustDomain + id.Path
+}
+
+// PeerID returns the SPIFFE ID in a certificate's URI SAN, an SVID has exactly one
+func PeerID(cert *x509.Certificate) (id ID, err error) {
+	var found bool
+	for _, u := range cert.URIs {
+		if u.Scheme != idScheme {
+			continue
+		} else if found {
+			err = fmt.Errorf("%w: more than one SPIFFE ID", ErrInvalidID)
+			return
+		}
+		if id, err = ParseID(u.String()); err != nil {
+			return
+		}
+		found = true
+	}
+	if !found {
+		err = ErrMissingPeerID
+	}
+	return
+}
+
+// Matcher authorizes peers by SPIFFE ID.  IDs without a path authorize every workload in their
+// trust domain, an empty Matcher authorizes nobody.
+type Matcher []ID
+
+// ParseMatcher parses a list of authorized SPIFFE IDs and trust domains
+func ParseMatcher(ids []string) (m Matcher, err error) {
+	for _, v := range ids {
+		var id ID
+		if id, err = ParseID(v); err != nil {
+			return nil, err
+		}
+		m = append(m, id)
+	}
+	return
+}
+
+// Authorized reports whether a peer ID is allowed
+func (m Matcher) Authorized(id ID) bool {
+	for _, a := range m {
+		if a.TrustDomain == id.TrustDomain && (a.Path == `` || a.Path == id.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseEndpoint splits a Workload API address into the network and address to dial, an empty
+// address falls back to the SPIFFE_ENDPOINT_SOCKET environment variable.
+func ParseEndpoint(v string) (network, addr string, err error) {
+	if v = strings.TrimSpace(v); v == `` {
+		if v = os.Getenv(EndpointSocketEnv); v == `` {
+			err = ErrNoEndpoint
+			return
+		}
+	}
+	u, err := url.Parse(v)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalidEndpoint, err)
+		return
+	}
+	switch u.Scheme {
+	case `unix`:
+		network = `unix`
+		if addr = u.Path; addr == `` {
+			addr = u.Opaque
+		}
+	case `tcp`:
+		network = `tcp`
+		addr = u.Host
+	}
+	if addr == `` {
+		err = fmt.Errorf("%w: %q", ErrInvalidEndpoint, v)
+	}
+	return
+}
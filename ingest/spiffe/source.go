@@ -0,0 +1,399 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package spiffe
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	fetchX509SVIDMethod = `/SpiffeWorkloadAPI/FetchX509SVID`
+	workloadHeader      = `workload.spiffe.io`
+
+	// how long to wait before reconnecting to the Workload API after the stream fails
+	retryInterval = 5 * time.Second
+
+	// X509SVIDResponse and X509SVID field numbers from the Workload API protobuf definitions
+	responseSVIDs  protowire.Number = 1
+	svidID         protowire.Number = 1
+	svidCerts      protowire.Number = 2
+	svidKey        protowire.Number = 3
+	svidBundle     protowire.Number = 4
+	maxMessageSize                  = 4 * 1024 * 1024
+)
+
+var (
+	ErrNoSVID       = errors.New("Workload API response did not contain an X.509 SVID")
+	ErrInvalidSVID  = errors.New("invalid X.509 SVID")
+	ErrSourceClosed = errors.New("SPIFFE source closed")
+)
+
+// SVID is an X.509 SVID and the trust bundle of its trust domain
+type SVID struct {
+	ID           ID
+	Certificates []*x509.Certificate // leaf first
+	PrivateKey   crypto.Signer
+	Bundle       *x509.CertPool
+}
+
+func (s *SVID) tlsCertificate() *tls.Certificate {
+	tc := &tls.Certificate{
+		PrivateKey: s.PrivateKey,
+		Leaf:       s.Certificates[0],
+	}
+	for _, c := range s.Certificates {
+		tc.Certificate = append(tc.Certificate, c.Raw)
+	}
+	return tc
+}
+
+// Source keeps the current X.509 SVID for a workload, the Workload API pushes a new SVID before
+// the current one expires so certificates rotate without any interaction.  If the Workload API
+// goes away the last SVID stays in use while the Source reconnects.
+type Source struct {
+	mtx  sync.RWMutex
+	svid *SVID
+	cert *tls.Certificate
+	errf func(error)
+
+	conn   *grpc.ClientConn
+	ready  chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error // first fetch failure, reported if no SVID ever arrives
+}
+
+// NewSource connects to the Workload API at endpoint, see ParseEndpoint, and waits for the first
+// SVID until the context is done.
+func NewSource(ctx context.Context, endpoint string) (*Source, error) {
+	network, addr, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	conn, err := grpc.DialContext(ctx, `passthrough:///spiffe`, grpc.WithInsecure(), grpc.WithContextDialer(dialer))
+	if err != nil {
+		return nil, err
+	}
+	s := &Source{
+		conn:  conn,
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	var rctx context.Context
+	rctx, s.cancel = context.WithCancel(context.Background())
+	go s.routine(rctx)
+	select {
+	case <-s.ready:
+		return s, nil
+	case <-ctx.Done():
+	}
+	s.Close()
+	s.mtx.RLock()
+	err = s.err
+	s.mtx.RUnlock()
+	if err == nil {
+		err = ctx.Err()
+	}
+	return nil, fmt.Errorf("failed to fetch an X.509 SVID from %s: %w", addr, err)
+}
+
+// SetErrorHandler sets a function which is told about Workload API failures
+func (s *Source) SetErrorHandler(fn func(error)) {
+	s.mtx.Lock()
+	s.errf = fn
+	s.mtx.Unlock()
+}
+
+// SVID returns the current SVID
+func (s *Source) SVID() *SVID {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.svid
+}
+
+// Close stops watching for new SVIDs
+func (s *Source) Close() error {
+	s.cancel()
+	<-s.done
+	return s.conn.Close()
+}
+
+func (s *Source) routine(ctx context.Context) {
+	defer close(s.done)
+	for {
+		err := s.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.reportError(err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// watch streams SVID updates until the stream fails
+func (s *Source) watch(ctx context.Context) error {
+	ctx = metadata.AppendToOutgoingContext(ctx, workloadHeader, `true`)
+	stream, err := s.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, fetchX509SVIDMethod,
+		grpc.ForceCodec(rawCodec{}), grpc.MaxCallRecvMsgSize(maxMessageSize))
+	if err != nil {
+		return err
+	}
+	req := []byte{} //X509SVIDRequest has no fields
+	if err = stream.SendMsg(&req); err != nil {
+		return err
+	} else if err = stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		var resp []byte
+		if err = stream.RecvMsg(&resp); err != nil {
+			return err
+		}
+		svid, err := parseX509SVIDResponse(resp)
+		if err != nil {
+			s.reportError(err)
+			continue
+		}
+		s.mtx.Lock()
+		first := s.svid == nil
+		s.svid, s.cert = svid, svid.tlsCertificate()
+		s.mtx.Unlock()
+		if first {
+			close(s.ready)
+		}
+	}
+}
+
+func (s *Source) reportError(err error) {
+	s.mtx.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	errf := s.errf
+	s.mtx.Unlock()
+	if errf != nil {
+		errf(err)
+	}
+}
+
+func (s *Source) certificate() (*tls.Certificate, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	if s.cert == nil {
+		return nil, ErrNoSVID
+	}
+	return s.cert, nil
+}
+
+// verifier checks that the peer holds an SVID issued by the current trust bundle with an authorized ID
+func (s *Source) verifier(authorized Matcher) func([][]byte, [][]*x509.Certificate) error {
+	return func(raw [][]byte, _ [][]*x509.Certificate) error {
+		if len(raw) == 0 {
+			return ErrMissingPeerID
+		}
+		certs := make([]*x509.Certificate, 0, len(raw))
+		for _, b := range raw {
+			c, err := x509.ParseCertificate(b)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, c)
+		}
+		svid := s.SVID()
+		if svid == nil {
+			return ErrNoSVID
+		}
+		opts := x509.VerifyOptions{
+			Roots:         svid.Bundle,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}
+		for _, c := range certs[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		if _, err := certs[0].Verify(opts); err != nil {
+			return err
+		}
+		id, err := PeerID(certs[0])
+		if err != nil {
+			return err
+		} else if !authorized.Authorized(id) {
+			return fmt.Errorf("%w: %s", ErrUnauthorizedID, id)
+		}
+		return nil
+	}
+}
+
+// ClientConfig sets up a client TLS config to present the current SVID and to only accept servers
+// holding an SVID with an authorized ID.  Servers are identified by SPIFFE ID rather than host name.
+// A VerifyPeerCertificate function already in the config is still called after the SPIFFE checks.
+func (s *Source) ClientConfig(tcfg *tls.Config, authorized Matcher) {
+	tcfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return s.certificate()
+	}
+	tcfg.InsecureSkipVerify = true //the verifier checks the chain against the SPIFFE bundle
+	verify := s.verifier(authorized)
+	if prev := tcfg.VerifyPeerCertificate; prev != nil {
+		tcfg.VerifyPeerCertificate = func(raw [][]byte, chains [][]*x509.Certificate) error {
+			if err := verify(raw, chains); err != nil {
+				return err
+			}
+			return prev(raw, chains)
+		}
+	} else {
+		tcfg.VerifyPeerCertificate = verify
+	}
+}
+
+// ServerConfig returns a server TLS config which presents the current SVID and requires clients
+// to present an SVID with an authorized ID.
+func (s *Source) ServerConfig(authorized Matcher) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.certificate()
+		},
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: s.verifier(authorized),
+	}
+}
+
+// parseX509SVIDResponse decodes an X509SVIDResponse, the first SVID is the default for the workload
+func parseX509SVIDResponse(b []byte) (*SVID, error) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if num == responseSVIDs && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			return parseX509SVID(v)
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil, ErrNoSVID
+}
+
+func parseX509SVID(b []byte) (svid *SVID, err error) {
+	var id string
+	var certs, key, bundle []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case svidID:
+			id = string(v)
+		case svidCerts:
+			certs = v
+		case svidKey:
+			key = v
+		case svidBundle:
+			bundle = v
+		}
+	}
+
+	svid = &SVID{Bundle: x509.NewCertPool()}
+	if svid.ID, err = ParseID(id); err != nil {
+		return nil, err
+	} else if svid.Certificates, err = x509.ParseCertificates(certs); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSVID, err)
+	} else if len(svid.Certificates) == 0 {
+		return nil, fmt.Errorf("%w: no certificates", ErrInvalidSVID)
+	}
+	if leafID, err := PeerID(svid.Certificates[0]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSVID, err)
+	} else if leafID != svid.ID {
+		return nil, fmt.Errorf("%w: certificate is for %s not %s", ErrInvalidSVID, leafID, svid.ID)
+	}
+	pk, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSVID, err)
+	}
+	var ok bool
+	if svid.PrivateKey, ok = pk.(crypto.Signer); !ok {
+		return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidSVID, pk)
+	}
+	cas, err := x509.ParseCertificates(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad bundle: %v", ErrInvalidSVID, err)
+	} else if len(cas) == 0 {
+		return nil, fmt.Errorf("%w: empty bundle", ErrInvalidSVID)
+	}
+	for _, c := range cas {
+		svid.Bundle.AddCert(c)
+	}
+	return svid, nil
+}
+
+// rawCodec passes the protobuf encoded messages through untouched, the Workload API messages
+// are simple enough that we decode them by hand rather than pulling in generated code.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return `proto`
+}
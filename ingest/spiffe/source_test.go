@@ -0,0 +1,274 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package spiffe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: `spire ca`},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{key: key}
+	if ca.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// svidMessage builds an X509SVIDResponse holding a freshly issued SVID
+func (ca *testCA) svidMessage(t *testing.T, id string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(id)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{u},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var svid []byte
+	svid = protowire.AppendTag(svid, svidID, protowire.BytesType)
+	svid = protowire.AppendString(svid, id)
+	svid = protowire.AppendTag(svid, svidCerts, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, der)
+	svid = protowire.AppendTag(svid, svidKey, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, kb)
+	svid = protowire.AppendTag(svid, svidBundle, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, ca.cert.Raw)
+	svid = protowire.AppendTag(svid, 5, protowire.BytesType) //hint, ignored
+	svid = protowire.AppendString(svid, `internal`)
+	var resp []byte
+	resp = protowire.AppendTag(resp, responseSVIDs, protowire.BytesType)
+	return protowire.AppendBytes(resp, svid)
+}
+
+// serverCodec adapts rawCodec to the older codec interface the server options take
+type serverCodec struct {
+	rawCodec
+}
+
+func (serverCodec) String() string {
+	return `proto`
+}
+
+// fakeWorkloadAPI serves every response pushed into the channel to each stream
+func fakeWorkloadAPI(t *testing.T, updates chan []byte) string {
+	sock := filepath.Join(t.TempDir(), `agent.sock`)
+	l, err := net.Listen(`unix`, sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.CustomCodec(serverCodec{}), grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		if m, _ := grpc.MethodFromServerStream(stream); m != fetchX509SVIDMethod {
+			return errors.New("unknown method " + m)
+		}
+		if md, _ := metadata.FromIncomingContext(stream.Context()); len(md.Get(workloadHeader)) == 0 {
+			return errors.New("missing security header")
+		}
+		var req []byte
+		if err := stream.RecvMsg(&req); err != nil {
+			return err
+		}
+		for {
+			select {
+			case resp := <-updates:
+				if err := stream.SendMsg(&resp); err != nil {
+					return err
+				}
+			case <-stream.Context().Done():
+				return nil
+			}
+		}
+	}))
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	return `unix://` + sock
+}
+
+func TestParse(t *testing.T) {
+	id, err := ParseID(`spiffe://Example.org/gravwell/indexer/`)
+	if err != nil {
+		t.Fatal(err)
+	} else if id.String() != `spiffe://example.org/gravwell/indexer` {
+		t.Fatalf("bad id %s", id)
+	}
+	for _, v := range []string{``, `example.org/foo`, `https://example.org/foo`, `spiffe:///foo`, `spiffe://example.org:80/foo`, `spiffe://example.org/foo?x=y`} {
+		if _, err = ParseID(v); !errors.Is(err, ErrInvalidID) {
+			t.Fatalf("failed to catch bad id %q: %v", v, err)
+		}
+	}
+
+	m, err := ParseMatcher([]string{`spiffe://example.org/gravwell/indexer`, `spiffe://dr.example.org`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v, ok := range map[string]bool{
+		`spiffe://example.org/gravwell/indexer`:  true,
+		`spiffe://example.org/gravwell/ingester`: false,
+		`spiffe://dr.example.org/anything`:       true,
+		`spiffe://other.org/gravwell/indexer`:    false,
+	} {
+		id, _ := ParseID(v)
+		if m.Authorized(id) != ok {
+			t.Fatalf("bad authorization for %s", v)
+		}
+	}
+
+	os.Setenv(EndpointSocketEnv, `unix:///run/spire/agent.sock`)
+	defer os.Unsetenv(EndpointSocketEnv)
+	for v, exp := range map[string][2]string{
+		``:                        {`unix`, `/run/spire/agent.sock`},
+		`unix:/tmp/agent.sock`:    {`unix`, `/tmp/agent.sock`},
+		`tcp://127.0.0.1:8081`:    {`tcp`, `127.0.0.1:8081`},
+		`unix:///tmp/agent2.sock`: {`unix`, `/tmp/agent2.sock`},
+	} {
+		if n, a, err := ParseEndpoint(v); err != nil || n != exp[0] || a != exp[1] {
+			t.Fatalf("bad endpoint %q: %s %s %v", v, n, a, err)
+		}
+	}
+	if _, _, err = ParseEndpoint(`/tmp/agent.sock`); !errors.Is(err, ErrInvalidEndpoint) {
+		t.Fatalf("bad endpoint not caught: %v", err)
+	}
+}
+
+func TestSource(t *testing.T) {
+	ca := newTestCA(t)
+	updates := make(chan []byte, 4)
+	ep := fakeWorkloadAPI(t, updates)
+	updates <- ca.svidMessage(t, `spiffe://example.org/gravwell/ingester`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	src, err := NewSource(ctx, ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	first := src.SVID()
+	if first.ID.String() != `spiffe://example.org/gravwell/ingester` {
+		t.Fatalf("bad SVID %s", first.ID)
+	}
+
+	//the same SVID plays both sides of a mutual TLS connection
+	handshake := func(serverAllows, clientAllows string) error {
+		sm, _ := ParseMatcher([]string{serverAllows})
+		cm, _ := ParseMatcher([]string{clientAllows})
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		defer c2.Close()
+		srv := tls.Server(c1, src.ServerConfig(sm))
+		go func() {
+			srv.Handshake()
+			c1.Close()
+		}()
+		var ccfg tls.Config
+		src.ClientConfig(&ccfg, cm)
+		cli := tls.Client(c2, &ccfg)
+		if err := cli.Handshake(); err != nil {
+			return err
+		}
+		_, err := cli.Read(make([]byte, 1))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	}
+	if err = handshake(`spiffe://example.org`, `spiffe://example.org/gravwell/ingester`); err != nil {
+		t.Fatal(err)
+	} else if err = handshake(`spiffe://example.org`, `spiffe://example.org/gravwell/indexer`); err == nil {
+		t.Fatal("unauthorized server accepted")
+	}
+
+	//rotation swaps in the new SVID
+	updates <- ca.svidMessage(t, `spiffe://example.org/gravwell/ingester`)
+	for i := 0; i < 100 && src.SVID() == first; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if src.SVID() == first {
+		t.Fatal("SVID not rotated")
+	}
+
+	//a peer from another trust domain can't get in even with an authorized looking ID
+	other := newTestCA(t)
+	bad, err := parseX509SVIDResponse(other.svidMessage(t, `spiffe://example.org/gravwell/ingester`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw [][]byte
+	for _, c := range bad.Certificates {
+		raw = append(raw, c.Raw)
+	}
+	m, _ := ParseMatcher([]string{`spiffe://example.org`})
+	if err = src.verifier(m)(raw, nil); err == nil {
+		t.Fatal("SVID from an untrusted CA accepted")
+	}
+}
+
+func TestSourceUnavailable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := NewSource(ctx, `unix://`+filepath.Join(t.TempDir(), `missing.sock`)); err == nil {
+		t.Fatal("created a source without a Workload API")
+	}
+	if _, err := parseX509SVIDResponse(nil); !errors.Is(err, ErrNoSVID) {
+		t.Fatalf("bad error for an empty response: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/spiffe"
+)
+
+const (
+	spiffeFetchTimeout = 30 * time.Second
+)
+
+var (
+	ErrSPIFFECleartext = errors.New("SPIFFE identities require encrypted connections to indexers")
+)
+
+// workloadIdentity lazily attaches to the SPIFFE Workload API so an ingester can start before
+// the local agent has issued its SVID, the SVID rotates for as long as the muxer is open.
+type workloadIdentity struct {
+	sync.Mutex
+	endpoint string
+	indexers spiffe.Matcher
+	lgr      Logger
+	src      *spiffe.Source
+	closed   bool
+}
+
+// newWorkloadIdentity returns nil if SPIFFE identities are not configured
+func newWorkloadIdentity(isc config.IngestStreamConfig, dests []Target, lgr Logger) (*workloadIdentity, error) {
+	if !isc.SPIFFEEnabled() {
+		return nil, nil
+	}
+	indexers, err := isc.SPIFFEIndexers()
+	if err != nil {
+		return nil, err
+	} else if _, _, err = spiffe.ParseEndpoint(isc.SPIFFE_Endpoint_Socket); err != nil {
+		return nil, err
+	}
+	for _, d := range dests {
+		if t, _, err := ConnectionType(d.Address); err != nil {
+			return nil, err
+		} else if t != `tls` {
+			return nil, ErrSPIFFECleartext
+		}
+	}
+	return &workloadIdentity{
+		endpoint: isc.SPIFFE_Endpoint_Socket,
+		indexers: indexers,
+		lgr:      lgr,
+	}, nil
+}
+
+// source returns the Workload API source, connecting to it if needed
+func (wi *workloadIdentity) source() (*spiffe.Source, error) {
+	wi.Lock()
+	defer wi.Unlock()
+	if wi.closed {
+		return nil, spiffe.ErrSourceClosed
+	} else if wi.src != nil {
+		return wi.src, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), spiffeFetchTimeout)
+	defer cancel()
+	src, err := spiffe.NewSource(ctx, wi.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if wi.lgr != nil {
+		src.SetErrorHandler(func(err error) {
+			wi.lgr.Error("SPIFFE Workload API error", log.KVErr(err))
+		})
+	}
+	wi.src = src
+	return src, nil
+}
+
+func (wi *workloadIdentity) close() {
+	if wi == nil {
+		return
+	}
+	wi.Lock()
+	defer wi.Unlock()
+	wi.closed = true
+	if wi.src != nil {
+		wi.src.Close()
+		wi.src = nil
+	}
+}
@@ -0,0 +1,122 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestOfflineTagCache(t *testing.T) {
+	dir := t.TempDir()
+	//a tag map left behind by a previous run, including a tag that is no longer configured
+	if err := writeTagCache(map[string]entry.EntryTag{`old`: 0, `foo`: 1, `bar`: 2}, dir); err != nil {
+		t.Fatal(err)
+	}
+	//grab an address that nobody is listening on
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := lst.Addr().String()
+	lst.Close()
+
+	im, err := NewUniformMuxer(UniformMuxerConfig{
+		Destinations: []string{`tcp://` + deadAddr},
+		Tags:         []string{`bar`, `foo`, `baz`},
+		Auth:         `foo`,
+		IngesterName: `offlinetest`,
+		CachePath:    dir,
+		CacheSize:    1,
+		CacheMode:    CacheModeFail,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, exp := range map[string]entry.EntryTag{`old`: 0, `foo`: 1, `bar`: 2, `baz`: 3} {
+		if tg, err := im.GetTag(name); err != nil || tg != exp {
+			t.Fatalf("bad tag %s: %v %v", name, tg, err)
+		}
+	}
+	if _, err = im.GetTag(`dyn`); err != ErrTagNotFound {
+		t.Fatalf("unknown tag resolved: %v", err)
+	}
+
+	//no indexers are up, we should still start and spool to the cache
+	if err = im.Start(); err != nil {
+		t.Fatal(err)
+	} else if err = im.WaitForHot(500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	foo, _ := im.GetTag(`foo`)
+	if err = im.WriteEntry(&entry.Entry{TS: entry.Now(), Tag: foo, Data: []byte("offline")}); err != nil {
+		t.Fatal(err)
+	} else if _, err = im.NegotiateTag(`dyn`); err != nil {
+		t.Fatal(err)
+	} else if err = im.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the tag map survives the shutdown, including tags negotiated while offline
+	if tm, err := readTagCache(dir); err != nil {
+		t.Fatal(err)
+	} else if len(tm) != 5 || tm[`foo`] != 1 || tm[`dyn`] != 4 {
+		t.Fatalf("bad tag cache %v", tm)
+	}
+
+	col := &serverCollector{ents: map[string][]string{}}
+	srv, err := NewIngestServer(ServerConfig{Secret: `foo`, Handler: col.handle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	if lst, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lst)
+
+	//the caches release their file locks in the background after a close
+	for i := 0; i < 50; i++ {
+		if im, err = NewUniformMuxer(UniformMuxerConfig{
+			Destinations: []string{`tcp://` + lst.Addr().String()},
+			Tags:         []string{`foo`},
+			Auth:         `foo`,
+			IngesterName: `offlinetest`,
+			CachePath:    dir,
+			CacheSize:    1,
+			CacheMode:    CacheModeFail,
+		}); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	} else if tg, err := im.GetTag(`foo`); err != nil || tg != foo {
+		t.Fatalf("tag ID changed across restarts: %v %v", tg, err)
+	} else if err = im.Start(); err != nil {
+		t.Fatal(err)
+	} else if err = im.WaitForHot(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500 && col.count(`foo`) < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = im.Close(); err != nil {
+		t.Fatal(err)
+	}
+	col.Lock()
+	defer col.Unlock()
+	if got := col.ents[`foo`]; len(got) != 1 || got[0] != `offline` {
+		t.Fatalf("cached entry not delivered: %v", got)
+	}
+}
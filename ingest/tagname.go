@@ -0,0 +1,138 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	DefaultTagReplacement rune = '_'
+
+	tagHashLen       = 8    // hex digits of the source hash appended to colliding names
+	maxSanitizedTags = 4096 // mappings remembered by a TagSanitizer before it starts over
+)
+
+var (
+	ErrInvalidTagReplacement = errors.New("Tag replacement character must be a printable character that is allowed in tags")
+)
+
+// SanitizeTag maps an arbitrary string, such as a topic name, URL, or label value, into a valid
+// tag name.  Forbidden and non-printable characters are replaced with the replacement character,
+// surrounding whitespace is trimmed, and the result is cut down to the maximum tag length.
+// A zero replacement uses DefaultTagReplacement.  The result is empty only if the input is.
+func SanitizeTag(v string, replacement rune) string {
+	if replacement == 0 {
+		replacement = DefaultTagReplacement
+	}
+	v = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || !unicode.IsPrint(r) || strings.ContainsRune(FORBIDDEN_TAG_SET, r) {
+			return replacement
+		}
+		return r
+	}, strings.TrimSpace(v))
+	return truncateTag(v, MAX_TAG_LENGTH)
+}
+
+// truncateTag cuts a tag down to at most max bytes without splitting a character
+func truncateTag(v string, max int) string {
+	if len(v) <= max {
+		return v
+	}
+	for max > 0 && !utf8.RuneStart(v[max]) {
+		max--
+	}
+	return v[:max]
+}
+
+// TagSanitizer maps derived strings into tag names like SanitizeTag, but also makes sure that two
+// different strings never share a tag.  The first string to claim a sanitized name gets it as is,
+// later strings which sanitize to the same name get a short hash of the original string appended,
+// so a given set of strings always maps to the same tags.  TagSanitizers are safe for concurrent use.
+type TagSanitizer struct {
+	mtx         sync.Mutex
+	replacement rune
+	names       map[string]string // original string to tag name
+	owners      map[string]string // tag name to original string
+}
+
+// NewTagSanitizer creates a TagSanitizer, a zero replacement uses DefaultTagReplacement
+func NewTagSanitizer(replacement rune) (*TagSanitizer, error) {
+	if replacement == 0 {
+		replacement = DefaultTagReplacement
+	} else if err := CheckTagReplacement(replacement); err != nil {
+		return nil, err
+	}
+	return &TagSanitizer{
+		replacement: replacement,
+		names:       map[string]string{},
+		owners:      map[string]string{},
+	}, nil
+}
+
+// CheckTagReplacement ensures a replacement character can itself appear in a tag
+func CheckTagReplacement(r rune) error {
+	if r == utf8.RuneError || !unicode.IsPrint(r) || unicode.IsSpace(r) || strings.ContainsRune(FORBIDDEN_TAG_SET, r) {
+		return fmt.Errorf("%w: %q", ErrInvalidTagReplacement, r)
+	}
+	return nil
+}
+
+// ParseTagReplacement parses a replacement character from a config value, empty values
+// return DefaultTagReplacement
+func ParseTagReplacement(v string) (r rune, err error) {
+	if v == `` {
+		r = DefaultTagReplacement
+		return
+	} else if utf8.RuneCountInString(v) != 1 {
+		err = fmt.Errorf("%w: %q is not a single character", ErrInvalidTagReplacement, v)
+		return
+	}
+	r, _ = utf8.DecodeRuneInString(v)
+	err = CheckTagReplacement(r)
+	return
+}
+
+// Tag returns the tag name for a derived string, ErrEmptyTag is returned for empty strings
+func (ts *TagSanitizer) Tag(v string) (string, error) {
+	ts.mtx.Lock()
+	defer ts.mtx.Unlock()
+	if name, ok := ts.names[v]; ok {
+		return name, nil
+	}
+	name := SanitizeTag(v, ts.replacement)
+	if name == `` {
+		return ``, ErrEmptyTag
+	}
+	if owner, ok := ts.owners[name]; ok && owner != v {
+		name = ts.hashed(name, v)
+	}
+	if len(ts.names) >= maxSanitizedTags {
+		//derived strings are usually a small set, if not don't grow without bound
+		ts.names = map[string]string{}
+		ts.owners = map[string]string{}
+	}
+	ts.names[v] = name
+	ts.owners[name] = v
+	return name, nil
+}
+
+// hashed appends a hash of the original string to a sanitized name that is already taken
+func (ts *TagSanitizer) hashed(name, orig string) string {
+	h := fnv.New64a()
+	h.Write([]byte(orig))
+	sfx := fmt.Sprintf("%c%0*x", ts.replacement, tagHashLen, h.Sum64())[:tagHashLen+utf8.RuneLen(ts.replacement)]
+	return truncateTag(name, MAX_TAG_LENGTH-len(sfx)) + sfx
+}
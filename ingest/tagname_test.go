@@ -0,0 +1,90 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeTag(t *testing.T) {
+	for v, exp := range map[string]string{
+		`syslog`:                    `syslog`,
+		` orders.v1 `:               `orders_v1`,
+		`https://example.com/a?b=c`: `https_//example_com/a?b_c`,
+		"label\x00value\n":          `label_value`,
+		`k8s-prod/web`:              `k8s-prod/web`,
+		"café.log":                  "café_log",
+		"bad\xffutf8":               `bad_utf8`,
+		``:                          ``,
+	} {
+		if r := SanitizeTag(v, 0); r != exp {
+			t.Fatalf("bad sanitize of %q: %q != %q", v, r, exp)
+		} else if r != `` && CheckTag(r) != nil {
+			t.Fatalf("sanitized %q is not a valid tag: %q", v, r)
+		}
+	}
+	if r := SanitizeTag(`a.b`, '-'); r != `a-b` {
+		t.Fatalf("bad replacement: %q", r)
+	}
+	long := strings.Repeat("é", MAX_TAG_LENGTH)
+	if r := SanitizeTag(long, 0); len(r) > MAX_TAG_LENGTH || !utf8.ValidString(r) {
+		t.Fatalf("bad truncation: %d %v", len(r), utf8.ValidString(r))
+	}
+}
+
+func TestTagSanitizer(t *testing.T) {
+	for _, r := range []rune{'.', ' ', '\n', '*', utf8.RuneError} {
+		if _, err := NewTagSanitizer(r); !errors.Is(err, ErrInvalidTagReplacement) {
+			t.Fatalf("bad replacement %q not caught: %v", r, err)
+		}
+	}
+	for v, exp := range map[string]rune{``: '_', `-`: '-', `~`: '~'} {
+		if r, err := ParseTagReplacement(v); err != nil || r != exp {
+			t.Fatalf("bad replacement parse of %q: %q %v", v, r, err)
+		}
+	}
+	for _, v := range []string{`--`, `.`, ` `} {
+		if _, err := ParseTagReplacement(v); !errors.Is(err, ErrInvalidTagReplacement) {
+			t.Fatalf("bad replacement %q not caught: %v", v, err)
+		}
+	}
+
+	ts, err := NewTagSanitizer('-')
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := ts.Tag(`orders.v1`)
+	if err != nil || first != `orders-v1` {
+		t.Fatalf("bad tag %q %v", first, err)
+	}
+	//a different string that sanitizes to the same name gets a hashed suffix
+	second, err := ts.Tag(`orders:v1`)
+	if err != nil || second == first || !strings.HasPrefix(second, `orders-v1-`) || len(second) != len(first)+1+tagHashLen {
+		t.Fatalf("bad collision handling %q %v", second, err)
+	} else if CheckTag(second) != nil {
+		t.Fatalf("collision tag %q is invalid", second)
+	}
+	//mappings are stable and deterministic
+	if again, _ := ts.Tag(`orders:v1`); again != second {
+		t.Fatalf("unstable mapping %q != %q", again, second)
+	} else if again, _ = ts.Tag(`orders.v1`); again != first {
+		t.Fatalf("unstable mapping %q != %q", again, first)
+	}
+	ts2, _ := NewTagSanitizer('-')
+	ts2.Tag(`orders.v1`)
+	if again, _ := ts2.Tag(`orders:v1`); again != second {
+		t.Fatalf("non-deterministic collision name %q != %q", again, second)
+	}
+	if _, err = ts.Tag(" \t"); err != ErrEmptyTag {
+		t.Fatalf("empty tag not caught: %v", err)
+	}
+}
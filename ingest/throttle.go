@@ -20,7 +20,7 @@ type parent struct {
 type throttleConn struct {
 	net.Conn
 	burst int
-	lm    *rate.Limiter
+	lms   []*rate.Limiter // every limiter must admit a write
 	to    time.Duration
 	ctx   context.Context
 	cncl  func()
@@ -45,15 +45,46 @@ func newParent(bps int64, burstMult int) *parent {
 	}
 }
 
+// newRateParent builds a parent from a rate and burst in bits per second
+func newRateParent(bps, burstBits int64) *parent {
+	if burstBits <= 0 {
+		burstBits = bps
+	}
+	burst := int(burstBits / 8)
+	if burst <= 0 {
+		burst = 1
+	}
+	return &parent{
+		burst: burst,
+		lm:    rate.NewLimiter(rate.Limit(bps/8), burst),
+	}
+}
+
 func (p *parent) newThrottleConn(c net.Conn) *throttleConn {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &throttleConn{
-		Conn:  c,
-		burst: p.burst,
-		lm:    p.lm,
-		cncl:  cancel,
-		ctx:   ctx,
+	return newParentsThrottleConn(c, p)
+}
+
+// newParentsThrottleConn builds a connection that is held to every non-nil parent, it returns
+// nil if there are none.
+func newParentsThrottleConn(c net.Conn, ps ...*parent) (tc *throttleConn) {
+	for _, p := range ps {
+		if p == nil {
+			continue
+		}
+		if tc == nil {
+			ctx, cancel := context.WithCancel(context.Background())
+			tc = &throttleConn{
+				Conn:  c,
+				burst: p.burst,
+				cncl:  cancel,
+				ctx:   ctx,
+			}
+		} else if p.burst < tc.burst {
+			tc.burst = p.burst
+		}
+		tc.lms = append(tc.lms, p.lm)
 	}
+	return
 }
 
 func newWriteThrottler(bps int64, burstMult int, c net.Conn) (wt *throttleConn) {
@@ -64,7 +95,7 @@ func newWriteThrottler(bps int64, burstMult int, c net.Conn) (wt *throttleConn)
 	return &throttleConn{
 		Conn:  c,
 		burst: burst,
-		lm:    rate.NewLimiter(rate.Limit(bps), burst),
+		lms:   []*rate.Limiter{rate.NewLimiter(rate.Limit(bps), burst)},
 	}
 }
 
@@ -109,8 +140,10 @@ func (w *throttleConn) Write(b []byte) (n int, err error) {
 		if r, err = w.Conn.Write(b[n : n+sz]); err != nil {
 			return
 		}
-		if err = w.lm.WaitN(ctx, r); err != nil {
-			return
+		for _, lm := range w.lms {
+			if err = lm.WaitN(ctx, r); err != nil {
+				return
+			}
 		}
 		n += r
 	}
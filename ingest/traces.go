@@ -0,0 +1,165 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
+)
+
+const (
+	traceExpireInterval = 30 * time.Second
+	maxTraceAge         = 5 * time.Minute // traced entries unconfirmed this long end their spans with an error
+)
+
+var (
+	errTraceExpired = errors.New("entry was not confirmed by an indexer in time, it may have been cached")
+	errTraceClosed  = errors.New("muxer closed before an indexer confirmed the entry")
+)
+
+// entryTrace is the muxer's part of a traced entry, span is the stage the entry is currently in
+type entryTrace struct {
+	parent *tracing.Span
+	span   *tracing.Span
+	since  time.Time
+}
+
+// entryTraces follows traced entries from the muxer queues to an indexer.  Writers hand the
+// muxer a context carrying a span, usually from a listener or preprocessor, and the muxer adds
+// a span for the time an entry waits in the queues and another for the time from being written
+// to an indexer until the indexer confirms it.  Batches are followed by their last entry since
+// indexers confirm entries in order.  Like latency markers, entries are recognized by pointer.
+type entryTraces struct {
+	sync.Mutex
+	pending int32 // number of traced entries, checked atomically on the hot paths
+	ents    map[*entry.Entry]*entryTrace
+}
+
+func newEntryTraces(t *tracing.Tracer) *entryTraces {
+	if t == nil {
+		return nil
+	}
+	return &entryTraces{
+		ents: map[*entry.Entry]*entryTrace{},
+	}
+}
+
+// queued starts timing an entry in the muxer queues if ctx carries a span, n is the number
+// of entries the traced entry stands for
+func (et *entryTraces) queued(ctx context.Context, ent *entry.Entry, n int) {
+	if et == nil || ent == nil {
+		return
+	}
+	parent := tracing.SpanFromContext(ctx)
+	if parent == nil {
+		return
+	}
+	tr := &entryTrace{
+		parent: parent,
+		span:   parent.Child(`muxer.queue`, tracing.KindProducer, tracing.Int(`entries`, int64(n))),
+		since:  time.Now(),
+	}
+	et.Lock()
+	if old, ok := et.ents[ent]; ok {
+		old.span.End()
+	} else {
+		atomic.AddInt32(&et.pending, 1)
+	}
+	et.ents[ent] = tr
+	et.Unlock()
+}
+
+// dropped ends the trace of an entry that never made it into the queues
+func (et *entryTraces) dropped(ent *entry.Entry, err error) {
+	if tr := et.remove(ent); tr != nil {
+		tr.span.SetError(err)
+		tr.span.End()
+	}
+}
+
+// sent ends the queue span and starts timing the indexer when an entry is written to a connection
+func (et *entryTraces) sent(ent *entry.Entry, dst string) {
+	if et == nil || ent == nil || atomic.LoadInt32(&et.pending) == 0 {
+		return
+	}
+	et.Lock()
+	defer et.Unlock()
+	tr, ok := et.ents[ent]
+	if !ok {
+		return
+	}
+	//an entry can be written more than once if a connection fails and it is recycled
+	tr.span.End()
+	tr.span = tr.parent.Child(`indexer.confirm`, tracing.KindClient, tracing.String(`indexer`, dst))
+}
+
+// ack is called with every entry an indexer confirms
+func (et *entryTraces) ack(ent *entry.Entry) {
+	if et == nil || ent == nil || atomic.LoadInt32(&et.pending) == 0 {
+		return
+	}
+	if tr := et.remove(ent); tr != nil {
+		tr.span.End()
+	}
+}
+
+func (et *entryTraces) remove(ent *entry.Entry) (tr *entryTrace) {
+	if et == nil || ent == nil || atomic.LoadInt32(&et.pending) == 0 {
+		return
+	}
+	et.Lock()
+	if tr = et.ents[ent]; tr != nil {
+		delete(et.ents, ent)
+		atomic.AddInt32(&et.pending, -1)
+	}
+	et.Unlock()
+	return
+}
+
+// expire ends the traces of entries that have been in the muxer too long, or all of them
+func (et *entryTraces) expire(all bool) {
+	if et == nil {
+		return
+	}
+	et.Lock()
+	defer et.Unlock()
+	for ent, tr := range et.ents {
+		if all {
+			tr.span.SetError(errTraceClosed)
+		} else if time.Since(tr.since) > maxTraceAge {
+			tr.span.SetError(errTraceExpired)
+		} else {
+			continue
+		}
+		tr.span.End()
+		delete(et.ents, ent)
+		atomic.AddInt32(&et.pending, -1)
+	}
+}
+
+// traceExpireRoutine keeps entries that will never be confirmed, such as entries spooled to
+// the cache, from holding on to their spans forever
+func (im *IngestMuxer) traceExpireRoutine() {
+	tkr := time.NewTicker(traceExpireInterval)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-im.dieChan:
+			return
+		case <-tkr.C:
+			im.traces.expire(false)
+		}
+	}
+}
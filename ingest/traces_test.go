@@ -0,0 +1,207 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
+)
+
+// exportedSpan is the part of an OTLP JSON span the tests look at
+type exportedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Status       *struct {
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type spanCollector struct {
+	sync.Mutex
+	spans []exportedSpan
+}
+
+func (sc *spanCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []exportedSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sc.Lock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			sc.spans = append(sc.spans, ss.Spans...)
+		}
+	}
+	sc.Unlock()
+}
+
+func TestEntryTraces(t *testing.T) {
+	sc := &spanCollector{}
+	hsrv := httptest.NewServer(sc)
+	defer hsrv.Close()
+	tr, err := tracing.New(tracing.Config{Endpoint: hsrv.URL, SampleRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	col := &serverCollector{ents: map[string][]string{}}
+	srv, err := NewIngestServer(ServerConfig{Secret: `foo`, Handler: col.handle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lst)
+
+	im, err := NewUniformMuxer(UniformMuxerConfig{
+		Destinations: []string{`tcp://` + lst.Addr().String()},
+		Tags:         []string{`foo`},
+		Auth:         `foo`,
+		IngesterName: `tracetest`,
+		Tracer:       tr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer im.Close()
+	if err = im.Start(); err != nil {
+		t.Fatal(err)
+	} else if err = im.WaitForHot(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	tag, err := im.GetTag(`foo`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newEnt := func() *entry.Entry {
+		return &entry.Entry{TS: entry.Now(), Tag: tag, Data: []byte(`traced`)}
+	}
+
+	ctx, root := tr.Start(context.Background(), `root`, tracing.KindServer)
+	if err = im.WriteEntryContext(ctx, newEnt()); err != nil {
+		t.Fatal(err)
+	} else if err = im.WriteBatchContext(ctx, []*entry.Entry{newEnt(), newEnt(), newEnt()}); err != nil {
+		t.Fatal(err)
+	} else if err = im.WriteEntry(newEnt()); err != nil {
+		t.Fatal(err)
+	} else if err = im.Sync(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	root.End()
+	//the acks may trail the sync slightly
+	for i := 0; i < 500; i++ {
+		im.traces.Lock()
+		n := len(im.traces.ents)
+		im.traces.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = im.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sc.Lock()
+	defer sc.Unlock()
+	var rootID string
+	counts := map[string]int{}
+	for _, s := range sc.spans {
+		if s.Name == `root` {
+			rootID = s.SpanID
+		}
+	}
+	for _, s := range sc.spans {
+		counts[s.Name]++
+		if s.Name == `root` {
+			continue
+		} else if s.ParentSpanID != rootID || s.TraceID != root.TraceID() {
+			t.Fatalf("span %s is not a child of the root span: %+v", s.Name, s)
+		} else if s.Status != nil {
+			t.Fatalf("span %s failed: %s", s.Name, s.Status.Message)
+		}
+	}
+	//one single entry and one batch were traced, the untraced entry adds nothing
+	if len(sc.spans) != 5 || counts[`muxer.queue`] != 2 || counts[`indexer.confirm`] != 2 {
+		t.Fatalf("bad spans: %+v", counts)
+	}
+}
+
+func TestEntryTracesExpire(t *testing.T) {
+	sc := &spanCollector{}
+	hsrv := httptest.NewServer(sc)
+	defer hsrv.Close()
+	tr, err := tracing.New(tracing.Config{Endpoint: hsrv.URL, SampleRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	et := newEntryTraces(tr)
+	ctx, root := tr.Start(context.Background(), `root`, tracing.KindServer)
+	old, young, untraced := &entry.Entry{}, &entry.Entry{}, &entry.Entry{}
+	et.queued(ctx, old, 1)
+	et.queued(ctx, young, 1)
+	et.queued(context.Background(), untraced, 1)
+	et.sent(old, `indexer`)
+	et.ents[old].since = time.Now().Add(-2 * maxTraceAge)
+	if et.pending != 2 {
+		t.Fatalf("expected 2 traced entries, got %d", et.pending)
+	}
+	et.expire(false)
+	if et.pending != 1 || et.ents[young] == nil {
+		t.Fatalf("bad traces after expire: %d", et.pending)
+	}
+	et.expire(true)
+	if et.pending != 0 || len(et.ents) != 0 {
+		t.Fatalf("bad traces after expiring everything: %d", et.pending)
+	}
+	root.End()
+	if err = tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	failed := map[string]string{}
+	for _, s := range sc.spans {
+		if s.Status != nil {
+			failed[s.Name] = s.Status.Message
+		}
+	}
+	if failed[`indexer.confirm`] != errTraceExpired.Error() || failed[`muxer.queue`] != errTraceClosed.Error() || len(sc.spans) != 4 {
+		t.Fatalf("bad expired spans %+v %d", failed, len(sc.spans))
+	}
+
+	//a nil set of traces is inert
+	et = newEntryTraces(nil)
+	et.queued(ctx, old, 1)
+	et.sent(old, `indexer`)
+	et.ack(old)
+	et.dropped(old, nil)
+	et.expire(true)
+}
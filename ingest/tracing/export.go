@@ -0,0 +1,279 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	exportInterval  = 5 * time.Second
+	exportBatch     = 512  // export as soon as this many spans are waiting
+	maxQueuedSpans  = 8192 // spans past this are dropped rather than growing without bound
+	exportTimeout   = 10 * time.Second
+	instrumentation = `github.com/gravwell/gravwell/v3/ingest`
+
+	statusError = 2
+)
+
+// exporter batches finished spans and posts them to an OTLP/HTTP collector as JSON
+type exporter struct {
+	dropped  uint64 // accessed atomically
+	mtx      sync.Mutex
+	endpoint string
+	service  string
+	headers  map[string]string
+	cli      *http.Client
+	spans    []*Span
+	kick     chan struct{}
+	done     chan struct{}
+	closing  sync.Once
+	wg       sync.WaitGroup
+	lastErr  error
+}
+
+func newExporter(c Config) *exporter {
+	return &exporter{
+		endpoint: c.Endpoint,
+		service:  c.Service,
+		headers:  c.Headers,
+		cli:      &http.Client{Timeout: exportTimeout},
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func (e *exporter) start() {
+	e.wg.Add(1)
+	go e.routine()
+}
+
+func (e *exporter) add(s *Span) {
+	e.mtx.Lock()
+	if len(e.spans) >= maxQueuedSpans {
+		e.mtx.Unlock()
+		atomic.AddUint64(&e.dropped, 1)
+		return
+	}
+	e.spans = append(e.spans, s)
+	full := len(e.spans) >= exportBatch
+	e.mtx.Unlock()
+	if full {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *exporter) routine() {
+	defer e.wg.Done()
+	tkr := time.NewTicker(exportInterval)
+	defer tkr.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-tkr.C:
+		case <-e.kick:
+		}
+		e.flush()
+	}
+}
+
+// close stops the export routine and makes a final attempt to export waiting spans
+func (e *exporter) close() error {
+	e.closing.Do(func() {
+		close(e.done)
+		e.wg.Wait()
+		e.flush()
+	})
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	return e.lastErr
+}
+
+// flush exports everything that is waiting, spans that fail to export are dropped
+func (e *exporter) flush() {
+	e.mtx.Lock()
+	spans := e.spans
+	e.spans = nil
+	e.mtx.Unlock()
+	for len(spans) > 0 {
+		n := len(spans)
+		if n > exportBatch {
+			n = exportBatch
+		}
+		err := e.post(spans[:n])
+		if err != nil {
+			atomic.AddUint64(&e.dropped, uint64(n))
+		}
+		e.mtx.Lock()
+		e.lastErr = err
+		e.mtx.Unlock()
+		spans = spans[n:]
+	}
+}
+
+func (e *exporter) post(spans []*Span) (err error) {
+	var b []byte
+	if b, err = json.Marshal(e.request(spans)); err != nil {
+		return
+	}
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(b)); err != nil {
+		return
+	}
+	req.Header.Set(`Content-Type`, `application/json`)
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	var resp *http.Response
+	if resp, err = e.cli.Do(req); err != nil {
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("trace collector returned %s", resp.Status)
+	}
+	return
+}
+
+// The OTLP JSON encoding of an ExportTraceServiceRequest, trace and span IDs are hex encoded
+// and 64 bit integers are strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string      `json:"traceId"`
+	SpanID       string      `json:"spanId"`
+	ParentSpanID string      `json:"parentSpanId,omitempty"`
+	Name         string      `json:"name"`
+	Kind         SpanKind    `json:"kind"`
+	Start        string      `json:"startTimeUnixNano"`
+	End          string      `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr  `json:"attributes,omitempty"`
+	Status       *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	String *string  `json:"stringValue,omitempty"`
+	Bool   *bool    `json:"boolValue,omitempty"`
+	Int    *string  `json:"intValue,omitempty"`
+	Double *float64 `json:"doubleValue,omitempty"`
+}
+
+func (e *exporter) request(spans []*Span) otlpRequest {
+	ss := otlpScopeSpans{
+		Scope: otlpScope{Name: instrumentation},
+		Spans: make([]otlpSpan, 0, len(spans)),
+	}
+	for _, s := range spans {
+		ss.Spans = append(ss.Spans, s.otlp())
+	}
+	return otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: []otlpAttr{newOTLPAttr(String(`service.name`, e.service))}},
+			ScopeSpans: []otlpScopeSpans{ss},
+		}},
+	}
+}
+
+func (s *Span) otlp() (r otlpSpan) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	r = otlpSpan{
+		TraceID:      s.traceID,
+		SpanID:       s.id,
+		ParentSpanID: s.parent,
+		Name:         s.name,
+		Kind:         s.kind,
+		Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+		End:          strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	for _, a := range s.attrs {
+		r.Attributes = append(r.Attributes, newOTLPAttr(a))
+	}
+	if s.err != `` {
+		r.Status = &otlpStatus{Code: statusError, Message: s.err}
+	}
+	return
+}
+
+func newOTLPAttr(a Attr) (oa otlpAttr) {
+	oa.Key = a.Key
+	switch v := a.Value.(type) {
+	case string:
+		oa.Value.String = &v
+	case bool:
+		oa.Value.Bool = &v
+	case int:
+		s := strconv.Itoa(v)
+		oa.Value.Int = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		oa.Value.Int = &s
+	case uint64:
+		s := strconv.FormatUint(v, 10)
+		oa.Value.Int = &s
+	case float64:
+		oa.Value.Double = &v
+	default:
+		s := fmt.Sprint(v)
+		oa.Value.String = &s
+	}
+	return
+}
+
+// Dropped returns how many spans were dropped because the queue was full or the export failed
+func (t *Tracer) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&t.exp.dropped)
+}
@@ -0,0 +1,281 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package tracing implements a small OpenTelemetry compatible tracer for the ingest pipeline.
+// Sampled entries get spans as they move from a listener through preprocessors and the muxer
+// to an indexer, spans are batched and exported to a collector using OTLP over HTTP.
+//
+// Tracers, spans, and contexts without a span are all safe to use when tracing is disabled,
+// a nil *Tracer never samples and a nil *Span ignores every call, so instrumented code does
+// not need to check whether tracing is enabled.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSampleRate = 0.01
+	DefaultService    = `gravwell-ingester`
+
+	tracesPath = `/v1/traces`
+)
+
+var (
+	ErrNoEndpoint        = errors.New("Trace endpoint is missing")
+	ErrInvalidEndpoint   = errors.New("Invalid trace endpoint")
+	ErrInvalidSampleRate = errors.New("Trace sample rate must be greater than 0 and no more than 1")
+)
+
+// SpanKind follows the OpenTelemetry span kinds
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+	KindProducer SpanKind = 4
+	KindConsumer SpanKind = 5
+)
+
+// Config controls where spans are sent and how many entries are traced
+type Config struct {
+	Endpoint   string            // OTLP/HTTP collector URL, /v1/traces is used if the URL has no path
+	Service    string            // service.name resource attribute, defaults to DefaultService
+	SampleRate float64           // fraction of entries traced, defaults to DefaultSampleRate
+	Headers    map[string]string // extra request headers, such as collector credentials
+}
+
+// Enabled reports whether the config asks for tracing
+func (c Config) Enabled() bool {
+	return c.Endpoint != ``
+}
+
+// Validate checks the config and fills in defaults
+func (c *Config) Validate() (err error) {
+	if c.Endpoint == `` {
+		return ErrNoEndpoint
+	}
+	var u *url.URL
+	if u, err = url.Parse(c.Endpoint); err != nil {
+		return fmt.Errorf("%w %q: %v", ErrInvalidEndpoint, c.Endpoint, err)
+	} else if (u.Scheme != `http` && u.Scheme != `https`) || u.Host == `` {
+		return fmt.Errorf("%w %q: must be an http or https URL", ErrInvalidEndpoint, c.Endpoint)
+	}
+	if u.Path == `` || u.Path == `/` {
+		u.Path = tracesPath
+	}
+	c.Endpoint = u.String()
+	if c.Service = strings.TrimSpace(c.Service); c.Service == `` {
+		c.Service = DefaultService
+	}
+	if c.SampleRate == 0 {
+		c.SampleRate = DefaultSampleRate
+	} else if c.SampleRate < 0 || c.SampleRate > 1 {
+		return ErrInvalidSampleRate
+	}
+	return
+}
+
+// Attr is a span attribute, values may be strings, bools, integers, or floats
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+func String(k, v string) Attr {
+	return Attr{Key: k, Value: v}
+}
+
+func Int(k string, v int64) Attr {
+	return Attr{Key: k, Value: v}
+}
+
+func Bool(k string, v bool) Attr {
+	return Attr{Key: k, Value: v}
+}
+
+// Tracer creates spans and hands finished spans to its exporter
+type Tracer struct {
+	mtx  sync.Mutex
+	rate float64
+	rnd  *mrand.Rand
+	exp  *exporter
+}
+
+// New creates a tracer and starts its exporter, the tracer must be closed to flush spans
+func New(c Config) (*Tracer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return nil, err
+	}
+	t := &Tracer{
+		rate: c.SampleRate,
+		rnd:  mrand.New(mrand.NewSource(int64(binary.LittleEndian.Uint64(seed[:])))),
+		exp:  newExporter(c),
+	}
+	t.exp.start()
+	return t, nil
+}
+
+// Sample decides whether to trace an entry, it is always false on a nil tracer
+func (t *Tracer) Sample() (r bool) {
+	if t == nil {
+		return false
+	}
+	t.mtx.Lock()
+	r = t.rate >= 1 || t.rnd.Float64() < t.rate
+	t.mtx.Unlock()
+	return
+}
+
+// Start begins a span, it is a child of the span in ctx if there is one and a new trace otherwise.
+// The returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var s *Span
+	if parent := SpanFromContext(ctx); parent != nil {
+		s = parent.Child(name, kind, attrs...)
+	} else {
+		s = t.newSpan(name, kind, attrs)
+		s.traceID = t.newID(16)
+	}
+	return ContextWithSpan(ctx, s), s
+}
+
+// Close flushes any spans that have not been exported and stops the exporter, it may be called more than once
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.exp.close()
+}
+
+func (t *Tracer) newSpan(name string, kind SpanKind, attrs []Attr) *Span {
+	return &Span{
+		t:     t,
+		name:  name,
+		kind:  kind,
+		id:    t.newID(8),
+		start: time.Now(),
+		attrs: attrs,
+	}
+}
+
+func (t *Tracer) newID(n int) string {
+	b := make([]byte, n)
+	t.mtx.Lock()
+	t.rnd.Read(b)
+	t.mtx.Unlock()
+	return hex.EncodeToString(b)
+}
+
+// Span is a single timed operation in a trace
+type Span struct {
+	mtx     sync.Mutex
+	t       *Tracer
+	name    string
+	kind    SpanKind
+	traceID string
+	id      string
+	parent  string
+	start   time.Time
+	end     time.Time
+	attrs   []Attr
+	err     string
+}
+
+// Child begins a span that is a child of this one
+func (s *Span) Child(name string, kind SpanKind, attrs ...Attr) *Span {
+	if s == nil {
+		return nil
+	}
+	c := s.t.newSpan(name, kind, attrs)
+	c.traceID, c.parent = s.traceID, s.id
+	return c
+}
+
+// SetAttrs adds attributes to the span
+func (s *Span) SetAttrs(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mtx.Unlock()
+}
+
+// SetError marks the span as failed, nil errors are ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mtx.Lock()
+	s.err = err.Error()
+	s.mtx.Unlock()
+}
+
+// End finishes the span and queues it for export, ending a span more than once does nothing
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	if !s.end.IsZero() {
+		s.mtx.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mtx.Unlock()
+	s.t.exp.add(s)
+}
+
+// TraceID returns the hex encoded trace ID, empty on a nil span
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ``
+	}
+	return s.traceID
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a context carrying a span, a nil span returns the context unchanged
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// SpanFromContext returns the span carried by a context, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
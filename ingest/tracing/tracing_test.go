@@ -0,0 +1,189 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type collector struct {
+	sync.Mutex
+	srv   *httptest.Server
+	spans []otlpSpan
+	svc   string
+	auth  string
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{}
+	c.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tracesPath || r.Header.Get(`Content-Type`) != `application/json` {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		c.Lock()
+		defer c.Unlock()
+		c.auth = r.Header.Get(`Authorization`)
+		for _, rs := range req.ResourceSpans {
+			for _, a := range rs.Resource.Attributes {
+				if a.Key == `service.name` && a.Value.String != nil {
+					c.svc = *a.Value.String
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(c.srv.Close)
+	return c
+}
+
+func (c *collector) byName() map[string]otlpSpan {
+	c.Lock()
+	defer c.Unlock()
+	r := map[string]otlpSpan{}
+	for _, s := range c.spans {
+		r[s.Name] = s
+	}
+	return r
+}
+
+func TestTracer(t *testing.T) {
+	c := newCollector(t)
+	tr, err := New(Config{
+		Endpoint:   c.srv.URL,
+		SampleRate: 1,
+		Headers:    map[string]string{`Authorization`: `Bearer test`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tr.Sample() {
+		t.Fatal("tracer with a sample rate of 1 did not sample")
+	}
+	ctx, root := tr.Start(context.Background(), `root`, KindServer, String(`listener`, `test`))
+	if SpanFromContext(ctx) != root {
+		t.Fatal("context does not carry the root span")
+	}
+	_, child := tr.Start(ctx, `child`, KindInternal)
+	grandchild := child.Child(`grandchild`, KindClient, Int(`entries`, 10), Bool(`ok`, false))
+	grandchild.SetError(errors.New("failed"))
+	grandchild.End()
+	child.End()
+	root.End()
+	root.End() //ending twice must not export twice
+	if err = tr.Close(); err != nil {
+		t.Fatal(err)
+	} else if err = tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.svc != DefaultService || c.auth != `Bearer test` {
+		t.Fatalf("bad export request: service %q auth %q", c.svc, c.auth)
+	} else if len(c.spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(c.spans))
+	}
+	spans := c.byName()
+	r, ch, gc := spans[`root`], spans[`child`], spans[`grandchild`]
+	if len(r.TraceID) != 32 || len(r.SpanID) != 16 || r.ParentSpanID != `` || r.Kind != KindServer {
+		t.Fatalf("bad root span: %+v", r)
+	} else if ch.TraceID != r.TraceID || ch.ParentSpanID != r.SpanID {
+		t.Fatalf("bad child span: %+v", ch)
+	} else if gc.TraceID != r.TraceID || gc.ParentSpanID != ch.SpanID {
+		t.Fatalf("bad grandchild span: %+v", gc)
+	} else if gc.Status == nil || gc.Status.Code != statusError || gc.Status.Message != `failed` || ch.Status != nil {
+		t.Fatalf("bad span status: %+v %+v", gc.Status, ch.Status)
+	} else if len(gc.Attributes) != 2 || gc.Attributes[0].Value.Int == nil || *gc.Attributes[0].Value.Int != `10` {
+		t.Fatalf("bad span attributes: %+v", gc.Attributes)
+	}
+	if start, err := strconv.ParseInt(r.Start, 10, 64); err != nil {
+		t.Fatal(err)
+	} else if end, err := strconv.ParseInt(r.End, 10, 64); err != nil {
+		t.Fatal(err)
+	} else if start <= 0 || end < start {
+		t.Fatalf("bad span times: %+v", r)
+	}
+	if tr.Dropped() != 0 {
+		t.Fatalf("dropped %d spans", tr.Dropped())
+	}
+}
+
+func TestTracerExportFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	tr, err := New(Config{Endpoint: srv.URL, SampleRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, s := tr.Start(context.Background(), `lost`, KindInternal)
+	s.End()
+	if err = tr.Close(); err == nil {
+		t.Fatal("failed export was not reported")
+	} else if tr.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped span, got %d", tr.Dropped())
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tr *Tracer
+	if tr.Sample() {
+		t.Fatal("nil tracer sampled")
+	}
+	ctx, s := tr.Start(context.Background(), `nothing`, KindInternal)
+	if s != nil || SpanFromContext(ctx) != nil {
+		t.Fatal("nil tracer created a span")
+	}
+	//none of these may panic
+	s.SetAttrs(String(`a`, `b`))
+	s.SetError(errors.New("error"))
+	s.Child(`child`, KindInternal).End()
+	s.End()
+	if s.TraceID() != `` || tr.Dropped() != 0 || tr.Close() != nil {
+		t.Fatal("nil tracer and span are not inert")
+	}
+	if SpanFromContext(nil) != nil || ContextWithSpan(ctx, nil) != ctx {
+		t.Fatal("bad context handling of nil spans")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	c := Config{Endpoint: `https://collector:4318/`}
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	} else if c.Endpoint != `https://collector:4318/v1/traces` || c.Service != DefaultService || c.SampleRate != DefaultSampleRate {
+		t.Fatalf("bad defaults: %+v", c)
+	}
+	bad := []Config{
+		{},
+		{Endpoint: `/v1/traces`},
+		{Endpoint: `grpc://collector:4317`},
+		{Endpoint: `http://collector`, SampleRate: -1},
+		{Endpoint: `http://collector`, SampleRate: 2},
+	}
+	for _, b := range bad {
+		if err := b.Validate(); err == nil {
+			t.Fatalf("failed to catch bad config %+v", b)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
@@ -138,7 +139,7 @@ func main() {
 		CachePath:          cfg.Global.Ingest_Cache_Path,
 		CacheSize:          cfg.Global.Max_Ingest_Cache,
 		CacheMode:          cfg.Global.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Global.Log_Source_Override),
+		LogSourceOverride:  cfg.Global.LogSourceOverride(),
 	}
 	igst, err := ingest.NewUniformMuxer(ingestConfig)
 	if err != nil {
@@ -188,6 +189,7 @@ func main() {
 		if err != nil {
 			lg.Fatal("preprocessor construction failed", log.KVErr(err))
 		}
+		procset.SetLatencyBudget(cfg.Global.PreprocessorMaxLatency(), 0)
 
 		// Get the subscription, creating if needed
 		subname := psv.Subscription_Name
@@ -275,9 +277,8 @@ func main() {
 			var src net.IP
 			if cfg.Global.Source_Override != `` {
 				// global override
-				src = net.ParseIP(cfg.Global.Source_Override)
-				if src == nil {
-					lg.Fatal("Global Source-Override is invalid", log.KV("override", cfg.Global.Source_Override))
+				if src, err = config.ParseSourceOverride(cfg.Global.Source_Override); err != nil {
+					lg.Fatal("Global Source-Override is invalid", log.KV("override", cfg.Global.Source_Override), log.KVErr(err))
 				}
 			}
 
@@ -0,0 +1,255 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"golang.org/x/time/rate"
+)
+
+const (
+	apiKeyAuth authType = `api-key`
+)
+
+var (
+	ErrAPIKeyMissingKey      = errors.New("API-Key requires a Key")
+	ErrAPIKeyMissingListener = errors.New("API-Key requires at least one Listener")
+	ErrAPIKeyDuplicate       = errors.New("API-Key value is used by more than one key on the same listener")
+	ErrAPIKeyBadListener     = errors.New("API-Key Listener does not use api-key authentication")
+	ErrAPIKeyRequestTooLarge = errors.New("request exceeds the API key Max-Request-Size")
+)
+
+// apiKeyCfg is a named key from an [API-Key "name"] config section.  Keys are reloaded on SIGHUP,
+// removing a key or setting Disabled revokes it without restarting the ingester.
+type apiKeyCfg struct {
+	Key              string   `json:"-"` // DO NOT send this when marshalling
+	Listener         []string //listeners the key may send to
+	Tag_Name         string   //default tag for requests using this key
+	Source_Override  string   //SRC applied to entries sent with this key
+	Rate_Limit       float64  //requests per second, zero is unlimited
+	Max_Request_Size string   //largest request body accepted, e.g. 512KB
+	Disabled         bool
+}
+
+func (k *apiKeyCfg) validate(name string) (err error) {
+	if strings.TrimSpace(k.Key) == `` {
+		err = ErrAPIKeyMissingKey
+	} else if len(k.Listener) == 0 {
+		err = ErrAPIKeyMissingListener
+	} else if k.Tag_Name != `` && ingest.CheckTag(k.Tag_Name) != nil {
+		err = fmt.Errorf("invalid Tag-Name %q: %v", k.Tag_Name, ingest.CheckTag(k.Tag_Name))
+	} else if k.Rate_Limit < 0 || k.Rate_Limit > maxTokenRateLimit {
+		err = fmt.Errorf("Rate-Limit must be between 0 and %d", maxTokenRateLimit)
+	} else if _, err = k.sourceOverride(); err == nil {
+		_, err = k.maxRequestSize()
+	}
+	if err != nil {
+		err = fmt.Errorf("API-Key %s: %w", name, err)
+	}
+	return
+}
+
+func (k *apiKeyCfg) sourceOverride() (ip net.IP, err error) {
+	if k.Source_Override == `` {
+		return
+	}
+	if ip, err = config.ParseSource(k.Source_Override); err != nil {
+		err = fmt.Errorf("invalid Source-Override %q: %v", k.Source_Override, err)
+	}
+	return
+}
+
+func (k *apiKeyCfg) maxRequestSize() (sz int64, err error) {
+	if k.Max_Request_Size == `` {
+		return
+	}
+	if sz, err = config.ParseDataSize(k.Max_Request_Size); err != nil {
+		err = fmt.Errorf("invalid Max-Request-Size %q: %v", k.Max_Request_Size, err)
+	}
+	return
+}
+
+// checkAPIKeys validates the keys against the listeners they reference
+func checkAPIKeys(keys map[string]*apiKeyCfg, listeners map[string]*lst) error {
+	seen := map[string]string{}
+	for name, k := range keys {
+		if err := k.validate(name); err != nil {
+			return err
+		}
+		hash := hashToken(k.Key)
+		for _, l := range k.Listener {
+			if v, ok := listeners[l]; ok && v.AuthType != apiKeyAuth {
+				return fmt.Errorf("API-Key %s: %w: %s", name, ErrAPIKeyBadListener, l)
+			} else if orig, ok := seen[l+`/`+hash]; ok {
+				return fmt.Errorf("API-Key %s and %s: %w", name, orig, ErrAPIKeyDuplicate)
+			}
+			seen[l+`/`+hash] = name
+		}
+	}
+	return nil
+}
+
+// apiKey is a loaded key, the key itself is only kept as a hash
+type apiKey struct {
+	name    string
+	rate    float64
+	lmt     *rate.Limiter
+	tag     entry.EntryTag
+	tagged  bool
+	src     net.IP
+	maxBody int64
+}
+
+// apiKeys holds the keys for every api-key listener, keyed by listener name and key hash
+type apiKeys struct {
+	sync.RWMutex
+	keys map[string]map[string]*apiKey
+}
+
+func newAPIKeys() *apiKeys {
+	return &apiKeys{
+		keys: map[string]map[string]*apiKey{},
+	}
+}
+
+// load replaces the key set.  Keys which are unchanged keep their rate limiter state so
+// a reload does not hand every client a fresh burst.
+func (ak *apiKeys) load(cfgs map[string]*apiKeyCfg, igst *ingest.IngestMuxer) (err error) {
+	nk := map[string]map[string]*apiKey{}
+	for name, c := range cfgs {
+		if c.Disabled {
+			continue
+		}
+		k := &apiKey{
+			name: name,
+			rate: c.Rate_Limit,
+		}
+		if k.src, err = c.sourceOverride(); err != nil {
+			return
+		} else if k.maxBody, err = c.maxRequestSize(); err != nil {
+			return
+		}
+		if c.Tag_Name != `` {
+			if k.tag, err = igst.NegotiateTag(c.Tag_Name); err != nil {
+				return fmt.Errorf("API-Key %s failed to negotiate tag %s: %w", name, c.Tag_Name, err)
+			}
+			k.tagged = true
+		}
+		hash := hashToken(c.Key)
+		for _, l := range c.Listener {
+			if nk[l] == nil {
+				nk[l] = map[string]*apiKey{}
+			}
+			lk := *k
+			nk[l][hash] = &lk
+		}
+	}
+	ak.Lock()
+	defer ak.Unlock()
+	for l, set := range nk {
+		for hash, k := range set {
+			if old, ok := ak.keys[l][hash]; ok && old.rate == k.rate {
+				k.lmt = old.lmt
+			} else {
+				k.lmt = newTokenLimiter(k.rate)
+			}
+		}
+	}
+	ak.keys = nk
+	return
+}
+
+func (ak *apiKeys) count() (n int) {
+	ak.RLock()
+	for _, set := range ak.keys {
+		n += len(set)
+	}
+	ak.RUnlock()
+	return
+}
+
+// apiKeyHandler authenticates a listener against its configured API keys.  The key is sent as
+// a bearer token in the Authorization header, or raw in the TokenName header if one is set.
+type apiKeyHandler struct {
+	noLogin
+	keys     *apiKeys
+	listener string
+	hdrName  string
+}
+
+func newAPIKeyHandler(keys *apiKeys, listener, hdrName string) (hnd authHandler, err error) {
+	if keys == nil {
+		err = errors.New("no API keys loaded")
+	} else {
+		hnd = &apiKeyHandler{
+			keys:     keys,
+			listener: listener,
+			hdrName:  hdrName,
+		}
+	}
+	return
+}
+
+func (akh *apiKeyHandler) AuthRequest(r *http.Request) (err error) {
+	_, err = akh.authKey(r)
+	return
+}
+
+// authKey returns the key used by the request
+func (akh *apiKeyHandler) authKey(r *http.Request) (k *apiKey, err error) {
+	var tok string
+	if akh.hdrName == `` {
+		tok, err = getAuthToken(r, defaultTokenName)
+	} else {
+		tok, err = getHeaderToken(r, akh.hdrName)
+	}
+	if err != nil {
+		return
+	}
+	akh.keys.RLock()
+	k, ok := akh.keys.keys[akh.listener][hashToken(tok)]
+	akh.keys.RUnlock()
+	if !ok {
+		return nil, ErrUnauthorized
+	} else if k.lmt != nil && !k.lmt.Allow() {
+		return nil, ErrTokenRateLimited
+	}
+	return
+}
+
+// quotaReader fails reads once more than max bytes have been read
+type quotaReader struct {
+	r   io.Reader
+	rem int64
+}
+
+func (qr *quotaReader) Read(b []byte) (n int, err error) {
+	if qr.rem < 0 {
+		return 0, ErrAPIKeyRequestTooLarge
+	}
+	if int64(len(b)) > qr.rem+1 {
+		b = b[:qr.rem+1]
+	}
+	n, err = qr.r.Read(b)
+	if qr.rem -= int64(n); qr.rem < 0 {
+		n += int(qr.rem)
+		err = ErrAPIKeyRequestTooLarge
+	}
+	return
+}
@@ -0,0 +1,51 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestQuotaReader(t *testing.T) {
+	tests := []struct {
+		size  int
+		quota int64
+		ok    bool
+	}{
+		{size: 0, quota: 0, ok: true},
+		{size: 1, quota: 0},
+		{size: 99, quota: 100, ok: true},
+		{size: 100, quota: 100, ok: true},
+		{size: 101, quota: 100},
+		{size: 4096, quota: 100},
+	}
+	for _, tc := range tests {
+		data := bytes.Repeat([]byte{'x'}, tc.size)
+		for _, small := range []bool{false, true} {
+			qr := &quotaReader{r: bytes.NewReader(data), rem: tc.quota}
+			if small {
+				//a reader that hands back a byte at a time must hit the quota at the same place
+				qr.r = iotest.OneByteReader(bytes.NewReader(data))
+			}
+			b, err := ioutil.ReadAll(qr)
+			if tc.ok {
+				if err != nil || len(b) != tc.size {
+					t.Fatalf("%d/%d: read %d bytes: %v", tc.size, tc.quota, len(b), err)
+				}
+			} else if err != ErrAPIKeyRequestTooLarge {
+				t.Fatalf("%d/%d: oversized read not refused: %v", tc.size, tc.quota, err)
+			} else if int64(len(b)) > tc.quota {
+				t.Fatalf("%d/%d: returned %d bytes past the quota", tc.size, tc.quota, len(b))
+			}
+		}
+	}
+}
@@ -65,6 +65,12 @@ type auth struct {
 	LoginURL   string
 	TokenName  string
 	TokenValue string `json:"-"` // DO NOT send this when marshalling
+
+	//hmac authentication, TokenName is the signature header and TokenValue the shared secret
+	HMACHash           string `json:",omitempty"` // sha1, sha256, or sha512
+	TimestampHeader    string `json:",omitempty"` // enables replay protection
+	NonceHeader        string `json:",omitempty"` // optional, the signature is used if not set
+	TimestampTolerance string `json:",omitempty"` // how far request timestamps may drift
 }
 
 type authHandler interface {
@@ -119,6 +125,20 @@ func (a *auth) Validate() (enabled bool, err error) {
 			return
 		}
 		enabled = true
+	case hmacAuth:
+		if a.TokenName == `` {
+			a.TokenName = defaultHMACHeader
+		}
+		if _, _, err = a.hmacConfig(); err != nil {
+			return
+		}
+		enabled = true
+	case scopedToken:
+		//tokens live in the token database, which is checked by the global config
+		enabled = true
+	case apiKeyAuth:
+		//keys are defined in API-Key sections, which are checked by the global config
+		enabled = true
 	}
 	return
 }
@@ -147,6 +167,8 @@ func (a auth) NewAuthHandler(lgr *log.Logger) (url string, hnd authHandler, err
 		hnd, err = newPresharedParamHandler(a.TokenName, a.TokenValue, lgr)
 	case hdrToken:
 		hnd, err = newPresharedHeaderTokenHandler(a.TokenName, a.TokenValue, lgr)
+	case hmacAuth:
+		hnd, err = newHMACAuthHandler(a, lgr)
 	default:
 		err = fmt.Errorf("Unknown authentication type %q", a.AuthType)
 	}
@@ -165,6 +187,9 @@ func parseAuthType(v string) (r authType, err error) {
 	case preToken:
 	case preParam:
 	case hdrToken:
+	case hmacAuth:
+	case scopedToken:
+	case apiKeyAuth:
 	default:
 		r = none
 		err = ErrInvalidAuthType
@@ -0,0 +1,100 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+)
+
+const (
+	// how long rejected clients are asked to wait, a couple of queue polls is plenty to see a change
+	backpressureRetryAfter = 1
+)
+
+var (
+	ErrBackpressureStatus      = errors.New("Backpressure-Status must be 429 or 503")
+	ErrBackpressureWithoutMode = errors.New("Backpressure-High-Water, Backpressure-Low-Water, and Backpressure-Status require a Backpressure mode")
+)
+
+// backpressure is the global behavior while the ingest queue is saturated.  pause and block
+// act on the listening socket, reject answers requests with Backpressure-Status.
+type backpressure struct {
+	gauge  *netframe.Gauge
+	mode   netframe.BackpressureMode
+	status int
+}
+
+func (g gbl) validateBackpressure() (err error) {
+	var mode netframe.BackpressureMode
+	if mode, err = netframe.ParseBackpressureMode(g.Backpressure); err != nil {
+		return
+	} else if mode == netframe.BackpressureNone {
+		if g.Backpressure_High_Water != 0 || g.Backpressure_Low_Water != 0 || g.Backpressure_Status != 0 {
+			err = ErrBackpressureWithoutMode
+		}
+		return
+	}
+	switch g.Backpressure_Status {
+	case 0, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return ErrBackpressureStatus
+	}
+	_, err = netframe.NewGauge(nil, g.Backpressure_High_Water, g.Backpressure_Low_Water, nil)
+	return
+}
+
+// newBackpressure returns nil if no backpressure mode is set
+func newBackpressure(g gbl, igst *ingest.IngestMuxer, lgr *log.Logger) (bp *backpressure, err error) {
+	mode, err := netframe.ParseBackpressureMode(g.Backpressure)
+	if err != nil || mode == netframe.BackpressureNone {
+		return
+	}
+	bp = &backpressure{
+		mode:   mode,
+		status: g.Backpressure_Status,
+	}
+	if bp.status == 0 {
+		bp.status = http.StatusServiceUnavailable
+	}
+	bp.gauge, err = netframe.NewGauge(igst.QueueDepth, g.Backpressure_High_Water, g.Backpressure_Low_Water, func(engaged bool) {
+		queued, capacity := igst.QueueDepth()
+		if engaged {
+			lgr.Warn("ingest queue saturated, pushing back on clients", log.KV("mode", mode), log.KV("queued", queued), log.KV("capacity", capacity))
+		} else {
+			lgr.Info("ingest queue drained, resuming", log.KV("mode", mode), log.KV("queued", queued), log.KV("capacity", capacity), log.KV("rejected", bp.gauge.Rejected()))
+		}
+	})
+	return
+}
+
+// listener applies the socket level modes, reject is handled per request
+func (bp *backpressure) listener(l net.Listener) net.Listener {
+	if bp == nil || bp.mode == netframe.BackpressureReject {
+		return l
+	}
+	return bp.gauge.Listener(l, bp.mode)
+}
+
+// reject answers the request and returns true if requests are being turned away
+func (bp *backpressure) reject(w http.ResponseWriter) bool {
+	if bp == nil || bp.mode != netframe.BackpressureReject || !bp.gauge.Engaged() {
+		return false
+	}
+	bp.gauge.Reject()
+	w.Header().Set(`Retry-After`, strconv.Itoa(backpressureRetryAfter))
+	w.WriteHeader(bp.status)
+	return true
+}
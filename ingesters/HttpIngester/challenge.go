@@ -0,0 +1,228 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+// Webhook providers which verify an endpoint before sending it events
+const (
+	challengeSlack     = `slack`     // Slack Events API url_verification
+	challengeSNS       = `sns`       // AWS SNS SubscriptionConfirmation
+	challengeMSGraph   = `msgraph`   // Microsoft Graph change notification validationToken
+	challengeEventGrid = `eventgrid` // Azure Event Grid subscription validation and CloudEvents webhook validation
+
+	snsMessageTypeHeader = `X-Amz-Sns-Message-Type`
+	aegEventTypeHeader   = `Aeg-Event-Type`
+	webhookOriginHeader  = `WebHook-Request-Origin`
+	snsConfirmTimeout    = 30 * time.Second
+)
+
+var (
+	ErrUnknownChallenge = errors.New("unknown Webhook-Challenge, expected slack, sns, msgraph, or eventgrid")
+	ErrBadSNSSubscribe  = errors.New("SNS SubscribeURL is not an https amazonaws.com SNS endpoint")
+
+	snsHostRe = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+)
+
+// challenges answers webhook verification handshakes so sources can be onboarded without a shim.
+// The Graph validation request and the CloudEvents webhook OPTIONS probe carry no credentials so
+// they are answered before authentication, Slack, SNS, and Event Grid subscription handshakes are
+// answered after the request authenticates like any other.
+type challenges struct {
+	slack     bool
+	sns       bool
+	msgraph   bool
+	eventgrid bool
+	cli       *http.Client
+	lgr       *log.Logger
+}
+
+// newChallenges returns nil if no challenges are enabled
+func newChallenges(names []string, lgr *log.Logger) (c *challenges, err error) {
+	if len(names) == 0 {
+		return
+	}
+	c = &challenges{
+		lgr: lgr,
+	}
+	for _, n := range names {
+		switch strings.ToLower(strings.TrimSpace(n)) {
+		case challengeSlack:
+			c.slack = true
+		case challengeSNS:
+			c.sns = true
+			c.cli = &http.Client{Timeout: snsConfirmTimeout}
+		case challengeMSGraph:
+			c.msgraph = true
+		case challengeEventGrid:
+			c.eventgrid = true
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnknownChallenge, n)
+		}
+	}
+	return
+}
+
+// isWebhookProbe returns true for a CloudEvents webhook validation request, which is an OPTIONS
+// request sent to the URL events will be POSTed to
+func isWebhookProbe(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get(webhookOriginHeader) != ``
+}
+
+// preAuth answers a Microsoft Graph validation request by echoing the validation token, and a
+// CloudEvents webhook validation request by allowing the requesting origin
+func (c *challenges) preAuth(w http.ResponseWriter, r *http.Request) (handled bool) {
+	if c == nil {
+		return
+	}
+	if c.eventgrid && isWebhookProbe(r) {
+		w.Header().Set(`WebHook-Allowed-Origin`, r.Header.Get(webhookOriginHeader))
+		w.Header().Set(`WebHook-Allowed-Rate`, `*`)
+		w.WriteHeader(http.StatusOK)
+		return true
+	}
+	if !c.msgraph {
+		return
+	}
+	tok, ok := r.URL.Query()[`validationToken`]
+	if !ok || len(tok) == 0 {
+		return
+	}
+	w.Header().Set(`Content-Type`, `text/plain`)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, tok[0])
+	return true
+}
+
+// postAuth answers Slack and SNS handshakes.  If the request is not a handshake the returned
+// reader yields the complete body, including anything read while checking.
+func (c *challenges) postAuth(w http.ResponseWriter, r *http.Request, rdr io.Reader) (handled bool, body io.Reader) {
+	body = rdr
+	if c == nil {
+		return
+	}
+	if c.sns {
+		switch r.Header.Get(snsMessageTypeHeader) {
+		case `SubscriptionConfirmation`:
+			c.confirmSNS(w, rdr)
+			return true, nil
+		case `UnsubscribeConfirmation`:
+			// nothing to do, but it is not an event either
+			return true, nil
+		}
+	}
+	if c.eventgrid && r.Header.Get(aegEventTypeHeader) == `SubscriptionValidation` {
+		c.validateEventGrid(w, rdr)
+		return true, nil
+	}
+	if c.slack && strings.HasPrefix(r.Header.Get(`Content-Type`), `application/json`) {
+		b, err := ioutil.ReadAll(io.LimitReader(rdr, int64(maxBody+1)))
+		body = io.MultiReader(bytes.NewReader(b), rdr)
+		if err != nil || len(b) > maxBody {
+			return
+		}
+		var v struct {
+			Type      string `json:"type"`
+			Challenge string `json:"challenge"`
+		}
+		if json.Unmarshal(b, &v) == nil && v.Type == `url_verification` {
+			w.Header().Set(`Content-Type`, `text/plain`)
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, v.Challenge)
+			return true, nil
+		}
+	}
+	return
+}
+
+func (c *challenges) confirmSNS(w http.ResponseWriter, rdr io.Reader) {
+	var msg struct {
+		TopicArn     string
+		SubscribeURL string
+	}
+	if err := json.NewDecoder(io.LimitReader(rdr, int64(maxBody))).Decode(&msg); err != nil {
+		c.lgr.Info("bad SNS subscription confirmation", log.KVErr(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	u, err := checkSNSSubscribeURL(msg.SubscribeURL)
+	if err != nil {
+		c.lgr.Warn("refusing SNS subscription confirmation", log.KV("topic", msg.TopicArn), log.KV("url", msg.SubscribeURL), log.KVErr(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// confirm in the background, SNS does not wait on our response to the handshake
+	go func() {
+		resp, err := c.cli.Get(u.String())
+		if err != nil {
+			c.lgr.Error("failed to confirm SNS subscription", log.KV("topic", msg.TopicArn), log.KVErr(err))
+			return
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			c.lgr.Error("failed to confirm SNS subscription", log.KV("topic", msg.TopicArn), log.KV("status", resp.Status))
+			return
+		}
+		c.lgr.Info("confirmed SNS subscription", log.KV("topic", msg.TopicArn))
+	}()
+	w.WriteHeader(http.StatusOK)
+}
+
+// validateEventGrid answers an Event Grid schema subscription validation event with its validation code
+func (c *challenges) validateEventGrid(w http.ResponseWriter, rdr io.Reader) {
+	var evs []struct {
+		EventType string `json:"eventType"`
+		Data      struct {
+			ValidationCode string `json:"validationCode"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(rdr, int64(maxBody))).Decode(&evs); err != nil {
+		c.lgr.Info("bad Event Grid subscription validation", log.KVErr(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, ev := range evs {
+		if ev.EventType == `Microsoft.EventGrid.SubscriptionValidationEvent` && ev.Data.ValidationCode != `` {
+			w.Header().Set(`Content-Type`, `application/json`)
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(map[string]string{`validationResponse`: ev.Data.ValidationCode})
+			c.lgr.Info("validated Event Grid subscription")
+			return
+		}
+	}
+	c.lgr.Info("Event Grid subscription validation is missing a validation code")
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+// checkSNSSubscribeURL makes sure we only ever fetch an SNS confirmation endpoint
+func checkSNSSubscribeURL(s string) (u *url.URL, err error) {
+	if u, err = url.Parse(s); err != nil {
+		return
+	} else if u.Scheme != `https` || u.User != nil || u.Port() != `` || !snsHostRe.MatchString(u.Hostname()) {
+		return nil, ErrBadSNSSubscribe
+	} else if u.Query().Get(`Action`) != `ConfirmSubscription` {
+		return nil, ErrBadSNSSubscribe
+	}
+	return
+}
@@ -0,0 +1,322 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	ceStructuredType = `application/cloudevents+json`
+	ceBatchType      = `application/cloudevents-batch+json`
+	ceHeaderPrefix   = `Ce-`
+	ceSpecVersion    = `1.0`
+)
+
+var (
+	ErrCETagMap          = errors.New("CloudEvents-Tag must be of the form type:<pattern>=tag or source:<pattern>=tag")
+	ErrCEWithoutMode     = errors.New("CloudEvents-Tag, CloudEvents-Attribute, and CloudEvents-Data-Only require CloudEvents=true")
+	ErrCEWithMultipart   = errors.New("CloudEvents cannot be combined with multipart uploads")
+	ErrCEBadSpecVersion  = errors.New("unsupported CloudEvents specversion")
+	ErrCEMissingRequired = errors.New("CloudEvent is missing a required id, source, or type attribute")
+	ErrCEBadContentType  = errors.New("request is not a CloudEvents structured, batched, or binary mode request")
+)
+
+// cloudEventsConfig implements the CloudEvents 1.0 HTTP protocol binding in structured, batched,
+// and binary content modes.  Events may be routed to tags by their type or source attribute and
+// selected attributes are attached to each entry.
+type cloudEventsConfig struct {
+	tagMap   []ceTagRule
+	attrs    []string
+	dataOnly bool
+	ann      processors.Annotator
+}
+
+type ceTagRule struct {
+	attr    string // type or source
+	pattern string
+	g       glob.Glob
+	tagName string
+	tag     entry.EntryTag
+}
+
+// cloudEvent holds the attributes we use, extension attributes are kept as raw JSON
+type cloudEvent struct {
+	attrs      map[string]json.RawMessage
+	data       []byte
+	structured []byte // the complete event in structured and batched mode
+}
+
+// ceRequest carries what the handler needs from a request, it is filled in per request
+type ceRequest struct {
+	contentType string
+	binary      map[string]string // ce- header attributes in binary mode
+}
+
+// parseCloudEventsConfig returns nil if CloudEvents handling is not enabled
+func parseCloudEventsConfig(enabled bool, tagMap, attrs []string, dataOnly bool, mode, field string) (cc *cloudEventsConfig, err error) {
+	if !enabled {
+		if len(tagMap) > 0 || len(attrs) > 0 || dataOnly {
+			err = ErrCEWithoutMode
+		}
+		return
+	}
+	cc = &cloudEventsConfig{
+		dataOnly: dataOnly,
+	}
+	if cc.ann, err = processors.NewAnnotator(mode, field); err != nil {
+		return nil, err
+	}
+	for _, tm := range tagMap {
+		var rule ceTagRule
+		colon := strings.IndexByte(tm, ':')
+		eq := strings.LastIndexByte(tm, '=')
+		if colon <= 0 || eq <= colon+1 {
+			return nil, fmt.Errorf("%w: %q", ErrCETagMap, tm)
+		}
+		rule.attr = strings.ToLower(strings.TrimSpace(tm[:colon]))
+		rule.pattern = strings.TrimSpace(tm[colon+1 : eq])
+		rule.tagName = strings.TrimSpace(tm[eq+1:])
+		if rule.attr != `type` && rule.attr != `source` {
+			return nil, fmt.Errorf("%w: %q", ErrCETagMap, tm)
+		} else if rule.g, err = glob.Compile(rule.pattern); err != nil {
+			return nil, fmt.Errorf("invalid CloudEvents-Tag pattern %q: %v", rule.pattern, err)
+		} else if err = ingest.CheckTag(rule.tagName); err != nil {
+			return nil, fmt.Errorf("CloudEvents-Tag %s: %w", tm, err)
+		}
+		cc.tagMap = append(cc.tagMap, rule)
+	}
+	for _, a := range attrs {
+		if a = strings.ToLower(strings.TrimSpace(a)); a == `` {
+			continue
+		}
+		cc.attrs = append(cc.attrs, a)
+	}
+	return
+}
+
+// tagNames returns the tags events may be routed to
+func (cc *cloudEventsConfig) tagNames() (r []string) {
+	if cc == nil {
+		return
+	}
+	for _, rule := range cc.tagMap {
+		r = append(r, rule.tagName)
+	}
+	return
+}
+
+func (cc *cloudEventsConfig) resolveTags(igst *ingest.IngestMuxer) (err error) {
+	for i := range cc.tagMap {
+		if cc.tagMap[i].tag, err = igst.NegotiateTag(cc.tagMap[i].tagName); err != nil {
+			return fmt.Errorf("failed to negotiate tag %s: %w", cc.tagMap[i].tagName, err)
+		}
+	}
+	return
+}
+
+// request captures the content mode and any binary mode attributes
+func (cc *cloudEventsConfig) request(r *http.Request) (cr ceRequest) {
+	cr.contentType, _, _ = mime.ParseMediaType(r.Header.Get(`Content-Type`))
+	if r.Header.Get(ceHeaderPrefix+`Specversion`) == `` {
+		return
+	}
+	cr.binary = map[string]string{}
+	for k, v := range r.Header {
+		if len(k) > len(ceHeaderPrefix) && strings.EqualFold(k[:len(ceHeaderPrefix)], ceHeaderPrefix) && len(v) > 0 {
+			cr.binary[strings.ToLower(k[len(ceHeaderPrefix):])] = v[0]
+		}
+	}
+	if ct := r.Header.Get(`Content-Type`); ct != `` {
+		cr.binary[`datacontenttype`] = ct
+	}
+	return
+}
+
+// parse decodes the events in a request body
+func (cr ceRequest) parse(b []byte) (evs []cloudEvent, err error) {
+	switch {
+	case cr.binary != nil:
+		ev := cloudEvent{
+			attrs: make(map[string]json.RawMessage, len(cr.binary)),
+			data:  b,
+		}
+		for k, v := range cr.binary {
+			ev.attrs[k], _ = json.Marshal(v)
+		}
+		evs = append(evs, ev)
+	case cr.contentType == ceStructuredType:
+		var ev cloudEvent
+		if ev, err = parseStructuredEvent(b); err == nil {
+			evs = append(evs, ev)
+		}
+	case cr.contentType == ceBatchType:
+		var raw []json.RawMessage
+		if err = json.Unmarshal(b, &raw); err != nil {
+			return
+		}
+		for _, r := range raw {
+			var ev cloudEvent
+			if ev, err = parseStructuredEvent(r); err != nil {
+				return nil, err
+			}
+			evs = append(evs, ev)
+		}
+	default:
+		err = ErrCEBadContentType
+		return
+	}
+	for _, ev := range evs {
+		if err = ev.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+func parseStructuredEvent(b []byte) (ev cloudEvent, err error) {
+	if err = json.Unmarshal(b, &ev.attrs); err != nil {
+		return
+	}
+	ev.structured = b
+	if v, ok := ev.attrs[`data_base64`]; ok {
+		var s string
+		if err = json.Unmarshal(v, &s); err != nil {
+			return
+		} else if ev.data, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return
+		}
+	} else if v, ok := ev.attrs[`data`]; ok {
+		var s string
+		if json.Unmarshal(v, &s) == nil {
+			// string data is stored without the JSON quoting
+			ev.data = []byte(s)
+		} else {
+			ev.data = []byte(v)
+		}
+	}
+	delete(ev.attrs, `data`)
+	delete(ev.attrs, `data_base64`)
+	return
+}
+
+// attr returns a string attribute, other JSON types are returned in their JSON encoding
+func (ev cloudEvent) attr(name string) (v string) {
+	raw, ok := ev.attrs[name]
+	if !ok {
+		return
+	} else if json.Unmarshal(raw, &v) != nil {
+		v = string(bytes.TrimSpace(raw))
+	}
+	return
+}
+
+func (ev cloudEvent) validate() error {
+	if sv := ev.attr(`specversion`); sv != ceSpecVersion {
+		return fmt.Errorf("%w %q", ErrCEBadSpecVersion, sv)
+	} else if ev.attr(`id`) == `` || ev.attr(`source`) == `` || ev.attr(`type`) == `` {
+		return ErrCEMissingRequired
+	}
+	return nil
+}
+
+// tagFor returns the mapped tag for an event, ok is false if no rule matches
+func (cc *cloudEventsConfig) tagFor(ev cloudEvent) (tg entry.EntryTag, ok bool) {
+	for _, rule := range cc.tagMap {
+		if rule.g.Match(ev.attr(rule.attr)) {
+			return rule.tag, true
+		}
+	}
+	return
+}
+
+// annotations returns the configured attributes present on an event, * selects every attribute
+func (cc *cloudEventsConfig) annotations(ev cloudEvent) (anns []processors.Annotation) {
+	for _, a := range cc.attrs {
+		if a == `*` {
+			names := make([]string, 0, len(ev.attrs))
+			for k := range ev.attrs {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			anns = anns[:0]
+			for _, k := range names {
+				anns = append(anns, processors.Annotation{Name: k, Value: ev.attr(k)})
+			}
+			return
+		}
+		if v := ev.attr(a); v != `` {
+			anns = append(anns, processors.Annotation{Name: a, Value: v})
+		}
+	}
+	return
+}
+
+func handleCloudEvents(h *handler, cfg routeHandler, w http.ResponseWriter, rdr io.Reader, ip net.IP) {
+	cc := cfg.ce
+	b, err := ioutil.ReadAll(io.LimitReader(rdr, int64(maxBody+1)))
+	if err != nil {
+		h.lgr.Info("got bad request", log.KV("address", ip), log.KVErr(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if len(b) > maxBody {
+		h.lgr.Error("request too large", log.KV("address", ip), log.KV("max", maxBody))
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	evs, err := cfg.ceReq.parse(b)
+	if err != nil {
+		h.lgr.Info("bad CloudEvents request", log.KV("address", ip), log.KV("listener", cfg.name), log.KVErr(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, ev := range evs {
+		erh := cfg
+		if tg, ok := cc.tagFor(ev); ok {
+			erh.tag = tg
+		}
+		if t := ev.attr(`time`); t != `` {
+			if ts, err := time.Parse(time.RFC3339Nano, t); err == nil {
+				erh.evTime = ts
+			}
+		}
+		erh.fields = append(cfg.fields[:len(cfg.fields):len(cfg.fields)], cc.annotations(ev)...)
+		erh.fieldAnn = cc.ann
+		data := ev.structured
+		if data == nil || cc.dataOnly {
+			data = ev.data
+		}
+		if len(data) == 0 {
+			continue
+		}
+		if err = h.handleEntry(erh, data, ip); err != nil {
+			h.lgr.Error("failed to handle entry", log.KV("address", ip), log.KVErr(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
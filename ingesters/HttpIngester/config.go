@@ -9,7 +9,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
@@ -23,6 +22,9 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
 const (
@@ -35,11 +37,30 @@ const (
 
 type gbl struct {
 	config.IngestConfig
-	Bind                 string
-	Max_Body             int
-	TLS_Certificate_File string
-	TLS_Key_File         string
-	Health_Check_URL     string
+	Bind                    string
+	Max_Body                int
+	TLS_Certificate_File    string
+	TLS_Key_File            string
+	TLS_Client_CA_File      string //CA bundle, when set clients must present a certificate signed by one of its CAs
+	Health_Check_URL        string
+	Token_Database          string   //path to the scoped token database
+	Token_Admin_URL         string   //URL used to manage scoped tokens
+	Token_Admin_Secret      string   `json:"-"` // DO NOT send this when marshalling
+	Listener_Admin_URL      string   //URL used to add, update, and remove listeners at runtime
+	Listener_Admin_Secret   string   `json:"-"` // DO NOT send this when marshalling
+	Listener_Database       string   //path where runtime listeners are persisted
+	Accept_From             []string //CIDRs, IPs, or list files allowed to connect at all
+	Deny_From               []string //CIDRs, IPs, or list files refused before any TLS handshake
+	Backpressure            string   //none, pause, reject, or block while the ingest queue is saturated
+	Backpressure_High_Water int      //queue fill percentage where backpressure engages, defaults to 90
+	Backpressure_Low_Water  int      //queue fill percentage where backpressure releases, defaults to 50
+	Backpressure_Status     int      //status sent to rejected requests, 429 or 503 (default)
+	Read_Timeout            string   //time allowed to read a request including its body, defaults to 5s (15m with streaming listeners), 0 disables
+	Write_Timeout           string   //time allowed to handle a request and write the response, defaults like Read-Timeout, 0 disables
+	Idle_Timeout            string   //time an idle keep-alive connection is held open, defaults to Read-Timeout, 5s with streaming listeners
+	Max_Header_Size         string   //largest request header block, such as 64KB, defaults to 1MB
+	Max_Concurrent_Streams  int      //concurrent requests per HTTP/2 connection, defaults to 250
+	Disable_HTTP2           bool     //TLS listeners only speak HTTP/1.x
 }
 
 type cfgReadType struct {
@@ -47,6 +68,7 @@ type cfgReadType struct {
 	Listener                         map[string]*lst
 	HEC_Compatible_Listener          map[string]*hecCompatible
 	Kinesis_Delivery_Stream_Listener map[string]*kds
+	API_Key                          map[string]*apiKeyCfg
 	Preprocessor                     processors.ProcessorConfig
 	TimeFormat                       config.CustomTimeFormat
 }
@@ -57,11 +79,35 @@ type lst struct {
 	Method                    string //method the listener expects
 	Tag_Name                  string //the tag to assign to the request
 	Multiline                 bool   //each request may have many entries
+	Stream_NDJSON             bool   //ingest newline delimited JSON records as they arrive, Max-Body limits each record
 	Ignore_Timestamps         bool   //Just apply the current timestamp to lines as we get them
 	Assume_Local_Timezone     bool
 	Timezone_Override         string
 	Timestamp_Format_Override string //override the timestamp format
 	Preprocessor              []string
+	Transform_Field           []string //reshape JSON bodies, name=expression
+	Accept_From               []string //CIDRs, IPs, or list files allowed to use this listener
+	Deny_From                 []string //CIDRs, IPs, or list files refused by this listener
+	Response_Code             int      //status code sent on success, defaults to 200
+	Response_Header           []string //headers sent on success, Name: value
+	Response_Body             string   //body sent on success, may contain ${...} substitutions
+	Webhook_Challenge         []string //verification handshakes to answer: slack, sns, msgraph
+	Multipart_Field           []string //form fields attached to entries from uploaded files
+	Multipart_File_Tag        []string //tag for files uploaded under a form part, part=tag
+	Tag_Source                string   //take the tag from the request, query:<name>, header:<name>, or path:<name>
+	Tag_Allow                 []string //tags, or glob patterns, a request may select with Tag-Source
+	CORS_Allow_Origin         []string //browser origins allowed to send, * for any
+	CORS_Allow_Header         []string //request headers browsers may send in addition to the defaults
+	CORS_Expose_Header        []string //response headers scripts may read
+	CORS_Allow_Credentials    bool     //allow browsers to send cookies and HTTP authentication
+	CORS_Max_Age              int      //seconds browsers may cache preflight responses
+	CloudEvents               bool     //requests use the CloudEvents HTTP binding, structured, batched, or binary
+	CloudEvents_Tag           []string //route events by attribute, type:<pattern>=tag or source:<pattern>=tag
+	CloudEvents_Attribute     []string //event attributes attached to entries, * for all
+	CloudEvents_Data_Only     bool     //store only the event data instead of the whole event
+	processors.ConnMetadataConfig
+	processors.SourceFieldConfig
+	processors.ChunkConfig
 }
 
 type cfgType struct {
@@ -69,6 +115,7 @@ type cfgType struct {
 	Listener     map[string]*lst
 	HECListener  map[string]*hecCompatible
 	KDSListener  map[string]*kds
+	APIKey       map[string]*apiKeyCfg
 	Preprocessor processors.ProcessorConfig
 	TimeFormat   config.CustomTimeFormat
 }
@@ -85,6 +132,7 @@ func GetConfig(path, overlayPath string) (*cfgType, error) {
 		Listener:     cr.Listener,
 		HECListener:  cr.HEC_Compatible_Listener,
 		KDSListener:  cr.Kinesis_Delivery_Stream_Listener,
+		APIKey:       cr.API_Key,
 		Preprocessor: cr.Preprocessor,
 		TimeFormat:   cr.TimeFormat,
 	}
@@ -113,9 +161,16 @@ func verifyConfig(c *cfgType) error {
 	}
 	if err := c.ValidateTLS(); err != nil {
 		return err
+	} else if _, err = utils.NewIPFilter(c.gbl.Accept_From, c.gbl.Deny_From, 0); err != nil {
+		return fmt.Errorf("Global address filter invalid: %v", err)
+	} else if err = c.validateBackpressure(); err != nil {
+		return err
+	} else if _, err = c.serverConfig(c.streaming()); err != nil {
+		return err
 	}
 	urls := map[route]string{}
-	if len(c.Listener) == 0 && len(c.HECListener) == 0 && len(c.KDSListener) == 0 {
+	_, dynamic := c.ListenerAdmin()
+	if len(c.Listener) == 0 && len(c.HECListener) == 0 && len(c.KDSListener) == 0 && !dynamic && c.Listener_Database == `` {
 		return errors.New("No Listeners specified")
 	}
 	if err := c.Preprocessor.Validate(); err != nil {
@@ -127,7 +182,7 @@ func verifyConfig(c *cfgType) error {
 		urls[newRoute(http.MethodGet, hc)] = `health check`
 	}
 	for k, v := range c.Listener {
-		pth, err := v.validate(k)
+		pth, err := c.checkListener(k, v)
 		if err != nil {
 			return err
 		}
@@ -135,23 +190,42 @@ func verifyConfig(c *cfgType) error {
 		if orig, ok := urls[rt]; ok {
 			return fmt.Errorf("%s %s duplicated in %s (was in %s)", v.Method, v.URL, k, orig)
 		}
-		//validate authentication
-		if enabled, err := v.auth.Validate(); err != nil {
-			return fmt.Errorf("Auth for %s is invalid: %v", k, err)
-		} else if enabled && v.LoginURL != `` {
+		if v.LoginURL != `` && v.AuthType != none && v.AuthType != _none {
 			//check the url
 			if orig, ok := urls[newRoute(http.MethodPost, v.LoginURL)]; ok {
 				return fmt.Errorf("%s %s duplicated in %s (was in %s)", v.Method, v.URL, k, orig)
 			}
 			urls[newRoute(http.MethodPost, v.LoginURL)] = k
 		}
-
-		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
-			return fmt.Errorf("HTTP Listener %s preprocessor invalid: %v", k, err)
-		}
 		urls[rt] = k
 		c.Listener[k] = v
 	}
+	if admin, ok := c.TokenAdmin(); ok {
+		if c.Token_Database == `` {
+			return errors.New("Token-Database is required when Token-Admin-URL is set")
+		} else if c.Token_Admin_Secret == `` {
+			return ErrTokenAdminSecret
+		}
+		for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+			rt := newRoute(m, admin)
+			if orig, ok := urls[rt]; ok {
+				return fmt.Errorf("Token-Admin-URL %s duplicated (was in %s)", admin, orig)
+			}
+			urls[rt] = `token admin`
+		}
+	}
+	if admin, ok := c.ListenerAdmin(); ok {
+		if c.Listener_Admin_Secret == `` {
+			return ErrListenerAdminSecret
+		}
+		for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+			rt := newRoute(m, admin)
+			if orig, ok := urls[rt]; ok {
+				return fmt.Errorf("Listener-Admin-URL %s duplicated (was in %s)", admin, orig)
+			}
+			urls[rt] = `listener admin`
+		}
+	}
 	for k, v := range c.HECListener {
 		pth, err := v.validate(k)
 		if err != nil {
@@ -184,7 +258,11 @@ func verifyConfig(c *cfgType) error {
 		c.KDSListener[k] = v
 	}
 
-	if len(urls) == 0 {
+	if err := checkAPIKeys(c.APIKey, c.Listener); err != nil {
+		return err
+	}
+
+	if len(urls) == 0 && c.Listener_Database == `` {
 		return fmt.Errorf("No listeners specified")
 	}
 	return nil
@@ -194,6 +272,24 @@ func verifyConfig(c *cfgType) error {
 func (c *cfgType) Tags() (tags []string, err error) {
 	tagMp := make(map[string]bool, 1)
 	for _, v := range c.Listener {
+		//uploaded files may be routed to their own tags
+		if mc, err := parseMultipartConfig(v.Multipart_Field, v.Multipart_File_Tag, v.Metadata_Mode, v.Metadata_Field); err == nil {
+			for _, tg := range mc.tagNames() {
+				if _, ok := tagMp[tg]; !ok {
+					tags = append(tags, tg)
+					tagMp[tg] = true
+				}
+			}
+		}
+		//CloudEvents may be routed by type or source
+		if cc, err := parseCloudEventsConfig(v.CloudEvents, v.CloudEvents_Tag, v.CloudEvents_Attribute, v.CloudEvents_Data_Only, v.Metadata_Mode, v.Metadata_Field); err == nil {
+			for _, tg := range cc.tagNames() {
+				if _, ok := tagMp[tg]; !ok {
+					tags = append(tags, tg)
+					tagMp[tg] = true
+				}
+			}
+		}
 		if len(v.Tag_Name) == 0 {
 			continue
 		}
@@ -222,7 +318,12 @@ func (c *cfgType) Tags() (tags []string, err error) {
 	}
 
 	if len(tags) == 0 {
-		err = errors.New("No tags specified")
+		if _, ok := c.ListenerAdmin(); ok || c.Listener_Database != `` {
+			//runtime listeners negotiate their tags as they are added
+			tags = []string{entry.DefaultTagName}
+		} else {
+			err = errors.New("No tags specified")
+		}
 	} else {
 		sort.Strings(tags)
 	}
@@ -244,7 +345,7 @@ func (g gbl) ValidateTLS() (err error) {
 	} else if g.TLS_Key_File == `` {
 		err = errors.New("TLS-Key-File argument is missing")
 	} else {
-		_, err = tls.LoadX509KeyPair(g.TLS_Certificate_File, g.TLS_Key_File)
+		_, err = netframe.NewCertReloader(g.TLS_Certificate_File, g.TLS_Key_File, g.TLS_Client_CA_File)
 	}
 	return
 }
@@ -263,6 +364,88 @@ func (g gbl) HealthCheck() (pth string, ok bool) {
 	return
 }
 
+func (g gbl) TokenAdmin() (pth string, ok bool) {
+	if g.Token_Admin_URL != `` {
+		if pth = path.Clean(g.Token_Admin_URL); pth != `.` {
+			ok = true
+		}
+	}
+	return
+}
+
+func (g gbl) ListenerAdmin() (pth string, ok bool) {
+	if g.Listener_Admin_URL != `` {
+		if pth = path.Clean(g.Listener_Admin_URL); pth != `.` {
+			ok = true
+		}
+	}
+	return
+}
+
+// ScopedTokenURLs returns the URLs of listeners using scoped-token authentication and their tags
+func (c *cfgType) ScopedTokenURLs() (r map[string]string) {
+	r = map[string]string{}
+	for _, v := range c.Listener {
+		if v.AuthType == scopedToken {
+			r[path.Clean(v.URL)] = v.Tag_Name
+		}
+	}
+	return
+}
+
+// checkListener validates a single listener definition against the global configuration
+func (c *cfgType) checkListener(k string, v *lst) (pth string, err error) {
+	if pth, err = v.validate(k); err != nil {
+		return
+	}
+	if _, err = v.auth.Validate(); err != nil {
+		err = fmt.Errorf("Auth for %s is invalid: %v", k, err)
+	} else if err = c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+		err = fmt.Errorf("HTTP Listener %s preprocessor invalid: %v", k, err)
+	} else if err = v.ConnMetadataConfig.Validate(); err != nil {
+		err = fmt.Errorf("HTTP Listener %s metadata invalid: %v", k, err)
+	} else if err = v.SourceFieldConfig.Validate(); err != nil {
+		err = fmt.Errorf("HTTP Listener %s source field invalid: %v", k, err)
+	} else if err = v.ChunkConfig.Validate(); err != nil {
+		err = fmt.Errorf("HTTP Listener %s entry size invalid: %v", k, err)
+	} else if _, err = newTransformer(v.Transform_Field); err != nil {
+		err = fmt.Errorf("HTTP Listener %s transform invalid: %v", k, err)
+	} else if _, err = utils.NewIPFilter(v.Accept_From, v.Deny_From, 0); err != nil {
+		err = fmt.Errorf("HTTP Listener %s address filter invalid: %v", k, err)
+	} else if _, err = newResponseTemplate(v.Response_Code, v.Response_Header, v.Response_Body, v.Tag_Name); err != nil {
+		err = fmt.Errorf("HTTP Listener %s response invalid: %v", k, err)
+	} else if _, err = newChallenges(v.Webhook_Challenge, nil); err != nil {
+		err = fmt.Errorf("HTTP Listener %s: %v", k, err)
+	} else if _, err = newCORS(v.CORS_Allow_Origin, v.CORS_Allow_Header, v.CORS_Expose_Header, v.CORS_Allow_Credentials, v.CORS_Max_Age); err != nil {
+		err = fmt.Errorf("HTTP Listener %s CORS invalid: %v", k, err)
+	} else if mc, lerr := parseMultipartConfig(v.Multipart_Field, v.Multipart_File_Tag, v.Metadata_Mode, v.Metadata_Field); lerr != nil {
+		err = fmt.Errorf("HTTP Listener %s multipart invalid: %v", k, lerr)
+	} else if cc, lerr := parseCloudEventsConfig(v.CloudEvents, v.CloudEvents_Tag, v.CloudEvents_Attribute, v.CloudEvents_Data_Only, v.Metadata_Mode, v.Metadata_Field); lerr != nil {
+		err = fmt.Errorf("HTTP Listener %s CloudEvents invalid: %v", k, lerr)
+	} else if cc != nil && mc != nil {
+		err = fmt.Errorf("HTTP Listener %s: %v", k, ErrCEWithMultipart)
+	} else if v.Stream_NDJSON && cc != nil {
+		err = fmt.Errorf("HTTP Listener %s: %v", k, ErrNDJSONWithCE)
+	} else if v.Stream_NDJSON && mc != nil {
+		err = fmt.Errorf("HTTP Listener %s: %v", k, ErrNDJSONWithMultipart)
+	} else if lerr = v.checkNDJSON(); lerr != nil {
+		err = fmt.Errorf("HTTP Listener %s: %v", k, lerr)
+	} else if ts, lerr := parseTagSelector(v.Tag_Source, v.Tag_Allow); lerr != nil {
+		err = fmt.Errorf("HTTP Listener %s: %v", k, lerr)
+	} else if up, lerr := parseURLPattern(v.URL); lerr != nil {
+		err = fmt.Errorf("HTTP Listener %s URL invalid: %v", k, lerr)
+	} else if lerr = ts.checkPattern(up); lerr != nil {
+		err = fmt.Errorf("HTTP Listener %s: %v", k, lerr)
+	} else if lerr = v.checkPathMetadata(up); lerr != nil {
+		err = fmt.Errorf("HTTP Listener %s metadata invalid: %v", k, lerr)
+	} else if ts != nil && v.AuthType == scopedToken {
+		err = fmt.Errorf("HTTP Listener %s: %v", k, ErrTagSourceScoped)
+	} else if v.AuthType == scopedToken && c.Token_Database == `` {
+		err = fmt.Errorf("Listener %s: %v", k, ErrTokenDBRequired)
+	}
+	return
+}
+
 func (v *lst) validate(name string) (string, error) {
 	if len(v.URL) == 0 {
 		return ``, errors.New("No URL provided for " + name)
@@ -290,3 +473,33 @@ func (v *lst) validate(name string) (string, error) {
 	}
 	return pth, nil
 }
+
+// timeGrinder builds the timegrinder used to extract timestamps on the listener
+func (v *lst) timeGrinder(ctf config.CustomTimeFormat) (tg *timegrinder.TimeGrinder, err error) {
+	tcfg := timegrinder.Config{
+		EnableLeftMostSeed: true,
+	}
+	if tg, err = timegrinder.NewTimeGrinder(tcfg); err != nil {
+		err = fmt.Errorf("failed to generate new timegrinder: %w", err)
+		return
+	} else if err = ctf.LoadFormats(tg); err != nil {
+		err = fmt.Errorf("failed to load custom time formats: %w", err)
+		return
+	}
+	if v.Timestamp_Format_Override != `` {
+		if err = tg.SetFormatOverride(v.Timestamp_Format_Override); err != nil {
+			err = fmt.Errorf("failed to set override timestamp: %w", err)
+			return
+		}
+	}
+	if v.Assume_Local_Timezone {
+		tg.SetLocalTime()
+	}
+	if v.Timezone_Override != `` {
+		if err = tg.SetTimezone(v.Timezone_Override); err != nil {
+			err = fmt.Errorf("failed to override timezone: %w", err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,183 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrCORSWithoutOrigin = errors.New("CORS settings require at least one CORS-Allow-Origin")
+	ErrCORSBadOrigin     = errors.New("CORS-Allow-Origin must be *, or a scheme://host[:port] origin which may contain * wildcards")
+	ErrCORSBadHeader     = errors.New("CORS-Allow-Header and CORS-Expose-Header entries must be header names")
+	ErrCORSBadMaxAge     = errors.New("CORS-Max-Age cannot be negative")
+	ErrCORSWildcardCreds = errors.New("CORS-Allow-Credentials cannot be used with CORS-Allow-Origin=*")
+)
+
+// headers browsers are always allowed to send to a listener, in addition to any configured
+var corsDefaultHeaders = []string{`Content-Type`, `Content-Encoding`, `Authorization`}
+
+// corsConfig lets browser based clients send to a listener.  Preflight requests are answered
+// before authentication as browsers never send credentials with them, responses to the actual
+// request carry the allow headers only if the Origin is permitted.  CORS is not access control,
+// a request from a disallowed origin is still processed if it authenticates.
+type corsConfig struct {
+	anyOrigin   bool
+	origins     []string // exact origins or path.Match patterns
+	allowHdrs   string
+	exposeHdrs  string
+	credentials bool
+	maxAge      string
+}
+
+// newCORS returns nil if no origins are allowed
+func newCORS(origins, headers, expose []string, credentials bool, maxAge int) (c *corsConfig, err error) {
+	if len(origins) == 0 {
+		if len(headers) > 0 || len(expose) > 0 || credentials || maxAge != 0 {
+			err = ErrCORSWithoutOrigin
+		}
+		return
+	}
+	c = &corsConfig{
+		credentials: credentials,
+	}
+	for _, o := range origins {
+		if o = strings.TrimSpace(o); o == `*` {
+			c.anyOrigin = true
+			continue
+		} else if err = checkOrigin(o); err != nil {
+			return nil, err
+		}
+		c.origins = append(c.origins, strings.ToLower(o))
+	}
+	if c.anyOrigin && credentials {
+		return nil, ErrCORSWildcardCreds
+	}
+	allow := append([]string{}, corsDefaultHeaders...)
+	if allow, err = appendHeaderNames(allow, headers); err != nil {
+		return nil, err
+	}
+	c.allowHdrs = strings.Join(allow, `, `)
+	var exp []string
+	if exp, err = appendHeaderNames(nil, expose); err != nil {
+		return nil, err
+	}
+	c.exposeHdrs = strings.Join(exp, `, `)
+	if maxAge < 0 {
+		return nil, ErrCORSBadMaxAge
+	} else if maxAge > 0 {
+		c.maxAge = strconv.Itoa(maxAge)
+	}
+	return
+}
+
+func checkOrigin(o string) error {
+	idx := strings.Index(o, `://`)
+	if idx <= 0 || idx+3 == len(o) || strings.ContainsAny(o[idx+3:], `/?#`) {
+		return fmt.Errorf("%w: %q", ErrCORSBadOrigin, o)
+	} else if _, err := path.Match(o, ``); err != nil {
+		return fmt.Errorf("%w: %q", ErrCORSBadOrigin, o)
+	}
+	return nil
+}
+
+func appendHeaderNames(set []string, hdrs []string) ([]string, error) {
+	for _, h := range hdrs {
+		if h = strings.TrimSpace(h); h == `` || strings.ContainsAny(h, " \t,:") {
+			return nil, fmt.Errorf("%w: %q", ErrCORSBadHeader, h)
+		}
+		h = http.CanonicalHeaderKey(h)
+		var dup bool
+		for _, v := range set {
+			if v == h {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			set = append(set, h)
+		}
+	}
+	return set, nil
+}
+
+func (c *corsConfig) allowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, o := range c.origins {
+		if ok, _ := path.Match(o, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// setOrigin adds the allow origin headers if the request origin is permitted
+func (c *corsConfig) setOrigin(w http.ResponseWriter, origin string) bool {
+	if !c.allowed(origin) {
+		return false
+	}
+	hdr := w.Header()
+	if c.anyOrigin {
+		hdr.Set(`Access-Control-Allow-Origin`, `*`)
+	} else {
+		hdr.Set(`Access-Control-Allow-Origin`, origin)
+		hdr.Add(`Vary`, `Origin`)
+	}
+	if c.credentials {
+		hdr.Set(`Access-Control-Allow-Credentials`, `true`)
+	}
+	return true
+}
+
+// isPreflight returns the method a CORS preflight request asks about
+func isPreflight(r *http.Request) (method string, ok bool) {
+	if r.Method != http.MethodOptions || r.Header.Get(`Origin`) == `` {
+		return
+	}
+	method = r.Header.Get(`Access-Control-Request-Method`)
+	ok = method != ``
+	return
+}
+
+// preflight answers a CORS preflight request for a listener
+func (c *corsConfig) preflight(w http.ResponseWriter, r *http.Request, method string) {
+	if c == nil || !c.setOrigin(w, r.Header.Get(`Origin`)) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	hdr := w.Header()
+	hdr.Set(`Access-Control-Allow-Methods`, method)
+	hdr.Set(`Access-Control-Allow-Headers`, c.allowHdrs)
+	if c.maxAge != `` {
+		hdr.Set(`Access-Control-Max-Age`, c.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// response adds the CORS headers to the response for an actual cross origin request
+func (c *corsConfig) response(w http.ResponseWriter, r *http.Request) {
+	if c == nil {
+		return
+	}
+	origin := r.Header.Get(`Origin`)
+	if origin == `` || !c.setOrigin(w, origin) {
+		return
+	}
+	if c.exposeHdrs != `` {
+		w.Header().Set(`Access-Control-Expose-Headers`, c.exposeHdrs)
+	}
+}
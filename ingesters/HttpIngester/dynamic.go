@@ -0,0 +1,333 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/renameio"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	listenerNameParam  = `name`
+	maxListenerRequest = 256 * 1024
+)
+
+var (
+	ErrListenerAdminSecret  = errors.New("Listener-Admin-Secret is required when Listener-Admin-URL is set")
+	ErrListenerNotFound     = errors.New("Listener not found")
+	ErrListenerNameRequired = errors.New("Listener name is required")
+	ErrStaticListener       = errors.New("Listeners defined in the configuration file cannot be modified at runtime")
+)
+
+// listenerRecord is a runtime listener definition as sent to the admin API and stored in the
+// listener database.  Secrets are not marshalled as part of the listener so they are carried
+// alongside it, they are never handed back by the API.
+type listenerRecord struct {
+	Name       string
+	Listener   lst
+	Password   string `json:",omitempty"`
+	TokenValue string `json:",omitempty"`
+}
+
+type listenerDBFile struct {
+	Listeners []listenerRecord
+}
+
+type dynamicListener struct {
+	rec   listenerRecord
+	rt    route
+	login route
+	rh    routeHandler
+}
+
+// dynamicListeners manages listeners that are added, updated, and removed while the ingester is running
+type dynamicListeners struct {
+	sync.Mutex
+	hnd    *handler
+	lb     *listenerBuilder
+	path   string // optional, runtime listeners are not persisted if empty
+	static map[string]bool
+	active map[string]*dynamicListener
+	lgr    *log.Logger
+}
+
+func newDynamicListeners(hnd *handler, lb *listenerBuilder, p string, lgr *log.Logger) (dl *dynamicListeners) {
+	dl = &dynamicListeners{
+		hnd:    hnd,
+		lb:     lb,
+		path:   p,
+		static: map[string]bool{},
+		active: map[string]*dynamicListener{},
+		lgr:    lgr,
+	}
+	for k := range lb.cfg.Listener {
+		dl.static[k] = true
+	}
+	return
+}
+
+// load installs every listener in the listener database, bad definitions are logged and skipped
+func (dl *dynamicListeners) load() (err error) {
+	if dl.path == `` {
+		return
+	}
+	var bb []byte
+	if bb, err = os.ReadFile(dl.path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var lf listenerDBFile
+	if err = json.Unmarshal(bb, &lf); err != nil {
+		err = fmt.Errorf("Failed to decode listener database %s: %v", dl.path, err)
+		return
+	}
+	dl.Lock()
+	defer dl.Unlock()
+	for _, rec := range lf.Listeners {
+		if _, lerr := dl.installNoLock(rec); lerr != nil {
+			dl.lgr.Error("failed to load listener", log.KV("listener", rec.Name), log.KVErr(lerr))
+		}
+	}
+	return
+}
+
+// upsert adds a new listener or replaces an existing one
+func (dl *dynamicListeners) upsert(rec listenerRecord) (created bool, err error) {
+	dl.Lock()
+	defer dl.Unlock()
+	var old *dynamicListener
+	if old, err = dl.installNoLock(rec); err != nil {
+		return
+	}
+	if err = dl.saveNoLock(); err != nil {
+		//put things back the way they were
+		dl.uninstallNoLock(dl.active[rec.Name], old)
+		return
+	}
+	created = old == nil
+	return
+}
+
+// remove takes a listener out of service
+func (dl *dynamicListeners) remove(name string) (err error) {
+	dl.Lock()
+	defer dl.Unlock()
+	old, ok := dl.active[name]
+	if !ok {
+		return ErrListenerNotFound
+	}
+	var none route
+	if err = dl.hnd.swapRoutes(old.rt, old.login, none, none, routeHandler{}); err != nil {
+		return
+	}
+	delete(dl.active, name)
+	if err = dl.saveNoLock(); err != nil {
+		dl.hnd.swapRoutes(none, none, old.rt, old.login, old.rh)
+		dl.active[name] = old
+		return
+	}
+	dl.unscope(old)
+	dl.retire(old)
+	return
+}
+
+// list returns the runtime listeners sorted by name without secrets
+func (dl *dynamicListeners) list() (r []listenerRecord) {
+	dl.Lock()
+	for _, v := range dl.active {
+		rec := v.rec
+		rec.Password = ``
+		rec.TokenValue = ``
+		r = append(r, rec)
+	}
+	dl.Unlock()
+	sort.Slice(r, func(i, j int) bool { return r[i].Name < r[j].Name })
+	return
+}
+
+// installNoLock builds a listener and swaps it in, the replaced listener (if any) is returned.  Caller must hold the lock.
+func (dl *dynamicListeners) installNoLock(rec listenerRecord) (old *dynamicListener, err error) {
+	if rec.Name = strings.TrimSpace(rec.Name); rec.Name == `` {
+		err = ErrListenerNameRequired
+		return
+	} else if dl.static[rec.Name] {
+		err = ErrStaticListener
+		return
+	}
+	v := rec.Listener
+	v.Password = rec.Password
+	v.TokenValue = rec.TokenValue
+	if _, err = dl.lb.cfg.checkListener(rec.Name, &v); err != nil {
+		return
+	}
+	rec.Listener = v
+	nl := &dynamicListener{
+		rec: rec,
+		rt:  newRoute(v.Method, v.URL),
+	}
+	var loginURL string
+	if nl.rh, loginURL, err = dl.lb.build(rec.Name, &v); err != nil {
+		return
+	}
+	if loginURL != `` {
+		nl.login = newRoute(http.MethodPost, loginURL)
+	}
+	nl.rh.inflight = &sync.WaitGroup{}
+
+	var oldRt, oldLogin route
+	if old = dl.active[rec.Name]; old != nil {
+		oldRt, oldLogin = old.rt, old.login
+	}
+	if err = dl.hnd.swapRoutes(oldRt, oldLogin, nl.rt, nl.login, nl.rh); err != nil {
+		nl.rh.pproc.Close()
+		return
+	}
+	dl.active[rec.Name] = nl
+	if old != nil {
+		dl.unscope(old)
+		dl.retire(old)
+	}
+	dl.scope(nl)
+	return
+}
+
+// uninstallNoLock reverses an install that could not be persisted.  Caller must hold the lock.
+func (dl *dynamicListeners) uninstallNoLock(nl, old *dynamicListener) {
+	var none route
+	dl.hnd.swapRoutes(nl.rt, nl.login, none, none, routeHandler{})
+	delete(dl.active, nl.rec.Name)
+	dl.unscope(nl)
+	dl.retire(nl)
+	if old != nil {
+		//the old listener was retired when it was replaced, so rebuild it
+		if _, err := dl.installNoLock(old.rec); err != nil {
+			dl.lgr.Error("failed to restore listener", log.KV("listener", old.rec.Name), log.KVErr(err))
+		}
+	}
+}
+
+func (dl *dynamicListeners) scope(v *dynamicListener) {
+	if v.rec.Listener.AuthType == scopedToken && dl.lb.tdb != nil {
+		dl.lb.tdb.addScope(v.rec.Listener.URL, v.rec.Listener.Tag_Name)
+	}
+}
+
+func (dl *dynamicListeners) unscope(v *dynamicListener) {
+	if v.rec.Listener.AuthType == scopedToken && dl.lb.tdb != nil {
+		dl.lb.tdb.removeScope(v.rec.Listener.URL)
+	}
+}
+
+// retire closes the preprocessors of a replaced listener once outstanding requests are done with them
+func (dl *dynamicListeners) retire(v *dynamicListener) {
+	go func(rh routeHandler, name string) {
+		rh.inflight.Wait()
+		if err := rh.pproc.Close(); err != nil {
+			dl.lgr.Error("failed to close preprocessors for listener", log.KV("listener", name), log.KVErr(err))
+		}
+	}(v.rh, v.rec.Name)
+}
+
+// saveNoLock writes the listener database if one is configured.  Caller must hold the lock.
+func (dl *dynamicListeners) saveNoLock() (err error) {
+	if dl.path == `` {
+		return
+	}
+	var lf listenerDBFile
+	for _, v := range dl.active {
+		lf.Listeners = append(lf.Listeners, v.rec)
+	}
+	sort.Slice(lf.Listeners, func(i, j int) bool { return lf.Listeners[i].Name < lf.Listeners[j].Name })
+	var bb []byte
+	if bb, err = json.MarshalIndent(lf, "", "\t"); err != nil {
+		return
+	}
+	err = renameio.WriteFile(dl.path, bb, 0600)
+	return
+}
+
+// listenerAdminHandler implements the runtime listener API
+//
+//	GET lists runtime listeners
+//	POST adds or replaces a runtime listener
+//	DELETE removes a runtime listener by name
+type listenerAdminHandler struct {
+	dl     *dynamicListeners
+	secret string
+	lgr    *log.Logger
+}
+
+func (lah *listenerAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tok, err := getAuthToken(r, defaultTokenName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(tok), []byte(lah.secret)) != 1 {
+		lah.lgr.Info("listener admin access denied", log.KV("address", getRemoteIP(r)))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		lah.writeJSON(w, lah.dl.list())
+	case http.MethodPost:
+		var rec listenerRecord
+		if err = json.NewDecoder(io.LimitReader(r.Body, maxListenerRequest)).Decode(&rec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		created, err := lah.dl.upsert(rec)
+		if err == ErrStaticListener {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			lah.lgr.Info("listener update failed", log.KV("address", getRemoteIP(r)), log.KV("listener", rec.Name), log.KVErr(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rec.Password = ``
+		rec.TokenValue = ``
+		if created {
+			lah.lgr.Info("listener added", log.KV("address", getRemoteIP(r)), log.KV("listener", rec.Name), log.KV("url", rec.Listener.URL))
+			w.WriteHeader(http.StatusCreated)
+		} else {
+			lah.lgr.Info("listener updated", log.KV("address", getRemoteIP(r)), log.KV("listener", rec.Name), log.KV("url", rec.Listener.URL))
+		}
+		lah.writeJSON(w, rec)
+	case http.MethodDelete:
+		name := r.URL.Query().Get(listenerNameParam)
+		if err = lah.dl.remove(name); err == ErrListenerNotFound {
+			w.WriteHeader(http.StatusNotFound)
+		} else if err != nil {
+			lah.lgr.Error("listener removal failed", log.KV("listener", name), log.KVErr(err))
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			lah.lgr.Info("listener removed", log.KV("address", getRemoteIP(r)), log.KV("listener", name))
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (lah *listenerAdminHandler) writeJSON(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		lah.lgr.Warn("failed to write listener response", log.KVErr(err))
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"bufio"
 	"compress/gzip"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
@@ -24,17 +25,44 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
 type handleFunc func(*handler, routeHandler, http.ResponseWriter, io.Reader, net.IP)
 type routeHandler struct {
-	ignoreTs bool
-	tag      entry.EntryTag
-	tg       *timegrinder.TimeGrinder
-	handler  handleFunc
-	auth     authHandler
-	pproc    *processors.ProcessorSet
+	ignoreTs  bool
+	tag       entry.EntryTag
+	tg        *timegrinder.TimeGrinder
+	handler   handleFunc
+	auth      authHandler
+	pproc     *processors.ProcessorSet
+	name      string
+	meta      *processors.MetadataAttacher
+	anns      []processors.Annotation     // per request connection metadata
+	xform     *transformer                // optional reshaping of JSON bodies
+	srcField  *processors.SourceExtractor // optional payload field holding the entry source
+	ipf       *utils.IPFilter             // optional peer address filter
+	resp      *responseTemplate           // optional custom success response
+	chal      *challenges                 // webhook verification handshakes
+	lines     bool                        // request bodies hold an entry per line
+	mpart     *multipartConfig            // multipart/form-data upload handling
+	tsel      *tagSelector                // optional per request tag selection
+	pattern   *urlPattern                 // set if the listener URL captures path parameters
+	params    map[string]string           // per request captured path parameters
+	cors      *corsConfig                 // optional browser cross origin support
+	boundary  string                      // per request multipart boundary
+	fields    []processors.Annotation     // per request multipart form fields or Firehose common attributes
+	kds       *kdsConfig                  // Kinesis-Delivery-Stream options
+	ce        *cloudEventsConfig          // CloudEvents HTTP binding
+	ceReq     ceRequest                   // per request CloudEvents content mode
+	evTime    time.Time                   // per event timestamp taken from the event itself
+	fieldAnn  processors.Annotator        // attaches fields
+	requestId string                      // per request Firehose request ID
+	inflight  *sync.WaitGroup             // requests in flight, used to retire dynamic listeners
+	trace     *tracing.Span               // per request span, nil unless the request is sampled
+	chunker   *processors.Chunker         // optional entry size policy
 }
 
 type handler struct {
@@ -45,6 +73,7 @@ type handler struct {
 	auth           map[route]authHandler
 	custom         map[route]http.Handler
 	healthCheckURL string
+	bp             *backpressure // nil if no backpressure mode is set
 }
 
 func (rh routeHandler) handle(h *handler, w http.ResponseWriter, r io.Reader, ip net.IP) {
@@ -54,6 +83,10 @@ func (rh routeHandler) handle(h *handler, w http.ResponseWriter, r io.Reader, ip
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if rh.boundary != `` {
+		rh.mpart.handle(h, rh, w, r, rh.boundary, ip)
+		return
+	}
 	rh.handler(h, rh, w, r, ip)
 }
 
@@ -78,6 +111,11 @@ func newHandler(igst *ingest.IngestMuxer, lgr *log.Logger) (h *handler, err erro
 func (h *handler) checkConflict(r route) error {
 	h.RLock()
 	defer h.RUnlock()
+	return h.checkConflictNoLock(r)
+}
+
+// checkConflictNoLock checks a route against every registered route.  Caller must hold the lock.
+func (h *handler) checkConflictNoLock(r route) error {
 	//check heathcheck
 	if r.method == http.MethodGet && h.healthCheckURL == r.uri {
 		return errors.New("route conflicts with health check URL")
@@ -131,6 +169,45 @@ func (h *handler) addCustomHandler(method, pth string, ah http.Handler) (err err
 	return
 }
 
+// swapRoutes atomically removes an ingest route and its login route and installs new ones.
+// A zero route is skipped, if the new routes conflict the old routes are left in place.
+func (h *handler) swapRoutes(oldRt, oldLogin, newRt, newLogin route, rh routeHandler) (err error) {
+	var none route
+	h.Lock()
+	defer h.Unlock()
+	oldRh, hadRh := h.mp[oldRt]
+	oldAh, hadAh := h.auth[oldLogin]
+	if oldRt != none {
+		delete(h.mp, oldRt)
+	}
+	if oldLogin != none {
+		delete(h.auth, oldLogin)
+	}
+	if newRt != none {
+		if err = h.checkConflictNoLock(newRt); err == nil && newLogin != none {
+			if newLogin == newRt {
+				err = errors.New("login URL conflicts with ingest URL")
+			} else {
+				err = h.checkConflictNoLock(newLogin)
+			}
+		}
+		if err != nil {
+			if hadRh {
+				h.mp[oldRt] = oldRh
+			}
+			if hadAh {
+				h.auth[oldLogin] = oldAh
+			}
+			return
+		}
+		h.mp[newRt] = rh
+		if newLogin != none {
+			h.auth[newLogin] = rh.auth
+		}
+	}
+	return
+}
+
 func (h *handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	w := &trackingRW{
 		ResponseWriter: rw,
@@ -148,13 +225,6 @@ func (h *handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		debugout("ROUTES: %+v %+v %+v\n", h.mp, h.auth, h.custom)
 	}(w, r)
 	ip := getRemoteIP(r)
-	rdr, err := getReadableBody(r)
-	if err != nil {
-		h.lgr.Error("failed to get body reader", log.KV("address", ip), log.KVErr(err))
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	defer rdr.Close()
 	rt := route{
 		method: r.Method,
 		uri:    path.Clean(r.URL.Path),
@@ -186,8 +256,32 @@ func (h *handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//not an auth, try the actual post URL
-	rh, ok := h.mp[rt]
+	// turn ingest requests away while the ingest queue is saturated
+	if h.bp.reject(w) {
+		h.RUnlock()
+		return
+	}
+
+	//CORS preflights are looked up by the method the browser intends to use, webhook
+	//validation probes by the default method as that is what events will be sent with
+	pfMethod, preflight := isPreflight(r)
+	probe := isWebhookProbe(r)
+	if preflight || probe {
+		if _, ok := h.mp[rt]; ok {
+			preflight, probe = false, false
+		} else if preflight {
+			rt.method = pfMethod
+		} else {
+			rt.method = defaultMethod
+		}
+	}
+
+	//not an auth, try the actual post URL and then any parameterized URLs
+	rh, ok := h.lookupNoLock(rt)
+	if ok && rh.inflight != nil {
+		rh.inflight.Add(1)
+		defer rh.inflight.Done()
+	}
 	h.RUnlock()
 	if !ok {
 		h.lgr.Info("bad request URL", log.KV("url", rt.uri), log.KV("method", r.Method))
@@ -198,19 +292,158 @@ func (h *handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	// filter on the connection peer, X-Forwarded-For is trivially forged
+	if peer := getPeerIP(r); !rh.ipf.Allowed(peer) {
+		h.lgr.Info("address denied", log.KV("address", peer), log.KV("url", rt.uri))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if preflight {
+		rh.cors.preflight(w, r, pfMethod)
+		return
+	}
+	rh.cors.response(w, r)
+	if rh.chal.preAuth(w, r) {
+		return
+	} else if probe {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var key *apiKey
 	if rh.auth != nil {
-		if err := rh.auth.AuthRequest(r); err != nil {
+		var err error
+		if akh, ok := rh.auth.(*apiKeyHandler); ok {
+			key, err = akh.authKey(r)
+		} else {
+			err = rh.auth.AuthRequest(r)
+		}
+		if err != nil {
 			h.lgr.Info("access denied", log.KV("address", getRemoteIP(r)), log.KV("url", rt.uri), log.KVErr(err))
-			w.WriteHeader(http.StatusUnauthorized)
+			if err == ErrTokenRateLimited {
+				w.WriteHeader(http.StatusTooManyRequests)
+			} else {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+			return
+		}
+	}
+	if key != nil {
+		if key.maxBody > 0 && r.ContentLength > key.maxBody {
+			h.lgr.Info("request exceeds API key size limit", log.KV("address", ip), log.KV("key", key.name), log.KV("size", r.ContentLength))
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
 			return
 		}
+		if key.tagged {
+			rh.tag = key.tag
+		}
+		if key.src != nil {
+			ip = key.src
+		}
+	}
+	//the body is opened after authentication, signature based auth may need to read it first
+	rdr, err := getReadableBody(r)
+	if err != nil {
+		h.lgr.Error("failed to get body reader", log.KV("address", ip), log.KVErr(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer rdr.Close()
+	var body io.Reader = rdr
+	if key != nil && key.maxBody > 0 {
+		//catches chunked and compressed bodies which the Content-Length check cannot
+		body = &quotaReader{r: rdr, rem: key.maxBody}
+	}
+	handled, body := rh.chal.postAuth(w, r, body)
+	if handled {
+		return
+	}
+	if tg, ok, err := rh.tsel.tag(r, rh.params); err != nil {
+		h.lgr.Info("bad tag selection", log.KV("address", ip), log.KV("url", rt.uri), log.KVErr(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if ok {
+		rh.tag = tg
+	}
+	if rh.meta != nil {
+		md := processors.ConnMetadataFromRequest(rh.name, r)
+		md.PathParams = rh.params
+		rh.anns = rh.meta.Annotations(md)
+	}
+	if rh.mpart != nil {
+		rh.boundary, _ = isMultipart(r)
+	}
+	if rh.ce != nil {
+		rh.ceReq = rh.ce.request(r)
+	}
+	if rh.kds != nil {
+		rh.requestId = r.Header.Get(kdsRequestIdHeader)
+		if rh.kds.attributes {
+			if rh.fields, err = commonAttributes(r); err != nil {
+				h.lgr.Info("bad Firehose common attributes", log.KV("address", ip), log.KVErr(err))
+				sendKDSError(w, http.StatusBadRequest, rh.requestId, err)
+				return
+			}
+		}
+	}
+	rh.startTrace(r, ip)
+	defer rh.endTrace(w)
+	if rh.resp == nil {
+		rh.handle(h, w, body, ip)
+	} else {
+		var captured *cappedBuffer
+		if rh.resp.needBody {
+			captured = &cappedBuffer{max: maxBody}
+			body = io.TeeReader(body, captured)
+		}
+		rr := &responseRecorder{ResponseWriter: w}
+		rh.handle(h, rr, body, ip)
+		if !rr.wrote {
+			//the handler only responds on its own when something went wrong
+			rc := responseContext{r: r, params: rh.params}
+			if captured != nil {
+				rc.body = captured.Bytes()
+			}
+			rh.resp.write(w, rc)
+		}
 	}
-	rh.handle(h, w, rdr, ip)
 	r.Body.Close()
 }
+
+// lookupNoLock finds the ingest route for a request, static URLs are preferred over parameterized
+// URLs.  Caller must hold the lock.
+func (h *handler) lookupNoLock(rt route) (rh routeHandler, ok bool) {
+	if rh, ok = h.mp[rt]; ok && rh.pattern == nil {
+		return
+	}
+	return h.matchPatternNoLock(rt)
+}
+
+// matchPatternNoLock finds the parameterized route matching a request.  If several patterns match,
+// the one with the most literal segments wins.  Caller must hold the lock.
+func (h *handler) matchPatternNoLock(rt route) (rh routeHandler, ok bool) {
+	var best string
+	for k, v := range h.mp {
+		if v.pattern == nil || k.method != rt.method {
+			continue
+		}
+		params, match := v.pattern.match(rt.uri)
+		if !match {
+			continue
+		}
+		if ok && (v.pattern.literals < rh.pattern.literals || (v.pattern.literals == rh.pattern.literals && k.uri > best)) {
+			continue
+		}
+		rh, best, ok = v, k.uri, true
+		rh.params = params
+	}
+	return
+}
+
 func (h *handler) handleEntry(cfg routeHandler, b []byte, ip net.IP) (err error) {
 	var ts entry.Timestamp
-	if cfg.ignoreTs || cfg.tg == nil {
+	if !cfg.evTime.IsZero() {
+		ts = entry.FromStandard(cfg.evTime)
+	} else if cfg.ignoreTs || cfg.tg == nil {
 		ts = entry.Now()
 	} else {
 		var hts time.Time
@@ -224,14 +457,28 @@ func (h *handler) handleEntry(cfg routeHandler, b []byte, ip net.IP) (err error)
 			ts = entry.FromStandard(hts)
 		}
 	}
+	//the source field refers to the body as sent, so pull it before any transform
+	ip = cfg.srcField.Source(b, ip)
+	if b, err = cfg.xform.apply(b); err != nil {
+		h.lgr.Warn("failed to transform entry", log.KVErr(err))
+		return
+	}
 	e := entry.Entry{
 		TS:   ts,
 		SRC:  ip,
 		Tag:  cfg.tag,
 		Data: b,
 	}
+	if err = cfg.meta.Attach(&e, cfg.anns); err != nil {
+		h.lgr.Warn("failed to attach connection metadata", log.KVErr(err))
+	}
+	if len(cfg.fields) > 0 {
+		if _, err = cfg.fieldAnn.Annotate(&e, cfg.fields...); err != nil {
+			h.lgr.Warn("failed to attach fields", log.KVErr(err))
+		}
+	}
 	debugout("Handling: %+v\n", e)
-	if err = cfg.pproc.Process(&e); err != nil {
+	if err = cfg.process(&e); err != nil {
 		h.lgr.Error("failed to send entry", log.KVErr(err))
 		return
 	}
@@ -282,7 +529,7 @@ func newRoute(method, uri string) route {
 	if method == `` {
 		method = defaultMethod
 	}
-	uri = path.Clean(uri)
+	uri = normalizeURLPattern(path.Clean(uri))
 	return route{
 		method: method,
 		uri:    uri,
@@ -302,7 +549,7 @@ func handleMulti(h *handler, cfg routeHandler, w http.ResponseWriter, rdr io.Rea
 	for scanner.Scan() {
 		if err := h.handleEntry(cfg, scanner.Bytes(), ip); err != nil {
 			h.lgr.Error("failed to handle entry", log.KV("address", ip), log.KVErr(err))
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(entryErrorCode(err))
 			return
 		}
 	}
@@ -329,6 +576,146 @@ func handleSingle(h *handler, cfg routeHandler, w http.ResponseWriter, rdr io.Re
 		w.WriteHeader(http.StatusBadRequest)
 	} else if err = h.handleEntry(cfg, b, ip); err != nil {
 		h.lgr.Error("failed to handle entry", log.KV("address", ip), log.KVErr(err))
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(entryErrorCode(err))
+	}
+}
+
+// entryErrorCode is the response status for an entry that could not be handled, entries
+// refused by the entry size policy get a 413 so clients know not to retry them
+func entryErrorCode(err error) int {
+	if errors.Is(err, processors.ErrEntryTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusInternalServerError
+}
+
+// listenerBuilder turns listener definitions into route handlers for both configured and dynamic listeners
+type listenerBuilder struct {
+	igst *ingest.IngestMuxer
+	cfg  *cfgType
+	tdb  *tokenDB
+	keys *apiKeys
+	lgr  *log.Logger
+}
+
+// build negotiates the listener tag and constructs the timegrinder, preprocessors, and authentication.
+// If the authentication type requires a login URL it is returned so the caller can register it.
+func (lb *listenerBuilder) build(name string, v *lst) (rh routeHandler, loginURL string, err error) {
+	rh = routeHandler{
+		handler: handleSingle,
+		name:    name,
+	}
+	if rh.meta, err = v.NewMetadataAttacher(); err != nil {
+		err = fmt.Errorf("failed to build connection metadata: %w", err)
+		return
+	}
+	if rh.srcField, err = v.NewSourceExtractor(); err != nil {
+		err = fmt.Errorf("failed to build source field: %w", err)
+		return
+	}
+	if rh.chunker, err = v.NewChunker(); err != nil {
+		err = fmt.Errorf("failed to build entry size policy: %w", err)
+		return
+	}
+	if v.Multiline {
+		rh.handler = handleMulti
+		rh.lines = true
+	}
+	if v.Stream_NDJSON {
+		if err = v.checkNDJSON(); err != nil {
+			return
+		}
+		rh.handler = handleNDJSON
+	}
+	if rh.xform, err = newTransformer(v.Transform_Field); err != nil {
+		err = fmt.Errorf("failed to build transform: %w", err)
+		return
+	}
+	if rh.ipf, err = newIPFilter(name, v.Accept_From, v.Deny_From, lb.lgr); err != nil {
+		err = fmt.Errorf("failed to build address filter: %w", err)
+		return
+	}
+	if rh.resp, err = newResponseTemplate(v.Response_Code, v.Response_Header, v.Response_Body, v.Tag_Name); err != nil {
+		err = fmt.Errorf("failed to build response: %w", err)
+		return
+	}
+	if rh.chal, err = newChallenges(v.Webhook_Challenge, lb.lgr); err != nil {
+		return
 	}
+	if rh.cors, err = newCORS(v.CORS_Allow_Origin, v.CORS_Allow_Header, v.CORS_Expose_Header, v.CORS_Allow_Credentials, v.CORS_Max_Age); err != nil {
+		return
+	}
+	if rh.pattern, err = parseURLPattern(v.URL); err != nil {
+		return
+	}
+	if rh.tsel, err = parseTagSelector(v.Tag_Source, v.Tag_Allow); err != nil {
+		return
+	} else if err = rh.tsel.checkPattern(rh.pattern); err != nil {
+		return
+	} else if rh.tsel != nil {
+		if v.AuthType == scopedToken {
+			err = ErrTagSourceScoped
+			return
+		}
+		rh.tsel.igst = lb.igst
+	}
+	if rh.mpart, err = parseMultipartConfig(v.Multipart_Field, v.Multipart_File_Tag, v.Metadata_Mode, v.Metadata_Field); err != nil {
+		return
+	} else if rh.mpart != nil {
+		if v.Stream_NDJSON {
+			err = ErrNDJSONWithMultipart
+			return
+		} else if err = rh.mpart.resolveTags(lb.igst); err != nil {
+			return
+		}
+		rh.fieldAnn = rh.mpart.ann
+	}
+	if rh.ce, err = parseCloudEventsConfig(v.CloudEvents, v.CloudEvents_Tag, v.CloudEvents_Attribute, v.CloudEvents_Data_Only, v.Metadata_Mode, v.Metadata_Field); err != nil {
+		return
+	} else if rh.ce != nil {
+		if rh.mpart != nil {
+			err = ErrCEWithMultipart
+			return
+		} else if err = rh.ce.resolveTags(lb.igst); err != nil {
+			return
+		}
+		if v.Stream_NDJSON {
+			err = ErrNDJSONWithCE
+			return
+		}
+		rh.handler = handleCloudEvents
+	}
+	if rh.tag, err = lb.igst.NegotiateTag(v.Tag_Name); err != nil {
+		err = fmt.Errorf("failed to negotiate tag %s: %w", v.Tag_Name, err)
+		return
+	}
+	if v.Ignore_Timestamps {
+		rh.ignoreTs = true
+	} else {
+		if rh.tg, err = v.timeGrinder(lb.cfg.TimeFormat); err != nil {
+			return
+		}
+	}
+	if v.Method == `` {
+		v.Method = defaultMethod
+	}
+
+	if rh.pproc, err = lb.cfg.Preprocessor.ProcessorSet(lb.igst, v.Preprocessor); err != nil {
+		err = fmt.Errorf("preprocessor construction error: %w", err)
+		return
+	}
+	rh.pproc.SetLatencyBudget(lb.cfg.PreprocessorMaxLatency(), 0)
+	//check if authentication is enabled for this URL
+	if v.AuthType == scopedToken {
+		rh.auth, err = newScopedTokenHandler(lb.tdb, v.URL, v.Tag_Name)
+	} else if v.AuthType == apiKeyAuth {
+		rh.auth, err = newAPIKeyHandler(lb.keys, name, v.TokenName)
+	} else {
+		loginURL, rh.auth, err = v.NewAuthHandler(lb.lgr)
+	}
+	if err != nil {
+		rh.pproc.Close()
+		err = fmt.Errorf("failed to get a new authentication handler: %w", err)
+	}
+	return
 }
@@ -28,6 +28,7 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
@@ -43,6 +44,7 @@ type hecCompatible struct {
 	Ignore_Timestamps bool
 	Ack               bool
 	Preprocessor      []string
+	processors.ChunkConfig
 }
 
 type hecHandler struct {
@@ -70,6 +72,8 @@ func (v *hecCompatible) validate(name string) (string, error) {
 	}
 	if strings.ContainsAny(v.Tag_Name, ingest.FORBIDDEN_TAG_SET) {
 		return ``, errors.New("Invalid characters in the \"" + v.Tag_Name + "\"Tag-Name for " + name)
+	} else if err = v.ChunkConfig.Validate(); err != nil {
+		return ``, fmt.Errorf("entry size invalid: %v", err)
 	}
 	//normalize the path
 	v.URL = pth
@@ -126,7 +130,7 @@ func (hh *hecHandler) handle(h *handler, cfg routeHandler, w http.ResponseWriter
 		Tag:  cfg.tag,
 		Data: b,
 	}
-	if err = cfg.pproc.Process(&e); err != nil {
+	if err = cfg.process(&e); err != nil {
 		h.lgr.Error("failed to send entry", log.KVErr(err))
 		return
 	}
@@ -155,7 +159,7 @@ func (hh *hecHandler) handleRaw(h *handler, cfg routeHandler, w http.ResponseWri
 		return
 	} else if err = h.handleEntry(cfg, b, ip); err != nil {
 		h.lgr.Error("failed to handle entry", log.KV("address", ip), log.KVErr(err))
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(entryErrorCode(err))
 		return
 	}
 	if hh.acking {
@@ -209,6 +213,11 @@ func includeHecListeners(hnd *handler, igst *ingest.IngestMuxer, cfg *cfgType, l
 			lg.Error("preprocessor construction error", log.KVErr(err))
 			return
 		}
+		hcfg.pproc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
+		if hcfg.chunker, err = v.NewChunker(); err != nil {
+			lg.Error("failed to build entry size policy", log.KVErr(err))
+			return
+		}
 		if hcfg.auth, err = newPresharedTokenHandler(`Splunk`, v.TokenValue, lgr); err != nil {
 			lg.Error("failed to generate HEC-Compatible-Listener auth", log.KVErr(err))
 			return
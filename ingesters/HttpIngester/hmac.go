@@ -0,0 +1,278 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	hmacAuth authType = `hmac`
+
+	defaultHMACHeader         = `X-Signature`
+	defaultHMACHash           = `sha256`
+	defaultTimestampTolerance = 5 * time.Minute
+	maxNonceCache             = 1024 * 1024
+)
+
+var (
+	ErrMissingSignature  = errors.New("Signature header not found")
+	ErrBadSignature      = errors.New("Invalid request signature")
+	ErrMissingTimestamp  = errors.New("Timestamp header not found")
+	ErrStaleRequest      = errors.New("Request timestamp is outside the allowed tolerance")
+	ErrReplayedRequest   = errors.New("Request has already been seen")
+	ErrNonceCacheFull    = errors.New("Replay cache is full")
+	ErrRequestTooLarge   = errors.New("Request body too large")
+	ErrNonceNeedsTimeHdr = errors.New("NonceHeader requires TimestampHeader")
+)
+
+// hmacConfig validates the hmac specific parts of an auth block
+func (a *auth) hmacConfig() (hf func() hash.Hash, tolerance time.Duration, err error) {
+	if a.TokenValue == `` {
+		err = fmt.Errorf("Missing Token-Value for auth type %s", a.AuthType)
+		return
+	}
+	if hf, err = hmacHash(a.HMACHash); err != nil {
+		return
+	}
+	if a.NonceHeader != `` && a.TimestampHeader == `` {
+		err = ErrNonceNeedsTimeHdr
+		return
+	}
+	tolerance = defaultTimestampTolerance
+	if a.TimestampTolerance != `` {
+		if tolerance, err = time.ParseDuration(a.TimestampTolerance); err != nil {
+			err = fmt.Errorf("Invalid TimestampTolerance %q: %v", a.TimestampTolerance, err)
+		} else if tolerance <= 0 {
+			err = fmt.Errorf("Invalid TimestampTolerance %q: must be positive", a.TimestampTolerance)
+		}
+	}
+	return
+}
+
+func hmacHash(v string) (hf func() hash.Hash, err error) {
+	name := strings.ToLower(strings.TrimSpace(v))
+	if name == `` {
+		name = defaultHMACHash
+	}
+	if err = config.CheckFIPSHash(name); err != nil {
+		return
+	}
+	switch name {
+	case defaultHMACHash:
+		hf = sha256.New
+	case `sha1`:
+		hf = sha1.New
+	case `sha512`:
+		hf = sha512.New
+	default:
+		err = fmt.Errorf("Unknown HMACHash %q, must be sha1, sha256, or sha512", v)
+	}
+	return
+}
+
+// hmacAuthHandler authenticates requests carrying an HMAC of the request body, as is common for
+// webhooks.  If a timestamp header is configured the timestamp (and optional nonce) are signed
+// along with the body:
+//
+//	timestamp.body
+//	timestamp.nonce.body
+//
+// and requests outside the timestamp tolerance, or that reuse a nonce, are rejected.  If no
+// nonce header is configured the signature itself is used as the nonce.
+type hmacAuthHandler struct {
+	noLogin
+	lgr       *log.Logger
+	hdr       string
+	hashName  string
+	secret    []byte
+	hf        func() hash.Hash
+	tsHdr     string
+	nonceHdr  string
+	tolerance time.Duration
+	nonces    *nonceCache
+}
+
+func newHMACAuthHandler(a auth, lgr *log.Logger) (hnd authHandler, err error) {
+	var hf func() hash.Hash
+	var tolerance time.Duration
+	if hf, tolerance, err = a.hmacConfig(); err != nil {
+		return
+	}
+	hah := &hmacAuthHandler{
+		lgr:       lgr,
+		hdr:       a.TokenName,
+		hashName:  strings.ToLower(strings.TrimSpace(a.HMACHash)),
+		secret:    []byte(a.TokenValue),
+		hf:        hf,
+		tsHdr:     a.TimestampHeader,
+		nonceHdr:  a.NonceHeader,
+		tolerance: tolerance,
+	}
+	if hah.hdr == `` {
+		hah.hdr = defaultHMACHeader
+	}
+	if hah.hashName == `` {
+		hah.hashName = defaultHMACHash
+	}
+	if hah.tsHdr != `` {
+		hah.nonces = newNonceCache(tolerance, maxNonceCache)
+	}
+	hnd = hah
+	return
+}
+
+func (hah *hmacAuthHandler) AuthRequest(r *http.Request) (err error) {
+	var sig []byte
+	if sig, err = hah.signature(r); err != nil {
+		return
+	}
+	var ts time.Time
+	var tsv, nonce string
+	if hah.tsHdr != `` {
+		if tsv = r.Header.Get(hah.tsHdr); tsv == `` {
+			return ErrMissingTimestamp
+		} else if ts, err = parseRequestTimestamp(tsv); err != nil {
+			return
+		}
+		if hah.nonceHdr != `` {
+			if nonce = r.Header.Get(hah.nonceHdr); nonce == `` {
+				return fmt.Errorf("Nonce header %s not found", hah.nonceHdr)
+			}
+		}
+	}
+
+	//the body has to be read to check the signature, put it back for the handlers
+	var body []byte
+	if body, err = ioutil.ReadAll(io.LimitReader(r.Body, int64(maxBody+1))); err != nil {
+		return
+	} else if len(body) > maxBody {
+		return ErrRequestTooLarge
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	mac := hmac.New(hah.hf, hah.secret)
+	if hah.tsHdr != `` {
+		io.WriteString(mac, tsv)
+		io.WriteString(mac, `.`)
+		if hah.nonceHdr != `` {
+			io.WriteString(mac, nonce)
+			io.WriteString(mac, `.`)
+		}
+	}
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), sig) {
+		return ErrBadSignature
+	}
+
+	//only authenticated requests make it into the replay cache
+	if hah.nonces != nil {
+		if nonce == `` {
+			nonce = string(sig)
+		}
+		return hah.nonces.check(nonce, ts, time.Now())
+	}
+	return nil
+}
+
+// signature pulls the signature out of the header, signatures may be hex or base64 encoded
+// and may be prefixed with the hash name, e.g. sha256=abcd...
+func (hah *hmacAuthHandler) signature(r *http.Request) (sig []byte, err error) {
+	v := strings.TrimSpace(r.Header.Get(hah.hdr))
+	if v == `` {
+		err = ErrMissingSignature
+		return
+	}
+	if idx := strings.Index(v, `=`); idx > 0 && strings.EqualFold(v[:idx], hah.hashName) {
+		v = v[idx+1:]
+	}
+	if sig, err = hex.DecodeString(v); err == nil {
+		return
+	} else if sig, err = base64.StdEncoding.DecodeString(v); err == nil {
+		return
+	}
+	err = ErrBadSignature
+	return
+}
+
+// parseRequestTimestamp accepts unix seconds, unix milliseconds, or RFC3339 timestamps
+func parseRequestTimestamp(v string) (ts time.Time, err error) {
+	if n, lerr := strconv.ParseInt(v, 10, 64); lerr == nil {
+		if n > 1e12 {
+			ts = time.Unix(0, n*int64(time.Millisecond))
+		} else {
+			ts = time.Unix(n, 0)
+		}
+	} else if ts, err = time.Parse(time.RFC3339Nano, v); err != nil {
+		err = fmt.Errorf("Invalid request timestamp %q", v)
+	}
+	return
+}
+
+// nonceCache remembers nonces until their requests fall outside the timestamp tolerance,
+// at which point a replay would be rejected as stale anyway
+type nonceCache struct {
+	sync.Mutex
+	tolerance time.Duration
+	max       int
+	seen      map[string]time.Time //nonce and when it can be forgotten
+	prune     time.Time
+}
+
+func newNonceCache(tolerance time.Duration, max int) *nonceCache {
+	return &nonceCache{
+		tolerance: tolerance,
+		max:       max,
+		seen:      map[string]time.Time{},
+	}
+}
+
+// check validates the request timestamp and records the nonce
+func (nc *nonceCache) check(nonce string, ts, now time.Time) error {
+	if ts.Before(now.Add(-nc.tolerance)) || ts.After(now.Add(nc.tolerance)) {
+		return ErrStaleRequest
+	}
+	nc.Lock()
+	defer nc.Unlock()
+	if now.After(nc.prune) || len(nc.seen) >= nc.max {
+		for k, v := range nc.seen {
+			if now.After(v) {
+				delete(nc.seen, k)
+			}
+		}
+		nc.prune = now.Add(nc.tolerance / 4)
+	}
+	if _, ok := nc.seen[nonce]; ok {
+		return ErrReplayedRequest
+	} else if len(nc.seen) >= nc.max {
+		return ErrNonceCacheFull
+	}
+	nc.seen[nonce] = ts.Add(nc.tolerance)
+	return nil
+}
@@ -0,0 +1,110 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHMACSecret = `sekrit`
+
+func testHMACSign(parts ...string) []byte {
+	mac := hmac.New(sha256.New, []byte(testHMACSecret))
+	mac.Write([]byte(strings.Join(parts, `.`)))
+	return mac.Sum(nil)
+}
+
+func TestHMACAuthRequest(t *testing.T) {
+	maxBody = 1024
+	hnd, err := newHMACAuthHandler(auth{
+		AuthType:        hmacAuth,
+		TokenValue:      testHMACSecret,
+		TimestampHeader: `X-Timestamp`,
+		NonceHeader:     `X-Nonce`,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := `{"event":"push"}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-2*defaultTimestampTolerance).Unix(), 10)
+	future := time.Now().Add(2 * defaultTimestampTolerance).Format(time.RFC3339)
+
+	tests := []struct {
+		name  string
+		sig   string
+		ts    string
+		nonce string
+		body  string
+		err   error
+	}{
+		{name: `good`, sig: hex.EncodeToString(testHMACSign(now, `n1`, body)), ts: now, nonce: `n1`, body: body},
+		{name: `prefixed base64`, sig: `sha256=` + base64.StdEncoding.EncodeToString(testHMACSign(now, `n2`, body)), ts: now, nonce: `n2`, body: body},
+		{name: `replayed nonce`, sig: hex.EncodeToString(testHMACSign(now, `n1`, body)), ts: now, nonce: `n1`, body: body, err: ErrReplayedRequest},
+		{name: `missing signature`, ts: now, nonce: `n3`, body: body, err: ErrMissingSignature},
+		{name: `undecodable signature`, sig: `not a signature!`, ts: now, nonce: `n3`, body: body, err: ErrBadSignature},
+		{name: `wrong secret`, sig: hex.EncodeToString(hmac.New(sha256.New, []byte(`nope`)).Sum(nil)), ts: now, nonce: `n3`, body: body, err: ErrBadSignature},
+		{name: `modified body`, sig: hex.EncodeToString(testHMACSign(now, `n3`, body)), ts: now, nonce: `n3`, body: body + ` `, err: ErrBadSignature},
+		{name: `unsigned nonce`, sig: hex.EncodeToString(testHMACSign(now, `n3`, body)), ts: now, nonce: `n4`, body: body, err: ErrBadSignature},
+		{name: `stale timestamp`, sig: hex.EncodeToString(testHMACSign(stale, `n5`, body)), ts: stale, nonce: `n5`, body: body, err: ErrStaleRequest},
+		{name: `future timestamp`, sig: hex.EncodeToString(testHMACSign(future, `n6`, body)), ts: future, nonce: `n6`, body: body, err: ErrStaleRequest},
+		{name: `missing timestamp`, sig: hex.EncodeToString(testHMACSign(now, `n7`, body)), nonce: `n7`, body: body, err: ErrMissingTimestamp},
+		{name: `oversized body`, sig: hex.EncodeToString(testHMACSign(now, `n8`, strings.Repeat(`x`, 1025))), ts: now, nonce: `n8`, body: strings.Repeat(`x`, 1025), err: ErrRequestTooLarge},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(`POST`, `/hook`, strings.NewReader(tc.body))
+		if tc.sig != `` {
+			r.Header.Set(defaultHMACHeader, tc.sig)
+		}
+		if tc.ts != `` {
+			r.Header.Set(`X-Timestamp`, tc.ts)
+		}
+		r.Header.Set(`X-Nonce`, tc.nonce)
+		if err := hnd.AuthRequest(r); !errors.Is(err, tc.err) {
+			t.Fatalf("%s: got %v, expected %v", tc.name, err, tc.err)
+		} else if err != nil {
+			continue
+		}
+		//the body must still be there for the handlers
+		if b, err := ioutil.ReadAll(r.Body); err != nil || string(b) != tc.body {
+			t.Fatalf("%s: body not restored: %q %v", tc.name, b, err)
+		}
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	now := time.Now()
+	nc := newNonceCache(time.Minute, 2)
+	if err := nc.check(`a`, now, now); err != nil {
+		t.Fatal(err)
+	} else if err = nc.check(`a`, now, now); err != ErrReplayedRequest {
+		t.Fatalf("replay not caught: %v", err)
+	} else if err = nc.check(`b`, now.Add(-time.Minute-time.Second), now); err != ErrStaleRequest {
+		t.Fatalf("stale request not caught: %v", err)
+	} else if err = nc.check(`b`, now, now); err != nil {
+		t.Fatal(err)
+	} else if err = nc.check(`c`, now, now); err != ErrNonceCacheFull {
+		t.Fatalf("full cache not caught: %v", err)
+	}
+	//once the tolerance passes the nonces are forgotten, a replay would be stale anyway
+	later := now.Add(2 * time.Minute)
+	if err := nc.check(`a`, later, later); err != nil {
+		t.Fatalf("expired nonce not pruned: %v", err)
+	}
+}
@@ -9,33 +9,56 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
 const (
-	kdsAuthTokenHeader = `X-Amz-Firehose-Access-Key`
+	kdsAuthTokenHeader  = `X-Amz-Firehose-Access-Key`
+	kdsRequestIdHeader  = `X-Amz-Firehose-Request-Id`
+	kdsAttributesHeader = `X-Amz-Firehose-Common-Attributes`
+
+	// Firehose buffers at most 64MiB before base64 encoding the records
+	maxKDSBody = 96 * 1024 * 1024
+)
+
+var (
+	ErrKDSMaxBody           = fmt.Errorf("Max-Body must be between 0 and %d", maxKDSBody)
+	ErrKDSRequestIdMismatch = errors.New("requestId does not match the X-Amz-Firehose-Request-Id header")
 )
 
-// KinesisDeliveryStream
+// KinesisDeliveryStream implements the Amazon Data Firehose HTTP endpoint delivery spec
 type kds struct {
-	URL               string //override the URL, defaults to "/services/collector/event"
-	TokenValue        string `json:"-"` //DO NOT SEND THIS when marshalling
-	Tag_Name          string //the tag to assign to the request
-	Ignore_Timestamps bool
-	Preprocessor      []string
+	URL                      string //override the URL, defaults to "/services/collector/event"
+	TokenValue               string `json:"-"` //DO NOT SEND THIS when marshalling
+	Tag_Name                 string //the tag to assign to the request
+	Ignore_Timestamps        bool
+	Preprocessor             []string
+	Max_Body                 int  //largest request accepted, defaults to the global Max-Body
+	Decompress_Records       bool //gunzip records which are gzip compressed, such as CloudWatch Logs subscriptions
+	Split_Records            bool //each line of a record is a separate entry
+	Attach_Common_Attributes bool //attach the delivery stream common attributes to every entry
+	processors.ConnMetadataConfig
+	processors.ChunkConfig
 }
 
 func (v *kds) validate(name string) (string, error) {
@@ -58,6 +81,13 @@ func (v *kds) validate(name string) (string, error) {
 	if strings.ContainsAny(v.Tag_Name, ingest.FORBIDDEN_TAG_SET) {
 		return ``, errors.New("Invalid characters in the \"" + v.Tag_Name + "\"Tag-Name for " + name)
 	}
+	if v.Max_Body < 0 || v.Max_Body > maxKDSBody {
+		return ``, ErrKDSMaxBody
+	} else if err = v.ConnMetadataConfig.Validate(); err != nil {
+		return ``, fmt.Errorf("metadata invalid: %v", err)
+	} else if err = v.ChunkConfig.Validate(); err != nil {
+		return ``, fmt.Errorf("entry size invalid: %v", err)
+	}
 	//normalize the path
 	v.URL = pth
 	return pth, nil
@@ -80,36 +110,121 @@ type record struct {
 	Data []byte `json:"data"`
 }
 
+// kdsAttributes is the X-Amz-Firehose-Common-Attributes header
+type kdsAttributes struct {
+	CommonAttributes map[string]string `json:"commonAttributes"`
+}
+
+// commonAttributes returns the annotations for the delivery stream common attributes, sorted by name
+func commonAttributes(r *http.Request) (anns []processors.Annotation, err error) {
+	hv := r.Header.Get(kdsAttributesHeader)
+	if hv == `` {
+		return
+	}
+	var ka kdsAttributes
+	if err = json.Unmarshal([]byte(hv), &ka); err != nil {
+		return
+	}
+	for k, v := range ka.CommonAttributes {
+		anns = append(anns, processors.Annotation{Name: k, Value: v})
+	}
+	sort.Slice(anns, func(i, j int) bool { return anns[i].Name < anns[j].Name })
+	return
+}
+
+// kdsRecordData returns the entries held by a record
+func kdsRecordData(cfg routeHandler, data []byte) (r [][]byte, err error) {
+	if cfg.kds.decompress && len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(gz, int64(cfg.kds.maxBody)+1))
+		gz.Close()
+		if err != nil {
+			return
+		} else if len(data) > cfg.kds.maxBody {
+			err = errors.New("decompressed record too large")
+			return
+		}
+	}
+	if !cfg.kds.split {
+		if len(data) > 0 {
+			r = append(r, data)
+		}
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			r = append(r, append([]byte(nil), scanner.Bytes()...))
+		}
+	}
+	err = scanner.Err()
+	return
+}
+
 func handleKDS(h *handler, cfg routeHandler, w http.ResponseWriter, rdr io.Reader, ip net.IP) {
 	var kr kinesisRequest
-	if err := json.NewDecoder(io.LimitReader(rdr, int64(maxBody+256))).Decode(&kr); err != nil {
+	hdrId := cfg.requestId
+	lr := &io.LimitedReader{R: rdr, N: int64(cfg.kds.maxBody) + 1}
+	if err := json.NewDecoder(lr).Decode(&kr); err != nil {
+		if lr.N <= 0 {
+			h.lgr.Error("request too large", log.KV("address", ip), log.KV("max", cfg.kds.maxBody))
+			sendKDSError(w, http.StatusRequestEntityTooLarge, hdrId, errors.New("request too large"))
+			return
+		}
 		h.lgr.Info("bad request", log.KV("address", ip), log.KVErr(err))
-		sendKDSError(w, http.StatusBadRequest, ``, nil)
+		sendKDSError(w, http.StatusBadRequest, hdrId, err)
 		return
-	} else if len(kr.Records) == 0 {
-		h.lgr.Info("bad request", log.KV("address", ip), log.KVErr(errors.New("empty records")))
-		sendKDSError(w, http.StatusBadRequest, kr.RequestId, errors.New("empty records"))
+	} else if hdrId != `` && kr.RequestId != `` && hdrId != kr.RequestId {
+		h.lgr.Info("bad request", log.KV("address", ip), log.KVErr(ErrKDSRequestIdMismatch))
+		sendKDSError(w, http.StatusBadRequest, hdrId, ErrKDSRequestIdMismatch)
+		return
+	} else if kr.RequestId == `` {
+		kr.RequestId = hdrId
+	}
+	if len(kr.Records) == 0 {
+		// Firehose does not send empty batches, but an empty batch has nothing to fail on
+		sendKDSOk(w, kr.RequestId)
 		return
 	}
 	reqTS := entry.FromStandard(kr.TS())
 	batch := make([]*entry.Entry, 0, len(kr.Records))
 	for _, r := range kr.Records {
-		e := &entry.Entry{
-			TS:   reqTS,
-			SRC:  ip,
-			Tag:  cfg.tag,
-			Data: r.Data,
-		}
-		if cfg.tg != nil {
-			if hts, ok, err := cfg.tg.Extract(r.Data); err == nil && ok {
-				e.TS = entry.FromStandard(hts)
+		datas, err := kdsRecordData(cfg, r.Data)
+		if err != nil {
+			h.lgr.Info("bad record", log.KV("address", ip), log.KV("requestid", kr.RequestId), log.KVErr(err))
+			sendKDSError(w, http.StatusBadRequest, kr.RequestId, err)
+			return
+		}
+		for _, data := range datas {
+			e := &entry.Entry{
+				TS:   reqTS,
+				SRC:  ip,
+				Tag:  cfg.tag,
+				Data: data,
+			}
+			if cfg.tg != nil {
+				if hts, ok, err := cfg.tg.Extract(data); err == nil && ok {
+					e.TS = entry.FromStandard(hts)
+				}
 			}
+			if err = cfg.meta.Attach(e, cfg.anns); err != nil {
+				h.lgr.Warn("failed to attach metadata", log.KVErr(err))
+			}
+			if len(cfg.fields) > 0 {
+				if _, err = cfg.kds.ann.Annotate(e, cfg.fields...); err != nil {
+					h.lgr.Warn("failed to attach common attributes", log.KVErr(err))
+				}
+			}
+			batch = append(batch, e)
 		}
-		batch = append(batch, e)
 	}
-	if err := cfg.pproc.ProcessBatch(batch); err != nil {
+	if err := cfg.processBatch(batch); err != nil {
 		h.lgr.Error("failed to send entries", log.KVErr(err))
-		sendKDSError(w, http.StatusInternalServerError, kr.RequestId, err)
+		sendKDSError(w, entryErrorCode(err), kr.RequestId, err)
 	} else {
 		sendKDSOk(w, kr.RequestId)
 	}
@@ -122,9 +237,11 @@ type kdsresp struct {
 }
 
 func (k kdsresp) send(w http.ResponseWriter, code int) {
+	b, _ := json.Marshal(k)
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
 	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(k)
+	w.Write(b)
 }
 
 func sendKDSError(w http.ResponseWriter, code int, id string, err error) {
@@ -147,9 +264,19 @@ func sendKDSOk(w http.ResponseWriter, id string) {
 }
 
 func includeKDSListeners(hnd *handler, igst *ingest.IngestMuxer, cfg *cfgType, lgr *log.Logger) (err error) {
-	for _, v := range cfg.KDSListener {
+	for k, v := range cfg.KDSListener {
 		hcfg := routeHandler{
 			handler: handleKDS,
+			name:    k,
+			kds: &kdsConfig{
+				maxBody:    v.Max_Body,
+				decompress: v.Decompress_Records,
+				split:      v.Split_Records,
+				attributes: v.Attach_Common_Attributes,
+			},
+		}
+		if hcfg.kds.maxBody == 0 {
+			hcfg.kds.maxBody = maxBody
 		}
 		if hcfg.tag, err = igst.GetTag(v.Tag_Name); err != nil {
 			lg.Error("failed to pull tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
@@ -163,19 +290,41 @@ func includeKDSListeners(hnd *handler, igst *ingest.IngestMuxer, cfg *cfgType, l
 				return
 			}
 		}
+		if hcfg.kds.ann, err = processors.NewAnnotator(v.Metadata_Mode, v.Metadata_Field); err != nil {
+			lg.Error("failed to build Kinesis-Delivery-Stream annotator", log.KVErr(err))
+			return
+		}
+		if hcfg.meta, err = v.NewMetadataAttacher(); err != nil {
+			lg.Error("failed to build Kinesis-Delivery-Stream metadata", log.KVErr(err))
+			return
+		}
 
 		if hcfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
 			lg.Error("preprocessor construction error", log.KVErr(err))
 			return
 		}
+		hcfg.pproc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
+		if hcfg.chunker, err = v.NewChunker(); err != nil {
+			lg.Error("failed to build entry size policy", log.KVErr(err))
+			return
+		}
 		if hcfg.auth, err = newPresharedHeaderTokenHandler(kdsAuthTokenHeader, v.TokenValue, lgr); err != nil {
 			lg.Error("failed to generate Kinesis-Delivery-Stream auth", log.KVErr(err))
 			return
 		}
-		if hnd.addHandler(http.MethodPost, v.URL, hcfg); err != nil {
+		if err = hnd.addHandler(http.MethodPost, v.URL, hcfg); err != nil {
 			return
 		}
 		debugout("KDS Handler URL %s handling %s\n", v.URL, v.Tag_Name)
 	}
 	return
 }
+
+// kdsConfig holds the Firehose options for a Kinesis-Delivery-Stream listener
+type kdsConfig struct {
+	maxBody    int
+	decompress bool
+	split      bool
+	attributes bool
+	ann        processors.Annotator // attaches common attributes
+}
@@ -9,6 +9,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
@@ -16,16 +17,20 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"runtime/debug"
 	"syscall"
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
 	"github.com/gravwell/gravwell/v3/ingesters/version"
-	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
 const (
@@ -40,12 +45,15 @@ var (
 	verbose        = flag.Bool("v", false, "Display verbose status updates to stdout")
 	ver            = flag.Bool("version", false, "Print the version information and exit")
 	stderrOverride = flag.String("stderr", "", "Redirect stderr to a shared memory file")
+	selftest       = flag.Bool("selftest", false, "Send synthetic entries through each listener's preprocessors to the indexers, report the results, and exit")
+	testTimestamp  = flag.Bool("test-timestamp", false, "Print the time format and timestamp found in each argument, or in each line of stdin if the argument is -, and exit.  With no arguments the time formats are listed")
+	testListener   = flag.String("test-listener", "", "Listener whose time settings are used by -test-timestamp")
 	lg             *log.Logger
 	v              bool
 	maxBody        int
 )
 
-func init() {
+func mainInit() {
 	flag.Parse()
 	if *ver {
 		version.PrintVersion(os.Stdout)
@@ -87,11 +95,15 @@ func init() {
 
 func main() {
 	debug.SetTraceback("all")
+	mainInit()
 	var lgr *log.Logger
 	cfg, err := GetConfig(*confLoc, *confdLoc)
 	if err != nil {
 		lg.Fatal("failed to load config file", log.KV("file", *confLoc), log.KVErr(err))
 	}
+	if *testTimestamp {
+		os.Exit(runTimestampTest(cfg, *testListener, flag.Args()))
+	}
 
 	//logging is a bit whacky here, we are creating a logger for fatal errors that goes to
 	//stderr and then creating another logger that goes to the logging file
@@ -128,6 +140,14 @@ func main() {
 	if !ok {
 		lg.FatalCode(0, "could not read ingester UUID")
 	}
+	if tc, err := cfg.TraceConfig(); err != nil {
+		lg.FatalCode(0, "invalid tracing configuration", log.KVErr(err))
+	} else if tc.Enabled() {
+		if tracer, err = tracing.New(tc); err != nil {
+			lg.FatalCode(0, "failed to start tracing", log.KVErr(err))
+		}
+		debugout("Tracing %v of requests to %s\n", tc.SampleRate, tc.Endpoint)
+	}
 	igCfg := ingest.UniformMuxerConfig{
 		IngestStreamConfig: cfg.IngestStreamConfig,
 		Destinations:       conns,
@@ -144,7 +164,11 @@ func main() {
 		CachePath:          cfg.Ingest_Cache_Path,
 		CacheSize:          cfg.Max_Ingest_Cache,
 		CacheMode:          cfg.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Log_Source_Override),
+		LogSourceOverride:  cfg.LogSourceOverride(),
+		Tracer:             tracer,
+	}
+	if igCfg.Secondary, err = ingest.SecondaryFromConfig(&cfg.IngestConfig); err != nil {
+		lg.FatalCode(0, "failed to get secondary backend targets from configuration", log.KVErr(err))
 	}
 	igst, err := ingest.NewUniformMuxer(igCfg)
 	if err != nil {
@@ -159,6 +183,10 @@ func main() {
 	}
 	debugout("Waiting for connections to indexers\n")
 	if err := igst.WaitForHot(cfg.Timeout()); err != nil {
+		if *selftest {
+			fmt.Printf("FAIL: %v\n", err)
+			os.Exit(1)
+		}
 		lg.Fatal("Timedout waiting for backend connections", log.KVErr(err))
 	}
 	debugout("Successfully connected to ingesters\n")
@@ -168,67 +196,80 @@ func main() {
 	if err != nil {
 		lg.FatalCode(0, "Failed to set configuration for ingester state messages")
 	}
+
+	if *selftest {
+		code := runSelfTest(cfg, igst)
+		igst.Close()
+		os.Exit(code)
+	}
 	hnd, err := newHandler(igst, lgr)
 	if err != nil {
 		lg.FatalCode(0, "Failed to create new handler")
 	}
 
+	bp, err := newBackpressure(cfg.gbl, igst, lgr)
+	if err != nil {
+		lg.Fatal("invalid backpressure configuration", log.KVErr(err))
+	}
+	hnd.bp = bp
+
 	if hcurl, ok := cfg.HealthCheck(); ok {
 		hnd.healthCheckURL = path.Clean(hcurl)
 	}
-	for _, v := range cfg.Listener {
-		hcfg := routeHandler{
-			handler: handleSingle,
-		}
-		if v.Multiline {
-			hcfg.handler = handleMulti
+	var tdb *tokenDB
+	if cfg.Token_Database != `` {
+		if tdb, err = newTokenDB(cfg.Token_Database, cfg.ScopedTokenURLs()); err != nil {
+			lg.Fatal("failed to open token database", log.KV("path", cfg.Token_Database), log.KVErr(err))
 		}
-		if hcfg.tag, err = igst.GetTag(v.Tag_Name); err != nil {
-			lg.Fatal("failed to pull tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
-		}
-		if v.Ignore_Timestamps {
-			hcfg.ignoreTs = true
-		} else {
-			tcfg := timegrinder.Config{
-				EnableLeftMostSeed: true,
-			}
-			if hcfg.tg, err = timegrinder.NewTimeGrinder(tcfg); err != nil {
-				lg.Fatal("failed to generate new timegrinder", log.KVErr(err))
-			} else if err = cfg.TimeFormat.LoadFormats(hcfg.tg); err != nil {
-				lg.Fatal("failed to load custom time formats", log.KVErr(err))
-			}
-			if v.Timestamp_Format_Override != `` {
-				if err = hcfg.tg.SetFormatOverride(v.Timestamp_Format_Override); err != nil {
-					lg.Fatal("Failed to set override timestamp", log.KVErr(err))
-				}
-			}
-			if v.Assume_Local_Timezone {
-				hcfg.tg.SetLocalTime()
+		if admin, ok := cfg.TokenAdmin(); ok {
+			tah := &tokenAdminHandler{
+				db:     tdb,
+				secret: cfg.Token_Admin_Secret,
+				lgr:    lgr,
 			}
-			if v.Timezone_Override != `` {
-				if err = hcfg.tg.SetTimezone(v.Timezone_Override); err != nil {
-					lg.Fatal("failed to override timezone", log.KVErr(err))
+			for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+				if err = hnd.addCustomHandler(m, admin, tah); err != nil {
+					lg.Fatal("failed to add token admin handler", log.KV("url", admin), log.KVErr(err))
 				}
 			}
+			debugout("Token administration on %s\n", admin)
 		}
-		if v.Method == `` {
-			v.Method = defaultMethod
+	}
+	keys := newAPIKeys()
+	if err = keys.load(cfg.APIKey, igst); err != nil {
+		lg.Fatal("failed to load API keys", log.KVErr(err))
+	}
+	var certs *netframe.CertReloader
+	if cfg.TLSEnabled() {
+		c := cfg.TLS_Certificate_File
+		k := cfg.TLS_Key_File
+		if certs, err = netframe.NewCertReloader(c, k, cfg.TLS_Client_CA_File); err != nil {
+			lg.Fatal("failed to load certificate", log.KV("certfile", c), log.KV("keyfile", k), log.KV("cafile", cfg.TLS_Client_CA_File), log.KVErr(err))
 		}
-
-		hcfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor)
+		certs.SetReloadErrorHandler(func(err error) {
+			lgr.Error("failed to reload certificates, keeping previous certificates", log.KV("certfile", c), log.KVErr(err))
+		})
+		if err = certs.Watch(); err != nil {
+			lg.Warn("failed to watch certificate files, certificates will only be reloaded on SIGHUP", log.KV("certfile", c), log.KVErr(err))
+		}
+	}
+	go reloadOnHangup(keys, certs, igst, lgr)
+	lb := &listenerBuilder{
+		igst: igst,
+		cfg:  cfg,
+		tdb:  tdb,
+		keys: keys,
+		lgr:  lgr,
+	}
+	for k, v := range cfg.Listener {
+		hcfg, loginURL, err := lb.build(k, v)
 		if err != nil {
-			lg.Fatal("preprocessor construction error", log.KVErr(err))
+			lg.Fatal("failed to build listener", log.KV("listener", k), log.KVErr(err))
 		}
-		//check if authentication is enabled for this URL
-		if pth, ah, err := v.NewAuthHandler(lgr); err != nil {
-			lg.Fatal("failed to get a new authentication handler", log.KVErr(err))
-		} else if hnd != nil {
-			if pth != `` {
-				if err = hnd.addAuthHandler(http.MethodPost, pth, ah); err != nil {
-					lg.Fatal("failed to add auth handler", log.KV("url", pth), log.KVErr(err))
-				}
+		if loginURL != `` {
+			if err = hnd.addAuthHandler(http.MethodPost, loginURL, hcfg.auth); err != nil {
+				lg.Fatal("failed to add auth handler", log.KV("url", loginURL), log.KVErr(err))
 			}
-			hcfg.auth = ah
 		}
 		if err = hnd.addHandler(v.Method, v.URL, hcfg); err != nil {
 			lg.Fatal("failed to add handler", log.KV("url", v.URL), log.KVErr(err))
@@ -236,6 +277,27 @@ func main() {
 		debugout("URL %s handling %s\n", v.URL, v.Tag_Name)
 	}
 
+	var dl *dynamicListeners
+	if admin, ok := cfg.ListenerAdmin(); ok || cfg.Listener_Database != `` {
+		dl = newDynamicListeners(hnd, lb, cfg.Listener_Database, lgr)
+		if err = dl.load(); err != nil {
+			lg.Fatal("failed to load listener database", log.KV("path", cfg.Listener_Database), log.KVErr(err))
+		}
+		if ok {
+			lah := &listenerAdminHandler{
+				dl:     dl,
+				secret: cfg.Listener_Admin_Secret,
+				lgr:    lgr,
+			}
+			for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+				if err = hnd.addCustomHandler(m, admin, lah); err != nil {
+					lg.Fatal("failed to add listener admin handler", log.KV("url", admin), log.KVErr(err))
+				}
+			}
+			debugout("Listener administration on %s\n", admin)
+		}
+	}
+
 	if err = includeHecListeners(hnd, igst, cfg, lgr); err != nil {
 		lg.Fatal("failed to include HEC Listeners", log.KVErr(err))
 	}
@@ -243,23 +305,48 @@ func main() {
 		lg.Fatal("failed to include KDS Listeners", log.KVErr(err))
 	}
 
-	srv := &http.Server{
-		Addr:         cfg.Bind,
-		Handler:      hnd,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
-		ErrorLog:     dlog.New(lgr, ``, dlog.Lshortfile|dlog.LUTC|dlog.LstdFlags),
+	sc, err := cfg.serverConfig(cfg.streaming())
+	if err != nil {
+		lg.Fatal("invalid server configuration", log.KVErr(err))
 	}
-	if cfg.TLSEnabled() {
-		c := cfg.TLS_Certificate_File
-		k := cfg.TLS_Key_File
+	var tcfg *tls.Config
+	if certs != nil {
+		// the TLS config hands out the current certificate
+		tcfg = certs.Config()
+	}
+	srv, err := sc.newServer(cfg.Bind, hnd, tcfg)
+	if err != nil {
+		lg.Fatal("failed to build HTTP server", log.KVErr(err))
+	}
+	srv.ErrorLog = dlog.New(lgr, ``, dlog.Lshortfile|dlog.LUTC|dlog.LstdFlags)
+	// systemd socket activation lets us serve on a privileged port without binding it ourselves
+	activated, err := utils.GetActivatedSockets()
+	if err != nil {
+		lg.Fatal("failed to load socket activated listeners", log.KVErr(err))
+	}
+	l, isActivated := activated.Listener(`tcp`, cfg.Bind)
+	for _, v := range activated.Close() {
+		lg.Warn("closing socket activated listener which does not match Bind", log.KV("socket", v), log.KV("bind", cfg.Bind))
+	}
+	if isActivated {
+		lg.Info("using socket activated listener", log.KV("bind", cfg.Bind))
+	} else if l, err = net.Listen(`tcp`, cfg.Bind); err != nil {
+		lg.Fatal("failed to listen", log.KV("bind", cfg.Bind), log.KVErr(err))
+	}
+	// the global filter drops connections as they are accepted, before any TLS handshake
+	ipf, err := newIPFilter(`global`, cfg.gbl.Accept_From, cfg.gbl.Deny_From, lgr)
+	if err != nil {
+		lg.Fatal("failed to build address filter", log.KVErr(err))
+	}
+	l = bp.listener(ipf.Listener(l))
+	if certs != nil {
 		debugout("Binding to %v with TLS enabled using %s %s\n", cfg.Bind, cfg.TLS_Certificate_File, cfg.TLS_Key_File)
-		if err := srv.ListenAndServeTLS(c, k); err != nil {
+		if err := srv.ServeTLS(l, ``, ``); err != nil {
 			lg.Error("failed to serve HTTPS server", log.KVErr(err))
 		}
 	} else {
 		debugout("Binding to %v in cleartext mode\n", cfg.Bind)
-		if err := srv.ListenAndServe(); err != nil {
+		if err := srv.Serve(l); err != nil {
 			lg.Error("failed to serve HTTP server", log.KVErr(err))
 		}
 	}
@@ -276,6 +363,9 @@ func main() {
 	if err := igst.Close(); err != nil {
 		lg.Error("failed to close muxer", log.KVErr(err))
 	}
+	if err := tracer.Close(); err != nil {
+		lg.Error("failed to export traces", log.KVErr(err))
+	}
 }
 
 func debugout(format string, args ...interface{}) {
@@ -296,6 +386,54 @@ func getRemoteAddr(r *http.Request) (host string) {
 
 }
 
+// getPeerIP returns the address of the connected peer, ignoring any forwarding headers
+func getPeerIP(r *http.Request) (ip net.IP) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip, _, _ = config.ParseIPZone(host)
+	return
+}
+
+// reloadOnHangup re-reads the configuration on SIGHUP and swaps in the API keys, so keys can be
+// added or revoked without a restart.  The TLS certificates are reloaded from their files as well,
+// nothing else in the configuration is reloaded.
+func reloadOnHangup(keys *apiKeys, certs *netframe.CertReloader, igst *ingest.IngestMuxer, lgr *log.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if certs != nil {
+			if err := certs.Reload(); err != nil {
+				lgr.Error("failed to reload certificates, keeping previous certificates", log.KVErr(err))
+			} else {
+				lgr.Info("reloaded TLS certificates")
+			}
+		}
+		cfg, err := GetConfig(*confLoc, *confdLoc)
+		if err != nil {
+			lgr.Error("failed to reload config, keeping previous API keys", log.KV("file", *confLoc), log.KVErr(err))
+			continue
+		}
+		if err = keys.load(cfg.APIKey, igst); err != nil {
+			lgr.Error("failed to reload API keys, keeping previous keys", log.KVErr(err))
+			continue
+		}
+		lgr.Info("reloaded API keys", log.KV("count", keys.count()))
+	}
+}
+
+// newIPFilter builds an address filter which logs failed list reloads, nil means everything is allowed
+func newIPFilter(name string, allow, deny []string, lgr *log.Logger) (f *utils.IPFilter, err error) {
+	if f, err = utils.NewIPFilter(allow, deny, 0); err != nil || f == nil {
+		return
+	}
+	f.SetReloadErrorHandler(func(err error) {
+		lgr.Error("failed to reload address filter, keeping previous lists", log.KV("listener", name), log.KVErr(err))
+	})
+	return
+}
+
 func getRemoteIP(r *http.Request) (ip net.IP) {
 	if host := getRemoteAddr(r); host != `` {
 		if ip = net.ParseIP(host); ip != nil {
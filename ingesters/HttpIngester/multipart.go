@@ -0,0 +1,188 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+var (
+	ErrMultipartBadFileTag = errors.New("Multipart-File-Tag must be of the form part=tag")
+	ErrMultipartBadField   = errors.New("Multipart-Field cannot be empty")
+)
+
+// multipartConfig handles multipart/form-data uploads.  Uploaded files are ingested line by line
+// on Multiline listeners and whole otherwise, selected form fields are attached to every entry
+// taken from the files which follow them in the form.
+type multipartConfig struct {
+	fields   map[string]bool
+	fileTags map[string]string // form part name to tag name
+	tags     map[string]entry.EntryTag
+	ann      processors.Annotator
+}
+
+// parseMultipartConfig validates the multipart options, returning nil if they are unset.
+// Tags are resolved later by resolveTags.
+func parseMultipartConfig(fields, fileTags []string, mode, field string) (mc *multipartConfig, err error) {
+	if len(fields) == 0 && len(fileTags) == 0 {
+		return
+	}
+	mc = &multipartConfig{
+		fields:   map[string]bool{},
+		fileTags: map[string]string{},
+	}
+	if mc.ann, err = processors.NewAnnotator(mode, field); err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if f = strings.TrimSpace(f); f == `` {
+			return nil, ErrMultipartBadField
+		}
+		mc.fields[f] = true
+	}
+	for _, ft := range fileTags {
+		idx := strings.IndexByte(ft, '=')
+		if idx <= 0 {
+			return nil, ErrMultipartBadFileTag
+		}
+		part, tag := strings.TrimSpace(ft[:idx]), strings.TrimSpace(ft[idx+1:])
+		if part == `` {
+			return nil, ErrMultipartBadFileTag
+		} else if err = ingest.CheckTag(tag); err != nil {
+			return nil, fmt.Errorf("Multipart-File-Tag %s: %w", part, err)
+		}
+		mc.fileTags[part] = tag
+	}
+	return
+}
+
+// tagNames returns the tags used by uploaded files
+func (mc *multipartConfig) tagNames() (r []string) {
+	if mc == nil {
+		return
+	}
+	for _, v := range mc.fileTags {
+		r = append(r, v)
+	}
+	return
+}
+
+func (mc *multipartConfig) resolveTags(igst *ingest.IngestMuxer) (err error) {
+	mc.tags = make(map[string]entry.EntryTag, len(mc.fileTags))
+	for part, name := range mc.fileTags {
+		if mc.tags[part], err = igst.NegotiateTag(name); err != nil {
+			return fmt.Errorf("failed to negotiate tag %s: %w", name, err)
+		}
+	}
+	return
+}
+
+// isMultipart returns the boundary if the request is a multipart/form-data upload
+func isMultipart(r *http.Request) (boundary string, ok bool) {
+	mt, params, err := mime.ParseMediaType(r.Header.Get(`Content-Type`))
+	if err != nil || mt != `multipart/form-data` {
+		return
+	}
+	boundary, ok = params[`boundary`], params[`boundary`] != ``
+	return
+}
+
+func (mc *multipartConfig) handle(h *handler, rh routeHandler, w http.ResponseWriter, body io.Reader, boundary string, ip net.IP) {
+	mr := multipart.NewReader(body, boundary)
+	var files int
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			h.lgr.Info("bad multipart upload", log.KV("address", ip), log.KVErr(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		name := part.FormName()
+		if part.FileName() == `` {
+			// a plain form field
+			if mc.fields[name] {
+				v, err := ioutil.ReadAll(io.LimitReader(part, int64(maxBody)))
+				if err != nil {
+					h.lgr.Info("bad multipart form field", log.KV("address", ip), log.KV("field", name), log.KVErr(err))
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				rh.fields = append(rh.fields, processors.Annotation{Name: name, Value: string(v)})
+			}
+			part.Close()
+			continue
+		}
+		files++
+		frh := rh
+		if tg, ok := mc.tags[name]; ok {
+			frh.tag = tg
+		}
+		code := mc.ingestFile(h, frh, part, ip)
+		part.Close()
+		if code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+	}
+	if files == 0 {
+		h.lgr.Info("multipart upload contained no files", log.KV("address", ip))
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (mc *multipartConfig) ingestFile(h *handler, rh routeHandler, part *multipart.Part, ip net.IP) int {
+	if rh.lines {
+		scanner := bufio.NewScanner(part)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			if err := h.handleEntry(rh, scanner.Bytes(), ip); err != nil {
+				h.lgr.Error("failed to handle entry", log.KV("address", ip), log.KVErr(err))
+				return http.StatusInternalServerError
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			h.lgr.Warn("failed to handle uploaded file", log.KV("file", part.FileName()), log.KVErr(err))
+			return http.StatusBadRequest
+		}
+		return http.StatusOK
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(part, int64(maxBody+1)))
+	if err != nil {
+		h.lgr.Info("got bad file upload", log.KV("address", ip), log.KV("file", part.FileName()), log.KVErr(err))
+		return http.StatusBadRequest
+	} else if len(b) > maxBody {
+		h.lgr.Error("uploaded file too large", log.KV("file", part.FileName()), log.KV("max", maxBody))
+		return http.StatusRequestEntityTooLarge
+	} else if len(b) == 0 {
+		return http.StatusOK
+	}
+	if err = h.handleEntry(rh, b, ip); err != nil {
+		h.lgr.Error("failed to handle entry", log.KV("address", ip), log.KVErr(err))
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,158 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	ndjsonReadBuffer = 64 * 1024
+)
+
+var (
+	ErrNDJSONWithCE        = errors.New("Stream-NDJSON cannot be combined with CloudEvents")
+	ErrNDJSONWithMultipart = errors.New("Stream-NDJSON cannot be combined with multipart uploads")
+	ErrNDJSONWithMultiline = errors.New("Stream-NDJSON and Multiline are mutually exclusive")
+	ErrNDJSONWithHMAC      = errors.New("Stream-NDJSON cannot use hmac authentication, the signature covers the whole body")
+	ErrNDJSONResponseBody  = errors.New("Stream-NDJSON responses cannot reference request body fields")
+	ErrRecordTooLarge      = errors.New("record is larger than Max-Body")
+	ErrInvalidRecord       = errors.New("record is not valid JSON")
+)
+
+// checkNDJSON rejects options which would read the whole body before the records are ingested
+func (v *lst) checkNDJSON() error {
+	if !v.Stream_NDJSON {
+		return nil
+	} else if v.Multiline {
+		return ErrNDJSONWithMultiline
+	} else if v.AuthType == hmacAuth {
+		return ErrNDJSONWithHMAC
+	}
+	if rt, err := newResponseTemplate(v.Response_Code, v.Response_Header, v.Response_Body, v.Tag_Name); err == nil && rt != nil && rt.needBody {
+		return ErrNDJSONResponseBody
+	}
+	return nil
+}
+
+// streaming reports whether any configured listener streams request bodies
+func (c *cfgType) streaming() bool {
+	for _, v := range c.Listener {
+		if v.Stream_NDJSON {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonReader pulls newline delimited JSON records off a request body as they arrive.
+// Only the record being read is held in memory, so the size of the body is not limited,
+// each record is limited to max bytes.
+type ndjsonReader struct {
+	brdr *bufio.Reader
+	max  int
+	line int // line number of the last record returned
+}
+
+func newNDJSONReader(rdr io.Reader, max int) *ndjsonReader {
+	return &ndjsonReader{
+		brdr: bufio.NewReaderSize(rdr, ndjsonReadBuffer),
+		max:  max,
+	}
+}
+
+// next returns the next record, blank lines are skipped.  The record is a fresh slice which
+// the caller may hand off.  Oversized records are discarded up to the end of their line and
+// get ErrRecordTooLarge, io.EOF is returned once the body is exhausted.
+func (nr *ndjsonReader) next() (rec []byte, err error) {
+	for {
+		var over bool
+		rec = rec[:0]
+		for {
+			b, lerr := nr.brdr.ReadSlice('\n')
+			if !over {
+				if len(rec)+len(b) > nr.max+2 {
+					//the line ending is not part of the record, so allow for a CRLF
+					over = true
+					rec = rec[:0]
+				} else {
+					rec = append(rec, b...)
+				}
+			}
+			if lerr == bufio.ErrBufferFull {
+				continue
+			} else if lerr == io.EOF {
+				if len(rec) == 0 && !over {
+					return nil, io.EOF
+				}
+				break
+			} else if lerr != nil {
+				return nil, lerr
+			}
+			break
+		}
+		nr.line++
+		if over {
+			return nil, ErrRecordTooLarge
+		}
+		if rec = bytes.TrimSpace(rec); len(rec) == 0 {
+			continue
+		} else if len(rec) > nr.max {
+			return nil, ErrRecordTooLarge
+		} else if !json.Valid(rec) {
+			return nil, ErrInvalidRecord
+		}
+		return append([]byte(nil), rec...), nil
+	}
+}
+
+// handleNDJSON ingests each record of a newline delimited JSON body as it is read.  Records which
+// arrived before a bad record are kept, the rest of the body is refused.
+func handleNDJSON(h *handler, cfg routeHandler, w http.ResponseWriter, rdr io.Reader, ip net.IP) {
+	debugout("ndjsonhandler\n")
+	nr := newNDJSONReader(rdr, maxBody)
+	var count int
+	for {
+		rec, err := nr.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			h.lgr.Info("bad NDJSON record", log.KV("address", ip), log.KV("listener", cfg.name),
+				log.KV("line", nr.line), log.KV("ingested", count), log.KVErr(err))
+			w.WriteHeader(ndjsonErrorCode(err))
+			return
+		}
+		if err = h.handleEntry(cfg, rec, ip); err != nil {
+			h.lgr.Error("failed to handle entry", log.KV("address", ip), log.KV("line", nr.line), log.KVErr(err))
+			w.WriteHeader(entryErrorCode(err))
+			return
+		}
+		count++
+	}
+	if count == 0 {
+		h.lgr.Info("got an empty post", log.KV("address", ip))
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// ndjsonErrorCode is the response status for a body that could not be read
+func ndjsonErrorCode(err error) int {
+	if errors.Is(err, ErrRecordTooLarge) || errors.Is(err, ErrAPIKeyRequestTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,308 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buger/jsonparser"
+)
+
+var (
+	ErrResponseBadCode   = errors.New("Response-Code must be between 200 and 599")
+	ErrResponseBadHeader = errors.New("Response-Header must be of the form Name: value")
+	ErrResponseBadSub    = errors.New("invalid substitution in response template")
+	ErrResponseUnclosed  = errors.New("unterminated ${ substitution in response template")
+)
+
+// responseTemplate is the reply sent when a request is ingested successfully.
+// Header values and the body may contain substitutions:
+//
+//	${query:name}   URL query parameter
+//	${header:Name}  request header
+//	${path:name}    parameter captured from a parameterized listener URL
+//	${field:path}   field from a JSON request body, using Transform-Field path syntax
+//	${tag}          listener tag
+//	${remote}       address of the connected peer
+//	${time}         current time in RFC3339 format
+//
+// $$ produces a literal $.  Substituted values are escaped when the Content-Type header is JSON.
+type responseTemplate struct {
+	code     int
+	tag      string
+	headers  []responseHeader
+	body     responseText
+	jsonBody bool // escape substitutions in the body as JSON string contents
+	needBody bool // the body template references request body fields
+}
+
+type responseHeader struct {
+	name string
+	val  responseText
+}
+
+type subKind int
+
+const (
+	subLiteral subKind = iota
+	subQuery
+	subHeader
+	subPath
+	subField
+	subTag
+	subRemote
+	subTime
+)
+
+type responsePart struct {
+	kind subKind
+	val  string   // literal text or the query parameter, header, or path parameter name
+	keys []string // body field path
+}
+
+type responseText []responsePart
+
+// responseContext holds the parts of a request available to substitutions
+type responseContext struct {
+	r      *http.Request
+	body   []byte
+	params map[string]string
+}
+
+// newResponseTemplate returns nil if nothing about the response is customized
+func newResponseTemplate(code int, headers []string, body, tag string) (rt *responseTemplate, err error) {
+	if code == 0 && len(headers) == 0 && body == `` {
+		return
+	}
+	if code == 0 {
+		code = http.StatusOK
+	} else if code < 200 || code > 599 {
+		return nil, ErrResponseBadCode
+	}
+	rt = &responseTemplate{
+		code: code,
+		tag:  tag,
+	}
+	for _, h := range headers {
+		idx := strings.IndexByte(h, ':')
+		if idx <= 0 {
+			return nil, ErrResponseBadHeader
+		}
+		rh := responseHeader{
+			name: http.CanonicalHeaderKey(strings.TrimSpace(h[:idx])),
+		}
+		if strings.ContainsAny(rh.name, " \t") {
+			return nil, ErrResponseBadHeader
+		}
+		if rh.val, err = parseResponseText(strings.TrimSpace(h[idx+1:])); err != nil {
+			return nil, fmt.Errorf("Response-Header %s: %w", rh.name, err)
+		}
+		if rh.name == `Content-Type` && strings.Contains(strings.ToLower(h[idx+1:]), `json`) {
+			rt.jsonBody = true
+		}
+		rt.headers = append(rt.headers, rh)
+	}
+	if rt.body, err = parseResponseText(body); err != nil {
+		return nil, fmt.Errorf("Response-Body: %w", err)
+	}
+	for _, p := range rt.body {
+		if p.kind == subField {
+			rt.needBody = true
+		}
+	}
+	for _, h := range rt.headers {
+		for _, p := range h.val {
+			if p.kind == subField {
+				rt.needBody = true
+			}
+		}
+	}
+	return
+}
+
+func parseResponseText(s string) (rt responseText, err error) {
+	var lit strings.Builder
+	for len(s) > 0 {
+		idx := strings.IndexByte(s, '$')
+		if idx < 0 || idx == len(s)-1 {
+			lit.WriteString(s)
+			break
+		}
+		lit.WriteString(s[:idx])
+		s = s[idx:]
+		if s[1] == '$' {
+			lit.WriteByte('$')
+			s = s[2:]
+			continue
+		} else if s[1] != '{' {
+			lit.WriteByte('$')
+			s = s[1:]
+			continue
+		}
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return nil, ErrResponseUnclosed
+		}
+		var p responsePart
+		if p, err = parseSubstitution(s[2:end]); err != nil {
+			return
+		}
+		if lit.Len() > 0 {
+			rt = append(rt, responsePart{kind: subLiteral, val: lit.String()})
+			lit.Reset()
+		}
+		rt = append(rt, p)
+		s = s[end+1:]
+	}
+	if lit.Len() > 0 {
+		rt = append(rt, responsePart{kind: subLiteral, val: lit.String()})
+	}
+	return
+}
+
+func parseSubstitution(v string) (p responsePart, err error) {
+	name, arg := v, ``
+	if idx := strings.IndexByte(v, ':'); idx >= 0 {
+		name, arg = v[:idx], strings.TrimSpace(v[idx+1:])
+	}
+	switch strings.TrimSpace(name) {
+	case `query`:
+		p.kind = subQuery
+	case `header`:
+		p.kind = subHeader
+		arg = http.CanonicalHeaderKey(arg)
+	case `path`:
+		p.kind = subPath
+	case `field`:
+		p.kind = subField
+		if p.keys, err = splitFieldPath(arg); err != nil {
+			err = fmt.Errorf("%w ${%s}", ErrResponseBadSub, v)
+			return
+		}
+	case `tag`:
+		p.kind = subTag
+	case `remote`:
+		p.kind = subRemote
+	case `time`:
+		p.kind = subTime
+	default:
+		err = fmt.Errorf("%w ${%s}", ErrResponseBadSub, v)
+		return
+	}
+	if (p.kind == subQuery || p.kind == subHeader || p.kind == subPath || p.kind == subField) && arg == `` {
+		err = fmt.Errorf("%w ${%s}, missing a name", ErrResponseBadSub, v)
+	} else if (p.kind == subTag || p.kind == subRemote || p.kind == subTime) && arg != `` {
+		err = fmt.Errorf("%w ${%s}, takes no name", ErrResponseBadSub, v)
+	}
+	p.val = arg
+	return
+}
+
+func (rt *responseTemplate) render(txt responseText, rc responseContext, escape bool) string {
+	var sb strings.Builder
+	for _, p := range txt {
+		if p.kind == subLiteral {
+			sb.WriteString(p.val)
+			continue
+		}
+		v := rt.value(p, rc)
+		if escape {
+			if b, err := json.Marshal(v); err == nil && len(b) >= 2 {
+				v = string(b[1 : len(b)-1])
+			}
+		}
+		sb.WriteString(v)
+	}
+	return sb.String()
+}
+
+func (rt *responseTemplate) value(p responsePart, rc responseContext) (v string) {
+	switch p.kind {
+	case subQuery:
+		v = rc.r.URL.Query().Get(p.val)
+	case subHeader:
+		v = rc.r.Header.Get(p.val)
+	case subPath:
+		v = rc.params[p.val]
+	case subField:
+		val, dt, ok, err := lookupField(rc.body, p.keys)
+		if !ok || err != nil || dt == jsonparser.Null {
+			return
+		} else if dt == jsonparser.String {
+			v, _ = jsonparser.ParseString(val)
+		} else {
+			v = string(val)
+		}
+	case subTag:
+		v = rt.tag
+	case subRemote:
+		if ip := getPeerIP(rc.r); ip != nil {
+			v = ip.String()
+		}
+	case subTime:
+		v = time.Now().UTC().Format(time.RFC3339)
+	}
+	return
+}
+
+// write sends the response, it is only called if the request handler did not already respond
+func (rt *responseTemplate) write(w http.ResponseWriter, rc responseContext) {
+	hdr := w.Header()
+	for _, h := range rt.headers {
+		hdr.Set(h.name, rt.render(h.val, rc, false))
+	}
+	body := rt.render(rt.body, rc, rt.jsonBody)
+	if len(body) > 0 {
+		hdr.Set(`Content-Length`, strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(rt.code)
+	if len(body) > 0 {
+		w.Write([]byte(body))
+	}
+}
+
+// responseRecorder notes whether a request handler responded on its own, which it does on errors
+type responseRecorder struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	rr.wrote = true
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wrote = true
+	return rr.ResponseWriter.Write(b)
+}
+
+// cappedBuffer keeps the first max bytes written to it, used to capture request bodies for substitutions
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (cb *cappedBuffer) Write(b []byte) (int, error) {
+	if rem := cb.max - cb.Len(); rem > 0 {
+		if len(b) > rem {
+			cb.Buffer.Write(b[:rem])
+		} else {
+			cb.Buffer.Write(b)
+		}
+	}
+	return len(b), nil
+}
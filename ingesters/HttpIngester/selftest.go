@@ -0,0 +1,81 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+// selfTestTargets builds the preprocessor chain of every listener so the self test exercises
+// the same path that live requests take
+func selfTestTargets(cfg *cfgType, igst *ingest.IngestMuxer) (targets []utils.SelfTestTarget, err error) {
+	add := func(kind, name string, pp []string) error {
+		proc, err := cfg.Preprocessor.ProcessorSet(igst, pp)
+		if err != nil {
+			return fmt.Errorf("%s %s preprocessors: %w", kind, name, err)
+		}
+		targets = append(targets, utils.SelfTestTarget{Name: kind + ` ` + name, Proc: proc})
+		return nil
+	}
+	var names []string
+	for k := range cfg.Listener {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if err = add(`Listener`, k, cfg.Listener[k].Preprocessor); err != nil {
+			return
+		}
+	}
+	names = names[:0]
+	for k := range cfg.HECListener {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if err = add(`HEC-Compatible-Listener`, k, cfg.HECListener[k].Preprocessor); err != nil {
+			return
+		}
+	}
+	names = names[:0]
+	for k := range cfg.KDSListener {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if err = add(`Kinesis-Delivery-Stream-Listener`, k, cfg.KDSListener[k].Preprocessor); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// runSelfTest sends synthetic entries through every listener's preprocessors to the indexers,
+// prints the results, and returns the exit code
+func runSelfTest(cfg *cfgType, igst *ingest.IngestMuxer) int {
+	targets, err := selfTestTargets(cfg, igst)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL: %v\n", err)
+		return 1
+	}
+	tcfg := utils.SelfTestConfig{
+		Ingester: appName,
+		Timeout:  cfg.Timeout(),
+	}
+	if !utils.RunSelfTest(context.Background(), igst, targets, tcfg, os.Stdout) {
+		return 1
+	}
+	return 0
+}
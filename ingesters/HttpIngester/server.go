@@ -0,0 +1,128 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"golang.org/x/net/http2"
+)
+
+const (
+	defaultReadTimeout  = 5 * time.Second
+	defaultWriteTimeout = 5 * time.Second
+	// a streaming upload is read and answered within one request, so it gets much longer defaults
+	defaultStreamTimeout = 15 * time.Minute
+
+	minMaxHeaderSize = 4 * 1024
+	maxMaxHeaderSize = 64 * 1024 * 1024
+)
+
+var (
+	ErrInvalidMaxHeaderSize   = fmt.Errorf("Max-Header-Size must be between %d and %d bytes", minMaxHeaderSize, maxMaxHeaderSize)
+	ErrInvalidMaxStreams      = errors.New("Max-Concurrent-Streams may not be negative")
+	ErrMaxStreamsWithoutHTTP2 = errors.New("Max-Concurrent-Streams requires HTTP/2, which is disabled")
+)
+
+// serverConfig holds the tuning applied to the HTTP server, a zero timeout disables the timeout
+type serverConfig struct {
+	headerTimeout time.Duration
+	readTimeout   time.Duration
+	writeTimeout  time.Duration
+	idleTimeout   time.Duration // zero uses the read timeout
+	maxHeader     int           // zero uses the net/http default
+	maxStreams    uint32        // zero uses the HTTP/2 default
+	http2         bool
+}
+
+func parseServerTimeout(name, v string, def time.Duration) (d time.Duration, err error) {
+	if v = strings.TrimSpace(v); v == `` {
+		d = def
+	} else if d, err = time.ParseDuration(v); err != nil {
+		err = fmt.Errorf("Invalid %s %q: %v", name, v, err)
+	} else if d < 0 {
+		err = fmt.Errorf("Invalid %s %q: may not be negative", name, v)
+	}
+	return
+}
+
+// serverConfig parses the server tuning, streaming is set if any listener streams request bodies.
+// Streaming raises the default read and write timeouts, request headers must still arrive
+// within the usual read timeout.
+func (g gbl) serverConfig(streaming bool) (sc serverConfig, err error) {
+	readDef, writeDef := defaultReadTimeout, defaultWriteTimeout
+	var idleDef time.Duration
+	if streaming {
+		//idle connections would otherwise be held as long as a streaming read
+		readDef, writeDef, idleDef = defaultStreamTimeout, defaultStreamTimeout, defaultReadTimeout
+	}
+	if sc.readTimeout, err = parseServerTimeout(`Read-Timeout`, g.Read_Timeout, readDef); err != nil {
+		return
+	} else if sc.writeTimeout, err = parseServerTimeout(`Write-Timeout`, g.Write_Timeout, writeDef); err != nil {
+		return
+	} else if sc.idleTimeout, err = parseServerTimeout(`Idle-Timeout`, g.Idle_Timeout, idleDef); err != nil {
+		return
+	}
+	if sc.headerTimeout = defaultReadTimeout; sc.readTimeout > 0 && sc.readTimeout < sc.headerTimeout {
+		sc.headerTimeout = sc.readTimeout
+	}
+	if s := strings.TrimSpace(g.Max_Header_Size); s != `` {
+		var sz int64
+		if sz, err = config.ParseDataSize(s); err != nil || sz < minMaxHeaderSize || sz > maxMaxHeaderSize {
+			err = fmt.Errorf("%w, got %q", ErrInvalidMaxHeaderSize, g.Max_Header_Size)
+			return
+		}
+		sc.maxHeader = int(sz)
+	}
+	sc.http2 = !g.Disable_HTTP2
+	if g.Max_Concurrent_Streams < 0 {
+		err = ErrInvalidMaxStreams
+	} else if g.Max_Concurrent_Streams > 0 && !sc.http2 {
+		err = ErrMaxStreamsWithoutHTTP2
+	} else {
+		sc.maxStreams = uint32(g.Max_Concurrent_Streams)
+	}
+	return
+}
+
+// newServer builds the HTTP server, HTTP/2 is negotiated on TLS listeners unless it is disabled.
+// Cleartext listeners only speak HTTP/1.x.
+func (sc serverConfig) newServer(bind string, hnd http.Handler, tcfg *tls.Config) (srv *http.Server, err error) {
+	srv = &http.Server{
+		Addr:              bind,
+		Handler:           hnd,
+		ReadTimeout:       sc.readTimeout,
+		ReadHeaderTimeout: sc.headerTimeout,
+		WriteTimeout:      sc.writeTimeout,
+		IdleTimeout:       sc.idleTimeout,
+		MaxHeaderBytes:    sc.maxHeader,
+		TLSConfig:         tcfg,
+	}
+	if tcfg == nil {
+		return
+	} else if !sc.http2 {
+		//a non-nil empty map keeps net/http from setting up HTTP/2 on its own
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return
+	}
+	h2 := &http2.Server{
+		MaxConcurrentStreams: sc.maxStreams,
+		IdleTimeout:          sc.idleTimeout,
+	}
+	if err = http2.ConfigureServer(srv, h2); err != nil {
+		err = fmt.Errorf("failed to enable HTTP/2: %w", err)
+	}
+	return
+}
@@ -0,0 +1,131 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	tagSourceQuery  = `query`
+	tagSourceHeader = `header`
+	tagSourcePath   = `path`
+)
+
+var (
+	ErrTagSourceInvalid   = errors.New("Tag-Source must be query:<name>, header:<name>, or path:<name>")
+	ErrTagSourceNoParam   = errors.New("Tag-Source path parameter is not captured by the listener URL")
+	ErrTagAllowRequired   = errors.New("Tag-Source requires at least one Tag-Allow entry")
+	ErrTagAllowWithoutSrc = errors.New("Tag-Allow requires Tag-Source")
+	ErrTagSourceScoped    = errors.New("Tag-Source cannot be used with scoped-token authentication, tokens are bound to Tag-Name")
+	ErrTagNotAllowed      = errors.New("requested tag is not allowed")
+)
+
+// tagSelector picks the tag for a request from a query parameter, header, or URL path parameter.  Requested tags
+// must match the allow list, requests which do not name a tag use the listener Tag-Name.
+type tagSelector struct {
+	kind  string
+	name  string
+	allow []string // tag names or path.Match patterns
+	igst  *ingest.IngestMuxer
+}
+
+// parseTagSelector validates the tag source and allow list, returning nil if neither is set
+func parseTagSelector(src string, allow []string) (ts *tagSelector, err error) {
+	if src = strings.TrimSpace(src); src == `` {
+		if len(allow) > 0 {
+			err = ErrTagAllowWithoutSrc
+		}
+		return
+	}
+	idx := strings.IndexByte(src, ':')
+	if idx <= 0 || strings.TrimSpace(src[idx+1:]) == `` {
+		return nil, ErrTagSourceInvalid
+	}
+	ts = &tagSelector{
+		kind: strings.ToLower(strings.TrimSpace(src[:idx])),
+		name: strings.TrimSpace(src[idx+1:]),
+	}
+	switch ts.kind {
+	case tagSourceQuery, tagSourcePath:
+	case tagSourceHeader:
+		ts.name = http.CanonicalHeaderKey(ts.name)
+	default:
+		return nil, ErrTagSourceInvalid
+	}
+	for _, a := range allow {
+		if a = strings.TrimSpace(a); a == `` {
+			continue
+		} else if _, err = path.Match(a, ``); err != nil {
+			return nil, fmt.Errorf("invalid Tag-Allow pattern %q: %v", a, err)
+		}
+		ts.allow = append(ts.allow, a)
+	}
+	if len(ts.allow) == 0 {
+		return nil, ErrTagAllowRequired
+	}
+	return
+}
+
+func (ts *tagSelector) allowed(tag string) bool {
+	for _, a := range ts.allow {
+		if ok, _ := path.Match(a, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPattern makes sure a path tag source names a parameter the listener URL captures
+func (ts *tagSelector) checkPattern(up *urlPattern) error {
+	if ts == nil || ts.kind != tagSourcePath || up.has(ts.name) {
+		return nil
+	}
+	return fmt.Errorf("%w: %q", ErrTagSourceNoParam, ts.name)
+}
+
+// requested returns the tag name the request asks for, if any
+func (ts *tagSelector) requested(r *http.Request, params map[string]string) (v string) {
+	switch ts.kind {
+	case tagSourceQuery:
+		v = r.URL.Query().Get(ts.name)
+	case tagSourceHeader:
+		v = r.Header.Get(ts.name)
+	case tagSourcePath:
+		v = params[ts.name]
+	}
+	return strings.TrimSpace(v)
+}
+
+// tag resolves the tag for a request, ok is false if the request does not name one
+func (ts *tagSelector) tag(r *http.Request, params map[string]string) (tg entry.EntryTag, ok bool, err error) {
+	if ts == nil {
+		return
+	}
+	name := ts.requested(r, params)
+	if name == `` {
+		return
+	} else if err = ingest.CheckTag(name); err != nil {
+		return
+	} else if !ts.allowed(name) {
+		err = fmt.Errorf("%w: %q", ErrTagNotAllowed, name)
+		return
+	}
+	if tg, err = ts.igst.NegotiateTag(name); err == nil {
+		ok = true
+	}
+	return
+}
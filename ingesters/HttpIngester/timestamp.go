@@ -0,0 +1,48 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+// timestampTestGrinder builds a timegrinder the way the named listener does, an empty name
+// gets the custom time formats with the default settings
+func timestampTestGrinder(cfg *cfgType, name string) (*timegrinder.TimeGrinder, error) {
+	if name == `` {
+		return (&lst{}).timeGrinder(cfg.TimeFormat)
+	}
+	v, ok := cfg.Listener[name]
+	if !ok || v == nil {
+		return nil, fmt.Errorf("no listener named %q", name)
+	}
+	return v.timeGrinder(cfg.TimeFormat)
+}
+
+// runTimestampTest shows which time format each sample matches using a listener's time settings,
+// with no samples the formats are listed.  It returns the exit code.
+func runTimestampTest(cfg *cfgType, name string, args []string) int {
+	tg, err := timestampTestGrinder(cfg, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL: %v\n", err)
+		return 1
+	}
+	samples, err := utils.TimestampSamples(args, os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL: %v\n", err)
+		return 1
+	} else if !utils.TestTimestamps(os.Stdout, tg, samples) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,407 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/renameio"
+	"github.com/google/uuid"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	scopedToken authType = `scoped-token`
+
+	tokenPrefix       = `gwt_`
+	tokenBytes        = 32
+	tokenIDParam      = `id`
+	maxTokenRequest   = 64 * 1024
+	maxTokenRateLimit = 1000000
+)
+
+var (
+	ErrTokenExpired       = errors.New("Token expired")
+	ErrTokenNotPermitted  = errors.New("Token is not permitted for this URL or tag")
+	ErrTokenRateLimited   = errors.New("Token rate limit exceeded")
+	ErrTokenNotFound      = errors.New("Token not found")
+	ErrTokenDBRequired    = errors.New("Token-Database is required for scoped-token authentication")
+	ErrTokenAdminSecret   = errors.New("Token-Admin-Secret is required when Token-Admin-URL is set")
+	ErrInvalidTokenScope  = errors.New("Token must be scoped to at least one URL")
+	ErrInvalidTokenExpiry = errors.New("Token expiration must be a duration or RFC3339 timestamp in the future")
+)
+
+// tokenRecord is a single scoped ingest token, the token itself is never stored.
+type tokenRecord struct {
+	ID         string
+	Name       string
+	Hash       string    // hex encoded SHA256 of the token
+	URLs       []string  // URLs the token may post to
+	Tags       []string  `json:",omitempty"` // tags the token may ingest into, empty is any tag on the allowed URLs
+	Expires    time.Time `json:",omitempty"`
+	Rate_Limit float64   `json:",omitempty"` // requests per second, zero is unlimited
+	Created    time.Time
+}
+
+// tokenRequest is what an administrator sends to mint a new token
+type tokenRequest struct {
+	Name       string
+	URLs       []string
+	Tags       []string
+	Expires    string  // duration from now or an RFC3339 timestamp
+	Rate_Limit float64 // requests per second
+}
+
+// tokenResponse is handed back once on creation, it is the only time the token is visible
+type tokenResponse struct {
+	tokenRecord
+	Token string
+}
+
+type tokenDB struct {
+	sync.RWMutex
+	path     string
+	scoped   map[string]string // URLs using scoped tokens mapped to their tag
+	tokens   map[string]*tokenRecord
+	limiters map[string]*rate.Limiter
+}
+
+type tokenDBFile struct {
+	Tokens []tokenRecord
+}
+
+// newTokenDB opens (or creates) the token database at p.  The scoped map contains
+// the listener URLs which accept scoped tokens and the tag each one ingests into.
+func newTokenDB(p string, scoped map[string]string) (db *tokenDB, err error) {
+	db = &tokenDB{
+		path:     p,
+		scoped:   scoped,
+		tokens:   map[string]*tokenRecord{},
+		limiters: map[string]*rate.Limiter{},
+	}
+	var bb []byte
+	if bb, err = os.ReadFile(p); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	var tf tokenDBFile
+	if err = json.Unmarshal(bb, &tf); err != nil {
+		err = fmt.Errorf("Failed to decode token database %s: %v", p, err)
+		return
+	}
+	for i := range tf.Tokens {
+		tr := tf.Tokens[i]
+		db.tokens[tr.Hash] = &tr
+		db.limiters[tr.Hash] = newTokenLimiter(tr.Rate_Limit)
+	}
+	return
+}
+
+func newTokenLimiter(rps float64) *rate.Limiter {
+	if rps <= 0 {
+		return nil
+	}
+	burst := int(rps)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+func hashToken(tok string) string {
+	h := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(h[:])
+}
+
+// authorize checks that the token is valid for the URL and tag
+func (db *tokenDB) authorize(tok, url, tag string) (err error) {
+	hash := hashToken(tok)
+	db.RLock()
+	tr, ok := db.tokens[hash]
+	lmt := db.limiters[hash]
+	db.RUnlock()
+	if !ok {
+		return ErrUnauthorized
+	} else if !tr.Expires.IsZero() && time.Now().After(tr.Expires) {
+		return ErrTokenExpired
+	} else if !inSet(tr.URLs, url) || (len(tr.Tags) > 0 && !inSet(tr.Tags, tag)) {
+		return ErrTokenNotPermitted
+	} else if lmt != nil && !lmt.Allow() {
+		return ErrTokenRateLimited
+	}
+	return
+}
+
+func inSet(set []string, v string) bool {
+	for _, s := range set {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// create validates a token request, mints a new token, and persists the database
+func (db *tokenDB) create(req tokenRequest) (resp tokenResponse, err error) {
+	tr := tokenRecord{
+		ID:         uuid.New().String(),
+		Name:       strings.TrimSpace(req.Name),
+		Tags:       req.Tags,
+		Rate_Limit: req.Rate_Limit,
+		Created:    time.Now().UTC(),
+	}
+	if len(req.URLs) == 0 {
+		err = ErrInvalidTokenScope
+		return
+	}
+	for _, u := range req.URLs {
+		u = path.Clean(u)
+		if !db.isScoped(u) {
+			err = fmt.Errorf("URL %s does not accept scoped tokens", u)
+			return
+		}
+		tr.URLs = append(tr.URLs, u)
+	}
+	for _, t := range tr.Tags {
+		if err = ingest.CheckTag(t); err != nil {
+			return
+		}
+	}
+	if tr.Rate_Limit < 0 || tr.Rate_Limit > maxTokenRateLimit {
+		err = fmt.Errorf("Rate_Limit must be between 0 and %d", maxTokenRateLimit)
+		return
+	}
+	if tr.Expires, err = parseTokenExpiry(req.Expires); err != nil {
+		return
+	}
+	var tok string
+	if tok, err = randToken(); err != nil {
+		return
+	}
+	tr.Hash = hashToken(tok)
+
+	db.Lock()
+	defer db.Unlock()
+	db.tokens[tr.Hash] = &tr
+	db.limiters[tr.Hash] = newTokenLimiter(tr.Rate_Limit)
+	if err = db.saveNoLock(); err != nil {
+		delete(db.tokens, tr.Hash)
+		delete(db.limiters, tr.Hash)
+		return
+	}
+	resp = tokenResponse{
+		tokenRecord: tr,
+		Token:       tok,
+	}
+	return
+}
+
+func (db *tokenDB) isScoped(url string) (ok bool) {
+	db.RLock()
+	_, ok = db.scoped[url]
+	db.RUnlock()
+	return
+}
+
+// addScope allows tokens to be minted for a listener added at runtime
+func (db *tokenDB) addScope(url, tag string) {
+	db.Lock()
+	db.scoped[path.Clean(url)] = tag
+	db.Unlock()
+}
+
+// removeScope stops minting tokens for a listener removed at runtime, existing tokens are left alone
+func (db *tokenDB) removeScope(url string) {
+	db.Lock()
+	delete(db.scoped, path.Clean(url))
+	db.Unlock()
+}
+
+// revoke removes a token by ID
+func (db *tokenDB) revoke(id string) (err error) {
+	db.Lock()
+	defer db.Unlock()
+	for k, v := range db.tokens {
+		if v.ID == id {
+			delete(db.tokens, k)
+			delete(db.limiters, k)
+			if err = db.saveNoLock(); err != nil {
+				db.tokens[k] = v
+				db.limiters[k] = newTokenLimiter(v.Rate_Limit)
+			}
+			return
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// list returns the set of tokens sorted by creation time, hashes are not included
+func (db *tokenDB) list() (r []tokenRecord) {
+	db.RLock()
+	for _, v := range db.tokens {
+		tr := *v
+		tr.Hash = ``
+		r = append(r, tr)
+	}
+	db.RUnlock()
+	sort.Slice(r, func(i, j int) bool { return r[i].Created.Before(r[j].Created) })
+	return
+}
+
+// saveNoLock writes the database, expired tokens are pruned.  Caller must hold the write lock.
+func (db *tokenDB) saveNoLock() (err error) {
+	var tf tokenDBFile
+	now := time.Now()
+	for k, v := range db.tokens {
+		if !v.Expires.IsZero() && now.After(v.Expires) {
+			delete(db.tokens, k)
+			delete(db.limiters, k)
+			continue
+		}
+		tf.Tokens = append(tf.Tokens, *v)
+	}
+	var bb []byte
+	if bb, err = json.MarshalIndent(tf, "", "\t"); err != nil {
+		return
+	}
+	err = renameio.WriteFile(db.path, bb, 0600)
+	return
+}
+
+func parseTokenExpiry(v string) (t time.Time, err error) {
+	if v = strings.TrimSpace(v); v == `` {
+		return //never expires
+	}
+	if d, lerr := time.ParseDuration(v); lerr == nil {
+		if d <= 0 {
+			err = ErrInvalidTokenExpiry
+		} else {
+			t = time.Now().UTC().Add(d)
+		}
+		return
+	}
+	if t, err = time.Parse(time.RFC3339, v); err != nil || !t.After(time.Now()) {
+		err = ErrInvalidTokenExpiry
+	}
+	return
+}
+
+func randToken() (s string, err error) {
+	buff := make([]byte, tokenBytes)
+	if _, err = io.ReadFull(rand.Reader, buff); err == nil {
+		s = tokenPrefix + base64.RawURLEncoding.EncodeToString(buff)
+	}
+	return
+}
+
+// scopedTokenHandler authenticates a single listener against the token database
+type scopedTokenHandler struct {
+	noLogin
+	db  *tokenDB
+	url string
+	tag string
+}
+
+func newScopedTokenHandler(db *tokenDB, url, tag string) (hnd authHandler, err error) {
+	if db == nil {
+		err = ErrTokenDBRequired
+	} else {
+		hnd = &scopedTokenHandler{
+			db:  db,
+			url: path.Clean(url),
+			tag: tag,
+		}
+	}
+	return
+}
+
+func (sth *scopedTokenHandler) AuthRequest(r *http.Request) error {
+	tok, err := getAuthToken(r, defaultTokenName)
+	if err != nil {
+		return err
+	}
+	return sth.db.authorize(tok, sth.url, sth.tag)
+}
+
+// tokenAdminHandler implements the token self-service API
+//
+//	GET lists tokens
+//	POST mints a new token
+//	DELETE revokes a token by id
+type tokenAdminHandler struct {
+	db     *tokenDB
+	secret string
+	lgr    *log.Logger
+}
+
+func (tah *tokenAdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tok, err := getAuthToken(r, defaultTokenName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(tok), []byte(tah.secret)) != 1 {
+		tah.lgr.Info("token admin access denied", log.KV("address", getRemoteIP(r)))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		tah.writeJSON(w, tah.db.list())
+	case http.MethodPost:
+		var req tokenRequest
+		if err = json.NewDecoder(io.LimitReader(r.Body, maxTokenRequest)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, err := tah.db.create(req)
+		if err != nil {
+			tah.lgr.Info("token creation failed", log.KV("address", getRemoteIP(r)), log.KVErr(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tah.lgr.Info("token created", log.KV("address", getRemoteIP(r)), log.KV("id", resp.ID), log.KV("name", resp.Name))
+		w.WriteHeader(http.StatusCreated)
+		tah.writeJSON(w, resp)
+	case http.MethodDelete:
+		id := r.URL.Query().Get(tokenIDParam)
+		if err = tah.db.revoke(id); err == ErrTokenNotFound {
+			w.WriteHeader(http.StatusNotFound)
+		} else if err != nil {
+			tah.lgr.Error("token revocation failed", log.KV("id", id), log.KVErr(err))
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			tah.lgr.Info("token revoked", log.KV("address", getRemoteIP(r)), log.KV("id", id))
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (tah *tokenAdminHandler) writeJSON(w http.ResponseWriter, obj interface{}) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		tah.lgr.Warn("failed to write token response", log.KVErr(err))
+	}
+}
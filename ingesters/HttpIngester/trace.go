@@ -0,0 +1,87 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
+)
+
+var (
+	tracer *tracing.Tracer // nil unless Trace-Endpoint is set
+)
+
+// startTrace starts a trace for the request if it is sampled, every entry in the request
+// hangs its preprocessor and muxer spans off the request span
+func (rh *routeHandler) startTrace(r *http.Request, ip net.IP) {
+	if !tracer.Sample() {
+		return
+	}
+	_, rh.trace = tracer.Start(context.Background(), `listener.accept`, tracing.KindServer,
+		tracing.String(`listener`, rh.name),
+		tracing.String(`remote`, ip.String()),
+		tracing.String(`http.method`, r.Method),
+		tracing.String(`http.target`, r.URL.Path))
+}
+
+// endTrace finishes the request span, a nil span is ignored
+func (rh routeHandler) endTrace(w *trackingRW) {
+	if rh.trace == nil {
+		return
+	}
+	code := w.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	rh.trace.SetAttrs(tracing.Int(`http.status_code`, int64(code)))
+	rh.trace.End()
+}
+
+// process hands an entry to the preprocessors, carrying the request span if there is one.
+// The entry size policy is applied first.
+func (rh routeHandler) process(e *entry.Entry) error {
+	ents, err := rh.chunker.Chunk(e)
+	if err != nil {
+		return err
+	} else if len(ents) > 1 {
+		return rh.sendBatch(ents)
+	}
+	if rh.trace == nil {
+		return rh.pproc.Process(e)
+	}
+	return rh.pproc.ProcessContext(e, tracing.ContextWithSpan(context.Background(), rh.trace))
+}
+
+// processBatch applies the entry size policy to a set of entries and hands them to the preprocessors
+func (rh routeHandler) processBatch(ents []*entry.Entry) error {
+	if rh.chunker != nil {
+		all := make([]*entry.Entry, 0, len(ents))
+		for _, e := range ents {
+			c, err := rh.chunker.Chunk(e)
+			if err != nil {
+				return err
+			}
+			all = append(all, c...)
+		}
+		ents = all
+	}
+	return rh.sendBatch(ents)
+}
+
+// sendBatch hands a set of entries to the preprocessors, carrying the request span if there is one
+func (rh routeHandler) sendBatch(ents []*entry.Entry) error {
+	if rh.trace == nil {
+		return rh.pproc.ProcessBatch(ents)
+	}
+	return rh.pproc.ProcessBatchContext(ents, tracing.ContextWithSpan(context.Background(), rh.trace))
+}
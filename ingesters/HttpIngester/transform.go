@@ -0,0 +1,249 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+)
+
+var (
+	ErrTransformMissingName = errors.New("Transform-Field requires an output name, expected name=expression")
+	ErrTransformEmptyExpr   = errors.New("Transform-Field has an empty expression")
+	ErrTransformBadPath     = errors.New("invalid field path in Transform-Field")
+	ErrTransformBadQuote    = errors.New("unterminated string constant in Transform-Field")
+	ErrTransformBadConcat   = errors.New("Transform-Field terms must be joined with +")
+)
+
+// transformTerm is a single component of a Transform-Field expression,
+// either a path into the request body or a quoted constant.
+type transformTerm struct {
+	keys []string
+	lit  string
+}
+
+func (t transformTerm) isLiteral() bool {
+	return t.keys == nil
+}
+
+// transformField produces a single field in the reshaped entry.
+type transformField struct {
+	name  []string
+	terms []transformTerm
+}
+
+// transformer reshapes JSON request bodies into a new object built from the
+// configured Transform-Field rules. Fields are emitted in configuration order.
+type transformer struct {
+	fields []transformField
+}
+
+// newTransformer compiles a set of Transform-Field rules, a nil transformer
+// is returned when no rules are provided.
+func newTransformer(rules []string) (t *transformer, err error) {
+	if len(rules) == 0 {
+		return
+	}
+	t = &transformer{}
+	for _, r := range rules {
+		var tf transformField
+		if tf, err = parseTransformField(r); err != nil {
+			return nil, fmt.Errorf("%q: %w", r, err)
+		}
+		t.fields = append(t.fields, tf)
+	}
+	return
+}
+
+// parseTransformField parses a rule of the form name=expression where the expression
+// is one or more field paths or quoted constants joined with +, for example:
+//
+//	user=actor.login
+//	summary=repository.name + ": " + action
+//	source="github"
+func parseTransformField(v string) (tf transformField, err error) {
+	idx := strings.IndexByte(v, '=')
+	if idx <= 0 {
+		err = ErrTransformMissingName
+		return
+	}
+	if tf.name, err = splitFieldPath(strings.TrimSpace(v[:idx])); err != nil {
+		return
+	}
+	expr := strings.TrimSpace(v[idx+1:])
+	if expr == `` {
+		err = ErrTransformEmptyExpr
+		return
+	}
+	for len(expr) > 0 {
+		var term transformTerm
+		if expr[0] == '"' {
+			end := closingQuote(expr)
+			if end < 0 {
+				err = ErrTransformBadQuote
+				return
+			}
+			if term.lit, err = strconv.Unquote(expr[:end+1]); err != nil {
+				return
+			}
+			expr = expr[end+1:]
+		} else {
+			end := strings.IndexAny(expr, "+ \t")
+			if end < 0 {
+				end = len(expr)
+			}
+			if term.keys, err = splitFieldPath(expr[:end]); err != nil {
+				return
+			}
+			expr = expr[end:]
+		}
+		tf.terms = append(tf.terms, term)
+		if expr = strings.TrimSpace(expr); expr == `` {
+			break
+		} else if expr[0] != '+' {
+			err = ErrTransformBadConcat
+			return
+		}
+		if expr = strings.TrimSpace(expr[1:]); expr == `` {
+			err = ErrTransformEmptyExpr
+			return
+		}
+	}
+	return
+}
+
+// closingQuote returns the index of the quote terminating the string constant at the start of s
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// splitFieldPath converts a dotted path such as "a.b[0].c" into jsonparser keys
+func splitFieldPath(p string) (keys []string, err error) {
+	p = strings.TrimPrefix(p, `.`)
+	if p == `` {
+		err = ErrTransformBadPath
+		return
+	}
+	keys = []string{}
+	for _, k := range strings.Split(p, `.`) {
+		if idx := strings.IndexByte(k, '['); idx >= 0 {
+			if idx > 0 {
+				keys = append(keys, k[:idx])
+			}
+			for k = k[idx:]; len(k) > 0; {
+				end := strings.IndexByte(k, ']')
+				if k[0] != '[' || end < 0 {
+					return nil, ErrTransformBadPath
+				} else if _, err = strconv.ParseUint(k[1:end], 10, 32); err != nil {
+					return nil, ErrTransformBadPath
+				}
+				keys = append(keys, k[:end+1])
+				k = k[end+1:]
+			}
+		} else if k == `` {
+			return nil, ErrTransformBadPath
+		} else {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+// apply reshapes the body, bodies that are not JSON objects are returned unmodified.
+// Fields referencing paths which are not present in the body are omitted.
+func (t *transformer) apply(b []byte) ([]byte, error) {
+	if t == nil || !isJSONObject(b) {
+		return b, nil
+	}
+	out := []byte(`{}`)
+	for _, f := range t.fields {
+		val, ok, err := f.value(b)
+		if err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		if out, err = jsonparser.Set(out, val, f.name...); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// value resolves a single output field. A lone field path keeps the original JSON type,
+// anything else is concatenated into a string.
+func (f transformField) value(b []byte) (val []byte, ok bool, err error) {
+	if len(f.terms) == 1 && !f.terms[0].isLiteral() {
+		var dt jsonparser.ValueType
+		if val, dt, ok, err = lookupField(b, f.terms[0].keys); !ok || err != nil {
+			return
+		}
+		if dt == jsonparser.String {
+			val = append(append([]byte{'"'}, val...), '"')
+		}
+		return
+	}
+	var sb strings.Builder
+	for _, term := range f.terms {
+		if term.isLiteral() {
+			sb.WriteString(term.lit)
+			ok = true
+			continue
+		}
+		v, dt, found, lerr := lookupField(b, term.keys)
+		if lerr != nil {
+			err = lerr
+			return
+		} else if !found {
+			continue
+		}
+		ok = true
+		if dt == jsonparser.String {
+			var s string
+			if s, err = jsonparser.ParseString(v); err != nil {
+				return
+			}
+			sb.WriteString(s)
+		} else if dt != jsonparser.Null {
+			sb.Write(v)
+		}
+	}
+	if ok {
+		val, err = json.Marshal(sb.String())
+	}
+	return
+}
+
+func lookupField(b []byte, keys []string) (val []byte, dt jsonparser.ValueType, ok bool, err error) {
+	if val, dt, _, err = jsonparser.Get(b, keys...); err == jsonparser.KeyPathNotFoundError {
+		err = nil
+	} else if err == nil {
+		ok = true
+	}
+	return
+}
+
+func isJSONObject(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 1 && b[0] == '{' && b[len(b)-1] == '}'
+}
@@ -0,0 +1,146 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+var (
+	ErrURLPatternBadSegment = errors.New("URL parameters must be whole path segments of the form {name}")
+	ErrURLPatternBadName    = errors.New("URL parameter names may only contain letters, digits, - and _")
+	ErrURLPatternDuplicate  = errors.New("URL parameter name used more than once")
+	ErrURLPatternNoParam    = errors.New("Attach-Metadata path parameter is not captured by the listener URL")
+
+	urlParamNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// urlPattern matches request paths against a listener URL containing {name} segments,
+// for example /collect/{app}/{env}.  Each parameter matches exactly one non-empty segment.
+type urlPattern struct {
+	segs     []urlSegment
+	literals int // number of literal segments, used to prefer the most specific pattern
+}
+
+type urlSegment struct {
+	val   string // literal value or parameter name
+	param bool
+}
+
+// isURLPattern returns true if the URL contains any parameter segments
+func isURLPattern(uri string) bool {
+	return strings.ContainsAny(uri, `{}`)
+}
+
+// parseURLPattern returns nil if the URL is a plain static path
+func parseURLPattern(uri string) (up *urlPattern, err error) {
+	if !isURLPattern(uri) {
+		return
+	}
+	up = &urlPattern{}
+	names := map[string]bool{}
+	for _, s := range splitPath(path.Clean(uri)) {
+		if !strings.ContainsAny(s, `{}`) {
+			up.segs = append(up.segs, urlSegment{val: s})
+			up.literals++
+			continue
+		}
+		if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' || strings.ContainsAny(s[1:len(s)-1], `{}`) {
+			return nil, fmt.Errorf("%w: %q", ErrURLPatternBadSegment, s)
+		}
+		name := s[1 : len(s)-1]
+		if !urlParamNameRe.MatchString(name) {
+			return nil, fmt.Errorf("%w: %q", ErrURLPatternBadName, name)
+		} else if names[name] {
+			return nil, fmt.Errorf("%w: %q", ErrURLPatternDuplicate, name)
+		}
+		names[name] = true
+		up.segs = append(up.segs, urlSegment{val: name, param: true})
+	}
+	return
+}
+
+// normalizeURLPattern replaces parameter names so that patterns which only differ in
+// their parameter names produce the same route and are caught as conflicts
+func normalizeURLPattern(uri string) string {
+	if !isURLPattern(uri) {
+		return uri
+	}
+	segs := splitPath(uri)
+	for i, s := range segs {
+		if len(s) >= 2 && s[0] == '{' && s[len(s)-1] == '}' {
+			segs[i] = `{}`
+		}
+	}
+	return `/` + strings.Join(segs, `/`)
+}
+
+// match returns the captured parameters if the cleaned request path matches the pattern
+func (up *urlPattern) match(uri string) (params map[string]string, ok bool) {
+	segs := splitPath(uri)
+	if len(segs) != len(up.segs) {
+		return
+	}
+	for i, s := range up.segs {
+		if !s.param {
+			if segs[i] != s.val {
+				return nil, false
+			}
+			continue
+		} else if segs[i] == `` {
+			return nil, false
+		}
+		if params == nil {
+			params = make(map[string]string, len(up.segs)-up.literals)
+		}
+		params[s.val] = segs[i]
+	}
+	return params, true
+}
+
+// has returns true if the pattern captures the named parameter
+func (up *urlPattern) has(name string) bool {
+	if up == nil {
+		return false
+	}
+	for _, s := range up.segs {
+		if s.param && s.val == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPathMetadata makes sure every path:<name> metadata item names a captured parameter
+func (v *lst) checkPathMetadata(up *urlPattern) error {
+	pfx := processors.MetadataPathParam
+	for _, m := range v.Attach_Metadata {
+		m = strings.TrimSpace(m)
+		if len(m) <= len(pfx) || !strings.EqualFold(m[:len(pfx)], pfx) {
+			continue
+		}
+		if name := strings.TrimSpace(m[len(pfx):]); !up.has(name) {
+			return fmt.Errorf("%w: %q", ErrURLPatternNoParam, name)
+		}
+	}
+	return nil
+}
+
+func splitPath(uri string) []string {
+	if uri = strings.Trim(uri, `/`); uri == `` {
+		return nil
+	}
+	return strings.Split(uri, `/`)
+}
@@ -0,0 +1,71 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestMatchPatternPrecedence(t *testing.T) {
+	h := &handler{mp: map[route]routeHandler{}}
+	add := func(method, uri string, tag entry.EntryTag) {
+		rh := routeHandler{tag: tag}
+		var err error
+		if rh.pattern, err = parseURLPattern(uri); err != nil {
+			t.Fatal(err)
+		}
+		h.mp[route{method: method, uri: uri}] = rh
+	}
+	add(`POST`, `/collect/{app}/{env}`, 1)
+	add(`POST`, `/collect/{app}/prod`, 2)
+	add(`POST`, `/collect/web/{env}`, 3)
+	add(`POST`, `/collect/web/prod`, 4) //static
+	add(`PUT`, `/collect/{app}/{env}`, 5)
+	add(`POST`, `/{a}/{b}/{c}`, 6)
+
+	tests := []struct {
+		method string
+		uri    string
+		tag    entry.EntryTag
+		params map[string]string
+		miss   bool
+	}{
+		{method: `POST`, uri: `/collect/web/prod`, tag: 4},
+		{method: `POST`, uri: `/collect/api/dev`, tag: 1, params: map[string]string{`app`: `api`, `env`: `dev`}},
+		{method: `POST`, uri: `/collect/api/prod`, tag: 2, params: map[string]string{`app`: `api`}},
+		{method: `POST`, uri: `/collect/web/dev`, tag: 3, params: map[string]string{`env`: `dev`}},
+		{method: `PUT`, uri: `/collect/api/dev`, tag: 5, params: map[string]string{`app`: `api`, `env`: `dev`}},
+		{method: `POST`, uri: `/other/web/dev`, tag: 6, params: map[string]string{`a`: `other`, `b`: `web`, `c`: `dev`}},
+		{method: `GET`, uri: `/collect/api/dev`, miss: true},
+		{method: `POST`, uri: `/collect/api`, miss: true},
+		{method: `POST`, uri: `/collect//dev`, miss: true},
+	}
+	for _, tc := range tests {
+		rh, ok := h.lookupNoLock(route{method: tc.method, uri: tc.uri})
+		if ok == tc.miss {
+			t.Fatalf("%s %s: match %v", tc.method, tc.uri, ok)
+		} else if tc.miss {
+			continue
+		} else if rh.tag != tc.tag {
+			t.Fatalf("%s %s: matched %d, expected %d", tc.method, tc.uri, rh.tag, tc.tag)
+		} else if !reflect.DeepEqual(rh.params, tc.params) {
+			t.Fatalf("%s %s: bad params %v != %v", tc.method, tc.uri, rh.params, tc.params)
+		}
+	}
+
+	//equally specific patterns are broken by the URL so the choice does not depend on map order
+	for i := 0; i < 16; i++ {
+		if rh, ok := h.matchPatternNoLock(route{method: `POST`, uri: `/collect/web/prod`}); !ok || rh.tag != 3 {
+			t.Fatalf("bad tie break: %v %d", ok, rh.tag)
+		}
+	}
+}
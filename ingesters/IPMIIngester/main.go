@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
@@ -165,7 +166,7 @@ func main() {
 		CachePath:          cfg.Global.Ingest_Cache_Path,
 		CacheSize:          cfg.Global.Max_Ingest_Cache,
 		CacheMode:          cfg.Global.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Global.Log_Source_Override),
+		LogSourceOverride:  cfg.Global.LogSourceOverride(),
 	}
 	igst, err = ingest.NewUniformMuxer(igCfg)
 	if err != nil {
@@ -203,15 +204,13 @@ func main() {
 		var src net.IP
 
 		if v.Source_Override != `` {
-			src = net.ParseIP(v.Source_Override)
-			if src == nil {
-				lg.FatalCode(0, "Source-Override is invalid", log.KV("sourceoverride", v.Source_Override), log.KV("listener", k))
+			if src, err = config.ParseSourceOverride(v.Source_Override); err != nil {
+				lg.FatalCode(0, "Source-Override is invalid", log.KV("sourceoverride", v.Source_Override), log.KV("listener", k), log.KVErr(err))
 			}
 		} else if cfg.Global.Source_Override != `` {
 			// global override
-			src = net.ParseIP(cfg.Global.Source_Override)
-			if src == nil {
-				lg.FatalCode(0, "Global Source-Override is invalid", log.KV("sourceoverride", cfg.Global.Source_Override))
+			if src, err = config.ParseSourceOverride(cfg.Global.Source_Override); err != nil {
+				lg.FatalCode(0, "Global Source-Override is invalid", log.KV("sourceoverride", cfg.Global.Source_Override), log.KVErr(err))
 			}
 		}
 
@@ -238,6 +237,7 @@ func main() {
 			if hcfg.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
 				lg.FatalCode(0, "preprocessor construction error", log.KVErr(err))
 			}
+			hcfg.proc.SetLatencyBudget(cfg.Global.PreprocessorMaxLatency(), 0)
 
 			ipmiConns[k+x] = hcfg
 		}
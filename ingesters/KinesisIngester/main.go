@@ -24,6 +24,7 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
@@ -180,7 +181,7 @@ func main() {
 		CachePath:          cfg.Global.Ingest_Cache_Path,
 		CacheSize:          cfg.Global.Max_Ingest_Cache,
 		CacheMode:          cfg.Global.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Global.Log_Source_Override),
+		LogSourceOverride:  cfg.Global.LogSourceOverride(),
 	}
 	igst, err := ingest.NewUniformMuxer(ingestConfig)
 	if err != nil {
@@ -332,9 +333,8 @@ func main() {
 				var src net.IP
 				if cfg.Global.Source_Override != `` {
 					// global override
-					src = net.ParseIP(cfg.Global.Source_Override)
-					if src == nil {
-						lg.Fatal("Global Source-Override is invalid")
+					if src, err = config.ParseSourceOverride(cfg.Global.Source_Override); err != nil {
+						lg.Fatal("Global Source-Override is invalid", log.KVErr(err))
 					}
 				}
 
@@ -343,6 +343,7 @@ func main() {
 				if err != nil {
 					lg.Fatal("preprocessor construction error", log.KVErr(err))
 				}
+				procset.SetLatencyBudget(cfg.Global.PreprocessorMaxLatency(), 0)
 
 				// make the shardMetrics and add it to the array
 				tracker := shardMetrics{}
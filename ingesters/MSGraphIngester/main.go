@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
@@ -148,7 +149,7 @@ func main() {
 		CachePath:          cfg.Global.Ingest_Cache_Path,
 		CacheSize:          cfg.Global.Max_Ingest_Cache,
 		CacheMode:          cfg.Global.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Global.Log_Source_Override),
+		LogSourceOverride:  cfg.Global.LogSourceOverride(),
 	}
 	igst, err := ingest.NewUniformMuxer(ingestConfig)
 	if err != nil {
@@ -185,9 +186,8 @@ func main() {
 	// get the src we'll attach to entries
 	if cfg.Global.Source_Override != `` {
 		// global override
-		src = net.ParseIP(cfg.Global.Source_Override)
-		if src == nil {
-			lg.FatalCode(0, "Global Source-Override is invalid")
+		if src, err = config.ParseSourceOverride(cfg.Global.Source_Override); err != nil {
+			lg.FatalCode(0, "Global Source-Override is invalid", log.KVErr(err))
 		}
 	}
 
@@ -215,6 +215,7 @@ func main() {
 		if err != nil {
 			lg.Fatal("preprocessor failure", log.KVErr(err))
 		}
+		procset.SetLatencyBudget(cfg.Global.PreprocessorMaxLatency(), 0)
 
 		// set up time extraction rules
 		tcfg := timegrinder.Config{
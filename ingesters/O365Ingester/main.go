@@ -24,6 +24,7 @@ import (
 
 	"github.com/floren/o365"
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
@@ -146,7 +147,7 @@ func main() {
 		CachePath:          cfg.Global.Ingest_Cache_Path,
 		CacheSize:          cfg.Global.Max_Ingest_Cache,
 		CacheMode:          cfg.Global.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Global.Log_Source_Override),
+		LogSourceOverride:  cfg.Global.LogSourceOverride(),
 	}
 	igst, err := ingest.NewUniformMuxer(ingestConfig)
 	if err != nil {
@@ -184,9 +185,8 @@ func main() {
 	var src net.IP
 	if cfg.Global.Source_Override != `` {
 		// global override
-		src = net.ParseIP(cfg.Global.Source_Override)
-		if src == nil {
-			lg.FatalCode(0, "Global Source-Override is invalid")
+		if src, err = config.ParseSourceOverride(cfg.Global.Source_Override); err != nil {
+			lg.FatalCode(0, "Global Source-Override is invalid", log.KVErr(err))
 		}
 	}
 
@@ -253,6 +253,7 @@ func main() {
 			if err != nil {
 				lg.Fatal("preprocessor failure", log.KVErr(err))
 			}
+			procset.SetLatencyBudget(cfg.Global.PreprocessorMaxLatency(), 0)
 
 			// we'll do a sliding window, they warn it can take a long time for some logs to show up
 			for running {
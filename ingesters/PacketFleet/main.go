@@ -207,7 +207,7 @@ func main() {
 		CachePath:          cfg.Global.Ingest_Cache_Path,
 		CacheSize:          cfg.Global.Max_Ingest_Cache,
 		CacheMode:          cfg.Global.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Global.Log_Source_Override),
+		LogSourceOverride:  cfg.Global.LogSourceOverride(),
 	}
 	igst, err = ingest.NewUniformMuxer(igCfg)
 	if err != nil {
@@ -248,15 +248,13 @@ func main() {
 		var src net.IP
 
 		if v.Source_Override != `` {
-			src = net.ParseIP(v.Source_Override)
-			if src == nil {
-				lg.Fatal("invalid Source-Override", log.KV("sourceoverride", v.Source_Override))
+			if src, err = config.ParseSourceOverride(v.Source_Override); err != nil {
+				lg.Fatal("invalid Source-Override", log.KV("sourceoverride", v.Source_Override), log.KVErr(err))
 			}
 		} else if cfg.Global.Source_Override != `` {
 			// global override
-			src = net.ParseIP(cfg.Global.Source_Override)
-			if src == nil {
-				lg.Fatal("invalid Global Source-Override", log.KV("sourceoverride", cfg.Global.Source_Override))
+			if src, err = config.ParseSourceOverride(cfg.Global.Source_Override); err != nil {
+				lg.Fatal("invalid Global Source-Override", log.KV("sourceoverride", cfg.Global.Source_Override), log.KVErr(err))
 			}
 		}
 
@@ -284,6 +282,7 @@ func main() {
 		if hcfg.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
 			lg.Fatal("preprocessor error", log.KV("handler", k), log.KV("preprocessor", v.Preprocessor), log.KVErr(err))
 		}
+		hcfg.proc.SetLatencyBudget(cfg.Global.PreprocessorMaxLatency(), 0)
 
 		// Load client cert
 		cert, err := tls.LoadX509KeyPair(hcfg.clientCert, hcfg.clientKey)
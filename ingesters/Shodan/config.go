@@ -35,6 +35,7 @@ type readerType int
 type cfgType struct {
 	Global struct {
 		config.IngestConfig
+		config.HTTPClientConfig
 		State_Store_Location string
 		Batching             bool
 		Label                string
@@ -93,6 +94,9 @@ func verifyConfig(c cfgType) error {
 	if len(c.ShodanAccount) == 0 {
 		return errors.New("At least one Shodan account required.")
 	}
+	if err := c.Global.VerifyHTTPClient(); err != nil {
+		return err
+	}
 
 	for _, acct := range c.ShodanAccount {
 		if acct.Tag_Name == `` && acct.Module_Tags_Prefix == `` {
@@ -21,7 +21,6 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -36,6 +35,7 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
 	"github.com/gravwell/gravwell/v3/ingesters/version"
 )
 
@@ -69,6 +69,7 @@ type shodanStream struct {
 	eChan            chan *entry.Entry
 	die              chan bool
 	firehose         bool
+	cli              *http.Client
 }
 
 func init() {
@@ -136,7 +137,7 @@ func main() {
 		CacheSize:          cfg.Global.Max_Ingest_Cache,
 		CacheMode:          cfg.Global.Cache_Mode,
 		Logger:             lg,
-		LogSourceOverride:  net.ParseIP(cfg.Global.Log_Source_Override),
+		LogSourceOverride:  cfg.Global.LogSourceOverride(),
 	}
 
 	igst, err := ingest.NewUniformMuxer(ingestConfig)
@@ -168,6 +169,11 @@ func main() {
 		return
 	}
 
+	cli, err := utils.NewHTTPClient(cfg.Global.HTTPClientConfig)
+	if err != nil {
+		lg.FatalCode(0, "Failed to create HTTP client", log.KVErr(err))
+	}
+
 	var streams []shodanStream
 	for name, acct := range cfg.ShodanAccount {
 		if acct == nil {
@@ -192,6 +198,7 @@ func main() {
 			eChan:      make(chan *entry.Entry, 2048),
 			die:        make(chan bool, 1),
 			firehose:   acct.Full_Firehose,
+			cli:        cli,
 		}
 		if acct.Module_Tags_Prefix != `` {
 			newacct.moduleTagPrefix = acct.Module_Tags_Prefix
@@ -226,8 +233,9 @@ func main() {
 // a channel for ingestion.
 func (shodan *shodanStream) streamReader(wg *sync.WaitGroup, igst *ingest.IngestMuxer) {
 	tagMap := make(map[string]entry.EntryTag)
+	//module names come straight from the stream, map them into valid tag names
+	tagNames, _ := ingest.NewTagSanitizer(ingest.DefaultTagReplacement)
 
-	cli := &http.Client{}
 	defer wg.Done()
 	defer close(shodan.eChan)
 outerLoop:
@@ -248,7 +256,7 @@ outerLoop:
 		}
 		req.Header.Set("User-Agent", userAgent)
 
-		res, err := cli.Do(req)
+		res, err := shodan.cli.Do(req)
 		if err != nil {
 			lg.Error("can't execute HTTP request", log.KVErr(err))
 			igst.Error("can't execute HTTP request", log.KVErr(err))
@@ -295,7 +303,7 @@ outerLoop:
 						if _, ok := shodan.extractedModules[modName]; ok || shodan.extractAll {
 							// If the module was on the list of modules we wish to
 							// extract, build a tag for it
-							tagString := shodan.moduleTagPrefix + modName
+							tagString, _ := tagNames.Tag(shodan.moduleTagPrefix + modName)
 							// Attempt to look this up in the existing tags
 							if tg, ok := tagMap[tagString]; ok {
 								tag = tg
@@ -0,0 +1,73 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"net"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+)
+
+var (
+	ErrBackpressureStreamOnly = errors.New("Backpressure requires a tcp, tls, or unix Bind-String")
+
+	bpGauge *netframe.Gauge
+)
+
+// backpressureConfig is the optional [Backpressure] section, it sets how full the ingest queue
+// must get before listeners with a Backpressure mode push back and how far it must drain
+// before they let go.  Values are percentages of the queue capacity.
+type backpressureConfig struct {
+	High_Water int
+	Low_Water  int
+}
+
+func (bc backpressureConfig) Validate() error {
+	if bc.High_Water == 0 && bc.Low_Water == 0 {
+		return nil
+	}
+	high, low := bc.High_Water, bc.Low_Water
+	if high == 0 {
+		high = netframe.DefaultHighWater
+	}
+	if low == 0 {
+		low = netframe.DefaultLowWater
+	}
+	return netframe.CheckWaterMarks(high, low)
+}
+
+func newBackpressureGauge(bc backpressureConfig, igst *ingest.IngestMuxer) (*netframe.Gauge, error) {
+	return netframe.NewGauge(igst.QueueDepth, bc.High_Water, bc.Low_Water, func(engaged bool) {
+		queued, capacity := igst.QueueDepth()
+		if engaged {
+			lg.Warn("ingest queue saturated, listeners are pushing back", log.KV("queued", queued), log.KV("capacity", capacity))
+		} else {
+			lg.Info("ingest queue drained, listeners resumed", log.KV("queued", queued), log.KV("capacity", capacity), log.KV("rejected", bpGauge.Rejected()))
+		}
+	})
+}
+
+func (l base) validateBackpressure(tp netframe.BindType) error {
+	mode, err := netframe.ParseBackpressureMode(l.Backpressure)
+	if err != nil {
+		return err
+	} else if mode != netframe.BackpressureNone && (!tp.Stream() || tp.FIFO()) {
+		return ErrBackpressureStreamOnly
+	}
+	return nil
+}
+
+// backpressure wraps a stream listener with its Backpressure mode, the config has already been validated
+func (l base) backpressure(ln net.Listener) net.Listener {
+	mode, _ := netframe.ParseBackpressureMode(l.Backpressure)
+	return bpGauge.Listener(ln, mode)
+}
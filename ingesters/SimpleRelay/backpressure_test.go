@@ -0,0 +1,49 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestBackpressureValidate(t *testing.T) {
+	good := []base{
+		{Bind_String: `tcp://0.0.0.0:601`, Backpressure: `block`},
+		{Bind_String: `tls://0.0.0.0:6514`, Backpressure: `pause`},
+		{Bind_String: `unix:///tmp/relay.sock`, Backpressure: `reject`},
+		{Bind_String: `udp://0.0.0.0:514`, Backpressure: `none`},
+		{Bind_String: `udp://0.0.0.0:514`},
+	}
+	for _, b := range good {
+		if err := b.Validate(); err != nil {
+			t.Fatalf("%+v failed: %v", b, err)
+		}
+	}
+	bad := []base{
+		{Bind_String: `udp://0.0.0.0:514`, Backpressure: `block`},
+		{Bind_String: `unixgram:///dev/log`, Backpressure: `pause`},
+		{Bind_String: `fifo:///tmp/relay.fifo`, Backpressure: `reject`},
+		{Bind_String: `tcp://0.0.0.0:601`, Backpressure: `drop`},
+	}
+	for _, b := range bad {
+		if err := b.Validate(); err == nil {
+			t.Fatalf("%+v did not fail", b)
+		}
+	}
+
+	if err := (backpressureConfig{}).Validate(); err != nil {
+		t.Fatal(err)
+	} else if err = (backpressureConfig{High_Water: 80}).Validate(); err != nil {
+		t.Fatal(err)
+	} else if err = (backpressureConfig{Low_Water: 95}).Validate(); err == nil {
+		t.Fatal("low water above the default high water accepted")
+	} else if err = (backpressureConfig{High_Water: 60, Low_Water: 60}).Validate(); err == nil {
+		t.Fatal("equal water marks accepted")
+	}
+}
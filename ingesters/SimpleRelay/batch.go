@@ -0,0 +1,201 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
+)
+
+const (
+	defaultBatchSize    = 1024
+	defaultBatchLatency = 100 * time.Millisecond
+	maxBatchSize        = 64 * 1024
+	minBatchLatency     = time.Millisecond
+	maxBatchLatency     = 10 * time.Second
+)
+
+var (
+	ErrBatcherClosed       = errors.New("batcher is closed")
+	ErrInvalidBatchSize    = fmt.Errorf("Batch-Size must be between 0 and %d", maxBatchSize)
+	ErrInvalidBatchLatency = fmt.Errorf("Batch-Latency must be between %v and %v", minBatchLatency, maxBatchLatency)
+)
+
+// entProcessor is the path handlers push entries into, it is either a preprocessor set
+// or a batcher sitting in front of one.
+type entProcessor interface {
+	ProcessContext(*entry.Entry, context.Context) error
+	Close() error
+}
+
+// batchConfig returns the batching parameters for a listener, batching is enabled
+// when either Batch-Size or Batch-Latency is set and unset values take defaults.
+func (l base) batchConfig() (enabled bool, size int, latency time.Duration, err error) {
+	if l.Batch_Size < 0 || l.Batch_Size > maxBatchSize {
+		err = ErrInvalidBatchSize
+		return
+	}
+	if s := strings.TrimSpace(l.Batch_Latency); s != `` {
+		if latency, err = time.ParseDuration(s); err != nil {
+			err = fmt.Errorf("Invalid Batch-Latency %q: %v", s, err)
+			return
+		} else if latency < minBatchLatency || latency > maxBatchLatency {
+			err = ErrInvalidBatchLatency
+			return
+		}
+	}
+	if l.Batch_Size <= 1 && latency == 0 {
+		return //not enabled
+	}
+	enabled, size = true, l.Batch_Size
+	if size <= 1 {
+		size = defaultBatchSize
+	}
+	if latency == 0 {
+		latency = defaultBatchLatency
+	}
+	return
+}
+
+// newEntProcessor wraps the preprocessor set in a batcher if the listener asks for it
+func (l base) newEntProcessor(name string, proc *processors.ProcessorSet, ctx context.Context) (entProcessor, error) {
+	enabled, size, latency, err := l.batchConfig()
+	if err != nil {
+		return nil, err
+	} else if !enabled {
+		return proc, nil
+	}
+	debugout("Listener %s batching %d entries or %v\n", name, size, latency)
+	return newBatcher(name, proc, size, latency, ctx), nil
+}
+
+// batcher accumulates entries from every connection on a listener and hands them to the
+// preprocessors as a batch once the batch is full or the oldest entry hits the latency limit.
+type batcher struct {
+	sync.Mutex
+	name    string
+	proc    *processors.ProcessorSet
+	ctx     context.Context
+	size    int
+	latency time.Duration
+	ents    []*entry.Entry
+	oldest  time.Time
+	span    *tracing.Span // times the wait of the first traced entry in the batch
+	closed  bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newBatcher(name string, proc *processors.ProcessorSet, size int, latency time.Duration, ctx context.Context) *batcher {
+	b := &batcher{
+		name:    name,
+		proc:    proc,
+		ctx:     ctx,
+		size:    size,
+		latency: latency,
+		ents:    make([]*entry.Entry, 0, size),
+		done:    make(chan struct{}),
+	}
+	b.wg.Add(1)
+	go b.routine()
+	return b
+}
+
+// ProcessContext adds an entry to the batch, flushing it if the batch is full
+func (b *batcher) ProcessContext(ent *entry.Entry, ctx context.Context) (err error) {
+	if ent == nil {
+		return
+	}
+	b.Lock()
+	if b.closed {
+		err = ErrBatcherClosed
+	} else {
+		if len(b.ents) == 0 {
+			b.oldest = time.Now()
+		}
+		if b.span == nil {
+			//the batch is handed on as a unit, so only one trace gets to follow it
+			b.span = tracing.SpanFromContext(ctx).Child(`batch.wait`, tracing.KindInternal, tracing.String(`listener`, b.name))
+		}
+		if b.ents = append(b.ents, ent); len(b.ents) >= b.size {
+			err = b.flush(ctx)
+		}
+	}
+	b.Unlock()
+	return
+}
+
+// flush hands the current batch to the preprocessors, caller must hold the lock
+func (b *batcher) flush(ctx context.Context) (err error) {
+	if len(b.ents) == 0 {
+		return
+	}
+	if b.span != nil {
+		b.span.SetAttrs(tracing.Int(`entries`, int64(len(b.ents))))
+		b.span.End()
+		ctx = tracing.ContextWithSpan(ctx, b.span)
+		b.span = nil
+	}
+	err = b.proc.ProcessBatchContext(b.ents, ctx)
+	//the set was handed off, so we need a new slice
+	b.ents = make([]*entry.Entry, 0, b.size)
+	return
+}
+
+func (b *batcher) routine() {
+	defer b.wg.Done()
+	//tick at half the latency so no entry waits much longer than the limit
+	tckr := time.NewTicker(b.latency / 2)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-tckr.C:
+			b.Lock()
+			if len(b.ents) > 0 && time.Since(b.oldest) >= b.latency {
+				if err := b.flush(b.ctx); err != nil {
+					lg.Warn("failed to flush entry batch", log.KV("listener", b.name), log.KVErr(err))
+				}
+			}
+			b.Unlock()
+		}
+	}
+}
+
+// Close flushes any outstanding entries and closes the underlying preprocessors
+func (b *batcher) Close() (err error) {
+	b.Lock()
+	if b.closed {
+		b.Unlock()
+		return ErrBatcherClosed
+	}
+	b.closed = true
+	close(b.done)
+	b.Unlock()
+	b.wg.Wait()
+
+	b.Lock()
+	//the listener context is likely cancelled by now, don't let it eat the last batch
+	err = b.flush(context.Background())
+	b.Unlock()
+	if lerr := b.proc.Close(); lerr != nil {
+		err = addError(lerr, err)
+	}
+	return
+}
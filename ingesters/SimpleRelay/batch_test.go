@@ -0,0 +1,121 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+type batchWriter struct {
+	sync.Mutex
+	batches []int
+	total   int
+}
+
+func (bw *batchWriter) WriteEntry(ent *entry.Entry) error {
+	return bw.WriteBatch([]*entry.Entry{ent})
+}
+
+func (bw *batchWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return bw.WriteBatch([]*entry.Entry{ent})
+}
+
+func (bw *batchWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return bw.WriteBatch(ents)
+}
+
+func (bw *batchWriter) WriteBatch(ents []*entry.Entry) error {
+	bw.Lock()
+	bw.batches = append(bw.batches, len(ents))
+	bw.total += len(ents)
+	bw.Unlock()
+	return nil
+}
+
+func (bw *batchWriter) counts() (batches, total int) {
+	bw.Lock()
+	batches, total = len(bw.batches), bw.total
+	bw.Unlock()
+	return
+}
+
+func TestBatchConfig(t *testing.T) {
+	if en, _, _, err := (base{}).batchConfig(); err != nil || en {
+		t.Fatalf("batching enabled by default: %v %v", en, err)
+	}
+	if en, sz, lat, err := (base{Batch_Size: 10}).batchConfig(); err != nil || !en || sz != 10 || lat != defaultBatchLatency {
+		t.Fatalf("bad size only config: %v %d %v %v", en, sz, lat, err)
+	}
+	if en, sz, lat, err := (base{Batch_Latency: `5ms`}).batchConfig(); err != nil || !en || sz != defaultBatchSize || lat != 5*time.Millisecond {
+		t.Fatalf("bad latency only config: %v %d %v %v", en, sz, lat, err)
+	}
+	for _, b := range []base{
+		base{Batch_Size: -1},
+		base{Batch_Size: maxBatchSize + 1},
+		base{Batch_Latency: `soon`},
+		base{Batch_Latency: `1us`},
+		base{Batch_Latency: `1h`},
+	} {
+		if _, _, _, err := b.batchConfig(); err == nil {
+			t.Fatalf("failed to catch bad batch config %+v", b)
+		}
+	}
+}
+
+func TestBatcher(t *testing.T) {
+	var bw batchWriter
+	ctx, cf := context.WithCancel(context.Background())
+	b := newBatcher(`test`, processors.NewProcessorSet(&bw), 4, 20*time.Millisecond, ctx)
+
+	//fill exactly one batch
+	for i := 0; i < 4; i++ {
+		if err := b.ProcessContext(&entry.Entry{Data: []byte(`test`)}, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if batches, total := bw.counts(); batches != 1 || total != 4 {
+		t.Fatalf("full batch not flushed: %d %d", batches, total)
+	}
+
+	//a partial batch goes out on the latency timer
+	if err := b.ProcessContext(&entry.Entry{Data: []byte(`test`)}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if batches, total := bw.counts(); batches == 2 && total == 5 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("partial batch not flushed: %d %d", batches, total)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	//close must push the last partial batch even with a cancelled context
+	if err := b.ProcessContext(&entry.Entry{Data: []byte(`test`)}, ctx); err != nil {
+		t.Fatal(err)
+	}
+	cf()
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	} else if _, total := bw.counts(); total != 6 {
+		t.Fatalf("close did not flush: %d", total)
+	}
+	if err := b.ProcessContext(&entry.Entry{}, context.Background()); err != ErrBatcherClosed {
+		t.Fatalf("write after close: %v", err)
+	} else if err = b.Close(); err != ErrBatcherClosed {
+		t.Fatalf("double close: %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+)
+
+const (
+	certHandshakeTimeout = 10 * time.Second
+)
+
+var (
+	ErrCertMapWithoutTLS   = errors.New("Client-Cert-Map requires a tls Bind-String and Client-CA-File")
+	ErrCertMapOnlyWithout  = errors.New("Client-Cert-Map-Only requires Client-Cert-Map")
+	ErrInvalidCertMap      = errors.New("Invalid Client-Cert-Map, must be fingerprint,source,tag")
+	ErrInvalidFingerprint  = errors.New("Invalid certificate fingerprint, must be a hex encoded SHA-256 digest")
+	ErrDuplicateCertMap    = errors.New("Duplicate Client-Cert-Map fingerprint")
+	ErrUnmappedCertificate = errors.New("client certificate is not in Client-Cert-Map")
+)
+
+// certMapping is a single Client-Cert-Map entry, either the source or tag may be empty
+type certMapping struct {
+	fingerprint string
+	src         net.IP
+	tag         string
+}
+
+// normalizeFingerprint accepts SHA-256 fingerprints in upper or lower case hex with or without
+// colon separators, which covers both openssl x509 -fingerprint and sha256sum style output
+func normalizeFingerprint(s string) (fp string, err error) {
+	fp = strings.ToLower(strings.Replace(strings.TrimSpace(s), `:`, ``, -1))
+	if b, lerr := hex.DecodeString(fp); lerr != nil || len(b) != sha256.Size {
+		err = fmt.Errorf("%w: %q", ErrInvalidFingerprint, s)
+	}
+	return
+}
+
+// certFingerprint returns the normalized SHA-256 fingerprint of a certificate
+func certFingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func (l *listener) certMappings() (mps []certMapping, err error) {
+	if len(l.Client_Cert_Map) == 0 {
+		if l.Client_Cert_Map_Only {
+			err = ErrCertMapOnlyWithout
+		}
+		return
+	}
+	if tp, _, lerr := netframe.ParseBind(l.Bind_String); lerr != nil || !tp.TLS() || l.Client_CA_File == `` {
+		err = ErrCertMapWithoutTLS
+		return
+	}
+	seen := map[string]bool{}
+	for _, v := range l.Client_Cert_Map {
+		flds := strings.Split(v, `,`)
+		if len(flds) != 3 {
+			err = fmt.Errorf("%w: %q", ErrInvalidCertMap, v)
+			return
+		}
+		var mp certMapping
+		if mp.fingerprint, err = normalizeFingerprint(flds[0]); err != nil {
+			return
+		} else if seen[mp.fingerprint] {
+			err = fmt.Errorf("%w: %q", ErrDuplicateCertMap, flds[0])
+			return
+		}
+		seen[mp.fingerprint] = true
+		if s := strings.TrimSpace(flds[1]); s != `` {
+			if mp.src, err = config.ParseSourceOverride(s); err != nil {
+				err = fmt.Errorf("Invalid Client-Cert-Map source %q: %v", s, err)
+				return
+			}
+		}
+		if mp.tag = strings.TrimSpace(flds[2]); mp.tag != `` {
+			if err = ingest.CheckTag(mp.tag); err != nil {
+				err = fmt.Errorf("Invalid Client-Cert-Map tag %q: %v", mp.tag, err)
+				return
+			}
+		} else if mp.src == nil {
+			err = fmt.Errorf("%w: %q maps to neither a source nor a tag", ErrInvalidCertMap, v)
+			return
+		}
+		mps = append(mps, mp)
+	}
+	return
+}
+
+// certMapTags returns the tags client certificates may select
+func (l *listener) certMapTags() (tags []string, err error) {
+	var mps []certMapping
+	if mps, err = l.certMappings(); err != nil {
+		return
+	}
+	for _, mp := range mps {
+		if mp.tag != `` {
+			tags = append(tags, mp.tag)
+		}
+	}
+	return
+}
+
+type certOverride struct {
+	src    net.IP
+	tag    entry.EntryTag
+	hasTag bool
+}
+
+// certMapper applies the source and tag overrides of a client certificate to its connection.
+// Mapped tags replace the listener tag, so Tag-Route rules do not apply to those connections.
+type certMapper struct {
+	only bool
+	mp   map[string]certOverride
+}
+
+// newCertMapper resolves the mapped tags, the mapper is nil if the listener has no mappings
+func (l *listener) newCertMapper(tg tagGetter) (cm *certMapper, err error) {
+	var mps []certMapping
+	if mps, err = l.certMappings(); err != nil || len(mps) == 0 {
+		return
+	}
+	cm = &certMapper{
+		only: l.Client_Cert_Map_Only,
+		mp:   make(map[string]certOverride, len(mps)),
+	}
+	for _, mp := range mps {
+		co := certOverride{src: mp.src}
+		if mp.tag != `` {
+			if co.tag, err = tg.GetTag(mp.tag); err != nil {
+				return nil, err
+			}
+			co.hasTag = true
+		}
+		cm.mp[mp.fingerprint] = co
+	}
+	return
+}
+
+// lookup returns the override for a fingerprint, ok is false if the connection must be refused
+func (cm *certMapper) lookup(fp string, cfg handlerConfig) (ncfg handlerConfig, ok bool) {
+	ncfg = cfg
+	co, has := cm.mp[fp]
+	if !has {
+		ok = !cm.only
+		return
+	}
+	if co.src != nil {
+		ncfg.src = co.src
+	}
+	if co.hasTag {
+		ncfg.tag = co.tag
+	}
+	ok = true
+	return
+}
+
+// apply completes the TLS handshake and maps the client certificate, the fingerprint is
+// returned so refused connections can be logged with it
+func (cm *certMapper) apply(c net.Conn, cfg handlerConfig) (ncfg handlerConfig, fp string, err error) {
+	ncfg = cfg
+	if cm == nil {
+		return
+	}
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		err = ErrCertMapWithoutTLS
+		return
+	}
+	c.SetDeadline(time.Now().Add(certHandshakeTimeout))
+	err = tc.Handshake()
+	c.SetDeadline(time.Time{})
+	if err != nil {
+		return
+	}
+	pcs := tc.ConnectionState().PeerCertificates
+	if len(pcs) == 0 {
+		err = netframe.ErrNoClientCertificate
+		return
+	}
+	fp = certFingerprint(pcs[0])
+	if ncfg, ok = cm.lookup(fp, cfg); !ok {
+		err = ErrUnmappedCertificate
+	}
+	return
+}
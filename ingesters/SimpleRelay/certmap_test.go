@@ -0,0 +1,184 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const testFingerprint = `AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89`
+
+type testTagGetter map[string]entry.EntryTag
+
+func (tg testTagGetter) GetTag(name string) (entry.EntryTag, error) {
+	if t, ok := tg[name]; ok {
+		return t, nil
+	}
+	return 0, errors.New("unknown tag")
+}
+
+// newSelfSignedCert makes a throwaway client certificate
+func newSelfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: `device`},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	want := strings.ToLower(strings.Replace(testFingerprint, `:`, ``, -1))
+	for _, v := range []string{testFingerprint, want, strings.ToUpper(want), ` ` + testFingerprint + ` `} {
+		if fp, err := normalizeFingerprint(v); err != nil {
+			t.Fatalf("rejected %q: %v", v, err)
+		} else if fp != want {
+			t.Fatalf("bad fingerprint %q from %q", fp, v)
+		}
+	}
+	for _, v := range []string{``, `abcd`, want[:40], want + `00`, strings.Replace(want, `a`, `g`, 1)} {
+		if _, err := normalizeFingerprint(v); !errors.Is(err, ErrInvalidFingerprint) {
+			t.Fatalf("bad error on %q: %v", v, err)
+		}
+	}
+}
+
+func TestCertMappings(t *testing.T) {
+	l := &listener{
+		base:            base{Bind_String: `tls://0.0.0.0:6514`},
+		Client_CA_File:  `/opt/gravwell/etc/devices.pem`,
+		Client_Cert_Map: []string{testFingerprint + `,10.0.0.1,firewall`, strings.Replace(testFingerprint, `AB`, `00`, 1) + `,,router`},
+	}
+	mps, err := l.certMappings()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(mps) != 2 {
+		t.Fatalf("bad mapping count %d", len(mps))
+	} else if !mps[0].src.Equal(net.ParseIP(`10.0.0.1`)) || mps[0].tag != `firewall` {
+		t.Fatalf("bad mapping %+v", mps[0])
+	} else if mps[1].src != nil || mps[1].tag != `router` {
+		t.Fatalf("bad mapping %+v", mps[1])
+	}
+	if tags, err := l.certMapTags(); err != nil {
+		t.Fatal(err)
+	} else if len(tags) != 2 || tags[0] != `firewall` || tags[1] != `router` {
+		t.Fatalf("bad tags %v", tags)
+	}
+
+	bad := []struct {
+		mp  []string
+		err error
+	}{
+		{[]string{testFingerprint}, ErrInvalidCertMap},
+		{[]string{testFingerprint + `,,`}, ErrInvalidCertMap},
+		{[]string{`abcd,10.0.0.1,firewall`}, ErrInvalidFingerprint},
+		{[]string{testFingerprint + `,10.0.0.1,fire wall`}, nil},
+		{[]string{testFingerprint + `,notanip,firewall`}, nil},
+		{[]string{testFingerprint + `,,a`, testFingerprint + `,,b`}, ErrDuplicateCertMap},
+	}
+	for _, b := range bad {
+		l.Client_Cert_Map = b.mp
+		if _, err = l.certMappings(); err == nil {
+			t.Fatalf("accepted %v", b.mp)
+		} else if b.err != nil && !errors.Is(err, b.err) {
+			t.Fatalf("bad error on %v: %v", b.mp, err)
+		}
+	}
+
+	l.Client_Cert_Map = []string{testFingerprint + `,,firewall`}
+	l.Client_CA_File = ``
+	if _, err = l.certMappings(); err != ErrCertMapWithoutTLS {
+		t.Fatalf("bad error without a CA file: %v", err)
+	}
+	l.Client_CA_File = `/opt/gravwell/etc/devices.pem`
+	l.Bind_String = `tcp://0.0.0.0:601`
+	if _, err = l.certMappings(); err != ErrCertMapWithoutTLS {
+		t.Fatalf("bad error on a tcp listener: %v", err)
+	}
+	l.Client_Cert_Map = nil
+	l.Client_Cert_Map_Only = true
+	if _, err = l.certMappings(); err != ErrCertMapOnlyWithout {
+		t.Fatalf("bad error on Client-Cert-Map-Only without a map: %v", err)
+	}
+}
+
+func TestCertMapperApply(t *testing.T) {
+	cert := newSelfSignedCert(t)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &listener{
+		base:            base{Bind_String: `tls://0.0.0.0:6514`},
+		Client_CA_File:  `/opt/gravwell/etc/devices.pem`,
+		Client_Cert_Map: []string{certFingerprint(leaf) + `,10.0.0.1,firewall`},
+	}
+	cm, err := l.newCertMapper(testTagGetter{`firewall`: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshake := func(cm *certMapper) (handlerConfig, string, error) {
+		client, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+		srv := tls.Server(server, &tls.Config{
+			Certificates: []tls.Certificate{newSelfSignedCert(t)},
+			ClientAuth:   tls.RequireAnyClientCert,
+		})
+		go tls.Client(client, &tls.Config{
+			Certificates:       []tls.Certificate{cert},
+			InsecureSkipVerify: true,
+		}).Handshake()
+		return cm.apply(srv, handlerConfig{tag: 1})
+	}
+
+	cfg, fp, err := handshake(cm)
+	if err != nil {
+		t.Fatal(err)
+	} else if fp != certFingerprint(leaf) {
+		t.Fatalf("bad fingerprint %q", fp)
+	} else if cfg.tag != 7 || !cfg.src.Equal(net.ParseIP(`10.0.0.1`)) {
+		t.Fatalf("mapping not applied: %+v", cfg)
+	}
+
+	//unmapped certificates keep the listener settings unless the map is exclusive
+	cm.mp = map[string]certOverride{}
+	if cfg, _, err = handshake(cm); err != nil {
+		t.Fatal(err)
+	} else if cfg.tag != 1 || cfg.src != nil {
+		t.Fatalf("unmapped certificate changed the config: %+v", cfg)
+	}
+	cm.only = true
+	if _, _, err = handshake(cm); err != ErrUnmappedCertificate {
+		t.Fatalf("bad error on unmapped certificate: %v", err)
+	}
+}
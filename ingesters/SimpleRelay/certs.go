@@ -0,0 +1,62 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"net"
+	"sync"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+)
+
+type namedCerts struct {
+	name string
+	cr   *netframe.CertReloader
+}
+
+var (
+	certMtx sync.Mutex
+	certs   []namedCerts
+)
+
+// tlsListener serves a listener over TLS, the certificates are reloaded whenever their files
+// change and on SIGHUP so rotated certificates are picked up without dropping connections.
+func tlsListener(l net.Listener, name, certFile, keyFile, caFile string) (net.Listener, error) {
+	cr, err := netframe.NewCertReloader(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	cr.SetReloadErrorHandler(func(err error) {
+		lg.Error("failed to reload certificates, keeping previous certificates", log.KV("listener", name), log.KV("certfile", certFile), log.KVErr(err))
+	})
+	if err = cr.Watch(); err != nil {
+		lg.Warn("failed to watch certificate files, certificates will only be reloaded on SIGHUP", log.KV("listener", name), log.KV("certfile", certFile), log.KVErr(err))
+	}
+	certMtx.Lock()
+	certs = append(certs, namedCerts{name: name, cr: cr})
+	certMtx.Unlock()
+	return cr.Listener(l), nil
+}
+
+// reloadCertificates reloads the certificates of every TLS listener, it returns false if there are none
+func reloadCertificates() bool {
+	certMtx.Lock()
+	defer certMtx.Unlock()
+	if len(certs) == 0 {
+		return false
+	}
+	for _, c := range certs {
+		if err := c.cr.Reload(); err != nil {
+			lg.Error("failed to reload certificates, keeping previous certificates", log.KV("listener", c.name), log.KVErr(err))
+		}
+	}
+	lg.Info("reloaded TLS certificates", log.KV("listeners", len(certs)))
+	return true
+}
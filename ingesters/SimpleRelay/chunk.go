@@ -0,0 +1,51 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+// chunkProcessor applies the listener's entry size policy before handing entries on, rejected
+// entries are logged and dropped rather than failing the connection
+type chunkProcessor struct {
+	entProcessor
+	name string
+	ch   *processors.Chunker
+}
+
+func (cp chunkProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	ents, err := cp.ch.Chunk(ent)
+	if err != nil {
+		lg.Info("rejected oversized entry", log.KV("listener", cp.name), log.KV("size", len(ent.Data)), log.KVErr(err))
+		return nil
+	}
+	for _, e := range ents {
+		if err = cp.entProcessor.ProcessContext(e, ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkWrap hands back a processor that applies the entry size policy, the chunker may be nil
+func chunkWrap(name string, ch *processors.Chunker, proc entProcessor) entProcessor {
+	if ch == nil {
+		return proc
+	}
+	return chunkProcessor{
+		entProcessor: proc,
+		name:         name,
+		ch:           ch,
+	}
+}
@@ -17,36 +17,55 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/charset"
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
 const (
-	MAX_CONFIG_SIZE int64    = (1024 * 1024 * 2) //2MB, even this is crazy large
-	tcp             bindType = iota
-	udp             bindType = iota
-	tcp6            bindType = iota
-	udp6            bindType = iota
-	TLS             bindType = iota
+	MAX_CONFIG_SIZE int64 = (1024 * 1024 * 2) //2MB, even this is crazy large
 
 	lineReader    readerType = iota
 	rfc5424Reader readerType = iota
+	headerReader  readerType = iota
+	relpReader    readerType = iota
+	rfc5425Reader readerType = iota
 )
 
 var ()
 
-type bindType int
 type readerType int
 
 type listener struct {
 	base
-	Tag_Name      string
-	Reader_Type   string
-	Keep_Priority bool // Leave the <nnn> priority value at the start of the log message
-	Cert_File     string
-	Key_File      string
-	Preprocessor  []string
+	tagRouteConfig
+	Tag_Name            string
+	Reader_Type         string
+	Keep_Priority       bool // Leave the <nnn> priority value at the start of the log message
+	Cert_File           string
+	Key_File            string
+	Client_CA_File      string //CA bundle, when set clients must present a certificate signed by one of its CAs
+	Preprocessor        []string
+	Header_Tag_Allow    []string //tags, or glob patterns, a connection header may select
+	Header_Allow_Source bool     //allow connection headers to override the source
+	Header_Timeout      string   //how long a connection has to send its header, defaults to 10s
+	Header_Ack          bool     //reply OK or ERR to the connection header
+
+	Client_Cert_Map      []string //fingerprint,source,tag overrides keyed by SHA-256 client certificate fingerprint
+	Client_Cert_Map_Only bool     //refuse clients whose certificate is not in Client-Cert-Map
+
+	UDP_Workers     int    //sockets bound to the address with SO_REUSEPORT, each read by its own goroutine
+	UDP_Read_Buffer string //kernel receive buffer size of each socket, such as 8MB
+	UDP_Batch_Size  int    //datagrams read per recvmmsg call on Linux, 1 reads them one at a time
+
+	Structured_Data       []string //RFC 5424 SD-IDs attached to entries, * attaches every SD-ELEMENT
+	Structured_Data_Mode  string   //prefix (default) prepends SDID.name=value pairs, json rewrites entries as JSON
+	Structured_Data_Field string   //JSON field holding the structured data, default is structured_data
 }
 
 type base struct {
@@ -55,25 +74,47 @@ type base struct {
 	Assume_Local_Timezone     bool
 	Timezone_Override         string
 	Source_Override           string
-	Timestamp_Format_Override string //override the timestamp format
+	Timestamp_Format_Override string   //override the timestamp format
+	Batch_Size                int      //flush batches into the muxer at this many entries
+	Batch_Latency             string   //flush batches into the muxer after this long
+	Socket_Owner              string   //owner of unix sockets and FIFOs, name or uid
+	Socket_Group              string   //group of unix sockets and FIFOs, name or gid
+	Socket_Mode               string   //octal permissions of unix sockets and FIFOs
+	Accept_From               []string //CIDRs, IPs, or list files allowed to connect
+	Deny_From                 []string //CIDRs, IPs, or list files refused
+	Backpressure              string   //none, pause, reject, or block while the ingest queue is saturated
+	Charset                   string   //character encoding of the data, transcoded to UTF-8 before timestamps are extracted
+	Session_ID                bool     //attach a per connection session ID and sequence number to entries
+	Time_Format_Profile       string   //only try the formats in this TimeFormatProfile
+	processors.ConnMetadataConfig
+	processors.ChunkConfig
+	quarantineConfig
 }
 
 type cfgReadType struct {
-	Global        config.IngestConfig
-	Listener      map[string]*listener
-	JSONListener  map[string]*jsonListener
-	RegexListener map[string]*regexListener
-	Preprocessor  processors.ProcessorConfig
-	TimeFormat    config.CustomTimeFormat
+	Global            config.IngestConfig
+	Listener          map[string]*listener
+	JSONListener      map[string]*jsonListener
+	RegexListener     map[string]*regexListener
+	Preprocessor      processors.ProcessorConfig
+	TimeFormat        config.CustomTimeFormat
+	TimeFormatProfile config.TimeFormatProfiles
+	DeviceProfile     deviceProfileConfig
+	Backpressure      backpressureConfig
 }
 
 type cfgType struct {
 	config.IngestConfig
-	Listener      map[string]*listener
-	JSONListener  map[string]*jsonListener
-	RegexListener map[string]*regexListener
-	Preprocessor  processors.ProcessorConfig
-	TimeFormat    config.CustomTimeFormat
+	Listener          map[string]*listener
+	JSONListener      map[string]*jsonListener
+	RegexListener     map[string]*regexListener
+	Preprocessor      processors.ProcessorConfig
+	TimeFormat        config.CustomTimeFormat
+	TimeFormatProfile config.TimeFormatProfiles
+	DeviceProfile     deviceProfileConfig
+	Backpressure      backpressureConfig
+
+	formatDir *timegrinder.FormatDirectory // shared hot-reloaded time formats
 }
 
 func GetConfig(path, overlayPath string) (*cfgType, error) {
@@ -85,12 +126,15 @@ func GetConfig(path, overlayPath string) (*cfgType, error) {
 		return nil, err
 	}
 	c := &cfgType{
-		IngestConfig:  cr.Global,
-		Listener:      cr.Listener,
-		RegexListener: cr.RegexListener,
-		JSONListener:  cr.JSONListener,
-		Preprocessor:  cr.Preprocessor,
-		TimeFormat:    cr.TimeFormat,
+		IngestConfig:      cr.Global,
+		Listener:          cr.Listener,
+		RegexListener:     cr.RegexListener,
+		JSONListener:      cr.JSONListener,
+		Preprocessor:      cr.Preprocessor,
+		TimeFormat:        cr.TimeFormat,
+		TimeFormatProfile: cr.TimeFormatProfile,
+		DeviceProfile:     cr.DeviceProfile,
+		Backpressure:      cr.Backpressure,
 	}
 
 	if err := verifyConfig(c); err != nil {
@@ -121,6 +165,12 @@ func verifyConfig(c *cfgType) error {
 		return err
 	} else if err = c.TimeFormat.Validate(); err != nil {
 		return err
+	} else if err = c.TimeFormatProfile.Validate(c.TimeFormat, c.Time_Format_Directory != ``); err != nil {
+		return err
+	} else if err = c.DeviceProfile.Validate(); err != nil {
+		return err
+	} else if err = c.Backpressure.Validate(); err != nil {
+		return err
 	}
 	bindMp := make(map[string]string, 1)
 	for k, v := range c.Listener {
@@ -148,11 +198,34 @@ func verifyConfig(c *cfgType) error {
 		bindMp[v.Bind_String] = k
 		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
 			return fmt.Errorf("Listener %s preprocessor invalid: %v", k, err)
+		} else if _, err = v.headerOptions(); err != nil {
+			return fmt.Errorf("Listener %s configuration error: %v", k, err)
+		} else if err = v.relpCheck(); err != nil {
+			return fmt.Errorf("Listener %s configuration error: %v", k, err)
+		} else if err = v.rfc5425Check(); err != nil {
+			return fmt.Errorf("Listener %s configuration error: %v", k, err)
+		} else if _, err = v.certMappings(); err != nil {
+			return fmt.Errorf("Listener %s configuration error: %v", k, err)
+		} else if _, err = v.udpOptions(); err != nil {
+			return fmt.Errorf("Listener %s configuration error: %v", k, err)
+		} else if _, err = v.tagRoutes(); err != nil {
+			return fmt.Errorf("Listener %s configuration error: %v", k, err)
+		} else if _, err = v.structuredData(); err != nil {
+			return fmt.Errorf("Listener %s configuration error: %v", k, err)
+		} else if _, err = c.timeProfile(v.base); err != nil {
+			return fmt.Errorf("Listener %s configuration error: %v", k, err)
 		}
 	}
 	for k, v := range c.RegexListener {
 		if err := v.Validate(); err != nil {
 			return fmt.Errorf("RegexListener %s configuration error: %v", k, err)
+		} else if _, err = v.tagRoutes(); err != nil {
+			return fmt.Errorf("RegexListener %s configuration error: %v", k, err)
+		} else if _, err = c.timeProfile(v.base); err != nil {
+			return fmt.Errorf("RegexListener %s configuration error: %v", k, err)
+		}
+		if tp, _, _ := netframe.ParseBind(v.Bind_String); tp.Unixgram() {
+			return fmt.Errorf("RegexListener %s configuration error: %v", k, ErrDatagramUnsupported)
 		}
 		if len(v.Tag_Name) == 0 {
 			v.Tag_Name = entry.DefaultTagName
@@ -180,6 +253,11 @@ func verifyConfig(c *cfgType) error {
 	for k, v := range c.JSONListener {
 		if err := v.base.Validate(); err != nil {
 			return fmt.Errorf("Listener %s configuration error: %v", k, err)
+		} else if _, err = c.timeProfile(v.base); err != nil {
+			return fmt.Errorf("JSONListener %s configuration error: %v", k, err)
+		}
+		if tp, _, _ := netframe.ParseBind(v.Bind_String); tp.Unixgram() {
+			return fmt.Errorf("JSONListener %s configuration error: %v", k, ErrDatagramUnsupported)
 		}
 		if len(v.Default_Tag) == 0 {
 			v.Default_Tag = entry.DefaultTagName
@@ -222,6 +300,21 @@ func verifyConfig(c *cfgType) error {
 	return nil
 }
 
+// timeProfile resolves the formats in a listener's Time-Format-Profile, a listener that also
+// overrides the timestamp format must pick a format in the profile
+func (c *cfgType) timeProfile(l base) (formats []string, err error) {
+	if formats, err = c.TimeFormatProfile.Formats(l.Time_Format_Profile); err != nil || formats == nil || l.Timestamp_Format_Override == `` {
+		return
+	}
+	for _, f := range formats {
+		if strings.EqualFold(strings.TrimSpace(f), l.Timestamp_Format_Override) {
+			return
+		}
+	}
+	err = fmt.Errorf("Timestamp-Format-Override %s is not in Time-Format-Profile %s", l.Timestamp_Format_Override, l.Time_Format_Profile)
+	return
+}
+
 func (c *cfgType) Tags() ([]string, error) {
 	var tags []string
 	tagMp := make(map[string]bool, 1)
@@ -234,6 +327,20 @@ func (c *cfgType) Tags() ([]string, error) {
 			tags = append(tags, v.Tag_Name)
 			tagMp[v.Tag_Name] = true
 		}
+		rtgs, err := v.routeTags()
+		if err != nil {
+			return nil, err
+		}
+		ctgs, err := v.certMapTags()
+		if err != nil {
+			return nil, err
+		}
+		for _, tg := range append(append(rtgs, ctgs...), v.quarantineTags()...) {
+			if _, ok := tagMp[tg]; !ok {
+				tags = append(tags, tg)
+				tagMp[tg] = true
+			}
+		}
 	}
 
 	for _, v := range c.RegexListener {
@@ -244,6 +351,16 @@ func (c *cfgType) Tags() ([]string, error) {
 			tags = append(tags, v.Tag_Name)
 			tagMp[v.Tag_Name] = true
 		}
+		rtgs, err := v.routeTags()
+		if err != nil {
+			return nil, err
+		}
+		for _, tg := range append(rtgs, v.quarantineTags()...) {
+			if _, ok := tagMp[tg]; !ok {
+				tags = append(tags, tg)
+				tagMp[tg] = true
+			}
+		}
 	}
 
 	//iterate over json listeners
@@ -263,72 +380,72 @@ func (c *cfgType) Tags() ([]string, error) {
 	if len(tags) == 0 {
 		return nil, errors.New("No tags specified")
 	}
+	if tg := c.DeviceProfile.Tag_Name; c.DeviceProfile.Enabled() && !tagMp[tg] {
+		tags = append(tags, tg)
+	}
 	sort.Strings(tags)
 	return tags, nil
 }
 
+// inputCharset returns the character encoding of the listener's data
+func (l base) inputCharset() (charset.Charset, error) {
+	return charset.Parse(l.Charset)
+}
+
 func (l base) Validate() error {
 	if len(l.Bind_String) == 0 {
 		return errors.New("No Bind-String provided")
 	}
-	return nil
-}
-
-func translateBindType(bstr string) (bindType, string, error) {
-	bits := strings.SplitN(bstr, "://", 2)
-	//if nothing specified, just return the tcp type
-	if len(bits) != 2 {
-		return tcp, bstr, nil
+	if _, _, _, err := l.batchConfig(); err != nil {
+		return err
+	} else if err = l.ConnMetadataConfig.Validate(); err != nil {
+		return err
+	} else if _, err = l.inputCharset(); err != nil {
+		return err
+	} else if err = l.quarantineConfig.Validate(); err != nil {
+		return err
+	} else if err = l.ChunkConfig.Validate(); err != nil {
+		return err
 	}
-	id := strings.ToLower(bits[0])
-	switch id {
-	case "tcp":
-		return tcp, bits[1], nil
-	case "udp":
-		return udp, bits[1], nil
-	case "tcp6":
-		return tcp6, bits[1], nil
-	case "udp6":
-		return udp6, bits[1], nil
-	case "tls":
-		return TLS, bits[1], nil
-	default:
+	tp, pth, err := netframe.ParseBind(l.Bind_String)
+	if err != nil {
+		return err
+	} else if err = tp.CheckAddr(pth); err != nil {
+		return fmt.Errorf("Invalid Bind-String: %w", err)
+	} else if err = l.validateBackpressure(tp); err != nil {
+		return err
+	} else if l.Session_ID && (!tp.Stream() || tp.FIFO()) {
+		return ErrSessionStreamOnly
 	}
-	return -1, "", errors.New("invalid bind protocol specifier of " + id)
-}
-
-func (bt bindType) TCP() bool {
-	if bt == tcp || bt == tcp6 {
-		return true
+	if tp.Local() {
+		if pth == `` {
+			return errors.New("Bind-String is missing a path")
+		}
+		if _, err = l.socketPerms(); err != nil {
+			return err
+		}
+	} else if l.Socket_Owner != `` || l.Socket_Group != `` || l.Socket_Mode != `` {
+		return ErrSocketOptsRequireLocal
 	}
-	return false
-}
-
-func (bt bindType) UDP() bool {
-	if bt == udp || bt == udp6 {
-		return true
+	if len(l.Accept_From) > 0 || len(l.Deny_From) > 0 {
+		if tp.Local() {
+			return ErrFilterRequiresIP
+		} else if _, err = utils.NewIPFilter(l.Accept_From, l.Deny_From, 0); err != nil {
+			return err
+		}
 	}
-	return false
-}
-
-func (bt bindType) TLS() bool {
-	return bt == TLS
+	return nil
 }
 
-func (bt bindType) String() string {
-	switch bt {
-	case tcp:
-		return "tcp"
-	case tcp6:
-		return "tcp6"
-	case udp:
-		return "udp"
-	case udp6:
-		return "udp6"
-	case TLS:
-		return "tls"
+// ipFilter builds the address filter for a listener, nil means everything is allowed
+func (l base) ipFilter(name string) (f *utils.IPFilter, err error) {
+	if f, err = utils.NewIPFilter(l.Accept_From, l.Deny_From, 0); err != nil || f == nil {
+		return
 	}
-	return "unknown"
+	f.SetReloadErrorHandler(func(err error) {
+		lg.Error("failed to reload address filter, keeping previous lists", log.KV("listener", name), log.KVErr(err))
+	})
+	return
 }
 
 func translateReaderType(s string) (readerType, error) {
@@ -338,6 +455,12 @@ func translateReaderType(s string) (readerType, error) {
 		return lineReader, nil
 	case `rfc5424`:
 		return rfc5424Reader, nil
+	case `header`:
+		return headerReader, nil
+	case `relp`:
+		return relpReader, nil
+	case `rfc5425`:
+		return rfc5425Reader, nil
 	case ``:
 		return lineReader, nil
 	}
@@ -350,6 +473,12 @@ func (rt readerType) String() string {
 		return `LINE`
 	case rfc5424Reader:
 		return `RFC5424`
+	case headerReader:
+		return `HEADER`
+	case relpReader:
+		return `RELP`
+	case rfc5425Reader:
+		return `RFC5425`
 	}
 	return "UNKNOWN"
 }
@@ -13,6 +13,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -71,6 +72,82 @@ func TestBasicConfig(t *testing.T) {
 	}
 }
 
+func TestAddressFilterValidate(t *testing.T) {
+	good := []base{
+		{Bind_String: `0.0.0.0:601`, Accept_From: []string{`10.0.0.0/8`, `192.168.1.1`}},
+		{Bind_String: `udp://0.0.0.0:514`, Deny_From: []string{`203.0.113.0/24`}},
+		{Bind_String: `tls://0.0.0.0:6514`, Accept_From: []string{`::1`}, Deny_From: []string{`fe80::/10`}},
+	}
+	for _, b := range good {
+		if err := b.Validate(); err != nil {
+			t.Fatalf("%+v failed validation: %v", b, err)
+		}
+	}
+	bad := []base{
+		{Bind_String: `0.0.0.0:601`, Accept_From: []string{`10.0.0.0/33`}},
+		{Bind_String: `0.0.0.0:601`, Deny_From: []string{`/no/such/list/file`}},
+		{Bind_String: `unix:///tmp/x.sock`, Accept_From: []string{`127.0.0.1`}},
+	}
+	for _, b := range bad {
+		if err := b.Validate(); err == nil {
+			t.Fatalf("%+v passed validation", b)
+		}
+	}
+}
+
+func TestCharsetValidate(t *testing.T) {
+	b := base{Bind_String: `0.0.0.0:601`, Charset: `UTF-16LE`}
+	if err := b.Validate(); err != nil {
+		t.Fatal(err)
+	} else if cs, err := b.inputCharset(); err != nil || cs.String() != `utf-16le` {
+		t.Fatalf("bad charset %v %v", cs, err)
+	}
+	b.Charset = `ebcdic`
+	if err := b.Validate(); err == nil {
+		t.Fatal("unknown charset passed validation")
+	}
+}
+
+func TestTimeFormatProfileConfig(t *testing.T) {
+	c, err := loadTestConfig(timeProfileConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := c.timeProfile(c.Listener[`syslog`].base); err != nil || len(f) != 2 {
+		t.Fatalf("bad profile formats %v %v", f, err)
+	}
+	//overrides have to be in the profile
+	b := c.Listener[`syslog`].base
+	b.Timestamp_Format_Override = `Apache`
+	if _, err = c.timeProfile(b); err == nil {
+		t.Fatal("failed to catch override outside of the profile")
+	}
+	b.Time_Format_Profile = `windows`
+	if _, err = c.timeProfile(b); err == nil {
+		t.Fatal("failed to catch unknown profile")
+	}
+	if _, err = loadTestConfig(strings.Replace(timeProfileConfigFile, `Format=RFC3339`, `Format=winevent`, 1)); err == nil {
+		t.Fatal("failed to catch unknown format in a profile")
+	}
+}
+
+const timeProfileConfigFile = `
+[Global]
+Ingest-Secret = IngestSecrets
+Cleartext-Backend-target=127.0.0.1:4023
+Log-Level=INFO
+
+[Listener "syslog"]
+	Bind-String="0.0.0.0:601"
+	Tag-Name=syslog
+	Time-Format-Profile=network
+	Timestamp-Format-Override=syslog
+
+[TimeFormatProfile "network"]
+	Format=Syslog
+	Format=RFC3339
+`
+
 const (
 	baseConfig string = `
 [Global]
@@ -0,0 +1,356 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+// Listening sockets are handed to a freshly exec'd relay by passing them as inherited file descriptors.
+// The child locates its sockets using the envInheritFDs variable which maps listener keys to descriptors,
+// and tells the parent it is up by writing to the ready pipe.  The parent then stops accepting and drains
+// its existing connections while the child services everything new.
+const (
+	envInheritFDs  = `GRAVWELL_RELAY_INHERIT_FDS`
+	envReadyFD     = `GRAVWELL_RELAY_READY_FD`
+	handoverReady  = 30 * time.Second
+	firstInherited = 3 // ExtraFiles start after stdin, stdout, and stderr
+)
+
+var (
+	ErrHandoverTimeout = errors.New("timed out waiting for the new relay process to start")
+	ErrHandoverExited  = errors.New("new relay process exited during handover")
+
+	handover = newHandoverSet()
+)
+
+type handoverSet struct {
+	sync.Mutex
+	inherited map[string]*os.File // sockets passed in from our parent, consumed as listeners start
+	active    map[string]handoverSocket
+	fifos     []io.Closer // FIFOs are reopened by the new process rather than passed over
+	ready     *os.File
+	activated *utils.ActivatedSockets // sockets passed in by systemd socket activation
+}
+
+// handoverSocket is a listening socket which can be passed to another process
+type handoverSocket interface {
+	Close() error
+	File() (*os.File, error)
+}
+
+func newHandoverSet() *handoverSet {
+	hs := &handoverSet{
+		inherited: map[string]*os.File{},
+		active:    map[string]handoverSocket{},
+	}
+	// entries are key=fd separated by semicolons, keys are listener names and bind strings
+	for _, v := range strings.Split(os.Getenv(envInheritFDs), `;`) {
+		idx := strings.LastIndexByte(v, '=')
+		if idx <= 0 {
+			continue
+		}
+		if fd, err := strconv.Atoi(v[idx+1:]); err == nil && fd >= firstInherited {
+			hs.inherited[v[:idx]] = os.NewFile(uintptr(fd), v[:idx])
+		}
+	}
+	if fd, err := strconv.Atoi(os.Getenv(envReadyFD)); err == nil && fd >= firstInherited {
+		hs.ready = os.NewFile(uintptr(fd), `handover-ready`)
+	}
+	os.Unsetenv(envInheritFDs)
+	os.Unsetenv(envReadyFD)
+	return hs
+}
+
+// loadActivated picks up any sockets systemd passed us, listeners bound to the same addresses use them
+func (hs *handoverSet) loadActivated() (err error) {
+	var as *utils.ActivatedSockets
+	if as, err = utils.GetActivatedSockets(); err != nil {
+		return
+	}
+	hs.Lock()
+	hs.activated = as
+	hs.Unlock()
+	if cnt := as.Count(); cnt > 0 {
+		lg.Info("picked up socket activated listeners", log.KV("count", cnt))
+	}
+	return
+}
+
+// handoverKey identifies a socket across processes, a listener which changes its bind string gets a new socket
+func handoverKey(kind, name, bind string) string {
+	return kind + `/` + name + `/` + bind
+}
+
+func (hs *handoverSet) take(key string) (f *os.File) {
+	hs.Lock()
+	if f = hs.inherited[key]; f != nil {
+		delete(hs.inherited, key)
+	}
+	hs.Unlock()
+	return
+}
+
+func (hs *handoverSet) add(key string, s handoverSocket) {
+	hs.Lock()
+	hs.active[key] = s
+	hs.Unlock()
+}
+
+func (hs *handoverSet) addFIFO(c io.Closer) {
+	hs.Lock()
+	hs.fifos = append(hs.fifos, c)
+	hs.Unlock()
+}
+
+// listenTCP returns an inherited TCP listener for the key if there is one, otherwise it binds a new one
+func (hs *handoverSet) listenTCP(key, network string, addr *net.TCPAddr) (l *net.TCPListener, err error) {
+	if f := hs.take(key); f != nil {
+		var fl net.Listener
+		fl, err = net.FileListener(f)
+		f.Close()
+		if err == nil {
+			var ok bool
+			if l, ok = fl.(*net.TCPListener); !ok {
+				fl.Close()
+				err = fmt.Errorf("inherited socket for %s is not a TCP listener", key)
+			}
+		}
+		if err != nil {
+			lg.Warn("failed to reuse inherited socket, binding a new one", log.KV("socket", key), log.KVErr(err))
+			err = nil
+		}
+	}
+	if l == nil {
+		if al, ok := hs.activated.Listener(network, addr.String()); ok {
+			if l, ok = al.(*net.TCPListener); !ok {
+				al.Close()
+				err = fmt.Errorf("activated socket for %v is not a TCP listener", addr)
+				return
+			}
+		} else if l, err = net.ListenTCP(network, addr); err != nil {
+			return
+		}
+	}
+	hs.add(key, l)
+	return
+}
+
+// listenUDP returns an inherited UDP socket for the key if there is one, otherwise it binds a new one.
+// Sockets which share their address with other workers are bound with SO_REUSEPORT.
+func (hs *handoverSet) listenUDP(key, network string, addr *net.UDPAddr, reuse bool) (c *net.UDPConn, err error) {
+	if f := hs.take(key); f != nil {
+		var pc net.PacketConn
+		pc, err = net.FilePacketConn(f)
+		f.Close()
+		if err == nil {
+			var ok bool
+			if c, ok = pc.(*net.UDPConn); !ok {
+				pc.Close()
+				err = fmt.Errorf("inherited socket for %s is not a UDP socket", key)
+			}
+		}
+		if err != nil {
+			lg.Warn("failed to reuse inherited socket, binding a new one", log.KV("socket", key), log.KVErr(err))
+			err = nil
+		}
+	}
+	if c == nil {
+		if pc, ok := hs.activated.PacketConn(network, addr.String()); ok {
+			if c, ok = pc.(*net.UDPConn); !ok {
+				pc.Close()
+				err = fmt.Errorf("activated socket for %v is not a UDP socket", addr)
+				return
+			}
+		} else if reuse {
+			if c, err = listenReusePort(network, addr); err != nil {
+				return
+			}
+		} else if c, err = net.ListenUDP(network, addr); err != nil {
+			return
+		}
+	}
+	hs.add(key, c)
+	return
+}
+
+// started closes any inherited or activated sockets no listener claimed and tells our parent, if any, that we are up
+func (hs *handoverSet) started() {
+	hs.Lock()
+	defer hs.Unlock()
+	for k, f := range hs.inherited {
+		lg.Info("closing unused inherited socket", log.KV("socket", k))
+		f.Close()
+		delete(hs.inherited, k)
+	}
+	for _, v := range hs.activated.Close() {
+		lg.Warn("closing socket activated listener which matches no configured listener", log.KV("socket", v))
+	}
+	if hs.ready != nil {
+		hs.ready.Write([]byte{1})
+		hs.ready.Close()
+		hs.ready = nil
+	}
+}
+
+// upgrade starts a new relay process from the binary on disk, passing it our listening sockets.
+// Once the new process reports that it is running our listeners are closed so we stop accepting.
+func (hs *handoverSet) upgrade() (pid int, err error) {
+	var bin string
+	if bin, err = os.Executable(); err != nil {
+		return
+	}
+	// the binary was most likely replaced underneath us
+	bin = strings.TrimSuffix(bin, ` (deleted)`)
+
+	hs.Lock()
+	defer hs.Unlock()
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	var mapping []string
+	for k, s := range hs.active {
+		var f *os.File
+		if f, err = s.File(); err != nil {
+			err = fmt.Errorf("failed to get descriptor for %s: %w", k, err)
+			return
+		}
+		mapping = append(mapping, fmt.Sprintf("%s=%d", k, firstInherited+len(files)))
+		files = append(files, f)
+	}
+	rp, wp, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	defer rp.Close()
+	readyFd := firstInherited + len(files)
+	files = append(files, wp)
+
+	cmd := exec.Command(bin, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		envInheritFDs+`=`+strings.Join(mapping, `;`),
+		envReadyFD+`=`+strconv.Itoa(readyFd),
+	)
+	if err = cmd.Start(); err != nil {
+		return
+	}
+	pid = cmd.Process.Pid
+	// drop our copy of the write side so a dying child closes the pipe
+	wp.Close()
+	files = files[:len(files)-1]
+
+	rch := make(chan error, 1)
+	go func() {
+		b := make([]byte, 1)
+		if _, rerr := rp.Read(b); rerr != nil {
+			rch <- ErrHandoverExited
+		} else {
+			rch <- nil
+		}
+	}()
+	select {
+	case err = <-rch:
+	case <-time.After(handoverReady):
+		err = ErrHandoverTimeout
+	}
+	if err != nil {
+		cmd.Process.Signal(syscall.SIGTERM)
+		go cmd.Wait()
+		return
+	}
+	cmd.Process.Release()
+	// the new process owns the sockets now, stop accepting
+	for k, s := range hs.active {
+		if ul, ok := s.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false) // the socket path belongs to the new process now
+		}
+		s.Close()
+		delete(hs.active, k)
+	}
+	for _, c := range hs.fifos {
+		c.Close()
+	}
+	hs.fifos = nil
+	return
+}
+
+// waitForQuitOrUpgrade blocks until we are asked to quit or SIGUSR2 asks for a binary upgrade
+// and our listening sockets have been handed to the new process.  SIGHUP only quits if there
+// are no TLS listeners, otherwise it reloads their certificates.
+func waitForQuitOrUpgrade() (upgraded bool) {
+	usr := make(chan os.Signal, 1)
+	signal.Notify(usr, syscall.SIGUSR2)
+	defer signal.Stop(usr)
+	quit := utils.GetQuitChannel()
+	defer signal.Stop(quit)
+	for {
+		select {
+		case sig := <-quit:
+			if sig == syscall.SIGHUP && reloadCertificates() {
+				continue
+			}
+			return false
+		case <-usr:
+			lg.Info("starting listener handover to a new relay process")
+			pid, err := handover.upgrade()
+			if err != nil {
+				lg.Error("listener handover failed, continuing to run", log.KVErr(err))
+				continue
+			}
+			lg.Info("listeners handed over, draining existing connections", log.KV("pid", pid), log.KV("active", connTracker.Count()))
+			return true
+		}
+	}
+}
+
+// drainConnections waits for existing connections to close, a quit signal ends the wait early
+// as does the timeout if it is not zero.
+func drainConnections(timeout time.Duration) {
+	quit := utils.GetQuitChannel()
+	defer signal.Stop(quit)
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		tmr := time.NewTimer(timeout)
+		defer tmr.Stop()
+		deadline = tmr.C
+	}
+	tckr := time.NewTicker(time.Second)
+	defer tckr.Stop()
+	for connTracker.Count() > 0 {
+		select {
+		case <-quit:
+			return
+		case <-deadline:
+			lg.Warn("connections did not drain, closing", log.KV("timeout", timeout), log.KV("active", connTracker.Count()))
+			return
+		case <-tckr.C:
+		}
+	}
+}
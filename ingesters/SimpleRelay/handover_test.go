@@ -0,0 +1,78 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestHandoverInherit(t *testing.T) {
+	hs := newHandoverSet()
+	addr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := handoverKey(`listener`, `test`, `127.0.0.1:0`)
+	orig, err := hs.listenTCP(key, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+
+	// hand the socket over to a new set the way a child process would see it
+	f, err := orig.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	child := newHandoverSet()
+	child.inherited[key] = f
+	l, err := child.listenTCP(key, "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.Addr().String() != orig.Addr().String() {
+		t.Fatalf("inherited listener is bound to %v, expected %v", l.Addr(), orig.Addr())
+	}
+	if len(child.inherited) != 0 {
+		t.Fatal("inherited socket was not consumed")
+	}
+
+	// the parent stops accepting, connections must land on the inherited listener
+	orig.Close()
+	go func() {
+		if c, err := net.Dial("tcp", l.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
+
+func TestHandoverEnv(t *testing.T) {
+	os.Setenv(envInheritFDs, `listener/a/tcp://0.0.0.0:601=1000;json/b/0.0.0.0:7777=1001;bad;x=1`)
+	hs := newHandoverSet()
+	if len(hs.inherited) != 2 {
+		t.Fatalf("bad inherited set: %v", hs.inherited)
+	}
+	if f, ok := hs.inherited[`listener/a/tcp://0.0.0.0:601`]; !ok || f.Fd() != 1000 {
+		t.Fatalf("bad inherited entry: %v", hs.inherited)
+	}
+	if _, ok := hs.inherited[`json/b/0.0.0.0:7777`]; !ok {
+		t.Fatalf("missing inherited entry: %v", hs.inherited)
+	}
+	if os.Getenv(envInheritFDs) != `` {
+		t.Fatal("inherit variable was not cleared")
+	}
+}
@@ -0,0 +1,256 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+)
+
+const (
+	maxHeaderSize        = 4096
+	defaultHeaderTimeout = 10 * time.Second
+)
+
+var (
+	ErrHeaderTooLarge       = errors.New("connection header line is too long")
+	ErrHeaderEmpty          = errors.New("connection header line is empty")
+	ErrHeaderUnknownKey     = errors.New("unknown connection header key")
+	ErrHeaderBadFormat      = errors.New("connection header format must be line or rfc5424")
+	ErrHeaderTagNotAllowed  = errors.New("connection header tag is not allowed")
+	ErrHeaderSourceDisabled = errors.New("connection header source overrides are not enabled")
+	ErrHeaderStreamOnly     = errors.New("header reader type requires a stream listener")
+	ErrHeaderOptsWithout    = errors.New("Header options require Reader-Type=header")
+)
+
+// connHeader is the first line of a connection on a header listener.  It is a JSON object or
+// whitespace separated key=value pairs, for example:
+//
+//	{"tag":"app1","source":"10.0.0.1","format":"rfc5424"}
+//	tag=app1 format=line timestamp-format=RFC3339
+type connHeader struct {
+	Tag              string `json:"tag"`
+	Source           string `json:"source"`
+	Format           string `json:"format"`
+	Timestamp_Format string `json:"timestamp-format"`
+	Timezone         string `json:"timezone"`
+	Ignore_TS        *bool  `json:"ignore-timestamps"`
+}
+
+// headerOptions are the listener settings which govern what a connection header may change
+type headerOptions struct {
+	tagAllow    []string // tag names or path.Match patterns
+	allowSource bool
+	timeout     time.Duration
+	ack         bool
+}
+
+func (l *listener) headerOptions() (ho headerOptions, err error) {
+	rt, err := translateReaderType(l.Reader_Type)
+	if err != nil {
+		return
+	} else if rt != headerReader {
+		if len(l.Header_Tag_Allow) > 0 || l.Header_Allow_Source || l.Header_Timeout != `` || l.Header_Ack {
+			err = ErrHeaderOptsWithout
+		}
+		return
+	}
+	if tp, _, lerr := netframe.ParseBind(l.Bind_String); lerr == nil && (tp.UDP() || tp.Unixgram()) {
+		err = ErrHeaderStreamOnly
+		return
+	}
+	ho = headerOptions{
+		allowSource: l.Header_Allow_Source,
+		timeout:     defaultHeaderTimeout,
+		ack:         l.Header_Ack,
+	}
+	for _, a := range l.Header_Tag_Allow {
+		if a = strings.TrimSpace(a); a == `` {
+			continue
+		} else if _, err = path.Match(a, ``); err != nil {
+			err = fmt.Errorf("invalid Header-Tag-Allow pattern %q: %v", a, err)
+			return
+		}
+		ho.tagAllow = append(ho.tagAllow, a)
+	}
+	if l.Header_Timeout != `` {
+		if ho.timeout, err = time.ParseDuration(l.Header_Timeout); err != nil {
+			err = fmt.Errorf("invalid Header-Timeout %q: %v", l.Header_Timeout, err)
+		} else if ho.timeout <= 0 {
+			err = fmt.Errorf("invalid Header-Timeout %q", l.Header_Timeout)
+		}
+	}
+	return
+}
+
+func (ho headerOptions) tagAllowed(tag string) bool {
+	for _, a := range ho.tagAllow {
+		if ok, _ := path.Match(a, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readHeaderLine reads up to the first newline a byte at a time so nothing past the header is
+// consumed, the connection can then be handed to the regular reader untouched.
+func readHeaderLine(r io.Reader) (ln []byte, err error) {
+	var b [1]byte
+	for len(ln) <= maxHeaderSize {
+		var n int
+		if n, err = r.Read(b[:]); n == 1 {
+			if b[0] == '\n' {
+				return bytes.TrimSpace(ln), nil
+			}
+			ln = append(ln, b[0])
+		}
+		if err != nil {
+			return
+		}
+	}
+	return nil, ErrHeaderTooLarge
+}
+
+func parseConnHeader(ln []byte) (ch connHeader, err error) {
+	if ln = bytes.TrimSpace(ln); len(ln) == 0 {
+		err = ErrHeaderEmpty
+		return
+	}
+	if ln[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(ln))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&ch)
+		return
+	}
+	for _, f := range strings.Fields(string(ln)) {
+		idx := strings.IndexByte(f, '=')
+		if idx <= 0 {
+			return ch, fmt.Errorf("%w %q", ErrHeaderUnknownKey, f)
+		}
+		k, v := strings.ToLower(f[:idx]), f[idx+1:]
+		switch k {
+		case `tag`:
+			ch.Tag = v
+		case `source`:
+			ch.Source = v
+		case `format`:
+			ch.Format = v
+		case `timestamp-format`:
+			ch.Timestamp_Format = v
+		case `timezone`:
+			ch.Timezone = v
+		case `ignore-timestamps`:
+			var b bool
+			if b, err = strconv.ParseBool(v); err != nil {
+				return ch, fmt.Errorf("invalid ignore-timestamps %q", v)
+			}
+			ch.Ignore_TS = &b
+		default:
+			return ch, fmt.Errorf("%w %q", ErrHeaderUnknownKey, k)
+		}
+	}
+	return
+}
+
+// apply checks the header against the listener options and returns the handler config to use
+func (ch connHeader) apply(cfg handlerConfig) (ncfg handlerConfig, err error) {
+	ncfg = cfg
+	ncfg.lrt = lineReader
+	if ch.Tag != `` {
+		if err = ingest.CheckTag(ch.Tag); err != nil {
+			return
+		} else if !cfg.hdr.tagAllowed(ch.Tag) {
+			err = fmt.Errorf("%w: %q", ErrHeaderTagNotAllowed, ch.Tag)
+			return
+		} else if ncfg.tag, err = cfg.igst.NegotiateTag(ch.Tag); err != nil {
+			return
+		}
+	}
+	if ch.Source != `` {
+		if !cfg.hdr.allowSource {
+			err = ErrHeaderSourceDisabled
+			return
+		} else if ncfg.src, _, err = config.ParseIPZone(ch.Source); err != nil {
+			err = fmt.Errorf("invalid header source: %w", err)
+			return
+		}
+	}
+	switch strings.ToLower(ch.Format) {
+	case ``, `line`:
+	case `rfc5424`:
+		ncfg.lrt = rfc5424Reader
+	default:
+		err = ErrHeaderBadFormat
+		return
+	}
+	if ch.Timezone != `` {
+		if _, err = time.LoadLocation(ch.Timezone); err != nil {
+			err = fmt.Errorf("invalid header timezone %q: %v", ch.Timezone, err)
+			return
+		}
+		ncfg.timezoneOverride = ch.Timezone
+		ncfg.setLocalTime = false
+	}
+	if ch.Timestamp_Format != `` {
+		ncfg.formatOverride = ch.Timestamp_Format
+	}
+	if ch.Ignore_TS != nil {
+		ncfg.ignoreTimestamps = *ch.Ignore_TS
+	}
+	return
+}
+
+// headerConnHandlerTCP reads the connection header and hands the connection to the reader it asks for
+func headerConnHandlerTCP(c net.Conn, cfg handlerConfig) {
+	c.SetReadDeadline(time.Now().Add(cfg.hdr.timeout))
+	ln, err := readHeaderLine(c)
+	if err == nil {
+		var ch connHeader
+		if ch, err = parseConnHeader(ln); err == nil {
+			cfg, err = ch.apply(cfg)
+		}
+	}
+	if err != nil {
+		lg.Info("rejected connection header", log.KV("address", c.RemoteAddr()), log.KV("listener", cfg.name), log.KVErr(err))
+		if cfg.hdr.ack {
+			c.SetWriteDeadline(time.Now().Add(cfg.hdr.timeout))
+			fmt.Fprintf(c, "ERR %v\n", err)
+		}
+		c.Close()
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+	if cfg.hdr.ack {
+		c.SetWriteDeadline(time.Now().Add(cfg.hdr.timeout))
+		if _, err = io.WriteString(c, "OK\n"); err != nil {
+			c.Close()
+			return
+		}
+		c.SetWriteDeadline(time.Time{})
+	}
+	switch cfg.lrt {
+	case rfc5424Reader:
+		rfc5424ConnHandlerTCP(c, cfg)
+	default:
+		lineConnHandlerTCP(c, cfg)
+	}
+}
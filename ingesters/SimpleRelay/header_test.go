@@ -0,0 +1,166 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConnHeader(t *testing.T) {
+	ch, err := parseConnHeader([]byte(`{"tag":"app1","source":"10.0.0.1","format":"rfc5424","ignore-timestamps":true}`))
+	if err != nil {
+		t.Fatal(err)
+	} else if ch.Tag != `app1` || ch.Source != `10.0.0.1` || ch.Format != `rfc5424` {
+		t.Fatalf("bad JSON header: %+v", ch)
+	} else if ch.Ignore_TS == nil || !*ch.Ignore_TS {
+		t.Fatal("ignore-timestamps not set")
+	}
+
+	ch, err = parseConnHeader([]byte(`tag=app2 timestamp-format=RFC3339 timezone=UTC ignore-timestamps=false`))
+	if err != nil {
+		t.Fatal(err)
+	} else if ch.Tag != `app2` || ch.Timestamp_Format != `RFC3339` || ch.Timezone != `UTC` {
+		t.Fatalf("bad plain header: %+v", ch)
+	} else if ch.Ignore_TS == nil || *ch.Ignore_TS {
+		t.Fatal("ignore-timestamps not cleared")
+	}
+
+	bad := []string{
+		``,
+		`tag=app1 color=blue`,
+		`justaword`,
+		`ignore-timestamps=maybe`,
+		`{"tag":"app1","color":"blue"}`,
+		`{"tag":`,
+	}
+	for _, b := range bad {
+		if _, err = parseConnHeader([]byte(b)); err == nil {
+			t.Fatalf("failed to reject %q", b)
+		}
+	}
+}
+
+func TestReadHeaderLine(t *testing.T) {
+	r := strings.NewReader("tag=app1\r\nfirst line\nsecond line\n")
+	ln, err := readHeaderLine(r)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(ln) != `tag=app1` {
+		t.Fatalf("bad header line %q", ln)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(rest) != "first line\nsecond line\n" {
+		t.Fatalf("header read consumed data: %q", rest)
+	}
+
+	if _, err = readHeaderLine(strings.NewReader(strings.Repeat(`a`, maxHeaderSize+10))); err != ErrHeaderTooLarge {
+		t.Fatalf("bad error on oversized header: %v", err)
+	}
+	if _, err = readHeaderLine(strings.NewReader(`tag=app1`)); err == nil {
+		t.Fatal("accepted a header without a newline")
+	}
+}
+
+func TestConnHeaderApply(t *testing.T) {
+	cfg := handlerConfig{
+		lrt: headerReader,
+		hdr: headerOptions{
+			tagAllow: []string{`app*`},
+		},
+	}
+	ncfg, err := connHeader{Format: `rfc5424`, Timezone: `UTC`}.apply(cfg)
+	if err != nil {
+		t.Fatal(err)
+	} else if ncfg.lrt != rfc5424Reader || ncfg.timezoneOverride != `UTC` {
+		t.Fatalf("header not applied: %+v", ncfg)
+	}
+	if ncfg, err = (connHeader{}).apply(cfg); err != nil {
+		t.Fatal(err)
+	} else if ncfg.lrt != lineReader {
+		t.Fatalf("bad default reader type %v", ncfg.lrt)
+	}
+
+	if _, err = (connHeader{Tag: `other`}).apply(cfg); !errors.Is(err, ErrHeaderTagNotAllowed) {
+		t.Fatalf("bad error on disallowed tag: %v", err)
+	}
+	if _, err = (connHeader{Tag: `app 1`}).apply(cfg); err == nil {
+		t.Fatal("accepted an invalid tag")
+	}
+	if _, err = (connHeader{Source: `10.0.0.1`}).apply(cfg); err != ErrHeaderSourceDisabled {
+		t.Fatalf("bad error on disabled source: %v", err)
+	}
+	if _, err = (connHeader{Format: `csv`}).apply(cfg); err != ErrHeaderBadFormat {
+		t.Fatalf("bad error on bad format: %v", err)
+	}
+	if _, err = (connHeader{Timezone: `Nowhere/Special`}).apply(cfg); err == nil {
+		t.Fatal("accepted a bad timezone")
+	}
+
+	cfg.hdr.allowSource = true
+	if ncfg, err = (connHeader{Source: `10.0.0.1`}).apply(cfg); err != nil {
+		t.Fatal(err)
+	} else if ncfg.src.String() != `10.0.0.1` {
+		t.Fatalf("bad source %v", ncfg.src)
+	}
+	if _, err = (connHeader{Source: `nope`}).apply(cfg); err == nil {
+		t.Fatal("accepted a bad source")
+	}
+}
+
+func TestHeaderOptions(t *testing.T) {
+	l := listener{
+		Reader_Type:      `header`,
+		Header_Tag_Allow: []string{`app*`, ` `, `syslog`},
+		Header_Timeout:   `5s`,
+		Header_Ack:       true,
+	}
+	l.Bind_String = `tcp://127.0.0.1:7778`
+	ho, err := l.headerOptions()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ho.tagAllow) != 2 || ho.timeout != 5*time.Second || !ho.ack {
+		t.Fatalf("bad header options: %+v", ho)
+	} else if !ho.tagAllowed(`app1`) || !ho.tagAllowed(`syslog`) || ho.tagAllowed(`other`) {
+		t.Fatal("bad tag allow list")
+	}
+
+	l.Header_Timeout = ``
+	if ho, err = l.headerOptions(); err != nil {
+		t.Fatal(err)
+	} else if ho.timeout != defaultHeaderTimeout {
+		t.Fatalf("bad default timeout %v", ho.timeout)
+	}
+
+	bad := l
+	bad.Header_Timeout = `-1s`
+	if _, err = bad.headerOptions(); err == nil {
+		t.Fatal("accepted a negative timeout")
+	}
+	bad = l
+	bad.Header_Tag_Allow = []string{`app[`}
+	if _, err = bad.headerOptions(); err == nil {
+		t.Fatal("accepted a bad tag pattern")
+	}
+	bad = l
+	bad.Bind_String = `udp://127.0.0.1:7778`
+	if _, err = bad.headerOptions(); err != ErrHeaderStreamOnly {
+		t.Fatalf("bad error on UDP header listener: %v", err)
+	}
+	bad = l
+	bad.Reader_Type = `line`
+	if _, err = bad.headerOptions(); err != ErrHeaderOptsWithout {
+		t.Fatalf("bad error on header options without header reader: %v", err)
+	}
+}
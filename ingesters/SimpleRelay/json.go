@@ -12,19 +12,18 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"fmt"
 	"net"
-	"os"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/charset"
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 
 	"github.com/buger/jsonparser"
@@ -41,9 +40,17 @@ type jsonHandlerConfig struct {
 	wg               *sync.WaitGroup
 	formatOverride   string
 	flds             []string
-	proc             *processors.ProcessorSet
+	proc             entProcessor
+	meta             *processors.MetadataAttacher
+	srcField         *processors.SourceExtractor
+	schema           *jsonSchemaCheck
+	promote          *fieldPromoter
 	ctx              context.Context
 	timeFormats      config.CustomTimeFormat
+	formatDir        *timegrinder.FormatDirectory
+	timeProfile      []string // formats the listener is restricted to, nil for all
+	cs               charset.Charset
+	sess             *sessionTagger
 }
 
 func startJSONListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, f *flusher, ctx context.Context) error {
@@ -63,24 +70,56 @@ func startJSONListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGro
 			timezoneOverride: v.Timezone_Override,
 			ctx:              ctx,
 			timeFormats:      cfg.TimeFormat,
+			formatDir:        cfg.formatDir,
 		}
-		if jhc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+		var proc *processors.ProcessorSet
+		if proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
 			lg.Fatal("preprocessor error", log.KVErr(err))
 		}
+		proc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
+		if jhc.proc, err = v.newEntProcessor(k, proc, ctx); err != nil {
+			return fmt.Errorf("JSONListener %s batching error: %v", k, err)
+		}
+		if ch, err := v.NewChunker(); err != nil {
+			return fmt.Errorf("JSONListener %s entry size error: %v", k, err)
+		} else {
+			jhc.proc = chunkWrap(k, ch, jhc.proc)
+		}
+		if jhc.meta, err = v.NewMetadataAttacher(); err != nil {
+			return fmt.Errorf("JSONListener %s metadata error: %v", k, err)
+		}
+		if jhc.cs, err = v.inputCharset(); err != nil {
+			return fmt.Errorf("JSONListener %s charset error: %v", k, err)
+		} else if jhc.sess, err = v.newSessionTagger(); err != nil {
+			return fmt.Errorf("JSONListener %s session error: %v", k, err)
+		} else if jhc.timeProfile, err = cfg.timeProfile(v.base); err != nil {
+			return fmt.Errorf("JSONListener %s time format profile error: %v", k, err)
+		}
+		if jhc.srcField, err = v.NewSourceExtractor(); err != nil {
+			return fmt.Errorf("JSONListener %s source field error: %v", k, err)
+		}
+		if jhc.schema, err = v.schemaCheck(igst); err != nil {
+			return fmt.Errorf("JSONListener %s schema error: %v", k, err)
+		} else if jhc.promote, err = v.newFieldPromoter(); err != nil {
+			return fmt.Errorf("JSONListener %s Promote-Field error: %v", k, err)
+		}
+		if q, err := v.newQuarantine(k, igst); err != nil {
+			return fmt.Errorf("JSONListener %s quarantine error: %v", k, err)
+		} else {
+			jhc.proc = q.wrap(jhc.proc)
+		}
 		f.Add(jhc.proc)
 		if jhc.flds, err = v.GetJsonFields(); err != nil {
 			return err
 		}
 		if v.Source_Override != `` {
-			jhc.src = net.ParseIP(v.Source_Override)
-			if jhc.src == nil {
-				return fmt.Errorf("JSONListener %v invalid source override \"%s\"", k, v.Source_Override)
+			if jhc.src, err = config.ParseSourceOverride(v.Source_Override); err != nil {
+				return fmt.Errorf("JSONListener %v invalid source override: %v", k, err)
 			}
 		} else if cfg.Source_Override != `` {
 			// global override
-			jhc.src = net.ParseIP(cfg.Source_Override)
-			if jhc.src == nil {
-				return fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
+			if jhc.src, err = config.ParseSourceOverride(cfg.Source_Override); err != nil {
+				return fmt.Errorf("invalid global source override: %v", err)
 			}
 		}
 		//resolve default tag
@@ -108,48 +147,59 @@ func startJSONListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGro
 			jhc.formatOverride = v.Timestamp_Format_Override
 		}
 
-		tp, str, err := translateBindType(v.Bind_String)
+		tp, str, err := netframe.ParseBind(v.Bind_String)
 		if err != nil {
 			lg.FatalCode(0, "invalid bind", log.KV("bindstring", v.Bind_String), log.KVErr(err))
 		}
 
+		ipf, err := v.ipFilter(k)
+		if err != nil {
+			return fmt.Errorf("JSONListener %s address filter error: %v", k, err)
+		}
 		if tp.TCP() {
 			//get the socket
 			addr, err := net.ResolveTCPAddr("tcp", v.Bind_String)
 			if err != nil {
 				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
 			}
-			l, err := net.ListenTCP("tcp", addr)
+			l, err := handover.listenTCP(handoverKey(`json`, k, v.Bind_String), "tcp", addr)
 			if err != nil {
 				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
 			}
-			connID := addConn(l)
+			fl := v.backpressure(ipf.Listener(l))
+			connID := connTracker.Add(fl)
 			//start the acceptor
 			wg.Add(1)
-			go jsonAcceptor(l, connID, igst, jhc, tp)
+			go jsonAcceptor(fl, connID, igst, jhc, tp)
 		} else if tp.TLS() {
-			config := &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			}
-
-			config.Certificates = make([]tls.Certificate, 1)
-			config.Certificates[0], err = tls.LoadX509KeyPair(v.Cert_File, v.Key_File)
-			if err != nil {
-				lg.Fatal("failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
-			}
 			//get the socket
-			addr, err := net.ResolveTCPAddr("tcp", str)
+			addr, err := net.ResolveTCPAddr(tp.Network(), str)
 			if err != nil {
 				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("jsonlistener", k), log.KVErr(err))
 			}
-			l, err := tls.Listen("tcp", addr.String(), config)
+			tl, err := handover.listenTCP(handoverKey(`json`, k, v.Bind_String), tp.Network(), addr)
 			if err != nil {
 				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("jsonlistener", k), log.KVErr(err))
 			}
-			connID := addConn(l)
+			l, err := tlsListener(v.backpressure(ipf.Listener(tl)), k, v.Cert_File, v.Key_File, v.Client_CA_File)
+			if err != nil {
+				lg.Fatal("failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
+			}
+			connID := connTracker.Add(l)
 			//start the acceptor
 			wg.Add(1)
 			go jsonAcceptor(l, connID, igst, jhc, tp)
+		} else if tp.Local() {
+			if err = startLocalListener(handoverKey(`json`, k, v.Bind_String), tp, str, v.base, func(l net.Listener) {
+				l = v.backpressure(l)
+				connID := connTracker.Add(l)
+				wg.Add(1)
+				go jsonAcceptor(l, connID, igst, jhc, tp)
+			}, nil, func(c net.Conn) {
+				go jsonConnHandler(c, jhc, igst)
+			}); err != nil {
+				return fmt.Errorf("JSONListener %s failed to listen on %s: %v", k, v.Bind_String, err)
+			}
 		}
 
 	}
@@ -157,52 +207,38 @@ func startJSONListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGro
 	return nil
 }
 
-func jsonAcceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg jsonHandlerConfig, tp bindType) {
+func jsonAcceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg jsonHandlerConfig, tp netframe.BindType) {
 	defer cfg.wg.Done()
-	defer delConn(id)
-	defer lst.Close()
-	var failCount int
-	for {
-		conn, err := lst.Accept()
-		if err != nil {
-			//i hate this... is there no damn error check that just says its closed or not?
-			if strings.Contains(err.Error(), "closed") {
-				break
-			}
-			failCount++
-			fmt.Fprintf(os.Stderr, "Failed to accept %v connection: %v\n", tp.String(), err)
-			if failCount > 3 {
-				break
-			}
-			continue
-		}
+	defer connTracker.Del(id)
+	netframe.Serve(lst, func(conn net.Conn) {
 		debugout("Accepted %v connection from %s in json mode\n", tp.String(), conn.RemoteAddr())
 		lg.Info("accepted connection", log.KV("address", conn.RemoteAddr()), log.KV("readertype", `json`), log.KV("mode", tp), log.KV("listener", cfg.name))
-		failCount = 0
-		go jsonConnHandler(conn, cfg, igst)
-	}
-	return
+		jsonConnHandler(conn, cfg, igst)
+	}, acceptOptions(`json`, cfg.name, tp))
 }
 
 func jsonConnHandler(c net.Conn, cfg jsonHandlerConfig, igst *ingest.IngestMuxer) {
 	cfg.wg.Add(1)
-	id := addConn(c)
+	id := connTracker.Add(c)
 	defer cfg.wg.Done()
-	defer delConn(id)
+	defer connTracker.Del(id)
 	defer c.Close()
+	cfg.proc = connProcessor(cfg.name, cfg.proc, cfg.meta, cfg.sess, c)
 	var rip net.IP
 	var ts entry.Timestamp
 	var tg *timegrinder.TimeGrinder
 	var tag entry.EntryTag
 	var ok bool
 
-	if cfg.src == nil {
+	if cfg.src == nil && isLocalConn(c) {
+		rip = localSourceIP
+	} else if cfg.src == nil {
 		ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String())
 		if err != nil {
 			lg.Error("failed to get host from remote addr", log.KV("remoteaddress", c.RemoteAddr().String()), log.KVErr(err))
 			return
 		}
-		if rip = net.ParseIP(ipstr); rip == nil {
+		if rip, _, err = config.ParseIPZone(ipstr); err != nil {
 			lg.Error("failed to get remote address", log.KV("remoteaddress", ipstr))
 			return
 		}
@@ -222,6 +258,12 @@ func jsonConnHandler(c net.Conn, cfg jsonHandlerConfig, igst *ingest.IngestMuxer
 		} else if err = cfg.timeFormats.LoadFormats(tg); err != nil {
 			lg.Error("failed to load custom time formats", log.KVErr(err))
 			return
+		} else if err = tg.SetFormatDirectory(cfg.formatDir); err != nil {
+			lg.Error("failed to load time format directory", log.KVErr(err))
+			return
+		} else if err = tg.SetFormatProfile(cfg.timeProfile); err != nil {
+			lg.Error("failed to load time format profile", log.KVErr(err))
+			return
 		}
 		if cfg.setLocalTime {
 			tg.SetLocalTime()
@@ -240,7 +282,13 @@ func jsonConnHandler(c net.Conn, cfg jsonHandlerConfig, igst *ingest.IngestMuxer
 			}
 		}
 	}
-	bio := bufio.NewReader(c)
+	var badPromotes int
+	defer func() {
+		if badPromotes > 0 {
+			lg.Info("fields could not be promoted to their type", log.KV("address", c.RemoteAddr()), log.KV("listener", cfg.name), log.KV("count", badPromotes))
+		}
+	}()
+	bio := bufio.NewReader(cfg.cs.Reader(c))
 	for {
 		//get the data entry and clean it a bit
 		data, err := bio.ReadBytes('\n')
@@ -271,11 +319,42 @@ func jsonConnHandler(c net.Conn, cfg jsonHandlerConfig, igst *ingest.IngestMuxer
 			tag = cfg.defTag
 		}
 		ent := &entry.Entry{
-			SRC:  cfg.src,
+			SRC:  cfg.srcField.Source(data, rip),
 			TS:   ts,
 			Tag:  tag,
 			Data: data,
 		}
+		if !cfg.schema.check(ent) {
+			continue
+		}
+		badPromotes += cfg.promote.promote(ent)
 		cfg.proc.ProcessContext(ent, cfg.ctx)
 	}
 }
+
+// jsonSchemaCheck validates entries against a listener's JSON Schema
+type jsonSchemaCheck struct {
+	schema *processors.JSONSchema
+	drop   bool
+	tag    entry.EntryTag
+	field  string
+	ann    processors.Annotator
+}
+
+// check returns false if the entry should be dropped, otherwise entries which fail validation are
+// retagged with the failure attached.  A nil check passes everything.
+func (jc *jsonSchemaCheck) check(ent *entry.Entry) bool {
+	if jc == nil {
+		return true
+	}
+	verr := jc.schema.Validate(ent.Data)
+	if verr == nil {
+		return true
+	} else if jc.drop {
+		return false
+	}
+	ent.Tag = jc.tag
+	//failing to attach the error shouldn't stop the entry from being routed
+	jc.ann.Annotate(ent, processors.Annotation{Name: jc.field, Value: verr.Error()})
+	return true
+}
@@ -17,26 +17,41 @@ import (
 	"unicode/utf8"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
 )
 
 const (
 	elemSep string = `:`
+
+	defaultSchemaErrorField = `schema_error`
 )
 
 var (
 	ErrMissingDefaultTag     = errors.New("Missing default tag")
 	ErrMissingJSONTagMatches = errors.New("Missing JSON Tag matches")
 	ErrEmptyJSONFields       = errors.New("Missing JSON field match")
+	ErrSchemaRejectAction    = errors.New("Schema-File requires exactly one of Schema-Reject-Tag or Schema-Drop-Rejects")
+	ErrSchemaOptsRequireFile = errors.New("Schema-Reject-Tag and Schema-Drop-Rejects require a Schema-File")
 )
 
 type jsonListener struct {
 	base
-	Extractor    string
-	Default_Tag  string
-	Tag_Match    []string
-	Cert_File    string
-	Key_File     string
-	Preprocessor []string
+	processors.SourceFieldConfig
+	Extractor      string
+	Default_Tag    string
+	Tag_Match      []string
+	Cert_File      string
+	Key_File       string
+	Client_CA_File string //CA bundle, when set clients must present a certificate signed by one of its CAs
+	Preprocessor   []string
+
+	Schema_File         string //JSON Schema every entry is validated against
+	Schema_Reject_Tag   string //tag applied to entries that fail validation
+	Schema_Drop_Rejects bool   //drop entries that fail validation instead of retagging them
+	Schema_Error_Field  string //field holding the validation failure on rejected entries, default is schema_error
+
+	Promote_Field []string //path[:name[:type]] fields copied into typed values on each entry
+	Promote_Into  string   //optional object the promoted fields are nested under
 }
 
 func (jl jsonListener) Validate() error {
@@ -57,6 +72,21 @@ func (jl jsonListener) Validate() error {
 	if _, err := jl.GetJsonFields(); err != nil {
 		return err
 	}
+
+	//check the payload source field
+	if err := jl.SourceFieldConfig.Validate(); err != nil {
+		return err
+	}
+
+	//check the schema
+	if _, err := jl.schemaCheck(nil); err != nil {
+		return err
+	}
+
+	//check the promoted fields
+	if _, err := jl.newFieldPromoter(); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -106,6 +136,12 @@ func (jl jsonListener) Tags() (tags []string, err error) {
 		}
 		mp[tag] = true
 	}
+	if jl.Schema_File != `` && jl.Schema_Reject_Tag != `` {
+		mp[jl.Schema_Reject_Tag] = true
+	}
+	for _, tag := range jl.quarantineTags() {
+		mp[tag] = true
+	}
 	for k, _ := range mp {
 		tags = append(tags, k)
 	}
@@ -141,6 +177,45 @@ func (jl jsonListener) GetJsonFields() (flds []string, err error) {
 	return getJsonFields(jl.Extractor)
 }
 
+// schemaCheck loads the listener schema, nil means there is no schema.  The reject tag is only
+// resolved if a muxer is provided.
+func (jl jsonListener) schemaCheck(igst *ingest.IngestMuxer) (jc *jsonSchemaCheck, err error) {
+	jl.Schema_Reject_Tag = strings.TrimSpace(jl.Schema_Reject_Tag)
+	if jl.Schema_File == `` {
+		if jl.Schema_Reject_Tag != `` || jl.Schema_Drop_Rejects {
+			err = ErrSchemaOptsRequireFile
+		}
+		return
+	} else if (jl.Schema_Reject_Tag == ``) == !jl.Schema_Drop_Rejects {
+		err = ErrSchemaRejectAction
+		return
+	} else if jl.Schema_Reject_Tag != `` {
+		if err = ingest.CheckTag(jl.Schema_Reject_Tag); err != nil {
+			err = fmt.Errorf("Invalid Schema-Reject-Tag %v", err)
+			return
+		}
+	}
+	jc = &jsonSchemaCheck{
+		drop:  jl.Schema_Drop_Rejects,
+		field: strings.TrimSpace(jl.Schema_Error_Field),
+	}
+	if jc.field == `` {
+		jc.field = defaultSchemaErrorField
+	}
+	if jc.schema, err = processors.LoadJSONSchema(jl.Schema_File); err != nil {
+		err = fmt.Errorf("Failed to load Schema-File %s: %w", jl.Schema_File, err)
+		return
+	} else if jc.ann, err = processors.NewAnnotator(``, ``); err != nil {
+		return
+	}
+	if igst != nil && jl.Schema_Reject_Tag != `` {
+		if jc.tag, err = igst.GetTag(jl.Schema_Reject_Tag); err != nil {
+			return
+		}
+	}
+	return
+}
+
 func getJsonFields(v string) (flds []string, err error) {
 	s := bufio.NewScanner(strings.NewReader(v))
 	s.Buffer(make([]byte, initDataSize), maxDataSize)
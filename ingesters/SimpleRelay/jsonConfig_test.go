@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/gravwell/gravwell/v3/ingest/entry"
@@ -195,3 +196,128 @@ Log-File=/opt/gravwell/log/simple_relay.log
 	Tag-Match=YYY:Ytag
 	`
 )
+
+func TestJSONSchemaCheck(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), `schema.json`)
+	if err := ioutil.WriteFile(pth, []byte(`{"type":"object","required":["host"]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	jl := jsonListener{
+		base:        base{Bind_String: `0.0.0.0:7777`},
+		Extractor:   `app`,
+		Default_Tag: `json`,
+		Tag_Match:   []string{`foo:foo`},
+		Schema_File: pth,
+	}
+	if err := jl.Validate(); err != ErrSchemaRejectAction {
+		t.Fatalf("missing reject action not caught: %v", err)
+	}
+	jl.Schema_Reject_Tag = `rejects`
+	if err := jl.Validate(); err != nil {
+		t.Fatal(err)
+	} else if tags, err := jl.Tags(); err != nil || len(tags) != 3 {
+		t.Fatalf("reject tag not listed: %v %v", tags, err)
+	}
+	jc, err := jl.schemaCheck(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jc.tag = 7
+	ent := &entry.Entry{Tag: 1, Data: []byte(`{"host":"a"}`)}
+	if !jc.check(ent) || ent.Tag != 1 {
+		t.Fatal("valid entry rejected")
+	}
+	ent.Data = []byte(`{"app":"b"}`)
+	if !jc.check(ent) || ent.Tag != 7 {
+		t.Fatal("invalid entry not retagged")
+	} else if exp := `{"app":"b","schema_error":"JSON Schema violation: /: missing required property \"host\""}`; string(ent.Data) != exp {
+		t.Fatalf("bad rejected entry: %s", ent.Data)
+	}
+
+	jl.Schema_Reject_Tag, jl.Schema_Drop_Rejects = ``, true
+	if jc, err = jl.schemaCheck(nil); err != nil {
+		t.Fatal(err)
+	} else if jc.check(&entry.Entry{Data: []byte(`[]`)}) {
+		t.Fatal("invalid entry not dropped")
+	}
+	jl.Schema_File = ``
+	if err = jl.Validate(); err != ErrSchemaOptsRequireFile {
+		t.Fatalf("schema options without a file not caught: %v", err)
+	}
+}
+
+func TestJSONFieldPromotion(t *testing.T) {
+	jl := jsonListener{
+		base:        base{Bind_String: `0.0.0.0:7777`},
+		Extractor:   `app`,
+		Default_Tag: `json`,
+		Tag_Match:   []string{`foo:foo`},
+		Promote_Field: []string{
+			`req.status:status:int`,
+			`req.bytes:bytes:uint`,
+			`req.took:took:float`,
+			`req.cached:cached:bool`,
+			`client:client:IP`,
+			`req.user`,
+		},
+	}
+	if err := jl.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	fp, err := jl.newFieldPromoter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in  string
+		out string
+		bad int
+	}{
+		{
+			in:  `{"client":"::ffff:10.0.0.1","req":{"status":"404","bytes":1.2e3,"took":"0.25","cached":"yes","user":"bob"}}`,
+			out: `{"client":"10.0.0.1","req":{"status":"404","bytes":1.2e3,"took":"0.25","cached":"yes","user":"bob"},"status":404,"bytes":1200,"took":0.25,"cached":true,"user":"bob"}`,
+		},
+		{ //missing and null fields are skipped
+			in:  `{"req":{"status":200,"user":null}}`,
+			out: `{"req":{"status":200,"user":null},"status":200}`,
+		},
+		{ //values that cannot be coerced are counted and skipped
+			in:  `{"client":"nope","req":{"status":"4.5","bytes":-1,"took":"NaN","cached":{}}}`,
+			out: `{"client":"nope","req":{"status":"4.5","bytes":-1,"took":"NaN","cached":{}}}`,
+			bad: 5,
+		},
+		{ //only JSON objects are annotated
+			in:  `[{"client":"10.0.0.1"}]`,
+			out: `[{"client":"10.0.0.1"}]`,
+		},
+	}
+	for _, tc := range tests {
+		ent := &entry.Entry{Data: []byte(tc.in)}
+		if bad := fp.promote(ent); bad != tc.bad {
+			t.Fatalf("bad count %d != %d for %s", bad, tc.bad, tc.in)
+		} else if string(ent.Data) != tc.out {
+			t.Fatalf("bad promotion of %s:\n%s\n%s", tc.in, ent.Data, tc.out)
+		}
+	}
+
+	jl.Promote_Into = `fields`
+	if fp, err = jl.newFieldPromoter(); err != nil {
+		t.Fatal(err)
+	}
+	ent := &entry.Entry{Data: []byte(`{"req":{"status":"500"}}`)}
+	if fp.promote(ent); string(ent.Data) != `{"req":{"status":"500"},"fields":{"status":500}}` {
+		t.Fatalf("bad nested promotion: %s", ent.Data)
+	}
+
+	for _, v := range [][]string{
+		{`a:b:c:d`},
+		{`a:b:text`},
+		{`a:x:int`, `b:x`},
+		{`app`},
+	} {
+		jl.Promote_Field, jl.Promote_Into = v, ``
+		if err := jl.Validate(); err == nil {
+			t.Fatalf("bad Promote-Field %v not caught", v)
+		}
+	}
+}
@@ -16,25 +16,29 @@ import (
 	"net"
 	"os"
 
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
 func lineConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 	cfg.wg.Add(1)
-	id := addConn(c)
+	id := connTracker.Add(c)
 	defer cfg.wg.Done()
-	defer delConn(id)
+	defer connTracker.Del(id)
 	defer c.Close()
+	cfg.proc = connProcessor(cfg.name, cfg.proc, cfg.meta, cfg.sess, c)
 	var rip net.IP
 
-	if cfg.src == nil {
+	if cfg.src == nil && isLocalConn(c) {
+		rip = localSourceIP
+	} else if cfg.src == nil {
 		ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String())
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to get host from rmote addr \"%s\": %v\n", c.RemoteAddr().String(), err)
 			return
 		}
-		if rip = net.ParseIP(ipstr); rip == nil {
+		if rip, _, err = config.ParseIPZone(ipstr); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to get remote addr from \"%s\"\n", ipstr)
 			return
 		}
@@ -55,6 +59,12 @@ func lineConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 		} else if err = cfg.timeFormats.LoadFormats(tg); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to load custom time formats: %v\n", err)
 			return
+		} else if err = tg.SetFormatDirectory(cfg.formatDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load time format directory: %v\n", err)
+			return
+		} else if err = tg.SetFormatProfile(cfg.timeProfile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load time format profile: %v\n", err)
+			return
 		}
 		if cfg.setLocalTime {
 			tg.SetLocalTime()
@@ -73,7 +83,7 @@ func lineConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 			}
 		}
 	}
-	bio := bufio.NewReader(c)
+	bio := bufio.NewReader(cfg.cs.Reader(c))
 	for {
 		data, err := bio.ReadBytes('\n')
 		data = bytes.Trim(data, "\n\r\t ")
@@ -98,9 +108,9 @@ func lineConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 	}
 }
 
-func lineConnHandlerUDP(c *net.UDPConn, cfg handlerConfig) {
+func lineConnHandlerUDP(c net.PacketConn, cfg handlerConfig) {
 	sp := []byte("\n")
-	buff := make([]byte, 16*1024) //local buffer that should be big enough for even the largest UDP packets
+	pr := newPacketReader(c, cfg.udpBatch)
 	tcfg := timegrinder.Config{
 		EnableLeftMostSeed: true,
 	}
@@ -111,6 +121,12 @@ func lineConnHandlerUDP(c *net.UDPConn, cfg handlerConfig) {
 	} else if err = cfg.timeFormats.LoadFormats(tg); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load custom time formats: %v\n", err)
 		return
+	} else if err = tg.SetFormatDirectory(cfg.formatDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load time format directory: %v\n", err)
+		return
+	} else if err = tg.SetFormatProfile(cfg.timeProfile); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load time format profile: %v\n", err)
+		return
 	}
 	if cfg.setLocalTime {
 		tg.SetLocalTime()
@@ -129,28 +145,27 @@ func lineConnHandlerUDP(c *net.UDPConn, cfg handlerConfig) {
 		}
 	}
 
+	local := isLocalPacketConn(c)
 	for {
-		var rip net.IP
-		n, raddr, err := c.ReadFromUDP(buff)
+		buff, raddr, err := pr.ReadPacket()
 		if err != nil {
 			break
-		}
-		if n == 0 {
+		} else if !cfg.ipf.AllowedAddr(raddr) {
 			continue
 		}
-		if raddr == nil {
+		if len(buff) == 0 {
 			continue
 		}
-		if n > len(buff) {
+		rip, ok := packetSourceIP(raddr, cfg.src, local)
+		if !ok {
 			continue
 		}
-		if cfg.src == nil {
-			rip = raddr.IP
-		} else {
-			rip = cfg.src
+		proc := packetProcessor(cfg.name, cfg.proc, cfg.meta, raddr)
+		if buff, err = cfg.cs.Decode(buff); err != nil {
+			continue
 		}
 
-		lns := bytes.Split(buff[:n], sp)
+		lns := bytes.Split(buff, sp)
 		for _, ln := range lns {
 			ln = bytes.Trim(ln, "\n\r\t ")
 			if len(ln) == 0 {
@@ -159,7 +174,7 @@ func lineConnHandlerUDP(c *net.UDPConn, cfg handlerConfig) {
 			//because we are using and reusing a local buffer, we have to copy the bytes when handing in
 			if ent, err := handleLog(append([]byte(nil), ln...), rip, cfg.ignoreTimestamps, cfg.tag, tg); err != nil {
 				return
-			} else if err = cfg.proc.ProcessContext(ent, cfg.ctx); err != nil {
+			} else if err = proc.ProcessContext(ent, cfg.ctx); err != nil {
 				return
 			}
 		}
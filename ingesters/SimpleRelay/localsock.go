@@ -0,0 +1,294 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+)
+
+var (
+	ErrSocketOptsRequireLocal = errors.New("Socket-Owner, Socket-Group, and Socket-Mode require a unix, unixgram, or fifo Bind-String")
+	ErrNotFIFO                = errors.New("path exists and is not a FIFO")
+	ErrNotSocket              = errors.New("path exists and is not a unix socket")
+	ErrDatagramUnsupported    = errors.New("unixgram sockets are only supported by line and rfc5424 listeners")
+	ErrFilterRequiresIP       = errors.New("Accept-From and Deny-From cannot be used with unix, unixgram, or fifo Bind-Strings")
+
+	// localSourceIP is the source applied to entries arriving over unix sockets and FIFOs
+	localSourceIP = net.IPv4(127, 0, 0, 1)
+)
+
+// socketPerms holds the ownership and permissions applied to unix sockets and FIFOs
+type socketPerms struct {
+	uid  int // -1 leaves the owner alone
+	gid  int // -1 leaves the group alone
+	mode os.FileMode
+	set  bool // mode was specified
+}
+
+func (l base) socketPerms() (sp socketPerms, err error) {
+	sp.uid, sp.gid = -1, -1
+	if l.Socket_Owner != `` {
+		if sp.uid, err = lookupId(l.Socket_Owner, func(s string) (string, error) {
+			u, err := user.Lookup(s)
+			if err != nil {
+				return ``, err
+			}
+			return u.Uid, nil
+		}); err != nil {
+			err = fmt.Errorf("invalid Socket-Owner %q: %v", l.Socket_Owner, err)
+			return
+		}
+	}
+	if l.Socket_Group != `` {
+		if sp.gid, err = lookupId(l.Socket_Group, func(s string) (string, error) {
+			g, err := user.LookupGroup(s)
+			if err != nil {
+				return ``, err
+			}
+			return g.Gid, nil
+		}); err != nil {
+			err = fmt.Errorf("invalid Socket-Group %q: %v", l.Socket_Group, err)
+			return
+		}
+	}
+	if l.Socket_Mode != `` {
+		var m uint64
+		if m, err = strconv.ParseUint(l.Socket_Mode, 8, 32); err != nil || m > 0777 {
+			err = fmt.Errorf("invalid Socket-Mode %q, expected octal permissions such as 0660", l.Socket_Mode)
+			return
+		}
+		sp.mode, sp.set = os.FileMode(m), true
+	}
+	return
+}
+
+// lookupId accepts a numeric id or resolves a name
+func lookupId(v string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(v); err == nil && id >= 0 {
+		return id, nil
+	}
+	s, err := lookup(v)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(s)
+}
+
+func (sp socketPerms) apply(pth string) (err error) {
+	if sp.set {
+		if err = os.Chmod(pth, sp.mode); err != nil {
+			return
+		}
+	}
+	if sp.uid >= 0 || sp.gid >= 0 {
+		err = os.Chown(pth, sp.uid, sp.gid)
+	}
+	return
+}
+
+// removeStaleSocket removes a socket file left behind by a previous run, anything else is left alone
+func removeStaleSocket(pth string) error {
+	fi, err := os.Lstat(pth)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	} else if fi.Mode()&os.ModeSocket == 0 {
+		return ErrNotSocket
+	}
+	return os.Remove(pth)
+}
+
+// listenUnix binds a unix stream socket, or picks up one handed over from a previous process
+func (hs *handoverSet) listenUnix(key, pth string, sp socketPerms) (l *net.UnixListener, err error) {
+	if f := hs.take(key); f != nil {
+		var fl net.Listener
+		fl, err = net.FileListener(f)
+		f.Close()
+		if err == nil {
+			var ok bool
+			if l, ok = fl.(*net.UnixListener); !ok {
+				fl.Close()
+				err = fmt.Errorf("inherited socket for %s is not a unix listener", key)
+			}
+		}
+		if err != nil {
+			lg.Warn("failed to reuse inherited socket, binding a new one", log.KV("socket", key), log.KVErr(err))
+			l, err = nil, nil
+		}
+	}
+	if l == nil {
+		if al, ok := hs.activated.Listener(`unix`, pth); ok {
+			if l, ok = al.(*net.UnixListener); !ok {
+				al.Close()
+				return nil, fmt.Errorf("activated socket for %s is not a unix listener", pth)
+			}
+			l.SetUnlinkOnClose(false) // systemd owns the socket path
+		} else if err = removeStaleSocket(pth); err != nil {
+			return
+		} else if l, err = net.ListenUnix(`unix`, &net.UnixAddr{Name: pth, Net: `unix`}); err != nil {
+			return
+		} else if err = sp.apply(pth); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	hs.add(key, l)
+	return
+}
+
+// listenUnixgram binds a unix datagram socket, or picks up one handed over from a previous process
+func (hs *handoverSet) listenUnixgram(key, pth string, sp socketPerms) (c *net.UnixConn, err error) {
+	if f := hs.take(key); f != nil {
+		var pc net.PacketConn
+		pc, err = net.FilePacketConn(f)
+		f.Close()
+		if err == nil {
+			var ok bool
+			if c, ok = pc.(*net.UnixConn); !ok {
+				pc.Close()
+				err = fmt.Errorf("inherited socket for %s is not a unix datagram socket", key)
+			}
+		}
+		if err != nil {
+			lg.Warn("failed to reuse inherited socket, binding a new one", log.KV("socket", key), log.KVErr(err))
+			c, err = nil, nil
+		}
+	}
+	if c == nil {
+		if pc, ok := hs.activated.PacketConn(`unixgram`, pth); ok {
+			if c, ok = pc.(*net.UnixConn); !ok {
+				pc.Close()
+				return nil, fmt.Errorf("activated socket for %s is not a unix datagram socket", pth)
+			}
+		} else if err = removeStaleSocket(pth); err != nil {
+			return
+		} else if c, err = net.ListenUnixgram(`unixgram`, &net.UnixAddr{Name: pth, Net: `unixgram`}); err != nil {
+			return
+		} else if err = sp.apply(pth); err != nil {
+			c.Close()
+			os.Remove(pth)
+			return nil, err
+		}
+	}
+	hs.add(key, c)
+	return
+}
+
+// fifoConn lets a named pipe stand in for a stream connection
+type fifoConn struct {
+	*os.File
+	addr *net.UnixAddr
+}
+
+// openFIFO creates the FIFO if needed and opens it for reading.  The FIFO is opened read/write so
+// that it never reports EOF when the last writer goes away.
+func openFIFO(pth string, sp socketPerms) (fc *fifoConn, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(pth); err != nil {
+		if !os.IsNotExist(err) {
+			return
+		}
+		mode := uint32(0660)
+		if sp.set {
+			mode = uint32(sp.mode)
+		}
+		if err = syscall.Mkfifo(pth, mode); err != nil {
+			return
+		}
+	} else if fi.Mode()&os.ModeNamedPipe == 0 {
+		err = ErrNotFIFO
+		return
+	}
+	if err = sp.apply(pth); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.OpenFile(pth, os.O_RDWR, 0); err != nil {
+		return
+	}
+	fc = &fifoConn{
+		File: f,
+		addr: &net.UnixAddr{Name: pth, Net: `fifo`},
+	}
+	return
+}
+
+func (fc *fifoConn) LocalAddr() net.Addr  { return fc.addr }
+func (fc *fifoConn) RemoteAddr() net.Addr { return fc.addr }
+
+// isLocalConn returns true for connections over unix sockets and FIFOs, which have no remote IP
+func isLocalConn(c net.Conn) bool {
+	_, ok := c.LocalAddr().(*net.UnixAddr)
+	return ok
+}
+
+func isLocalPacketConn(c net.PacketConn) bool {
+	_, ok := c.LocalAddr().(*net.UnixAddr)
+	return ok
+}
+
+// packetSourceIP returns the source IP for a datagram, ok is false if one cannot be determined
+func packetSourceIP(raddr net.Addr, override net.IP, local bool) (ip net.IP, ok bool) {
+	if override != nil {
+		return override, true
+	} else if local {
+		return localSourceIP, true
+	}
+	if ua, isUDP := raddr.(*net.UDPAddr); isUDP && ua != nil {
+		return ua.IP, true
+	}
+	return
+}
+
+// startLocalListener opens a unix socket or FIFO listener and hands it to the matching start function.
+// Listeners which cannot handle datagrams pass a nil startPacket.
+func startLocalListener(key string, tp netframe.BindType, pth string, b base, startStream func(net.Listener), startPacket func(net.PacketConn), startFIFO func(net.Conn)) error {
+	sp, err := b.socketPerms()
+	if err != nil {
+		return err
+	}
+	switch tp {
+	case netframe.Unix:
+		l, err := handover.listenUnix(key, pth, sp)
+		if err != nil {
+			return err
+		}
+		startStream(l)
+	case netframe.Unixgram:
+		if startPacket == nil {
+			return ErrDatagramUnsupported
+		}
+		c, err := handover.listenUnixgram(key, pth, sp)
+		if err != nil {
+			return err
+		}
+		startPacket(c)
+	case netframe.FIFO:
+		fc, err := openFIFO(pth, sp)
+		if err != nil {
+			return err
+		}
+		handover.addFIFO(fc)
+		startFIFO(fc)
+	default:
+		return fmt.Errorf("%v is not a local bind type", tp)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+)
+
+func TestLocalBindTypes(t *testing.T) {
+	tests := []struct {
+		bind string
+		tp   netframe.BindType
+		pth  string
+	}{
+		{`unix:///run/gravwell/relay.sock`, netframe.Unix, `/run/gravwell/relay.sock`},
+		{`unixgram:///dev/log`, netframe.Unixgram, `/dev/log`},
+		{`fifo:///var/spool/relay.fifo`, netframe.FIFO, `/var/spool/relay.fifo`},
+	}
+	for _, tst := range tests {
+		tp, pth, err := netframe.ParseBind(tst.bind)
+		if err != nil {
+			t.Fatal(err)
+		} else if tp != tst.tp || pth != tst.pth || !tp.Local() {
+			t.Fatalf("bad translation of %s: %v %s", tst.bind, tp, pth)
+		}
+	}
+	if tp, _, _ := netframe.ParseBind(`tcp://0.0.0.0:601`); tp.Local() {
+		t.Fatal("tcp is not local")
+	}
+}
+
+func TestSocketPermsValidate(t *testing.T) {
+	good := []base{
+		{Bind_String: `unix:///tmp/x.sock`, Socket_Mode: `0660`, Socket_Owner: `0`, Socket_Group: `0`},
+		{Bind_String: `fifo:///tmp/x.fifo`, Socket_Mode: `600`},
+		{Bind_String: `unixgram:///tmp/x.sock`},
+	}
+	for _, b := range good {
+		if err := b.Validate(); err != nil {
+			t.Fatalf("%+v failed validation: %v", b, err)
+		}
+	}
+	bad := []base{
+		{Bind_String: `0.0.0.0:7777`, Socket_Mode: `0660`},
+		{Bind_String: `unix:///tmp/x.sock`, Socket_Mode: `0999`},
+		{Bind_String: `unix:///tmp/x.sock`, Socket_Mode: `01777`},
+		{Bind_String: `unix://`},
+		{Bind_String: `fifo:///tmp/x.fifo`, Socket_Owner: `no-such-user-hopefully`},
+	}
+	for _, b := range bad {
+		if err := b.Validate(); err == nil {
+			t.Fatalf("%+v passed validation", b)
+		}
+	}
+}
+
+func TestUnixListener(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), `relay.sock`)
+	// a stale socket from a previous run must not block the bind
+	stale, err := net.ListenUnix(`unix`, &net.UnixAddr{Name: pth, Net: `unix`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	hs := newHandoverSet()
+	l, err := hs.listenUnix(handoverKey(`listener`, `test`, pth), pth, socketPerms{uid: -1, gid: -1, mode: 0620, set: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if fi, err := os.Stat(pth); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode().Perm() != 0620 {
+		t.Fatalf("bad socket permissions %v", fi.Mode().Perm())
+	}
+	go func() {
+		if c, err := net.Dial(`unix`, pth); err == nil {
+			c.Write([]byte("hello\n"))
+			c.Close()
+		}
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !isLocalConn(c) {
+		t.Fatal("unix connection not flagged as local")
+	}
+	if ln, err := bufio.NewReader(c).ReadString('\n'); err != nil || ln != "hello\n" {
+		t.Fatalf("bad read %q %v", ln, err)
+	}
+}
+
+func TestFIFO(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), `relay.fifo`)
+	fc, err := openFIFO(pth, socketPerms{uid: -1, gid: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fc.Close()
+	if fi, err := os.Stat(pth); err != nil {
+		t.Fatal(err)
+	} else if fi.Mode()&os.ModeNamedPipe == 0 {
+		t.Fatal("path is not a FIFO")
+	}
+	// multiple writers come and go without the reader seeing EOF
+	for _, msg := range []string{"first\n", "second\n"} {
+		w, err := os.OpenFile(pth, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(msg))
+		w.Close()
+	}
+	rdr := bufio.NewReader(fc)
+	for _, msg := range []string{"first\n", "second\n"} {
+		if ln, err := rdr.ReadString('\n'); err != nil || ln != msg {
+			t.Fatalf("bad read %q %v", ln, err)
+		}
+	}
+	if !isLocalConn(fc) {
+		t.Fatal("FIFO not flagged as local")
+	}
+
+	// regular files are not clobbered
+	reg := filepath.Join(t.TempDir(), `file`)
+	if err := os.WriteFile(reg, nil, 0600); err != nil {
+		t.Fatal(err)
+	} else if _, err = openFIFO(reg, socketPerms{uid: -1, gid: -1}); err != ErrNotFIFO {
+		t.Fatalf("expected ErrNotFIFO, got %v", err)
+	}
+}
+
+func TestPacketSourceIP(t *testing.T) {
+	over := net.ParseIP(`10.1.1.1`)
+	if ip, ok := packetSourceIP(nil, over, false); !ok || !ip.Equal(over) {
+		t.Fatal("override not applied")
+	}
+	if ip, ok := packetSourceIP(nil, nil, true); !ok || !ip.Equal(localSourceIP) {
+		t.Fatal("local datagram without a peer address not accepted")
+	}
+	if _, ok := packetSourceIP(nil, nil, false); ok {
+		t.Fatal("missing address accepted")
+	}
+	ua := &net.UDPAddr{IP: net.ParseIP(`192.168.1.1`), Port: 514}
+	if ip, ok := packetSourceIP(ua, nil, false); !ok || !ip.Equal(ua.IP) {
+		t.Fatal("udp source not used")
+	}
+}
@@ -13,7 +13,6 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"path/filepath"
 	"runtime/debug"
@@ -25,7 +24,7 @@ import (
 	"github.com/gravwell/gravwell/v3/ingest"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/log"
-	"github.com/gravwell/gravwell/v3/ingesters/utils"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
 	"github.com/gravwell/gravwell/v3/ingesters/version"
 )
 
@@ -46,6 +45,10 @@ var (
 	verbose        = flag.Bool("v", false, "Display verbose status updates to stdout")
 	stderrOverride = flag.String("stderr", "", "Redirect stderr to a shared memory file")
 	ver            = flag.Bool("version", false, "Print the version information and exit")
+	selftest       = flag.Bool("selftest", false, "Send synthetic entries through each listener's preprocessors to the indexers, report the results, and exit")
+	testTimestamp  = flag.Bool("test-timestamp", false, "Print the time format and timestamp found in each argument, or in each line of stdin if the argument is -, and exit.  With no arguments the time formats are listed")
+	testListener   = flag.String("test-listener", "", "Listener whose time settings are used by -test-timestamp")
+	drainTimeout   = flag.Duration("drain-timeout", 10*time.Minute, "Time to wait for existing connections to close after handing listeners to an upgraded process, 0 waits indefinitely")
 
 	v  bool
 	lg *log.Logger
@@ -85,7 +88,6 @@ func mainInit() {
 	}
 
 	v = *verbose
-	connClosers = make(map[int]closer, 1)
 }
 
 func main() {
@@ -106,6 +108,9 @@ func main() {
 		lg.FatalCode(0, "failed to get configuration", log.KVErr(err))
 		return
 	}
+	if *testTimestamp {
+		os.Exit(runTimestampTest(cfg, *testListener, flag.Args()))
+	}
 
 	if len(cfg.Log_File) > 0 {
 		fout, err := os.OpenFile(cfg.Log_File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
@@ -147,6 +152,14 @@ func main() {
 	if !ok {
 		lg.FatalCode(0, "Couldn't read ingester UUID")
 	}
+	if tc, err := cfg.TraceConfig(); err != nil {
+		lg.FatalCode(0, "invalid tracing configuration", log.KVErr(err))
+	} else if tc.Enabled() {
+		if tracer, err = tracing.New(tc); err != nil {
+			lg.FatalCode(0, "failed to start tracing", log.KVErr(err))
+		}
+		debugout("Tracing %v of entries to %s\n", tc.SampleRate, tc.Endpoint)
+	}
 	igCfg := ingest.UniformMuxerConfig{
 		IngestStreamConfig: cfg.IngestStreamConfig,
 		Destinations:       conns,
@@ -163,7 +176,12 @@ func main() {
 		CacheSize:          cfg.Max_Ingest_Cache,
 		CacheMode:          cfg.Cache_Mode,
 		Logger:             lg,
-		LogSourceOverride:  net.ParseIP(cfg.Log_Source_Override),
+		LogSourceOverride:  cfg.LogSourceOverride(),
+		Tracer:             tracer,
+	}
+	if igCfg.Secondary, err = ingest.SecondaryFromConfig(&cfg.IngestConfig); err != nil {
+		lg.FatalCode(0, "failed to get secondary backend targets from configuration", log.KVErr(err))
+		return
 	}
 	igst, err := ingest.NewUniformMuxer(igCfg)
 	if err != nil {
@@ -183,6 +201,10 @@ func main() {
 
 	debugout("Waiting for connections to indexers ... ")
 	if err := igst.WaitForHot(cfg.Timeout()); err != nil {
+		if *selftest {
+			fmt.Printf("FAIL: %v\n", err)
+			os.Exit(1)
+		}
 		lg.FatalCode(0, "timeout waiting for backend connections", log.KV("timeout", cfg.Timeout()), log.KVErr(err))
 		return
 	}
@@ -194,12 +216,43 @@ func main() {
 		lg.FatalCode(0, "failed to set configuration for ingester state messages", log.KV("ingesteruuid", id), log.KVErr(err))
 	}
 
+	if *selftest {
+		code := runSelfTest(cfg, igst)
+		igst.Close()
+		os.Exit(code)
+	}
+
+	if cfg.formatDir, err = cfg.TimeFormatDirectory(func(err error) {
+		lg.Error("failed to reload time formats", log.KV("path", cfg.Time_Format_Directory), log.KVErr(err))
+	}); err != nil {
+		lg.FatalCode(0, "failed to load time format directory", log.KV("path", cfg.Time_Format_Directory), log.KVErr(err))
+	} else if cfg.formatDir != nil {
+		defer cfg.formatDir.Close()
+	}
+
 	wg := &sync.WaitGroup{}
 
 	var flshr flusher
 
+	if cfg.DeviceProfile.Enabled() {
+		if profiler, err = newDeviceProfiler(cfg.DeviceProfile, igst); err != nil {
+			lg.FatalCode(0, "failed to start device profiling", log.KVErr(err))
+		}
+		profiler.Start()
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
+	if err := handover.loadActivated(); err != nil {
+		lg.FatalCode(0, "failed to load socket activated listeners", log.KVErr(err))
+	}
+
+	// accept failures are reported through the ingester state
+	acceptMon = newAcceptMonitor(igst)
+	if bpGauge, err = newBackpressureGauge(cfg.Backpressure, igst); err != nil {
+		lg.FatalCode(0, "invalid backpressure configuration", log.KVErr(err))
+	}
+
 	//fire off our simple listeners
 	if err := startSimpleListeners(cfg, igst, wg, &flshr, ctx); err != nil {
 		lg.FatalCode(0, "Failed to start simple listeners", log.KV("ingesteruuid", id), log.KVErr(err))
@@ -216,23 +269,22 @@ func main() {
 		return
 	}
 
+	handover.started()
 	lg.Info("Ingester running")
 
-	//listen for signals so we can close gracefully
-	utils.WaitForQuit()
-	debugout("Closing %d connections\n", connCount())
-	lg.Info("Closing active connections", log.KV("ingesteruuid", id), log.KV("active", connCount()))
+	//listen for signals so we can close gracefully, SIGUSR2 hands our listeners to a freshly started relay
+	if waitForQuitOrUpgrade() {
+		drainConnections(*drainTimeout)
+	}
+	debugout("Closing %d connections\n", connTracker.Count())
+	lg.Info("Closing active connections", log.KV("ingesteruuid", id), log.KV("active", connTracker.Count()))
 
 	go func() {
 		time.Sleep(time.Second)
 		cancel()
 	}()
 
-	mtx.Lock()
-	for _, v := range connClosers {
-		v.Close()
-	}
-	mtx.Unlock() //must unlock so they can delete their connections
+	connTracker.CloseAll()
 
 	//wait for everyone to exit with a timeout
 	wch := make(chan bool, 1)
@@ -244,11 +296,16 @@ func main() {
 	select {
 	case <-wch:
 	case <-time.After(1 * time.Second):
-		lg.Error("Failed to wait for all connections to close", log.KV("timeout", time.Second), log.KV("active", connCount()))
+		lg.Error("Failed to wait for all connections to close", log.KV("timeout", time.Second), log.KV("active", connTracker.Count()))
 	}
 	if err := flshr.Close(); err != nil {
 		lg.Error("failed to close preprocessors", log.KVErr(err))
 	}
+	if profiler != nil {
+		if err := profiler.Close(); err != nil {
+			lg.Error("failed to write device profiles", log.KVErr(err))
+		}
+	}
 	lg.Info("Ingester exiting", log.KV("ingesteruuid", id))
 	if err := igst.Sync(time.Second); err != nil {
 		lg.Error("failed to sync", log.KVErr(err))
@@ -256,6 +313,9 @@ func main() {
 	if err := igst.Close(); err != nil {
 		lg.Error("failed to close", log.KVErr(err))
 	}
+	if err := tracer.Close(); err != nil {
+		lg.Error("failed to export traces", log.KVErr(err))
+	}
 }
 
 func debugout(format string, args ...interface{}) {
@@ -0,0 +1,59 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+// metaProcessor attaches a fixed set of connection metadata to each entry before handing it on
+type metaProcessor struct {
+	entProcessor
+	meta *processors.MetadataAttacher
+	anns []processors.Annotation
+}
+
+func (mp metaProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	if ent != nil {
+		if err := mp.meta.Attach(ent, mp.anns); err != nil {
+			return err
+		}
+	}
+	return mp.entProcessor.ProcessContext(ent, ctx)
+}
+
+// connProcessor returns the processor a stream connection should use, attaching connection metadata and
+// session numbering if configured.  Device profiling sits in front of the metadata so it sees entries as they arrived.
+func connProcessor(name string, proc entProcessor, meta *processors.MetadataAttacher, sess *sessionTagger, c net.Conn) entProcessor {
+	proc = sess.wrap(proc)
+	if meta != nil {
+		proc = metaProcessor{
+			entProcessor: proc,
+			meta:         meta,
+			anns:         meta.Annotations(processors.ConnMetadataFromConn(name, c)),
+		}
+	}
+	return traceWrap(name, profiler.wrap(name, proc, c.RemoteAddr()), c.RemoteAddr())
+}
+
+// packetProcessor returns the processor a single datagram should use, attaching metadata if configured
+func packetProcessor(name string, proc entProcessor, meta *processors.MetadataAttacher, raddr net.Addr) entProcessor {
+	if meta != nil {
+		proc = metaProcessor{
+			entProcessor: proc,
+			meta:         meta,
+			anns:         meta.Annotations(processors.ConnMetadataFromAddr(name, raddr)),
+		}
+	}
+	return traceWrap(name, profiler.wrap(name, proc, raddr), raddr)
+}
@@ -0,0 +1,428 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	defaultProfileInterval    = 5 * time.Minute
+	defaultProfileIdleTimeout = 24 * time.Hour
+	defaultProfileMaxDevices  = 10000
+	minProfileInterval        = 10 * time.Second
+
+	formatSniffSize = 256
+
+	fmtJSON    = `json`
+	fmtRFC5424 = `rfc5424`
+	fmtRFC3164 = `rfc3164`
+	fmtCEF     = `cef`
+	fmtLEEF    = `leef`
+	fmtKV      = `kv`
+	fmtText    = `text`
+)
+
+var (
+	profiler *deviceProfiler // nil unless device profiling is enabled
+
+	ErrInvalidProfileInterval = fmt.Errorf("DeviceProfile Interval must be at least %v", minProfileInterval)
+)
+
+// deviceProfileConfig is the optional [DeviceProfile] section, when a tag is set the relay keeps
+// per source statistics and periodically emits a JSON profile entry for each device.
+type deviceProfileConfig struct {
+	Tag_Name     string // tag that profile entries are written to
+	Interval     string // how often profiles are emitted
+	Idle_Timeout string // devices not heard from for this long are dropped
+	Max_Devices  int    // upper bound on the number of tracked devices
+}
+
+func (dpc deviceProfileConfig) Enabled() bool {
+	return dpc.Tag_Name != ``
+}
+
+func (dpc deviceProfileConfig) Validate() error {
+	if !dpc.Enabled() {
+		return nil
+	}
+	if strings.ContainsAny(dpc.Tag_Name, ingest.FORBIDDEN_TAG_SET) {
+		return errors.New("Invalid characters in the DeviceProfile Tag-Name")
+	} else if dpc.Max_Devices < 0 {
+		return errors.New("DeviceProfile Max-Devices must be positive")
+	}
+	_, _, err := dpc.durations()
+	return err
+}
+
+func (dpc deviceProfileConfig) durations() (intv, idle time.Duration, err error) {
+	intv, idle = defaultProfileInterval, defaultProfileIdleTimeout
+	if s := strings.TrimSpace(dpc.Interval); s != `` {
+		if intv, err = time.ParseDuration(s); err != nil {
+			err = fmt.Errorf("Invalid DeviceProfile Interval %q: %v", s, err)
+			return
+		} else if intv < minProfileInterval {
+			err = ErrInvalidProfileInterval
+			return
+		}
+	}
+	if s := strings.TrimSpace(dpc.Idle_Timeout); s != `` {
+		if idle, err = time.ParseDuration(s); err != nil {
+			err = fmt.Errorf("Invalid DeviceProfile Idle-Timeout %q: %v", s, err)
+			return
+		} else if idle < intv {
+			err = errors.New("DeviceProfile Idle-Timeout must be at least as long as the Interval")
+			return
+		}
+	}
+	return
+}
+
+// profileWriter is where profile entries go, normally the ingest muxer
+type profileWriter interface {
+	WriteEntry(*entry.Entry) error
+}
+
+// deviceProfile is the JSON body of a profile entry, counts cover the last interval
+type deviceProfile struct {
+	Device       string
+	Listeners    []string
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	Entries      uint64
+	Bytes        uint64
+	Rate         float64 // entries per second over the interval
+	AverageSize  float64
+	TotalEntries uint64
+	TotalBytes   uint64
+	Formats      map[string]uint64 `json:",omitempty"`
+	SkewMin      float64           // timestamp skew in seconds, positive means the entry timestamp is behind our clock
+	SkewMean     float64
+	SkewMax      float64
+}
+
+// deviceStats accumulates what we have seen from a single device
+type deviceStats struct {
+	sync.Mutex
+	evicted   bool
+	addr      string
+	listeners map[string]bool
+	first     time.Time
+	last      time.Time
+	ents      uint64
+	bytes     uint64
+	totalEnts uint64
+	totalBts  uint64
+	formats   map[string]uint64
+	skewMin   time.Duration
+	skewMax   time.Duration
+	skewSum   time.Duration
+}
+
+func (ds *deviceStats) record(listener string, ent *entry.Entry, now time.Time) {
+	skew := now.Sub(ent.TS.StandardTime())
+	ds.Lock()
+	if !ds.listeners[listener] {
+		ds.listeners[listener] = true
+	}
+	if ds.ents == 0 || skew < ds.skewMin {
+		ds.skewMin = skew
+	}
+	if ds.ents == 0 || skew > ds.skewMax {
+		ds.skewMax = skew
+	}
+	ds.skewSum += skew
+	ds.ents++
+	ds.bytes += uint64(len(ent.Data))
+	ds.formats[detectFormat(ent.Data)]++
+	ds.last = now
+	ds.Unlock()
+}
+
+// profile builds the profile for the last interval and resets the interval counters
+func (ds *deviceStats) profile(elapsed time.Duration) (dp deviceProfile) {
+	ds.Lock()
+	defer ds.Unlock()
+	ds.totalEnts += ds.ents
+	ds.totalBts += ds.bytes
+	dp = deviceProfile{
+		Device:       ds.addr,
+		FirstSeen:    ds.first,
+		LastSeen:     ds.last,
+		Entries:      ds.ents,
+		Bytes:        ds.bytes,
+		TotalEntries: ds.totalEnts,
+		TotalBytes:   ds.totalBts,
+	}
+	for k := range ds.listeners {
+		dp.Listeners = append(dp.Listeners, k)
+	}
+	sort.Strings(dp.Listeners)
+	if ds.ents > 0 {
+		if elapsed > 0 {
+			dp.Rate = float64(ds.ents) / elapsed.Seconds()
+		}
+		dp.AverageSize = float64(ds.bytes) / float64(ds.ents)
+		dp.Formats = ds.formats
+		dp.SkewMin = ds.skewMin.Seconds()
+		dp.SkewMax = ds.skewMax.Seconds()
+		dp.SkewMean = (ds.skewSum / time.Duration(ds.ents)).Seconds()
+	}
+	ds.ents, ds.bytes, ds.skewSum = 0, 0, 0
+	ds.formats = map[string]uint64{}
+	return
+}
+
+// deviceProfiler tracks every device sending to the relay and emits a profile for each of them
+// on an interval, giving an automatically maintained inventory of sources.
+type deviceProfiler struct {
+	sync.Mutex
+	wtr     profileWriter
+	tag     entry.EntryTag
+	intv    time.Duration
+	idle    time.Duration
+	max     int
+	devices map[string]*deviceStats
+	dropped uint64 // entries from devices we could not track because we hit max
+	last    time.Time
+	die     chan bool
+	wg      sync.WaitGroup
+}
+
+func newDeviceProfiler(dpc deviceProfileConfig, igst *ingest.IngestMuxer) (dp *deviceProfiler, err error) {
+	var tag entry.EntryTag
+	if tag, err = igst.GetTag(dpc.Tag_Name); err != nil {
+		return
+	}
+	dp, err = newDeviceProfilerWriter(dpc, igst, tag)
+	return
+}
+
+func newDeviceProfilerWriter(dpc deviceProfileConfig, wtr profileWriter, tag entry.EntryTag) (dp *deviceProfiler, err error) {
+	var intv, idle time.Duration
+	if intv, idle, err = dpc.durations(); err != nil {
+		return
+	}
+	dp = &deviceProfiler{
+		wtr:     wtr,
+		tag:     tag,
+		intv:    intv,
+		idle:    idle,
+		max:     dpc.Max_Devices,
+		devices: map[string]*deviceStats{},
+		last:    time.Now(),
+		die:     make(chan bool),
+	}
+	if dp.max == 0 {
+		dp.max = defaultProfileMaxDevices
+	}
+	return
+}
+
+func (dp *deviceProfiler) Start() {
+	dp.wg.Add(1)
+	go dp.routine()
+}
+
+// Close stops the profiler and emits a final set of profiles
+func (dp *deviceProfiler) Close() error {
+	close(dp.die)
+	dp.wg.Wait()
+	return dp.emit(time.Now())
+}
+
+func (dp *deviceProfiler) routine() {
+	defer dp.wg.Done()
+	tckr := time.NewTicker(dp.intv)
+	defer tckr.Stop()
+	for {
+		select {
+		case now := <-tckr.C:
+			if err := dp.emit(now); err != nil {
+				lg.Error("failed to write device profiles", log.KVErr(err))
+			}
+		case <-dp.die:
+			return
+		}
+	}
+}
+
+// device returns the stats for the device at addr, nil if we are tracking too many devices
+func (dp *deviceProfiler) device(addr string) (ds *deviceStats) {
+	dp.Lock()
+	defer dp.Unlock()
+	if ds = dp.devices[addr]; ds != nil {
+		return
+	} else if len(dp.devices) >= dp.max {
+		dp.dropped++
+		return nil
+	}
+	ds = &deviceStats{
+		addr:      addr,
+		listeners: map[string]bool{},
+		formats:   map[string]uint64{},
+		first:     time.Now(),
+	}
+	dp.devices[addr] = ds
+	return
+}
+
+// emit writes a profile entry for every tracked device and drops devices that have gone idle
+func (dp *deviceProfiler) emit(now time.Time) (err error) {
+	dp.Lock()
+	devs := make([]*deviceStats, 0, len(dp.devices))
+	for k, ds := range dp.devices {
+		ds.Lock()
+		if now.Sub(ds.last) > dp.idle {
+			ds.evicted = true
+			delete(dp.devices, k)
+		} else {
+			devs = append(devs, ds)
+		}
+		ds.Unlock()
+	}
+	if dp.dropped > 0 {
+		lg.Warn("too many devices to profile", log.KV("max", dp.max), log.KV("untracked", dp.dropped))
+		dp.dropped = 0
+	}
+	elapsed := now.Sub(dp.last)
+	dp.last = now
+	dp.Unlock()
+
+	ts := entry.FromStandard(now)
+	for _, ds := range devs {
+		prof := ds.profile(elapsed)
+		var b []byte
+		if b, err = json.Marshal(prof); err != nil {
+			return
+		}
+		ent := &entry.Entry{
+			TS:   ts,
+			Tag:  dp.tag,
+			SRC:  net.ParseIP(prof.Device),
+			Data: b,
+		}
+		if err = dp.wtr.WriteEntry(ent); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// profileProcessor records entries against a device on their way into the rest of the pipeline
+type profileProcessor struct {
+	entProcessor
+	dp       *deviceProfiler
+	listener string
+	addr     string
+	ds       *deviceStats
+}
+
+func (pp *profileProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	if ent != nil {
+		if pp.ds == nil {
+			pp.ds = pp.dp.device(pp.addr)
+		}
+		if pp.ds != nil {
+			pp.ds.record(pp.listener, ent, time.Now())
+			//a long lived connection can go quiet long enough to be evicted, pick up a fresh record
+			pp.ds.Lock()
+			if pp.ds.evicted {
+				pp.ds = nil
+			}
+			pp.ds.Unlock()
+		}
+	}
+	return pp.entProcessor.ProcessContext(ent, ctx)
+}
+
+// wrap hands back a processor that records entries from raddr, the profiler may be nil
+func (dp *deviceProfiler) wrap(listener string, proc entProcessor, raddr net.Addr) entProcessor {
+	if dp == nil || raddr == nil {
+		return proc
+	}
+	addr := raddr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return &profileProcessor{
+		entProcessor: proc,
+		dp:           dp,
+		listener:     listener,
+		addr:         addr,
+	}
+}
+
+// detectFormat takes a quick look at the start of an entry and guesses at its format
+func detectFormat(b []byte) string {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	if len(b) > formatSniffSize {
+		b = b[:formatSniffSize]
+	}
+	if len(b) == 0 {
+		return fmtText
+	}
+	if bytes.Contains(b, []byte("CEF:")) {
+		return fmtCEF
+	} else if bytes.Contains(b, []byte("LEEF:")) {
+		return fmtLEEF
+	}
+	switch b[0] {
+	case '{', '[':
+		if json.Valid(b) || len(b) == formatSniffSize {
+			return fmtJSON
+		}
+	case '<':
+		if end := bytes.IndexByte(b, '>'); end > 1 && end <= 4 && isDigits(b[1:end]) {
+			if rest := b[end+1:]; len(rest) > 1 && rest[0] >= '1' && rest[0] <= '9' && rest[1] == ' ' {
+				return fmtRFC5424
+			}
+			return fmtRFC3164
+		}
+	}
+	if countKV(b) >= 2 {
+		return fmtKV
+	}
+	return fmtText
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+// countKV counts key=value pairs where the key is a run of word characters
+func countKV(b []byte) (cnt int) {
+	for i := 1; i < len(b); i++ {
+		if b[i] != '=' {
+			continue
+		}
+		if c := b[i-1]; (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' {
+			cnt++
+		}
+	}
+	return
+}
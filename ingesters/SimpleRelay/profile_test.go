@@ -0,0 +1,170 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+type profileCollector struct {
+	ents []*entry.Entry
+}
+
+func (pc *profileCollector) WriteEntry(ent *entry.Entry) error {
+	pc.ents = append(pc.ents, ent)
+	return nil
+}
+
+type nopProcessor struct {
+	cnt int
+}
+
+func (np *nopProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	np.cnt++
+	return nil
+}
+
+func (np *nopProcessor) Close() error {
+	return nil
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		data string
+		fmt  string
+	}{
+		{`{"foo": "bar", "baz": 1}`, fmtJSON},
+		{`[1, 2, 3]`, fmtJSON},
+		{`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed`, fmtRFC5424},
+		{`<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8`, fmtRFC3164},
+		{`<134>Oct 11 22:14:15 host CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1`, fmtCEF},
+		{`LEEF:1.0|Microsoft|MSExchange|4.0 SP1|15345|src=10.50.1.1`, fmtLEEF},
+		{`user=bob action=login result=ok`, fmtKV},
+		{`just a plain old log line, a=b`, fmtText},
+		{``, fmtText},
+	}
+	for _, tt := range tests {
+		if f := detectFormat([]byte(tt.data)); f != tt.fmt {
+			t.Errorf("detected %q as %s, expected %s", tt.data, f, tt.fmt)
+		}
+	}
+}
+
+func TestDeviceProfileConfig(t *testing.T) {
+	if (deviceProfileConfig{}).Enabled() {
+		t.Fatal("profiling enabled without a tag")
+	}
+	good := deviceProfileConfig{Tag_Name: `devices`, Interval: `1m`, Idle_Timeout: `1h`}
+	if err := good.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []deviceProfileConfig{
+		{Tag_Name: `dev ices`},
+		{Tag_Name: `devices`, Interval: `1s`},
+		{Tag_Name: `devices`, Interval: `foo`},
+		{Tag_Name: `devices`, Interval: `1h`, Idle_Timeout: `1m`},
+		{Tag_Name: `devices`, Max_Devices: -1},
+	}
+	for _, c := range bad {
+		if err := c.Validate(); err == nil {
+			t.Errorf("bad config %+v passed validation", c)
+		}
+	}
+}
+
+func TestDeviceProfiler(t *testing.T) {
+	pc := &profileCollector{}
+	dp, err := newDeviceProfilerWriter(deviceProfileConfig{Tag_Name: `devices`, Max_Devices: 2}, pc, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	np := &nopProcessor{}
+	addrs := []net.Addr{
+		&net.UDPAddr{IP: net.ParseIP(`10.0.0.1`), Port: 5000},
+		&net.TCPAddr{IP: net.ParseIP(`10.0.0.2`), Port: 6000},
+	}
+	now := time.Now()
+	for i, addr := range addrs {
+		proc := dp.wrap(`test`, np, addr)
+		for j := 0; j <= i; j++ {
+			ent := &entry.Entry{
+				TS:   entry.FromStandard(now.Add(-10 * time.Second)),
+				Data: []byte(`{"foo": "bar"}`),
+			}
+			if err := proc.ProcessContext(ent, context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if np.cnt != 3 {
+		t.Fatalf("entries were not passed on: %d", np.cnt)
+	}
+	//we are at our device limit, a third device should not be tracked
+	if ds := dp.device(`10.0.0.3`); ds != nil {
+		t.Fatal("tracked a device over the limit")
+	}
+	dp.dropped = 0
+
+	if err = dp.emit(time.Now()); err != nil {
+		t.Fatal(err)
+	} else if len(pc.ents) != 2 {
+		t.Fatalf("expected 2 profiles, got %d", len(pc.ents))
+	}
+	profs := map[string]deviceProfile{}
+	for _, ent := range pc.ents {
+		var prof deviceProfile
+		if err = json.Unmarshal(ent.Data, &prof); err != nil {
+			t.Fatal(err)
+		} else if ent.Tag != 7 || !ent.SRC.Equal(net.ParseIP(prof.Device)) {
+			t.Fatalf("bad profile entry: %v %v", ent.Tag, ent.SRC)
+		}
+		profs[prof.Device] = prof
+	}
+	prof, ok := profs[`10.0.0.2`]
+	if !ok {
+		t.Fatalf("missing profile: %+v", profs)
+	} else if prof.Entries != 2 || prof.Bytes != 28 || prof.AverageSize != 14 || prof.Formats[fmtJSON] != 2 {
+		t.Fatalf("bad profile: %+v", prof)
+	} else if prof.SkewMin < 9 || prof.SkewMax > 11 || prof.SkewMean < 9 || prof.SkewMean > 11 {
+		t.Fatalf("bad skew: %+v", prof)
+	} else if len(prof.Listeners) != 1 || prof.Listeners[0] != `test` {
+		t.Fatalf("bad listeners: %v", prof.Listeners)
+	}
+
+	//interval counters reset, totals carry over, and idle devices age out
+	pc.ents = nil
+	if err = dp.emit(time.Now()); err != nil {
+		t.Fatal(err)
+	} else if len(pc.ents) != 2 {
+		t.Fatalf("expected 2 profiles, got %d", len(pc.ents))
+	}
+	var prof2 deviceProfile
+	for _, ent := range pc.ents {
+		if err = json.Unmarshal(ent.Data, &prof2); err != nil {
+			t.Fatal(err)
+		} else if prof2.Device == `10.0.0.2` {
+			break
+		}
+	}
+	if prof2.Entries != 0 || prof2.TotalEntries != 2 || prof2.Formats != nil {
+		t.Fatalf("bad second profile: %+v", prof2)
+	}
+	pc.ents = nil
+	if err = dp.emit(time.Now().Add(2 * dp.idle)); err != nil {
+		t.Fatal(err)
+	} else if len(pc.ents) != 0 || len(dp.devices) != 0 {
+		t.Fatalf("idle devices were not dropped: %d %d", len(pc.ents), len(dp.devices))
+	}
+}
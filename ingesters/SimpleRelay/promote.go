@@ -0,0 +1,199 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	promoteString = `string`
+	promoteInt    = `int`
+	promoteUint   = `uint`
+	promoteFloat  = `float`
+	promoteBool   = `bool`
+	promoteIP     = `ip`
+)
+
+var (
+	ErrInvalidPromoteField = errors.New("Invalid Promote-Field, must be path[:name[:type]]")
+	ErrInvalidPromoteType  = errors.New("Invalid Promote-Field type, must be string, int, uint, float, bool, or ip")
+	ErrDuplicatePromote    = errors.New("Duplicate Promote-Field name")
+	ErrPromoteOntoSelf     = errors.New("Promote-Field would copy a string field onto itself")
+)
+
+// promotedField copies a single JSON field into a named, typed value
+type promotedField struct {
+	path []string
+	name string
+	tp   string
+}
+
+// fieldPromoter lifts selected fields of JSON entries into top level (or Promote-Into) values
+// coerced to a fixed type, so common query fields do not need search time extraction.  Entries
+// do not carry enumerated values in this tree, so the values are attached with an Annotator the
+// same way connection metadata is.  A nil fieldPromoter does nothing.
+type fieldPromoter struct {
+	flds []promotedField
+	ann  processors.Annotator
+}
+
+// newFieldPromoter builds the promoter for the listener, nil means nothing is promoted
+func (jl jsonListener) newFieldPromoter() (fp *fieldPromoter, err error) {
+	into := strings.TrimSpace(jl.Promote_Into)
+	if len(jl.Promote_Field) == 0 {
+		return
+	}
+	fp = &fieldPromoter{}
+	if fp.ann, err = processors.NewAnnotator(processors.AnnotateJSON, into); err != nil {
+		return
+	}
+	names := map[string]bool{}
+	for _, v := range jl.Promote_Field {
+		var pf promotedField
+		if pf, err = parsePromotedField(v); err != nil {
+			return
+		} else if names[pf.name] {
+			err = fmt.Errorf("%w %q", ErrDuplicatePromote, pf.name)
+			return
+		} else if into == `` && len(pf.path) == 1 && pf.path[0] == pf.name && pf.tp == promoteString {
+			err = fmt.Errorf("%w: %q", ErrPromoteOntoSelf, v)
+			return
+		}
+		names[pf.name] = true
+		fp.flds = append(fp.flds, pf)
+	}
+	return
+}
+
+// parsePromotedField parses a path[:name[:type]] specification, the name defaults to the last
+// element of the path and the type defaults to string.  A type requires a name.
+func parsePromotedField(v string) (pf promotedField, err error) {
+	var flds []string
+	s := bufio.NewScanner(strings.NewReader(v))
+	s.Buffer(make([]byte, initDataSize), maxDataSize)
+	s.Split(colonSplitter)
+	for s.Scan() {
+		flds = append(flds, s.Text())
+	}
+	if len(flds) == 0 || len(flds) > 3 {
+		err = fmt.Errorf("%w, got %q", ErrInvalidPromoteField, v)
+		return
+	} else if pf.path, err = getJsonFields(flds[0]); err != nil {
+		err = fmt.Errorf("%w, got %q", ErrInvalidPromoteField, v)
+		return
+	}
+	pf.name = pf.path[len(pf.path)-1]
+	pf.tp = promoteString
+	if len(flds) > 1 {
+		pf.name = flds[1]
+	}
+	if len(flds) > 2 {
+		switch pf.tp = strings.ToLower(flds[2]); pf.tp {
+		case promoteString, promoteInt, promoteUint, promoteFloat, promoteBool, promoteIP:
+		default:
+			err = fmt.Errorf("%w, got %q", ErrInvalidPromoteType, flds[2])
+		}
+	}
+	return
+}
+
+// promote attaches each configured field that is present and can be coerced to its type, existing
+// fields with the same name are replaced.  Missing and null fields are skipped, the number of
+// fields which could not be coerced is returned.
+func (fp *fieldPromoter) promote(ent *entry.Entry) (bad int) {
+	if fp == nil {
+		return
+	}
+	anns := make([]processors.Annotation, 0, len(fp.flds))
+	for _, pf := range fp.flds {
+		val, dt, _, err := jsonparser.Get(ent.Data, pf.path...)
+		if err != nil || dt == jsonparser.Null || dt == jsonparser.NotExist {
+			continue
+		}
+		if dt == jsonparser.String {
+			var s string
+			if s, err = jsonparser.ParseString(val); err != nil {
+				bad++
+				continue
+			}
+			val = []byte(s)
+		}
+		if an, ok := coercePromoted(pf, string(val), dt); ok {
+			anns = append(anns, an)
+		} else {
+			bad++
+		}
+	}
+	if len(anns) > 0 {
+		if _, err := fp.ann.Annotate(ent, anns...); err != nil {
+			bad += len(anns)
+		}
+	}
+	return
+}
+
+// coercePromoted converts a raw field value to the promoted type, numbers and booleans become
+// JSON literals and IPs are written in their canonical form
+func coercePromoted(pf promotedField, v string, dt jsonparser.ValueType) (an processors.Annotation, ok bool) {
+	an.Name = pf.name
+	switch pf.tp {
+	case promoteString:
+		an.Value, ok = v, true
+		return
+	case promoteIP:
+		if ip := net.ParseIP(strings.TrimSpace(v)); ip != nil {
+			an.Value, ok = ip.String(), true
+		}
+		return
+	}
+	if dt == jsonparser.Object || dt == jsonparser.Array {
+		return
+	}
+	v = strings.TrimSpace(v)
+	an.Literal = true
+	switch pf.tp {
+	case promoteInt:
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			an.Value, ok = strconv.FormatInt(i, 10), true
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil && f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			an.Value, ok = strconv.FormatInt(int64(f), 10), true
+		}
+	case promoteUint:
+		if i, err := strconv.ParseUint(v, 10, 64); err == nil {
+			an.Value, ok = strconv.FormatUint(i, 10), true
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil && f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 {
+			an.Value, ok = strconv.FormatUint(uint64(f), 10), true
+		}
+	case promoteFloat:
+		//JSON has no representation for NaN or infinity
+		if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			an.Value, ok = strconv.FormatFloat(f, 'g', -1, 64), true
+		}
+	case promoteBool:
+		if b, err := strconv.ParseBool(v); err == nil {
+			an.Value, ok = strconv.FormatBool(b), true
+		} else if strings.EqualFold(v, `yes`) || strings.EqualFold(v, `on`) {
+			an.Value, ok = `true`, true
+		} else if strings.EqualFold(v, `no`) || strings.EqualFold(v, `off`) {
+			an.Value, ok = `false`, true
+		}
+	}
+	return
+}
@@ -0,0 +1,201 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+)
+
+const (
+	binarySniffSize       = 512 // bytes at the start of an entry checked for binary data
+	quarantineLogInterval = time.Minute
+)
+
+var (
+	ErrQuarantineAction      = errors.New("Quarantine-Tag and Quarantine-Drop are mutually exclusive")
+	ErrQuarantineNoAction    = errors.New("Quarantine-Max-Size requires Quarantine-Tag or Quarantine-Drop")
+	ErrInvalidQuarantineSize = errors.New("Invalid Quarantine-Max-Size")
+)
+
+// quarantineConfig keeps binary blobs and oversized records out of text tags.  Entries that look
+// like binary data, or are larger than the max size, are moved to the quarantine tag or dropped.
+// Either way they are counted and the counts are logged periodically.
+type quarantineConfig struct {
+	Quarantine_Tag      string // tag binary and oversized entries are moved to
+	Quarantine_Drop     bool   // drop binary and oversized entries instead of retagging them
+	Quarantine_Max_Size string // entries larger than this are quarantined, such as 64KB
+}
+
+func (qc quarantineConfig) enabled() bool {
+	return strings.TrimSpace(qc.Quarantine_Tag) != `` || qc.Quarantine_Drop
+}
+
+func (qc quarantineConfig) maxSize() (sz int64, err error) {
+	if qc.Quarantine_Max_Size == `` {
+		return
+	} else if !qc.enabled() {
+		err = ErrQuarantineNoAction
+	} else if sz, err = config.ParseDataSize(qc.Quarantine_Max_Size); err != nil || sz <= 0 {
+		err = fmt.Errorf("%w %q", ErrInvalidQuarantineSize, qc.Quarantine_Max_Size)
+	}
+	return
+}
+
+func (qc quarantineConfig) Validate() (err error) {
+	if tag := strings.TrimSpace(qc.Quarantine_Tag); tag != `` {
+		if qc.Quarantine_Drop {
+			return ErrQuarantineAction
+		} else if err = ingest.CheckTag(tag); err != nil {
+			return fmt.Errorf("Invalid Quarantine-Tag %v", err)
+		}
+	}
+	_, err = qc.maxSize()
+	return
+}
+
+// quarantineTags returns the quarantine tag, if there is one
+func (qc quarantineConfig) quarantineTags() (tags []string) {
+	if tag := strings.TrimSpace(qc.Quarantine_Tag); tag != `` {
+		tags = append(tags, tag)
+	}
+	return
+}
+
+// quarantine counts and moves entries, it is shared by every connection on a listener
+type quarantine struct {
+	name      string
+	drop      bool
+	tag       entry.EntryTag
+	maxSize   int
+	binary    uint64 // atomic count of binary entries
+	oversized uint64 // atomic count of oversized entries
+	logged    int64  // unix time the counts were last logged
+}
+
+// newQuarantine resolves the quarantine tag, the quarantine is nil if it is not enabled
+func (qc quarantineConfig) newQuarantine(name string, tg tagGetter) (q *quarantine, err error) {
+	if !qc.enabled() {
+		return
+	}
+	var sz int64
+	if err = qc.Validate(); err != nil {
+		return
+	} else if sz, err = qc.maxSize(); err != nil {
+		return
+	}
+	q = &quarantine{
+		name:    name,
+		drop:    qc.Quarantine_Drop,
+		maxSize: int(sz),
+		logged:  time.Now().Unix(),
+	}
+	if !q.drop {
+		if q.tag, err = tg.GetTag(strings.TrimSpace(qc.Quarantine_Tag)); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// check returns false if the entry should be dropped, otherwise quarantined entries are retagged
+func (q *quarantine) check(ent *entry.Entry) bool {
+	if q.maxSize > 0 && len(ent.Data) > q.maxSize {
+		atomic.AddUint64(&q.oversized, 1)
+	} else if isBinary(ent.Data) {
+		atomic.AddUint64(&q.binary, 1)
+	} else {
+		return true
+	}
+	q.report()
+	if q.drop {
+		return false
+	}
+	ent.Tag = q.tag
+	return true
+}
+
+// report logs the running counts, at most once per interval
+func (q *quarantine) report() {
+	now := time.Now().Unix()
+	last := atomic.LoadInt64(&q.logged)
+	if now-last < int64(quarantineLogInterval/time.Second) || !atomic.CompareAndSwapInt64(&q.logged, last, now) {
+		return
+	}
+	action := `quarantined`
+	if q.drop {
+		action = `dropped`
+	}
+	lg.Warn("binary or oversized entries "+action, log.KV("listener", q.name),
+		log.KV("binary", atomic.LoadUint64(&q.binary)), log.KV("oversized", atomic.LoadUint64(&q.oversized)))
+}
+
+// isBinary reports whether data looks like a binary blob rather than text.  A NUL byte gives it
+// away, otherwise it is binary if more than one in ten of the leading bytes are control
+// characters or invalid UTF-8.
+func isBinary(b []byte) bool {
+	if len(b) > binarySniffSize {
+		b = b[:binarySniffSize]
+	}
+	var bad int
+	for i := 0; i < len(b); {
+		r, sz := utf8.DecodeRune(b[i:])
+		switch {
+		case r == 0:
+			return true
+		case r == utf8.RuneError && sz == 1:
+			if !utf8.FullRune(b[i:]) {
+				//the sample cut a character short
+				i = len(b)
+				continue
+			}
+			bad++
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != '\v' && r != 0x1b:
+			bad++
+		case r == 0x7f:
+			bad++
+		}
+		i += sz
+	}
+	return bad*10 > len(b)
+}
+
+// quarantineProcessor quarantines entries before handing them on
+type quarantineProcessor struct {
+	entProcessor
+	q *quarantine
+}
+
+func (qp quarantineProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	if ent != nil && !qp.q.check(ent) {
+		return nil
+	}
+	return qp.entProcessor.ProcessContext(ent, ctx)
+}
+
+// wrap hands back a processor that quarantines entries, the quarantine may be nil
+func (q *quarantine) wrap(proc entProcessor) entProcessor {
+	if q == nil {
+		return proc
+	}
+	return quarantineProcessor{
+		entProcessor: proc,
+		q:            q,
+	}
+}
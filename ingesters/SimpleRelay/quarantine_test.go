@@ -0,0 +1,120 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestIsBinary(t *testing.T) {
+	text := []string{
+		``,
+		`<34>1 2022-01-01T00:00:00Z host app - - - hello`,
+		"multi\r\nline\twith tabs",
+		"\x1b[31mcolored\x1b[0m",
+		`日本語のログ`,
+		strings.Repeat(`é`, binarySniffSize), //the sample ends part way through a character
+	}
+	for _, v := range text {
+		if isBinary([]byte(v)) {
+			t.Fatalf("%q detected as binary", v)
+		}
+	}
+	binary := []string{
+		"text with a \x00 nul",
+		"\x89PNG\r\n\x1a\n\x01\x02\x03\x04\x05\x06",
+		"\xff\xfe\xfd\xfc\xfb mostly garbage",
+	}
+	for _, v := range binary {
+		if !isBinary([]byte(v)) {
+			t.Fatalf("%q not detected as binary", v)
+		}
+	}
+}
+
+func TestQuarantineConfig(t *testing.T) {
+	good := []quarantineConfig{
+		{},
+		{Quarantine_Tag: `quarantine`},
+		{Quarantine_Drop: true, Quarantine_Max_Size: `64KB`},
+	}
+	for _, qc := range good {
+		if err := qc.Validate(); err != nil {
+			t.Fatalf("%+v failed validation: %v", qc, err)
+		}
+	}
+	bad := []struct {
+		qc  quarantineConfig
+		err error
+	}{
+		{quarantineConfig{Quarantine_Tag: `quarantine`, Quarantine_Drop: true}, ErrQuarantineAction},
+		{quarantineConfig{Quarantine_Max_Size: `1MB`}, ErrQuarantineNoAction},
+		{quarantineConfig{Quarantine_Drop: true, Quarantine_Max_Size: `big`}, ErrInvalidQuarantineSize},
+		{quarantineConfig{Quarantine_Drop: true, Quarantine_Max_Size: `0`}, ErrInvalidQuarantineSize},
+	}
+	for _, b := range bad {
+		if err := b.qc.Validate(); !errors.Is(err, b.err) {
+			t.Fatalf("bad error on %+v: %v", b.qc, err)
+		}
+	}
+	if err := (quarantineConfig{Quarantine_Tag: `bad tag`}).Validate(); err == nil {
+		t.Fatal("invalid tag passed validation")
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	tt := testTags{`default`, `quarantine`}
+	ents := []string{`fine`, "bin\x00ary", strings.Repeat(`a`, 100), `also fine`}
+
+	qc := quarantineConfig{Quarantine_Tag: `quarantine`, Quarantine_Max_Size: `64B`}
+	q, err := qc.newQuarantine(`test`, tt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := &tagProcessor{}
+	proc := q.wrap(tp)
+	for _, v := range ents {
+		if err = proc.ProcessContext(&entry.Entry{Data: []byte(v)}, context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tp.tags) != 4 || tp.tags[0] != 0 || tp.tags[1] != 1 || tp.tags[2] != 1 || tp.tags[3] != 0 {
+		t.Fatalf("bad tags %v", tp.tags)
+	} else if q.binary != 1 || q.oversized != 1 {
+		t.Fatalf("bad counts %d %d", q.binary, q.oversized)
+	}
+
+	//dropping only counts
+	qc = quarantineConfig{Quarantine_Drop: true}
+	if q, err = qc.newQuarantine(`test`, tt); err != nil {
+		t.Fatal(err)
+	}
+	np := &nopProcessor{}
+	proc = q.wrap(np)
+	for _, v := range ents {
+		if err = proc.ProcessContext(&entry.Entry{Data: []byte(v)}, context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if np.cnt != 3 || q.binary != 1 || q.oversized != 0 {
+		t.Fatalf("bad counts %d %d %d", np.cnt, q.binary, q.oversized)
+	}
+
+	//disabled is a passthrough
+	if q, err = (quarantineConfig{}).newQuarantine(`test`, tt); err != nil || q != nil {
+		t.Fatalf("disabled quarantine %v %v", q, err)
+	} else if q.wrap(np) != entProcessor(np) {
+		t.Fatal("disabled quarantine wrapped the processor")
+	}
+}
@@ -18,10 +18,12 @@ import (
 
 type regexListener struct {
 	base
+	tagRouteConfig
 	Regex           string
 	Tag_Name        string
 	Cert_File       string
 	Key_File        string
+	Client_CA_File  string //CA bundle, when set clients must present a certificate signed by one of its CAs
 	Trim_Whitespace bool
 	Max_Buffer      int // maximum number of bytes to buffer without finding a regular expression
 	Preprocessor    []string
@@ -12,20 +12,20 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
 	"regexp"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/charset"
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
@@ -42,12 +42,17 @@ type regexHandlerConfig struct {
 	src              net.IP
 	wg               *sync.WaitGroup
 	formatOverride   string
-	proc             *processors.ProcessorSet
+	proc             entProcessor
+	meta             *processors.MetadataAttacher
 	ctx              context.Context
 	regex            string
 	timeFormats      config.CustomTimeFormat
+	formatDir        *timegrinder.FormatDirectory
+	timeProfile      []string // formats the listener is restricted to, nil for all
 	trimWhitespace   bool
 	maxBuffer        int
+	cs               charset.Charset
+	sess             *sessionTagger
 }
 
 func startRegexListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, f *flusher, ctx context.Context) error {
@@ -66,33 +71,62 @@ func startRegexListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGr
 			timezoneOverride: v.Timezone_Override,
 			ctx:              ctx,
 			timeFormats:      cfg.TimeFormat,
+			formatDir:        cfg.formatDir,
 			regex:            v.Regex,
 			trimWhitespace:   v.Trim_Whitespace,
 			maxBuffer:        v.Max_Buffer,
 		}
-		if rhc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+		var proc *processors.ProcessorSet
+		if proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
 			lg.Fatal("preprocessor error", log.KVErr(err))
 		}
+		proc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
+		if rhc.proc, err = v.newEntProcessor(k, proc, ctx); err != nil {
+			return fmt.Errorf("RegexListener %s batching error: %v", k, err)
+		}
+		if ch, err := v.NewChunker(); err != nil {
+			return fmt.Errorf("RegexListener %s entry size error: %v", k, err)
+		} else {
+			rhc.proc = chunkWrap(k, ch, rhc.proc)
+		}
+		if rhc.meta, err = v.NewMetadataAttacher(); err != nil {
+			return fmt.Errorf("RegexListener %s metadata error: %v", k, err)
+		}
+		if rhc.cs, err = v.inputCharset(); err != nil {
+			return fmt.Errorf("RegexListener %s charset error: %v", k, err)
+		} else if rhc.sess, err = v.newSessionTagger(); err != nil {
+			return fmt.Errorf("RegexListener %s session error: %v", k, err)
+		} else if rhc.timeProfile, err = cfg.timeProfile(v.base); err != nil {
+			return fmt.Errorf("RegexListener %s time format profile error: %v", k, err)
+		}
 		f.Add(rhc.proc)
 		if _, err = regexp.Compile(v.Regex); err != nil {
 			return err
 		}
 		if v.Source_Override != `` {
-			rhc.src = net.ParseIP(v.Source_Override)
-			if rhc.src == nil {
-				return fmt.Errorf("RegexListener %v invalid source override \"%s\"", k, v.Source_Override)
+			if rhc.src, err = config.ParseSourceOverride(v.Source_Override); err != nil {
+				return fmt.Errorf("RegexListener %v invalid source override: %v", k, err)
 			}
 		} else if cfg.Source_Override != `` {
 			// global override
-			rhc.src = net.ParseIP(cfg.Source_Override)
-			if rhc.src == nil {
-				return fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
+			if rhc.src, err = config.ParseSourceOverride(cfg.Source_Override); err != nil {
+				return fmt.Errorf("invalid global source override: %v", err)
 			}
 		}
 		//resolve default tag
 		if rhc.defTag, err = igst.GetTag(v.Tag_Name); err != nil {
 			return err
 		}
+		if tr, err := v.newTagRouter(igst, rhc.defTag); err != nil {
+			return fmt.Errorf("RegexListener %s tag route error: %v", k, err)
+		} else {
+			rhc.proc = tr.wrap(rhc.proc)
+		}
+		if q, err := v.newQuarantine(k, igst); err != nil {
+			return fmt.Errorf("RegexListener %s quarantine error: %v", k, err)
+		} else {
+			rhc.proc = q.wrap(rhc.proc)
+		}
 
 		//check format override
 		if v.Timestamp_Format_Override != `` {
@@ -102,48 +136,59 @@ func startRegexListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGr
 			rhc.formatOverride = v.Timestamp_Format_Override
 		}
 
-		tp, str, err := translateBindType(v.Bind_String)
+		tp, str, err := netframe.ParseBind(v.Bind_String)
 		if err != nil {
 			lg.FatalCode(0, "invalid bind", log.KV("bindstring", v.Bind_String), log.KVErr(err))
 		}
 
+		ipf, err := v.ipFilter(k)
+		if err != nil {
+			return fmt.Errorf("RegexListener %s address filter error: %v", k, err)
+		}
 		if tp.TCP() {
 			//get the socket
 			addr, err := net.ResolveTCPAddr("tcp", v.Bind_String)
 			if err != nil {
 				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
 			}
-			l, err := net.ListenTCP("tcp", addr)
+			l, err := handover.listenTCP(handoverKey(`regex`, k, v.Bind_String), "tcp", addr)
 			if err != nil {
 				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
 			}
-			connID := addConn(l)
+			fl := v.backpressure(ipf.Listener(l))
+			connID := connTracker.Add(fl)
 			//start the acceptor
 			wg.Add(1)
-			go regexAcceptor(l, connID, igst, rhc, tp)
+			go regexAcceptor(fl, connID, igst, rhc, tp)
 		} else if tp.TLS() {
-			config := &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			}
-
-			config.Certificates = make([]tls.Certificate, 1)
-			config.Certificates[0], err = tls.LoadX509KeyPair(v.Cert_File, v.Key_File)
-			if err != nil {
-				lg.Fatal("failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
-			}
 			//get the socket
-			addr, err := net.ResolveTCPAddr("tcp", str)
+			addr, err := net.ResolveTCPAddr(tp.Network(), str)
 			if err != nil {
 				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("regexlistener", k), log.KVErr(err))
 			}
-			l, err := tls.Listen("tcp", addr.String(), config)
+			tl, err := handover.listenTCP(handoverKey(`regex`, k, v.Bind_String), tp.Network(), addr)
 			if err != nil {
 				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("regexlistener", k), log.KVErr(err))
 			}
-			connID := addConn(l)
+			l, err := tlsListener(v.backpressure(ipf.Listener(tl)), k, v.Cert_File, v.Key_File, v.Client_CA_File)
+			if err != nil {
+				lg.Fatal("failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
+			}
+			connID := connTracker.Add(l)
 			//start the acceptor
 			wg.Add(1)
 			go regexAcceptor(l, connID, igst, rhc, tp)
+		} else if tp.Local() {
+			if err = startLocalListener(handoverKey(`regex`, k, v.Bind_String), tp, str, v.base, func(l net.Listener) {
+				l = v.backpressure(l)
+				connID := connTracker.Add(l)
+				wg.Add(1)
+				go regexAcceptor(l, connID, igst, rhc, tp)
+			}, nil, func(c net.Conn) {
+				go regexConnHandler(c, rhc, igst)
+			}); err != nil {
+				return fmt.Errorf("RegexListener %s failed to listen on %s: %v", k, v.Bind_String, err)
+			}
 		}
 
 	}
@@ -151,48 +196,34 @@ func startRegexListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGr
 	return nil
 }
 
-func regexAcceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg regexHandlerConfig, tp bindType) {
+func regexAcceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg regexHandlerConfig, tp netframe.BindType) {
 	defer cfg.wg.Done()
-	defer delConn(id)
-	defer lst.Close()
-	var failCount int
-	for {
-		conn, err := lst.Accept()
-		if err != nil {
-			//i hate this... is there no damn error check that just says its closed or not?
-			if strings.Contains(err.Error(), "closed") {
-				break
-			}
-			failCount++
-			lg.Info("failed to accept connection", log.KV("readertype", `regex`), log.KV("mode", tp.String()), log.KVErr(err))
-			if failCount > 3 {
-				break
-			}
-			continue
-		}
+	defer connTracker.Del(id)
+	netframe.Serve(lst, func(conn net.Conn) {
 		debugout("Accepted %v connection from %s in regex mode\n", tp.String(), conn.RemoteAddr())
 		lg.Info("accepted connection", log.KV("address", conn.RemoteAddr()), log.KV("readertype", `regex`), log.KV("mode", tp), log.KV("listener", cfg.name))
-		failCount = 0
-		go regexConnHandler(conn, cfg, igst)
-	}
-	return
+		regexConnHandler(conn, cfg, igst)
+	}, acceptOptions(`regex`, cfg.name, tp))
 }
 
 func regexConnHandler(c net.Conn, cfg regexHandlerConfig, igst *ingest.IngestMuxer) {
 	cfg.wg.Add(1)
-	id := addConn(c)
+	id := connTracker.Add(c)
 	defer cfg.wg.Done()
-	defer delConn(id)
+	defer connTracker.Del(id)
 	defer c.Close()
+	cfg.proc = connProcessor(cfg.name, cfg.proc, cfg.meta, cfg.sess, c)
 	var rip net.IP
 
-	if cfg.src == nil {
+	if cfg.src == nil && isLocalConn(c) {
+		rip = localSourceIP
+	} else if cfg.src == nil {
 		ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String())
 		if err != nil {
 			lg.Error("failed to get host from remote addr", log.KV("remoteaddress", c.RemoteAddr().String()), log.KVErr(err))
 			return
 		}
-		if rip = net.ParseIP(ipstr); rip == nil {
+		if rip, _, err = config.ParseIPZone(ipstr); err != nil {
 			lg.Error("failed to get remote address", log.KV("remoteaddress", ipstr))
 			return
 		}
@@ -201,7 +232,7 @@ func regexConnHandler(c net.Conn, cfg regexHandlerConfig, igst *ingest.IngestMux
 	}
 
 	out := make(chan *entry.Entry, 128)
-	go regexLoop(c, cfg, rip, out)
+	go regexLoop(cfg.cs.Reader(c), cfg, rip, out)
 
 	for ent := range out {
 		cfg.proc.ProcessContext(ent, cfg.ctx)
@@ -238,6 +269,12 @@ func regexLoop(c io.Reader, cfg regexHandlerConfig, rip net.IP, out chan *entry.
 		} else if err = cfg.timeFormats.LoadFormats(tg); err != nil {
 			lg.Error("failed to load custom time formats", log.KVErr(err))
 			return
+		} else if err = tg.SetFormatDirectory(cfg.formatDir); err != nil {
+			lg.Error("failed to load time format directory", log.KVErr(err))
+			return
+		} else if err = tg.SetFormatProfile(cfg.timeProfile); err != nil {
+			lg.Error("failed to load time format profile", log.KVErr(err))
+			return
 		}
 		if cfg.setLocalTime {
 			tg.SetLocalTime()
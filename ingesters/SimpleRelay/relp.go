@@ -0,0 +1,318 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+// RELP is the Reliable Event Logging Protocol rsyslog uses for reliable delivery.  Every frame
+// carries a transaction number and clients hold on to messages until the server acknowledges
+// their transaction number, anything unacknowledged when a connection drops is sent again when
+// the client reconnects.  We only acknowledge a message once it has been handed to the ingest
+// pipeline, so messages in flight when the relay restarts are resent rather than lost.
+//
+// Frames are TXNR SP COMMAND SP DATALEN [SP DATA] LF.
+
+const (
+	relpMaxTxnr       = 999999999
+	relpMaxCommand    = 32
+	relpMaxDataLenLen = 9 // DATALEN digits
+	relpVersion       = `0`
+	relpSoftware      = `gravwell-simplerelay`
+
+	relpCmdOpen   = `open`
+	relpCmdClose  = `close`
+	relpCmdSyslog = `syslog`
+	relpRsp       = `rsp`
+
+	relpOK          = `200 OK`
+	relpErrNotOpen  = `500 session is not open`
+	relpErrUnknown  = `500 command not supported`
+	relpErrNoSyslog = `500 syslog command not offered`
+	relpErrBadData  = `500 message could not be decoded`
+)
+
+var (
+	ErrRELPStreamOnly = errors.New("relp reader type requires a tcp, tls, or unix stream listener")
+	ErrRELPBadFrame   = errors.New("malformed RELP frame")
+	ErrRELPTooLarge   = errors.New("RELP frame is too large")
+)
+
+type relpFrame struct {
+	txnr uint64
+	cmd  string
+	data []byte
+}
+
+// relpCheck makes sure RELP listeners are bound to a socket we can send acknowledgements on
+func (l *listener) relpCheck() error {
+	rt, err := translateReaderType(l.Reader_Type)
+	if err != nil {
+		return err
+	} else if rt != relpReader {
+		return nil
+	}
+	if tp, _, lerr := netframe.ParseBind(l.Bind_String); lerr == nil && (!tp.Stream() || tp.FIFO()) {
+		return ErrRELPStreamOnly
+	}
+	return nil
+}
+
+// readRELPToken reads up to a space or newline, the delimiter is consumed and returned
+func readRELPToken(br *bufio.Reader, max int) (tok []byte, delim byte, err error) {
+	for {
+		var b byte
+		if b, err = br.ReadByte(); err != nil {
+			if err == io.EOF && len(tok) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		} else if b == ' ' || b == '\n' {
+			delim = b
+			return
+		} else if len(tok) >= max {
+			err = ErrRELPBadFrame
+			return
+		}
+		tok = append(tok, b)
+	}
+}
+
+func readRELPNumber(br *bufio.Reader, max int) (v uint64, delim byte, err error) {
+	var tok []byte
+	if tok, delim, err = readRELPToken(br, max); err != nil {
+		return
+	} else if len(tok) == 0 || tok[0] < '0' || tok[0] > '9' {
+		err = ErrRELPBadFrame
+		return
+	}
+	if v, err = strconv.ParseUint(string(tok), 10, 64); err != nil {
+		err = ErrRELPBadFrame
+	}
+	return
+}
+
+// readRELPFrame reads a single frame, io.EOF is only returned if the connection closed between frames
+func readRELPFrame(br *bufio.Reader, maxData int) (f relpFrame, err error) {
+	var delim byte
+	if f.txnr, delim, err = readRELPNumber(br, relpMaxDataLenLen); err != nil {
+		return
+	} else if delim != ' ' || f.txnr > relpMaxTxnr {
+		err = ErrRELPBadFrame
+		return
+	}
+	var cmd []byte
+	if cmd, delim, err = readRELPToken(br, relpMaxCommand); err != nil {
+		err = unexpectedEOF(err)
+		return
+	} else if delim != ' ' || len(cmd) == 0 {
+		err = ErrRELPBadFrame
+		return
+	}
+	f.cmd = string(cmd)
+	var dlen uint64
+	if dlen, delim, err = readRELPNumber(br, relpMaxDataLenLen); err != nil {
+		err = unexpectedEOF(err)
+		return
+	} else if dlen > uint64(maxData) {
+		err = ErrRELPTooLarge
+		return
+	}
+	if dlen == 0 {
+		//no data, the trailer follows DATALEN directly
+		if delim != '\n' {
+			err = ErrRELPBadFrame
+		}
+		return
+	} else if delim != ' ' {
+		err = ErrRELPBadFrame
+		return
+	}
+	f.data = make([]byte, dlen)
+	if _, err = io.ReadFull(br, f.data); err != nil {
+		err = unexpectedEOF(err)
+		return
+	}
+	var b byte
+	if b, err = br.ReadByte(); err != nil {
+		err = unexpectedEOF(err)
+	} else if b != '\n' {
+		err = ErrRELPBadFrame
+	}
+	return
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func writeRELPRsp(w io.Writer, txnr uint64, rsp string) (err error) {
+	if rsp == `` {
+		_, err = fmt.Fprintf(w, "%d %s 0\n", txnr, relpRsp)
+	} else {
+		_, err = fmt.Fprintf(w, "%d %s %d %s\n", txnr, relpRsp, len(rsp), rsp)
+	}
+	return
+}
+
+// relpOffers parses the key=value lines of an open command
+func relpOffers(data []byte) map[string]string {
+	mp := map[string]string{}
+	for _, ln := range bytes.Split(data, []byte("\n")) {
+		if idx := bytes.IndexByte(ln, '='); idx > 0 {
+			mp[string(ln[:idx])] = string(bytes.TrimRight(ln[idx+1:], "\r"))
+		} else if len(ln) > 0 {
+			mp[string(ln)] = ``
+		}
+	}
+	return mp
+}
+
+// relpOpenRsp builds the response to an open command, ok is false if the client cannot send syslog
+func relpOpenRsp(data []byte) (rsp string, ok bool) {
+	offers := relpOffers(data)
+	if cmds, has := offers[`commands`]; has {
+		for _, c := range bytes.Split([]byte(cmds), []byte(",")) {
+			if string(c) == relpCmdSyslog {
+				ok = true
+			}
+		}
+	}
+	if !ok {
+		rsp = relpErrNoSyslog
+		return
+	}
+	rsp = relpOK + "\nrelp_version=" + relpVersion + "\nrelp_software=" + relpSoftware + "\ncommands=" + relpCmdSyslog
+	return
+}
+
+func relpConnHandlerTCP(c net.Conn, cfg handlerConfig) {
+	cfg.wg.Add(1)
+	id := connTracker.Add(c)
+	defer cfg.wg.Done()
+	defer connTracker.Del(id)
+	defer c.Close()
+	cfg.proc = connProcessor(cfg.name, cfg.proc, cfg.meta, cfg.sess, c)
+	var rip net.IP
+
+	if cfg.src == nil && isLocalConn(c) {
+		rip = localSourceIP
+	} else if cfg.src == nil {
+		ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get host from rmote addr \"%s\": %v\n", c.RemoteAddr().String(), err)
+			return
+		}
+		if rip, _, err = config.ParseIPZone(ipstr); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get remote addr from \"%s\"\n", ipstr)
+			return
+		}
+	} else {
+		rip = cfg.src
+	}
+
+	var tg *timegrinder.TimeGrinder
+	if !cfg.ignoreTimestamps {
+		var err error
+		tcfg := timegrinder.Config{
+			EnableLeftMostSeed: true,
+		}
+		if tg, err = timegrinder.NewTimeGrinder(tcfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to get a handle on the timegrinder: %v\n", err)
+			return
+		} else if err = cfg.timeFormats.LoadFormats(tg); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load custom time formats: %v\n", err)
+			return
+		} else if err = tg.SetFormatDirectory(cfg.formatDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load time format directory: %v\n", err)
+			return
+		} else if err = tg.SetFormatProfile(cfg.timeProfile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load time format profile: %v\n", err)
+			return
+		}
+		if cfg.setLocalTime {
+			tg.SetLocalTime()
+		}
+		if cfg.timezoneOverride != `` {
+			if err = tg.SetTimezone(cfg.timezoneOverride); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to set timezone to %v: %v\n", cfg.timezoneOverride, err)
+				return
+			}
+		}
+		if cfg.formatOverride != `` {
+			if err = tg.SetFormatOverride(cfg.formatOverride); err != nil {
+				lg.Error("Failed to load format override", log.KV("override", cfg.formatOverride), log.KVErr(err))
+				return
+			}
+		}
+	}
+
+	br := bufio.NewReader(c)
+	var open bool
+	for {
+		f, err := readRELPFrame(br, maxDataSize)
+		if err != nil {
+			if err != io.EOF {
+				lg.Info("closing RELP session", log.KV("address", c.RemoteAddr()), log.KV("listener", cfg.name), log.KVErr(err))
+			}
+			return
+		}
+		switch f.cmd {
+		case relpCmdOpen:
+			var rsp string
+			rsp, open = relpOpenRsp(f.data)
+			err = writeRELPRsp(c, f.txnr, rsp)
+		case relpCmdClose:
+			writeRELPRsp(c, f.txnr, ``)
+			return
+		case relpCmdSyslog:
+			if !open {
+				writeRELPRsp(c, f.txnr, relpErrNotOpen)
+				return
+			}
+			var data []byte
+			if data, err = cfg.cs.Decode(f.data); err != nil {
+				writeRELPRsp(c, f.txnr, relpErrBadData)
+				return
+			}
+			data = bytes.TrimRight(data, "\n\r")
+			if len(data) > 0 {
+				ent, err := handleLog(data, rip, cfg.ignoreTimestamps, cfg.tag, tg)
+				if err != nil {
+					return
+				} else if err = cfg.proc.ProcessContext(ent, cfg.ctx); err != nil {
+					//leave the message unacknowledged so the client sends it again
+					return
+				}
+			}
+			err = writeRELPRsp(c, f.txnr, relpOK)
+		default:
+			err = writeRELPRsp(c, f.txnr, relpErrUnknown)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func relpFrameString(txnr int, cmd, data string) string {
+	if data == `` {
+		return fmt.Sprintf("%d %s 0\n", txnr, cmd)
+	}
+	return fmt.Sprintf("%d %s %d %s\n", txnr, cmd, len(data), data)
+}
+
+func TestReadRELPFrame(t *testing.T) {
+	input := relpFrameString(1, `open`, "relp_version=0\ncommands=syslog") +
+		relpFrameString(2, `syslog`, `<34>1 2022-01-01T00:00:00Z host app - - - hello world`) +
+		relpFrameString(3, `close`, ``)
+	br := bufio.NewReader(strings.NewReader(input))
+	f, err := readRELPFrame(br, 1024)
+	if err != nil {
+		t.Fatal(err)
+	} else if f.txnr != 1 || f.cmd != relpCmdOpen || string(f.data) != "relp_version=0\ncommands=syslog" {
+		t.Fatalf("bad open frame: %+v", f)
+	}
+	if f, err = readRELPFrame(br, 1024); err != nil {
+		t.Fatal(err)
+	} else if f.txnr != 2 || f.cmd != relpCmdSyslog || !strings.HasSuffix(string(f.data), `hello world`) {
+		t.Fatalf("bad syslog frame: %+v", f)
+	}
+	if f, err = readRELPFrame(br, 1024); err != nil {
+		t.Fatal(err)
+	} else if f.txnr != 3 || f.cmd != relpCmdClose || len(f.data) != 0 {
+		t.Fatalf("bad close frame: %+v", f)
+	}
+	if _, err = readRELPFrame(br, 1024); err != io.EOF {
+		t.Fatalf("bad error at end of stream: %v", err)
+	}
+
+	bad := []string{
+		"x syslog 5 hello\n",
+		"1 syslog 5 hello",
+		"1 syslog 5 hellothere\n",
+		"1 syslog 10 hello\n",
+		"1syslog 5 hello\n",
+		"1 syslog 0 \n",
+		"1000000000 syslog 5 hello\n",
+		"1 " + strings.Repeat("a", relpMaxCommand+1) + " 5 hello\n",
+	}
+	for _, b := range bad {
+		if _, err = readRELPFrame(bufio.NewReader(strings.NewReader(b)), 1024); err == nil || err == io.EOF {
+			t.Fatalf("failed to reject %q: %v", b, err)
+		}
+	}
+	if _, err = readRELPFrame(bufio.NewReader(strings.NewReader("1 syslog 5 hello\n")), 4); err != ErrRELPTooLarge {
+		t.Fatalf("bad error on oversized frame: %v", err)
+	}
+}
+
+func TestRELPCheck(t *testing.T) {
+	good := []string{`tcp://0.0.0.0:2514`, `tls://0.0.0.0:2515`, `unix:///tmp/relp.sock`}
+	for _, b := range good {
+		l := &listener{Reader_Type: `relp`, base: base{Bind_String: b}}
+		if err := l.relpCheck(); err != nil {
+			t.Fatalf("rejected %s: %v", b, err)
+		}
+	}
+	bad := []string{`udp://0.0.0.0:514`, `unixgram:///tmp/relp.sock`, `fifo:///tmp/relp.fifo`}
+	for _, b := range bad {
+		l := &listener{Reader_Type: `relp`, base: base{Bind_String: b}}
+		if err := l.relpCheck(); err != ErrRELPStreamOnly {
+			t.Fatalf("bad error on %s: %v", b, err)
+		}
+	}
+}
+
+func TestRELPOpenRsp(t *testing.T) {
+	rsp, ok := relpOpenRsp([]byte("relp_version=0\nrelp_software=librelp,1.10.0\ncommands=syslog"))
+	if !ok || !strings.HasPrefix(rsp, relpOK+"\n") || !strings.Contains(rsp, "commands=syslog") {
+		t.Fatalf("bad open response %q", rsp)
+	}
+	if rsp, ok = relpOpenRsp([]byte("relp_version=0\ncommands=other")); ok || rsp != relpErrNoSyslog {
+		t.Fatalf("accepted a session without syslog: %q", rsp)
+	}
+}
+
+func TestRELPSession(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	np := &nopProcessor{}
+	cfg := handlerConfig{
+		name:             `relp`,
+		lrt:              relpReader,
+		ignoreTimestamps: true,
+		src:              net.ParseIP(`10.0.0.1`),
+		wg:               &sync.WaitGroup{},
+		proc:             np,
+		ctx:              context.Background(),
+	}
+	done := make(chan struct{})
+	go func() {
+		relpConnHandlerTCP(server, cfg)
+		close(done)
+	}()
+	br := bufio.NewReader(client)
+	exchange := func(txnr int, cmd, data string) relpFrame {
+		if _, err := io.WriteString(client, relpFrameString(txnr, cmd, data)); err != nil {
+			t.Fatal(err)
+		}
+		f, err := readRELPFrame(br, 1024)
+		if err != nil {
+			t.Fatal(err)
+		} else if f.txnr != uint64(txnr) || f.cmd != relpRsp {
+			t.Fatalf("bad response to %s: %+v", cmd, f)
+		}
+		return f
+	}
+
+	if f := exchange(1, `open`, "relp_version=0\nrelp_software=test\ncommands=syslog"); !strings.HasPrefix(string(f.data), relpOK) {
+		t.Fatalf("open refused: %q", f.data)
+	}
+	for i := 2; i < 5; i++ {
+		if f := exchange(i, `syslog`, `<34>1 2022-01-01T00:00:00Z host app - - - hello`); string(f.data) != relpOK {
+			t.Fatalf("syslog %d not acknowledged: %q", i, f.data)
+		}
+	}
+	if np.cnt != 3 {
+		t.Fatalf("processed %d entries, expected 3", np.cnt)
+	}
+	if f := exchange(5, `starttls`, ``); !strings.HasPrefix(string(f.data), `500`) {
+		t.Fatalf("unknown command accepted: %q", f.data)
+	}
+	if f := exchange(6, `close`, ``); len(f.data) != 0 {
+		t.Fatalf("bad close response %q", f.data)
+	}
+	<-done
+}
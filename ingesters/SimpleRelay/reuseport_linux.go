@@ -0,0 +1,46 @@
+//go:build linux
+// +build linux
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const reusePortSupported = true
+
+func reusePortControl(network, address string, rc syscall.RawConn) (err error) {
+	if cerr := rc.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); cerr != nil {
+		err = cerr
+	}
+	return
+}
+
+// udpReadBuffer returns the receive buffer size of a socket, Linux reports double the size
+// that was set to account for its bookkeeping so we halve it
+func udpReadBuffer(c *net.UDPConn) (sz int, err error) {
+	var rc syscall.RawConn
+	if rc, err = c.SyscallConn(); err != nil {
+		return
+	}
+	if cerr := rc.Control(func(fd uintptr) {
+		sz, err = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+	}); cerr != nil {
+		err = cerr
+	}
+	sz /= 2
+	return
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+const reusePortSupported = false
+
+func reusePortControl(network, address string, rc syscall.RawConn) error {
+	return ErrReusePortUnsupported
+}
+
+func udpReadBuffer(c *net.UDPConn) (int, error) {
+	return 0, ErrReusePortUnsupported
+}
@@ -17,28 +17,31 @@ import (
 	"os"
 	"regexp"
 
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
-	"github.com/gravwell/gravwell/v3/ingest/processors"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
 func rfc5424ConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 	cfg.wg.Add(1)
-	id := addConn(c)
+	id := connTracker.Add(c)
 	defer cfg.wg.Done()
-	defer delConn(id)
+	defer connTracker.Del(id)
 	defer c.Close()
+	cfg.proc = connProcessor(cfg.name, cfg.proc, cfg.meta, cfg.sess, c)
 	var rip net.IP
 	debugout("new connection from %v", c.RemoteAddr().String())
 
-	if cfg.src == nil {
+	if cfg.src == nil && isLocalConn(c) {
+		rip = localSourceIP
+	} else if cfg.src == nil {
 		ipstr, _, err := net.SplitHostPort(c.RemoteAddr().String())
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to get host from rmote addr \"%s\": %v\n", c.RemoteAddr().String(), err)
 			return
 		}
-		if rip = net.ParseIP(ipstr); rip == nil {
+		if rip, _, err = config.ParseIPZone(ipstr); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to get remote addr from \"%s\"\n", ipstr)
 			return
 		}
@@ -56,6 +59,12 @@ func rfc5424ConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 	} else if err = cfg.timeFormats.LoadFormats(tg); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load custom time formats: %v\n", err)
 		return
+	} else if err = tg.SetFormatDirectory(cfg.formatDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load time format directory: %v\n", err)
+		return
+	} else if err = tg.SetFormatProfile(cfg.timeProfile); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load time format profile: %v\n", err)
+		return
 	}
 
 	if cfg.setLocalTime {
@@ -76,7 +85,7 @@ func rfc5424ConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 	}
 	re := regexp.MustCompile(`\n<\d{1,3}>`)
 
-	s := bufio.NewScanner(c)
+	s := bufio.NewScanner(cfg.cs.Reader(c))
 	s.Buffer(make([]byte, initDataSize), maxDataSize)
 	splitter := func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		debugout("data = %v", string(data))
@@ -107,7 +116,11 @@ func rfc5424ConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 		token = data[:advance]
 		return
 	}
-	s.Split(splitter)
+	if cfg.lrt == rfc5425Reader {
+		s.Split(octetCountSplit)
+	} else {
+		s.Split(splitter)
+	}
 	for s.Scan() {
 		data := bytes.Trim(s.Bytes(), "\n\r\t ")
 		debugout("Scanning TCP input %s\n", string(data))
@@ -122,8 +135,8 @@ func rfc5424ConnHandlerTCP(c net.Conn, cfg handlerConfig) {
 	}
 }
 
-func rfc5424ConnHandlerUDP(c *net.UDPConn, cfg handlerConfig) {
-	buff := make([]byte, 16*1024) //local buffer that should be big enough for even the largest UDP packets
+func rfc5424ConnHandlerUDP(c net.PacketConn, cfg handlerConfig) {
+	pr := newPacketReader(c, cfg.udpBatch)
 	tcfg := timegrinder.Config{
 		EnableLeftMostSeed: true,
 	}
@@ -134,6 +147,12 @@ func rfc5424ConnHandlerUDP(c *net.UDPConn, cfg handlerConfig) {
 	} else if err = cfg.timeFormats.LoadFormats(tg); err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load custom time formats: %v\n", err)
 		return
+	} else if err = tg.SetFormatDirectory(cfg.formatDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load time format directory: %v\n", err)
+		return
+	} else if err = tg.SetFormatProfile(cfg.timeProfile); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load time format profile: %v\n", err)
+		return
 	}
 
 	if cfg.setLocalTime {
@@ -153,32 +172,29 @@ func rfc5424ConnHandlerUDP(c *net.UDPConn, cfg handlerConfig) {
 		}
 	}
 
-	var rip net.IP
+	local := isLocalPacketConn(c)
 	for {
-		n, raddr, err := c.ReadFromUDP(buff)
+		buff, raddr, err := pr.ReadPacket()
 		if err != nil {
 			break
+		} else if !cfg.ipf.AllowedAddr(raddr) {
+			continue
 		}
-		if n > 0 {
-			if raddr == nil {
+		if len(buff) > 0 {
+			rip, ok := packetSourceIP(raddr, cfg.src, local)
+			if !ok {
 				continue
-			}
-			if n > len(buff) {
+			} else if buff, err = cfg.cs.Decode(buff); err != nil {
 				continue
 			}
-			if cfg.src == nil {
-				rip = raddr.IP
-			} else {
-				rip = cfg.src
-			}
-			handleRFC5424Packet(append([]byte(nil), buff[:n]...), rip, cfg.ignoreTimestamps, cfg.tag, tg, cfg.proc, cfg.ctx)
+			handleRFC5424Packet(append([]byte(nil), buff...), rip, cfg.ignoreTimestamps, cfg.tag, tg, packetProcessor(cfg.name, cfg.proc, cfg.meta, raddr), cfg.ctx)
 		}
 	}
 
 }
 
 //we can be very very fast on this one by just manually scanning the buffer
-func handleRFC5424Packet(buff []byte, ip net.IP, ignoreTS bool, tag entry.EntryTag, tg *timegrinder.TimeGrinder, proc *processors.ProcessorSet, ctx context.Context) {
+func handleRFC5424Packet(buff []byte, ip net.IP, ignoreTS bool, tag entry.EntryTag, tg *timegrinder.TimeGrinder, proc entProcessor, ctx context.Context) {
 	var idx []int
 	var idx2 []int
 	re := regexp.MustCompile(`^<\d{1,3}>`)
@@ -0,0 +1,88 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+)
+
+const (
+	maxMsgLenDigits = 9
+)
+
+var (
+	ErrRFC5425StreamOnly = errors.New("rfc5425 reader type requires a stream listener")
+	ErrBadOctetFrame     = errors.New("malformed octet counted syslog frame")
+)
+
+// octetCountSplit splits RFC 5425 (and RFC 6587 octet counting) frames, each message is
+// preceded by its length in bytes and a space.  Stray whitespace between frames is skipped,
+// some senders terminate every frame with a newline anyway.
+func octetCountSplit(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for advance < len(data) && isFrameSpace(data[advance]) {
+		advance++
+	}
+	data = data[advance:]
+	if len(data) == 0 {
+		if atEOF {
+			return advance, nil, nil
+		}
+		return
+	}
+	var i int
+	for i < len(data) && data[i] >= '0' && data[i] <= '9' {
+		if i++; i > maxMsgLenDigits {
+			err = ErrBadOctetFrame
+			return
+		}
+	}
+	if i == len(data) {
+		if atEOF {
+			err = ErrBadOctetFrame
+		}
+		return advance, nil, err
+	} else if i == 0 || data[0] == '0' || data[i] != ' ' {
+		err = ErrBadOctetFrame
+		return
+	}
+	n, err := strconv.Atoi(string(data[:i]))
+	if err != nil || n > maxDataSize {
+		err = ErrBadOctetFrame
+		return
+	}
+	if end := i + 1 + n; end <= len(data) {
+		return advance + end, data[i+1 : end], nil
+	} else if atEOF {
+		err = bufio.ErrFinalToken
+		return advance + len(data), data[i+1:], err
+	}
+	return advance, nil, nil
+}
+
+func isFrameSpace(b byte) bool {
+	return b == '\n' || b == '\r' || b == '\t' || b == ' '
+}
+
+// rfc5425Check makes sure octet counted listeners are bound to a stream socket
+func (l *listener) rfc5425Check() error {
+	rt, err := translateReaderType(l.Reader_Type)
+	if err != nil {
+		return err
+	} else if rt != rfc5425Reader {
+		return nil
+	}
+	if tp, _, lerr := netframe.ParseBind(l.Bind_String); lerr == nil && !tp.Stream() {
+		return ErrRFC5425StreamOnly
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestOctetCountSplit(t *testing.T) {
+	msgs := []string{
+		`<34>1 2022-01-01T00:00:00Z host app - - - hello`,
+		"<34>1 2022-01-01T00:00:01Z host app - - - multi\nline",
+		`<34>1 2022-01-01T00:00:02Z host app - - - bye`,
+	}
+	var input string
+	for i, m := range msgs {
+		input += strconv.Itoa(len(m)) + ` ` + m
+		if i == 1 {
+			input += "\n" //trailing newlines between frames are skipped
+		}
+	}
+	s := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(input)))
+	s.Split(octetCountSplit)
+	var got []string
+	for s.Scan() {
+		got = append(got, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	} else if len(got) != len(msgs) {
+		t.Fatalf("got %d messages, expected %d: %q", len(got), len(msgs), got)
+	}
+	for i := range msgs {
+		if got[i] != msgs[i] {
+			t.Fatalf("message %d is %q, expected %q", i, got[i], msgs[i])
+		}
+	}
+
+	for _, b := range []string{`<34>1 no length`, `05 hello`, `5hello`, `1234567890 x`} {
+		s = bufio.NewScanner(strings.NewReader(b))
+		s.Split(octetCountSplit)
+		for s.Scan() {
+		}
+		if s.Err() != ErrBadOctetFrame {
+			t.Fatalf("bad error on %q: %v", b, s.Err())
+		}
+	}
+}
+
+func TestRFC5425Check(t *testing.T) {
+	l := &listener{Reader_Type: `rfc5425`, base: base{Bind_String: `tls://0.0.0.0:6514`}}
+	if err := l.rfc5425Check(); err != nil {
+		t.Fatal(err)
+	}
+	l.Bind_String = `udp://0.0.0.0:514`
+	if err := l.rfc5425Check(); err != ErrRFC5425StreamOnly {
+		t.Fatalf("bad error on udp listener: %v", err)
+	}
+}
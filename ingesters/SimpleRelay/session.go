@@ -0,0 +1,95 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	sessionIDName  = `session_id`
+	sessionSeqName = `session_seq`
+	sessionIDSize  = 8 // random bytes in a session ID
+)
+
+var (
+	ErrSessionStreamOnly = errors.New("Session-ID requires a stream Bind-String, such as tcp or tls")
+)
+
+// sessionTagger attaches a session ID and sequence number to entries from stream connections.
+// Every connection gets a random ID and entries are numbered from 1 in the order they were
+// read, so records from one client session can be pulled back together and put in order even
+// after they are interleaved with other connections.  Values are attached with the listener's
+// Metadata-Mode and Metadata-Field.
+type sessionTagger struct {
+	ann processors.Annotator
+}
+
+// newSessionTagger returns nil if sessions are not enabled
+func (l base) newSessionTagger() (st *sessionTagger, err error) {
+	if !l.Session_ID {
+		return
+	}
+	st = &sessionTagger{}
+	if st.ann, err = processors.NewAnnotator(l.Metadata_Mode, l.Metadata_Field); err != nil {
+		st = nil
+	}
+	return
+}
+
+func newSessionID() string {
+	b := make([]byte, sessionIDSize)
+	if _, err := rand.Read(b); err != nil {
+		//the ID only needs to be unique, fall back on the clock
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// sessionProcessor numbers the entries of a single connection
+type sessionProcessor struct {
+	entProcessor
+	ann processors.Annotator
+	id  string
+	seq *uint64
+}
+
+func (sp sessionProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	if ent != nil {
+		seq := atomic.AddUint64(sp.seq, 1)
+		if _, err := sp.ann.Annotate(ent,
+			processors.Annotation{Name: sessionIDName, Value: sp.id},
+			processors.Annotation{Name: sessionSeqName, Value: strconv.FormatUint(seq, 10)}); err != nil {
+			return err
+		}
+	}
+	return sp.entProcessor.ProcessContext(ent, ctx)
+}
+
+// wrap starts a new session, it is called once per connection and the tagger may be nil
+func (st *sessionTagger) wrap(proc entProcessor) entProcessor {
+	if st == nil {
+		return proc
+	}
+	return sessionProcessor{
+		entProcessor: proc,
+		ann:          st.ann,
+		id:           newSessionID(),
+		seq:          new(uint64),
+	}
+}
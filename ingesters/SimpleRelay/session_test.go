@@ -0,0 +1,93 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+type dataProcessor struct {
+	data []string
+}
+
+func (dp *dataProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	dp.data = append(dp.data, string(ent.Data))
+	return nil
+}
+
+func (dp *dataProcessor) Close() error {
+	return nil
+}
+
+func TestSessionTagger(t *testing.T) {
+	if st, err := (base{}).newSessionTagger(); err != nil || st != nil {
+		t.Fatalf("disabled sessions built a tagger: %v %v", st, err)
+	}
+	st, err := (base{Session_ID: true}).newSessionTagger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dp := &dataProcessor{}
+	a, b := st.wrap(dp), st.wrap(dp)
+	for _, p := range []entProcessor{a, b, a, a, b} {
+		if err = p.ProcessContext(&entry.Entry{Data: []byte(`{"msg":"hi"}`)}, context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type sess struct {
+		ID  string `json:"session_id"`
+		Seq string `json:"session_seq"`
+	}
+	ids := map[string][]string{}
+	for _, d := range dp.data {
+		var s sess
+		if err = json.Unmarshal([]byte(d), &s); err != nil {
+			t.Fatal(err)
+		} else if len(s.ID) != 2*sessionIDSize {
+			t.Fatalf("bad session ID in %s", d)
+		}
+		ids[s.ID] = append(ids[s.ID], s.Seq)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("connections did not get their own sessions: %v", ids)
+	}
+	for id, seqs := range ids {
+		for i, s := range seqs {
+			if want := strconv.Itoa(i + 1); s != want {
+				t.Fatalf("session %s entry %d has sequence %s", id, i, s)
+			}
+		}
+	}
+
+	//text entries get key=value pairs
+	dp.data = nil
+	if err = st.wrap(dp).ProcessContext(&entry.Entry{Data: []byte(`hello`)}, context.Background()); err != nil {
+		t.Fatal(err)
+	} else if d := dp.data[0]; len(d) < 6 || d[:len(sessionIDName)+1] != sessionIDName+`=` || d[len(d)-6:] != ` hello` {
+		t.Fatalf("bad prefixed entry %q", d)
+	}
+}
+
+func TestSessionValidate(t *testing.T) {
+	for _, b := range []string{`tcp://0.0.0.0:601`, `tls://0.0.0.0:6514`, `unix:///tmp/relay.sock`} {
+		if err := (base{Bind_String: b, Session_ID: true}).Validate(); err != nil {
+			t.Fatalf("%s: %v", b, err)
+		}
+	}
+	for _, b := range []string{`udp://0.0.0.0:514`, `unixgram:///tmp/relay.sock`} {
+		if err := (base{Bind_String: b, Session_ID: true}).Validate(); err != ErrSessionStreamOnly {
+			t.Fatalf("bad error on %s: %v", b, err)
+		}
+	}
+}
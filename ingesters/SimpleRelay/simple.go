@@ -10,29 +10,52 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"net"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/charset"
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 )
 
 var (
-	connClosers map[int]closer
-	connId      int
-	mtx         sync.Mutex
+	connTracker = netframe.NewTracker()
+	acceptMon   *netframe.Monitor
 )
 
-type closer interface {
-	Close() error
+// newAcceptMonitor publishes the listener accept status in the ingester state so listeners which
+// cannot accept are visible upstream
+func newAcceptMonitor(igst *ingest.IngestMuxer) *netframe.Monitor {
+	return netframe.NewMonitor(func(st []netframe.AcceptStatus) {
+		ls := make([]ingest.ListenerStatus, 0, len(st))
+		for _, v := range st {
+			ls = append(ls, ingest.ListenerStatus(v))
+		}
+		igst.SetListenerStatus(ls)
+	})
+}
+
+// acceptOptions builds the accept loop options for a listener, the kind keeps
+// identically named listeners of different types apart in the status
+func acceptOptions(kind, name string, tp netframe.BindType) netframe.AcceptOptions {
+	return netframe.AcceptOptions{
+		Name:    kind + `/` + name,
+		Monitor: acceptMon,
+		OnError: func(err error, class netframe.AcceptErrorClass, consecutive int) {
+			// log the first failure and then back off so a stuck listener does not flood the log
+			if consecutive == 1 || consecutive%100 == 0 {
+				lg.Warn("failed to accept connection", log.KV("listener", name), log.KV("type", kind), log.KV("mode", tp), log.KV("failures", consecutive), log.KV("temporary", class == netframe.AcceptTemporary), log.KVErr(err))
+			}
+		},
+	}
 }
 
 type handlerConfig struct {
@@ -45,9 +68,19 @@ type handlerConfig struct {
 	src              net.IP
 	wg               *sync.WaitGroup
 	formatOverride   string
-	proc             *processors.ProcessorSet
+	proc             entProcessor
+	meta             *processors.MetadataAttacher
 	ctx              context.Context
 	timeFormats      config.CustomTimeFormat
+	formatDir        *timegrinder.FormatDirectory
+	timeProfile      []string        // formats the listener is restricted to, nil for all
+	ipf              *utils.IPFilter // datagram address filter, stream listeners are filtered on accept
+	igst             *ingest.IngestMuxer
+	hdr              headerOptions // what connection headers may change on header listeners
+	certs            *certMapper   // client certificate overrides on TLS listeners
+	udpBatch         int           // datagrams per read on UDP sockets
+	cs               charset.Charset
+	sess             *sessionTagger // numbers entries per connection, nil if disabled
 }
 
 func startSimpleListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitGroup, f *flusher, ctx context.Context) error {
@@ -59,16 +92,15 @@ func startSimpleListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitG
 	//fire up our simple backends
 	for k, v := range cfg.Listener {
 		var src net.IP
+		var err error
 		if v.Source_Override != `` {
-			src = net.ParseIP(v.Source_Override)
-			if src == nil {
-				return fmt.Errorf("Listener %v invalid source override \"%s\"", k, v.Source_Override)
+			if src, err = config.ParseSourceOverride(v.Source_Override); err != nil {
+				return fmt.Errorf("Listener %v invalid source override: %v", k, err)
 			}
 		} else if cfg.Source_Override != `` {
 			// global override
-			src = net.ParseIP(cfg.Source_Override)
-			if src == nil {
-				return fmt.Errorf("global source override \"%s\" is invalid", cfg.Source_Override)
+			if src, err = config.ParseSourceOverride(cfg.Source_Override); err != nil {
+				return fmt.Errorf("invalid global source override: %v", err)
 			}
 		}
 		//get the tag for this listener
@@ -76,7 +108,7 @@ func startSimpleListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitG
 		if err != nil {
 			lg.Fatal("failed to resolve tag", log.KV("tag", v.Tag_Name), log.KVErr(err))
 		}
-		tp, str, err := translateBindType(v.Bind_String)
+		tp, str, err := netframe.ParseBind(v.Bind_String)
 		if err != nil {
 			lg.FatalCode(0, "invalid bind", log.KV("bindstring", v.Bind_String), log.KVErr(err))
 		}
@@ -96,45 +128,92 @@ func startSimpleListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitG
 			formatOverride:   v.Timestamp_Format_Override,
 			ctx:              ctx,
 			timeFormats:      cfg.TimeFormat,
+			formatDir:        cfg.formatDir,
+			igst:             igst,
+		}
+		if hcfg.hdr, err = v.headerOptions(); err != nil {
+			return fmt.Errorf("Listener %s header error: %v", k, err)
+		}
+		if hcfg.cs, err = v.inputCharset(); err != nil {
+			return fmt.Errorf("Listener %s charset error: %v", k, err)
+		} else if hcfg.sess, err = v.newSessionTagger(); err != nil {
+			return fmt.Errorf("Listener %s session error: %v", k, err)
+		} else if hcfg.timeProfile, err = cfg.timeProfile(v.base); err != nil {
+			return fmt.Errorf("Listener %s time format profile error: %v", k, err)
 		}
-		if hcfg.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
+		if hcfg.certs, err = v.newCertMapper(igst); err != nil {
+			return fmt.Errorf("Listener %s client certificate map error: %v", k, err)
+		}
+		proc, err := cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor)
+		if err != nil {
 			lg.Fatal("preprocessor error", log.KVErr(err))
 		}
+		proc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
+		if hcfg.proc, err = v.newEntProcessor(k, proc, ctx); err != nil {
+			return fmt.Errorf("Listener %s batching error: %v", k, err)
+		}
+		//size limits apply to entries as they will be ingested, after any rewriting
+		if ch, err := v.NewChunker(); err != nil {
+			return fmt.Errorf("Listener %s entry size error: %v", k, err)
+		} else {
+			hcfg.proc = chunkWrap(k, ch, hcfg.proc)
+		}
+		if sdm, err := v.structuredData(); err != nil {
+			return fmt.Errorf("Listener %s structured data error: %v", k, err)
+		} else {
+			hcfg.proc = sdm.wrap(hcfg.proc)
+		}
+		//routing comes first so rules see the message as it was sent
+		if tr, err := v.newTagRouter(igst, tag); err != nil {
+			return fmt.Errorf("Listener %s tag route error: %v", k, err)
+		} else {
+			hcfg.proc = tr.wrap(hcfg.proc)
+		}
+		//quarantine ahead of everything else so binary blobs are never routed
+		if q, err := v.newQuarantine(k, igst); err != nil {
+			return fmt.Errorf("Listener %s quarantine error: %v", k, err)
+		} else {
+			hcfg.proc = q.wrap(hcfg.proc)
+		}
+		if hcfg.meta, err = v.NewMetadataAttacher(); err != nil {
+			return fmt.Errorf("Listener %s metadata error: %v", k, err)
+		}
 		f.Add(hcfg.proc)
+		ipf, err := v.ipFilter(k)
+		if err != nil {
+			return fmt.Errorf("Listener %s address filter error: %v", k, err)
+		}
 		if tp.TCP() {
 			//get the socket
 			addr, err := net.ResolveTCPAddr(tp.String(), str)
 			if err != nil {
 				return fmt.Errorf("%s Bind-String \"%s\" is invalid: %v\n", k, v.Bind_String, err)
 			}
-			l, err := net.ListenTCP(tp.String(), addr)
+			l, err := handover.listenTCP(handoverKey(`listener`, k, v.Bind_String), tp.String(), addr)
 			if err != nil {
 				return fmt.Errorf("%s Failed to listen on \"%s\": %v\n", k, addr, err)
 			}
-			connID := addConn(l)
+			fl := v.backpressure(ipf.Listener(l))
+			connID := connTracker.Add(fl)
 			//start the acceptor
 			wg.Add(1)
-			go acceptor(l, connID, igst, hcfg, tp)
+			go acceptor(fl, connID, igst, hcfg, tp)
 		} else if tp.TLS() {
-			config := &tls.Config{
-				MinVersion: tls.VersionTLS12,
-			}
-
-			config.Certificates = make([]tls.Certificate, 1)
-			config.Certificates[0], err = tls.LoadX509KeyPair(v.Cert_File, v.Key_File)
-			if err != nil {
-				lg.FatalCode(0, "failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
-			}
 			//get the socket
-			addr, err := net.ResolveTCPAddr("tcp", str)
+			addr, err := net.ResolveTCPAddr(tp.Network(), str)
 			if err != nil {
 				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("listener", k), log.KVErr(err))
 			}
-			l, err := tls.Listen("tcp", addr.String(), config)
+			tl, err := handover.listenTCP(handoverKey(`listener`, k, v.Bind_String), tp.Network(), addr)
 			if err != nil {
 				lg.FatalCode(0, "failed to listen via TLS", log.KV("address", addr), log.KV("listener", k), log.KVErr(err))
 			}
-			connID := addConn(l)
+			// filter before the handshake so denied peers cost us nothing
+			l, err := tlsListener(v.backpressure(ipf.Listener(tl)), k, v.Cert_File, v.Key_File, v.Client_CA_File)
+			if err != nil {
+				lg.FatalCode(0, "failed to load certificate", log.KV("certfile", v.Cert_File), log.KV("keyfile", v.Key_File), log.KVErr(err))
+			}
+			connID := connTracker.Add(l)
 			//start the acceptor
 			wg.Add(1)
 			go acceptor(l, connID, igst, hcfg, tp)
@@ -143,56 +222,86 @@ func startSimpleListeners(cfg *cfgType, igst *ingest.IngestMuxer, wg *sync.WaitG
 			if err != nil {
 				lg.FatalCode(0, "invalid Bind-String", log.KV("bindstring", v.Bind_String), log.KV("listener", k), log.KVErr(err))
 			}
-			l, err := net.ListenUDP(tp.String(), addr)
+			uo, err := v.udpOptions()
+			if err != nil {
+				return fmt.Errorf("Listener %s UDP error: %v", k, err)
+			}
+			conns, err := listenUDPWorkers(handoverKey(`listener`, k, v.Bind_String), k, tp.String(), addr, uo)
 			if err != nil {
 				lg.FatalCode(0, "failed to listen via udp", log.KV("address", addr), log.KV("listener", k), log.KVErr(err))
 			}
-			connID := addConn(l)
-			wg.Add(1)
-			go acceptorUDP(l, connID, hcfg, igst)
+			hcfg.ipf = ipf
+			hcfg.udpBatch = uo.batch
+			for _, l := range conns {
+				connID := connTracker.Add(l)
+				wg.Add(1)
+				go acceptorUDP(l, connID, hcfg, igst)
+			}
+		} else if tp.Local() {
+			if err = startLocalListener(handoverKey(`listener`, k, v.Bind_String), tp, str, v.base, func(l net.Listener) {
+				l = v.backpressure(l)
+				connID := connTracker.Add(l)
+				wg.Add(1)
+				go acceptor(l, connID, igst, hcfg, tp)
+			}, func(c net.PacketConn) {
+				connID := connTracker.Add(c)
+				wg.Add(1)
+				go acceptorUDP(c, connID, hcfg, igst)
+			}, func(c net.Conn) {
+				switch hcfg.lrt {
+				case lineReader:
+					go lineConnHandlerTCP(c, hcfg)
+				case rfc5424Reader, rfc5425Reader:
+					go rfc5424ConnHandlerTCP(c, hcfg)
+				case headerReader:
+					go headerConnHandlerTCP(c, hcfg)
+				case relpReader:
+					go relpConnHandlerTCP(c, hcfg)
+				}
+			}); err != nil {
+				return fmt.Errorf("Listener %s failed to listen on %s: %v", k, v.Bind_String, err)
+			}
 		}
 	}
 	debugout("Started %d listeners\n", len(cfg.Listener))
 	return nil
 }
 
-func acceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg handlerConfig, tp bindType) {
-	var failCount int
+func acceptor(lst net.Listener, id int, igst *ingest.IngestMuxer, cfg handlerConfig, tp netframe.BindType) {
 	defer cfg.wg.Done()
-	defer delConn(id)
-	defer lst.Close()
-	for {
-		conn, err := lst.Accept()
-		if err != nil {
-			//i hate this... is there no damn error check that just says its closed or not?
-			if strings.Contains(err.Error(), "closed") {
-				break
-			}
-			failCount++
-			lg.Warn("failed to accept TCP connection", log.KVErr(err))
-			if failCount > 3 {
-				break
-			}
-			continue
-		}
+	defer connTracker.Del(id)
+	switch cfg.lrt {
+	case lineReader, rfc5424Reader, rfc5425Reader, headerReader, relpReader:
+	default:
+		lg.Error("invalid reader type", log.KV("readertype", cfg.lrt))
+		lst.Close()
+		return
+	}
+	netframe.Serve(lst, func(conn net.Conn) {
 		debugout("Accepted %v connection from %s in %v mode\n", conn.RemoteAddr(), cfg.lrt, tp.String())
 		lg.Info("accepted connection", log.KV("address", conn.RemoteAddr()), log.KV("readertype", cfg.lrt), log.KV("mode", tp), log.KV("listener", cfg.name))
-		failCount = 0
+		ccfg, fp, err := cfg.certs.apply(conn, cfg)
+		if err != nil {
+			lg.Info("refused client certificate", log.KV("address", conn.RemoteAddr()), log.KV("fingerprint", fp), log.KV("listener", cfg.name), log.KVErr(err))
+			conn.Close()
+			return
+		}
 		switch cfg.lrt {
 		case lineReader:
-			go lineConnHandlerTCP(conn, cfg)
-		case rfc5424Reader:
-			go rfc5424ConnHandlerTCP(conn, cfg)
-		default:
-			lg.Error("invalid reader type", log.KV("readertype", cfg.lrt))
-			return
+			lineConnHandlerTCP(conn, ccfg)
+		case rfc5424Reader, rfc5425Reader:
+			rfc5424ConnHandlerTCP(conn, ccfg)
+		case headerReader:
+			headerConnHandlerTCP(conn, ccfg)
+		case relpReader:
+			relpConnHandlerTCP(conn, ccfg)
 		}
-	}
+	}, acceptOptions(`listener`, cfg.name, tp))
 }
 
-func acceptorUDP(conn *net.UDPConn, id int, cfg handlerConfig, igst *ingest.IngestMuxer) {
+func acceptorUDP(conn net.PacketConn, id int, cfg handlerConfig, igst *ingest.IngestMuxer) {
 	defer cfg.wg.Done()
-	defer delConn(id)
+	defer connTracker.Del(id)
 	defer conn.Close()
 	//read packets off
 	switch cfg.lrt {
@@ -233,24 +342,3 @@ func handleLog(b []byte, ip net.IP, ignoreTS bool, tag entry.EntryTag, tg *timeg
 	}
 	return
 }
-
-func addConn(c closer) int {
-	mtx.Lock()
-	connId++
-	id := connId
-	connClosers[connId] = c
-	mtx.Unlock()
-	return id
-}
-
-func delConn(id int) {
-	mtx.Lock()
-	delete(connClosers, id)
-	mtx.Unlock()
-}
-
-func connCount() int {
-	mtx.Lock()
-	defer mtx.Unlock()
-	return len(connClosers)
-}
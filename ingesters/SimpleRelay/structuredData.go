@@ -0,0 +1,292 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	sdModePrefix = `prefix`
+	sdModeJSON   = `json`
+	sdAll        = `*`
+
+	defaultSDField   = `structured_data`
+	sdMessageField   = `message`
+	sdNilValue       = '-'
+	maxSDHeaderField = 255 // longest header field RFC 5424 allows (HOSTNAME)
+)
+
+var (
+	ErrStructuredDataWithout = errors.New("Structured-Data options require Reader-Type=rfc5424, rfc5425, or relp")
+	ErrSDOptsWithoutIDs      = errors.New("Structured-Data-Mode and Structured-Data-Field require Structured-Data")
+	ErrInvalidSDMode         = errors.New("Invalid Structured-Data-Mode, must be prefix or json")
+	ErrSDFieldWithoutJSON    = errors.New("Structured-Data-Field requires Structured-Data-Mode=json")
+	ErrInvalidSDID           = errors.New("Invalid Structured-Data SD-ID")
+)
+
+// sdElement is a single SD-ELEMENT, params are kept in the order they were sent
+type sdElement struct {
+	id     string
+	params []sdParam
+}
+
+type sdParam struct {
+	name  string
+	value string
+}
+
+// parseStructuredData pulls the SD-ELEMENTs out of an RFC 5424 message, ok is false if the
+// message does not have a well formed RFC 5424 header.  Messages with a nil STRUCTURED-DATA
+// are ok and have no elements.
+func parseStructuredData(b []byte) (elems []sdElement, ok bool) {
+	//PRI
+	if len(b) < 3 || b[0] != '<' {
+		return
+	}
+	idx := bytes.IndexByte(b, '>')
+	if idx < 2 || idx > 4 {
+		return
+	}
+	b = b[idx+1:]
+	//VERSION is a nonzero number, which keeps us from wandering into BSD syslog headers
+	if len(b) == 0 || b[0] < '1' || b[0] > '9' {
+		return
+	}
+	//VERSION, TIMESTAMP, HOSTNAME, APP-NAME, PROCID, and MSGID are all space delimited
+	for i := 0; i < 6; i++ {
+		if idx = bytes.IndexByte(b, ' '); idx <= 0 || idx > maxSDHeaderField {
+			return
+		}
+		b = b[idx+1:]
+	}
+	if len(b) == 0 {
+		return
+	} else if b[0] == sdNilValue {
+		ok = len(b) == 1 || b[1] == ' '
+		return
+	}
+	for len(b) > 0 && b[0] == '[' {
+		var el sdElement
+		if el, b, ok = parseSDElement(b[1:]); !ok {
+			return nil, false
+		}
+		elems = append(elems, el)
+	}
+	//the structured data must end the message or be followed by a space and the MSG
+	ok = len(b) == 0 || b[0] == ' '
+	return
+}
+
+// parseSDElement parses an element after the opening bracket, returning the remaining data
+func parseSDElement(b []byte) (el sdElement, rem []byte, ok bool) {
+	var i int
+	for i < len(b) && b[i] != ' ' && b[i] != ']' {
+		i++
+	}
+	if i == 0 || i == len(b) {
+		return
+	}
+	el.id, b = string(b[:i]), b[i:]
+	for {
+		if len(b) == 0 {
+			return
+		} else if b[0] == ']' {
+			rem, ok = b[1:], true
+			return
+		} else if b[0] != ' ' {
+			return
+		}
+		b = b[1:]
+		//PARAM-NAME="PARAM-VALUE"
+		idx := bytes.IndexByte(b, '=')
+		if idx <= 0 || idx+1 >= len(b) || b[idx+1] != '"' {
+			return
+		}
+		p := sdParam{name: string(b[:idx])}
+		b = b[idx+2:]
+		var val []byte
+		var closed bool
+		for i = 0; i < len(b); i++ {
+			if b[i] == '\\' && i+1 < len(b) && (b[i+1] == '"' || b[i+1] == '\\' || b[i+1] == ']') {
+				i++
+			} else if b[i] == '"' {
+				closed = true
+				break
+			}
+			val = append(val, b[i])
+		}
+		if !closed {
+			return
+		}
+		p.value = string(val)
+		b = b[i+1:]
+		el.params = append(el.params, p)
+	}
+}
+
+// sdMapper attaches the structured data of RFC 5424 messages to entries.  Entries do not carry
+// enumerated values, so in prefix mode params are prepended as SDID.name=value pairs and in json
+// mode the entry is rewritten as a JSON object holding the original message and the structured data.
+type sdMapper struct {
+	all  bool
+	ids  map[string]bool
+	json bool
+	fld  string
+	ann  processors.Annotator
+}
+
+// structuredData returns the structured data mapper for the listener, nil if it is not enabled
+func (l *listener) structuredData() (sdm *sdMapper, err error) {
+	rt, err := translateReaderType(l.Reader_Type)
+	if err != nil {
+		return
+	} else if rt != rfc5424Reader && rt != rfc5425Reader && rt != relpReader {
+		if len(l.Structured_Data) > 0 || l.Structured_Data_Mode != `` || l.Structured_Data_Field != `` {
+			err = ErrStructuredDataWithout
+		}
+		return
+	} else if len(l.Structured_Data) == 0 {
+		if l.Structured_Data_Mode != `` || l.Structured_Data_Field != `` {
+			err = ErrSDOptsWithoutIDs
+		}
+		return
+	}
+	sdm = &sdMapper{
+		ids: map[string]bool{},
+	}
+	for _, id := range l.Structured_Data {
+		if id = strings.TrimSpace(id); id == sdAll {
+			sdm.all = true
+		} else if id == `` || strings.ContainsAny(id, " =]\"") {
+			err = fmt.Errorf("%w %q", ErrInvalidSDID, id)
+			return
+		} else {
+			sdm.ids[id] = true
+		}
+	}
+	switch strings.ToLower(strings.TrimSpace(l.Structured_Data_Mode)) {
+	case ``, sdModePrefix:
+		if l.Structured_Data_Field != `` {
+			err = ErrSDFieldWithoutJSON
+			return
+		}
+		sdm.ann, err = processors.NewAnnotator(processors.AnnotatePrefix, ``)
+	case sdModeJSON:
+		sdm.json = true
+		if sdm.fld = strings.TrimSpace(l.Structured_Data_Field); sdm.fld == `` {
+			sdm.fld = defaultSDField
+		} else if sdm.fld == sdMessageField {
+			err = fmt.Errorf("Structured-Data-Field cannot be %q", sdMessageField)
+		}
+	default:
+		err = ErrInvalidSDMode
+	}
+	return
+}
+
+func (sdm *sdMapper) allowed(id string) bool {
+	return sdm.all || sdm.ids[id]
+}
+
+// attach maps the structured data onto the entry, messages that are not RFC 5424 or have no
+// allowed elements are left alone
+func (sdm *sdMapper) attach(ent *entry.Entry) (err error) {
+	elems, ok := parseStructuredData(ent.Data)
+	if !ok {
+		return
+	}
+	if sdm.json {
+		return sdm.attachJSON(ent, elems)
+	}
+	var anns []processors.Annotation
+	for _, el := range elems {
+		if !sdm.allowed(el.id) {
+			continue
+		}
+		for _, p := range el.params {
+			anns = append(anns, processors.Annotation{Name: el.id + `.` + p.name, Value: p.value})
+		}
+	}
+	if len(anns) > 0 {
+		_, err = sdm.ann.Annotate(ent, anns...)
+	}
+	return
+}
+
+// attachJSON rewrites the entry as a JSON object, repeated params become arrays
+func (sdm *sdMapper) attachJSON(ent *entry.Entry, elems []sdElement) (err error) {
+	sd := map[string]map[string]interface{}{}
+	for _, el := range elems {
+		if !sdm.allowed(el.id) {
+			continue
+		}
+		params, ok := sd[el.id]
+		if !ok {
+			params = map[string]interface{}{}
+			sd[el.id] = params
+		}
+		for _, p := range el.params {
+			switch v := params[p.name].(type) {
+			case nil:
+				params[p.name] = p.value
+			case string:
+				params[p.name] = []string{v, p.value}
+			case []string:
+				params[p.name] = append(v, p.value)
+			}
+		}
+	}
+	if len(sd) == 0 {
+		return
+	}
+	var b []byte
+	if b, err = json.Marshal(map[string]interface{}{
+		sdMessageField: string(ent.Data),
+		sdm.fld:        sd,
+	}); err == nil {
+		ent.Data = b
+	}
+	return
+}
+
+// sdProcessor maps structured data before handing entries on
+type sdProcessor struct {
+	entProcessor
+	sdm *sdMapper
+}
+
+func (sp sdProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	if ent != nil {
+		if err := sp.sdm.attach(ent); err != nil {
+			lg.Warn("failed to attach structured data", log.KVErr(err))
+		}
+	}
+	return sp.entProcessor.ProcessContext(ent, ctx)
+}
+
+// wrap hands back a processor that maps structured data, the mapper may be nil
+func (sdm *sdMapper) wrap(proc entProcessor) entProcessor {
+	if sdm == nil {
+		return proc
+	}
+	return sdProcessor{
+		entProcessor: proc,
+		sdm:          sdm,
+	}
+}
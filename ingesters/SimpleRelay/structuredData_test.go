@@ -0,0 +1,147 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	sdTestMsg = `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][origin ip="192.0.2.1" ip="192.0.2.2" software="te\"st\]\\"] An application event`
+)
+
+func TestParseStructuredData(t *testing.T) {
+	elems, ok := parseStructuredData([]byte(sdTestMsg))
+	if !ok {
+		t.Fatal("failed to parse structured data")
+	}
+	want := []sdElement{
+		{id: `exampleSDID@32473`, params: []sdParam{{`iut`, `3`}, {`eventSource`, `Application`}, {`eventID`, `1011`}}},
+		{id: `origin`, params: []sdParam{{`ip`, `192.0.2.1`}, {`ip`, `192.0.2.2`}, {`software`, `te"st]\`}}},
+	}
+	if !reflect.DeepEqual(elems, want) {
+		t.Fatalf("bad elements:\n%+v\n%+v", elems, want)
+	}
+
+	good := map[string]int{
+		`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed`: 0,
+		`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 -`:                  0,
+		`<34>1 2003-10-11T22:14:15.003Z host su - ID47 [a][b x=""]`:                         2,
+		`<34>1 2003-10-11T22:14:15.003Z host su - ID47 [meta sequenceId="1"] msg`:           1,
+	}
+	for msg, cnt := range good {
+		if elems, ok = parseStructuredData([]byte(msg)); !ok || len(elems) != cnt {
+			t.Fatalf("bad parse of %q: %v %+v", msg, ok, elems)
+		}
+	}
+	bad := []string{
+		``,
+		`Oct 11 22:14:15 mymachine su: 'su root' failed`,
+		`<34>Oct 11 22:14:15 mymachine su: 'su root' failed`,
+		`<34>1 2003-10-11T22:14:15.003Z host su - ID47`,
+		`<34>1 2003-10-11T22:14:15.003Z host su - ID47 [meta sequenceId="1" msg`,
+		`<34>1 2003-10-11T22:14:15.003Z host su - ID47 [meta sequenceId=1] msg`,
+		`<34>1 2003-10-11T22:14:15.003Z host su - ID47 [meta sequenceId="1"]msg`,
+		`<34>1 2003-10-11T22:14:15.003Z host su - ID47 [] msg`,
+	}
+	for _, msg := range bad {
+		if _, ok = parseStructuredData([]byte(msg)); ok {
+			t.Fatalf("parsed bad message %q", msg)
+		}
+	}
+}
+
+func TestStructuredDataPrefix(t *testing.T) {
+	l := listener{
+		Reader_Type:     `rfc5424`,
+		Structured_Data: []string{`origin`},
+	}
+	sdm, err := l.structuredData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ent := &entry.Entry{Data: []byte(sdTestMsg)}
+	if err = sdm.attach(ent); err != nil {
+		t.Fatal(err)
+	}
+	want := `origin.ip=192.0.2.1 origin.ip=192.0.2.2 origin.software="te\"st]\\" ` + sdTestMsg
+	if string(ent.Data) != want {
+		t.Fatalf("bad prefix:\n%s\n%s", ent.Data, want)
+	}
+
+	//messages without allowed elements, or that are not RFC 5424, are untouched
+	for _, msg := range []string{
+		`<34>1 2003-10-11T22:14:15.003Z host su - ID47 [meta sequenceId="1"] msg`,
+		`<34>Oct 11 22:14:15 mymachine su: 'su root' failed`,
+	} {
+		ent = &entry.Entry{Data: []byte(msg)}
+		if err = sdm.attach(ent); err != nil {
+			t.Fatal(err)
+		} else if string(ent.Data) != msg {
+			t.Fatalf("modified %q: %s", msg, ent.Data)
+		}
+	}
+}
+
+func TestStructuredDataJSON(t *testing.T) {
+	l := listener{
+		Reader_Type:           `rfc5424`,
+		Structured_Data:       []string{`*`},
+		Structured_Data_Mode:  `JSON`,
+		Structured_Data_Field: `sd`,
+	}
+	sdm, err := l.structuredData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ent := &entry.Entry{Data: []byte(sdTestMsg)}
+	if err = sdm.attach(ent); err != nil {
+		t.Fatal(err)
+	}
+	var obj struct {
+		Message string
+		SD      map[string]map[string]interface{} `json:"sd"`
+	}
+	if err = json.Unmarshal(ent.Data, &obj); err != nil {
+		t.Fatal(err)
+	} else if obj.Message != sdTestMsg {
+		t.Fatalf("bad message %q", obj.Message)
+	} else if obj.SD[`exampleSDID@32473`][`eventID`] != `1011` {
+		t.Fatalf("bad structured data %+v", obj.SD)
+	} else if ips, ok := obj.SD[`origin`][`ip`].([]interface{}); !ok || len(ips) != 2 || ips[1] != `192.0.2.2` {
+		t.Fatalf("bad repeated param %+v", obj.SD[`origin`])
+	}
+}
+
+func TestStructuredDataConfig(t *testing.T) {
+	if sdm, err := (&listener{Reader_Type: `rfc5424`}).structuredData(); err != nil || sdm != nil {
+		t.Fatalf("structured data enabled by default: %v %v", sdm, err)
+	}
+	bad := []listener{
+		{Reader_Type: `line`, Structured_Data: []string{`origin`}},
+		{Reader_Type: `rfc5424`, Structured_Data_Mode: `json`},
+		{Reader_Type: `rfc5424`, Structured_Data: []string{`origin`}, Structured_Data_Mode: `xml`},
+		{Reader_Type: `rfc5424`, Structured_Data: []string{`origin`}, Structured_Data_Field: `sd`},
+		{Reader_Type: `rfc5424`, Structured_Data: []string{`origin`}, Structured_Data_Mode: `json`, Structured_Data_Field: `message`},
+		{Reader_Type: `rfc5424`, Structured_Data: []string{`bad id`}},
+	}
+	for _, b := range bad {
+		if _, err := b.structuredData(); err == nil {
+			t.Fatalf("failed to catch bad structured data config %+v", b)
+		}
+	}
+	if _, err := loadTestConfig(strings.Replace(tagRouteConfigFile, `Reader-Type=rfc5424`, "Reader-Type=rfc5424\n\tStructured-Data=origin\n\tStructured-Data-Mode=json", 1)); err != nil {
+		t.Fatal(err)
+	}
+}
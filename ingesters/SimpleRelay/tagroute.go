@@ -0,0 +1,142 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+var (
+	ErrInvalidTagRoute = errors.New("Invalid Tag-Route, must be regex:tag")
+)
+
+// tagRouteConfig selects tags for entries by matching regular expressions against the raw entry.
+// Rules are tried in order and the first match wins, entries that match nothing keep the
+// listener tag.  Tags cannot contain a colon so the tag is everything after the last one.
+type tagRouteConfig struct {
+	Tag_Route []string // ordered regex:tag rules
+}
+
+type tagRoute struct {
+	rx  *regexp.Regexp
+	tag string
+}
+
+func (trc tagRouteConfig) tagRoutes() (routes []tagRoute, err error) {
+	for _, v := range trc.Tag_Route {
+		idx := strings.LastIndex(v, `:`)
+		if idx <= 0 {
+			err = fmt.Errorf("%w: %q", ErrInvalidTagRoute, v)
+			return
+		}
+		tr := tagRoute{
+			tag: strings.TrimSpace(v[idx+1:]),
+		}
+		if err = ingest.CheckTag(tr.tag); err != nil {
+			err = fmt.Errorf("Invalid Tag-Route tag %q: %v", tr.tag, err)
+			return
+		} else if tr.rx, err = regexp.Compile(v[:idx]); err != nil {
+			err = fmt.Errorf("Invalid Tag-Route regex %q: %v", v[:idx], err)
+			return
+		}
+		routes = append(routes, tr)
+	}
+	return
+}
+
+// routeTags returns the tags the rules may select
+func (trc tagRouteConfig) routeTags() (tags []string, err error) {
+	var routes []tagRoute
+	if routes, err = trc.tagRoutes(); err != nil {
+		return
+	}
+	for _, r := range routes {
+		tags = append(tags, r.tag)
+	}
+	return
+}
+
+type tagGetter interface {
+	GetTag(string) (entry.EntryTag, error)
+}
+
+type tagRouteRule struct {
+	rx  *regexp.Regexp
+	tag entry.EntryTag
+}
+
+// tagRouter applies the rules to entries carrying the listener tag, entries that were given
+// another tag, such as by a connection header, are left alone
+type tagRouter struct {
+	def   entry.EntryTag
+	rules []tagRouteRule
+}
+
+// newTagRouter resolves the rule tags, the router is nil if there are no rules
+func (trc tagRouteConfig) newTagRouter(tg tagGetter, def entry.EntryTag) (tr *tagRouter, err error) {
+	var routes []tagRoute
+	if routes, err = trc.tagRoutes(); err != nil || len(routes) == 0 {
+		return
+	}
+	tr = &tagRouter{
+		def:   def,
+		rules: make([]tagRouteRule, 0, len(routes)),
+	}
+	for _, r := range routes {
+		rule := tagRouteRule{rx: r.rx}
+		if rule.tag, err = tg.GetTag(r.tag); err != nil {
+			return nil, err
+		}
+		tr.rules = append(tr.rules, rule)
+	}
+	return
+}
+
+func (tr *tagRouter) route(ent *entry.Entry) {
+	if ent.Tag != tr.def {
+		return
+	}
+	for _, r := range tr.rules {
+		if r.rx.Match(ent.Data) {
+			ent.Tag = r.tag
+			return
+		}
+	}
+}
+
+// routeProcessor retags entries before handing them on
+type routeProcessor struct {
+	entProcessor
+	tr *tagRouter
+}
+
+func (rp routeProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	if ent != nil {
+		rp.tr.route(ent)
+	}
+	return rp.entProcessor.ProcessContext(ent, ctx)
+}
+
+// wrap hands back a processor that routes entries, the router may be nil
+func (tr *tagRouter) wrap(proc entProcessor) entProcessor {
+	if tr == nil {
+		return proc
+	}
+	return routeProcessor{
+		entProcessor: proc,
+		tr:           tr,
+	}
+}
@@ -0,0 +1,164 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+type testTags []string
+
+func (tt testTags) GetTag(name string) (entry.EntryTag, error) {
+	for i, v := range tt {
+		if v == name {
+			return entry.EntryTag(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tag %s", name)
+}
+
+type tagProcessor struct {
+	tags []entry.EntryTag
+}
+
+func (tp *tagProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	tp.tags = append(tp.tags, ent.Tag)
+	return nil
+}
+
+func (tp *tagProcessor) Close() error {
+	return nil
+}
+
+func TestTagRoutes(t *testing.T) {
+	trc := tagRouteConfig{
+		Tag_Route: []string{
+			`^<\d+>\S+ \S+ \S+ sshd\[:sshd`,
+			`kernel: :kernel`,
+			`\d{2}:\d{2}:\d{2}.*kernel:kernel_ts`, //colons in the regex are fine
+		},
+	}
+	if tags, err := trc.routeTags(); err != nil {
+		t.Fatal(err)
+	} else if len(tags) != 3 || tags[0] != `sshd` || tags[1] != `kernel` || tags[2] != `kernel_ts` {
+		t.Fatalf("bad route tags: %v", tags)
+	}
+	tt := testTags{`default`, `sshd`, `kernel`, `kernel_ts`, `header`}
+	tr, err := trc.newTagRouter(tt, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tp := &tagProcessor{}
+	proc := tr.wrap(tp)
+	ents := []*entry.Entry{
+		{Data: []byte(`<34>Oct 11 host sshd[1234]: Accepted publickey`)},
+		{Data: []byte(`Oct 11 22:14:15 host kernel: oops`)}, //first matching rule wins
+		{Data: []byte(`Oct 11 22:14:15 host kernel:oops`)},
+		{Data: []byte(`Oct 11 22:14:15 host cron[1]: job`)}, //falls through to the listener tag
+		{Data: []byte(`<34>Oct 11 host sshd[1234]: from a header`), Tag: 4},
+	}
+	for _, ent := range ents {
+		if err = proc.ProcessContext(ent, context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []entry.EntryTag{1, 2, 3, 0, 4}
+	for i := range want {
+		if tp.tags[i] != want[i] {
+			t.Fatalf("entry %d routed to %d, expected %d", i, tp.tags[i], want[i])
+		}
+	}
+
+	//no rules means no router
+	if tr, err = (tagRouteConfig{}).newTagRouter(tt, 0); err != nil || tr != nil {
+		t.Fatalf("router without rules: %v %v", tr, err)
+	} else if tr.wrap(tp) != entProcessor(tp) {
+		t.Fatal("nil router wrapped the processor")
+	}
+	if _, err = (tagRouteConfig{Tag_Route: []string{`foo:missing`}}).newTagRouter(tt, 0); err == nil {
+		t.Fatal("failed to catch an unknown tag")
+	}
+}
+
+func TestTagRoutesBad(t *testing.T) {
+	bad := []string{
+		`sshd`,
+		`:sshd`,
+		`sshd:`,
+		`sshd:bad tag`,
+		`sshd[:sshd`,
+	}
+	for _, b := range bad {
+		if _, err := (tagRouteConfig{Tag_Route: []string{b}}).tagRoutes(); err == nil {
+			t.Fatalf("failed to catch bad Tag-Route %q", b)
+		}
+	}
+}
+
+func TestTagRouteConfig(t *testing.T) {
+	cfg, err := loadTestConfig(tagRouteConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, ok := cfg.Listener[`syslog`]
+	if !ok {
+		t.Fatal("missing syslog listener")
+	} else if len(l.Tag_Route) != 2 || l.Tag_Route[1] != `kernel:kernel` {
+		t.Fatalf("bad Tag-Route: %v", l.Tag_Route)
+	}
+	tags, err := cfg.Tags()
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.Join(tags, `,`) != `kernel,multiline,sshd,su,syslog` {
+		t.Fatalf("bad tags: %v", tags)
+	}
+	if _, err = loadTestConfig(strings.Replace(tagRouteConfigFile, `kernel:kernel`, `kernel:ker nel`, 1)); err == nil {
+		t.Fatal("failed to catch a bad Tag-Route")
+	}
+}
+
+func loadTestConfig(s string) (*cfgType, error) {
+	fout, err := ioutil.TempFile(tmpDir, `cfg`)
+	if err != nil {
+		return nil, err
+	}
+	defer fout.Close()
+	if _, err = fout.WriteString(s); err != nil {
+		return nil, err
+	}
+	return GetConfig(fout.Name(), ``)
+}
+
+const tagRouteConfigFile = `
+[Global]
+Ingest-Secret = IngestSecrets
+Connection-Timeout = 0
+Cleartext-Backend-target=127.0.0.1:4023
+Log-Level=INFO
+Log-File=/opt/gravwell/log/simple_relay.log
+
+[Listener "syslog"]
+	Bind-String="0.0.0.0:601"
+	Reader-Type=rfc5424
+	Tag-Name=syslog
+	Tag-Route="sshd\\[:sshd"
+	Tag-Route="kernel:kernel"
+
+[RegexListener "multiline"]
+	Bind-String="0.0.0.0:7778"
+	Regex="\\n\\S"
+	Tag-Name=multiline
+	Tag-Route="su:su"
+`
@@ -0,0 +1,88 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+// listenerBase finds a listener of any type by name
+func (c *cfgType) listenerBase(name string) (b base, ok bool) {
+	if l, lok := c.Listener[name]; lok && l != nil {
+		return l.base, true
+	} else if rl, rok := c.RegexListener[name]; rok && rl != nil {
+		return rl.base, true
+	} else if jl, jok := c.JSONListener[name]; jok && jl != nil {
+		return jl.base, true
+	}
+	return
+}
+
+// timestampTestGrinder builds a timegrinder the way the named listener does, an empty name
+// gets the global custom and directory formats with the default settings
+func timestampTestGrinder(cfg *cfgType, name string) (tg *timegrinder.TimeGrinder, err error) {
+	var b base
+	if name != `` {
+		var ok bool
+		if b, ok = cfg.listenerBase(name); !ok {
+			return nil, fmt.Errorf("no listener named %q", name)
+		}
+	}
+	if tg, err = timegrinder.New(timegrinder.Config{EnableLeftMostSeed: true}); err != nil {
+		return
+	} else if err = cfg.TimeFormat.LoadFormats(tg); err != nil {
+		return
+	}
+	if cfg.Time_Format_Directory != `` {
+		var fd *timegrinder.FormatDirectory
+		if fd, err = timegrinder.NewFormatDirectory(cfg.Time_Format_Directory); err != nil {
+			return
+		} else if err = tg.SetFormatDirectory(fd); err != nil {
+			return
+		}
+	}
+	var profile []string
+	if profile, err = cfg.timeProfile(b); err != nil {
+		return
+	} else if err = tg.SetFormatProfile(profile); err != nil {
+		return
+	}
+	if b.Assume_Local_Timezone {
+		tg.SetLocalTime()
+	}
+	if b.Timezone_Override != `` {
+		if err = tg.SetTimezone(b.Timezone_Override); err != nil {
+			return
+		}
+	}
+	err = tg.SetFormatOverride(b.Timestamp_Format_Override)
+	return
+}
+
+// runTimestampTest shows which time format each sample matches using a listener's time settings,
+// with no samples the formats are listed.  It returns the exit code.
+func runTimestampTest(cfg *cfgType, name string, args []string) int {
+	tg, err := timestampTestGrinder(cfg, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL: %v\n", err)
+		return 1
+	}
+	samples, err := utils.TimestampSamples(args, os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAIL: %v\n", err)
+		return 1
+	} else if !utils.TestTimestamps(os.Stdout, tg, samples) {
+		return 1
+	}
+	return 0
+}
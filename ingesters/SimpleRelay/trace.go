@@ -0,0 +1,59 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/tracing"
+)
+
+var (
+	tracer *tracing.Tracer // nil unless Trace-Endpoint is set
+)
+
+// traceProcessor starts a trace for sampled entries as they come off a listener, the span
+// rides along in the context so the preprocessors and muxer can hang their spans off it
+type traceProcessor struct {
+	entProcessor
+	listener string
+	remote   string
+}
+
+func (tp traceProcessor) ProcessContext(ent *entry.Entry, ctx context.Context) error {
+	if ent == nil || !tracer.Sample() {
+		return tp.entProcessor.ProcessContext(ent, ctx)
+	}
+	tctx, span := tracer.Start(ctx, `listener.accept`, tracing.KindServer,
+		tracing.String(`listener`, tp.listener),
+		tracing.String(`remote`, tp.remote),
+		tracing.Int(`bytes`, int64(len(ent.Data))))
+	err := tp.entProcessor.ProcessContext(ent, tctx)
+	span.SetError(err)
+	span.End()
+	return err
+}
+
+// traceWrap hands back a processor that traces sampled entries from raddr, proc is returned
+// as is if tracing is disabled
+func traceWrap(listener string, proc entProcessor, raddr net.Addr) entProcessor {
+	if tracer == nil {
+		return proc
+	}
+	tp := traceProcessor{
+		entProcessor: proc,
+		listener:     listener,
+	}
+	if raddr != nil {
+		tp.remote = raddr.String()
+	}
+	return tp
+}
@@ -0,0 +1,166 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
+)
+
+const (
+	maxUDPWorkers    = 256
+	maxUDPReadBuffer = 1024 * 1024 * 1024
+	defaultUDPBatch  = 32
+	maxUDPBatch      = 1024
+	udpBufferSize    = 16 * 1024 //big enough for even the largest UDP packets
+)
+
+var (
+	ErrUDPOptsWithoutUDP    = errors.New("UDP-Workers, UDP-Read-Buffer, and UDP-Batch-Size require a udp Bind-String")
+	ErrInvalidUDPBatch      = fmt.Errorf("UDP-Batch-Size must be between 1 and %d", maxUDPBatch)
+	ErrInvalidUDPWorkers    = fmt.Errorf("UDP-Workers must be between 1 and %d", maxUDPWorkers)
+	ErrInvalidUDPReadBuffer = errors.New("Invalid UDP-Read-Buffer")
+	ErrReusePortUnsupported = errors.New("UDP-Workers greater than 1 requires SO_REUSEPORT, which is not supported on this platform")
+)
+
+// udpOptions control how many sockets a UDP listener binds.  A single socket read by a single
+// goroutine tops out well below line rate, so busy collectors bind several sockets to the same
+// address with SO_REUSEPORT and the kernel spreads packets across them by flow, each socket
+// has a dedicated reader.  Larger kernel buffers ride out bursts while readers catch up.
+// On Linux each reader pulls a batch of datagrams per recvmmsg call.
+type udpOptions struct {
+	workers    int
+	readBuffer int // bytes, zero leaves the kernel default alone
+	batch      int // datagrams per read
+}
+
+func (l *listener) udpOptions() (uo udpOptions, err error) {
+	uo.workers = 1
+	uo.batch = defaultUDPBatch
+	if l.UDP_Workers == 0 && l.UDP_Read_Buffer == `` && l.UDP_Batch_Size == 0 {
+		return
+	}
+	if tp, _, lerr := netframe.ParseBind(l.Bind_String); lerr != nil || !tp.UDP() {
+		err = ErrUDPOptsWithoutUDP
+		return
+	}
+	if l.UDP_Workers != 0 {
+		if l.UDP_Workers < 0 || l.UDP_Workers > maxUDPWorkers {
+			err = ErrInvalidUDPWorkers
+			return
+		} else if l.UDP_Workers > 1 && !reusePortSupported {
+			err = ErrReusePortUnsupported
+			return
+		}
+		uo.workers = l.UDP_Workers
+	}
+	if l.UDP_Read_Buffer != `` {
+		var sz int64
+		if sz, err = config.ParseDataSize(l.UDP_Read_Buffer); err != nil || sz <= 0 || sz > maxUDPReadBuffer {
+			err = fmt.Errorf("%w %q", ErrInvalidUDPReadBuffer, l.UDP_Read_Buffer)
+			return
+		}
+		uo.readBuffer = int(sz)
+	}
+	if l.UDP_Batch_Size != 0 {
+		if l.UDP_Batch_Size < 0 || l.UDP_Batch_Size > maxUDPBatch {
+			err = ErrInvalidUDPBatch
+			return
+		}
+		uo.batch = l.UDP_Batch_Size
+	}
+	return
+}
+
+// udpWorkerKey is the handover key of a worker socket, the first socket keeps the listener key
+// so sockets are handed over to and from relays which bind a single socket
+func udpWorkerKey(key string, i int) string {
+	if i == 0 {
+		return key
+	}
+	return key + `#` + strconv.Itoa(i)
+}
+
+// listenUDPWorkers binds the sockets of a UDP listener
+func listenUDPWorkers(key, name, network string, addr *net.UDPAddr, uo udpOptions) (conns []*net.UDPConn, err error) {
+	for i := 0; i < uo.workers; i++ {
+		var c *net.UDPConn
+		if c, err = handover.listenUDP(udpWorkerKey(key, i), network, addr, uo.workers > 1); err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		if uo.readBuffer > 0 {
+			setUDPReadBuffer(c, name, uo.readBuffer)
+		}
+		conns = append(conns, c)
+	}
+	return
+}
+
+// setUDPReadBuffer sizes the kernel receive buffer, the kernel quietly caps the size at
+// net.core.rmem_max so we warn if we did not get what was asked for
+func setUDPReadBuffer(c *net.UDPConn, name string, sz int) {
+	if err := c.SetReadBuffer(sz); err != nil {
+		lg.Warn("failed to set UDP read buffer", log.KV("listener", name), log.KV("size", sz), log.KVErr(err))
+	} else if got, err := udpReadBuffer(c); err == nil && got < sz {
+		lg.Warn("UDP read buffer is smaller than requested, raise net.core.rmem_max",
+			log.KV("listener", name), log.KV("requested", sz), log.KV("actual", got))
+	}
+}
+
+// listenReusePort binds a UDP socket with SO_REUSEPORT set so other sockets may share the address
+func listenReusePort(network string, addr *net.UDPAddr) (c *net.UDPConn, err error) {
+	lc := net.ListenConfig{Control: reusePortControl}
+	var pc net.PacketConn
+	if pc, err = lc.ListenPacket(context.Background(), network, addr.String()); err != nil {
+		return
+	}
+	var ok bool
+	if c, ok = pc.(*net.UDPConn); !ok {
+		pc.Close()
+		err = fmt.Errorf("socket for %v is not a UDP socket", addr)
+	}
+	return
+}
+
+// packetReader hands out datagrams one at a time, the data is only valid until the next read
+type packetReader interface {
+	ReadPacket() ([]byte, net.Addr, error)
+}
+
+// singlePacketReader reads a datagram per syscall, it is used for unixgram sockets, batches
+// of one, and platforms without recvmmsg
+type singlePacketReader struct {
+	c    net.PacketConn
+	buff []byte
+}
+
+func newSinglePacketReader(c net.PacketConn) *singlePacketReader {
+	return &singlePacketReader{
+		c:    c,
+		buff: make([]byte, udpBufferSize),
+	}
+}
+
+func (r *singlePacketReader) ReadPacket() (b []byte, raddr net.Addr, err error) {
+	var n int
+	if n, raddr, err = r.c.ReadFrom(r.buff); err == nil {
+		b = r.buff[:n]
+	}
+	return
+}
@@ -0,0 +1,153 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestUDPOptions(t *testing.T) {
+	l := &listener{base: base{Bind_String: `udp://0.0.0.0:514`}}
+	if uo, err := l.udpOptions(); err != nil {
+		t.Fatal(err)
+	} else if uo.workers != 1 || uo.readBuffer != 0 || uo.batch != defaultUDPBatch {
+		t.Fatalf("bad defaults %+v", uo)
+	}
+	l.UDP_Read_Buffer = `8MB`
+	if uo, err := l.udpOptions(); err != nil {
+		t.Fatal(err)
+	} else if uo.workers != 1 || uo.readBuffer != 8*1024*1024 {
+		t.Fatalf("bad read buffer %+v", uo)
+	}
+	if reusePortSupported {
+		l.UDP_Workers = 4
+		if uo, err := l.udpOptions(); err != nil {
+			t.Fatal(err)
+		} else if uo.workers != 4 {
+			t.Fatalf("bad workers %+v", uo)
+		}
+	}
+
+	bad := []struct {
+		bind    string
+		workers int
+		buff    string
+		err     error
+	}{
+		{`tcp://0.0.0.0:601`, 4, ``, ErrUDPOptsWithoutUDP},
+		{`unixgram:///tmp/relay.sock`, 0, `1MB`, ErrUDPOptsWithoutUDP},
+		{`udp://0.0.0.0:514`, -1, ``, ErrInvalidUDPWorkers},
+		{`udp://0.0.0.0:514`, maxUDPWorkers + 1, ``, ErrInvalidUDPWorkers},
+		{`udp://0.0.0.0:514`, 0, `lots`, ErrInvalidUDPReadBuffer},
+		{`udp://0.0.0.0:514`, 0, `0`, ErrInvalidUDPReadBuffer},
+		{`udp://0.0.0.0:514`, 0, `2GB`, ErrInvalidUDPReadBuffer},
+	}
+	for _, b := range bad {
+		l := &listener{base: base{Bind_String: b.bind}, UDP_Workers: b.workers, UDP_Read_Buffer: b.buff}
+		if _, err := l.udpOptions(); !errors.Is(err, b.err) {
+			t.Fatalf("bad error on %+v: %v", b, err)
+		}
+	}
+
+	l = &listener{base: base{Bind_String: `udp://0.0.0.0:514`}, UDP_Batch_Size: 1}
+	if uo, err := l.udpOptions(); err != nil {
+		t.Fatal(err)
+	} else if uo.batch != 1 {
+		t.Fatalf("bad batch size %+v", uo)
+	}
+	for _, v := range []int{-1, maxUDPBatch + 1} {
+		l.UDP_Batch_Size = v
+		if _, err := l.udpOptions(); err != ErrInvalidUDPBatch {
+			t.Fatalf("bad error on batch size %d: %v", v, err)
+		}
+	}
+}
+
+func TestPacketReader(t *testing.T) {
+	for _, batch := range []int{1, 4} {
+		c, err := net.ListenUDP(`udp`, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		snd, err := net.DialUDP(`udp`, nil, c.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs := []string{`first`, `second`, `third`, `fourth`, `fifth`, `sixth`}
+		for _, m := range msgs {
+			if _, err = snd.Write([]byte(m)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pr := newPacketReader(c, batch)
+		for _, m := range msgs {
+			b, raddr, err := pr.ReadPacket()
+			if err != nil {
+				t.Fatal(err)
+			} else if string(b) != m {
+				t.Fatalf("batch %d read %q, expected %q", batch, b, m)
+			} else if raddr.String() != snd.LocalAddr().String() {
+				t.Fatalf("batch %d bad remote address %v, expected %v", batch, raddr, snd.LocalAddr())
+			}
+		}
+		snd.Close()
+		c.Close()
+		if _, _, err = pr.ReadPacket(); err == nil {
+			t.Fatal("read from a closed socket")
+		}
+	}
+}
+
+func TestUDPWorkerKey(t *testing.T) {
+	key := handoverKey(`listener`, `syslogudp`, `udp://0.0.0.0:514`)
+	if udpWorkerKey(key, 0) != key {
+		t.Fatal("first worker does not use the listener key")
+	}
+	if k1, k2 := udpWorkerKey(key, 1), udpWorkerKey(key, 2); k1 == key || k1 == k2 {
+		t.Fatalf("worker keys are not unique: %q %q", k1, k2)
+	}
+}
+
+func TestListenUDPWorkers(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("SO_REUSEPORT is not supported")
+	}
+	//find a free port
+	c, err := net.ListenUDP(`udp`, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := c.LocalAddr().(*net.UDPAddr)
+	c.Close()
+
+	conns, err := listenUDPWorkers(`test/workers`, `workers`, `udp`, addr, udpOptions{workers: 4, readBuffer: 256 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	if len(conns) != 4 {
+		t.Fatalf("bound %d sockets, expected 4", len(conns))
+	}
+	for _, c := range conns {
+		if c.LocalAddr().String() != addr.String() {
+			t.Fatalf("worker bound to %v, expected %v", c.LocalAddr(), addr)
+		}
+	}
+	//a socket without SO_REUSEPORT cannot join the group
+	if c, err = net.ListenUDP(`udp`, addr); err == nil {
+		c.Close()
+		t.Fatal("bound a plain socket to a shared address")
+	}
+}
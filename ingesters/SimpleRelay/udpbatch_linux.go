@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// batchPacketReader pulls up to a batch of datagrams per recvmmsg call and hands them out one at a time
+type batchPacketReader struct {
+	pc   *ipv4.PacketConn
+	msgs []ipv4.Message
+	n    int
+	idx  int
+}
+
+// newPacketReader returns a batching reader for UDP sockets, anything else reads a datagram at a time.
+// The batch reader works on IPv6 sockets too, addresses are decoded by their family.
+func newPacketReader(c net.PacketConn, batch int) packetReader {
+	uc, ok := c.(*net.UDPConn)
+	if !ok || batch <= 1 {
+		return newSinglePacketReader(c)
+	}
+	r := &batchPacketReader{
+		pc:   ipv4.NewPacketConn(uc),
+		msgs: make([]ipv4.Message, batch),
+	}
+	for i := range r.msgs {
+		r.msgs[i].Buffers = [][]byte{make([]byte, udpBufferSize)}
+	}
+	return r
+}
+
+func (r *batchPacketReader) ReadPacket() (b []byte, raddr net.Addr, err error) {
+	for r.idx >= r.n {
+		r.idx = 0
+		if r.n, err = r.pc.ReadBatch(r.msgs, 0); err != nil {
+			r.n = 0
+			return
+		}
+	}
+	m := &r.msgs[r.idx]
+	r.idx++
+	b, raddr = m.Buffers[0][:m.N], m.Addr
+	return
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"net"
+)
+
+// newPacketReader reads a datagram at a time, recvmmsg is only available on Linux
+func newPacketReader(c net.PacketConn, batch int) packetReader {
+	return newSinglePacketReader(c)
+}
@@ -43,24 +43,14 @@ type Args struct {
 }
 
 func Parse() (a Args, err error) {
-	flag.Parse()
+	if a, err = ParseTags(); err != nil {
+		return
+	}
 	if *timeoutSec < 0 {
 		err = errors.New("Invalid timeout")
 		return
 	}
 	a.Timeout = time.Second * time.Duration(*timeoutSec)
-	if *tagName == "" {
-		err = errors.New("tag name required")
-		return
-	} else {
-		//verify that the tag name is valid
-		*tagName = strings.TrimSpace(*tagName)
-		if strings.ContainsAny(*tagName, ingest.FORBIDDEN_TAG_SET) {
-			err = errors.New("Forbidden characters in tag")
-			return
-		}
-	}
-	a.Tags = []string{*tagName}
 	if *clearConns != "" {
 		for _, conn := range strings.Split(*clearConns, ",") {
 			conn = strings.TrimSpace(conn)
@@ -107,3 +97,20 @@ func Parse() (a Args, err error) {
 	}
 	return
 }
+
+// ParseTags only parses and validates the tag, it is for tools that do not connect to an indexer
+func ParseTags() (a Args, err error) {
+	flag.Parse()
+	if *tagName == "" {
+		err = errors.New("tag name required")
+		return
+	}
+	//verify that the tag name is valid
+	*tagName = strings.TrimSpace(*tagName)
+	if strings.ContainsAny(*tagName, ingest.FORBIDDEN_TAG_SET) {
+		err = errors.New("Forbidden characters in tag")
+		return
+	}
+	a.Tags = []string{*tagName}
+	return
+}
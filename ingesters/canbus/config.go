@@ -10,7 +10,7 @@ package main
 
 import (
 	"errors"
-	"net"
+	"fmt"
 	"strings"
 	"time"
 
@@ -85,8 +85,8 @@ func verifyConfig(c *cfgType) error {
 			return errors.New("Invalid characters in the \"" + v.Tag_Name + "\"Tag-Name for " + k)
 		}
 		if v.Source_Override != `` {
-			if net.ParseIP(v.Source_Override) == nil {
-				return errors.New("Failed to parse Source_Override")
+			if _, err := config.ParseSourceOverride(v.Source_Override); err != nil {
+				return fmt.Errorf("Invalid Source-Override for %s: %w", k, err)
 			}
 		}
 	}
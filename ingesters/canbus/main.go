@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingesters/utils"
@@ -99,10 +100,9 @@ func main() {
 		}
 		var src net.IP
 		if v.Source_Override != `` {
-			src = net.ParseIP(v.Source_Override)
-			if src == nil {
+			if src, err = config.ParseSourceOverride(v.Source_Override); err != nil {
 				closeSniffers(sniffs)
-				log.Fatal("Source-Override is invalid")
+				log.Fatal("Source-Override is invalid: ", err)
 			}
 		}
 		c, err := New(v.Interface)
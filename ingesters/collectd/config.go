@@ -237,8 +237,8 @@ func (c collector) srcOverride() (ip net.IP, err error) {
 	if len(v) == 0 {
 		return
 	}
-	if ip = net.ParseIP(v); ip == nil {
-		err = ErrInvalidSourceOverride
+	if ip, err = config.ParseSourceOverride(v); err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalidSourceOverride, err)
 	}
 	return
 }
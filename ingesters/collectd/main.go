@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net"
 	"os"
 	"path/filepath"
 	"runtime/debug"
@@ -150,7 +149,7 @@ func main() {
 		CachePath:          cfg.Ingest_Cache_Path,
 		CacheSize:          cfg.Max_Ingest_Cache,
 		CacheMode:          cfg.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Log_Source_Override),
+		LogSourceOverride:  cfg.LogSourceOverride(),
 	}
 	igst, err := ingest.NewUniformMuxer(igCfg)
 	if err != nil {
@@ -204,6 +203,7 @@ func main() {
 		if cc.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
 			lg.Fatal("preprocessor error", log.KV("collector", k), log.KV("preprocessor", v.Preprocessor), log.KVErr(err))
 		}
+		cc.proc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
 
 		cc.src = nil
 
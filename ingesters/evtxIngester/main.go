@@ -0,0 +1,225 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingesters/args"
+	"github.com/gravwell/gravwell/v3/ingesters/version"
+	"github.com/gravwell/gravwell/v3/winevent/evtx"
+)
+
+var (
+	inFile    = flag.String("i", "", "Input EVTX file to process")
+	ver       = flag.Bool("version", false, "Print version and exit")
+	ignoreTS  = flag.Bool("ignore-ts", false, "Ignore event timestamps and use the current time")
+	verbose   = flag.Bool("verbose", false, "Print every step")
+	blockSize = flag.Int("block-size", 0, "Optimized ingest using blocks, 0 disables")
+	status    = flag.Bool("status", false, "Output ingest rate stats as we go")
+	srcOvr    = flag.String("source-override", "", "Override source with address, hash, or integeter")
+
+	count       uint64
+	skipped     uint64
+	totalBytes  uint64
+	dur         time.Duration
+	bsize       int
+	srcOverride net.IP
+	start       time.Time
+)
+
+func init() {
+	flag.Parse()
+	if *ver {
+		version.PrintVersion(os.Stdout)
+		ingest.PrintVersion(os.Stdout)
+		os.Exit(0)
+	}
+	if *blockSize > 0 {
+		bsize = *blockSize
+	}
+}
+
+func main() {
+	debug.SetTraceback("all")
+	if *inFile == "" {
+		log.Fatal("Input file path required")
+	}
+	a, err := args.Parse()
+	if err != nil {
+		log.Fatalf("Invalid arguments: %v\n", err)
+	}
+	if len(a.Tags) != 1 {
+		log.Fatal("File oneshot only accepts a single tag")
+	}
+
+	if *srcOvr != `` {
+		if srcOverride, err = config.ParseSource(*srcOvr); err != nil {
+			log.Fatalf("Invalid source override")
+		}
+	}
+
+	//open the file and check the header before we go connect to anything
+	fin, err := os.Open(*inFile)
+	if err != nil {
+		log.Fatalf("Failed to open %s: %v\n", *inFile, err)
+	}
+	defer fin.Close()
+	rdr, err := evtx.NewReader(bufio.NewReaderSize(fin, evtx.ChunkSize))
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v\n", *inFile, err)
+	}
+
+	//fire up a uniform muxer
+	igst, err := ingest.NewUniformIngestMuxer(a.Conns, a.Tags, a.IngestSecret, a.TLSPublicKey, a.TLSPrivateKey, "")
+	if err != nil {
+		log.Fatalf("Failed to create new ingest muxer: %v\n", err)
+	}
+	if err := igst.Start(); err != nil {
+		log.Fatalf("Failed to start ingest muxer: %v\n", err)
+	}
+	if err := igst.WaitForHot(a.Timeout); err != nil {
+		log.Fatalf("Failed to wait for hot connection: %v\n", err)
+	}
+	tag, err := igst.GetTag(a.Tags[0])
+	if err != nil {
+		log.Fatalf("Failed to resolve tag %s: %v\n", a.Tags[0], err)
+	}
+
+	//go ingest the file
+	if err := doIngest(rdr, igst, tag); err != nil {
+		log.Fatalf("Failed to ingest file: %v\n", err)
+	}
+
+	if err = igst.Sync(a.Timeout); err != nil {
+		log.Fatalf("Failed to sync ingest muxer: %v\n", err)
+	}
+	if err := igst.Close(); err != nil {
+		log.Fatalf("Failed to close the ingest muxer: %v\n", err)
+	}
+	fmt.Printf("Completed in %v (%s)\n", dur, ingest.HumanSize(totalBytes))
+	fmt.Printf("Total Count: %s\n", ingest.HumanCount(count))
+	fmt.Printf("Chunks: %d\n", rdr.Chunks())
+	if skipped > 0 {
+		fmt.Printf("Skipped Records: %s\n", ingest.HumanCount(skipped))
+	}
+	fmt.Printf("Entry Rate: %s\n", ingest.HumanEntryRate(count, dur))
+	fmt.Printf("Ingest Rate: %s\n", ingest.HumanRate(totalBytes, dur))
+}
+
+func doIngest(rdr *evtx.Reader, igst *ingest.IngestMuxer, tag entry.EntryTag) (err error) {
+	//if not doing regular updates, just fire it off
+	if !*status {
+		err = ingestFile(rdr, igst, tag)
+		return
+	}
+
+	errCh := make(chan error, 1)
+	tckr := time.NewTicker(time.Second)
+	defer tckr.Stop()
+	go func(ch chan error) {
+		ch <- ingestFile(rdr, igst, tag)
+	}(errCh)
+
+loop:
+	for {
+		lastts := time.Now()
+		lastcnt := count
+		lastsz := totalBytes
+		select {
+		case err = <-errCh:
+			fmt.Println("\nDONE")
+			break loop
+		case _ = <-tckr.C:
+			dur := time.Since(lastts)
+			cnt := count - lastcnt
+			bts := totalBytes - lastsz
+			fmt.Printf("\r%s %s                                     ",
+				ingest.HumanEntryRate(cnt, dur),
+				ingest.HumanRate(bts, dur))
+		}
+	}
+	return
+}
+
+func ingestFile(rdr *evtx.Reader, igst *ingest.IngestMuxer, tag entry.EntryTag) error {
+	var blk []*entry.Entry
+	src := srcOverride
+	if src == nil {
+		var err error
+		if src, err = igst.SourceIP(); err != nil {
+			return err
+		}
+	}
+
+	if bsize > 0 {
+		blk = make([]*entry.Entry, 0, bsize)
+	}
+	start = time.Now()
+
+	for {
+		rec, err := rdr.Next()
+		if err == io.EOF {
+			break
+		} else if errors.Is(err, evtx.ErrInvalidRecord) {
+			//damaged records are common in logs pulled off of compromised hosts, keep going
+			log.Printf("Skipping record: %v\n", err)
+			skipped++
+			continue
+		} else if err != nil {
+			return err
+		}
+		ts := rec.Timestamp()
+		if *ignoreTS || ts.IsZero() {
+			ts = time.Now()
+		}
+		ent := &entry.Entry{
+			TS:   entry.FromStandard(ts),
+			Tag:  tag,
+			SRC:  src,
+			Data: rec.XML,
+		}
+		if bsize == 0 {
+			if err = igst.WriteEntry(ent); err != nil {
+				return err
+			}
+		} else {
+			blk = append(blk, ent)
+			if len(blk) >= bsize {
+				if err = igst.WriteBatch(blk); err != nil {
+					return err
+				}
+				blk = make([]*entry.Entry, 0, bsize)
+			}
+		}
+		if *verbose {
+			fmt.Println(ent.TS, ent.Tag, ent.SRC, string(ent.Data))
+		}
+		count++
+		totalBytes += uint64(len(ent.Data))
+	}
+	var err error
+	if len(blk) > 0 {
+		err = igst.WriteBatch(blk)
+	}
+	dur = time.Since(start)
+	return err
+}
@@ -18,7 +18,9 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gravwell/gravwell/v3/filewatch"
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/charset"
 	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
@@ -32,6 +34,9 @@ const (
 var (
 	ErrInvalidStateStoreLocation         = errors.New("Empty state storage location")
 	ErrTimestampDelimiterMissingOverride = errors.New("Timestamp delimiting requires a defined timestamp override")
+	ErrMultipleDelimiters                = errors.New("Only one of Timestamp-Delimited, Regex-Delimiter, Multiline-Start-Regex, or JSON-Delimited may be set")
+	ErrContinueWithoutStart              = errors.New("Multiline-Continue-Regex requires Multiline-Start-Regex")
+	ErrWideCharsetDelimiter              = errors.New("UTF-16 Charsets cannot be used with Timestamp-Delimited, Regex-Delimiter, Multiline-Start-Regex, or JSON-Delimited")
 )
 
 type bindType int
@@ -57,12 +62,24 @@ type follower struct {
 	Timestamp_Delimited       bool
 	Timezone_Override         string
 	Regex_Delimiter           string
+	Multiline_Start_Regex     string   // lines matching this begin a new entry
+	Multiline_Continue_Regex  string   // optional, only lines matching this are appended to an entry
+	JSON_Delimited            bool     // entries are brace balanced JSON objects
+	Charset                   string   // character encoding of the files, entries are transcoded to UTF-8
+	Catchup_Rotated           bool     // at startup, ingest rotations written since the state was saved
+	Path_Regex                string   // named captures from file paths become metadata and tag components
+	Path_Metadata             []string // path captures to attach, all named captures by default
+	Path_Metadata_Mode        string   // auto, json, prefix, or none
+	Path_Metadata_Field       string   // optional JSON field to nest path captures under
+	Tag_Replacement           string   // replaces characters that are not allowed in tags built from path captures
 	Preprocessor              []string
+	processors.ChunkConfig
 	// these two must be used together
 	Timestamp_Regex         string
 	Timestamp_Format_String string
 	// so that we can initialize the timegrinder
-	timeFormats config.CustomTimeFormat
+	timeFormats    config.CustomTimeFormat
+	tagReplacement rune
 }
 
 type global struct {
@@ -130,6 +147,15 @@ func verifyConfig(c *cfgType) error {
 		if v.Timestamp_Delimited && v.Timestamp_Format_Override == `` {
 			return ErrTimestampDelimiterMissingOverride
 		}
+		if err := v.verifyDelimiters(); err != nil {
+			return fmt.Errorf("Follower %s: %w", k, err)
+		}
+		if err := v.verifyCharset(); err != nil {
+			return fmt.Errorf("Follower %s: %w", k, err)
+		}
+		if err := v.ChunkConfig.Validate(); err != nil {
+			return fmt.Errorf("Follower %s: %w", k, err)
+		}
 		if (v.Timestamp_Regex != `` && v.Timestamp_Format_String == ``) || (v.Timestamp_Regex == `` && v.Timestamp_Format_String != ``) {
 			return errors.New("Timestamp-Regex and Timestamp-Format-String must both be specified, or both left unset")
 		}
@@ -139,7 +165,21 @@ func verifyConfig(c *cfgType) error {
 				return fmt.Errorf("Failed to parse Timestamp-Regex and Timestamp-Format-String defs: %v", err)
 			}
 		}
-		if strings.ContainsAny(v.Tag_Name, ingest.FORBIDDEN_TAG_SET) {
+		if v.Path_Regex != `` {
+			if err := filewatch.CheckPathRegex(v.Path_Regex, v.Tag_Name); err != nil {
+				return fmt.Errorf("Follower %s: %w", k, err)
+			}
+		} else if filewatch.IsTagTemplate(v.Tag_Name) {
+			return fmt.Errorf("Follower %s: %w", k, filewatch.ErrTagTemplateNoRegex)
+		} else if len(v.Path_Metadata) > 0 {
+			return errors.New("Path-Metadata requires a Path-Regex for " + k)
+		}
+		r, err := ingest.ParseTagReplacement(v.Tag_Replacement)
+		if err != nil {
+			return fmt.Errorf("Follower %s: invalid Tag-Replacement: %w", k, err)
+		}
+		v.tagReplacement = r
+		if !filewatch.IsTagTemplate(v.Tag_Name) && strings.ContainsAny(v.Tag_Name, ingest.FORBIDDEN_TAG_SET) {
 			return errors.New("Invalid characters in the Tag-Name for " + k)
 		}
 		v.Base_Directory = filepath.Clean(v.Base_Directory)
@@ -161,10 +201,14 @@ func verifyConfig(c *cfgType) error {
 
 func (c *cfgType) Tags() ([]string, error) {
 	var tags []string
+	var templated bool
 	tagMp := make(map[string]bool, 1)
 	for _, v := range c.Follower {
 		if len(v.Tag_Name) == 0 {
 			continue
+		} else if filewatch.IsTagTemplate(v.Tag_Name) {
+			templated = true //negotiated as files are found
+			continue
 		}
 		if _, ok := tagMp[v.Tag_Name]; !ok {
 			tags = append(tags, v.Tag_Name)
@@ -172,7 +216,10 @@ func (c *cfgType) Tags() ([]string, error) {
 		}
 	}
 	if len(tags) == 0 {
-		return nil, errors.New("No tags specified")
+		if !templated {
+			return nil, errors.New("No tags specified")
+		}
+		tags = append(tags, entry.DefaultTagName)
 	}
 	sort.Strings(tags)
 	return tags, nil
@@ -236,6 +283,65 @@ func (f follower) TimestampDelimited() (rex string, ok bool, err error) {
 	return
 }
 
+func (f follower) verifyDelimiters() error {
+	var cnt int
+	for _, set := range []bool{f.Timestamp_Delimited, f.Regex_Delimiter != ``, f.Multiline_Start_Regex != ``, f.JSON_Delimited} {
+		if set {
+			cnt++
+		}
+	}
+	if cnt > 1 {
+		return ErrMultipleDelimiters
+	} else if f.Multiline_Continue_Regex != `` && f.Multiline_Start_Regex == `` {
+		return ErrContinueWithoutStart
+	}
+	for _, rx := range []string{f.Regex_Delimiter, f.Multiline_Start_Regex, f.Multiline_Continue_Regex} {
+		if _, err := regexp.Compile(rx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyCharset checks the charset name, UTF-16 files are split on aligned newlines
+// so they can only be line delimited
+func (f follower) verifyCharset() error {
+	cs, err := charset.Parse(f.Charset)
+	if err != nil {
+		return err
+	} else if cs.Wide() && (f.Timestamp_Delimited || f.Regex_Delimiter != `` || f.Multiline_Start_Regex != `` || f.JSON_Delimited) {
+		return ErrWideCharsetDelimiter
+	}
+	return nil
+}
+
+// EngineConfig picks the filewatch engine that frames entries for this follower
+func (f follower) EngineConfig() (ec filewatch.FollowerEngineConfig, err error) {
+	var rex string
+	var ok bool
+	if ec.Charset, err = charset.Parse(f.Charset); err != nil {
+		return
+	}
+	if rex, ok, err = f.TimestampDelimited(); err != nil {
+		return
+	} else if ok {
+		ec.Engine = filewatch.RegexEngine
+		ec.EngineArgs = rex
+	} else if f.Regex_Delimiter != `` {
+		ec.Engine = filewatch.RegexEngine
+		ec.EngineArgs = f.Regex_Delimiter
+	} else if f.Multiline_Start_Regex != `` {
+		ec.Engine = filewatch.MultilineEngine
+		ec.EngineArgs = f.Multiline_Start_Regex
+		ec.ContinueArgs = f.Multiline_Continue_Regex
+	} else if f.JSON_Delimited {
+		ec.Engine = filewatch.JSONEngine
+	} else {
+		ec.Engine = filewatch.LineEngine
+	}
+	return
+}
+
 func (f follower) TimezoneOverride() string {
 	return f.Timezone_Override
 }
@@ -12,6 +12,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
@@ -23,7 +24,10 @@ import (
 
 	"github.com/gravwell/gravwell/v3/filewatch"
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/ha"
 	"github.com/gravwell/gravwell/v3/ingest/log"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
 	"github.com/gravwell/gravwell/v3/ingesters/utils"
@@ -146,7 +150,7 @@ func main() {
 		CachePath:          cfg.Ingest_Cache_Path,
 		CacheSize:          cfg.Max_Ingest_Cache,
 		CacheMode:          cfg.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Log_Source_Override),
+		LogSourceOverride:  cfg.LogSourceOverride(),
 	}
 	igst, err := ingest.NewUniformMuxer(ingestConfig)
 	if err != nil {
@@ -177,14 +181,35 @@ func main() {
 	var src net.IP
 	if cfg.Source_Override != "" {
 		// global override
-		if src = net.ParseIP(cfg.Source_Override); src == nil {
-			lg.Fatal("Global Source-Override is invalid", log.KV("sourceoverride", cfg.Source_Override))
+		if src, err = config.ParseSourceOverride(cfg.Source_Override); err != nil {
+			lg.Fatal("Global Source-Override is invalid", log.KV("sourceoverride", cfg.Source_Override), log.KVErr(err))
 		}
 	} else {
 		//it is fine to set it to nil, it will be set by the ingest muxer, this can and WILL fail sometimes
 		src, _ = igst.SourceIP()
 	}
 
+	//in an HA pair only the instance holding the lease follows files
+	qc := utils.GetQuitChannel()
+	haMgr, err := ha.NewManager(cfg.LeaseConfig, ha.NodeID(id.String()), cfg.Secret())
+	if err != nil {
+		lg.FatalCode(0, "failed to set up HA lease", log.KVErr(err))
+	}
+	var haLost <-chan struct{}
+	if haMgr != nil {
+		lg.Info("waiting to acquire HA lease", log.KV("mode", cfg.HA_Mode))
+		if quit, err := waitForLease(haMgr, qc); err != nil {
+			lg.FatalCode(0, "failed to acquire HA lease", log.KVErr(err))
+		} else if quit {
+			haMgr.Close()
+			igst.Close()
+			return
+		}
+		lg.Info("acquired HA lease, following files", log.KV("mode", cfg.HA_Mode))
+		defer haMgr.Close()
+		haLost = haMgr.Lost()
+	}
+
 	wtcher, err := filewatch.NewWatcher(cfg.StatePath())
 	if err != nil {
 		lg.Fatal("failed to create notification watcher", log.KVErr(err))
@@ -202,11 +227,14 @@ func main() {
 		if err != nil {
 			lg.FatalCode(0, "preprocessor construction error", log.KVErr(err))
 		}
+		pproc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
 		procs = append(procs, pproc)
 		//get the tag for this listener
-		tag, err := igst.GetTag(val.Tag_Name)
-		if err != nil {
-			lg.Fatal("failed to resolve tag", log.KV("watcher", k), log.KV("tag", val.Tag_Name), log.KVErr(err))
+		var tag entry.EntryTag
+		if !filewatch.IsTagTemplate(val.Tag_Name) {
+			if tag, err = igst.GetTag(val.Tag_Name); err != nil {
+				lg.Fatal("failed to resolve tag", log.KV("watcher", k), log.KV("tag", val.Tag_Name), log.KVErr(err))
+			}
 		}
 
 		tsFmtOverride, err := val.TimestampOverride()
@@ -230,6 +258,13 @@ func main() {
 			TimezoneOverride:        val.Timezone_Override,
 			Ctx:                     wtcher.Context(),
 			TimeFormat:              cfg.TimeFormat,
+			PathRegex:               val.Path_Regex,
+			PathMetadata:            val.Path_Metadata,
+			PathMetadataMode:        val.Path_Metadata_Mode,
+			PathMetadataField:       val.Path_Metadata_Field,
+			TagResolver:             igst.NegotiateTag,
+			TagReplacement:          val.tagReplacement,
+			EntrySize:               val.ChunkConfig,
 		}
 		if v {
 			cfg.Debugger = debugout
@@ -239,22 +274,15 @@ func main() {
 			lg.Fatal("failed to generate handler", log.KVErr(err))
 		}
 		c := filewatch.WatchConfig{
-			ConfigName: k,
-			BaseDir:    val.Base_Directory,
-			FileFilter: val.File_Filter,
-			Hnd:        lh,
-			Recursive:  val.Recursive,
+			ConfigName:     k,
+			BaseDir:        val.Base_Directory,
+			FileFilter:     val.File_Filter,
+			Hnd:            lh,
+			Recursive:      val.Recursive,
+			CatchupRotated: val.Catchup_Rotated,
 		}
-		if rex, ok, err := val.TimestampDelimited(); err != nil {
-			lg.FatalCode(0, "invalid timestamp delimiter", log.KVErr(err))
-		} else if ok {
-			c.Engine = filewatch.RegexEngine
-			c.EngineArgs = rex
-		} else if val.Regex_Delimiter != `` {
-			c.Engine = filewatch.RegexEngine
-			c.EngineArgs = val.Regex_Delimiter
-		} else {
-			c.Engine = filewatch.LineEngine
+		if c.FollowerEngineConfig, err = val.EngineConfig(); err != nil {
+			lg.FatalCode(0, "invalid entry delimiter", log.KVErr(err))
 		}
 		if err := wtcher.Add(c); err != nil {
 			wtcher.Close()
@@ -262,7 +290,7 @@ func main() {
 				log.KV("filter", val.File_Filter), log.KVErr(err))
 		}
 	}
-	qc := utils.GetQuitChannel()
+	var leaseLost bool
 	if quit, err := wtcher.Catchup(qc); err != nil {
 		lg.Error("failed to catchup file watcher", log.KVErr(err))
 		wtcher.Close()
@@ -283,6 +311,9 @@ func main() {
 		select {
 		case <-qc:
 		case <-wtcher.Context().Done():
+		case <-haLost:
+			lg.Error("lost HA lease, standby instance is taking over")
+			leaseLost = true
 		}
 	}
 	debugout("Attempting to close the watcher... ")
@@ -308,6 +339,28 @@ func main() {
 	if err := igst.Close(); err != nil {
 		lg.Error("failed to close", log.KVErr(err))
 	}
+	if leaseLost {
+		//exit with an error so a supervisor restarts us as the standby
+		os.Exit(-1)
+	}
+}
+
+// waitForLease blocks until the HA lease is acquired or we are told to quit
+func waitForLease(mgr *ha.Manager, qc chan os.Signal) (quit bool, err error) {
+	ctx, cf := context.WithCancel(context.Background())
+	defer cf()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- mgr.WaitActive(ctx)
+	}()
+	select {
+	case <-qc:
+		cf()
+		<-errCh
+		quit = true
+	case err = <-errCh:
+	}
+	return
 }
 
 func debugout(format string, args ...interface{}) {
@@ -20,6 +20,7 @@ import (
 	"github.com/gravwell/gravwell/v3/filewatch"
 	"github.com/gravwell/gravwell/v3/ingest"
 	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/processors"
 	"github.com/gravwell/gravwell/v3/ingesters/version"
 )
@@ -42,6 +43,7 @@ type mainService struct {
 	timeFormats config.CustomTimeFormat
 	wtchr       *filewatch.WatchManager
 	pp          processors.ProcessorConfig
+	ppLatency   time.Duration
 	procs       []*processors.ProcessorSet
 	srcOverride string
 	logLevel    string
@@ -94,6 +96,7 @@ func NewService(cfg *cfgType) (*mainService, error) {
 		wtchr:       wtchr,
 		timeFormats: cfg.TimeFormat,
 		pp:          cfg.Preprocessor,
+		ppLatency:   cfg.PreprocessorMaxLatency(),
 		logLevel:    cfg.LogLevel(),
 		uuid:        id.String(),
 		label:       cfg.Label,
@@ -241,12 +244,15 @@ func (m *mainService) init(ctx context.Context) error {
 			errorout("Preprocessor construction error: %v", err)
 			return err
 		}
+		pproc.SetLatencyBudget(m.ppLatency, 0)
 		m.procs = append(m.procs, pproc)
 		//get the tag for this listener
-		tag, err := igst.GetTag(val.Tag_Name)
-		if err != nil {
-			errorout("Failed to resolve tag \"%s\" for %s: %v\n", val.Tag_Name, k, err)
-			return err
+		var tag entry.EntryTag
+		if !filewatch.IsTagTemplate(val.Tag_Name) {
+			if tag, err = igst.GetTag(val.Tag_Name); err != nil {
+				errorout("Failed to resolve tag \"%s\" for %s: %v\n", val.Tag_Name, k, err)
+				return err
+			}
 		}
 		tsFmtOverride, err := val.TimestampOverride()
 		if err != nil {
@@ -270,6 +276,13 @@ func (m *mainService) init(ctx context.Context) error {
 			UserTimeFormat:          val.Timestamp_Format_String,
 			Ctx:                     ctx,
 			TimeFormat:              m.timeFormats,
+			PathRegex:               val.Path_Regex,
+			PathMetadata:            val.Path_Metadata,
+			PathMetadataMode:        val.Path_Metadata_Mode,
+			PathMetadataField:       val.Path_Metadata_Field,
+			TagResolver:             igst.NegotiateTag,
+			TagReplacement:          val.tagReplacement,
+			EntrySize:               val.ChunkConfig,
 		}
 
 		lh, err := filewatch.NewLogHandler(cfg, pproc)
@@ -278,19 +291,15 @@ func (m *mainService) init(ctx context.Context) error {
 			return err
 		}
 		c := filewatch.WatchConfig{
-			ConfigName: k,
-			BaseDir:    val.Base_Directory,
-			FileFilter: val.File_Filter,
-			Hnd:        lh,
-			Recursive:  val.Recursive,
+			ConfigName:     k,
+			BaseDir:        val.Base_Directory,
+			FileFilter:     val.File_Filter,
+			Hnd:            lh,
+			Recursive:      val.Recursive,
+			CatchupRotated: val.Catchup_Rotated,
 		}
-		if rex, ok, err := val.TimestampDelimited(); err != nil {
-			errorout("Invalid timestamp delimiter: %v\n", err)
-		} else if ok {
-			c.Engine = filewatch.RegexEngine
-			c.EngineArgs = rex
-		} else {
-			c.Engine = filewatch.LineEngine
+		if c.FollowerEngineConfig, err = val.EngineConfig(); err != nil {
+			errorout("Invalid entry delimiter: %v\n", err)
 		}
 
 		if err := m.wtchr.Add(c); err != nil {
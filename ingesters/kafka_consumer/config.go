@@ -68,6 +68,9 @@ type ConfigConsumer struct {
 
 	KafkaAuthConfig
 
+	//optionally pull the entry source out of a field in the message
+	processors.SourceFieldConfig
+
 	//TLS stuff
 	Use_TLS                  bool
 	Insecure_Skip_TLS_Verify bool
@@ -96,6 +99,7 @@ type consumerCfg struct {
 	tagKey      string
 	srcBin      bool
 	srcOverride net.IP
+	srcField    *processors.SourceExtractor
 
 	auth KafkaAuthConfig
 
@@ -247,6 +251,9 @@ func (cc ConfigConsumer) validateAndProcess() (c consumerCfg, err error) {
 	c.srcKey = cc.Source_Header
 	c.tagKey = cc.Tag_Header
 	c.srcBin = cc.Source_As_Binary
+	if c.srcField, err = cc.NewSourceExtractor(); err != nil {
+		return
+	}
 
 	//check leader
 	if len(cc.Leader) == 0 {
@@ -270,8 +277,8 @@ func (cc ConfigConsumer) validateAndProcess() (c consumerCfg, err error) {
 
 	// check that the source override is valid
 	if len(cc.Source_Override) > 0 {
-		if c.srcOverride = net.ParseIP(cc.Source_Override); c.srcOverride == nil {
-			err = fmt.Errorf("Invalid source override %s", cc.Source_Override)
+		if c.srcOverride, err = config.ParseSourceOverride(cc.Source_Override); err != nil {
+			err = fmt.Errorf("Invalid Source-Override: %w", err)
 			return
 		}
 	}
@@ -291,10 +291,12 @@ loop:
 }
 
 func (kc *kafkaConsumer) resolveTag(tn string) (tag entry.EntryTag, ok bool, err error) {
-	if tag, err = kc.tgr.Negotiate(tn); err != nil {
+	//never negotiate tags we aren't going to use
+	if !kc.tgr.AllowedName(tn) {
+		return
+	} else if tag, err = kc.tgr.Negotiate(tn); err != nil {
 		return
 	}
-	//don't have it, so check if its ok
 	ok = kc.tgr.Allowed(tag)
 	return
 }
@@ -351,8 +353,10 @@ func (kc *kafkaConsumer) resolveSourceAndTag(m *sarama.ConsumerMessage) (tag ent
 	}
 	var tagHit bool
 
+	//a source field in the message takes precedence over the source header
+	ip = kc.srcField.Source(m.Value, nil)
 	for _, rh := range m.Headers {
-		if string(rh.Key) == kc.srcKey {
+		if string(rh.Key) == kc.srcKey && ip == nil {
 			ip = kc.extractSrc(rh.Value)
 		} else if string(rh.Key) == kc.tagKey {
 			if tag, tagHit, err = kc.resolveTag(string(rh.Value)); err != nil {
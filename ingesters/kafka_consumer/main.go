@@ -11,7 +11,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net"
 	"os"
 	"path"
 	"runtime/debug"
@@ -161,7 +160,7 @@ func main() {
 		CachePath:          cfg.Ingest_Cache_Path,
 		CacheSize:          cfg.Max_Ingest_Cache,
 		CacheMode:          cfg.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Log_Source_Override),
+		LogSourceOverride:  cfg.LogSourceOverride(),
 	}
 	igst, err := ingest.NewUniformMuxer(igCfg)
 	if err != nil {
@@ -209,6 +208,7 @@ func main() {
 		if kcfg.pproc, err = cfg.Preprocessor.ProcessorSet(igst, v.preprocessor); err != nil {
 			lg.Fatal("preprocessor construction error", log.KVErr(err))
 		}
+		kcfg.pproc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
 		procs = append(procs, kcfg.pproc)
 		kc, err := newKafkaConsumer(kcfg)
 		if err != nil {
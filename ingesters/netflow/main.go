@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
@@ -155,7 +156,7 @@ func main() {
 		CachePath:          cfg.Ingest_Cache_Path,
 		CacheSize:          cfg.Max_Ingest_Cache,
 		CacheMode:          cfg.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Log_Source_Override),
+		LogSourceOverride:  cfg.LogSourceOverride(),
 	}
 	igst, err := ingest.NewUniformMuxer(igCfg)
 	if err != nil {
@@ -193,9 +194,8 @@ func main() {
 	var src net.IP
 	if cfg.Source_Override != `` {
 		// global override
-		src = net.ParseIP(cfg.Source_Override)
-		if src == nil {
-			lg.FatalCode(0, "Global Source-Override is invalid")
+		if src, err = config.ParseSourceOverride(cfg.Source_Override); err != nil {
+			lg.FatalCode(0, "Global Source-Override is invalid", log.KVErr(err))
 		}
 	}
 
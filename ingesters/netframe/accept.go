@@ -0,0 +1,122 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	// MinAcceptBackoff is the first delay after a failed accept, it doubles with each consecutive failure
+	MinAcceptBackoff = 5 * time.Millisecond
+	// MaxAcceptBackoff caps the delay after temporary failures such as running out of file descriptors
+	MaxAcceptBackoff = time.Second
+	// MaxFatalAcceptBackoff caps the delay after failures we do not expect to clear up on their own
+	MaxFatalAcceptBackoff = 10 * time.Second
+)
+
+// AcceptErrorClass is how an accept loop should treat a failed accept
+type AcceptErrorClass int
+
+const (
+	// AcceptClosed means the listener was closed and the loop should exit
+	AcceptClosed AcceptErrorClass = iota
+	// AcceptTemporary errors are expected to clear up, such as file descriptor exhaustion
+	AcceptTemporary
+	// AcceptFatal errors are unexpected, the loop keeps retrying but reports the listener as failing
+	AcceptFatal
+)
+
+// AcceptOptions control an accept loop, the zero value is usable
+type AcceptOptions struct {
+	Name    string   // listener name used for status reporting
+	Monitor *Monitor // optional, accept failures are reported here
+	// OnError is called with each failed accept and the number of consecutive failures, it may be nil
+	OnError func(err error, class AcceptErrorClass, consecutive int)
+}
+
+// ClassifyAcceptError decides whether an accept error means the listener is closed,
+// a temporary resource problem, or something unexpected.
+func ClassifyAcceptError(err error) AcceptErrorClass {
+	if errors.Is(err, net.ErrClosed) {
+		return AcceptClosed
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED, syscall.EINTR, syscall.EAGAIN:
+			return AcceptTemporary
+		}
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && (ne.Timeout() || isTemporary(ne)) {
+		return AcceptTemporary
+	}
+	return AcceptFatal
+}
+
+// isTemporary checks the deprecated Temporary method, the net package still uses it to flag
+// the accept errors worth retrying
+func isTemporary(err error) bool {
+	te, ok := err.(interface{ Temporary() bool })
+	return ok && te.Temporary()
+}
+
+// Serve accepts connections and hands each to hnd in its own goroutine until the listener is
+// closed, which also closes the listener when Serve returns.  Failed accepts are retried with
+// a jittered exponential backoff rather than ending the loop, a listener which keeps failing is
+// reported as such through the Monitor.
+func Serve(l net.Listener, hnd func(net.Conn), opts AcceptOptions) {
+	defer l.Close()
+	defer opts.Monitor.remove(opts.Name)
+	opts.Monitor.add(opts.Name)
+	var failCount int
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			class := ClassifyAcceptError(err)
+			if class == AcceptClosed {
+				return
+			}
+			failCount++
+			opts.Monitor.failed(opts.Name, err, class)
+			if opts.OnError != nil {
+				opts.OnError(err, class, failCount)
+			}
+			time.Sleep(acceptBackoff(failCount, class))
+			continue
+		}
+		if failCount > 0 {
+			opts.Monitor.recovered(opts.Name)
+			failCount = 0
+		}
+		go hnd(conn)
+	}
+}
+
+// acceptBackoff returns the delay after the given number of consecutive failures,
+// the delay is randomized down by up to half so stuck listeners do not retry in lockstep
+func acceptBackoff(failures int, class AcceptErrorClass) time.Duration {
+	max := MaxAcceptBackoff
+	if class == AcceptFatal {
+		max = MaxFatalAcceptBackoff
+	}
+	d := MinAcceptBackoff
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,174 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan string, 1)
+	done := make(chan bool, 1)
+	go func() {
+		Serve(l, func(c net.Conn) {
+			defer c.Close()
+			b := make([]byte, 5)
+			if _, err := c.Read(b); err == nil {
+				got <- string(b)
+			}
+		}, AcceptOptions{})
+		done <- true
+	}()
+	c, err := net.Dial(`tcp`, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte(`hello`))
+	c.Close()
+	select {
+	case v := <-got:
+		if v != `hello` {
+			t.Fatalf("bad data %q", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection not handled")
+	}
+	l.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not exit on close")
+	}
+}
+
+type tempError struct{}
+
+func (tempError) Error() string   { return `temporary` }
+func (tempError) Timeout() bool   { return false }
+func (tempError) Temporary() bool { return true }
+
+func TestClassifyAcceptError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class AcceptErrorClass
+	}{
+		{net.ErrClosed, AcceptClosed},
+		{&net.OpError{Op: `accept`, Err: net.ErrClosed}, AcceptClosed},
+		{&net.OpError{Op: `accept`, Err: os.NewSyscallError(`accept4`, syscall.EMFILE)}, AcceptTemporary},
+		{syscall.ECONNABORTED, AcceptTemporary},
+		{tempError{}, AcceptTemporary},
+		{errors.New(`connection closed`), AcceptFatal},
+		{&net.OpError{Op: `accept`, Err: syscall.EINVAL}, AcceptFatal},
+	}
+	for _, tst := range tests {
+		if c := ClassifyAcceptError(tst.err); c != tst.class {
+			t.Fatalf("bad class for %v: %v != %v", tst.err, c, tst.class)
+		}
+	}
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	for i := 1; i < 50; i++ {
+		d := acceptBackoff(i, AcceptTemporary)
+		if d < MinAcceptBackoff/2 || d > MaxAcceptBackoff {
+			t.Fatalf("bad temporary backoff after %d failures: %v", i, d)
+		}
+		if d = acceptBackoff(i, AcceptFatal); d > MaxFatalAcceptBackoff {
+			t.Fatalf("bad fatal backoff after %d failures: %v", i, d)
+		}
+	}
+	if d := acceptBackoff(30, AcceptTemporary); d < MaxAcceptBackoff/2 {
+		t.Fatalf("backoff did not grow: %v", d)
+	}
+}
+
+// scriptListener returns the scripted errors, a single connection, and then reports closed
+type scriptListener struct {
+	net.Listener
+	errs   []error
+	conn   net.Conn
+	closed bool
+}
+
+func (sl *scriptListener) Accept() (c net.Conn, err error) {
+	if len(sl.errs) > 0 {
+		err, sl.errs = sl.errs[0], sl.errs[1:]
+	} else if sl.conn != nil {
+		c, sl.conn = sl.conn, nil
+	} else {
+		err = net.ErrClosed
+	}
+	return
+}
+
+func (sl *scriptListener) Close() error {
+	sl.closed = true
+	return nil
+}
+
+func TestServeFailures(t *testing.T) {
+	errFailed := errors.New("accept failed")
+	a, b := net.Pipe()
+	defer b.Close()
+	sl := &scriptListener{
+		errs: []error{errFailed, errFailed, errFailed, errFailed, errFailed},
+		conn: a,
+	}
+	var changes [][]AcceptStatus
+	mon := NewMonitor(func(st []AcceptStatus) {
+		changes = append(changes, st)
+	})
+	var errs []int
+	handled := make(chan bool, 1)
+	Serve(sl, func(c net.Conn) {
+		c.Close()
+		handled <- true
+	}, AcceptOptions{
+		Name:    `test`,
+		Monitor: mon,
+		OnError: func(err error, class AcceptErrorClass, consecutive int) {
+			if err != errFailed || class != AcceptFatal {
+				t.Errorf("bad error %v %v", err, class)
+			}
+			errs = append(errs, consecutive)
+		},
+	})
+	if !sl.closed {
+		t.Fatal("listener not closed")
+	} else if len(errs) != 5 || errs[4] != 5 {
+		t.Fatalf("bad error callbacks %v", errs)
+	}
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("connection after failures not handled")
+	}
+	// added, failing, recovered, removed
+	if len(changes) != 4 {
+		t.Fatalf("bad status changes %+v", changes)
+	}
+	if st := changes[1][0]; !st.Failing || !st.Fatal || st.LastError != errFailed.Error() {
+		t.Fatalf("bad failing status %+v", st)
+	}
+	if st := changes[2][0]; st.Failing || st.TotalFailures != 5 || st.ConsecutiveFailures != 0 {
+		t.Fatalf("bad recovered status %+v", st)
+	}
+	if len(changes[3]) != 0 || len(mon.Status()) != 0 {
+		t.Fatal("listener not removed from the monitor")
+	}
+}
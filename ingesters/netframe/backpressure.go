@@ -0,0 +1,255 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BackpressureNone BackpressureMode = iota
+	BackpressurePause
+	BackpressureReject
+	BackpressureBlock
+)
+
+const (
+	// DefaultHighWater is the queue fill percentage at which backpressure engages
+	DefaultHighWater = 90
+	// DefaultLowWater is the queue fill percentage at which backpressure releases
+	DefaultLowWater = 50
+
+	backpressurePoll = 100 * time.Millisecond
+)
+
+var (
+	ErrBadBackpressureMode = errors.New("backpressure mode must be none, pause, reject, or block")
+	ErrBadWaterMarks       = errors.New("backpressure low water mark must be below the high water mark, and both between 1 and 100")
+)
+
+// BackpressureMode is what a listener does while the ingest queues are saturated.
+//
+//	pause  - stop accepting connections, new clients wait in the kernel accept backlog
+//	reject - accept and immediately close new connections, HTTP listeners answer with an error status
+//	block  - stop reading from connections so TCP flow control pushes back on the senders
+type BackpressureMode int
+
+func ParseBackpressureMode(s string) (BackpressureMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case ``, `none`:
+		return BackpressureNone, nil
+	case `pause`:
+		return BackpressurePause, nil
+	case `reject`:
+		return BackpressureReject, nil
+	case `block`:
+		return BackpressureBlock, nil
+	}
+	return BackpressureNone, fmt.Errorf("%w: %q", ErrBadBackpressureMode, s)
+}
+
+func (m BackpressureMode) String() string {
+	switch m {
+	case BackpressureNone:
+		return `none`
+	case BackpressurePause:
+		return `pause`
+	case BackpressureReject:
+		return `reject`
+	case BackpressureBlock:
+		return `block`
+	}
+	return `unknown`
+}
+
+// Gauge watches an ingest queue and decides when listeners should push back.  Backpressure
+// engages when the queue fills past the high water mark and releases once it drains below the
+// low water mark, so listeners do not flap around a single threshold.  A nil *Gauge never engages.
+type Gauge struct {
+	depth    func() (queued, capacity int)
+	high     int
+	low      int
+	engaged  int32
+	rejected uint64
+	onChange func(engaged bool)
+	mtx      sync.Mutex // serializes state changes so the change handler sees them in order
+}
+
+// NewGauge builds a gauge over a queue depth function, IngestMuxer.QueueDepth fits.  Zero water
+// marks use the defaults.  onChange is called when backpressure engages or releases and may be nil.
+func NewGauge(depth func() (queued, capacity int), high, low int, onChange func(engaged bool)) (*Gauge, error) {
+	if high == 0 {
+		high = DefaultHighWater
+	}
+	if low == 0 {
+		low = DefaultLowWater
+	}
+	if err := CheckWaterMarks(high, low); err != nil {
+		return nil, err
+	}
+	return &Gauge{
+		depth:    depth,
+		high:     high,
+		low:      low,
+		onChange: onChange,
+	}, nil
+}
+
+// CheckWaterMarks validates high and low water mark percentages
+func CheckWaterMarks(high, low int) error {
+	if high < 1 || high > 100 || low < 1 || low >= high {
+		return ErrBadWaterMarks
+	}
+	return nil
+}
+
+// Engaged returns true while listeners should push back
+func (g *Gauge) Engaged() bool {
+	if g == nil {
+		return false
+	}
+	queued, capacity := g.depth()
+	if capacity <= 0 {
+		return false
+	}
+	pct := queued * 100 / capacity
+	was := atomic.LoadInt32(&g.engaged) == 1
+	if was && pct <= g.low {
+		g.set(false)
+		return false
+	} else if !was && pct >= g.high {
+		g.set(true)
+		return true
+	}
+	return was
+}
+
+func (g *Gauge) set(engaged bool) {
+	var v, old int32 = 0, 1
+	if engaged {
+		v, old = 1, 0
+	}
+	g.mtx.Lock()
+	if atomic.CompareAndSwapInt32(&g.engaged, old, v) && g.onChange != nil {
+		g.onChange(engaged)
+	}
+	g.mtx.Unlock()
+}
+
+// Wait blocks while backpressure is engaged, it returns false if done is closed first
+func (g *Gauge) Wait(done <-chan struct{}) bool {
+	if !g.Engaged() {
+		return true
+	}
+	tckr := time.NewTicker(backpressurePoll)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-done:
+			return false
+		case <-tckr.C:
+			if !g.Engaged() {
+				return true
+			}
+		}
+	}
+}
+
+// Rejected returns how many connections or requests have been turned away
+func (g *Gauge) Rejected() uint64 {
+	if g == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&g.rejected)
+}
+
+// Reject counts a connection or request turned away, it is used by listeners that reject
+// at the protocol level rather than through Listener.
+func (g *Gauge) Reject() {
+	if g != nil {
+		atomic.AddUint64(&g.rejected, 1)
+	}
+}
+
+// Listener applies a backpressure mode to a listener.  Wrap the raw listener, beneath any TLS
+// listener, so paused and rejected connections cost no handshake and blocked reads stall the
+// TCP stream itself.  Listeners with no gauge or mode are returned untouched.
+func (g *Gauge) Listener(l net.Listener, mode BackpressureMode) net.Listener {
+	if g == nil || mode == BackpressureNone {
+		return l
+	}
+	return &bpListener{
+		Listener: l,
+		g:        g,
+		mode:     mode,
+		done:     make(chan struct{}),
+	}
+}
+
+type bpListener struct {
+	net.Listener
+	g    *Gauge
+	mode BackpressureMode
+	once sync.Once
+	done chan struct{}
+}
+
+func (bl *bpListener) Accept() (net.Conn, error) {
+	for {
+		if bl.mode == BackpressurePause && !bl.g.Wait(bl.done) {
+			return nil, net.ErrClosed
+		}
+		c, err := bl.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		switch bl.mode {
+		case BackpressureReject:
+			if bl.g.Engaged() {
+				bl.g.Reject()
+				c.Close()
+				continue
+			}
+		case BackpressureBlock:
+			c = &bpConn{Conn: c, g: bl.g, done: make(chan struct{})}
+		}
+		return c, nil
+	}
+}
+
+func (bl *bpListener) Close() error {
+	bl.once.Do(func() { close(bl.done) })
+	return bl.Listener.Close()
+}
+
+// bpConn holds off reads while backpressure is engaged
+type bpConn struct {
+	net.Conn
+	g    *Gauge
+	once sync.Once
+	done chan struct{}
+}
+
+func (bc *bpConn) Read(b []byte) (int, error) {
+	if !bc.g.Wait(bc.done) {
+		return 0, net.ErrClosed
+	}
+	return bc.Conn.Read(b)
+}
+
+func (bc *bpConn) Close() error {
+	bc.once.Do(func() { close(bc.done) })
+	return bc.Conn.Close()
+}
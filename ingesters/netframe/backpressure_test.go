@@ -0,0 +1,247 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testQueue struct {
+	queued int64
+}
+
+func (tq *testQueue) set(v int64) {
+	atomic.StoreInt64(&tq.queued, v)
+}
+
+func (tq *testQueue) depth() (int, int) {
+	return int(atomic.LoadInt64(&tq.queued)), 100
+}
+
+func TestParseBackpressureMode(t *testing.T) {
+	for _, m := range []BackpressureMode{BackpressureNone, BackpressurePause, BackpressureReject, BackpressureBlock} {
+		if v, err := ParseBackpressureMode(m.String()); err != nil || v != m {
+			t.Fatalf("bad round trip of %v: %v %v", m, v, err)
+		}
+	}
+	if v, err := ParseBackpressureMode(``); err != nil || v != BackpressureNone {
+		t.Fatal("empty mode is not none")
+	}
+	if _, err := ParseBackpressureMode(`drop`); !errors.Is(err, ErrBadBackpressureMode) {
+		t.Fatalf("bad error on invalid mode: %v", err)
+	}
+}
+
+func TestGaugeHysteresis(t *testing.T) {
+	if _, err := NewGauge(nil, 50, 60, nil); err != ErrBadWaterMarks {
+		t.Fatalf("bad error on inverted water marks: %v", err)
+	} else if _, err = NewGauge(nil, 101, 0, nil); err != ErrBadWaterMarks {
+		t.Fatalf("bad error on high water mark: %v", err)
+	}
+	var tq testQueue
+	var changes []bool
+	g, err := NewGauge(tq.depth, 0, 0, func(engaged bool) {
+		changes = append(changes, engaged)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		queued  int64
+		engaged bool
+	}{
+		{10, false},
+		{89, false},
+		{90, true},
+		{70, true},
+		{51, true},
+		{50, false},
+		{80, false},
+		{100, true},
+		{0, false},
+	}
+	for _, s := range steps {
+		tq.set(s.queued)
+		if g.Engaged() != s.engaged {
+			t.Fatalf("bad state at %d", s.queued)
+		}
+	}
+	if len(changes) != 4 || !changes[0] || changes[1] || !changes[2] || changes[3] {
+		t.Fatalf("bad changes %v", changes)
+	}
+	var ng *Gauge
+	if ng.Engaged() || !ng.Wait(nil) {
+		t.Fatal("nil gauge engaged")
+	}
+}
+
+func TestGaugeWait(t *testing.T) {
+	var tq testQueue
+	g, err := NewGauge(tq.depth, 0, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tq.set(100)
+	done := make(chan struct{})
+	close(done)
+	if g.Wait(done) {
+		t.Fatal("wait ignored done")
+	}
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		tq.set(0)
+	}()
+	if !g.Wait(nil) {
+		t.Fatal("wait did not release")
+	}
+}
+
+func TestBackpressureReject(t *testing.T) {
+	var tq testQueue
+	g, _ := NewGauge(tq.depth, 0, 0, nil)
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := g.Listener(l, BackpressureReject)
+	defer bl.Close()
+	tq.set(100)
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := bl.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+	c, err := net.Dial(`tcp`, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("rejected connection was not closed: %v", err)
+	}
+	c.Close()
+	if g.Rejected() != 1 {
+		t.Fatalf("bad reject count %d", g.Rejected())
+	}
+	tq.set(0)
+	if c, err = net.Dial(`tcp`, l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	select {
+	case ac := <-accepted:
+		ac.Close()
+	case <-time.After(time.Second):
+		t.Fatal("connection not accepted after release")
+	}
+}
+
+func TestBackpressurePause(t *testing.T) {
+	var tq testQueue
+	g, _ := NewGauge(tq.depth, 0, 0, nil)
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := g.Listener(l, BackpressurePause)
+	tq.set(100)
+	accepted := make(chan error, 1)
+	go func() {
+		c, err := bl.Accept()
+		if err == nil {
+			c.Close()
+		}
+		accepted <- err
+	}()
+	c, err := net.Dial(`tcp`, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	select {
+	case <-accepted:
+		t.Fatal("accepted while paused")
+	case <-time.After(250 * time.Millisecond):
+	}
+	tq.set(0)
+	select {
+	case err = <-accepted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not accepted after release")
+	}
+
+	// a paused accept returns closed when the listener is closed
+	tq.set(100)
+	go func() {
+		_, err := bl.Accept()
+		accepted <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	bl.Close()
+	select {
+	case err = <-accepted:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("bad error from closed paused listener: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("paused accept did not exit on close")
+	}
+}
+
+func TestBackpressureBlock(t *testing.T) {
+	var tq testQueue
+	g, _ := NewGauge(tq.depth, 0, 0, nil)
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl := g.Listener(l, BackpressureBlock)
+	defer bl.Close()
+	go func() {
+		if c, err := net.Dial(`tcp`, l.Addr().String()); err == nil {
+			c.Write([]byte(`x`))
+			defer c.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+	c, err := bl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tq.set(100)
+	got := make(chan error, 1)
+	go func() {
+		_, err := c.Read(make([]byte, 1))
+		got <- err
+	}()
+	select {
+	case <-got:
+		t.Fatal("read while blocked")
+	case <-time.After(250 * time.Millisecond):
+	}
+	tq.set(0)
+	select {
+	case err = <-got:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read not released")
+	}
+	c.Close()
+}
@@ -0,0 +1,149 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package netframe holds the listener plumbing shared by the network ingesters: bind string
+// parsing, TLS setup, accept loops, and tracking of open listeners and connections.
+package netframe
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+)
+
+const (
+	TCP BindType = iota
+	UDP
+	TCP6
+	UDP6
+	TLS
+	Unix
+	Unixgram
+	FIFO
+)
+
+var (
+	ErrInvalidBindType = errors.New("invalid bind protocol specifier")
+)
+
+// BindType is the protocol portion of a bind string such as tcp://0.0.0.0:601
+type BindType int
+
+// ParseBind splits a bind string into its type and address, a bind string without
+// a protocol specifier is treated as TCP.
+func ParseBind(bstr string) (BindType, string, error) {
+	bits := strings.SplitN(bstr, "://", 2)
+	//if nothing specified, just return the tcp type
+	if len(bits) != 2 {
+		return TCP, bstr, nil
+	}
+	id := strings.ToLower(bits[0])
+	switch id {
+	case "tcp":
+		return TCP, bits[1], nil
+	case "udp":
+		return UDP, bits[1], nil
+	case "tcp6":
+		return TCP6, bits[1], nil
+	case "udp6":
+		return UDP6, bits[1], nil
+	case "tls":
+		return TLS, bits[1], nil
+	case "unix":
+		return Unix, bits[1], nil
+	case "unixgram":
+		return Unixgram, bits[1], nil
+	case "fifo":
+		return FIFO, bits[1], nil
+	}
+	return -1, "", fmt.Errorf("%w of %s", ErrInvalidBindType, id)
+}
+
+// CheckAddr validates the address portion of a network bind string.  IPv6 addresses must be
+// bracketed and may carry a zone, e.g. tcp://[fe80::1%eth0]:601, local bind types are not checked.
+func (bt BindType) CheckAddr(addr string) error {
+	if bt.Local() {
+		return nil
+	}
+	host, _, err := config.SplitHostPortZone(addr)
+	if err != nil {
+		return err
+	}
+	if (bt == TCP6 || bt == UDP6) && host != `` {
+		if ip, _, err := config.ParseIPZone(host); err == nil && ip.To4() != nil {
+			return fmt.Errorf("%q: %w", addr, config.ErrIPv4OnIPv6Network)
+		}
+	}
+	return nil
+}
+
+func (bt BindType) TCP() bool {
+	return bt == TCP || bt == TCP6
+}
+
+func (bt BindType) UDP() bool {
+	return bt == UDP || bt == UDP6
+}
+
+func (bt BindType) TLS() bool {
+	return bt == TLS
+}
+
+func (bt BindType) Unix() bool {
+	return bt == Unix
+}
+
+func (bt BindType) Unixgram() bool {
+	return bt == Unixgram
+}
+
+func (bt BindType) FIFO() bool {
+	return bt == FIFO
+}
+
+// Local returns true for bind types which live on the filesystem rather than the network
+func (bt BindType) Local() bool {
+	return bt == Unix || bt == Unixgram || bt == FIFO
+}
+
+// Stream returns true for bind types which produce connections rather than datagrams
+func (bt BindType) Stream() bool {
+	return bt.TCP() || bt == TLS || bt == Unix || bt == FIFO
+}
+
+// Network returns the network name to hand to the net package, TLS listens on tcp
+func (bt BindType) Network() string {
+	if bt == TLS {
+		return "tcp"
+	}
+	return bt.String()
+}
+
+func (bt BindType) String() string {
+	switch bt {
+	case TCP:
+		return "tcp"
+	case TCP6:
+		return "tcp6"
+	case UDP:
+		return "udp"
+	case UDP6:
+		return "udp6"
+	case TLS:
+		return "tls"
+	case Unix:
+		return "unix"
+	case Unixgram:
+		return "unixgram"
+	case FIFO:
+		return "fifo"
+	}
+	return "unknown"
+}
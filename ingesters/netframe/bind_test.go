@@ -0,0 +1,74 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+)
+
+func TestParseBind(t *testing.T) {
+	tests := []struct {
+		bind   string
+		tp     BindType
+		addr   string
+		stream bool
+	}{
+		{`0.0.0.0:601`, TCP, `0.0.0.0:601`, true},
+		{`tcp://0.0.0.0:601`, TCP, `0.0.0.0:601`, true},
+		{`TCP6://[::1]:601`, TCP6, `[::1]:601`, true},
+		{`udp://127.0.0.1:514`, UDP, `127.0.0.1:514`, false},
+		{`udp6://[::1]:514`, UDP6, `[::1]:514`, false},
+		{`tls://0.0.0.0:6514`, TLS, `0.0.0.0:6514`, true},
+		{`unix:///run/gravwell/relay.sock`, Unix, `/run/gravwell/relay.sock`, true},
+		{`unixgram:///dev/log`, Unixgram, `/dev/log`, false},
+		{`fifo:///var/spool/relay.fifo`, FIFO, `/var/spool/relay.fifo`, true},
+	}
+	for _, tst := range tests {
+		tp, addr, err := ParseBind(tst.bind)
+		if err != nil {
+			t.Fatal(err)
+		} else if tp != tst.tp || addr != tst.addr {
+			t.Fatalf("bad translation of %s: %v %s", tst.bind, tp, addr)
+		} else if tp.Stream() != tst.stream {
+			t.Fatalf("bad stream flag for %s", tst.bind)
+		}
+	}
+	if _, _, err := ParseBind(`sctp://0.0.0.0:601`); !errors.Is(err, ErrInvalidBindType) {
+		t.Fatalf("bad error on invalid bind type: %v", err)
+	}
+
+	for _, v := range []string{`0.0.0.0:601`, `:601`, `[::]:601`, `[fe80::1%eth0]:601`, `localhost:514`} {
+		if err := TCP.CheckAddr(v); err != nil {
+			t.Fatalf("%s: %v", v, err)
+		}
+	}
+	for v, exp := range map[string]error{
+		`fe80::1%eth0:601`:  config.ErrUnbracketedIPv6,
+		`0.0.0.0`:           config.ErrMissingPort,
+		`[10.0.0.1%eth0]:1`: config.ErrZoneRequiresIPv6,
+	} {
+		if err := UDP.CheckAddr(v); !errors.Is(err, exp) {
+			t.Fatalf("bad error for %s: %v", v, err)
+		}
+	}
+	if err := TCP6.CheckAddr(`10.0.0.1:601`); !errors.Is(err, config.ErrIPv4OnIPv6Network) {
+		t.Fatalf("IPv4 address on tcp6 not caught: %v", err)
+	} else if err = Unix.CheckAddr(`/run/relay.sock`); err != nil {
+		t.Fatal(err)
+	}
+	if TLS.Network() != `tcp` || UDP6.Network() != `udp6` {
+		t.Fatal("bad network names")
+	}
+	if TCP.Local() || !FIFO.Local() || !Unixgram.Local() {
+		t.Fatal("bad local flags")
+	}
+}
@@ -0,0 +1,240 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+)
+
+const (
+	// certificate rotation usually writes the certificate and key separately, so we wait
+	// for changes to settle before reloading
+	certReloadDelay = 250 * time.Millisecond
+)
+
+var (
+	ErrNoClientCAs         = errors.New("client CA bundle does not contain any certificates")
+	ErrNoClientCertificate = errors.New("client did not provide a certificate")
+)
+
+// CertReloader serves a certificate and key to TLS listeners and optionally requires clients
+// to present a certificate signed by a CA in a bundle.  The files are reloaded when they change
+// on disk or when Reload is called, so short lived certificates can be rotated without a restart
+// and without dropping established connections.  If a reload fails the previous certificates
+// remain in use.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mtx  sync.RWMutex
+	cert *tls.Certificate
+	cas  *x509.CertPool
+	errf func(error)
+	w    *fsnotify.Watcher
+	done chan struct{}
+}
+
+// NewCertReloader loads a certificate and key, caFile is an optional bundle of CAs that
+// client certificates must be signed by.  Call Watch to reload the files when they change.
+func NewCertReloader(certFile, keyFile, caFile string) (*CertReloader, error) {
+	if certFile == `` {
+		return nil, ErrMissingCertFile
+	} else if keyFile == `` {
+		return nil, ErrMissingKeyFile
+	}
+	cr := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// SetReloadErrorHandler sets a function which is told about failed reloads while watching
+func (cr *CertReloader) SetReloadErrorHandler(fn func(error)) {
+	cr.mtx.Lock()
+	cr.errf = fn
+	cr.mtx.Unlock()
+}
+
+// Reload re-reads the certificate, key, and client CA bundle.  New handshakes use the new
+// certificates, connections that are already established are not affected.
+func (cr *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	} else if config.FIPSEnabled() {
+		if err = config.CheckFIPSCertificate(cert); err != nil {
+			return err
+		}
+	}
+	var cas *x509.CertPool
+	if cr.caFile != `` {
+		if cas, err = loadCertPool(cr.caFile); err != nil {
+			return err
+		}
+	}
+	cr.mtx.Lock()
+	cr.cert, cr.cas = &cert, cas
+	cr.mtx.Unlock()
+	return nil
+}
+
+func loadCertPool(pth string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+	p := x509.NewCertPool()
+	if !p.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%w: %s", ErrNoClientCAs, pth)
+	}
+	return p, nil
+}
+
+// Config returns a server TLS config which always uses the most recently loaded certificates
+func (cr *CertReloader) Config() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.getCertificate,
+	}
+	if cr.caFile != `` {
+		// the stock client verification is tied to a fixed pool, so we verify against the current bundle
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = cr.verifyClient
+	}
+	config.FIPSTLSConfig(cfg) //certificates are checked as they are loaded
+	return cfg
+}
+
+// Listener wraps a listener so accepted connections are served over TLS using the current certificates
+func (cr *CertReloader) Listener(l net.Listener) net.Listener {
+	return tls.NewListener(l, cr.Config())
+}
+
+func (cr *CertReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mtx.RLock()
+	defer cr.mtx.RUnlock()
+	return cr.cert, nil
+}
+
+func (cr *CertReloader) verifyClient(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return ErrNoClientCertificate
+	}
+	cr.mtx.RLock()
+	cas := cr.cas
+	cr.mtx.RUnlock()
+	opts := x509.VerifyOptions{
+		Roots:         cas,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// Watch starts reloading the certificates whenever their files change.  The directories holding
+// the files are watched rather than the files themselves because rotation tools typically replace
+// files by renaming or swapping symlinks, which would silently drop a watch on the file.
+func (cr *CertReloader) Watch() error {
+	cr.mtx.Lock()
+	defer cr.mtx.Unlock()
+	if cr.w != nil {
+		return nil
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := map[string]bool{}
+	for _, f := range []string{cr.certFile, cr.keyFile, cr.caFile} {
+		if f == `` {
+			continue
+		}
+		if d := filepath.Dir(f); !dirs[d] {
+			if err = w.Add(d); err != nil {
+				w.Close()
+				return err
+			}
+			dirs[d] = true
+		}
+	}
+	cr.w = w
+	cr.done = make(chan struct{})
+	go cr.watchRoutine(w, cr.done)
+	return nil
+}
+
+func (cr *CertReloader) watchRoutine(w *fsnotify.Watcher, done chan struct{}) {
+	defer close(done)
+	tmr := time.NewTimer(certReloadDelay)
+	defer tmr.Stop()
+	if !tmr.Stop() {
+		<-tmr.C
+	}
+	for {
+		select {
+		case _, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			tmr.Reset(certReloadDelay)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			cr.reportError(err)
+		case <-tmr.C:
+			if err := cr.Reload(); err != nil {
+				cr.reportError(err)
+			}
+		}
+	}
+}
+
+func (cr *CertReloader) reportError(err error) {
+	cr.mtx.RLock()
+	errf := cr.errf
+	cr.mtx.RUnlock()
+	if errf != nil {
+		errf(err)
+	}
+}
+
+// Close stops watching the certificate files, listeners using the certificates keep working
+func (cr *CertReloader) Close() (err error) {
+	cr.mtx.Lock()
+	w, done := cr.w, cr.done
+	cr.w, cr.done = nil, nil
+	cr.mtx.Unlock()
+	if w != nil {
+		err = w.Close()
+		<-done
+	}
+	return
+}
@@ -0,0 +1,204 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pair tls.Certificate
+}
+
+// newTestCert creates a certificate signed by parent, a nil parent makes a self signed CA
+func newTestCert(t *testing.T, cn string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := &testCert{key: key}
+	if tc.cert, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	tc.pair = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: tc.cert}
+	return tc
+}
+
+func (tc *testCert) write(t *testing.T, certFile, keyFile string) {
+	kb, err := x509.MarshalECPrivateKey(tc.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: tc.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	} else if keyFile == `` {
+		return
+	} else if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: `EC PRIVATE KEY`, Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS accepts and immediately closes connections so clients can complete a handshake
+func serveTLS(t *testing.T, cr *CertReloader) string {
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl := cr.Listener(l)
+	t.Cleanup(func() { tl.Close() })
+	go Serve(tl, func(c net.Conn) {
+		c.(*tls.Conn).Handshake()
+		c.Close()
+	}, AcceptOptions{})
+	return l.Addr().String()
+}
+
+func handshake(addr string, cfg *tls.Config) (*x509.Certificate, error) {
+	c, err := tls.Dial(`tcp`, addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	// TLS 1.3 reports client certificate failures on the first read
+	if _, err = c.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return c.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	cf, kf := filepath.Join(dir, `cert.pem`), filepath.Join(dir, `key.pem`)
+	first := newTestCert(t, `first`, nil)
+	first.write(t, cf, kf)
+	if _, err := NewCertReloader(cf, ``, ``); err != ErrMissingKeyFile {
+		t.Fatalf("bad error on missing key: %v", err)
+	}
+	cr, err := NewCertReloader(cf, kf, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloadErrs := make(chan error, 16)
+	cr.SetReloadErrorHandler(func(err error) {
+		select {
+		case reloadErrs <- err:
+		default:
+		}
+	})
+	if err = cr.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Close()
+	addr := serveTLS(t, cr)
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	if c, err := handshake(addr, cfg); err != nil {
+		t.Fatal(err)
+	} else if c.Subject.CommonName != `first` {
+		t.Fatalf("bad certificate %s", c.Subject.CommonName)
+	}
+
+	newTestCert(t, `second`, nil).write(t, cf, kf)
+	var cn string
+	for i := 0; i < 100 && cn != `second`; i++ {
+		time.Sleep(50 * time.Millisecond)
+		c, err := handshake(addr, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cn = c.Subject.CommonName
+	}
+	if cn != `second` {
+		t.Fatal("rotated certificate was not picked up")
+	}
+
+	//a broken certificate is reported and the previous one stays in use
+	if err = ioutil.WriteFile(cf, []byte(`garbage`), 0600); err != nil {
+		t.Fatal(err)
+	} else if err = cr.Reload(); err == nil {
+		t.Fatal("broken certificate loaded")
+	} else if c, err := handshake(addr, cfg); err != nil || c.Subject.CommonName != `second` {
+		t.Fatalf("lost the previous certificate: %v", err)
+	}
+	select {
+	case <-reloadErrs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("reload error not reported")
+	}
+}
+
+func TestCertReloaderClientCA(t *testing.T) {
+	dir := t.TempDir()
+	cf, kf, caf := filepath.Join(dir, `cert.pem`), filepath.Join(dir, `key.pem`), filepath.Join(dir, `ca.pem`)
+	newTestCert(t, `server`, nil).write(t, cf, kf)
+	ca := newTestCert(t, `ca`, nil)
+	ca.write(t, caf, ``)
+	client := newTestCert(t, `client`, ca)
+
+	cr, err := NewCertReloader(cf, kf, caf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTLS(t, cr)
+	if _, err = handshake(addr, &tls.Config{InsecureSkipVerify: true}); err == nil {
+		t.Fatal("client without a certificate accepted")
+	}
+	withCert := &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{client.pair}}
+	if _, err = handshake(addr, withCert); err != nil {
+		t.Fatal(err)
+	}
+
+	//swapping the bundle for another CA revokes the client
+	newTestCert(t, `other`, nil).write(t, caf, ``)
+	if err = cr.Reload(); err != nil {
+		t.Fatal(err)
+	} else if _, err = handshake(addr, withCert); err == nil {
+		t.Fatal("client accepted after its CA was removed")
+	}
+
+	if err = ioutil.WriteFile(caf, []byte(`nothing here`), 0600); err != nil {
+		t.Fatal(err)
+	} else if _, err = NewCertReloader(cf, kf, caf); err == nil {
+		t.Fatal("empty CA bundle accepted")
+	}
+}
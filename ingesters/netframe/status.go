@@ -0,0 +1,146 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// AcceptStatus is the accept health of a single listener
+type AcceptStatus struct {
+	Listener            string
+	Failing             bool
+	Fatal               bool   `json:",omitempty"` // the current failures are not a known temporary condition
+	ConsecutiveFailures uint64 `json:",omitempty"`
+	TotalFailures       uint64 `json:",omitempty"`
+	LastError           string `json:",omitempty"`
+	LastErrorTime       time.Time
+}
+
+// Monitor tracks the accept health of a set of listeners.  The change handler is called whenever
+// a listener starts or stops failing so the status can be published, typically through the
+// ingest muxer listener status.  A nil *Monitor ignores everything.
+type Monitor struct {
+	mtx      sync.Mutex
+	status   map[string]*AcceptStatus
+	onChange func([]AcceptStatus)
+}
+
+func NewMonitor(onChange func([]AcceptStatus)) *Monitor {
+	return &Monitor{
+		status:   map[string]*AcceptStatus{},
+		onChange: onChange,
+	}
+}
+
+// Status returns the state of every running listener sorted by name
+func (m *Monitor) Status() (r []AcceptStatus) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	r = m.statusNoLock()
+	m.mtx.Unlock()
+	return
+}
+
+// Failing returns the number of listeners which are currently failing to accept
+func (m *Monitor) Failing() (n int) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	for _, v := range m.status {
+		if v.Failing {
+			n++
+		}
+	}
+	m.mtx.Unlock()
+	return
+}
+
+func (m *Monitor) statusNoLock() []AcceptStatus {
+	r := make([]AcceptStatus, 0, len(m.status))
+	for _, v := range m.status {
+		r = append(r, *v)
+	}
+	sort.Slice(r, func(i, j int) bool { return r[i].Listener < r[j].Listener })
+	return r
+}
+
+// changedNoLock calls the change handler, the lock must be held.  Handlers are called under the
+// lock so updates are delivered in order.
+func (m *Monitor) changedNoLock() {
+	if m.onChange != nil {
+		m.onChange(m.statusNoLock())
+	}
+}
+
+func (m *Monitor) add(name string) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	if _, ok := m.status[name]; !ok {
+		m.status[name] = &AcceptStatus{Listener: name}
+		m.changedNoLock()
+	}
+	m.mtx.Unlock()
+}
+
+func (m *Monitor) remove(name string) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	if _, ok := m.status[name]; ok {
+		delete(m.status, name)
+		m.changedNoLock()
+	}
+	m.mtx.Unlock()
+}
+
+func (m *Monitor) failed(name string, err error, class AcceptErrorClass) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	s, ok := m.status[name]
+	if !ok {
+		s = &AcceptStatus{Listener: name}
+		m.status[name] = s
+	}
+	wasFailing := s.Failing
+	s.Failing = true
+	s.Fatal = class == AcceptFatal
+	s.ConsecutiveFailures++
+	s.TotalFailures++
+	s.LastError = err.Error()
+	s.LastErrorTime = time.Now()
+	if !wasFailing {
+		m.changedNoLock()
+	}
+	m.mtx.Unlock()
+}
+
+func (m *Monitor) recovered(name string) {
+	if m == nil {
+		return
+	}
+	m.mtx.Lock()
+	if s, ok := m.status[name]; ok && s.Failing {
+		s.Failing = false
+		s.Fatal = false
+		s.ConsecutiveFailures = 0
+		m.changedNoLock()
+	}
+	m.mtx.Unlock()
+}
@@ -0,0 +1,58 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMonitor(t *testing.T) {
+	var calls int
+	m := NewMonitor(func([]AcceptStatus) { calls++ })
+	m.add(`b`)
+	m.add(`a`)
+	m.add(`a`)
+	if calls != 2 {
+		t.Fatalf("bad change count %d", calls)
+	}
+	m.failed(`a`, errors.New(`nope`), AcceptTemporary)
+	m.failed(`a`, errors.New(`still nope`), AcceptTemporary)
+	if calls != 3 {
+		t.Fatalf("repeated failures reported as changes: %d", calls)
+	} else if m.Failing() != 1 {
+		t.Fatalf("bad failing count %d", m.Failing())
+	}
+	st := m.Status()
+	if len(st) != 2 || st[0].Listener != `a` || st[1].Listener != `b` {
+		t.Fatalf("bad status order %+v", st)
+	} else if !st[0].Failing || st[0].Fatal || st[0].ConsecutiveFailures != 2 || st[0].LastError != `still nope` {
+		t.Fatalf("bad failing status %+v", st[0])
+	}
+	m.recovered(`a`)
+	m.recovered(`b`)
+	if calls != 4 || m.Failing() != 0 {
+		t.Fatalf("bad recovery, %d changes %d failing", calls, m.Failing())
+	}
+	m.remove(`a`)
+	m.remove(`a`)
+	if calls != 5 || len(m.Status()) != 1 {
+		t.Fatalf("bad removal, %d changes", calls)
+	}
+
+	// nil monitors ignore everything
+	var nm *Monitor
+	nm.add(`a`)
+	nm.failed(`a`, errors.New(`nope`), AcceptFatal)
+	nm.recovered(`a`)
+	nm.remove(`a`)
+	if nm.Status() != nil || nm.Failing() != 0 {
+		t.Fatal("nil monitor returned status")
+	}
+}
@@ -0,0 +1,54 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+)
+
+var (
+	ErrMissingCertFile = errors.New("TLS listener requires a certificate file")
+	ErrMissingKeyFile  = errors.New("TLS listener requires a key file")
+)
+
+// ServerTLSConfig loads a certificate and key into a TLS server config with our minimum version,
+// the config is restricted to approved algorithms in FIPS mode
+func ServerTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == `` {
+		return nil, ErrMissingCertFile
+	} else if keyFile == `` {
+		return nil, ErrMissingKeyFile
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if err = config.FIPSTLSConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// TLSListener wraps a listener so accepted connections are served over TLS.  Wrap any
+// address filtering listener before calling this so denied peers never get a handshake.
+func TLSListener(l net.Listener, certFile, keyFile string) (net.Listener, error) {
+	cfg, err := ServerTLSConfig(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(l, cfg), nil
+}
@@ -0,0 +1,106 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: `netframe`},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, `cert.pem`)
+	keyFile = filepath.Join(dir, `key.pem`)
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	} else if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: `EC PRIVATE KEY`, Bytes: kb}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	cf, kf := writeTestCert(t)
+	cfg, err := ServerTLSConfig(cf, kf)
+	if err != nil {
+		t.Fatal(err)
+	} else if cfg.MinVersion != tls.VersionTLS12 || len(cfg.Certificates) != 1 {
+		t.Fatalf("bad config %+v", cfg)
+	}
+	if _, err = ServerTLSConfig(``, kf); err != ErrMissingCertFile {
+		t.Fatalf("bad error on missing cert: %v", err)
+	} else if _, err = ServerTLSConfig(cf, ``); err != ErrMissingKeyFile {
+		t.Fatalf("bad error on missing key: %v", err)
+	} else if _, err = ServerTLSConfig(kf, cf); err == nil {
+		t.Fatal("accepted swapped cert and key")
+	}
+}
+
+func TestTLSListener(t *testing.T) {
+	cf, kf := writeTestCert(t)
+	l, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl, err := TLSListener(l, cf, kf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan string, 1)
+	go Serve(tl, func(c net.Conn) {
+		defer c.Close()
+		b, _ := ioutil.ReadAll(c)
+		got <- string(b)
+	}, AcceptOptions{})
+	defer tl.Close()
+	c, err := tls.Dial(`tcp`, l.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte(`hello`))
+	c.Close()
+	select {
+	case v := <-got:
+		if v != `hello` {
+			t.Fatalf("bad data %q", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not handled")
+	}
+}
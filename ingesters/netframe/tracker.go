@@ -0,0 +1,65 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"io"
+	"sync"
+)
+
+// Tracker keeps the open listeners and connections of an ingester so they can be counted
+// while draining and closed on shutdown.
+type Tracker struct {
+	mtx    sync.Mutex
+	id     int
+	closer map[int]io.Closer
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		closer: map[int]io.Closer{},
+	}
+}
+
+// Add registers a closer and returns the id to remove it with
+func (t *Tracker) Add(c io.Closer) int {
+	t.mtx.Lock()
+	t.id++
+	id := t.id
+	t.closer[id] = c
+	t.mtx.Unlock()
+	return id
+}
+
+func (t *Tracker) Del(id int) {
+	t.mtx.Lock()
+	delete(t.closer, id)
+	t.mtx.Unlock()
+}
+
+func (t *Tracker) Count() (n int) {
+	t.mtx.Lock()
+	n = len(t.closer)
+	t.mtx.Unlock()
+	return
+}
+
+// CloseAll closes everything currently tracked.  Closed items are not removed, their
+// owners remove them as they exit so Count reflects what is still shutting down.
+func (t *Tracker) CloseAll() {
+	t.mtx.Lock()
+	cs := make([]io.Closer, 0, len(t.closer))
+	for _, c := range t.closer {
+		cs = append(cs, c)
+	}
+	t.mtx.Unlock()
+	for _, c := range cs {
+		c.Close()
+	}
+}
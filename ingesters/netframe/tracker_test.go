@@ -0,0 +1,49 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package netframe
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+type testCloser struct {
+	closed *int32
+}
+
+func (tc testCloser) Close() error {
+	atomic.AddInt32(tc.closed, 1)
+	return nil
+}
+
+func TestTracker(t *testing.T) {
+	var closed int32
+	tr := NewTracker()
+	a := tr.Add(testCloser{&closed})
+	b := tr.Add(testCloser{&closed})
+	if a == b {
+		t.Fatal("duplicate ids")
+	} else if tr.Count() != 2 {
+		t.Fatalf("bad count %d", tr.Count())
+	}
+	tr.Del(a)
+	if tr.Count() != 1 {
+		t.Fatalf("bad count after delete %d", tr.Count())
+	}
+	tr.CloseAll()
+	if atomic.LoadInt32(&closed) != 1 {
+		t.Fatalf("closed %d items", closed)
+	} else if tr.Count() != 1 {
+		t.Fatal("CloseAll removed items")
+	}
+	tr.Del(b)
+	if tr.Count() != 0 {
+		t.Fatalf("bad final count %d", tr.Count())
+	}
+}
@@ -0,0 +1,65 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+const (
+	backendPcap     string = `pcap`
+	backendAFPacket string = `afpacket`
+
+	defaultRingSize int = 64 //MB of ring buffer per AF_PACKET socket
+)
+
+var (
+	ErrUnknownBackend      = errors.New("unknown Capture-Backend, must be pcap or afpacket")
+	ErrAFPacketUnsupported = errors.New("the afpacket capture backend is only available on Linux")
+	ErrFanoutRequiresRing  = errors.New("Fanout-Workers requires Capture-Backend=afpacket")
+)
+
+// packetSource is a capture handle, satisfied by both libpcap handles and AF_PACKET rings
+type packetSource interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+	Close()
+}
+
+// openSource opens the capture handle for a sniffer using its configured backend and applies the BPF filter
+func openSource(s *sniffer) (packetSource, error) {
+	switch s.backend {
+	case backendAFPacket:
+		return openAFPacket(s)
+	case backendPcap, ``:
+	default:
+		return nil, ErrUnknownBackend
+	}
+	hnd, err := pcap.OpenLive(s.Interface, int32(s.SnapLen), s.Promisc, pktTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if s.BPFFilter != `` {
+		if err = hnd.SetBPFFilter(s.BPFFilter); err != nil {
+			hnd.Close()
+			return nil, fmt.Errorf("invalid BPF filter: %w", err)
+		}
+	}
+	return hnd, nil
+}
+
+// isReadTimeout returns true when a read returned nothing because the poll timeout expired
+func isReadTimeout(err error) bool {
+	return err == pcap.NextErrorTimeoutExpired || isAFPacketTimeout(err)
+}
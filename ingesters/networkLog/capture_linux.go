@@ -0,0 +1,118 @@
+//go:build linux
+// +build linux
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket/afpacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// ringSource is a TPACKETv3 memory mapped ring, optionally a member of a fanout group
+type ringSource struct {
+	*afpacket.TPacket
+	promisc int //socket holding the interface in promiscuous mode, -1 if unused
+}
+
+func (r ringSource) Close() {
+	r.TPacket.Close()
+	if r.promisc >= 0 {
+		unix.Close(r.promisc)
+	}
+}
+
+// AF_PACKET sockets always deliver ethernet framing
+func (r ringSource) LinkType() layers.LinkType {
+	return layers.LinkTypeEthernet
+}
+
+func openAFPacket(s *sniffer) (packetSource, error) {
+	ringSize := s.ringSize
+	if ringSize <= 0 {
+		ringSize = defaultRingSize
+	}
+	blocks := (ringSize * 1024 * 1024) / afpacket.DefaultBlockSize
+	if blocks < 1 {
+		blocks = 1
+	}
+	tp, err := afpacket.NewTPacket(
+		afpacket.OptInterface(s.Interface),
+		afpacket.TPacketVersion3,
+		afpacket.OptFrameSize(afpacket.DefaultFrameSize),
+		afpacket.OptBlockSize(afpacket.DefaultBlockSize),
+		afpacket.OptNumBlocks(blocks),
+		afpacket.OptPollTimeout(pktTimeout),
+		afpacket.SocketRaw,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if s.BPFFilter != `` {
+		var insts []pcap.BPFInstruction
+		if insts, err = pcap.CompileBPFFilter(layers.LinkTypeEthernet, s.SnapLen, s.BPFFilter); err != nil {
+			tp.Close()
+			return nil, fmt.Errorf("invalid BPF filter: %w", err)
+		}
+		raw := make([]bpf.RawInstruction, 0, len(insts))
+		for _, i := range insts {
+			raw = append(raw, bpf.RawInstruction{Op: i.Code, Jt: i.Jt, Jf: i.Jf, K: i.K})
+		}
+		if err = tp.SetBPF(raw); err != nil {
+			tp.Close()
+			return nil, fmt.Errorf("failed to attach BPF filter: %w", err)
+		}
+	}
+	if s.workers > 1 {
+		if err = tp.SetFanout(afpacket.FanoutHashWithDefrag, s.fanoutId); err != nil {
+			tp.Close()
+			return nil, fmt.Errorf("failed to join fanout group %d: %w", s.fanoutId, err)
+		}
+	}
+	rs := ringSource{TPacket: tp, promisc: -1}
+	if s.Promisc {
+		if rs.promisc, err = setPromisc(s.Interface); err != nil {
+			tp.Close()
+			return nil, fmt.Errorf("failed to enable promiscuous mode: %w", err)
+		}
+	}
+	return rs, nil
+}
+
+// setPromisc puts the interface into promiscuous mode for as long as the returned socket is open,
+// AF_PACKET rings do not do this on their own
+func setPromisc(iface string) (fd int, err error) {
+	var ifc *net.Interface
+	if ifc, err = net.InterfaceByName(iface); err != nil {
+		return -1, err
+	}
+	if fd, err = unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, 0); err != nil {
+		return -1, err
+	}
+	mreq := unix.PacketMreq{
+		Ifindex: int32(ifc.Index),
+		Type:    unix.PACKET_MR_PROMISC,
+	}
+	if err = unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return
+}
+
+func isAFPacketTimeout(err error) bool {
+	return err == afpacket.ErrTimeout
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+func openAFPacket(s *sniffer) (packetSource, error) {
+	return nil, ErrAFPacketUnsupported
+}
+
+func isAFPacketTimeout(err error) bool {
+	return false
+}
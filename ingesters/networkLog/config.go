@@ -10,9 +10,12 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"runtime"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gravwell/gravwell/v3/ingest"
@@ -25,6 +28,7 @@ const (
 	maxSnapLen       int    = 0xffff
 	defaultSnapLen   int    = 96
 	defaultBpfFilter string = `not tcp port 4023 and not tcp port 4024`
+	maxFanoutWorkers int    = 256
 
 	envInterface string = `GRAVWELL_SNIFF_INTERFACE`
 	envBPFFilter string = `GRAVWELL_SNIFF_BPF_FILTER`
@@ -38,12 +42,20 @@ type cfgReadType struct {
 }
 
 type snif struct {
-	Interface       string //interface name to bind to
-	Promisc         bool   //whether we are binding in promisc mode
-	Tag_Name        string //tag to apply to ingested data
-	Snap_Len        int    //max capture length for packets
-	BPF_Filter      string //BPF-syntax expression to filter packets captured
-	Source_Override string //override normal source IP of the interface
+	Interface           string //interface name to bind to
+	Promisc             bool   //whether we are binding in promisc mode
+	Tag_Name            string //tag to apply to ingested data
+	Snap_Len            int    //max capture length for packets
+	BPF_Filter          string //BPF-syntax expression to filter packets captured
+	Source_Override     string //override normal source IP of the interface
+	Sample_Rate         int    //capture 1 in N packets, 0 or 1 captures every packet
+	Flow_Aggregation    bool   //emit per-flow summaries instead of raw packets
+	Flow_Idle_Timeout   string //export a flow after it has been idle this long
+	Flow_Active_Timeout string //export long lived flows at this interval
+	Max_Flows           int    //maximum number of flows tracked at once
+	Capture_Backend     string //pcap (default) or afpacket for TPACKETv3 rings
+	Fanout_Workers      int    //number of AF_PACKET sockets sharing the interface via fanout
+	Ring_Size           int    //MB of ring buffer for each AF_PACKET socket
 }
 
 type cfgType struct {
@@ -116,17 +128,99 @@ func verifyConfig(c *cfgType) error {
 			v.Snap_Len = defaultSnapLen
 		}
 		if v.Source_Override != `` {
-			if net.ParseIP(v.Source_Override) == nil {
-				return errors.New("Failed to parse Source_Override")
+			if _, err := config.ParseSourceOverride(v.Source_Override); err != nil {
+				return fmt.Errorf("Invalid Source-Override for %s: %w", k, err)
 			}
 		}
 		if err := config.LoadEnvVar(&v.BPF_Filter, envBPFFilter, defaultBpfFilter); err != nil {
 			return err
 		}
+		if v.Sample_Rate < 0 {
+			return errors.New("Invalid Sample-Rate for " + k + ", must be >= 0")
+		}
+		if err := v.verifyBackend(); err != nil {
+			return fmt.Errorf("Invalid capture backend for %s: %v", k, err)
+		}
+		if _, err := v.flowConfig(); err != nil {
+			return fmt.Errorf("Invalid flow configuration for %s: %v", k, err)
+		}
 	}
 	return nil
 }
 
+func (s *snif) verifyBackend() error {
+	s.Capture_Backend = strings.ToLower(strings.TrimSpace(s.Capture_Backend))
+	switch s.Capture_Backend {
+	case ``, backendPcap:
+		if s.Fanout_Workers > 1 || s.Ring_Size != 0 {
+			return ErrFanoutRequiresRing
+		}
+	case backendAFPacket:
+		if runtime.GOOS != `linux` {
+			return ErrAFPacketUnsupported
+		}
+	default:
+		return ErrUnknownBackend
+	}
+	if s.Fanout_Workers < 0 || s.Fanout_Workers > maxFanoutWorkers {
+		return fmt.Errorf("Fanout-Workers must be between 1 and %d", maxFanoutWorkers)
+	} else if s.Ring_Size < 0 {
+		return errors.New("Ring-Size must be positive")
+	}
+	return nil
+}
+
+// workers returns the number of capture workers to start for the sniffer
+func (s *snif) workers() int {
+	if s.Fanout_Workers < 1 {
+		return 1
+	}
+	return s.Fanout_Workers
+}
+
+// sampleRate returns N for 1-in-N sampling, 1 means every packet is captured
+func (s *snif) sampleRate() int {
+	if s.Sample_Rate <= 1 {
+		return 1
+	}
+	return s.Sample_Rate
+}
+
+// flowConfig returns the flow aggregation settings, nil is returned if flow aggregation is disabled
+func (s *snif) flowConfig() (fc *flowConfig, err error) {
+	if !s.Flow_Aggregation {
+		if s.Flow_Idle_Timeout != `` || s.Flow_Active_Timeout != `` || s.Max_Flows != 0 {
+			err = errors.New("flow options require Flow-Aggregation=true")
+		}
+		return
+	}
+	fc = &flowConfig{
+		idle:       defaultFlowIdleTimeout,
+		active:     defaultFlowActiveTimeout,
+		maxFlows:   defaultMaxFlows,
+		sampleRate: s.sampleRate(),
+	}
+	if s.Flow_Idle_Timeout != `` {
+		if fc.idle, err = time.ParseDuration(s.Flow_Idle_Timeout); err != nil {
+			return nil, fmt.Errorf("invalid Flow-Idle-Timeout: %v", err)
+		}
+	}
+	if s.Flow_Active_Timeout != `` {
+		if fc.active, err = time.ParseDuration(s.Flow_Active_Timeout); err != nil {
+			return nil, fmt.Errorf("invalid Flow-Active-Timeout: %v", err)
+		}
+	}
+	if fc.idle <= 0 || fc.active <= 0 {
+		return nil, errors.New("flow timeouts must be positive")
+	}
+	if s.Max_Flows < 0 {
+		return nil, errors.New("Max-Flows must be positive")
+	} else if s.Max_Flows > 0 {
+		fc.maxFlows = s.Max_Flows
+	}
+	return
+}
+
 // Generate a list of all tags used by this ingester
 func (c *cfgType) Tags() ([]string, error) {
 	var tags []string
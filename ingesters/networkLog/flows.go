@@ -0,0 +1,233 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultFlowIdleTimeout   = 15 * time.Second
+	defaultFlowActiveTimeout = time.Minute
+	defaultMaxFlows          = 65536
+)
+
+// flowKey is the unidirectional 5-tuple used to aggregate packets, IPv4 addresses
+// are stored in their IPv4-in-IPv6 form.
+type flowKey struct {
+	src   [16]byte
+	dst   [16]byte
+	sport uint16
+	dport uint16
+	proto uint8
+}
+
+// flowRecord is the summary emitted for each flow, counts are of sampled packets
+// and should be scaled by SampleRate to estimate the true volume.
+type flowRecord struct {
+	Start      time.Time
+	End        time.Time
+	Proto      string
+	Src        net.IP
+	SrcPort    uint16
+	Dst        net.IP
+	DstPort    uint16
+	Packets    uint64
+	Bytes      uint64
+	TCPFlags   uint8 `json:",omitempty"`
+	SampleRate int   `json:",omitempty"`
+}
+
+type flowConfig struct {
+	idle       time.Duration
+	active     time.Duration
+	maxFlows   int
+	sampleRate int
+}
+
+// flowTable aggregates decoded packets into flows, it is not safe for concurrent use.
+type flowTable struct {
+	flowConfig
+	flows map[flowKey]*flowRecord
+
+	eth     layers.Ethernet
+	sll     layers.LinuxSLL
+	dot1q   layers.Dot1Q
+	ip4     layers.IPv4
+	ip6     layers.IPv6
+	tcp     layers.TCP
+	udp     layers.UDP
+	icmp4   layers.ICMPv4
+	icmp6   layers.ICMPv6
+	decoded []gopacket.LayerType
+	parsers map[gopacket.LayerType]*gopacket.DecodingLayerParser
+	first   gopacket.LayerType
+}
+
+func newFlowTable(cfg flowConfig, lt layers.LinkType) *flowTable {
+	ft := &flowTable{
+		flowConfig: cfg,
+		flows:      make(map[flowKey]*flowRecord),
+		parsers:    make(map[gopacket.LayerType]*gopacket.DecodingLayerParser, 3),
+	}
+	switch lt {
+	case layers.LinkTypeLinuxSLL:
+		ft.first = layers.LayerTypeLinuxSLL
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+		ft.first = gopacket.LayerTypeZero // picked per packet from the IP version
+	default:
+		ft.first = layers.LayerTypeEthernet
+	}
+	for _, first := range []gopacket.LayerType{ft.first, layers.LayerTypeIPv4, layers.LayerTypeIPv6} {
+		if first == gopacket.LayerTypeZero {
+			continue
+		}
+		p := gopacket.NewDecodingLayerParser(first, &ft.eth, &ft.sll, &ft.dot1q, &ft.ip4, &ft.ip6,
+			&ft.tcp, &ft.udp, &ft.icmp4, &ft.icmp6)
+		p.IgnoreUnsupported = true
+		ft.parsers[first] = p
+	}
+	return ft
+}
+
+// add decodes a packet and accounts for it in the table, packets which are not IP are ignored.
+// If the table is full every active flow is returned so that it can be exported early.
+func (ft *flowTable) add(data []byte, wireLen int, ts time.Time) (full []*flowRecord) {
+	first := ft.first
+	if first == gopacket.LayerTypeZero {
+		if len(data) == 0 {
+			return
+		} else if data[0]>>4 == 6 {
+			first = layers.LayerTypeIPv6
+		} else {
+			first = layers.LayerTypeIPv4
+		}
+	}
+	if err := ft.parsers[first].DecodeLayers(data, &ft.decoded); err != nil {
+		return
+	}
+	var key flowKey
+	var flags uint8
+	var ip bool
+	for _, lt := range ft.decoded {
+		switch lt {
+		case layers.LayerTypeIPv4:
+			copy(key.src[:], ft.ip4.SrcIP.To16())
+			copy(key.dst[:], ft.ip4.DstIP.To16())
+			key.proto = uint8(ft.ip4.Protocol)
+			ip = true
+		case layers.LayerTypeIPv6:
+			copy(key.src[:], ft.ip6.SrcIP.To16())
+			copy(key.dst[:], ft.ip6.DstIP.To16())
+			key.proto = uint8(ft.ip6.NextHeader)
+			ip = true
+		case layers.LayerTypeTCP:
+			key.sport, key.dport = uint16(ft.tcp.SrcPort), uint16(ft.tcp.DstPort)
+			flags = tcpFlags(&ft.tcp)
+		case layers.LayerTypeUDP:
+			key.sport, key.dport = uint16(ft.udp.SrcPort), uint16(ft.udp.DstPort)
+		case layers.LayerTypeICMPv4:
+			key.dport = uint16(ft.icmp4.TypeCode) // type and code, as netflow does
+		case layers.LayerTypeICMPv6:
+			key.dport = uint16(ft.icmp6.TypeCode)
+		}
+	}
+	if !ip {
+		return
+	}
+	fr, ok := ft.flows[key]
+	if !ok {
+		if len(ft.flows) >= ft.maxFlows {
+			full = ft.expire(time.Time{}, true)
+		}
+		fr = &flowRecord{
+			Start:      ts,
+			Proto:      layers.IPProtocol(key.proto).String(),
+			Src:        net.IP(append([]byte(nil), key.src[:]...)),
+			SrcPort:    key.sport,
+			Dst:        net.IP(append([]byte(nil), key.dst[:]...)),
+			DstPort:    key.dport,
+			SampleRate: ft.sampleRate,
+		}
+		ft.flows[key] = fr
+	}
+	fr.End = ts
+	fr.Packets++
+	fr.Bytes += uint64(wireLen)
+	fr.TCPFlags |= flags
+	// a closed TCP connection will not see any more traffic, no reason to wait out the idle timer
+	if flags&(tcpFIN|tcpRST) != 0 {
+		full = append(full, fr)
+		delete(ft.flows, key)
+	}
+	return
+}
+
+// expire removes and returns flows which have been idle or active for too long as of now,
+// all flows are returned when all is set.
+func (ft *flowTable) expire(now time.Time, all bool) (r []*flowRecord) {
+	for k, fr := range ft.flows {
+		if all || now.Sub(fr.End) >= ft.idle || now.Sub(fr.Start) >= ft.active {
+			r = append(r, fr)
+			delete(ft.flows, k)
+		}
+	}
+	return
+}
+
+// flowEntries encodes flow records into entries
+func flowEntries(flows []*flowRecord, src net.IP, tag entry.EntryTag) (set []*entry.Entry, size uint64) {
+	set = make([]*entry.Entry, 0, len(flows))
+	for _, fr := range flows {
+		b, err := json.Marshal(fr)
+		if err != nil {
+			continue
+		}
+		set = append(set, &entry.Entry{
+			TS:   entry.FromStandard(fr.Start),
+			SRC:  src,
+			Tag:  tag,
+			Data: b,
+		})
+		size += uint64(len(b))
+	}
+	return
+}
+
+const (
+	tcpFIN uint8 = 1 << iota
+	tcpSYN
+	tcpRST
+	tcpPSH
+	tcpACK
+	tcpURG
+	tcpECE
+	tcpCWR
+)
+
+func tcpFlags(t *layers.TCP) (f uint8) {
+	for _, v := range []struct {
+		set  bool
+		flag uint8
+	}{
+		{t.FIN, tcpFIN}, {t.SYN, tcpSYN}, {t.RST, tcpRST}, {t.PSH, tcpPSH},
+		{t.ACK, tcpACK}, {t.URG, tcpURG}, {t.ECE, tcpECE}, {t.CWR, tcpCWR},
+	} {
+		if v.set {
+			f |= v.flag
+		}
+	}
+	return
+}
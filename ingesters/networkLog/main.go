@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
@@ -29,7 +30,6 @@ import (
 	"github.com/gravwell/gravwell/v3/ingesters/version"
 
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
 )
 
 const (
@@ -70,7 +70,13 @@ type sniffer struct {
 	tag       entry.EntryTag
 	SnapLen   int
 	BPFFilter string
-	handle    *pcap.Handle
+	sample    int         //capture 1 in N packets
+	flows     *flowConfig //emit flow summaries rather than packets when set
+	backend   string      //capture backend
+	ringSize  int         //MB of ring for AF_PACKET
+	workers   int         //number of workers in the fanout group
+	fanoutId  uint16      //AF_PACKET fanout group shared by the workers
+	handle    packetSource
 	src       net.IP
 	die       chan bool
 	res       chan results
@@ -181,7 +187,7 @@ func main() {
 		CacheSize:          cfg.Max_Ingest_Cache,
 		CacheMode:          cfg.Cache_Mode,
 		Logger:             lg,
-		LogSourceOverride:  net.ParseIP(cfg.Log_Source_Override),
+		LogSourceOverride:  cfg.LogSourceOverride(),
 	}
 	igst, err := ingest.NewUniformMuxer(igCfg)
 	if err != nil {
@@ -219,17 +225,15 @@ func main() {
 		//If not, derive one.
 		var src net.IP
 		if v.Source_Override != `` {
-			src = net.ParseIP(v.Source_Override)
-			if src == nil {
+			if src, err = config.ParseSourceOverride(v.Source_Override); err != nil {
 				closeSniffers(sniffs)
-				lg.FatalCode(0, "Source-Override is invalid")
+				lg.FatalCode(0, "Source-Override is invalid", log.KV("sniffer", k), log.KVErr(err))
 			}
 		} else if cfg.Source_Override != `` {
 			// global override
-			src = net.ParseIP(cfg.Source_Override)
-			if src == nil {
+			if src, err = config.ParseSourceOverride(cfg.Source_Override); err != nil {
 				closeSniffers(sniffs)
-				lg.FatalCode(0, "Global Source-Override is invalid")
+				lg.FatalCode(0, "Global Source-Override is invalid", log.KVErr(err))
 			}
 		} else {
 			src, err = getSourceIP(v.Interface)
@@ -238,32 +242,42 @@ func main() {
 			}
 		}
 
-		//get the handle on the device
-		hnd, err := pcap.OpenLive(v.Interface, int32(v.Snap_Len), v.Promisc, pktTimeout)
+		fc, err := v.flowConfig()
 		if err != nil {
 			closeSniffers(sniffs)
-			lg.FatalCode(0, "failed to initialize handler", log.KV("interface", v.Interface), log.KV("sniffer", k), log.KVErr(err))
+			lg.FatalCode(0, "invalid flow configuration", log.KV("sniffer", k), log.KVErr(err))
 		}
-		//apply a filter if one is specified
-		if v.BPF_Filter != `` {
-			if err := hnd.SetBPFFilter(v.BPF_Filter); err != nil {
-				hnd.Close()
+		//each fanout worker is an independent sniffer sharing the interface through the fanout group
+		workers := v.workers()
+		fanoutId := uint16(os.Getpid()&0xff)<<8 | uint16(len(sniffs)&0xff)
+		for w := 0; w < workers; w++ {
+			s := sniffer{
+				name:      k,
+				src:       src,
+				Promisc:   v.Promisc,
+				Interface: v.Interface,
+				TagName:   v.Tag_Name,
+				SnapLen:   v.Snap_Len,
+				BPFFilter: v.BPF_Filter,
+				sample:    v.sampleRate(),
+				flows:     fc,
+				backend:   v.Capture_Backend,
+				ringSize:  v.Ring_Size,
+				workers:   workers,
+				fanoutId:  fanoutId,
+				die:       make(chan bool, 1),
+				res:       make(chan results, 1),
+			}
+			if workers > 1 {
+				s.name = fmt.Sprintf("%s/%d", k, w)
+			}
+			//get the handle on the device
+			if s.handle, err = openSource(&s); err != nil {
 				closeSniffers(sniffs)
-				lg.FatalCode(0, "invalid BPF filter", log.KV("sniffer", k), log.KVErr(err))
+				lg.FatalCode(0, "failed to initialize handler", log.KV("interface", v.Interface), log.KV("sniffer", k), log.KVErr(err))
 			}
+			sniffs = append(sniffs, s)
 		}
-		sniffs = append(sniffs, sniffer{
-			name:      k,
-			src:       src,
-			Promisc:   v.Promisc,
-			Interface: v.Interface,
-			TagName:   v.Tag_Name,
-			SnapLen:   v.Snap_Len,
-			BPFFilter: v.BPF_Filter,
-			handle:    hnd,
-			die:       make(chan bool, 1),
-			res:       make(chan results, 1),
-		})
 	}
 
 	//set tags and source for each sniffer
@@ -305,7 +319,7 @@ func main() {
 }
 
 //Called if something bad happens and we need to re-open the packet source
-func rebuildPacketSource(s *sniffer) (*pcap.Handle, bool) {
+func rebuildPacketSource(s *sniffer) (packetSource, bool) {
 	var threwErr bool
 mainLoop:
 	for {
@@ -315,23 +329,15 @@ mainLoop:
 		case <-s.die:
 			break mainLoop
 		}
-		//sleep over, try to reopen our pcap device
-		hnd, err := pcap.OpenLive(s.Interface, int32(s.SnapLen), s.Promisc, pktTimeout)
+		//sleep over, try to reopen our capture device
+		hnd, err := openSource(s)
 		if err != nil {
 			if !threwErr {
 				threwErr = true
-				lg.Error("failed to get pcap device on reopen", log.KV("interface", s.Interface), log.KVErr(err))
+				lg.Error("failed to get capture device on reopen", log.KV("interface", s.Interface), log.KVErr(err))
 			}
 			continue
 		}
-		if s.BPFFilter != `` {
-			if err := hnd.SetBPFFilter(s.BPFFilter); err != nil {
-				//this is fatal, this shouldn't be possible, but here we are
-				lg.Error("invalid BPF Filter on reopen", log.KV("interface", s.Interface), log.KVErr(err))
-				hnd.Close()
-				break mainLoop
-			}
-		}
 		//we got a good handle, return it
 		return hnd, true
 	}
@@ -340,11 +346,14 @@ mainLoop:
 
 //A captured packet
 type capPacket struct {
-	ts   entry.Timestamp
-	data []byte
+	ts      entry.Timestamp
+	data    []byte
+	wireLen int
 }
 
-func packetExtractor(hnd *pcap.Handle, c chan []capPacket) {
+//packetExtractor reads packets from the handle, keeping 1 in every sample packets.
+//Raw packets are handed over exactly as captured, without any link layer trimming.
+func packetExtractor(hnd packetSource, c chan []capPacket, sample int, raw bool) {
 	defer close(c)
 	var packets []capPacket
 	var packetsSize int
@@ -355,14 +364,15 @@ func packetExtractor(hnd *pcap.Handle, c chan []capPacket) {
 	var trimSize int
 	//in order for us to deal SLL "cooked" interfaces we have to trim th first 2 bytes
 	//The ethernet layer is going to be foobared, but the IP layers should be fine
-	if hnd.LinkType() == layers.LinkTypeLinuxSLL {
+	if hnd.LinkType() == layers.LinkTypeLinuxSLL && !raw {
 		trimSize = 2
 	}
+	var seen int
 
 	for {
 		data, ci, err := hnd.ReadPacketData()
 		if err != nil {
-			if err == pcap.NextErrorTimeoutExpired || err == io.EOF {
+			if isReadTimeout(err) || err == io.EOF {
 				if len(packets) > 0 {
 					c <- packets
 					packets = nil
@@ -373,11 +383,17 @@ func packetExtractor(hnd *pcap.Handle, c chan []capPacket) {
 			debugout("failed to get packet from source: %v\n", err)
 			break
 		}
+		if sample > 1 {
+			if seen++; seen%sample != 0 {
+				continue
+			}
+		}
 		if trimSize > 0 && len(data) > trimSize {
 			data = data[trimSize:]
 		}
 		capPkt.data = data
 		capPkt.ts = entry.FromStandard(ci.Timestamp)
+		capPkt.wireLen = ci.Length
 		packets = append(packets, capPkt)
 		packetsSize += len(capPkt.data)
 
@@ -409,9 +425,38 @@ func pcapIngester(igst *ingest.IngestMuxer, s *sniffer) {
 
 	//get a packet source
 	ch := make(chan []capPacket, 1024)
-	go packetExtractor(s.handle, ch)
+	go packetExtractor(s.handle, ch, s.sample, s.flows != nil)
 	debugout("Starting sniffer %s on %s with \"%s\"\n", s.name, s.Interface, s.BPFFilter)
-	lg.Info("starting sniffer", log.KV("sniffer", s.name), log.KV("interface", s.Interface), log.KV("bpffilter", s.BPFFilter))
+	lg.Info("starting sniffer", log.KV("sniffer", s.name), log.KV("interface", s.Interface), log.KV("bpffilter", s.BPFFilter),
+		log.KV("samplerate", s.sample), log.KV("flows", s.flows != nil), log.KV("backend", s.backend))
+
+	//when aggregating flows we periodically sweep the table for flows to export
+	var ft *flowTable
+	var sweep <-chan time.Time
+	if s.flows != nil {
+		ft = newFlowTable(*s.flows, s.handle.LinkType())
+		tckr := time.NewTicker(time.Second)
+		defer tckr.Stop()
+		sweep = tckr.C
+	}
+	writeFlows := func(flows []*flowRecord) error {
+		if len(flows) == 0 {
+			return nil
+		}
+		set, sz := flowEntries(flows, s.src, s.tag)
+		count += uint64(len(set))
+		totalBytes += sz
+		return igst.WriteBatch(set)
+	}
+	fail := func(err error) {
+		s.handle.Close()
+		lg.Error("failed to handle entry", log.KVErr(err))
+		s.res <- results{
+			Bytes: 0,
+			Count: 0,
+			Error: err,
+		}
+	}
 
 mainLoop:
 	for {
@@ -419,7 +464,17 @@ mainLoop:
 		select {
 		case _ = <-s.die:
 			s.handle.Close()
+			if ft != nil {
+				if err := writeFlows(ft.expire(time.Time{}, true)); err != nil {
+					lg.Error("failed to flush flows", log.KVErr(err))
+				}
+			}
 			break mainLoop
+		case now := <-sweep:
+			if err := writeFlows(ft.expire(now, false)); err != nil {
+				fail(err)
+				return
+			}
 		case pkts, ok := <-ch: //get a packet
 			if !ok {
 				//Something bad happened, attempt to restart the pcap
@@ -433,11 +488,22 @@ mainLoop:
 				}
 				//now we need to re-start the extractor
 				ch = make(chan []capPacket, 1024)
-				go packetExtractor(s.handle, ch)
+				go packetExtractor(s.handle, ch, s.sample, s.flows != nil)
 				debugout("Rebuilding packet source\n")
 				lg.Info("rebuilt packet source")
 				continue
 			}
+			if ft != nil {
+				var flows []*flowRecord
+				for i := range pkts {
+					flows = append(flows, ft.add(pkts[i].data, pkts[i].wireLen, pkts[i].ts.StandardTime())...)
+				}
+				if err := writeFlows(flows); err != nil {
+					fail(err)
+					return
+				}
+				continue
+			}
 			staticSet := make([]entry.Entry, len(pkts))
 			set := make([]*entry.Entry, len(pkts))
 			for i := range pkts {
@@ -450,13 +516,7 @@ mainLoop:
 				count++
 			}
 			if err := igst.WriteBatch(set); err != nil {
-				s.handle.Close()
-				lg.Error("failed to handle entry", log.KVErr(err))
-				s.res <- results{
-					Bytes: 0,
-					Count: 0,
-					Error: err,
-				}
+				fail(err)
 				return
 			}
 		}
@@ -0,0 +1,26 @@
+//go:build cgo
+// +build cgo
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// newPacketFilter compiles a BPF expression with libpcap, the same syntax used for live capture
+func newPacketFilter(lt layers.LinkType, snaplen int, expr string) (pf packetFilter, err error) {
+	var bpf *pcap.BPF
+	if bpf, err = pcap.NewBPF(lt, snaplen, expr); err == nil {
+		pf = bpf
+	}
+	return
+}
@@ -0,0 +1,23 @@
+//go:build !cgo
+// +build !cgo
+
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+
+	"github.com/google/gopacket/layers"
+)
+
+// newPacketFilter needs libpcap to compile BPF expressions, which is not available without cgo
+func newPacketFilter(lt layers.LinkType, snaplen int, expr string) (packetFilter, error) {
+	return nil, errors.New("BPF filters are not supported in builds without cgo")
+}
@@ -24,12 +24,14 @@ import (
 	"github.com/gravwell/gravwell/v3/ingesters/version"
 
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	pcap "github.com/google/gopacket/pcapgo"
 )
 
 const (
 	throwHintSize  uint64 = 1024 * 1024 * 4
 	throwBlockSize int    = 4096
+	maxSnapLen     int    = 262144 //same default as tcpdump
 )
 
 var (
@@ -37,6 +39,8 @@ var (
 	tsOverride = flag.Bool("ts-override", false, "Override the timestamps and start them at now")
 	simIngest  = flag.Bool("no-ingest", false, "Do not ingest the packets, just read the pcap file")
 	srcOvr     = flag.String("source-override", "", "Override source with address, hash, or integeter")
+	bpfFilter  = flag.String("bpf", "", "Only ingest packets matching a BPF filter, e.g. \"tcp port 443\"")
+	snapLen    = flag.Int("snaplen", 0, "Truncate packets to this many bytes, 0 keeps whole packets")
 	ver        = flag.Bool("version", false, "Print the version information and exit")
 
 	pktCount uint64
@@ -56,6 +60,10 @@ func init() {
 		fmt.Printf("A PCAP file is required\n")
 		os.Exit(-1)
 	}
+	if *snapLen < 0 {
+		fmt.Printf("Invalid snaplen %d\n", *snapLen)
+		os.Exit(-1)
+	}
 
 	simulate = *simIngest
 }
@@ -74,6 +82,10 @@ func main() {
 		return
 	}
 	defer ph.Close()
+	if err = ph.configure(*bpfFilter, *snapLen); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set up packet filtering: %v\n", err)
+		return
+	}
 
 	//fire up the ingesters
 	igCfg := ingest.UniformMuxerConfig{
@@ -150,6 +162,9 @@ func main() {
 	dur := time.Since(start)
 	fmt.Printf("Completed in %v (%s)\n", dur, ingest.HumanSize(pktSize))
 	fmt.Printf("Total Count: %s\n", ingest.HumanCount(pktCount))
+	if ph.filter != nil {
+		fmt.Printf("Filtered Count: %s\n", ingest.HumanCount(ph.filtered))
+	}
 	fmt.Printf("Entry Rate: %s\n", ingest.HumanEntryRate(pktCount, dur))
 	fmt.Printf("Ingest Rate: %s\n", ingest.HumanRate(pktSize, dur))
 }
@@ -260,11 +275,19 @@ func simulatePacketRead(hnd *packetHandle) (err error) {
 	return
 }
 
+// packetFilter decides if a packet should be ingested
+type packetFilter interface {
+	Matches(gopacket.CaptureInfo, []byte) bool
+}
+
 type packetHandle struct {
-	fi     io.ReadCloser
-	ngMode bool
-	hnd    *pcap.Reader
-	nghnd  *pcap.NgReader
+	fi       io.ReadCloser
+	ngMode   bool
+	hnd      *pcap.Reader
+	nghnd    *pcap.NgReader
+	filter   packetFilter
+	snaplen  int
+	filtered uint64 //packets dropped by the filter
 }
 
 func newPacketReader(pth string, buff int) (ph *packetHandle, err error) {
@@ -306,11 +329,44 @@ func (ph *packetHandle) Close() error {
 	return ph.fi.Close()
 }
 
-func (ph *packetHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+func (ph *packetHandle) LinkType() layers.LinkType {
 	if ph.ngMode {
-		data, ci, err = ph.nghnd.ReadPacketData()
-	} else {
-		data, ci, err = ph.hnd.ReadPacketData()
+		return ph.nghnd.LinkType()
+	}
+	return ph.hnd.LinkType()
+}
+
+// configure sets up an optional BPF filter and snaplen, packets are filtered before they are truncated
+func (ph *packetHandle) configure(expr string, snaplen int) (err error) {
+	ph.snaplen = snaplen
+	if expr == `` {
+		return
+	}
+	fsnap := snaplen
+	if fsnap == 0 {
+		fsnap = maxSnapLen
+	}
+	ph.filter, err = newPacketFilter(ph.LinkType(), fsnap, expr)
+	return
+}
+
+func (ph *packetHandle) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	for {
+		if ph.ngMode {
+			data, ci, err = ph.nghnd.ReadPacketData()
+		} else {
+			data, ci, err = ph.hnd.ReadPacketData()
+		}
+		if err != nil {
+			return
+		} else if ph.filter == nil || ph.filter.Matches(ci, data) {
+			break
+		}
+		ph.filtered++
+	}
+	if ph.snaplen > 0 && len(data) > ph.snaplen {
+		data = data[:ph.snaplen]
+		ci.CaptureLength = ph.snaplen
 	}
 	return
 }
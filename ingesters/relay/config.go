@@ -0,0 +1,249 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	defClearPort uint16 = 4023
+	defTLSPort   uint16 = 4024
+
+	tenantTagSep = `-`
+)
+
+var (
+	ErrInvalidTenantSecret = errors.New("Tenant-Secret must be <tenant>:<secret>")
+)
+
+type listener struct {
+	Bind_String          string
+	Ingest_Secret        string   `json:"-"` // secret downstream ingesters authenticate with, defaults to the global Ingest-Secret
+	Tenant_Secret        []string `json:"-"` // <tenant>:<secret>, ingesters using the secret are labeled with the tenant
+	Tenant_Tag_Prefix    bool     // prefix upstream tags with the tenant label, e.g. acme-syslog
+	TLS_Certificate_File string
+	TLS_Key_File         string
+	Tag_Allow            []string // tags, or path.Match patterns, accepted from downstream ingesters
+	Tag_Deny             []string // tags, or patterns, dropped even when allowed
+	Tag_Rewrite          []string // <tag or pattern>:<new tag>, the first match wins
+	Client_Rate_Limit    string   // bandwidth allowed per client address, e.g. 10Mbit
+	Client_Entry_Rate    int      // entries per second allowed per client address
+	Max_Clients          int      // concurrent connections allowed across all clients
+	Preprocessor         []string
+	processors.ConnMetadataConfig
+}
+
+type cfgReadType struct {
+	Global       config.IngestConfig
+	Listener     map[string]*listener
+	Preprocessor processors.ProcessorConfig
+}
+
+type cfgType struct {
+	config.IngestConfig
+	Listener     map[string]*listener
+	Preprocessor processors.ProcessorConfig
+}
+
+func GetConfig(path, overlayPath string) (*cfgType, error) {
+	var cr cfgReadType
+	if err := config.LoadConfigFile(&cr, path); err != nil {
+		return nil, err
+	} else if err = config.LoadConfigOverlays(&cr, overlayPath); err != nil {
+		return nil, err
+	}
+
+	c := &cfgType{
+		IngestConfig: cr.Global,
+		Listener:     cr.Listener,
+		Preprocessor: cr.Preprocessor,
+	}
+	if err := verifyConfig(c); err != nil {
+		return nil, err
+	}
+	// Verify and set UUID
+	if _, ok := c.IngesterUUID(); !ok {
+		id := uuid.New()
+		if err := c.SetIngesterUUID(id, path); err != nil {
+			return nil, err
+		}
+		if id2, ok := c.IngesterUUID(); !ok || id != id2 {
+			return nil, errors.New("Failed to set a new ingester UUID")
+		}
+	}
+	return c, nil
+}
+
+func verifyConfig(c *cfgType) error {
+	//verify the global parameters
+	if err := c.Verify(); err != nil {
+		return err
+	}
+	if len(c.Listener) == 0 {
+		return errors.New("No listeners specified")
+	}
+	if err := c.Preprocessor.Validate(); err != nil {
+		return err
+	}
+	bindMp := make(map[string]string, len(c.Listener))
+	for k, v := range c.Listener {
+		if v.Bind_String == `` {
+			return errors.New("No Bind-String provided for " + k)
+		}
+		if v.tlsEnabled() {
+			if v.TLS_Certificate_File == `` || v.TLS_Key_File == `` {
+				return fmt.Errorf("Listener %s requires both TLS-Certificate-File and TLS-Key-File", k)
+			}
+			v.Bind_String = config.AppendDefaultPort(v.Bind_String, defTLSPort)
+		} else {
+			v.Bind_String = config.AppendDefaultPort(v.Bind_String, defClearPort)
+		}
+		if n, ok := bindMp[v.Bind_String]; ok {
+			return errors.New("Bind-String for " + k + " already in use by " + n)
+		}
+		bindMp[v.Bind_String] = k
+		tenants, err := v.tenants()
+		if err != nil {
+			return fmt.Errorf("Listener %s %v", k, err)
+		}
+		if len(tenants) > 0 {
+			//tenant labels are always attached, that is the point of having them
+			if !hasMetadata(v.Attach_Metadata, processors.MetadataTenant) {
+				v.Attach_Metadata = append(v.Attach_Metadata, processors.MetadataTenant)
+			}
+		} else if v.Ingest_Secret == `` {
+			//listeners that only serve tenants do not accept the global secret
+			v.Ingest_Secret = c.Secret()
+		}
+		if err = v.ConnMetadataConfig.Validate(); err != nil {
+			return fmt.Errorf("Listener %s %v", k, err)
+		}
+		if _, err := v.rules(); err != nil {
+			return fmt.Errorf("Listener %s %v", k, err)
+		}
+		if _, err := v.quotas(); err != nil {
+			return fmt.Errorf("Listener %s %v", k, err)
+		}
+		if v.Max_Clients < 0 {
+			return fmt.Errorf("Listener %s has an invalid Max-Clients", k)
+		}
+		if err := c.Preprocessor.CheckProcessors(v.Preprocessor); err != nil {
+			return fmt.Errorf("Listener %s preprocessor invalid: %v", k, err)
+		}
+	}
+	return nil
+}
+
+// Tags returns the tags negotiated up front, the rewrite targets.  Everything else is
+// negotiated as downstream ingesters send it.
+func (c *cfgType) Tags() ([]string, error) {
+	tagMp := map[string]bool{entry.DefaultTagName: true}
+	for _, v := range c.Listener {
+		tr, err := v.rules()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range tr.rewrite {
+			tagMp[r.tag] = true
+		}
+	}
+	tags := make([]string, 0, len(tagMp))
+	for k := range tagMp {
+		tags = append(tags, k)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (l *listener) tlsEnabled() bool {
+	return l.TLS_Certificate_File != `` || l.TLS_Key_File != ``
+}
+
+func (l *listener) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(l.TLS_Certificate_File, l.TLS_Key_File)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if err = config.FIPSTLSConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (l *listener) rules() (*tagRules, error) {
+	return newTagRules(l.Tag_Allow, l.Tag_Deny, l.Tag_Rewrite)
+}
+
+// tenants parses the Tenant-Secret values, the tenant is split off at the first colon so secrets may contain colons
+func (l *listener) tenants() (ts []ingest.TenantSecret, err error) {
+	labels := make(map[string]bool, len(l.Tenant_Secret))
+	for _, v := range l.Tenant_Secret {
+		idx := strings.IndexByte(v, ':')
+		if idx <= 0 || idx == len(v)-1 {
+			return nil, ErrInvalidTenantSecret
+		}
+		t := ingest.TenantSecret{
+			Tenant: strings.TrimSpace(v[:idx]),
+			Secret: v[idx+1:],
+		}
+		if t.Tenant == `` {
+			return nil, ErrInvalidTenantSecret
+		} else if labels[t.Tenant] {
+			return nil, fmt.Errorf("has tenant %s more than once", t.Tenant)
+		} else if l.Tenant_Tag_Prefix {
+			//the label becomes part of tag names
+			if err = ingest.CheckTag(t.Tenant + tenantTagSep); err != nil {
+				return nil, fmt.Errorf("tenant %q cannot prefix tags: %v", t.Tenant, err)
+			}
+		}
+		labels[t.Tenant] = true
+		ts = append(ts, t)
+	}
+	return
+}
+
+func hasMetadata(md []string, name string) bool {
+	for _, v := range md {
+		if strings.EqualFold(strings.TrimSpace(v), name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *listener) quotas() (*quotas, error) {
+	var bps int64
+	if l.Client_Rate_Limit != `` {
+		var err error
+		if bps, err = config.ParseRate(l.Client_Rate_Limit); err != nil {
+			return nil, fmt.Errorf("has an invalid Client-Rate-Limit: %v", err)
+		} else if bps <= 0 {
+			return nil, errors.New("has an invalid Client-Rate-Limit")
+		}
+	}
+	if l.Client_Entry_Rate < 0 {
+		return nil, errors.New("has an invalid Client-Entry-Rate")
+	}
+	return newQuotas(bps, l.Client_Entry_Rate), nil
+}
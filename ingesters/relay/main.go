@@ -0,0 +1,229 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// The relay ingester accepts native ingest connections from downstream ingesters and forwards
+// their entries upstream, filtering and renaming tags and limiting each client along the way.
+// It is intended for DMZ and multi-tenant collection edges.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config/validate"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+	"github.com/gravwell/gravwell/v3/ingesters/version"
+)
+
+const (
+	defaultConfigLoc  = `/opt/gravwell/etc/relay.conf`
+	defaultConfigDLoc = `/opt/gravwell/etc/relay.conf.d`
+	ingesterName      = `relay`
+	appName           = `relay`
+)
+
+var (
+	confLoc        = flag.String("config-file", defaultConfigLoc, "Override location for configuration file")
+	confdLoc       = flag.String("config-overlays", defaultConfigDLoc, "Location for configuration overlay files")
+	verbose        = flag.Bool("v", false, "Display verbose status updates to stdout")
+	ver            = flag.Bool("version", false, "Print the version information and exit")
+	stderrOverride = flag.String("stderr", "", "Redirect stderr to a shared memory file")
+	v              bool
+	lg             *log.Logger
+)
+
+func mainInit() {
+	flag.Parse()
+	if *ver {
+		version.PrintVersion(os.Stdout)
+		ingest.PrintVersion(os.Stdout)
+		os.Exit(0)
+	}
+	lg = log.New(os.Stderr) // DO NOT close this, it will prevent backtraces from firing
+	lg.SetAppname(appName)
+	if *stderrOverride != `` {
+		if oldstderr, err := syscall.Dup(int(os.Stderr.Fd())); err != nil {
+			lg.Fatal("Failed to dup stderr", log.KVErr(err))
+		} else {
+			lg.AddWriter(os.NewFile(uintptr(oldstderr), "oldstderr"))
+		}
+
+		fp := filepath.Join(`/dev/shm/`, *stderrOverride)
+		fout, err := os.Create(fp)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %v\n", fp, err)
+		} else {
+			version.PrintVersion(fout)
+			ingest.PrintVersion(fout)
+			log.PrintOSInfo(fout)
+			//file created, dup it
+			if err := syscall.Dup2(int(fout.Fd()), int(os.Stderr.Fd())); err != nil {
+				fout.Close()
+				lg.Fatal("Failed to dup2 stderr", log.KVErr(err))
+			}
+		}
+	}
+	v = *verbose
+	validate.ValidateConfig(GetConfig, *confLoc, *confdLoc)
+}
+
+func main() {
+	mainInit()
+	debug.SetTraceback("all")
+	cfg, err := GetConfig(*confLoc, *confdLoc)
+	if err != nil {
+		lg.FatalCode(0, "failed to get configuration", log.KVErr(err))
+		return
+	}
+
+	if len(cfg.Log_File) > 0 {
+		fout, err := os.OpenFile(cfg.Log_File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+		if err != nil {
+			lg.FatalCode(0, "failed to open log file", log.KV("path", cfg.Log_File), log.KVErr(err))
+		}
+		if err = lg.AddWriter(fout); err != nil {
+			lg.Fatal("failed to add a writer", log.KVErr(err))
+		}
+		if len(cfg.Log_Level) > 0 {
+			if err = lg.SetLevelString(cfg.Log_Level); err != nil {
+				lg.FatalCode(0, "invalid Log Level", log.KV("loglevel", cfg.Log_Level), log.KVErr(err))
+			}
+		}
+	}
+
+	tags, err := cfg.Tags()
+	if err != nil {
+		lg.FatalCode(0, "failed to get tags from configuration", log.KVErr(err))
+	}
+	conns, err := cfg.Targets()
+	if err != nil {
+		lg.FatalCode(0, "failed to get backend targets from configuration", log.KVErr(err))
+	}
+	debugout("Handling %d tags over %d targets\n", len(tags), len(conns))
+
+	lmt, err := cfg.RateLimit()
+	if err != nil {
+		lg.FatalCode(0, "failed to get rate limit from configuration", log.KVErr(err))
+		return
+	}
+	debugout("Rate limiting connection to %d bps\n", lmt)
+
+	//fire up the ingesters
+	debugout("INSECURE skipping TLS verification: %v\n", cfg.InsecureSkipTLSVerification())
+	id, ok := cfg.IngesterUUID()
+	if !ok {
+		lg.FatalCode(0, "Couldn't read ingester UUID")
+	}
+	igCfg := ingest.UniformMuxerConfig{
+		IngestStreamConfig: cfg.IngestStreamConfig,
+		Destinations:       conns,
+		Tags:               tags,
+		Auth:               cfg.Secret(),
+		VerifyCert:         !cfg.InsecureSkipTLSVerification(),
+		IngesterName:       ingesterName,
+		IngesterVersion:    version.GetVersion(),
+		IngesterUUID:       id.String(),
+		IngesterLabel:      cfg.Label,
+		RateLimitBps:       lmt,
+		Logger:             lg,
+		CacheDepth:         cfg.Cache_Depth,
+		CachePath:          cfg.Ingest_Cache_Path,
+		CacheSize:          cfg.Max_Ingest_Cache,
+		CacheMode:          cfg.Cache_Mode,
+		LogSourceOverride:  cfg.LogSourceOverride(),
+	}
+	igst, err := ingest.NewUniformMuxer(igCfg)
+	if err != nil {
+		lg.Fatal("failed build our ingest system", log.KVErr(err))
+	}
+	debugout("Started ingester muxer\n")
+	if cfg.SelfIngest() {
+		lg.AddRelay(igst)
+	}
+	if err := igst.Start(); err != nil {
+		lg.Fatal("failed start our ingest system", log.KVErr(err))
+	}
+	defer igst.Close()
+
+	//wait for something to go hot
+	debugout("Waiting for connections to indexers ... ")
+	if err := igst.WaitForHot(cfg.Timeout()); err != nil {
+		lg.FatalCode(0, "timeout waiting for backend connections", log.KV("timeout", cfg.Timeout()), log.KVErr(err))
+	}
+	debugout("Successfully connected to ingesters\n")
+
+	// prepare the configuration we're going to send upstream
+	err = igst.SetRawConfiguration(cfg)
+	if err != nil {
+		lg.FatalCode(0, "failed to set configuration for ingester state messages", log.KVErr(err))
+	}
+
+	var listeners []*relayListener
+	for k, l := range cfg.Listener {
+		rc := relayConfig{
+			name:       k,
+			igst:       igst,
+			maxClients: l.Max_Clients,
+			tenantTags: l.Tenant_Tag_Prefix,
+		}
+		if rc.rules, err = l.rules(); err != nil {
+			lg.Fatal("invalid tag rules", log.KV("listener", k), log.KVErr(err))
+		} else if rc.quotas, err = l.quotas(); err != nil {
+			lg.Fatal("invalid quotas", log.KV("listener", k), log.KVErr(err))
+		} else if rc.meta, err = l.NewMetadataAttacher(); err != nil {
+			lg.Fatal("invalid metadata", log.KV("listener", k), log.KVErr(err))
+		}
+		if rc.proc, err = cfg.Preprocessor.ProcessorSet(igst, l.Preprocessor); err != nil {
+			lg.Fatal("preprocessor error", log.KV("listener", k), log.KV("preprocessor", l.Preprocessor), log.KVErr(err))
+		}
+		rc.proc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
+		rl, err := newRelayListener(rc, l)
+		if err != nil {
+			lg.Fatal("failed to start listener", log.KV("listener", k), log.KV("bindstring", l.Bind_String), log.KVErr(err))
+		}
+		rl.Start()
+		debugout("Listening for ingesters on %s\n", l.Bind_String)
+		listeners = append(listeners, rl)
+	}
+
+	//listen for the stop signal so we can die gracefully
+	utils.WaitForQuit()
+
+	for _, rl := range listeners {
+		if err := rl.Close(); err != nil {
+			lg.Error("failed to close listener", log.KV("listener", rl.name), log.KVErr(err))
+		}
+		if err := rl.proc.Close(); err != nil {
+			lg.Error("failed to close preprocessors", log.KV("listener", rl.name), log.KVErr(err))
+		}
+		relayed, filtered := rl.Stats()
+		debugout("%s relayed %d entries and filtered %d\n", rl.name, relayed, filtered)
+	}
+
+	lg.Info("relay ingester exiting", log.KV("ingesteruuid", id))
+	if err := igst.Sync(time.Second); err != nil {
+		lg.Error("failed to sync", log.KVErr(err))
+	}
+	if err := igst.Close(); err != nil {
+		lg.Error("failed to close", log.KVErr(err))
+	}
+}
+
+func debugout(format string, args ...interface{}) {
+	if !v {
+		return
+	}
+	fmt.Printf(format, args...)
+}
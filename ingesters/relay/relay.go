@@ -0,0 +1,215 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+var (
+	ErrTooManyClients = errors.New("Max-Clients reached")
+)
+
+type relayConfig struct {
+	name       string
+	igst       *ingest.IngestMuxer
+	proc       *processors.ProcessorSet
+	rules      *tagRules
+	quotas     *quotas
+	maxClients int
+	meta       *processors.MetadataAttacher
+	tenantTags bool // prefix upstream tags with the tenant label
+}
+
+// relayListener accepts downstream ingesters on a single Bind-String and relays what they send upstream
+type relayListener struct {
+	relayConfig
+	//counters are accessed atomically, keep them 8 byte aligned
+	relayed  uint64
+	filtered uint64
+
+	mtx     sync.RWMutex
+	srv     *ingest.IngestServer
+	lst     net.Listener
+	clients map[*ingest.ServerConn]*relayClient
+	routes  map[routeKey]route // downstream tag to upstream tag
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+type relayClient struct {
+	quota *clientQuota
+	anns  []processors.Annotation
+}
+
+// routeKey identifies a downstream tag, tenants may route the same tag differently
+type routeKey struct {
+	tenant string
+	tag    entry.EntryTag
+}
+
+type route struct {
+	tag entry.EntryTag
+	ok  bool // false if the tag is filtered
+}
+
+func newRelayListener(rc relayConfig, l *listener) (rl *relayListener, err error) {
+	rl = &relayListener{
+		relayConfig: rc,
+		clients:     map[*ingest.ServerConn]*relayClient{},
+		routes:      map[routeKey]route{},
+	}
+	var tenants []ingest.TenantSecret
+	if tenants, err = l.tenants(); err != nil {
+		return nil, err
+	}
+	rl.srv, err = ingest.NewIngestServer(ingest.ServerConfig{
+		Secret:       l.Ingest_Secret,
+		Tenants:      tenants,
+		Handler:      rl.handle,
+		Connected:    rl.connected,
+		Disconnected: rl.disconnected,
+		Logger:       lg,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rl.lst, err = net.Listen(`tcp`, l.Bind_String); err != nil {
+		return nil, err
+	}
+	if l.tlsEnabled() {
+		var tcfg *tls.Config
+		if tcfg, err = l.tlsConfig(); err != nil {
+			rl.lst.Close()
+			return nil, err
+		}
+		rl.lst = tls.NewListener(rl.lst, tcfg)
+	}
+	rl.ctx, rl.cancel = context.WithCancel(context.Background())
+	return
+}
+
+func (rl *relayListener) Start() {
+	rl.wg.Add(1)
+	go func() {
+		defer rl.wg.Done()
+		if err := rl.srv.Serve(rl.lst); err != nil && err != ingest.ErrServerClosed {
+			lg.Error("listener failed", log.KV("listener", rl.name), log.KVErr(err))
+		}
+	}()
+}
+
+// Close drops every downstream ingester, anything they already sent has been handed to the muxer
+func (rl *relayListener) Close() error {
+	rl.cancel()
+	err := rl.srv.Close()
+	rl.wg.Wait()
+	return err
+}
+
+func (rl *relayListener) Stats() (relayed, filtered uint64) {
+	return atomic.LoadUint64(&rl.relayed), atomic.LoadUint64(&rl.filtered)
+}
+
+func (rl *relayListener) connected(sc *ingest.ServerConn) error {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	if rl.maxClients > 0 && len(rl.clients) >= rl.maxClients {
+		lg.Warn("rejecting downstream ingester", log.KV("listener", rl.name), log.KV("remote", sc.RemoteAddr()), log.KVErr(ErrTooManyClients))
+		return ErrTooManyClients
+	}
+	md := processors.ConnMetadataFromConn(rl.name, sc.Conn())
+	md.Tenant = sc.Tenant()
+	rl.clients[sc] = &relayClient{
+		quota: rl.quotas.acquire(clientAddr(sc)),
+		anns:  rl.meta.Annotations(md),
+	}
+	return nil
+}
+
+func (rl *relayListener) disconnected(sc *ingest.ServerConn) {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	if rc, ok := rl.clients[sc]; ok {
+		if rc.quota != nil {
+			rl.quotas.release(clientAddr(sc))
+		}
+		delete(rl.clients, sc)
+	}
+}
+
+func (rl *relayListener) handle(sc *ingest.ServerConn, ent *entry.Entry) (err error) {
+	key := routeKey{tenant: sc.Tenant(), tag: ent.Tag}
+	rl.mtx.RLock()
+	r, ok := rl.routes[key]
+	rc := rl.clients[sc]
+	rl.mtx.RUnlock()
+	if !ok {
+		if r, err = rl.resolve(sc, key); err != nil {
+			return
+		}
+	}
+	if !r.ok {
+		atomic.AddUint64(&rl.filtered, 1)
+		return
+	}
+	if err = rc.quota.wait(rl.ctx, len(ent.Data)); err != nil {
+		return
+	} else if err = rl.meta.Attach(ent, rc.anns); err != nil {
+		return
+	}
+	ent.Tag = r.tag
+	if err = rl.proc.ProcessContext(ent, rl.ctx); err == nil {
+		atomic.AddUint64(&rl.relayed, 1)
+	}
+	return
+}
+
+// resolve applies the tag rules to a downstream tag and negotiates the result upstream.
+// Tenant prefixes are applied after the rules, so rules are written against the tags ingesters send.
+func (rl *relayListener) resolve(sc *ingest.ServerConn, key routeKey) (r route, err error) {
+	name, ok := sc.LookupTag(key.tag)
+	if !ok {
+		//the ingester should never send a tag it did not negotiate, drop anything it does
+		return
+	}
+	if name, r.ok = rl.rules.route(name); r.ok {
+		if rl.tenantTags && key.tenant != `` {
+			name = key.tenant + tenantTagSep + name
+		}
+		if name == entry.GravwellTagName {
+			r.tag = entry.GravwellTagId
+		} else if r.tag, err = rl.igst.NegotiateTag(name); err != nil {
+			lg.Error("failed to negotiate tag", log.KV("listener", rl.name), log.KV("tag", name), log.KVErr(err))
+			return
+		}
+	}
+	rl.mtx.Lock()
+	rl.routes[key] = r
+	rl.mtx.Unlock()
+	return
+}
+
+func clientAddr(sc *ingest.ServerConn) string {
+	if host, _, err := net.SplitHostPort(sc.RemoteAddr().String()); err == nil {
+		return host
+	}
+	return sc.RemoteAddr().String()
+}
@@ -0,0 +1,299 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/log"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+func TestTagRules(t *testing.T) {
+	tr, err := newTagRules([]string{`fw-*`, `syslog`}, []string{`fw-debug`}, []string{`fw-old*:fw-legacy`, `syslog:edge-syslog`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		tag  string
+		ok   bool
+	}{
+		{`fw-edge`, `fw-edge`, true},
+		{`fw-debug`, ``, false},
+		{`fw-old-dmz`, `fw-legacy`, true},
+		{`syslog`, `edge-syslog`, true},
+		{`windows`, ``, false},
+	}
+	for _, tst := range tests {
+		if tag, ok := tr.route(tst.name); ok != tst.ok || tag != tst.tag {
+			t.Fatalf("bad route for %s: %q %v", tst.name, tag, ok)
+		}
+	}
+	if tr, err = newTagRules(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	} else if tag, ok := tr.route(`anything`); !ok || tag != `anything` {
+		t.Fatal("empty rules should relay everything")
+	}
+
+	bad := [][3][]string{
+		{{`fw-[`}, nil, nil},
+		{nil, {`[`}, nil},
+		{nil, nil, {`nocolon`}},
+		{nil, nil, {`foo:bad tag`}},
+	}
+	for _, b := range bad {
+		if _, err = newTagRules(b[0], b[1], b[2]); err == nil {
+			t.Fatalf("failed to catch bad rules %v", b)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	q := newQuotas(0, 0)
+	if cq := q.acquire(`10.0.0.1`); cq != nil {
+		t.Fatal("quota handed out with no limits")
+	} else if err := cq.wait(context.Background(), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	q = newQuotas(8*1024, 10)
+	a := q.acquire(`10.0.0.1`)
+	if b := q.acquire(`10.0.0.1`); a != b {
+		t.Fatal("connections from a client do not share a quota")
+	} else if c := q.acquire(`10.0.0.2`); c == a {
+		t.Fatal("clients share a quota")
+	}
+	//oversized entries take the whole bucket rather than failing
+	if err := a.wait(context.Background(), 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := a.wait(ctx, 1024); err == nil {
+		t.Fatal("quota not enforced")
+	}
+	q.release(`10.0.0.1`)
+	q.release(`10.0.0.1`)
+	q.release(`10.0.0.2`)
+	if len(q.clients) != 0 {
+		t.Fatalf("quotas not released: %v", q.clients)
+	}
+}
+
+type upstream struct {
+	sync.Mutex
+	srv  *ingest.IngestServer
+	lst  net.Listener
+	ents map[string][]string
+}
+
+func newUpstream(t *testing.T) *upstream {
+	up := &upstream{ents: map[string][]string{}}
+	var err error
+	up.srv, err = ingest.NewIngestServer(ingest.ServerConfig{
+		Secret: `upstream`,
+		Handler: func(sc *ingest.ServerConn, ent *entry.Entry) error {
+			name, _ := sc.LookupTag(ent.Tag)
+			up.Lock()
+			up.ents[name] = append(up.ents[name], string(ent.Data))
+			up.Unlock()
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.lst, err = net.Listen(`tcp`, `127.0.0.1:0`); err != nil {
+		t.Fatal(err)
+	}
+	go up.srv.Serve(up.lst)
+	return up
+}
+
+func (up *upstream) count(tag string) int {
+	up.Lock()
+	defer up.Unlock()
+	return len(up.ents[tag])
+}
+
+func newTestMuxer(t *testing.T, addr, secret string, tags []string) *ingest.IngestMuxer {
+	im, err := ingest.NewUniformMuxer(ingest.UniformMuxerConfig{
+		Destinations: []string{`tcp://` + addr},
+		Tags:         tags,
+		Auth:         secret,
+		IngesterName: `test`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = im.Start(); err != nil {
+		t.Fatal(err)
+	} else if err = im.WaitForHot(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return im
+}
+
+func startRelay(t *testing.T, igst *ingest.IngestMuxer, l *listener) *relayListener {
+	rc := relayConfig{name: `test`, igst: igst, quotas: newQuotas(0, 0), maxClients: l.Max_Clients, tenantTags: l.Tenant_Tag_Prefix}
+	var pc processors.ProcessorConfig
+	var err error
+	if rc.rules, err = l.rules(); err != nil {
+		t.Fatal(err)
+	} else if rc.meta, err = l.NewMetadataAttacher(); err != nil {
+		t.Fatal(err)
+	} else if rc.proc, err = pc.ProcessorSet(igst, nil); err != nil {
+		t.Fatal(err)
+	}
+	rl, err := newRelayListener(rc, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl.Start()
+	return rl
+}
+
+func TestRelayListener(t *testing.T) {
+	lg = log.NewDiscardLogger()
+	up := newUpstream(t)
+	defer up.srv.Close()
+	igst := newTestMuxer(t, up.lst.Addr().String(), `upstream`, []string{`default`})
+	defer igst.Close()
+
+	l := &listener{
+		Bind_String:   `127.0.0.1:0`,
+		Ingest_Secret: `downstream`,
+		Tag_Deny:      []string{`debug`},
+		Tag_Rewrite:   []string{`old:new`},
+		Max_Clients:   1,
+	}
+	rl := startRelay(t, igst, l)
+
+	down := newTestMuxer(t, rl.lst.Addr().String(), `downstream`, []string{`syslog`, `debug`, `old`})
+	for _, name := range []string{`syslog`, `debug`, `old`} {
+		tg, err := down.GetTag(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			if err = down.WriteEntry(&entry.Entry{TS: entry.Now(), Tag: tg, Data: []byte(name)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := down.Sync(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if relayed, filtered := rl.Stats(); relayed == 20 && filtered == 10 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := igst.Sync(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500 && up.count(`new`) < 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if up.count(`syslog`) != 10 || up.count(`new`) != 10 || up.count(`debug`) != 0 || up.count(`old`) != 0 {
+		t.Fatalf("bad relayed entries: %v", up.ents)
+	}
+
+	rl.mtx.RLock()
+	if len(rl.clients) != 1 {
+		t.Fatalf("bad client count %d", len(rl.clients))
+	}
+	rl.mtx.RUnlock()
+
+	down.Close()
+	if err := rl.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTenantSecrets(t *testing.T) {
+	l := &listener{Tenant_Secret: []string{`acme:s3:cr3t`, `beta:beta`}, Tenant_Tag_Prefix: true}
+	if ts, err := l.tenants(); err != nil {
+		t.Fatal(err)
+	} else if len(ts) != 2 || ts[0].Tenant != `acme` || ts[0].Secret != `s3:cr3t` {
+		t.Fatalf("bad tenants: %+v", ts)
+	}
+	bad := [][]string{
+		{`nocolon`},
+		{`:secret`},
+		{`acme:`},
+		{`acme:a`, `acme:b`},
+		{`bad tenant:secret`},
+	}
+	for _, b := range bad {
+		l.Tenant_Secret = b
+		if _, err := l.tenants(); err == nil {
+			t.Fatalf("failed to catch bad tenant secrets %v", b)
+		}
+	}
+}
+
+func TestRelayTenants(t *testing.T) {
+	lg = log.NewDiscardLogger()
+	up := newUpstream(t)
+	defer up.srv.Close()
+	igst := newTestMuxer(t, up.lst.Addr().String(), `upstream`, []string{`default`})
+	defer igst.Close()
+
+	l := &listener{
+		Bind_String:       `127.0.0.1:0`,
+		Tenant_Secret:     []string{`acme:acmesecret`, `beta:betasecret`},
+		Tenant_Tag_Prefix: true,
+	}
+	l.Attach_Metadata = []string{`tenant`}
+	rl := startRelay(t, igst, l)
+	defer rl.Close()
+
+	for _, tenant := range []string{`acme`, `beta`} {
+		down := newTestMuxer(t, rl.lst.Addr().String(), tenant+`secret`, []string{`syslog`})
+		tg, err := down.GetTag(`syslog`)
+		if err != nil {
+			t.Fatal(err)
+		} else if err = down.WriteEntry(&entry.Entry{TS: entry.Now(), Tag: tg, Data: []byte(`hello`)}); err != nil {
+			t.Fatal(err)
+		} else if err = down.Sync(5 * time.Second); err != nil {
+			t.Fatal(err)
+		}
+		down.Close()
+	}
+	for i := 0; i < 500; i++ {
+		if relayed, _ := rl.Stats(); relayed == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := igst.Sync(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500 && up.count(`beta-syslog`) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	up.Lock()
+	defer up.Unlock()
+	if len(up.ents[`syslog`]) != 0 {
+		t.Fatal("tenant entries relayed without a prefix")
+	}
+	for _, tenant := range []string{`acme`, `beta`} {
+		if ents := up.ents[tenant+`-syslog`]; len(ents) != 1 || ents[0] != `tenant=`+tenant+` hello` {
+			t.Fatalf("bad entries for %s: %v", tenant, ents)
+		}
+	}
+}
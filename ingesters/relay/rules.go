@@ -0,0 +1,177 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrInvalidRewrite = errors.New("Tag-Rewrite must be <tag or pattern>:<new tag>")
+)
+
+type rewriteRule struct {
+	match string // tag name or path.Match pattern
+	tag   string
+}
+
+// tagRules decide which downstream tags are relayed and what they are called upstream.
+// Deny patterns win over allow patterns, an empty allow list accepts every tag.
+type tagRules struct {
+	allow   []string
+	deny    []string
+	rewrite []rewriteRule
+}
+
+func newTagRules(allow, deny, rewrite []string) (tr *tagRules, err error) {
+	tr = &tagRules{}
+	if tr.allow, err = checkPatterns(`Tag-Allow`, allow); err != nil {
+		return nil, err
+	} else if tr.deny, err = checkPatterns(`Tag-Deny`, deny); err != nil {
+		return nil, err
+	}
+	for _, v := range rewrite {
+		idx := strings.LastIndexByte(v, ':')
+		if idx <= 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRewrite, v)
+		}
+		r := rewriteRule{
+			match: strings.TrimSpace(v[:idx]),
+			tag:   strings.TrimSpace(v[idx+1:]),
+		}
+		if _, err = path.Match(r.match, ``); err != nil {
+			return nil, fmt.Errorf("invalid Tag-Rewrite pattern %q: %v", r.match, err)
+		} else if err = ingest.CheckTag(r.tag); err != nil {
+			return nil, fmt.Errorf("invalid Tag-Rewrite tag %q: %v", r.tag, err)
+		}
+		tr.rewrite = append(tr.rewrite, r)
+	}
+	return
+}
+
+func checkPatterns(name string, vals []string) (r []string, err error) {
+	for _, v := range vals {
+		if v = strings.TrimSpace(v); v == `` {
+			continue
+		} else if _, err = path.Match(v, ``); err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %v", name, v, err)
+		}
+		r = append(r, v)
+	}
+	return
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns the upstream name for a downstream tag, ok is false if the tag is filtered
+func (tr *tagRules) route(name string) (tag string, ok bool) {
+	if len(tr.allow) > 0 && !matchAny(tr.allow, name) {
+		return
+	} else if matchAny(tr.deny, name) {
+		return
+	}
+	for _, r := range tr.rewrite {
+		if m, _ := path.Match(r.match, name); m {
+			return r.tag, true
+		}
+	}
+	return name, true
+}
+
+// quotas hands out rate limiters per client address, every connection from a client shares them
+type quotas struct {
+	sync.Mutex
+	bytesPerSec int64
+	entsPerSec  int
+	clients     map[string]*clientQuota
+}
+
+type clientQuota struct {
+	refs  int
+	bytes *rate.Limiter
+	ents  *rate.Limiter
+}
+
+func newQuotas(bps int64, eps int) *quotas {
+	return &quotas{
+		bytesPerSec: bps / 8,
+		entsPerSec:  eps,
+		clients:     map[string]*clientQuota{},
+	}
+}
+
+func (q *quotas) enabled() bool {
+	return q.bytesPerSec > 0 || q.entsPerSec > 0
+}
+
+// acquire returns the quota for a client, it is nil if no quotas are configured
+func (q *quotas) acquire(client string) (cq *clientQuota) {
+	if !q.enabled() {
+		return
+	}
+	q.Lock()
+	defer q.Unlock()
+	if cq = q.clients[client]; cq == nil {
+		cq = &clientQuota{}
+		if q.bytesPerSec > 0 {
+			cq.bytes = rate.NewLimiter(rate.Limit(q.bytesPerSec), int(q.bytesPerSec))
+		}
+		if q.entsPerSec > 0 {
+			cq.ents = rate.NewLimiter(rate.Limit(q.entsPerSec), q.entsPerSec)
+		}
+		q.clients[client] = cq
+	}
+	cq.refs++
+	return
+}
+
+func (q *quotas) release(client string) {
+	q.Lock()
+	if cq := q.clients[client]; cq != nil {
+		if cq.refs--; cq.refs <= 0 {
+			delete(q.clients, client)
+		}
+	}
+	q.Unlock()
+}
+
+// wait blocks until the client is within its quota, which pushes back on the ingester
+func (cq *clientQuota) wait(ctx context.Context, sz int) (err error) {
+	if cq == nil {
+		return
+	}
+	if cq.ents != nil {
+		if err = cq.ents.Wait(ctx); err != nil {
+			return
+		}
+	}
+	if cq.bytes != nil {
+		//entries larger than a second of quota take the whole bucket
+		if sz > cq.bytes.Burst() {
+			sz = cq.bytes.Burst()
+		}
+		err = cq.bytes.WaitN(ctx, sz)
+	}
+	return
+}
@@ -0,0 +1,383 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// The replay tool re-ingests the files written by the archive preprocessor, or Gravwell JSON
+// exports, keeping the original timestamps, tags, and sources.  It is intended for disaster
+// recovery and backfill.
+//
+//	replay -clear-conns 10.0.0.1 -start 2022-03-01T00:00:00Z -tags 'firewall-*' /opt/gravwell/archive
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/ingesters/args"
+	"github.com/gravwell/gravwell/v3/ingesters/version"
+)
+
+const (
+	jsonExt = `.json`
+	gzExt   = `.gz`
+)
+
+var (
+	ver      = flag.Bool("version", false, "Print version and exit")
+	verbose  = flag.Bool("v", false, "Print every entry as it is replayed")
+	status   = flag.Bool("status", false, "Output ingest rate stats as we go")
+	dryRun   = flag.Bool("dry-run", false, "Read and filter the files without connecting to an indexer")
+	startF   = flag.String("start", "", "Only replay entries at or after this RFC3339 time")
+	endF     = flag.String("end", "", "Only replay entries before this RFC3339 time")
+	tagsF    = flag.String("tags", "", "Comma separated tag names or patterns to replay, default is every tag")
+	tagOvr   = flag.String("tag-override", "", "Replay every entry into this tag")
+	srcOvr   = flag.String("source-override", "", "Override source with address, hash, or integer")
+	skipBad  = flag.Bool("skip-truncated", false, "Keep going when a file ends part way through an entry, as a file being written when a host died will")
+	fileList []string
+
+	count      uint64
+	skipped    uint64
+	totalBytes uint64
+)
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] <archive file or directory>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *ver {
+		version.PrintVersion(os.Stdout)
+		ingest.PrintVersion(os.Stdout)
+		os.Exit(0)
+	}
+}
+
+func main() {
+	debug.SetTraceback("all")
+	flt, err := newFilter(*startF, *endF, *tagsF)
+	if err != nil {
+		log.Fatalf("Invalid filter: %v\n", err)
+	}
+	var srcOverride net.IP
+	if *srcOvr != `` {
+		if srcOverride, err = config.ParseSource(*srcOvr); err != nil {
+			log.Fatalf("Invalid source override: %v\n", err)
+		}
+	}
+	if fileList, err = findFiles(flag.Args()); err != nil {
+		log.Fatal(err)
+	} else if len(fileList) == 0 {
+		log.Fatal("No archive files specified")
+	}
+
+	var wtr entryWriter
+	var igst *ingest.IngestMuxer
+	if *dryRun {
+		wtr = &dryWriter{}
+	} else {
+		a, err := args.Parse()
+		if err != nil {
+			log.Fatalf("Invalid arguments: %v\n", err)
+		}
+		tags := a.Tags
+		if *tagOvr != `` {
+			tags = append(tags, *tagOvr)
+		}
+		igst, err = ingest.NewUniformMuxer(ingest.UniformMuxerConfig{
+			Destinations:    a.Conns,
+			Tags:            tags,
+			Auth:            a.IngestSecret,
+			PublicKey:       a.TLSPublicKey,
+			PrivateKey:      a.TLSPrivateKey,
+			VerifyCert:      a.TLSRemoteVerify,
+			CacheDepth:      config.CACHE_DEPTH_DEFAULT,
+			IngesterName:    `replay`,
+			IngesterVersion: version.GetVersion(),
+		})
+		if err != nil {
+			log.Fatalf("Failed to create new ingest muxer: %v\n", err)
+		}
+		if err := igst.Start(); err != nil {
+			log.Fatalf("Failed to start ingest muxer: %v\n", err)
+		}
+		if err := igst.WaitForHot(a.Timeout); err != nil {
+			log.Fatalf("Failed to wait for hot connection: %v\n", err)
+		}
+		if srcOverride == nil {
+			//entries archived without a source get the address we are replaying from
+			if srcOverride, err = igst.SourceIP(); err != nil {
+				log.Fatalf("Failed to get source IP: %v\n", err)
+			}
+		}
+		wtr = &muxWriter{igst: igst, tags: map[string]entry.EntryTag{}, override: *tagOvr}
+	}
+
+	r := replayer{
+		flt:     flt,
+		wtr:     wtr,
+		src:     srcOverride,
+		srcOnly: *srcOvr != ``,
+	}
+	start := time.Now()
+	var done chan struct{}
+	if *status {
+		done = make(chan struct{})
+		go statusRoutine(done)
+	}
+	for _, p := range fileList {
+		if err = r.replayFile(p); err != nil {
+			if *skipBad && errors.Is(err, io.ErrUnexpectedEOF) {
+				log.Printf("%s is truncated, skipping the rest of it\n", p)
+				continue
+			}
+			break
+		}
+	}
+	if done != nil {
+		close(done)
+	}
+	dur := time.Since(start)
+	if igst != nil {
+		if serr := igst.Sync(time.Minute); serr != nil && err == nil {
+			err = fmt.Errorf("Failed to sync ingest muxer: %v", serr)
+		}
+		if cerr := igst.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("Failed to close the ingest muxer: %v", cerr)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Completed in %v (%s)\n", dur, ingest.HumanSize(totalBytes))
+	fmt.Printf("Total Count: %s\n", ingest.HumanCount(count))
+	fmt.Printf("Filtered Out: %s\n", ingest.HumanCount(skipped))
+	fmt.Printf("Entry Rate: %s\n", ingest.HumanEntryRate(count, dur))
+	fmt.Printf("Ingest Rate: %s\n", ingest.HumanRate(totalBytes, dur))
+}
+
+// findFiles expands directories into the archive and JSON export files they hold.  Archive file
+// names sort by time so files are replayed oldest first.
+func findFiles(paths []string) (r []string, err error) {
+	for _, p := range paths {
+		var fi os.FileInfo
+		if fi, err = os.Stat(p); err != nil {
+			return
+		} else if !fi.IsDir() {
+			r = append(r, p)
+			continue
+		}
+		var found []string
+		err = filepath.Walk(p, func(fp string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			} else if fi.Mode().IsRegular() && replayable(fi.Name()) {
+				found = append(found, fp)
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		sort.Strings(found)
+		r = append(r, found...)
+	}
+	return
+}
+
+func replayable(name string) bool {
+	name = strings.TrimSuffix(name, gzExt)
+	return strings.HasPrefix(name, processors.ArchiveFilePrefix) || strings.HasSuffix(name, jsonExt)
+}
+
+// openFile opens an archive file, or a Gravwell JSON export which shares the ndjson archive format
+func openFile(p string) (*processors.ArchiveReader, io.Closer, error) {
+	name := strings.TrimSuffix(filepath.Base(p), gzExt)
+	if !strings.HasSuffix(name, jsonExt) {
+		ar, err := processors.OpenArchive(p)
+		return ar, ar, err
+	}
+	fin, err := os.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rdr io.Reader = fin
+	if strings.HasSuffix(p, gzExt) {
+		if rdr, err = gzip.NewReader(fin); err != nil {
+			fin.Close()
+			return nil, nil, err
+		}
+	}
+	ar, err := processors.NewArchiveReader(rdr, processors.ArchiveFormatNDJSON)
+	if err != nil {
+		fin.Close()
+		return nil, nil, err
+	}
+	return ar, fin, nil
+}
+
+func statusRoutine(done chan struct{}) {
+	tckr := time.NewTicker(time.Second)
+	defer tckr.Stop()
+	lastts := time.Now()
+	lastcnt := count
+	lastsz := totalBytes
+	for {
+		select {
+		case <-done:
+			fmt.Println("\nDONE")
+			return
+		case <-tckr.C:
+			dur := time.Since(lastts)
+			cnt, sz := count, totalBytes
+			fmt.Printf("\r%s %s                                     ",
+				ingest.HumanEntryRate(cnt-lastcnt, dur),
+				ingest.HumanRate(sz-lastsz, dur))
+			lastts, lastcnt, lastsz = time.Now(), cnt, sz
+		}
+	}
+}
+
+// filter selects the entries to replay by time range and tag
+type filter struct {
+	start, end time.Time
+	tags       []glob.Glob
+}
+
+func newFilter(start, end, tags string) (f filter, err error) {
+	if start != `` {
+		if f.start, err = time.Parse(time.RFC3339Nano, start); err != nil {
+			err = fmt.Errorf("invalid start time %q: %v", start, err)
+			return
+		}
+	}
+	if end != `` {
+		if f.end, err = time.Parse(time.RFC3339Nano, end); err != nil {
+			err = fmt.Errorf("invalid end time %q: %v", end, err)
+			return
+		}
+	}
+	if !f.start.IsZero() && !f.end.IsZero() && !f.end.After(f.start) {
+		err = errors.New("end time must be after the start time")
+		return
+	}
+	for _, t := range strings.Split(tags, `,`) {
+		if t = strings.TrimSpace(t); t == `` {
+			continue
+		}
+		var g glob.Glob
+		if g, err = glob.Compile(t); err != nil {
+			err = fmt.Errorf("invalid tag pattern %q: %v", t, err)
+			return
+		}
+		f.tags = append(f.tags, g)
+	}
+	return
+}
+
+func (f filter) match(ent *entry.Entry, tag string) bool {
+	ts := ent.TS.StandardTime()
+	if !f.start.IsZero() && ts.Before(f.start) {
+		return false
+	} else if !f.end.IsZero() && !ts.Before(f.end) {
+		return false
+	} else if len(f.tags) == 0 {
+		return true
+	}
+	for _, g := range f.tags {
+		if g.Match(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+type entryWriter interface {
+	write(ent *entry.Entry, tag string) error
+}
+
+// muxWriter negotiates tags by name as they show up in the archive
+type muxWriter struct {
+	igst     *ingest.IngestMuxer
+	tags     map[string]entry.EntryTag
+	override string
+}
+
+func (mw *muxWriter) write(ent *entry.Entry, tag string) (err error) {
+	if mw.override != `` {
+		tag = mw.override
+	}
+	tg, ok := mw.tags[tag]
+	if !ok {
+		if tg, err = mw.igst.NegotiateTag(tag); err != nil {
+			return fmt.Errorf("Failed to negotiate tag %q: %v", tag, err)
+		}
+		mw.tags[tag] = tg
+	}
+	ent.Tag = tg
+	return mw.igst.WriteEntry(ent)
+}
+
+type dryWriter struct{}
+
+func (dw *dryWriter) write(ent *entry.Entry, tag string) error {
+	return nil
+}
+
+type replayer struct {
+	flt     filter
+	wtr     entryWriter
+	src     net.IP
+	srcOnly bool // src replaces every source rather than filling in missing ones
+}
+
+func (r *replayer) replayFile(p string) (err error) {
+	ar, clsr, err := openFile(p)
+	if err != nil {
+		return
+	}
+	defer clsr.Close()
+	for {
+		ent, tag, err := ar.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		if !r.flt.match(ent, tag) {
+			skipped++
+			continue
+		}
+		if r.srcOnly || len(ent.SRC) == 0 {
+			ent.SRC = r.src
+		}
+		if *verbose {
+			fmt.Println(ent.TS, tag, ent.SRC, string(ent.Data))
+		}
+		if err = r.wtr.write(ent, tag); err != nil {
+			return err
+		}
+		count++
+		totalBytes += uint64(len(ent.Data))
+	}
+}
@@ -22,7 +22,9 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingesters/netframe"
 	"github.com/gravwell/gravwell/v3/ingesters/version"
 )
 
@@ -42,16 +44,10 @@ var (
 	connSet       []string
 	timeout       time.Duration
 
-	connClosers map[int]closer
-	connId      int
-	mtx         sync.Mutex
+	connTracker = netframe.NewTracker()
 	maxSize     int
 )
 
-type closer interface {
-	Close() error
-}
-
 type ingestUnit struct {
 	igst *ingest.IngestMuxer
 	tag  entry.EntryTag
@@ -130,7 +126,6 @@ func init() {
 		fmt.Printf("No connections were specified\nWe need at least one\n")
 		os.Exit(-1)
 	}
-	connClosers = make(map[int]closer, 1)
 }
 
 func main() {
@@ -194,11 +189,7 @@ mainLoop:
 		}
 	}
 	//kill connections
-	mtx.Lock()
-	for _, v := range connClosers {
-		v.Close()
-	}
-	mtx.Unlock() //must unlock so they can delete their connections
+	connTracker.CloseAll()
 
 	//wait for everyone to exit with a timeout
 	wch := make(chan bool, 1)
@@ -222,25 +213,10 @@ mainLoop:
 	igst.Close()
 }
 
-func addConn(c closer) int {
-	mtx.Lock()
-	connId++
-	id := connId
-	connClosers[connId] = c
-	mtx.Unlock()
-	return id
-}
-
-func delConn(id int) {
-	mtx.Lock()
-	delete(connClosers, id)
-	mtx.Unlock()
-}
-
 func acceptor(l net.Listener, entChan chan *entry.Entry, tag entry.EntryTag, doneChan chan bool, wg *sync.WaitGroup) {
-	id := addConn(l)
+	id := connTracker.Add(l)
 	defer l.Close()
-	defer delConn(id)
+	defer connTracker.Del(id)
 	defer wg.Done()
 	for {
 		c, err := l.Accept()
@@ -254,9 +230,9 @@ func acceptor(l net.Listener, entChan chan *entry.Entry, tag entry.EntryTag, don
 }
 
 func connHandler(c net.Conn, entChan chan *entry.Entry, tag entry.EntryTag, wg *sync.WaitGroup) {
-	id := addConn(c)
+	id := connTracker.Add(c)
 	defer c.Close()
-	defer delConn(id)
+	defer connTracker.Del(id)
 	defer wg.Done()
 
 	//attempt to resolve the remote connection
@@ -265,9 +241,9 @@ func connHandler(c net.Conn, entChan chan *entry.Entry, tag entry.EntryTag, wg *
 		fmt.Println("Failed to split IP from port on", c.RemoteAddr().String(), ":", err)
 		return
 	}
-	src := net.ParseIP(ipS)
-	if src == nil {
-		fmt.Println("Failed to parse IP from", ipS)
+	src, _, err := config.ParseIPZone(ipS)
+	if err != nil {
+		fmt.Println("Failed to parse IP from", ipS, ":", err)
 		return
 	}
 
@@ -0,0 +1,242 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultDedupWindow = 7 * 24 * time.Hour
+
+	dedupKeySize    = 16
+	dedupRecordSize = dedupKeySize + 8 // key and the time it was recorded
+)
+
+type dedupKey [dedupKeySize]byte
+
+// dedupStore remembers content hashes of the entries that were delivered so that re-running an
+// ingest does not send them again.  Hashes cover the tag name, timestamp, and data of each entry.
+// The store is an append only file of hashes and the time they were recorded, hashes older than
+// the window are dropped when the store is loaded.
+//
+// Identical entries are legitimate, so the store counts them, if a prior run sent an entry twice
+// the first two copies in this run are skipped and any more are sent.
+type dedupStore struct {
+	sync.Mutex
+	fout    *os.File
+	prior   map[dedupKey]uint32 // entries sent by previous runs
+	seen    map[dedupKey]uint32 // entries this run has matched against prior
+	pending []dedupKey          // entries sent by this run that haven't been synced
+	skipped uint64
+}
+
+func newDedupStore(pth string, window time.Duration) (ds *dedupStore, err error) {
+	ds = &dedupStore{
+		prior: map[dedupKey]uint32{},
+		seen:  map[dedupKey]uint32{},
+	}
+	var keep []byte
+	if keep, err = ds.load(pth, time.Now().Add(-window)); err != nil {
+		return
+	}
+	//rewrite the store without the expired hashes
+	tmp := pth + `.tmp`
+	if err = os.WriteFile(tmp, keep, 0600); err != nil {
+		return
+	} else if err = os.Rename(tmp, pth); err != nil {
+		return
+	}
+	ds.fout, err = os.OpenFile(pth, os.O_WRONLY|os.O_APPEND, 0600)
+	return
+}
+
+// load reads the existing store, returning the records that are still inside the window.
+// A partial record at the end of the file is from an interrupted write and is ignored.
+func (ds *dedupStore) load(pth string, cutoff time.Time) (keep []byte, err error) {
+	var fin *os.File
+	if fin, err = os.Open(pth); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer fin.Close()
+	rdr := bufio.NewReader(fin)
+	rec := make([]byte, dedupRecordSize)
+	for {
+		if _, err = io.ReadFull(rdr, rec); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			return
+		}
+		if ts := time.Unix(0, int64(binary.LittleEndian.Uint64(rec[dedupKeySize:]))); ts.Before(cutoff) {
+			continue
+		}
+		var k dedupKey
+		copy(k[:], rec)
+		ds.prior[k]++
+		keep = append(keep, rec...)
+	}
+}
+
+func hashEntry(tagName string, ent *entry.Entry) (k dedupKey) {
+	var ts [12]byte
+	binary.LittleEndian.PutUint64(ts[:], uint64(ent.TS.Sec))
+	binary.LittleEndian.PutUint32(ts[8:], uint32(ent.TS.Nsec))
+	h := sha256.New()
+	h.Write([]byte(tagName))
+	h.Write([]byte{0})
+	h.Write(ts[:])
+	h.Write(ent.Data)
+	copy(k[:], h.Sum(nil))
+	return
+}
+
+// check reports whether the entry was already sent by a previous run
+func (ds *dedupStore) check(k dedupKey) (dup bool) {
+	ds.Lock()
+	if ds.seen[k] < ds.prior[k] {
+		ds.seen[k]++
+		ds.skipped++
+		dup = true
+	}
+	ds.Unlock()
+	return
+}
+
+// sent records keys for entries that were handed to the muxer
+func (ds *dedupStore) sent(ks ...dedupKey) {
+	ds.Lock()
+	ds.pending = append(ds.pending, ks...)
+	ds.Unlock()
+}
+
+// commit writes out the entries sent since the last commit, it must only be called once the
+// muxer has synced so that we never record an entry that didn't make it
+func (ds *dedupStore) commit() (err error) {
+	ds.Lock()
+	defer ds.Unlock()
+	if len(ds.pending) == 0 {
+		return
+	}
+	now := uint64(time.Now().UnixNano())
+	buff := make([]byte, 0, len(ds.pending)*dedupRecordSize)
+	var ts [8]byte
+	binary.LittleEndian.PutUint64(ts[:], now)
+	for _, k := range ds.pending {
+		buff = append(buff, k[:]...)
+		buff = append(buff, ts[:]...)
+	}
+	if _, err = ds.fout.Write(buff); err == nil {
+		ds.pending = ds.pending[:0]
+	}
+	return
+}
+
+func (ds *dedupStore) Skipped() uint64 {
+	ds.Lock()
+	defer ds.Unlock()
+	return ds.skipped
+}
+
+func (ds *dedupStore) Close() (err error) {
+	if err = ds.commit(); err != nil {
+		ds.fout.Close()
+		return
+	}
+	return ds.fout.Close()
+}
+
+// dedupWriter drops entries that a previous run already delivered and records the rest.
+// Hashes are only committed to the store when the muxer syncs.
+type dedupWriter struct {
+	entrySink
+	ds *dedupStore
+}
+
+func (dw dedupWriter) key(ent *entry.Entry) dedupKey {
+	name, _ := dw.LookupTag(ent.Tag)
+	return hashEntry(name, ent)
+}
+
+func (dw dedupWriter) WriteEntry(ent *entry.Entry) (err error) {
+	k := dw.key(ent)
+	if dw.ds.check(k) {
+		return
+	}
+	if err = dw.entrySink.WriteEntry(ent); err == nil {
+		dw.ds.sent(k)
+	}
+	return
+}
+
+func (dw dedupWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) (err error) {
+	k := dw.key(ent)
+	if dw.ds.check(k) {
+		return
+	}
+	if err = dw.entrySink.WriteEntryContext(ctx, ent); err == nil {
+		dw.ds.sent(k)
+	}
+	return
+}
+
+func (dw dedupWriter) filter(ents []*entry.Entry) (out []*entry.Entry, ks []dedupKey) {
+	out = ents[:0:0]
+	for _, ent := range ents {
+		if k := dw.key(ent); !dw.ds.check(k) {
+			out = append(out, ent)
+			ks = append(ks, k)
+		}
+	}
+	return
+}
+
+func (dw dedupWriter) WriteBatch(ents []*entry.Entry) (err error) {
+	ents, ks := dw.filter(ents)
+	if len(ents) == 0 {
+		return
+	}
+	if err = dw.entrySink.WriteBatch(ents); err == nil {
+		dw.ds.sent(ks...)
+	}
+	return
+}
+
+func (dw dedupWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) (err error) {
+	ents, ks := dw.filter(ents)
+	if len(ents) == 0 {
+		return
+	}
+	if err = dw.entrySink.WriteBatchContext(ctx, ents); err == nil {
+		dw.ds.sent(ks...)
+	}
+	return
+}
+
+func (dw dedupWriter) Sync(to time.Duration) (err error) {
+	if err = dw.entrySink.Sync(to); err != nil {
+		return
+	} else if err = dw.ds.commit(); err != nil {
+		err = fmt.Errorf("Failed to write dedup file: %w", err)
+	}
+	return
+}
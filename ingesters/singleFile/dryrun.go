@@ -0,0 +1,178 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultDryRunCount = 10
+)
+
+var (
+	errDryRunDone = errors.New("dry run entry limit reached")
+)
+
+// dryRunWriter stands in for the ingest muxer when previewing an ingest.  Tags are resolved
+// locally and the first max entries are printed, after that writes fail with errDryRunDone
+// so the reader stops early.
+type dryRunWriter struct {
+	sync.Mutex
+	out   io.Writer
+	max   int
+	cnt   int
+	tags  map[string]entry.EntryTag
+	names []string
+}
+
+// previewFiles runs the files through timestamp extraction and any preprocessors, printing
+// the first entries instead of sending them anywhere
+func previewFiles(files, fileTags, tags []string) (err error) {
+	var drw *dryRunWriter
+	if drw, err = newDryRunWriter(os.Stdout, *dryRunCount, tags); err != nil {
+		return
+	}
+	for i, f := range files {
+		if drw.done() {
+			break
+		}
+		//hitting the entry limit shows up as an error out of the reader or preprocessors
+		if rep := ingestFile(drw, f, fileTags[i], srcOverride); rep.err != nil && !drw.done() {
+			return fmt.Errorf("%s: %w", f, rep.err)
+		}
+	}
+	log.Printf("Dry run complete, %d entries previewed and nothing was sent\n", drw.count())
+	return
+}
+
+func newDryRunWriter(out io.Writer, max int, tags []string) (drw *dryRunWriter, err error) {
+	drw = &dryRunWriter{
+		out:  out,
+		max:  max,
+		tags: map[string]entry.EntryTag{},
+	}
+	for _, t := range tags {
+		if _, err = drw.NegotiateTag(t); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// done returns true once we have printed everything we were asked for
+func (drw *dryRunWriter) done() bool {
+	drw.Lock()
+	defer drw.Unlock()
+	return drw.cnt >= drw.max
+}
+
+func (drw *dryRunWriter) count() int {
+	drw.Lock()
+	defer drw.Unlock()
+	return drw.cnt
+}
+
+func (drw *dryRunWriter) WriteEntry(ent *entry.Entry) error {
+	drw.Lock()
+	defer drw.Unlock()
+	return drw.write(ent)
+}
+
+func (drw *dryRunWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return drw.WriteEntry(ent)
+}
+
+func (drw *dryRunWriter) WriteBatch(ents []*entry.Entry) (err error) {
+	drw.Lock()
+	defer drw.Unlock()
+	for _, ent := range ents {
+		if err = drw.write(ent); err != nil {
+			break
+		}
+	}
+	return
+}
+
+func (drw *dryRunWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return drw.WriteBatch(ents)
+}
+
+func (drw *dryRunWriter) write(ent *entry.Entry) error {
+	if ent == nil {
+		return nil
+	} else if drw.cnt >= drw.max {
+		return errDryRunDone
+	}
+	drw.cnt++
+	tag := fmt.Sprintf("<unknown tag %d>", ent.Tag)
+	if int(ent.Tag) < len(drw.names) {
+		tag = drw.names[ent.Tag]
+	}
+	src := `-`
+	if ent.SRC != nil {
+		src = ent.SRC.String()
+	}
+	fmt.Fprintf(drw.out, "%s %s %s %s\n", ent.TS.StandardTime().Format(time.RFC3339Nano), tag, src, ent.Data)
+	return nil
+}
+
+func (drw *dryRunWriter) NegotiateTag(name string) (tg entry.EntryTag, err error) {
+	if err = ingest.CheckTag(name); err != nil {
+		return
+	}
+	drw.Lock()
+	defer drw.Unlock()
+	var ok bool
+	if tg, ok = drw.tags[name]; !ok {
+		tg = entry.EntryTag(len(drw.names))
+		drw.tags[name] = tg
+		drw.names = append(drw.names, name)
+	}
+	return
+}
+
+func (drw *dryRunWriter) GetTag(name string) (tg entry.EntryTag, err error) {
+	drw.Lock()
+	defer drw.Unlock()
+	var ok bool
+	if tg, ok = drw.tags[name]; !ok {
+		err = ingest.ErrTagNotFound
+	}
+	return
+}
+
+func (drw *dryRunWriter) LookupTag(tg entry.EntryTag) (name string, ok bool) {
+	drw.Lock()
+	defer drw.Unlock()
+	if ok = int(tg) < len(drw.names); ok {
+		name = drw.names[tg]
+	}
+	return
+}
+
+func (drw *dryRunWriter) KnownTags() []string {
+	drw.Lock()
+	defer drw.Unlock()
+	return append([]string(nil), drw.names...)
+}
+
+func (drw *dryRunWriter) Sync(time.Duration) error {
+	return nil
+}
@@ -0,0 +1,196 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+)
+
+const stdinPath = `-`
+
+var (
+	ErrNoInputFiles = errors.New("No input files matched")
+)
+
+// fileReport tracks what was ingested from a single input file
+type fileReport struct {
+	path  string
+	tag   string
+	lines uint64
+	bytes uint64
+	dur   time.Duration
+	note  string // set when the file was skipped or resumed
+	err   error
+}
+
+// tagFields are the values available to a -tag-template
+type tagFields struct {
+	Path string // full path to the file
+	Dir  string // name of the directory containing the file
+	Base string // file name
+	Name string // file name without the extension
+	Ext  string // file extension without the leading dot
+}
+
+func splitPatterns(v string) (pats []string, err error) {
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p == `` {
+			continue
+		}
+		//check that the pattern is well formed up front, Match only complains when it hits the bad part
+		if _, err = filepath.Match(p, ``); err != nil {
+			err = fmt.Errorf("invalid pattern %q: %w", p, err)
+			return
+		}
+		pats = append(pats, p)
+	}
+	return
+}
+
+// matchAny checks patterns against both the file name and the full path
+func matchAny(pats []string, pth string) bool {
+	base := filepath.Base(pth)
+	for _, p := range pats {
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		} else if ok, _ = filepath.Match(p, pth); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func wanted(pth string, include, exclude []string) bool {
+	if len(include) > 0 && !matchAny(include, pth) {
+		return false
+	}
+	return !matchAny(exclude, pth)
+}
+
+// findInputs expands the input specification into a sorted list of files.  The input may be
+// stdin, a single file, a directory which is walked recursively, a glob, or a remote URL.  Include
+// and exclude patterns apply to files found by walking directories, expanding globs, or listing
+// an S3 prefix.
+func findInputs(in string, include, exclude []string) (files []string, err error) {
+	if in == stdinPath {
+		files = []string{stdinPath}
+		return
+	} else if isRemote(in) {
+		return findRemoteInputs(in, include, exclude)
+	}
+	var paths []string
+	if fi, lerr := os.Stat(in); lerr == nil {
+		if !fi.IsDir() {
+			//explicitly named files are always ingested
+			files = []string{in}
+			return
+		}
+		paths = []string{in}
+	} else if !os.IsNotExist(lerr) {
+		err = lerr
+		return
+	} else if paths, err = filepath.Glob(in); err != nil {
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, p := range paths {
+		err = filepath.Walk(p, func(pth string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			} else if !fi.Mode().IsRegular() || seen[pth] || !wanted(pth, include, exclude) {
+				return nil
+			}
+			seen[pth] = true
+			files = append(files, pth)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	if len(files) == 0 {
+		err = ErrNoInputFiles
+		return
+	}
+	sort.Strings(files)
+	return
+}
+
+func newTagTemplate(v string) (tmpl *template.Template, err error) {
+	if v = strings.TrimSpace(v); v == `` {
+		return
+	}
+	tmpl, err = template.New(`tag`).Option(`missingkey=error`).Parse(v)
+	return
+}
+
+// renderTag produces the tag for a file, files without a template or read from stdin use the default tag.
+func renderTag(tmpl *template.Template, pth, def string) (tag string, err error) {
+	if tmpl == nil || pth == stdinPath {
+		tag = def
+		return
+	}
+	lpth := pth
+	if isRemote(pth) {
+		lpth = remotePath(pth)
+	}
+	base := filepath.Base(lpth)
+	ext := filepath.Ext(base)
+	tf := tagFields{
+		Path: pth,
+		Dir:  filepath.Base(filepath.Dir(lpth)),
+		Base: base,
+		Name: strings.TrimSuffix(base, ext),
+		Ext:  strings.TrimPrefix(ext, `.`),
+	}
+	bb := bytes.NewBuffer(nil)
+	if err = tmpl.Execute(bb, tf); err != nil {
+		return
+	}
+	tag = strings.TrimSpace(bb.String())
+	if err = ingest.CheckTag(tag); err != nil {
+		err = fmt.Errorf("tag %q generated for %s is invalid: %w", tag, pth, err)
+	}
+	return
+}
+
+// writeReport prints a per file breakdown of what was ingested
+func writeReport(w io.Writer, reports []fileReport) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "FILE\tTAG\tLINES\tBYTES\tDURATION\tSTATUS\n")
+	var lines, bts uint64
+	var failed int
+	for _, r := range reports {
+		status := `ok`
+		if r.err != nil {
+			status = r.err.Error()
+			failed++
+		} else if r.note != `` {
+			status = r.note
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%v\t%s\n", r.path, r.tag, r.lines, ingest.HumanSize(r.bytes), r.dur.Round(time.Millisecond), status)
+		lines += r.lines
+		bts += r.bytes
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d files (%d failed), %d lines, %s\n", len(reports), failed, lines, ingest.HumanSize(bts))
+}
@@ -9,6 +9,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -31,7 +32,10 @@ import (
 var (
 	tso         = flag.String("timestamp-override", "", "Timestamp override")
 	tzo         = flag.String("timezone-override", "", "Timezone override e.g. America/Chicago")
-	inFile      = flag.String("i", "", "Input file to process (specify - for stdin)")
+	inFile      = flag.String("i", "", "Input file, directory, glob, s3:// or http(s):// URL to process (specify - for stdin)")
+	includePats = flag.String("include", "", "Comma separated patterns of files to ingest when walking directories or globs")
+	excludePats = flag.String("exclude", "", "Comma separated patterns of files to skip when walking directories or globs")
+	tagTmpl     = flag.String("tag-template", "", "Per file tag template, e.g. {{.Dir}} or {{.Name}}")
 	ver         = flag.Bool("version", false, "Print version and exit")
 	utc         = flag.Bool("utc", false, "Assume UTC time")
 	ignoreTS    = flag.Bool("ignore-ts", false, "Ignore timetamp")
@@ -42,6 +46,18 @@ var (
 	blockSize   = flag.Int("block-size", 0, "Optimized ingest using blocks, 0 disables")
 	status      = flag.Bool("status", false, "Output ingest rate stats as we go")
 	srcOvr      = flag.String("source-override", "", "Override source with address, hash, or integeter")
+	workers     = flag.Int("workers", 1, "Number of goroutines extracting timestamps, values greater than 1 process each file in parallel")
+	ordered     = flag.Bool("ordered", false, "Preserve input order when using multiple workers")
+	stateFile   = flag.String("state-file", "", "Record per file progress so an interrupted ingest can be resumed")
+	progress    = flag.Bool("progress", false, "Periodically print progress and an ETA to stderr, draws a progress bar on a terminal")
+	progIntv    = flag.String("progress-interval", defaultProgressInterval.String(), "Interval between progress updates")
+	stateIntv   = flag.String("state-interval", defaultStateInterval.String(), "Interval between state file checkpoints")
+	ppConfig    = flag.String("preprocessor-config", "", "Config file with preprocessors to apply to every entry")
+	dryRun      = flag.Bool("dry-run", false, "Print the first entries that would be ingested without connecting to an indexer")
+	dryRunCount = flag.Int("dry-run-count", defaultDryRunCount, "Number of entries to print in a dry run")
+	dedup       = flag.Bool("dedup", false, "Skip entries that a previous run already sent, making re-runs idempotent")
+	dedupFile   = flag.String("dedup-file", "", "File to record sent entry hashes in, defaults to the state file with a .dedup suffix")
+	dedupWindow = flag.String("dedup-window", defaultDedupWindow.String(), "How long sent entry hashes are remembered")
 
 	count            uint64
 	totalBytes       uint64
@@ -51,9 +67,26 @@ var (
 	ignorePrefixFlag bool
 	ignorePrefix     []byte
 	srcOverride      net.IP
+	resume           *resumeState
+	prog             *progressReport
+	ppCfg            *preprocessorConfig
+	dedupSt          *dedupStore
 )
 
-func init() {
+// entrySink is where ingested entries go, either the ingest muxer or a dry run preview
+type entrySink interface {
+	WriteEntry(*entry.Entry) error
+	WriteEntryContext(context.Context, *entry.Entry) error
+	WriteBatch([]*entry.Entry) error
+	WriteBatchContext(context.Context, []*entry.Entry) error
+	NegotiateTag(string) (entry.EntryTag, error)
+	LookupTag(entry.EntryTag) (string, bool)
+	KnownTags() []string
+	GetTag(string) (entry.EntryTag, error)
+	Sync(time.Duration) error
+}
+
+func mainInit() {
 	flag.Parse()
 	if *ver {
 		version.PrintVersion(os.Stdout)
@@ -74,16 +107,32 @@ func init() {
 
 func main() {
 	debug.SetTraceback("all")
+	mainInit()
 	if *inFile == "" {
 		log.Fatal("Input file path required")
 	}
-	a, err := args.Parse()
-	if err != nil {
+	var a args.Args
+	var err error
+	if *dryRun {
+		//a dry run never talks to an indexer, so only the tag is needed
+		if a, err = args.ParseTags(); err != nil {
+			log.Fatalf("Invalid arguments: %v\n", err)
+		} else if *dryRunCount <= 0 {
+			log.Fatal("Dry run count must be greater than zero")
+		} else if *stateFile != `` || *progress || *status || *dedup {
+			log.Fatal("Dry run cannot be used with state files, dedup, progress, or status output")
+		}
+	} else if a, err = args.Parse(); err != nil {
 		log.Fatalf("Invalid arguments: %v\n", err)
 	}
 	if len(a.Tags) != 1 {
 		log.Fatal("File oneshot only accepts a single tag")
 	}
+	if *ppConfig != `` {
+		if ppCfg, err = loadPreprocessorConfig(*ppConfig); err != nil {
+			log.Fatalf("Invalid preprocessor config %s: %v\n", *ppConfig, err)
+		}
+	}
 
 	//resolve the timestmap override if there is one
 	if *tso != "" {
@@ -92,46 +141,96 @@ func main() {
 		}
 	}
 
-	if *srcOvr != `` {
-		if srcOverride, err = config.ParseSource(*srcOvr); err != nil {
-			log.Fatal("Invalid source override")
+	if *workers < 1 {
+		log.Fatal("Workers must be at least 1")
+	} else if *ordered && *workers == 1 {
+		log.Println("Ordered has no effect with a single worker")
+	}
+
+	if *stateFile != `` {
+		intv, err := time.ParseDuration(*stateIntv)
+		if err != nil || intv <= 0 {
+			log.Fatalf("Invalid state interval %q\n", *stateIntv)
+		} else if resume, err = newResumeState(*stateFile, intv, a.Timeout); err != nil {
+			log.Fatalf("Failed to load state file: %v\n", err)
 		}
 	}
-	var tg *timegrinder.TimeGrinder
-	if !noTg {
-		//build a new timegrinder
-		c := timegrinder.Config{
-			EnableLeftMostSeed: true,
-			FormatOverride:     *tso,
+
+	if *dedup {
+		pth := *dedupFile
+		if pth == `` {
+			if *stateFile == `` {
+				log.Fatal("Dedup requires a dedup file or a state file")
+			}
+			pth = *stateFile + `.dedup`
+		}
+		window, err := time.ParseDuration(*dedupWindow)
+		if err != nil || window <= 0 {
+			log.Fatalf("Invalid dedup window %q\n", *dedupWindow)
+		} else if dedupSt, err = newDedupStore(pth, window); err != nil {
+			log.Fatalf("Failed to load dedup file: %v\n", err)
 		}
+	} else if *dedupFile != `` {
+		log.Fatal("Dedup file requires -dedup")
+	}
 
-		if tg, err = timegrinder.NewTimeGrinder(c); err != nil {
-			log.Fatalf("failed to build timegrinder: %v\n", err)
+	var progressIntv time.Duration
+	if *progress {
+		if *status {
+			log.Fatal("Status and progress cannot be used together")
+		} else if progressIntv, err = time.ParseDuration(*progIntv); err != nil || progressIntv <= 0 {
+			log.Fatalf("Invalid progress interval %q\n", *progIntv)
 		}
-		if *utc {
-			tg.SetUTC()
+	}
+
+	if *srcOvr != `` {
+		if srcOverride, err = config.ParseSource(*srcOvr); err != nil {
+			log.Fatal("Invalid source override")
 		}
+	}
+	//make sure the timegrinder settings are sane before we go looking for files
+	if _, err = newTimegrinder(); err != nil {
+		log.Fatalf("Invalid timestamp settings: %v\n", err)
+	}
+	include, err := splitPatterns(*includePats)
+	if err != nil {
+		log.Fatalf("Invalid include patterns: %v\n", err)
+	}
+	exclude, err := splitPatterns(*excludePats)
+	if err != nil {
+		log.Fatalf("Invalid exclude patterns: %v\n", err)
+	}
+	tmpl, err := newTagTemplate(*tagTmpl)
+	if err != nil {
+		log.Fatalf("Invalid tag template: %v\n", err)
+	}
+	files, err := findInputs(*inFile, include, exclude)
+	if err != nil {
+		log.Fatalf("Failed to find input files from %s: %v\n", *inFile, err)
+	}
 
-		if *tzo != `` {
-			if err = tg.SetTimezone(*tzo); err != nil {
-				log.Fatalf("Failed to set timegrinder timezeon: %v\n", err)
-			}
+	//resolve the tag for every file up front so the muxer knows about all of them
+	tags := []string{}
+	fileTags := make([]string, len(files))
+	seenTags := map[string]bool{}
+	for i, f := range files {
+		if fileTags[i], err = renderTag(tmpl, f, a.Tags[0]); err != nil {
+			log.Fatalf("Failed to generate tag: %v\n", err)
+		} else if !seenTags[fileTags[i]] {
+			seenTags[fileTags[i]] = true
+			tags = append(tags, fileTags[i])
 		}
 	}
 
-	//get a handle on the input file with a wrapped decompressor if needed
-	var fin io.ReadCloser
-	if *inFile == "-" {
-		fin = os.Stdin
-	} else {
-		fin, err = utils.OpenBufferedFileReader(*inFile, 8192)
-		if err != nil {
-			log.Fatalf("Failed to open %s: %v\n", *inFile, err)
+	if *dryRun {
+		if err = previewFiles(files, fileTags, tags); err != nil {
+			log.Fatalf("Dry run failed: %v\n", err)
 		}
+		return
 	}
 
 	//fire up a uniform muxer
-	igst, err := ingest.NewUniformIngestMuxer(a.Conns, a.Tags, a.IngestSecret, a.TLSPublicKey, a.TLSPrivateKey, "")
+	igst, err := ingest.NewUniformIngestMuxer(a.Conns, tags, a.IngestSecret, a.TLSPublicKey, a.TLSPrivateKey, "")
 	if err != nil {
 		log.Fatalf("Failed to create new ingest muxer: %v\n", err)
 	}
@@ -141,44 +240,191 @@ func main() {
 	if err := igst.WaitForHot(a.Timeout); err != nil {
 		log.Fatalf("Failed to wait for hot connection: %v\n", err)
 	}
-	tag, err := igst.GetTag(a.Tags[0])
-	if err != nil {
-		log.Fatalf("Failed to resolve tag %s: %v\n", a.Tags[0], err)
-	}
 
 	src := srcOverride
 	if src == nil {
 		src, _ = igst.SourceIP()
 	}
+	var sink entrySink = igst
+	if dedupSt != nil {
+		sink = dedupWriter{entrySink: igst, ds: dedupSt}
+	}
 
-	//go ingest the file
-	if err := doIngest(fin, igst, tag, tg, src); err != nil {
-		log.Fatalf("Failed to ingest file: %v\n", err)
+	//go ingest the files
+	var failed int
+	reports := make([]fileReport, 0, len(files))
+	if *progress {
+		prog = newProgressReport(igst, files, progressIntv)
+		prog.start()
+	}
+	start := time.Now()
+	for i, f := range files {
+		rep := ingestFile(sink, f, fileTags[i], src)
+		if rep.err != nil {
+			if len(files) == 1 {
+				log.Fatalf("Failed to ingest file: %v\n", rep.err)
+			}
+			log.Printf("Failed to ingest %s: %v\n", f, rep.err)
+			failed++
+		}
+		reports = append(reports, rep)
+	}
+	dur = time.Since(start)
+	if prog != nil {
+		prog.stop()
 	}
 
-	if err = igst.Sync(a.Timeout); err != nil {
+	if err = sink.Sync(a.Timeout); err != nil {
 		log.Fatalf("Failed to sync ingest muxer: %v\n", err)
 	}
+	if dedupSt != nil {
+		if err = dedupSt.Close(); err != nil {
+			log.Fatalf("Failed to close the dedup file: %v\n", err)
+		}
+	}
 	if err := igst.Close(); err != nil {
 		log.Fatalf("Failed to close the ingest muxer: %v\n", err)
 	}
-	if err := fin.Close(); err != nil {
-		log.Fatalf("Failed to close the input file: %v\n", err)
+	if len(files) > 1 {
+		writeReport(os.Stdout, reports)
 	}
 	fmt.Printf("Completed in %v (%s)\n", dur, ingest.HumanSize(totalBytes))
 	fmt.Printf("Total Count: %s\n", ingest.HumanCount(count))
 	fmt.Printf("Entry Rate: %s\n", ingest.HumanEntryRate(count, dur))
 	fmt.Printf("Ingest Rate: %s\n", ingest.HumanRate(totalBytes, dur))
+	if dedupSt != nil {
+		fmt.Printf("Duplicates Skipped: %s\n", ingest.HumanCount(dedupSt.Skipped()))
+	}
+	if failed > 0 {
+		log.Fatalf("Failed to ingest %d of %d files\n", failed, len(files))
+	}
+}
+
+func newTimegrinder() (tg *timegrinder.TimeGrinder, err error) {
+	if noTg {
+		return
+	}
+	//build a new timegrinder
+	c := timegrinder.Config{
+		EnableLeftMostSeed: true,
+		FormatOverride:     *tso,
+	}
+	if tg, err = timegrinder.NewTimeGrinder(c); err != nil {
+		err = fmt.Errorf("failed to build timegrinder: %w", err)
+		return
+	}
+	if *utc {
+		tg.SetUTC()
+	}
+	if *tzo != `` {
+		if err = tg.SetTimezone(*tzo); err != nil {
+			err = fmt.Errorf("Failed to set timegrinder timezone: %w", err)
+		}
+	}
+	return
+}
+
+// ingestFile pushes a single file, each file gets its own timegrinder so that
+// files with different timestamp formats don't fight over the seed
+func ingestFile(igst entrySink, pth, tagName string, src net.IP) (rep fileReport) {
+	rep.path, rep.tag = pth, tagName
+	startCount, startBytes := count, totalBytes
+	start := time.Now()
+	defer func() {
+		rep.lines, rep.bytes = count-startCount, totalBytes-startBytes
+		rep.dur = time.Since(start)
+	}()
+
+	tag, err := igst.GetTag(tagName)
+	if err != nil {
+		rep.err = fmt.Errorf("Failed to resolve tag %s: %w", tagName, err)
+		return
+	}
+	tg, err := newTimegrinder()
+	if err != nil {
+		rep.err = err
+		return
+	}
+
+	//stdin and remote objects can't be resumed, so they are never tracked
+	var rf *resumeFile
+	if resume != nil && pth != stdinPath && !isRemote(pth) {
+		if rf, err = resume.open(igst, pth); err != nil {
+			rep.err = fmt.Errorf("Failed to check state for %s: %w", pth, err)
+			return
+		} else if rf.fs.Done {
+			rep.note = `skipped, already ingested`
+			if prog != nil {
+				prog.fileSkipped(pth)
+			}
+			return
+		} else if rf.fs.Offset > 0 {
+			rep.note = fmt.Sprintf("resumed at offset %d", rf.fs.Offset)
+			log.Printf("Resuming %s at offset %d\n", pth, rf.fs.Offset)
+		}
+	}
+
+	//get a handle on the input file with a wrapped decompressor if needed
+	var fin io.ReadCloser
+	var raw *int64
+	if prog != nil {
+		raw = prog.fileCounter()
+		defer prog.fileDone(pth)
+	}
+	switch {
+	case pth == stdinPath:
+		fin = os.Stdin
+	case isRemote(pth):
+		//remote objects are streamed straight through the decompressors
+		var rc io.ReadCloser
+		if rc, err = openRemote(pth); err == nil {
+			fin, err = utils.OpenStreamArchiveReader(rc, 8192, raw)
+		}
+	default:
+		fin, err = utils.OpenTrackedArchiveReader(pth, 8192, raw)
+	}
+	if err != nil {
+		rep.err = fmt.Errorf("Failed to open %s: %w", pth, err)
+		return
+	}
+	if rf != nil {
+		if err = rf.skip(fin); err != nil {
+			fin.Close()
+			rep.err = fmt.Errorf("Failed to resume %s: %w", pth, err)
+			return
+		}
+	}
+	if rep.err = doIngest(fin, igst, tag, tg, src, rf); rep.err != nil {
+		fin.Close()
+	} else if err = fin.Close(); err != nil {
+		rep.err = fmt.Errorf("Failed to close the input file: %w", err)
+	} else if rf != nil {
+		rep.err = rf.complete()
+	}
+	return
 }
 
-func doIngest(fin io.Reader, igst *ingest.IngestMuxer, tag entry.EntryTag, tg *timegrinder.TimeGrinder, src net.IP) (err error) {
+func doIngest(fin io.Reader, igst entrySink, tag entry.EntryTag, tg *timegrinder.TimeGrinder, src net.IP, rf *resumeFile) (err error) {
 	var ignore [][]byte
 	if ignorePrefixFlag {
 		ignore = [][]byte{ignorePrefix}
 	}
+	var proc *processors.ProcessorSet
+	if prog != nil {
+		igst = progressWriter{entrySink: igst, pr: prog}
+	}
+	if proc, err = ppCfg.processorSet(igst); err != nil {
+		return fmt.Errorf("Failed to build preprocessors: %w", err)
+	}
+	defer func() {
+		//flush anything still held by the preprocessors
+		if lerr := proc.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}()
 	cfg := utils.LineDelimitedStream{
 		Rdr:            fin,
-		Proc:           processors.NewProcessorSet(igst),
+		Proc:           proc,
 		Tag:            tag,
 		SRC:            src,
 		TG:             tg,
@@ -187,13 +433,21 @@ func doIngest(fin io.Reader, igst *ingest.IngestMuxer, tag entry.EntryTag, tg *t
 		BatchSize:      *blockSize,
 		Verbose:        *verbose,
 		Quotable:       *quotable,
+		Workers:        *workers,
+		Ordered:        *ordered,
+	}
+	if tg != nil {
+		cfg.NewTG = newTimegrinder
+	}
+	if rf != nil {
+		cfg.Progress = rf.progress(proc)
 	}
 	//if not doing regular updates, just fire it off
 	if !*status {
-		c, b, err := utils.IngestLineDelimitedStream(cfg)
+		c, b, lerr := utils.IngestLineDelimitedStream(cfg)
 		count += c
 		totalBytes += b
-		return err
+		return lerr
 	}
 
 	errCh := make(chan error, 1)
@@ -0,0 +1,55 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+// preprocessorConfig is loaded from the file given with -preprocessor-config, it uses the same
+// preprocessor sections as the ingester configs.  The Global section lists the preprocessors to
+// apply in order:
+//
+//	[Global]
+//		Preprocessor=timestamps
+//	[Preprocessor "timestamps"]
+//		Type=regextimestamp
+//		...
+type preprocessorConfig struct {
+	Global struct {
+		Preprocessor []string
+	}
+	Preprocessor processors.ProcessorConfig
+}
+
+func loadPreprocessorConfig(pth string) (pc *preprocessorConfig, err error) {
+	pc = &preprocessorConfig{}
+	if err = config.LoadConfigFile(pc, pth); err != nil {
+		return
+	} else if len(pc.Global.Preprocessor) == 0 {
+		err = errors.New("No preprocessors listed in the Global section")
+	} else if err = pc.Preprocessor.Validate(); err != nil {
+		return
+	} else if err = pc.Preprocessor.CheckProcessors(pc.Global.Preprocessor); err != nil {
+		return
+	}
+	return
+}
+
+// processorSet builds a preprocessor set that writes to wtr, with no configuration entries
+// go straight to wtr
+func (pc *preprocessorConfig) processorSet(wtr entrySink) (*processors.ProcessorSet, error) {
+	if pc == nil {
+		return processors.NewProcessorSet(wtr), nil
+	}
+	return pc.Preprocessor.ProcessorSet(wtr, pc.Global.Preprocessor)
+}
@@ -0,0 +1,236 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+const (
+	defaultProgressInterval = 5 * time.Second
+	progressBarWidth        = 30
+)
+
+// progressReport periodically prints how far through the inputs we are.  Position is measured in
+// bytes read from disk so that compressed inputs give a sensible ETA.
+type progressReport struct {
+	//counters are accessed atomically, keep them first for alignment
+	done    int64 // raw bytes in files that are finished
+	cur     int64 // raw bytes read from the current file
+	total   int64 // raw bytes across all inputs, zero if unknown
+	ents    uint64
+	bytes   uint64
+	igst    *ingest.IngestMuxer
+	out     io.Writer
+	tty     bool
+	intv    time.Duration
+	began   time.Time
+	lastPos int64
+	lastTS  time.Time
+	die     chan bool
+	wg      sync.WaitGroup
+}
+
+func newProgressReport(igst *ingest.IngestMuxer, files []string, intv time.Duration) (pr *progressReport) {
+	pr = &progressReport{
+		igst: igst,
+		out:  os.Stderr,
+		tty:  isTerminal(os.Stderr),
+		intv: intv,
+		die:  make(chan bool),
+	}
+	for _, f := range files {
+		if f == stdinPath || isRemote(f) {
+			//no idea how big stdin or a remote object is, so no percentages or ETA
+			pr.total = 0
+			return
+		}
+		if fi, err := os.Stat(f); err == nil {
+			pr.total += fi.Size()
+		}
+	}
+	return
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && (fi.Mode()&os.ModeCharDevice) != 0
+}
+
+func (pr *progressReport) start() {
+	pr.began, pr.lastTS = time.Now(), time.Now()
+	pr.wg.Add(1)
+	go pr.routine()
+}
+
+// stop prints a final update and shuts down the reporting routine
+func (pr *progressReport) stop() {
+	close(pr.die)
+	pr.wg.Wait()
+	pr.print()
+	if pr.tty {
+		fmt.Fprintln(pr.out)
+	}
+}
+
+func (pr *progressReport) routine() {
+	defer pr.wg.Done()
+	tckr := time.NewTicker(pr.intv)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-tckr.C:
+			pr.print()
+		case <-pr.die:
+			return
+		}
+	}
+}
+
+// fileCounter is handed to the file reader to tally the raw bytes read from the current file
+func (pr *progressReport) fileCounter() *int64 {
+	atomic.StoreInt64(&pr.cur, 0)
+	return &pr.cur
+}
+
+// fileSkipped drops a file that doesn't need to be read from the total, counting it as
+// done would make the rate and ETA look much better than they are
+func (pr *progressReport) fileSkipped(pth string) {
+	if fi, err := os.Stat(pth); err == nil && atomic.LoadInt64(&pr.total) > 0 {
+		atomic.AddInt64(&pr.total, -fi.Size())
+	}
+}
+
+// fileDone rolls a finished file into the completed total
+func (pr *progressReport) fileDone(pth string) {
+	if pth == stdinPath {
+		atomic.StoreInt64(&pr.cur, 0)
+		return
+	} else if isRemote(pth) {
+		//remote objects are only ever streamed, so what was read is all we know
+		atomic.AddInt64(&pr.done, atomic.SwapInt64(&pr.cur, 0))
+		return
+	}
+	atomic.StoreInt64(&pr.cur, 0)
+	if fi, err := os.Stat(pth); err == nil {
+		atomic.AddInt64(&pr.done, fi.Size())
+	}
+}
+
+func (pr *progressReport) position() int64 {
+	return atomic.LoadInt64(&pr.done) + atomic.LoadInt64(&pr.cur)
+}
+
+func (pr *progressReport) print() {
+	now := time.Now()
+	pos := pr.position()
+	ents := atomic.LoadUint64(&pr.ents)
+	bts := atomic.LoadUint64(&pr.bytes)
+	total := atomic.LoadInt64(&pr.total)
+	queued, capacity := pr.igst.QueueDepth()
+
+	//current rate is since the last update, the ETA uses the average so it doesn't bounce around
+	var delta uint64
+	if pos > pr.lastPos {
+		delta = uint64(pos - pr.lastPos)
+	}
+	rate := ingest.HumanRate(delta, now.Sub(pr.lastTS))
+	pr.lastPos, pr.lastTS = pos, now
+
+	var pct float64
+	eta := `unknown`
+	if total > 0 {
+		if pct = float64(pos) / float64(total); pct > 1 {
+			pct = 1
+		}
+		if elapsed := now.Sub(pr.began); pos > 0 && elapsed > 0 {
+			remain := time.Duration(float64(total-pos) / (float64(pos) / float64(elapsed)))
+			if remain < 0 {
+				remain = 0
+			}
+			eta = remain.Round(time.Second).String()
+		}
+	}
+	var qpct float64
+	if capacity > 0 {
+		qpct = 100 * float64(queued) / float64(capacity)
+	}
+
+	if pr.tty {
+		bar := ``
+		if total > 0 {
+			n := int(pct * progressBarWidth)
+			bar = fmt.Sprintf("[%s%s] %5.1f%% ", strings.Repeat("#", n), strings.Repeat(".", progressBarWidth-n), pct*100)
+		}
+		fmt.Fprintf(pr.out, "\r%s%s %s entries %s queue %.0f%% ETA %s   ",
+			bar, ingest.HumanSize(uint64(pos)), ingest.HumanCount(ents), rate, qpct, eta)
+		return
+	}
+	if total > 0 {
+		fmt.Fprintf(pr.out, "progress: %s of %s (%.1f%%) read, %s entries (%s) sent, %s, queue %.0f%%, ETA %s\n",
+			ingest.HumanSize(uint64(pos)), ingest.HumanSize(uint64(total)), pct*100,
+			ingest.HumanCount(ents), ingest.HumanSize(bts), rate, qpct, eta)
+	} else {
+		fmt.Fprintf(pr.out, "progress: %s read, %s entries (%s) sent, %s, queue %.0f%%\n",
+			ingest.HumanSize(uint64(pos)), ingest.HumanCount(ents), ingest.HumanSize(bts), rate, qpct)
+	}
+}
+
+// progressWriter counts entries on their way to the muxer
+type progressWriter struct {
+	entrySink
+	pr *progressReport
+}
+
+func (pw progressWriter) count(ents ...*entry.Entry) {
+	var sz uint64
+	for _, ent := range ents {
+		sz += uint64(len(ent.Data))
+	}
+	atomic.AddUint64(&pw.pr.ents, uint64(len(ents)))
+	atomic.AddUint64(&pw.pr.bytes, sz)
+}
+
+func (pw progressWriter) WriteEntry(ent *entry.Entry) (err error) {
+	if err = pw.entrySink.WriteEntry(ent); err == nil {
+		pw.count(ent)
+	}
+	return
+}
+
+func (pw progressWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) (err error) {
+	if err = pw.entrySink.WriteEntryContext(ctx, ent); err == nil {
+		pw.count(ent)
+	}
+	return
+}
+
+func (pw progressWriter) WriteBatch(ents []*entry.Entry) (err error) {
+	if err = pw.entrySink.WriteBatch(ents); err == nil {
+		pw.count(ents...)
+	}
+	return
+}
+
+func (pw progressWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) (err error) {
+	if err = pw.entrySink.WriteBatchContext(ctx, ents); err == nil {
+		pw.count(ents...)
+	}
+	return
+}
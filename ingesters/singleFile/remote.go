@@ -0,0 +1,226 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	s3Scheme    = `s3`
+	httpScheme  = `http`
+	httpsScheme = `https`
+)
+
+var (
+	s3Region    = flag.String("s3-region", "", "Region for s3:// inputs, defaults to the AWS environment and shared config")
+	s3Endpoint  = flag.String("s3-endpoint", "", "Endpoint for S3 compatible object stores")
+	s3AccessKey = flag.String("s3-access-key", "", "Access key ID for s3:// inputs, defaults to the AWS environment and shared credentials")
+	s3SecretKey = flag.String("s3-secret-key", "", "Secret access key for s3:// inputs")
+	httpHeaders = newHeaderFlag("http-header", "Header to send with http(s):// requests, e.g. \"Authorization: Bearer XYZ\", may be repeated")
+
+	ErrInvalidS3URL   = errors.New("S3 URLs must be of the form s3://bucket/key or s3://bucket/prefix/")
+	ErrInvalidHeader  = errors.New("HTTP headers must be of the form \"Name: value\"")
+	ErrS3Credentials  = errors.New("S3 access key and secret key must be specified together")
+	ErrNoRemoteObject = errors.New("No objects matched")
+
+	s3svc *s3.S3
+)
+
+// headerFlag collects repeated -http-header flags
+type headerFlag http.Header
+
+func newHeaderFlag(name, usage string) headerFlag {
+	hf := headerFlag{}
+	flag.Var(hf, name, usage)
+	return hf
+}
+
+func (hf headerFlag) String() string {
+	return fmt.Sprintf("%d headers", len(hf))
+}
+
+func (hf headerFlag) Set(v string) error {
+	idx := strings.Index(v, `:`)
+	if idx <= 0 {
+		return ErrInvalidHeader
+	}
+	http.Header(hf).Add(strings.TrimSpace(v[:idx]), strings.TrimSpace(v[idx+1:]))
+	return nil
+}
+
+// isRemote returns true if the input is an s3 or http(s) URL rather than a local path
+func isRemote(pth string) bool {
+	u, err := url.Parse(pth)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case s3Scheme, httpScheme, httpsScheme:
+		return true
+	}
+	return false
+}
+
+// remotePath is the host and path of a remote input, used for include/exclude matching and tag templates
+func remotePath(pth string) string {
+	u, err := url.Parse(pth)
+	if err != nil {
+		return pth
+	}
+	return path.Join(u.Host, u.Path)
+}
+
+func parseS3URL(pth string) (bucket, key string, err error) {
+	var u *url.URL
+	if u, err = url.Parse(pth); err != nil {
+		return
+	} else if bucket = u.Host; bucket == `` {
+		err = ErrInvalidS3URL
+		return
+	}
+	key = strings.TrimPrefix(u.Path, `/`)
+	return
+}
+
+// findRemoteInputs expands a remote input into a list of URLs.  An S3 URL with an empty key or
+// a key ending in / is treated as a prefix and every object under it is ingested, subject to
+// the include and exclude patterns.
+func findRemoteInputs(in string, include, exclude []string) (files []string, err error) {
+	u, err := url.Parse(in)
+	if err != nil {
+		return
+	} else if !strings.EqualFold(u.Scheme, s3Scheme) {
+		files = []string{in}
+		return
+	}
+	var bucket, prefix string
+	if bucket, prefix, err = parseS3URL(in); err != nil {
+		return
+	} else if prefix != `` && !strings.HasSuffix(prefix, `/`) {
+		files = []string{in}
+		return
+	}
+	var svc *s3.S3
+	if svc, err = s3Client(); err != nil {
+		return
+	}
+	req := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	err = svc.ListObjectsV2Pages(req, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range out.Contents {
+			key := aws.StringValue(obj.Key)
+			if strings.HasSuffix(key, `/`) || !wanted(path.Join(bucket, key), include, exclude) {
+				continue
+			}
+			files = append(files, fmt.Sprintf("s3://%s/%s", bucket, key))
+		}
+		return true
+	})
+	if err != nil {
+		return
+	} else if len(files) == 0 {
+		err = ErrNoRemoteObject
+		return
+	}
+	sort.Strings(files)
+	return
+}
+
+// openRemote starts streaming a remote object, nothing is staged on local disk
+func openRemote(pth string) (rc io.ReadCloser, err error) {
+	u, err := url.Parse(pth)
+	if err != nil {
+		return
+	}
+	if strings.EqualFold(u.Scheme, s3Scheme) {
+		return openS3(pth)
+	}
+	var req *http.Request
+	if req, err = http.NewRequest(http.MethodGet, pth, nil); err != nil {
+		return
+	}
+	for k, v := range httpHeaders {
+		req.Header[k] = v
+	}
+	var resp *http.Response
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		return
+	} else if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		err = fmt.Errorf("request failed: %s", resp.Status)
+		return
+	}
+	rc = resp.Body
+	return
+}
+
+func openS3(pth string) (rc io.ReadCloser, err error) {
+	var bucket, key string
+	var svc *s3.S3
+	if bucket, key, err = parseS3URL(pth); err != nil {
+		return
+	} else if key == `` {
+		err = ErrInvalidS3URL
+		return
+	} else if svc, err = s3Client(); err != nil {
+		return
+	}
+	var out *s3.GetObjectOutput
+	if out, err = svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}); err != nil {
+		return
+	}
+	rc = out.Body
+	return
+}
+
+// s3Client builds the S3 client on first use, credentials given on the command line take
+// precedence over the usual AWS environment variables, shared config, and instance roles
+func s3Client() (*s3.S3, error) {
+	if s3svc != nil {
+		return s3svc, nil
+	}
+	cfg := aws.NewConfig()
+	if *s3Region != `` {
+		cfg = cfg.WithRegion(*s3Region)
+	}
+	if *s3Endpoint != `` {
+		cfg = cfg.WithEndpoint(*s3Endpoint).WithS3ForcePathStyle(true)
+	}
+	if *s3AccessKey != `` || *s3SecretKey != `` {
+		if *s3AccessKey == `` || *s3SecretKey == `` {
+			return nil, ErrS3Credentials
+		}
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(*s3AccessKey, *s3SecretKey, ``))
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s3svc = s3.New(sess)
+	return s3svc, nil
+}
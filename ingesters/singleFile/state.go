@@ -0,0 +1,167 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingesters/utils"
+)
+
+const (
+	defaultStateInterval = 10 * time.Second
+)
+
+// fileState records how far into an input file we have gotten.  Offsets are in bytes of the
+// decompressed stream and always land on a line boundary.
+type fileState struct {
+	Offset  uint64
+	Size    int64
+	ModTime time.Time
+	Done    bool
+}
+
+// resumeState tracks per file progress so that an interrupted ingest can pick up where it left off.
+// Offsets are only written after the preprocessors have been flushed and the muxer has been synced,
+// so a resumed ingest only repeats the entries sent after the last checkpoint.  Aggregating
+// preprocessors such as rollup are flushed at every checkpoint, so their aggregates never span more
+// than one checkpoint interval.
+type resumeState struct {
+	sync.Mutex
+	st       *utils.State
+	files    map[string]fileState
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func newResumeState(pth string, interval, timeout time.Duration) (rs *resumeState, err error) {
+	rs = &resumeState{
+		files:    map[string]fileState{},
+		interval: interval,
+		timeout:  timeout,
+	}
+	if rs.st, err = utils.NewState(pth, 0600); err != nil {
+		return
+	}
+	if err = rs.st.Read(&rs.files); err == utils.ErrNoState {
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("failed to read state file %s: %w", pth, err)
+	}
+	return
+}
+
+// resumeFile holds the state of a single input file while it is being ingested
+type resumeFile struct {
+	rs   *resumeState
+	igst entrySink
+	key  string
+	fs   fileState
+	pos  uint64 // offset of the last line handed to the muxer
+	last time.Time
+}
+
+// open looks up the state for the file at pth, if the file has changed since the state was
+// recorded it is ingested from the start
+func (rs *resumeState) open(igst entrySink, pth string) (rf *resumeFile, err error) {
+	var fi os.FileInfo
+	rf = &resumeFile{
+		rs:   rs,
+		igst: igst,
+		last: time.Now(),
+	}
+	if rf.key, err = filepath.Abs(pth); err != nil {
+		return
+	} else if fi, err = os.Stat(pth); err != nil {
+		return
+	}
+	rs.Lock()
+	fs, ok := rs.files[rf.key]
+	rs.Unlock()
+	if ok && fs.Size == fi.Size() && fs.ModTime.Equal(fi.ModTime()) {
+		rf.fs = fs
+		rf.pos = fs.Offset
+	} else {
+		if ok {
+			log.Printf("%s has changed since it was last ingested, starting from the beginning\n", pth)
+		}
+		rf.fs = fileState{Size: fi.Size(), ModTime: fi.ModTime()}
+	}
+	return
+}
+
+// skip moves the reader past everything that was ingested in a previous run
+func (rf *resumeFile) skip(rdr io.Reader) (err error) {
+	if rf.fs.Offset == 0 {
+		return
+	}
+	var n int64
+	if n, err = io.CopyN(io.Discard, rdr, int64(rf.fs.Offset)); err == io.EOF {
+		err = fmt.Errorf("file is shorter than the recorded offset %d (%d)", rf.fs.Offset, n)
+	}
+	return
+}
+
+// entryFlusher pushes out entries held back by the preprocessors
+type entryFlusher interface {
+	Flush() error
+}
+
+// progress is handed to the line reader, every interval it flushes the preprocessors, syncs the
+// muxer, and records the offset.  Delivery is blocked while we sync, so the sync always catches up.
+func (rf *resumeFile) progress(fl entryFlusher) func(uint64) {
+	base := rf.fs.Offset
+	return func(off uint64) {
+		rf.pos = base + off
+		if time.Since(rf.last) < rf.rs.interval {
+			return
+		}
+		rf.last = time.Now()
+		//entries held by the preprocessors came from before the offset, they must be sent first
+		if err := fl.Flush(); err != nil {
+			log.Printf("Failed to flush preprocessors for checkpoint: %v\n", err)
+			return
+		} else if err := rf.igst.Sync(rf.rs.timeout); err != nil {
+			log.Printf("Failed to sync ingest muxer for checkpoint: %v\n", err)
+			return
+		}
+		rf.fs.Offset = rf.pos
+		if err := rf.rs.update(rf.key, rf.fs); err != nil {
+			log.Printf("Failed to write state file: %v\n", err)
+		}
+	}
+}
+
+// complete syncs the muxer and marks the file as completely ingested, the preprocessors must
+// already have been closed
+func (rf *resumeFile) complete() (err error) {
+	if err = rf.igst.Sync(rf.rs.timeout); err != nil {
+		err = fmt.Errorf("Failed to sync ingest muxer: %w", err)
+		return
+	}
+	rf.fs.Offset = rf.pos
+	rf.fs.Done = true
+	if err = rf.rs.update(rf.key, rf.fs); err != nil {
+		err = fmt.Errorf("Failed to write state file: %w", err)
+	}
+	return
+}
+
+func (rs *resumeState) update(key string, fs fileState) error {
+	rs.Lock()
+	defer rs.Unlock()
+	rs.files[key] = fs
+	return rs.st.Write(rs.files)
+}
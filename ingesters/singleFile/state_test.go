@@ -0,0 +1,180 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errTestCheckpoint = errors.New("checkpoint failure")
+
+// checkpointSink records the order of flushes and syncs, everything else is unused
+type checkpointSink struct {
+	entrySink
+	calls    []string
+	flushErr error
+	syncErr  error
+}
+
+func (cs *checkpointSink) Flush() error {
+	cs.calls = append(cs.calls, `flush`)
+	return cs.flushErr
+}
+
+func (cs *checkpointSink) Sync(time.Duration) error {
+	cs.calls = append(cs.calls, `sync`)
+	return cs.syncErr
+}
+
+func writeStateInput(t *testing.T, pth, data string) {
+	if err := ioutil.WriteFile(pth, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResumeStateCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	statePth := filepath.Join(dir, `state`)
+	input := filepath.Join(dir, `input.log`)
+	writeStateInput(t, input, "one\ntwo\nthree\n")
+
+	rs, err := newResumeState(statePth, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &checkpointSink{}
+	rf, err := rs.open(cs, input)
+	if err != nil {
+		t.Fatal(err)
+	} else if rf.fs.Offset != 0 || rf.fs.Done {
+		t.Fatalf("new file has state: %+v", rf.fs)
+	}
+	prog := rf.progress(cs)
+
+	//the preprocessors must be flushed before the muxer is synced and the offset recorded
+	prog(4)
+	if strings.Join(cs.calls, `,`) != `flush,sync` || rf.fs.Offset != 4 {
+		t.Fatalf("bad checkpoint: %v %d", cs.calls, rf.fs.Offset)
+	}
+
+	//a failed flush or sync leaves the recorded offset alone
+	for _, fail := range []*error{&cs.flushErr, &cs.syncErr} {
+		*fail = errTestCheckpoint
+		prog(8)
+		*fail = nil
+		if rf.fs.Offset != 4 {
+			t.Fatalf("offset recorded after a failed checkpoint: %d", rf.fs.Offset)
+		}
+	}
+	prog(8)
+	if rf.fs.Offset != 8 {
+		t.Fatalf("offset not recorded: %d", rf.fs.Offset)
+	}
+
+	//a new run picks up at the recorded offset
+	if rs, err = newResumeState(statePth, 0, time.Second); err != nil {
+		t.Fatal(err)
+	} else if rf, err = rs.open(cs, input); err != nil {
+		t.Fatal(err)
+	} else if rf.fs.Offset != 8 {
+		t.Fatalf("offset not restored: %+v", rf.fs)
+	}
+	fin, err := os.Open(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fin.Close()
+	if err = rf.skip(fin); err != nil {
+		t.Fatal(err)
+	} else if rest, err := ioutil.ReadAll(fin); err != nil || string(rest) != "three\n" {
+		t.Fatalf("bad skip: %q %v", rest, err)
+	}
+
+	//progress is relative to where the resumed reader started
+	rf.progress(cs)(6)
+	if rf.fs.Offset != 14 {
+		t.Fatalf("bad resumed offset: %d", rf.fs.Offset)
+	} else if err = rf.complete(); err != nil {
+		t.Fatal(err)
+	}
+	if rs, err = newResumeState(statePth, 0, time.Second); err != nil {
+		t.Fatal(err)
+	} else if rf, err = rs.open(cs, input); err != nil {
+		t.Fatal(err)
+	} else if !rf.fs.Done || rf.fs.Offset != 14 {
+		t.Fatalf("completed file not marked done: %+v", rf.fs)
+	}
+}
+
+func TestResumeStateChangedFile(t *testing.T) {
+	dir := t.TempDir()
+	statePth := filepath.Join(dir, `state`)
+	input := filepath.Join(dir, `input.log`)
+	writeStateInput(t, input, "one\ntwo\n")
+
+	rs, err := newResumeState(statePth, 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &checkpointSink{}
+	rf, err := rs.open(cs, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rf.progress(cs)(4)
+
+	//a file that changed since the checkpoint is ingested from the start
+	writeStateInput(t, input, "one\ntwo\nthree\n")
+	if rf, err = rs.open(cs, input); err != nil {
+		t.Fatal(err)
+	} else if rf.fs.Offset != 0 {
+		t.Fatalf("changed file resumed: %+v", rf.fs)
+	}
+
+	//a recorded offset past the end of the input is an error
+	rf.fs.Offset = 100
+	fin, err := os.Open(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fin.Close()
+	if err = rf.skip(fin); err == nil {
+		t.Fatal("short file not caught")
+	}
+}
+
+func TestResumeStateInterval(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, `input.log`)
+	writeStateInput(t, input, "one\n")
+	rs, err := newResumeState(filepath.Join(dir, `state`), time.Hour, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := &checkpointSink{}
+	rf, err := rs.open(cs, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//nothing is flushed or recorded until the interval passes, but the position is tracked
+	rf.progress(cs)(4)
+	if len(cs.calls) != 0 || rf.fs.Offset != 0 || rf.pos != 4 {
+		t.Fatalf("checkpoint before the interval: %v %d %d", cs.calls, rf.fs.Offset, rf.pos)
+	} else if err = rf.complete(); err != nil {
+		t.Fatal(err)
+	} else if rf.fs.Offset != 4 || !rf.fs.Done {
+		t.Fatalf("bad completed state: %+v", rf.fs)
+	}
+}
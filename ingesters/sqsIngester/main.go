@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/gravwell/gravwell/v3/ingest"
+	"github.com/gravwell/gravwell/v3/ingest/config"
 	"github.com/gravwell/gravwell/v3/ingest/config/validate"
 	"github.com/gravwell/gravwell/v3/ingest/entry"
 	"github.com/gravwell/gravwell/v3/ingest/log"
@@ -177,7 +178,7 @@ func main() {
 		CachePath:          cfg.Ingest_Cache_Path,
 		CacheSize:          cfg.Max_Ingest_Cache,
 		CacheMode:          cfg.Cache_Mode,
-		LogSourceOverride:  net.ParseIP(cfg.Log_Source_Override),
+		LogSourceOverride:  cfg.LogSourceOverride(),
 	}
 	igst, err = ingest.NewUniformMuxer(igCfg)
 	if err != nil {
@@ -217,15 +218,13 @@ func main() {
 		var src net.IP
 
 		if v.Source_Override != `` {
-			src = net.ParseIP(v.Source_Override)
-			if src == nil {
-				lg.FatalCode(0, "listener invalid source override, is not an IP address", log.KV("listener", k), log.KV("sourceoverride", v.Source_Override))
+			if src, err = config.ParseSourceOverride(v.Source_Override); err != nil {
+				lg.FatalCode(0, "listener invalid source override", log.KV("listener", k), log.KV("sourceoverride", v.Source_Override), log.KVErr(err))
 			}
 		} else if cfg.Source_Override != `` {
 			// global override
-			src = net.ParseIP(cfg.Source_Override)
-			if src == nil {
-				lg.FatalCode(0, "Global Source-Override is invalid")
+			if src, err = config.ParseSourceOverride(cfg.Source_Override); err != nil {
+				lg.FatalCode(0, "Global Source-Override is invalid", log.KVErr(err))
 			}
 		}
 
@@ -254,6 +253,7 @@ func main() {
 		if hcfg.proc, err = cfg.Preprocessor.ProcessorSet(igst, v.Preprocessor); err != nil {
 			lg.Fatal("preprocessor failure", log.KVErr(err))
 		}
+		hcfg.proc.SetLatencyBudget(cfg.PreprocessorMaxLatency(), 0)
 
 		wg.Add(1)
 		go queueRunner(hcfg)
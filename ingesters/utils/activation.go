@@ -0,0 +1,193 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// systemd socket activation passes pre-opened sockets starting at descriptor 3, see sd_listen_fds(3)
+const (
+	envListenPID     = `LISTEN_PID`
+	envListenFDs     = `LISTEN_FDS`
+	envListenFDNames = `LISTEN_FDNAMES`
+	listenFDsStart   = 3
+)
+
+var (
+	ErrInvalidListenFDs = errors.New("invalid LISTEN_FDS environment variable")
+)
+
+// ActivatedSockets holds the listening sockets handed to us by systemd socket activation.
+// Listeners claim sockets by bind address, a nil *ActivatedSockets is valid and holds nothing.
+type ActivatedSockets struct {
+	sync.Mutex
+	socks []*activatedSocket
+}
+
+type activatedSocket struct {
+	name string
+	l    net.Listener
+	pc   net.PacketConn
+}
+
+func (s *activatedSocket) addr() net.Addr {
+	if s.l != nil {
+		return s.l.Addr()
+	}
+	return s.pc.LocalAddr()
+}
+
+// GetActivatedSockets picks up sockets passed in using the LISTEN_FDS protocol.  The environment
+// variables are cleared so that child processes do not try to use the sockets.  If the process
+// was not socket activated an empty set is returned.
+func GetActivatedSockets() (*ActivatedSockets, error) {
+	defer func() {
+		os.Unsetenv(envListenPID)
+		os.Unsetenv(envListenFDs)
+		os.Unsetenv(envListenFDNames)
+	}()
+	if pid, err := strconv.Atoi(os.Getenv(envListenPID)); err != nil || pid != os.Getpid() {
+		// not set, or the sockets were meant for some other process
+		return &ActivatedSockets{}, nil
+	}
+	cnt, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil || cnt < 0 {
+		return nil, ErrInvalidListenFDs
+	}
+	names := strings.Split(os.Getenv(envListenFDNames), `:`)
+	files := make([]*os.File, 0, cnt)
+	for i := 0; i < cnt; i++ {
+		name := `fd` + strconv.Itoa(listenFDsStart+i)
+		if i < len(names) && names[i] != `` {
+			name = names[i]
+		}
+		files = append(files, os.NewFile(uintptr(listenFDsStart+i), name))
+	}
+	return newActivatedSockets(files)
+}
+
+func newActivatedSockets(files []*os.File) (as *ActivatedSockets, err error) {
+	as = &ActivatedSockets{}
+	for i, f := range files {
+		s := &activatedSocket{name: f.Name()}
+		// FileListener rejects datagram sockets, so anything it refuses gets a second chance as a packet conn
+		if s.l, err = net.FileListener(f); err != nil {
+			if s.pc, err = net.FilePacketConn(f); err != nil {
+				err = fmt.Errorf("activated descriptor %s is not a listening socket: %w", s.name, err)
+				for _, f := range files[i:] {
+					f.Close()
+				}
+				as.Close()
+				return nil, err
+			}
+		}
+		f.Close() // the listener holds its own copy of the descriptor
+		as.socks = append(as.socks, s)
+	}
+	return
+}
+
+// Count returns the number of unclaimed sockets
+func (as *ActivatedSockets) Count() int {
+	if as == nil {
+		return 0
+	}
+	as.Lock()
+	defer as.Unlock()
+	return len(as.socks)
+}
+
+// Listener claims a stream socket bound to the given address, ok is false if there is no such socket.
+// Sockets bound to the unspecified address match listeners bound to either 0.0.0.0 or [::].
+func (as *ActivatedSockets) Listener(network, addr string) (l net.Listener, ok bool) {
+	if s := as.claim(network, addr, true); s != nil {
+		l, ok = s.l, true
+	}
+	return
+}
+
+// PacketConn claims a datagram socket bound to the given address, ok is false if there is no such socket
+func (as *ActivatedSockets) PacketConn(network, addr string) (pc net.PacketConn, ok bool) {
+	if s := as.claim(network, addr, false); s != nil {
+		pc, ok = s.pc, true
+	}
+	return
+}
+
+func (as *ActivatedSockets) claim(network, addr string, stream bool) *activatedSocket {
+	if as == nil {
+		return nil
+	}
+	as.Lock()
+	defer as.Unlock()
+	for i, s := range as.socks {
+		if (s.l != nil) != stream || !addrMatch(network, addr, s.addr()) {
+			continue
+		}
+		as.socks = append(as.socks[:i], as.socks[i+1:]...)
+		return s
+	}
+	return nil
+}
+
+// Close closes any sockets no listener claimed and returns their names and addresses
+func (as *ActivatedSockets) Close() (unclaimed []string) {
+	if as == nil {
+		return
+	}
+	as.Lock()
+	defer as.Unlock()
+	for _, s := range as.socks {
+		unclaimed = append(unclaimed, s.name+` `+s.addr().String())
+		if s.l != nil {
+			s.l.Close()
+		} else {
+			s.pc.Close()
+		}
+	}
+	as.socks = nil
+	return
+}
+
+func addrMatch(network, want string, have net.Addr) bool {
+	switch h := have.(type) {
+	case *net.TCPAddr:
+		if !strings.HasPrefix(network, `tcp`) {
+			return false
+		}
+		w, err := net.ResolveTCPAddr(network, want)
+		return err == nil && ipPortMatch(w.IP, w.Port, h.IP, h.Port)
+	case *net.UDPAddr:
+		if !strings.HasPrefix(network, `udp`) {
+			return false
+		}
+		w, err := net.ResolveUDPAddr(network, want)
+		return err == nil && ipPortMatch(w.IP, w.Port, h.IP, h.Port)
+	case *net.UnixAddr:
+		return network == h.Net && filepath.Clean(want) == filepath.Clean(h.Name)
+	}
+	return false
+}
+
+func ipPortMatch(wip net.IP, wport int, hip net.IP, hport int) bool {
+	if wport != hport {
+		return false
+	} else if len(wip) == 0 || wip.IsUnspecified() {
+		return len(hip) == 0 || hip.IsUnspecified()
+	}
+	return wip.Equal(hip)
+}
@@ -0,0 +1,131 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestActivatedSockets(t *testing.T) {
+	tl, err := net.ListenTCP(`tcp`, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	uc, err := net.ListenUDP(`udp`, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	tf, err := tl.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uf, err := uc.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	as, err := newActivatedSockets([]*os.File{tf, uf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if as.Count() != 2 {
+		t.Fatalf("bad socket count %d", as.Count())
+	}
+	tport := strconv.Itoa(tl.Addr().(*net.TCPAddr).Port)
+	uport := strconv.Itoa(uc.LocalAddr().(*net.UDPAddr).Port)
+
+	// wrong address, wrong type, and wrong network must not match
+	if _, ok := as.Listener(`tcp`, `127.0.0.2:`+tport); ok {
+		t.Fatal("matched the wrong address")
+	} else if _, ok = as.PacketConn(`udp`, `127.0.0.1:`+tport); ok {
+		t.Fatal("matched a stream socket as a datagram socket")
+	} else if _, ok = as.Listener(`udp`, `127.0.0.1:`+uport); ok {
+		t.Fatal("matched a datagram socket as a listener")
+	}
+
+	l, ok := as.Listener(`tcp`, `127.0.0.1:`+tport)
+	if !ok {
+		t.Fatal("failed to claim listener")
+	}
+	defer l.Close()
+	if _, ok = as.Listener(`tcp`, `127.0.0.1:`+tport); ok {
+		t.Fatal("listener claimed twice")
+	}
+	go func() {
+		if c, err := net.Dial(`tcp`, l.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	if c, err := l.Accept(); err != nil {
+		t.Fatal(err)
+	} else {
+		c.Close()
+	}
+
+	if unclaimed := as.Close(); len(unclaimed) != 1 {
+		t.Fatalf("bad unclaimed list: %v", unclaimed)
+	} else if as.Count() != 0 {
+		t.Fatal("sockets remain after close")
+	}
+
+	var nilSet *ActivatedSockets
+	if _, ok := nilSet.Listener(`tcp`, `:80`); ok || nilSet.Count() != 0 || nilSet.Close() != nil {
+		t.Fatal("nil set is not empty")
+	}
+}
+
+func TestAddrMatch(t *testing.T) {
+	any4 := &net.TCPAddr{IP: net.IPv4zero, Port: 80}
+	any6 := &net.TCPAddr{IP: net.IPv6unspecified, Port: 80}
+	lo := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+	tests := []struct {
+		want string
+		have net.Addr
+		ok   bool
+	}{
+		{`:80`, any6, true},
+		{`0.0.0.0:80`, any6, true},
+		{`[::]:80`, any4, true},
+		{`127.0.0.1:80`, lo, true},
+		{`127.0.0.1:80`, any4, false},
+		{`0.0.0.0:80`, lo, false},
+		{`0.0.0.0:81`, any4, false},
+	}
+	for _, tst := range tests {
+		if addrMatch(`tcp`, tst.want, tst.have) != tst.ok {
+			t.Fatalf("%s against %v should be %v", tst.want, tst.have, tst.ok)
+		}
+	}
+	ua := &net.UnixAddr{Name: `/run/gravwell/relay.sock`, Net: `unix`}
+	if !addrMatch(`unix`, `/run/gravwell//relay.sock`, ua) || addrMatch(`unixgram`, `/run/gravwell/relay.sock`, ua) {
+		t.Fatal("bad unix socket matching")
+	}
+}
+
+func TestGetActivatedSocketsOtherPid(t *testing.T) {
+	os.Setenv(envListenPID, strconv.Itoa(os.Getpid()+1))
+	os.Setenv(envListenFDs, `2`)
+	as, err := GetActivatedSockets()
+	if err != nil {
+		t.Fatal(err)
+	} else if as.Count() != 0 {
+		t.Fatal("picked up sockets meant for another process")
+	} else if os.Getenv(envListenFDs) != `` {
+		t.Fatal("environment was not cleared")
+	}
+	os.Setenv(envListenPID, strconv.Itoa(os.Getpid()))
+	os.Setenv(envListenFDs, `bogus`)
+	if _, err = GetActivatedSockets(); err != ErrInvalidListenFDs {
+		t.Fatalf("expected ErrInvalidListenFDs, got %v", err)
+	}
+}
@@ -9,19 +9,38 @@
 package utils
 
 import (
+	"archive/tar"
 	"bufio"
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
 	"errors"
 	"io"
 	"os"
+	"sync/atomic"
 
 	ft "github.com/h2non/filetype"
 	"github.com/h2non/filetype/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 const (
 	defaultBufferSize int = 2 * 1024 * 1024
+
+	tarBlockSize   = 512
+	tarMagicOffset = 257
+
+	//how much of a stream is kept so that type detection can rewind it
+	streamSniffSize   = 262
+	streamRewindLimit = 8 * 1024 * 1024
+)
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic  = []byte("ustar")
+
+	ErrStreamRewind = errors.New("stream can no longer be rewound")
 )
 
 type ReadResetCloser interface {
@@ -69,7 +88,36 @@ func OpenBufferedFileReader(p string, buffer int) (r ReadResetCloser, err error)
 	return
 }
 
+// OpenBufferedArchiveReader is OpenBufferedFileReader, but if the file is a tar archive (compressed
+// or not) the contents of each member file are read back to back instead of the raw archive.
+func OpenBufferedArchiveReader(p string, buffer int) (r ReadResetCloser, err error) {
+	return OpenTrackedArchiveReader(p, buffer, nil)
+}
+
+// OpenTrackedArchiveReader is OpenBufferedArchiveReader, but every byte read from the file on disk is
+// atomically added to raw.  Callers can use it to report progress through compressed files.
+func OpenTrackedArchiveReader(p string, buffer int, raw *int64) (r ReadResetCloser, err error) {
+	if buffer <= 0 {
+		buffer = defaultBufferSize
+	}
+	if r, err = openFileReader(p, raw); err != nil {
+		return
+	} else if r, err = Untar(r); err != nil {
+		return
+	}
+	r = &buffReadCloser{
+		r:     r,
+		b:     bufio.NewReaderSize(r, buffer),
+		bsize: buffer,
+	}
+	return
+}
+
 func OpenFileReader(p string) (r ReadResetCloser, err error) {
+	return openFileReader(p, nil)
+}
+
+func openFileReader(p string, raw *int64) (r ReadResetCloser, err error) {
 	var fin *os.File
 	var tp types.Type
 	if tp, err = ft.MatchFile(p); err != nil {
@@ -78,24 +126,124 @@ func OpenFileReader(p string) (r ReadResetCloser, err error) {
 	if fin, err = os.Open(p); err != nil {
 		return
 	}
-	if r, err = GetReader(fin, tp); err != nil {
+	var frc ReadResetCloser = fileResetter{File: fin}
+	if raw != nil {
+		frc = &countingResetter{fileResetter: fileResetter{File: fin}, raw: raw}
+	}
+	if r, err = getReader(frc, fin, tp); err != nil {
 		fin.Close()
 	}
 	return
 }
 
+// GetReader wraps the file in a decompressor based on its type
 func GetReader(fin *os.File, tp types.Type) (r ReadResetCloser, err error) {
+	return getReader(NewFileReadResetCloser(fin), fin, tp)
+}
+
+func getReader(frc ReadResetCloser, fin *os.File, tp types.Type) (r ReadResetCloser, err error) {
+	return decompressor(frc, tp, func() bool { return isZstd(fin) })
+}
+
+func decompressor(frc ReadResetCloser, tp types.Type, zstd func() bool) (r ReadResetCloser, err error) {
 	switch tp.MIME.Subtype {
 	case `gzip`:
-		r, err = newGzipReader(NewFileReadResetCloser(fin))
+		r, err = newGzipReader(frc)
 	case `x-bzip2`:
-		r, err = newBzip2Reader(NewFileReadResetCloser(fin))
+		r, err = newBzip2Reader(frc)
+	case `x-xz`:
+		r, err = newXzReader(frc)
 	default:
-		r = NewFileReadResetCloser(fin)
+		if zstd() {
+			r, err = newZstdReader(frc)
+		} else {
+			r = frc
+		}
 	}
 	return
 }
 
+// OpenStreamArchiveReader is OpenTrackedArchiveReader for streams that can't be seeked, such as
+// an HTTP response body.  Compression and tar archives are detected by buffering the start of the
+// stream, the returned reader can't be Reset once it has been read.  raw may be nil.
+func OpenStreamArchiveReader(rc io.ReadCloser, buffer int, raw *int64) (r ReadResetCloser, err error) {
+	if buffer <= 0 {
+		buffer = defaultBufferSize
+	}
+	sr := &streamResetter{rc: rc, raw: raw, hist: []byte{}}
+	hdr := make([]byte, streamSniffSize)
+	n, lerr := io.ReadFull(sr, hdr)
+	if lerr != nil && lerr != io.ErrUnexpectedEOF && lerr != io.EOF {
+		rc.Close()
+		return nil, lerr
+	}
+	hdr = hdr[:n]
+	sr.Reset()
+	tp, _ := ft.Match(hdr)
+	if r, err = decompressor(sr, tp, func() bool { return bytes.HasPrefix(hdr, zstdMagic) }); err != nil {
+		rc.Close()
+		return
+	} else if r, err = Untar(r); err != nil {
+		return
+	}
+	r = &buffReadCloser{
+		r:     r,
+		b:     bufio.NewReaderSize(r, buffer),
+		bsize: buffer,
+	}
+	return
+}
+
+// streamResetter remembers the start of a stream so that it can be rewound while sniffing
+// out what is in it.  Once more than streamRewindLimit bytes have been read it stops
+// remembering and Reset fails.
+type streamResetter struct {
+	rc   io.ReadCloser
+	raw  *int64
+	hist []byte
+	pos  int
+}
+
+func (sr *streamResetter) Read(b []byte) (n int, err error) {
+	if sr.pos < len(sr.hist) {
+		n = copy(b, sr.hist[sr.pos:])
+		sr.pos += n
+		return
+	}
+	n, err = sr.rc.Read(b)
+	if sr.raw != nil {
+		atomic.AddInt64(sr.raw, int64(n))
+	}
+	if sr.hist != nil {
+		if len(sr.hist)+n > streamRewindLimit {
+			sr.hist, sr.pos = nil, 0
+		} else {
+			sr.hist = append(sr.hist, b[:n]...)
+			sr.pos += n
+		}
+	}
+	return
+}
+
+func (sr *streamResetter) Close() error {
+	return sr.rc.Close()
+}
+
+func (sr *streamResetter) Reset() error {
+	if sr.hist == nil {
+		return ErrStreamRewind
+	}
+	sr.pos = 0
+	return nil
+}
+
+// isZstd checks the file for the zstd frame magic, filetype does not know about zstd
+func isZstd(fin *os.File) bool {
+	hdr := make([]byte, len(zstdMagic))
+	n, _ := fin.ReadAt(hdr, 0)
+	return n == len(hdr) && bytes.Equal(hdr, zstdMagic)
+}
+
 type fileResetter struct {
 	*os.File
 }
@@ -114,6 +262,26 @@ func (fr fileResetter) Reset() (err error) {
 	return
 }
 
+// countingResetter tallies the bytes read from the underlying file, a reset takes back
+// everything it has counted so rewinding to sniff archive headers isn't counted twice
+type countingResetter struct {
+	fileResetter
+	raw  *int64
+	read int64
+}
+
+func (cr *countingResetter) Read(b []byte) (n int, err error) {
+	n, err = cr.File.Read(b)
+	atomic.AddInt64(&cr.read, int64(n))
+	atomic.AddInt64(cr.raw, int64(n))
+	return
+}
+
+func (cr *countingResetter) Reset() error {
+	atomic.AddInt64(cr.raw, -atomic.SwapInt64(&cr.read, 0))
+	return cr.fileResetter.Reset()
+}
+
 type gzipReader struct {
 	fin ReadResetCloser
 	rdr *gzip.Reader
@@ -132,7 +300,8 @@ func (gr *gzipReader) Read(b []byte) (int, error) {
 }
 
 func (gr *gzipReader) Close() error {
-	return gr.rdr.Close()
+	gr.rdr.Close()
+	return gr.fin.Close()
 }
 
 func (gr *gzipReader) Reset() (err error) {
@@ -170,3 +339,140 @@ func (bzr *bzip2Reader) Reset() (err error) {
 	}
 	return
 }
+
+type zstdReader struct {
+	fin ReadResetCloser
+	rdr *zstd.Decoder
+}
+
+func newZstdReader(rdr ReadResetCloser) (zr *zstdReader, err error) {
+	zr = &zstdReader{
+		fin: rdr,
+	}
+	zr.rdr, err = zstd.NewReader(zr.fin)
+	return
+}
+
+func (zr *zstdReader) Read(b []byte) (int, error) {
+	return zr.rdr.Read(b)
+}
+
+func (zr *zstdReader) Close() error {
+	zr.rdr.Close()
+	return zr.fin.Close()
+}
+
+func (zr *zstdReader) Reset() (err error) {
+	//the decoder reads ahead in the background, stop it before rewinding the file underneath it
+	zr.rdr.Reset(nil)
+	if err = zr.fin.Reset(); err == nil {
+		err = zr.rdr.Reset(zr.fin)
+	}
+	return
+}
+
+type xzReader struct {
+	fin ReadResetCloser
+	rdr *xz.Reader
+}
+
+func newXzReader(rdr ReadResetCloser) (xzr *xzReader, err error) {
+	xzr = &xzReader{
+		fin: rdr,
+	}
+	xzr.rdr, err = xz.NewReader(xzr.fin)
+	return
+}
+
+func (xzr *xzReader) Read(b []byte) (int, error) {
+	return xzr.rdr.Read(b)
+}
+
+func (xzr *xzReader) Close() error {
+	return xzr.fin.Close()
+}
+
+func (xzr *xzReader) Reset() (err error) {
+	if err = xzr.fin.Reset(); err == nil {
+		xzr.rdr, err = xz.NewReader(xzr.fin)
+	}
+	return
+}
+
+// Untar checks if a decompressed stream is a tar archive and wraps it in a reader that returns the
+// contents of each member file if so, anything else is returned as is.  On error rdr is closed.
+func Untar(rdr ReadResetCloser) (r ReadResetCloser, err error) {
+	hdr := make([]byte, tarBlockSize)
+	n, lerr := io.ReadFull(rdr, hdr)
+	if lerr != nil && lerr != io.ErrUnexpectedEOF && lerr != io.EOF {
+		rdr.Close()
+		return nil, lerr
+	} else if err = rdr.Reset(); err != nil {
+		rdr.Close()
+		return
+	}
+	if n == tarBlockSize && bytes.Equal(hdr[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic) {
+		return newTarReader(rdr)
+	}
+	return rdr, nil
+}
+
+// tarReader reads the contents of every regular file in a tar archive as a single stream.
+// A newline is inserted between members that do not end with one so lines don't run together.
+type tarReader struct {
+	fin  ReadResetCloser
+	tr   *tar.Reader
+	cur  io.Reader
+	last byte
+}
+
+func newTarReader(rdr ReadResetCloser) (tr *tarReader, err error) {
+	tr = &tarReader{
+		fin: rdr,
+		tr:  tar.NewReader(rdr),
+	}
+	return
+}
+
+func (tr *tarReader) Read(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return
+	}
+	for {
+		if tr.cur == nil {
+			var hdr *tar.Header
+			if hdr, err = tr.tr.Next(); err != nil {
+				return
+			} else if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+				continue
+			}
+			tr.cur = tr.tr
+		}
+		if n, err = tr.cur.Read(b); n > 0 {
+			tr.last = b[n-1]
+			err = nil
+			return
+		} else if err != io.EOF {
+			return
+		}
+		//done with this member
+		tr.cur = nil
+		if tr.last != 0 && tr.last != '\n' {
+			b[0], tr.last = '\n', '\n'
+			return 1, nil
+		}
+	}
+}
+
+func (tr *tarReader) Close() error {
+	return tr.fin.Close()
+}
+
+func (tr *tarReader) Reset() (err error) {
+	if err = tr.fin.Reset(); err == nil {
+		tr.tr = tar.NewReader(tr.fin)
+		tr.cur = nil
+		tr.last = 0
+	}
+	return
+}
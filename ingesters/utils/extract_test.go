@@ -0,0 +1,195 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	testLinesA = "line one\nline two\n"
+	testLinesB = "line three\nline four" //no trailing newline on purpose
+	testLinesC = "line five\n"
+)
+
+type compressor func(io.Writer) (io.WriteCloser, error)
+
+func gzipCompressor(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func zstdCompressor(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func xzCompressor(w io.Writer) (io.WriteCloser, error) {
+	return xz.NewWriter(w)
+}
+
+func compress(t *testing.T, c compressor, b []byte) []byte {
+	bb := bytes.NewBuffer(nil)
+	wtr, err := c(bb)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = wtr.Write(b); err != nil {
+		t.Fatal(err)
+	} else if err = wtr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func tarball(t *testing.T, members ...string) []byte {
+	bb := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(bb)
+	if err := tw.WriteHeader(&tar.Header{Name: `dir/`, Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range members {
+		hdr := &tar.Header{
+			Name:     filepath.Join(`dir`, string(rune('a'+i))),
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(m)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		} else if _, err = tw.Write([]byte(m)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bb.Bytes()
+}
+
+func checkReader(t *testing.T, name string, b []byte, want string) {
+	checkReaderFunc(t, OpenBufferedFileReader, name, b, want)
+}
+
+func checkArchiveReader(t *testing.T, name string, b []byte, want string) {
+	checkReaderFunc(t, OpenBufferedArchiveReader, name, b, want)
+}
+
+func checkReaderFunc(t *testing.T, open func(string, int) (ReadResetCloser, error), name string, b []byte, want string) {
+	p := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(p, b, 0640); err != nil {
+		t.Fatal(err)
+	}
+	r, err := open(p, 0)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	defer r.Close()
+	for i := 0; i < 2; i++ {
+		if got, err := ioutil.ReadAll(r); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if string(got) != want {
+			t.Fatalf("%s: bad output on pass %d:\n%q\n%q", name, i, got, want)
+		} else if err = r.Reset(); err != nil {
+			t.Fatalf("%s: reset failed: %v", name, err)
+		}
+	}
+}
+
+func TestCompressedReaders(t *testing.T) {
+	plain := []byte(testLinesA + testLinesB)
+	checkReader(t, `plain.log`, plain, string(plain))
+	checkReader(t, `data.gz`, compress(t, gzipCompressor, plain), string(plain))
+	checkReader(t, `data.zst`, compress(t, zstdCompressor, plain), string(plain))
+	checkReader(t, `data.xz`, compress(t, xzCompressor, plain), string(plain))
+
+	//concatenated gzip members are read back to back
+	multi := append(compress(t, gzipCompressor, []byte(testLinesA)), compress(t, gzipCompressor, []byte(testLinesC))...)
+	checkReader(t, `multi.gz`, multi, testLinesA+testLinesC)
+}
+
+func TestTarReaders(t *testing.T) {
+	tb := tarball(t, testLinesA, testLinesB, testLinesC)
+	want := testLinesA + testLinesB + "\n" + testLinesC
+	checkArchiveReader(t, `logs.tar`, tb, want)
+	checkArchiveReader(t, `logs.tar.gz`, compress(t, gzipCompressor, tb), want)
+	checkArchiveReader(t, `logs.tar.zst`, compress(t, zstdCompressor, tb), want)
+	checkArchiveReader(t, `logs.tar.xz`, compress(t, xzCompressor, tb), want)
+
+	//plain readers leave the archive alone
+	checkReader(t, `logs.tar.gz`, compress(t, gzipCompressor, tb), string(tb))
+	checkArchiveReader(t, `plain.log`, []byte(testLinesA), testLinesA)
+}
+
+func TestTrackedArchiveReader(t *testing.T) {
+	tb := tarball(t, testLinesA, testLinesB, testLinesC)
+	files := map[string][]byte{
+		`plain.log`:    []byte(testLinesA),
+		`logs.tar`:     tb,
+		`logs.tar.gz`:  compress(t, gzipCompressor, tb),
+		`logs.tar.zst`: compress(t, zstdCompressor, tb),
+	}
+	for name, b := range files {
+		p := filepath.Join(t.TempDir(), name)
+		if err := ioutil.WriteFile(p, b, 0640); err != nil {
+			t.Fatal(err)
+		}
+		var raw int64
+		r, err := OpenTrackedArchiveReader(p, 0, &raw)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err = ioutil.ReadAll(r); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if n := atomic.LoadInt64(&raw); n != int64(len(b)) {
+			t.Fatalf("%s: bad raw count %d != %d", name, n, len(b))
+		}
+		r.Close()
+	}
+}
+
+func TestStreamArchiveReader(t *testing.T) {
+	tb := tarball(t, testLinesA, testLinesB, testLinesC)
+	want := testLinesA + testLinesB + "\n" + testLinesC
+	files := map[string]struct {
+		b    []byte
+		want string
+	}{
+		`plain.log`:    {[]byte(testLinesA), testLinesA},
+		`data.gz`:      {compress(t, gzipCompressor, []byte(testLinesA)), testLinesA},
+		`data.xz`:      {compress(t, xzCompressor, []byte(testLinesA)), testLinesA},
+		`logs.tar`:     {tb, want},
+		`logs.tar.gz`:  {compress(t, gzipCompressor, tb), want},
+		`logs.tar.zst`: {compress(t, zstdCompressor, tb), want},
+	}
+	for name, f := range files {
+		var raw int64
+		//hide everything but Read and Close so the reader can't seek
+		rc := ioutil.NopCloser(struct{ io.Reader }{bytes.NewReader(f.b)})
+		r, err := OpenStreamArchiveReader(rc, 0, &raw)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err := ioutil.ReadAll(r); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if string(got) != f.want {
+			t.Fatalf("%s: bad output:\n%q\n%q", name, got, f.want)
+		} else if n := atomic.LoadInt64(&raw); n != int64(len(f.b)) {
+			t.Fatalf("%s: bad raw count %d != %d", name, n, len(f.b))
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,242 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+)
+
+const (
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+)
+
+var (
+	ErrNoCACerts = errors.New("No certificates found in CA bundle")
+)
+
+// NewHTTPClient builds an HTTP client from the shared ingester HTTP settings.  The client
+// goes through the configured proxy and resolver, trusts the system CAs plus any CA bundle,
+// and retries failed requests with backoff, honoring server rate limit headers.
+func NewHTTPClient(hc config.HTTPClientConfig) (cli *http.Client, err error) {
+	if err = hc.VerifyHTTPClient(); err != nil {
+		return
+	}
+	var timeout, backoff, maxBackoff time.Duration
+	var dnsServer string
+	if timeout, err = hc.HTTPTimeout(); err != nil {
+		return
+	} else if backoff, maxBackoff, err = hc.HTTPBackoff(); err != nil {
+		return
+	} else if dnsServer, err = hc.HTTPDNSServer(); err != nil {
+		return
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: dialKeepAlive,
+	}
+	if dnsServer != `` {
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, dnsServer)
+			},
+		}
+	}
+
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = dialer.DialContext
+	if hc.UseEnvironmentProxy() {
+		tr.Proxy = http.ProxyFromEnvironment
+	} else if pu, _ := hc.HTTPProxyURL(); pu != nil {
+		tr.Proxy = http.ProxyURL(pu)
+	} else {
+		tr.Proxy = nil
+	}
+	tr.TLSClientConfig = &tls.Config{
+		InsecureSkipVerify: hc.HTTP_Insecure_Skip_TLS_Verify,
+	}
+	if hc.HTTP_CA_Bundle != `` {
+		if tr.TLSClientConfig.RootCAs, err = loadCABundle(hc.HTTP_CA_Bundle); err != nil {
+			return
+		}
+	}
+
+	cli = &http.Client{
+		Timeout: timeout,
+		Transport: &retryTransport{
+			rt:         tr,
+			retries:    hc.HTTP_Retries,
+			backoff:    backoff,
+			maxBackoff: maxBackoff,
+		},
+	}
+	return
+}
+
+// loadCABundle adds the certificates in the PEM file to the system pool
+func loadCABundle(pth string) (pool *x509.CertPool, err error) {
+	var bts []byte
+	if bts, err = ioutil.ReadFile(pth); err != nil {
+		return
+	}
+	if pool, err = x509.SystemCertPool(); err != nil || pool == nil {
+		pool, err = x509.NewCertPool(), nil
+	}
+	if !pool.AppendCertsFromPEM(bts) {
+		err = fmt.Errorf("%w %s", ErrNoCACerts, pth)
+	}
+	return
+}
+
+// retryTransport retries requests that fail with a network error or a response indicating the
+// server is overloaded or rate limiting.  When a server says the rate limit has been exhausted
+// all requests through the transport hold off until the limit resets.
+type retryTransport struct {
+	rt         http.RoundTripper
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mtx  sync.Mutex
+	hold time.Time // no requests go out before this
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		if err = t.wait(req.Context(), t.holdDuration()); err != nil {
+			return
+		}
+		if attempt > 0 && req.Body != nil {
+			//the body was consumed by the last attempt
+			if req.GetBody == nil {
+				return
+			}
+			r2 := req.Clone(req.Context())
+			if r2.Body, err = req.GetBody(); err != nil {
+				return
+			}
+			req = r2
+		}
+		resp, err = t.rt.RoundTrip(req)
+		if !t.retryable(resp, err) || attempt >= t.retries {
+			return
+		}
+
+		delay := backoff
+		if resp != nil {
+			if ra, ok := retryAfter(resp.Header, time.Now()); ok {
+				delay = ra
+			}
+			//drain so the connection can be reused
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp = nil
+		}
+		if delay > t.maxBackoff {
+			delay = t.maxBackoff
+		}
+		if err = t.wait(req.Context(), delay); err != nil {
+			return
+		}
+		if backoff *= 2; backoff > t.maxBackoff {
+			backoff = t.maxBackoff
+		}
+	}
+}
+
+func (t *retryTransport) retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	t.checkRateLimit(resp.Header, time.Now())
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// checkRateLimit looks for the common X-RateLimit headers, if the server says there are no
+// requests left we hold off until the reset time
+func (t *retryTransport) checkRateLimit(hdr http.Header, now time.Time) {
+	if hdr.Get(`X-RateLimit-Remaining`) != `0` {
+		return
+	}
+	v, err := strconv.ParseInt(hdr.Get(`X-RateLimit-Reset`), 10, 64)
+	if err != nil || v <= 0 {
+		return
+	}
+	//some APIs send seconds until the reset, others a unix timestamp
+	reset := time.Unix(v, 0)
+	if reset.Before(now) {
+		reset = now.Add(time.Duration(v) * time.Second)
+	}
+	if max := now.Add(t.maxBackoff); reset.After(max) {
+		reset = max
+	}
+	t.mtx.Lock()
+	if reset.After(t.hold) {
+		t.hold = reset
+	}
+	t.mtx.Unlock()
+}
+
+func (t *retryTransport) holdDuration() time.Duration {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return time.Until(t.hold)
+}
+
+func (t *retryTransport) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	tmr := time.NewTimer(d)
+	defer tmr.Stop()
+	select {
+	case <-tmr.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfter parses a Retry-After header, which is either seconds or an HTTP date
+func retryAfter(hdr http.Header, now time.Time) (d time.Duration, ok bool) {
+	v := hdr.Get(`Retry-After`)
+	if v == `` {
+		return
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if ts, err := http.ParseTime(v); err == nil {
+		if d = ts.Sub(now); d < 0 {
+			d = 0
+		}
+		ok = true
+	}
+	return
+}
@@ -0,0 +1,132 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/config"
+)
+
+func TestHTTPClientRetry(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch atomic.AddInt32(&hits, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.Header().Set(`Retry-After`, `0`)
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write(body)
+		}
+	}))
+	defer srv.Close()
+
+	cli, err := NewHTTPClient(config.HTTPClientConfig{
+		HTTP_Proxy:         `none`,
+		HTTP_Retries:       2,
+		HTTP_Retry_Backoff: `10ms`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cli.Post(srv.URL, `text/plain`, strings.NewReader(`hello`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := ioutil.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != `hello` {
+		t.Fatalf("bad response %d %q", resp.StatusCode, body)
+	} else if hits != 3 {
+		t.Fatalf("bad hit count %d", hits)
+	}
+
+	//out of retries, the last response is handed back
+	atomic.StoreInt32(&hits, 0)
+	if cli, err = NewHTTPClient(config.HTTPClientConfig{HTTP_Proxy: `none`, HTTP_Retries: 1, HTTP_Retry_Backoff: `10ms`}); err != nil {
+		t.Fatal(err)
+	} else if resp, err = cli.Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || hits != 2 {
+		t.Fatalf("bad response %d after %d hits", resp.StatusCode, hits)
+	}
+}
+
+func TestHTTPClientRateLimit(t *testing.T) {
+	rt := &retryTransport{maxBackoff: time.Minute}
+	now := time.Now()
+	hdr := http.Header{}
+	hdr.Set(`X-RateLimit-Remaining`, `1`)
+	hdr.Set(`X-RateLimit-Reset`, `30`)
+	if rt.checkRateLimit(hdr, now); !rt.hold.IsZero() {
+		t.Fatal("held with requests remaining")
+	}
+	hdr.Set(`X-RateLimit-Remaining`, `0`)
+	if rt.checkRateLimit(hdr, now); !rt.hold.Equal(now.Add(30 * time.Second)) {
+		t.Fatalf("bad relative hold %v", rt.hold.Sub(now))
+	}
+	//absolute resets are capped at the max backoff
+	hdr.Set(`X-RateLimit-Reset`, `99999999999`)
+	if rt.checkRateLimit(hdr, now); !rt.hold.Equal(now.Add(time.Minute)) {
+		t.Fatalf("bad capped hold %v", rt.hold.Sub(now))
+	}
+
+	if d, ok := retryAfter(http.Header{`Retry-After`: []string{`120`}}, now); !ok || d != 2*time.Minute {
+		t.Fatalf("bad retry after %v", d)
+	}
+	date := now.Add(time.Hour).UTC().Format(http.TimeFormat)
+	if d, ok := retryAfter(http.Header{`Retry-After`: []string{date}}, now); !ok || d < 59*time.Minute {
+		t.Fatalf("bad retry after %v", d)
+	}
+}
+
+func TestHTTPClientCABundle(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	//without the bundle the self signed server is rejected
+	cli, err := NewHTTPClient(config.HTTPClientConfig{HTTP_Proxy: `none`})
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err = cli.Get(srv.URL); err == nil {
+		t.Fatal("untrusted server was accepted")
+	}
+
+	p := filepath.Join(t.TempDir(), `ca.pem`)
+	ca := pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: srv.Certificate().Raw})
+	if err = ioutil.WriteFile(p, ca, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if cli, err = NewHTTPClient(config.HTTPClientConfig{HTTP_Proxy: `none`, HTTP_CA_Bundle: p}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cli.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if err = ioutil.WriteFile(p, []byte(`garbage`), 0640); err != nil {
+		t.Fatal(err)
+	} else if _, err = NewHTTPClient(config.HTTPClientConfig{HTTP_CA_Bundle: p}); err == nil {
+		t.Fatal("empty CA bundle was accepted")
+	}
+}
@@ -0,0 +1,284 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultIPFilterReload is how often list files are checked for changes
+	DefaultIPFilterReload = time.Minute
+)
+
+var (
+	ErrInvalidFilterEntry = errors.New("invalid address filter entry, expected a CIDR, an IP, or an absolute file path")
+)
+
+// IPFilter decides whether a remote address may connect based on allow and deny lists.
+// Entries are CIDR blocks, bare IP addresses, or absolute paths to files holding one entry per line.
+// Files are checked for changes periodically and reloaded, if a reload fails the previous lists remain in effect.
+// Deny entries win over allow entries, and an empty allow list allows every address which is not denied.
+// A nil *IPFilter allows everything.
+type IPFilter struct {
+	mtx       sync.RWMutex
+	allowSpec []string
+	denySpec  []string
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	files     map[string]time.Time // list files and their modification times
+	reload    time.Duration
+	next      int64 // unix nanoseconds of the next file check
+	denied    uint64
+	errf      func(error)
+}
+
+// NewIPFilter builds a filter from allow and deny entries, a nil filter is returned if both are empty.
+// File lists are checked for changes every reload interval, zero uses DefaultIPFilterReload.
+func NewIPFilter(allow, deny []string, reload time.Duration) (f *IPFilter, err error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return
+	}
+	if reload <= 0 {
+		reload = DefaultIPFilterReload
+	}
+	f = &IPFilter{
+		allowSpec: allow,
+		denySpec:  deny,
+		reload:    reload,
+	}
+	if f.allow, f.deny, f.files, err = f.load(); err != nil {
+		return nil, err
+	}
+	f.next = time.Now().Add(reload).UnixNano()
+	return
+}
+
+// SetReloadErrorHandler sets a function which is told about failed list file reloads
+func (f *IPFilter) SetReloadErrorHandler(fn func(error)) {
+	if f == nil {
+		return
+	}
+	f.mtx.Lock()
+	f.errf = fn
+	f.mtx.Unlock()
+}
+
+func (f *IPFilter) load() (allow, deny []*net.IPNet, files map[string]time.Time, err error) {
+	files = map[string]time.Time{}
+	if allow, err = parseFilterEntries(f.allowSpec, files); err != nil {
+		return
+	}
+	deny, err = parseFilterEntries(f.denySpec, files)
+	return
+}
+
+func parseFilterEntries(specs []string, files map[string]time.Time) (nets []*net.IPNet, err error) {
+	for _, s := range specs {
+		if s = strings.TrimSpace(s); s == `` {
+			continue
+		} else if filepath.IsAbs(s) {
+			var fi os.FileInfo
+			if fi, err = os.Stat(s); err != nil {
+				return
+			}
+			files[s] = fi.ModTime()
+			var fnets []*net.IPNet
+			if fnets, err = readFilterFile(s); err != nil {
+				return
+			}
+			nets = append(nets, fnets...)
+			continue
+		}
+		var n *net.IPNet
+		if n, err = parseFilterEntry(s); err != nil {
+			return
+		}
+		nets = append(nets, n)
+	}
+	return
+}
+
+// readFilterFile reads one entry per line, blank lines and lines starting with # are ignored
+func readFilterFile(pth string) (nets []*net.IPNet, err error) {
+	var fin *os.File
+	if fin, err = os.Open(pth); err != nil {
+		return
+	}
+	defer fin.Close()
+	scn := bufio.NewScanner(fin)
+	var lineno int
+	for scn.Scan() {
+		lineno++
+		ln := scn.Text()
+		if idx := strings.IndexByte(ln, '#'); idx >= 0 {
+			ln = ln[:idx]
+		}
+		if ln = strings.TrimSpace(ln); ln == `` {
+			continue
+		}
+		var n *net.IPNet
+		if n, err = parseFilterEntry(ln); err != nil {
+			err = fmt.Errorf("%s line %d: %w", pth, lineno, err)
+			return
+		}
+		nets = append(nets, n)
+	}
+	err = scn.Err()
+	return
+}
+
+func parseFilterEntry(s string) (*net.IPNet, error) {
+	if strings.ContainsRune(s, '/') {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidFilterEntry, s)
+		}
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFilterEntry, s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Reload re-reads the list files if any of them have changed
+func (f *IPFilter) Reload() (changed bool, err error) {
+	if f == nil {
+		return
+	}
+	f.mtx.RLock()
+	for pth, mt := range f.files {
+		if fi, serr := os.Stat(pth); serr != nil || !fi.ModTime().Equal(mt) {
+			changed = true
+			break
+		}
+	}
+	f.mtx.RUnlock()
+	if !changed {
+		return
+	}
+	allow, deny, files, err := f.load()
+	if err != nil {
+		return false, err
+	}
+	f.mtx.Lock()
+	f.allow, f.deny, f.files = allow, deny, files
+	f.mtx.Unlock()
+	return
+}
+
+// maybeReload checks the list files once per reload interval, only one caller does the check
+func (f *IPFilter) maybeReload() {
+	next := atomic.LoadInt64(&f.next)
+	now := time.Now()
+	if now.UnixNano() < next || !atomic.CompareAndSwapInt64(&f.next, next, now.Add(f.reload).UnixNano()) {
+		return
+	}
+	if _, err := f.Reload(); err != nil {
+		f.mtx.RLock()
+		errf := f.errf
+		f.mtx.RUnlock()
+		if errf != nil {
+			errf(err)
+		}
+	}
+}
+
+// Allowed returns true if the address is permitted
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	f.maybeReload()
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+	for _, n := range f.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, n := range f.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedAddr checks a TCP or UDP address, addresses without an IP such as unix sockets are always allowed
+func (f *IPFilter) AllowedAddr(a net.Addr) (ok bool) {
+	switch v := a.(type) {
+	case *net.TCPAddr:
+		ok = f.Allowed(v.IP)
+	case *net.UDPAddr:
+		ok = f.Allowed(v.IP)
+	case *net.IPAddr:
+		ok = f.Allowed(v.IP)
+	default:
+		ok = true
+	}
+	if !ok {
+		atomic.AddUint64(&f.denied, 1)
+	}
+	return
+}
+
+// Denied returns the number of addresses rejected by AllowedAddr and filtered listeners
+func (f *IPFilter) Denied() uint64 {
+	if f == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&f.denied)
+}
+
+// Listener wraps a listener so that connections from addresses which are not allowed are closed
+// as soon as they are accepted, before any bytes are read or a TLS handshake happens.
+func (f *IPFilter) Listener(l net.Listener) net.Listener {
+	if f == nil {
+		return l
+	}
+	return &filteredListener{Listener: l, f: f}
+}
+
+type filteredListener struct {
+	net.Listener
+	f *IPFilter
+}
+
+func (fl *filteredListener) Accept() (net.Conn, error) {
+	for {
+		c, err := fl.Listener.Accept()
+		if err != nil {
+			return nil, err
+		} else if fl.f.AllowedAddr(c.RemoteAddr()) {
+			return c, nil
+		}
+		c.Close()
+	}
+}
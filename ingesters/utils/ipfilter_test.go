@@ -0,0 +1,132 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIPFilter(t *testing.T) {
+	if f, err := NewIPFilter(nil, nil, 0); err != nil || f != nil {
+		t.Fatalf("empty lists should produce a nil filter: %v %v", f, err)
+	}
+	f, err := NewIPFilter([]string{`10.0.0.0/8`, `192.168.1.1`, `2001:db8::/32`}, []string{`10.1.0.0/16`}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip string
+		ok bool
+	}{
+		{`10.0.0.1`, true},
+		{`10.1.2.3`, false},
+		{`192.168.1.1`, true},
+		{`192.168.1.2`, false},
+		{`::ffff:10.0.0.1`, true},
+		{`2001:db8::1`, true},
+		{`2001:db9::1`, false},
+	}
+	for _, tst := range tests {
+		if f.Allowed(net.ParseIP(tst.ip)) != tst.ok {
+			t.Fatalf("%s should be %v", tst.ip, tst.ok)
+		}
+	}
+	if !f.AllowedAddr(&net.UnixAddr{Name: `/tmp/x`, Net: `unix`}) {
+		t.Fatal("unix address denied")
+	} else if f.AllowedAddr(&net.UDPAddr{IP: net.ParseIP(`1.2.3.4`)}) || f.Denied() != 1 {
+		t.Fatal("denied address not counted")
+	}
+
+	// deny only lists allow everything else
+	if f, err = NewIPFilter(nil, []string{`1.2.3.4`}, 0); err != nil {
+		t.Fatal(err)
+	} else if f.Allowed(net.ParseIP(`1.2.3.4`)) || !f.Allowed(net.ParseIP(`1.2.3.5`)) {
+		t.Fatal("bad deny only filter")
+	}
+
+	for _, bad := range []string{`bogus`, `10.0.0.0/33`, `relative/path`, `/does/not/exist`} {
+		if _, err = NewIPFilter([]string{bad}, nil, 0); err == nil {
+			t.Fatalf("%q was accepted", bad)
+		}
+	}
+	if _, err = NewIPFilter(nil, []string{`bogus`}, 0); !errors.Is(err, ErrInvalidFilterEntry) {
+		t.Fatalf("expected ErrInvalidFilterEntry, got %v", err)
+	}
+}
+
+func TestIPFilterReload(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), `deny.txt`)
+	if err := os.WriteFile(pth, []byte("# bad actors\n1.1.1.1\n\n2.2.2.0/24 # scanners\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewIPFilter(nil, []string{pth}, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reloadErr error
+	f.SetReloadErrorHandler(func(err error) { reloadErr = err })
+	if f.Allowed(net.ParseIP(`1.1.1.1`)) || f.Allowed(net.ParseIP(`2.2.2.9`)) || !f.Allowed(net.ParseIP(`3.3.3.3`)) {
+		t.Fatal("bad file list")
+	}
+	if err = os.WriteFile(pth, []byte("3.3.3.3\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err = os.Chtimes(pth, future, future); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if !f.Allowed(net.ParseIP(`1.1.1.1`)) || f.Allowed(net.ParseIP(`3.3.3.3`)) {
+		t.Fatal("list was not reloaded")
+	}
+
+	// a broken file keeps the old list
+	if err = os.WriteFile(pth, []byte("not an address\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if f.Allowed(net.ParseIP(`3.3.3.3`)) || reloadErr == nil {
+		t.Fatal("broken reload replaced the list")
+	}
+}
+
+func TestFilteredListener(t *testing.T) {
+	f, err := NewIPFilter(nil, []string{`127.0.0.1`}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := f.Listener(ln)
+	defer l.Close()
+	go func() {
+		if c, err := net.Dial(`tcp`, ln.Addr().String()); err == nil {
+			c.Close()
+		}
+		// wait for the acceptor to reject the connection before shutting it down
+		for i := 0; i < 100 && f.Denied() == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		l.Close()
+	}()
+	if c, err := l.Accept(); err == nil {
+		c.Close()
+		t.Fatal("denied connection was accepted")
+	}
+	if f.Denied() != 1 {
+		t.Fatalf("bad denied count %d", f.Denied())
+	}
+}
@@ -0,0 +1,262 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+const (
+	defaultParallelChunk = 1024
+)
+
+var (
+	ErrMissingTGFactory = errors.New("parallel ingest with timestamp extraction requires a timegrinder factory")
+)
+
+type lineChunk struct {
+	seq   uint64
+	end   uint64 // input offset just past the last line in the chunk
+	lines [][]byte
+}
+
+type entChunk struct {
+	seq       uint64
+	end       uint64
+	ents      []*entry.Entry
+	bytes     uint64
+	delivered uint64 // entries already handed to the processor set by the worker
+	err       error
+}
+
+// ingestLineDelimitedParallel splits timestamp extraction across cfg.Workers goroutines.  Lines are
+// handed to workers in chunks, when cfg.Ordered is set chunks are delivered to the processor set in
+// the order they were read, otherwise each worker delivers as soon as it is done.
+func ingestLineDelimitedParallel(cfg LineDelimitedStream) (count, totalBytes uint64, err error) {
+	tgs := make([]*timegrinder.TimeGrinder, cfg.Workers)
+	if cfg.TG != nil {
+		if cfg.NewTG == nil {
+			err = ErrMissingTGFactory
+			return
+		}
+		//timegrinders carry seed state so every worker needs its own
+		tgs[0] = cfg.TG
+		for i := 1; i < len(tgs); i++ {
+			if tgs[i], err = cfg.NewTG(); err != nil {
+				err = fmt.Errorf("failed to build worker timegrinder: %w", err)
+				return
+			}
+		}
+	}
+	chunkSize := defaultParallelChunk
+	if cfg.BatchSize > 0 {
+		chunkSize = cfg.BatchSize
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	work := make(chan lineChunk, cfg.Workers*2)
+	results := make(chan entChunk, cfg.Workers*2)
+	//bound the number of chunks between the scanner and delivery so an ordered
+	//ingest doesn't pile up chunks behind a slow worker
+	inflight := make(chan struct{}, cfg.Workers*4)
+	var wg sync.WaitGroup
+	for i := range tgs {
+		wg.Add(1)
+		go func(tg *timegrinder.TimeGrinder) {
+			defer wg.Done()
+			for lc := range work {
+				if ctx.Err() != nil {
+					continue
+				}
+				ec := buildChunk(cfg, tg, lc)
+				if !cfg.Ordered && ec.err == nil {
+					if ec.err = deliverChunk(cfg, ec.ents); ec.err == nil {
+						ec.delivered = uint64(len(ec.ents))
+					}
+					ec.ents = nil
+				}
+				select {
+				case results <- ec:
+				case <-ctx.Done():
+				}
+			}
+		}(tgs[i])
+	}
+
+	//the collector tallies results and, if ordered, delivers chunks in sequence.  Progress only
+	//advances once every chunk before it has been delivered.
+	collected := make(chan error, 1)
+	go func() {
+		var cerr error
+		var next uint64
+		pending := map[uint64]entChunk{}
+		done := map[uint64]uint64{}
+		for ec := range results {
+			if !cfg.Ordered {
+				<-inflight
+			}
+			if cerr != nil {
+				continue //drain
+			} else if ec.err != nil {
+				cerr = ec.err
+				cancel()
+				continue
+			}
+			if !cfg.Ordered {
+				count += ec.delivered
+				totalBytes += ec.bytes
+				done[ec.seq] = ec.end
+				for end, ok := done[next]; ok; end, ok = done[next] {
+					delete(done, next)
+					next++
+					if cfg.Progress != nil {
+						cfg.Progress(end)
+					}
+				}
+				continue
+			}
+			pending[ec.seq] = ec
+			for {
+				pc, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				<-inflight
+				if cerr = deliverChunk(cfg, pc.ents); cerr != nil {
+					cancel()
+					break
+				}
+				count += uint64(len(pc.ents))
+				totalBytes += pc.bytes
+				if cfg.Progress != nil {
+					cfg.Progress(pc.end)
+				}
+			}
+		}
+		collected <- cerr
+	}()
+
+	consumed, serr := scanChunks(ctx, cfg, chunkSize, work, inflight)
+	close(work)
+	wg.Wait()
+	close(results)
+	if err = <-collected; err == nil {
+		if err = serr; err == nil && cfg.Progress != nil {
+			cfg.Progress(consumed) //cover any trailing lines that were skipped
+		}
+	}
+	return
+}
+
+// scanChunks reads and filters lines, handing them to the workers in chunks
+func scanChunks(ctx context.Context, cfg LineDelimitedStream, chunkSize int, work chan lineChunk, inflight chan struct{}) (consumed uint64, err error) {
+	ignorePrefixFlag := len(cfg.IgnorePrefixes) > 0
+	scn := newLineScanner(cfg, &consumed)
+
+	var seq uint64
+	lc := lineChunk{lines: make([][]byte, 0, chunkSize)}
+	send := func() bool {
+		select {
+		case inflight <- struct{}{}:
+		case <-ctx.Done():
+			return false
+		}
+		lc.end = consumed
+		select {
+		case work <- lc:
+		case <-ctx.Done():
+			return false
+		}
+		seq++
+		lc = lineChunk{seq: seq, lines: make([][]byte, 0, chunkSize)}
+		return true
+	}
+
+scannerLoop:
+	for scn.Scan() {
+		var bts []byte
+		if bts = bytes.TrimSuffix(scn.Bytes(), nlBytes); len(bts) == 0 {
+			continue
+		}
+		if cfg.CleanQuotes {
+			if bts = trimQuotes(bts); len(bts) == 0 {
+				continue
+			}
+		}
+		if ignorePrefixFlag {
+			for _, pfx := range cfg.IgnorePrefixes {
+				if bytes.HasPrefix(bts, pfx) {
+					continue scannerLoop
+				}
+			}
+		}
+		lc.lines = append(lc.lines, append([]byte(nil), bts...)) //force reallocation due to the scanner
+		if len(lc.lines) >= chunkSize && !send() {
+			return
+		}
+	}
+	if len(lc.lines) > 0 && !send() {
+		return
+	}
+	err = scn.Err()
+	return
+}
+
+func buildChunk(cfg LineDelimitedStream, tg *timegrinder.TimeGrinder, lc lineChunk) (ec entChunk) {
+	ec.seq, ec.end = lc.seq, lc.end
+	ec.ents = make([]*entry.Entry, 0, len(lc.lines))
+	for _, ln := range lc.lines {
+		ts, ok := time.Time{}, false
+		if tg != nil {
+			if ts, ok, ec.err = tg.Extract(ln); ec.err != nil {
+				return
+			}
+		}
+		if !ok {
+			ts = time.Now()
+		}
+		ec.ents = append(ec.ents, &entry.Entry{
+			TS:   entry.FromStandard(ts),
+			Tag:  cfg.Tag,
+			SRC:  cfg.SRC,
+			Data: ln,
+		})
+		ec.bytes += uint64(len(ln))
+	}
+	return
+}
+
+func deliverChunk(cfg LineDelimitedStream, ents []*entry.Entry) (err error) {
+	if cfg.BatchSize > 0 {
+		err = cfg.Proc.ProcessBatch(ents)
+	} else {
+		for _, ent := range ents {
+			if err = cfg.Proc.Process(ent); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil && cfg.Verbose {
+		for _, ent := range ents {
+			fmt.Println(ent.TS, ent.Tag, ent.SRC, string(ent.Data))
+		}
+	}
+	return
+}
@@ -0,0 +1,153 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+const parallelTestLines = 10000
+
+var parallelBase = time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+
+type captureWriter struct {
+	sync.Mutex
+	ents []*entry.Entry
+}
+
+func (cw *captureWriter) WriteEntry(ent *entry.Entry) error {
+	cw.Lock()
+	cw.ents = append(cw.ents, ent)
+	cw.Unlock()
+	return nil
+}
+
+func (cw *captureWriter) WriteEntryContext(ctx context.Context, ent *entry.Entry) error {
+	return cw.WriteEntry(ent)
+}
+
+func (cw *captureWriter) WriteBatch(ents []*entry.Entry) error {
+	cw.Lock()
+	cw.ents = append(cw.ents, ents...)
+	cw.Unlock()
+	return nil
+}
+
+func (cw *captureWriter) WriteBatchContext(ctx context.Context, ents []*entry.Entry) error {
+	return cw.WriteBatch(ents)
+}
+
+func newTestTG() (*timegrinder.TimeGrinder, error) {
+	return timegrinder.NewTimeGrinder(timegrinder.Config{EnableLeftMostSeed: true})
+}
+
+func parallelInput() []byte {
+	bb := bytes.NewBuffer(nil)
+	for i := 0; i < parallelTestLines; i++ {
+		fmt.Fprintf(bb, "%s line %d\n", parallelBase.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i)
+		if i%100 == 0 {
+			bb.WriteString("#comment\n\n")
+		}
+	}
+	return bb.Bytes()
+}
+
+func runLineStream(t *testing.T, workers, batch int, ordered bool) []*entry.Entry {
+	tg, err := newTestTG()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cw captureWriter
+	var last uint64
+	var backwards bool
+	input := parallelInput()
+	cfg := LineDelimitedStream{
+		Rdr:            bytes.NewReader(input),
+		Proc:           processors.NewProcessorSet(&cw),
+		TG:             tg,
+		NewTG:          newTestTG,
+		IgnorePrefixes: [][]byte{[]byte("#")},
+		BatchSize:      batch,
+		Workers:        workers,
+		Ordered:        ordered,
+		Progress: func(off uint64) {
+			if off < last {
+				backwards = true
+			}
+			last = off
+		},
+	}
+	cnt, sz, err := IngestLineDelimitedStream(cfg)
+	if err != nil {
+		t.Fatal(err)
+	} else if cnt != parallelTestLines || len(cw.ents) != parallelTestLines {
+		t.Fatalf("bad count with %d workers: %d %d", workers, cnt, len(cw.ents))
+	}
+	var total uint64
+	for _, ent := range cw.ents {
+		total += uint64(len(ent.Data))
+	}
+	if total != sz {
+		t.Fatalf("bad size with %d workers: %d != %d", workers, sz, total)
+	} else if backwards || last != uint64(len(input)) {
+		t.Fatalf("bad progress with %d workers: %v %d != %d", workers, backwards, last, len(input))
+	}
+	return cw.ents
+}
+
+func checkParallelEntries(t *testing.T, ents []*entry.Entry) {
+	for i, ent := range ents {
+		want := parallelBase.Add(time.Duration(i) * time.Second)
+		if !ent.TS.StandardTime().Equal(want) {
+			t.Fatalf("entry %d has bad timestamp %v != %v", i, ent.TS.StandardTime(), want)
+		} else if !bytes.HasSuffix(ent.Data, []byte(fmt.Sprintf(" line %d", i))) {
+			t.Fatalf("entry %d is out of order: %s", i, ent.Data)
+		}
+	}
+}
+
+func TestParallelLineStream(t *testing.T) {
+	//single worker is the reference
+	checkParallelEntries(t, runLineStream(t, 1, 0, false))
+	checkParallelEntries(t, runLineStream(t, 1, 50, false))
+	checkParallelEntries(t, runLineStream(t, 4, 0, true))
+	checkParallelEntries(t, runLineStream(t, 4, 33, true))
+
+	//unordered must deliver everything, just not necessarily in order
+	ents := runLineStream(t, 4, 100, false)
+	sort.Slice(ents, func(i, j int) bool { return ents[i].TS.Before(ents[j].TS) })
+	checkParallelEntries(t, ents)
+}
+
+func TestParallelLineStreamNeedsFactory(t *testing.T) {
+	tg, err := newTestTG()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cw captureWriter
+	cfg := LineDelimitedStream{
+		Rdr:     bytes.NewReader(parallelInput()),
+		Proc:    processors.NewProcessorSet(&cw),
+		TG:      tg,
+		Workers: 2,
+	}
+	if _, _, err = IngestLineDelimitedStream(cfg); err != ErrMissingTGFactory {
+		t.Fatalf("missing timegrinder factory not caught: %v", err)
+	}
+}
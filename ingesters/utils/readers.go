@@ -262,9 +262,16 @@ type LineDelimitedStream struct {
 	Verbose        bool
 	Quotable       bool
 	BatchSize      int
+	Workers        int                                      // values greater than one split timestamp extraction across goroutines
+	Ordered        bool                                     // with multiple workers, deliver entries in the order they were read
+	NewTG          func() (*timegrinder.TimeGrinder, error) // builds timegrinders for additional workers
+	Progress       func(uint64)                             // called with the count of input bytes covered by delivered entries
 }
 
 func IngestLineDelimitedStream(cfg LineDelimitedStream) (uint64, uint64, error) {
+	if cfg.Workers > 1 {
+		return ingestLineDelimitedParallel(cfg)
+	}
 	var bts []byte
 	var ts time.Time
 	var ok bool
@@ -276,11 +283,13 @@ func IngestLineDelimitedStream(cfg LineDelimitedStream) (uint64, uint64, error)
 	}
 	ignorePrefixFlag := len(cfg.IgnorePrefixes) > 0
 
-	scn := bufio.NewScanner(cfg.Rdr)
-	if cfg.Quotable {
-		scn.Split(quotableSplitter)
+	var consumed uint64
+	scn := newLineScanner(cfg, &consumed)
+	progress := func() {
+		if cfg.Progress != nil {
+			cfg.Progress(consumed)
+		}
 	}
-	scn.Buffer(make([]byte, initBuffSize), maxBuffSize)
 
 scannerLoop:
 	for scn.Scan() {
@@ -316,12 +325,14 @@ scannerLoop:
 			if err = cfg.Proc.Process(ent); err != nil {
 				return count, totalBytes, err
 			}
+			progress()
 		} else {
 			blk = append(blk, ent)
 			if len(blk) >= cfg.BatchSize {
 				if err = cfg.Proc.ProcessBatch(blk); err != nil {
 					return count, totalBytes, err
 				}
+				progress()
 				blk = make([]*entry.Entry, 0, cfg.BatchSize)
 			}
 		}
@@ -336,7 +347,28 @@ scannerLoop:
 			return count, totalBytes, err
 		}
 	}
-	return count, totalBytes, scn.Err()
+	if err = scn.Err(); err == nil {
+		progress() //cover any trailing lines that were skipped
+	}
+	return count, totalBytes, err
+}
+
+// newLineScanner builds the line scanner for a stream, consumed is updated with the number of
+// bytes the scanner has moved past as each line is returned
+func newLineScanner(cfg LineDelimitedStream, consumed *uint64) (scn *bufio.Scanner) {
+	split := bufio.ScanLines
+	if cfg.Quotable {
+		split = quotableSplitter
+	}
+	scn = bufio.NewScanner(cfg.Rdr)
+	scn.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if advance, token, err = split(data, atEOF); err == nil {
+			*consumed += uint64(advance)
+		}
+		return
+	})
+	scn.Buffer(make([]byte, initBuffSize), maxBuffSize)
+	return
 }
 
 func quotableSplitter(data []byte, atEOF bool) (int, []byte, error) {
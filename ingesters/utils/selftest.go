@@ -0,0 +1,179 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+const (
+	DefaultSelfTestTag     = `gravwell-selftest`
+	DefaultSelfTestCount   = 10
+	DefaultSelfTestTimeout = 10 * time.Second
+)
+
+var (
+	ErrSelfTestNoIndexers = errors.New("no indexer connections are up")
+)
+
+// SelfTestMuxer is the part of the ingest muxer used by self tests
+type SelfTestMuxer interface {
+	NegotiateTag(string) (entry.EntryTag, error)
+	Hot() (int, error)
+	SyncContext(context.Context, time.Duration) error
+}
+
+// SelfTestTarget is a named preprocessor chain to push synthetic entries through, such as a listener
+type SelfTestTarget struct {
+	Name string
+	Proc *processors.ProcessorSet
+}
+
+// SelfTestConfig controls a self test, zero values get the defaults
+type SelfTestConfig struct {
+	Ingester string
+	Tag      string
+	Count    int
+	Timeout  time.Duration // how long to wait for the indexers to confirm each entry
+}
+
+// SelfTestResult is the outcome of pushing synthetic entries through one target
+type SelfTestResult struct {
+	Name      string
+	Sent      int
+	Confirmed int
+	Min       time.Duration
+	Max       time.Duration
+	Mean      time.Duration
+	Err       error
+}
+
+// Passed reports whether every entry was confirmed by the indexers
+func (r SelfTestResult) Passed() bool {
+	return r.Err == nil && r.Sent > 0 && r.Confirmed == r.Sent
+}
+
+func (r SelfTestResult) String() string {
+	status := `PASS`
+	if !r.Passed() {
+		status = `FAIL`
+	}
+	s := fmt.Sprintf("%s %s: %d/%d entries confirmed", status, r.Name, r.Confirmed, r.Sent)
+	if r.Confirmed > 0 {
+		s += fmt.Sprintf(", latency min %v mean %v max %v", r.Min, r.Mean, r.Max)
+	}
+	if r.Err != nil {
+		s += fmt.Sprintf(": %v", r.Err)
+	}
+	return s
+}
+
+func (c *SelfTestConfig) normalize() {
+	if c.Tag == `` {
+		c.Tag = DefaultSelfTestTag
+	}
+	if c.Count <= 0 {
+		c.Count = DefaultSelfTestCount
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultSelfTestTimeout
+	}
+}
+
+// RunSelfTest pushes synthetic entries through each target's preprocessors and the muxer to the
+// diagnostics tag, waiting for the indexers to confirm each one so the latency covers the entire
+// pipeline.  The targets' preprocessors are closed when their test finishes.  A line per target is
+// written to w and the return value is true if every target passed.
+func RunSelfTest(ctx context.Context, igst SelfTestMuxer, targets []SelfTestTarget, cfg SelfTestConfig, w io.Writer) (passed bool) {
+	cfg.normalize()
+	passed = len(targets) > 0
+	for _, t := range targets {
+		r := SelfTest(ctx, igst, t, cfg)
+		fmt.Fprintln(w, r)
+		passed = passed && r.Passed()
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(w, "FAIL: nothing to test")
+	}
+	return
+}
+
+// SelfTest pushes synthetic entries through a single target, its preprocessors are closed when it finishes
+func SelfTest(ctx context.Context, igst SelfTestMuxer, t SelfTestTarget, cfg SelfTestConfig) (r SelfTestResult) {
+	cfg.normalize()
+	r.Name = t.Name
+	r.Err = r.send(ctx, igst, t, cfg)
+	//flush anything a preprocessor is holding on to
+	if err := t.Proc.Close(); r.Err == nil {
+		if r.Err = err; r.Err == nil && r.Sent > 0 {
+			r.Err = igst.SyncContext(ctx, cfg.Timeout)
+		}
+	}
+	return
+}
+
+func (r *SelfTestResult) send(ctx context.Context, igst SelfTestMuxer, t SelfTestTarget, cfg SelfTestConfig) error {
+	cnt, err := igst.Hot()
+	if err != nil {
+		return err
+	} else if cnt == 0 {
+		return ErrSelfTestNoIndexers
+	}
+	tag, err := igst.NegotiateTag(cfg.Tag)
+	if err != nil {
+		return err
+	}
+
+	var total time.Duration
+	for i := 0; i < cfg.Count; i++ {
+		ts := time.Now()
+		ent := &entry.Entry{
+			TS:   entry.FromStandard(ts),
+			Tag:  tag,
+			Data: selfTestPayload(cfg.Ingester, t.Name, i, ts),
+		}
+		if err = t.Proc.ProcessContext(ent, ctx); err != nil {
+			return err
+		}
+		r.Sent++
+		if err = igst.SyncContext(ctx, cfg.Timeout); err != nil {
+			return err
+		}
+		lat := time.Since(ts)
+		if r.Confirmed == 0 || lat < r.Min {
+			r.Min = lat
+		}
+		if lat > r.Max {
+			r.Max = lat
+		}
+		total += lat
+		r.Confirmed++
+		r.Mean = total / time.Duration(r.Confirmed)
+	}
+	return nil
+}
+
+func selfTestPayload(ingester, target string, seq int, ts time.Time) []byte {
+	b, _ := json.Marshal(struct {
+		SelfTest bool      `json:"selftest"`
+		Ingester string    `json:"ingester"`
+		Target   string    `json:"target"`
+		Seq      int       `json:"seq"`
+		Time     time.Time `json:"time"`
+	}{true, ingester, target, seq, ts.UTC()})
+	return b
+}
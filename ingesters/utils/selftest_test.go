@@ -0,0 +1,92 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+	"github.com/gravwell/gravwell/v3/ingest/processors"
+)
+
+type selfTestMuxer struct {
+	captureWriter
+	hot     int
+	syncErr error
+	syncs   int
+	tags    []string
+}
+
+func (m *selfTestMuxer) NegotiateTag(name string) (entry.EntryTag, error) {
+	m.tags = append(m.tags, name)
+	return entry.EntryTag(len(m.tags)), nil
+}
+
+func (m *selfTestMuxer) Hot() (int, error) {
+	return m.hot, nil
+}
+
+func (m *selfTestMuxer) SyncContext(ctx context.Context, to time.Duration) error {
+	m.syncs++
+	return m.syncErr
+}
+
+func TestSelfTest(t *testing.T) {
+	m := &selfTestMuxer{hot: 1}
+	targets := []SelfTestTarget{
+		{Name: `syslog`, Proc: processors.NewProcessorSet(m)},
+		{Name: `json`, Proc: processors.NewProcessorSet(m)},
+	}
+	var out bytes.Buffer
+	if !RunSelfTest(context.Background(), m, targets, SelfTestConfig{Ingester: `test`, Count: 3}, &out) {
+		t.Fatalf("self test failed: %s", out.String())
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], `PASS syslog: 3/3 entries confirmed, latency`) || !strings.HasPrefix(lines[1], `PASS json: 3/3`) {
+		t.Fatalf("bad report %q", out.String())
+	}
+	if len(m.ents) != 6 || m.syncs != 8 {
+		t.Fatalf("bad entry or sync count %d %d", len(m.ents), m.syncs)
+	} else if m.tags[0] != DefaultSelfTestTag || m.ents[0].Tag != 1 {
+		t.Fatalf("bad tag %v %d", m.tags, m.ents[0].Tag)
+	}
+	var payload struct {
+		SelfTest bool
+		Ingester string
+		Target   string
+		Seq      int
+	}
+	if err := json.Unmarshal(m.ents[4].Data, &payload); err != nil {
+		t.Fatal(err)
+	} else if !payload.SelfTest || payload.Ingester != `test` || payload.Target != `json` || payload.Seq != 1 {
+		t.Fatalf("bad payload %s", m.ents[4].Data)
+	}
+
+	//failures
+	m.syncErr = errors.New("sync timeout")
+	if r := SelfTest(context.Background(), m, SelfTestTarget{Name: `a`, Proc: processors.NewProcessorSet(m)}, SelfTestConfig{}); r.Passed() || r.Sent != 1 || r.Confirmed != 0 || r.Err != m.syncErr {
+		t.Fatalf("sync failure not caught: %v", r)
+	} else if !strings.HasPrefix(r.String(), `FAIL a: 0/1 entries confirmed: sync timeout`) {
+		t.Fatalf("bad failure report %q", r)
+	}
+	m.hot = 0
+	if r := SelfTest(context.Background(), m, SelfTestTarget{Name: `a`, Proc: processors.NewProcessorSet(m)}, SelfTestConfig{}); r.Err != ErrSelfTestNoIndexers {
+		t.Fatalf("missing indexers not caught: %v", r)
+	}
+	out.Reset()
+	if RunSelfTest(context.Background(), m, nil, SelfTestConfig{}, &out) {
+		t.Fatal("empty self test passed")
+	}
+}
@@ -0,0 +1,81 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+// TestTimestamps backs the -test-timestamp mode of ingesters.  Each sample is run through tg
+// and the format that matched, where, and the resulting time are written to w along with any
+// other formats that would also match.  With no samples the formats tg tries are listed with an
+// example of each.  The return value is true if a timestamp was found in every sample.
+func TestTimestamps(w io.Writer, tg *timegrinder.TimeGrinder, samples []string) (passed bool) {
+	if len(samples) == 0 {
+		ListTimestampFormats(w, tg)
+		return true
+	}
+	passed = true
+	for _, s := range samples {
+		r, ok := tg.TestExtract([]byte(s))
+		if !ok {
+			fmt.Fprintf(w, "FAIL %q: no timestamp found\n", s)
+			passed = false
+			continue
+		}
+		fmt.Fprintf(w, "PASS %q: %s via %s at offset %d", s, r.Time.Format(time.RFC3339Nano), r.Name, r.Offset)
+		if r.End >= 0 {
+			fmt.Fprintf(w, " %q", s[r.Offset:r.End])
+		}
+		fmt.Fprintln(w)
+		for _, o := range tg.TestExtractAll([]byte(s)) {
+			if o.Name != r.Name {
+				fmt.Fprintf(w, "\talso matches %s at offset %d: %s\n", o.Name, o.Offset, o.Time.Format(time.RFC3339Nano))
+			}
+		}
+	}
+	return
+}
+
+// ListTimestampFormats writes the formats tg tries, in order, with an example of each
+func ListTimestampFormats(w io.Writer, tg *timegrinder.TimeGrinder) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tEXAMPLE\tFORMAT")
+	for _, fi := range tg.Formats() {
+		name := fi.Name
+		if fi.Override {
+			name += ` (override)`
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", name, fi.Example, fi.Format)
+	}
+	tw.Flush()
+}
+
+// TimestampSamples returns the samples given on the command line, a lone "-" reads a sample
+// per line from r instead
+func TimestampSamples(args []string, r io.Reader) (samples []string, err error) {
+	if len(args) != 1 || args[0] != `-` {
+		return args, nil
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1024*1024)
+	for sc.Scan() {
+		if ln := sc.Text(); ln != `` {
+			samples = append(samples, ln)
+		}
+	}
+	err = sc.Err()
+	return
+}
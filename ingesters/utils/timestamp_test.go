@@ -0,0 +1,50 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/gravwell/gravwell/v3/timegrinder"
+)
+
+func TestTestTimestamps(t *testing.T) {
+	tg, err := timegrinder.New(timegrinder.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb := &bytes.Buffer{}
+	if !TestTimestamps(bb, tg, nil) {
+		t.Fatal("listing formats failed")
+	} else if out := bb.String(); !strings.HasPrefix(out, "NAME") || !strings.Contains(out, "RFC3339 ") {
+		t.Fatalf("bad format listing:\n%s", out)
+	}
+
+	bb.Reset()
+	if TestTimestamps(bb, tg, []string{`<13>2022-03-04T13:14:15Z host app: hi`, `nothing`}) {
+		t.Fatal("sample without a timestamp passed")
+	}
+	lines := strings.Split(strings.TrimSpace(bb.String()), "\n")
+	if !strings.HasPrefix(lines[0], `PASS "<13>2022-03-04T13:14:15Z host app: hi": 2022-03-04T13:14:15Z via RFC3339 at offset 4 "2022-03-04T13:14:15Z"`) {
+		t.Fatalf("bad result line %q", lines[0])
+	} else if last := lines[len(lines)-1]; last != `FAIL "nothing": no timestamp found` {
+		t.Fatalf("bad failure line %q", last)
+	}
+}
+
+func TestTimestampSamples(t *testing.T) {
+	if s, err := TimestampSamples([]string{`a`, `-`}, nil); err != nil || len(s) != 2 {
+		t.Fatalf("bad samples %v %v", s, err)
+	}
+	if s, err := TimestampSamples([]string{`-`}, strings.NewReader("one\n\ntwo\r\n")); err != nil || len(s) != 2 || s[1] != "two" {
+		t.Fatalf("bad samples %q %v", s, err)
+	}
+}
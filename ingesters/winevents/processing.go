@@ -73,6 +73,7 @@ type mainService struct {
 	igst           *ingest.IngestMuxer
 	tg             *timegrinder.TimeGrinder
 	pp             processors.ProcessorConfig
+	ppLatency      time.Duration
 	shutdownCalled bool
 }
 
@@ -119,6 +120,7 @@ func NewService(cfg *winevent.CfgType) (*mainService, error) {
 		uuid:         id.String(),
 		label:        cfg.Global.Label,
 		pp:           cfg.Preprocessor,
+		ppLatency:    cfg.Global.PreprocessorMaxLatency(),
 		lmt:          lmt,
 		evtSrcs:      map[string]eventSrc{},
 		deadStreams:  map[string]winevent.EventStreamParams{},
@@ -400,6 +402,7 @@ func (m *mainService) initStream(c winevent.EventStreamParams, errReport bool) (
 		//failing to create the preprocessor set is fatal
 		return eventSrc{}, true, fmt.Errorf("Preprocessor construction error: %v", err)
 	}
+	pproc.SetLatencyBudget(m.ppLatency, 0)
 
 	var evt *winevent.EventStreamHandle
 	if evt, err = winevent.NewStream(c, last); err != nil {
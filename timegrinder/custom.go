@@ -98,7 +98,7 @@ func (cp *customProcessor) ToString(t time.Time) string {
 }
 
 func (cp *customProcessor) Match(d []byte) (int, int, bool) {
-	return match(cp.rx, nil, d)
+	return match(cp.rx, nil, d, cp.CustomFormat.Format)
 }
 
 func (cp *customProcessor) Extract(d []byte, loc *time.Location) (t time.Time, ok bool, offset int) {
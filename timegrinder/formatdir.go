@@ -0,0 +1,308 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package timegrinder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	formatFileExt = `.json`
+
+	DefaultFormatDirectoryPoll = 10 * time.Second
+	minFormatDirectoryPoll     = time.Second
+)
+
+var (
+	ErrFormatDirectoryRunning = errors.New("Format directory watcher is already running")
+	ErrNotADirectory          = errors.New("Time format directory path is not a directory")
+)
+
+// FormatDefinition is a single custom format loaded from a format directory.
+// Format files contain either a single JSON object or an array of them:
+//
+//	{"Name": "myformat", "Regex": `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}`, "Format": "2006-01-02T15:04", "Preferred": true}
+//
+// Preferred formats are tried ahead of the built-in formats, others are tried after them.
+type FormatDefinition struct {
+	Name      string
+	Regex     string
+	Format    string
+	Preferred bool
+}
+
+// FormatDirectory holds a set of custom formats loaded from every .json file in a directory.
+// Any number of TimeGrinders may attach to a single FormatDirectory, when the directory
+// is reloaded each attached TimeGrinder picks up the new set on its next extraction.
+type FormatDirectory struct {
+	mtx     sync.Mutex
+	dir     string
+	defs    []FormatDefinition
+	sig     string
+	version uint64
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewFormatDirectory loads the format definitions in dir.  The directory is not watched
+// until Start is called.
+func NewFormatDirectory(dir string) (fd *FormatDirectory, err error) {
+	if fi, lerr := os.Stat(dir); lerr != nil {
+		err = lerr
+		return
+	} else if !fi.IsDir() {
+		err = ErrNotADirectory
+		return
+	}
+	fd = &FormatDirectory{
+		dir: dir,
+	}
+	if _, err = fd.Reload(); err != nil {
+		fd = nil
+	}
+	return
+}
+
+// Path returns the directory being loaded
+func (fd *FormatDirectory) Path() string {
+	return fd.dir
+}
+
+// Formats returns the current set of format definitions
+func (fd *FormatDirectory) Formats() (defs []FormatDefinition) {
+	fd.mtx.Lock()
+	defs = append(defs, fd.defs...)
+	fd.mtx.Unlock()
+	return
+}
+
+// Version returns a counter that increments every time the set of formats changes
+func (fd *FormatDirectory) Version() uint64 {
+	return atomic.LoadUint64(&fd.version)
+}
+
+// Reload re-reads the directory if any of the format files have changed.  If any file fails
+// to load the existing set of formats is kept and an error is returned.
+func (fd *FormatDirectory) Reload() (changed bool, err error) {
+	var files []string
+	var sig string
+	if files, sig, err = scanFormatDirectory(fd.dir); err != nil {
+		return
+	}
+	fd.mtx.Lock()
+	defer fd.mtx.Unlock()
+	if sig == fd.sig && fd.version > 0 {
+		return //nothing changed
+	}
+	var defs []FormatDefinition
+	names := map[string]string{}
+	for _, f := range files {
+		var fdefs []FormatDefinition
+		if fdefs, err = loadFormatFile(f); err != nil {
+			return
+		}
+		for _, d := range fdefs {
+			if other, ok := names[d.Name]; ok {
+				err = fmt.Errorf("format %q in %s is already defined in %s", d.Name, f, other)
+				return
+			}
+			names[d.Name] = f
+		}
+		defs = append(defs, fdefs...)
+	}
+	fd.defs = defs
+	fd.sig = sig
+	atomic.AddUint64(&fd.version, 1)
+	changed = true
+	return
+}
+
+// Start begins polling the directory for changes, errors encountered while reloading are handed
+// to errf if it is not nil.
+func (fd *FormatDirectory) Start(interval time.Duration, errf func(error)) (err error) {
+	if interval < minFormatDirectoryPoll {
+		interval = minFormatDirectoryPoll
+	}
+	fd.mtx.Lock()
+	defer fd.mtx.Unlock()
+	if fd.done != nil {
+		return ErrFormatDirectoryRunning
+	}
+	fd.done = make(chan struct{})
+	fd.wg.Add(1)
+	go fd.routine(interval, fd.done, errf)
+	return
+}
+
+// Close stops the directory watcher
+func (fd *FormatDirectory) Close() (err error) {
+	fd.mtx.Lock()
+	if fd.done != nil {
+		close(fd.done)
+		fd.done = nil
+	}
+	fd.mtx.Unlock()
+	fd.wg.Wait()
+	return
+}
+
+func (fd *FormatDirectory) routine(interval time.Duration, done chan struct{}, errf func(error)) {
+	defer fd.wg.Done()
+	tckr := time.NewTicker(interval)
+	defer tckr.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-tckr.C:
+			if _, err := fd.Reload(); err != nil && errf != nil {
+				errf(err)
+			}
+		}
+	}
+}
+
+// scanFormatDirectory returns the sorted set of format files and a signature that changes
+// whenever a file is added, removed, or modified
+func scanFormatDirectory(dir string) (files []string, sig string, err error) {
+	var ents []os.FileInfo
+	if ents, err = ioutil.ReadDir(dir); err != nil {
+		return
+	}
+	sb := strings.Builder{}
+	for _, fi := range ents {
+		if !fi.Mode().IsRegular() || !strings.EqualFold(filepath.Ext(fi.Name()), formatFileExt) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, fi.Name()))
+		fmt.Fprintf(&sb, "%s:%d:%d;", fi.Name(), fi.Size(), fi.ModTime().UnixNano())
+	}
+	sort.Strings(files)
+	sig = sb.String()
+	return
+}
+
+func loadFormatFile(p string) (defs []FormatDefinition, err error) {
+	var b []byte
+	if b, err = ioutil.ReadFile(p); err != nil {
+		return
+	}
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] == '[' {
+		err = json.Unmarshal(b, &defs)
+	} else {
+		var d FormatDefinition
+		if err = json.Unmarshal(b, &d); err == nil {
+			defs = append(defs, d)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to decode format file %s: %w", p, err)
+		return
+	}
+	for _, d := range defs {
+		cf := d.customFormat()
+		if err = cf.Validate(); err != nil {
+			err = fmt.Errorf("invalid format %q in %s: %w", d.Name, p, err)
+			return
+		}
+	}
+	return
+}
+
+func (d FormatDefinition) customFormat() CustomFormat {
+	return CustomFormat{
+		Name:   d.Name,
+		Regex:  d.Regex,
+		Format: d.Format,
+	}
+}
+
+// SetFormatDirectory attaches a format directory to the TimeGrinder, the formats are loaded
+// immediately and reloaded whenever the directory changes.  A nil directory is ignored.
+func (tg *TimeGrinder) SetFormatDirectory(fd *FormatDirectory) (err error) {
+	if fd == nil {
+		return
+	}
+	tg.fd = fd
+	tg.fdVersion = 0
+	return tg.loadFormatDirectory()
+}
+
+// checkFormatDirectory reloads the directory formats if they have changed, failures leave
+// the existing formats in place.
+func (tg *TimeGrinder) checkFormatDirectory() {
+	if tg.fd != nil && tg.fd.Version() != tg.fdVersion {
+		if err := tg.loadFormatDirectory(); err != nil {
+			//don't retry the same broken set on every extraction
+			tg.fdVersion = tg.fd.Version()
+		}
+	}
+}
+
+func (tg *TimeGrinder) loadFormatDirectory() (err error) {
+	ver := tg.fd.Version()
+	defs := tg.fd.Formats()
+	var pre, post []Processor
+	for _, d := range defs {
+		var p Processor
+		if p, err = NewCustomProcessor(d.customFormat()); err != nil {
+			return
+		} else if !tg.inProfile(d.Name) {
+			continue
+		}
+		if d.Preferred {
+			pre = append(pre, p)
+		} else {
+			post = append(post, p)
+		}
+	}
+
+	//strip out the previous directory formats, then make sure we don't collide with anything else
+	procs := make([]Processor, 0, len(tg.procs)+len(defs))
+	for _, p := range tg.procs {
+		if _, ok := tg.fdNames[p.Name()]; !ok {
+			procs = append(procs, p)
+		}
+	}
+	names := make(map[string]struct{}, len(defs))
+	for _, d := range defs {
+		for _, p := range procs {
+			if p.Name() == d.Name {
+				err = fmt.Errorf("Name collision, processor name %s already present", d.Name)
+				return
+			}
+		}
+		names[d.Name] = struct{}{}
+	}
+	procs = append(append(pre, procs...), post...)
+
+	tg.procs = procs
+	tg.count = len(procs)
+	tg.curr = 0
+	tg.fdNames = names
+	tg.fdVersion = ver
+	//the override may point at a format that was just replaced
+	if tg.FormatOverride != `` {
+		if p, ok := tg.GetProcessor(tg.FormatOverride); ok {
+			tg.override = p
+		}
+	}
+	return
+}
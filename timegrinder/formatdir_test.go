@@ -0,0 +1,127 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package timegrinder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testFmtDirA = `{"Name": "dotted", "Regex": "\\d{4}\\.\\d{2}\\.\\d{2}_\\d{2}\\.\\d{2}\\.\\d{2}", "Format": "2006.01.02_15.04.05", "Preferred": true}`
+	testFmtDirB = `[
+		{"Name": "dotted", "Regex": "\\d{4}\\.\\d{2}\\.\\d{2}_\\d{2}\\.\\d{2}\\.\\d{2}", "Format": "2006.01.02_15.04.05"},
+		{"Name": "slashed", "Regex": "\\d{4}/\\d{2}/\\d{2}\\|\\d{2}:\\d{2}:\\d{2}", "Format": "2006/01/02|15:04:05"}
+	]`
+)
+
+func writeFormatFile(t *testing.T, dir, name, body string) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFormatDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFormatFile(t, dir, `a.json`, testFmtDirA)
+	writeFormatFile(t, dir, `ignored.txt`, `not a format`)
+	fd, err := NewFormatDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	} else if defs := fd.Formats(); len(defs) != 1 || defs[0].Name != `dotted` || !defs[0].Preferred {
+		t.Fatalf("bad formats: %+v", defs)
+	}
+	tg, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	} else if err = tg.SetFormatDirectory(fd); err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	if ts, ok, err := tg.Extract([]byte(`test 2022.03.04_05.06.07 test`)); err != nil || !ok || !ts.Equal(want) {
+		t.Fatalf("bad extraction: %v %v %v", ts, ok, err)
+	} else if tg.procs[0].Name() != `dotted` {
+		t.Fatalf("preferred format was not placed first: %s", tg.procs[0].Name())
+	}
+	if _, ok, _ := tg.Extract([]byte(`2022/03/04|05:06:07`)); ok {
+		t.Fatal("extracted a format that is not loaded yet")
+	}
+
+	//replace the file with a set, the grinder should pick it up on the next extraction
+	ver := fd.Version()
+	writeFormatFile(t, dir, `a.json`, testFmtDirB)
+	if changed, err := fd.Reload(); err != nil || !changed || fd.Version() == ver {
+		t.Fatalf("reload failed: %v %v", changed, err)
+	}
+	if ts, ok, err := tg.Extract([]byte(`2022/03/04|05:06:07`)); err != nil || !ok || !ts.Equal(want) {
+		t.Fatalf("bad extraction after reload: %v %v %v", ts, ok, err)
+	} else if tg.procs[0].Name() == `dotted` || tg.procs[len(tg.procs)-1].Name() != `slashed` {
+		t.Fatal("non-preferred formats were not appended")
+	}
+	if _, ok := tg.GetProcessor(`dotted`); !ok {
+		t.Fatal("reloaded format missing")
+	}
+
+	//a broken file must not clobber the working set
+	ver = fd.Version()
+	writeFormatFile(t, dir, `b.json`, `{"Name": "broken", "Regex": "(", "Format": "2006"}`)
+	if _, err := fd.Reload(); err == nil {
+		t.Fatal("failed to catch bad format file")
+	} else if fd.Version() != ver || len(fd.Formats()) != 2 {
+		t.Fatal("bad format file replaced the existing formats")
+	}
+
+	//duplicate names across files are rejected
+	writeFormatFile(t, dir, `b.json`, testFmtDirA)
+	if _, err := fd.Reload(); err == nil {
+		t.Fatal("failed to catch duplicate format name")
+	}
+
+	//removing everything drops the formats from the grinder
+	os.Remove(filepath.Join(dir, `a.json`))
+	os.Remove(filepath.Join(dir, `b.json`))
+	if _, err := fd.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := tg.Extract([]byte(`2022/03/04|05:06:07`)); ok {
+		t.Fatal("removed format still extracting")
+	} else if _, ok := tg.GetProcessor(`dotted`); ok {
+		t.Fatal("removed format still present")
+	}
+}
+
+func TestFormatDirectoryWatch(t *testing.T) {
+	dir := t.TempDir()
+	fd, err := NewFormatDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fd.Start(time.Second, func(err error) { t.Error(err) }); err != nil {
+		t.Fatal(err)
+	} else if err = fd.Start(time.Second, nil); err != ErrFormatDirectoryRunning {
+		t.Fatalf("double start: %v", err)
+	}
+	defer fd.Close()
+	ver := fd.Version()
+	writeFormatFile(t, dir, `a.json`, testFmtDirA)
+	deadline := time.Now().Add(5 * time.Second)
+	for fd.Version() == ver {
+		if time.Now().After(deadline) {
+			t.Fatal("watcher did not pick up new format file")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, err := NewFormatDirectory(filepath.Join(dir, `a.json`)); err != ErrNotADirectory {
+		t.Fatalf("bad error on file path: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package timegrinder
+
+import (
+	"time"
+)
+
+var (
+	// ExampleTime is the time rendered in the format examples
+	ExampleTime = time.Date(2022, time.March, 4, 13, 14, 15, 123000000, time.UTC)
+)
+
+// FormatInfo describes a format loaded into a TimeGrinder
+type FormatInfo struct {
+	Name     string
+	Format   string // the layout, for numeric formats this is only an illustration
+	Regex    string // extraction regular expression
+	Example  string // ExampleTime in this format
+	Override bool   // the format is the format override and is tried first
+}
+
+// TestResult describes a timestamp found in a piece of data
+type TestResult struct {
+	Name   string    // format that matched
+	Time   time.Time // the extracted time
+	Offset int       // offset of the timestamp in the data
+	End    int       // end of the timestamp in the data, -1 if the format cannot tell
+}
+
+// Formats lists the formats the TimeGrinder tries, in the order it first tries them.
+func (tg *TimeGrinder) Formats() (fis []FormatInfo) {
+	tg.checkFormatDirectory()
+	if tg.override != nil {
+		fis = append(fis, formatInfo(tg.override, true))
+	}
+	for _, p := range tg.procs {
+		if p != tg.override {
+			fis = append(fis, formatInfo(p, false))
+		}
+	}
+	return
+}
+
+func formatInfo(p Processor, override bool) FormatInfo {
+	return FormatInfo{
+		Name:     p.Name(),
+		Format:   p.Format(),
+		Regex:    p.ExtractionRegex(),
+		Example:  p.ToString(ExampleTime),
+		Override: override,
+	}
+}
+
+// TestExtract reports which format the TimeGrinder would use on data and where the timestamp
+// is, without disturbing the format the TimeGrinder has settled on.  Like a fresh TimeGrinder
+// with left most seeding, the format override wins and otherwise the left most timestamp is used.
+func (tg *TimeGrinder) TestExtract(data []byte) (r TestResult, ok bool) {
+	tg.checkFormatDirectory()
+	if tg.override != nil {
+		if r, ok = tg.testProcessor(tg.override, data); ok {
+			return
+		}
+	}
+	for _, p := range tg.procs {
+		if pr, pok := tg.testProcessor(p, data); pok && (!ok || pr.Offset < r.Offset) {
+			r, ok = pr, true
+		}
+	}
+	return
+}
+
+// TestExtractAll reports every format that can extract a timestamp from data, in the order
+// the formats are tried.
+func (tg *TimeGrinder) TestExtractAll(data []byte) (rs []TestResult) {
+	for _, fi := range tg.Formats() {
+		if p, ok := tg.GetProcessor(fi.Name); ok {
+			if r, ok := tg.testProcessor(p, data); ok {
+				rs = append(rs, r)
+			}
+		}
+	}
+	return
+}
+
+func (tg *TimeGrinder) testProcessor(p Processor, data []byte) (r TestResult, ok bool) {
+	if r.Time, ok, r.Offset = p.Extract(data, tg.loc); !ok {
+		return
+	}
+	r.Name = p.Name()
+	r.End = -1
+	if start, end, mok := p.Match(data); mok && start == r.Offset {
+		r.End = end
+	}
+	return
+}
@@ -0,0 +1,73 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package timegrinder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormats(t *testing.T) {
+	tg, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fis := tg.Formats()
+	if want := len(overrides) - len(tg.directives); len(fis) != want {
+		t.Fatalf("listed %d formats, expected %d", len(fis), want)
+	}
+	//every example has to come back out through its own format
+	for _, fi := range fis {
+		if fi.Example == `` || fi.Regex == `` {
+			t.Fatalf("format %s is missing an example or regex: %+v", fi.Name, fi)
+		}
+		p, ok := tg.GetProcessor(fi.Name)
+		if !ok {
+			t.Fatalf("listed format %s is not loaded", fi.Name)
+		} else if _, ok, _ = p.Extract([]byte(fi.Example), time.UTC); !ok {
+			t.Fatalf("format %s failed to extract its own example %q", fi.Name, fi.Example)
+		}
+	}
+
+	if err = tg.SetFormatOverride(`NGINX`); err != nil {
+		t.Fatal(err)
+	}
+	if fis = tg.Formats(); !fis[0].Override || fis[0].Name != `NGINX` || len(fis) != len(overrides)-len(tg.directives) {
+		t.Fatalf("override not listed first: %+v", fis[0])
+	}
+}
+
+func TestTestExtract(t *testing.T) {
+	tg, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`host 2022-03-04T13:14:15Z app started at Mar  4 13:14:15`)
+	r, ok := tg.TestExtract(data)
+	if !ok {
+		t.Fatal("no timestamp found")
+	} else if r.Offset != 5 || r.End <= r.Offset || !r.Time.Equal(time.Date(2022, 3, 4, 13, 14, 15, 0, time.UTC)) {
+		t.Fatalf("bad result %+v", r)
+	} else if r.Name != string(RFC3339) {
+		t.Fatalf("left most timestamp not chosen: %+v", r)
+	}
+	if rs := tg.TestExtractAll(data); len(rs) < 2 {
+		t.Fatalf("expected several matching formats, got %+v", rs)
+	}
+	if _, ok = tg.TestExtract([]byte(`no time here`)); ok {
+		t.Fatal("extracted from data without a timestamp")
+	}
+
+	//the override wins even when it is not the left most
+	if err = tg.SetFormatOverride(`Syslog`); err != nil {
+		t.Fatal(err)
+	} else if r, ok = tg.TestExtract(data); !ok || r.Name != string(Syslog) || r.Offset <= 5 {
+		t.Fatalf("override not used: %+v", r)
+	}
+}
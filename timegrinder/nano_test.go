@@ -0,0 +1,121 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package timegrinder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gravwell/gravwell/v3/ingest/entry"
+)
+
+func TestNanosecondPrecision(t *testing.T) {
+	want := time.Date(2022, time.March, 4, 13, 14, 15, 123456789, time.UTC)
+	tests := []struct {
+		name string
+		data string
+	}{
+		{`RFC3339Nano`, `x 2022-03-04T13:14:15.123456789Z y`},
+		{`ZonelessRFC3339`, `x 2022-03-04T13:14:15.123456789123 y`},
+		{`DPKG`, `x 2022-03-04 13:14:15.123456789 y`},
+		{`NGINX`, `2022/03/04 13:14:15.123456789 [error]`},
+		{`UnixMilli`, `1646399655.123456789 y`},
+		{`TAI64N`, `@40000000622210b1075bcd15 y`},
+	}
+	tg, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		p, ok := tg.GetProcessor(tt.name)
+		if !ok {
+			t.Fatalf("missing processor %s", tt.name)
+		}
+		ts, ok, _ := p.Extract([]byte(tt.data), time.UTC)
+		if !ok || !ts.Equal(want) {
+			t.Fatalf("%s extracted %v from %q, expected %v", tt.name, ts, tt.data, want)
+		}
+		//make sure nothing is lost on the way into an entry
+		if et := entry.FromStandard(ts); !et.StandardTime().Equal(want) {
+			t.Fatalf("%s lost precision in the entry timestamp: %v", tt.name, et.StandardTime())
+		}
+	}
+
+	//the match covers the fraction too
+	if p, _ := tg.GetProcessor(`DPKG`); p != nil {
+		d := []byte(`x 2022-03-04 13:14:15.123456789 y`)
+		if s, e, ok := p.Match(d); !ok || string(d[s:e]) != `2022-03-04 13:14:15.123456789` {
+			t.Fatalf("bad match %q", d[s:e])
+		}
+	}
+	//commas after the seconds are separators, not fractions
+	if ts, ok, _ := tg.Extract([]byte(`2022-03-04 13:14:15,123,GET`)); !ok || ts.Nanosecond() != 0 {
+		t.Fatalf("comma taken as a fraction: %v %v", ts, ok)
+	}
+}
+
+func TestTAI64N(t *testing.T) {
+	p := NewTAI64NProcessor()
+	want := time.Date(2022, time.March, 4, 13, 14, 15, 123456789, time.UTC)
+	if s := p.ToString(want); s != `@40000000622210b1075bcd15` {
+		t.Fatalf("bad label %s", s)
+	}
+	if ts, ok, off := p.Extract([]byte(`run: @40000000622210b1075bcd15 up`), time.UTC); !ok || off != 5 || !ts.Equal(want) {
+		t.Fatalf("bad extraction %v %v %d", ts, ok, off)
+	}
+	//nanoseconds past a second and plain hex are not labels
+	for _, d := range []string{`@40000000622210b13b9aca00`, `40000000622210b1075bcd15`, `@40000000622210b1075bcd`} {
+		if _, ok, _ := p.Extract([]byte(d), time.UTC); ok {
+			t.Fatalf("extracted from %q", d)
+		}
+	}
+}
+
+func TestTickDirectives(t *testing.T) {
+	want := time.Date(2022, time.March, 4, 13, 14, 15, 123456700, time.UTC)
+	tg, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//ticks look like any other big number, so they are not scanned for
+	if ts, ok, _ := tg.Extract([]byte(`event 637819964551234567 logged`)); ok {
+		t.Fatalf("ticks extracted without an override: %v", ts)
+	}
+	for _, tt := range []struct {
+		name Format
+		data string
+	}{
+		{FileTime, `{"TimeCreated":132908732551234567,"Id":4624}`},
+		{DotNetTicks, `event 637819964551234567 logged`},
+	} {
+		if err = tg.SetFormatOverride(tt.name.String()); err != nil {
+			t.Fatal(err)
+		}
+		ts, ok, err := tg.Extract([]byte(tt.data))
+		if err != nil || !ok || !ts.Equal(want) {
+			t.Fatalf("%s extracted %v %v %v", tt.name, ts, ok, err)
+		}
+		p, _ := tg.GetProcessor(tt.name.String())
+		if ts, _, _ = p.Extract([]byte(p.ToString(want)), time.UTC); !ts.Equal(want) {
+			t.Fatalf("%s did not round trip: %v", tt.name, ts)
+		} else if _, ok, _ = p.Extract([]byte(`1329087325512345678`), time.UTC); ok {
+			t.Fatalf("%s extracted from a 19 digit number", tt.name)
+		}
+	}
+
+	//profiles can pull a directive into the scan
+	if err = tg.SetFormatProfile([]string{`filetime`, `RFC3339`}); err != nil {
+		t.Fatal(err)
+	} else if tg.count != 2 {
+		t.Fatalf("profile kept %d formats", tg.count)
+	}
+	if err = ValidateFormatOverride(`dotnetticks`); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -48,6 +48,9 @@ const (
 	UK                    Format = `UK`
 	Bind                  Format = `Bind`
 	Gravwell              Format = `Gravwell`
+	TAI64N                Format = `TAI64N`
+	FileTime              Format = `FileTime`
+	DotNetTicks           Format = `DotNetTicks`
 )
 
 //Timestamp Formats
@@ -81,6 +84,9 @@ const (
 	UKFormat                    string = `02/01/2006 15:04:05,99999`
 	GravwellFormat              string = `1-2-2006 15:04:05.99999`
 	BindFormat                  string = `02-Jan-2006 15:04:05.999`
+	TAI64NFormat                string = `@40000000622210b1075bcd15` // Time formatting API doesn't work, this is just for docs
+	FileTimeFormat              string = `132908732551234567`        // Time formatting API doesn't work, this is just for docs
+	DotNetTicksFormat           string = `637819964551234567`        // Time formatting API doesn't work, this is just for docs
 )
 
 //Regular Expression Extractors
@@ -114,6 +120,9 @@ const (
 	UKRegex                    string = `\d\d/\d\d/\d\d\d\d\s\d\d\:\d\d\:\d\d,\d{1,5}`
 	GravwellRegex              string = `\d{1,2}\-\d{1,2}\-\d{4}\s+\d{1,2}\:\d{2}\:\d{2}(\.\d{1,6})?`
 	BindRegex                  string = `\d{2}\-(Jan|Feb|Mar|Apr|May|Jun|Jul|Aug|Sep|Sept|Oct|Nov|Dec)\-\d{4} \d{2}:\d{2}:\d{2}\.\d{1,3}`
+	TAI64NRegex                string = `@(400000[0-9a-f]{10})([0-9a-f]{8})`
+	FileTimeRegex              string = `(?:\A|\D)(\d{18})(?:\D|\z)`
+	DotNetTicksRegex           string = `(?:\A|\D)(\d{18})(?:\D|\z)`
 
 	// non base extrators
 	_unixSecondsRegex  string = `\d{9,10}`
@@ -166,6 +175,9 @@ var (
 		UK,
 		Gravwell,
 		Bind,
+		TAI64N,
+		FileTime,
+		DotNetTicks,
 	}
 )
 
@@ -223,7 +235,12 @@ func extract(rx, rxt *regexp.Regexp, d []byte, format string, loc *time.Location
 		}
 	}
 
-	if t, err = time.ParseInLocation(format, string(d[idxs[0]:idxs[1]]), loc); err != nil {
+	//pick up fractional seconds the regex stopped short of, falling back to the plain match
+	end := fractionEnd(format, d, idxs[1])
+	if t, err = time.ParseInLocation(format, string(d[idxs[0]:end]), loc); err != nil && end != idxs[1] {
+		t, err = time.ParseInLocation(format, string(d[idxs[0]:idxs[1]]), loc)
+	}
+	if err != nil {
 		return
 	}
 	ok = true
@@ -231,6 +248,24 @@ func extract(rx, rxt *regexp.Regexp, d []byte, format string, loc *time.Location
 	return
 }
 
+// fractionEnd extends a match over fractional seconds that directly follow it.  time.Parse takes
+// a fraction right after the seconds field even when the layout has none, so formats that end
+// on the seconds keep full nanosecond precision.  Only a period is honored, a comma after the
+// seconds is far more likely to be a field separator.
+func fractionEnd(format string, d []byte, end int) int {
+	if !strings.HasSuffix(format, `:05`) || end+1 >= len(d) || d[end] != '.' {
+		return end
+	}
+	i := end + 1
+	for i < len(d) && d[i] >= '0' && d[i] <= '9' {
+		i++
+	}
+	if i == end+1 {
+		return end
+	}
+	return i
+}
+
 func (a *processor) Extract(d []byte, loc *time.Location) (time.Time, bool, int) {
 	if len(d) < a.min {
 		return time.Time{}, false, -1 //cannot possibly hit
@@ -238,7 +273,7 @@ func (a *processor) Extract(d []byte, loc *time.Location) (time.Time, bool, int)
 	return extract(a.rxp, a.trxpEx, d, a.format, loc)
 }
 
-func match(rx, rxt *regexp.Regexp, d []byte) (start, end int, ok bool) {
+func match(rx, rxt *regexp.Regexp, d []byte, format string) (start, end int, ok bool) {
 	idxs := rx.FindIndex(d)
 	if len(idxs) != 2 {
 		return
@@ -251,7 +286,7 @@ func match(rx, rxt *regexp.Regexp, d []byte) (start, end int, ok bool) {
 			}
 		}
 	}
-	start, end = idxs[0], idxs[1]
+	start, end = idxs[0], fractionEnd(format, d, idxs[1])
 	ok = true
 	return
 }
@@ -260,7 +295,7 @@ func (a *processor) Match(d []byte) (int, int, bool) {
 	if len(d) < a.min {
 		return -1, -1, false //cannot possibly hit
 	}
-	return match(a.rxp, a.trxpEx, d)
+	return match(a.rxp, a.trxpEx, d, a.format)
 }
 
 func NewAnsiCProcessor() *processor {
@@ -9,6 +9,7 @@
 package timegrinder
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"regexp"
@@ -63,8 +64,7 @@ func (up *unixProcessor) Name() string {
 }
 
 func (up *unixProcessor) ToString(t time.Time) string {
-	uns := t.Unix()
-	return fmt.Sprintf("%d.%d", uns, t.UnixNano()-(uns*ns))
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
 }
 
 func (up *unixProcessor) ExtractionRegex() string {
@@ -130,13 +130,22 @@ func (up unixProcessor) Extract(d []byte, loc *time.Location) (t time.Time, ok b
 	if len(idx) != 4 {
 		return
 	}
-	s, err := strconv.ParseFloat(string(d[idx[2]:idx[3]]), 64)
+	//a float64 can't hold seconds and nanoseconds, parse the two halves separately
+	v := d[idx[2]:idx[3]]
+	dot := bytes.IndexByte(v, '.')
+	sec, err := strconv.ParseInt(string(v[:dot]), 10, 64)
 	if err != nil {
 		return
 	}
+	var nsec int64
+	frac := v[dot+1:]
+	for i := 0; i < 9; i++ {
+		nsec *= 10
+		if i < len(frac) {
+			nsec += int64(frac[i] - '0')
+		}
+	}
 	offset = idx[2]
-	sec := int64(s)
-	nsec := int64((s - float64(sec)) * 1000000000.0)
 	t = time.Unix(sec, nsec).In(loc)
 	ok = true
 	return
@@ -491,3 +500,157 @@ func (up unixSecondsProcessor) Match(d []byte) (start, end int, ok bool) {
 	}
 	return
 }
+
+// tai64nOffset is the TAI64 label of the Unix epoch, labels are 2^62 plus TAI seconds and
+// TAI was 10 seconds ahead of UTC in 1970.  Like daemontools we ignore later leap seconds.
+const tai64nOffset uint64 = 1<<62 + 10
+
+type tai64nProcessor struct {
+	re     *regexp.Regexp
+	rxstr  string
+	format string
+	name   string
+	min    int
+}
+
+// NewTAI64NProcessor handles the TAI64N labels written by daemontools and runit, such as
+// @40000000622210b1075bcd15, an @ followed by 16 hex digits of seconds and 8 of nanoseconds
+func NewTAI64NProcessor() *tai64nProcessor {
+	return &tai64nProcessor{
+		re:     regexp.MustCompile(TAI64NRegex),
+		rxstr:  TAI64NRegex,
+		format: ``, //format API doesn't work here
+		name:   TAI64N.String(),
+		min:    25,
+	}
+}
+
+func (tp *tai64nProcessor) Format() string {
+	return tp.format
+}
+
+func (tp *tai64nProcessor) Name() string {
+	return tp.name
+}
+
+func (tp *tai64nProcessor) ToString(t time.Time) string {
+	return fmt.Sprintf("@%016x%08x", uint64(t.Unix())+tai64nOffset, t.Nanosecond())
+}
+
+func (tp *tai64nProcessor) ExtractionRegex() string {
+	return tp.rxstr
+}
+
+func (tp *tai64nProcessor) Extract(d []byte, loc *time.Location) (t time.Time, ok bool, offset int) {
+	offset = -1
+	if len(d) < tp.min {
+		return
+	}
+	idx := tp.re.FindSubmatchIndex(d)
+	if len(idx) != 6 {
+		return
+	}
+	sec, err := strconv.ParseUint(string(d[idx[2]:idx[3]]), 16, 64)
+	if err != nil || sec < tai64nOffset {
+		return
+	}
+	nsec, err := strconv.ParseUint(string(d[idx[4]:idx[5]]), 16, 32)
+	if err != nil || nsec >= uint64(ns) {
+		return
+	}
+	offset = idx[0]
+	t = time.Unix(int64(sec-tai64nOffset), int64(nsec)).In(loc)
+	ok = true
+	return
+}
+
+func (tp *tai64nProcessor) Match(d []byte) (start, end int, ok bool) {
+	if len(d) < tp.min {
+		return
+	}
+	if idx := tp.re.FindIndex(d); len(idx) == 2 {
+		start, end = idx[0], idx[1]
+		ok = true
+	}
+	return
+}
+
+const (
+	fileTimeEpoch    int64 = 116444736000000000 // 100ns ticks from 1601-01-01 to the Unix epoch
+	dotNetTicksEpoch int64 = 621355968000000000 // 100ns ticks from 0001-01-01 to the Unix epoch
+	ticksPerSecond   int64 = 10000000
+)
+
+// tickProcessor handles integer counts of 100ns ticks since an epoch, as Windows FILETIME
+// values and .NET DateTime.Ticks are.  Nothing sets these apart from any other large integer,
+// so they are only used when selected as a format override or in a format profile.
+type tickProcessor struct {
+	re     *regexp.Regexp
+	rxstr  string
+	format string
+	name   string
+	epoch  int64
+}
+
+// NewFileTimeProcessor handles Windows FILETIME values, 100ns ticks since 1601-01-01 UTC
+func NewFileTimeProcessor() *tickProcessor {
+	return &tickProcessor{
+		re:     regexp.MustCompile(FileTimeRegex),
+		rxstr:  FileTimeRegex,
+		format: ``, //format API doesn't work here
+		name:   FileTime.String(),
+		epoch:  fileTimeEpoch,
+	}
+}
+
+// NewDotNetTicksProcessor handles .NET DateTime ticks, 100ns ticks since 0001-01-01
+func NewDotNetTicksProcessor() *tickProcessor {
+	return &tickProcessor{
+		re:     regexp.MustCompile(DotNetTicksRegex),
+		rxstr:  DotNetTicksRegex,
+		format: ``, //format API doesn't work here
+		name:   DotNetTicks.String(),
+		epoch:  dotNetTicksEpoch,
+	}
+}
+
+func (tp *tickProcessor) Format() string {
+	return tp.format
+}
+
+func (tp *tickProcessor) Name() string {
+	return tp.name
+}
+
+func (tp *tickProcessor) ToString(t time.Time) string {
+	return fmt.Sprintf("%d", t.Unix()*ticksPerSecond+int64(t.Nanosecond())/100+tp.epoch)
+}
+
+func (tp *tickProcessor) ExtractionRegex() string {
+	return tp.rxstr
+}
+
+func (tp *tickProcessor) Extract(d []byte, loc *time.Location) (t time.Time, ok bool, offset int) {
+	offset = -1
+	idx := tp.re.FindSubmatchIndex(d)
+	if len(idx) != 4 {
+		return
+	}
+	ticks, err := strconv.ParseInt(string(d[idx[2]:idx[3]]), 10, 64)
+	if err != nil {
+		return
+	}
+	ticks -= tp.epoch
+	offset = idx[2]
+	t = time.Unix(ticks/ticksPerSecond, (ticks%ticksPerSecond)*100).In(loc)
+	ok = true
+	return
+}
+
+func (tp *tickProcessor) Match(d []byte) (start, end int, ok bool) {
+	if idx := tp.re.FindSubmatchIndex(d); len(idx) == 4 {
+		start, end = idx[2], idx[3]
+		ok = true
+	}
+	return
+}
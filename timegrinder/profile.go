@@ -0,0 +1,65 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package timegrinder
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	ErrEmptyFormatProfile = errors.New("None of the formats in the profile are loaded")
+)
+
+// SetFormatProfile restricts the TimeGrinder to a named set of formats, which may be built-in
+// formats, directives, custom formats, or formats from a format directory.  A source that only ever sends a
+// handful of formats is faster to extract and cannot be mismatched against a format it never uses.
+// Names are case insensitive.  Formats added after the profile is set that are not part of it are
+// ignored, names which are not loaded yet are kept in case a format directory supplies them later.
+// An empty set of names clears the profile, the formats it removed are not restored.
+func (tg *TimeGrinder) SetFormatProfile(names []string) (err error) {
+	if len(names) == 0 {
+		tg.profile = nil
+		return
+	}
+	profile := make(map[string]struct{}, len(names))
+	for _, n := range names {
+		profile[strings.ToLower(strings.TrimSpace(n))] = struct{}{}
+	}
+	procs := make([]Processor, 0, len(profile))
+	seen := make(map[string]struct{}, len(profile))
+	//directives are only ever tried when a profile asks for them
+	for _, p := range append(append([]Processor{}, tg.procs...), tg.directives...) {
+		name := strings.ToLower(p.Name())
+		if _, ok := profile[name]; !ok {
+			continue
+		} else if _, ok = seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		procs = append(procs, p)
+	}
+	if len(procs) == 0 && tg.fd == nil {
+		return ErrEmptyFormatProfile
+	}
+	tg.profile = profile
+	tg.procs = procs
+	tg.count = len(procs)
+	tg.curr = 0
+	return
+}
+
+// inProfile reports whether a format may be loaded under the current profile
+func (tg *TimeGrinder) inProfile(name string) (ok bool) {
+	if tg.profile == nil {
+		return true
+	}
+	_, ok = tg.profile[strings.ToLower(name)]
+	return
+}
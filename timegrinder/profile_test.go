@@ -0,0 +1,77 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package timegrinder
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatProfile(t *testing.T) {
+	tg, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tg.SetFormatProfile([]string{`syslog`, `RFC3339`, `dotted`}); err != nil {
+		t.Fatal(err)
+	} else if tg.count != 2 {
+		t.Fatalf("profile kept %d formats", tg.count)
+	}
+	if _, ok, _ := tg.Extract([]byte(`Mar  4 05:06:07 host sshd: hello`)); !ok {
+		t.Fatal("missed a format in the profile")
+	} else if _, ok, _ = tg.Extract([]byte(`2022/03/04 05:06:07 [error] nginx`)); ok {
+		t.Fatal("extracted a format outside of the profile")
+	}
+
+	//custom formats outside of the profile are dropped, those in it are loaded
+	for _, cf := range []CustomFormat{
+		{Name: `slashed`, Regex: `\d{4}/\d{2}/\d{2}\|\d{2}:\d{2}:\d{2}`, Format: `2006/01/02|15:04:05`},
+		{Name: `dotted`, Regex: `\d{4}\.\d{2}\.\d{2}_\d{2}\.\d{2}\.\d{2}`, Format: `2006.01.02_15.04.05`},
+	} {
+		p, err := NewCustomProcessor(cf)
+		if err != nil {
+			t.Fatal(err)
+		} else if _, err = tg.AddProcessor(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	if ts, ok, _ := tg.Extract([]byte(`x 2022.03.04_05.06.07`)); !ok || !ts.Equal(want) {
+		t.Fatalf("bad extraction of a custom format in the profile: %v %v", ts, ok)
+	} else if _, ok = tg.GetProcessor(`slashed`); ok {
+		t.Fatal("custom format outside of the profile was loaded")
+	}
+
+	//directory formats are filtered the same way
+	dir := t.TempDir()
+	writeFormatFile(t, dir, `b.json`, `{"Name": "slashed", "Regex": "\\d{4}/\\d{2}/\\d{2}\\|\\d{2}:\\d{2}:\\d{2}", "Format": "2006/01/02|15:04:05"}`)
+	fd, err := NewFormatDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tg2, _ := New(Config{})
+	if err = tg2.SetFormatDirectory(fd); err != nil {
+		t.Fatal(err)
+	} else if err = tg2.SetFormatProfile([]string{`Slashed`}); err != nil {
+		t.Fatal(err)
+	} else if tg2.count != 1 {
+		t.Fatalf("profile kept %d formats", tg2.count)
+	}
+	if ts, ok, _ := tg2.Extract([]byte(`2022/03/04|05:06:07`)); !ok || !ts.Equal(want) {
+		t.Fatalf("bad extraction of a directory format in the profile: %v %v", ts, ok)
+	}
+
+	//a profile that matches nothing is an error unless a directory may fill it in
+	tg3, _ := New(Config{})
+	if err = tg3.SetFormatProfile([]string{`nope`}); err != ErrEmptyFormatProfile {
+		t.Fatalf("bad error on empty profile: %v", err)
+	} else if tg3.count == 0 || tg3.profile != nil {
+		t.Fatal("failed profile changed the formats")
+	}
+}
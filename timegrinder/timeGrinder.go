@@ -44,6 +44,14 @@ type TimeGrinder struct {
 	seed     bool
 	override Processor
 	loc      *time.Location
+
+	fd        *FormatDirectory    // optional directory of hot-reloaded formats
+	fdVersion uint64              // version of the directory formats currently loaded
+	fdNames   map[string]struct{} // names of the loaded directory formats
+
+	profile map[string]struct{} // lowercased names of the formats we are restricted to, nil for all
+
+	directives []Processor // formats too ambiguous to scan for, used as an override or in a profile
 }
 
 // Config defines a few configuration options when instantiating a new TimeGrinder.
@@ -179,11 +187,21 @@ func New(c Config) (tg *TimeGrinder, err error) {
 	// Unix nanoseconds
 	procs = append(procs, NewUnixNanoTimeProcessor())
 
+	// TAI64N labels
+	procs = append(procs, NewTAI64NProcessor())
+
+	// Directives, these are only tried when asked for by name
+	directives := []Processor{
+		NewFileTimeProcessor(),
+		NewDotNetTicksProcessor(),
+	}
+
 	tg = &TimeGrinder{
-		procs: procs,
-		count: len(procs),
-		loc:   time.UTC,
-		seed:  c.EnableLeftMostSeed,
+		procs:      procs,
+		count:      len(procs),
+		loc:        time.UTC,
+		seed:       c.EnableLeftMostSeed,
+		directives: directives,
 	}
 	if c.FormatOverride != `` {
 		err = tg.SetFormatOverride(c.FormatOverride)
@@ -196,12 +214,10 @@ func (tg *TimeGrinder) SetFormatOverride(v string) (err error) {
 		return
 	}
 	//attempt to find the override
-	for i := range tg.procs {
-		if tg.procs[i].Name() == v {
-			tg.override = tg.procs[i]
-			tg.FormatOverride = v
-			return
-		}
+	if p, ok := tg.GetProcessor(v); ok {
+		tg.override = p
+		tg.FormatOverride = v
+		return
 	}
 	err = fmt.Errorf("override %q not found", v)
 	return
@@ -233,15 +249,16 @@ func (tg *TimeGrinder) OverrideProcessor() (Processor, error) {
 
 // AddProcessor inserts a new Processor at the *beginning* of the processor list.
 // For compatibility, it still returns the index of the inserted processor, but that
-// index will always be 0.
+// index will always be 0.  Processors outside of the format profile are dropped.
 func (tg *TimeGrinder) AddProcessor(p Processor) (idx int, err error) {
 	//grab the name of the processor
 	name := p.Name()
-	for i := range tg.procs {
-		if tg.procs[i].Name() == name {
-			err = fmt.Errorf("Name collision, processor name %s already present", name)
-			return
-		}
+	if _, ok := tg.GetProcessor(name); ok {
+		err = fmt.Errorf("Name collision, processor name %s already present", name)
+		return
+	}
+	if !tg.inProfile(name) {
+		return
 	}
 	tg.procs = append([]Processor{p}, tg.procs...)
 	tg.count++
@@ -249,8 +266,15 @@ func (tg *TimeGrinder) AddProcessor(p Processor) (idx int, err error) {
 	return
 }
 
+// GetProcessor looks up a loaded format or directive by name
 func (tg *TimeGrinder) GetProcessor(name string) (p Processor, ok bool) {
 	for _, v := range tg.procs {
+		if v.Name() == name {
+			p, ok = v, true
+			return
+		}
+	}
+	for _, v := range tg.directives {
 		if v.Name() == name {
 			p, ok = v, true
 			break
@@ -294,6 +318,8 @@ func (tg *TimeGrinder) Extract(data []byte) (t time.Time, ok bool, err error) {
 	var i int
 	var c int
 
+	tg.checkFormatDirectory()
+
 	if tg.override != nil {
 		if t, ok, _ = tg.override.Extract(data, tg.loc); ok {
 			return
@@ -330,6 +356,8 @@ func (tg *TimeGrinder) Match(data []byte) (start, end int, ok bool) {
 	var i int
 	var c int
 
+	tg.checkFormatDirectory()
+
 	if tg.override != nil {
 		if start, end, ok = tg.override.Match(data); ok {
 			return
@@ -363,6 +391,8 @@ func (tg *TimeGrinder) DebugExtract(data []byte) (t time.Time, offset int, name
 	var i int
 	var c int
 
+	tg.checkFormatDirectory()
+
 	if tg.override != nil {
 		if t, _, offset = tg.override.Extract(data, tg.loc); offset < 0 {
 			return
@@ -401,6 +431,8 @@ func (tg *TimeGrinder) DebugMatch(data []byte) (ts time.Time, name string, start
 	var i int
 	var c int
 
+	tg.checkFormatDirectory()
+
 	if tg.override != nil {
 		if start, end, ok = tg.override.Match(data); ok {
 			if ts, ok, _ = tg.override.Extract(data, tg.loc); ok {
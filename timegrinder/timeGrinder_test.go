@@ -205,7 +205,7 @@ func TestUnixMilli(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	ctime, err := time.Parse(time.RFC3339Nano, `2017-11-27T17:09:59.453396081Z`)
+	ctime, err := time.Parse(time.RFC3339Nano, `2017-11-27T17:09:59.453396Z`)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -725,6 +725,7 @@ var testSetList = []testSet{
 	testSet{name: `UnixMs`, data: `1641818658123 unix`, ts: time.Date(2022, time.January, 10, 12, 44, 18, 123000000, time.UTC)},
 	testSet{name: `UnixNano`, data: `1641818658123456000 unix`, ts: time.Date(2022, time.January, 10, 12, 44, 18, 123456000, time.UTC)},
 	testSet{name: `UnixMilli`, data: `1641818658.0 unix`, ts: time.Date(2022, time.January, 10, 12, 44, 18, 0, time.UTC)},
+	testSet{name: `TAI64N`, data: `@4000000061dc2a2c075bcd15 run: up`, ts: time.Date(2022, time.January, 10, 12, 44, 18, 123456789, time.UTC)},
 }
 
 func TestExtractions(t *testing.T) {
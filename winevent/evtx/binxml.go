@@ -0,0 +1,535 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package evtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// binary XML tokens, the 0x40 bit flags "more data follows" and is masked off
+const (
+	tokEOF          = 0x00
+	tokOpenStart    = 0x01
+	tokCloseStart   = 0x02
+	tokCloseEmpty   = 0x03
+	tokEnd          = 0x04
+	tokValue        = 0x05
+	tokAttribute    = 0x06
+	tokCDATA        = 0x07
+	tokCharRef      = 0x08
+	tokEntityRef    = 0x09
+	tokPITarget     = 0x0a
+	tokPIData       = 0x0b
+	tokTemplate     = 0x0c
+	tokSubstitution = 0x0d
+	tokOptionalSub  = 0x0e
+	tokFragment     = 0x0f
+
+	tokMoreFlag = 0x40
+)
+
+// substitution value types
+const (
+	typeNull       = 0x00
+	typeString     = 0x01
+	typeAnsiString = 0x02
+	typeInt8       = 0x03
+	typeUint8      = 0x04
+	typeInt16      = 0x05
+	typeUint16     = 0x06
+	typeInt32      = 0x07
+	typeUint32     = 0x08
+	typeInt64      = 0x09
+	typeUint64     = 0x0a
+	typeReal32     = 0x0b
+	typeReal64     = 0x0c
+	typeBool       = 0x0d
+	typeBinary     = 0x0e
+	typeGUID       = 0x0f
+	typeSizeT      = 0x10
+	typeFileTime   = 0x11
+	typeSysTime    = 0x12
+	typeSID        = 0x13
+	typeHexInt32   = 0x14
+	typeHexInt64   = 0x15
+	typeBinXML     = 0x21
+
+	typeArrayFlag = 0x80
+)
+
+const (
+	maxDepth = 64
+
+	timeCreated = `TimeCreated`
+	systemTime  = `SystemTime`
+
+	//seconds between the FILETIME epoch (1601) and the unix epoch
+	filetimeEpochDelta = 11644473600
+
+	//EvtRender renders times with 100ns precision
+	timeFormat = `2006-01-02T15:04:05.0000000Z`
+)
+
+var (
+	errTruncated = errors.New("binary XML runs past the end of the chunk")
+	errTooDeep   = errors.New("binary XML is nested too deeply")
+
+	textEscaper = strings.NewReplacer(`&`, `&amp;`, `<`, `&lt;`, `>`, `&gt;`)
+	attrEscaper = strings.NewReplacer(`&`, `&amp;`, `<`, `&lt;`, `>`, `&gt;`, `'`, `&apos;`, `"`, `&quot;`)
+)
+
+// value is a template substitution, the data stays in the chunk
+type value struct {
+	typ  byte
+	off  int
+	size int
+}
+
+// parser renders the binary XML of a single record.  All offsets are relative to the start
+// of the chunk, which is how names and templates refer to each other.  Reads past the end of
+// the chunk set a sticky error and return zero values, callers check p.err at each token.
+type parser struct {
+	chunk   []byte
+	out     *bytes.Buffer
+	err     error
+	depth   int
+	created time.Time
+}
+
+func (p *parser) render(off, end int) error {
+	if end > len(p.chunk) {
+		return errTruncated
+	}
+	p.fragment(off, end, nil)
+	return p.err
+}
+
+func (p *parser) need(off, n int) bool {
+	if p.err == nil && (off < 0 || n < 0 || off+n > len(p.chunk)) {
+		p.err = errTruncated
+	}
+	return p.err == nil
+}
+
+func (p *parser) u8(off int) byte {
+	if !p.need(off, 1) {
+		return 0
+	}
+	return p.chunk[off]
+}
+
+func (p *parser) u16(off int) uint16 {
+	if !p.need(off, 2) {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(p.chunk[off:])
+}
+
+func (p *parser) u32(off int) uint32 {
+	if !p.need(off, 4) {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(p.chunk[off:])
+}
+
+// utf16 decodes count UTF16 characters at off
+func (p *parser) utf16(off, count int) string {
+	if !p.need(off, count*2) {
+		return ``
+	}
+	return decodeUTF16(p.chunk[off : off+count*2])
+}
+
+// name reads a name reference at off, returning the name and the offset just past it.  Names
+// are defined inline the first time they are used in a chunk and referenced after that.
+func (p *parser) name(off int) (string, int) {
+	noff := int(p.u32(off))
+	off += 4
+	cnt := int(p.u16(noff + 6))
+	s := p.utf16(noff+8, cnt)
+	if noff == off {
+		off += 8 + cnt*2 + 2
+	}
+	return s, off
+}
+
+// fragment renders tokens until the end of the fragment
+func (p *parser) fragment(off, end int, subs []value) int {
+	if p.depth++; p.depth > maxDepth {
+		p.err = errTooDeep
+	}
+	defer func() { p.depth-- }()
+	for p.err == nil && off < end {
+		switch tok := p.u8(off); tok &^ tokMoreFlag {
+		case tokEOF:
+			return off + 1
+		case tokFragment:
+			off += 4
+		case tokTemplate:
+			off = p.template(off)
+		case tokOpenStart:
+			off = p.element(off, subs)
+		default:
+			p.err = fmt.Errorf("unexpected token %#x at offset %d", tok, off)
+		}
+	}
+	return off
+}
+
+// template renders a template instance: the template definition (which may be inline) filled in
+// with the substitution values that follow it
+func (p *parser) template(off int) int {
+	defOff := int(p.u32(off + 6))
+	off += 10
+	defSize := int(p.u32(defOff + 20))
+	if defOff == off {
+		off += 24 + defSize
+	}
+
+	//the substitution array, descriptors followed by the values
+	cnt := int(p.u32(off))
+	off += 4
+	if !p.need(off, cnt*4) {
+		return off
+	}
+	subs := make([]value, cnt)
+	voff := off + cnt*4
+	for i := range subs {
+		subs[i] = value{
+			size: int(p.u16(off)),
+			typ:  p.u8(off + 2),
+			off:  voff,
+		}
+		off += 4
+		voff += subs[i].size
+	}
+	if !p.need(off, voff-off) {
+		return voff
+	}
+	p.fragment(defOff+24, defOff+24+defSize, subs)
+	return voff
+}
+
+func (p *parser) element(off int, subs []value) int {
+	tok := p.u8(off)
+	name, off := p.name(off + 7) //token, dependency id, and data size
+	if tok&tokMoreFlag != 0 {
+		off += 4 //attribute list size
+	}
+	p.out.WriteByte('<')
+	p.out.WriteString(name)
+	for p.err == nil {
+		atok := p.u8(off)
+		if atok&^tokMoreFlag != tokAttribute {
+			break
+		}
+		var aname string
+		aname, off = p.name(off + 1)
+		var val string
+		var ok bool
+		off, val, ok = p.attrValue(off, subs, name == timeCreated && aname == systemTime)
+		if ok {
+			p.out.WriteByte(' ')
+			p.out.WriteString(aname)
+			p.out.WriteString(`='`)
+			p.out.WriteString(val)
+			p.out.WriteByte('\'')
+		}
+		if atok&tokMoreFlag == 0 {
+			break
+		}
+	}
+
+	switch ctok := p.u8(off); ctok {
+	case tokCloseEmpty:
+		p.out.WriteString(`/>`)
+		return off + 1
+	case tokCloseStart:
+		p.out.WriteByte('>')
+		off++
+	default:
+		if p.err == nil {
+			p.err = fmt.Errorf("unexpected token %#x closing element %s", ctok, name)
+		}
+		return off
+	}
+
+	//element content
+	for p.err == nil {
+		switch tok := p.u8(off); tok &^ tokMoreFlag {
+		case tokEnd:
+			p.out.WriteString(`</`)
+			p.out.WriteString(name)
+			p.out.WriteByte('>')
+			return off + 1
+		case tokOpenStart:
+			off = p.element(off, subs)
+		case tokTemplate:
+			off = p.template(off)
+		case tokValue:
+			cnt := int(p.u16(off + 2))
+			textEscaper.WriteString(p.out, p.utf16(off+4, cnt))
+			off += 4 + cnt*2
+		case tokCDATA:
+			cnt := int(p.u16(off + 1))
+			p.out.WriteString(`<![CDATA[`)
+			p.out.WriteString(p.utf16(off+3, cnt))
+			p.out.WriteString(`]]>`)
+			off += 3 + cnt*2
+		case tokCharRef:
+			fmt.Fprintf(p.out, "&#%d;", p.u16(off+1))
+			off += 3
+		case tokEntityRef:
+			var ent string
+			ent, off = p.name(off + 1)
+			fmt.Fprintf(p.out, "&%s;", ent)
+		case tokPITarget:
+			var target string
+			target, off = p.name(off + 1)
+			p.out.WriteString(`<?`)
+			p.out.WriteString(target)
+			if p.u8(off) == tokPIData {
+				cnt := int(p.u16(off + 1))
+				p.out.WriteByte(' ')
+				p.out.WriteString(p.utf16(off+3, cnt))
+				off += 3 + cnt*2
+			}
+			p.out.WriteString(`?>`)
+		case tokSubstitution, tokOptionalSub:
+			id := int(p.u16(off + 1))
+			off += 4
+			if id < len(subs) {
+				if subs[id].typ == typeBinXML {
+					p.fragment(subs[id].off, subs[id].off+subs[id].size, nil)
+				} else {
+					textEscaper.WriteString(p.out, p.valueString(subs[id]))
+				}
+			}
+		default:
+			p.err = fmt.Errorf("unexpected token %#x in element %s", tok, name)
+		}
+	}
+	return off
+}
+
+// attrValue renders the value of an attribute, ok is false if the attribute should be
+// dropped because it is an optional substitution with no value
+func (p *parser) attrValue(off int, subs []value, created bool) (noff int, val string, ok bool) {
+	var sb strings.Builder
+	ok = true
+	for p.err == nil {
+		tok := p.u8(off)
+		switch tok &^ tokMoreFlag {
+		case tokValue:
+			cnt := int(p.u16(off + 2))
+			sb.WriteString(p.utf16(off+4, cnt))
+			off += 4 + cnt*2
+		case tokCharRef:
+			sb.WriteRune(rune(p.u16(off + 1)))
+			off += 3
+		case tokEntityRef:
+			var ent string
+			ent, off = p.name(off + 1)
+			sb.WriteString(`&` + ent + `;`)
+		case tokSubstitution, tokOptionalSub:
+			id := int(p.u16(off + 1))
+			off += 4
+			if id >= len(subs) || (subs[id].typ == typeNull && tok&^tokMoreFlag == tokOptionalSub) {
+				ok = false
+			} else {
+				if created {
+					p.setCreated(subs[id])
+				}
+				sb.WriteString(p.valueString(subs[id]))
+			}
+		default:
+			//anything else belongs to the element
+			noff = off
+			val = attrEscaper.Replace(sb.String())
+			return
+		}
+	}
+	noff = off
+	return
+}
+
+func (p *parser) setCreated(v value) {
+	switch v.typ {
+	case typeFileTime:
+		if p.need(v.off, 8) {
+			p.created = filetime(binary.LittleEndian.Uint64(p.chunk[v.off:]))
+		}
+	case typeSysTime:
+		if p.need(v.off, 16) {
+			p.created = systime(p.chunk[v.off : v.off+16])
+		}
+	}
+}
+
+// valueString renders a substitution value the way EvtRender does
+func (p *parser) valueString(v value) string {
+	if !p.need(v.off, v.size) {
+		return ``
+	}
+	b := p.chunk[v.off : v.off+v.size]
+	if v.typ&typeArrayFlag != 0 {
+		return arrayString(v.typ&^typeArrayFlag, b)
+	}
+	return scalarString(v.typ, b)
+}
+
+func arrayString(typ byte, b []byte) string {
+	var vals []string
+	switch typ {
+	case typeString:
+		for _, s := range strings.Split(decodeUTF16(b), "\x00") {
+			if s != `` {
+				vals = append(vals, s)
+			}
+		}
+	case typeAnsiString:
+		for _, s := range strings.Split(string(b), "\x00") {
+			if s != `` {
+				vals = append(vals, s)
+			}
+		}
+	default:
+		sz := typeSize(typ)
+		if sz == 0 {
+			return strings.ToUpper(hex.EncodeToString(b))
+		}
+		for len(b) >= sz {
+			vals = append(vals, scalarString(typ, b[:sz]))
+			b = b[sz:]
+		}
+	}
+	return strings.Join(vals, `, `)
+}
+
+func typeSize(typ byte) int {
+	switch typ {
+	case typeInt8, typeUint8:
+		return 1
+	case typeInt16, typeUint16:
+		return 2
+	case typeInt32, typeUint32, typeReal32, typeBool, typeHexInt32:
+		return 4
+	case typeInt64, typeUint64, typeReal64, typeFileTime, typeHexInt64, typeSizeT:
+		return 8
+	case typeGUID, typeSysTime:
+		return 16
+	}
+	return 0
+}
+
+func scalarString(typ byte, b []byte) string {
+	if sz := typeSize(typ); sz > len(b) || (typ == typeSizeT && len(b) < 4) {
+		return strings.ToUpper(hex.EncodeToString(b))
+	}
+	switch typ {
+	case typeNull:
+		return ``
+	case typeString:
+		return strings.TrimRight(decodeUTF16(b), "\x00")
+	case typeAnsiString:
+		return strings.TrimRight(string(b), "\x00")
+	case typeInt8:
+		return strconv.FormatInt(int64(int8(b[0])), 10)
+	case typeUint8:
+		return strconv.FormatUint(uint64(b[0]), 10)
+	case typeInt16:
+		return strconv.FormatInt(int64(int16(binary.LittleEndian.Uint16(b))), 10)
+	case typeUint16:
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint16(b)), 10)
+	case typeInt32:
+		return strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(b))), 10)
+	case typeUint32:
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b)), 10)
+	case typeInt64:
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(b)), 10)
+	case typeUint64:
+		return strconv.FormatUint(binary.LittleEndian.Uint64(b), 10)
+	case typeReal32:
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'g', -1, 32)
+	case typeReal64:
+		return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64)
+	case typeBool:
+		if binary.LittleEndian.Uint32(b) != 0 {
+			return `true`
+		}
+		return `false`
+	case typeGUID:
+		return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint16(b[4:]),
+			binary.LittleEndian.Uint16(b[6:]), b[8:10], b[10:16])
+	case typeSizeT:
+		if len(b) >= 8 {
+			return fmt.Sprintf("0x%016x", binary.LittleEndian.Uint64(b))
+		}
+		return fmt.Sprintf("0x%08x", binary.LittleEndian.Uint32(b))
+	case typeFileTime:
+		return filetime(binary.LittleEndian.Uint64(b)).Format(timeFormat)
+	case typeSysTime:
+		return systime(b).Format(timeFormat)
+	case typeSID:
+		return sidString(b)
+	case typeHexInt32:
+		return fmt.Sprintf("0x%x", binary.LittleEndian.Uint32(b))
+	case typeHexInt64:
+		return fmt.Sprintf("0x%x", binary.LittleEndian.Uint64(b))
+	}
+	//binary and anything we don't know how to render
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+func sidString(b []byte) string {
+	if len(b) < 8 || len(b) < 8+int(b[1])*4 {
+		return strings.ToUpper(hex.EncodeToString(b))
+	}
+	var auth uint64
+	for _, v := range b[2:8] {
+		auth = auth<<8 | uint64(v)
+	}
+	s := fmt.Sprintf("S-%d-%d", b[0], auth)
+	for i := 0; i < int(b[1]); i++ {
+		s += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(b[8+i*4:]))
+	}
+	return s
+}
+
+// filetime converts 100ns intervals since 1601 to a time
+func filetime(v uint64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(v/10000000)-filetimeEpochDelta, int64(v%10000000)*100).UTC()
+}
+
+// systime converts a SYSTEMTIME structure to a time
+func systime(b []byte) time.Time {
+	v := func(i int) int { return int(binary.LittleEndian.Uint16(b[i*2:])) }
+	//year, month, day of week, day, hour, minute, second, milliseconds
+	return time.Date(v(0), time.Month(v(1)), v(3), v(4), v(5), v(6), v(7)*int(time.Millisecond), time.UTC)
+}
+
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
@@ -0,0 +1,155 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+// Package evtx reads Windows event log (.evtx) files without the Windows event log API.
+// Records are rendered to the same XML the Windows ingester gets back from EvtRender so
+// offline imports look the same as live collection.
+package evtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	FileHeaderSize  = 4096
+	ChunkSize       = 65536
+	chunkHeaderSize = 512 //header plus the string and template tables
+	recordHeaderLen = 24
+
+	fileMagic   = "ElfFile\x00"
+	chunkMagic  = "ElfChnk\x00"
+	recordMagic = "**\x00\x00"
+
+	//offsets into the chunk header
+	chunkFreeSpaceOff = 48
+)
+
+var (
+	ErrNotEVTX       = errors.New("not an EVTX file")
+	ErrInvalidRecord = errors.New("invalid event record")
+)
+
+// Record is a single rendered event
+type Record struct {
+	ID      uint64    //event record ID
+	Written time.Time //when the record was written to the log
+	Created time.Time //System/TimeCreated/@SystemTime, zero if the event does not have one
+	XML     []byte
+}
+
+// Timestamp returns the time the event was created, falling back to when it was written
+func (r Record) Timestamp() time.Time {
+	if !r.Created.IsZero() {
+		return r.Created
+	}
+	return r.Written
+}
+
+// Reader walks the records in an EVTX file, chunk by chunk
+type Reader struct {
+	rdr    io.Reader
+	chunk  []byte
+	off    int
+	end    int
+	chunks int
+	bb     bytes.Buffer
+}
+
+// NewReader validates the EVTX file header and returns a Reader positioned at the first record
+func NewReader(rdr io.Reader) (r *Reader, err error) {
+	hdr := make([]byte, FileHeaderSize)
+	if _, err = io.ReadFull(rdr, hdr); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = ErrNotEVTX
+		}
+		return
+	} else if string(hdr[:len(fileMagic)]) != fileMagic {
+		err = ErrNotEVTX
+		return
+	} else if major := binary.LittleEndian.Uint16(hdr[38:]); major != 3 {
+		err = fmt.Errorf("unsupported EVTX version %d", major)
+		return
+	}
+	r = &Reader{
+		rdr:   rdr,
+		chunk: make([]byte, ChunkSize),
+	}
+	return
+}
+
+// Chunks returns the number of chunks read so far
+func (r *Reader) Chunks() int {
+	return r.chunks
+}
+
+// Next returns the next record in the file, io.EOF is returned once the file is exhausted.
+// Records that cannot be parsed return an error wrapping ErrInvalidRecord, the caller may
+// keep calling Next to skip over them.
+func (r *Reader) Next() (rec Record, err error) {
+	for r.off >= r.end {
+		if err = r.nextChunk(); err != nil {
+			return
+		}
+	}
+	off := r.off
+	if off+recordHeaderLen > r.end || string(r.chunk[off:off+len(recordMagic)]) != recordMagic {
+		//anything past a bad record header can't be trusted, move on to the next chunk
+		r.off = r.end
+		err = fmt.Errorf("%w: bad record signature at chunk %d offset %d", ErrInvalidRecord, r.chunks-1, off)
+		return
+	}
+	sz := int(binary.LittleEndian.Uint32(r.chunk[off+4:]))
+	if sz < recordHeaderLen+4 || off+sz > r.end {
+		r.off = r.end
+		err = fmt.Errorf("%w: bad record size %d at chunk %d offset %d", ErrInvalidRecord, sz, r.chunks-1, off)
+		return
+	}
+	r.off += sz
+	rec.ID = binary.LittleEndian.Uint64(r.chunk[off+8:])
+	rec.Written = filetime(binary.LittleEndian.Uint64(r.chunk[off+16:]))
+
+	r.bb.Reset()
+	p := parser{
+		chunk: r.chunk,
+		out:   &r.bb,
+	}
+	if err = p.render(off+recordHeaderLen, off+sz-4); err != nil {
+		err = fmt.Errorf("%w %d: %v", ErrInvalidRecord, rec.ID, err)
+		return
+	}
+	rec.Created = p.created
+	rec.XML = append([]byte(nil), r.bb.Bytes()...)
+	return
+}
+
+// nextChunk loads the next chunk, chunks without a valid header (unused space at
+// the end of a log) are skipped
+func (r *Reader) nextChunk() (err error) {
+	if _, err = io.ReadFull(r.rdr, r.chunk); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return
+	}
+	r.chunks++
+	r.off, r.end = 0, 0
+	if string(r.chunk[:len(chunkMagic)]) != chunkMagic {
+		return
+	}
+	end := int(binary.LittleEndian.Uint32(r.chunk[chunkFreeSpaceOff:]))
+	if end > ChunkSize {
+		end = ChunkSize
+	}
+	r.off, r.end = chunkHeaderSize, end
+	return
+}
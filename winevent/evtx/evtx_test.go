@@ -0,0 +1,344 @@
+/*************************************************************************
+ * Copyright 2022 Gravwell, Inc. All rights reserved.
+ * Contact: <legal@gravwell.io>
+ *
+ * This software may be modified and distributed under the terms of the
+ * BSD 2-clause license. See the LICENSE file for details.
+ **************************************************************************/
+
+package evtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	testXMLNS = `http://schemas.microsoft.com/win/2004/08/events/event`
+)
+
+// chunkBuilder assembles binary XML the way the event log service lays it out in a chunk
+type chunkBuilder struct {
+	buf   []byte
+	names map[string]int
+}
+
+func newChunkBuilder() *chunkBuilder {
+	return &chunkBuilder{
+		buf:   make([]byte, chunkHeaderSize),
+		names: map[string]int{},
+	}
+}
+
+func (cb *chunkBuilder) u8(v byte) {
+	cb.buf = append(cb.buf, v)
+}
+
+func (cb *chunkBuilder) u16(v uint16) {
+	cb.buf = append(cb.buf, le16(v)...)
+}
+
+func (cb *chunkBuilder) u32(v uint32) {
+	cb.buf = append(cb.buf, le32(v)...)
+}
+
+func (cb *chunkBuilder) u64(v uint64) {
+	cb.buf = append(cb.buf, le64(v)...)
+}
+
+func (cb *chunkBuilder) str(s string) {
+	for _, v := range utf16.Encode([]rune(s)) {
+		cb.u16(v)
+	}
+}
+
+// name writes a name reference, defining it inline the first time it is used
+func (cb *chunkBuilder) name(s string) {
+	if off, ok := cb.names[s]; ok {
+		cb.u32(uint32(off))
+		return
+	}
+	off := len(cb.buf) + 4
+	cb.names[s] = off
+	cb.u32(uint32(off))
+	cb.u32(0) //next string
+	cb.u16(0) //hash
+	cb.u16(uint16(len(s)))
+	cb.str(s)
+	cb.u16(0)
+}
+
+func (cb *chunkBuilder) open(name string, attrs bool) {
+	if attrs {
+		cb.u8(tokOpenStart | tokMoreFlag)
+	} else {
+		cb.u8(tokOpenStart)
+	}
+	cb.u16(0xffff) //dependency id
+	cb.u32(0)      //data size, not used when rendering
+	cb.name(name)
+	if attrs {
+		cb.u32(0) //attribute list size
+	}
+}
+
+func (cb *chunkBuilder) attr(name string, more bool) {
+	if more {
+		cb.u8(tokAttribute | tokMoreFlag)
+	} else {
+		cb.u8(tokAttribute)
+	}
+	cb.name(name)
+}
+
+func (cb *chunkBuilder) text(s string) {
+	cb.u8(tokValue)
+	cb.u8(typeString)
+	cb.u16(uint16(len(s)))
+	cb.str(s)
+}
+
+func (cb *chunkBuilder) sub(id uint16, typ byte, optional bool) {
+	if optional {
+		cb.u8(tokOptionalSub)
+	} else {
+		cb.u8(tokSubstitution)
+	}
+	cb.u16(id)
+	cb.u8(typ)
+}
+
+// eventTemplate writes a template definition for a small security event
+func (cb *chunkBuilder) eventTemplate() {
+	cb.u32(0)                                    //next template
+	cb.buf = append(cb.buf, make([]byte, 16)...) //guid
+	szOff := len(cb.buf)
+	cb.u32(0)
+	start := len(cb.buf)
+	cb.buf = append(cb.buf, tokFragment, 1, 1, 0)
+	cb.open(`Event`, true)
+	cb.attr(`xmlns`, false)
+	cb.text(testXMLNS)
+	cb.u8(tokCloseStart)
+
+	cb.open(`System`, false)
+	cb.u8(tokCloseStart)
+	cb.open(`Provider`, true)
+	cb.attr(`Name`, true)
+	cb.sub(0, typeString, false)
+	cb.attr(`Guid`, false)
+	cb.sub(1, typeGUID, true)
+	cb.u8(tokCloseEmpty)
+	cb.open(`EventID`, false)
+	cb.u8(tokCloseStart)
+	cb.sub(2, typeUint16, false)
+	cb.u8(tokEnd)
+	cb.open(`TimeCreated`, true)
+	cb.attr(`SystemTime`, false)
+	cb.sub(3, typeFileTime, false)
+	cb.u8(tokCloseEmpty)
+	cb.open(`Keywords`, false)
+	cb.u8(tokCloseStart)
+	cb.sub(4, typeHexInt64, false)
+	cb.u8(tokEnd)
+	cb.u8(tokEnd) //System
+
+	cb.open(`EventData`, false)
+	cb.u8(tokCloseStart)
+	cb.open(`Data`, true)
+	cb.attr(`Name`, false)
+	cb.text(`TargetUserSid`)
+	cb.u8(tokCloseStart)
+	cb.sub(5, typeSID, true)
+	cb.u8(tokEnd)
+	cb.open(`Data`, true)
+	cb.attr(`Name`, false)
+	cb.text(`Message`)
+	cb.u8(tokCloseStart)
+	cb.sub(6, typeString, true)
+	cb.u8(tokEnd)
+	cb.u8(tokEnd) //EventData
+
+	cb.u8(tokEnd) //Event
+	cb.u8(tokEOF)
+	binary.LittleEndian.PutUint32(cb.buf[szOff:], uint32(len(cb.buf)-start))
+}
+
+type testValue struct {
+	typ  byte
+	data []byte
+}
+
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, v := range utf16.Encode([]rune(s)) {
+		b = append(b, le16(v)...)
+	}
+	return b
+}
+
+// record writes an event record, the template is defined inline if tmplOff is zero
+func (cb *chunkBuilder) record(id uint64, written uint64, tmplOff int, vals []testValue) (defOff int) {
+	start := len(cb.buf)
+	cb.buf = append(cb.buf, recordMagic...)
+	cb.u32(0) //size, filled in below
+	cb.u64(id)
+	cb.u64(written)
+	cb.buf = append(cb.buf, tokFragment, 1, 1, 0)
+	cb.u8(tokTemplate)
+	cb.u8(1)
+	cb.u32(1) //template id
+	if defOff = tmplOff; defOff == 0 {
+		defOff = len(cb.buf) + 4
+		cb.u32(uint32(defOff))
+		cb.eventTemplate()
+	} else {
+		cb.u32(uint32(defOff))
+	}
+	cb.u32(uint32(len(vals)))
+	for _, v := range vals {
+		cb.u16(uint16(len(v.data)))
+		cb.u8(v.typ)
+		cb.u8(0)
+	}
+	for _, v := range vals {
+		cb.buf = append(cb.buf, v.data...)
+	}
+	cb.u8(tokEOF)
+	sz := len(cb.buf) - start + 4
+	cb.u32(uint32(sz))
+	binary.LittleEndian.PutUint32(cb.buf[start+4:], uint32(sz))
+	return
+}
+
+func (cb *chunkBuilder) chunk() []byte {
+	b := make([]byte, ChunkSize)
+	copy(b, cb.buf)
+	copy(b, chunkMagic)
+	binary.LittleEndian.PutUint32(b[chunkFreeSpaceOff:], uint32(len(cb.buf)))
+	return b
+}
+
+func toFiletime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + filetimeEpochDelta*10000000
+}
+
+func le16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return b
+}
+
+func le32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+func le64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
+
+func testFile(chunks ...[]byte) []byte {
+	hdr := make([]byte, FileHeaderSize)
+	copy(hdr, fileMagic)
+	binary.LittleEndian.PutUint16(hdr[36:], 1)
+	binary.LittleEndian.PutUint16(hdr[38:], 3)
+	for _, c := range chunks {
+		hdr = append(hdr, c...)
+	}
+	return hdr
+}
+
+func TestReader(t *testing.T) {
+	created := time.Date(2022, 3, 4, 5, 6, 7, 123456700, time.UTC)
+	written := created.Add(time.Second)
+	guid := []byte{0x54, 0x84, 0x9d, 0x54, 0x47, 0x8b, 0x4c, 0xa5, 0x99, 0x4a, 0x3e, 0x3b, 0x30, 0x28, 0xf3, 0x0d}
+	sid := []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}
+
+	cb := newChunkBuilder()
+	defOff := cb.record(100, toFiletime(written), 0, []testValue{
+		{typeString, utf16Bytes(`Microsoft-Windows-Security-Auditing`)},
+		{typeGUID, guid},
+		{typeUint16, le16(4624)},
+		{typeFileTime, le64(toFiletime(created))},
+		{typeHexInt64, le64(0x8020000000000000)},
+		{typeSID, sid},
+		{typeString, utf16Bytes(`a <b> & 'c'`)},
+	})
+	//the second record reuses the template and leaves the optional values out
+	cb.record(101, toFiletime(written), defOff, []testValue{
+		{typeString, utf16Bytes(`Test`)},
+		{typeNull, nil},
+		{typeUint16, le16(1)},
+		{typeFileTime, le64(toFiletime(created))},
+		{typeHexInt64, le64(0)},
+		{typeNull, nil},
+		{typeNull, nil},
+	})
+
+	//an empty chunk at the end of the file is skipped
+	rdr, err := NewReader(bytes.NewReader(testFile(cb.chunk(), make([]byte, ChunkSize))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := rdr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `<Event xmlns='` + testXMLNS + `'><System><Provider Name='Microsoft-Windows-Security-Auditing' Guid='{549D8454-8B47-A54C-994A-3E3B3028F30D}'/>` +
+		`<EventID>4624</EventID><TimeCreated SystemTime='2022-03-04T05:06:07.1234567Z'/><Keywords>0x8020000000000000</Keywords></System>` +
+		`<EventData><Data Name='TargetUserSid'>S-1-5-18</Data><Data Name='Message'>a &lt;b&gt; &amp; 'c'</Data></EventData></Event>`
+	if rec.ID != 100 || string(rec.XML) != exp {
+		t.Fatalf("bad record %d:\n%s\n%s", rec.ID, rec.XML, exp)
+	} else if !rec.Created.Equal(created) || !rec.Written.Equal(written) || !rec.Timestamp().Equal(created) {
+		t.Fatalf("bad timestamps: %v %v", rec.Created, rec.Written)
+	}
+
+	if rec, err = rdr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	exp = `<Event xmlns='` + testXMLNS + `'><System><Provider Name='Test'/>` +
+		`<EventID>1</EventID><TimeCreated SystemTime='2022-03-04T05:06:07.1234567Z'/><Keywords>0x0</Keywords></System>` +
+		`<EventData><Data Name='TargetUserSid'></Data><Data Name='Message'></Data></EventData></Event>`
+	if rec.ID != 101 || string(rec.XML) != exp {
+		t.Fatalf("bad record %d:\n%s\n%s", rec.ID, rec.XML, exp)
+	}
+
+	if _, err = rdr.Next(); err != io.EOF {
+		t.Fatalf("expected EOF: %v", err)
+	} else if rdr.Chunks() != 2 {
+		t.Fatalf("bad chunk count %d", rdr.Chunks())
+	}
+}
+
+func TestReaderCorrupt(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte(`not an event log`))); err != ErrNotEVTX {
+		t.Fatalf("bad error: %v", err)
+	}
+
+	cb := newChunkBuilder()
+	cb.record(1, 0, 0, []testValue{
+		{typeString, utf16Bytes(`Test`)},
+	})
+	//point the substitution array past the end of the chunk
+	c := cb.chunk()
+	binary.LittleEndian.PutUint32(c[len(cb.buf)-9-4-4:], 0xffff)
+	rdr, err := NewReader(bytes.NewReader(testFile(c)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = rdr.Next(); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("failed to catch corrupt record: %v", err)
+	} else if _, err = rdr.Next(); err != io.EOF {
+		t.Fatalf("expected EOF: %v", err)
+	}
+}